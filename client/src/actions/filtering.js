@@ -47,10 +47,17 @@ export const addFilterRequest = createAction('ADD_FILTER_REQUEST');
 export const addFilterFailure = createAction('ADD_FILTER_FAILURE');
 export const addFilterSuccess = createAction('ADD_FILTER_SUCCESS');
 
-export const addFilter = (url, name, whitelist = false) => async (dispatch, getState) => {
+export const addFilter = (
+    url,
+    name,
+    whitelist = false,
+    monitorOnly = false,
+) => async (dispatch, getState) => {
     dispatch(addFilterRequest());
     try {
-        await apiClient.addFilter({ url, name, whitelist });
+        await apiClient.addFilter({
+            url, name, whitelist, monitor_only: monitorOnly,
+        });
         dispatch(addFilterSuccess(url));
         if (getState().filtering.isModalOpen) {
             dispatch(toggleFilteringModal());
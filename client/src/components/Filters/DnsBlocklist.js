@@ -29,8 +29,8 @@ class DnsBlocklist extends Component {
                 this.props.editFilter(modalFilterUrl, values);
                 break;
             case MODAL_TYPE.ADD_FILTERS: {
-                const { name, url } = values;
-                this.props.addFilter(url, name);
+                const { name, url, monitor_only } = values;
+                this.props.addFilter(url, name, false, monitor_only);
                 break;
             }
             case MODAL_TYPE.CHOOSE_FILTERING_LIST: {
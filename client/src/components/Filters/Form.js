@@ -152,6 +152,15 @@ const Form = (props) => {
                 <div className="form__description">
                     {whitelist ? t('enter_valid_allowlist') : t('enter_valid_blocklist')}
                 </div>
+                {!whitelist && <div className="form__group mt-3 mb-0">
+                    <Field
+                        name="monitor_only"
+                        type="checkbox"
+                        component={CheckboxField}
+                        placeholder={t('monitor_only')}
+                        subtitle={t('monitor_only_desc')}
+                    />
+                </div>}
             </>}
         </div>
         <div className="modal-footer">
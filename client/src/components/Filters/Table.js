@@ -13,8 +13,12 @@ class Table extends Component {
 
     renderCheckbox = ({ original }) => {
         const { processingConfigFilter, toggleFilter } = this.props;
-        const { url, name, enabled } = original;
-        const data = { name, url, enabled: !enabled };
+        const {
+            url, name, enabled, monitorOnly,
+        } = original;
+        const data = {
+            name, url, enabled: !enabled, monitor_only: monitorOnly,
+        };
 
         return (
             <label className="checkbox">
@@ -21,6 +21,7 @@ const ResponseCell = ({
     upstream,
     rules,
     service_name,
+    cname_match,
     cached,
 }) => {
     const { t } = useTranslation();
@@ -64,6 +65,7 @@ const ResponseCell = ({
         ...(service_name && services.allServices
                 && { service_name: getServiceName(services.allServices, service_name) }
         ),
+        ...(cname_match && { cname_match }),
         ...(rules.length > 0
                 && { rule_label: getRulesToFilterList(rules, filters, whitelistFilters) }
         ),
@@ -130,6 +132,7 @@ ResponseCell.propTypes = {
         filter_list_id: propTypes.number.isRequired,
     })),
     service_name: propTypes.string,
+    cname_match: propTypes.string,
 };
 
 export default ResponseCell;
@@ -153,6 +153,7 @@ let Form = (props) => {
     const { safe_search } = initialValues;
     const safeSearchServices = { ...safe_search };
     delete safeSearchServices.enabled;
+    delete safeSearchServices.custom;
 
     const [activeTabLabel, setActiveTabLabel] = useState('settings');
 
@@ -204,6 +205,15 @@ let Form = (props) => {
                         </div>
                     ))}
                 </div>
+                <div className="form__group">
+                    <Field
+                        name="allowlist_only"
+                        type="checkbox"
+                        component={CheckboxField}
+                        placeholder={t('client_allowlist_only')}
+                        subtitle={t('client_allowlist_only_desc')}
+                    />
+                </div>
                 <div className="form__label--bold form__label--top form__label--bot">
                     {t('log_and_stats_section_label')}
                 </div>
@@ -153,7 +153,8 @@ const Form = ({
                     </div>
                 </div>
             </div>
-            {blocking_mode === BLOCKING_MODES.custom_ip && (
+            {(blocking_mode === BLOCKING_MODES.custom_ip
+                || blocking_mode === BLOCKING_MODES.block_page) && (
                 <>
                     {customIps.map(({
                         description,
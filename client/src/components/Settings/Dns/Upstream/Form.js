@@ -117,6 +117,14 @@ const INPUT_FIELDS = [
         subtitle: 'fastest_addr_desc',
         placeholder: 'fastest_addr',
     },
+    {
+        name: UPSTREAM_MODE_NAME,
+        type: 'radio',
+        value: DNS_REQUEST_OPTIONS.RACE,
+        component: renderRadioField,
+        subtitle: 'race_requests_desc',
+        placeholder: 'race_requests',
+    },
 ];
 
 const Form = ({
@@ -54,6 +54,7 @@ class Settings extends Component {
         const { enabled } = safesearch || {};
         const searches = { ...(safesearch || {}) };
         delete searches.enabled;
+        delete searches.custom;
         return (
             <>
                 <Checkbox
@@ -240,6 +240,7 @@ export const BLOCKING_MODES = {
     nxdomain: 'nxdomain',
     null_ip: 'null_ip',
     custom_ip: 'custom_ip',
+    block_page: 'block_page',
 };
 
 // Note that translation strings contain these modes (theme_CONSTANT)
@@ -279,6 +280,11 @@ export const FILTERED_STATUS = {
     FILTERED_SAFE_SEARCH: 'FilteredSafeSearch',
     FILTERED_SAFE_BROWSING: 'FilteredSafeBrowsing',
     FILTERED_PARENTAL: 'FilteredParental',
+    FILTERED_QUERY_TYPE: 'FilteredQueryType',
+    FILTERED_RESPONSE_IP: 'FilteredResponseIP',
+    NOT_FILTERED_MONITORED: 'NotFilteredMonitored',
+    FILTERED_CATEGORY: 'FilteredCategory',
+    FILTERED_ALLOWLIST_ONLY: 'FilteredAllowlistOnly',
 };
 
 export const RESPONSE_FILTER = {
@@ -322,6 +328,18 @@ export const RESPONSE_FILTER = {
         QUERY: 'safe_search',
         LABEL: 'safe_search',
     },
+    WOULD_BLOCK: {
+        QUERY: 'would_block',
+        LABEL: 'would_block',
+    },
+    BLOCKED_CATEGORY: {
+        QUERY: 'blocked_category',
+        LABEL: 'blocked_category',
+    },
+    BLOCKED_NOT_ALLOWED: {
+        QUERY: 'blocked_not_allowed',
+        LABEL: 'blocked_not_allowed',
+    },
 };
 
 export const RESPONSE_FILTER_QUERIES = Object.values(RESPONSE_FILTER)
@@ -351,6 +369,26 @@ export const FILTERED_STATUS_TO_META_MAP = {
         LABEL: 'blocked_service',
         COLOR: QUERY_STATUS_COLORS.RED,
     },
+    [FILTERED_STATUS.FILTERED_QUERY_TYPE]: {
+        LABEL: 'blocked_query_type',
+        COLOR: QUERY_STATUS_COLORS.RED,
+    },
+    [FILTERED_STATUS.FILTERED_RESPONSE_IP]: {
+        LABEL: 'blocked_response_ip',
+        COLOR: QUERY_STATUS_COLORS.RED,
+    },
+    [FILTERED_STATUS.FILTERED_CATEGORY]: {
+        LABEL: RESPONSE_FILTER.BLOCKED_CATEGORY.LABEL,
+        COLOR: QUERY_STATUS_COLORS.RED,
+    },
+    [FILTERED_STATUS.FILTERED_ALLOWLIST_ONLY]: {
+        LABEL: RESPONSE_FILTER.BLOCKED_NOT_ALLOWED.LABEL,
+        COLOR: QUERY_STATUS_COLORS.RED,
+    },
+    [FILTERED_STATUS.NOT_FILTERED_MONITORED]: {
+        LABEL: RESPONSE_FILTER.WOULD_BLOCK.LABEL,
+        COLOR: QUERY_STATUS_COLORS.YELLOW,
+    },
     [FILTERED_STATUS.FILTERED_SAFE_SEARCH]: {
         LABEL: RESPONSE_FILTER.SAFE_SEARCH.LABEL,
         COLOR: QUERY_STATUS_COLORS.YELLOW,
@@ -413,6 +451,9 @@ export const SPECIAL_FILTER_ID = {
     PARENTAL: -3,
     SAFE_BROWSING: -4,
     SAFE_SEARCH: -5,
+    MANAGED_HOSTS: -6,
+    IP_BLOCKLISTS: -7,
+    CATEGORIES: -8,
 };
 
 export const BLOCK_ACTIONS = {
@@ -431,6 +472,7 @@ export const SCHEME_TO_PROTOCOL_MAP = {
 export const DNS_REQUEST_OPTIONS = {
     PARALLEL: 'parallel',
     FASTEST_ADDR: 'fastest_addr',
+    RACE: 'race',
     LOAD_BALANCING: '',
 };
 
@@ -74,6 +74,7 @@ export const normalizeLogs = (logs) => logs.map((log) => {
         rule,
         rules,
         service_name,
+        cname_match,
         original_answer,
         upstream,
         cached,
@@ -113,6 +114,7 @@ export const normalizeLogs = (logs) => logs.map((log) => {
         rules: newRules,
         status,
         service_name,
+        cname_match,
         originalAnswer: original_answer,
         originalResponse: processResponse(original_answer),
         tracker: getTrackerData(domain),
@@ -163,6 +165,7 @@ export const normalizeFilters = (filters) => (
             last_updated,
             name = 'Default name',
             rules_count = 0,
+            monitor_only = false,
         } = filter;
 
         return {
@@ -172,6 +175,7 @@ export const normalizeFilters = (filters) => (
             lastUpdated: last_updated,
             name,
             rulesCount: rules_count,
+            monitorOnly: monitor_only,
         };
     }) : []
 );
@@ -490,11 +494,14 @@ export const getCurrentFilter = (url, filters) => {
     const filter = filters?.find((item) => url === item.url);
 
     if (filter) {
-        const { enabled, name, url } = filter;
+        const {
+            enabled, name, url, monitorOnly,
+        } = filter;
         return {
             enabled,
             name,
             url,
+            monitor_only: monitorOnly,
         };
     }
 
@@ -839,6 +846,12 @@ export const getSpecialFilterName = (filterId) => {
             return i18n.t('safe_browsing');
         case SPECIAL_FILTER_ID.SAFE_SEARCH:
             return i18n.t('safe_search');
+        case SPECIAL_FILTER_ID.MANAGED_HOSTS:
+            return i18n.t('managed_hosts_list');
+        case SPECIAL_FILTER_ID.IP_BLOCKLISTS:
+            return i18n.t('ip_blocklists');
+        case SPECIAL_FILTER_ID.CATEGORIES:
+            return i18n.t('domain_categories');
         default:
             return i18n.t('unknown_filter', { filterId });
     }
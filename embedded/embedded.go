@@ -0,0 +1,345 @@
+// Package embedded provides the programmatic API for embedding the DNS
+// filtering core of AdGuard Home into other Go programs, such as router
+// firmware and appliances.  It doesn't provide the web interface, the HTTP
+// API, DHCP, or the persistent configuration file; the embedding program is
+// responsible for those.
+//
+// A typical use looks like this:
+//
+//	srv, err := embedded.New(&embedded.Config{
+//		ListenAddrs: []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:53")},
+//		Upstreams:   []string{"https://dns10.quad9.net/dns-query"},
+//		DataDir:     "/var/lib/filtering",
+//		FilterLists: []*embedded.FilterList{{
+//			ID:   1,
+//			Path: "/etc/filtering/blocklist.txt",
+//		}},
+//		UserRules: []string{"||ads.example^"},
+//	})
+//	if err != nil {
+//		// Handle the error.
+//	}
+//
+//	err = srv.Start()
+//	if err != nil {
+//		// Handle the error.
+//	}
+//
+//	defer func() { err = srv.Close() }()
+package embedded
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// DefaultCacheSize is the default size of the DNS cache, in bytes.
+const DefaultCacheSize uint32 = 4 * 1024 * 1024
+
+// Config is the configuration of an embedded DNS server.
+type Config struct {
+	// QueryLogger, if not nil, receives an entry for every processed request.
+	QueryLogger QueryLogger
+
+	// DataDir is the directory for the filtering data.  It must not be empty.
+	DataDir string
+
+	// ListenAddrs are the addresses on which the plain DNS server listens for
+	// both UDP and TCP requests.  It must not be empty.
+	ListenAddrs []netip.AddrPort
+
+	// Upstreams are the upstream DNS servers in the same format as in the
+	// configuration file of AdGuard Home.  It must not be empty.
+	Upstreams []string
+
+	// BootstrapDNS are the plain DNS servers used to resolve the hostnames of
+	// the upstream servers.
+	BootstrapDNS []string
+
+	// FilterLists are the blocklists.  Their IDs must be positive and unique.
+	FilterLists []*FilterList
+
+	// UserRules are the custom filtering rules, which take precedence over the
+	// rules of the filter lists.
+	UserRules []string
+
+	// UpstreamTimeout is the timeout for the upstream requests.  If zero, the
+	// default timeout of 10 seconds is used.
+	UpstreamTimeout time.Duration
+
+	// CacheSize is the size of the DNS cache, in bytes.  If zero,
+	// [DefaultCacheSize] is used.
+	CacheSize uint32
+
+	// DisableCache, if true, disables the DNS cache.
+	DisableCache bool
+}
+
+// FilterList is a blocklist.  Exactly one of Path and Data must be set.
+type FilterList struct {
+	// Path is the path to the file with the rules.
+	Path string
+
+	// Data is the contents of the list.
+	Data []byte
+
+	// ID is the unique positive identifier of the list, which is reported in
+	// the results of matching.
+	ID int64
+}
+
+// validate returns an error if conf is invalid.
+func (conf *Config) validate() (err error) {
+	switch {
+	case conf == nil:
+		return errors.Error("no config")
+	case conf.DataDir == "":
+		return errors.Error("no data dir")
+	case len(conf.ListenAddrs) == 0:
+		return errors.Error("no listen addresses")
+	case len(conf.Upstreams) == 0:
+		return errors.Error("no upstreams")
+	default:
+		// Go on.
+	}
+
+	ids := map[int64]struct{}{}
+	for i, fl := range conf.FilterLists {
+		err = fl.validate()
+		if err != nil {
+			return fmt.Errorf("filter list at index %d: %w", i, err)
+		}
+
+		if _, ok := ids[fl.ID]; ok {
+			return fmt.Errorf("filter list at index %d: duplicate id %d", i, fl.ID)
+		}
+
+		ids[fl.ID] = struct{}{}
+	}
+
+	return nil
+}
+
+// validate returns an error if fl is invalid.
+func (fl *FilterList) validate() (err error) {
+	switch {
+	case fl == nil:
+		return errors.Error("no value")
+	case fl.ID <= 0:
+		return fmt.Errorf("id %d: must be positive", fl.ID)
+	case (fl.Path == "") == (fl.Data == nil):
+		return errors.Error("exactly one of path and data must be set")
+	default:
+		return nil
+	}
+}
+
+// Server is an embedded DNS server, which filters the requests and forwards
+// them to the upstream servers.
+type Server struct {
+	dnsServer *dnsforward.Server
+	dnsFilter *filtering.DNSFilter
+}
+
+// New returns a new properly initialized embedded DNS server.  conf must not
+// be modified after calling New.
+func New(conf *Config) (s *Server, err error) {
+	err = conf.validate()
+	if err != nil {
+		return nil, fmt.Errorf("embedded: validating config: %w", err)
+	}
+
+	filters := []filtering.Filter{{
+		ID:   filtering.CustomListID,
+		Data: []byte(strings.Join(conf.UserRules, "\n")),
+	}}
+
+	for _, fl := range conf.FilterLists {
+		filters = append(filters, filtering.Filter{
+			ID:       fl.ID,
+			FilePath: fl.Path,
+			Data:     fl.Data,
+		})
+	}
+
+	dnsFilter, err := filtering.New(&filtering.Config{
+		DataDir:           conf.DataDir,
+		BlockingMode:      filtering.BlockingModeDefault,
+		ProtectionEnabled: true,
+		FilteringEnabled:  true,
+	}, filters)
+	if err != nil {
+		return nil, fmt.Errorf("embedded: %w", err)
+	}
+
+	dnsFilter.SetEnabled(true)
+
+	s = &Server{
+		dnsFilter: dnsFilter,
+	}
+
+	p := dnsforward.DNSCreateParams{
+		DNSFilter:   dnsFilter,
+		DHCPServer:  emptyDHCP{},
+		PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+		Anonymizer:  aghnet.NewIPMut(nil),
+	}
+
+	if conf.QueryLogger != nil {
+		p.QueryLog = &queryLog{logger: conf.QueryLogger}
+	}
+
+	s.dnsServer, err = dnsforward.NewServer(p)
+	if err != nil {
+		dnsFilter.Close()
+
+		return nil, fmt.Errorf("embedded: %w", err)
+	}
+
+	err = s.dnsServer.Prepare(newServerConfig(conf))
+	if err != nil {
+		s.dnsServer.Close()
+		dnsFilter.Close()
+
+		return nil, fmt.Errorf("embedded: preparing dns server: %w", err)
+	}
+
+	return s, nil
+}
+
+// newServerConfig returns the configuration of the DNS server for conf.
+func newServerConfig(conf *Config) (srvConf *dnsforward.ServerConfig) {
+	srvConf = &dnsforward.ServerConfig{
+		Config: dnsforward.Config{
+			UpstreamDNS:        conf.Upstreams,
+			BootstrapDNS:       conf.BootstrapDNS,
+			CacheSize:          DefaultCacheSize,
+			EDNSClientSubnet:   &dnsforward.EDNSClientSubnet{},
+			MaxGoroutines:      300,
+			DeduplicateQueries: true,
+		},
+		UpstreamTimeout: conf.UpstreamTimeout,
+	}
+
+	if conf.CacheSize != 0 {
+		srvConf.CacheSize = conf.CacheSize
+	}
+
+	if conf.DisableCache {
+		srvConf.CacheSize = 0
+	}
+
+	for _, addrPort := range conf.ListenAddrs {
+		srvConf.UDPListenAddrs = append(srvConf.UDPListenAddrs, net.UDPAddrFromAddrPort(addrPort))
+		srvConf.TCPListenAddrs = append(srvConf.TCPListenAddrs, net.TCPAddrFromAddrPort(addrPort))
+	}
+
+	return srvConf
+}
+
+// Start starts serving the DNS requests.
+func (s *Server) Start() (err error) {
+	err = s.dnsServer.Start()
+	if err != nil {
+		return fmt.Errorf("embedded: starting: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops serving the DNS requests and releases the resources of s.  s
+// must not be used after calling Close.
+func (s *Server) Close() (err error) {
+	err = s.dnsServer.Stop()
+	s.dnsServer.Close()
+	s.dnsFilter.Close()
+
+	if err != nil {
+		return fmt.Errorf("embedded: stopping: %w", err)
+	}
+
+	return nil
+}
+
+// CheckResult is the result of matching a host against the filtering rules.
+type CheckResult struct {
+	// Reason is the name of the reason of the result, for example
+	// "FilteredBlockList" or "NotFilteredNotFound".
+	Reason string
+
+	// Rules are the matched rules.
+	Rules []*MatchedRule
+
+	// IsFiltered is true if the request for the host is blocked.
+	IsFiltered bool
+}
+
+// MatchedRule is a rule, which has matched the host.
+type MatchedRule struct {
+	// Text is the text of the rule.
+	Text string
+
+	// FilterListID is the ID of the filter list the rule belongs to.  It's
+	// zero for the user rules.
+	FilterListID int64
+}
+
+// CheckHost matches host against the filtering rules as if it has been
+// requested with qtype by the client with clientIP, which may be empty.
+func (s *Server) CheckHost(host string, qtype uint16, clientIP netip.Addr) (res *CheckResult, err error) {
+	setts := s.dnsFilter.Settings()
+	setts.ProtectionEnabled = true
+	if clientIP.IsValid() {
+		setts.ClientIP = clientIP
+	}
+
+	fres, err := s.dnsFilter.CheckHost(host, qtype, setts)
+	if err != nil {
+		return nil, fmt.Errorf("embedded: checking host: %w", err)
+	}
+
+	return newCheckResult(&fres), nil
+}
+
+// newCheckResult converts the filtering result into the public one.
+func newCheckResult(fres *filtering.Result) (res *CheckResult) {
+	res = &CheckResult{
+		Reason:     fres.Reason.String(),
+		Rules:      make([]*MatchedRule, 0, len(fres.Rules)),
+		IsFiltered: fres.IsFiltered,
+	}
+
+	for _, r := range fres.Rules {
+		res.Rules = append(res.Rules, &MatchedRule{
+			Text:         r.Text,
+			FilterListID: r.FilterListID,
+		})
+	}
+
+	return res
+}
+
+// emptyDHCP is the [dnsforward.DHCP] that knows no clients, since the embedded
+// server doesn't run a DHCP server.
+type emptyDHCP struct{}
+
+// type check
+var _ dnsforward.DHCP = emptyDHCP{}
+
+// HostByIP implements the [dnsforward.DHCP] interface for emptyDHCP.
+func (emptyDHCP) HostByIP(_ netip.Addr) (host string) { return "" }
+
+// IPsByHost implements the [dnsforward.DHCP] interface for emptyDHCP.
+func (emptyDHCP) IPsByHost(_ string) (ips []netip.Addr) { return nil }
+
+// Enabled implements the [dnsforward.DHCP] interface for emptyDHCP.
+func (emptyDHCP) Enabled() (ok bool) { return false }
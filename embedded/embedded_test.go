@@ -0,0 +1,126 @@
+package embedded_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/embedded"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// newConf returns a new valid configuration for tests.
+func newConf(t *testing.T) (conf *embedded.Config) {
+	t.Helper()
+
+	return &embedded.Config{
+		DataDir:     t.TempDir(),
+		ListenAddrs: []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:0")},
+		Upstreams:   []string{"127.0.0.1:53"},
+		FilterLists: []*embedded.FilterList{{
+			Data: []byte("||blocked.example^\n||allowed.example^\n"),
+			ID:   1,
+		}},
+		UserRules: []string{"@@||allowed.example^"},
+	}
+}
+
+func TestServer_CheckHost(t *testing.T) {
+	srv, err := embedded.New(newConf(t))
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, srv.Close)
+
+	testCases := []struct {
+		want *embedded.CheckResult
+		name string
+		host string
+	}{{
+		want: &embedded.CheckResult{
+			Reason: "FilteredBlackList",
+			Rules: []*embedded.MatchedRule{{
+				Text:         "||blocked.example^",
+				FilterListID: 1,
+			}},
+			IsFiltered: true,
+		},
+		name: "blocked",
+		host: "blocked.example",
+	}, {
+		want: &embedded.CheckResult{
+			Reason: "NotFilteredWhiteList",
+			Rules: []*embedded.MatchedRule{{
+				Text:         "@@||allowed.example^",
+				FilterListID: 0,
+			}},
+			IsFiltered: false,
+		},
+		name: "allowed",
+		host: "allowed.example",
+	}, {
+		want: &embedded.CheckResult{
+			Reason:     "NotFilteredNotFound",
+			Rules:      []*embedded.MatchedRule{},
+			IsFiltered: false,
+		},
+		name: "not_found",
+		host: "other.example",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cErr := srv.CheckHost(tc.host, dns.TypeA, netip.Addr{})
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.want, res)
+		})
+	}
+}
+
+func TestNew_badConfig(t *testing.T) {
+	testCases := []struct {
+		modify     func(conf *embedded.Config)
+		name       string
+		wantErrMsg string
+	}{{
+		modify:     func(conf *embedded.Config) { conf.DataDir = "" },
+		name:       "no_data_dir",
+		wantErrMsg: "embedded: validating config: no data dir",
+	}, {
+		modify:     func(conf *embedded.Config) { conf.Upstreams = nil },
+		name:       "no_upstreams",
+		wantErrMsg: "embedded: validating config: no upstreams",
+	}, {
+		modify: func(conf *embedded.Config) {
+			conf.FilterLists = append(conf.FilterLists, &embedded.FilterList{
+				Path: "/path/to/list.txt",
+				ID:   1,
+			})
+		},
+		name: "duplicate_list",
+		wantErrMsg: "embedded: validating config: " +
+			"filter list at index 1: duplicate id 1",
+	}, {
+		modify: func(conf *embedded.Config) {
+			conf.FilterLists[0].Path = "/path/to/list.txt"
+		},
+		name: "path_and_data",
+		wantErrMsg: "embedded: validating config: " +
+			"filter list at index 0: exactly one of path and data must be set",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := newConf(t)
+			tc.modify(conf)
+
+			_, err := embedded.New(conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
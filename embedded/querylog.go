@@ -0,0 +1,106 @@
+package embedded
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// QueryLogger receives the entries about the processed requests.
+type QueryLogger interface {
+	// LogQuery is called for every processed request.  It must be safe for
+	// concurrent use and must not block for long, since it's called on the
+	// request processing path.  e must not be retained.
+	LogQuery(e *QueryLogEntry)
+}
+
+// QueryLogEntry is the information about a processed request.
+type QueryLogEntry struct {
+	// Time is the time when the request has been processed.
+	Time time.Time
+
+	// Answer is the response sent to the client, if any.
+	Answer *dns.Msg
+
+	// Result is the result of filtering the request.  It's nil if the request
+	// hasn't been filtered.
+	Result *CheckResult
+
+	// Host is the requested host name without the trailing dot.
+	Host string
+
+	// ClientID is the ClientID of the client, if any.
+	ClientID string
+
+	// Upstream is the address of the upstream server, which has answered the
+	// request, if any.
+	Upstream string
+
+	// ClientIP is the IP address of the client.
+	ClientIP netip.Addr
+
+	// Elapsed is the time spent for processing the request.
+	Elapsed time.Duration
+
+	// QType is the type of the requested records.
+	QType uint16
+
+	// Cached is true if the response has been served from the cache.
+	Cached bool
+}
+
+// queryLog is the [querylog.QueryLog] passing the entries to a [QueryLogger].
+type queryLog struct {
+	logger QueryLogger
+}
+
+// type check
+var _ querylog.QueryLog = (*queryLog)(nil)
+
+// Start implements the [querylog.QueryLog] interface for *queryLog.
+func (l *queryLog) Start() {}
+
+// Close implements the [querylog.QueryLog] interface for *queryLog.
+func (l *queryLog) Close() {}
+
+// Add implements the [querylog.QueryLog] interface for *queryLog.
+func (l *queryLog) Add(p *querylog.AddParams) {
+	if p.Question == nil || len(p.Question.Question) != 1 {
+		return
+	}
+
+	q := p.Question.Question[0]
+	e := &QueryLogEntry{
+		Time:     time.Now(),
+		Answer:   p.Answer,
+		Host:     strings.ToLower(strings.TrimSuffix(q.Name, ".")),
+		ClientID: p.ClientID,
+		Upstream: p.Upstream,
+		Elapsed:  p.Elapsed,
+		QType:    q.Qtype,
+		Cached:   p.Cached,
+	}
+
+	if p.ClientIP != nil {
+		e.ClientIP, _ = netutil.IPToAddrNoMapped(p.ClientIP)
+	}
+
+	if p.Result != nil {
+		e.Result = newCheckResult(p.Result)
+	}
+
+	l.logger.LogQuery(e)
+}
+
+// WriteDiskConfig implements the [querylog.QueryLog] interface for *queryLog.
+func (l *queryLog) WriteDiskConfig(_ *querylog.Config) {}
+
+// ShouldLog implements the [querylog.QueryLog] interface for *queryLog.  It
+// always returns true.
+func (l *queryLog) ShouldLog(_ string, _, _ uint16, _ []string) (ok bool) {
+	return true
+}
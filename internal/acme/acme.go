@@ -0,0 +1,355 @@
+// Package acme contains the client of the ACME certificate authorities, such as
+// Let's Encrypt, which obtains and renews the TLS certificates using the
+// HTTP-01 or the DNS-01 challenges.
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"golang.org/x/exp/slices"
+)
+
+// Challenge is the type of the ACME challenge used to prove the control over
+// the domains.
+type Challenge string
+
+// Challenge types.
+const (
+	// ChallengeHTTP01 means that the certificate authority requests a token
+	// from the web server on port 80.
+	ChallengeHTTP01 Challenge = "http-01"
+
+	// ChallengeDNS01 means that the certificate authority looks up a TXT
+	// record of the "_acme-challenge" subdomain.
+	ChallengeDNS01 Challenge = "dns-01"
+)
+
+// HTTP01PathPrefix is the prefix of the paths of the HTTP-01 challenge
+// requests.
+const HTTP01PathPrefix = "/.well-known/acme-challenge/"
+
+// LetsEncryptURL is the URL of the directory of the Let's Encrypt production
+// certificate authority.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// DefaultRenewBefore is the default duration before the expiration of the
+// certificate, within which it's renewed.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// Names of the files in [Config.Dir].
+const (
+	accountKeyFile = "account.key"
+	certFile       = "cert.pem"
+	keyFile        = "key.pem"
+)
+
+const (
+	// checkIvl is the maximum interval between the checks of the certificate
+	// expiration.
+	checkIvl = 12 * time.Hour
+
+	// retryIvl is the interval between the attempts to obtain a certificate
+	// after a failure.
+	retryIvl = 1 * time.Hour
+
+	// obtainTimeout is the timeout of a single attempt to obtain a
+	// certificate.
+	obtainTimeout = 10 * time.Minute
+)
+
+// Config is the configuration of a [Manager].
+type Config struct {
+	// DNSProvider provisions the TXT records for the DNS-01 challenges.  It
+	// must not be nil if Challenge is [ChallengeDNS01].
+	DNSProvider DNSProvider
+
+	// HTTPClient is the client used to send the requests to the certificate
+	// authority.  If nil, [http.DefaultClient] is used.
+	HTTPClient *http.Client
+
+	// OnCertificate is called with the PEM-encoded certificate chain and
+	// private key each time a new certificate is obtained.  It must not be
+	// nil.
+	OnCertificate func(certChain, privateKey []byte)
+
+	// DirectoryURL is the URL of the directory of the certificate authority.
+	// If empty, [LetsEncryptURL] is used.
+	DirectoryURL string
+
+	// Email is the contact address of the account.  It may be empty.
+	Email string
+
+	// Dir is the directory, in which the account key, the certificate, and
+	// its private key are kept.  It must not be empty.
+	Dir string
+
+	// Challenge is the type of the challenge.  It must be either
+	// [ChallengeHTTP01] or [ChallengeDNS01].
+	Challenge Challenge
+
+	// Domains are the domain names of the certificate.  The first one is used
+	// as the common name.  Wildcards are only allowed for [ChallengeDNS01].
+	// It must not be empty.
+	Domains []string
+
+	// RenewBefore is the duration before the expiration of the certificate,
+	// within which it's renewed.  If zero, [DefaultRenewBefore] is used.
+	RenewBefore time.Duration
+
+	// PropagationDelay is the duration to wait after the DNS-01 TXT records
+	// have been provisioned before asking the certificate authority to check
+	// them.
+	PropagationDelay time.Duration
+}
+
+// validate returns an error if c is invalid.
+func (c *Config) validate() (err error) {
+	switch {
+	case c.OnCertificate == nil:
+		return errors.Error("no certificate callback")
+	case c.Dir == "":
+		return errors.Error("no directory")
+	case len(c.Domains) == 0:
+		return errors.Error("no domains")
+	case c.RenewBefore < 0:
+		return fmt.Errorf("renew_before: must not be negative, got %s", c.RenewBefore)
+	case c.PropagationDelay < 0:
+		return fmt.Errorf(
+			"propagation_delay: must not be negative, got %s",
+			c.PropagationDelay,
+		)
+	}
+
+	switch c.Challenge {
+	case ChallengeHTTP01:
+		// Go on.
+	case ChallengeDNS01:
+		if c.DNSProvider == nil {
+			return errors.Error("dns-01 challenge requires a dns provider")
+		}
+	default:
+		return fmt.Errorf("bad challenge %q", c.Challenge)
+	}
+
+	for i, d := range c.Domains {
+		name, isWildcard := strings.CutPrefix(d, "*.")
+		if isWildcard && c.Challenge != ChallengeDNS01 {
+			return fmt.Errorf("domain at index %d: wildcards require dns-01 challenge", i)
+		}
+
+		err = netutil.ValidateHostname(name)
+		if err != nil {
+			return fmt.Errorf("domain at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Manager obtains the certificates from an ACME certificate authority and
+// renews them before they expire.  It also serves the HTTP-01 challenge
+// responses.
+type Manager struct {
+	conf *Config
+
+	// tokensMu protects tokens.
+	tokensMu *sync.RWMutex
+
+	// tokens are the key authorizations of the pending HTTP-01 challenges by
+	// their tokens.
+	tokens map[string]string
+
+	// obtainMu prevents the concurrent attempts to obtain a certificate.
+	obtainMu *sync.Mutex
+
+	// done is closed when the manager is closing.
+	done chan struct{}
+}
+
+// New returns a new properly initialized *Manager.  conf must not be modified
+// after calling New.
+func New(conf *Config) (m *Manager, err error) {
+	err = conf.validate()
+	if err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
+	}
+
+	c := *conf
+	if c.DirectoryURL == "" {
+		c.DirectoryURL = LetsEncryptURL
+	}
+
+	if c.RenewBefore == 0 {
+		c.RenewBefore = DefaultRenewBefore
+	}
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+
+	return &Manager{
+		conf:     &c,
+		tokensMu: &sync.RWMutex{},
+		tokens:   map[string]string{},
+		obtainMu: &sync.Mutex{},
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// CertificatePaths returns the paths to the files with the PEM-encoded
+// certificate chain and private key.  The files may not exist until the first
+// certificate is obtained.
+func (m *Manager) CertificatePaths() (certPath, keyPath string) {
+	return filepath.Join(m.conf.Dir, certFile), filepath.Join(m.conf.Dir, keyFile)
+}
+
+// Start starts renewing the certificate in the background.  It obtains the
+// certificate immediately if there is none or it needs renewal.
+func (m *Manager) Start() {
+	go m.renewLoop()
+}
+
+// Close stops renewing the certificate.
+func (m *Manager) Close() {
+	close(m.done)
+}
+
+// renewLoop renews the certificate until m is closed.  It's intended to be
+// used as a goroutine.
+func (m *Manager) renewLoop() {
+	defer log.OnPanic("acme: renewing")
+
+	for {
+		t := time.NewTimer(m.refresh(time.Now()))
+		select {
+		case <-m.done:
+			t.Stop()
+
+			return
+		case <-t.C:
+			// Go on.
+		}
+	}
+}
+
+// refresh obtains a new certificate if the current one needs renewal and
+// returns the duration until the next check.
+func (m *Manager) refresh(now time.Time) (next time.Duration) {
+	cert, err := m.current()
+	if err != nil {
+		log.Info("acme: %s; obtaining new certificate", err)
+	} else if left := cert.NotAfter.Sub(now); left > m.conf.RenewBefore {
+		return mathutil.Min(left-m.conf.RenewBefore, checkIvl)
+	} else {
+		log.Info("acme: certificate expires at %s; renewing", cert.NotAfter)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), obtainTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-m.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = m.Obtain(ctx)
+	if err != nil {
+		log.Error("acme: %s; retrying in %s", err, retryIvl)
+
+		return retryIvl
+	}
+
+	return checkIvl
+}
+
+// current returns the current certificate, if it covers all the configured
+// domains.
+func (m *Manager) current() (cert *x509.Certificate, err error) {
+	certPath, _ := m.CertificatePaths()
+	b, err := os.ReadFile(certPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Error("no certificate")
+		}
+
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.Error("no pem block in certificate file")
+	}
+
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	for _, d := range m.conf.Domains {
+		if !slices.Contains(cert.DNSNames, d) {
+			return nil, fmt.Errorf("certificate doesn't contain domain %q", d)
+		}
+	}
+
+	return cert, nil
+}
+
+// type check
+var _ http.Handler = (*Manager)(nil)
+
+// ServeHTTP implements the [http.Handler] interface for *Manager.  It responds
+// to the HTTP-01 challenge requests, which paths start with
+// [HTTP01PathPrefix].
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, HTTP01PathPrefix)
+	if !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	m.tokensMu.RLock()
+	defer m.tokensMu.RUnlock()
+
+	keyAuth, ok := m.tokens[token]
+	if !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, err := w.Write([]byte(keyAuth))
+	if err != nil {
+		log.Debug("acme: writing challenge response: %s", err)
+	}
+}
+
+// setToken sets the key authorization of the pending HTTP-01 challenge with
+// token.  If keyAuth is empty, the challenge is removed.
+func (m *Manager) setToken(token, keyAuth string) {
+	m.tokensMu.Lock()
+	defer m.tokensMu.Unlock()
+
+	if keyAuth == "" {
+		delete(m.tokens, token)
+	} else {
+		m.tokens[token] = keyAuth
+	}
+}
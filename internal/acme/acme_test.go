@@ -0,0 +1,615 @@
+package acme_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/acme"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 5 * time.Second
+
+// testAuthz is an authorization of the test certificate authority.
+type testAuthz struct {
+	domain string
+	token  string
+	status string
+}
+
+// testCA is a minimal RFC 8555 certificate authority for tests.  It doesn't
+// verify the request signatures.
+type testCA struct {
+	// validate is called when a challenge is accepted.
+	validate func(typ, domain, token string) (err error)
+
+	mu *sync.Mutex
+
+	srv    *httptest.Server
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	authzs []*testAuthz
+	chain  []byte
+
+	nonce    int
+	accounts int
+}
+
+// newTestCA returns a new running test certificate authority.
+func newTestCA(t *testing.T, validate func(typ, domain, token string) (err error)) (ca *testCA) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, caKey.Public(), caKey)
+	require.NoError(t, err)
+
+	caCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca = &testCA{
+		validate: validate,
+		mu:       &sync.Mutex{},
+		caCert:   caCert,
+		caKey:    caKey,
+	}
+
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.serveHTTP))
+	t.Cleanup(ca.srv.Close)
+
+	return ca
+}
+
+// dirURL returns the URL of the directory of ca.
+func (ca *testCA) dirURL() (u string) {
+	return ca.srv.URL + "/dir"
+}
+
+// serveHTTP handles the requests to ca.
+func (ca *testCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.nonce))
+	w.Header().Set("Cache-Control", "no-store")
+
+	var payload []byte
+	if r.Method == http.MethodPost {
+		var jws struct {
+			Payload string `json:"payload"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&jws)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		payload, err = base64.RawURLEncoding.DecodeString(jws.Payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	p := r.URL.Path
+	switch {
+	case p == "/dir":
+		ca.writeJSON(w, http.StatusOK, map[string]any{
+			"newNonce":   ca.srv.URL + "/nonce",
+			"newAccount": ca.srv.URL + "/account",
+			"newOrder":   ca.srv.URL + "/order",
+			"revokeCert": ca.srv.URL + "/revoke",
+			"keyChange":  ca.srv.URL + "/key-change",
+		})
+	case p == "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case p == "/account":
+		ca.handleAccount(w)
+	case p == "/order" && len(payload) > 0:
+		ca.handleNewOrder(w, payload)
+	case p == "/order":
+		ca.writeOrder(w)
+	case strings.HasPrefix(p, "/authz/"):
+		ca.handleAuthz(w, strings.TrimPrefix(p, "/authz/"))
+	case strings.HasPrefix(p, "/chal/"):
+		ca.handleChallenge(w, strings.TrimPrefix(p, "/chal/"))
+	case p == "/finalize":
+		ca.handleFinalize(w, payload)
+	case p == "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON writes v as the JSON response with code.
+func (ca *testCA) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// handleAccount handles the account registration.
+func (ca *testCA) handleAccount(w http.ResponseWriter) {
+	ca.accounts++
+	w.Header().Set("Location", ca.srv.URL+"/account/1")
+
+	code := http.StatusCreated
+	if ca.accounts > 1 {
+		code = http.StatusOK
+	}
+
+	ca.writeJSON(w, code, map[string]any{"status": "valid"})
+}
+
+// handleNewOrder handles the creation of an order.
+func (ca *testCA) handleNewOrder(w http.ResponseWriter, payload []byte) {
+	var req struct {
+		Identifiers []struct {
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}
+
+	err := json.Unmarshal(payload, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	ca.authzs, ca.chain = nil, nil
+	for i, id := range req.Identifiers {
+		ca.authzs = append(ca.authzs, &testAuthz{
+			domain: id.Value,
+			token:  fmt.Sprintf("token-%d", i),
+			status: "pending",
+		})
+	}
+
+	w.Header().Set("Location", ca.srv.URL+"/order")
+	ca.writeJSON(w, http.StatusCreated, ca.order())
+}
+
+// writeOrder writes the current order.
+func (ca *testCA) writeOrder(w http.ResponseWriter) {
+	w.Header().Set("Location", ca.srv.URL+"/order")
+	ca.writeJSON(w, http.StatusOK, ca.order())
+}
+
+// order returns the current order.
+func (ca *testCA) order() (o map[string]any) {
+	var authzURLs []string
+	status := "ready"
+	for i, a := range ca.authzs {
+		authzURLs = append(authzURLs, fmt.Sprintf("%s/authz/%d", ca.srv.URL, i))
+		if a.status != "valid" {
+			status = "pending"
+		}
+	}
+
+	o = map[string]any{
+		"status":         status,
+		"authorizations": authzURLs,
+		"finalize":       ca.srv.URL + "/finalize",
+	}
+
+	if ca.chain != nil {
+		o["status"] = "valid"
+		o["certificate"] = ca.srv.URL + "/cert"
+	}
+
+	return o
+}
+
+// authz returns the authorization by its index in s.
+func (ca *testCA) authz(s string) (i int, a *testAuthz) {
+	_, err := fmt.Sscanf(s, "%d", &i)
+	if err != nil || i >= len(ca.authzs) {
+		return 0, nil
+	}
+
+	return i, ca.authzs[i]
+}
+
+// handleAuthz handles the requests of the authorizations.
+func (ca *testCA) handleAuthz(w http.ResponseWriter, idx string) {
+	i, a := ca.authz(idx)
+	if a == nil {
+		http.Error(w, "no authz", http.StatusNotFound)
+
+		return
+	}
+
+	var chals []map[string]any
+	for _, typ := range []string{"http-01", "dns-01"} {
+		chals = append(chals, map[string]any{
+			"type":   typ,
+			"url":    fmt.Sprintf("%s/chal/%d/%s", ca.srv.URL, i, typ),
+			"token":  a.token,
+			"status": a.status,
+		})
+	}
+
+	domain, isWildcard := strings.CutPrefix(a.domain, "*.")
+	ca.writeJSON(w, http.StatusOK, map[string]any{
+		"identifier": map[string]any{"type": "dns", "value": domain},
+		"status":     a.status,
+		"wildcard":   isWildcard,
+		"challenges": chals,
+	})
+}
+
+// handleChallenge handles the acceptance of a challenge.
+func (ca *testCA) handleChallenge(w http.ResponseWriter, path string) {
+	idx, typ, _ := strings.Cut(path, "/")
+	_, a := ca.authz(idx)
+	if a == nil {
+		http.Error(w, "no authz", http.StatusNotFound)
+
+		return
+	}
+
+	domain := strings.TrimPrefix(a.domain, "*.")
+	if err := ca.validate(typ, domain, a.token); err != nil {
+		a.status = "invalid"
+	} else {
+		a.status = "valid"
+	}
+
+	ca.writeJSON(w, http.StatusOK, map[string]any{
+		"type":   typ,
+		"url":    ca.srv.URL + "/chal/" + path,
+		"token":  a.token,
+		"status": a.status,
+	})
+}
+
+// handleFinalize handles the finalization of the order.
+func (ca *testCA) handleFinalize(w http.ResponseWriter, payload []byte) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+
+	err := json.Unmarshal(payload, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	leaf, err := x509.CreateCertificate(rand.Reader, tmpl, ca.caCert, csr.PublicKey, ca.caKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	ca.chain = append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...,
+	)
+
+	ca.writeOrder(w)
+}
+
+// testDNSProvider is a [acme.DNSProvider] for tests.
+type testDNSProvider struct {
+	mu      *sync.Mutex
+	records map[string]string
+	cleaned []string
+}
+
+// type check
+var _ acme.DNSProvider = (*testDNSProvider)(nil)
+
+// Present implements the [acme.DNSProvider] interface for *testDNSProvider.
+func (p *testDNSProvider) Present(_ context.Context, fqdn, value string) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.records[fqdn] = value
+
+	return nil
+}
+
+// CleanUp implements the [acme.DNSProvider] interface for *testDNSProvider.
+func (p *testDNSProvider) CleanUp(_ context.Context, fqdn, _ string) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.records, fqdn)
+	p.cleaned = append(p.cleaned, fqdn)
+
+	return nil
+}
+
+// certResult is the certificate passed to [acme.Config.OnCertificate].
+type certResult struct {
+	chain []byte
+	key   []byte
+}
+
+// checkCertificate checks that res is a valid certificate pair for domains.
+func checkCertificate(t *testing.T, res *certResult, domains []string) {
+	t.Helper()
+
+	require.NotNil(t, res)
+
+	pair, err := tls.X509KeyPair(res.chain, res.key)
+	require.NoError(t, err)
+	require.Len(t, pair.Certificate, 2)
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+
+	assert.Equal(t, domains, leaf.DNSNames)
+	assert.Equal(t, domains[0], leaf.Subject.CommonName)
+}
+
+func TestManager_Obtain_http01(t *testing.T) {
+	var m *acme.Manager
+	ca := newTestCA(t, func(typ, _, token string) (err error) {
+		if typ != string(acme.ChallengeHTTP01) {
+			return fmt.Errorf("unexpected challenge %q", typ)
+		}
+
+		rw := httptest.NewRecorder()
+		m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, acme.HTTP01PathPrefix+token, nil))
+		if rw.Code != http.StatusOK || !strings.HasPrefix(rw.Body.String(), token+".") {
+			return fmt.Errorf("bad response %d %q", rw.Code, rw.Body)
+		}
+
+		return nil
+	})
+
+	domains := []string{"example.com", "www.example.com"}
+
+	var res *certResult
+	m, err := acme.New(&acme.Config{
+		OnCertificate: func(certChain, privateKey []byte) {
+			res = &certResult{chain: certChain, key: privateKey}
+		},
+		DirectoryURL: ca.dirURL(),
+		Email:        "admin@example.com",
+		Dir:          t.TempDir(),
+		Challenge:    acme.ChallengeHTTP01,
+		Domains:      domains,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+
+	err = m.Obtain(ctx)
+	require.NoError(t, err)
+
+	checkCertificate(t, res, domains)
+
+	certPath, keyPath := m.CertificatePaths()
+
+	certData, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+	assert.Equal(t, res.chain, certData)
+
+	keyData, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, res.key, keyData)
+
+	// The challenge responses are removed after the authorization.
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, acme.HTTP01PathPrefix+"token-0", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+
+	// The account is reused.
+	err = m.Obtain(ctx)
+	require.NoError(t, err)
+
+	checkCertificate(t, res, domains)
+}
+
+func TestManager_Obtain_dns01(t *testing.T) {
+	prov := &testDNSProvider{
+		mu:      &sync.Mutex{},
+		records: map[string]string{},
+	}
+
+	ca := newTestCA(t, func(typ, domain, _ string) (err error) {
+		prov.mu.Lock()
+		defer prov.mu.Unlock()
+
+		if typ != string(acme.ChallengeDNS01) {
+			return fmt.Errorf("unexpected challenge %q", typ)
+		} else if prov.records["_acme-challenge."+domain+"."] == "" {
+			return fmt.Errorf("no record for %q", domain)
+		}
+
+		return nil
+	})
+
+	domains := []string{"*.example.com"}
+
+	var res *certResult
+	m, err := acme.New(&acme.Config{
+		DNSProvider: prov,
+		OnCertificate: func(certChain, privateKey []byte) {
+			res = &certResult{chain: certChain, key: privateKey}
+		},
+		DirectoryURL: ca.dirURL(),
+		Dir:          t.TempDir(),
+		Challenge:    acme.ChallengeDNS01,
+		Domains:      domains,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+
+	err = m.Obtain(ctx)
+	require.NoError(t, err)
+
+	checkCertificate(t, res, domains)
+
+	assert.Empty(t, prov.records)
+	assert.Equal(t, []string{"_acme-challenge.example.com."}, prov.cleaned)
+}
+
+func TestManager_Obtain_invalid(t *testing.T) {
+	ca := newTestCA(t, func(_, _, _ string) (err error) {
+		return assert.AnError
+	})
+
+	m, err := acme.New(&acme.Config{
+		OnCertificate: func(_, _ []byte) {
+			panic("not implemented")
+		},
+		DirectoryURL: ca.dirURL(),
+		Dir:          t.TempDir(),
+		Challenge:    acme.ChallengeHTTP01,
+		Domains:      []string{"example.com"},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+
+	err = m.Obtain(ctx)
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `authorizing "example.com": waiting for authorization`)
+}
+
+func TestNew_error(t *testing.T) {
+	onCert := func(_, _ []byte) {}
+
+	testCases := []struct {
+		conf       *acme.Config
+		name       string
+		wantErrMsg string
+	}{{
+		conf: &acme.Config{
+			Dir:       "dir",
+			Challenge: acme.ChallengeHTTP01,
+			Domains:   []string{"example.com"},
+		},
+		name:       "no_callback",
+		wantErrMsg: "validating config: no certificate callback",
+	}, {
+		conf: &acme.Config{
+			OnCertificate: onCert,
+			Dir:           "dir",
+			Challenge:     acme.ChallengeHTTP01,
+		},
+		name:       "no_domains",
+		wantErrMsg: "validating config: no domains",
+	}, {
+		conf: &acme.Config{
+			OnCertificate: onCert,
+			Dir:           "dir",
+			Challenge:     "tls-alpn-01",
+			Domains:       []string{"example.com"},
+		},
+		name:       "bad_challenge",
+		wantErrMsg: `validating config: bad challenge "tls-alpn-01"`,
+	}, {
+		conf: &acme.Config{
+			OnCertificate: onCert,
+			Dir:           "dir",
+			Challenge:     acme.ChallengeDNS01,
+			Domains:       []string{"example.com"},
+		},
+		name:       "no_provider",
+		wantErrMsg: "validating config: dns-01 challenge requires a dns provider",
+	}, {
+		conf: &acme.Config{
+			OnCertificate: onCert,
+			Dir:           "dir",
+			Challenge:     acme.ChallengeHTTP01,
+			Domains:       []string{"*.example.com"},
+		},
+		name: "wildcard_http01",
+		wantErrMsg: "validating config: domain at index 0: " +
+			"wildcards require dns-01 challenge",
+	}, {
+		conf: &acme.Config{
+			OnCertificate: onCert,
+			Dir:           "dir",
+			Challenge:     acme.ChallengeHTTP01,
+			Domains:       []string{"bad domain"},
+		},
+		name: "bad_domain",
+		wantErrMsg: `validating config: domain at index 0: bad hostname "bad domain": ` +
+			`bad top-level domain name label "bad domain": ` +
+			`bad top-level domain name label rune ' '`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := acme.New(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// DNSProvider provisions the TXT records for the DNS-01 challenges.
+type DNSProvider interface {
+	// Present creates a TXT record for fqdn with value.  fqdn is the fully
+	// qualified domain name with the trailing dot, for example
+	// "_acme-challenge.example.com.".
+	Present(ctx context.Context, fqdn, value string) (err error)
+
+	// CleanUp removes the TXT record created by Present.
+	CleanUp(ctx context.Context, fqdn, value string) (err error)
+}
+
+// ExecProvider is a [DNSProvider], which runs an external command to manage
+// the TXT records.  The command is run with three additional arguments: the
+// action, which is either "present" or "cleanup", the FQDN, and the value of
+// the record.
+type ExecProvider struct {
+	// command is the executable and its arguments.
+	command []string
+}
+
+// NewExecProvider returns a new *ExecProvider.  command must not be empty.
+func NewExecProvider(command []string) (p *ExecProvider) {
+	return &ExecProvider{
+		command: command,
+	}
+}
+
+// type check
+var _ DNSProvider = (*ExecProvider)(nil)
+
+// Present implements the [DNSProvider] interface for *ExecProvider.
+func (p *ExecProvider) Present(ctx context.Context, fqdn, value string) (err error) {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+// CleanUp implements the [DNSProvider] interface for *ExecProvider.
+func (p *ExecProvider) CleanUp(ctx context.Context, fqdn, value string) (err error) {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// run runs the command with the additional arguments args.
+func (p *ExecProvider) run(ctx context.Context, args ...string) (err error) {
+	argv := append(p.command[1:len(p.command):len(p.command)], args...)
+
+	// #nosec G204 -- The command is set by the administrator in the
+	// configuration file.
+	cmd := exec.CommandContext(ctx, p.command[0], argv...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running command: %w; output: %q", err, out)
+	}
+
+	return nil
+}
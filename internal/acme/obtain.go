@@ -0,0 +1,333 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/crypto/acme"
+)
+
+// Obtain obtains a new certificate from the certificate authority, writes it
+// into the directory, and calls [Config.OnCertificate].
+func (m *Manager) Obtain(ctx context.Context) (err error) {
+	defer func() { err = errors.Annotate(err, "obtaining certificate: %w") }()
+
+	m.obtainMu.Lock()
+	defer m.obtainMu.Unlock()
+
+	cl, err := m.newClient(ctx)
+	if err != nil {
+		return fmt.Errorf("registering account: %w", err)
+	}
+
+	order, err := cl.AuthorizeOrder(ctx, acme.DomainIDs(m.conf.Domains...))
+	if err != nil {
+		return fmt.Errorf("creating order: %w", err)
+	}
+
+	for _, u := range order.AuthzURLs {
+		err = m.authorize(ctx, cl, u)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+	}
+
+	order, err = cl.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("waiting for order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating key: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.conf.Domains[0]},
+		DNSNames: m.conf.Domains,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("creating csr: %w", err)
+	}
+
+	der, _, err := cl.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("finalizing order: %w", err)
+	}
+
+	certChain := &bytes.Buffer{}
+	for _, b := range der {
+		// Writes to a bytes.Buffer never fail.
+		_ = pem.Encode(certChain, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = m.writeCertificate(certChain.Bytes(), keyPEM)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	log.Info("acme: obtained certificate for %q", m.conf.Domains)
+
+	m.conf.OnCertificate(certChain.Bytes(), keyPEM)
+
+	return nil
+}
+
+// newClient returns a new ACME client with the account key from the directory
+// and makes sure that the account is registered.  It creates the key if
+// there is none.
+func (m *Manager) newClient(ctx context.Context) (cl *acme.Client, err error) {
+	key, err := m.accountKey()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	cl = &acme.Client{
+		Key:          key,
+		HTTPClient:   m.conf.HTTPClient,
+		DirectoryURL: m.conf.DirectoryURL,
+		UserAgent:    "AdGuardHome",
+	}
+
+	acct := &acme.Account{}
+	if m.conf.Email != "" {
+		acct.Contact = []string{"mailto:" + m.conf.Email}
+	}
+
+	_, err = cl.Register(ctx, acct, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, err
+	}
+
+	return cl, nil
+}
+
+// accountKey returns the account key from the directory.  It generates and
+// writes a new one if there is none.
+func (m *Manager) accountKey() (key crypto.Signer, err error) {
+	p := filepath.Join(m.conf.Dir, accountKeyFile)
+	b, err := os.ReadFile(p)
+	if err == nil {
+		return decodeKey(b)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading account key: %w", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating account key: %w", err)
+	}
+
+	b, err = encodeKey(ecKey)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	err = writeFile(p, b)
+	if err != nil {
+		return nil, fmt.Errorf("writing account key: %w", err)
+	}
+
+	return ecKey, nil
+}
+
+// authorize fulfills the challenge of the authorization at u, unless it's
+// already valid.
+func (m *Manager) authorize(ctx context.Context, cl *acme.Client, u string) (err error) {
+	authz, err := cl.GetAuthorization(ctx, u)
+	if err != nil {
+		return fmt.Errorf("getting authorization: %w", err)
+	} else if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	domain := authz.Identifier.Value
+	defer func() { err = errors.Annotate(err, "authorizing %q: %w", domain) }()
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if Challenge(c.Type) == m.conf.Challenge {
+			chal = c
+
+			break
+		}
+	}
+
+	if chal == nil {
+		return fmt.Errorf("no %s challenge offered", m.conf.Challenge)
+	}
+
+	cleanup, err := m.prepareChallenge(ctx, cl, domain, chal.Token)
+	if err != nil {
+		return fmt.Errorf("preparing challenge: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, cleanup()) }()
+
+	_, err = cl.Accept(ctx, chal)
+	if err != nil {
+		return fmt.Errorf("accepting challenge: %w", err)
+	}
+
+	_, err = cl.WaitAuthorization(ctx, authz.URI)
+	if err != nil {
+		return fmt.Errorf("waiting for authorization: %w", err)
+	}
+
+	return nil
+}
+
+// prepareChallenge makes the response to the challenge with token for domain
+// available to the certificate authority.  cleanup removes it.
+func (m *Manager) prepareChallenge(
+	ctx context.Context,
+	cl *acme.Client,
+	domain string,
+	token string,
+) (cleanup func() (err error), err error) {
+	if m.conf.Challenge == ChallengeHTTP01 {
+		var keyAuth string
+		keyAuth, err = cl.HTTP01ChallengeResponse(token)
+		if err != nil {
+			return nil, err
+		}
+
+		m.setToken(token, keyAuth)
+
+		return func() (err error) {
+			m.setToken(token, "")
+
+			return nil
+		}, nil
+	}
+
+	value, err := cl.DNS01ChallengeRecord(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// The challenge for a wildcard domain uses the TXT record of the base
+	// domain.  See RFC 8555, section 8.4.
+	fqdn := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+
+	err = m.conf.DNSProvider.Present(ctx, fqdn, value)
+	if err != nil {
+		return nil, fmt.Errorf("presenting txt record: %w", err)
+	}
+
+	cleanup = func() (err error) {
+		// Use a separate context, since ctx may already be canceled.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		return errors.Annotate(
+			m.conf.DNSProvider.CleanUp(cleanupCtx, fqdn, value),
+			"cleaning up txt record: %w",
+		)
+	}
+
+	if d := m.conf.PropagationDelay; d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.WithDeferred(ctx.Err(), cleanup())
+		case <-t.C:
+			// Go on.
+		}
+	}
+
+	return cleanup, nil
+}
+
+// writeCertificate writes the certificate chain and the private key into the
+// directory.
+func (m *Manager) writeCertificate(certChain, privateKey []byte) (err error) {
+	certPath, keyPath := m.CertificatePaths()
+
+	err = writeFile(keyPath, privateKey)
+	if err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+
+	err = writeFile(certPath, certChain)
+	if err != nil {
+		return fmt.Errorf("writing certificate: %w", err)
+	}
+
+	return nil
+}
+
+// writeFile atomically writes b into the file at p, which is only accessible
+// by the owner.
+func writeFile(p string, b []byte) (err error) {
+	err = os.MkdirAll(filepath.Dir(p), 0o700)
+	if err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	f, err := aghrenameio.NewPendingFile(p, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	_, err = f.Write(b)
+
+	return err
+}
+
+// encodeKey returns the PEM-encoded PKCS #8 form of key.
+func encodeKey(key *ecdsa.PrivateKey) (b []byte, err error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encoding key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// decodeKey parses the PEM-encoded PKCS #8 private key from b.
+func decodeKey(b []byte) (key crypto.Signer, err error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.Error("decoding account key: no pem block")
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("decoding account key: %w", err)
+	}
+
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("decoding account key: unsupported key type %T", k)
+	}
+
+	return key, nil
+}
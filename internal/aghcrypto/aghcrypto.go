@@ -0,0 +1,129 @@
+// Package aghcrypto contains utilities for encrypting the data stored on disk.
+package aghcrypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// KeySize is the size of the encryption key in bytes.  The key is used with
+// AES-256 in GCM mode.
+const KeySize = 32
+
+// Cipher encrypts and decrypts data using AES-256-GCM.  It is safe for
+// concurrent use.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a new properly initialized *Cipher with key, which must be
+// [KeySize] bytes long.
+func NewCipher(key []byte) (c *Cipher, err error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("bad key length %d, want %d", len(key), KeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating block cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating aead: %w", err)
+	}
+
+	return &Cipher{
+		aead: aead,
+	}, nil
+}
+
+// NewCipherFromFile returns a new *Cipher with the key read from the file at
+// path.  The file must contain either [KeySize] raw bytes or their hexadecimal
+// encoding, optionally surrounded by whitespace.  Such a file can be generated
+// with:
+//
+//	openssl rand -hex 32
+func NewCipherFromFile(path string) (c *Cipher, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	key := data
+	if len(key) != KeySize {
+		trimmed := bytes.TrimSpace(data)
+		key = make([]byte, hex.DecodedLen(len(trimmed)))
+		_, err = hex.Decode(key, trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key file: %w", err)
+		}
+	}
+
+	c, err = NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key file %q: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Seal encrypts and authenticates plaintext.  The result contains the random
+// nonce followed by the ciphertext.
+func (c *Cipher) Seal(plaintext []byte) (sealed []byte) {
+	nonceSize := c.aead.NonceSize()
+	sealed = make([]byte, nonceSize, nonceSize+len(plaintext)+c.aead.Overhead())
+
+	// crypto/rand.Read never returns an error on the supported platforms.
+	_, _ = rand.Read(sealed)
+
+	return c.aead.Seal(sealed, sealed, plaintext, nil)
+}
+
+// Open authenticates and decrypts sealed, which must have been produced by
+// [Cipher.Seal] with the same key.
+func (c *Cipher) Open(sealed []byte) (plaintext []byte, err error) {
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.Error("sealed data too short")
+	}
+
+	plaintext, err = c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// SealLine encrypts line, which must not contain a newline character, and
+// returns the result encoded with the standard base64 encoding, so that it
+// can be stored in line-oriented files.
+func (c *Cipher) SealLine(line []byte) (sealed []byte) {
+	data := c.Seal(line)
+	sealed = make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(sealed, data)
+
+	return sealed
+}
+
+// OpenLine decodes and decrypts line, which must have been produced by
+// [Cipher.SealLine] with the same key.
+func (c *Cipher) OpenLine(line []byte) (plaintext []byte, err error) {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(data, line)
+	if err != nil {
+		return nil, fmt.Errorf("decoding line: %w", err)
+	}
+
+	return c.Open(data[:n])
+}
@@ -0,0 +1,122 @@
+package aghcrypto_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey is the common encryption key for tests.
+var testKey = bytes.Repeat([]byte{0x42}, aghcrypto.KeySize)
+
+func TestCipher(t *testing.T) {
+	c, err := aghcrypto.NewCipher(testKey)
+	require.NoError(t, err)
+
+	plaintext := []byte(`{"QH":"example.org"}`)
+
+	t.Run("seal_open", func(t *testing.T) {
+		sealed := c.Seal(plaintext)
+		assert.NotContains(t, string(sealed), "example.org")
+
+		var got []byte
+		got, err = c.Open(sealed)
+		require.NoError(t, err)
+
+		assert.Equal(t, plaintext, got)
+	})
+
+	t.Run("seal_open_line", func(t *testing.T) {
+		sealed := c.SealLine(plaintext)
+		assert.NotContains(t, string(sealed), "\n")
+
+		var got []byte
+		got, err = c.OpenLine(sealed)
+		require.NoError(t, err)
+
+		assert.Equal(t, plaintext, got)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		sealed := c.Seal(plaintext)
+		sealed[len(sealed)-1] ^= 0xff
+
+		_, err = c.Open(sealed)
+		testutil.AssertErrorMsg(t, "cipher: message authentication failed", err)
+	})
+
+	t.Run("too_short", func(t *testing.T) {
+		_, err = c.Open([]byte{1, 2, 3})
+		testutil.AssertErrorMsg(t, "sealed data too short", err)
+	})
+
+	t.Run("other_key", func(t *testing.T) {
+		other, cErr := aghcrypto.NewCipher(make([]byte, aghcrypto.KeySize))
+		require.NoError(t, cErr)
+
+		_, err = other.Open(c.Seal(plaintext))
+		assert.Error(t, err)
+	})
+}
+
+func TestNewCipherFromFile(t *testing.T) {
+	dir := t.TempDir()
+
+	writeKey := func(t *testing.T, name string, data []byte) (path string) {
+		t.Helper()
+
+		path = filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+
+		return path
+	}
+
+	testCases := []struct {
+		name       string
+		data       []byte
+		wantErrMsg string
+	}{{
+		name:       "raw",
+		data:       testKey,
+		wantErrMsg: "",
+	}, {
+		name:       "hex",
+		data:       []byte(hex.EncodeToString(testKey) + "\n"),
+		wantErrMsg: "",
+	}, {
+		name: "short",
+		data: []byte("0102"),
+		wantErrMsg: `key file "` + filepath.Join(dir, "short") +
+			`": bad key length 2, want 32`,
+	}, {
+		name: "bad_hex",
+		data: []byte("not a key"),
+		wantErrMsg: "decoding key file: encoding/hex: invalid byte: " +
+			"U+006E 'n'",
+	}}
+
+	want, err := aghcrypto.NewCipher(testKey)
+	require.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, cErr := aghcrypto.NewCipherFromFile(writeKey(t, tc.name, tc.data))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, cErr)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			got, oErr := want.Open(c.Seal([]byte("data")))
+			require.NoError(t, oErr)
+
+			assert.Equal(t, []byte("data"), got)
+		})
+	}
+}
@@ -3,8 +3,10 @@ package aghnet
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/net/idna"
 )
 
 // NormalizeDomain returns a lowercased version of host without the final dot,
@@ -12,12 +14,44 @@ import (
 // case that to allow matching queries like:
 //
 //	dig IN NS '.'
+//
+// The internationalized labels of host are converted into punycode, so that the
+// names written in unicode match the ones sent by the clients.  If host can't be
+// converted, it's only lowercased.
 func NormalizeDomain(host string) (norm string) {
 	if host == "." {
 		return host
 	}
 
-	return strings.ToLower(strings.TrimSuffix(host, "."))
+	norm = strings.ToLower(strings.TrimSuffix(host, "."))
+	if isASCII(norm) {
+		return norm
+	}
+
+	ascii, err := idna.Lookup.ToASCII(norm)
+	if err != nil {
+		// The lookup profile is too strict for some names, e.g. the ones
+		// containing underscores or wildcards, so try to only encode the
+		// labels.
+		ascii, err = idna.Punycode.ToASCII(norm)
+	}
+
+	if err != nil {
+		return norm
+	}
+
+	return ascii
+}
+
+// isASCII returns true if s only contains ASCII characters.
+func isASCII(s string) (ok bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
 }
 
 // NewDomainNameSet returns nil and error, if list has duplicate or empty domain
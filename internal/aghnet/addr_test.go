@@ -8,6 +8,45 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func TestNormalizeDomain(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		in   string
+		want string
+	}{{
+		name: "root",
+		in:   ".",
+		want: ".",
+	}, {
+		name: "ascii",
+		in:   "WWW.Example.ORG.",
+		want: "www.example.org",
+	}, {
+		name: "unicode",
+		in:   "Пример.РФ.",
+		want: "xn--e1afmkfd.xn--p1ai",
+	}, {
+		name: "punycode",
+		in:   "xn--e1afmkfd.xn--p1ai",
+		want: "xn--e1afmkfd.xn--p1ai",
+	}, {
+		name: "wildcard",
+		in:   "*.пример.рф",
+		want: "*.xn--e1afmkfd.xn--p1ai",
+	}}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, aghnet.NormalizeDomain(tc.in))
+		})
+	}
+}
+
 func TestNewDomainNameSet(t *testing.T) {
 	t.Parallel()
 
@@ -5,6 +5,8 @@ package aghos
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
@@ -14,6 +16,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
@@ -76,6 +79,50 @@ func RunCommand(command string, arguments ...string) (code int, output []byte, e
 	return cmd.ProcessState.ExitCode(), out, nil
 }
 
+// hookWaitDelay is the time given to the hook process to exit after the
+// context is canceled, after which its output is no longer waited for.
+const hookWaitDelay = 1 * time.Second
+
+// hookEnv are the names of the environment variables passed to the hooks.
+var hookEnv = []string{"HOME", "LANG", "PATH", "TZ"}
+
+// RunHook runs the executable and its arguments from argv, which must not be
+// empty, with stdin as its standard input until it exits or ctx is canceled.
+// The command isn't run by a shell, and its environment only contains the
+// variables from [hookEnv].  On Unix, it's run in a separate process group,
+// which is killed entirely when ctx is canceled.  The output of the command is
+// only returned within err, and it's limited to [MaxCmdOutputSize].
+func RunHook(ctx context.Context, argv []string, stdin []byte) (err error) {
+	// #nosec G204 -- The command is set by the administrator in the
+	// configuration file.
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.WaitDelay = hookWaitDelay
+
+	for _, name := range hookEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+v)
+		}
+	}
+
+	// Make sure that the environment of AdGuard Home isn't inherited, if none
+	// of the variables are set.
+	if cmd.Env == nil {
+		cmd.Env = []string{}
+	}
+
+	isolateProcess(cmd)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		out = out[:mathutil.Min(len(out), MaxCmdOutputSize)]
+
+		return fmt.Errorf("running command: %w; output: %q", err, out)
+	}
+
+	return nil
+}
+
 // PIDByCommand searches for process named command and returns its PID ignoring
 // the PIDs from except.  If no processes found, the error returned.
 func PIDByCommand(command string, except ...int) (pid int, err error) {
@@ -2,7 +2,12 @@ package aghos
 
 import (
 	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/golibs/ioutil"
 	"github.com/stretchr/testify/assert"
@@ -95,3 +100,31 @@ func TestLargestLabeled(t *testing.T) {
 		assert.Equal(t, 1, instances)
 	})
 }
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a posix shell")
+	}
+
+	t.Setenv("AGH_TEST_SECRET", "secret")
+
+	outFile := filepath.Join(t.TempDir(), "out.txt")
+	cmd := []string{"sh", "-c", `{ cat; echo "${AGH_TEST_SECRET:-none}"; } > "$0"`, outFile}
+
+	err := RunHook(context.Background(), cmd, []byte("input\n"))
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(outFile)
+	require.NoError(t, err)
+
+	assert.Equal(t, "input\nnone\n", string(data))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = RunHook(ctx, []string{"sh", "-c", "sleep 10 & sleep 10"}, nil)
+	require.Error(t, err)
+
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -5,6 +5,7 @@ package aghos
 import (
 	"io/fs"
 	"os"
+	"os/exec"
 	"os/signal"
 
 	"golang.org/x/sys/unix"
@@ -41,3 +42,14 @@ func isShutdownSignal(sig os.Signal) (ok bool) {
 func sendShutdownSignal(_ chan<- os.Signal) {
 	// On Unix we are already notified by the system.
 }
+
+// isolateProcess makes cmd run in a separate process group, which is killed
+// entirely when the context of cmd is canceled.
+func isolateProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() (err error) {
+		// Kill the whole process group, so that the children of the command
+		// don't outlive it.
+		return unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+	}
+}
@@ -5,6 +5,7 @@ package aghos
 import (
 	"io/fs"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"syscall"
@@ -81,3 +82,7 @@ func isShutdownSignal(sig os.Signal) (ok bool) {
 func sendShutdownSignal(c chan<- os.Signal) {
 	c <- os.Interrupt
 }
+
+// isolateProcess does nothing on Windows, so the process is killed by the
+// default cancellation of cmd.
+func isolateProcess(_ *exec.Cmd) {}
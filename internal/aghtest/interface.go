@@ -8,6 +8,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
 	"github.com/AdguardTeam/AdGuardHome/internal/next/agh"
 	"github.com/AdguardTeam/AdGuardHome/internal/rdns"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
@@ -104,7 +105,8 @@ func (p *AddressProcessor) Close() (err error) {
 
 // AddressUpdater is a fake [client.AddressUpdater] implementation for tests.
 type AddressUpdater struct {
-	OnUpdateAddress func(ip netip.Addr, host string, info *whois.Info)
+	OnUpdateAddress    func(ip netip.Addr, host string, info *whois.Info)
+	OnUpdateDiscovered func(ip netip.Addr, info *discovery.Info)
 }
 
 // type check
@@ -116,6 +118,12 @@ func (p *AddressUpdater) UpdateAddress(ip netip.Addr, host string, info *whois.I
 	p.OnUpdateAddress(ip, host, info)
 }
 
+// UpdateDiscovered implements the [client.AddressUpdater] interface for
+// *AddressUpdater.
+func (p *AddressUpdater) UpdateDiscovered(ip netip.Addr, info *discovery.Info) {
+	p.OnUpdateDiscovered(ip, info)
+}
+
 // Package filtering
 
 // Resolver is a fake [filtering.Resolver] implementation for tests.
@@ -0,0 +1,268 @@
+package aghtls
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ECH protocol values.
+//
+// See https://datatracker.ietf.org/doc/draft-ietf-tls-esni.
+const (
+	// echVersion is the version of the ECHConfig structure.
+	echVersion uint16 = 0xfe0d
+
+	// hpkeKEMX25519 is the identifier of the DHKEM(X25519, HKDF-SHA256) HPKE
+	// key encapsulation mechanism.
+	hpkeKEMX25519 uint16 = 0x0020
+
+	// hpkeKDFHKDFSHA256 is the identifier of the HKDF-SHA256 HPKE key
+	// derivation function.
+	hpkeKDFHKDFSHA256 uint16 = 0x0001
+)
+
+// ErrECHUnsupported is returned when the Encrypted Client Hello is configured,
+// but AdGuard Home is built with a version of Go, which doesn't support it.
+const ErrECHUnsupported errors.Error = "encrypted client hello requires go 1.24 or later"
+
+// echAEADs are the identifiers of the HPKE AEAD functions advertised in the
+// ECH configurations: AES-128-GCM, AES-256-GCM, and ChaCha20Poly1305.
+var echAEADs = []uint16{0x0001, 0x0002, 0x0003}
+
+// PEM block types of the ECH key files.
+//
+// See https://datatracker.ietf.org/doc/draft-farrell-tls-pemesni.
+const (
+	pemTypePrivateKey = "PRIVATE KEY"
+	pemTypeECHConfig  = "ECHCONFIG"
+)
+
+// ECHKey is an Encrypted Client Hello key of a server.
+type ECHKey struct {
+	// Config is the serialized ECHConfig, which is published to the clients.
+	Config []byte
+
+	// PrivateKey is the serialized X25519 private key corresponding to the
+	// public key in Config.
+	PrivateKey []byte
+}
+
+// NewECHKey generates a new X25519 ECH key with the configuration identifier
+// id.  publicName is the name, which the clients put into the outer SNI, and it
+// must be covered by the certificate of the server.
+func NewECHKey(id uint8, publicName string) (key *ECHKey, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating private key: %w", err)
+	}
+
+	conf, err := marshalECHConfig(id, priv.PublicKey().Bytes(), publicName)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling ech config: %w", err)
+	}
+
+	return &ECHKey{
+		Config:     conf,
+		PrivateKey: priv.Bytes(),
+	}, nil
+}
+
+// marshalECHConfig returns the serialized ECHConfig with the X25519 public key
+// pub.
+func marshalECHConfig(id uint8, pub []byte, publicName string) (conf []byte, err error) {
+	if l := len(publicName); l == 0 || l > 255 {
+		return nil, fmt.Errorf("bad public name length %d", l)
+	}
+
+	b := &cryptobyte.Builder{}
+	b.AddUint16(echVersion)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(id)
+		b.AddUint16(hpkeKEMX25519)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(pub) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, aead := range echAEADs {
+				b.AddUint16(hpkeKDFHKDFSHA256)
+				b.AddUint16(aead)
+			}
+		})
+
+		// Use zero maximum name length to make the clients use the default
+		// padding policy.
+		b.AddUint8(0)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(publicName)) })
+
+		// No extensions.
+		b.AddUint16(0)
+	})
+
+	return b.Bytes()
+}
+
+// ECHConfigList returns the serialized ECHConfigList with the configurations
+// of keys, which is published in the HTTPS DNS records.
+func ECHConfigList(keys []*ECHKey) (list []byte, err error) {
+	b := &cryptobyte.Builder{}
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, k := range keys {
+			b.AddBytes(k.Config)
+		}
+	})
+
+	return b.Bytes()
+}
+
+// MarshalECHKey returns the PEM-encoded key with a PRIVATE KEY and an ECHCONFIG
+// blocks, as described in draft-farrell-tls-pemesni.
+func MarshalECHKey(key *ECHKey) (data []byte, err error) {
+	priv, err := ecdh.X25519().NewPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("bad private key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling private key: %w", err)
+	}
+
+	list, err := ECHConfigList([]*ECHKey{key})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling ech config list: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = pem.Encode(buf, &pem.Block{Type: pemTypePrivateKey, Bytes: der})
+	if err != nil {
+		return nil, fmt.Errorf("encoding private key: %w", err)
+	}
+
+	err = pem.Encode(buf, &pem.Block{Type: pemTypeECHConfig, Bytes: list})
+	if err != nil {
+		return nil, fmt.Errorf("encoding ech config list: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseECHKey parses the key encoded by [MarshalECHKey].  Only the first
+// configuration of the ECHCONFIG block is used.
+func ParseECHKey(data []byte) (key *ECHKey, err error) {
+	var priv *ecdh.PrivateKey
+	key = &ECHKey{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case pemTypePrivateKey:
+			priv, err = parseECHPrivateKey(block.Bytes)
+		case pemTypeECHConfig:
+			key.Config, err = parseFirstECHConfig(block.Bytes)
+		default:
+			err = fmt.Errorf("unexpected pem block type %q", block.Type)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case priv == nil:
+		return nil, errors.Error("no private key")
+	case key.Config == nil:
+		return nil, errors.Error("no ech config")
+	}
+
+	pub, _, err := parseECHConfig(key.Config)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ech config: %w", err)
+	} else if !bytes.Equal(pub, priv.PublicKey().Bytes()) {
+		return nil, errors.Error("private key doesn't match ech config")
+	}
+
+	key.PrivateKey = priv.Bytes()
+
+	return key, nil
+}
+
+// parseECHPrivateKey parses the PKCS #8 DER-encoded X25519 private key.
+func parseECHPrivateKey(der []byte) (priv *ecdh.PrivateKey, err error) {
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	priv, ok := k.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("private key: unsupported type %T", k)
+	}
+
+	return priv, nil
+}
+
+// parseFirstECHConfig returns the first serialized ECHConfig from the
+// serialized ECHConfigList.
+func parseFirstECHConfig(list []byte) (conf []byte, err error) {
+	s := cryptobyte.String(list)
+
+	var configs cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&configs) || !s.Empty() {
+		return nil, errors.Error("bad ech config list")
+	}
+
+	full := configs
+	var version uint16
+	var contents cryptobyte.String
+	if !configs.ReadUint16(&version) || !configs.ReadUint16LengthPrefixed(&contents) {
+		return nil, errors.Error("bad ech config")
+	} else if version != echVersion {
+		return nil, fmt.Errorf("unsupported ech config version %#04x", version)
+	}
+
+	return full[:4+len(contents)], nil
+}
+
+// PublicName returns the public name from the configuration of k.  It's empty
+// if the configuration is malformed.
+func (k *ECHKey) PublicName() (name string) {
+	_, name, _ = parseECHConfig(k.Config)
+
+	return name
+}
+
+// parseECHConfig returns the public key and the public name from the
+// serialized ECHConfig with the X25519 key encapsulation mechanism.
+func parseECHConfig(conf []byte) (pub []byte, publicName string, err error) {
+	s := cryptobyte.String(conf)
+
+	var contents cryptobyte.String
+	if !s.Skip(2) || !s.ReadUint16LengthPrefixed(&contents) {
+		return nil, "", errors.Error("bad ech config")
+	}
+
+	var kem uint16
+	var pubStr, suites, name cryptobyte.String
+	if !contents.Skip(1) ||
+		!contents.ReadUint16(&kem) ||
+		!contents.ReadUint16LengthPrefixed(&pubStr) ||
+		!contents.ReadUint16LengthPrefixed(&suites) ||
+		!contents.Skip(1) ||
+		!contents.ReadUint8LengthPrefixed(&name) {
+		return nil, "", errors.Error("bad ech config contents")
+	} else if kem != hpkeKEMX25519 {
+		return nil, "", fmt.Errorf("unsupported kem %#04x", kem)
+	}
+
+	return pubStr, string(name), nil
+}
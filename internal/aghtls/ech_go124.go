@@ -0,0 +1,24 @@
+//go:build go1.24
+
+package aghtls
+
+import "crypto/tls"
+
+// ECHSupported is true if the TLS servers can accept the Encrypted Client
+// Hello, which requires Go 1.24 or later.
+const ECHSupported = true
+
+// SetECHKeys makes the TLS server with conf accept the Encrypted Client Hello
+// with keys.
+func SetECHKeys(conf *tls.Config, keys []*ECHKey) (err error) {
+	conf.EncryptedClientHelloKeys = nil
+	for _, k := range keys {
+		conf.EncryptedClientHelloKeys = append(conf.EncryptedClientHelloKeys, tls.EncryptedClientHelloKey{
+			Config:      k.Config,
+			PrivateKey:  k.PrivateKey,
+			SendAsRetry: true,
+		})
+	}
+
+	return nil
+}
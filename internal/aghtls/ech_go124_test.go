@@ -0,0 +1,91 @@
+//go:build go1.24
+
+package aghtls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetECHKeys(t *testing.T) {
+	const (
+		publicName = "public.example"
+		innerName  = "dns.example"
+	)
+
+	key, err := aghtls.NewECHKey(1, publicName)
+	require.NoError(t, err)
+
+	list, err := aghtls.ECHConfigList([]*aghtls.ECHKey{key})
+	require.NoError(t, err)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: innerName},
+		DNSNames:     []string{innerName, publicName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	srvConf := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  priv,
+			Leaf:        leaf,
+		}},
+		MinVersion: tls.VersionTLS13,
+	}
+
+	err = aghtls.SetECHKeys(srvConf, []*aghtls.ECHKey{key})
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	cliConf := &tls.Config{
+		ServerName:                     innerName,
+		RootCAs:                        roots,
+		EncryptedClientHelloConfigList: list,
+		MinVersion:                     tls.VersionTLS13,
+	}
+
+	srvConn, cliConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = srvConn.Close()
+		_ = cliConn.Close()
+	})
+
+	srvErrCh := make(chan error, 1)
+	srv := tls.Server(srvConn, srvConf)
+	go func() { srvErrCh <- srv.Handshake() }()
+
+	cli := tls.Client(cliConn, cliConf)
+	require.NoError(t, cli.Handshake())
+	require.NoError(t, <-srvErrCh)
+
+	assert.True(t, cli.ConnectionState().ECHAccepted)
+	assert.Equal(t, innerName, srv.ConnectionState().ServerName)
+}
@@ -0,0 +1,20 @@
+//go:build !go1.24
+
+package aghtls
+
+import "crypto/tls"
+
+// ECHSupported is true if the TLS servers can accept the Encrypted Client
+// Hello, which requires Go 1.24 or later.
+const ECHSupported = false
+
+// SetECHKeys makes the TLS server with conf accept the Encrypted Client Hello
+// with keys.  It returns an error if there are any keys, since it's not
+// supported by this version of Go.
+func SetECHKeys(_ *tls.Config, keys []*ECHKey) (err error) {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return ErrECHUnsupported
+}
@@ -0,0 +1,101 @@
+package aghtls_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewECHKey(t *testing.T) {
+	testCases := []struct {
+		name       string
+		publicName string
+		wantErrMsg string
+	}{{
+		name:       "valid",
+		publicName: "public.example",
+		wantErrMsg: "",
+	}, {
+		name:       "empty",
+		publicName: "",
+		wantErrMsg: "marshaling ech config: bad public name length 0",
+	}, {
+		name:       "too_long",
+		publicName: strings.Repeat("a", 256),
+		wantErrMsg: "marshaling ech config: bad public name length 256",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := aghtls.NewECHKey(1, tc.publicName)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			require.NotNil(t, key)
+
+			assert.Equal(t, tc.publicName, key.PublicName())
+			assert.Len(t, key.PrivateKey, 32)
+		})
+	}
+}
+
+func TestParseECHKey(t *testing.T) {
+	key, err := aghtls.NewECHKey(1, "public.example")
+	require.NoError(t, err)
+
+	data, err := aghtls.MarshalECHKey(key)
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		var parsed *aghtls.ECHKey
+		parsed, err = aghtls.ParseECHKey(data)
+		require.NoError(t, err)
+
+		assert.Equal(t, key, parsed)
+	})
+
+	other, err := aghtls.NewECHKey(1, "public.example")
+	require.NoError(t, err)
+
+	otherData, err := aghtls.MarshalECHKey(other)
+	require.NoError(t, err)
+
+	// Split the PEM blocks to mix the key and the config of different files.
+	i := bytes.Index(data, []byte("-----BEGIN ECHCONFIG"))
+	require.Positive(t, i)
+
+	j := bytes.Index(otherData, []byte("-----BEGIN ECHCONFIG"))
+	require.Positive(t, j)
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		data       []byte
+	}{{
+		name:       "no_private_key",
+		wantErrMsg: "no private key",
+		data:       data[i:],
+	}, {
+		name:       "no_config",
+		wantErrMsg: "no ech config",
+		data:       data[:i],
+	}, {
+		name:       "mismatch",
+		wantErrMsg: "private key doesn't match ech config",
+		data:       append(bytes.Clone(data[:i]), otherData[j:]...),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err = aghtls.ParseECHKey(tc.data)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package aghtls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// ExternalSignerConfig is the configuration of an external signer, which keeps
+// the private key, for example in a PKCS#11 token or a TPM, and performs the
+// signing operations for the TLS handshakes.
+//
+// The signer listens on a Unix socket.  For each signing operation a new
+// connection is opened, the JSON-encoded [SignRequest] is written to it, and
+// the JSON-encoded [SignResponse] is read from it.
+type ExternalSignerConfig struct {
+	// Socket is the path to the Unix socket of the signer.
+	Socket string `yaml:"socket"`
+}
+
+// SignRequest is the request sent to the external signer.
+type SignRequest struct {
+	// PublicKey is the PKIX, ASN.1 DER form of the public key of the
+	// certificate, which identifies the private key to use.
+	PublicKey []byte `json:"public_key"`
+
+	// Digest is the digest to sign or, for Ed25519 keys, the whole message.
+	Digest []byte `json:"digest"`
+
+	// Hash is the name of the hash function used to produce Digest, for
+	// example "SHA-256".  It's empty for Ed25519 keys.
+	Hash string `json:"hash"`
+
+	// PSS is true if the RSA-PSS signature with the salt length equal to the
+	// length of the hash is requested.  Otherwise, the PKCS #1 v1.5 signature
+	// is requested for RSA keys.  It's always false for other keys.
+	PSS bool `json:"pss"`
+}
+
+// SignResponse is the response of the external signer.
+type SignResponse struct {
+	// Error is the error message.  It's empty if the signing has succeeded.
+	Error string `json:"error,omitempty"`
+
+	// Signature is the signature of the digest.
+	Signature []byte `json:"signature"`
+}
+
+// signerTimeout is the timeout of a single signing operation.
+const signerTimeout = 5 * time.Second
+
+// externalSigner is the [crypto.Signer] which delegates the signing operations
+// to the external signer.
+type externalSigner struct {
+	// pub is the public key of the certificate.
+	pub crypto.PublicKey
+
+	// pubDER is the PKIX, ASN.1 DER form of pub.
+	pubDER []byte
+
+	// socket is the path to the Unix socket of the signer.
+	socket string
+}
+
+// type check
+var _ crypto.Signer = (*externalSigner)(nil)
+
+// Public implements the [crypto.Signer] interface for *externalSigner.
+func (s *externalSigner) Public() (pub crypto.PublicKey) {
+	return s.pub
+}
+
+// Sign implements the [crypto.Signer] interface for *externalSigner.
+func (s *externalSigner) Sign(
+	_ io.Reader,
+	digest []byte,
+	opts crypto.SignerOpts,
+) (sig []byte, err error) {
+	req := &SignRequest{
+		PublicKey: s.pubDER,
+		Digest:    digest,
+	}
+
+	if h := opts.HashFunc(); h != 0 {
+		req.Hash = h.String()
+	}
+
+	if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+		if pssOpts.SaltLength != rsa.PSSSaltLengthEqualsHash {
+			return nil, fmt.Errorf("unsupported pss salt length %d", pssOpts.SaltLength)
+		}
+
+		req.PSS = true
+	}
+
+	conn, err := net.DialTimeout("unix", s.socket, signerTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to signer: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	err = conn.SetDeadline(time.Now().Add(signerTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return nil, fmt.Errorf("writing request: %w", err)
+	}
+
+	resp := &SignResponse{}
+	err = json.NewDecoder(conn).Decode(resp)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	} else if resp.Error != "" {
+		return nil, fmt.Errorf("signer: %s", resp.Error)
+	}
+
+	return resp.Signature, nil
+}
+
+// KeyPair returns the certificate from the PEM-encoded certChain.  If signer is
+// nil, keyPEM must contain the PEM-encoded private key, see
+// [tls.X509KeyPair].  Otherwise, keyPEM is ignored and the private key is kept
+// by the external signer.
+func KeyPair(certChain, keyPEM []byte, signer *ExternalSignerConfig) (cert tls.Certificate, err error) {
+	if signer == nil {
+		return tls.X509KeyPair(certChain, keyPEM)
+	}
+
+	for block, rest := pem.Decode(certChain); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, errors.Error("no certificates found")
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(cert.Leaf.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("marshaling public key: %w", err)
+	}
+
+	cert.PrivateKey = &externalSigner{
+		pub:    cert.Leaf.PublicKey,
+		pubDER: pubDER,
+		socket: signer.Socket,
+	}
+
+	return cert, nil
+}
+
+// VerifySigner returns an error if the private key of cert can't sign or its
+// signature doesn't match the public key of the certificate.
+func VerifySigner(cert *tls.Certificate) (err error) {
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unexpected private key type %T", cert.PrivateKey)
+	}
+
+	msg := []byte("adguard home signer check")
+	digest := sha256.Sum256(msg)
+
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+
+		var sig []byte
+		sig, err = signer.Sign(rand.Reader, digest[:], opts)
+		if err == nil {
+			err = rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, opts)
+		}
+	case *ecdsa.PublicKey:
+		var sig []byte
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err == nil && !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			err = errBadSignature
+		}
+	case ed25519.PublicKey:
+		var sig []byte
+		sig, err = signer.Sign(rand.Reader, msg, crypto.Hash(0))
+		if err == nil && !ed25519.Verify(pub, msg, sig) {
+			err = errBadSignature
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return err
+}
+
+// errBadSignature is returned when the signature doesn't match the public key.
+const errBadSignature errors.Error = "signature doesn't match public key"
@@ -0,0 +1,112 @@
+package aghtls_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCert returns the PEM-encoded self-signed certificate for key.
+func newTestCert(t *testing.T, key *ecdsa.PrivateKey) (certPEM []byte) {
+	t.Helper()
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signer.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// startTestSigner starts the external signer signing with key and returns its
+// configuration.
+func startTestSigner(t *testing.T, key *ecdsa.PrivateKey) (conf *aghtls.ExternalSignerConfig) {
+	t.Helper()
+
+	sock := filepath.Join(t.TempDir(), "signer.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, l.Close)
+
+	go func() {
+		for {
+			conn, aErr := l.Accept()
+			if aErr != nil {
+				return
+			}
+
+			req := &aghtls.SignRequest{}
+			resp := &aghtls.SignResponse{}
+			aErr = json.NewDecoder(conn).Decode(req)
+			if aErr == nil {
+				resp.Signature, aErr = key.Sign(rand.Reader, req.Digest, crypto.SHA256)
+			}
+
+			if aErr != nil {
+				resp.Error = aErr.Error()
+			}
+
+			_ = json.NewEncoder(conn).Encode(resp)
+			_ = conn.Close()
+		}
+	}()
+
+	return &aghtls.ExternalSignerConfig{
+		Socket: sock,
+	}
+}
+
+func TestKeyPair_externalSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	certPEM := newTestCert(t, key)
+
+	t.Run("valid", func(t *testing.T) {
+		cert, kpErr := aghtls.KeyPair(certPEM, nil, startTestSigner(t, key))
+		require.NoError(t, kpErr)
+		require.NotNil(t, cert.Leaf)
+
+		assert.Equal(t, "signer.example", cert.Leaf.Subject.CommonName)
+		assert.NoError(t, aghtls.VerifySigner(&cert))
+	})
+
+	t.Run("other_key", func(t *testing.T) {
+		cert, kpErr := aghtls.KeyPair(certPEM, nil, startTestSigner(t, otherKey))
+		require.NoError(t, kpErr)
+
+		testutil.AssertErrorMsg(
+			t,
+			"signature doesn't match public key",
+			aghtls.VerifySigner(&cert),
+		)
+	})
+
+	t.Run("no_certificates", func(t *testing.T) {
+		_, kpErr := aghtls.KeyPair(nil, nil, startTestSigner(t, key))
+		testutil.AssertErrorMsg(t, "no certificates found", kpErr)
+	})
+}
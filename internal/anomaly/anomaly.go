@@ -0,0 +1,261 @@
+// Package anomaly contains the detector of the anomalies in the DNS query
+// patterns, such as the storms of NXDOMAIN responses, the floods of random
+// subdomains, and the sudden jumps of the query rates of the clients.
+package anomaly
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Type is the type of an anomaly.
+type Type string
+
+// Anomaly types.
+const (
+	// TypeNXDomainStorm means that the share of the NXDOMAIN responses among
+	// all the responses is too high.
+	TypeNXDomainStorm Type = "nxdomain_storm"
+
+	// TypeRandomSubdomains means that too many distinct random-looking
+	// subdomains of a single domain have been requested, which is typical for
+	// the domain generation algorithms and the random subdomain attacks.
+	TypeRandomSubdomains Type = "random_subdomains"
+
+	// TypeClientRate means that the query rate of a client has jumped
+	// compared to its usual rate.
+	TypeClientRate Type = "client_rate"
+)
+
+// Alert is a single detected anomaly.
+type Alert struct {
+	// Time is the end of the window, within which the anomaly has been
+	// detected.
+	Time time.Time `json:"time"`
+
+	// Type is the type of the anomaly.
+	Type Type `json:"type"`
+
+	// Client is the IP address or the ClientID of the client, if the anomaly
+	// is related to a single client.
+	Client string `json:"client,omitempty"`
+
+	// Domain is the domain name, if the anomaly is related to a single
+	// domain.
+	Domain string `json:"domain,omitempty"`
+
+	// Message is the human-readable description of the anomaly.
+	Message string `json:"message"`
+
+	// Value is the observed value, which has exceeded the threshold.
+	Value float64 `json:"value"`
+
+	// Threshold is the threshold of the value.
+	Threshold float64 `json:"threshold"`
+}
+
+// Config is the configuration of the anomaly detector.
+type Config struct {
+	// OnAlert, if not nil, is called with each detected anomaly.  It must not
+	// block and must not modify the alert.
+	OnAlert func(a *Alert)
+
+	// HTTPRegister, if not nil, is used to register the HTTP API of the
+	// detector.
+	HTTPRegister aghhttp.RegisterFunc
+
+	// Window is the duration of the window, within which the queries are
+	// counted.  It must be positive.
+	Window time.Duration
+
+	// Cooldown is the minimum duration between two alerts of the same type
+	// about the same client or domain.
+	Cooldown time.Duration
+
+	// MinQueries is the minimum number of queries within a window, for which
+	// the NXDOMAIN share and the query rate of a client are checked.
+	MinQueries uint64
+
+	// RandomSubdomains is the minimum number of distinct random-looking
+	// subdomains of a single domain within a window considered an anomaly.
+	// It must be positive.
+	RandomSubdomains uint64
+
+	// NXDomainRatio is the share of the NXDOMAIN responses among all the
+	// responses within a window considered an anomaly, from 0 to 1.
+	NXDomainRatio float64
+
+	// MinEntropy is the minimum average Shannon entropy, in bits per
+	// character, of the subdomain labels considered random.
+	MinEntropy float64
+
+	// RateFactor is the minimum ratio of the number of the client's queries
+	// within a window to its usual number considered an anomaly.  It must be
+	// greater than 1.
+	RateFactor float64
+
+	// MaxAlerts is the maximum number of the kept recent alerts.  It must be
+	// positive.
+	MaxAlerts int
+}
+
+// Validate returns an error if c is not valid.
+func (c *Config) Validate() (err error) {
+	switch {
+	case c.Window <= 0:
+		return fmt.Errorf("window: must be positive, got %s", c.Window)
+	case c.Cooldown < 0:
+		return fmt.Errorf("cooldown: must not be negative, got %s", c.Cooldown)
+	case c.RandomSubdomains == 0:
+		return errors.Error("random_subdomains: must be positive")
+	case c.NXDomainRatio <= 0 || c.NXDomainRatio > 1:
+		return fmt.Errorf("nxdomain_ratio: must be in (0, 1], got %v", c.NXDomainRatio)
+	case c.MinEntropy < 0:
+		return fmt.Errorf("min_entropy: must not be negative, got %v", c.MinEntropy)
+	case c.RateFactor <= 1:
+		return fmt.Errorf("rate_factor: must be greater than 1, got %v", c.RateFactor)
+	case c.MaxAlerts <= 0:
+		return fmt.Errorf("max_alerts: must be positive, got %d", c.MaxAlerts)
+	default:
+		return nil
+	}
+}
+
+// alertKey is the key of the last alert time of a kind.
+type alertKey struct {
+	typ    Type
+	client string
+	domain string
+}
+
+// Detector detects the anomalies in the stream of the statistics entries.
+type Detector struct {
+	// now returns the current time.
+	now func() (now time.Time)
+
+	// onAlert is called with each detected anomaly, if not nil.
+	onAlert func(a *Alert)
+
+	// mu protects all the fields below.
+	mu *sync.Mutex
+
+	// alerts are the recent alerts.
+	alerts *aghalg.RingBuffer[*Alert]
+
+	// lastAlerts are the times of the last alerts of each kind.
+	lastAlerts map[alertKey]time.Time
+
+	// baselines are the exponentially weighted moving averages of the numbers
+	// of the queries of each client within a window.
+	baselines map[string]float64
+
+	// win is the current window.
+	win *window
+
+	// conf is the configuration of the detector.
+	conf *Config
+}
+
+// New returns a new properly initialized *Detector.  conf must not be nil and
+// must be valid.
+func New(conf *Config) (d *Detector) {
+	d = &Detector{
+		now:        time.Now,
+		onAlert:    conf.OnAlert,
+		mu:         &sync.Mutex{},
+		alerts:     aghalg.NewRingBuffer[*Alert](conf.MaxAlerts),
+		lastAlerts: map[alertKey]time.Time{},
+		baselines:  map[string]float64{},
+		conf:       conf,
+	}
+
+	if conf.HTTPRegister != nil {
+		d.initWeb(conf.HTTPRegister)
+	}
+
+	return d
+}
+
+// Observe accounts e in the current window and reports the anomalies of the
+// previous one, if it's over.  e must not be nil.
+func (d *Detector) Observe(e *stats.Entry) {
+	alerts := d.observe(e, d.now())
+	if d.onAlert == nil {
+		return
+	}
+
+	for _, a := range alerts {
+		d.onAlert(a)
+	}
+}
+
+// observe accounts e observed at now and returns the new alerts.
+func (d *Detector) observe(e *stats.Entry, now time.Time) (alerts []*Alert) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.win == nil {
+		d.win = newWindow(now)
+	} else if end := d.win.start.Add(d.conf.Window); !now.Before(end) {
+		alerts = d.finishWindow(end)
+
+		// Account the windows without any queries.
+		missed := int(now.Sub(end) / d.conf.Window)
+		d.decayBaselines(missed)
+		d.win = newWindow(end.Add(time.Duration(missed) * d.conf.Window))
+	}
+
+	d.win.add(e, d.conf.RandomSubdomains)
+
+	return alerts
+}
+
+// Alerts returns the recent alerts, the most recent first.
+func (d *Detector) Alerts() (alerts []*Alert) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	alerts = make([]*Alert, 0, d.alerts.Len())
+	d.alerts.ReverseRange(func(a *Alert) (cont bool) {
+		alerts = append(alerts, a)
+
+		return true
+	})
+
+	return alerts
+}
+
+// finishWindow checks the current window ended at end for anomalies, updates
+// the baselines, and returns the new alerts.  d.mu is expected to be locked.
+func (d *Detector) finishWindow(end time.Time) (alerts []*Alert) {
+	for _, a := range d.win.check(d.conf, d.baselines) {
+		key := alertKey{typ: a.Type, client: a.Client, domain: a.Domain}
+		if last, ok := d.lastAlerts[key]; ok && end.Sub(last) < d.conf.Cooldown {
+			continue
+		}
+
+		d.lastAlerts[key] = end
+		a.Time = end
+		d.alerts.Append(a)
+		alerts = append(alerts, a)
+
+		log.Info("anomaly: %s", a.Message)
+	}
+
+	for key, last := range d.lastAlerts {
+		if end.Sub(last) >= d.conf.Cooldown {
+			delete(d.lastAlerts, key)
+		}
+	}
+
+	d.updateBaselines()
+
+	return alerts
+}
@@ -0,0 +1,198 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testWindow is the window of the detector for tests.
+const testWindow = time.Minute
+
+// newTestDetector returns a new *Detector for tests and a function returning
+// the alerts reported since its previous call.
+func newTestDetector(t *testing.T) (d *Detector, got func() (alerts []*Alert)) {
+	t.Helper()
+
+	var alerts []*Alert
+	conf := &Config{
+		OnAlert:          func(a *Alert) { alerts = append(alerts, a) },
+		Window:           testWindow,
+		Cooldown:         10 * testWindow,
+		MinQueries:       10,
+		RandomSubdomains: 20,
+		NXDomainRatio:    0.5,
+		MinEntropy:       3,
+		RateFactor:       10,
+		MaxAlerts:        10,
+	}
+	require.NoError(t, conf.Validate())
+
+	d = New(conf)
+
+	return d, func() (res []*Alert) {
+		res, alerts = alerts, nil
+
+		return res
+	}
+}
+
+func TestDetector_Observe_nxDomain(t *testing.T) {
+	d, got := newTestDetector(t)
+
+	start := time.Unix(0, 0)
+	d.now = func() (now time.Time) { return start }
+
+	for i := 0; i < 10; i++ {
+		rcode := "NOERROR"
+		if i%2 == 0 {
+			rcode = "NXDOMAIN"
+		}
+
+		d.Observe(&stats.Entry{Client: "1.2.3.4", Domain: "example.org", RCode: rcode})
+	}
+
+	assert.Empty(t, got())
+
+	d.now = func() (now time.Time) { return start.Add(testWindow) }
+	d.Observe(&stats.Entry{Client: "1.2.3.4", Domain: "example.org", RCode: "NXDOMAIN"})
+
+	alerts := got()
+	require.Len(t, alerts, 1)
+
+	assert.Equal(t, &Alert{
+		Time:      start.Add(testWindow),
+		Type:      TypeNXDomainStorm,
+		Message:   "5 of 10 responses are NXDOMAIN",
+		Value:     0.5,
+		Threshold: 0.5,
+	}, alerts[0])
+
+	t.Run("cooldown", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			d.Observe(&stats.Entry{Client: "1.2.3.4", Domain: "example.org", RCode: "NXDOMAIN"})
+		}
+
+		d.now = func() (now time.Time) { return start.Add(2 * testWindow) }
+		d.Observe(&stats.Entry{Client: "1.2.3.4", Domain: "example.org"})
+
+		assert.Empty(t, got())
+		assert.Len(t, d.Alerts(), 1)
+	})
+}
+
+func TestDetector_Observe_randomSubdomains(t *testing.T) {
+	d, got := newTestDetector(t)
+
+	start := time.Unix(0, 0)
+	d.now = func() (now time.Time) { return start }
+
+	for i := 0; i < 30; i++ {
+		// Plain subdomains have low entropy.
+		d.Observe(&stats.Entry{
+			Client: "1.2.3.4",
+			Domain: fmt.Sprintf("www%d.example.org", i),
+		})
+
+		d.Observe(&stats.Entry{
+			Client: "1.2.3.4",
+			Domain: fmt.Sprintf("x%dqz7w%dkv4p%d.example.com", i, i*7, i*13),
+		})
+	}
+
+	d.now = func() (now time.Time) { return start.Add(testWindow) }
+	d.Observe(&stats.Entry{Client: "1.2.3.4", Domain: "example.com"})
+
+	alerts := got()
+	require.Len(t, alerts, 1)
+
+	assert.Equal(t, TypeRandomSubdomains, alerts[0].Type)
+	assert.Equal(t, "example.com", alerts[0].Domain)
+	assert.Equal(t, float64(20), alerts[0].Value)
+}
+
+func TestDetector_Observe_clientRate(t *testing.T) {
+	d, got := newTestDetector(t)
+
+	start := time.Unix(0, 0)
+	observeWindow := func(n int, client string) {
+		for i := 0; i < n; i++ {
+			d.Observe(&stats.Entry{Client: client, Domain: "example.org"})
+		}
+
+		start = start.Add(testWindow)
+		d.now = func() (now time.Time) { return start }
+	}
+
+	d.now = func() (now time.Time) { return start }
+
+	observeWindow(2, "1.2.3.4")
+	observeWindow(2, "1.2.3.4")
+	observeWindow(50, "5.6.7.8")
+	observeWindow(30, "1.2.3.4")
+
+	// The new client has no baseline yet.
+	assert.Empty(t, got())
+
+	// Finish the previous window.
+	observeWindow(1, "9.9.9.9")
+
+	alerts := got()
+	require.Len(t, alerts, 1)
+
+	assert.Equal(t, TypeClientRate, alerts[0].Type)
+	assert.Equal(t, "1.2.3.4", alerts[0].Client)
+
+	t.Run("decay", func(t *testing.T) {
+		// After a long pause the baseline is forgotten.
+		start = start.Add(100 * testWindow)
+		d.now = func() (now time.Time) { return start }
+
+		observeWindow(30, "1.2.3.4")
+		observeWindow(1, "9.9.9.9")
+
+		assert.Empty(t, got())
+	})
+}
+
+func TestDetector_handleAlerts(t *testing.T) {
+	d, _ := newTestDetector(t)
+
+	d.alerts.Append(&Alert{Type: TypeNXDomainStorm})
+	d.alerts.Append(&Alert{Type: TypeClientRate})
+
+	w := httptest.NewRecorder()
+	d.handleAlerts(w, httptest.NewRequest(http.MethodGet, "/control/alerts", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &alertsResp{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+	require.Len(t, resp.Alerts, 2)
+
+	assert.Equal(t, TypeClientRate, resp.Alerts[0].Type)
+	assert.Equal(t, TypeNXDomainStorm, resp.Alerts[1].Type)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	conf := &Config{
+		Window:           time.Minute,
+		RandomSubdomains: 1,
+		NXDomainRatio:    1.5,
+		RateFactor:       2,
+		MaxAlerts:        1,
+	}
+
+	assert.EqualError(t, conf.Validate(), "nxdomain_ratio: must be in (0, 1], got 1.5")
+}
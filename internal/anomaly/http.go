@@ -0,0 +1,23 @@
+package anomaly
+
+import (
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// alertsResp is the response to the GET /control/alerts HTTP API.
+type alertsResp struct {
+	// Alerts are the recent alerts, the most recent first.
+	Alerts []*Alert `json:"alerts"`
+}
+
+// initWeb registers the HTTP API of the detector using reg.
+func (d *Detector) initWeb(reg aghhttp.RegisterFunc) {
+	reg(http.MethodGet, "/control/alerts", d.handleAlerts)
+}
+
+// handleAlerts is the handler for the GET /control/alerts HTTP API.
+func (d *Detector) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &alertsResp{Alerts: d.Alerts()})
+}
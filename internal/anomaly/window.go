@@ -0,0 +1,234 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Limits of the numbers of the tracked items within a window, which keep the
+// memory usage bounded during the floods.
+const (
+	// maxClients is the maximum number of the tracked clients.
+	maxClients = 10_000
+
+	// maxDomains is the maximum number of the tracked registrable domains.
+	maxDomains = 10_000
+)
+
+// baselineWeight is the weight of the number of the queries within the last
+// window in the exponentially weighted moving average of a client's queries.
+const baselineWeight = 0.2
+
+// minBaseline is the baseline, below which the client is forgotten.
+const minBaseline = 0.01
+
+// subdomains are the distinct subdomains of a single registrable domain within
+// a window.
+type subdomains struct {
+	// labels is the set of the distinct subdomain labels.
+	labels map[string]struct{}
+
+	// entropySum is the sum of the Shannon entropies of labels.
+	entropySum float64
+}
+
+// window contains the counters of the queries within a single window.
+type window struct {
+	// start is the beginning of the window.
+	start time.Time
+
+	// clients are the numbers of the queries by the client.
+	clients map[string]uint64
+
+	// domains are the distinct subdomains by the registrable domain.
+	domains map[string]*subdomains
+
+	// total is the number of the responses with a known response code.
+	total uint64
+
+	// nxDomain is the number of the NXDOMAIN responses.
+	nxDomain uint64
+}
+
+// newWindow returns a new properly initialized *window starting at start.
+func newWindow(start time.Time) (w *window) {
+	return &window{
+		start:   start,
+		clients: map[string]uint64{},
+		domains: map[string]*subdomains{},
+	}
+}
+
+// add accounts e in w.  maxLabels is the number of the distinct subdomain
+// labels of a domain, after which the new ones aren't tracked.
+func (w *window) add(e *stats.Entry, maxLabels uint64) {
+	if e.RCode != "" {
+		w.total++
+		if e.RCode == dns.RcodeToString[dns.RcodeNameError] {
+			w.nxDomain++
+		}
+	}
+
+	if _, ok := w.clients[e.Client]; ok || len(w.clients) < maxClients {
+		w.clients[e.Client]++
+	}
+
+	w.addDomain(strings.ToLower(strings.TrimSuffix(e.Domain, ".")), maxLabels)
+}
+
+// addDomain accounts the subdomain label of host in w.
+func (w *window) addDomain(host string, maxLabels uint64) {
+	base, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil || base == host {
+		return
+	}
+
+	subs, ok := w.domains[base]
+	if !ok {
+		if len(w.domains) >= maxDomains {
+			return
+		}
+
+		subs = &subdomains{labels: map[string]struct{}{}}
+		w.domains[base] = subs
+	}
+
+	if uint64(len(subs.labels)) >= maxLabels {
+		return
+	}
+
+	label := strings.ReplaceAll(host[:len(host)-len(base)-1], ".", "")
+	if _, ok = subs.labels[label]; !ok {
+		subs.labels[label] = struct{}{}
+		subs.entropySum += entropy(label)
+	}
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) (e float64) {
+	if s == "" {
+		return 0
+	}
+
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
+
+// check returns the anomalies within w.  baselines are the usual numbers of
+// the queries of the clients.  The returned alerts have no time set.
+func (w *window) check(conf *Config, baselines map[string]float64) (alerts []*Alert) {
+	if w.total >= conf.MinQueries && w.total > 0 {
+		ratio := float64(w.nxDomain) / float64(w.total)
+		if ratio >= conf.NXDomainRatio {
+			alerts = append(alerts, &Alert{
+				Type: TypeNXDomainStorm,
+				Message: fmt.Sprintf(
+					"%d of %d responses are NXDOMAIN",
+					w.nxDomain,
+					w.total,
+				),
+				Value:     ratio,
+				Threshold: conf.NXDomainRatio,
+			})
+		}
+	}
+
+	for base, subs := range w.domains {
+		n := uint64(len(subs.labels))
+		if n < conf.RandomSubdomains {
+			continue
+		}
+
+		avg := subs.entropySum / float64(n)
+		if avg < conf.MinEntropy {
+			continue
+		}
+
+		alerts = append(alerts, &Alert{
+			Type:   TypeRandomSubdomains,
+			Domain: base,
+			Message: fmt.Sprintf(
+				"at least %d random-looking subdomains of %s requested",
+				n,
+				base,
+			),
+			Value:     float64(n),
+			Threshold: float64(conf.RandomSubdomains),
+		})
+	}
+
+	for client, n := range w.clients {
+		b, ok := baselines[client]
+		if !ok || n < conf.MinQueries || float64(n) < conf.RateFactor*b {
+			continue
+		}
+
+		alerts = append(alerts, &Alert{
+			Type:   TypeClientRate,
+			Client: client,
+			Message: fmt.Sprintf(
+				"client %s made %d queries, usually %.1f",
+				client,
+				n,
+				b,
+			),
+			Value:     float64(n) / b,
+			Threshold: conf.RateFactor,
+		})
+	}
+
+	return alerts
+}
+
+// updateBaselines updates the baselines of the clients with the numbers of
+// their queries within the current window.  d.mu is expected to be locked.
+func (d *Detector) updateBaselines() {
+	for client, b := range d.baselines {
+		b = b*(1-baselineWeight) + float64(d.win.clients[client])*baselineWeight
+		if b < minBaseline {
+			delete(d.baselines, client)
+		} else {
+			d.baselines[client] = b
+		}
+	}
+
+	for client, n := range d.win.clients {
+		if _, ok := d.baselines[client]; !ok && len(d.baselines) < maxClients {
+			d.baselines[client] = float64(n)
+		}
+	}
+}
+
+// decayBaselines updates the baselines as if there were n windows without
+// queries.  d.mu is expected to be locked.
+func (d *Detector) decayBaselines(n int) {
+	if n <= 0 {
+		return
+	}
+
+	f := math.Pow(1-baselineWeight, float64(n))
+	for client, b := range d.baselines {
+		if b *= f; b < minBaseline {
+			delete(d.baselines, client)
+		} else {
+			d.baselines[client] = b
+		}
+	}
+}
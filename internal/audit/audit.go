@@ -0,0 +1,347 @@
+// Package audit contains the audit log of the administrative actions, which is
+// kept in a local file and shipped to a remote syslog server or an HTTP
+// collector.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+)
+
+// Action is the type of an audited administrative action.
+type Action string
+
+// Action values.
+const (
+	// ActionLogin means a successful login into the web interface.
+	ActionLogin Action = "login"
+
+	// ActionLoginFailed means a failed attempt to log into the web interface.
+	ActionLoginFailed Action = "login_failed"
+
+	// ActionLoginBlocked means that the address has been temporarily blocked
+	// from logging in after too many failed attempts.
+	ActionLoginBlocked Action = "login_blocked"
+
+	// ActionLogout means a logout from the web interface.
+	ActionLogout Action = "logout"
+
+	// ActionConfigChange means a request changing the configuration.
+	ActionConfigChange Action = "config_change"
+
+	// ActionRuleChange means a request changing the filtering rules, the
+	// filter lists, the rewrites, or the blocked services.
+	ActionRuleChange Action = "rule_change"
+)
+
+// Event is a single audited administrative action.
+type Event struct {
+	// Time is the time of the action.
+	Time time.Time `json:"time"`
+
+	// Action is the type of the action.
+	Action Action `json:"action"`
+
+	// User is the name of the user, who has performed the action, if known.
+	User string `json:"user,omitempty"`
+
+	// RemoteIP is the IP address of the user, who has performed the action.
+	RemoteIP string `json:"remote_ip,omitempty"`
+
+	// Method is the HTTP method of the request, if any.
+	Method string `json:"method,omitempty"`
+
+	// Path is the path of the HTTP request, if any.
+	Path string `json:"path,omitempty"`
+
+	// Status is the HTTP status code of the response, if any.
+	Status int `json:"status,omitempty"`
+}
+
+// Interface is the audit log.
+type Interface interface {
+	// Record records e.  It must not block.  e must not be nil and must not
+	// be modified after the call.
+	Record(e *Event)
+}
+
+// Empty is an [Interface] implementation that does nothing.
+type Empty struct{}
+
+// type check
+var _ Interface = Empty{}
+
+// Record implements the [Interface] interface for Empty.
+func (Empty) Record(_ *Event) {}
+
+// Config is the configuration of the audit log.
+type Config struct {
+	// HTTPClient is the client used to send the events to the HTTP collector.
+	// If nil, [http.DefaultClient] is used.
+	HTTPClient *http.Client
+
+	// URL is the address of the remote collector.  For a remote syslog
+	// server, the scheme must be either "udp" or "tcp", for example
+	// "udp://192.0.2.1:514".  For an HTTP collector, which receives each event
+	// as a JSON object in the body of a POST request, the scheme must be
+	// either "http" or "https".  It must not be nil.
+	URL *url.URL
+
+	// Hostname is the name of this host used in the syslog messages.
+	Hostname string
+
+	// SpoolFile is the path to the file, in which the undelivered events are
+	// kept between the restarts.  If empty, the undelivered events are lost
+	// on shutdown.
+	SpoolFile string
+
+	// BufferSize is the maximum number of the undelivered events.  When it's
+	// exceeded, the oldest events are dropped.  It must be positive.
+	BufferSize int
+}
+
+// Retry intervals for the failed deliveries.
+const (
+	minRetryIvl = 1 * time.Second
+	maxRetryIvl = 1 * time.Minute
+)
+
+// Logger is an [Interface] implementation, which delivers the events to the
+// remote collector in the order they were recorded.  Each event is retried
+// until delivered, and the undelivered events are saved to the spool file on
+// shutdown.
+type Logger struct {
+	// sender sends the events to the remote collector.
+	sender sender
+
+	// mu protects queue.
+	mu *sync.Mutex
+
+	// queue are the undelivered events, oldest first.
+	queue []*Event
+
+	// notify receives a value each time an event is recorded.
+	notify chan struct{}
+
+	// done is closed when the logger is closing.
+	done chan struct{}
+
+	// stopped is closed when the delivery goroutine exits.
+	stopped chan struct{}
+
+	// spoolFile is the path to the spool file, if any.
+	spoolFile string
+
+	// bufferSize is the maximum length of queue.
+	bufferSize int
+}
+
+// type check
+var _ Interface = (*Logger)(nil)
+
+// New returns a new properly initialized *Logger.  It loads the events left
+// undelivered by the previous run from the spool file, if any.  conf must not
+// be nil.
+func New(conf *Config) (l *Logger, err error) {
+	if conf.BufferSize <= 0 {
+		return nil, fmt.Errorf("buffer size: must be positive, got %d", conf.BufferSize)
+	}
+
+	s, err := newSender(conf)
+	if err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	}
+
+	l = &Logger{
+		sender:     s,
+		mu:         &sync.Mutex{},
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		spoolFile:  conf.SpoolFile,
+		bufferSize: conf.BufferSize,
+	}
+
+	err = l.loadSpool()
+	if err != nil {
+		return nil, fmt.Errorf("loading spool: %w", err)
+	}
+
+	return l, nil
+}
+
+// Start starts delivering the events.  It must only be called once.
+func (l *Logger) Start() {
+	go l.deliver()
+}
+
+// Record implements the [Interface] interface for *Logger.
+func (l *Logger) Record(e *Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pushLocked(e)
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+		// There is already a pending notification.
+	}
+}
+
+// pushLocked appends e to the queue dropping the oldest events, if necessary.
+// l.mu must be locked.
+func (l *Logger) pushLocked(e *Event) {
+	l.queue = append(l.queue, e)
+	if over := len(l.queue) - l.bufferSize; over > 0 {
+		log.Error("audit: buffer is full, dropping %d oldest events", over)
+
+		l.queue = l.queue[over:]
+	}
+}
+
+// front returns the oldest undelivered event, if any.
+func (l *Logger) front() (e *Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.queue) == 0 {
+		return nil
+	}
+
+	return l.queue[0]
+}
+
+// remove removes e from the queue, if it's still there.
+func (l *Logger) remove(e *Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.queue) > 0 && l.queue[0] == e {
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+	}
+}
+
+// deliver sends the queued events until l is closed.  It is intended to be
+// used as a goroutine.
+func (l *Logger) deliver() {
+	defer log.OnPanic("audit: delivering")
+	defer close(l.stopped)
+
+	retryIvl := minRetryIvl
+	for {
+		e := l.front()
+		if e == nil {
+			select {
+			case <-l.notify:
+				continue
+			case <-l.done:
+				return
+			}
+		}
+
+		err := l.sender.send(e)
+		if err == nil {
+			l.remove(e)
+			retryIvl = minRetryIvl
+
+			continue
+		}
+
+		log.Debug("audit: sending event, retrying in %s: %s", retryIvl, err)
+
+		select {
+		case <-time.After(retryIvl):
+			retryIvl = mathutil.Min(retryIvl*2, maxRetryIvl)
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Close stops delivering the events and saves the undelivered ones to the
+// spool file.  It must only be called once and only after [Logger.Start].
+func (l *Logger) Close() (err error) {
+	close(l.done)
+
+	// Wait for the delivery goroutine to finish sending the current event, if
+	// any.
+	<-l.stopped
+
+	err = l.storeSpool()
+
+	return errors.WithDeferred(err, l.sender.close())
+}
+
+// loadSpool loads the undelivered events from the spool file and removes it.
+func (l *Logger) loadSpool() (err error) {
+	if l.spoolFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(l.spoolFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		e := &Event{}
+		err = json.Unmarshal(s.Bytes(), e)
+		if err != nil {
+			log.Error("audit: decoding spooled event: %s", err)
+
+			continue
+		}
+
+		l.pushLocked(e)
+	}
+
+	log.Debug("audit: loaded %d spooled events", len(l.queue))
+
+	return os.Remove(l.spoolFile)
+}
+
+// storeSpool saves the undelivered events to the spool file.
+func (l *Logger) storeSpool() (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.spoolFile == "" || len(l.queue) == 0 {
+		return nil
+	}
+
+	f, err := aghrenameio.NewPendingFile(l.spoolFile, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating spool: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	enc := json.NewEncoder(f)
+	for _, e := range l.queue {
+		err = enc.Encode(e)
+		if err != nil {
+			return fmt.Errorf("encoding event: %w", err)
+		}
+	}
+
+	log.Info("audit: saved %d undelivered events", len(l.queue))
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/audit"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 5 * time.Second
+
+// newTestEvent returns a new event with the given path.
+func newTestEvent(path string) (e *audit.Event) {
+	return &audit.Event{
+		Time:     time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Action:   audit.ActionConfigChange,
+		User:     "admin",
+		RemoteIP: "192.0.2.1",
+		Method:   http.MethodPost,
+		Path:     path,
+		Status:   http.StatusOK,
+	}
+}
+
+// newTestLogger returns a new started *audit.Logger and closes it on the test
+// cleanup.
+func newTestLogger(t *testing.T, conf *audit.Config) (l *audit.Logger) {
+	t.Helper()
+
+	l, err := audit.New(conf)
+	require.NoError(t, err)
+
+	l.Start()
+	testutil.CleanupAndRequireSuccess(t, l.Close)
+
+	return l
+}
+
+func TestLogger_syslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	l := newTestLogger(t, &audit.Config{
+		URL:        &url.URL{Scheme: "udp", Host: conn.LocalAddr().String()},
+		Hostname:   "testhost",
+		BufferSize: 10,
+	})
+
+	l.Record(newTestEvent("/control/dns_config"))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	msg := string(buf[:n])
+	const wantPrefix = "<85>1 2023-01-01T00:00:00.000000Z testhost AdGuardHome - " +
+		"config_change - "
+	require.True(t, strings.HasPrefix(msg, wantPrefix), msg)
+
+	got := &audit.Event{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(msg, wantPrefix)), got))
+
+	assert.Equal(t, newTestEvent("/control/dns_config"), got)
+}
+
+func TestLogger_http(t *testing.T) {
+	var reqNum atomic.Int32
+	events := make(chan *audit.Event, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first request to make the logger retry.
+		if reqNum.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		e := &audit.Event{}
+		err := json.NewDecoder(r.Body).Decode(e)
+		if err != nil {
+			log.Error("decoding: %s", err)
+		}
+
+		events <- e
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	l := newTestLogger(t, &audit.Config{
+		URL:        u,
+		BufferSize: 10,
+	})
+
+	l.Record(newTestEvent("/control/first"))
+	l.Record(newTestEvent("/control/second"))
+
+	for _, want := range []string{"/control/first", "/control/second"} {
+		select {
+		case e := <-events:
+			assert.Equal(t, want, e.Path)
+		case <-time.After(testTimeout):
+			t.Fatalf("event %q not received", want)
+		}
+	}
+}
+
+func TestLogger_spool(t *testing.T) {
+	// Get an address that nobody listens to.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	spoolFile := filepath.Join(t.TempDir(), "audit.spool")
+	conf := &audit.Config{
+		URL:        &url.URL{Scheme: "http", Host: addr},
+		SpoolFile:  spoolFile,
+		BufferSize: 2,
+	}
+
+	l, err := audit.New(conf)
+	require.NoError(t, err)
+
+	l.Start()
+
+	// The first event is dropped, since the buffer only holds two.
+	l.Record(newTestEvent("/control/first"))
+	l.Record(newTestEvent("/control/second"))
+	l.Record(newTestEvent("/control/third"))
+
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(spoolFile)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	for i, want := range []string{"/control/second", "/control/third"} {
+		got := &audit.Event{}
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), got))
+
+		assert.Equal(t, newTestEvent(want), got)
+	}
+
+	events := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		e := &audit.Event{}
+		dErr := json.NewDecoder(r.Body).Decode(e)
+		if dErr != nil {
+			log.Error("decoding: %s", dErr)
+		}
+
+		events <- e.Path
+	}))
+	t.Cleanup(srv.Close)
+
+	conf.URL, err = url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	newTestLogger(t, conf)
+
+	assert.NoFileExists(t, spoolFile)
+
+	for _, want := range []string{"/control/second", "/control/third"} {
+		select {
+		case got := <-events:
+			assert.Equal(t, want, got)
+		case <-time.After(testTimeout):
+			t.Fatalf("event %q not received", want)
+		}
+	}
+}
+
+func TestNew_errors(t *testing.T) {
+	testCases := []struct {
+		conf       *audit.Config
+		name       string
+		wantErrMsg string
+	}{{
+		conf: &audit.Config{
+			URL:        &url.URL{Scheme: "ftp", Host: "192.0.2.1"},
+			BufferSize: 1,
+		},
+		name:       "bad_scheme",
+		wantErrMsg: `url: bad scheme "ftp"`,
+	}, {
+		conf: &audit.Config{
+			BufferSize: 1,
+		},
+		name:       "no_url",
+		wantErrMsg: "url: no value",
+	}, {
+		conf: &audit.Config{
+			URL:        &url.URL{Scheme: "udp", Host: "192.0.2.1:514"},
+			BufferSize: 0,
+		},
+		name:       "bad_buffer_size",
+		wantErrMsg: "buffer size: must be positive, got 0",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := audit.New(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
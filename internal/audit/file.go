@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// File is an [Interface] implementation, which keeps the events in a local
+// file to review them later.  When the file exceeds the maximum size, it's
+// rotated, so that the events are kept in two files at most.  The events are
+// written synchronously, but they are small and rare enough for that.
+type File struct {
+	// mu protects the files.
+	mu *sync.Mutex
+
+	// path is the path to the current file.
+	path string
+
+	// maxSize is the size of the current file, after exceeding which it's
+	// rotated.
+	maxSize int64
+}
+
+// type check
+var _ Interface = (*File)(nil)
+
+// NewFile returns a new properly initialized *File keeping the events in the
+// file with path.  maxSize must be positive.
+func NewFile(path string, maxSize int64) (f *File) {
+	return &File{
+		mu:      &sync.Mutex{},
+		path:    path,
+		maxSize: maxSize,
+	}
+}
+
+// rotatedPath returns the path to the rotated file.
+func (f *File) rotatedPath() (p string) {
+	return f.path + ".1"
+}
+
+// Record implements the [Interface] interface for *File.  The errors are
+// logged.
+func (f *File) Record(e *Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.write(e)
+	if err != nil {
+		log.Error("audit: writing event to file: %s", err)
+	}
+}
+
+// write appends e to the current file and rotates it, if necessary.  f.mu must
+// be locked.
+func (f *File) write(e *Event) (err error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return errors.WithDeferred(err, file.Close())
+	}
+
+	fi, err := file.Stat()
+	err = errors.WithDeferred(err, file.Close())
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if fi.Size() < f.maxSize {
+		return nil
+	}
+
+	log.Debug("audit: rotating %q", f.path)
+
+	return os.Rename(f.path, f.rotatedPath())
+}
+
+// SearchParams are the parameters of searching for the recorded events.
+type SearchParams struct {
+	// OlderThan, if not zero, excludes the events recorded at this time or
+	// later.
+	OlderThan time.Time
+
+	// Action, if not empty, is the type of the events.
+	Action Action
+
+	// User, if not empty, is the name of the user, who has performed the
+	// actions.
+	User string
+
+	// Limit is the maximum number of the returned events.  It must be
+	// positive.
+	Limit int
+}
+
+// match returns true if e matches p.
+func (p *SearchParams) match(e *Event) (ok bool) {
+	return (p.OlderThan.IsZero() || e.Time.Before(p.OlderThan)) &&
+		(p.Action == "" || e.Action == p.Action) &&
+		(p.User == "" || e.User == p.User)
+}
+
+// Events returns the recorded events matching p, the most recent first.  p
+// must not be nil.
+func (f *File) Events(p *SearchParams) (events []*Event, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, path := range []string{f.rotatedPath(), f.path} {
+		var fileEvents []*Event
+		fileEvents, err = readEvents(path, p)
+		if err != nil {
+			return nil, fmt.Errorf("reading %q: %w", path, err)
+		}
+
+		events = append(events, fileEvents...)
+	}
+
+	slices.Reverse(events)
+	if len(events) > p.Limit {
+		events = events[:p.Limit]
+	}
+
+	return events, nil
+}
+
+// readEvents returns the events matching p from the file with path, oldest
+// first.  A missing file is considered empty.
+func readEvents(path string, p *SearchParams) (events []*Event, err error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		e := &Event{}
+		err = json.Unmarshal(s.Bytes(), e)
+		if err != nil {
+			log.Debug("audit: decoding event from file: %s", err)
+
+			continue
+		}
+
+		if p.match(e) {
+			events = append(events, e)
+		}
+	}
+
+	return events, s.Err()
+}
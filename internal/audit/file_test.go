@@ -0,0 +1,58 @@
+package audit_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+
+	// Rotate after each couple of events.
+	f := audit.NewFile(path, 200)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, action := range []audit.Action{
+		audit.ActionLogin,
+		audit.ActionConfigChange,
+		audit.ActionLoginFailed,
+		audit.ActionLogin,
+		audit.ActionRuleChange,
+	} {
+		e := newTestEvent("/control/test")
+		e.Time = start.Add(time.Duration(i) * time.Minute)
+		e.Action = action
+		f.Record(e)
+	}
+
+	events, err := f.Events(&audit.SearchParams{Limit: 10})
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+
+	// The oldest events are removed by the rotation.
+	assert.Less(t, len(events), 5)
+	assert.Equal(t, audit.ActionRuleChange, events[0].Action)
+	for i := 1; i < len(events); i++ {
+		assert.True(t, events[i].Time.Before(events[i-1].Time))
+	}
+
+	events, err = f.Events(&audit.SearchParams{
+		OlderThan: start.Add(4 * time.Minute),
+		Action:    audit.ActionLogin,
+		Limit:     1,
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	assert.Equal(t, start.Add(3*time.Minute), events[0].Time)
+
+	events, err = f.Events(&audit.SearchParams{User: "nobody", Limit: 10})
+	require.NoError(t, err)
+
+	assert.Empty(t, events)
+}
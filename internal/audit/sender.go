@@ -0,0 +1,213 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+)
+
+// sendTimeout is the timeout for sending a single event.
+const sendTimeout = 10 * time.Second
+
+// sender sends the events to the remote collector.
+type sender interface {
+	// send sends e.  It returns an error if the event hasn't been delivered.
+	send(e *Event) (err error)
+
+	// close releases the resources used by the sender.
+	close() (err error)
+}
+
+// newSender returns a new sender for the collector described by conf.
+func newSender(conf *Config) (s sender, err error) {
+	u := conf.URL
+	if u == nil {
+		return nil, errors.Error("no value")
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		return &syslogSender{
+			network:  u.Scheme,
+			addr:     u.Host,
+			hostname: conf.Hostname,
+		}, nil
+	case "http", "https":
+		cli := conf.HTTPClient
+		if cli == nil {
+			cli = http.DefaultClient
+		}
+
+		return &httpSender{
+			client: cli,
+			url:    u,
+		}, nil
+	default:
+		return nil, fmt.Errorf("bad scheme %q", u.Scheme)
+	}
+}
+
+// syslogPriority is the priority of the syslog messages: the authpriv facility
+// with the notice severity, see RFC 5424.
+const syslogPriority = 10*8 + 5
+
+// syslogSender is a sender to a remote syslog server.  The messages are
+// formatted according to RFC 5424 and framed using the octet counting for TCP,
+// see RFC 6587.
+type syslogSender struct {
+	// conn is the current connection, if any.  It's only accessed from the
+	// delivery goroutine.
+	conn net.Conn
+
+	// network is either "udp" or "tcp".
+	network string
+
+	// addr is the address of the syslog server.
+	addr string
+
+	// hostname is the HOSTNAME field of the messages.
+	hostname string
+}
+
+// type check
+var _ sender = (*syslogSender)(nil)
+
+// send implements the [sender] interface for *syslogSender.
+func (s *syslogSender) send(e *Event) (err error) {
+	msg, err := s.message(e)
+	if err != nil {
+		return fmt.Errorf("formatting message: %w", err)
+	}
+
+	if s.conn == nil {
+		s.conn, err = net.DialTimeout(s.network, s.addr, sendTimeout)
+		if err != nil {
+			return fmt.Errorf("dialing: %w", err)
+		}
+	}
+
+	err = s.write(msg)
+	if err != nil {
+		// Reconnect on the next attempt.
+		err = errors.WithDeferred(err, s.conn.Close())
+		s.conn = nil
+
+		return fmt.Errorf("writing: %w", err)
+	}
+
+	return nil
+}
+
+// write writes msg into the current connection.
+func (s *syslogSender) write(msg []byte) (err error) {
+	err = s.conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+	if err != nil {
+		return fmt.Errorf("setting deadline: %w", err)
+	}
+
+	if s.network == "tcp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	_, err = s.conn.Write(msg)
+
+	return err
+}
+
+// message returns the syslog message for e.
+func (s *syslogSender) message(e *Event) (msg []byte, err error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	hostname := s.hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	b := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(
+		b,
+		"<%d>1 %s %s AdGuardHome - %s - ",
+		syslogPriority,
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname,
+		e.Action,
+	)
+	_, _ = b.Write(data)
+
+	return b.Bytes(), nil
+}
+
+// close implements the [sender] interface for *syslogSender.
+func (s *syslogSender) close() (err error) {
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
+
+// httpSender is a sender to an HTTP collector.
+type httpSender struct {
+	// client is used to send the requests.
+	client *http.Client
+
+	// url is the address of the collector.
+	url *url.URL
+}
+
+// type check
+var _ sender = (*httpSender)(nil)
+
+// send implements the [sender] interface for *httpSender.
+func (s *httpSender) send(e *Event) (err error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, aghhttp.HdrValApplicationJSON)
+
+	cli := *s.client
+	if cli.Timeout == 0 {
+		cli.Timeout = sendTimeout
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// close implements the [sender] interface for *httpSender.
+func (s *httpSender) close() (err error) {
+	return nil
+}
@@ -0,0 +1,248 @@
+// Package blockhook contains the hooks, which run external commands or call
+// webhooks when requests are blocked.
+package blockhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Event is a single blocked request.
+type Event struct {
+	// Time is the time of the request.
+	Time time.Time `json:"time"`
+
+	// Host is the requested hostname.
+	Host string `json:"host"`
+
+	// QType is the type of the request, for example "A".
+	QType string `json:"qtype"`
+
+	// ClientIP is the IP address of the client.  It's anonymized, if the
+	// anonymization of client IP addresses is enabled.
+	ClientIP netip.Addr `json:"client_ip"`
+
+	// ClientID is the ClientID of the client, if any.
+	ClientID string `json:"client_id,omitempty"`
+
+	// Reason is the reason of blocking, see [filtering.Reason].
+	Reason string `json:"reason"`
+
+	// ServiceName is the name of the blocked service, if the request has been
+	// blocked by one.
+	ServiceName string `json:"service_name,omitempty"`
+
+	// Categories are the domain categories of the host, if the request has
+	// been blocked as a host from a blocked category.
+	Categories []string `json:"categories,omitempty"`
+
+	// Rules are the rules, which have blocked the request, if any.
+	Rules []*Rule `json:"rules,omitempty"`
+}
+
+// Rule is a filtering rule, which has blocked a request.
+type Rule struct {
+	// Text is the text of the rule.
+	Text string `json:"text"`
+
+	// FilterListID is the ID of the filter list containing the rule.
+	FilterListID int64 `json:"filter_list_id"`
+}
+
+// Interface is the notifier of the hooks.
+type Interface interface {
+	// Notify runs the hooks matching e.  It must not block.  e must not be nil
+	// and must not be modified after the call.
+	Notify(e *Event)
+}
+
+// Empty is an [Interface] implementation that does nothing.
+type Empty struct{}
+
+// type check
+var _ Interface = Empty{}
+
+// Notify implements the [Interface] interface for Empty.
+func (Empty) Notify(_ *Event) {}
+
+// Multi is an [Interface] implementation that notifies each of its items.
+// Each item must not be nil.
+type Multi []Interface
+
+// type check
+var _ Interface = Multi(nil)
+
+// Notify implements the [Interface] interface for Multi.
+func (m Multi) Notify(e *Event) {
+	for _, n := range m {
+		n.Notify(e)
+	}
+}
+
+// Config is the configuration of the hooks notifier.
+type Config struct {
+	// HTTPClient is the client used to call the webhooks.  If nil,
+	// [http.DefaultClient] is used.
+	HTTPClient *http.Client
+
+	// Hooks are the configurations of the hooks.  Each item must not be nil.
+	Hooks []*HookConfig
+}
+
+// HookConfig is the configuration of a single hook.
+type HookConfig struct {
+	// URL is the address of the webhook, which receives the event as a JSON
+	// object in the body of a POST request.  The scheme must be either "http"
+	// or "https".  Exactly one of URL and Command must be set.
+	URL *url.URL
+
+	// Name is the name of the hook used in logs.
+	Name string
+
+	// Command is the executable and its arguments, which is run with the
+	// event as a JSON object on its standard input.  See [aghos.RunHook].
+	Command []string
+
+	// Rules, if not empty, are the texts of the rules, at least one of which
+	// must have blocked the request.
+	Rules []string
+
+	// Clients, if not empty, are the IP addresses, CIDR subnets, and
+	// ClientIDs, at least one of which must match the client.
+	Clients []string
+
+	// Services, if not empty, are the names of the blocked services, for
+	// example "YouTube", one of which must have blocked the request.  The
+	// names are matched case-insensitively.
+	Services []string
+
+	// Categories, if not empty, are the domain categories, for example
+	// "gambling", one of which the host blocked by the category must belong
+	// to.
+	Categories []string
+
+	// RateLimit is the minimum duration between two runs of the hook.  The
+	// events matched within it are dropped.  If zero, the hook is run for
+	// every matched event.
+	RateLimit time.Duration
+
+	// Timeout is the timeout for a single run of the hook.  If zero,
+	// [DefaultTimeout] is used.
+	Timeout time.Duration
+}
+
+// queueSize is the maximum number of the events waiting for a hook to run.
+// When it's exceeded, the new events are dropped.
+const queueSize = 16
+
+// DefaultTimeout is the timeout for a single run of a hook used, when the hook
+// has no timeout.
+const DefaultTimeout = 10 * time.Second
+
+// Notifier is an [Interface] implementation, which runs each matching hook
+// asynchronously.
+type Notifier struct {
+	// ctx is canceled when the notifier is closing.
+	ctx context.Context
+
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+
+	// wg waits for the hooks goroutines to exit.
+	wg *sync.WaitGroup
+
+	// hooks are the configured hooks.
+	hooks []*hook
+}
+
+// type check
+var _ Interface = (*Notifier)(nil)
+
+// New returns a new properly initialized *Notifier.  conf must not be nil.
+func New(conf *Config) (n *Notifier, err error) {
+	cli := conf.HTTPClient
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n = &Notifier{
+		ctx:    ctx,
+		cancel: cancel,
+		wg:     &sync.WaitGroup{},
+		hooks:  make([]*hook, 0, len(conf.Hooks)),
+	}
+
+	for i, hc := range conf.Hooks {
+		var h *hook
+		h, err = newHook(hc, cli)
+		if err != nil {
+			cancel()
+
+			return nil, fmt.Errorf("hook at index %d: %w", i, err)
+		}
+
+		n.hooks = append(n.hooks, h)
+	}
+
+	return n, nil
+}
+
+// Start starts running the hooks.  It must only be called once.
+func (n *Notifier) Start() {
+	for _, h := range n.hooks {
+		n.wg.Add(1)
+		go n.handle(h)
+	}
+}
+
+// Notify implements the [Interface] interface for *Notifier.
+func (n *Notifier) Notify(e *Event) {
+	now := time.Now()
+	for _, h := range n.hooks {
+		if !h.match(e) || !h.allow(now) {
+			continue
+		}
+
+		select {
+		case h.queue <- e:
+		default:
+			log.Debug("blockhook: %s: queue is full, dropping event", h.name)
+		}
+	}
+}
+
+// handle runs h for each of the queued events until n is closed.  It's
+// intended to be used as a goroutine.
+func (n *Notifier) handle(h *hook) {
+	defer n.wg.Done()
+	defer log.OnPanic("blockhook: " + h.name)
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case e := <-h.queue:
+			ctx, cancel := context.WithTimeout(n.ctx, h.timeout)
+			err := h.run(ctx, e)
+			cancel()
+			if err != nil {
+				log.Error("blockhook: %s: %s", h.name, err)
+			}
+		}
+	}
+}
+
+// Close stops running the hooks, cancels the running ones, and waits for them
+// to exit.
+func (n *Notifier) Close() {
+	n.cancel()
+	n.wg.Wait()
+}
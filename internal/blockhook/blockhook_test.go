@@ -0,0 +1,222 @@
+package blockhook_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// newTestEvent returns a new event blocked by the rule with text for the
+// client with ip.
+func newTestEvent(ip, text string) (e *blockhook.Event) {
+	return &blockhook.Event{
+		Time:     time.Now(),
+		Host:     "blocked.example",
+		QType:    "A",
+		ClientIP: netip.MustParseAddr(ip),
+		Reason:   "FilteredBlackList",
+		Rules: []*blockhook.Rule{{
+			Text:         text,
+			FilterListID: 1,
+		}},
+	}
+}
+
+// newTestNotifier returns a new started notifier for the hooks and registers
+// its closing in t's cleanup.
+func newTestNotifier(t *testing.T, hooks ...*blockhook.HookConfig) (n *blockhook.Notifier) {
+	t.Helper()
+
+	n, err := blockhook.New(&blockhook.Config{
+		Hooks: hooks,
+	})
+	require.NoError(t, err)
+
+	n.Start()
+	t.Cleanup(n.Close)
+
+	return n
+}
+
+func TestNotifier_webhook(t *testing.T) {
+	eventsCh := make(chan *blockhook.Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pt := testutil.PanicT{}
+
+		e := &blockhook.Event{}
+		require.NoError(pt, json.NewDecoder(r.Body).Decode(e))
+
+		eventsCh <- e
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	n := newTestNotifier(t, &blockhook.HookConfig{
+		URL:       u,
+		Name:      "test",
+		Rules:     []string{"||blocked.example^"},
+		Clients:   []string{"192.0.2.0/24", "kids-tablet"},
+		RateLimit: time.Hour,
+	})
+
+	// Not matched by the rule.
+	n.Notify(newTestEvent("192.0.2.1", "||other.example^"))
+
+	// Not matched by the client.
+	n.Notify(newTestEvent("198.51.100.1", "||blocked.example^"))
+
+	matched := newTestEvent("192.0.2.1", "||blocked.example^")
+	n.Notify(matched)
+
+	// Dropped due to the rate limit.
+	limited := newTestEvent("192.0.2.2", "||blocked.example^")
+	limited.ClientID = "kids-tablet"
+	n.Notify(limited)
+
+	e, ok := testutil.RequireReceive(t, eventsCh, testTimeout)
+	require.True(t, ok)
+
+	assert.Equal(t, matched.ClientIP, e.ClientIP)
+	assert.Equal(t, matched.Rules, e.Rules)
+	assert.Empty(t, eventsCh)
+}
+
+func TestNotifier_category(t *testing.T) {
+	eventsCh := make(chan *blockhook.Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pt := testutil.PanicT{}
+
+		e := &blockhook.Event{}
+		require.NoError(pt, json.NewDecoder(r.Body).Decode(e))
+
+		eventsCh <- e
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	n := newTestNotifier(t, &blockhook.HookConfig{
+		URL:        u,
+		Name:       "test",
+		Categories: []string{"gambling"},
+	})
+
+	// Not blocked by a category.
+	n.Notify(newTestEvent("192.0.2.1", "||blocked.example^"))
+
+	other := newTestEvent("192.0.2.1", "adult")
+	other.Reason, other.Categories = "FilteredCategory", []string{"adult"}
+	n.Notify(other)
+
+	matched := newTestEvent("192.0.2.1", "gambling")
+	matched.Reason, matched.Categories = "FilteredCategory", []string{"games", "gambling"}
+	n.Notify(matched)
+
+	e, ok := testutil.RequireReceive(t, eventsCh, testTimeout)
+	require.True(t, ok)
+
+	assert.Equal(t, matched.Categories, e.Categories)
+	assert.Empty(t, eventsCh)
+}
+
+func TestNotifier_command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a posix shell")
+	}
+
+	outFile := filepath.Join(t.TempDir(), "event.json")
+	n := newTestNotifier(t, &blockhook.HookConfig{
+		Name:     "test",
+		Command:  []string{"sh", "-c", `cat > "$0"`, outFile},
+		Services: []string{"youtube"},
+	})
+
+	n.Notify(newTestEvent("192.0.2.1", "||blocked.example^"))
+
+	e := newTestEvent("192.0.2.1", "")
+	e.Reason, e.ServiceName, e.Rules = "FilteredBlockedService", "YouTube", nil
+	n.Notify(e)
+
+	var data []byte
+	require.Eventually(t, func() (ok bool) {
+		var err error
+		data, err = os.ReadFile(outFile)
+
+		return err == nil && len(data) > 0
+	}, testTimeout, testTimeout/10)
+
+	got := &blockhook.Event{}
+	require.NoError(t, json.Unmarshal(data, got))
+
+	assert.Equal(t, "YouTube", got.ServiceName)
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		hook       *blockhook.HookConfig
+		name       string
+		wantErrMsg string
+	}{{
+		hook:       &blockhook.HookConfig{},
+		name:       "no_action",
+		wantErrMsg: "hook at index 0: exactly one of url and command must be set",
+	}, {
+		hook: &blockhook.HookConfig{
+			URL:     &url.URL{Scheme: "https", Host: "hook.example"},
+			Command: []string{"true"},
+		},
+		name:       "both_actions",
+		wantErrMsg: "hook at index 0: exactly one of url and command must be set",
+	}, {
+		hook: &blockhook.HookConfig{
+			URL: &url.URL{Scheme: "ftp", Host: "hook.example"},
+		},
+		name:       "bad_scheme",
+		wantErrMsg: `hook at index 0: bad url scheme "ftp"`,
+	}, {
+		hook: &blockhook.HookConfig{
+			Command:   []string{"true"},
+			RateLimit: -time.Second,
+		},
+		name:       "bad_rate_limit",
+		wantErrMsg: "hook at index 0: rate limit: must not be negative, got -1s",
+	}, {
+		hook: &blockhook.HookConfig{
+			Command: []string{"true"},
+			Timeout: -time.Second,
+		},
+		name:       "bad_timeout",
+		wantErrMsg: "hook at index 0: timeout: must not be negative, got -1s",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := blockhook.New(&blockhook.Config{
+				Hooks: []*blockhook.HookConfig{tc.hook},
+			})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
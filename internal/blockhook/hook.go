@@ -0,0 +1,260 @@
+package blockhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/exp/slices"
+)
+
+// hook is a single configured hook.
+type hook struct {
+	// client is used to call the webhook.
+	client *http.Client
+
+	// conf is the configuration of the hook.
+	conf *HookConfig
+
+	// rules are the texts of the matched rules.
+	rules *stringutil.Set
+
+	// clientIDs are the matched ClientIDs.
+	clientIDs *stringutil.Set
+
+	// mu protects lastRun.
+	mu *sync.Mutex
+
+	// lastRun is the time, when the last event has been accepted for running.
+	lastRun time.Time
+
+	// queue are the events waiting for the hook to run.
+	queue chan *Event
+
+	// name is the name of the hook used in logs.
+	name string
+
+	// timeout is the timeout for a single run of the hook.
+	timeout time.Duration
+
+	// subnets are the matched client subnets.  The single IP addresses are
+	// kept as single-address prefixes.
+	subnets []netip.Prefix
+}
+
+// newHook returns a new properly initialized *hook.  conf must not be nil.
+func newHook(conf *HookConfig, cli *http.Client) (h *hook, err error) {
+	hasURL, hasCmd := conf.URL != nil, len(conf.Command) > 0
+	if hasURL == hasCmd {
+		return nil, errors.Error("exactly one of url and command must be set")
+	}
+
+	if hasURL {
+		switch conf.URL.Scheme {
+		case aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS:
+			// Go on.
+		default:
+			return nil, fmt.Errorf("bad url scheme %q", conf.URL.Scheme)
+		}
+	}
+
+	if conf.RateLimit < 0 {
+		return nil, fmt.Errorf("rate limit: must not be negative, got %s", conf.RateLimit)
+	}
+
+	if conf.Timeout < 0 {
+		return nil, fmt.Errorf("timeout: must not be negative, got %s", conf.Timeout)
+	}
+
+	h = &hook{
+		client:    cli,
+		conf:      conf,
+		rules:     stringutil.NewSet(conf.Rules...),
+		clientIDs: stringutil.NewSet(),
+		mu:        &sync.Mutex{},
+		queue:     make(chan *Event, queueSize),
+		name:      conf.Name,
+		timeout:   conf.Timeout,
+	}
+
+	if h.name == "" {
+		h.name = "unnamed"
+	}
+
+	if h.timeout == 0 {
+		h.timeout = DefaultTimeout
+	}
+
+	for _, c := range conf.Clients {
+		var pref netip.Prefix
+		pref, err = parseClient(c)
+		if err == nil {
+			h.subnets = append(h.subnets, pref.Masked())
+		} else {
+			h.clientIDs.Add(c)
+		}
+	}
+
+	return h, nil
+}
+
+// parseClient parses c as either an IP address or a CIDR subnet.
+func parseClient(c string) (pref netip.Prefix, err error) {
+	if strings.Contains(c, "/") {
+		return netip.ParsePrefix(c)
+	}
+
+	ip, err := netip.ParseAddr(c)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// match returns true if e satisfies all the conditions of h.
+func (h *hook) match(e *Event) (ok bool) {
+	return h.matchRules(e) && h.matchClient(e) && h.matchService(e) && h.matchCategory(e)
+}
+
+// matchRules returns true if e has been blocked by one of the rules of h or if
+// h has none.
+func (h *hook) matchRules(e *Event) (ok bool) {
+	if h.rules.Len() == 0 {
+		return true
+	}
+
+	for _, r := range e.Rules {
+		if h.rules.Has(r.Text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchClient returns true if the client of e is one of the clients of h or if
+// h has none.
+func (h *hook) matchClient(e *Event) (ok bool) {
+	if h.clientIDs.Len() == 0 && len(h.subnets) == 0 {
+		return true
+	}
+
+	if e.ClientID != "" && h.clientIDs.Has(e.ClientID) {
+		return true
+	}
+
+	ip := e.ClientIP.Unmap()
+	for _, pref := range h.subnets {
+		if pref.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchService returns true if e has been blocked by one of the services of h
+// or if h has none.
+func (h *hook) matchService(e *Event) (ok bool) {
+	if len(h.conf.Services) == 0 {
+		return true
+	} else if e.ServiceName == "" {
+		return false
+	}
+
+	for _, s := range h.conf.Services {
+		if strings.EqualFold(s, e.ServiceName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchCategory returns true if e has been blocked as a host from one of the
+// categories of h or if h has none.
+func (h *hook) matchCategory(e *Event) (ok bool) {
+	if len(h.conf.Categories) == 0 {
+		return true
+	}
+
+	for _, c := range e.Categories {
+		if slices.Contains(h.conf.Categories, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allow returns true if the rate limit of h allows running it at now.
+func (h *hook) allow(now time.Time) (ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.lastRun.IsZero() && now.Sub(h.lastRun) < h.conf.RateLimit {
+		return false
+	}
+
+	h.lastRun = now
+
+	return true
+}
+
+// run runs h for e.
+func (h *hook) run(ctx context.Context, e *Event) (err error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	if h.conf.URL != nil {
+		return h.post(ctx, data)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return aghos.RunHook(ctx, h.conf.Command, data)
+}
+
+// post sends data to the webhook of h.
+func (h *hook) post(ctx context.Context, data []byte) (err error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		h.conf.URL.String(),
+		bytes.NewReader(data),
+	)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, aghhttp.HdrValApplicationJSON)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
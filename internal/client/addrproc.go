@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
 	"github.com/AdguardTeam/AdGuardHome/internal/rdns"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
 	"github.com/AdguardTeam/golibs/errors"
@@ -74,6 +75,14 @@ type DefaultAddrProcConfig struct {
 
 	// UseWHOIS, if true, enables resolving of client IP addresses using WHOIS.
 	UseWHOIS bool
+
+	// UseLLMNR, if true, enables resolving of private client IP addresses
+	// using LLMNR reverse queries.
+	UseLLMNR bool
+
+	// UseNetBIOS, if true, enables resolving of private client IPv4 addresses
+	// using NetBIOS node status queries.
+	UseNetBIOS bool
 }
 
 // AddressUpdater is the interface for storages of DNS clients that can update
@@ -85,6 +94,10 @@ type AddressUpdater interface {
 	// UpdateAddress updates information about an IP address, setting host (if
 	// not empty) and WHOIS information (if not nil).
 	UpdateAddress(ip netip.Addr, host string, info *whois.Info)
+
+	// UpdateDiscovered updates the information about the device with ip
+	// discovered on the local network.  info must not be nil.
+	UpdateDiscovered(ip netip.Addr, info *discovery.Info)
 }
 
 // DefaultAddrProc processes incoming client addresses with rDNS and WHOIS, if
@@ -102,6 +115,10 @@ type DefaultAddrProc struct {
 	// whois is used to perform WHOIS lookups of clients' IP addresses.
 	whois whois.Interface
 
+	// prober is used to ask the devices with private IP addresses about their
+	// names using LLMNR and NetBIOS.
+	prober discovery.Prober
+
 	// addrUpdater is used to update the information about a client's IP
 	// address.
 	addrUpdater AddressUpdater
@@ -130,6 +147,9 @@ const (
 	// defaultIPTTL is the Time to Live duration for IP addresses cached by
 	// rDNS and WHOIS.
 	defaultIPTTL = 1 * time.Hour
+
+	// defaultProbeTimeout is the timeout for a single LLMNR or NetBIOS probe.
+	defaultProbeTimeout = 1 * time.Second
 )
 
 // NewDefaultAddrProc returns a new running client address processor.  c must
@@ -141,6 +161,7 @@ func NewDefaultAddrProc(c *DefaultAddrProcConfig) (p *DefaultAddrProc) {
 		rdns:           &rdns.Empty{},
 		addrUpdater:    c.AddressUpdater,
 		whois:          &whois.Empty{},
+		prober:         discovery.EmptyProber{},
 		privateSubnets: c.PrivateSubnets,
 		usePrivateRDNS: c.UsePrivateRDNS,
 	}
@@ -157,6 +178,16 @@ func NewDefaultAddrProc(c *DefaultAddrProcConfig) (p *DefaultAddrProc) {
 		p.whois = newWHOIS(c.DialContext)
 	}
 
+	if c.UseLLMNR || c.UseNetBIOS {
+		p.prober = discovery.NewProber(&discovery.ProberConfig{
+			CacheSize:  defaultCacheSize,
+			CacheTTL:   defaultIPTTL,
+			Timeout:    defaultProbeTimeout,
+			UseLLMNR:   c.UseLLMNR,
+			UseNetBIOS: c.UseNetBIOS,
+		})
+	}
+
 	go p.process(c.CatchPanics)
 
 	for _, ip := range c.InitialAddresses {
@@ -232,6 +263,10 @@ func (p *DefaultAddrProc) process(catchPanics bool) {
 		info := p.processWHOIS(ip)
 
 		p.addrUpdater.UpdateAddress(ip, host, info)
+
+		if di := p.processDiscovery(ip); di != nil {
+			p.addrUpdater.UpdateDiscovered(ip, di)
+		}
 	}
 
 	log.Info("clients: finished processing addresses")
@@ -286,6 +321,22 @@ func (p *DefaultAddrProc) processWHOIS(ip netip.Addr) (info *whois.Info) {
 	return info
 }
 
+// processDiscovery asks the devices with private IP addresses about their
+// names.  info is nil if there were errors or if the information hasn't
+// changed.
+func (p *DefaultAddrProc) processDiscovery(ip netip.Addr) (info *discovery.Info) {
+	if ip.IsLoopback() || !p.privateSubnets.Contains(ip.AsSlice()) {
+		return nil
+	}
+
+	info, changed := p.prober.Process(context.Background(), ip)
+	if !changed {
+		info = nil
+	}
+
+	return info
+}
+
 // Close implements the [AddressProcessor] interface for *DefaultAddrProc.
 func (p *DefaultAddrProc) Close() (err error) {
 	p.clientIPsMu.Lock()
@@ -18,6 +18,9 @@ const (
 	SourceNone Source = iota
 	SourceWHOIS
 	SourceARP
+	SourceNetBIOS
+	SourceLLMNR
+	SourceMDNS
 	SourceRDNS
 	SourceDHCP
 	SourceHostsFile
@@ -34,6 +37,12 @@ func (cs Source) String() (s string) {
 		return "WHOIS"
 	case SourceARP:
 		return "ARP"
+	case SourceNetBIOS:
+		return "NetBIOS"
+	case SourceLLMNR:
+		return "LLMNR"
+	case SourceMDNS:
+		return "mDNS"
 	case SourceRDNS:
 		return "rDNS"
 	case SourceDHCP:
@@ -0,0 +1,361 @@
+// Package ddns contains the dynamic DNS updater, which keeps the address
+// records of the hostnames pointed at the public IP addresses of the host.
+package ddns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Config is the configuration of the updater.
+type Config struct {
+	// HTTPClient is the client used to detect the addresses and to call the
+	// APIs of the providers.  If nil, [http.DefaultClient] is used.
+	HTTPClient *http.Client
+
+	// HTTPRegister, if not nil, is used to register the HTTP API of the
+	// updater.
+	HTTPRegister aghhttp.RegisterFunc
+
+	// OnError, if not nil, is called with the name of the provider and the
+	// error each time the records couldn't be updated.  It must not block.
+	OnError func(name string, err error)
+
+	// IPv4URL is the address of the service returning the public IPv4 address
+	// of the host as plain text.  If nil, the A records aren't updated.
+	IPv4URL *url.URL
+
+	// IPv6URL is the address of the service returning the public IPv6 address
+	// of the host as plain text.  If nil, the AAAA records aren't updated.
+	IPv6URL *url.URL
+
+	// Providers are the configurations of the providers.  Each item must not
+	// be nil.  It must not be empty.
+	Providers []*ProviderConfig
+
+	// Interval is the interval between the checks of the public addresses.
+	// It must be positive.
+	Interval time.Duration
+}
+
+// maxAddrRespSize is the maximum size of the response of the address
+// detection service.
+const maxAddrRespSize = 1024
+
+// updateTimeout is the timeout for a single check of the addresses including
+// the updates of the records.
+const updateTimeout = 1 * time.Minute
+
+// Updater checks the public addresses of the host periodically and updates
+// the records of the providers, when they change.
+type Updater struct {
+	// client is used to detect the addresses.
+	client *http.Client
+
+	// onError is called on each failed update, if not nil.
+	onError func(name string, err error)
+
+	// ipv4URL is the address of the IPv4 detection service, if any.
+	ipv4URL *url.URL
+
+	// ipv6URL is the address of the IPv6 detection service, if any.
+	ipv6URL *url.URL
+
+	// ctx is canceled when the updater is closing.
+	ctx context.Context
+
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+
+	// done is closed when the updating goroutine exits.
+	done chan struct{}
+
+	// mu protects the fields below and the states of the providers.
+	mu *sync.Mutex
+
+	// checked is the time of the last check.  It's zero before the first one.
+	checked time.Time
+
+	// ipv4 is the public IPv4 address of the host as of the last check, if
+	// any.
+	ipv4 netip.Addr
+
+	// ipv6 is the public IPv6 address of the host as of the last check, if
+	// any.
+	ipv6 netip.Addr
+
+	// detectErr is the error of the last detection of the addresses, if any.
+	detectErr error
+
+	// providers are the configured providers.
+	providers []*providerState
+
+	// interval is the interval between the checks.
+	interval time.Duration
+}
+
+// Validate returns an error if conf is invalid.
+func (conf *Config) Validate() (err error) {
+	if conf.Interval <= 0 {
+		return fmt.Errorf("interval: must be positive, got %s", conf.Interval)
+	} else if conf.IPv4URL == nil && conf.IPv6URL == nil {
+		return errors.Error("no address detection urls")
+	} else if len(conf.Providers) == 0 {
+		return errors.Error("no providers")
+	}
+
+	for _, u := range []*url.URL{conf.IPv4URL, conf.IPv6URL} {
+		if u == nil {
+			continue
+		}
+
+		err = validateHTTPURL(u)
+		if err != nil {
+			return fmt.Errorf("address detection url: %w", err)
+		}
+	}
+
+	for i, pc := range conf.Providers {
+		_, err = newProvider(pc, nil)
+		if err != nil {
+			return fmt.Errorf("provider at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// New returns a new properly initialized *Updater.  conf must not be nil.
+func New(conf *Config) (u *Updater, err error) {
+	err = conf.Validate()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	cli := conf.HTTPClient
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u = &Updater{
+		client:    cli,
+		onError:   conf.OnError,
+		ipv4URL:   conf.IPv4URL,
+		ipv6URL:   conf.IPv6URL,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		mu:        &sync.Mutex{},
+		providers: make([]*providerState, 0, len(conf.Providers)),
+		interval:  conf.Interval,
+	}
+
+	for _, pc := range conf.Providers {
+		var p provider
+		p, err = newProvider(pc, cli)
+		if err != nil {
+			// Shouldn't happen, since the configuration is valid.
+			panic(err)
+		}
+
+		u.providers = append(u.providers, &providerState{
+			provider: p,
+			conf:     pc,
+		})
+	}
+
+	if conf.HTTPRegister != nil {
+		u.initWeb(conf.HTTPRegister)
+	}
+
+	return u, nil
+}
+
+// Start starts checking the addresses.  It must only be called once.
+func (u *Updater) Start() {
+	go u.updateLoop()
+}
+
+// Close stops checking the addresses, cancels the current updates, and waits
+// for the updating goroutine to exit.
+func (u *Updater) Close() {
+	u.cancel()
+	<-u.done
+}
+
+// updateLoop checks the addresses right away and then each u.interval until u
+// is closed.  It's intended to be used as a goroutine.
+func (u *Updater) updateLoop() {
+	defer close(u.done)
+	defer log.OnPanic("ddns")
+
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	for {
+		u.refresh(u.ctx)
+
+		select {
+		case <-u.ctx.Done():
+			return
+		case <-ticker.C:
+			// Go on.
+		}
+	}
+}
+
+// refresh detects the public addresses and updates the records of the
+// providers, which haven't been updated with them yet.
+func (u *Updater) refresh(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, updateTimeout)
+	defer cancel()
+
+	ipv4, ipv6, err := u.detect(ctx)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.checked, u.ipv4, u.ipv6, u.detectErr = time.Now(), ipv4, ipv6, err
+	if err != nil {
+		log.Error("ddns: detecting addresses: %s", err)
+
+		// Go on and update the records with the detected addresses, if any.
+	}
+
+	if !ipv4.IsValid() && !ipv6.IsValid() {
+		return
+	}
+
+	for _, p := range u.providers {
+		u.updateProvider(ctx, p, ipv4, ipv6)
+	}
+}
+
+// updateProvider updates the records of p with ipv4 and ipv6, unless it has
+// already been updated with them.  u.mu is expected to be locked.
+func (u *Updater) updateProvider(ctx context.Context, p *providerState, ipv4, ipv6 netip.Addr) {
+	if p.err == nil && p.ipv4 == ipv4 && p.ipv6 == ipv6 {
+		return
+	}
+
+	p.err = p.provider.update(ctx, ipv4, ipv6)
+	if p.err != nil {
+		log.Error("ddns: %s: %s", p.conf.Name, p.err)
+
+		if u.onError != nil {
+			u.onError(p.conf.Name, p.err)
+		}
+
+		return
+	}
+
+	p.ipv4, p.ipv6, p.updated = ipv4, ipv6, time.Now()
+
+	log.Info(
+		"ddns: %s: updated %s with %s",
+		p.conf.Name,
+		p.conf.Hostname,
+		formatAddrs(ipv4, ipv6),
+	)
+}
+
+// formatAddrs returns the valid addresses of ipv4 and ipv6 joined with
+// commas.
+func formatAddrs(ipv4, ipv6 netip.Addr) (s string) {
+	addrs := make([]string, 0, 2)
+	for _, ip := range []netip.Addr{ipv4, ipv6} {
+		if ip.IsValid() {
+			addrs = append(addrs, ip.String())
+		}
+	}
+
+	return strings.Join(addrs, ", ")
+}
+
+// detect returns the public addresses of the host.  The address of the family,
+// which has no detection URL or couldn't be detected, is invalid.
+func (u *Updater) detect(ctx context.Context) (ipv4, ipv6 netip.Addr, err error) {
+	var errs []error
+	if u.ipv4URL != nil {
+		ipv4, err = u.detectAddr(ctx, u.ipv4URL, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ipv4: %w", err))
+		}
+	}
+
+	if u.ipv6URL != nil {
+		ipv6, err = u.detectAddr(ctx, u.ipv6URL, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ipv6: %w", err))
+		}
+	}
+
+	return ipv4, ipv6, errors.Join(errs...)
+}
+
+// detectAddr requests the public address of the host from the service at
+// addrURL.  is4 tells if the address must be an IPv4 one.
+func (u *Updater) detectAddr(
+	ctx context.Context,
+	addrURL *url.URL,
+	is4 bool,
+) (ip netip.Addr, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addrURL.String(), nil)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAddrRespSize))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("reading response: %w", err)
+	} else if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	ip, err = netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return netip.Addr{}, err
+	}
+
+	if ip.Is4() != is4 || ip.Is4In6() {
+		return netip.Addr{}, fmt.Errorf("address %s is of the wrong family", ip)
+	}
+
+	return ip, nil
+}
+
+// validateHTTPURL returns an error if u is not a valid HTTP or HTTPS URL.
+func validateHTTPURL(u *url.URL) (err error) {
+	switch u.Scheme {
+	case aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS:
+		// Go on.
+	default:
+		return fmt.Errorf("bad scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return errors.Error("no host")
+	}
+
+	return nil
+}
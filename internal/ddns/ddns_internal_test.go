@@ -0,0 +1,346 @@
+package ddns
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// testAddrService is a fake address detection service.
+type testAddrService struct {
+	// mu protects ipv4.
+	mu *sync.Mutex
+
+	// ipv4 is the address returned by the service.
+	ipv4 string
+}
+
+// set sets the address returned by s.
+func (s *testAddrService) set(ipv4 string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ipv4 = ipv4
+}
+
+// ServeHTTP implements the [http.Handler] interface for *testAddrService.
+func (s *testAddrService) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, _ = io.WriteString(w, s.ipv4+"\n")
+}
+
+func TestUpdater_refresh(t *testing.T) {
+	addrSrv := &testAddrService{mu: &sync.Mutex{}, ipv4: "192.0.2.1"}
+	addrURL := startServer(t, addrSrv)
+
+	queries := make(chan url.Values, 10)
+	duckResp := "OK"
+	duckURL := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		_, _ = io.WriteString(w, duckResp)
+	}))
+
+	var errs []string
+	u, err := New(&Config{
+		OnError: func(name string, err error) {
+			errs = append(errs, name+": "+err.Error())
+		},
+		IPv4URL: addrURL,
+		Providers: []*ProviderConfig{{
+			APIURL:   duckURL,
+			Name:     "duck",
+			Type:     ProviderDuckDNS,
+			Hostname: "example.duckdns.org",
+			Token:    "secret",
+		}},
+		Interval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	u.refresh(ctx)
+	require.Len(t, queries, 1)
+	assert.Equal(t, url.Values{
+		"domains": []string{"example"},
+		"token":   []string{"secret"},
+		"ip":      []string{"192.0.2.1"},
+	}, <-queries)
+
+	// The address hasn't changed, so nothing is updated.
+	u.refresh(ctx)
+	assert.Empty(t, queries)
+
+	addrSrv.set("192.0.2.2")
+	duckResp = "KO"
+	u.refresh(ctx)
+	require.Len(t, queries, 1)
+	assert.Equal(t, "192.0.2.2", (<-queries).Get("ip"))
+	assert.Equal(t, []string{`duck: unexpected response "KO"`}, errs)
+
+	s := u.status()
+	assert.Equal(t, "192.0.2.1", s.Providers[0].IPv4)
+	assert.Equal(t, `unexpected response "KO"`, s.Providers[0].Error)
+
+	// The failed update is retried.
+	duckResp = "OK"
+	u.refresh(ctx)
+	require.Len(t, queries, 1)
+	<-queries
+
+	s = u.status()
+	assert.Equal(t, "192.0.2.2", s.IPv4)
+	assert.Empty(t, s.Error)
+	assert.Equal(t, "192.0.2.2", s.Providers[0].IPv4)
+	assert.Empty(t, s.Providers[0].Error)
+	assert.NotNil(t, s.Providers[0].Updated)
+
+	addrSrv.set("2001:db8::1")
+	u.refresh(ctx)
+	assert.Empty(t, queries)
+
+	s = u.status()
+	assert.Empty(t, s.IPv4)
+	assert.Equal(t, "ipv4: address 2001:db8::1 is of the wrong family", s.Error)
+}
+
+func TestCloudflare_update(t *testing.T) {
+	var reqs []string
+	var bodies []*cloudflareRecord
+	apiURL := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		reqs = append(reqs, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+
+		result := "null"
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("type") == "A" {
+				result = `[{"id":"rec1","content":"192.0.2.1"}]`
+			} else {
+				result = `[]`
+			}
+		default:
+			rec := &cloudflareRecord{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(rec))
+
+			bodies = append(bodies, rec)
+		}
+
+		_, _ = io.WriteString(w, `{"success":true,"errors":[],"result":`+result+`}`)
+	}))
+
+	p, err := newProvider(&ProviderConfig{
+		APIURL:   apiURL,
+		Name:     "cf",
+		Type:     ProviderCloudflare,
+		Hostname: "home.example.com",
+		Token:    "token",
+		ZoneID:   "zone",
+	}, http.DefaultClient)
+	require.NoError(t, err)
+
+	ipv4, ipv6 := netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("2001:db8::1")
+	err = p.update(context.Background(), ipv4, ipv6)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"GET /zones/zone/dns_records?name=home.example.com&type=A",
+		"PATCH /zones/zone/dns_records/rec1?",
+		"GET /zones/zone/dns_records?name=home.example.com&type=AAAA",
+		"POST /zones/zone/dns_records?",
+	}, reqs)
+	assert.Equal(t, []*cloudflareRecord{{
+		Content: "192.0.2.2",
+		TTL:     1,
+	}, {
+		Type:    "AAAA",
+		Name:    "home.example.com",
+		Content: "2001:db8::1",
+		TTL:     1,
+	}}, bodies)
+}
+
+func TestRFC2136_update(t *testing.T) {
+	const (
+		keyName = "ddns-key."
+		secret  = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+	)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	updates := make(chan *dns.Msg, 1)
+	srv := &dns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{keyName: secret},
+		// The default function rejects the UPDATE messages.
+		MsgAcceptFunc: func(_ dns.Header) (act dns.MsgAcceptAction) { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			resp := (&dns.Msg{}).SetReply(r)
+			if r.IsTsig() == nil || w.TsigStatus() != nil {
+				resp.Rcode = dns.RcodeNotAuth
+			} else {
+				updates <- r
+				resp.SetTsig(keyName, dns.HmacSHA256, tsigFudge, time.Now().Unix())
+			}
+
+			_ = w.WriteMsg(resp)
+		}),
+	}
+
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+	<-started
+
+	conf := &ProviderConfig{
+		Name:        "rfc2136",
+		Type:        ProviderRFC2136,
+		Hostname:    "home.example.com",
+		Server:      pc.LocalAddr().String(),
+		Zone:        "example.com",
+		TSIGKeyName: "ddns-key",
+		TSIGSecret:  secret,
+	}
+
+	p, err := newProvider(conf, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+
+	err = p.update(ctx, netip.MustParseAddr("192.0.2.1"), netip.Addr{})
+	require.NoError(t, err)
+
+	m := <-updates
+	require.Len(t, m.Question, 1)
+	assert.Equal(t, "example.com.", m.Question[0].Name)
+	require.Len(t, m.Ns, 2)
+	assert.Equal(t, uint16(dns.ClassANY), m.Ns[0].Header().Class)
+
+	a := testutil.RequireTypeAssert[*dns.A](t, m.Ns[1])
+	assert.Equal(t, "home.example.com.", a.Hdr.Name)
+	assert.Equal(t, uint32(DefaultTTL.Seconds()), a.Hdr.Ttl)
+	assert.Equal(t, net.IP{192, 0, 2, 1}, a.A.To4())
+
+	conf.TSIGSecret = "d3Jvbmc="
+	p, err = newProvider(conf, nil)
+	require.NoError(t, err)
+
+	err = p.update(ctx, netip.MustParseAddr("192.0.2.1"), netip.Addr{})
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	addrURL := &url.URL{Scheme: "https", Host: "addr.example"}
+
+	testCases := []struct {
+		provider   *ProviderConfig
+		name       string
+		wantErrMsg string
+	}{{
+		provider: &ProviderConfig{
+			Name:     "duck",
+			Type:     ProviderDuckDNS,
+			Hostname: "example",
+			Token:    "token",
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		provider: &ProviderConfig{
+			Name:     "bad",
+			Type:     "bad",
+			Hostname: "example.com",
+		},
+		name:       "bad_type",
+		wantErrMsg: `provider at index 0: type: bad value "bad"`,
+	}, {
+		provider: &ProviderConfig{
+			Name:     "duck",
+			Type:     ProviderDuckDNS,
+			Hostname: "home.example.com",
+			Token:    "token",
+		},
+		name: "bad_duckdns_hostname",
+		wantErrMsg: `provider at index 0: hostname: ` +
+			`"home.example.com" is not a subdomain of duckdns.org`,
+	}, {
+		provider: &ProviderConfig{
+			Name:     "cf",
+			Type:     ProviderCloudflare,
+			Hostname: "home.example.com",
+			Token:    "token",
+		},
+		name:       "no_zone_id",
+		wantErrMsg: "provider at index 0: zone id: no value",
+	}, {
+		provider: &ProviderConfig{
+			Name:     "rfc",
+			Type:     ProviderRFC2136,
+			Hostname: "home.example.org",
+			Server:   "192.0.2.1:53",
+			Zone:     "example.com",
+		},
+		name: "outside_zone",
+		wantErrMsg: `provider at index 0: hostname: ` +
+			`"home.example.org" is not within zone "example.com"`,
+	}, {
+		provider: &ProviderConfig{
+			Name:          "rfc",
+			Type:          ProviderRFC2136,
+			Hostname:      "home.example.com",
+			Server:        "192.0.2.1:53",
+			Zone:          "example.com",
+			TSIGKeyName:   "key",
+			TSIGAlgorithm: "hmac-md4",
+			TSIGSecret:    "c2VjcmV0",
+		},
+		name:       "bad_algorithm",
+		wantErrMsg: `provider at index 0: tsig algorithm: bad value "hmac-md4"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(&Config{
+				IPv4URL:   addrURL,
+				Providers: []*ProviderConfig{tc.provider},
+				Interval:  time.Minute,
+			})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+// startServer starts an HTTP server with h and returns its URL.
+func startServer(t *testing.T, h http.Handler) (u *url.URL) {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	return u
+}
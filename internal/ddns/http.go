@@ -0,0 +1,118 @@
+package ddns
+
+import (
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// statusResp is the response to the GET /control/ddns/status HTTP API.
+type statusResp struct {
+	// Checked is the time of the last check, if any.
+	Checked *time.Time `json:"checked,omitempty"`
+
+	// IPv4 is the detected public IPv4 address, if any.
+	IPv4 string `json:"ipv4,omitempty"`
+
+	// IPv6 is the detected public IPv6 address, if any.
+	IPv6 string `json:"ipv6,omitempty"`
+
+	// Error is the error of the last detection of the addresses, if any.
+	Error string `json:"error,omitempty"`
+
+	// Providers are the states of the providers in the order of the
+	// configuration.
+	Providers []*providerStatus `json:"providers"`
+}
+
+// providerStatus is the state of a single provider.
+type providerStatus struct {
+	// Updated is the time of the last successful update, if any.
+	Updated *time.Time `json:"updated,omitempty"`
+
+	// Name is the name of the provider.
+	Name string `json:"name"`
+
+	// Type is the type of the provider.
+	Type ProviderType `json:"type"`
+
+	// Hostname is the hostname, which records are updated.
+	Hostname string `json:"hostname"`
+
+	// IPv4 is the IPv4 address of the last successful update, if any.
+	IPv4 string `json:"ipv4,omitempty"`
+
+	// IPv6 is the IPv6 address of the last successful update, if any.
+	IPv6 string `json:"ipv6,omitempty"`
+
+	// Error is the error of the last update, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// initWeb registers the HTTP API of the updater using reg.
+func (u *Updater) initWeb(reg aghhttp.RegisterFunc) {
+	reg(http.MethodGet, "/control/ddns/status", u.handleStatus)
+}
+
+// handleStatus is the handler for the GET /control/ddns/status HTTP API.
+func (u *Updater) handleStatus(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, u.status())
+}
+
+// status returns the current state of the updater.
+func (u *Updater) status() (resp *statusResp) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	resp = &statusResp{
+		Checked:   timeOrNil(u.checked),
+		IPv4:      addrOrEmpty(u.ipv4),
+		IPv6:      addrOrEmpty(u.ipv6),
+		Error:     errOrEmpty(u.detectErr),
+		Providers: make([]*providerStatus, 0, len(u.providers)),
+	}
+
+	for _, p := range u.providers {
+		resp.Providers = append(resp.Providers, &providerStatus{
+			Updated:  timeOrNil(p.updated),
+			Name:     p.conf.Name,
+			Type:     p.conf.Type,
+			Hostname: p.conf.Hostname,
+			IPv4:     addrOrEmpty(p.ipv4),
+			IPv6:     addrOrEmpty(p.ipv6),
+			Error:    errOrEmpty(p.err),
+		})
+	}
+
+	return resp
+}
+
+// timeOrNil returns a pointer to t or nil if t is zero.
+func timeOrNil(t time.Time) (res *time.Time) {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// addrOrEmpty returns the string representation of ip or an empty string if
+// ip is invalid.
+func addrOrEmpty(ip netip.Addr) (s string) {
+	if !ip.IsValid() {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// errOrEmpty returns the message of err or an empty string if err is nil.
+func errOrEmpty(err error) (msg string) {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
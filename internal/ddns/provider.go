@@ -0,0 +1,577 @@
+package ddns
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// ProviderType is the type of a DDNS provider.
+type ProviderType string
+
+// ProviderType values.
+const (
+	// ProviderCloudflare updates the records through the Cloudflare API.
+	ProviderCloudflare ProviderType = "cloudflare"
+
+	// ProviderDuckDNS updates the subdomain of duckdns.org.
+	ProviderDuckDNS ProviderType = "duckdns"
+
+	// ProviderRFC2136 sends the DNS UPDATE messages, see RFC 2136, optionally
+	// signed with a TSIG key to the primary DNS server of the zone.
+	ProviderRFC2136 ProviderType = "rfc2136"
+)
+
+// ProviderConfig is the configuration of a single DDNS provider.
+type ProviderConfig struct {
+	// APIURL, if not nil, overrides the address of the API of the Cloudflare
+	// and DuckDNS providers.
+	APIURL *url.URL
+
+	// Name is the name of the provider used in logs and in the status.
+	Name string
+
+	// Type is the type of the provider.
+	Type ProviderType
+
+	// Hostname is the hostname, which records are updated.  For DuckDNS, it's
+	// either the subdomain or the full hostname within duckdns.org.
+	Hostname string
+
+	// Token is the API token for Cloudflare or the account token for DuckDNS.
+	Token string
+
+	// ZoneID is the ID of the Cloudflare zone containing Hostname.
+	ZoneID string
+
+	// Server is the address of the DNS server accepting the updates for
+	// RFC 2136, for example "192.0.2.1:53".
+	Server string
+
+	// Zone is the zone containing Hostname for RFC 2136.
+	Zone string
+
+	// TSIGKeyName is the name of the TSIG key for RFC 2136.  If empty, the
+	// updates aren't signed.
+	TSIGKeyName string
+
+	// TSIGAlgorithm is the algorithm of the TSIG key, for example
+	// "hmac-sha256".  If empty, "hmac-sha256" is used.
+	TSIGAlgorithm string
+
+	// TSIGSecret is the base64-encoded secret of the TSIG key.
+	TSIGSecret string
+
+	// TTL is the TTL of the records.  If zero, [DefaultTTL] is used for
+	// RFC 2136 and the automatic TTL is used for Cloudflare.
+	TTL time.Duration
+}
+
+// DefaultTTL is the TTL of the records sent through RFC 2136, when the
+// provider has no TTL.
+const DefaultTTL = 5 * time.Minute
+
+// provider updates the address records of a hostname.
+type provider interface {
+	// update sets the records of the hostname to ipv4 and ipv6.  The invalid
+	// addresses are ignored.
+	update(ctx context.Context, ipv4, ipv6 netip.Addr) (err error)
+}
+
+// providerState is a provider with the result of its last update.
+type providerState struct {
+	// provider updates the records.
+	provider provider
+
+	// conf is the configuration of the provider.
+	conf *ProviderConfig
+
+	// updated is the time of the last successful update.
+	updated time.Time
+
+	// err is the error of the last update, if any.
+	err error
+
+	// ipv4 is the IPv4 address of the last successful update, if any.
+	ipv4 netip.Addr
+
+	// ipv6 is the IPv6 address of the last successful update, if any.
+	ipv6 netip.Addr
+}
+
+// newProvider returns a new provider for conf.
+func newProvider(conf *ProviderConfig, cli *http.Client) (p provider, err error) {
+	if conf.Name == "" {
+		return nil, errors.Error("name: no value")
+	}
+
+	err = netutil.ValidateHostname(strings.TrimSuffix(conf.Hostname, "."))
+	if err != nil {
+		return nil, fmt.Errorf("hostname: %w", err)
+	} else if conf.TTL < 0 {
+		return nil, fmt.Errorf("ttl: must not be negative, got %s", conf.TTL)
+	}
+
+	if conf.APIURL != nil {
+		err = validateHTTPURL(conf.APIURL)
+		if err != nil {
+			return nil, fmt.Errorf("api url: %w", err)
+		}
+	}
+
+	switch conf.Type {
+	case ProviderCloudflare:
+		return newCloudflare(conf, cli)
+	case ProviderDuckDNS:
+		return newDuckDNS(conf, cli)
+	case ProviderRFC2136:
+		return newRFC2136(conf)
+	default:
+		return nil, fmt.Errorf("type: bad value %q", conf.Type)
+	}
+}
+
+// cloudflareAPIURL is the default address of the Cloudflare API.
+const cloudflareAPIURL = "https://api.cloudflare.com/client/v4"
+
+// maxAPIRespSize is the maximum size of the responses of the APIs.
+const maxAPIRespSize = 64 * 1024
+
+// cloudflare updates the records through the Cloudflare API.
+type cloudflare struct {
+	// client is used to send the requests.
+	client *http.Client
+
+	// recordsURL is the address of the DNS records of the zone.
+	recordsURL *url.URL
+
+	// token is the API token.
+	token string
+
+	// hostname is the hostname, which records are updated.
+	hostname string
+
+	// ttl is the TTL of the records in seconds, where 1 means automatic.
+	ttl uint32
+}
+
+// type check
+var _ provider = (*cloudflare)(nil)
+
+// newCloudflare returns a new Cloudflare provider for conf.
+func newCloudflare(conf *ProviderConfig, cli *http.Client) (p *cloudflare, err error) {
+	switch {
+	case conf.Token == "":
+		return nil, errors.Error("token: no value")
+	case conf.ZoneID == "":
+		return nil, errors.Error("zone id: no value")
+	}
+
+	apiURL := conf.APIURL
+	if apiURL == nil {
+		apiURL, err = url.Parse(cloudflareAPIURL)
+		if err != nil {
+			// Shouldn't happen, since the default URL is valid.
+			panic(err)
+		}
+	}
+
+	// Automatic TTL in Cloudflare.
+	ttl := uint32(1)
+	if conf.TTL > 0 {
+		ttl = uint32(conf.TTL.Seconds())
+	}
+
+	return &cloudflare{
+		client:     cli,
+		recordsURL: apiURL.JoinPath("zones", conf.ZoneID, "dns_records"),
+		token:      conf.Token,
+		hostname:   strings.TrimSuffix(conf.Hostname, "."),
+		ttl:        ttl,
+	}, nil
+}
+
+// cloudflareRecord is a DNS record in the Cloudflare API.
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content"`
+	TTL     uint32 `json:"ttl,omitempty"`
+}
+
+// cloudflareResp is the common envelope of the responses of the Cloudflare
+// API.
+type cloudflareResp struct {
+	Result  json.RawMessage    `json:"result"`
+	Errors  []*cloudflareError `json:"errors"`
+	Success bool               `json:"success"`
+}
+
+// cloudflareError is an error in the response of the Cloudflare API.
+type cloudflareError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// update implements the [provider] interface for *cloudflare.
+func (p *cloudflare) update(ctx context.Context, ipv4, ipv6 netip.Addr) (err error) {
+	var errs []error
+	for _, rec := range []struct {
+		ip    netip.Addr
+		rrTyp string
+	}{{
+		ip:    ipv4,
+		rrTyp: "A",
+	}, {
+		ip:    ipv6,
+		rrTyp: "AAAA",
+	}} {
+		if !rec.ip.IsValid() {
+			continue
+		}
+
+		err = p.updateRecord(ctx, rec.rrTyp, rec.ip)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s record: %w", rec.rrTyp, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// updateRecord sets the record of the type rrTyp to ip, creating it if
+// necessary.
+func (p *cloudflare) updateRecord(ctx context.Context, rrTyp string, ip netip.Addr) (err error) {
+	listURL := *p.recordsURL
+	listURL.RawQuery = url.Values{
+		"type": []string{rrTyp},
+		"name": []string{p.hostname},
+	}.Encode()
+
+	var recs []*cloudflareRecord
+	err = p.do(ctx, http.MethodGet, &listURL, nil, &recs)
+	if err != nil {
+		return fmt.Errorf("listing: %w", err)
+	}
+
+	content := ip.String()
+	if len(recs) == 0 {
+		return p.do(ctx, http.MethodPost, p.recordsURL, &cloudflareRecord{
+			Type:    rrTyp,
+			Name:    p.hostname,
+			Content: content,
+			TTL:     p.ttl,
+		}, nil)
+	} else if recs[0].Content == content {
+		return nil
+	}
+
+	return p.do(ctx, http.MethodPatch, p.recordsURL.JoinPath(recs[0].ID), &cloudflareRecord{
+		Content: content,
+		TTL:     p.ttl,
+	}, nil)
+}
+
+// do sends the request with the JSON-encoded body, if any, to u and decodes the
+// result into res, if it's not nil.
+func (p *cloudflare) do(ctx context.Context, method string, u *url.URL, body, res any) (err error) {
+	var r io.Reader
+	if body != nil {
+		var data []byte
+		data, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.Authorization, "Bearer "+p.token)
+	if body != nil {
+		req.Header.Set(httphdr.ContentType, aghhttp.HdrValApplicationJSON)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	cfResp := &cloudflareResp{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxAPIRespSize)).Decode(cfResp)
+	if err != nil {
+		return fmt.Errorf("decoding response with status code %d: %w", resp.StatusCode, err)
+	}
+
+	if !cfResp.Success {
+		if len(cfResp.Errors) > 0 {
+			e := cfResp.Errors[0]
+
+			return fmt.Errorf("api error %d: %s", e.Code, e.Message)
+		}
+
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if res == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(cfResp.Result, res)
+	if err != nil {
+		return fmt.Errorf("decoding result: %w", err)
+	}
+
+	return nil
+}
+
+// duckDNSAPIURL is the default address of the DuckDNS update API.
+const duckDNSAPIURL = "https://www.duckdns.org/update"
+
+// duckDNSSuffix is the suffix of the DuckDNS hostnames.
+const duckDNSSuffix = ".duckdns.org"
+
+// duckDNS updates the subdomain of duckdns.org.
+type duckDNS struct {
+	// client is used to send the requests.
+	client *http.Client
+
+	// apiURL is the address of the update API.
+	apiURL *url.URL
+
+	// token is the account token.
+	token string
+
+	// domain is the subdomain of duckdns.org.
+	domain string
+}
+
+// type check
+var _ provider = (*duckDNS)(nil)
+
+// newDuckDNS returns a new DuckDNS provider for conf.
+func newDuckDNS(conf *ProviderConfig, cli *http.Client) (p *duckDNS, err error) {
+	if conf.Token == "" {
+		return nil, errors.Error("token: no value")
+	}
+
+	apiURL := conf.APIURL
+	if apiURL == nil {
+		apiURL, err = url.Parse(duckDNSAPIURL)
+		if err != nil {
+			// Shouldn't happen, since the default URL is valid.
+			panic(err)
+		}
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(conf.Hostname, "."))
+	domain = strings.TrimSuffix(domain, duckDNSSuffix)
+	if strings.Contains(domain, ".") {
+		return nil, fmt.Errorf("hostname: %q is not a subdomain of duckdns.org", conf.Hostname)
+	}
+
+	return &duckDNS{
+		client: cli,
+		apiURL: apiURL,
+		token:  conf.Token,
+		domain: domain,
+	}, nil
+}
+
+// update implements the [provider] interface for *duckDNS.
+func (p *duckDNS) update(ctx context.Context, ipv4, ipv6 netip.Addr) (err error) {
+	q := url.Values{
+		"domains": []string{p.domain},
+		"token":   []string{p.token},
+	}
+
+	if ipv4.IsValid() {
+		q.Set("ip", ipv4.String())
+	}
+
+	if ipv6.IsValid() {
+		q.Set("ipv6", ipv6.String())
+	}
+
+	u := *p.apiURL
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// Don't expose the token from the URL.
+		urlErr := &url.Error{}
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIRespSize))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if res := strings.TrimSpace(string(body)); res != "OK" {
+		return fmt.Errorf("unexpected response %q", res)
+	}
+
+	return nil
+}
+
+// tsigAlgorithms are the supported TSIG algorithms by their names.
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// tsigFudge is the permitted difference between the clocks of AdGuard Home and
+// the DNS server in seconds.
+const tsigFudge = 300
+
+// rfc2136 sends the DNS UPDATE messages to the primary DNS server of the zone.
+type rfc2136 struct {
+	// server is the address of the DNS server.
+	server string
+
+	// zone is the FQDN of the zone.
+	zone string
+
+	// hostname is the FQDN of the hostname, which records are updated.
+	hostname string
+
+	// keyName is the FQDN of the TSIG key, if any.
+	keyName string
+
+	// algorithm is the FQDN of the algorithm of the TSIG key.
+	algorithm string
+
+	// secret is the base64-encoded secret of the TSIG key.
+	secret string
+
+	// ttl is the TTL of the records in seconds.
+	ttl uint32
+}
+
+// type check
+var _ provider = (*rfc2136)(nil)
+
+// newRFC2136 returns a new RFC 2136 provider for conf.
+func newRFC2136(conf *ProviderConfig) (p *rfc2136, err error) {
+	_, _, err = net.SplitHostPort(conf.Server)
+	if err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+
+	if conf.Zone == "" {
+		return nil, errors.Error("zone: no value")
+	}
+
+	p = &rfc2136{
+		server:   conf.Server,
+		zone:     dns.CanonicalName(conf.Zone),
+		hostname: dns.CanonicalName(conf.Hostname),
+		ttl:      uint32(DefaultTTL.Seconds()),
+	}
+
+	if !dns.IsSubDomain(p.zone, p.hostname) {
+		return nil, fmt.Errorf("hostname: %q is not within zone %q", conf.Hostname, conf.Zone)
+	}
+
+	if conf.TTL > 0 {
+		p.ttl = uint32(conf.TTL.Seconds())
+	}
+
+	if conf.TSIGKeyName == "" {
+		return p, nil
+	}
+
+	alg := conf.TSIGAlgorithm
+	if alg == "" {
+		alg = "hmac-sha256"
+	}
+
+	var ok bool
+	p.algorithm, ok = tsigAlgorithms[strings.ToLower(strings.TrimSuffix(alg, "."))]
+	if !ok {
+		return nil, fmt.Errorf("tsig algorithm: bad value %q", alg)
+	}
+
+	if conf.TSIGSecret == "" {
+		return nil, errors.Error("tsig secret: no value")
+	}
+
+	_, err = base64.StdEncoding.DecodeString(conf.TSIGSecret)
+	if err != nil {
+		return nil, fmt.Errorf("tsig secret: %w", err)
+	}
+
+	p.keyName, p.secret = dns.CanonicalName(conf.TSIGKeyName), conf.TSIGSecret
+
+	return p, nil
+}
+
+// update implements the [provider] interface for *rfc2136.
+func (p *rfc2136) update(ctx context.Context, ipv4, ipv6 netip.Addr) (err error) {
+	m := &dns.Msg{}
+	m.SetUpdate(p.zone)
+
+	if ipv4.IsValid() {
+		hdr := dns.RR_Header{Name: p.hostname, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: p.ttl}
+		m.RemoveRRset([]dns.RR{&dns.A{Hdr: hdr}})
+		m.Insert([]dns.RR{&dns.A{Hdr: hdr, A: ipv4.AsSlice()}})
+	}
+
+	if ipv6.IsValid() {
+		hdr := dns.RR_Header{Name: p.hostname, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: p.ttl}
+		m.RemoveRRset([]dns.RR{&dns.AAAA{Hdr: hdr}})
+		m.Insert([]dns.RR{&dns.AAAA{Hdr: hdr, AAAA: ipv6.AsSlice()}})
+	}
+
+	c := &dns.Client{}
+	if p.keyName != "" {
+		m.SetTsig(p.keyName, p.algorithm, tsigFudge, time.Now().Unix())
+		c.TsigSecret = map[string]string{p.keyName: p.secret}
+	}
+
+	resp, _, err := c.ExchangeContext(ctx, m, p.server)
+	if err != nil {
+		return fmt.Errorf("exchanging with %s: %w", p.server, err)
+	} else if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("server %s responded with %s", p.server, dns.RcodeToString[resp.Rcode])
+	}
+
+	return nil
+}
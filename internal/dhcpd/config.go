@@ -3,6 +3,7 @@ package dhcpd
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
 	"time"
 
@@ -17,9 +18,27 @@ type ServerConfig struct {
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func() `yaml:"-"`
 
+	// PoolExhausted, if not nil, is called when the DHCPv4 server has no IP
+	// addresses left to offer to a new client.
+	PoolExhausted func() `yaml:"-"`
+
+	// LeaseEvent, if not nil, is called when a dynamic lease is created,
+	// renewed, or expired.  It must not block.
+	LeaseEvent func(e *LeaseEvent) `yaml:"-"`
+
+	// Fingerprint, if not nil, is called with the fingerprint of each DHCPv4
+	// client acknowledged by the server.  It's called outside of the locked
+	// sections.
+	Fingerprint func(fp *Fingerprint) `yaml:"-"`
+
 	// Register an HTTP handler
 	HTTPRegister aghhttp.RegisterFunc `yaml:"-"`
 
+	// HTTPClient is the client used to request the leases from the peer, see
+	// [LeaseSyncConfig].  It must not be nil if the synchronization is
+	// enabled.
+	HTTPClient *http.Client `yaml:"-"`
+
 	Enabled       bool   `yaml:"enabled"`
 	InterfaceName string `yaml:"interface_name"`
 
@@ -31,9 +50,27 @@ type ServerConfig struct {
 	// [Interface.Enabled].
 	LocalDomainName string `yaml:"local_domain_name"`
 
+	// HostnameConflict is the policy of resolving the conflicts between the
+	// hostnames requested by the DHCPv4 clients.  If empty,
+	// [HostnameConflictKeep] is used.
+	HostnameConflict HostnameConflict `yaml:"hostname_conflict"`
+
 	Conf4 V4ServerConf `yaml:"dhcpv4"`
 	Conf6 V6ServerConf `yaml:"dhcpv6"`
 
+	// Scopes are the additional DHCPv4 scopes served on the other network
+	// interfaces.
+	Scopes []*ScopeConfig `yaml:"scopes"`
+
+	// LeaseSync is the configuration of the synchronization of the leases
+	// with the peer server.
+	LeaseSync *LeaseSyncConfig `yaml:"lease_sync"`
+
+	// LeaseHook, if not empty, is the executable and its arguments, which is
+	// run with each [LeaseEvent] as a JSON object on its standard input.  The
+	// command isn't run by a shell.
+	LeaseHook []string `yaml:"lease_hook"`
+
 	// WorkDir is used to store DHCP leases.
 	//
 	// Deprecated:  Remove it when migration of DHCP leases will not be needed.
@@ -50,6 +87,10 @@ type ServerConfig struct {
 type DHCPServer interface {
 	// ResetLeases resets leases.
 	ResetLeases(leases []*Lease) (err error)
+	// MergeLeases merges the dynamic leases of the peer server into the
+	// current ones and returns the number of the changed leases.  See
+	// [LeaseSyncConfig].
+	MergeLeases(leases []*Lease) (n int)
 	// GetLeases returns deep clones of the current leases.
 	GetLeases(flags GetLeasesFlags) (leases []*Lease)
 	// AddStaticLease - add a static lease
@@ -82,6 +123,27 @@ type DHCPServer interface {
 	// Stop - stop server
 	Stop() (err error)
 	getLeasesRef() []*Lease
+
+	// subnets returns the networks the server leases the addresses in.
+	subnets() (nets []netip.Prefix)
+
+	// delegatedPrefixes returns the copies of the IPv6 prefixes delegated to
+	// the requesting routers.
+	delegatedPrefixes() (pls []*prefixLease)
+}
+
+// ScopeConfig is the configuration of an additional DHCPv4 scope, which is
+// served on its own network interface independently of the main one.
+type ScopeConfig struct {
+	// InterfaceName is the name of the network interface to serve the scope
+	// on.  It must differ from the ones of the main server and of the other
+	// scopes.
+	InterfaceName string `yaml:"interface_name"`
+
+	// Conf4 is the configuration of the scope's DHCPv4 server.  Its networks
+	// must not overlap with the networks of the main server and of the other
+	// scopes.
+	Conf4 V4ServerConf `yaml:"dhcpv4"`
 }
 
 // V4ServerConf - server configuration
@@ -114,7 +176,11 @@ type V4ServerConf struct {
 	//
 	// Option with IP data (only 1 IP is supported):
 	//     DEC_CODE ip IP_ADDR
-	Options []string `yaml:"options" json:"-"`
+	Options []string `yaml:"options" json:"options"`
+
+	// RelayPools are the address pools for the clients in the networks behind
+	// the DHCP relay agents.
+	RelayPools []*V4RelayPool `yaml:"relay_pools" json:"-"`
 
 	ipRange *ipRange
 
@@ -132,6 +198,83 @@ type V4ServerConf struct {
 	// TODO(a.garipov): This is utter madness and must be refactored.  It just
 	// begs for deadlock bugs and other nastiness.
 	notify func(uint32)
+
+	// leaseEvent, if not nil, is called when a dynamic lease is created or
+	// renewed.
+	leaseEvent leaseEventFunc
+
+	// fingerprint, if not nil, is called with the fingerprint of each
+	// acknowledged client.
+	fingerprint func(fp *Fingerprint)
+
+	// hostnameConflict is the policy of resolving the conflicts between the
+	// hostnames requested by the clients.
+	hostnameConflict HostnameConflict
+}
+
+// V4RelayPool is the configuration of an address pool for the DHCPv4 clients in
+// a network behind a DHCP relay agent, for example in another VLAN.  A relayed
+// request is served from the pool if its giaddr is within the pool's network
+// and its Relay Agent Information option, see RFC 3046, contains the configured
+// identifiers.
+type V4RelayPool struct {
+	// Name is the human-readable name of the pool used in logs.
+	Name string `yaml:"name"`
+
+	// CircuitID is the value of the Agent Circuit ID sub-option the relayed
+	// requests must contain.  If empty, the sub-option isn't checked.
+	CircuitID string `yaml:"circuit_id"`
+
+	// RemoteID is the value of the Agent Remote ID sub-option the relayed
+	// requests must contain.  If empty, the sub-option isn't checked.
+	RemoteID string `yaml:"remote_id"`
+
+	// GatewayIP is the IP address of the router in the pool's network.
+	GatewayIP netip.Addr `yaml:"gateway_ip"`
+
+	// SubnetMask is the subnet mask of the pool's network.
+	SubnetMask netip.Addr `yaml:"subnet_mask"`
+
+	// RangeStart is the first IP address for the dynamic leases.
+	RangeStart netip.Addr `yaml:"range_start"`
+
+	// RangeEnd is the last IP address for the dynamic leases.
+	RangeEnd netip.Addr `yaml:"range_end"`
+
+	// ipRange is the range of the dynamic leases pre-calculated from
+	// RangeStart and RangeEnd.
+	ipRange *ipRange
+
+	// subnet is the pool's network.  The IP is the IP of the gateway.
+	subnet netip.Prefix
+}
+
+// validate returns an error if p is not a valid relay pool configuration.
+// others are the networks of the already validated pools, which the pool's
+// network must not overlap.
+func (p *V4RelayPool) validate(others []netip.Prefix) (err error) {
+	if p == nil {
+		return errNilConfig
+	}
+
+	p.subnet, p.ipRange, err = validateV4Network(
+		p.GatewayIP,
+		p.SubnetMask,
+		p.RangeStart,
+		p.RangeEnd,
+	)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	for _, o := range others {
+		if p.subnet.Overlaps(o) {
+			return fmt.Errorf("network %s overlaps network %s", p.subnet, o)
+		}
+	}
+
+	return nil
 }
 
 // errNilConfig is an error returned by validation method if the config is nil.
@@ -148,78 +291,108 @@ func ensureV4(ip netip.Addr, kind string) (ip4 netip.Addr, err error) {
 	return ip4, nil
 }
 
-// Validate returns an error if c is not a valid configuration.
-//
-// TODO(e.burkov):  Don't set the config fields when the server itself will stop
-// containing the config.
-func (c *V4ServerConf) Validate() (err error) {
-	defer func() { err = errors.Annotate(err, "dhcpv4: %w") }()
-
-	if c == nil {
-		return errNilConfig
-	}
-
-	gatewayIP, err := ensureV4(c.GatewayIP, "address")
+// validateV4Network returns the network and the range of the dynamic addresses
+// built from the given values.  err is not nil if they don't form a valid
+// address pool.  The IP of subnet is the gateway IP.
+func validateV4Network(
+	gwIP netip.Addr,
+	mask netip.Addr,
+	start netip.Addr,
+	end netip.Addr,
+) (subnet netip.Prefix, r *ipRange, err error) {
+	gatewayIP, err := ensureV4(gwIP, "address")
 	if err != nil {
-		// Don't wrap the error since it's informative enough as is and there is
-		// an annotation deferred already.
-		return err
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Prefix{}, nil, err
 	}
 
-	subnetMask, err := ensureV4(c.SubnetMask, "subnet mask")
+	subnetMask, err := ensureV4(mask, "subnet mask")
 	if err != nil {
-		// Don't wrap the error since it's informative enough as is and there is
-		// an annotation deferred already.
-		return err
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Prefix{}, nil, err
 	}
 	maskLen, _ := net.IPMask(subnetMask.AsSlice()).Size()
 
-	c.subnet = netip.PrefixFrom(gatewayIP, maskLen)
-	c.broadcastIP = aghnet.BroadcastFromPref(c.subnet)
+	subnet = netip.PrefixFrom(gatewayIP, maskLen)
 
-	rangeStart, err := ensureV4(c.RangeStart, "address")
+	rangeStart, err := ensureV4(start, "address")
 	if err != nil {
-		// Don't wrap the error since it's informative enough as is and there is
-		// an annotation deferred already.
-		return err
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Prefix{}, nil, err
 	}
 
-	rangeEnd, err := ensureV4(c.RangeEnd, "address")
+	rangeEnd, err := ensureV4(end, "address")
 	if err != nil {
-		// Don't wrap the error since it's informative enough as is and there is
-		// an annotation deferred already.
-		return err
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Prefix{}, nil, err
 	}
 
-	c.ipRange, err = newIPRange(rangeStart.AsSlice(), rangeEnd.AsSlice())
+	r, err = newIPRange(rangeStart.AsSlice(), rangeEnd.AsSlice())
 	if err != nil {
-		// Don't wrap the error since it's informative enough as is and there is
-		// an annotation deferred already.
-		return err
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Prefix{}, nil, err
 	}
 
-	if c.ipRange.contains(gatewayIP.AsSlice()) {
-		return fmt.Errorf("gateway ip %v in the ip range: %v-%v",
+	if r.contains(gatewayIP.AsSlice()) {
+		return netip.Prefix{}, nil, fmt.Errorf("gateway ip %v in the ip range: %v-%v",
 			gatewayIP,
-			c.RangeStart,
-			c.RangeEnd,
+			start,
+			end,
 		)
 	}
 
-	if !c.subnet.Contains(rangeStart) {
-		return fmt.Errorf("range start %v is outside network %v",
-			c.RangeStart,
-			c.subnet,
+	if !subnet.Contains(rangeStart) {
+		return netip.Prefix{}, nil, fmt.Errorf("range start %v is outside network %v",
+			start,
+			subnet,
 		)
 	}
 
-	if !c.subnet.Contains(rangeEnd) {
-		return fmt.Errorf("range end %v is outside network %v",
-			c.RangeEnd,
-			c.subnet,
+	if !subnet.Contains(rangeEnd) {
+		return netip.Prefix{}, nil, fmt.Errorf("range end %v is outside network %v",
+			end,
+			subnet,
 		)
 	}
 
+	return subnet, r, nil
+}
+
+// Validate returns an error if c is not a valid configuration.
+//
+// TODO(e.burkov):  Don't set the config fields when the server itself will stop
+// containing the config.
+func (c *V4ServerConf) Validate() (err error) {
+	defer func() { err = errors.Annotate(err, "dhcpv4: %w") }()
+
+	if c == nil {
+		return errNilConfig
+	}
+
+	c.subnet, c.ipRange, err = validateV4Network(
+		c.GatewayIP,
+		c.SubnetMask,
+		c.RangeStart,
+		c.RangeEnd,
+	)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is and there is
+		// an annotation deferred already.
+		return err
+	}
+
+	c.broadcastIP = aghnet.BroadcastFromPref(c.subnet)
+
+	subnets := []netip.Prefix{c.subnet}
+	for i, p := range c.RelayPools {
+		err = p.validate(subnets)
+		if err != nil {
+			return fmt.Errorf("relay pool at index %d: %w", i, err)
+		}
+
+		subnets = append(subnets, p.subnet)
+	}
+
 	return nil
 }
 
@@ -234,8 +407,21 @@ type V6ServerConf struct {
 
 	LeaseDuration uint32 `yaml:"lease_duration" json:"lease_duration"` // in seconds
 
-	RASLAACOnly  bool `yaml:"ra_slaac_only" json:"-"`  // send ICMPv6.RA packets without MO flags
-	RAAllowSLAAC bool `yaml:"ra_allow_slaac" json:"-"` // send ICMPv6.RA packets with MO flags
+	RASLAACOnly  bool `yaml:"ra_slaac_only" json:"ra_slaac_only"`   // send ICMPv6.RA packets without MO flags
+	RAAllowSLAAC bool `yaml:"ra_allow_slaac" json:"ra_allow_slaac"` // send ICMPv6.RA packets with MO flags
+
+	// PDPrefix is the prefix, the smaller prefixes of which are delegated to
+	// the requesting routers.  Prefix delegation is disabled if it's not set.
+	PDPrefix netip.Prefix `yaml:"pd_prefix" json:"pd_prefix"`
+
+	// PDLength is the length of the delegated prefixes.  If it's zero,
+	// defaultPDLength is used.
+	PDLength int `yaml:"pd_length" json:"pd_length"`
+
+	// Options are the custom DHCPv6 options in the same format as the DHCPv4
+	// ones, except that the code is in range [1..65535] and only the hex, ip,
+	// ips, and text types are supported.  IP addresses must be IPv6.
+	Options []string `yaml:"options" json:"options"`
 
 	ipStart    net.IP        // starting IP address for dynamic leases
 	leaseTime  time.Duration // the time during which a dynamic lease is considered valid
@@ -243,4 +429,8 @@ type V6ServerConf struct {
 
 	// Server calls this function when leases data changes
 	notify func(uint32)
+
+	// leaseEvent, if not nil, is called when a dynamic lease is created or
+	// renewed.
+	leaseEvent leaseEventFunc
 }
@@ -61,9 +61,12 @@ func (s *server) dbLoad() (err error) {
 		}
 	}
 
-	err = s.srv4.ResetLeases(leases4)
-	if err != nil {
-		return fmt.Errorf("resetting dhcpv4 leases: %w", err)
+	bySrv := s.splitLeases4(leases4)
+	for _, srv := range s.servers4() {
+		err = srv.ResetLeases(bySrv[srv])
+		if err != nil {
+			return fmt.Errorf("resetting dhcpv4 leases: %w", err)
+		}
 	}
 
 	if s.srv6 != nil {
@@ -85,8 +88,9 @@ func (s *server) dbStore() (err error) {
 	// "null" into the database file if leases are empty.
 	leases := []*Lease{}
 
-	leases4 := s.srv4.getLeasesRef()
-	leases = append(leases, leases4...)
+	for _, srv := range s.servers4() {
+		leases = append(leases, srv.getLeasesRef()...)
+	}
 
 	if s.srv6 != nil {
 		leases6 := s.srv6.getLeasesRef()
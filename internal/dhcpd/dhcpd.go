@@ -49,6 +49,23 @@ type Lease struct {
 
 	// IsStatic defines if the lease is static.
 	IsStatic bool `json:"static"`
+
+	// Options are the DHCP options sent to the client in addition to the ones
+	// of the server.  Only used for static leases.  See the documentation of
+	// the server options for the format.
+	Options []string `json:"options,omitempty"`
+}
+
+// prefixLease is an IPv6 prefix delegated to a requesting router.
+type prefixLease struct {
+	// Expiry is the expiration time of the delegation.
+	Expiry time.Time
+
+	// HWAddr is the MAC address of the router.
+	HWAddr net.HardwareAddr
+
+	// Prefix is the delegated prefix.
+	Prefix netip.Prefix
 }
 
 // Clone returns a deep copy of l.
@@ -63,6 +80,7 @@ func (l *Lease) Clone() (clone *Lease) {
 		HWAddr:   slices.Clone(l.HWAddr),
 		IP:       l.IP,
 		IsStatic: l.IsStatic,
+		Options:  slices.Clone(l.Options),
 	}
 }
 
@@ -139,6 +157,7 @@ const (
 	LeaseChangedRemovedAll
 
 	LeaseChangedDBStore
+	LeaseChangedPoolExhausted
 )
 
 // GetLeasesFlags are the flags for GetLeases.
@@ -179,10 +198,9 @@ type Interface interface {
 	// due to an assumption that a DHCP client must always have an IP address.
 	HostByIP(ip netip.Addr) (host string)
 
-	// IPByHost returns the IP address of the DHCP client with the given
-	// hostname.  The address will be netip.Addr{} if there is no such client,
-	// due to an assumption that a DHCP client must always have an IP address.
-	IPByHost(host string) (ip netip.Addr)
+	// IPsByHost returns the IPv4 and IPv6 addresses of the DHCP client with
+	// the given hostname.  ips is empty if there is no such client.
+	IPsByHost(host string) (ips []netip.Addr)
 
 	WriteDiskConfig(c *ServerConfig)
 }
@@ -192,12 +210,28 @@ type server struct {
 	srv4 DHCPServer
 	srv6 DHCPServer
 
+	// scopes are the DHCPv4 servers of the additional scopes, see
+	// [ScopeConfig].
+	scopes []DHCPServer
+
 	// TODO(a.garipov): Either create a separate type for the internal config or
 	// just put the config values into Server.
 	conf *ServerConfig
 
 	// Called when the leases DB is modified
 	onLeaseChanged []OnLeaseChangedT
+
+	// syncDone is closed when the synchronization of the leases with the
+	// peer is stopping.  It's nil if the synchronization isn't running.
+	syncDone chan struct{}
+
+	// eventsDone is closed when the handling of the lease events is stopping.
+	// It's nil if the handling isn't running.
+	eventsDone chan struct{}
+
+	// leaseHookQueue are the lease events waiting for the lease hook to run.
+	// It's nil if there is no lease hook.
+	leaseHookQueue chan *LeaseEvent
 }
 
 // type check
@@ -206,21 +240,43 @@ var _ Interface = (*server)(nil)
 // Create initializes and returns the DHCP server handling both address
 // families.  It also registers the corresponding HTTP API endpoints.
 func Create(conf *ServerConfig) (s *server, err error) {
+	err = conf.LeaseSync.validate()
+	if err != nil {
+		return nil, fmt.Errorf("lease_sync: %w", err)
+	}
+
+	err = conf.HostnameConflict.validate()
+	if err != nil {
+		return nil, fmt.Errorf("hostname_conflict: %w", err)
+	}
+
 	s = &server{
 		conf: &ServerConfig{
 			ConfigModified: conf.ConfigModified,
+			PoolExhausted:  conf.PoolExhausted,
+			LeaseEvent:     conf.LeaseEvent,
+			Fingerprint:    conf.Fingerprint,
 
 			HTTPRegister: conf.HTTPRegister,
+			HTTPClient:   conf.HTTPClient,
 
 			Enabled:       conf.Enabled,
 			InterfaceName: conf.InterfaceName,
 
-			LocalDomainName: conf.LocalDomainName,
+			LocalDomainName:  conf.LocalDomainName,
+			HostnameConflict: conf.HostnameConflict,
+
+			LeaseSync: conf.LeaseSync,
+			LeaseHook: conf.LeaseHook,
 
 			dbFilePath: filepath.Join(conf.DataDir, dataFilename),
 		},
 	}
 
+	if len(conf.LeaseHook) > 0 {
+		s.leaseHookQueue = make(chan *LeaseEvent, leaseHookQueueSize)
+	}
+
 	// TODO(e.burkov):  Don't register handlers, see TODO on
 	// [aghhttp.RegisterFunc].
 	s.registerHandlers()
@@ -238,6 +294,18 @@ func Create(conf *ServerConfig) (s *server, err error) {
 		return nil, fmt.Errorf("neither dhcpv4 nor dhcpv6 srv is configured")
 	}
 
+	s.scopes, err = s.createScopes(
+		conf.Scopes,
+		s.conf.Enabled,
+		s.conf.InterfaceName,
+		s.srv4.subnets(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating scopes: %w", err)
+	}
+
+	s.conf.Scopes = conf.Scopes
+
 	// Migrate leases db if needed.
 	err = migrateDB(conf)
 	if err != nil {
@@ -262,6 +330,9 @@ func (s *server) setServers(conf *ServerConfig) (v4Enabled, v6Enabled bool, err
 	v4conf := conf.Conf4
 	v4conf.InterfaceName = s.conf.InterfaceName
 	v4conf.notify = s.onNotify
+	v4conf.leaseEvent = s.onLeaseEvent
+	v4conf.fingerprint = s.conf.Fingerprint
+	v4conf.hostnameConflict = s.conf.HostnameConflict
 	v4conf.Enabled = s.conf.Enabled && v4conf.RangeStart.IsValid()
 
 	s.srv4, err = v4Create(&v4conf)
@@ -276,6 +347,7 @@ func (s *server) setServers(conf *ServerConfig) (v4Enabled, v6Enabled bool, err
 	v6conf := conf.Conf6
 	v6conf.InterfaceName = s.conf.InterfaceName
 	v6conf.notify = s.onNotify
+	v6conf.leaseEvent = s.onLeaseEvent
 	v6conf.Enabled = s.conf.Enabled && len(v6conf.RangeStart) != 0
 
 	s.srv6, err = v6Create(v6conf)
@@ -293,9 +365,11 @@ func (s *server) Enabled() (ok bool) {
 
 // resetLeases resets all leases in the lease database.
 func (s *server) resetLeases() (err error) {
-	err = s.srv4.ResetLeases(nil)
-	if err != nil {
-		return err
+	for _, srv := range s.servers4() {
+		err = srv.ResetLeases(nil)
+		if err != nil {
+			return err
+		}
 	}
 
 	if s.srv6 != nil {
@@ -310,12 +384,19 @@ func (s *server) resetLeases() (err error) {
 
 // server calls this function after DB is updated
 func (s *server) onNotify(flags uint32) {
-	if flags == LeaseChangedDBStore {
+	switch flags {
+	case LeaseChangedDBStore:
 		err := s.dbStore()
 		if err != nil {
 			log.Error("updating db: %s", err)
 		}
 
+		return
+	case LeaseChangedPoolExhausted:
+		if s.conf.PoolExhausted != nil {
+			s.conf.PoolExhausted()
+		}
+
 		return
 	}
 
@@ -333,6 +414,10 @@ func (s *server) WriteDiskConfig(c *ServerConfig) {
 	c.Enabled = s.conf.Enabled
 	c.InterfaceName = s.conf.InterfaceName
 	c.LocalDomainName = s.conf.LocalDomainName
+	c.HostnameConflict = s.conf.HostnameConflict
+	c.LeaseSync = s.conf.LeaseSync
+	c.LeaseHook = s.conf.LeaseHook
+	c.Scopes = s.conf.Scopes
 
 	s.srv4.WriteDiskConfig4(&c.Conf4)
 	s.srv6.WriteDiskConfig6(&c.Conf6)
@@ -340,9 +425,11 @@ func (s *server) WriteDiskConfig(c *ServerConfig) {
 
 // Start will listen on port 67 and serve DHCP requests.
 func (s *server) Start() (err error) {
-	err = s.srv4.Start()
-	if err != nil {
-		return err
+	for _, srv := range s.servers4() {
+		err = srv.Start()
+		if err != nil {
+			return err
+		}
 	}
 
 	err = s.srv6.Start()
@@ -350,14 +437,37 @@ func (s *server) Start() (err error) {
 		return err
 	}
 
+	if ls := s.conf.LeaseSync; s.conf.Enabled && ls != nil && ls.Enabled && s.syncDone == nil {
+		s.syncDone = make(chan struct{})
+		go s.syncLeasesLoop(ls, s.conf.HTTPClient, s.syncDone)
+	}
+
+	if s.conf.Enabled && s.eventsDone == nil &&
+		(s.conf.LeaseEvent != nil || s.leaseHookQueue != nil) {
+		s.eventsDone = make(chan struct{})
+		go s.leaseEventsLoop(s.eventsDone)
+	}
+
 	return nil
 }
 
 // Stop closes the listening UDP socket
 func (s *server) Stop() (err error) {
-	err = s.srv4.Stop()
-	if err != nil {
-		return err
+	if s.syncDone != nil {
+		close(s.syncDone)
+		s.syncDone = nil
+	}
+
+	if s.eventsDone != nil {
+		close(s.eventsDone)
+		s.eventsDone = nil
+	}
+
+	for _, srv := range s.servers4() {
+		err = srv.Stop()
+		if err != nil {
+			return err
+		}
 	}
 
 	err = s.srv6.Stop()
@@ -368,9 +478,18 @@ func (s *server) Stop() (err error) {
 	return nil
 }
 
+// allLeases returns the copies of all the leases of all the servers.
+func (s *server) allLeases() (leases []*Lease) {
+	for _, srv := range s.servers4() {
+		leases = append(leases, srv.GetLeases(LeasesAll)...)
+	}
+
+	return append(leases, s.srv6.GetLeases(LeasesAll)...)
+}
+
 // Leases returns the list of active DHCP leases.
 func (s *server) Leases() (leases []*dhcpsvc.Lease) {
-	ls := append(s.srv4.GetLeases(LeasesAll), s.srv6.GetLeases(LeasesAll)...)
+	ls := s.allLeases()
 	leases = make([]*dhcpsvc.Lease, len(ls))
 	for i, l := range ls {
 		leases[i] = &dhcpsvc.Lease{
@@ -389,31 +508,84 @@ func (s *server) Leases() (leases []*dhcpsvc.Lease) {
 // one.
 func (s *server) MACByIP(ip netip.Addr) (mac net.HardwareAddr) {
 	if ip.Is4() {
-		return s.srv4.FindMACbyIP(ip)
+		return s.srv4ByIP(ip).FindMACbyIP(ip)
 	}
 
 	return s.srv6.FindMACbyIP(ip)
 }
 
-// HostByIP implements the [Interface] interface for *server.
-//
-// TODO(e.burkov):  Implement this method for DHCPv6.
+// HostByIP implements the [Interface] interface for *server.  The DHCPv6
+// clients without their own hostnames are resolved into the hostnames of the
+// DHCPv4 leases with the same MAC addresses.
 func (s *server) HostByIP(ip netip.Addr) (host string) {
 	if ip.Is4() {
-		return s.srv4.HostByIP(ip)
+		return s.srv4ByIP(ip).HostByIP(ip)
+	}
+
+	host = s.srv6.HostByIP(ip)
+	if host != "" {
+		return host
+	}
+
+	mac := s.srv6.FindMACbyIP(ip)
+	if mac == nil {
+		return ""
+	}
+
+	for _, l := range s.leases4ByMAC(mac) {
+		if l.Hostname != "" {
+			return l.Hostname
+		}
 	}
 
 	return ""
 }
 
-// IPByHost implements the [Interface] interface for *server.
-//
-// TODO(e.burkov):  Implement this method for DHCPv6.
-func (s *server) IPByHost(host string) (ip netip.Addr) {
-	return s.srv4.IPByHost(host)
+// IPsByHost implements the [Interface] interface for *server.  The DHCPv6
+// leases of the clients registered by DHCPv4 are matched by their MAC
+// addresses.
+func (s *server) IPsByHost(host string) (ips []netip.Addr) {
+	var mac net.HardwareAddr
+	for _, srv := range s.servers4() {
+		ip := srv.IPByHost(host)
+		if ip.IsValid() {
+			ips = append(ips, ip)
+			mac = srv.FindMACbyIP(ip)
+
+			break
+		}
+	}
+
+	if ip := s.srv6.IPByHost(host); ip.IsValid() {
+		return append(ips, ip)
+	} else if mac == nil {
+		return ips
+	}
+
+	now := time.Now()
+	for _, l := range s.srv6.GetLeases(LeasesDynamic) {
+		if l.isActive(now) && slices.Equal(l.HWAddr, mac) {
+			return append(ips, l.IP)
+		}
+	}
+
+	return ips
+}
+
+// leases4ByMAC returns the active DHCPv4 leases of the client with mac.
+func (s *server) leases4ByMAC(mac net.HardwareAddr) (leases []*Lease) {
+	for _, srv := range s.servers4() {
+		for _, l := range srv.GetLeases(LeasesAll) {
+			if slices.Equal(l.HWAddr, mac) {
+				leases = append(leases, l)
+			}
+		}
+	}
+
+	return leases
 }
 
 // AddStaticLease - add static v4 lease
 func (s *server) AddStaticLease(l *Lease) error {
-	return s.srv4.AddStaticLease(l)
+	return s.srv4ByIP(l.IP).AddStaticLease(l)
 }
@@ -0,0 +1,22 @@
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+)
+
+// Fingerprint is the information, which a DHCPv4 client discloses about itself
+// in its requests and which helps to identify the kind of the device.
+type Fingerprint struct {
+	// HWAddr is the MAC address of the client.
+	HWAddr net.HardwareAddr
+
+	// IP is the IP address leased to the client.
+	IP netip.Addr
+
+	// VendorClass is the vendor class identifier, option 60.
+	VendorClass string
+
+	// Params is the parameter request list, option 55, as is.
+	Params []byte
+}
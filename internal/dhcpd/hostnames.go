@@ -0,0 +1,51 @@
+package dhcpd
+
+import (
+	"fmt"
+	"time"
+)
+
+// HostnameConflict is the policy of resolving the conflicts between the
+// hostnames requested by the DHCP clients.
+type HostnameConflict string
+
+// Hostname conflict policies.
+const (
+	// HostnameConflictKeep means that the hostname stays with the client,
+	// which has registered it first, and the other client is assigned a
+	// generated hostname.  It's the default policy.
+	HostnameConflictKeep HostnameConflict = "keep"
+
+	// HostnameConflictReplace means that the hostname is taken over by the
+	// client, which has requested it last, unless it belongs to a static
+	// lease.
+	HostnameConflictReplace HostnameConflict = "replace"
+)
+
+// validate returns an error if p is not a valid policy.  An empty policy is
+// the same as [HostnameConflictKeep].
+func (p HostnameConflict) validate() (err error) {
+	switch p {
+	case "", HostnameConflictKeep, HostnameConflictReplace:
+		return nil
+	default:
+		return fmt.Errorf("bad policy %q", p)
+	}
+}
+
+// canTake returns true if the hostname of holder may be reassigned to another
+// client at now under policy p.  The hostnames of the expired dynamic leases
+// are always released.
+func (p HostnameConflict) canTake(holder *Lease, now time.Time) (ok bool) {
+	if holder.IsStatic {
+		return false
+	}
+
+	return p == HostnameConflictReplace || !holder.Expiry.After(now)
+}
+
+// isActive returns true if l may be resolved by its hostname or IP address at
+// now, which is when it's static or hasn't expired yet.
+func (l *Lease) isActive(now time.Time) (ok bool) {
+	return l.IsStatic || l.Expiry.After(now)
+}
@@ -0,0 +1,166 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostnameConflict_validate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		policy     HostnameConflict
+		wantErrMsg string
+	}{{
+		name:       "empty",
+		policy:     "",
+		wantErrMsg: "",
+	}, {
+		name:       "keep",
+		policy:     HostnameConflictKeep,
+		wantErrMsg: "",
+	}, {
+		name:       "replace",
+		policy:     HostnameConflictReplace,
+		wantErrMsg: "",
+	}, {
+		name:       "bad",
+		policy:     "bad",
+		wantErrMsg: `bad policy "bad"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.policy.validate())
+		})
+	}
+}
+
+func TestV4Server_commitLease_hostnameConflict(t *testing.T) {
+	const hostname = "conflict"
+
+	testCases := []struct {
+		name     string
+		policy   HostnameConflict
+		isStatic bool
+		expired  bool
+		wantNew  bool
+	}{{
+		name:     "keep",
+		policy:   HostnameConflictKeep,
+		isStatic: false,
+		expired:  false,
+		wantNew:  false,
+	}, {
+		name:     "keep_expired",
+		policy:   HostnameConflictKeep,
+		isStatic: false,
+		expired:  true,
+		wantNew:  true,
+	}, {
+		name:     "replace",
+		policy:   HostnameConflictReplace,
+		isStatic: false,
+		expired:  false,
+		wantNew:  true,
+	}, {
+		name:     "replace_static",
+		policy:   HostnameConflictReplace,
+		isStatic: true,
+		expired:  false,
+		wantNew:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := defaultV4ServerConf()
+			conf.hostnameConflict = tc.policy
+
+			s, err := v4Create(conf)
+			require.NoError(t, err)
+
+			expiry := time.Now().Add(time.Hour)
+			if tc.expired {
+				expiry = time.Now().Add(-time.Hour)
+			}
+
+			holder := &Lease{
+				Expiry:   expiry,
+				Hostname: hostname,
+				HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+				IP:       netip.MustParseAddr("192.168.10.101"),
+				IsStatic: tc.isStatic,
+			}
+			require.NoError(t, s.addLease(holder))
+
+			l := &Lease{
+				HWAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02},
+				IP:     netip.MustParseAddr("192.168.10.102"),
+			}
+			require.NoError(t, s.addLease(l))
+
+			s.commitLease(l, hostname)
+
+			if tc.wantNew {
+				assert.Equal(t, hostname, l.Hostname)
+				assert.Empty(t, holder.Hostname)
+				assert.Equal(t, l.IP, s.IPByHost(hostname))
+			} else {
+				assert.NotEqual(t, hostname, l.Hostname)
+				assert.Equal(t, hostname, holder.Hostname)
+			}
+		})
+	}
+}
+
+func TestServer_dualStackHosts(t *testing.T) {
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		Conf4:          *defaultV4ServerConf(),
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+	})
+	require.NoError(t, err)
+
+	srv4, ok := s.srv4.(*v4Server)
+	require.True(t, ok)
+
+	srv6, ok := s.srv6.(*v6Server)
+	require.True(t, ok)
+
+	mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	ip4 := netip.MustParseAddr("192.168.10.150")
+	ip6 := netip.MustParseAddr("2001::150")
+
+	now := time.Now()
+	require.NoError(t, srv4.addLease(&Lease{
+		Expiry:   now.Add(time.Hour),
+		Hostname: "dual",
+		HWAddr:   mac,
+		IP:       ip4,
+	}))
+
+	l6 := &Lease{
+		Expiry: now.Add(time.Hour),
+		HWAddr: mac,
+		IP:     ip6,
+	}
+	srv6.addLease(l6)
+
+	assert.Equal(t, []netip.Addr{ip4, ip6}, s.IPsByHost("dual"))
+	assert.Equal(t, "dual", s.HostByIP(ip4))
+	assert.Equal(t, "dual", s.HostByIP(ip6))
+
+	l6.Expiry = now.Add(-time.Hour)
+
+	assert.Equal(t, []netip.Addr{ip4}, s.IPsByHost("dual"))
+	assert.Empty(t, s.HostByIP(ip6))
+	assert.Empty(t, s.IPsByHost("unknown"))
+}
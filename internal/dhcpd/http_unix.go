@@ -15,7 +15,6 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
-	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
@@ -27,6 +26,7 @@ type v4ServerConfJSON struct {
 	SubnetMask    netip.Addr `json:"subnet_mask"`
 	RangeStart    netip.Addr `json:"range_start"`
 	RangeEnd      netip.Addr `json:"range_end"`
+	Options       []string   `json:"options"`
 	LeaseDuration uint32     `json:"lease_duration"`
 }
 
@@ -40,34 +40,137 @@ func (j *v4ServerConfJSON) toServerConf() *V4ServerConf {
 		SubnetMask:    j.SubnetMask,
 		RangeStart:    j.RangeStart,
 		RangeEnd:      j.RangeEnd,
+		Options:       j.Options,
 		LeaseDuration: j.LeaseDuration,
 	}
 }
 
-type v6ServerConfJSON struct {
+// scopeJSON is the JSON form of an additional DHCPv4 scope, see
+// [ScopeConfig].
+type scopeJSON struct {
+	InterfaceName string     `json:"interface_name"`
+	GatewayIP     netip.Addr `json:"gateway_ip"`
+	SubnetMask    netip.Addr `json:"subnet_mask"`
 	RangeStart    netip.Addr `json:"range_start"`
+	RangeEnd      netip.Addr `json:"range_end"`
+	Options       []string   `json:"options"`
 	LeaseDuration uint32     `json:"lease_duration"`
 }
 
+// scopesToJSON converts the configurations of the additional scopes to their
+// JSON form.
+func scopesToJSON(confs []*ScopeConfig) (scopes []*scopeJSON) {
+	// Don't return nil, since the front-end expects an array.
+	scopes = make([]*scopeJSON, 0, len(confs))
+	for _, c := range confs {
+		scopes = append(scopes, &scopeJSON{
+			InterfaceName: c.InterfaceName,
+			GatewayIP:     c.Conf4.GatewayIP,
+			SubnetMask:    c.Conf4.SubnetMask,
+			RangeStart:    c.Conf4.RangeStart,
+			RangeEnd:      c.Conf4.RangeEnd,
+			Options:       c.Conf4.Options,
+			LeaseDuration: c.Conf4.LeaseDuration,
+		})
+	}
+
+	return scopes
+}
+
+// toScopeConfig converts j to the configuration of the additional scope.  The
+// fields not configurable via web API are set to their default values.
+func (j *scopeJSON) toScopeConfig() (c *ScopeConfig) {
+	if j == nil {
+		return nil
+	}
+
+	return &ScopeConfig{
+		InterfaceName: j.InterfaceName,
+		Conf4: V4ServerConf{
+			GatewayIP:     j.GatewayIP,
+			SubnetMask:    j.SubnetMask,
+			RangeStart:    j.RangeStart,
+			RangeEnd:      j.RangeEnd,
+			LeaseDuration: j.LeaseDuration,
+			ICMPTimeout:   DefaultDHCPTimeoutICMP,
+			Options:       j.Options,
+		},
+	}
+}
+
+type v6ServerConfJSON struct {
+	RangeStart netip.Addr `json:"range_start"`
+
+	// PDPrefix is the prefix to delegate from.  If it's nil, the current
+	// prefix delegation settings are kept.
+	PDPrefix *netip.Prefix `json:"pd_prefix"`
+
+	Options       []string        `json:"options"`
+	LeaseDuration uint32          `json:"lease_duration"`
+	PDLength      int             `json:"pd_length"`
+	RASLAACOnly   aghalg.NullBool `json:"ra_slaac_only"`
+	RAAllowSLAAC  aghalg.NullBool `json:"ra_allow_slaac"`
+}
+
 func v6JSONToServerConf(j *v6ServerConfJSON) V6ServerConf {
 	if j == nil {
 		return V6ServerConf{}
 	}
 
-	return V6ServerConf{
+	c := V6ServerConf{
 		RangeStart:    j.RangeStart.AsSlice(),
+		Options:       j.Options,
 		LeaseDuration: j.LeaseDuration,
 	}
+
+	if j.PDPrefix != nil {
+		c.PDPrefix, c.PDLength = *j.PDPrefix, j.PDLength
+	}
+
+	return c
+}
+
+// nullBoolOr returns the value of nb or def if nb is null.
+func nullBoolOr(nb aghalg.NullBool, def bool) (ok bool) {
+	if nb == aghalg.NBNull {
+		return def
+	}
+
+	return nb == aghalg.NBTrue
+}
+
+// prefixLeaseJSON is the JSON form of the IPv6 prefix delegated to a router.
+type prefixLeaseJSON struct {
+	HWAddr string       `json:"mac"`
+	Prefix netip.Prefix `json:"prefix"`
+	Expiry string       `json:"expires"`
+}
+
+// prefixLeasesToJSON converts the delegated prefixes to their JSON form.
+func prefixLeasesToJSON(pls []*prefixLease) (j []*prefixLeaseJSON) {
+	// Don't return nil, since the front-end expects an array.
+	j = make([]*prefixLeaseJSON, 0, len(pls))
+	for _, pl := range pls {
+		j = append(j, &prefixLeaseJSON{
+			HWAddr: pl.HWAddr.String(),
+			Prefix: pl.Prefix,
+			Expiry: pl.Expiry.Format(time.RFC3339),
+		})
+	}
+
+	return j
 }
 
 // dhcpStatusResponse is the response for /control/dhcp/status endpoint.
 type dhcpStatusResponse struct {
-	IfaceName    string          `json:"interface_name"`
-	V4           V4ServerConf    `json:"v4"`
-	V6           V6ServerConf    `json:"v6"`
-	Leases       []*leaseDynamic `json:"leases"`
-	StaticLeases []*leaseStatic  `json:"static_leases"`
-	Enabled      bool            `json:"enabled"`
+	IfaceName         string             `json:"interface_name"`
+	V4                V4ServerConf       `json:"v4"`
+	V6                V6ServerConf       `json:"v6"`
+	Scopes            []*scopeJSON       `json:"scopes"`
+	Leases            []*leaseDynamic    `json:"leases"`
+	StaticLeases      []*leaseStatic     `json:"static_leases"`
+	DelegatedPrefixes []*prefixLeaseJSON `json:"delegated_prefixes"`
+	Enabled           bool               `json:"enabled"`
 }
 
 // leaseStatic is the JSON form of static DHCP lease.
@@ -75,10 +178,11 @@ type leaseStatic struct {
 	HWAddr   string     `json:"mac"`
 	IP       netip.Addr `json:"ip"`
 	Hostname string     `json:"hostname"`
+	Options  []string   `json:"options,omitempty"`
 }
 
 // leasesToStatic converts list of leases to their JSON form.
-func leasesToStatic(leases []*dhcpsvc.Lease) (static []*leaseStatic) {
+func leasesToStatic(leases []*Lease) (static []*leaseStatic) {
 	static = make([]*leaseStatic, len(leases))
 
 	for i, l := range leases {
@@ -86,6 +190,7 @@ func leasesToStatic(leases []*dhcpsvc.Lease) (static []*leaseStatic) {
 			HWAddr:   l.HWAddr.String(),
 			IP:       l.IP,
 			Hostname: l.Hostname,
+			Options:  l.Options,
 		}
 	}
 
@@ -104,6 +209,7 @@ func (l *leaseStatic) toLease() (lease *Lease, err error) {
 		IP:       l.IP,
 		Hostname: l.Hostname,
 		IsStatic: true,
+		Options:  l.Options,
 	}, nil
 }
 
@@ -116,7 +222,7 @@ type leaseDynamic struct {
 }
 
 // leasesToDynamic converts list of leases to their JSON form.
-func leasesToDynamic(leases []*dhcpsvc.Lease) (dynamic []*leaseDynamic) {
+func leasesToDynamic(leases []*Lease) (dynamic []*leaseDynamic) {
 	dynamic = make([]*leaseDynamic, len(leases))
 
 	for i, l := range leases {
@@ -141,13 +247,14 @@ func (s *server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 		IfaceName: s.conf.InterfaceName,
 		V4:        V4ServerConf{},
 		V6:        V6ServerConf{},
+		Scopes:    scopesToJSON(s.conf.Scopes),
 	}
 
 	s.srv4.WriteDiskConfig4(&status.V4)
 	s.srv6.WriteDiskConfig6(&status.V6)
 
-	leases := s.Leases()
-	slices.SortFunc(leases, func(a, b *dhcpsvc.Lease) (res int) {
+	leases := s.allLeases()
+	slices.SortFunc(leases, func(a, b *Lease) (res int) {
 		if a.IsStatic == b.IsStatic {
 			return 0
 		} else if a.IsStatic {
@@ -157,7 +264,7 @@ func (s *server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 		}
 	})
 
-	dynamicIdx := slices.IndexFunc(leases, func(l *dhcpsvc.Lease) (ok bool) {
+	dynamicIdx := slices.IndexFunc(leases, func(l *Lease) (ok bool) {
 		return !l.IsStatic
 	})
 
@@ -167,6 +274,7 @@ func (s *server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 
 	status.Leases = leasesToDynamic(leases[dynamicIdx:])
 	status.StaticLeases = leasesToStatic(leases[:dynamicIdx])
+	status.DelegatedPrefixes = prefixLeasesToJSON(s.srv6.delegatedPrefixes())
 
 	aghhttp.WriteJSONResponseOK(w, r, status)
 }
@@ -219,10 +327,14 @@ func (s *server) enableDHCP(ifaceName string) (code int, err error) {
 }
 
 type dhcpServerConfigJSON struct {
-	V4            *v4ServerConfJSON `json:"v4"`
-	V6            *v6ServerConfJSON `json:"v6"`
-	InterfaceName string            `json:"interface_name"`
-	Enabled       aghalg.NullBool   `json:"enabled"`
+	V4 *v4ServerConfJSON `json:"v4"`
+	V6 *v6ServerConfJSON `json:"v6"`
+
+	// Scopes, if not nil, replace the current additional scopes.
+	Scopes []*scopeJSON `json:"scopes"`
+
+	InterfaceName string          `json:"interface_name"`
+	Enabled       aghalg.NullBool `json:"enabled"`
 }
 
 func (s *server) handleDHCPSetConfigV4(
@@ -245,12 +357,23 @@ func (s *server) handleDHCPSetConfigV4(
 		notify:      s.onNotify,
 		ICMPTimeout: s.conf.Conf4.ICMPTimeout,
 		Options:     s.conf.Conf4.Options,
+		RelayPools:  s.conf.Conf4.RelayPools,
 	}
 
 	s.srv4.WriteDiskConfig4(c4)
 	v4Conf.notify = c4.notify
+	v4Conf.leaseEvent = s.onLeaseEvent
+	v4Conf.fingerprint = s.conf.Fingerprint
+	v4Conf.hostnameConflict = s.conf.HostnameConflict
 	v4Conf.ICMPTimeout = c4.ICMPTimeout
-	v4Conf.Options = c4.Options
+	v4Conf.RelayPools = c4.RelayPools
+
+	if v4Conf.Options == nil {
+		// Keep the current options, if the request doesn't change them.
+		v4Conf.Options = c4.Options
+	} else if _, err = parseDHCPOptions(v4Conf.Options); err != nil {
+		return nil, false, fmt.Errorf("validating options: %w", err)
+	}
 
 	srv4, err := v4Create(v4Conf)
 
@@ -270,16 +393,25 @@ func (s *server) handleDHCPSetConfigV6(
 		v6Conf.Enabled = false
 	}
 
-	// Don't overwrite the RA/SLAAC settings from the config file.
-	//
-	// TODO(a.garipov): Perhaps include them into the request to allow
-	// changing them from the HTTP API?
-	v6Conf.RASLAACOnly = s.conf.Conf6.RASLAACOnly
-	v6Conf.RAAllowSLAAC = s.conf.Conf6.RAAllowSLAAC
+	// Keep the current settings, which the request doesn't change.
+	c6 := &V6ServerConf{}
+	s.srv6.WriteDiskConfig6(c6)
+
+	v6Conf.RASLAACOnly = nullBoolOr(conf.V6.RASLAACOnly, c6.RASLAACOnly)
+	v6Conf.RAAllowSLAAC = nullBoolOr(conf.V6.RAAllowSLAAC, c6.RAAllowSLAAC)
+
+	if conf.V6.PDPrefix == nil {
+		v6Conf.PDPrefix, v6Conf.PDLength = c6.PDPrefix, c6.PDLength
+	}
+
+	if v6Conf.Options == nil {
+		v6Conf.Options = c6.Options
+	}
 
 	enabled = v6Conf.Enabled
 	v6Conf.InterfaceName = conf.InterfaceName
 	v6Conf.notify = s.onNotify
+	v6Conf.leaseEvent = s.onLeaseEvent
 
 	srv6, err = v6Create(v6Conf)
 
@@ -327,6 +459,13 @@ func (s *server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scopeConfs, scopes, err := s.createScopesFromJSON(conf, srv4)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "bad scopes configuration: %s", err)
+
+		return
+	}
+
 	err = s.Stop()
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusInternalServerError, "stopping dhcp: %s", err)
@@ -335,6 +474,7 @@ func (s *server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.setConfFromJSON(conf, srv4, srv6)
+	s.conf.Scopes, s.scopes = scopeConfs, scopes
 	s.conf.ConfigModified()
 
 	err = s.dbLoad()
@@ -353,6 +493,46 @@ func (s *server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// createScopesFromJSON returns the configurations and the DHCPv4 servers of
+// the additional scopes for the new configuration decoded from JSON.  srv4 is
+// the new main DHCPv4 server, if any.
+func (s *server) createScopesFromJSON(
+	conf *dhcpServerConfigJSON,
+	srv4 DHCPServer,
+) (confs []*ScopeConfig, scopes []DHCPServer, err error) {
+	confs = s.conf.Scopes
+	if conf.Scopes != nil {
+		confs = make([]*ScopeConfig, 0, len(conf.Scopes))
+		for i, j := range conf.Scopes {
+			if j != nil {
+				_, err = parseDHCPOptions(j.Options)
+				if err != nil {
+					return nil, nil, fmt.Errorf("scope at index %d: validating options: %w", i, err)
+				}
+			}
+
+			confs = append(confs, j.toScopeConfig())
+		}
+	}
+
+	if srv4 == nil {
+		srv4 = s.srv4
+	}
+
+	scopes, err = s.createScopes(
+		confs,
+		conf.Enabled == aghalg.NBTrue,
+		conf.InterfaceName,
+		srv4.subnets(),
+	)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, nil, err
+	}
+
+	return confs, scopes, nil
+}
+
 // setConfFromJSON sets configuration parameters in s from the new configuration
 // decoded from JSON.
 func (s *server) setConfFromJSON(conf *dhcpServerConfigJSON, srv4, srv6 DHCPServer) {
@@ -611,13 +791,16 @@ func (s *server) parseLease(r io.Reader) (srv DHCPServer, lease *Lease, err erro
 		return nil, nil, fmt.Errorf("parsing: %w", err)
 	}
 
-	if lease.IP.Is4() {
-		srv = s.srv4
-	} else {
-		srv = s.srv6
+	return s.srvByIP(lease.IP), lease, nil
+}
+
+// srvByIP returns the DHCP server responsible for ip.
+func (s *server) srvByIP(ip netip.Addr) (srv DHCPServer) {
+	if ip.Is4() {
+		return s.srv4ByIP(ip)
 	}
 
-	return srv, lease, nil
+	return s.srv6
 }
 
 // handleDHCPAddStaticLease is the handler for the POST
@@ -665,6 +848,56 @@ func (s *server) handleDHCPUpdateStaticLease(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+// makeStaticJSON is the request body for the POST /control/dhcp/make_static
+// HTTP API.
+type makeStaticJSON struct {
+	// IP is the address of the dynamic lease to convert.
+	IP netip.Addr `json:"ip"`
+}
+
+// handleDHCPMakeStatic is the handler for the POST /control/dhcp/make_static
+// HTTP API.  It converts the active dynamic lease for the IP address into a
+// static lease and responds with the latter.
+func (s *server) handleDHCPMakeStatic(w http.ResponseWriter, r *http.Request) {
+	req := &makeStaticJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	} else if !req.IP.IsValid() {
+		aghhttp.Error(r, w, http.StatusBadRequest, "invalid ip")
+
+		return
+	}
+
+	ip := req.IP.Unmap()
+	srv := s.srvByIP(ip)
+	dynamic := srv.GetLeases(LeasesDynamic)
+	i := slices.IndexFunc(dynamic, func(l *Lease) (ok bool) { return l.IP == ip })
+	if i < 0 {
+		aghhttp.Error(r, w, http.StatusNotFound, "no dynamic lease for %s", ip)
+
+		return
+	}
+
+	dyn := dynamic[i]
+	lease := &Lease{
+		Hostname: dyn.Hostname,
+		HWAddr:   dyn.HWAddr,
+		IP:       dyn.IP,
+	}
+
+	err = srv.AddStaticLease(lease)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, leasesToStatic([]*Lease{lease})[0])
+}
+
 func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 	err := s.Stop()
 	if err != nil {
@@ -680,6 +913,9 @@ func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 
 	s.conf = &ServerConfig{
 		ConfigModified: s.conf.ConfigModified,
+		PoolExhausted:  s.conf.PoolExhausted,
+		LeaseEvent:     s.conf.LeaseEvent,
+		Fingerprint:    s.conf.Fingerprint,
 
 		HTTPRegister: s.conf.HTTPRegister,
 
@@ -689,16 +925,22 @@ func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 		dbFilePath: s.conf.dbFilePath,
 	}
 
+	s.scopes = nil
+	s.leaseHookQueue = nil
+
 	v4conf := &V4ServerConf{
 		LeaseDuration: DefaultDHCPLeaseTTL,
 		ICMPTimeout:   DefaultDHCPTimeoutICMP,
 		notify:        s.onNotify,
+		leaseEvent:    s.onLeaseEvent,
+		fingerprint:   s.conf.Fingerprint,
 	}
 	s.srv4, _ = v4Create(v4conf)
 
 	v6conf := V6ServerConf{
 		LeaseDuration: DefaultDHCPLeaseTTL,
 		notify:        s.onNotify,
+		leaseEvent:    s.onLeaseEvent,
 	}
 	s.srv6, _ = v6Create(v6conf)
 
@@ -715,6 +957,18 @@ func (s *server) handleResetLeases(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleSyncLeases is the handler for the GET /control/dhcp/sync_leases HTTP
+// API.  It returns the active dynamic leases for the peer server, see
+// [LeaseSyncConfig].
+func (s *server) handleSyncLeases(w http.ResponseWriter, r *http.Request) {
+	leases := s.srv4.GetLeases(LeasesDynamic)
+	if s.srv6 != nil {
+		leases = append(leases, s.srv6.GetLeases(LeasesDynamic)...)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &syncLeasesJSON{Leases: leases})
+}
+
 func (s *server) registerHandlers() {
 	if s.conf.HTTPRegister == nil {
 		return
@@ -727,6 +981,8 @@ func (s *server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/add_static_lease", s.handleDHCPAddStaticLease)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/remove_static_lease", s.handleDHCPRemoveStaticLease)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/update_static_lease", s.handleDHCPUpdateStaticLease)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/make_static", s.handleDHCPMakeStatic)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset", s.handleReset)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset_leases", s.handleResetLeases)
+	s.conf.HTTPRegister(http.MethodGet, syncLeasesAPI, s.handleSyncLeases)
 }
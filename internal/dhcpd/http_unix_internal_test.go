@@ -5,10 +5,12 @@ package dhcpd
 import (
 	"bytes"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,11 +23,13 @@ func defaultResponse() *dhcpStatusResponse {
 	conf4.LeaseDuration = 86400
 
 	resp := &dhcpStatusResponse{
-		V4:           *conf4,
-		V6:           V6ServerConf{},
-		Leases:       []*leaseDynamic{},
-		StaticLeases: []*leaseStatic{},
-		Enabled:      true,
+		V4:                *conf4,
+		V6:                V6ServerConf{},
+		Scopes:            []*scopeJSON{},
+		Leases:            []*leaseDynamic{},
+		StaticLeases:      []*leaseStatic{},
+		DelegatedPrefixes: []*prefixLeaseJSON{},
+		Enabled:           true,
 	}
 
 	return resp
@@ -317,3 +321,72 @@ func TestServer_HandleUpdateStaticLease_validation(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_handleDHCPMakeStatic(t *testing.T) {
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		Conf4:          *defaultV4ServerConf(),
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+	})
+	require.NoError(t, err)
+
+	srv4, ok := s.srv4.(*v4Server)
+	require.True(t, ok)
+
+	dynLease := &Lease{
+		Expiry:   time.Now().Add(time.Hour),
+		Hostname: "dynamic-client",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       netip.MustParseAddr("192.168.10.150"),
+	}
+	require.NoError(t, srv4.addLease(dynLease))
+
+	makeStatic := func(t *testing.T, ip netip.Addr) (w *httptest.ResponseRecorder) {
+		t.Helper()
+
+		b := &bytes.Buffer{}
+		err = json.NewEncoder(b).Encode(&makeStaticJSON{IP: ip})
+		require.NoError(t, err)
+
+		r, reqErr := http.NewRequest(http.MethodPost, "", b)
+		require.NoError(t, reqErr)
+
+		w = httptest.NewRecorder()
+		s.handleDHCPMakeStatic(w, r)
+
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
+		w := makeStatic(t, dynLease.IP)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		want := &leaseStatic{
+			HWAddr:   dynLease.HWAddr.String(),
+			IP:       dynLease.IP,
+			Hostname: dynLease.Hostname,
+		}
+
+		got := &leaseStatic{}
+		err = json.NewDecoder(w.Body).Decode(got)
+		require.NoError(t, err)
+
+		assert.Equal(t, want, got)
+
+		resp := defaultResponse()
+		resp.StaticLeases = []*leaseStatic{want}
+
+		checkStatus(t, s, resp)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		w := makeStatic(t, dynLease.IP)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("invalid_ip", func(t *testing.T) {
+		w := makeStatic(t, netip.Addr{})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,194 @@
+package dhcpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// LeaseEventType is the type of a change of a dynamic lease.
+type LeaseEventType string
+
+// Lease event types.
+const (
+	// LeaseEventCreated means that an IP address has been leased to a client,
+	// which had no active lease for it.
+	LeaseEventCreated LeaseEventType = "created"
+
+	// LeaseEventRenewed means that a client has extended its active lease.
+	LeaseEventRenewed LeaseEventType = "renewed"
+
+	// LeaseEventExpired means that a lease hasn't been renewed in time.
+	LeaseEventExpired LeaseEventType = "expired"
+)
+
+// LeaseEvent is a change of a dynamic lease.
+type LeaseEvent struct {
+	// Time is the time of the event.
+	Time time.Time `json:"time"`
+
+	// Expiry is the expiration time of the lease.
+	Expiry time.Time `json:"expires"`
+
+	// Type is the type of the event.
+	Type LeaseEventType `json:"type"`
+
+	// Hostname is the hostname of the client.
+	Hostname string `json:"hostname"`
+
+	// HWAddr is the MAC address of the client.
+	HWAddr string `json:"mac"`
+
+	// IP is the leased IP address.
+	IP netip.Addr `json:"ip"`
+}
+
+// leaseEventFunc is called by the DHCP servers when a dynamic lease is created
+// or renewed.  l must not be modified.
+type leaseEventFunc func(typ LeaseEventType, l *Lease)
+
+// leaseEventType returns the type of the event of committing l, which expired
+// at prevExpiry, at now.
+func leaseEventType(prevExpiry, now time.Time) (typ LeaseEventType) {
+	if prevExpiry.After(now) {
+		return LeaseEventRenewed
+	}
+
+	return LeaseEventCreated
+}
+
+// expiryCheckIvl is the interval between the checks of the expiration of the
+// dynamic leases.
+const expiryCheckIvl = 1 * time.Minute
+
+// leaseHookQueueSize is the maximum number of the events waiting for the lease
+// hook to run.  When it's exceeded, the new events are dropped.
+const leaseHookQueueSize = 16
+
+// leaseHookTimeout is the timeout for a single run of the lease hook.
+const leaseHookTimeout = 10 * time.Second
+
+// onLeaseEvent sends the event of type typ about l to the subscriber and the
+// lease hook, if any.  It must not block, since it's called with the leases of
+// the DHCP server locked.
+func (s *server) onLeaseEvent(typ LeaseEventType, l *Lease) {
+	s.emitLeaseEvent(&LeaseEvent{
+		Time:     time.Now(),
+		Expiry:   l.Expiry,
+		Type:     typ,
+		Hostname: l.Hostname,
+		HWAddr:   l.HWAddr.String(),
+		IP:       l.IP,
+	})
+}
+
+// emitLeaseEvent sends e to the subscriber and the lease hook, if any.
+func (s *server) emitLeaseEvent(e *LeaseEvent) {
+	log.Debug("dhcp: lease %s: %s %s", e.Type, e.IP, e.HWAddr)
+
+	if s.conf.LeaseEvent != nil {
+		s.conf.LeaseEvent(e)
+	}
+
+	if s.leaseHookQueue == nil {
+		return
+	}
+
+	select {
+	case s.leaseHookQueue <- e:
+	default:
+		log.Debug("dhcp: lease hook: queue is full, dropping event")
+	}
+}
+
+// leaseEventsLoop runs the lease hook for the queued events and periodically
+// checks the expiration of the leases until done is closed.  It's intended to
+// be used as a goroutine.
+func (s *server) leaseEventsLoop(done <-chan struct{}) {
+	defer log.OnPanic("dhcp: lease events")
+
+	ticker := time.NewTicker(expiryCheckIvl)
+	defer ticker.Stop()
+
+	active := s.checkExpired(nil, time.Now())
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-s.leaseHookQueue:
+			err := runLeaseHook(s.conf.LeaseHook, e)
+			if err != nil {
+				log.Error("dhcp: lease hook: %s", err)
+			}
+		case now := <-ticker.C:
+			active = s.checkExpired(active, now)
+		}
+	}
+}
+
+// checkExpired emits the events about the leases from active, which have
+// expired by now without being renewed, and returns the dynamic leases, which
+// are active at now, by their IP addresses.  The leases, which have been
+// removed before their expiration, aren't reported.
+func (s *server) checkExpired(
+	active map[netip.Addr]*Lease,
+	now time.Time,
+) (cur map[netip.Addr]*Lease) {
+	cur = map[netip.Addr]*Lease{}
+	for _, srv := range append(s.servers4(), s.srv6) {
+		for _, l := range srv.GetLeases(LeasesDynamic) {
+			if l.Expiry.After(now) {
+				cur[l.IP] = l
+			}
+		}
+	}
+
+	for ip, l := range active {
+		if c, ok := cur[ip]; ok && bytes.Equal(c.HWAddr, l.HWAddr) {
+			continue
+		} else if l.Expiry.After(now) {
+			continue
+		}
+
+		s.emitLeaseEvent(&LeaseEvent{
+			Time:     now,
+			Expiry:   l.Expiry,
+			Type:     LeaseEventExpired,
+			Hostname: l.Hostname,
+			HWAddr:   l.HWAddr.String(),
+			IP:       l.IP,
+		})
+	}
+
+	return cur
+}
+
+// runLeaseHook runs the command argv with e as a JSON object on its standard
+// input.  The command isn't run by a shell.
+func runLeaseHook(argv []string, e *LeaseEvent) (err error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaseHookTimeout)
+	defer cancel()
+
+	// #nosec G204 -- The command is set by the administrator in the
+	// configuration file.
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running command: %w; output: %q", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseEventType(t *testing.T) {
+	now := time.Now()
+
+	assert.Equal(t, LeaseEventCreated, leaseEventType(time.Time{}, now))
+	assert.Equal(t, LeaseEventCreated, leaseEventType(now.Add(-time.Minute), now))
+	assert.Equal(t, LeaseEventRenewed, leaseEventType(now.Add(time.Minute), now))
+}
+
+func TestServer_checkExpired(t *testing.T) {
+	var events []*LeaseEvent
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		Conf4:          *defaultV4ServerConf(),
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+		LeaseEvent: func(e *LeaseEvent) {
+			events = append(events, e)
+		},
+	})
+	require.NoError(t, err)
+
+	srv4, ok := s.srv4.(*v4Server)
+	require.True(t, ok)
+
+	now := time.Now()
+	expiring := &Lease{
+		Expiry:   now.Add(time.Minute),
+		Hostname: "expiring",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+		IP:       netip.MustParseAddr("192.168.10.101"),
+	}
+	renewed := &Lease{
+		Expiry:   now.Add(time.Minute),
+		Hostname: "renewed",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02},
+		IP:       netip.MustParseAddr("192.168.10.102"),
+	}
+
+	require.NoError(t, srv4.addLease(expiring))
+	require.NoError(t, srv4.addLease(renewed))
+
+	active := s.checkExpired(nil, now)
+	require.Len(t, active, 2)
+	require.Empty(t, events)
+
+	expiring.Expiry = now.Add(-time.Second)
+	renewed.Expiry = now.Add(time.Hour)
+
+	later := now.Add(2 * time.Minute)
+	active = s.checkExpired(active, later)
+	require.Len(t, active, 1)
+	require.Len(t, events, 1)
+
+	e := events[0]
+	assert.Equal(t, LeaseEventExpired, e.Type)
+	assert.Equal(t, expiring.IP, e.IP)
+	assert.Equal(t, expiring.HWAddr.String(), e.HWAddr)
+	assert.Equal(t, expiring.Hostname, e.Hostname)
+
+	_ = s.checkExpired(active, later)
+	assert.Len(t, events, 1)
+}
+
+func TestRunLeaseHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "event.json")
+	e := &LeaseEvent{
+		Time:     time.Unix(1700000000, 0).UTC(),
+		Expiry:   time.Unix(1700003600, 0).UTC(),
+		Type:     LeaseEventCreated,
+		Hostname: "host",
+		HWAddr:   "aa:aa:aa:aa:aa:aa",
+		IP:       netip.MustParseAddr("192.168.10.100"),
+	}
+
+	err := runLeaseHook([]string{"sh", "-c", `cat > "$0"`, out}, e)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+
+	got := &LeaseEvent{}
+	err = json.Unmarshal(data, got)
+	require.NoError(t, err)
+
+	assert.Equal(t, e, got)
+
+	err = runLeaseHook([]string{"false"}, e)
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package dhcpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"golang.org/x/exp/slices"
+)
+
+// LeaseSyncConfig is the configuration of the synchronization of the dynamic
+// leases with the peer AdGuard Home instance, which serves DHCP in the same
+// network.  Both servers request the active leases of each other periodically,
+// so that neither of them hands out the addresses leased by the other one.
+type LeaseSyncConfig struct {
+	// PeerURL is the base URL of the peer's web interface, for example
+	// "https://192.168.1.3:3000".
+	PeerURL string `yaml:"peer_url"`
+
+	// Username is the name of the user to authenticate with on the peer.
+	Username string `yaml:"username"`
+
+	// Password is the password of the user to authenticate with on the peer.
+	Password string `yaml:"password"`
+
+	// Interval is the interval between the requests to the peer.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// Enabled defines if the leases are synchronized with the peer.
+	Enabled bool `yaml:"enabled"`
+}
+
+// DefaultLeaseSyncInterval is the default interval between the requests to
+// the peer.
+const DefaultLeaseSyncInterval = 30 * time.Second
+
+// syncLeasesAPI is the path of the HTTP API returning the active dynamic
+// leases for the peer.
+const syncLeasesAPI = "/control/dhcp/sync_leases"
+
+// maxSyncLeasesSize is the maximum size of the peer's response.
+const maxSyncLeasesSize = 16 * 1024 * 1024
+
+// validate returns an error if the lease sync configuration is invalid.
+func (c *LeaseSyncConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	u, err := url.Parse(c.PeerURL)
+	if err != nil {
+		return fmt.Errorf("bad peer_url: %w", err)
+	} else if u.Scheme != aghhttp.SchemeHTTP && u.Scheme != aghhttp.SchemeHTTPS {
+		return fmt.Errorf("bad peer_url scheme %q", u.Scheme)
+	}
+
+	if c.Interval.Duration <= 0 {
+		return fmt.Errorf("interval: %w", errors.Error("must be positive"))
+	}
+
+	return nil
+}
+
+// syncLeasesJSON is the response to the GET /control/dhcp/sync_leases HTTP
+// API.
+type syncLeasesJSON struct {
+	// Leases are the active dynamic leases.
+	Leases []*Lease `json:"leases"`
+}
+
+// mergeLeases returns local merged with the dynamic leases from remote, which
+// are active at now and have IP addresses, for which inRange returns true, and
+// the number of the added or extended leases.  The static leases of local are
+// never replaced.  Of the conflicting leases for the same IP or hardware
+// address, the one expiring later is kept.  The leases of local may be
+// extended, but the leases of remote aren't modified.
+func mergeLeases(
+	local []*Lease,
+	remote []*Lease,
+	now time.Time,
+	inRange func(ip netip.Addr) (ok bool),
+) (merged []*Lease, n int) {
+	merged = slices.Clone(local)
+	for _, r := range remote {
+		if r.IsStatic || r.IsBlocklisted() || !r.Expiry.After(now) || !inRange(r.IP) {
+			continue
+		}
+
+		byIP := slices.IndexFunc(merged, func(l *Lease) (ok bool) { return l.IP == r.IP })
+		byMAC := slices.IndexFunc(merged, func(l *Lease) (ok bool) {
+			return bytes.Equal(l.HWAddr, r.HWAddr)
+		})
+
+		if (byIP >= 0 && merged[byIP].IsStatic) || (byMAC >= 0 && merged[byMAC].IsStatic) {
+			continue
+		}
+
+		if byIP >= 0 && byIP == byMAC {
+			if l := merged[byIP]; r.Expiry.After(l.Expiry) {
+				l.Expiry = r.Expiry
+				n++
+			}
+
+			continue
+		}
+
+		if (byIP >= 0 && !r.Expiry.After(merged[byIP].Expiry)) ||
+			(byMAC >= 0 && !r.Expiry.After(merged[byMAC].Expiry)) {
+			continue
+		}
+
+		merged = slices.DeleteFunc(merged, func(l *Lease) (ok bool) {
+			return l.IP == r.IP || bytes.Equal(l.HWAddr, r.HWAddr)
+		})
+
+		added := r.Clone()
+		if slices.ContainsFunc(merged, func(l *Lease) (ok bool) {
+			return added.Hostname != "" && l.Hostname == added.Hostname
+		}) {
+			added.Hostname = ""
+		}
+
+		merged = append(merged, added)
+		n++
+	}
+
+	return merged, n
+}
+
+// MergeLeases merges the dynamic leases of the peer server into the current
+// ones and stores them, if any have changed.
+func (s *server) MergeLeases(leases []*Lease) (n int) {
+	var leases4, leases6 []*Lease
+	for _, l := range leases {
+		if l.IP.Is4() {
+			leases4 = append(leases4, l)
+		} else {
+			leases6 = append(leases6, l)
+		}
+	}
+
+	for srv, ls := range s.splitLeases4(leases4) {
+		n += srv.MergeLeases(ls)
+	}
+
+	if s.srv6 != nil {
+		n += s.srv6.MergeLeases(leases6)
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	err := s.dbStore()
+	if err != nil {
+		log.Error("dhcp: lease sync: %s", err)
+	}
+
+	s.notify(LeaseChangedAdded)
+
+	return n
+}
+
+// syncLeasesLoop periodically merges the leases of the peer until done is
+// closed.  It's intended to be used as a goroutine.
+func (s *server) syncLeasesLoop(conf *LeaseSyncConfig, cli *http.Client, done <-chan struct{}) {
+	defer log.OnPanic("dhcp: lease sync")
+
+	ticker := time.NewTicker(conf.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), conf.Interval.Duration)
+		leases, err := fetchPeerLeases(ctx, conf, cli)
+		cancel()
+
+		if err != nil {
+			log.Error("dhcp: lease sync: %s", err)
+		} else if n := s.MergeLeases(leases); n > 0 {
+			log.Info("dhcp: lease sync: merged %d leases from %s", n, conf.PeerURL)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// Go on.
+		}
+	}
+}
+
+// fetchPeerLeases requests the active dynamic leases from the peer.
+func fetchPeerLeases(
+	ctx context.Context,
+	conf *LeaseSyncConfig,
+	cli *http.Client,
+) (leases []*Lease, err error) {
+	u, err := url.Parse(conf.PeerURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing url: %w", err)
+	}
+
+	u = u.JoinPath(syncLeasesAPI)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	if conf.Username != "" {
+		req.SetBasicAuth(conf.Username, conf.Password)
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	sl := &syncLeasesJSON{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxSyncLeasesSize)).Decode(sl)
+	if err != nil {
+		return nil, fmt.Errorf("decoding leases: %w", err)
+	}
+
+	return sl.Leases, nil
+}
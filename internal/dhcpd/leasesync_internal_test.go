@@ -0,0 +1,152 @@
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeLeases(t *testing.T) {
+	now := time.Now()
+	inRange := func(ip netip.Addr) (ok bool) {
+		return netip.MustParsePrefix("192.168.0.0/24").Contains(ip)
+	}
+
+	mac1 := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01}
+	mac2 := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02}
+	mac3 := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x03}
+
+	ip1 := netip.MustParseAddr("192.168.0.1")
+	ip2 := netip.MustParseAddr("192.168.0.2")
+	ip3 := netip.MustParseAddr("192.168.0.3")
+
+	newLocal := func() (local []*Lease) {
+		return []*Lease{{
+			Expiry:   now.Add(time.Hour),
+			Hostname: "static",
+			HWAddr:   mac1,
+			IP:       ip1,
+			IsStatic: true,
+		}, {
+			Expiry:   now.Add(time.Hour),
+			Hostname: "dynamic",
+			HWAddr:   mac2,
+			IP:       ip2,
+		}}
+	}
+
+	testCases := []struct {
+		remote  *Lease
+		name    string
+		wantIP  netip.Addr
+		wantMAC net.HardwareAddr
+		wantN   int
+	}{{
+		remote: &Lease{
+			Expiry:   now.Add(time.Hour),
+			Hostname: "new",
+			HWAddr:   mac3,
+			IP:       ip3,
+		},
+		name:    "new",
+		wantIP:  ip3,
+		wantMAC: mac3,
+		wantN:   1,
+	}, {
+		remote: &Lease{
+			Expiry: now.Add(2 * time.Hour),
+			HWAddr: mac3,
+			IP:     ip1,
+		},
+		name:    "static_conflict",
+		wantIP:  ip1,
+		wantMAC: mac1,
+		wantN:   0,
+	}, {
+		remote: &Lease{
+			Expiry: now.Add(2 * time.Hour),
+			HWAddr: mac2,
+			IP:     ip2,
+		},
+		name:    "extended",
+		wantIP:  ip2,
+		wantMAC: mac2,
+		wantN:   1,
+	}, {
+		remote: &Lease{
+			Expiry: now.Add(time.Minute),
+			HWAddr: mac3,
+			IP:     ip2,
+		},
+		name:    "older_conflict",
+		wantIP:  ip2,
+		wantMAC: mac2,
+		wantN:   0,
+	}, {
+		remote: &Lease{
+			Expiry: now.Add(2 * time.Hour),
+			HWAddr: mac3,
+			IP:     ip2,
+		},
+		name:    "newer_conflict",
+		wantIP:  ip2,
+		wantMAC: mac3,
+		wantN:   1,
+	}, {
+		remote: &Lease{
+			Expiry: now.Add(-time.Minute),
+			HWAddr: mac3,
+			IP:     ip3,
+		},
+		name:    "expired",
+		wantIP:  ip3,
+		wantMAC: nil,
+		wantN:   0,
+	}, {
+		remote: &Lease{
+			Expiry: now.Add(time.Hour),
+			HWAddr: mac3,
+			IP:     netip.MustParseAddr("10.0.0.1"),
+		},
+		name:    "out_of_range",
+		wantIP:  netip.MustParseAddr("10.0.0.1"),
+		wantMAC: nil,
+		wantN:   0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, n := mergeLeases(newLocal(), []*Lease{tc.remote}, now, inRange)
+			assert.Equal(t, tc.wantN, n)
+
+			var gotMAC net.HardwareAddr
+			for _, l := range merged {
+				if l.IP == tc.wantIP {
+					gotMAC = l.HWAddr
+				}
+			}
+
+			assert.Equal(t, tc.wantMAC, gotMAC)
+		})
+	}
+
+	t.Run("dup_hostname", func(t *testing.T) {
+		remote := &Lease{
+			Expiry:   now.Add(time.Hour),
+			Hostname: "dynamic",
+			HWAddr:   mac3,
+			IP:       ip3,
+		}
+
+		merged, n := mergeLeases(newLocal(), []*Lease{remote}, now, inRange)
+		require.Equal(t, 1, n)
+		require.Len(t, merged, 3)
+
+		assert.Empty(t, merged[2].Hostname)
+		assert.Equal(t, "dynamic", remote.Hostname)
+	})
+}
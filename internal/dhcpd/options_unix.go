@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 )
 
 // The aliases for DHCP option types available for explicit declaration.
@@ -194,6 +196,25 @@ func parseDHCPOption(s string) (code dhcpv4.OptionCode, val dhcpv4.OptionValue,
 	return dhcpv4.GenericOptionCode(code64), val, nil
 }
 
+// parseDHCPOptions parses the option strings opts.  See [parseDHCPOption] for
+// the format.  parsed is nil if opts are empty.
+func parseDHCPOptions(opts []string) (parsed dhcpv4.Options, err error) {
+	for i, o := range opts {
+		code, val, parseErr := parseDHCPOption(o)
+		if parseErr != nil {
+			return nil, fmt.Errorf("option at index %d: %w", i, parseErr)
+		}
+
+		if parsed == nil {
+			parsed = dhcpv4.Options{}
+		}
+
+		parsed.Update(dhcpv4.Option{Code: code, Value: val})
+	}
+
+	return parsed, nil
+}
+
 // prepareOptions builds the set of DHCP options according to host requirements
 // document and values from conf.
 func (s *v4Server) prepareOptions() {
@@ -396,3 +417,97 @@ func (s *v4Server) prepareOptions() {
 		s.explicitOpts = nil
 	}
 }
+
+// parseDHCPv6OptionIP parses a DHCPv6 option as a single IPv6 address.
+func parseDHCPv6OptionIP(s string) (data []byte, err error) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	} else if !ip.Is6() {
+		return nil, fmt.Errorf("%s is not an ipv6 address", ip)
+	}
+
+	ip16 := ip.As16()
+
+	return ip16[:], nil
+}
+
+// parseDHCPv6OptionData parses the data of a DHCPv6 option considering typ.
+func parseDHCPv6OptionData(typ, valStr string) (data []byte, err error) {
+	switch typ {
+	case typHex:
+		data, err = hex.DecodeString(valStr)
+		if err != nil {
+			return nil, fmt.Errorf("decoding hex: %w", err)
+		}
+	case typIP:
+		data, err = parseDHCPv6OptionIP(valStr)
+	case typIPs:
+		for i, ipStr := range strings.Split(valStr, ",") {
+			var ipData []byte
+			ipData, err = parseDHCPv6OptionIP(ipStr)
+			if err != nil {
+				return nil, fmt.Errorf("parsing ip at index %d: %w", i, err)
+			}
+
+			data = append(data, ipData...)
+		}
+	case typText:
+		data = []byte(valStr)
+	default:
+		err = fmt.Errorf("unknown option type %q", typ)
+	}
+
+	return data, err
+}
+
+// parseDHCPv6Option parses a DHCPv6 option.  The examples of possible option
+// strings:
+//
+//   - 17 hex  0000000a0001000474667470
+//   - 21 ip   2001:db8::1
+//   - 23 ips  2001:db8::1,2001:db8::2
+//   - 59 text tftp://[2001:db8::1]/boot.efi
+func parseDHCPv6Option(s string) (opt dhcpv6.Option, err error) {
+	defer func() { err = errors.Annotate(err, "invalid option string %q: %w", s) }()
+
+	s = strings.TrimSpace(s)
+	parts := strings.SplitN(s, " ", 3)
+	if len(parts) < 3 {
+		return nil, errors.Error("bad option format")
+	}
+
+	code, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("parsing option code: %w", err)
+	}
+
+	data, err := parseDHCPv6OptionData(parts[1], parts[2])
+	if err != nil {
+		// Don't wrap an error since it's informative enough as is and there
+		// also the deferred annotation.
+		return nil, err
+	}
+
+	return &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionCode(code),
+		OptionData: data,
+	}, nil
+}
+
+// parseDHCPv6Options parses the DHCPv6 option strings opts.  See
+// [parseDHCPv6Option] for the format.
+func parseDHCPv6Options(opts []string) (parsed []dhcpv6.Option, err error) {
+	for i, o := range opts {
+		var opt dhcpv6.Option
+		opt, err = parseDHCPv6Option(o)
+		if err != nil {
+			return nil, fmt.Errorf("option at index %d: %w", i, err)
+		}
+
+		parsed = append(parsed, opt)
+	}
+
+	return parsed, nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -173,6 +174,72 @@ func TestParseOpt(t *testing.T) {
 	}
 }
 
+func TestParseDHCPv6Option(t *testing.T) {
+	testCases := []struct {
+		want       dhcpv6.Option
+		name       string
+		in         string
+		wantErrMsg string
+	}{{
+		want: &dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionBootfileURL,
+			OptionData: []byte("tftp://[2001:db8::1]/boot.efi"),
+		},
+		name:       "text_success",
+		in:         "59 text tftp://[2001:db8::1]/boot.efi",
+		wantErrMsg: "",
+	}, {
+		want: &dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionDNSRecursiveNameServer,
+			OptionData: append(
+				net.ParseIP("2001:db8::1").To16(),
+				net.ParseIP("2001:db8::2").To16()...,
+			),
+		},
+		name:       "ips_success",
+		in:         "23 ips 2001:db8::1,2001:db8::2",
+		wantErrMsg: "",
+	}, {
+		want: &dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionVendorOpts,
+			OptionData: []byte{0x00, 0x00, 0x00, 0x0A},
+		},
+		name:       "hex_success",
+		in:         "17 hex 0000000a",
+		wantErrMsg: "",
+	}, {
+		want:       nil,
+		name:       "ip_fail_v4",
+		in:         "21 ip 192.168.1.1",
+		wantErrMsg: `invalid option string "21 ip 192.168.1.1": 192.168.1.1 is not an ipv6 address`,
+	}, {
+		want:       nil,
+		name:       "bad_type",
+		in:         "21 u8 1",
+		wantErrMsg: `invalid option string "21 u8 1": unknown option type "u8"`,
+	}, {
+		want: nil,
+		name: "bad_code",
+		in:   "65536 text a",
+		wantErrMsg: `invalid option string "65536 text a": parsing option code: ` +
+			`strconv.ParseUint: parsing "65536": value out of range`,
+	}, {
+		want:       nil,
+		name:       "del_unsupported",
+		in:         "23 del",
+		wantErrMsg: `invalid option string "23 del": bad option format`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opt, err := parseDHCPv6Option(tc.in)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.want, opt)
+		})
+	}
+}
+
 func TestPrepareOptions(t *testing.T) {
 	oneIP, otherIP := net.IP{1, 2, 3, 4}, net.IP{5, 6, 7, 8}
 
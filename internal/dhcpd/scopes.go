@@ -0,0 +1,108 @@
+package dhcpd
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"golang.org/x/exp/slices"
+)
+
+// createScopes returns the DHCPv4 servers for the additional scopes confs.
+// mainIface and mainNets are the network interface and the networks of the
+// main DHCPv4 server, which the scopes must not use.
+func (s *server) createScopes(
+	confs []*ScopeConfig,
+	enabled bool,
+	mainIface string,
+	mainNets []netip.Prefix,
+) (srvs []DHCPServer, err error) {
+	ifaces := []string{mainIface}
+	nets := slices.Clone(mainNets)
+	for i, sc := range confs {
+		var srv DHCPServer
+		srv, err = s.createScope(sc, enabled, ifaces, nets)
+		if err != nil {
+			return nil, fmt.Errorf("scope at index %d: %w", i, err)
+		}
+
+		ifaces = append(ifaces, sc.InterfaceName)
+		nets = append(nets, srv.subnets()...)
+		srvs = append(srvs, srv)
+	}
+
+	return srvs, nil
+}
+
+// createScope returns the DHCPv4 server for the additional scope sc.  Its
+// network interface must not be one of usedIfaces and its networks must not
+// overlap any of usedNets.
+func (s *server) createScope(
+	sc *ScopeConfig,
+	enabled bool,
+	usedIfaces []string,
+	usedNets []netip.Prefix,
+) (srv DHCPServer, err error) {
+	if sc == nil {
+		return nil, errNilConfig
+	} else if sc.InterfaceName == "" {
+		return nil, errors.Error("no interface_name")
+	} else if slices.Contains(usedIfaces, sc.InterfaceName) {
+		return nil, fmt.Errorf("interface %q is already used", sc.InterfaceName)
+	}
+
+	conf := sc.Conf4
+	conf.InterfaceName = sc.InterfaceName
+	conf.notify = s.onNotify
+	conf.leaseEvent = s.onLeaseEvent
+	conf.fingerprint = s.conf.Fingerprint
+	conf.hostnameConflict = s.conf.HostnameConflict
+	conf.Enabled = enabled
+
+	srv, err = v4Create(&conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	for _, n := range srv.subnets() {
+		for _, used := range usedNets {
+			if n.Overlaps(used) {
+				return nil, fmt.Errorf("network %s overlaps network %s", n, used)
+			}
+		}
+	}
+
+	return srv, nil
+}
+
+// servers4 returns the main DHCPv4 server followed by the servers of the
+// additional scopes.
+func (s *server) servers4() (srvs []DHCPServer) {
+	return append([]DHCPServer{s.srv4}, s.scopes...)
+}
+
+// srv4ByIP returns the DHCPv4 server of the additional scope, which networks
+// contain ip, or the main DHCPv4 server if there is no such scope.
+func (s *server) srv4ByIP(ip netip.Addr) (srv DHCPServer) {
+	for _, srv = range s.scopes {
+		if slices.ContainsFunc(srv.subnets(), func(n netip.Prefix) (ok bool) {
+			return n.Contains(ip)
+		}) {
+			return srv
+		}
+	}
+
+	return s.srv4
+}
+
+// splitLeases4 groups the IPv4 leases by the DHCPv4 servers they belong to.
+func (s *server) splitLeases4(leases []*Lease) (bySrv map[DHCPServer][]*Lease) {
+	bySrv = map[DHCPServer][]*Lease{}
+	for _, l := range leases {
+		srv := s.srv4ByIP(l.IP)
+		bySrv[srv] = append(bySrv[srv], l)
+	}
+
+	return bySrv
+}
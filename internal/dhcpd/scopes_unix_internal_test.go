@@ -0,0 +1,162 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestScope returns an additional scope for the network 192.168.20.0/24 to
+// use in tests.
+func newTestScope() (sc *ScopeConfig) {
+	return &ScopeConfig{
+		InterfaceName: "eth1",
+		Conf4: V4ServerConf{
+			GatewayIP:     netip.MustParseAddr("192.168.20.1"),
+			SubnetMask:    netip.MustParseAddr("255.255.255.0"),
+			RangeStart:    netip.MustParseAddr("192.168.20.100"),
+			RangeEnd:      netip.MustParseAddr("192.168.20.200"),
+			LeaseDuration: 3600,
+		},
+	}
+}
+
+func TestServer_createScopes(t *testing.T) {
+	s := &server{conf: &ServerConfig{}}
+
+	overlapping := newTestScope()
+	overlapping.InterfaceName = "eth2"
+	overlapping.Conf4.GatewayIP = netip.MustParseAddr("192.168.10.250")
+	overlapping.Conf4.SubnetMask = netip.MustParseAddr("255.255.0.0")
+
+	noIface := newTestScope()
+	noIface.InterfaceName = ""
+
+	mainIface := newTestScope()
+	mainIface.InterfaceName = "eth0"
+
+	mainNets := []netip.Prefix{netip.MustParsePrefix("192.168.10.1/24")}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*ScopeConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs:      []*ScopeConfig{newTestScope()},
+	}, {
+		name:       "nil",
+		wantErrMsg: "scope at index 0: nil config",
+		confs:      []*ScopeConfig{nil},
+	}, {
+		name:       "no_iface",
+		wantErrMsg: "scope at index 0: no interface_name",
+		confs:      []*ScopeConfig{noIface},
+	}, {
+		name:       "main_iface",
+		wantErrMsg: `scope at index 0: interface "eth0" is already used`,
+		confs:      []*ScopeConfig{mainIface},
+	}, {
+		name:       "same_iface",
+		wantErrMsg: `scope at index 1: interface "eth1" is already used`,
+		confs:      []*ScopeConfig{newTestScope(), newTestScope()},
+	}, {
+		name: "overlapping",
+		wantErrMsg: "scope at index 0: network 192.168.10.250/16 overlaps " +
+			"network 192.168.10.1/24",
+		confs: []*ScopeConfig{overlapping},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srvs, err := s.createScopes(tc.confs, true, "eth0", mainNets)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg == "" {
+				assert.Len(t, srvs, len(tc.confs))
+			}
+		})
+	}
+}
+
+func TestServer_scopes(t *testing.T) {
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		InterfaceName:  "eth0",
+		Conf4:          *defaultV4ServerConf(),
+		Scopes:         []*ScopeConfig{newTestScope()},
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+	})
+	require.NoError(t, err)
+	require.Len(t, s.scopes, 1)
+
+	scopeLease := &Lease{
+		Hostname: "scoped",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       netip.MustParseAddr("192.168.20.10"),
+	}
+
+	err = s.AddStaticLease(scopeLease)
+	require.NoError(t, err)
+
+	assert.Equal(t, scopeLease.HWAddr, s.MACByIP(scopeLease.IP))
+	assert.Equal(t, scopeLease.Hostname, s.HostByIP(scopeLease.IP))
+	assert.Equal(t, []netip.Addr{scopeLease.IP}, s.IPsByHost(scopeLease.Hostname))
+
+	t.Run("db", func(t *testing.T) {
+		require.NoError(t, s.dbStore())
+		require.NoError(t, s.dbLoad())
+
+		assert.Empty(t, s.srv4.GetLeases(LeasesAll))
+
+		leases := s.scopes[0].GetLeases(LeasesStatic)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, scopeLease.IP, leases[0].IP)
+	})
+
+	t.Run("set_config", func(t *testing.T) {
+		conf := &dhcpServerConfigJSON{
+			Scopes: []*scopeJSON{{
+				InterfaceName: "eth2",
+				GatewayIP:     netip.MustParseAddr("192.168.30.1"),
+				SubnetMask:    netip.MustParseAddr("255.255.255.0"),
+				RangeStart:    netip.MustParseAddr("192.168.30.100"),
+				RangeEnd:      netip.MustParseAddr("192.168.30.200"),
+				Options:       []string{"6 ip 192.168.30.2"},
+				LeaseDuration: 3600,
+			}},
+			Enabled: aghalg.NBFalse,
+		}
+
+		b := &bytes.Buffer{}
+		err = json.NewEncoder(b).Encode(conf)
+		require.NoError(t, err)
+
+		r, reqErr := http.NewRequest(http.MethodPost, "", b)
+		require.NoError(t, reqErr)
+
+		w := httptest.NewRecorder()
+		s.handleDHCPSetConfig(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		got := &ServerConfig{}
+		s.WriteDiskConfig(got)
+		require.Len(t, got.Scopes, 1)
+
+		assert.Equal(t, "eth2", got.Scopes[0].InterfaceName)
+		assert.Equal(t, conf.Scopes[0].Options, got.Scopes[0].Conf4.Options)
+	})
+}
@@ -15,6 +15,7 @@ type winServer struct{}
 var _ DHCPServer = winServer{}
 
 func (winServer) ResetLeases(_ []*Lease) (err error)              { return nil }
+func (winServer) MergeLeases(_ []*Lease) (n int)                  { return 0 }
 func (winServer) GetLeases(_ GetLeasesFlags) (leases []*Lease)    { return nil }
 func (winServer) getLeasesRef() []*Lease                          { return nil }
 func (winServer) AddStaticLease(_ *Lease) (err error)             { return nil }
@@ -27,6 +28,8 @@ func (winServer) Start() (err error)                              { return nil }
 func (winServer) Stop() (err error)                               { return nil }
 func (winServer) HostByIP(_ netip.Addr) (host string)             { return "" }
 func (winServer) IPByHost(_ string) (ip netip.Addr)               { return netip.Addr{} }
+func (winServer) subnets() (nets []netip.Prefix)                  { return nil }
+func (winServer) delegatedPrefixes() (pls []*prefixLease)         { return nil }
 
 func v4Create(_ *V4ServerConf) (s DHCPServer, err error) { return winServer{}, nil }
 func v6Create(_ V6ServerConf) (s DHCPServer, err error)  { return winServer{}, nil }
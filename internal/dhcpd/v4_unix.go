@@ -38,12 +38,13 @@ type v4Server struct {
 	// have intersections with [implicitOpts].
 	explicitOpts dhcpv4.Options
 
-	// leasesLock protects leases, leaseHosts, and leasedOffsets.
+	// leasesLock protects leases, leaseHosts, and the leased offsets of pools.
 	leasesLock sync.Mutex
 
-	// leasedOffsets contains offsets from conf.ipRange.start that have been
-	// leased.
-	leasedOffsets *bitSet
+	// pools are the address pools of the server.  The first one is the pool of
+	// the directly connected network, the rest are the pools for the relay
+	// agents.
+	pools []*v4Pool
 
 	// leases contains all dynamic and static leases.
 	leases []*Lease
@@ -116,24 +117,30 @@ func (s *v4Server) validHostnameForClient(cliHostname string, ip netip.Addr) (ho
 	return hostname
 }
 
-// HostByIP implements the [Interface] interface for *v4Server.
+// HostByIP implements the [Interface] interface for *v4Server.  The expired
+// leases aren't resolved.
 func (s *v4Server) HostByIP(ip netip.Addr) (host string) {
+	now := time.Now()
+
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	if l, ok := s.ipIndex[ip]; ok {
+	if l, ok := s.ipIndex[ip]; ok && l.isActive(now) {
 		return l.Hostname
 	}
 
 	return ""
 }
 
-// IPByHost implements the [Interface] interface for *v4Server.
+// IPByHost implements the [Interface] interface for *v4Server.  The expired
+// leases aren't resolved.
 func (s *v4Server) IPByHost(host string) (ip netip.Addr) {
+	now := time.Now()
+
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	if l, ok := s.hostsIndex[host]; ok {
+	if l, ok := s.hostsIndex[host]; ok && l.isActive(now) {
 		return l.IP
 	}
 
@@ -151,7 +158,17 @@ func (s *v4Server) ResetLeases(leases []*Lease) (err error) {
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	s.leasedOffsets = newBitSet()
+	s.resetLeasesLocked(leases)
+
+	return nil
+}
+
+// resetLeasesLocked replaces the leases of s with leases.  s.leasesLock is
+// expected to be locked.
+func (s *v4Server) resetLeasesLocked(leases []*Lease) {
+	for _, p := range s.pools {
+		p.leasedOffsets = newBitSet()
+	}
 	s.hostsIndex = make(map[string]*Lease, len(leases))
 	s.ipIndex = make(map[netip.Addr]*Lease, len(leases))
 	s.leases = nil
@@ -160,7 +177,7 @@ func (s *v4Server) ResetLeases(leases []*Lease) (err error) {
 		if !l.IsStatic {
 			l.Hostname = s.validHostnameForClient(l.Hostname, l.IP)
 		}
-		err = s.addLease(l)
+		err := s.addLease(l)
 		if err != nil {
 			// TODO(a.garipov): Wrap and bubble up the error.
 			log.Error("dhcpv4: reset: re-adding a lease for %s (%s): %s", l.IP, l.HWAddr, err)
@@ -168,8 +185,32 @@ func (s *v4Server) ResetLeases(leases []*Lease) (err error) {
 			continue
 		}
 	}
+}
 
-	return nil
+// MergeLeases implements the [DHCPServer] interface for *v4Server.
+func (s *v4Server) MergeLeases(leases []*Lease) (n int) {
+	if s.conf == nil || len(s.pools) == 0 {
+		return 0
+	}
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	merged, n := mergeLeases(s.leases, leases, time.Now(), func(ip netip.Addr) (ok bool) {
+		p := s.poolByIP(ip)
+		if p == nil {
+			return false
+		}
+
+		_, ok = p.ipRange.offset(ip.AsSlice())
+
+		return ok
+	})
+	if n > 0 {
+		s.resetLeasesLocked(merged)
+	}
+
+	return n
 }
 
 // getLeasesRef returns the actual leases slice.  For internal use only.
@@ -177,6 +218,21 @@ func (s *v4Server) getLeasesRef() []*Lease {
 	return s.leases
 }
 
+// delegatedPrefixes implements the [DHCPServer] interface for *v4Server.
+// Since DHCPv4 doesn't support prefix delegation, it always returns nil.
+func (s *v4Server) delegatedPrefixes() (pls []*prefixLease) {
+	return nil
+}
+
+// subnets implements the [DHCPServer] interface for *v4Server.
+func (s *v4Server) subnets() (nets []netip.Prefix) {
+	for _, p := range s.pools {
+		nets = append(nets, p.subnet)
+	}
+
+	return nets
+}
+
 // isBlocklisted returns true if this lease holds a blocklisted IP.
 //
 // TODO(a.garipov): Make a method of *Lease?
@@ -267,11 +323,11 @@ func (s *v4Server) rmLeaseByIndex(i int) {
 	l := s.leases[i]
 	s.leases = append(s.leases[:i], s.leases[i+1:]...)
 
-	r := s.conf.ipRange
-	leaseIP := net.IP(l.IP.AsSlice())
-	offset, ok := r.offset(leaseIP)
-	if ok {
-		s.leasedOffsets.set(offset, false)
+	if p := s.poolByIP(l.IP); p != nil {
+		offset, ok := p.ipRange.offset(l.IP.AsSlice())
+		if ok {
+			p.leasedOffsets.set(offset, false)
+		}
 	}
 
 	delete(s.hostsIndex, l.Hostname)
@@ -321,15 +377,19 @@ const (
 
 // addLease adds a dynamic or static lease.
 func (s *v4Server) addLease(l *Lease) (err error) {
-	r := s.conf.ipRange
-	leaseIP := net.IP(l.IP.AsSlice())
-	offset, inOffset := r.offset(leaseIP)
+	p := s.poolByIP(l.IP)
+
+	var offset uint64
+	var inOffset bool
+	if p != nil {
+		offset, inOffset = p.ipRange.offset(l.IP.AsSlice())
+	}
 
 	if l.IsStatic {
 		// TODO(a.garipov, d.seregin): Subnet can be nil when dhcp server is
 		// disabled.
-		if sn := s.conf.subnet; !sn.Contains(l.IP) {
-			return fmt.Errorf("subnet %s does not contain the ip %q", sn, l.IP)
+		if p == nil {
+			return fmt.Errorf("no configured subnet contains the ip %q", l.IP)
 		}
 	} else if !inOffset {
 		return fmt.Errorf("lease %s (%s) out of range, not adding", l.IP, l.HWAddr)
@@ -346,7 +406,9 @@ func (s *v4Server) addLease(l *Lease) (err error) {
 	s.ipIndex[l.IP] = l
 
 	s.leases = append(s.leases, l)
-	s.leasedOffsets.set(offset, true)
+	if inOffset {
+		p.leasedOffsets.set(offset, true)
+	}
 
 	return nil
 }
@@ -389,8 +451,8 @@ func (s *v4Server) AddStaticLease(l *Lease) (err error) {
 
 	if !l.IP.Is4() {
 		return fmt.Errorf("invalid IP %q: only IPv4 is supported", l.IP)
-	} else if gwIP := s.conf.GatewayIP; gwIP == l.IP {
-		return fmt.Errorf("can't assign the gateway IP %q to the lease", gwIP)
+	} else if p := s.poolByIP(l.IP); p != nil && p.subnet.Addr() == l.IP {
+		return fmt.Errorf("can't assign the gateway IP %q to the lease", l.IP)
 	}
 
 	l.IsStatic = true
@@ -401,6 +463,11 @@ func (s *v4Server) AddStaticLease(l *Lease) (err error) {
 		return err
 	}
 
+	_, err = parseDHCPOptions(l.Options)
+	if err != nil {
+		return fmt.Errorf("validating options: %w", err)
+	}
+
 	if hostname := l.Hostname; hostname != "" {
 		hostname, err = normalizeHostname(hostname)
 		if err != nil {
@@ -486,6 +553,11 @@ func (s *v4Server) validateStaticLease(l *Lease) (err error) {
 		return fmt.Errorf("validating hostname: %w", err)
 	}
 
+	_, err = parseDHCPOptions(l.Options)
+	if err != nil {
+		return fmt.Errorf("validating options: %w", err)
+	}
+
 	dup, ok := s.hostsIndex[hostname]
 	if ok && !bytes.Equal(dup.HWAddr, l.HWAddr) {
 		return ErrDupHostname
@@ -498,14 +570,13 @@ func (s *v4Server) validateStaticLease(l *Lease) (err error) {
 
 	l.Hostname = hostname
 
-	if gwIP := s.conf.GatewayIP; gwIP == l.IP {
+	p := s.poolByIP(l.IP)
+	if p == nil {
+		return fmt.Errorf("no configured subnet contains the ip %q", l.IP)
+	} else if gwIP := p.subnet.Addr(); gwIP == l.IP {
 		return fmt.Errorf("can't assign the gateway IP %q to the lease", gwIP)
 	}
 
-	if sn := s.conf.subnet; !sn.Contains(l.IP) {
-		return fmt.Errorf("subnet %s does not contain the ip %q", sn, l.IP)
-	}
-
 	return nil
 }
 
@@ -616,9 +687,9 @@ func (s *v4Server) findLease(mac net.HardwareAddr) (l *Lease) {
 	return nil
 }
 
-// nextIP generates a new free IP.
-func (s *v4Server) nextIP() (ip net.IP) {
-	r := s.conf.ipRange
+// nextIP generates a new free IP from the pool p.
+func (s *v4Server) nextIP(p *v4Pool) (ip net.IP) {
+	r := p.ipRange
 	ip = r.find(func(next net.IP) (ok bool) {
 		offset, ok := r.offset(next)
 		if !ok {
@@ -626,17 +697,17 @@ func (s *v4Server) nextIP() (ip net.IP) {
 			return false
 		}
 
-		return !s.leasedOffsets.isSet(offset)
+		return !p.leasedOffsets.isSet(offset)
 	})
 
 	return ip.To4()
 }
 
-// Find an expired lease and return its index or -1
-func (s *v4Server) findExpiredLease() int {
+// Find an expired lease within the pool p and return its index or -1
+func (s *v4Server) findExpiredLease(p *v4Pool) int {
 	now := time.Now()
 	for i, lease := range s.leases {
-		if !lease.IsStatic && lease.Expiry.Before(now) {
+		if !lease.IsStatic && lease.Expiry.Before(now) && p.subnet.Contains(lease.IP) {
 			return i
 		}
 	}
@@ -644,14 +715,14 @@ func (s *v4Server) findExpiredLease() int {
 	return -1
 }
 
-// reserveLease reserves a lease for a client by its MAC-address.  It returns
-// nil if it couldn't allocate a new lease.
-func (s *v4Server) reserveLease(mac net.HardwareAddr) (l *Lease, err error) {
+// reserveLease reserves a lease for a client by its MAC-address in the pool p.
+// It returns nil if it couldn't allocate a new lease.
+func (s *v4Server) reserveLease(mac net.HardwareAddr, p *v4Pool) (l *Lease, err error) {
 	l = &Lease{HWAddr: slices.Clone(mac)}
 
-	nextIP := s.nextIP()
+	nextIP := s.nextIP(p)
 	if nextIP == nil {
-		i := s.findExpiredLease()
+		i := s.findExpiredLease(p)
 		if i < 0 {
 			return nil, nil
 		}
@@ -683,7 +754,15 @@ func (s *v4Server) commitLease(l *Lease, hostname string) {
 	prev := l.Hostname
 	hostname = s.validHostnameForClient(hostname, l.IP)
 
-	if _, ok := s.hostsIndex[hostname]; ok {
+	now := time.Now()
+	if holder, ok := s.hostsIndex[hostname]; !ok || holder == l {
+		// Go on.
+	} else if s.conf.hostnameConflict.canTake(holder, now) {
+		log.Info("dhcpv4: hostname %q is taken over from %s", hostname, holder.HWAddr)
+
+		holder.Hostname = ""
+		delete(s.hostsIndex, hostname)
+	} else {
 		log.Info("dhcpv4: hostname %q already exists", hostname)
 
 		if prev == "" {
@@ -697,7 +776,11 @@ func (s *v4Server) commitLease(l *Lease, hostname string) {
 		l.Hostname = hostname
 	}
 
-	l.Expiry = time.Now().Add(s.conf.leaseTime)
+	typ := leaseEventType(l.Expiry, now)
+	l.Expiry = now.Add(s.conf.leaseTime)
+	if s.conf.leaseEvent != nil {
+		s.conf.leaseEvent(typ, l)
+	}
 	if prev != "" && prev != l.Hostname {
 		delete(s.hostsIndex, prev)
 	}
@@ -707,11 +790,11 @@ func (s *v4Server) commitLease(l *Lease, hostname string) {
 	s.ipIndex[l.IP] = l
 }
 
-// allocateLease allocates a new lease for the MAC address.  If there are no IP
-// addresses left, both l and err are nil.
-func (s *v4Server) allocateLease(mac net.HardwareAddr) (l *Lease, err error) {
+// allocateLease allocates a new lease for the MAC address in the pool p.  If
+// there are no IP addresses left, both l and err are nil.
+func (s *v4Server) allocateLease(mac net.HardwareAddr, p *v4Pool) (l *Lease, err error) {
 	for {
-		l, err = s.reserveLease(mac)
+		l, err = s.reserveLease(mac, p)
 		if err != nil {
 			return nil, fmt.Errorf("reserving a lease: %w", err)
 		} else if l == nil {
@@ -727,8 +810,9 @@ func (s *v4Server) allocateLease(mac net.HardwareAddr) (l *Lease, err error) {
 	}
 }
 
-// handleDiscover is the handler for the DHCP Discover request.
-func (s *v4Server) handleDiscover(req, resp *dhcpv4.DHCPv4) (l *Lease, err error) {
+// handleDiscover is the handler for the DHCP Discover request.  p is the pool
+// to serve the request from.
+func (s *v4Server) handleDiscover(req, resp *dhcpv4.DHCPv4, p *v4Pool) (l *Lease, err error) {
 	mac := req.ClientHWAddr
 
 	defer s.conf.notify(LeaseChangedDBStore)
@@ -737,6 +821,21 @@ func (s *v4Server) handleDiscover(req, resp *dhcpv4.DHCPv4) (l *Lease, err error
 	defer s.leasesLock.Unlock()
 
 	l = s.findLease(mac)
+	if l != nil && !p.subnet.Contains(l.IP) {
+		if l.IsStatic {
+			return nil, fmt.Errorf("static lease for %s is outside of pool %s", mac, p)
+		}
+
+		// The client has moved to another network, so release its lease in
+		// the previous one.
+		err = s.rmLease(l)
+		if err != nil {
+			return nil, fmt.Errorf("removing lease for %s: %w", mac, err)
+		}
+
+		l = nil
+	}
+
 	if l != nil {
 		reqIP := req.RequestedIPAddress()
 		leaseIP := net.IP(l.IP.AsSlice())
@@ -749,7 +848,7 @@ func (s *v4Server) handleDiscover(req, resp *dhcpv4.DHCPv4) (l *Lease, err error
 		return l, nil
 	}
 
-	l, err = s.allocateLease(mac)
+	l, err = s.allocateLease(mac, p)
 	if err != nil {
 		return nil, err
 	} else if l == nil {
@@ -857,7 +956,12 @@ func (s *v4Server) handleSelecting(
 }
 
 // handleInitReboot handles the DHCPREQUEST generated during INIT-REBOOT state.
-func (s *v4Server) handleInitReboot(req *dhcpv4.DHCPv4, reqIP net.IP) (l *Lease, needsReply bool) {
+// p is the pool to serve the request from.
+func (s *v4Server) handleInitReboot(
+	req *dhcpv4.DHCPv4,
+	reqIP net.IP,
+	p *v4Pool,
+) (l *Lease, needsReply bool) {
 	mac := req.ClientHWAddr
 
 	ip4 := reqIP.To4()
@@ -875,7 +979,7 @@ func (s *v4Server) handleInitReboot(req *dhcpv4.DHCPv4, reqIP net.IP) (l *Lease,
 		return nil, false
 	}
 
-	if !s.conf.subnet.Contains(netip.AddrFrom4([4]byte(ip4))) {
+	if !p.subnet.Contains(netip.AddrFrom4([4]byte(ip4))) {
 		// If the DHCP server detects that the client is on the wrong net then
 		// the server SHOULD send a DHCPNAK message to the client.
 		log.Debug("dhcpv4: wrong subnet in init-reboot req msg for %s: %s", mac, reqIP)
@@ -925,8 +1029,11 @@ func (s *v4Server) handleRenew(req *dhcpv4.DHCPv4) (l *Lease, needsReply bool) {
 }
 
 // handleByRequestType handles the DHCPREQUEST according to the state during
-// which it's generated by client.
-func (s *v4Server) handleByRequestType(req *dhcpv4.DHCPv4) (lease *Lease, needsReply bool) {
+// which it's generated by client.  p is the pool to serve the request from.
+func (s *v4Server) handleByRequestType(
+	req *dhcpv4.DHCPv4,
+	p *v4Pool,
+) (lease *Lease, needsReply bool) {
 	reqIP, sid := req.RequestedIPAddress(), req.ServerIdentifier()
 
 	if sid != nil && !sid.IsUnspecified() {
@@ -939,7 +1046,7 @@ func (s *v4Server) handleByRequestType(req *dhcpv4.DHCPv4) (lease *Lease, needsR
 	if reqIP != nil && !reqIP.IsUnspecified() {
 		// Requested IP address option MUST be filled in with client's notion of
 		// its previously assigned address.
-		return s.handleInitReboot(req, reqIP)
+		return s.handleInitReboot(req, reqIP, p)
 	}
 
 	// Server identifier MUST NOT be filled in, requested IP address option MUST
@@ -950,8 +1057,12 @@ func (s *v4Server) handleByRequestType(req *dhcpv4.DHCPv4) (lease *Lease, needsR
 // handleRequest is the handler for a DHCPREQUEST message.
 //
 // See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.2.
-func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *Lease, needsReply bool) {
-	lease, needsReply = s.handleByRequestType(req)
+func (s *v4Server) handleRequest(
+	req *dhcpv4.DHCPv4,
+	resp *dhcpv4.DHCPv4,
+	p *v4Pool,
+) (lease *Lease, needsReply bool) {
+	lease, needsReply = s.handleByRequestType(req, p)
 	if lease == nil {
 		return nil, needsReply
 	}
@@ -989,8 +1100,9 @@ func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *Lease, needsR
 	return lease, needsReply
 }
 
-// handleDecline is the handler for the DHCP Decline request.
-func (s *v4Server) handleDecline(req, resp *dhcpv4.DHCPv4) (err error) {
+// handleDecline is the handler for the DHCP Decline request.  p is the pool to
+// serve the request from.
+func (s *v4Server) handleDecline(req, resp *dhcpv4.DHCPv4, p *v4Pool) (err error) {
 	s.conf.notify(LeaseChangedDBStore)
 
 	s.leasesLock.Lock()
@@ -1014,7 +1126,7 @@ func (s *v4Server) handleDecline(req, resp *dhcpv4.DHCPv4) (err error) {
 		return fmt.Errorf("removing old lease for %s: %w", mac, err)
 	}
 
-	newLease, err := s.allocateLease(mac)
+	newLease, err := s.allocateLease(mac, p)
 	if err != nil {
 		return fmt.Errorf("allocating new lease for %s: %w", mac, err)
 	} else if newLease == nil {
@@ -1105,8 +1217,14 @@ func (s *v4Server) handleRelease(req, resp *dhcpv4.DHCPv4) (err error) {
 	return nil
 }
 
-// messageHandler describes a DHCPv4 message handler function.
-type messageHandler func(s *v4Server, req, resp *dhcpv4.DHCPv4) (rCode int, l *Lease, err error)
+// messageHandler describes a DHCPv4 message handler function.  p is the pool to
+// serve the request from.
+type messageHandler func(
+	s *v4Server,
+	req *dhcpv4.DHCPv4,
+	resp *dhcpv4.DHCPv4,
+	p *v4Pool,
+) (rCode int, l *Lease, err error)
 
 // messageHandlers is a map of handlers for various messages with message types
 // keys.
@@ -1115,13 +1233,17 @@ var messageHandlers = map[dhcpv4.MessageType]messageHandler{
 		s *v4Server,
 		req *dhcpv4.DHCPv4,
 		resp *dhcpv4.DHCPv4,
+		p *v4Pool,
 	) (rCode int, l *Lease, err error) {
-		l, err = s.handleDiscover(req, resp)
+		l, err = s.handleDiscover(req, resp, p)
 		if err != nil {
 			return 0, nil, fmt.Errorf("handling discover: %s", err)
 		}
 
 		if l == nil {
+			// There are no IP addresses left.
+			s.conf.notify(LeaseChangedPoolExhausted)
+
 			return 0, nil, nil
 		}
 
@@ -1131,9 +1253,10 @@ var messageHandlers = map[dhcpv4.MessageType]messageHandler{
 		s *v4Server,
 		req *dhcpv4.DHCPv4,
 		resp *dhcpv4.DHCPv4,
+		p *v4Pool,
 	) (rCode int, l *Lease, err error) {
 		var toReply bool
-		l, toReply = s.handleRequest(req, resp)
+		l, toReply = s.handleRequest(req, resp, p)
 		if l == nil {
 			if toReply {
 				return 0, nil, nil
@@ -1149,8 +1272,9 @@ var messageHandlers = map[dhcpv4.MessageType]messageHandler{
 		s *v4Server,
 		req *dhcpv4.DHCPv4,
 		resp *dhcpv4.DHCPv4,
+		p *v4Pool,
 	) (rCode int, l *Lease, err error) {
-		err = s.handleDecline(req, resp)
+		err = s.handleDecline(req, resp, p)
 		if err != nil {
 			return 0, nil, fmt.Errorf("handling decline: %s", err)
 		}
@@ -1161,6 +1285,7 @@ var messageHandlers = map[dhcpv4.MessageType]messageHandler{
 		s *v4Server,
 		req *dhcpv4.DHCPv4,
 		resp *dhcpv4.DHCPv4,
+		p *v4Pool,
 	) (rCode int, l *Lease, err error) {
 		err = s.handleRelease(req, resp)
 		if err != nil {
@@ -1186,14 +1311,21 @@ func (s *v4Server) handle(req, resp *dhcpv4.DHCPv4) (rCode int) {
 	// See https://datatracker.ietf.org/doc/html/rfc2131#page-29.
 	resp.UpdateOption(dhcpv4.OptServerIdentifier(s.conf.dnsIPAddrs[0].AsSlice()))
 
+	p := s.poolForRequest(req)
+	if p == nil {
+		log.Debug("dhcpv4: no pool for request relayed by %s", req.GatewayIPAddr)
+
+		return -1
+	}
+
 	handler := messageHandlers[req.MessageType()]
 	if handler == nil {
-		s.updateOptions(req, resp)
+		s.updateOptions(req, resp, p)
 
 		return 1
 	}
 
-	rCode, l, err := handler(s, req, resp)
+	rCode, l, err := handler(s, req, resp, p)
 	if err != nil {
 		log.Error("dhcpv4: %s", err)
 
@@ -1208,16 +1340,19 @@ func (s *v4Server) handle(req, resp *dhcpv4.DHCPv4) (rCode int) {
 		resp.YourIPAddr = l.IP.AsSlice()
 	}
 
-	s.updateOptions(req, resp)
+	s.updateOptions(req, resp, p)
+	if l != nil && l.IsStatic {
+		updateLeaseOptions(resp, l)
+	}
 
 	return 1
 }
 
 // updateOptions updates the options of the response in accordance with the
-// request and RFC 2131.
+// request, the pool p, and RFC 2131.
 //
 // See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.1.
-func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4) {
+func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4, p *v4Pool) {
 	// Set IP address lease time for all DHCPOFFER messages and DHCPACK messages
 	// replied for DHCPREQUEST.
 	//
@@ -1233,10 +1368,18 @@ func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4) {
 		}
 	}
 
+	p.updateRelayOptions(resp)
+
 	// If the server has been explicitly configured with a default value for the
 	// parameter or the parameter has a non-default value on the client's
 	// subnet, the server MUST include that value in an appropriate option.
-	for code, val := range s.explicitOpts {
+	updateExplicitOptions(resp, s.explicitOpts)
+}
+
+// updateExplicitOptions sets the explicitly configured options opts into resp.
+// The options with nil values are removed from resp.
+func updateExplicitOptions(resp *dhcpv4.DHCPv4, opts dhcpv4.Options) {
+	for code, val := range opts {
 		if val != nil {
 			resp.Options[code] = val
 		} else {
@@ -1246,6 +1389,21 @@ func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4) {
 	}
 }
 
+// updateLeaseOptions sets the options configured for the static lease l into
+// resp.  Those take precedence over the options of the server.
+func updateLeaseOptions(resp *dhcpv4.DHCPv4, l *Lease) {
+	opts, err := parseDHCPOptions(l.Options)
+	if err != nil {
+		// Shouldn't happen for the leases added via API, since their options
+		// are validated.
+		log.Error("dhcpv4: static lease for %s: %s", l.HWAddr, err)
+
+		return
+	}
+
+	updateExplicitOptions(resp, opts)
+}
+
 // client(0.0.0.0:68) -> (Request:ClientMAC,Type=Discover,ClientID,ReqIP,HostName) -> server(255.255.255.255:67)
 // client(255.255.255.255:68) <- (Reply:YourIP,ClientMAC,Type=Offer,ServerID,SubnetMask,LeaseTime) <- server(<IP>:67)
 // client(0.0.0.0:68) -> (Request:ClientMAC,Type=Request,ClientID,ReqIP||ClientIP,HostName,ServerID,ParamReqList) -> server(255.255.255.255:67)
@@ -1285,11 +1443,26 @@ func (s *v4Server) packetHandler(conn net.PacketConn, peer net.Addr, req *dhcpv4
 		return
 	} else if r == 0 {
 		resp.Options.Update(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+	} else if s.conf.fingerprint != nil && resp.MessageType() == dhcpv4.MessageTypeAck {
+		s.conf.fingerprint(newFingerprint(req, resp))
 	}
 
 	s.send(peer, conn, req, resp)
 }
 
+// newFingerprint returns the fingerprint of the client from its request req
+// acknowledged with resp.
+func newFingerprint(req, resp *dhcpv4.DHCPv4) (fp *Fingerprint) {
+	ip, _ := netip.AddrFromSlice(resp.YourIPAddr)
+
+	return &Fingerprint{
+		HWAddr:      slices.Clone(req.ClientHWAddr),
+		IP:          ip.Unmap(),
+		VendorClass: req.ClassIdentifier(),
+		Params:      slices.Clone(req.Options.Get(dhcpv4.OptionParameterRequestList)),
+	}
+}
+
 // Start starts the IPv4 DHCP server.
 func (s *v4Server) Start() (err error) {
 	defer func() { err = errors.Annotate(err, "dhcpv4: %w") }()
@@ -1417,7 +1590,7 @@ func v4Create(conf *V4ServerConf) (srv *v4Server, err error) {
 	*s.conf = *conf
 
 	// TODO(a.garipov, d.seregin): Check that every lease is inside the IPRange.
-	s.leasedOffsets = newBitSet()
+	s.pools = newV4Pools(s.conf)
 
 	if conf.LeaseDuration == 0 {
 		s.conf.leaseTime = timeutil.Day
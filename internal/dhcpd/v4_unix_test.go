@@ -400,6 +400,51 @@ func TestV4Server_handle_optionsPriority(t *testing.T) {
 	})
 }
 
+func TestV4Server_handle_leaseOptions(t *testing.T) {
+	conf := defaultV4ServerConf()
+	conf.Options = []string{"66 text 192.168.10.1"}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	l := &Lease{
+		Hostname: "pxe-host",
+		HWAddr:   mac,
+		IP:       netip.MustParseAddr("192.168.10.150"),
+		Options:  []string{"67 text pxelinux.0", "66 text 192.168.10.2"},
+	}
+
+	err = s.AddStaticLease(l)
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, s.handle(req, resp))
+
+	assert.Equal(t, "pxelinux.0", resp.BootFileNameOption())
+	assert.Equal(t, "192.168.10.2", resp.TFTPServerName())
+
+	t.Run("invalid", func(t *testing.T) {
+		err = s.AddStaticLease(&Lease{
+			Hostname: "bad-host",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAB},
+			IP:       netip.MustParseAddr("192.168.10.151"),
+			Options:  []string{"67 txt pxelinux.0"},
+		})
+		testutil.AssertErrorMsg(
+			t,
+			`dhcpv4: adding static lease: validating options: option at index 0: `+
+				`invalid option string "67 txt pxelinux.0": unknown option type "txt"`,
+			err,
+		)
+	})
+}
+
 func TestV4Server_updateOptions(t *testing.T) {
 	testIP := net.IP{1, 2, 3, 4}
 
@@ -488,7 +533,7 @@ func TestV4Server_updateOptions(t *testing.T) {
 		require.IsType(t, (*v4Server)(nil), s)
 
 		t.Run(tc.name, func(t *testing.T) {
-			s.updateOptions(req, resp)
+			s.updateOptions(req, resp, s.pools[0])
 
 			for c, v := range tc.wantOpts {
 				if v == nil {
@@ -860,7 +905,7 @@ func TestV4Server_handleDecline(t *testing.T) {
 	req.ClientHWAddr = dynamicMAC
 
 	resp := &dhcpv4.DHCPv4{}
-	err = s4.handleDecline(req, resp)
+	err = s4.handleDecline(req, resp, s4.pools[0])
 	require.NoError(t, err)
 
 	wantResp := &dhcpv4.DHCPv4{
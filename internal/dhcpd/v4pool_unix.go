@@ -0,0 +1,136 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// v4Pool is an address pool of the DHCPv4 server.
+type v4Pool struct {
+	// relay is the configuration of the pool for the clients behind a relay
+	// agent.  It's nil for the pool of the directly connected network.
+	relay *V4RelayPool
+
+	// ipRange is the range of the dynamic leases.
+	ipRange *ipRange
+
+	// leasedOffsets contains offsets from ipRange.start that have been leased.
+	leasedOffsets *bitSet
+
+	// subnet is the pool's network.  The IP is the IP of the gateway.
+	subnet netip.Prefix
+}
+
+// newV4Pools returns the address pools for the validated conf.  The first one
+// is always the pool of the directly connected network.
+func newV4Pools(conf *V4ServerConf) (pools []*v4Pool) {
+	pools = []*v4Pool{{
+		ipRange:       conf.ipRange,
+		leasedOffsets: newBitSet(),
+		subnet:        conf.subnet,
+	}}
+
+	for _, r := range conf.RelayPools {
+		pools = append(pools, &v4Pool{
+			relay:         r,
+			ipRange:       r.ipRange,
+			leasedOffsets: newBitSet(),
+			subnet:        r.subnet,
+		})
+	}
+
+	return pools
+}
+
+// String implements the [fmt.Stringer] interface for *v4Pool.
+func (p *v4Pool) String() (s string) {
+	if p.relay == nil || p.relay.Name == "" {
+		return p.subnet.Masked().String()
+	}
+
+	return p.relay.Name
+}
+
+// matches returns true if the relayed request with the gateway IP address
+// giaddr and the relay agent information info should be served from p.
+func (p *v4Pool) matches(giaddr netip.Addr, info *dhcpv4.RelayOptions) (ok bool) {
+	r := p.relay
+	if r == nil || !p.subnet.Contains(giaddr) {
+		return false
+	}
+
+	return relaySubOptMatches(info, dhcpv4.AgentCircuitIDSubOption, r.CircuitID) &&
+		relaySubOptMatches(info, dhcpv4.AgentRemoteIDSubOption, r.RemoteID)
+}
+
+// relaySubOptMatches returns true if want is empty or if info contains the
+// sub-option with the code and the value equal to want.
+func relaySubOptMatches(info *dhcpv4.RelayOptions, code dhcpv4.OptionCode, want string) (ok bool) {
+	if want == "" {
+		return true
+	} else if info == nil {
+		return false
+	}
+
+	return string(info.Get(code)) == want
+}
+
+// poolByIP returns the pool, which network contains ip, or nil if there is no
+// such pool.
+func (s *v4Server) poolByIP(ip netip.Addr) (p *v4Pool) {
+	for _, p = range s.pools {
+		if p.subnet.Contains(ip) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// poolForRequest returns the pool to serve req from.  The requests received
+// directly or relayed from within the directly connected network are served
+// from its pool.  It returns nil if the request is relayed by an agent none of
+// the pools are configured for.
+func (s *v4Server) poolForRequest(req *dhcpv4.DHCPv4) (p *v4Pool) {
+	giaddr := req.GatewayIPAddr
+	if giaddr == nil || giaddr.IsUnspecified() {
+		return s.pools[0]
+	}
+
+	gw, ok := netip.AddrFromSlice(giaddr.To4())
+	if !ok {
+		return nil
+	} else if s.pools[0].subnet.Contains(gw) {
+		return s.pools[0]
+	}
+
+	info := req.RelayAgentInfo()
+	for _, p = range s.pools[1:] {
+		if p.matches(gw, info) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// updateRelayOptions replaces the network-specific values of the options in
+// resp for the clients behind the relay agent of p.
+func (p *v4Pool) updateRelayOptions(resp *dhcpv4.DHCPv4) {
+	r := p.relay
+	if r == nil {
+		return
+	}
+
+	if resp.Options.Has(dhcpv4.OptionRouter) {
+		resp.UpdateOption(dhcpv4.OptRouter(net.IP(r.GatewayIP.AsSlice())))
+	}
+
+	if resp.Options.Has(dhcpv4.OptionSubnetMask) {
+		resp.UpdateOption(dhcpv4.OptSubnetMask(net.IPMask(r.SubnetMask.AsSlice())))
+	}
+}
@@ -0,0 +1,184 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRelayPool returns a relay pool for the network 192.168.20.0/24 to use
+// in tests.
+func newTestRelayPool() (p *V4RelayPool) {
+	return &V4RelayPool{
+		Name:       "vlan20",
+		CircuitID:  "eth0.20",
+		GatewayIP:  netip.MustParseAddr("192.168.20.1"),
+		SubnetMask: netip.MustParseAddr("255.255.255.0"),
+		RangeStart: netip.MustParseAddr("192.168.20.100"),
+		RangeEnd:   netip.MustParseAddr("192.168.20.200"),
+	}
+}
+
+func TestV4Server_relayPools(t *testing.T) {
+	conf := defaultV4ServerConf()
+	conf.RelayPools = []*V4RelayPool{newTestRelayPool()}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	relayIP := net.IP{192, 168, 20, 1}
+	circuitID := dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0.20"))
+
+	testCases := []struct {
+		name     string
+		giaddr   net.IP
+		subOpts  []dhcpv4.Option
+		wantIP   net.IP
+		wantCode int
+	}{{
+		name:     "direct",
+		giaddr:   nil,
+		subOpts:  nil,
+		wantIP:   net.IP{192, 168, 10, 100},
+		wantCode: 1,
+	}, {
+		name:     "relayed",
+		giaddr:   relayIP,
+		subOpts:  []dhcpv4.Option{circuitID},
+		wantIP:   net.IP{192, 168, 20, 100},
+		wantCode: 1,
+	}, {
+		name:     "no_circuit_id",
+		giaddr:   relayIP,
+		subOpts:  nil,
+		wantIP:   nil,
+		wantCode: -1,
+	}, {
+		name:     "unknown_relay",
+		giaddr:   net.IP{192, 168, 30, 1},
+		subOpts:  []dhcpv4.Option{circuitID},
+		wantIP:   nil,
+		wantCode: -1,
+	}}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, byte(i)}
+
+			modifiers := []dhcpv4.Modifier{dhcpv4.WithRequestedOptions(
+				dhcpv4.OptionRouter,
+				dhcpv4.OptionSubnetMask,
+			)}
+			if tc.giaddr != nil {
+				modifiers = append(modifiers, dhcpv4.WithGatewayIP(tc.giaddr))
+			}
+			if tc.subOpts != nil {
+				modifiers = append(modifiers, dhcpv4.WithOption(
+					dhcpv4.OptRelayAgentInfo(tc.subOpts...),
+				))
+			}
+
+			req, reqErr := dhcpv4.NewDiscovery(mac, modifiers...)
+			require.NoError(t, reqErr)
+
+			resp, respErr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, respErr)
+
+			require.Equal(t, tc.wantCode, s.handle(req, resp))
+			if tc.wantCode != 1 {
+				return
+			}
+
+			assert.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType())
+			assert.True(t, tc.wantIP.Equal(resp.YourIPAddr))
+
+			pool := s.poolByIP(netip.AddrFrom4([4]byte(tc.wantIP.To4())))
+			require.NotNil(t, pool)
+
+			router := resp.Router()
+			require.Len(t, router, 1)
+
+			assert.True(t, router[0].Equal(pool.subnet.Addr().AsSlice()))
+
+			ones, _ := resp.SubnetMask().Size()
+			assert.Equal(t, pool.subnet.Bits(), ones)
+
+			if tc.subOpts != nil {
+				rai := resp.RelayAgentInfo()
+				require.NotNil(t, rai)
+
+				assert.Equal(t, []byte("eth0.20"), rai.Get(dhcpv4.AgentCircuitIDSubOption))
+			}
+		})
+	}
+
+	t.Run("static", func(t *testing.T) {
+		err = s.AddStaticLease(&Lease{
+			Hostname: "relayed-host",
+			HWAddr:   net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB},
+			IP:       netip.MustParseAddr("192.168.20.10"),
+		})
+		require.NoError(t, err)
+
+		err = s.AddStaticLease(&Lease{
+			Hostname: "other-host",
+			HWAddr:   net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBC},
+			IP:       netip.MustParseAddr("192.168.30.10"),
+		})
+		testutil.AssertErrorMsg(
+			t,
+			`dhcpv4: adding static lease: adding static lease for 192.168.30.10 `+
+				`(bb:bb:bb:bb:bb:bc): no configured subnet contains the ip "192.168.30.10"`,
+			err,
+		)
+	})
+}
+
+func TestV4ServerConf_Validate_relayPools(t *testing.T) {
+	overlapping := newTestRelayPool()
+	overlapping.GatewayIP = netip.MustParseAddr("192.168.10.250")
+	overlapping.SubnetMask = netip.MustParseAddr("255.255.0.0")
+
+	badRange := newTestRelayPool()
+	badRange.RangeEnd = netip.MustParseAddr("192.168.21.200")
+
+	testCases := []struct {
+		pool       *V4RelayPool
+		name       string
+		wantErrMsg string
+	}{{
+		pool:       newTestRelayPool(),
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		pool:       nil,
+		name:       "nil",
+		wantErrMsg: "dhcpv4: relay pool at index 0: nil config",
+	}, {
+		pool: overlapping,
+		name: "overlapping",
+		wantErrMsg: "dhcpv4: relay pool at index 0: network 192.168.10.250/16 " +
+			"overlaps network 192.168.10.1/24",
+	}, {
+		pool: badRange,
+		name: "bad_range",
+		wantErrMsg: "dhcpv4: relay pool at index 0: range end 192.168.21.200 " +
+			"is outside network 192.168.20.1/24",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := defaultV4ServerConf()
+			conf.RelayPools = []*V4RelayPool{tc.pool}
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, conf.Validate())
+		})
+	}
+}
@@ -31,9 +31,16 @@ type v6Server struct {
 	sid  dhcpv6.DUID
 	srv  *server6.Server
 
+	// explicitOpts are the options explicitly configured by the user.
+	explicitOpts []dhcpv6.Option
+
 	leases     []*Lease
 	leasesLock sync.Mutex
 	ipAddrs    [256]byte
+
+	// prefixes are the IPv6 prefixes delegated to the requesting routers.  It's
+	// protected by leasesLock.
+	prefixes []*prefixLease
 }
 
 // WriteDiskConfig4 - write configuration
@@ -58,13 +65,16 @@ func ip6InRange(start, ip net.IP) bool {
 	return start[15] <= ip[15]
 }
 
-// HostByIP implements the [Interface] interface for *v6Server.
+// HostByIP implements the [Interface] interface for *v6Server.  The expired
+// leases aren't resolved.
 func (s *v6Server) HostByIP(ip netip.Addr) (host string) {
+	now := time.Now()
+
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
 	for _, l := range s.leases {
-		if l.IP == ip {
+		if l.IP == ip && l.isActive(now) {
 			return l.Hostname
 		}
 	}
@@ -72,13 +82,16 @@ func (s *v6Server) HostByIP(ip netip.Addr) (host string) {
 	return ""
 }
 
-// IPByHost implements the [Interface] interface for *v6Server.
+// IPByHost implements the [Interface] interface for *v6Server.  The expired
+// leases aren't resolved.
 func (s *v6Server) IPByHost(host string) (ip netip.Addr) {
+	now := time.Now()
+
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
 	for _, l := range s.leases {
-		if l.Hostname == host {
+		if l.Hostname == host && l.isActive(now) {
 			return l.IP
 		}
 	}
@@ -93,6 +106,14 @@ func (s *v6Server) ResetLeases(leases []*Lease) (err error) {
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
+	s.resetLeasesLocked(leases)
+
+	return nil
+}
+
+// resetLeasesLocked replaces the leases of s with leases.  s.leasesLock is
+// expected to be locked.
+func (s *v6Server) resetLeasesLocked(leases []*Lease) {
 	s.leases = nil
 	for _, l := range leases {
 		ip := net.IP(l.IP.AsSlice())
@@ -105,8 +126,21 @@ func (s *v6Server) ResetLeases(leases []*Lease) (err error) {
 
 		s.addLease(l)
 	}
+}
 
-	return nil
+// MergeLeases implements the [DHCPServer] interface for *v6Server.
+func (s *v6Server) MergeLeases(leases []*Lease) (n int) {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	merged, n := mergeLeases(s.leases, leases, time.Now(), func(ip netip.Addr) (ok bool) {
+		return ip.Is6() && ip6InRange(s.conf.ipStart, ip.AsSlice())
+	})
+	if n > 0 {
+		s.resetLeasesLocked(merged)
+	}
+
+	return n
 }
 
 // GetLeases returns the list of current DHCP leases.  It is safe for concurrent
@@ -137,6 +171,12 @@ func (s *v6Server) getLeasesRef() []*Lease {
 	return s.leases
 }
 
+// subnets implements the [DHCPServer] interface for *v6Server.  Since the
+// DHCPv6 server doesn't support scopes, it always returns nil.
+func (s *v6Server) subnets() (nets []netip.Prefix) {
+	return nil
+}
+
 // FindMACbyIP implements the [Interface] for *v6Server.
 func (s *v6Server) FindMACbyIP(ip netip.Addr) (mac net.HardwareAddr) {
 	now := time.Now()
@@ -216,6 +256,11 @@ func (s *v6Server) AddStaticLease(l *Lease) (err error) {
 		return fmt.Errorf("validating lease: %w", err)
 	}
 
+	_, err = parseDHCPv6Options(l.Options)
+	if err != nil {
+		return fmt.Errorf("validating options: %w", err)
+	}
+
 	l.IsStatic = true
 
 	s.leasesLock.Lock()
@@ -256,6 +301,11 @@ func (s *v6Server) UpdateStaticLease(l *Lease) (err error) {
 		return fmt.Errorf("can't find lease %s", l.HWAddr)
 	}
 
+	_, err = parseDHCPv6Options(l.Options)
+	if err != nil {
+		return fmt.Errorf("validating options: %w", err)
+	}
+
 	err = s.rmLease(found)
 	if err != nil {
 		return fmt.Errorf("removing previous lease for %s (%s): %w", l.IP, l.HWAddr, err)
@@ -391,9 +441,14 @@ func (s *v6Server) reserveLease(mac net.HardwareAddr) *Lease {
 }
 
 func (s *v6Server) commitDynamicLease(l *Lease) {
-	l.Expiry = time.Now().Add(s.conf.leaseTime)
-
 	s.leasesLock.Lock()
+	now := time.Now()
+	typ := leaseEventType(l.Expiry, now)
+	l.Expiry = now.Add(s.conf.leaseTime)
+	if s.conf.leaseEvent != nil {
+		s.conf.leaseEvent(typ, l)
+	}
+
 	s.conf.notify(LeaseChangedDBStore)
 	s.leasesLock.Unlock()
 	s.conf.notify(LeaseChangedAdded)
@@ -485,6 +540,31 @@ func (s *v6Server) commitLease(msg *dhcpv6.Message, lease *Lease) time.Duration
 	return lifetime
 }
 
+// updateOptions sets the explicitly configured options of the server and the
+// options of the static lease l, if any, into resp.
+func (s *v6Server) updateOptions(resp dhcpv6.DHCPv6, l *Lease) {
+	for _, opt := range s.explicitOpts {
+		resp.UpdateOption(opt)
+	}
+
+	if l == nil || !l.IsStatic {
+		return
+	}
+
+	opts, err := parseDHCPv6Options(l.Options)
+	if err != nil {
+		// Shouldn't happen for the leases added via API, since their options
+		// are validated.
+		log.Error("dhcpv6: static lease for %s: %s", l.HWAddr, err)
+
+		return
+	}
+
+	for _, opt := range opts {
+		resp.UpdateOption(opt)
+	}
+}
+
 // Find a lease associated with MAC and prepare response
 func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 	switch msg.Type() {
@@ -506,7 +586,43 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 		return false
 	}
 
+	// Routers may request only a prefix without an address.
 	var lease *Lease
+	if msg.Options.OneIANA() != nil || msg.Options.OneIAPD() == nil {
+		lease = s.processNA(msg, resp, mac)
+		if lease == nil {
+			return false
+		}
+	}
+
+	s.updatePD(msg, resp, mac)
+
+	if msg.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+		resp.UpdateOption(dhcpv6.OptDNS(s.conf.dnsIPAddrs...))
+	}
+
+	fqdn := msg.GetOneOption(dhcpv6.OptionFQDN)
+	if fqdn != nil {
+		resp.AddOption(fqdn)
+	}
+
+	s.updateOptions(resp, lease)
+
+	resp.AddOption(&dhcpv6.OptStatusCode{
+		StatusCode:    iana.StatusSuccess,
+		StatusMessage: "success",
+	})
+	return true
+}
+
+// processNA finds or reserves the lease for the client with mac and adds the
+// IA_NA option with its address into resp.  It returns nil if the request
+// should be ignored.
+func (s *v6Server) processNA(
+	msg *dhcpv6.Message,
+	resp dhcpv6.DHCPv6,
+	mac net.HardwareAddr,
+) (lease *Lease) {
 	func() {
 		s.leasesLock.Lock()
 		defer s.leasesLock.Unlock()
@@ -522,19 +638,19 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 		case dhcpv6.MessageTypeSolicit:
 			lease = s.reserveLease(mac)
 			if lease == nil {
-				return false
+				return nil
 			}
 
 		default:
-			return false
+			return nil
 		}
 	}
 
-	err = s.checkIA(msg, lease)
+	err := s.checkIA(msg, lease)
 	if err != nil {
 		log.Debug("dhcpv6: %s", err)
 
-		return false
+		return nil
 	}
 
 	lifetime := s.commitLease(msg, lease)
@@ -559,20 +675,7 @@ func (s *v6Server) process(msg *dhcpv6.Message, req, resp dhcpv6.DHCPv6) bool {
 	}
 	resp.AddOption(oia)
 
-	if msg.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
-		resp.UpdateOption(dhcpv6.OptDNS(s.conf.dnsIPAddrs...))
-	}
-
-	fqdn := msg.GetOneOption(dhcpv6.OptionFQDN)
-	if fqdn != nil {
-		resp.AddOption(fqdn)
-	}
-
-	resp.AddOption(&dhcpv6.OptStatusCode{
-		StatusCode:    iana.StatusSuccess,
-		StatusMessage: "success",
-	})
-	return true
+	return lease
 }
 
 // 1.
@@ -804,5 +907,18 @@ func v6Create(conf V6ServerConf) (DHCPServer, error) {
 		s.conf.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
 	}
 
+	var err error
+	s.explicitOpts, err = parseDHCPv6Options(conf.Options)
+	if err != nil {
+		return s, fmt.Errorf("dhcpv6: %w", err)
+	}
+
+	if conf.PDPrefix.IsValid() {
+		err = validatePD(&s.conf)
+		if err != nil {
+			return s, fmt.Errorf("dhcpv6: %w", err)
+		}
+	}
+
 	return s, nil
 }
@@ -112,6 +112,7 @@ func TestV6GetLease(t *testing.T) {
 	sIface, err := v6Create(V6ServerConf{
 		Enabled:    true,
 		RangeStart: net.ParseIP("2001::1"),
+		Options:    []string{"59 text tftp://[2001::2]/boot.efi"},
 		notify:     notify6,
 	})
 	require.NoError(t, err)
@@ -127,8 +128,9 @@ func TestV6GetLease(t *testing.T) {
 	}
 
 	l := &Lease{
-		IP:     netip.MustParseAddr("2001::1"),
-		HWAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:      netip.MustParseAddr("2001::1"),
+		HWAddr:  net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		Options: []string{"15 hex 0003707865"},
 	}
 	err = s.AddStaticLease(l)
 	require.NoError(t, err)
@@ -193,6 +195,14 @@ func TestV6GetLease(t *testing.T) {
 	require.Len(t, dnsAddrs, 1)
 	assert.Equal(t, dnsAddr, dnsAddrs[0])
 
+	bootURL := resp.GetOneOption(dhcpv6.OptionBootfileURL)
+	require.NotNil(t, bootURL)
+	assert.Equal(t, []byte("tftp://[2001::2]/boot.efi"), bootURL.ToBytes())
+
+	userClass := resp.GetOneOption(dhcpv6.OptionUserClass)
+	require.NotNil(t, userClass)
+	assert.Equal(t, []byte{0x00, 0x03, 'p', 'x', 'e'}, userClass.ToBytes())
+
 	t.Run("lease", func(t *testing.T) {
 		ls := s.GetLeases(LeasesStatic)
 		require.Len(t, ls, 1)
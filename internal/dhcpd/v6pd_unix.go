@@ -0,0 +1,238 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"golang.org/x/exp/slices"
+)
+
+// defaultPDLength is the default and the maximum length of the delegated
+// prefixes.
+const defaultPDLength = 64
+
+// maxPDBits is the maximum number of bits, by which the delegated prefixes may
+// be longer than the configured one.  It limits the number of the delegated
+// prefixes to 65536.
+const maxPDBits = 16
+
+// validatePD validates the prefix delegation settings of conf and sets the
+// default values.  conf.PDPrefix is expected to be valid.
+func validatePD(conf *V6ServerConf) (err error) {
+	p := conf.PDPrefix
+	if !p.Addr().Is6() || p.Addr().Is4In6() {
+		return fmt.Errorf("pd_prefix %s is not an ipv6 prefix", p)
+	}
+
+	if conf.PDLength == 0 {
+		conf.PDLength = defaultPDLength
+	}
+
+	bits := p.Bits()
+	if l := conf.PDLength; l < bits || l > defaultPDLength {
+		return fmt.Errorf("pd_length %d is out of range [%d..%d]", l, bits, defaultPDLength)
+	} else if l-bits > maxPDBits {
+		return fmt.Errorf("pd_length %d is longer than pd_prefix %s by more than %d", l, p, maxPDBits)
+	}
+
+	conf.PDPrefix = p.Masked()
+
+	return nil
+}
+
+// nthPrefix returns the n-th prefix of length l within p.  p is expected to be
+// masked, l is expected to be in range [p.Bits()..defaultPDLength], and n is
+// expected to be less than 2^(l-p.Bits()).
+func nthPrefix(p netip.Prefix, l int, n uint64) (np netip.Prefix) {
+	a := p.Addr().As16()
+	hi := binary.BigEndian.Uint64(a[:8])
+	binary.BigEndian.PutUint64(a[:8], hi|n<<(defaultPDLength-l))
+
+	return netip.PrefixFrom(netip.AddrFrom16(a), l)
+}
+
+// delegatedPrefixes implements the [DHCPServer] interface for *v6Server.
+func (s *v6Server) delegatedPrefixes() (pls []*prefixLease) {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	for _, pl := range s.prefixes {
+		pls = append(pls, &prefixLease{
+			Expiry: pl.Expiry,
+			HWAddr: slices.Clone(pl.HWAddr),
+			Prefix: pl.Prefix,
+		})
+	}
+
+	return pls
+}
+
+// findPrefixLease returns the delegation to the router with mac or nil if
+// there is none.  s.leasesLock is expected to be locked.
+func (s *v6Server) findPrefixLease(mac net.HardwareAddr) (pl *prefixLease) {
+	for _, pl = range s.prefixes {
+		if slices.Equal(pl.HWAddr, mac) {
+			return pl
+		}
+	}
+
+	return nil
+}
+
+// reservePrefix returns the delegation to the router with mac, delegating a
+// new prefix if there is none.  want is preferred if it's within the pool and
+// isn't delegated yet, so that the routers keep their prefixes after a restart.
+// It returns nil if there are no free prefixes.  s.leasesLock is expected to
+// be locked.
+func (s *v6Server) reservePrefix(mac net.HardwareAddr, want netip.Prefix) (pl *prefixLease) {
+	pl = s.findPrefixLease(mac)
+	if pl != nil {
+		return pl
+	}
+
+	used := make(map[netip.Prefix]struct{}, len(s.prefixes))
+	for _, p := range s.prefixes {
+		used[p.Prefix] = struct{}{}
+	}
+
+	conf := s.conf
+	if _, ok := used[want]; !ok &&
+		want.Bits() == conf.PDLength &&
+		want == want.Masked() &&
+		conf.PDPrefix.Contains(want.Addr()) {
+		return s.addPrefixLease(mac, want)
+	}
+
+	n := uint64(1) << (conf.PDLength - conf.PDPrefix.Bits())
+	for i := uint64(0); i < n; i++ {
+		p := nthPrefix(conf.PDPrefix, conf.PDLength, i)
+		if _, ok := used[p]; !ok {
+			return s.addPrefixLease(mac, p)
+		}
+	}
+
+	now := time.Now()
+	for _, pl = range s.prefixes {
+		if pl.Expiry.Before(now) {
+			log.Debug("dhcpv6: reusing expired prefix %s of %s", pl.Prefix, pl.HWAddr)
+
+			pl.HWAddr = slices.Clone(mac)
+
+			return pl
+		}
+	}
+
+	return nil
+}
+
+// addPrefixLease adds the delegation of p to the router with mac.
+// s.leasesLock is expected to be locked.
+func (s *v6Server) addPrefixLease(mac net.HardwareAddr, p netip.Prefix) (pl *prefixLease) {
+	pl = &prefixLease{
+		HWAddr: slices.Clone(mac),
+		Prefix: p,
+	}
+	s.prefixes = append(s.prefixes, pl)
+
+	return pl
+}
+
+// commitPrefix returns the copy of the delegation to the router with mac,
+// extending it if msg requests that.  It returns nil if prefix delegation is
+// disabled or if there are no free prefixes.
+func (s *v6Server) commitPrefix(
+	msg *dhcpv6.Message,
+	mac net.HardwareAddr,
+	want netip.Prefix,
+) (pl *prefixLease) {
+	if !s.conf.PDPrefix.IsValid() {
+		return nil
+	}
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	pl = s.reservePrefix(mac, want)
+	if pl == nil {
+		return nil
+	}
+
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind:
+		pl.Expiry = time.Now().Add(s.conf.leaseTime)
+		log.Debug("dhcpv6: delegated prefix %s to %s", pl.Prefix, mac)
+	}
+
+	return &prefixLease{
+		Expiry: pl.Expiry,
+		HWAddr: pl.HWAddr,
+		Prefix: pl.Prefix,
+	}
+}
+
+// requestedPrefix returns the first prefix of iapd or an empty prefix if there
+// is none.
+func requestedPrefix(iapd *dhcpv6.OptIAPD) (p netip.Prefix) {
+	for _, o := range iapd.Options.Prefixes() {
+		if o.Prefix == nil {
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(o.Prefix.IP.To16())
+		if !ok {
+			continue
+		}
+
+		ones, _ := o.Prefix.Mask.Size()
+
+		return netip.PrefixFrom(addr, ones)
+	}
+
+	return netip.Prefix{}
+}
+
+// updatePD adds the IA_PD option with the prefix delegated to the router with
+// mac into resp, if msg requests one.
+func (s *v6Server) updatePD(msg *dhcpv6.Message, resp dhcpv6.DHCPv6, mac net.HardwareAddr) {
+	iapd := msg.Options.OneIAPD()
+	if iapd == nil || msg.Type() == dhcpv6.MessageTypeConfirm {
+		return
+	}
+
+	oiapd := &dhcpv6.OptIAPD{
+		IaId: iapd.IaId,
+	}
+	defer resp.AddOption(oiapd)
+
+	pl := s.commitPrefix(msg, mac, requestedPrefix(iapd))
+	if pl == nil {
+		oiapd.Options.Add(&dhcpv6.OptStatusCode{
+			StatusCode:    iana.StatusNoPrefixAvail,
+			StatusMessage: "no prefix available",
+		})
+
+		return
+	}
+
+	lifetime := s.conf.leaseTime
+	oiapd.T1 = lifetime / 2
+	oiapd.T2 = time.Duration(float32(lifetime) / 1.5)
+	oiapd.Options.Add(&dhcpv6.OptIAPrefix{
+		PreferredLifetime: lifetime,
+		ValidLifetime:     lifetime,
+		Prefix: &net.IPNet{
+			IP:   pl.Prefix.Addr().AsSlice(),
+			Mask: net.CIDRMask(pl.Prefix.Bits(), net.IPv6len*8),
+		},
+	})
+}
@@ -0,0 +1,178 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePD(t *testing.T) {
+	testCases := []struct {
+		name       string
+		prefix     netip.Prefix
+		wantErrMsg string
+		length     int
+		wantLength int
+	}{{
+		name:       "default_length",
+		prefix:     netip.MustParsePrefix("2001:db8:100::/56"),
+		wantErrMsg: "",
+		length:     0,
+		wantLength: 64,
+	}, {
+		name:       "custom_length",
+		prefix:     netip.MustParsePrefix("2001:db8:100::/48"),
+		wantErrMsg: "",
+		length:     60,
+		wantLength: 60,
+	}, {
+		name:       "ipv4",
+		prefix:     netip.MustParsePrefix("192.168.0.0/16"),
+		wantErrMsg: "pd_prefix 192.168.0.0/16 is not an ipv6 prefix",
+		length:     0,
+		wantLength: 0,
+	}, {
+		name:       "too_short",
+		prefix:     netip.MustParsePrefix("2001:db8:100::/56"),
+		wantErrMsg: "pd_length 48 is out of range [56..64]",
+		length:     48,
+		wantLength: 48,
+	}, {
+		name:   "too_many",
+		prefix: netip.MustParsePrefix("2001:db8::/32"),
+		wantErrMsg: "pd_length 64 is longer than pd_prefix 2001:db8::/32 " +
+			"by more than 16",
+		length:     64,
+		wantLength: 64,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := &V6ServerConf{
+				PDPrefix: tc.prefix,
+				PDLength: tc.length,
+			}
+
+			err := validatePD(conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantLength, conf.PDLength)
+		})
+	}
+}
+
+func TestNthPrefix(t *testing.T) {
+	p := netip.MustParsePrefix("2001:db8:100::/56")
+
+	assert.Equal(t, netip.MustParsePrefix("2001:db8:100::/64"), nthPrefix(p, 64, 0))
+	assert.Equal(t, netip.MustParsePrefix("2001:db8:100:1::/64"), nthPrefix(p, 64, 1))
+	assert.Equal(t, netip.MustParsePrefix("2001:db8:100:ff::/64"), nthPrefix(p, 64, 255))
+	assert.Equal(t, netip.MustParsePrefix("2001:db8:100:10::/60"), nthPrefix(p, 60, 1))
+}
+
+func TestV6Server_prefixDelegation(t *testing.T) {
+	sIface, err := v6Create(V6ServerConf{
+		Enabled:    true,
+		RangeStart: net.ParseIP("2001::1"),
+		PDPrefix:   netip.MustParsePrefix("2001:db8:100::/63"),
+		notify:     notify6,
+	})
+	require.NoError(t, err)
+
+	s, ok := sIface.(*v6Server)
+	require.True(t, ok)
+
+	s.sid = &dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+	}
+
+	// exchange processes the message of type typ from the router with mac,
+	// which requests only a prefix, and returns the delegated prefix option.
+	exchange := func(
+		t *testing.T,
+		typ dhcpv6.MessageType,
+		mac net.HardwareAddr,
+	) (oiapd *dhcpv6.OptIAPD) {
+		t.Helper()
+
+		req, reqErr := dhcpv6.NewSolicit(mac, dhcpv6.WithIAPD([4]byte{1, 2, 3, 4}))
+		require.NoError(t, reqErr)
+
+		req.Options.Del(dhcpv6.OptionIANA)
+		req.MessageType = typ
+
+		var resp *dhcpv6.Message
+		var respErr error
+		if typ == dhcpv6.MessageTypeSolicit {
+			resp, respErr = dhcpv6.NewAdvertiseFromSolicit(req)
+		} else {
+			resp, respErr = dhcpv6.NewReplyFromMessage(req)
+		}
+		require.NoError(t, respErr)
+
+		require.True(t, s.process(req, req, resp))
+
+		assert.Nil(t, resp.Options.OneIANA())
+
+		oiapd = resp.Options.OneIAPD()
+		require.NotNil(t, oiapd)
+
+		return oiapd
+	}
+
+	macs := []net.HardwareAddr{
+		{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0x01},
+		{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0x02},
+	}
+	wantPrefixes := []string{"2001:db8:100::/64", "2001:db8:100:1::/64"}
+
+	for i, mac := range macs {
+		for _, typ := range []dhcpv6.MessageType{
+			dhcpv6.MessageTypeSolicit,
+			dhcpv6.MessageTypeRequest,
+		} {
+			oiapd := exchange(t, typ, mac)
+
+			prefixes := oiapd.Options.Prefixes()
+			require.Len(t, prefixes, 1)
+
+			assert.Equal(t, wantPrefixes[i], prefixes[0].Prefix.String())
+		}
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		mac := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0x03}
+		oiapd := exchange(t, dhcpv6.MessageTypeSolicit, mac)
+
+		assert.Empty(t, oiapd.Options.Prefixes())
+
+		status := oiapd.Options.Status()
+		require.NotNil(t, status)
+
+		assert.Equal(t, iana.StatusNoPrefixAvail, status.StatusCode)
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		oiapd := exchange(t, dhcpv6.MessageTypeRenew, macs[0])
+
+		prefixes := oiapd.Options.Prefixes()
+		require.Len(t, prefixes, 1)
+
+		assert.Equal(t, wantPrefixes[0], prefixes[0].Prefix.String())
+	})
+
+	pls := s.delegatedPrefixes()
+	require.Len(t, pls, 2)
+
+	assert.Equal(t, macs[0], pls[0].HWAddr)
+	assert.Equal(t, netip.MustParsePrefix(wantPrefixes[0]), pls[0].Prefix)
+}
@@ -0,0 +1,85 @@
+// Package discovery discovers the names and the types of the devices on the
+// local network using mDNS, LLMNR, and NetBIOS.
+package discovery
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// Method is the method by which the information about a device has been
+// discovered.
+type Method uint8
+
+// Discovery methods.
+const (
+	MethodNone Method = iota
+	MethodNetBIOS
+	MethodLLMNR
+	MethodMDNS
+)
+
+// String returns a human-readable name of m.
+func (m Method) String() (s string) {
+	switch m {
+	case MethodNetBIOS:
+		return "NetBIOS"
+	case MethodLLMNR:
+		return "LLMNR"
+	case MethodMDNS:
+		return "mDNS"
+	default:
+		return ""
+	}
+}
+
+// DeviceType is a hint about the kind of a device.
+type DeviceType string
+
+// Device types.
+const (
+	DeviceTypeNone      DeviceType = ""
+	DeviceTypeComputer  DeviceType = "computer"
+	DeviceTypePhone     DeviceType = "phone"
+	DeviceTypeTablet    DeviceType = "tablet"
+	DeviceTypePrinter   DeviceType = "printer"
+	DeviceTypeMedia     DeviceType = "media"
+	DeviceTypeSmartHome DeviceType = "smart_home"
+	DeviceTypeStorage   DeviceType = "storage"
+	DeviceTypeTV        DeviceType = "tv"
+	DeviceTypeConsole   DeviceType = "console"
+	DeviceTypeIoT       DeviceType = "iot"
+)
+
+// Info is the information about a device discovered on the local network.
+type Info struct {
+	// Name is the hostname of the device without the ".local" suffix.  It may
+	// be empty.
+	Name string
+
+	// Model is the model of the device, if advertised.
+	Model string
+
+	// DeviceType is the kind of the device, if known.
+	DeviceType DeviceType
+
+	// Method is the method by which the information has been discovered.
+	Method Method
+}
+
+// Updater is the interface for storages of DNS clients that can update the
+// information discovered about them.
+type Updater interface {
+	// UpdateDiscovered updates the information about the device with ip.  info
+	// is never nil.
+	UpdateDiscovered(ip netip.Addr, info *Info)
+}
+
+// normalizeName returns the lowercase hostname from a DNS name or a NetBIOS
+// name, trimming the trailing dot, the ".local" suffix, and the spaces.
+func normalizeName(name string) (host string) {
+	host = strings.ToLower(strings.TrimSpace(name))
+	host = strings.TrimSuffix(host, ".")
+
+	return strings.TrimSuffix(host, ".local")
+}
@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// llmnrPort is the port of LLMNR.
+const llmnrPort = 5355
+
+// maxLLMNRSize is the maximum size of an LLMNR response.
+const maxLLMNRSize = 512
+
+// queryLLMNR sends the LLMNR PTR query for ip to addr and returns the name from
+// the response.  See RFC 4795, section 2.4.
+func queryLLMNR(ctx context.Context, addr netip.AddrPort, ip netip.Addr) (name string, err error) {
+	arpa, err := netutil.IPToReversedAddr(ip.AsSlice())
+	if err != nil {
+		return "", fmt.Errorf("reversing address: %w", err)
+	}
+
+	req := &dns.Msg{}
+	req.SetQuestion(dns.Fqdn(arpa), dns.TypePTR)
+	req.RecursionDesired = false
+
+	data, err := req.Pack()
+	if err != nil {
+		return "", fmt.Errorf("packing: %w", err)
+	}
+
+	resp, err := exchangeUDP(ctx, addr, data, maxLLMNRSize)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return "", err
+	}
+
+	msg := &dns.Msg{}
+	err = msg.Unpack(resp)
+	if err != nil {
+		return "", fmt.Errorf("unpacking: %w", err)
+	} else if msg.Id != req.Id {
+		return "", errors.Error("response id mismatch")
+	}
+
+	for _, rr := range msg.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			return normalizeName(ptr.Ptr), nil
+		}
+	}
+
+	return "", nil
+}
+
+// exchangeUDP sends data to addr and returns the response of at most maxSize
+// bytes.  The deadline of ctx is used as the timeout.
+func exchangeUDP(
+	ctx context.Context,
+	addr netip.AddrPort,
+	data []byte,
+	maxSize int,
+) (resp []byte, err error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+	} else {
+		err = conn.SetDeadline(time.Now().Add(defaultTimeout))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	_, err = conn.Write(data)
+	if err != nil {
+		return nil, fmt.Errorf("writing: %w", err)
+	}
+
+	resp = make([]byte, maxSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, fmt.Errorf("reading: %w", err)
+	}
+
+	return resp[:n], nil
+}
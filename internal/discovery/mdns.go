@@ -0,0 +1,337 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// mdnsGroup is the IPv4 multicast address and the port of mDNS.
+var mdnsGroup = netip.MustParseAddrPort("224.0.0.251:5353")
+
+// maxMDNSSize is the maximum size of an mDNS message.
+const maxMDNSSize = 9000
+
+// DefaultQueryIvl is the default interval between the mDNS service queries.
+const DefaultQueryIvl = 10 * time.Minute
+
+// serviceDeviceTypes are the types of the devices advertising the well-known
+// DNS-SD services.
+var serviceDeviceTypes = map[string]DeviceType{
+	"_adisk._tcp":           DeviceTypeStorage,
+	"_afpovertcp._tcp":      DeviceTypeComputer,
+	"_airplay._tcp":         DeviceTypeMedia,
+	"_apple-mobdev2._tcp":   DeviceTypePhone,
+	"_companion-link._tcp":  DeviceTypePhone,
+	"_googlecast._tcp":      DeviceTypeMedia,
+	"_hap._tcp":             DeviceTypeSmartHome,
+	"_hap._udp":             DeviceTypeSmartHome,
+	"_ipp._tcp":             DeviceTypePrinter,
+	"_ipps._tcp":            DeviceTypePrinter,
+	"_matter._tcp":          DeviceTypeSmartHome,
+	"_nfs._tcp":             DeviceTypeStorage,
+	"_pdl-datastream._tcp":  DeviceTypePrinter,
+	"_printer._tcp":         DeviceTypePrinter,
+	"_raop._tcp":            DeviceTypeMedia,
+	"_rfb._tcp":             DeviceTypeComputer,
+	"_scanner._tcp":         DeviceTypePrinter,
+	"_smb._tcp":             DeviceTypeComputer,
+	"_spotify-connect._tcp": DeviceTypeMedia,
+	"_ssh._tcp":             DeviceTypeComputer,
+	"_workstation._tcp":     DeviceTypeComputer,
+}
+
+// modelDeviceTypes are the types of the devices by the prefixes of the models
+// advertised by the "_device-info._tcp" service.  The longer prefixes go
+// first.
+var modelDeviceTypes = []struct {
+	prefix string
+	typ    DeviceType
+}{
+	{prefix: "audioaccessory", typ: DeviceTypeMedia},
+	{prefix: "appletv", typ: DeviceTypeMedia},
+	{prefix: "iphone", typ: DeviceTypePhone},
+	{prefix: "ipad", typ: DeviceTypeTablet},
+	{prefix: "ipod", typ: DeviceTypeMedia},
+	{prefix: "imac", typ: DeviceTypeComputer},
+	{prefix: "mac", typ: DeviceTypeComputer},
+}
+
+// modelDeviceType returns the type of the device by its model.
+func modelDeviceType(model string) (typ DeviceType) {
+	model = strings.ToLower(model)
+	for _, m := range modelDeviceTypes {
+		if strings.HasPrefix(model, m.prefix) {
+			return m.typ
+		}
+	}
+
+	return DeviceTypeNone
+}
+
+// serviceType returns the DNS-SD service type, such as "_ipp._tcp", which
+// name belongs to, or an empty string if there is none.
+func serviceType(name string) (svc string) {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i := len(labels) - 1; i > 0; i-- {
+		if p := labels[i]; p == "_tcp" || p == "_udp" {
+			return labels[i-1] + "." + p
+		}
+	}
+
+	return ""
+}
+
+// parseResponse returns the information about the device, which has sent the
+// mDNS response msg from src, and its addresses.  info is nil if there is
+// nothing useful in msg.
+func parseResponse(src netip.Addr, msg *dns.Msg) (addrs []netip.Addr, info *Info) {
+	if !msg.Response {
+		return nil, nil
+	}
+
+	info = &Info{
+		Method: MethodMDNS,
+	}
+
+	hostAddrs := map[string][]netip.Addr{}
+	var hosts []string
+	for _, rr := range append(slices.Clip(msg.Answer), msg.Extra...) {
+		host := normalizeName(rr.Header().Name)
+
+		switch rr := rr.(type) {
+		case *dns.A, *dns.AAAA:
+			addr := rrAddr(rr)
+			if !addr.IsValid() {
+				continue
+			}
+
+			if _, ok := hostAddrs[host]; !ok {
+				hosts = append(hosts, host)
+			}
+
+			hostAddrs[host] = append(hostAddrs[host], addr)
+			if addr == src {
+				info.Name = host
+			}
+		case *dns.PTR:
+			if info.DeviceType == DeviceTypeNone {
+				info.DeviceType = serviceDeviceTypes[serviceType(rr.Ptr)]
+			}
+		case *dns.TXT:
+			if serviceType(rr.Hdr.Name) == "_device-info._tcp" {
+				info.Model = txtValue(rr.Txt, "model")
+			}
+		}
+	}
+
+	if info.Name == "" && len(hosts) > 0 {
+		// The response is sent from the address, which isn't advertised, for
+		// example from an IPv6 link-local one.
+		info.Name = hosts[0]
+	}
+
+	if info.DeviceType == DeviceTypeNone && info.Model != "" {
+		info.DeviceType = modelDeviceType(info.Model)
+	}
+
+	if info.Name == "" && info.Model == "" && info.DeviceType == DeviceTypeNone {
+		return nil, nil
+	}
+
+	addrs = hostAddrs[info.Name]
+	if !slices.Contains(addrs, src) {
+		addrs = append(addrs, src)
+	}
+
+	return addrs, info
+}
+
+// rrAddr returns the address from an A or AAAA record.
+func rrAddr(rr dns.RR) (addr netip.Addr) {
+	var ip net.IP
+	switch rr := rr.(type) {
+	case *dns.A:
+		ip = rr.A
+	case *dns.AAAA:
+		ip = rr.AAAA
+	}
+
+	addr, _ = netip.AddrFromSlice(ip)
+
+	return addr.Unmap()
+}
+
+// txtValue returns the value of the key from the DNS-SD TXT record strings.
+func txtValue(txt []string, key string) (val string) {
+	for _, kv := range txt {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// ListenerConfig is the configuration structure for the mDNS listener.
+type ListenerConfig struct {
+	// Updater is updated with the information about the devices.  It must not
+	// be nil.
+	Updater Updater
+
+	// QueryIvl is the interval between the queries for the DNS-SD services,
+	// which make the devices announce themselves.  If zero, the listener is
+	// completely passive.
+	QueryIvl time.Duration
+}
+
+// Listener listens to the mDNS responses on the local network.
+type Listener struct {
+	// conn is the connection joined to the mDNS multicast group.
+	conn *net.UDPConn
+
+	// updater is updated with the information about the devices.
+	updater Updater
+
+	// done is closed when the listener is closing.
+	done chan struct{}
+
+	// wg waits for the goroutines of the listener.
+	wg *sync.WaitGroup
+
+	// queryIvl is the interval between the queries for the DNS-SD services.
+	queryIvl time.Duration
+}
+
+// NewListener returns a new mDNS listener joined to the mDNS multicast group on
+// the default interface.  conf must not be nil.
+func NewListener(conf *ListenerConfig) (l *Listener, err error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, net.UDPAddrFromAddrPort(mdnsGroup))
+	if err != nil {
+		return nil, fmt.Errorf("listening: %w", err)
+	}
+
+	return &Listener{
+		conn:     conn,
+		updater:  conf.Updater,
+		done:     make(chan struct{}),
+		wg:       &sync.WaitGroup{},
+		queryIvl: conf.QueryIvl,
+	}, nil
+}
+
+// Start starts handling the mDNS responses and sending the queries.
+func (l *Listener) Start() {
+	l.wg.Add(1)
+	go l.listen()
+
+	if l.queryIvl > 0 {
+		l.wg.Add(1)
+		go l.query()
+	}
+}
+
+// Close stops the listener.
+func (l *Listener) Close() (err error) {
+	close(l.done)
+	err = l.conn.Close()
+	l.wg.Wait()
+
+	return err
+}
+
+// listen reads and handles the mDNS messages until the connection is closed.
+// It's intended to be used as a goroutine.
+func (l *Listener) listen() {
+	defer l.wg.Done()
+	defer log.OnPanic("discovery: mdns: listening")
+
+	buf := make([]byte, maxMDNSSize)
+	for {
+		n, src, err := l.conn.ReadFromUDPAddrPort(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Debug("discovery: mdns: reading: %s", err)
+
+			continue
+		}
+
+		l.handle(src.Addr().Unmap(), buf[:n])
+	}
+}
+
+// handle updates the information about the devices from the mDNS message data
+// sent from src.
+func (l *Listener) handle(src netip.Addr, data []byte) {
+	msg := &dns.Msg{}
+	err := msg.Unpack(data)
+	if err != nil {
+		log.Debug("discovery: mdns: unpacking message from %s: %s", src, err)
+
+		return
+	}
+
+	addrs, info := parseResponse(src, msg)
+	for _, addr := range addrs {
+		l.updater.UpdateDiscovered(addr, info)
+	}
+}
+
+// query periodically sends the queries for the DNS-SD services until the
+// listener is closed.  It's intended to be used as a goroutine.
+func (l *Listener) query() {
+	defer l.wg.Done()
+	defer log.OnPanic("discovery: mdns: querying")
+
+	ticker := time.NewTicker(l.queryIvl)
+	defer ticker.Stop()
+
+	for {
+		err := l.sendQuery()
+		if err != nil {
+			log.Debug("discovery: mdns: sending query: %s", err)
+		}
+
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			// Go on.
+		}
+	}
+}
+
+// sendQuery sends the query for the DNS-SD service types and the device
+// information to the mDNS multicast group.
+func (l *Listener) sendQuery() (err error) {
+	msg := &dns.Msg{
+		Question: []dns.Question{{
+			Name:   "_services._dns-sd._udp.local.",
+			Qtype:  dns.TypePTR,
+			Qclass: dns.ClassINET,
+		}, {
+			Name:   "_device-info._tcp.local.",
+			Qtype:  dns.TypePTR,
+			Qclass: dns.ClassINET,
+		}},
+	}
+
+	data, err := msg.Pack()
+	if err != nil {
+		return fmt.Errorf("packing: %w", err)
+	}
+
+	_, err = l.conn.WriteToUDPAddrPort(data, mdnsGroup)
+
+	return err
+}
@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResponse(t *testing.T) {
+	src := netip.MustParseAddr("192.168.1.2")
+	srcV6 := netip.MustParseAddr("fe80::2")
+
+	hdr := func(name string, typ uint16) (h dns.RR_Header) {
+		return dns.RR_Header{Name: name, Rrtype: typ, Class: dns.ClassINET, Ttl: 120}
+	}
+
+	aRR := &dns.A{Hdr: hdr("Johns-iPhone.local.", dns.TypeA), A: net.IP{192, 168, 1, 2}}
+	aaaaRR := &dns.AAAA{
+		Hdr:  hdr("Johns-iPhone.local.", dns.TypeAAAA),
+		AAAA: net.ParseIP("fd00::2"),
+	}
+	infoRR := &dns.TXT{
+		Hdr: hdr("Johns iPhone._device-info._tcp.local.", dns.TypeTXT),
+		Txt: []string{"model=iPhone14,2", "osxvers=21"},
+	}
+	printerRR := &dns.PTR{
+		Hdr: hdr("_ipp._tcp.local.", dns.TypePTR),
+		Ptr: "Office Printer._ipp._tcp.local.",
+	}
+
+	testCases := []struct {
+		msg       *dns.Msg
+		wantInfo  *Info
+		name      string
+		src       netip.Addr
+		wantAddrs []netip.Addr
+	}{{
+		msg:       &dns.Msg{MsgHdr: dns.MsgHdr{Response: false}, Answer: []dns.RR{aRR}},
+		wantInfo:  nil,
+		name:      "query",
+		src:       src,
+		wantAddrs: nil,
+	}, {
+		msg:       &dns.Msg{MsgHdr: dns.MsgHdr{Response: true}},
+		wantInfo:  nil,
+		name:      "empty",
+		src:       src,
+		wantAddrs: nil,
+	}, {
+		msg: &dns.Msg{
+			MsgHdr: dns.MsgHdr{Response: true},
+			Answer: []dns.RR{infoRR},
+			Extra:  []dns.RR{aRR, aaaaRR},
+		},
+		wantInfo: &Info{
+			Name:       "johns-iphone",
+			Model:      "iPhone14,2",
+			DeviceType: DeviceTypePhone,
+			Method:     MethodMDNS,
+		},
+		name: "device_info",
+		src:  src,
+		wantAddrs: []netip.Addr{
+			src,
+			netip.MustParseAddr("fd00::2"),
+		},
+	}, {
+		msg: &dns.Msg{
+			MsgHdr: dns.MsgHdr{Response: true},
+			Answer: []dns.RR{aRR},
+		},
+		wantInfo: &Info{
+			Name:       "johns-iphone",
+			Model:      "",
+			DeviceType: DeviceTypeNone,
+			Method:     MethodMDNS,
+		},
+		name:      "link_local_source",
+		src:       srcV6,
+		wantAddrs: []netip.Addr{src, srcV6},
+	}, {
+		msg: &dns.Msg{
+			MsgHdr: dns.MsgHdr{Response: true},
+			Answer: []dns.RR{printerRR},
+		},
+		wantInfo: &Info{
+			Name:       "",
+			Model:      "",
+			DeviceType: DeviceTypePrinter,
+			Method:     MethodMDNS,
+		},
+		name:      "service",
+		src:       src,
+		wantAddrs: []netip.Addr{src},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addrs, info := parseResponse(tc.src, tc.msg)
+			assert.Equal(t, tc.wantInfo, info)
+			assert.Equal(t, tc.wantAddrs, addrs)
+		})
+	}
+}
+
+func TestServiceType(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want string
+	}{{
+		name: "instance",
+		in:   "Office Printer._ipp._tcp.local.",
+		want: "_ipp._tcp",
+	}, {
+		name: "subtype",
+		in:   "_printer._sub._http._tcp.local.",
+		want: "_http._tcp",
+	}, {
+		name: "udp",
+		in:   "_hap._udp.local.",
+		want: "_hap._udp",
+	}, {
+		name: "host",
+		in:   "host.local.",
+		want: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, serviceType(tc.in))
+		})
+	}
+}
+
+func TestModelDeviceType(t *testing.T) {
+	assert.Equal(t, DeviceTypeTablet, modelDeviceType("iPad13,4"))
+	assert.Equal(t, DeviceTypeComputer, modelDeviceType("MacBookPro18,3"))
+	assert.Equal(t, DeviceTypeMedia, modelDeviceType("AppleTV11,1"))
+	assert.Equal(t, DeviceTypeNone, modelDeviceType("Unknown"))
+}
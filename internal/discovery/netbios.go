@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// netBIOSPort is the port of the NetBIOS name service.
+const netBIOSPort = 137
+
+// maxNetBIOSSize is the maximum size of a NetBIOS node status response.
+const maxNetBIOSSize = 1024
+
+// NetBIOS node status constants.  See RFC 1002, section 4.2.17.
+const (
+	// nbstatType is the type of the node status request.
+	nbstatType = 0x0021
+
+	// nbstatClass is the Internet class.
+	nbstatClass = 0x0001
+
+	// nbHeaderLen is the length of the header of a NetBIOS message.
+	nbHeaderLen = 12
+
+	// nbEncodedNameLen is the length of the encoded wildcard name including
+	// the length byte and the terminating zero.
+	nbEncodedNameLen = 34
+
+	// nbNameEntryLen is the length of a name entry in the node status
+	// response.
+	nbNameEntryLen = 18
+
+	// nbNameLen is the length of a name in a name entry, not including the
+	// suffix byte.
+	nbNameLen = 15
+
+	// nbSuffixWorkstation is the suffix of the workstation service name.
+	nbSuffixWorkstation = 0x00
+
+	// nbFlagGroup is the flag of the group names.
+	nbFlagGroup = 0x8000
+)
+
+// newNBSTATRequest returns the NetBIOS node status request for the wildcard
+// name with the transaction id.
+func newNBSTATRequest(id uint16) (req []byte) {
+	req = make([]byte, 0, nbHeaderLen+nbEncodedNameLen+4)
+	req = binary.BigEndian.AppendUint16(req, id)
+	// Flags, QDCOUNT, ANCOUNT, NSCOUNT, ARCOUNT.
+	req = append(req, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0)
+
+	// The first-level encoding of the wildcard name "*" padded with zeros.
+	// See RFC 1001, section 14.1.
+	name := [16]byte{'*'}
+	req = append(req, 32)
+	for _, b := range name {
+		req = append(req, 'A'+b>>4, 'A'+b&0x0F)
+	}
+	req = append(req, 0)
+
+	req = binary.BigEndian.AppendUint16(req, nbstatType)
+
+	return binary.BigEndian.AppendUint16(req, nbstatClass)
+}
+
+// parseNBSTATResponse returns the unique workstation name from the NetBIOS node
+// status response resp to the request with id.
+func parseNBSTATResponse(resp []byte, id uint16) (name string, err error) {
+	// Header, name, type, class, TTL, RDLENGTH, and the number of names.
+	const namesOff = nbHeaderLen + nbEncodedNameLen + 2 + 2 + 4 + 2 + 1
+
+	if len(resp) < namesOff {
+		return "", fmt.Errorf("response is too short: %d bytes", len(resp))
+	} else if binary.BigEndian.Uint16(resp) != id {
+		return "", errors.Error("response id mismatch")
+	}
+
+	n := int(resp[namesOff-1])
+	entries := resp[namesOff:]
+	if len(entries) < n*nbNameEntryLen {
+		return "", fmt.Errorf("response is too short for %d names", n)
+	}
+
+	for i := 0; i < n; i++ {
+		e := entries[i*nbNameEntryLen : (i+1)*nbNameEntryLen]
+		flags := binary.BigEndian.Uint16(e[nbNameLen+1:])
+		if e[nbNameLen] == nbSuffixWorkstation && flags&nbFlagGroup == 0 {
+			return normalizeName(string(e[:nbNameLen])), nil
+		}
+	}
+
+	return "", nil
+}
+
+// queryNetBIOS sends the NetBIOS node status request to addr and returns the
+// workstation name from the response.
+func queryNetBIOS(ctx context.Context, addr netip.AddrPort) (name string, err error) {
+	id := uint16(nextID())
+
+	resp, err := exchangeUDP(ctx, addr, newNBSTATRequest(id), maxNetBIOSSize)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return "", err
+	}
+
+	return parseNBSTATResponse(resp, id)
+}
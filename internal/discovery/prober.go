@@ -0,0 +1,189 @@
+package discovery
+
+import (
+	"context"
+	"math/rand"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/bluele/gcache"
+)
+
+// defaultTimeout is the timeout for a single probe, used when the context has
+// no deadline.
+const defaultTimeout = 1 * time.Second
+
+// lastID is the last id of the LLMNR and NetBIOS requests.
+var lastID = rand.Uint32()
+
+// nextID returns the id for the next request.
+func nextID() (id uint32) {
+	return atomic.AddUint32(&lastID, 1)
+}
+
+// Prober actively asks the devices on the local network about their names.
+type Prober interface {
+	// Process probes the device with ip and returns the information about it.
+	// changed indicates that the information was updated since the last
+	// request.  info is nil if nothing has been discovered.
+	Process(ctx context.Context, ip netip.Addr) (info *Info, changed bool)
+}
+
+// EmptyProber is an empty [Prober] implementation which does nothing.
+type EmptyProber struct{}
+
+// type check
+var _ Prober = EmptyProber{}
+
+// Process implements the [Prober] interface for EmptyProber.
+func (EmptyProber) Process(_ context.Context, _ netip.Addr) (info *Info, changed bool) {
+	return nil, false
+}
+
+// ProberConfig is the configuration structure for DefaultProber.
+type ProberConfig struct {
+	// CacheSize is the maximum size of the cache.  It must be greater than
+	// zero.
+	CacheSize int
+
+	// CacheTTL is the Time to Live duration for the cached results.
+	CacheTTL time.Duration
+
+	// Timeout is the timeout for a single probe.
+	Timeout time.Duration
+
+	// UseLLMNR, if true, enables the LLMNR reverse queries.
+	UseLLMNR bool
+
+	// UseNetBIOS, if true, enables the NetBIOS node status queries.
+	UseNetBIOS bool
+}
+
+// DefaultProber is the default [Prober] implementation, which uses LLMNR and
+// NetBIOS.
+type DefaultProber struct {
+	// cache contains the results of probing by IP addresses.  If an address
+	// couldn't be probed, it stays here for some time to prevent further
+	// attempts.
+	cache gcache.Cache
+
+	// cacheTTL is the Time to Live duration for the cached results.
+	cacheTTL time.Duration
+
+	// timeout is the timeout for a single probe.
+	timeout time.Duration
+
+	// llmnrPort is the port of LLMNR, zero if LLMNR is disabled.
+	llmnrPort uint16
+
+	// netBIOSPort is the port of NetBIOS, zero if NetBIOS is disabled.
+	netBIOSPort uint16
+}
+
+// NewProber returns a new default prober.  conf must not be nil.
+func NewProber(conf *ProberConfig) (p *DefaultProber) {
+	p = &DefaultProber{
+		cache:    gcache.New(conf.CacheSize).LRU().Build(),
+		cacheTTL: conf.CacheTTL,
+		timeout:  conf.Timeout,
+	}
+
+	if conf.UseLLMNR {
+		p.llmnrPort = llmnrPort
+	}
+
+	if conf.UseNetBIOS {
+		p.netBIOSPort = netBIOSPort
+	}
+
+	return p
+}
+
+// type check
+var _ Prober = (*DefaultProber)(nil)
+
+// Process implements the [Prober] interface for *DefaultProber.  LLMNR is
+// tried first, since it provides the full hostname.
+func (p *DefaultProber) Process(ctx context.Context, ip netip.Addr) (info *Info, changed bool) {
+	fromCache, expired := p.findInCache(ip)
+	if !expired {
+		return fromCache, false
+	}
+
+	info = p.probe(ctx, ip)
+
+	err := p.cache.SetWithExpire(ip, info, p.cacheTTL)
+	if err != nil {
+		log.Debug("discovery: cache: adding item %q: %s", ip, err)
+	}
+
+	if info == nil {
+		return nil, false
+	}
+
+	return info, fromCache == nil || *info != *fromCache
+}
+
+// probe sends the enabled probes to ip one by one and returns the information
+// from the first successful one.
+func (p *DefaultProber) probe(ctx context.Context, ip netip.Addr) (info *Info) {
+	if p.llmnrPort != 0 {
+		name, err := p.probeOne(ctx, func(ctx context.Context) (string, error) {
+			return queryLLMNR(ctx, netip.AddrPortFrom(ip, p.llmnrPort), ip)
+		})
+		if err != nil {
+			log.Debug("discovery: llmnr: probing %s: %s", ip, err)
+		} else if name != "" {
+			return &Info{Name: name, Method: MethodLLMNR}
+		}
+	}
+
+	if p.netBIOSPort != 0 && ip.Is4() {
+		name, err := p.probeOne(ctx, func(ctx context.Context) (string, error) {
+			return queryNetBIOS(ctx, netip.AddrPortFrom(ip, p.netBIOSPort))
+		})
+		if err != nil {
+			log.Debug("discovery: netbios: probing %s: %s", ip, err)
+		} else if name != "" {
+			return &Info{Name: name, Method: MethodNetBIOS}
+		}
+	}
+
+	return nil
+}
+
+// probeOne calls query with the timeout of p.
+func (p *DefaultProber) probeOne(
+	ctx context.Context,
+	query func(ctx context.Context) (name string, err error),
+) (name string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	return query(ctx)
+}
+
+// findInCache finds the information about ip in the cache.  expired is true if
+// info is not valid anymore.
+func (p *DefaultProber) findInCache(ip netip.Addr) (info *Info, expired bool) {
+	val, err := p.cache.Get(ip)
+	if err != nil {
+		if !errors.Is(err, gcache.KeyNotFoundError) {
+			log.Debug("discovery: cache: retrieving %q: %s", ip, err)
+		}
+
+		return nil, true
+	}
+
+	info, ok := val.(*Info)
+	if !ok && val != nil {
+		log.Debug("discovery: cache: %q bad type %T", ip, val)
+
+		return nil, true
+	}
+
+	return info, false
+}
@@ -0,0 +1,194 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// startUDPServer starts a UDP server on the loopback address, which responds
+// to each request with the result of handle, and returns its port.
+func startUDPServer(t *testing.T, handle func(req []byte) (resp []byte)) (port uint16) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, rerr := conn.ReadFromUDPAddrPort(buf)
+			if rerr != nil {
+				return
+			}
+
+			_, _ = conn.WriteToUDPAddrPort(handle(buf[:n]), addr)
+		}
+	}()
+
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// newNBSTATResponse returns the NetBIOS node status response to req with the
+// names.
+func newNBSTATResponse(req []byte, names []string, suffixes []byte) (resp []byte) {
+	resp = append(resp, req[:2]...)
+	resp = append(resp, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0)
+	resp = append(resp, req[nbHeaderLen:nbHeaderLen+nbEncodedNameLen+4]...)
+	// TTL.
+	resp = append(resp, 0, 0, 0, 0)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(1+len(names)*nbNameEntryLen))
+	resp = append(resp, byte(len(names)))
+	for i, n := range names {
+		entry := [nbNameLen]byte{}
+		copy(entry[:], n+"               ")
+		resp = append(resp, entry[:]...)
+		resp = append(resp, suffixes[i], 0x04, 0)
+	}
+
+	return resp
+}
+
+func TestDefaultProber_Process(t *testing.T) {
+	ip := netip.MustParseAddr("127.0.0.1")
+
+	llmnrPort := startUDPServer(t, func(req []byte) (resp []byte) {
+		msg := &dns.Msg{}
+		if msg.Unpack(req) != nil {
+			return nil
+		}
+
+		ans := &dns.Msg{}
+		ans.SetReply(msg)
+		ans.Answer = append(ans.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   msg.Question[0].Name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+			},
+			Ptr: "Desktop-PC.",
+		})
+
+		resp, _ = ans.Pack()
+
+		return resp
+	})
+
+	netBIOSPort := startUDPServer(t, func(req []byte) (resp []byte) {
+		return newNBSTATResponse(
+			req,
+			[]string{"LAPTOP", "LAPTOP"},
+			[]byte{0x20, nbSuffixWorkstation},
+		)
+	})
+
+	newProber := func(llmnr, netBIOS uint16) (p *DefaultProber) {
+		p = NewProber(&ProberConfig{
+			CacheSize: 10,
+			CacheTTL:  time.Hour,
+			Timeout:   testTimeout,
+		})
+		p.llmnrPort, p.netBIOSPort = llmnr, netBIOS
+
+		return p
+	}
+
+	testCases := []struct {
+		want        *Info
+		name        string
+		llmnrPort   uint16
+		netBIOSPort uint16
+	}{{
+		want:        &Info{Name: "desktop-pc", Method: MethodLLMNR},
+		name:        "llmnr",
+		llmnrPort:   llmnrPort,
+		netBIOSPort: netBIOSPort,
+	}, {
+		want:        &Info{Name: "laptop", Method: MethodNetBIOS},
+		name:        "netbios",
+		llmnrPort:   0,
+		netBIOSPort: netBIOSPort,
+	}, {
+		want:        nil,
+		name:        "disabled",
+		llmnrPort:   0,
+		netBIOSPort: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProber(tc.llmnrPort, tc.netBIOSPort)
+
+			info, changed := p.Process(context.Background(), ip)
+			assert.Equal(t, tc.want, info)
+			assert.Equal(t, tc.want != nil, changed)
+
+			info, changed = p.Process(context.Background(), ip)
+			assert.Equal(t, tc.want, info)
+			assert.False(t, changed)
+		})
+	}
+}
+
+func TestParseNBSTATResponse(t *testing.T) {
+	const id = 0x1234
+
+	req := newNBSTATRequest(id)
+	require.Len(t, req, nbHeaderLen+nbEncodedNameLen+4)
+
+	testCases := []struct {
+		name       string
+		wantName   string
+		wantErrMsg string
+		resp       []byte
+	}{{
+		name:       "success",
+		wantName:   "laptop",
+		wantErrMsg: "",
+		resp: newNBSTATResponse(
+			req,
+			[]string{"FILESRV", "LAPTOP"},
+			[]byte{0x20, nbSuffixWorkstation},
+		),
+	}, {
+		name:       "no_workstation",
+		wantName:   "",
+		wantErrMsg: "",
+		resp:       newNBSTATResponse(req, []string{"FILESRV"}, []byte{0x20}),
+	}, {
+		name:       "short",
+		wantName:   "",
+		wantErrMsg: "response is too short: 12 bytes",
+		resp:       req[:nbHeaderLen],
+	}, {
+		name:       "bad_id",
+		wantName:   "",
+		wantErrMsg: "response id mismatch",
+		resp: newNBSTATResponse(
+			append([]byte{0, 0}, req[2:]...),
+			[]string{"LAPTOP"},
+			[]byte{nbSuffixWorkstation},
+		),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := parseNBSTATResponse(tc.resp, id)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantName, name)
+		})
+	}
+}
@@ -5,88 +5,227 @@ import (
 	"fmt"
 	"net/http"
 	"net/netip"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/geoip"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/urlfilter"
 	"github.com/AdguardTeam/urlfilter/filterlist"
 	"github.com/AdguardTeam/urlfilter/rules"
+	"golang.org/x/exp/slices"
 )
 
 // unit is a convenient alias for struct{}
 type unit = struct{}
 
+// Prefixes of the access rules matching the clients by their geolocation.
+const (
+	accessPrefixASN     = "asn:"
+	accessPrefixCountry = "country:"
+)
+
 // accessManager controls IP and client blocking that takes place before all
 // other processing.  An accessManager is safe for concurrent use.
 type accessManager struct {
-	allowedIPs map[netip.Addr]unit
-	blockedIPs map[netip.Addr]unit
-
-	allowedClientIDs *stringutil.Set
-	blockedClientIDs *stringutil.Set
+	allowed *accessClients
+	blocked *accessClients
 
 	// TODO(s.chzhen):  Use [aghnet.IgnoreEngine].
 	blockedHostsEng *urlfilter.DNSEngine
 
+	// blockedHostsHits are the numbers of the requests matched by each of the
+	// blocked hosts rules.  The map itself is never modified after creation.
+	blockedHostsHits map[string]*atomic.Uint64
+
+	// geoIP is used to look up the ASNs and the countries of the clients.  It
+	// may be nil, if there are no ASN or country rules.
+	geoIP *geoip.Database
+}
+
+// accessClients is a list of the access rules for clients.
+type accessClients struct {
+	ips       map[netip.Addr]unit
+	clientIDs *stringutil.Set
+
 	// TODO(a.garipov): Create a type for a set of IP networks.
-	allowedNets []netip.Prefix
-	blockedNets []netip.Prefix
+	nets []netip.Prefix
+
+	asns      map[uint32]unit
+	countries *stringutil.Set
+
+	// hits are the numbers of the requests matched by each of the rules in
+	// their canonical form.  The map itself is never modified after creation.
+	hits map[string]*atomic.Uint64
 }
 
-// processAccessClients is a helper for processing a list of client strings,
-// which may be an IP address, a CIDR, or a ClientID.
-func processAccessClients(
-	clientStrs []string,
-	ips map[netip.Addr]unit,
-	nets *[]netip.Prefix,
-	clientIDs *stringutil.Set,
-) (err error) {
+// newAccessClients returns a new list of access rules parsed from clientStrs,
+// each of which may be an IP address, a CIDR, an ASN, a country code, or a
+// ClientID.  geoIP must not be nil if there are ASN or country rules.
+func newAccessClients(clientStrs []string, geoIP *geoip.Database) (c *accessClients, err error) {
+	c = &accessClients{
+		ips:       map[netip.Addr]unit{},
+		clientIDs: stringutil.NewSet(),
+		asns:      map[uint32]unit{},
+		countries: stringutil.NewSet(),
+		hits:      make(map[string]*atomic.Uint64, len(clientStrs)),
+	}
+
 	for i, s := range clientStrs {
-		var ip netip.Addr
-		var ipnet netip.Prefix
-		if ip, err = netip.ParseAddr(s); err == nil {
-			ips[ip] = unit{}
-		} else if ipnet, err = netip.ParsePrefix(s); err == nil {
-			*nets = append(*nets, ipnet)
-		} else {
-			err = ValidateClientID(s)
-			if err != nil {
-				return fmt.Errorf("value %q at index %d: bad ip, cidr, or clientid", s, i)
-			}
-
-			clientIDs.Add(s)
+		var rule string
+		rule, err = c.add(s, geoIP)
+		if err != nil {
+			return nil, fmt.Errorf("value %q at index %d: %w", s, i, err)
 		}
+
+		c.hits[rule] = &atomic.Uint64{}
 	}
 
-	return nil
+	return c, nil
 }
 
-// newAccessCtx creates a new accessCtx.
-func newAccessCtx(allowed, blocked, blockedHosts []string) (a *accessManager, err error) {
-	a = &accessManager{
-		allowedIPs: map[netip.Addr]unit{},
-		blockedIPs: map[netip.Addr]unit{},
+// add parses s and adds it to c.  rule is the canonical form of s.
+func (c *accessClients) add(s string, geoIP *geoip.Database) (rule string, err error) {
+	if ip, ipErr := netip.ParseAddr(s); ipErr == nil {
+		c.ips[ip] = unit{}
+
+		return ip.String(), nil
+	} else if ipnet, netErr := netip.ParsePrefix(s); netErr == nil {
+		c.nets = append(c.nets, ipnet)
 
-		allowedClientIDs: stringutil.NewSet(),
-		blockedClientIDs: stringutil.NewSet(),
+		return ipnet.String(), nil
 	}
 
-	err = processAccessClients(allowed, a.allowedIPs, &a.allowedNets, a.allowedClientIDs)
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, accessPrefixASN) || strings.HasPrefix(lower, accessPrefixCountry) {
+		if geoIP == nil {
+			return "", errors.Error("asn and country rules require geoip databases")
+		}
+
+		return c.addGeo(lower)
+	}
+
+	err = ValidateClientID(s)
+	if err != nil {
+		return "", errors.Error("bad ip, cidr, asn, country, or clientid")
+	}
+
+	c.clientIDs.Add(s)
+
+	return s, nil
+}
+
+// addGeo parses the lowercased ASN or country rule s and adds it to c.  rule is
+// the canonical form of s.
+func (c *accessClients) addGeo(s string) (rule string, err error) {
+	if v, ok := strings.CutPrefix(s, accessPrefixASN); ok {
+		var asn uint64
+		asn, err = strconv.ParseUint(strings.TrimPrefix(v, "as"), 10, 32)
+		if err != nil || asn == 0 {
+			return "", errors.Error("bad asn")
+		}
+
+		c.asns[uint32(asn)] = unit{}
+
+		return accessPrefixASN + strconv.FormatUint(asn, 10), nil
+	}
+
+	cc := strings.ToUpper(strings.TrimPrefix(s, accessPrefixCountry))
+	if len(cc) != 2 || strings.Trim(cc, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", errors.Error("bad country code")
+	}
+
+	c.countries.Add(cc)
+
+	return accessPrefixCountry + cc, nil
+}
+
+// isEmpty returns true if c contains no rules.
+func (c *accessClients) isEmpty() (ok bool) {
+	return len(c.hits) == 0
+}
+
+// hasGeo returns true if c contains ASN or country rules.
+func (c *accessClients) hasGeo() (ok bool) {
+	return len(c.asns) != 0 || c.countries.Len() != 0
+}
+
+// matchIP returns the canonical form of the rule matching ip, if any.
+func (c *accessClients) matchIP(ip netip.Addr, geoIP *geoip.Database) (rule string) {
+	if _, ok := c.ips[ip]; ok {
+		return ip.String()
+	}
+
+	for _, ipnet := range c.nets {
+		if ipnet.Contains(ip) {
+			return ipnet.String()
+		}
+	}
+
+	if !c.hasGeo() || geoIP == nil {
+		return ""
+	}
+
+	info, err := geoIP.Lookup(ip)
+	if err != nil {
+		log.Debug("access: looking up %s: %s", ip, err)
+	}
+
+	if _, ok := c.asns[info.ASN]; ok && info.ASN != 0 {
+		return accessPrefixASN + strconv.FormatUint(uint64(info.ASN), 10)
+	} else if info.Country != "" && c.countries.Has(info.Country) {
+		return accessPrefixCountry + info.Country
+	}
+
+	return ""
+}
+
+// hitCounts returns the current numbers of the hits of each rule in c.
+func hitCounts(hits map[string]*atomic.Uint64) (counts map[string]uint64) {
+	counts = make(map[string]uint64, len(hits))
+	for rule, n := range hits {
+		counts[rule] = n.Load()
+	}
+
+	return counts
+}
+
+// newAccessCtx creates a new accessCtx.  geoIP is used to match the ASN and
+// country rules, if any.
+func newAccessCtx(
+	allowed []string,
+	blocked []string,
+	blockedHosts []string,
+	geoIP *geoip.Database,
+) (a *accessManager, err error) {
+	a = &accessManager{
+		blockedHostsHits: make(map[string]*atomic.Uint64, len(blockedHosts)),
+		geoIP:            geoIP,
+	}
+
+	a.allowed, err = newAccessClients(allowed, geoIP)
 	if err != nil {
 		return nil, fmt.Errorf("adding allowed: %w", err)
 	}
 
-	err = processAccessClients(blocked, a.blockedIPs, &a.blockedNets, a.blockedClientIDs)
+	a.blocked, err = newAccessClients(blocked, geoIP)
 	if err != nil {
 		return nil, fmt.Errorf("adding blocked: %w", err)
 	}
 
 	b := &strings.Builder{}
 	for _, h := range blockedHosts {
-		stringutil.WriteToBuilder(b, strings.ToLower(h), "\n")
+		h = strings.ToLower(h)
+		stringutil.WriteToBuilder(b, h, "\n")
+
+		if h = strings.TrimSpace(h); h != "" {
+			a.blockedHostsHits[h] = &atomic.Uint64{}
+		}
 	}
 
 	lists := []filterlist.RuleList{
@@ -109,7 +248,7 @@ func newAccessCtx(allowed, blocked, blockedHosts []string) (a *accessManager, er
 
 // allowlistMode returns true if this *accessCtx is in the allowlist mode.
 func (a *accessManager) allowlistMode() (ok bool) {
-	return len(a.allowedIPs) != 0 || a.allowedClientIDs.Len() != 0 || len(a.allowedNets) != 0
+	return !a.allowed.isEmpty()
 }
 
 // isBlockedClientID returns true if the ClientID should be blocked.
@@ -122,47 +261,100 @@ func (a *accessManager) isBlockedClientID(id string) (ok bool) {
 	}
 
 	if allowlistMode {
-		return !a.allowedClientIDs.Has(id)
+		return !a.allowed.clientIDs.Has(id)
 	}
 
-	return a.blockedClientIDs.Has(id)
+	return a.blocked.clientIDs.Has(id)
 }
 
-// isBlockedHost returns true if host should be blocked.
-func (a *accessManager) isBlockedHost(host string, qt rules.RRType) (ok bool) {
-	_, ok = a.blockedHostsEng.MatchRequest(&urlfilter.DNSRequest{
+// isBlockedHost returns true if host should be blocked.  rule is the text of
+// the matched rule, if any.
+func (a *accessManager) isBlockedHost(host string, qt rules.RRType) (ok bool, rule string) {
+	res, ok := a.blockedHostsEng.MatchRequest(&urlfilter.DNSRequest{
 		Hostname: host,
 		DNSType:  qt,
 	})
+	if !ok || res == nil {
+		return ok, ""
+	}
+
+	if res.NetworkRule != nil {
+		return true, res.NetworkRule.Text()
+	} else if len(res.HostRulesV4) > 0 {
+		return true, res.HostRulesV4[0].Text()
+	} else if len(res.HostRulesV6) > 0 {
+		return true, res.HostRulesV6[0].Text()
+	}
 
-	return ok
+	return true, ""
 }
 
 // isBlockedIP returns the status of the IP address blocking as well as the rule
 // that blocked it.
 func (a *accessManager) isBlockedIP(ip netip.Addr) (blocked bool, rule string) {
 	blocked = true
-	ips := a.blockedIPs
-	ipnets := a.blockedNets
+	list := a.blocked
 
 	if a.allowlistMode() {
 		// Enable allowlist mode and use the allowlist sets.
 		blocked = false
-		ips = a.allowedIPs
-		ipnets = a.allowedNets
+		list = a.allowed
 	}
 
-	if _, ok := ips[ip]; ok {
-		return blocked, ip.String()
+	rule = list.matchIP(ip, a.geoIP)
+	if rule != "" {
+		return blocked, rule
 	}
 
-	for _, ipnet := range ipnets {
-		if ipnet.Contains(ip) {
-			return blocked, ipnet.String()
-		}
+	return !blocked, ""
+}
+
+// isBlockedClient returns true if the client with ip and clientID is blocked.
+// rule is the rule that blocked it or the ClientID.
+func (a *accessManager) isBlockedClient(ip netip.Addr, clientID string) (blocked bool, rule string) {
+	blockedByIP := false
+	if ip != (netip.Addr{}) {
+		blockedByIP, rule = a.isBlockedIP(ip)
 	}
 
-	return !blocked, ""
+	allowlistMode := a.allowlistMode()
+	blockedByClientID := a.isBlockedClientID(clientID)
+
+	// Allow if at least one of the checks allows in allowlist mode, but block
+	// if at least one of the checks blocks in blocklist mode.
+	if allowlistMode && blockedByIP && blockedByClientID {
+		log.Debug("dnsforward: client %v (id %q) is not in access allowlist", ip, clientID)
+
+		// Return now without substituting the empty rule for the
+		// clientID because the rule can't be empty here.
+		return true, rule
+	} else if !allowlistMode && (blockedByIP || blockedByClientID) {
+		log.Debug("dnsforward: client %v (id %q) is in access blocklist", ip, clientID)
+
+		blocked = true
+	}
+
+	return blocked, aghalg.Coalesce(rule, clientID)
+}
+
+// countClientHit increments the number of the hits of the client rule.  rule
+// is the rule returned by [Server.IsBlockedClient].
+func (a *accessManager) countClientHit(rule string) {
+	list := a.blocked
+	if a.allowlistMode() {
+		list = a.allowed
+	}
+
+	if n, ok := list.hits[rule]; ok {
+		n.Add(1)
+	}
+}
+
+// countHostHit increments the number of the hits of the blocked hosts rule.
+func (a *accessManager) countHostHit(rule string) {
+	if n, ok := a.blockedHostsHits[rule]; ok {
+		n.Add(1)
+	}
 }
 
 type accessListJSON struct {
@@ -186,6 +378,35 @@ func (s *Server) handleAccessList(w http.ResponseWriter, r *http.Request) {
 	aghhttp.WriteJSONResponseOK(w, r, s.accessListJSON())
 }
 
+// accessHitsJSON is the numbers of the requests matched by each rule of the
+// access lists since the lists were last changed.
+type accessHitsJSON struct {
+	AllowedClients    map[string]uint64 `json:"allowed_clients"`
+	DisallowedClients map[string]uint64 `json:"disallowed_clients"`
+	BlockedHosts      map[string]uint64 `json:"blocked_hosts"`
+}
+
+// handleAccessHits is the handler for the GET /control/access/hits HTTP API.
+func (s *Server) handleAccessHits(w http.ResponseWriter, r *http.Request) {
+	s.serverLock.RLock()
+	a := s.access
+	s.serverLock.RUnlock()
+
+	resp := &accessHitsJSON{
+		AllowedClients:    map[string]uint64{},
+		DisallowedClients: map[string]uint64{},
+		BlockedHosts:      map[string]uint64{},
+	}
+
+	if a != nil {
+		resp.AllowedClients = hitCounts(a.allowed.hits)
+		resp.DisallowedClients = hitCounts(a.blocked.hits)
+		resp.BlockedHosts = hitCounts(a.blockedHostsHits)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
 // validateAccessSet checks the internal accessListJSON lists.  To search for
 // duplicates, we cannot compare the new stringutil.Set and []string, because
 // creating a set for a large array can be an unnecessary algorithmic complexity
@@ -240,8 +461,12 @@ func (s *Server) handleAccessSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.serverLock.RLock()
+	geoIP := s.geoIP
+	s.serverLock.RUnlock()
+
 	var a *accessManager
-	a, err = newAccessCtx(list.AllowedClients, list.DisallowedClients, list.BlockedHosts)
+	a, err = newAccessCtx(list.AllowedClients, list.DisallowedClients, list.BlockedHosts, geoIP)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "creating access ctx: %s", err)
 
@@ -265,3 +490,59 @@ func (s *Server) handleAccessSet(w http.ResponseWriter, r *http.Request) {
 	s.conf.BlockedHosts = list.BlockedHosts
 	s.access = a
 }
+
+// DisallowedClients returns a copy of the list of the disallowed clients.
+func (s *Server) DisallowedClients() (clients []string) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	return stringutil.CloneSlice(s.conf.DisallowedClients)
+}
+
+// AddDisallowedClients adds the clients, which aren't disallowed yet, to the
+// list of the disallowed clients.  Each client must be an IP address, a CIDR,
+// an ASN, a country, or a ClientID.  added is the number of the new clients.
+func (s *Server) AddDisallowedClients(clients []string) (added int, err error) {
+	s.serverLock.Lock()
+	defer s.serverLock.Unlock()
+
+	list := &accessListJSON{
+		AllowedClients:    s.conf.AllowedClients,
+		DisallowedClients: stringutil.CloneSlice(s.conf.DisallowedClients),
+		BlockedHosts:      s.conf.BlockedHosts,
+	}
+
+	for _, c := range clients {
+		if !slices.Contains(list.DisallowedClients, c) {
+			list.DisallowedClients = append(list.DisallowedClients, c)
+			added++
+		}
+	}
+
+	if added == 0 {
+		return 0, nil
+	}
+
+	err = validateAccessSet(list)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return 0, err
+	}
+
+	a, err := newAccessCtx(
+		list.AllowedClients,
+		list.DisallowedClients,
+		list.BlockedHosts,
+		s.geoIP,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("creating access ctx: %w", err)
+	}
+
+	s.conf.DisallowedClients = list.DisallowedClients
+	s.access = a
+
+	log.Debug("access: added %d disallowed clients", added)
+
+	return added, nil
+}
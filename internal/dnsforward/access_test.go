@@ -2,8 +2,12 @@ package dnsforward
 
 import (
 	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/geoip"
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
@@ -14,12 +18,12 @@ func TestIsBlockedClientID(t *testing.T) {
 	clientID := "client-1"
 	clients := []string{clientID}
 
-	a, err := newAccessCtx(clients, nil, nil)
+	a, err := newAccessCtx(clients, nil, nil, nil)
 	require.NoError(t, err)
 
 	assert.False(t, a.isBlockedClientID(clientID))
 
-	a, err = newAccessCtx(nil, clients, nil)
+	a, err = newAccessCtx(nil, clients, nil, nil)
 	require.NoError(t, err)
 
 	assert.True(t, a.isBlockedClientID(clientID))
@@ -32,7 +36,7 @@ func TestIsBlockedHost(t *testing.T) {
 		"||host3.com^",
 		"||*^$dnstype=HTTPS",
 		"|.^",
-	})
+	}, nil)
 	require.NoError(t, err)
 
 	testCases := []struct {
@@ -104,7 +108,8 @@ func TestIsBlockedHost(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			tc.want(t, a.isBlockedHost(tc.host, tc.qt))
+			blocked, _ := a.isBlockedHost(tc.host, tc.qt)
+			tc.want(t, blocked)
 		})
 	}
 }
@@ -115,10 +120,10 @@ func TestIsBlockedIP(t *testing.T) {
 		"5.6.7.8/24",
 	}
 
-	allowCtx, err := newAccessCtx(clients, nil, nil)
+	allowCtx, err := newAccessCtx(clients, nil, nil, nil)
 	require.NoError(t, err)
 
-	blockCtx, err := newAccessCtx(nil, clients, nil)
+	blockCtx, err := newAccessCtx(nil, clients, nil, nil)
 	require.NoError(t, err)
 
 	testCases := []struct {
@@ -164,3 +169,151 @@ func TestIsBlockedIP(t *testing.T) {
 		}
 	})
 }
+
+// newTestGeoIP returns a GeoIP database, in which the addresses from
+// 0.0.0.0/1 belong to the AS64496 in Germany.
+func newTestGeoIP(t *testing.T) (db *geoip.Database) {
+	t.Helper()
+
+	b := []byte{
+		// The search tree with a single node, which left record points to
+		// the data and the right one means "not found".
+		0x00, 0x00, 0x11, 0x00, 0x00, 0x01,
+	}
+
+	// The data section separator.
+	b = append(b, make([]byte, 16)...)
+
+	// The data section.
+	b = append(b, 0xe2, 0x58)
+	b = append(b, "autonomous_system_number"...)
+	b = append(b, 0xc2, 0xfb, 0xf0, 0x47)
+	b = append(b, "country"...)
+	b = append(b, 0x42, 'D', 'E')
+
+	// The metadata.
+	b = append(b, "\xab\xcd\xefMaxMind.com"...)
+	b = append(b, 0xe3, 0x4a)
+	b = append(b, "ip_version"...)
+	b = append(b, 0xa1, 0x04, 0x4a)
+	b = append(b, "node_count"...)
+	b = append(b, 0xa1, 0x01, 0x4b)
+	b = append(b, "record_size"...)
+	b = append(b, 0xa1, 0x18)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	err := os.WriteFile(path, b, 0o644)
+	require.NoError(t, err)
+
+	db, err = geoip.New([]string{path}, 0)
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestNewAccessCtx_geo(t *testing.T) {
+	geoIP := newTestGeoIP(t)
+
+	testCases := []struct {
+		geoIP      *geoip.Database
+		name       string
+		rule       string
+		wantErrMsg string
+	}{{
+		geoIP:      geoIP,
+		name:       "asn",
+		rule:       "asn:64496",
+		wantErrMsg: "",
+	}, {
+		geoIP:      geoIP,
+		name:       "asn_prefixed",
+		rule:       "ASN:AS64496",
+		wantErrMsg: "",
+	}, {
+		geoIP:      geoIP,
+		name:       "country",
+		rule:       "country:de",
+		wantErrMsg: "",
+	}, {
+		geoIP: nil,
+		name:  "no_geoip",
+		rule:  "country:DE",
+		wantErrMsg: `adding blocked: value "country:DE" at index 0: ` +
+			`asn and country rules require geoip databases`,
+	}, {
+		geoIP:      geoIP,
+		name:       "bad_asn",
+		rule:       "asn:abc",
+		wantErrMsg: `adding blocked: value "asn:abc" at index 0: bad asn`,
+	}, {
+		geoIP:      geoIP,
+		name:       "bad_country",
+		rule:       "country:DEU",
+		wantErrMsg: `adding blocked: value "country:DEU" at index 0: bad country code`,
+	}, {
+		geoIP: geoIP,
+		name:  "bad_clientid",
+		rule:  "bad.clientid",
+		wantErrMsg: `adding blocked: value "bad.clientid" at index 0: ` +
+			`bad ip, cidr, asn, country, or clientid`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newAccessCtx(nil, []string{tc.rule}, nil, tc.geoIP)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestIsBlockedIP_geo(t *testing.T) {
+	geoIP := newTestGeoIP(t)
+
+	matchingIP := netip.MustParseAddr("1.2.3.4")
+	otherIP := netip.MustParseAddr("192.0.2.1")
+
+	a, err := newAccessCtx(nil, []string{"ASN:AS64496"}, nil, geoIP)
+	require.NoError(t, err)
+
+	blocked, rule := a.isBlockedIP(matchingIP)
+	assert.True(t, blocked)
+	assert.Equal(t, "asn:64496", rule)
+
+	blocked, rule = a.isBlockedIP(otherIP)
+	assert.False(t, blocked)
+	assert.Empty(t, rule)
+
+	a, err = newAccessCtx([]string{"country:de"}, nil, nil, geoIP)
+	require.NoError(t, err)
+
+	blocked, rule = a.isBlockedIP(matchingIP)
+	assert.False(t, blocked)
+	assert.Equal(t, "country:DE", rule)
+
+	blocked, rule = a.isBlockedIP(otherIP)
+	assert.True(t, blocked)
+	assert.Empty(t, rule)
+}
+
+func TestAccessManager_countHits(t *testing.T) {
+	a, err := newAccessCtx(nil, []string{"1.2.3.4", "5.6.7.8/24"}, []string{"||host.com^"}, nil)
+	require.NoError(t, err)
+
+	_, rule := a.isBlockedIP(netip.MustParseAddr("5.6.7.100"))
+	a.countClientHit(rule)
+	a.countClientHit(rule)
+
+	_, rule = a.isBlockedHost("sub.host.com", dns.TypeA)
+	a.countHostHit(rule)
+
+	// Unknown rules are ignored.
+	a.countClientHit("")
+	a.countHostHit("unknown")
+
+	assert.Equal(t, map[string]uint64{
+		"1.2.3.4":    0,
+		"5.6.7.8/24": 2,
+	}, hitCounts(a.blocked.hits))
+	assert.Equal(t, map[string]uint64{}, hitCounts(a.allowed.hits))
+	assert.Equal(t, map[string]uint64{"||host.com^": 1}, hitCounts(a.blockedHostsHits))
+}
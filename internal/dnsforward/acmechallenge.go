@@ -0,0 +1,101 @@
+package dnsforward
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// acmeChallengeTTL is the TTL of the TXT records of the ACME DNS-01
+// challenges.  It's low, since the records are short-lived.
+const acmeChallengeTTL = 10
+
+// acmeChallenges are the TXT records of the pending ACME DNS-01 challenges,
+// which the server answers authoritatively.
+type acmeChallenges struct {
+	// mu protects records.
+	mu *sync.RWMutex
+
+	// records are the values of the TXT records by the lowercased FQDNs.
+	records map[string][]string
+}
+
+// newACMEChallenges returns a new properly initialized *acmeChallenges.
+func newACMEChallenges() (c *acmeChallenges) {
+	return &acmeChallenges{
+		mu:      &sync.RWMutex{},
+		records: map[string][]string{},
+	}
+}
+
+// AddACMEChallenge makes the server answer the TXT requests for fqdn with
+// value until [Server.RemoveACMEChallenge] is called.  It's used to fulfill the
+// ACME DNS-01 challenges for the domains delegated to AdGuard Home.
+func (s *Server) AddACMEChallenge(fqdn, value string) {
+	c := s.acmeChallenges
+	fqdn = strings.ToLower(dns.Fqdn(fqdn))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !slices.Contains(c.records[fqdn], value) {
+		c.records[fqdn] = append(c.records[fqdn], value)
+	}
+}
+
+// RemoveACMEChallenge removes the TXT record added by
+// [Server.AddACMEChallenge].
+func (s *Server) RemoveACMEChallenge(fqdn, value string) {
+	c := s.acmeChallenges
+	fqdn = strings.ToLower(dns.Fqdn(fqdn))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vals := slices.DeleteFunc(c.records[fqdn], func(v string) (ok bool) { return v == value })
+	if len(vals) == 0 {
+		delete(c.records, fqdn)
+	} else {
+		c.records[fqdn] = vals
+	}
+}
+
+// processACMEChallenge responds to the requests for the names of the pending
+// ACME DNS-01 challenges.
+func (s *Server) processACMEChallenge(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing acme challenges")
+	defer log.Debug("dnsforward: finished processing acme challenges")
+
+	c := s.acmeChallenges
+	if c == nil {
+		return resultCodeSuccess
+	}
+
+	pctx := dctx.proxyCtx
+	q := pctx.Req.Question[0]
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	vals, ok := c.records[strings.ToLower(q.Name)]
+	if !ok {
+		return resultCodeSuccess
+	}
+
+	resp := s.makeResponse(pctx.Req)
+	resp.Authoritative = true
+	if q.Qtype == dns.TypeTXT {
+		for _, v := range vals {
+			ans := s.genAnswerTXT(pctx.Req, []string{v})
+			ans.Hdr.Ttl = acmeChallengeTTL
+			resp.Answer = append(resp.Answer, ans)
+		}
+	}
+
+	pctx.Res = resp
+
+	return resultCodeFinish
+}
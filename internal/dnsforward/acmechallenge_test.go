@@ -0,0 +1,162 @@
+package dnsforward
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ProcessACMEChallenge(t *testing.T) {
+	s := &Server{
+		dnsFilter:      createTestDNSFilter(t),
+		acmeChallenges: newACMEChallenges(),
+	}
+
+	const (
+		fqdn = "_acme-challenge.example.com."
+		val1 = "value-1"
+		val2 = "value-2"
+	)
+
+	s.AddACMEChallenge("_ACME-Challenge.example.com", val1)
+	s.AddACMEChallenge(fqdn, val2)
+	s.AddACMEChallenge(fqdn, val2)
+
+	process := func(host string, qtype uint16) (rc resultCode, resp *dns.Msg) {
+		dctx := &dnsContext{
+			proxyCtx: &proxy.DNSContext{
+				Req: createTestMessageWithType(host, qtype),
+			},
+		}
+
+		rc = s.processACMEChallenge(dctx)
+
+		return rc, dctx.proxyCtx.Res
+	}
+
+	rc, resp := process(fqdn, dns.TypeTXT)
+	require.Equal(t, resultCodeFinish, rc)
+	require.NotNil(t, resp)
+
+	assert.True(t, resp.Authoritative)
+	require.Len(t, resp.Answer, 2)
+
+	var vals []string
+	for _, rr := range resp.Answer {
+		txt := testutil.RequireTypeAssert[*dns.TXT](t, rr)
+		assert.Equal(t, uint32(acmeChallengeTTL), txt.Hdr.Ttl)
+		vals = append(vals, txt.Txt...)
+	}
+
+	assert.Equal(t, []string{val1, val2}, vals)
+
+	rc, resp = process(fqdn, dns.TypeA)
+	require.Equal(t, resultCodeFinish, rc)
+	require.NotNil(t, resp)
+
+	assert.Empty(t, resp.Answer)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+
+	rc, _ = process("other.example.com.", dns.TypeTXT)
+	assert.Equal(t, resultCodeSuccess, rc)
+
+	s.RemoveACMEChallenge(fqdn, val1)
+	s.RemoveACMEChallenge(fqdn, val2)
+
+	rc, _ = process(fqdn, dns.TypeTXT)
+	assert.Equal(t, resultCodeSuccess, rc)
+}
+
+// newTestCertificate returns a new self-signed PEM-encoded certificate and its
+// private key for name.
+func newTestCertificate(t *testing.T, name string) (certPem, keyPem []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certPem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return certPem, keyPem
+}
+
+func TestServer_UpdateCertificate(t *testing.T) {
+	s, oldCertPem := createTestTLS(t, TLSConfig{
+		TLSListenAddrs: []*net.TCPAddr{{}},
+	})
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{newGoogleUpstream()}
+	startDeferStop(t, s)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoTLS).String()
+	newTLSConfig := func(certPem []byte) (conf *tls.Config) {
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(certPem)
+
+		return &tls.Config{
+			ServerName: tlsServerName,
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	conn, err := dns.DialWithTLS("tcp-tls", addr, newTLSConfig(oldCertPem))
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	sendTestMessages(t, conn)
+
+	certPem, keyPem := newTestCertificate(t, "other.example")
+	err = s.UpdateCertificate(certPem, keyPem)
+	testutil.AssertErrorMsg(t, "updating certificate: names in certificate have changed", err)
+
+	certPem, keyPem = newTestCertificate(t, tlsServerName)
+	err = s.UpdateCertificate(certPem, keyPem)
+	require.NoError(t, err)
+
+	// The established connection is kept.
+	sendTestMessages(t, conn)
+
+	// The new connections use the new certificate.
+	_, err = dns.DialWithTLS("tcp-tls", addr, newTLSConfig(oldCertPem))
+	require.Error(t, err)
+
+	newConn, err := dns.DialWithTLS("tcp-tls", addr, newTLSConfig(certPem))
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, newConn.Close)
+
+	sendTestMessages(t, newConn)
+}
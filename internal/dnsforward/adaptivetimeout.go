@@ -0,0 +1,267 @@
+package dnsforward
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// AdaptiveTimeoutConfig is the configuration of the per-upstream adaptive
+// timeouts, which are derived from the recently measured round-trip times of
+// each upstream.  The global upstream timeout is used as the ceiling.
+type AdaptiveTimeoutConfig struct {
+	// Min is the floor of the adaptive timeouts.
+	Min timeutil.Duration `yaml:"min"`
+
+	// Multiplier is the factor, by which the percentile of the round-trip
+	// times is multiplied to get the timeout.
+	Multiplier float64 `yaml:"multiplier"`
+
+	// Percentile is the percentile of the recent round-trip times of an
+	// upstream, from which its timeout is derived.  It must be between 1 and
+	// 100.
+	Percentile int `yaml:"percentile"`
+
+	// Enabled defines if the adaptive timeouts are used.
+	Enabled bool `yaml:"enabled"`
+}
+
+const (
+	// rttWindowSize is the number of the most recent round-trip times kept
+	// for each upstream.
+	rttWindowSize = 100
+
+	// minRTTSamples is the number of the measured round-trip times required
+	// before the adaptive timeout is applied to an upstream.
+	minRTTSamples = 10
+
+	// maxAbandonedExchanges is the maximum number of the exchanges with an
+	// upstream, which have exceeded the adaptive timeout and are still
+	// waiting for the response.  Once it's reached, the adaptive timeout isn't
+	// applied until some of them finish, so that an unresponsive upstream
+	// doesn't pile up goroutines.
+	maxAbandonedExchanges = 64
+)
+
+// Exchange states of an [adaptiveUpstream].
+const (
+	exchangeRunning int32 = iota
+	exchangeFinished
+	exchangeAbandoned
+)
+
+// validateAdaptiveTimeout returns an error if c is invalid.  ceiling is the
+// global upstream timeout.
+func validateAdaptiveTimeout(c *AdaptiveTimeoutConfig, ceiling time.Duration) (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	defer func() { err = errors.Annotate(err, "adaptive timeout: %w") }()
+
+	switch {
+	case c.Min.Duration <= 0:
+		return fmt.Errorf("min: must be positive, got %s", c.Min)
+	case c.Min.Duration > ceiling:
+		return fmt.Errorf("min: must not be greater than upstream timeout %s, got %s", ceiling, c.Min)
+	case c.Percentile < 1 || c.Percentile > 100:
+		return fmt.Errorf("percentile: must be between 1 and 100, got %d", c.Percentile)
+	case c.Multiplier < 1:
+		return fmt.Errorf("multiplier: must be at least 1, got %g", c.Multiplier)
+	default:
+		return nil
+	}
+}
+
+// wrapAdaptiveUpstreams wraps each upstream in ups to use the adaptive timeout
+// according to c.  ceiling is the global upstream timeout.
+func wrapAdaptiveUpstreams(ups []upstream.Upstream, c *AdaptiveTimeoutConfig, ceiling time.Duration) {
+	if c == nil || !c.Enabled {
+		return
+	}
+
+	for i, u := range ups {
+		ups[i] = &adaptiveUpstream{
+			Upstream:  u,
+			rtts:      newRTTWindow(),
+			conf:      c,
+			abandoned: &atomic.Int32{},
+			ceiling:   ceiling,
+		}
+	}
+}
+
+// adaptiveUpstream is an [upstream.Upstream] that stops waiting for a response
+// after the timeout derived from its recent round-trip times.
+type adaptiveUpstream struct {
+	upstream.Upstream
+
+	// rtts are the recent round-trip times of the upstream.
+	rtts *rttWindow
+
+	// conf is the configuration of the adaptive timeout.
+	conf *AdaptiveTimeoutConfig
+
+	// abandoned is the number of the exchanges, which have exceeded the
+	// adaptive timeout and are still going on.
+	abandoned *atomic.Int32
+
+	// ceiling is the maximum timeout, which is also the timeout of the
+	// underlying upstream.
+	ceiling time.Duration
+}
+
+// type check
+var _ upstream.Upstream = (*adaptiveUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *adaptiveUpstream.
+func (u *adaptiveUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	timeout, ok := u.timeout()
+	if !ok || u.abandoned.Load() >= maxAbandonedExchanges {
+		return u.exchange(req)
+	}
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+
+	// Use a copy of the request, since the underlying exchange may still be
+	// going on after the timeout, when the request is sent to another
+	// upstream.  It can't be canceled, but it ends within the ceiling, which
+	// is the timeout of the underlying upstream.  The response is still used
+	// to measure the round-trip time, so that the timeout grows with the
+	// latency of the upstream.
+	reqCopy := req.Copy()
+	resCh := make(chan result, 1)
+	state := &atomic.Int32{}
+	go func() {
+		defer log.OnPanic("dnsforward: adaptive upstream")
+
+		r, exchErr := u.exchange(reqCopy)
+		resCh <- result{resp: r, err: exchErr}
+
+		if !state.CompareAndSwap(exchangeRunning, exchangeFinished) {
+			u.abandoned.Add(-1)
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-resCh:
+		return res.resp, res.err
+	case <-timer.C:
+		u.abandoned.Add(1)
+		if !state.CompareAndSwap(exchangeRunning, exchangeAbandoned) {
+			// The exchange has just finished.
+			u.abandoned.Add(-1)
+			res := <-resCh
+
+			return res.resp, res.err
+		}
+
+		return nil, fmt.Errorf("adaptive timeout %s exceeded: %w", timeout, os.ErrDeadlineExceeded)
+	}
+}
+
+// exchange sends req to the underlying upstream and records the round-trip
+// time of the successful exchange.
+func (u *adaptiveUpstream) exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	start := time.Now()
+	resp, err = u.Upstream.Exchange(req)
+	if err == nil {
+		u.rtts.add(time.Since(start))
+	}
+
+	return resp, err
+}
+
+// timeout returns the current adaptive timeout of the upstream.  ok is false
+// if there aren't enough measurements yet.
+func (u *adaptiveUpstream) timeout() (timeout time.Duration, ok bool) {
+	rtt, ok := u.rtts.percentile(u.conf.Percentile)
+	if !ok {
+		return 0, false
+	}
+
+	timeout = time.Duration(float64(rtt) * u.conf.Multiplier)
+	switch {
+	case timeout < u.conf.Min.Duration:
+		timeout = u.conf.Min.Duration
+	case timeout > u.ceiling:
+		timeout = u.ceiling
+	}
+
+	return timeout, true
+}
+
+// rttWindow is a rolling window of the most recent round-trip times.
+type rttWindow struct {
+	// mu protects all the fields.
+	mu *sync.Mutex
+
+	// samples is the ring buffer of the round-trip times.
+	samples []time.Duration
+
+	// sorted are the round-trip times from samples in the ascending order.
+	sorted []time.Duration
+
+	// next is the index in samples to write the next round-trip time to, once
+	// the buffer is full.
+	next int
+}
+
+// newRTTWindow returns a new empty rolling window of round-trip times.
+func newRTTWindow() (w *rttWindow) {
+	return &rttWindow{
+		mu:      &sync.Mutex{},
+		samples: make([]time.Duration, 0, rttWindowSize),
+		sorted:  make([]time.Duration, 0, rttWindowSize),
+	}
+}
+
+// add records rtt into w.
+func (w *rttWindow) add(rtt time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.samples) < rttWindowSize {
+		w.samples = append(w.samples, rtt)
+	} else {
+		i, _ := slices.BinarySearch(w.sorted, w.samples[w.next])
+		w.sorted = slices.Delete(w.sorted, i, i+1)
+
+		w.samples[w.next] = rtt
+		w.next = (w.next + 1) % rttWindowSize
+	}
+
+	i, _ := slices.BinarySearch(w.sorted, rtt)
+	w.sorted = slices.Insert(w.sorted, i, rtt)
+}
+
+// percentile returns the p-th percentile of the round-trip times in w using
+// the nearest-rank method.  ok is false if there aren't enough samples.
+func (w *rttWindow) percentile(p int) (rtt time.Duration, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.sorted) < minRTTSamples {
+		return 0, false
+	}
+
+	// Round up to get the nearest rank.
+	rank := (p*len(w.sorted) + 99) / 100
+
+	return w.sorted[rank-1], true
+}
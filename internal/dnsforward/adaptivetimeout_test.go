@@ -0,0 +1,171 @@
+package dnsforward
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAdaptiveTimeout(t *testing.T) {
+	const ceiling = 10 * time.Second
+
+	testCases := []struct {
+		conf       *AdaptiveTimeoutConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf: &AdaptiveTimeoutConfig{
+			Min:        timeutil.Duration{Duration: 100 * time.Millisecond},
+			Multiplier: 2,
+			Percentile: 99,
+			Enabled:    true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &AdaptiveTimeoutConfig{
+			Min:        timeutil.Duration{Duration: time.Minute},
+			Multiplier: 2,
+			Percentile: 99,
+			Enabled:    true,
+		},
+		name:       "big_min",
+		wantErrMsg: "adaptive timeout: min: must not be greater than upstream timeout 10s, got 1m",
+	}, {
+		conf: &AdaptiveTimeoutConfig{
+			Min:        timeutil.Duration{Duration: time.Second},
+			Multiplier: 2,
+			Percentile: 0,
+			Enabled:    true,
+		},
+		name:       "bad_percentile",
+		wantErrMsg: "adaptive timeout: percentile: must be between 1 and 100, got 0",
+	}, {
+		conf: &AdaptiveTimeoutConfig{
+			Min:        timeutil.Duration{Duration: time.Second},
+			Multiplier: 0.5,
+			Percentile: 90,
+			Enabled:    true,
+		},
+		name:       "bad_multiplier",
+		wantErrMsg: "adaptive timeout: multiplier: must be at least 1, got 0.5",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateAdaptiveTimeout(tc.conf, ceiling)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestRTTWindow_percentile(t *testing.T) {
+	w := newRTTWindow()
+	for i := 1; i < minRTTSamples; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+
+	_, ok := w.percentile(50)
+	assert.False(t, ok)
+
+	for i := minRTTSamples; i <= rttWindowSize; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+
+	rtt, ok := w.percentile(50)
+	require.True(t, ok)
+
+	assert.Equal(t, 50*time.Millisecond, rtt)
+
+	rtt, ok = w.percentile(100)
+	require.True(t, ok)
+
+	assert.Equal(t, 100*time.Millisecond, rtt)
+
+	// Overwrite the oldest samples.
+	for i := 0; i < rttWindowSize/2; i++ {
+		w.add(time.Second)
+	}
+
+	rtt, ok = w.percentile(50)
+	require.True(t, ok)
+
+	assert.Equal(t, 100*time.Millisecond, rtt)
+
+	rtt, ok = w.percentile(51)
+	require.True(t, ok)
+
+	assert.Equal(t, time.Second, rtt)
+}
+
+func TestAdaptiveUpstream_Exchange(t *testing.T) {
+	const (
+		fastRTT = time.Millisecond
+		minTO   = 50 * time.Millisecond
+	)
+
+	delay := &atomic.Int64{}
+	delay.Store(int64(fastRTT))
+
+	ups := []upstream.Upstream{&aghtest.UpstreamMock{
+		OnAddress: func() (addr string) { return "mock" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			time.Sleep(time.Duration(delay.Load()))
+
+			return (&dns.Msg{}).SetReply(req), nil
+		},
+	}}
+
+	wrapAdaptiveUpstreams(ups, &AdaptiveTimeoutConfig{
+		Min:        timeutil.Duration{Duration: minTO},
+		Multiplier: 2,
+		Percentile: 99,
+		Enabled:    true,
+	}, time.Second)
+
+	u, ok := ups[0].(*adaptiveUpstream)
+	require.True(t, ok)
+
+	req := createTestMessage(aghtest.ReqFQDN)
+	for i := 0; i < minRTTSamples; i++ {
+		_, err := u.Exchange(req)
+		require.NoError(t, err)
+	}
+
+	to, ok := u.timeout()
+	require.True(t, ok)
+
+	assert.Equal(t, minTO, to)
+
+	delay.Store(int64(4 * minTO))
+
+	_, err := u.Exchange(req)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// The abandoned exchange is still accounted until it ends.
+	assert.Equal(t, int32(1), u.abandoned.Load())
+	require.Eventually(t, func() (ok bool) {
+		return u.abandoned.Load() == 0
+	}, time.Second, minTO/10)
+
+	t.Run("too_many_abandoned", func(t *testing.T) {
+		u.abandoned.Store(maxAbandonedExchanges)
+		t.Cleanup(func() { u.abandoned.Store(0) })
+
+		_, err = u.Exchange(req)
+		assert.NoError(t, err)
+	})
+}
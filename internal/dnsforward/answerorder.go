@@ -0,0 +1,418 @@
+package dnsforward
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// AnswerOrderMode is the way the records of the multi-record answers are
+// ordered before being sent to the client.
+type AnswerOrderMode string
+
+// Answer order modes.
+const (
+	// AnswerOrderPreserve keeps the order of the records received from the
+	// upstream server.
+	AnswerOrderPreserve AnswerOrderMode = "preserve"
+
+	// AnswerOrderRoundRobin rotates the records of each RRset by one position
+	// with every response.
+	AnswerOrderRoundRobin AnswerOrderMode = "round_robin"
+
+	// AnswerOrderRTT sorts the A and AAAA records of each RRset by the
+	// round-trip time of the previous TCP connections to the addresses, the
+	// fastest first.  The addresses, which haven't been connected to yet, are
+	// placed last and connected to in the background.
+	AnswerOrderRTT AnswerOrderMode = "rtt"
+)
+
+// validate returns an error if m is not a valid answer order mode.
+func (m AnswerOrderMode) validate() (err error) {
+	switch m {
+	case AnswerOrderPreserve, AnswerOrderRoundRobin, AnswerOrderRTT:
+		return nil
+	default:
+		return fmt.Errorf("bad mode %q", m)
+	}
+}
+
+// AnswerOrderConfig is the configuration of the ordering of the records of the
+// multi-record answers.
+type AnswerOrderConfig struct {
+	// Mode is the mode used for the domain names not matching any of Domains.
+	Mode AnswerOrderMode `yaml:"mode"`
+
+	// Domains are the modes for particular domain names.  The most specific
+	// matching domain name is used.
+	Domains []*AnswerOrderDomainConfig `yaml:"domains"`
+}
+
+// AnswerOrderDomainConfig is the answer order mode for particular domain
+// names.
+type AnswerOrderDomainConfig struct {
+	// Mode is the mode used for Domains.
+	Mode AnswerOrderMode `yaml:"mode"`
+
+	// Domains are the domain names, to which the mode applies, including their
+	// subdomains.
+	Domains []string `yaml:"domains"`
+}
+
+// answerOrderer orders the records of the multi-record answers.
+type answerOrderer struct {
+	// rtts are the round-trip times of the connections to the addresses.
+	rtts *rttCache
+
+	// domains are the modes by the normalized domain names.
+	domains map[string]AnswerOrderMode
+
+	// mode is the mode for the domain names not matching any of domains.
+	mode AnswerOrderMode
+
+	// counter is the number of the responses rotated so far.
+	counter atomic.Uint32
+}
+
+// newAnswerOrderer returns a new answer orderer for conf.  o is nil if the
+// order of the records is preserved for all domain names.
+func newAnswerOrderer(conf *AnswerOrderConfig) (o *answerOrderer, err error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	mode := conf.Mode
+	if mode == "" {
+		mode = AnswerOrderPreserve
+	} else if err = mode.validate(); err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	o = &answerOrderer{
+		rtts:    newRTTCache(),
+		domains: map[string]AnswerOrderMode{},
+		mode:    mode,
+	}
+
+	for i, dc := range conf.Domains {
+		err = o.addDomains(dc)
+		if err != nil {
+			return nil, fmt.Errorf("domains at index %d: %w", i, err)
+		}
+	}
+
+	if o.mode == AnswerOrderPreserve && len(o.domains) == 0 {
+		return nil, nil
+	}
+
+	return o, nil
+}
+
+// addDomains validates dc and adds its domain names to o.
+func (o *answerOrderer) addDomains(dc *AnswerOrderDomainConfig) (err error) {
+	if dc == nil {
+		return errors.Error("no value")
+	} else if err = dc.Mode.validate(); err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if len(dc.Domains) == 0 {
+		return errors.Error("no domains")
+	}
+
+	for _, d := range dc.Domains {
+		err = netutil.ValidateDomainName(strings.TrimSuffix(d, "."))
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		o.domains[aghnet.NormalizeDomain(d)] = dc.Mode
+	}
+
+	return nil
+}
+
+// modeFor returns the mode for the domain name.
+func (o *answerOrderer) modeFor(name string) (mode AnswerOrderMode) {
+	name = aghnet.NormalizeDomain(name)
+	for name != "" {
+		if mode, ok := o.domains[name]; ok {
+			return mode
+		}
+
+		_, name, _ = strings.Cut(name, ".")
+	}
+
+	return o.mode
+}
+
+// order orders the records of each RRset in rrs according to mode.
+func (o *answerOrderer) order(rrs []dns.RR, mode AnswerOrderMode) {
+	for start := 0; start < len(rrs); {
+		end := rrsetEnd(rrs, start)
+		set := rrs[start:end]
+		start = end
+
+		if len(set) < 2 {
+			continue
+		}
+
+		switch mode {
+		case AnswerOrderRoundRobin:
+			rotateRRs(set, int(o.counter.Add(1)%uint32(len(set))))
+		case AnswerOrderRTT:
+			o.sortByRTT(set)
+		default:
+			// Go on.
+		}
+	}
+}
+
+// rrsetEnd returns the index of the first record in rrs after start, which
+// doesn't belong to the same RRset as the record at start.
+func rrsetEnd(rrs []dns.RR, start int) (end int) {
+	hdr := rrs[start].Header()
+	for end = start + 1; end < len(rrs); end++ {
+		h := rrs[end].Header()
+		if h.Rrtype != hdr.Rrtype || !strings.EqualFold(h.Name, hdr.Name) {
+			break
+		}
+	}
+
+	return end
+}
+
+// rotateRRs rotates rrs to the left by n positions.
+func rotateRRs(rrs []dns.RR, n int) {
+	rotated := append(slices.Clone(rrs[n:]), rrs[:n]...)
+	copy(rrs, rotated)
+}
+
+// sortByRTT sorts the A and AAAA records of rrs by the known round-trip times
+// of their addresses and starts measuring the unknown ones.
+func (o *answerOrderer) sortByRTT(rrs []dns.RR) {
+	rtts := make(map[dns.RR]time.Duration, len(rrs))
+	for _, rr := range rrs {
+		ip, ok := rrIP(rr)
+		if !ok {
+			return
+		}
+
+		rtt, ok := o.rtts.get(ip)
+		if !ok {
+			o.rtts.measure(ip)
+			rtt = maxRTT
+		}
+
+		rtts[rr] = rtt
+	}
+
+	slices.SortStableFunc(rrs, func(a, b dns.RR) (res int) {
+		ra, rb := rtts[a], rtts[b]
+		switch {
+		case ra < rb:
+			return -1
+		case ra > rb:
+			return 1
+		default:
+			return 0
+		}
+	})
+}
+
+// rrIP returns the address of rr if it's an A or AAAA record.
+func rrIP(rr dns.RR) (ip netip.Addr, ok bool) {
+	switch rr := rr.(type) {
+	case *dns.A:
+		ip, ok = netip.AddrFromSlice(rr.A.To4())
+	case *dns.AAAA:
+		ip, ok = netip.AddrFromSlice(rr.AAAA)
+	default:
+		return netip.Addr{}, false
+	}
+
+	return ip, ok
+}
+
+// Constants for the round-trip time measurements.
+const (
+	// rttMaxEntries is the maximum number of the addresses, for which the
+	// round-trip times are stored.
+	rttMaxEntries = 10_000
+
+	// rttEntryTTL is the duration, for which a measured round-trip time is
+	// used.
+	rttEntryTTL = 10 * time.Minute
+
+	// rttDialTimeout is the timeout of a single measuring connection.
+	rttDialTimeout = 1 * time.Second
+
+	// maxRTT is the round-trip time used for the unreachable addresses and
+	// the ones, which haven't been measured yet.
+	maxRTT time.Duration = math.MaxInt64
+)
+
+// rttProbePorts are the TCP ports, connections to which are used to measure
+// the round-trip times, in the order of trying.
+var rttProbePorts = []uint16{443, 80}
+
+// rttEntry is a measured round-trip time.
+type rttEntry struct {
+	// expires is the time, after which the entry must be measured again.
+	expires time.Time
+
+	// rtt is the round-trip time.  It's the largest possible duration if the
+	// address isn't reachable.
+	rtt time.Duration
+}
+
+// rttCache stores the round-trip times of the connections to the addresses.
+type rttCache struct {
+	// mu protects entries and measuring.
+	mu *sync.Mutex
+
+	// entries are the measured round-trip times.
+	entries map[netip.Addr]rttEntry
+
+	// measuring are the addresses being measured.
+	measuring map[netip.Addr]struct{}
+
+	// dial measures the round-trip time of a connection to ip.  ok is false if
+	// ip isn't reachable.  It's replaced in tests.
+	dial func(ip netip.Addr) (rtt time.Duration, ok bool)
+}
+
+// newRTTCache returns a new properly initialized *rttCache.
+func newRTTCache() (c *rttCache) {
+	return &rttCache{
+		mu:        &sync.Mutex{},
+		entries:   map[netip.Addr]rttEntry{},
+		measuring: map[netip.Addr]struct{}{},
+		dial:      dialRTT,
+	}
+}
+
+// get returns the round-trip time of the connection to ip, if it's known and
+// hasn't expired.
+func (c *rttCache) get(ip netip.Addr) (rtt time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[ip]
+	if !ok || time.Now().After(e.expires) {
+		return 0, false
+	}
+
+	return e.rtt, true
+}
+
+// measure starts measuring the round-trip time of the connection to ip in the
+// background, unless it's already being measured.
+func (c *rttCache) measure(ip netip.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.measuring[ip]; ok {
+		return
+	}
+
+	c.measuring[ip] = struct{}{}
+
+	go c.measureSync(ip)
+}
+
+// measureSync measures the round-trip time of the connection to ip and stores
+// it.
+func (c *rttCache) measureSync(ip netip.Addr) {
+	defer log.OnPanic("dnsforward: measuring rtt")
+
+	rtt, ok := c.dial(ip)
+	if !ok {
+		rtt = maxRTT
+	}
+
+	log.Debug("dnsforward: rtt for %s: %s, reachable: %t", ip, rtt, ok)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.measuring, ip)
+
+	now := time.Now()
+	if len(c.entries) >= rttMaxEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+
+		if len(c.entries) >= rttMaxEntries {
+			return
+		}
+	}
+
+	c.entries[ip] = rttEntry{
+		expires: now.Add(rttEntryTTL),
+		rtt:     rtt,
+	}
+}
+
+// dialRTT measures the round-trip time of a TCP connection to ip using the
+// first reachable port from rttProbePorts.
+func dialRTT(ip netip.Addr) (rtt time.Duration, ok bool) {
+	for _, port := range rttProbePorts {
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", addr, rttDialTimeout)
+		if err != nil {
+			log.Debug("dnsforward: measuring rtt: %s", err)
+
+			continue
+		}
+
+		rtt = time.Since(start)
+		if err = conn.Close(); err != nil {
+			log.Debug("dnsforward: measuring rtt: closing: %s", err)
+		}
+
+		return rtt, true
+	}
+
+	return 0, false
+}
+
+// processAnswerOrder orders the records of the multi-record answers according
+// to the answer order mode for the question name.
+func (s *Server) processAnswerOrder(dctx *dnsContext) (rc resultCode) {
+	o := s.answerOrder
+	pctx := dctx.proxyCtx
+	if o == nil || pctx.Res == nil || len(pctx.Res.Answer) < 2 {
+		return resultCodeSuccess
+	}
+
+	mode := o.modeFor(pctx.Req.Question[0].Name)
+	if mode == AnswerOrderPreserve {
+		return resultCodeSuccess
+	}
+
+	log.Debug("dnsforward: ordering answer using mode %q", mode)
+
+	o.order(pctx.Res.Answer, mode)
+
+	return resultCodeSuccess
+}
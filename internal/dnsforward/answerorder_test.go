@@ -0,0 +1,137 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAnswerOrderer(t *testing.T) {
+	testCases := []struct {
+		conf       *AnswerOrderConfig
+		name       string
+		wantErrMsg string
+		wantNil    bool
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+		wantNil:    true,
+	}, {
+		conf:       &AnswerOrderConfig{Mode: AnswerOrderPreserve},
+		name:       "preserve",
+		wantErrMsg: "",
+		wantNil:    true,
+	}, {
+		conf:       &AnswerOrderConfig{Mode: "random"},
+		name:       "bad_mode",
+		wantErrMsg: `bad mode "random"`,
+		wantNil:    true,
+	}, {
+		conf: &AnswerOrderConfig{
+			Domains: []*AnswerOrderDomainConfig{{Mode: AnswerOrderRTT}},
+		},
+		name:       "no_domains",
+		wantErrMsg: "domains at index 0: no domains",
+		wantNil:    true,
+	}, {
+		conf: &AnswerOrderConfig{
+			Domains: []*AnswerOrderDomainConfig{{
+				Mode:    AnswerOrderRoundRobin,
+				Domains: []string{"legacy.example"},
+			}},
+		},
+		name:       "domain",
+		wantErrMsg: "",
+		wantNil:    false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := newAnswerOrderer(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantNil, o == nil)
+		})
+	}
+}
+
+func TestAnswerOrderer(t *testing.T) {
+	o, err := newAnswerOrderer(&AnswerOrderConfig{
+		Mode: AnswerOrderRTT,
+		Domains: []*AnswerOrderDomainConfig{{
+			Mode:    AnswerOrderRoundRobin,
+			Domains: []string{"example.org"},
+		}, {
+			Mode:    AnswerOrderPreserve,
+			Domains: []string{"static.example.org"},
+		}},
+	})
+	require.NoError(t, err)
+
+	t.Run("mode_for", func(t *testing.T) {
+		assert.Equal(t, AnswerOrderRoundRobin, o.modeFor("www.example.org."))
+		assert.Equal(t, AnswerOrderPreserve, o.modeFor("a.static.example.org."))
+		assert.Equal(t, AnswerOrderRTT, o.modeFor("example.net."))
+	})
+
+	newAnswer := func() (rrs []dns.RR) {
+		return []dns.RR{
+			newRR(t, "www.example.org.", dns.TypeCNAME, 60, "cdn.example.org."),
+			newRR(t, "cdn.example.org.", dns.TypeA, 60, net.IP{192, 0, 2, 1}),
+			newRR(t, "cdn.example.org.", dns.TypeA, 60, net.IP{192, 0, 2, 2}),
+			newRR(t, "cdn.example.org.", dns.TypeA, 60, net.IP{192, 0, 2, 3}),
+		}
+	}
+
+	aAt := func(rrs []dns.RR, i int) (ip net.IP) {
+		return rrs[i].(*dns.A).A.To4()
+	}
+
+	t.Run("round_robin", func(t *testing.T) {
+		o.counter.Store(0)
+
+		rrs := newAnswer()
+		o.order(rrs, AnswerOrderRoundRobin)
+
+		assert.Equal(t, dns.TypeCNAME, rrs[0].Header().Rrtype)
+		assert.Equal(t, net.IP{192, 0, 2, 2}, aAt(rrs, 1))
+		assert.Equal(t, net.IP{192, 0, 2, 3}, aAt(rrs, 2))
+		assert.Equal(t, net.IP{192, 0, 2, 1}, aAt(rrs, 3))
+	})
+
+	t.Run("rtt", func(t *testing.T) {
+		measured := make(chan netip.Addr, 1)
+		o.rtts.dial = func(ip netip.Addr) (rtt time.Duration, ok bool) {
+			measured <- ip
+
+			return time.Millisecond, true
+		}
+
+		now := time.Now()
+		o.rtts.entries[netip.MustParseAddr("192.0.2.2")] = rttEntry{
+			expires: now.Add(time.Hour),
+			rtt:     20 * time.Millisecond,
+		}
+		o.rtts.entries[netip.MustParseAddr("192.0.2.3")] = rttEntry{
+			expires: now.Add(time.Hour),
+			rtt:     10 * time.Millisecond,
+		}
+
+		rrs := newAnswer()
+		o.order(rrs, AnswerOrderRTT)
+
+		assert.Equal(t, net.IP{192, 0, 2, 3}, aAt(rrs, 1))
+		assert.Equal(t, net.IP{192, 0, 2, 2}, aAt(rrs, 2))
+		assert.Equal(t, net.IP{192, 0, 2, 1}, aAt(rrs, 3))
+
+		ip, _ := testutil.RequireReceive(t, measured, time.Second)
+		assert.Equal(t, netip.MustParseAddr("192.0.2.1"), ip)
+	})
+}
@@ -0,0 +1,127 @@
+package dnsforward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// ClientCertField is the field of the client certificate, which is used as the
+// ClientID.
+type ClientCertField string
+
+// ClientCertField values.
+const (
+	// ClientCertFieldNone means that the client certificates aren't used for
+	// ClientIDs.
+	ClientCertFieldNone ClientCertField = ""
+
+	// ClientCertFieldCN means that the common name of the client certificate
+	// is used as the ClientID.
+	ClientCertFieldCN ClientCertField = "cn"
+
+	// ClientCertFieldSAN means that the first DNS name from the subject
+	// alternative names of the client certificate is used as the ClientID.
+	ClientCertFieldSAN ClientCertField = "san"
+)
+
+// clientAuthType returns the policy of the TLS client authentication for the
+// encrypted DNS servers.
+func (c *TLSConfig) clientAuthType() (typ tls.ClientAuthType) {
+	switch {
+	case c.ClientCAs == nil:
+		return tls.NoClientCert
+	case c.RequireClientCert:
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.VerifyClientCertIfGiven
+	}
+}
+
+// clientCertificate returns the verified certificate of the client, if any.
+func clientCertificate(pctx *proxy.DNSContext) (cert *x509.Certificate) {
+	var cs *tls.ConnectionState
+	switch pctx.Proto {
+	case proxy.ProtoHTTPS:
+		if r := pctx.HTTPRequest; r != nil {
+			cs = r.TLS
+		}
+	case proxy.ProtoQUIC:
+		if conn, ok := pctx.QUICConnection.(quicConnection); ok {
+			tlsCS := conn.ConnectionState().TLS
+			cs = &tlsCS
+		}
+	case proxy.ProtoTLS:
+		if conn, ok := pctx.Conn.(tlsConn); ok {
+			tlsCS := conn.ConnectionState()
+			cs = &tlsCS
+		}
+	default:
+		// Go on.
+	}
+
+	if cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return cs.VerifiedChains[0][0]
+}
+
+// clientIDFromCertificate returns the ClientID from the verified certificate of
+// the client.  clientID is empty if the certificates aren't used for ClientIDs
+// or the client hasn't presented one.
+func (s *Server) clientIDFromCertificate(pctx *proxy.DNSContext) (clientID string, err error) {
+	field := s.conf.ClientCertField
+	if field == ClientCertFieldNone {
+		return "", nil
+	}
+
+	cert := clientCertificate(pctx)
+	if cert == nil {
+		return "", nil
+	}
+
+	switch field {
+	case ClientCertFieldCN:
+		clientID = cert.Subject.CommonName
+	case ClientCertFieldSAN:
+		if len(cert.DNSNames) == 0 {
+			return "", errors.Error("no dns names in client certificate")
+		}
+
+		clientID = cert.DNSNames[0]
+	default:
+		return "", fmt.Errorf("bad client certificate field %q", field)
+	}
+
+	err = ValidateClientID(clientID)
+	if err != nil {
+		return "", fmt.Errorf("client certificate: %w", err)
+	}
+
+	return strings.ToLower(clientID), nil
+}
+
+// checkClientCertHTTP returns true if the DNS-over-HTTPS request r is allowed
+// by the client certificate policy.  Otherwise, it writes an error response.
+// Unlike DoT and DoQ, the HTTPS server is shared with the web interface, so it
+// can't reject the clients without certificates during the handshake.
+func (s *Server) checkClientCertHTTP(w http.ResponseWriter, r *http.Request) (ok bool) {
+	if !s.conf.RequireClientCert || s.conf.ClientCAs == nil {
+		return true
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	aghhttp.Error(r, w, http.StatusForbidden, "client certificate required")
+
+	return false
+}
@@ -0,0 +1,269 @@
+package dnsforward
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testVerifiedTLSConn is a tlsConn with a verified client certificate for
+// tests.
+type testVerifiedTLSConn struct {
+	// Conn is embedded here simply to make testVerifiedTLSConn a net.Conn
+	// without actually implementing all methods.
+	net.Conn
+
+	cert *x509.Certificate
+}
+
+// ConnectionState implements the tlsConn interface for testVerifiedTLSConn.
+func (c testVerifiedTLSConn) ConnectionState() (cs tls.ConnectionState) {
+	if c.cert != nil {
+		cs.VerifiedChains = [][]*x509.Certificate{{c.cert}}
+	}
+
+	return cs
+}
+
+// newTestClientCertificate returns a new client certificate with cn and
+// dnsNames issued by a new certificate authority, as well as the pool with the
+// certificate of that authority.
+func newTestClientCertificate(
+	t *testing.T,
+	cn string,
+	dnsNames ...string,
+) (cert tls.Certificate, pool *x509.CertPool) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	require.NoError(t, err)
+
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool = x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, pool
+}
+
+func TestServer_clientIDFromCertificate(t *testing.T) {
+	cnCert, _ := newTestClientCertificate(t, "Laptop", "Phone")
+	badCert, _ := newTestClientCertificate(t, "bad name", "phone.clients.example")
+	noSANCert, _ := newTestClientCertificate(t, "laptop")
+
+	testCases := []struct {
+		cert         *x509.Certificate
+		name         string
+		field        ClientCertField
+		wantClientID string
+		wantErrMsg   string
+	}{{
+		cert:         cnCert.Leaf,
+		name:         "cn",
+		field:        ClientCertFieldCN,
+		wantClientID: "laptop",
+		wantErrMsg:   "",
+	}, {
+		cert:         cnCert.Leaf,
+		name:         "san",
+		field:        ClientCertFieldSAN,
+		wantClientID: "phone",
+		wantErrMsg:   "",
+	}, {
+		cert:         cnCert.Leaf,
+		name:         "none",
+		field:        ClientCertFieldNone,
+		wantClientID: "",
+		wantErrMsg:   "",
+	}, {
+		cert:         nil,
+		name:         "no_cert",
+		field:        ClientCertFieldCN,
+		wantClientID: "",
+		wantErrMsg:   "",
+	}, {
+		cert:         noSANCert.Leaf,
+		name:         "no_san",
+		field:        ClientCertFieldSAN,
+		wantClientID: "",
+		wantErrMsg:   "no dns names in client certificate",
+	}, {
+		cert:         badCert.Leaf,
+		name:         "bad_cn",
+		field:        ClientCertFieldCN,
+		wantClientID: "",
+		wantErrMsg: `client certificate: invalid clientid "bad name": ` +
+			`bad hostname label rune ' '`,
+	}, {
+		cert:         badCert.Leaf,
+		name:         "bad_san",
+		field:        ClientCertFieldSAN,
+		wantClientID: "",
+		wantErrMsg: `client certificate: invalid clientid "phone.clients.example": ` +
+			`bad hostname label rune '.'`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{
+				conf: ServerConfig{
+					TLSConfig: TLSConfig{
+						ClientCertField: tc.field,
+					},
+				},
+			}
+
+			pctx := &proxy.DNSContext{
+				Proto: proxy.ProtoTLS,
+				Conn:  testVerifiedTLSConn{cert: tc.cert},
+			}
+
+			clientID, err := s.clientIDFromCertificate(pctx)
+			assert.Equal(t, tc.wantClientID, clientID)
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestServer_clientCert_dot(t *testing.T) {
+	clientCert, pool := newTestClientCertificate(t, "laptop")
+
+	s, certPem := createTestTLS(t, TLSConfig{
+		TLSListenAddrs:    []*net.TCPAddr{{}},
+		ClientCAs:         pool,
+		ClientCertField:   ClientCertFieldCN,
+		RequireClientCert: true,
+	})
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{newGoogleUpstream()}
+	startDeferStop(t, s)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPem)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoTLS).String()
+	cli := &dns.Client{
+		Net: "tcp-tls",
+		TLSConfig: &tls.Config{
+			ServerName: tlsServerName,
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS12,
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	t.Run("no_cert", func(t *testing.T) {
+		_, _, err := cli.Exchange(createGoogleATestMessage(), addr)
+		assert.Error(t, err)
+	})
+
+	t.Run("cert", func(t *testing.T) {
+		cli.TLSConfig.Certificates = []tls.Certificate{clientCert}
+
+		resp, _, err := cli.Exchange(createGoogleATestMessage(), addr)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	})
+}
+
+func TestServer_checkClientCertHTTP(t *testing.T) {
+	clientCert, pool := newTestClientCertificate(t, "laptop")
+
+	s := &Server{
+		conf: ServerConfig{
+			TLSConfig: TLSConfig{
+				ClientCAs:         pool,
+				RequireClientCert: true,
+			},
+		},
+	}
+
+	testCases := []struct {
+		connState *tls.ConnectionState
+		name      string
+		want      bool
+	}{{
+		connState: nil,
+		name:      "plain",
+		want:      false,
+	}, {
+		connState: &tls.ConnectionState{},
+		name:      "no_cert",
+		want:      false,
+	}, {
+		connState: &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{clientCert.Leaf}},
+		},
+		name: "cert",
+		want: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/dns-query", nil)
+			r.TLS = tc.connState
+			w := httptest.NewRecorder()
+
+			ok := s.checkClientCertHTTP(w, r)
+			assert.Equal(t, tc.want, ok)
+
+			if !tc.want {
+				assert.Equal(t, http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
@@ -107,10 +107,32 @@ type quicConnection interface {
 	ConnectionState() (cs quic.ConnectionState)
 }
 
-// clientIDFromDNSContext extracts the client's ID from the server name of the
+// clientIDFromDNSContext extracts the client's ID from the verified client
+// certificate, if configured, or from the server name of the client's DoT or
+// DoQ request or the path of the client's DoH.  If there is none, the ClientID
+// of the listener, which has received the request, is used, if any.
+func (s *Server) clientIDFromDNSContext(pctx *proxy.DNSContext) (clientID string, err error) {
+	clientID, err = s.clientIDFromCertificate(pctx)
+	if err != nil || clientID != "" {
+		return clientID, err
+	}
+
+	clientID, err = s.clientIDFromRequest(pctx)
+	if err != nil || clientID != "" {
+		return clientID, err
+	}
+
+	if lc := s.listenerConfig(pctx); lc != nil {
+		return lc.ClientID, nil
+	}
+
+	return "", nil
+}
+
+// clientIDFromRequest extracts the client's ID from the server name of the
 // client's DoT or DoQ request or the path of the client's DoH.  If the protocol
 // is not one of these, clientID is an empty string and err is nil.
-func (s *Server) clientIDFromDNSContext(pctx *proxy.DNSContext) (clientID string, err error) {
+func (s *Server) clientIDFromRequest(pctx *proxy.DNSContext) (clientID string, err error) {
 	proto := pctx.Proto
 	if proto == proxy.ProtoHTTPS {
 		clientID, err = clientIDFromDNSContextHTTPS(pctx)
@@ -135,6 +157,7 @@ func (s *Server) clientIDFromDNSContext(pctx *proxy.DNSContext) (clientID string
 		return "", err
 	}
 
+	hostSrvName = s.conf.serverNameFor(cliSrvName)
 	clientID, err = clientIDFromClientServerName(
 		hostSrvName,
 		cliSrvName,
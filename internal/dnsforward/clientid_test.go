@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"testing"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/quic-go/quic-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // testTLSConn is a tlsConn for tests.
@@ -254,6 +256,62 @@ func TestServer_clientIDFromDNSContext(t *testing.T) {
 	}
 }
 
+func TestServer_clientIDFromDNSContext_additionalCertificates(t *testing.T) {
+	srv := &Server{
+		conf: ServerConfig{
+			TLSConfig: TLSConfig{
+				ServerName: "dns.example.com",
+				AdditionalCertificates: []TLSCertificate{{
+					ServerName: "dns.other.org",
+				}},
+				StrictSNICheck: true,
+			},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		cliSrvName   string
+		wantClientID string
+		wantErrMsg   string
+	}{{
+		name:         "main",
+		cliSrvName:   "cli.dns.example.com",
+		wantClientID: "cli",
+		wantErrMsg:   "",
+	}, {
+		name:         "additional",
+		cliSrvName:   "cli.dns.other.org",
+		wantClientID: "cli",
+		wantErrMsg:   "",
+	}, {
+		name:         "additional_no_clientid",
+		cliSrvName:   "dns.other.org",
+		wantClientID: "",
+		wantErrMsg:   "",
+	}, {
+		name:         "unknown",
+		cliSrvName:   "cli.dns.unknown.example",
+		wantClientID: "",
+		wantErrMsg: `clientid check: client server name "cli.dns.unknown.example" ` +
+			`doesn't match host server name "dns.example.com"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pctx := &proxy.DNSContext{
+				Proto: proxy.ProtoTLS,
+				Conn:  testTLSConn{serverName: tc.cliSrvName},
+			}
+
+			clientID, err := srv.clientIDFromDNSContext(pctx)
+			assert.Equal(t, tc.wantClientID, clientID)
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
 // newHTTPReq is a helper to create HTTP requests for tests.
 func newHTTPReq(cliSrvName string, inclTLS bool) (r *http.Request) {
 	u := &url.URL{
@@ -364,3 +422,99 @@ func TestClientIDFromDNSContextHTTPS(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_clientIDFromDNSContext_listener(t *testing.T) {
+	laddr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 5301}
+
+	srv := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				Listeners: []*ListenerConfig{{
+					Address:  laddr.AddrPort(),
+					ClientID: "tv",
+				}},
+			},
+			TLSConfig: TLSConfig{
+				ServerName: "example.com",
+			},
+		},
+	}
+
+	testCases := []struct {
+		conn         net.Conn
+		name         string
+		wantClientID string
+		proto        proxy.Proto
+	}{{
+		conn:         testUDPConn{laddr: laddr},
+		name:         "plain",
+		wantClientID: "tv",
+		proto:        proxy.ProtoUDP,
+	}, {
+		conn:         testUDPConn{laddr: &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 1}},
+		name:         "other_listener",
+		wantClientID: "",
+		proto:        proxy.ProtoUDP,
+	}, {
+		conn: testTLSConn{
+			Conn:       testUDPConn{laddr: laddr},
+			serverName: "cli.example.com",
+		},
+		name:         "own_clientid",
+		wantClientID: "cli",
+		proto:        proxy.ProtoTLS,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientID, err := srv.clientIDFromDNSContext(&proxy.DNSContext{
+				Proto: tc.proto,
+				Conn:  tc.conn,
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantClientID, clientID)
+		})
+	}
+}
+
+// testUDPConn is a net.Conn with a local address for tests.
+type testUDPConn struct {
+	// Conn is embedded here simply to make testUDPConn a net.Conn without
+	// actually implementing all methods.
+	net.Conn
+
+	laddr net.Addr
+}
+
+// LocalAddr implements the net.Conn interface for testUDPConn.
+func (c testUDPConn) LocalAddr() (addr net.Addr) { return c.laddr }
+
+func TestServerConfig_addClientIDListenAddrs(t *testing.T) {
+	conf := &ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{IP: net.IP{0, 0, 0, 0}, Port: 53}},
+		TCPListenAddrs: []*net.TCPAddr{{IP: net.IP{0, 0, 0, 0}, Port: 53}},
+	}
+
+	conf.addClientIDListenAddrs([]*ListenerConfig{{
+		Address:  netip.MustParseAddrPort("0.0.0.0:5301"),
+		ClientID: "tv",
+	}, {
+		Address:  netip.MustParseAddrPort("192.168.1.1:53"),
+		ClientID: "skipped_unspecified",
+	}, {
+		Address:  netip.MustParseAddrPort("192.168.1.1:0"),
+		ClientID: "skipped_any_port",
+	}, {
+		Address:        netip.MustParseAddrPort("192.168.1.1:5302"),
+		IgnoreQueryLog: true,
+	}})
+
+	want := netip.MustParseAddrPort("0.0.0.0:5301")
+
+	require.Len(t, conf.UDPListenAddrs, 2)
+	require.Len(t, conf.TCPListenAddrs, 2)
+
+	assert.Equal(t, want, conf.UDPListenAddrs[1].AddrPort())
+	assert.Equal(t, want, conf.TCPListenAddrs[1].AddrPort())
+}
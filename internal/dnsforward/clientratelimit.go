@@ -0,0 +1,247 @@
+package dnsforward
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// ClientRatelimit is the rate limit of the requests from a persistent client.
+type ClientRatelimit struct {
+	// Name is the name of the client.  The requests from all the addresses
+	// and ClientIDs of the client share the limit.
+	Name string
+
+	// RPS is the maximum number of requests per second.  Zero means no limit.
+	RPS uint32
+
+	// Burst is the maximum number of requests, which may be sent at once.  If
+	// zero, RPS is used.
+	Burst uint32
+
+	// Drop, if true, means that the requests above the limit are dropped
+	// instead of being responded with REFUSED.
+	Drop bool
+}
+
+// FloodProtectionConfig is the configuration of the circuit breaker, which
+// temporarily blocks the clients flooding the server.
+type FloodProtectionConfig struct {
+	// BlockDuration is the duration, for which a flooding client is blocked.
+	BlockDuration timeutil.Duration `yaml:"block_duration"`
+
+	// Threshold is the number of requests per second from a single client or
+	// persistent client, above which it's considered flooding.
+	Threshold uint32 `yaml:"threshold"`
+
+	// Enabled defines if the flooding clients are blocked.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validateFloodProtection returns an error if c is invalid.
+func validateFloodProtection(c *FloodProtectionConfig) (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	defer func() { err = errors.Annotate(err, "flood protection: %w") }()
+
+	switch {
+	case c.Threshold == 0:
+		return errors.Error("threshold: must be positive")
+	case c.BlockDuration.Duration <= 0:
+		return fmt.Errorf("block_duration: must be positive, got %s", c.BlockDuration)
+	default:
+		return nil
+	}
+}
+
+// limitDecision is the result of checking a request against the limits.
+type limitDecision uint8
+
+// Limit decisions.
+const (
+	// limitAllow means that the request is processed.
+	limitAllow limitDecision = iota
+
+	// limitRefuse means that the request is responded with REFUSED.
+	limitRefuse
+
+	// limitDrop means that the request is dropped.
+	limitDrop
+)
+
+// limiterSweepIvl is the interval between the removals of the states of the
+// inactive clients.
+const limiterSweepIvl = 1 * time.Minute
+
+// clientLimitState is the state of the limits of a single client.
+type clientLimitState struct {
+	// lastSeen is the time of the last request.
+	lastSeen time.Time
+
+	// windowStart is the start of the current one-second window used to count
+	// the requests for the flood protection.
+	windowStart time.Time
+
+	// blockedUntil is the end of the block by the flood protection.
+	blockedUntil time.Time
+
+	// tokens is the number of the requests, which can currently be made within
+	// the rate limit.
+	tokens float64
+
+	// windowReqs is the number of the requests within the current window.
+	windowReqs uint32
+}
+
+// clientLimiter limits the rates of the requests from the persistent clients
+// and blocks the flooding clients.
+type clientLimiter struct {
+	// mu protects states and lastSweep.
+	mu *sync.Mutex
+
+	// flood is the configuration of the flood protection.  It's nil if the
+	// flood protection is disabled.
+	flood *FloodProtectionConfig
+
+	// states are the states of the clients by their keys.
+	states map[string]*clientLimitState
+
+	// lastSweep is the time of the last removal of the inactive states.
+	lastSweep time.Time
+}
+
+// newClientLimiter returns a new limiter with the flood protection
+// configuration flood, which must be valid.
+func newClientLimiter(flood *FloodProtectionConfig) (l *clientLimiter) {
+	if flood != nil && !flood.Enabled {
+		flood = nil
+	}
+
+	return &clientLimiter{
+		mu:     &sync.Mutex{},
+		flood:  flood,
+		states: map[string]*clientLimitState{},
+	}
+}
+
+// check accounts the request from the client with key at now and returns the
+// decision about it.  lim is the rate limit of the persistent client, if any,
+// in which case its name is used as the key instead.
+func (l *clientLimiter) check(key string, lim *ClientRatelimit, now time.Time) (d limitDecision) {
+	limited := lim != nil && lim.RPS > 0
+	if !limited && l.flood == nil {
+		return limitAllow
+	} else if limited {
+		key = lim.Name
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	st, ok := l.states[key]
+	if !ok {
+		st = &clientLimitState{
+			windowStart: now,
+		}
+
+		if limited {
+			st.tokens = float64(burst(lim))
+		}
+
+		l.states[key] = st
+	}
+
+	elapsed := now.Sub(st.lastSeen)
+	st.lastSeen = now
+
+	if now.Before(st.blockedUntil) {
+		return limitDrop
+	}
+
+	if l.isFlood(key, st, now) {
+		return limitDrop
+	}
+
+	if !limited {
+		return limitAllow
+	}
+
+	if ok {
+		st.tokens += elapsed.Seconds() * float64(lim.RPS)
+		if b := float64(burst(lim)); st.tokens > b {
+			st.tokens = b
+		}
+	}
+
+	if st.tokens >= 1 {
+		st.tokens--
+
+		return limitAllow
+	}
+
+	if lim.Drop {
+		return limitDrop
+	}
+
+	return limitRefuse
+}
+
+// isFlood counts the request from the client with key and state st at now and
+// returns true if it's flooding, blocking it.  l.mu is expected to be locked.
+func (l *clientLimiter) isFlood(key string, st *clientLimitState, now time.Time) (ok bool) {
+	if l.flood == nil {
+		return false
+	}
+
+	if now.Sub(st.windowStart) >= time.Second {
+		st.windowStart, st.windowReqs = now, 0
+	}
+
+	st.windowReqs++
+	if st.windowReqs <= l.flood.Threshold {
+		return false
+	}
+
+	st.blockedUntil = now.Add(l.flood.BlockDuration.Duration)
+	log.Info(
+		"dnsforward: client %q has sent more than %d requests per second, blocking for %s",
+		key,
+		l.flood.Threshold,
+		l.flood.BlockDuration,
+	)
+
+	return true
+}
+
+// sweep removes the states of the clients, which are neither active nor
+// blocked, if [limiterSweepIvl] has passed since the last time.  l.mu is
+// expected to be locked.
+func (l *clientLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < limiterSweepIvl {
+		return
+	}
+
+	l.lastSweep = now
+	for key, st := range l.states {
+		if now.Sub(st.lastSeen) >= limiterSweepIvl && !now.Before(st.blockedUntil) {
+			delete(l.states, key)
+		}
+	}
+}
+
+// burst returns the size of the token bucket for lim.
+func burst(lim *ClientRatelimit) (n uint32) {
+	if lim.Burst == 0 {
+		return lim.RPS
+	}
+
+	return lim.Burst
+}
@@ -0,0 +1,112 @@
+package dnsforward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFloodProtection(t *testing.T) {
+	testCases := []struct {
+		conf       *FloodProtectionConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf: &FloodProtectionConfig{
+			BlockDuration: timeutil.Duration{Duration: time.Minute},
+			Threshold:     100,
+			Enabled:       true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &FloodProtectionConfig{
+			BlockDuration: timeutil.Duration{Duration: time.Minute},
+			Threshold:     0,
+			Enabled:       true,
+		},
+		name:       "no_threshold",
+		wantErrMsg: "flood protection: threshold: must be positive",
+	}, {
+		conf: &FloodProtectionConfig{
+			BlockDuration: timeutil.Duration{},
+			Threshold:     100,
+			Enabled:       true,
+		},
+		name:       "no_duration",
+		wantErrMsg: "flood protection: block_duration: must be positive, got 0s",
+	}, {
+		conf: &FloodProtectionConfig{
+			Enabled: false,
+		},
+		name:       "disabled",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateFloodProtection(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestClientLimiter_check(t *testing.T) {
+	start := time.Now()
+
+	t.Run("ratelimit", func(t *testing.T) {
+		l := newClientLimiter(nil)
+		lim := &ClientRatelimit{
+			Name:  "camera",
+			RPS:   2,
+			Burst: 3,
+		}
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, limitAllow, l.check("192.168.1.2", lim, start))
+		}
+
+		// The limit is shared between the addresses of the client.
+		assert.Equal(t, limitRefuse, l.check("192.168.1.3", lim, start))
+
+		// Two tokens are refilled within a second.
+		now := start.Add(time.Second)
+		assert.Equal(t, limitAllow, l.check("192.168.1.2", lim, now))
+		assert.Equal(t, limitAllow, l.check("192.168.1.2", lim, now))
+		assert.Equal(t, limitRefuse, l.check("192.168.1.2", lim, now))
+
+		lim.Drop = true
+		assert.Equal(t, limitDrop, l.check("192.168.1.2", lim, now))
+
+		assert.Equal(t, limitAllow, l.check("192.168.1.4", nil, now))
+	})
+
+	t.Run("flood", func(t *testing.T) {
+		l := newClientLimiter(&FloodProtectionConfig{
+			BlockDuration: timeutil.Duration{Duration: time.Minute},
+			Threshold:     2,
+			Enabled:       true,
+		})
+
+		const key = "192.168.1.2"
+
+		assert.Equal(t, limitAllow, l.check(key, nil, start))
+		assert.Equal(t, limitAllow, l.check(key, nil, start))
+		assert.Equal(t, limitDrop, l.check(key, nil, start))
+
+		// Still blocked after the window ends.
+		assert.Equal(t, limitDrop, l.check(key, nil, start.Add(30*time.Second)))
+
+		// Other clients aren't affected.
+		assert.Equal(t, limitAllow, l.check("192.168.1.3", nil, start))
+
+		assert.Equal(t, limitAllow, l.check(key, nil, start.Add(2*time.Minute)))
+	})
+}
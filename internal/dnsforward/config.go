@@ -40,6 +40,20 @@ type Config struct {
 	// nil if there are no custom upstreams for the client.
 	GetCustomUpstreamByClient func(id string) (conf *proxy.UpstreamConfig, err error) `yaml:"-"`
 
+	// GetClientMinTTL is a callback that returns the minimum TTL of the
+	// records in the responses to the client with the IP address or ClientID.
+	// It returns zero if the TTLs shouldn't be changed.
+	GetClientMinTTL func(id string) (ttl uint32) `yaml:"-"`
+
+	// UserAgentHandler is an optional callback that is called with the
+	// address of the client and the User-Agent header of its DoH request.
+	UserAgentHandler func(ip netip.Addr, ua string) `yaml:"-"`
+
+	// GetClientRatelimit is a callback that returns the rate limit of the
+	// persistent client with the IP address or ClientID.  It returns nil if
+	// the client has no rate limit.
+	GetClientRatelimit func(id string) (lim *ClientRatelimit) `yaml:"-"`
+
 	// Anti-DNS amplification
 
 	// Ratelimit is the maximum number of requests per second from a given IP
@@ -52,6 +66,11 @@ type Config struct {
 	// RefuseAny, if true, refuse ANY requests.
 	RefuseAny bool `yaml:"refuse_any"`
 
+	// FloodProtection is the configuration of the circuit breaker, which
+	// temporarily blocks the clients flooding the server.  If nil or disabled,
+	// the clients aren't blocked.
+	FloodProtection *FloodProtectionConfig `yaml:"flood_protection"`
+
 	// Upstream DNS servers configuration
 
 	// UpstreamDNS is the list of upstream DNS servers.
@@ -69,6 +88,47 @@ type Config struct {
 	// servers are not responding.
 	FallbackDNS []string `yaml:"fallback_dns"`
 
+	// UpstreamPrivacy are the privacy settings for the plain-UDP upstream
+	// servers.
+	UpstreamPrivacy []*UpstreamPrivacyConfig `yaml:"upstream_privacy"`
+
+	// UpstreamECS are the EDNS Client Subnet policies for the upstream
+	// servers.  The upstream servers without a policy handle ECS according to
+	// the EDNSClientSubnet settings.
+	UpstreamECS []*UpstreamECSConfig `yaml:"upstream_ecs"`
+
+	// Listeners are the per-listener settings.  The first one matching the
+	// local address of the request is used.
+	Listeners []*ListenerConfig `yaml:"listeners"`
+
+	// AdaptiveTimeout is the configuration of the per-upstream adaptive
+	// timeouts.  If nil or disabled, the upstream timeout is used for all
+	// upstreams.
+	AdaptiveTimeout *AdaptiveTimeoutConfig `yaml:"adaptive_timeout"`
+
+	// UpstreamFailure is the configuration of the behavior in case the
+	// upstream servers fail to respond.
+	UpstreamFailure *UpstreamFailureConfig `yaml:"upstream_failure"`
+
+	// UpstreamPipelining, if true, makes the DNS-over-TCP and DNS-over-TLS
+	// upstreams keep a pool of the persistent connections and send the
+	// queries over them without waiting for the previous responses.
+	UpstreamPipelining bool `yaml:"upstream_pipelining"`
+
+	// SecondaryZones are the zones, for which the server acts as a secondary
+	// server, transferring them from the primaries and serving them
+	// authoritatively.
+	SecondaryZones []*SecondaryZoneConfig `yaml:"secondary_zones"`
+
+	// DoHRelays are the DoH relays forwarding the requests of other resolvers
+	// to the upstream servers without filtering or logging them.
+	DoHRelays []*DoHRelayConfig `yaml:"doh_relays"`
+
+	// ForwardingEndpoints are the encrypted DNS endpoints selected by their
+	// server names, which forward the requests to the upstream servers without
+	// filtering, caching, or logging them.
+	ForwardingEndpoints []*ForwardingEndpointConfig `yaml:"forwarding_endpoints"`
+
 	// AllServers, if true, parallel queries to all configured upstream servers
 	// are enabled.
 	AllServers bool `yaml:"all_servers"`
@@ -80,20 +140,41 @@ type Config struct {
 	// when FastestAddr is true.
 	FastestTimeout timeutil.Duration `yaml:"fastest_timeout"`
 
+	// UpstreamRace, if true, each query is sent to the fastest upstream server
+	// and, if it doesn't respond within UpstreamHedgeDelay, to the second
+	// fastest one, using the first valid response.  It's ignored if either
+	// AllServers or FastestAddr is true.
+	UpstreamRace bool `yaml:"upstream_race"`
+
+	// UpstreamHedgeDelay is the delay, after which the query is also sent to
+	// the second fastest upstream server when UpstreamRace is true.  If zero,
+	// [DefaultUpstreamHedgeDelay] is used.
+	UpstreamHedgeDelay timeutil.Duration `yaml:"upstream_hedge_delay"`
+
+	// DeduplicateQueries, if true, coalesces the identical concurrent requests
+	// to the same upstream servers into a single upstream request, the
+	// response to which is sent to all the requesting clients.
+	DeduplicateQueries bool `yaml:"deduplicate_queries"`
+
 	// Access settings
 
-	// AllowedClients is the slice of IP addresses, CIDR networks, and
-	// ClientIDs of allowed clients.  If not empty, only these clients are
-	// allowed, and [Config.DisallowedClients] are ignored.
+	// AllowedClients is the slice of IP addresses, CIDR networks, ASNs,
+	// country codes, and ClientIDs of allowed clients.  If not empty, only
+	// these clients are allowed, and [Config.DisallowedClients] are ignored.
 	AllowedClients []string `yaml:"allowed_clients"`
 
-	// DisallowedClients is the slice of IP addresses, CIDR networks, and
-	// ClientIDs of disallowed clients.
+	// DisallowedClients is the slice of IP addresses, CIDR networks, ASNs,
+	// country codes, and ClientIDs of disallowed clients.
 	DisallowedClients []string `yaml:"disallowed_clients"`
 
 	// BlockedHosts is the list of hosts that should be blocked.
 	BlockedHosts []string `yaml:"blocked_hosts"`
 
+	// GeoIPDatabases are the paths to the databases in the MaxMind DB format,
+	// which are used to match the ASN and country access rules.  The files
+	// are reloaded when they change.
+	GeoIPDatabases []string `yaml:"geoip_databases"`
+
 	// TrustedProxies is the list of IP addresses and CIDR networks to detect
 	// proxy servers addresses the DoH requests from which should be handled.
 	// The value of nil or an empty slice for this field makes Proxy not trust
@@ -116,6 +197,11 @@ type Config struct {
 	// CacheOptimistic defines if optimistic cache mechanism should be used.
 	CacheOptimistic bool `yaml:"cache_optimistic"`
 
+	// CacheTypePolicies are the caching policies for the responses to the
+	// requests with particular query types.  The first policy matching the
+	// query type applies.
+	CacheTypePolicies []*CacheTypePolicyConfig `yaml:"cache_type_policies"`
+
 	// Other settings
 
 	// BogusNXDomain is the list of IP addresses, responses with them will be
@@ -129,9 +215,26 @@ type Config struct {
 	// EnableDNSSEC, if true, set AD flag in outcoming DNS request.
 	EnableDNSSEC bool `yaml:"enable_dnssec"`
 
+	// ValidateDNSSEC, if true, validate the DNSSEC signatures of the upstream
+	// responses locally and respond with SERVFAIL to the bogus ones.
+	ValidateDNSSEC bool `yaml:"validate_dnssec"`
+
 	// EDNSClientSubnet is the settings list for EDNS Client Subnet.
 	EDNSClientSubnet *EDNSClientSubnet `yaml:"edns_client_subnet"`
 
+	// RebindProtection is the configuration of the DNS rebinding protection.
+	RebindProtection *RebindProtectionConfig `yaml:"rebind_protection"`
+
+	// QueryTypePolicies are the policies for the requests with particular
+	// query types.  The first policy matching both the client and the query
+	// type determines the response.
+	QueryTypePolicies []*QueryTypePolicyConfig `yaml:"query_type_policies"`
+
+	// AnswerOrder is the configuration of the ordering of the records of the
+	// multi-record answers.  If nil, the order of the records received from
+	// the upstream servers is preserved.
+	AnswerOrder *AnswerOrderConfig `yaml:"answer_order"`
+
 	// MaxGoroutines is the max number of parallel goroutines for processing
 	// incoming requests.
 	MaxGoroutines uint32 `yaml:"max_goroutines"`
@@ -170,12 +273,14 @@ type EDNSClientSubnet struct {
 
 // TLSConfig is the TLS configuration for HTTPS, DNS-over-HTTPS, and DNS-over-TLS
 type TLSConfig struct {
-	cert tls.Certificate
-
 	TLSListenAddrs   []*net.TCPAddr `yaml:"-" json:"-"`
 	QUICListenAddrs  []*net.UDPAddr `yaml:"-" json:"-"`
 	HTTPSListenAddrs []*net.TCPAddr `yaml:"-" json:"-"`
 
+	// HTTP3ListenAddrs are the addresses, on which the DNS-over-HTTPS requests
+	// over HTTP/3 are served.  It's only used for the DDR responses.
+	HTTP3ListenAddrs []*net.UDPAddr `yaml:"-" json:"-"`
+
 	// PEM-encoded certificates chain
 	CertificateChain string `yaml:"certificate_chain" json:"certificate_chain"`
 	// PEM-encoded private key
@@ -187,10 +292,39 @@ type TLSConfig struct {
 	CertificateChainData []byte `yaml:"-" json:"-"`
 	PrivateKeyData       []byte `yaml:"-" json:"-"`
 
+	// PrivateKeySigner, if not nil, is the external signer keeping the private
+	// key of the certificate.  PrivateKey and PrivateKeyPath must be empty
+	// then.
+	PrivateKeySigner *aghtls.ExternalSignerConfig `yaml:"private_key_signer,omitempty" json:"-"`
+
 	// ServerName is the hostname of the server.  Currently, it is only being
 	// used for ClientID checking and Discovery of Designated Resolvers (DDR).
 	ServerName string `yaml:"-" json:"-"`
 
+	// AdditionalCertificates are the certificates, which are used instead of
+	// the main one for the clients, which SNI they match.
+	AdditionalCertificates []TLSCertificate `yaml:"-" json:"-"`
+
+	// additionalCerts are the parsed AdditionalCertificates.
+	additionalCerts []tls.Certificate
+
+	// ClientCAs, if not nil, are the certificate authorities, with which the
+	// client certificates are verified.
+	ClientCAs *x509.CertPool `yaml:"-" json:"-"`
+
+	// ClientCertField is the field of the verified client certificate, which
+	// is used as the ClientID.
+	ClientCertField ClientCertField `yaml:"-" json:"-"`
+
+	// RequireClientCert makes the encrypted servers reject the clients without
+	// a valid certificate.  It's only used if ClientCAs isn't nil.
+	RequireClientCert bool `yaml:"-" json:"-"`
+
+	// ECHKeys are the Encrypted Client Hello keys of the HTTPS server.  If
+	// there are any, the HTTPS records for ServerName advertising them are
+	// answered locally.
+	ECHKeys []*aghtls.ECHKey `yaml:"-" json:"-"`
+
 	// DNS names from certificate (SAN) or CN value from Subject
 	dnsNames []string
 
@@ -207,13 +341,52 @@ type TLSConfig struct {
 	hasIPAddrs bool
 }
 
+// HasPrivateKey returns true if the private key of the certificate is either
+// loaded or kept by the external signer.
+func (c *TLSConfig) HasPrivateKey() (ok bool) {
+	return len(c.PrivateKeyData) != 0 || c.PrivateKeySigner != nil
+}
+
+// serverNameFor returns the server name of the host, to which the client's
+// server name cliSrvName belongs.  It's the main server name, unless
+// cliSrvName is one of the server names of the additional certificates or
+// their immediate subdomain.
+func (c *TLSConfig) serverNameFor(cliSrvName string) (srvName string) {
+	for _, cert := range c.AdditionalCertificates {
+		n := cert.ServerName
+		if n != "" && (n == cliSrvName || netutil.IsImmediateSubdomain(cliSrvName, n)) {
+			return n
+		}
+	}
+
+	return c.ServerName
+}
+
+// TLSCertificate is an additional certificate of the encrypted DNS servers.
+type TLSCertificate struct {
+	// ServerName is the hostname of the server, for which the certificate is
+	// issued.  It's used for ClientID checking.
+	ServerName string
+
+	// CertificateChainData is the PEM-encoded certificate chain.
+	CertificateChainData []byte
+
+	// PrivateKeyData is the PEM-encoded private key.
+	PrivateKeyData []byte
+}
+
 // DNSCryptConfig is the DNSCrypt server configuration struct.
 type DNSCryptConfig struct {
 	ResolverCert   *dnscrypt.Cert
 	ProviderName   string
 	UDPListenAddrs []*net.UDPAddr
 	TCPListenAddrs []*net.TCPAddr
-	Enabled        bool
+
+	// AdditionalResolvers are the DNSCrypt resolvers with other provider
+	// names and keys.  They are only served if Enabled is true.
+	AdditionalResolvers []*DNSCryptResolver
+
+	Enabled bool
 }
 
 // ServerConfig represents server configuration.
@@ -300,11 +473,6 @@ func (s *Server) createProxyConfig() (conf proxy.Config, err error) {
 		conf.EDNSAddr = net.IP(srvConf.EDNSClientSubnet.CustomIP.AsSlice())
 	}
 
-	if srvConf.CacheSize != 0 {
-		conf.CacheEnabled = true
-		conf.CacheSizeBytes = int(srvConf.CacheSize)
-	}
-
 	setProxyUpstreamMode(
 		&conf,
 		srvConf.AllServers,
@@ -512,7 +680,7 @@ func (conf *ServerConfig) ourAddrsMatcher() (m addrPortMatcher, err error) {
 
 // prepareTLS - prepares TLS configuration for the DNS proxy
 func (s *Server) prepareTLS(proxyConfig *proxy.Config) (err error) {
-	if len(s.conf.CertificateChainData) == 0 || len(s.conf.PrivateKeyData) == 0 {
+	if len(s.conf.CertificateChainData) == 0 || !s.conf.HasPrivateKey() {
 		return nil
 	}
 
@@ -530,16 +698,23 @@ func (s *Server) prepareTLS(proxyConfig *proxy.Config) (err error) {
 		proxyConfig.QUICListenAddr,
 	)
 
-	s.conf.cert, err = tls.X509KeyPair(s.conf.CertificateChainData, s.conf.PrivateKeyData)
+	pair, err := aghtls.KeyPair(
+		s.conf.CertificateChainData,
+		s.conf.PrivateKeyData,
+		s.conf.PrivateKeySigner,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to parse TLS keypair: %w", err)
 	}
 
-	cert, err := x509.ParseCertificate(s.conf.cert.Certificate[0])
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
 	if err != nil {
 		return fmt.Errorf("x509.ParseCertificate(): %w", err)
 	}
 
+	pair.Leaf = cert
+	s.cert.Store(&pair)
+
 	s.conf.hasIPAddrs = aghtls.CertificateHasIP(cert)
 
 	if s.conf.StrictSNICheck {
@@ -553,8 +728,16 @@ func (s *Server) prepareTLS(proxyConfig *proxy.Config) (err error) {
 		}
 	}
 
+	err = s.prepareAdditionalCertificates()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	proxyConfig.TLSConfig = &tls.Config{
 		GetCertificate: s.onGetCertificate,
+		ClientCAs:      s.conf.ClientCAs,
+		ClientAuth:     s.conf.clientAuthType(),
 		CipherSuites:   s.conf.TLSCiphers,
 		MinVersion:     tls.VersionTLS12,
 	}
@@ -562,6 +745,34 @@ func (s *Server) prepareTLS(proxyConfig *proxy.Config) (err error) {
 	return nil
 }
 
+// prepareAdditionalCertificates parses the additional certificates and adds
+// their names to the ones allowed by the strict SNI check.
+func (s *Server) prepareAdditionalCertificates() (err error) {
+	s.conf.additionalCerts = make([]tls.Certificate, 0, len(s.conf.AdditionalCertificates))
+	for i, c := range s.conf.AdditionalCertificates {
+		var pair tls.Certificate
+		pair, err = tls.X509KeyPair(c.CertificateChainData, c.PrivateKeyData)
+		if err != nil {
+			return fmt.Errorf("additional certificate at index %d: %w", i, err)
+		}
+
+		pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("additional certificate at index %d: %w", i, err)
+		}
+
+		s.conf.additionalCerts = append(s.conf.additionalCerts, pair)
+
+		if s.conf.StrictSNICheck {
+			s.conf.dnsNames = append(s.conf.dnsNames, certNames(pair.Leaf)...)
+		}
+	}
+
+	slices.Sort(s.conf.dnsNames)
+
+	return nil
+}
+
 // isWildcard returns true if host is a wildcard hostname.
 func isWildcard(host string) (ok bool) {
 	return len(host) >= 2 && host[0] == '*' && host[1] == '.'
@@ -601,7 +812,81 @@ func (s *Server) onGetCertificate(ch *tls.ClientHelloInfo) (*tls.Certificate, er
 		log.Info("dns: tls: unknown SNI in Client Hello: %s", ch.ServerName)
 		return nil, fmt.Errorf("invalid SNI")
 	}
-	return &s.conf.cert, nil
+
+	cert := s.cert.Load()
+	if len(s.conf.additionalCerts) == 0 || ch.SupportsCertificate(cert) == nil {
+		return cert, nil
+	}
+
+	for i := range s.conf.additionalCerts {
+		c := &s.conf.additionalCerts[i]
+		if ch.SupportsCertificate(c) == nil {
+			return c, nil
+		}
+	}
+
+	return cert, nil
+}
+
+// UpdateCertificate replaces the certificate of the running DNS-over-TLS,
+// DNS-over-QUIC, and DNS-over-HTTPS listeners, so that the established
+// connections are kept.  It returns an error if the listeners aren't running
+// or the names in the new certificate differ from the current ones, in which
+// case the server must be reconfigured.
+func (s *Server) UpdateCertificate(certChain, privateKey []byte) (err error) {
+	defer func() { err = errors.Annotate(err, "updating certificate: %w") }()
+
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	cur := s.cert.Load()
+	if cur == nil || !s.isRunning {
+		return errors.Error("encrypted dns is not running")
+	}
+
+	cert, err := aghtls.KeyPair(certChain, privateKey, s.conf.PrivateKeySigner)
+	if err != nil {
+		return fmt.Errorf("parsing key pair: %w", err)
+	}
+
+	curLeaf, err := x509.ParseCertificate(cur.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parsing current certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	if !sameCertNames(curLeaf, leaf) {
+		return errors.Error("names in certificate have changed")
+	}
+
+	cert.Leaf = leaf
+	s.cert.Store(&cert)
+
+	log.Info("dnsforward: updated certificate, expires at %s", leaf.NotAfter)
+
+	return nil
+}
+
+// sameCertNames returns true if a and b have the same names and the same
+// presence of IP addresses, which are used to check the SNI and to answer the
+// DDR requests.
+func sameCertNames(a, b *x509.Certificate) (ok bool) {
+	return slices.Equal(certNames(a), certNames(b)) &&
+		aghtls.CertificateHasIP(a) == aghtls.CertificateHasIP(b)
+}
+
+// certNames returns the DNS names of cert or its common name, if there are
+// none.
+func certNames(cert *x509.Certificate) (names []string) {
+	if len(cert.DNSNames) == 0 {
+		return []string{cert.Subject.CommonName}
+	}
+
+	return cert.DNSNames
 }
 
 // UpdatedProtectionStatus updates protection state, if the protection was
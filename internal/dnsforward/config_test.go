@@ -1,9 +1,14 @@
 package dnsforward
 
 import (
+	"crypto/tls"
+	"net"
 	"testing"
 
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/slices"
 )
 
@@ -55,3 +60,73 @@ func TestAnyNameMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_onGetCertificate(t *testing.T) {
+	const (
+		mainName  = "dns.example.com"
+		otherName = "dns.other.org"
+	)
+
+	mainCert, mainKey := newTestCertificate(t, mainName)
+	otherCert, otherKey := newTestCertificate(t, otherName)
+
+	s := &Server{
+		conf: ServerConfig{
+			TLSConfig: TLSConfig{
+				TLSListenAddrs:       []*net.TCPAddr{{}},
+				CertificateChainData: mainCert,
+				PrivateKeyData:       mainKey,
+				AdditionalCertificates: []TLSCertificate{{
+					ServerName:           otherName,
+					CertificateChainData: otherCert,
+					PrivateKeyData:       otherKey,
+				}},
+				StrictSNICheck: true,
+			},
+		},
+	}
+
+	err := s.prepareTLS(&proxy.Config{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		sni        string
+		wantName   string
+		wantErrMsg string
+	}{{
+		name:       "main",
+		sni:        mainName,
+		wantName:   mainName,
+		wantErrMsg: "",
+	}, {
+		name:       "additional",
+		sni:        otherName,
+		wantName:   otherName,
+		wantErrMsg: "",
+	}, {
+		name:       "unknown",
+		sni:        "unknown.example",
+		wantName:   "",
+		wantErrMsg: "invalid SNI",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, getErr := s.onGetCertificate(&tls.ClientHelloInfo{
+				ServerName:        tc.sni,
+				SupportedVersions: []uint16{tls.VersionTLS13},
+				SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, getErr)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			require.NotNil(t, cert)
+			require.NotNil(t, cert.Leaf)
+
+			assert.Equal(t, tc.wantName, cert.Leaf.Subject.CommonName)
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// inflightResult is the result of an upstream request shared between the
+// identical concurrent requests.
+type inflightResult struct {
+	// res is the copy of the response made right after resolving, so that it
+	// isn't affected by the further processing of the leader's request.  It
+	// must not be modified.
+	res *dns.Msg
+
+	// upstream is the upstream server, which has resolved the request.
+	upstream upstream.Upstream
+
+	// cachedUpstreamAddr is the address of the upstream server, which has
+	// resolved the cached response.
+	cachedUpstreamAddr string
+}
+
+// errInflightAborted is returned to the requests coalesced with an in-flight
+// one, which has been aborted without a result.
+const errInflightAborted errors.Error = "in-flight request aborted"
+
+// inflightCall is an upstream request in flight, which the identical requests
+// wait for.
+type inflightCall struct {
+	// done is closed when the request is finished.
+	done chan struct{}
+
+	// res is the result of the request.  It must only be accessed after done
+	// is closed.
+	res *inflightResult
+
+	// err is the error of the request.  It must only be accessed after done is
+	// closed.
+	err error
+}
+
+// inflightGroup coalesces the identical concurrent upstream requests.  The zero
+// value is ready for use.
+type inflightGroup struct {
+	// mu protects calls.
+	mu sync.Mutex
+
+	// calls are the requests in flight by their keys.
+	calls map[string]*inflightCall
+}
+
+// do calls resolve unless there is a request with the same key in flight, in
+// which case it waits for that one and returns its result with shared set to
+// true.
+func (g *inflightGroup) do(
+	key string,
+	resolve func() (r *inflightResult, err error),
+) (r *inflightResult, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+
+		return c.res, true, c.err
+	}
+
+	if g.calls == nil {
+		g.calls = map[string]*inflightCall{}
+	}
+
+	c := &inflightCall{
+		done: make(chan struct{}),
+		err:  errInflightAborted,
+	}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		close(c.done)
+	}()
+
+	c.res, c.err = resolve()
+
+	return c.res, false, c.err
+}
+
+// resolve resolves pctx using prx.  If the deduplication is enabled, the
+// identical concurrent requests are coalesced into a single upstream request
+// and each of them receives a copy of its response.
+func (s *Server) resolve(prx *proxy.Proxy, pctx *proxy.DNSContext) (err error) {
+	if !s.conf.DeduplicateQueries {
+		return prx.Resolve(pctx)
+	}
+
+	r, shared, err := s.inflight.do(s.inflightKey(pctx), func() (r *inflightResult, err error) {
+		err = prx.Resolve(pctx)
+
+		r = &inflightResult{
+			upstream:           pctx.Upstream,
+			cachedUpstreamAddr: pctx.CachedUpstreamAddr,
+		}
+		if pctx.Res != nil {
+			r.res = pctx.Res.Copy()
+		}
+
+		return r, err
+	})
+
+	if !shared || r == nil {
+		return err
+	}
+
+	log.Debug("dnsforward: coalesced request %d with an in-flight one", pctx.Req.Id)
+
+	pctx.Upstream = r.upstream
+	pctx.CachedUpstreamAddr = r.cachedUpstreamAddr
+	if r.res != nil {
+		pctx.Res = r.res.Copy()
+		pctx.Res.Id = pctx.Req.Id
+		pctx.Res.Question = slices.Clone(pctx.Req.Question)
+	}
+
+	return err
+}
+
+// inflightKey returns the key identifying the requests, which are resolved
+// the same way and thus may be coalesced.  It includes the question, the flags
+// and the EDNS settings affecting the response, the transport affecting the
+// truncation, and the upstream configuration.  The subnet of the client is
+// only included if it may be sent to the upstream servers and the address of
+// the client is only included for the PTR requests, since the upstream servers
+// for them depend on whether the client is local.
+func (s *Server) inflightKey(pctx *proxy.DNSContext) (key string) {
+	req := pctx.Req
+	q := req.Question[0]
+
+	b := &strings.Builder{}
+	_, _ = fmt.Fprintf(
+		b,
+		"%s|%d|%d|%t|%t|%t|%t|%p",
+		strings.ToLower(q.Name),
+		q.Qtype,
+		q.Qclass,
+		req.RecursionDesired,
+		req.CheckingDisabled,
+		req.AuthenticatedData,
+		pctx.Proto == proxy.ProtoUDP,
+		pctx.CustomUpstreamConfig,
+	)
+
+	if opt := req.IsEdns0(); opt != nil {
+		_, _ = fmt.Fprintf(b, "|edns|%d|%t", opt.UDPSize(), opt.Do())
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+				_, _ = fmt.Fprintf(b, "|ecs|%s/%d", subnet.Address, subnet.SourceNetmask)
+			}
+		}
+	}
+
+	if ecs := s.conf.EDNSClientSubnet; (ecs != nil && ecs.Enabled) || len(s.conf.UpstreamECS) > 0 {
+		if subnet, ok := clientSubnet(pctx.Addr); ok {
+			_, _ = fmt.Fprintf(b, "|subnet|%s", subnet)
+		}
+	}
+
+	if q.Qtype == dns.TypePTR {
+		_, _ = fmt.Fprintf(b, "|client|%s", netutil.NetAddrToAddrPort(pctx.Addr).Addr())
+	}
+
+	return b.String()
+}
@@ -0,0 +1,141 @@
+package dnsforward
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_resolve_dedup(t *testing.T) {
+	const reqNum = 10
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		Config: Config{
+			EDNSClientSubnet:   &EDNSClientSubnet{Enabled: false},
+			DeduplicateQueries: true,
+		},
+	}, nil)
+
+	var exchanges atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ups := &aghtest.UpstreamMock{
+		OnAddress: func() (addr string) { return "upstream.example" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			if exchanges.Add(1) == 1 {
+				close(started)
+			}
+
+			<-release
+
+			return newDedupTestResp(t, req), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+	s.dnsProxy.UpstreamConfig = &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{ups},
+	}
+
+	newCtx := func(id uint16) (pctx *proxy.DNSContext) {
+		req := createTestMessageWithType(aghtest.ReqFQDN, dns.TypeA)
+		req.Id = id
+
+		return &proxy.DNSContext{
+			Proto: proxy.ProtoUDP,
+			Req:   req,
+			Addr:  testClientAddr,
+		}
+	}
+
+	ctxs := make([]*proxy.DNSContext, reqNum)
+	errs := make([]error, reqNum)
+	wg := &sync.WaitGroup{}
+	for i := range ctxs {
+		ctxs[i] = newCtx(uint16(i + 1))
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		errs[0] = s.resolve(s.dnsProxy, ctxs[0])
+	}()
+
+	<-started
+
+	for i := 1; i < reqNum; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			errs[i] = s.resolve(s.dnsProxy, ctxs[i])
+		}(i)
+	}
+
+	// Give the followers the time to join the in-flight request.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), exchanges.Load())
+
+	for i, pctx := range ctxs {
+		require.NoError(t, errs[i])
+		require.NotNil(t, pctx.Res)
+
+		assert.Equal(t, pctx.Req.Id, pctx.Res.Id)
+		assert.Len(t, pctx.Res.Answer, 1)
+		assert.Equal(t, ups, pctx.Upstream)
+	}
+
+	assert.NotSame(t, ctxs[0].Res, ctxs[1].Res)
+}
+
+// newDedupTestResp returns a test response to req with a single A record.
+func newDedupTestResp(t *testing.T, req *dns.Msg) (resp *dns.Msg) {
+	t.Helper()
+
+	return newResp(dns.RcodeSuccess, req, []dns.RR{
+		newRR(t, req.Question[0].Name, dns.TypeA, 3600, net.IP{192, 0, 2, 1}),
+	})
+}
+
+func TestServer_inflightKey(t *testing.T) {
+	s := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			},
+		},
+	}
+
+	newCtx := func(name string, qtype uint16, proto proxy.Proto) (pctx *proxy.DNSContext) {
+		return &proxy.DNSContext{
+			Proto: proto,
+			Req:   createTestMessageWithType(name, qtype),
+			Addr:  testClientAddr,
+		}
+	}
+
+	key := s.inflightKey(newCtx("example.org.", dns.TypeA, proxy.ProtoUDP))
+
+	assert.Equal(t, key, s.inflightKey(newCtx("EXAMPLE.org.", dns.TypeA, proxy.ProtoUDP)))
+	assert.NotEqual(t, key, s.inflightKey(newCtx("example.org.", dns.TypeAAAA, proxy.ProtoUDP)))
+	assert.NotEqual(t, key, s.inflightKey(newCtx("example.org.", dns.TypeA, proxy.ProtoTCP)))
+
+	withDO := newCtx("example.org.", dns.TypeA, proxy.ProtoUDP)
+	withDO.Req.SetEdns0(dns.DefaultMsgSize, true)
+	assert.NotEqual(t, key, s.inflightKey(withDO))
+}
@@ -3,9 +3,12 @@ package dnsforward
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
 	"time"
 
+	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 )
@@ -54,3 +57,82 @@ func (s *Server) DialContext(ctx context.Context, network, addr string) (conn ne
 
 	return nil, errors.Join(dialErrs...)
 }
+
+// newBootstrapResolvers returns the resolvers for the bootstrap servers from
+// opts, or the system resolver if there are none.  closers close the resolvers
+// that need it.
+func newBootstrapResolvers(
+	opts *upstream.Options,
+) (resolvers []upstream.Resolver, closers []io.Closer, err error) {
+	for _, b := range opts.Bootstrap {
+		var r upstream.Resolver
+		r, err = upstream.NewUpstreamResolver(b, opts)
+		if err != nil {
+			for _, c := range closers {
+				err = errors.WithDeferred(err, c.Close())
+			}
+
+			return nil, nil, fmt.Errorf("creating bootstrap resolver %q: %w", b, err)
+		}
+
+		resolvers = append(resolvers, r)
+		if c, ok := r.(io.Closer); ok {
+			closers = append(closers, c)
+		}
+	}
+
+	if len(resolvers) == 0 {
+		resolvers = []upstream.Resolver{net.DefaultResolver}
+	}
+
+	return resolvers, closers, nil
+}
+
+// newBootstrapDialFunc returns the function dialing the addresses using the
+// timeout from opts and resolving the hostnames using resolvers.
+func newBootstrapDialFunc(
+	opts *upstream.Options,
+	resolvers []upstream.Resolver,
+) (dial func(ctx context.Context, network, addr string) (conn net.Conn, err error)) {
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+	}
+
+	ipNetwork := "ip4"
+	if opts.PreferIPv6 {
+		ipNetwork = "ip"
+	}
+
+	return func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = netip.ParseAddr(host); err == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		var errs []error
+		for _, r := range resolvers {
+			var ips []netip.Addr
+			ips, err = r.LookupNetIP(ctx, ipNetwork, host)
+			if err != nil {
+				errs = append(errs, err)
+
+				continue
+			}
+
+			for _, ip := range ips {
+				conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+				if err == nil {
+					return conn, nil
+				}
+
+				errs = append(errs, err)
+			}
+		}
+
+		return nil, fmt.Errorf("dialing %q: %w", addr, errors.Join(errs...))
+	}
+}
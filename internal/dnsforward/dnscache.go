@@ -0,0 +1,852 @@
+package dnsforward
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// dnsCacheShards is the number of the independently locked parts of the DNS
+// cache.
+const dnsCacheShards = 16
+
+// cacheOptimisticTTL is the TTL of the expired responses served from the cache
+// in the optimistic mode, in seconds.
+const cacheOptimisticTTL = 10
+
+// CacheTypePolicyConfig is the configuration of the caching of the responses
+// to the requests with particular query types.
+type CacheTypePolicyConfig struct {
+	// Types are the names of the query types, for example "A" or "HTTPS".
+	Types []string `yaml:"types"`
+
+	// MinTTL, if not zero, overrides [Config.CacheMinTTL] for the matching
+	// responses.
+	MinTTL uint32 `yaml:"cache_ttl_min"`
+
+	// MaxTTL, if not zero, overrides [Config.CacheMaxTTL] for the matching
+	// responses.
+	MaxTTL uint32 `yaml:"cache_ttl_max"`
+
+	// Disabled, if true, disables the caching of the matching responses.
+	Disabled bool `yaml:"disabled"`
+}
+
+// cacheTypePolicy is the cache type policy prepared for use.
+type cacheTypePolicy struct {
+	// types are the matching query types.
+	types []uint16
+
+	minTTL   uint32
+	maxTTL   uint32
+	disabled bool
+}
+
+// newCacheTypePolicies validates confs and returns the policies for them.
+func newCacheTypePolicies(confs []*CacheTypePolicyConfig) (ps []*cacheTypePolicy, err error) {
+	for i, c := range confs {
+		if c == nil {
+			return nil, fmt.Errorf("cache type policy at index %d: %w", i, errors.Error("no value"))
+		} else if len(c.Types) == 0 {
+			return nil, fmt.Errorf("cache type policy at index %d: %w", i, errors.Error("no types"))
+		} else if c.MaxTTL != 0 && c.MinTTL > c.MaxTTL {
+			return nil, fmt.Errorf(
+				"cache type policy at index %d: cache_ttl_min %d is greater than cache_ttl_max %d",
+				i,
+				c.MinTTL,
+				c.MaxTTL,
+			)
+		}
+
+		p := &cacheTypePolicy{
+			minTTL:   c.MinTTL,
+			maxTTL:   c.MaxTTL,
+			disabled: c.Disabled,
+		}
+
+		for _, name := range c.Types {
+			qt, ok := dns.StringToType[strings.ToUpper(name)]
+			if !ok {
+				return nil, fmt.Errorf("cache type policy at index %d: unknown type %q", i, name)
+			}
+
+			p.types = append(p.types, qt)
+		}
+
+		ps = append(ps, p)
+	}
+
+	return ps, nil
+}
+
+// dnsCacheKey is the key of a cached response.
+type dnsCacheKey struct {
+	// name is the lowercased name of the question.
+	name string
+
+	// subnet is the client subnet the response has been received for.  It's
+	// invalid if the response doesn't depend on the client subnet.
+	subnet netip.Prefix
+
+	qtype  uint16
+	qclass uint16
+
+	// do is true if the response has been received for the request with the
+	// DNSSEC OK flag set.
+	do bool
+}
+
+// dnsCacheItem is a single cached response.
+type dnsCacheItem struct {
+	// msg is the cached response without the OPT records.  It must not be
+	// modified.
+	msg *dns.Msg
+
+	// upstream is the address of the upstream, which has resolved msg.
+	upstream string
+
+	// expire is the time, after which msg is considered expired.
+	expire time.Time
+
+	key dnsCacheKey
+
+	// size is the estimated size of the item in bytes.
+	size int
+
+	// refreshing is true if the expired item is being refreshed in the
+	// optimistic mode.
+	refreshing bool
+}
+
+// dnsCacheShard is an independently locked LRU part of the DNS cache.
+type dnsCacheShard struct {
+	// mu protects items, order, and size.
+	mu *sync.Mutex
+
+	// items are the elements of order by the key.
+	items map[dnsCacheKey]*list.Element
+
+	// order is the list of *dnsCacheItem from the most recently used to the
+	// least recently used.
+	order *list.List
+
+	// size is the total size of the items.
+	size int
+
+	// maxSize is the maximum total size of the items.
+	maxSize int
+}
+
+// dnsCache is the sharded LRU cache of the upstream responses.  The responses
+// are distributed between the shards by their names, so that the concurrent
+// requests for the different names rarely wait for each other.
+type dnsCache struct {
+	// seed is the seed for hashing the names into the shards.
+	seed maphash.Seed
+
+	shards []*dnsCacheShard
+
+	// policies are the cache type policies.  The first policy matching the
+	// query type applies.
+	policies []*cacheTypePolicy
+
+	// minTTL and maxTTL are the overrides of the TTLs of the responses.  Zero
+	// means no override.
+	minTTL uint32
+	maxTTL uint32
+
+	// optimistic, if true, makes the cache serve the expired responses while
+	// refreshing them.
+	optimistic bool
+}
+
+// newDNSCache returns a new properly initialized *dnsCache according to conf.
+// c is nil if the cache is disabled.
+func newDNSCache(conf *ServerConfig) (c *dnsCache, err error) {
+	if conf.CacheSize == 0 {
+		return nil, nil
+	}
+
+	policies, err := newCacheTypePolicies(conf.CacheTypePolicies)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	c = &dnsCache{
+		seed:       maphash.MakeSeed(),
+		shards:     make([]*dnsCacheShard, dnsCacheShards),
+		policies:   policies,
+		minTTL:     conf.CacheMinTTL,
+		maxTTL:     conf.CacheMaxTTL,
+		optimistic: conf.CacheOptimistic,
+	}
+
+	for i := range c.shards {
+		c.shards[i] = &dnsCacheShard{
+			mu:      &sync.Mutex{},
+			items:   map[dnsCacheKey]*list.Element{},
+			order:   list.New(),
+			maxSize: int(conf.CacheSize) / dnsCacheShards,
+		}
+	}
+
+	return c, nil
+}
+
+// policy returns the cache type policy for qtype, if any.
+func (c *dnsCache) policy(qtype uint16) (p *cacheTypePolicy) {
+	for _, p = range c.policies {
+		if slices.Contains(p.types, qtype) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// shard returns the shard for the items with name.
+func (c *dnsCache) shard(name string) (sh *dnsCacheShard) {
+	return c.shards[c.shardIndex(name)]
+}
+
+// shardIndex returns the index of the shard for the items with name.
+func (c *dnsCache) shardIndex(name string) (i int) {
+	return int(maphash.String(c.seed, name) % dnsCacheShards)
+}
+
+// key returns the cache key for the request in pctx sent from subnet.  ok is
+// false if the response to it mustn't be cached.  c may be nil.
+func (c *dnsCache) key(pctx *proxy.DNSContext, subnet netip.Prefix) (key dnsCacheKey, ok bool) {
+	if c == nil || pctx.CustomUpstreamConfig != nil || pctx.Req.CheckingDisabled {
+		return dnsCacheKey{}, false
+	}
+
+	q := pctx.Req.Question[0]
+	if p := c.policy(q.Qtype); p != nil && p.disabled {
+		return dnsCacheKey{}, false
+	}
+
+	return dnsCacheKey{
+		name:   strings.ToLower(q.Name),
+		subnet: subnet,
+		qtype:  q.Qtype,
+		qclass: q.Qclass,
+		do:     hasDO(pctx.Req),
+	}, true
+}
+
+// get sets the response to the request in pctx from the cache, if there is
+// one for key.  refresh is true if the response has expired and should be
+// refreshed.
+func (c *dnsCache) get(key dnsCacheKey, pctx *proxy.DNSContext) (hit, refresh bool) {
+	sh := c.shard(key.name)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.items[key]
+	if !ok {
+		return false, false
+	}
+
+	item := e.Value.(*dnsCacheItem)
+
+	ttl := uint32(cacheOptimisticTTL)
+	if left := time.Until(item.expire); left > 0 {
+		ttl = uint32(left.Seconds())
+	} else if !c.optimistic {
+		sh.remove(e)
+
+		return false, false
+	} else if !item.refreshing {
+		item.refreshing = true
+		refresh = true
+	}
+
+	sh.order.MoveToFront(e)
+
+	pctx.Res = cachedResp(item.msg, pctx, ttl)
+	pctx.CachedUpstreamAddr = item.upstream
+
+	return true, refresh
+}
+
+// cachedResp returns the copy of the cached msg prepared to be the response to
+// the request in pctx with all the TTLs set to ttl.
+func cachedResp(msg *dns.Msg, pctx *proxy.DNSContext, ttl uint32) (resp *dns.Msg) {
+	req := pctx.Req
+
+	resp = msg.Copy()
+	resp.Id = req.Id
+	resp.Question = slices.Clone(req.Question)
+	for _, rrs := range [...][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			rr.Header().Ttl = ttl
+		}
+	}
+
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), opt.Do())
+		size = mathutil.Max(int(opt.UDPSize()), dns.MinMsgSize)
+	}
+
+	if pctx.Proto != proxy.ProtoUDP {
+		size = dns.MaxMsgSize
+	}
+
+	resp.Truncate(size)
+
+	return resp
+}
+
+// set caches the copy of resp received from ups for key, if it's cacheable.
+func (c *dnsCache) set(key dnsCacheKey, resp *dns.Msg, ups string) {
+	ttl := c.ttl(key.qtype, resp)
+	if ttl == 0 {
+		return
+	}
+
+	msg := resp.Copy()
+	msg.Extra = slices.DeleteFunc(msg.Extra, func(rr dns.RR) (ok bool) {
+		return rr.Header().Rrtype == dns.TypeOPT
+	})
+
+	item := &dnsCacheItem{
+		msg:      msg,
+		upstream: ups,
+		expire:   time.Now().Add(time.Duration(ttl) * time.Second),
+		key:      key,
+		size:     msg.Len(),
+	}
+
+	sh := c.shard(key.name)
+	if item.size > sh.maxSize {
+		return
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if e, ok := sh.items[key]; ok {
+		sh.remove(e)
+	}
+
+	sh.items[key] = sh.order.PushFront(item)
+	sh.size += item.size
+
+	for sh.size > sh.maxSize {
+		sh.remove(sh.order.Back())
+	}
+}
+
+// ttl returns the number of seconds, for which resp to the request of qtype
+// can be cached.  ttl is zero if resp mustn't be cached.
+func (c *dnsCache) ttl(qtype uint16, resp *dns.Msg) (ttl uint32) {
+	if resp == nil || resp.Truncated || len(resp.Question) != 1 || !isCacheable(resp) {
+		return 0
+	}
+
+	ttl = math.MaxUint32
+	for _, rrs := range [...][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+				ttl = mathutil.Min(ttl, hdr.Ttl)
+			}
+		}
+	}
+
+	if ttl == math.MaxUint32 || ttl == 0 {
+		return 0
+	}
+
+	minTTL, maxTTL := c.minTTL, c.maxTTL
+	if p := c.policy(qtype); p != nil {
+		if p.minTTL != 0 {
+			minTTL = p.minTTL
+		}
+
+		if p.maxTTL != 0 {
+			maxTTL = p.maxTTL
+		}
+	}
+
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+
+	if maxTTL != 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	return ttl
+}
+
+// isCacheable returns true if resp contains useful data according to its
+// response code.  The negative responses are only cacheable if they contain an
+// SOA record, see RFC 2308.
+func isCacheable(resp *dns.Msg) (ok bool) {
+	hasSOA := false
+	for _, rr := range resp.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA:
+			hasSOA = true
+		case dns.TypeNS:
+			return false
+		default:
+			// Go on.
+		}
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		if qt := resp.Question[0].Qtype; qt != dns.TypeA && qt != dns.TypeAAAA {
+			return true
+		}
+
+		for _, rr := range resp.Answer {
+			if t := rr.Header().Rrtype; t == dns.TypeA || t == dns.TypeAAAA {
+				return true
+			}
+		}
+
+		return hasSOA
+	case dns.RcodeNameError:
+		return hasSOA
+	default:
+		return false
+	}
+}
+
+// remove removes e from sh.  sh.mu is expected to be locked.
+func (sh *dnsCacheShard) remove(e *list.Element) {
+	item := sh.order.Remove(e).(*dnsCacheItem)
+	delete(sh.items, item.key)
+	sh.size -= item.size
+}
+
+// delete removes the cached response for key, if any.
+func (c *dnsCache) delete(key dnsCacheKey) {
+	sh := c.shard(key.name)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if e, ok := sh.items[key]; ok {
+		sh.remove(e)
+	}
+}
+
+// dnsCacheFilter selects the cached responses by their questions.
+type dnsCacheFilter struct {
+	// pattern is the shell pattern matched against the names without the
+	// trailing dot, see [path.Match].  Empty pattern matches any name.
+	pattern string
+
+	// qtype is the matching query type.  Zero matches any type.
+	qtype uint16
+}
+
+// newDNSCacheFilter returns a new properly initialized *dnsCacheFilter.
+func newDNSCacheFilter(pattern, qtypeStr string) (f *dnsCacheFilter, err error) {
+	f = &dnsCacheFilter{
+		pattern: strings.ToLower(strings.TrimSuffix(pattern, ".")),
+	}
+
+	_, err = path.Match(f.pattern, "")
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+
+	if qtypeStr != "" {
+		var ok bool
+		f.qtype, ok = dns.StringToType[strings.ToUpper(qtypeStr)]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q", qtypeStr)
+		}
+	}
+
+	return f, nil
+}
+
+// match returns true if the item with key is selected by f.
+func (f *dnsCacheFilter) match(key dnsCacheKey) (ok bool) {
+	if f.qtype != 0 && key.qtype != f.qtype {
+		return false
+	}
+
+	if f.pattern == "" {
+		return true
+	}
+
+	// The pattern has been validated in [newDNSCacheFilter].
+	ok, _ = path.Match(f.pattern, strings.TrimSuffix(key.name, "."))
+
+	return ok
+}
+
+// cacheEntryJSON is the JSON representation of a cached response.
+type cacheEntryJSON struct {
+	// Name is the name of the question.
+	Name string `json:"name"`
+
+	// Type is the query type.
+	Type string `json:"type"`
+
+	// Class is the query class.
+	Class string `json:"class"`
+
+	// Subnet is the client subnet the response has been received for.  It's
+	// empty if the response doesn't depend on the client subnet.
+	Subnet string `json:"subnet"`
+
+	// Rcode is the response code.
+	Rcode string `json:"rcode"`
+
+	// Upstream is the address of the upstream, which has resolved the
+	// response.
+	Upstream string `json:"upstream"`
+
+	// TTL is the number of seconds left until the response expires.
+	TTL uint32 `json:"ttl"`
+
+	// Size is the estimated size of the response in bytes.
+	Size int `json:"size"`
+
+	// DNSSEC is true if the response has been received for the request with
+	// the DNSSEC OK flag set.
+	DNSSEC bool `json:"dnssec"`
+
+	// Expired is true if the response has expired and is only served in the
+	// optimistic mode.
+	Expired bool `json:"expired"`
+}
+
+// list returns the JSON representations of the cached responses selected by
+// f sorted by their names and types, as well as the total number of them.  At
+// most limit entries are returned.
+func (c *dnsCache) list(f *dnsCacheFilter, limit int) (entries []*cacheEntryJSON, total int) {
+	now := time.Now()
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for key, e := range sh.items {
+			if !f.match(key) {
+				continue
+			}
+
+			item := e.Value.(*dnsCacheItem)
+			entry := &cacheEntryJSON{
+				Name:     key.name,
+				Type:     dns.Type(key.qtype).String(),
+				Class:    dns.Class(key.qclass).String(),
+				Rcode:    dns.RcodeToString[item.msg.Rcode],
+				Upstream: item.upstream,
+				Size:     item.size,
+				DNSSEC:   key.do,
+			}
+
+			if key.subnet.IsValid() {
+				entry.Subnet = key.subnet.String()
+			}
+
+			if left := item.expire.Sub(now); left > 0 {
+				entry.TTL = uint32(left.Seconds())
+			} else {
+				entry.Expired = true
+			}
+
+			entries = append(entries, entry)
+		}
+		sh.mu.Unlock()
+	}
+
+	slices.SortFunc(entries, func(a, b *cacheEntryJSON) (res int) {
+		if a.Name != b.Name {
+			return strings.Compare(a.Name, b.Name)
+		}
+
+		return strings.Compare(a.Type, b.Type)
+	})
+
+	total = len(entries)
+	if limit < total {
+		entries = entries[:limit]
+	}
+
+	return entries, total
+}
+
+// flush removes the cached responses selected by f and returns the number of
+// them.
+func (c *dnsCache) flush(f *dnsCacheFilter) (n int) {
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for key, e := range sh.items {
+			if f.match(key) {
+				sh.remove(e)
+				n++
+			}
+		}
+		sh.mu.Unlock()
+	}
+
+	log.Info("dnsforward: cache: flushed %d entries matching %q", n, f.pattern)
+
+	return n
+}
+
+// cacheSubnet returns the client subnet, on which the response to the request
+// in pctx may depend.  subnet is invalid if the response doesn't depend on the
+// client subnet.
+func (s *Server) cacheSubnet(pctx *proxy.DNSContext) (subnet netip.Prefix) {
+	if opt := pctx.Req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			ecs, ok := o.(*dns.EDNS0_SUBNET)
+			if !ok || ecs.SourceNetmask == 0 {
+				continue
+			}
+
+			ip, _ := netip.AddrFromSlice(ecs.Address)
+			subnet, _ = ip.Unmap().Prefix(int(ecs.SourceNetmask))
+
+			return subnet
+		}
+	}
+
+	if ecs := s.conf.EDNSClientSubnet; ecs != nil && ecs.Enabled {
+		if ecs.UseCustom {
+			bits := ecsPrefixLenIPv4
+			if ecs.CustomIP.Is6() {
+				bits = ecsPrefixLenIPv6
+			}
+
+			subnet, _ = ecs.CustomIP.Prefix(bits)
+
+			return subnet
+		}
+
+		subnet, _ = clientSubnet(pctx.Addr)
+
+		return subnet
+	} else if len(s.conf.UpstreamECS) > 0 {
+		subnet, _ = clientSubnet(pctx.Addr)
+	}
+
+	return subnet
+}
+
+// resolveCached resolves the request in pctx using the cache, if it's
+// enabled, and prx otherwise.  The expired responses served in the optimistic
+// mode are refreshed in the background.  The use of the cache is recorded into
+// tr, if it isn't nil.
+func (s *Server) resolveCached(
+	prx *proxy.Proxy,
+	pctx *proxy.DNSContext,
+	tr *queryTrace,
+) (err error) {
+	c := s.cache
+	key, cacheable := c.key(pctx, s.cacheSubnet(pctx))
+	if !cacheable {
+		tr.setCache(c, key, cacheStatusNotCacheable)
+
+		return s.resolveUpstream(prx, pctx, c, key, false)
+	}
+
+	hit, refresh := c.get(key, pctx)
+	if !hit {
+		tr.setCache(c, key, cacheStatusMiss)
+
+		return s.resolveUpstream(prx, pctx, c, key, true)
+	}
+
+	if refresh {
+		tr.setCache(c, key, cacheStatusStale)
+	} else {
+		tr.setCache(c, key, cacheStatusHit)
+	}
+
+	log.Debug("dnsforward: cache: serving cached response for %q", key.name)
+
+	if refresh {
+		// Use TCP, so that the refreshed response isn't truncated.
+		refreshCtx := &proxy.DNSContext{
+			Proto: proxy.ProtoTCP,
+			Req:   pctx.Req.Copy(),
+			Addr:  pctx.Addr,
+		}
+
+		go s.refreshCached(prx, refreshCtx, c, key)
+	}
+
+	return nil
+}
+
+// resolveUpstream resolves the request in pctx using prx and caches the
+// response for key in c, if cacheable is true.
+func (s *Server) resolveUpstream(
+	prx *proxy.Proxy,
+	pctx *proxy.DNSContext,
+	c *dnsCache,
+	key dnsCacheKey,
+	cacheable bool,
+) (err error) {
+	if s.isRaceMode() {
+		// Expect the race upstream to store the upstream, which response has
+		// been used.
+		s.raceWinners.Store(pctx.Req, nil)
+	}
+
+	err = s.resolve(prx, pctx)
+	s.setRaceWinner(pctx)
+	if err == nil && cacheable && pctx.Upstream != nil {
+		c.set(key, pctx.Res, pctx.Upstream.Address())
+	}
+
+	return err
+}
+
+// refreshCached resolves the request in pctx, which has been served from the
+// cache after expiring, and caches the new response for key in c.  It is
+// intended to be used as a goroutine.
+func (s *Server) refreshCached(
+	prx *proxy.Proxy,
+	pctx *proxy.DNSContext,
+	c *dnsCache,
+	key dnsCacheKey,
+) {
+	defer log.OnPanic("dnsforward: cache: refreshing")
+
+	if len(s.conf.UpstreamECS) > 0 {
+		if subnet, ok := clientSubnet(pctx.Addr); ok {
+			s.clientSubnets.Store(pctx.Req, subnet)
+			defer s.clientSubnets.Delete(pctx.Req)
+		}
+	}
+
+	err := s.resolveUpstream(prx, pctx, c, key, true)
+	if err != nil {
+		log.Debug("dnsforward: cache: refreshing %q: %s", key.name, err)
+
+		// Let the next request try to refresh it again.
+		c.delete(key)
+	}
+}
+
+// defaultCacheListLimit is the default maximum number of the cached responses
+// returned by the GET /control/cache HTTP API.
+const defaultCacheListLimit = 100
+
+// cacheListJSON is the JSON representation of the cached responses.
+type cacheListJSON struct {
+	// Entries are the selected cached responses.
+	Entries []*cacheEntryJSON `json:"entries"`
+
+	// Total is the total number of the selected cached responses, which may
+	// be greater than the number of Entries.
+	Total int `json:"total"`
+
+	// Enabled is true if the cache is enabled.
+	Enabled bool `json:"enabled"`
+}
+
+// handleCacheList is the handler for the GET /control/cache HTTP API.
+func (s *Server) handleCacheList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, err := newDNSCacheFilter(q.Get("pattern"), q.Get("type"))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	limit := defaultCacheListLimit
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad limit %q", limitStr)
+
+			return
+		}
+	}
+
+	resp := &cacheListJSON{
+		Entries: []*cacheEntryJSON{},
+	}
+
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	if s.cache != nil {
+		resp.Enabled = true
+		resp.Entries, resp.Total = s.cache.list(f, limit)
+		if resp.Entries == nil {
+			resp.Entries = []*cacheEntryJSON{}
+		}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// cacheFlushReq is the request to the POST /control/cache/flush HTTP API.
+type cacheFlushReq struct {
+	// Pattern is the shell pattern of the names of the responses to remove.
+	// Empty pattern matches all names.
+	Pattern string `json:"pattern"`
+
+	// Type is the query type of the responses to remove.  Empty type matches
+	// all types.
+	Type string `json:"type"`
+}
+
+// cacheFlushResp is the response to the POST /control/cache/flush HTTP API.
+type cacheFlushResp struct {
+	// Flushed is the number of the removed responses.
+	Flushed int `json:"flushed"`
+}
+
+// handleCacheFlush is the handler for the POST /control/cache/flush HTTP API.
+func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	req := &cacheFlushReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	f, err := newDNSCacheFilter(req.Pattern, req.Type)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	resp := &cacheFlushResp{}
+
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	if s.cache != nil {
+		resp.Flushed = s.cache.flush(f)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
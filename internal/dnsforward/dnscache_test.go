@@ -0,0 +1,391 @@
+package dnsforward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCacheResp returns a response to the A request for name with a single
+// record with ttl.
+func newTestCacheResp(name string, ttl uint32) (resp *dns.Msg) {
+	req := (&dns.Msg{}).SetQuestion(name, dns.TypeA)
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		A: net.IP{192, 0, 2, 1},
+	}}
+
+	return resp
+}
+
+// newTestCachePctx returns a new context of the A request for name sent over
+// UDP.
+func newTestCachePctx(name string) (pctx *proxy.DNSContext) {
+	return &proxy.DNSContext{
+		Proto: proxy.ProtoUDP,
+		Req:   (&dns.Msg{}).SetQuestion(name, dns.TypeA),
+		Addr:  &net.UDPAddr{IP: net.IP{192, 0, 2, 10}, Port: 53},
+	}
+}
+
+// newTestDNSCache returns a new *dnsCache for tests.
+func newTestDNSCache(t *testing.T, conf *ServerConfig) (c *dnsCache) {
+	t.Helper()
+
+	if conf.CacheSize == 0 {
+		conf.CacheSize = 64 * 1024
+	}
+
+	c, err := newDNSCache(conf)
+	require.NoError(t, err)
+	require.NotNil(t, c)
+
+	return c
+}
+
+func TestDNSCache_get(t *testing.T) {
+	const name = "Example.ORG."
+
+	c := newTestDNSCache(t, &ServerConfig{})
+	subnet := netip.MustParsePrefix("192.0.2.0/24")
+
+	key, ok := c.key(newTestCachePctx(name), subnet)
+	require.True(t, ok)
+
+	c.set(key, newTestCacheResp(name, 60), "tcp://upstream")
+
+	t.Run("hit", func(t *testing.T) {
+		pctx := newTestCachePctx("example.org.")
+		pctx.Req.Id = 1234
+
+		hit, refresh := c.get(key, pctx)
+		require.True(t, hit)
+
+		assert.False(t, refresh)
+		assert.Equal(t, "tcp://upstream", pctx.CachedUpstreamAddr)
+
+		require.NotNil(t, pctx.Res)
+
+		assert.Equal(t, uint16(1234), pctx.Res.Id)
+		assert.Equal(t, "example.org.", pctx.Res.Question[0].Name)
+
+		require.Len(t, pctx.Res.Answer, 1)
+
+		assert.LessOrEqual(t, pctx.Res.Answer[0].Header().Ttl, uint32(60))
+	})
+
+	t.Run("other_subnet", func(t *testing.T) {
+		pctx := newTestCachePctx(name)
+		otherKey, _ := c.key(pctx, netip.MustParsePrefix("198.51.100.0/24"))
+
+		hit, _ := c.get(otherKey, pctx)
+		assert.False(t, hit)
+	})
+
+	t.Run("dnssec_ok", func(t *testing.T) {
+		pctx := newTestCachePctx(name)
+		pctx.Req.SetEdns0(dns.DefaultMsgSize, true)
+		doKey, _ := c.key(pctx, subnet)
+
+		hit, _ := c.get(doKey, pctx)
+		assert.False(t, hit)
+	})
+
+	t.Run("checking_disabled", func(t *testing.T) {
+		pctx := newTestCachePctx(name)
+		pctx.Req.CheckingDisabled = true
+
+		_, ok = c.key(pctx, subnet)
+		assert.False(t, ok)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expireTestCache(c)
+
+		hit, _ := c.get(key, newTestCachePctx(name))
+		assert.False(t, hit)
+
+		_, total := c.list(&dnsCacheFilter{}, 0)
+		assert.Zero(t, total)
+	})
+
+	t.Run("optimistic", func(t *testing.T) {
+		oc := newTestDNSCache(t, &ServerConfig{
+			Config: Config{
+				CacheOptimistic: true,
+			},
+		})
+		oc.set(key, newTestCacheResp(name, 60), "tcp://upstream")
+		expireTestCache(oc)
+
+		pctx := newTestCachePctx(name)
+		hit, refresh := oc.get(key, pctx)
+		require.True(t, hit)
+
+		assert.True(t, refresh)
+		assert.Equal(t, uint32(cacheOptimisticTTL), pctx.Res.Answer[0].Header().Ttl)
+
+		hit, refresh = oc.get(key, newTestCachePctx(name))
+		require.True(t, hit)
+
+		assert.False(t, refresh)
+	})
+}
+
+// expireTestCache makes all the items of c expire.
+func expireTestCache(c *dnsCache) {
+	for _, sh := range c.shards {
+		for _, e := range sh.items {
+			e.Value.(*dnsCacheItem).expire = time.Now().Add(-time.Second)
+		}
+	}
+}
+
+func TestDNSCache_set_evict(t *testing.T) {
+	resp := newTestCacheResp("0000.example.", 60)
+	c := newTestDNSCache(t, &ServerConfig{
+		Config: Config{
+			CacheSize: uint32(dnsCacheShards * resp.Len() * 3),
+		},
+	})
+
+	// Find the names hashed into the same shard, which only fits three of
+	// them.
+	sh := c.shard("a.example.")
+	names := []string{"a.example."}
+	for i := 0; len(names) < 5; i++ {
+		name := fmt.Sprintf("%d.example.", i)
+		if c.shard(name) == sh {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		key, ok := c.key(newTestCachePctx(name), netip.Prefix{})
+		require.True(t, ok)
+
+		c.set(key, newTestCacheResp(name, 60), "tcp://upstream")
+	}
+
+	assert.LessOrEqual(t, sh.size, sh.maxSize)
+	assert.Len(t, sh.items, 3)
+
+	last := names[len(names)-1]
+	key, _ := c.key(newTestCachePctx(last), netip.Prefix{})
+	hit, _ := c.get(key, newTestCachePctx(last))
+	assert.True(t, hit)
+
+	first := names[0]
+	key, _ = c.key(newTestCachePctx(first), netip.Prefix{})
+	hit, _ = c.get(key, newTestCachePctx(first))
+	assert.False(t, hit)
+}
+
+func TestDNSCache_ttl(t *testing.T) {
+	c := newTestDNSCache(t, &ServerConfig{
+		Config: Config{
+			CacheMinTTL: 30,
+			CacheMaxTTL: 3600,
+			CacheTypePolicies: []*CacheTypePolicyConfig{{
+				Types:  []string{"a"},
+				MinTTL: 300,
+			}, {
+				Types:  []string{"TXT"},
+				MaxTTL: 10,
+			}},
+		},
+	})
+
+	aaaaReq := (&dns.Msg{}).SetQuestion("example.", dns.TypeAAAA)
+	nxdomain := (&dns.Msg{}).SetRcode(aaaaReq, dns.RcodeNameError)
+	nxdomain.Ns = []dns.RR{&dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   "example.",
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    7200,
+		},
+	}}
+
+	aReq := (&dns.Msg{}).SetQuestion("example.", dns.TypeA)
+	servfail := (&dns.Msg{}).SetRcode(aReq, dns.RcodeServerFailure)
+
+	truncated := newTestCacheResp("example.", 60)
+	truncated.Truncated = true
+
+	testCases := []struct {
+		resp  *dns.Msg
+		name  string
+		qtype uint16
+		want  uint32
+	}{{
+		resp:  newTestCacheResp("example.", 60),
+		name:  "policy_min",
+		qtype: dns.TypeA,
+		want:  300,
+	}, {
+		resp:  newTestCacheResp("example.", 60),
+		name:  "policy_max",
+		qtype: dns.TypeTXT,
+		want:  10,
+	}, {
+		resp:  newTestCacheResp("example.", 10),
+		name:  "global_min",
+		qtype: dns.TypeMX,
+		want:  30,
+	}, {
+		resp:  nxdomain,
+		name:  "global_max_negative",
+		qtype: dns.TypeAAAA,
+		want:  3600,
+	}, {
+		resp:  servfail,
+		name:  "servfail",
+		qtype: dns.TypeA,
+		want:  0,
+	}, {
+		resp:  truncated,
+		name:  "truncated",
+		qtype: dns.TypeA,
+		want:  0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, c.ttl(tc.qtype, tc.resp))
+		})
+	}
+}
+
+func TestNewCacheTypePolicies(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*CacheTypePolicyConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs: []*CacheTypePolicyConfig{{
+			Types:    []string{"HTTPS", "svcb"},
+			Disabled: true,
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "cache type policy at index 0: no value",
+		confs:      []*CacheTypePolicyConfig{nil},
+	}, {
+		name:       "no_types",
+		wantErrMsg: "cache type policy at index 0: no types",
+		confs:      []*CacheTypePolicyConfig{{}},
+	}, {
+		name:       "bad_type",
+		wantErrMsg: `cache type policy at index 0: unknown type "BAD"`,
+		confs: []*CacheTypePolicyConfig{{
+			Types: []string{"BAD"},
+		}},
+	}, {
+		name: "bad_ttl",
+		wantErrMsg: "cache type policy at index 0: " +
+			"cache_ttl_min 20 is greater than cache_ttl_max 10",
+		confs: []*CacheTypePolicyConfig{{
+			Types:  []string{"A"},
+			MinTTL: 20,
+			MaxTTL: 10,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newCacheTypePolicies(tc.confs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestServer_handleCache(t *testing.T) {
+	c := newTestDNSCache(t, &ServerConfig{})
+	for _, name := range []string{"example.org.", "a.example.org.", "example.com."} {
+		key, ok := c.key(newTestCachePctx(name), netip.Prefix{})
+		require.True(t, ok)
+
+		c.set(key, newTestCacheResp(name, 60), "tcp://upstream")
+	}
+
+	s := &Server{
+		cache: c,
+	}
+
+	list := func(t *testing.T, query string) (resp *cacheListJSON) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		s.handleCacheList(w, httptest.NewRequest(http.MethodGet, "/control/cache?"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp = &cacheListJSON{}
+		err := json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	t.Run("list", func(t *testing.T) {
+		resp := list(t, "")
+		assert.True(t, resp.Enabled)
+		assert.Equal(t, 3, resp.Total)
+
+		require.Len(t, resp.Entries, 3)
+
+		assert.Equal(t, "a.example.org.", resp.Entries[0].Name)
+		assert.Equal(t, "A", resp.Entries[0].Type)
+		assert.Equal(t, "tcp://upstream", resp.Entries[0].Upstream)
+	})
+
+	t.Run("list_pattern", func(t *testing.T) {
+		resp := list(t, "pattern=*.org&limit=1")
+		assert.Equal(t, 2, resp.Total)
+
+		require.Len(t, resp.Entries, 1)
+
+		assert.Equal(t, "a.example.org.", resp.Entries[0].Name)
+	})
+
+	t.Run("list_bad_pattern", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.handleCacheList(w, httptest.NewRequest(http.MethodGet, "/control/cache?pattern=[", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"pattern":"*.example.org"}`)
+		w := httptest.NewRecorder()
+		s.handleCacheFlush(w, httptest.NewRequest(http.MethodPost, "/control/cache/flush", body))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &cacheFlushResp{}
+		err := json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, resp.Flushed)
+		assert.Equal(t, 2, list(t, "").Total)
+	})
+}
@@ -0,0 +1,208 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/miekg/dns"
+)
+
+// DNSCryptResolver is the configuration of an additional DNSCrypt resolver
+// with its own provider name and keys.
+type DNSCryptResolver struct {
+	// ResolverCert is the certificate of the resolver.  It must not be nil.
+	ResolverCert *dnscrypt.Cert
+
+	// ProviderName is the DNSCrypt provider name of the resolver.  It must not
+	// be empty.
+	ProviderName string
+
+	// UDPListenAddrs are the UDP addresses to listen on.
+	UDPListenAddrs []*net.UDPAddr
+
+	// TCPListenAddrs are the TCP addresses to listen on.
+	TCPListenAddrs []*net.TCPAddr
+}
+
+// dnsCryptResolvers serves the additional DNSCrypt resolvers, since the proxy
+// only supports a single provider name.
+type dnsCryptResolvers struct {
+	// servers are the DNSCrypt servers, one for each resolver.
+	servers []*dnscrypt.Server
+
+	// udpListens are the listened UDP connections, in the same order as
+	// servers.
+	udpListens [][]*net.UDPConn
+
+	// tcpListens are the listened TCP connections, in the same order as
+	// servers.
+	tcpListens [][]net.Listener
+}
+
+// newDNSCryptResolvers returns the additional DNSCrypt resolvers, which pass
+// the requests to s.  It returns nil if there are no resolvers.
+func newDNSCryptResolvers(s *Server, confs []*DNSCryptResolver) (rs *dnsCryptResolvers, err error) {
+	if len(confs) == 0 {
+		return nil, nil
+	}
+
+	rs = &dnsCryptResolvers{}
+	for i, c := range confs {
+		switch {
+		case c == nil:
+			return nil, fmt.Errorf("resolver at index %d: %w", i, errors.Error("no value"))
+		case c.ResolverCert == nil:
+			return nil, fmt.Errorf("resolver at index %d: no certificate", i)
+		case c.ProviderName == "":
+			return nil, fmt.Errorf("resolver at index %d: no provider name", i)
+		}
+
+		rs.servers = append(rs.servers, &dnscrypt.Server{
+			ProviderName: c.ProviderName,
+			ResolverCert: c.ResolverCert,
+			Handler:      &dnsCryptHandler{srv: s},
+		})
+	}
+
+	return rs, nil
+}
+
+// start listens on the addresses of the resolvers and starts serving them.  rs
+// may be nil.
+func (rs *dnsCryptResolvers) start(confs []*DNSCryptResolver) (err error) {
+	if rs == nil {
+		return nil
+	}
+
+	rs.udpListens = make([][]*net.UDPConn, len(rs.servers))
+	rs.tcpListens = make([][]net.Listener, len(rs.servers))
+
+	for i, c := range confs {
+		err = rs.listen(i, c)
+		if err != nil {
+			rs.close()
+
+			return fmt.Errorf("dnscrypt resolver %q: %w", c.ProviderName, err)
+		}
+	}
+
+	for i, srv := range rs.servers {
+		for _, l := range rs.udpListens[i] {
+			go func(srv *dnscrypt.Server, l *net.UDPConn) { _ = srv.ServeUDP(l) }(srv, l)
+		}
+
+		for _, l := range rs.tcpListens[i] {
+			go func(srv *dnscrypt.Server, l net.Listener) { _ = srv.ServeTCP(l) }(srv, l)
+		}
+	}
+
+	return nil
+}
+
+// listen creates the listeners of the resolver at index i.
+func (rs *dnsCryptResolvers) listen(i int, c *DNSCryptResolver) (err error) {
+	for _, a := range c.UDPListenAddrs {
+		var l *net.UDPConn
+		l, err = net.ListenUDP("udp", a)
+		if err != nil {
+			return fmt.Errorf("listening to udp socket: %w", err)
+		}
+
+		rs.udpListens[i] = append(rs.udpListens[i], l)
+		log.Info("dnsforward: dnscrypt resolver %q: listening on udp://%s", c.ProviderName, l.LocalAddr())
+	}
+
+	for _, a := range c.TCPListenAddrs {
+		var l net.Listener
+		l, err = net.ListenTCP("tcp", a)
+		if err != nil {
+			return fmt.Errorf("listening to tcp socket: %w", err)
+		}
+
+		rs.tcpListens[i] = append(rs.tcpListens[i], l)
+		log.Info("dnsforward: dnscrypt resolver %q: listening on tcp://%s", c.ProviderName, l.Addr())
+	}
+
+	return nil
+}
+
+// close closes the listeners of the resolvers.  Like the proxy, it doesn't
+// wait for the requests being processed.  rs may be nil.
+func (rs *dnsCryptResolvers) close() {
+	if rs == nil {
+		return
+	}
+
+	var errs []error
+	for _, ls := range rs.udpListens {
+		for _, l := range ls {
+			errs = append(errs, l.Close())
+		}
+	}
+
+	for _, ls := range rs.tcpListens {
+		for _, l := range ls {
+			errs = append(errs, l.Close())
+		}
+	}
+
+	rs.udpListens, rs.tcpListens = nil, nil
+
+	err := errors.Join(errs...)
+	if err != nil {
+		log.Error("dnsforward: closing dnscrypt resolvers: %s", err)
+	}
+}
+
+// dnsCryptHandler is a [dnscrypt.Handler], which processes the requests to the
+// additional DNSCrypt resolvers the same way the proxy processes the requests
+// to the main one.
+type dnsCryptHandler struct {
+	srv *Server
+}
+
+// type check
+var _ dnscrypt.Handler = (*dnsCryptHandler)(nil)
+
+// ServeDNS implements the [dnscrypt.Handler] interface for *dnsCryptHandler.
+func (h *dnsCryptHandler) ServeDNS(rw dnscrypt.ResponseWriter, req *dns.Msg) (err error) {
+	if req.Response {
+		return nil
+	}
+
+	pctx := &proxy.DNSContext{
+		Proto:                  proxy.ProtoDNSCrypt,
+		Req:                    req,
+		Addr:                   rw.RemoteAddr(),
+		StartTime:              time.Now(),
+		DNSCryptResponseWriter: rw,
+	}
+
+	ok, err := h.srv.beforeRequestHandler(nil, pctx)
+	if err != nil {
+		log.Error("dnsforward: dnscrypt resolver: %s", err)
+		pctx.Res = h.srv.genServerFailure(req)
+	} else if !ok {
+		return nil
+	} else if len(req.Question) != 1 {
+		pctx.Res = h.srv.genServerFailure(req)
+	}
+
+	if pctx.Res == nil {
+		err = h.srv.handleDNSRequest(nil, pctx)
+		if err != nil {
+			log.Debug("dnsforward: dnscrypt resolver: handling request: %s", err)
+		}
+	}
+
+	if pctx.Res == nil {
+		return nil
+	}
+
+	return rw.WriteMsg(pctx.Res)
+}
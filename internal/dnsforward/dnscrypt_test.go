@@ -0,0 +1,114 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_dnsCryptResolvers(t *testing.T) {
+	rc, err := dnscrypt.GenerateResolverConfig("extra.example", nil)
+	require.NoError(t, err)
+
+	cert, err := rc.CreateCert()
+	require.NoError(t, err)
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+	}, nil)
+
+	s.conf.DNSCryptConfig = DNSCryptConfig{
+		AdditionalResolvers: []*DNSCryptResolver{{
+			ResolverCert:   cert,
+			ProviderName:   rc.ProviderName,
+			UDPListenAddrs: []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		}},
+		Enabled: true,
+	}
+
+	err = s.Prepare(&s.conf)
+	require.NoError(t, err)
+
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{newGoogleUpstream()}
+	startDeferStop(t, s)
+
+	require.NotNil(t, s.dnsCrypt)
+	require.Len(t, s.dnsCrypt.udpListens, 1)
+	require.Len(t, s.dnsCrypt.udpListens[0], 1)
+
+	addr := s.dnsCrypt.udpListens[0][0].LocalAddr().String()
+	stamp, err := rc.CreateStamp(addr)
+	require.NoError(t, err)
+
+	cli := &dnscrypt.Client{
+		Net:     "udp",
+		Timeout: testTimeout,
+	}
+
+	ri, err := cli.DialStamp(stamp)
+	require.NoError(t, err)
+
+	resp, err := cli.Exchange(createGoogleATestMessage(), ri)
+	require.NoError(t, err)
+
+	assertGoogleAResponse(t, resp)
+}
+
+func TestNewDNSCryptResolvers(t *testing.T) {
+	rc, err := dnscrypt.GenerateResolverConfig("extra.example", nil)
+	require.NoError(t, err)
+
+	cert, err := rc.CreateCert()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*DNSCryptResolver
+	}{{
+		name:       "empty",
+		wantErrMsg: "",
+		confs:      nil,
+	}, {
+		name:       "valid",
+		wantErrMsg: "",
+		confs: []*DNSCryptResolver{{
+			ResolverCert: cert,
+			ProviderName: rc.ProviderName,
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "resolver at index 0: no value",
+		confs:      []*DNSCryptResolver{nil},
+	}, {
+		name:       "no_cert",
+		wantErrMsg: "resolver at index 0: no certificate",
+		confs: []*DNSCryptResolver{{
+			ProviderName: rc.ProviderName,
+		}},
+	}, {
+		name:       "no_provider_name",
+		wantErrMsg: "resolver at index 0: no provider name",
+		confs: []*DNSCryptResolver{{
+			ResolverCert: cert,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err = newDNSCryptResolvers(&Server{}, tc.confs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -2,6 +2,7 @@
 package dnsforward
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,10 +13,14 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/geoip"
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
 	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/AdGuardHome/internal/rdns"
 	"github.com/AdguardTeam/AdGuardHome/internal/stats"
@@ -64,11 +69,9 @@ type DHCP interface {
 	// due to an assumption that a DHCP client must always have an IP address.
 	HostByIP(ip netip.Addr) (host string)
 
-	// IPByHost returns the IP address of the DHCP client with the given
-	// hostname.  The hostname will be an empty string if there is no such
-	// client, due to an assumption that a DHCP client must always have a
-	// hostname, either set by the client or assigned automatically.
-	IPByHost(host string) (ip netip.Addr)
+	// IPsByHost returns the IPv4 and IPv6 addresses of the DHCP client with
+	// the given hostname.  ips is empty if there is no such client.
+	IPsByHost(host string) (ips []netip.Addr)
 
 	// Enabled returns true if DHCP provides information about clients.
 	Enabled() (ok bool)
@@ -94,6 +97,15 @@ type Server struct {
 	// dnsProxy is the DNS proxy for forwarding client's DNS requests.
 	dnsProxy *proxy.Proxy
 
+	// listener is the started DNS proxy serving the listeners.  It's the same
+	// as dnsProxy unless the server has been reloaded in place, see
+	// [Server.Reload].
+	listener *proxy.Proxy
+
+	// listenerConf is the configuration, with which listener has been
+	// started.
+	listenerConf ServerConfig
+
 	// dnsFilter is the DNS filter for filtering client's DNS requests and
 	// responses.
 	dnsFilter *filtering.DNSFilter
@@ -108,9 +120,47 @@ type Server struct {
 	// stats is the statistics collector for client's DNS usage data.
 	stats stats.Interface
 
+	// blockHook runs the hooks configured for the blocked requests.
+	blockHook blockhook.Interface
+
+	// metrics are the metrics of the server.  It's nil if the metrics are
+	// disabled.
+	metrics *Metrics
+
+	// anomalies detects the anomalies in the statistics entries.  It's nil if
+	// the anomaly detection is disabled.
+	anomalies *anomaly.Detector
+
+	// notifier sends the notifications about the new clients and the upstream
+	// outages.
+	notifier notify.Interface
+
+	// seenClients are the IP addresses of the clients seen since the start,
+	// used to detect the new ones.
+	seenClients cache.Cache
+
+	// upstreamFails is the number of the consecutive failed upstream
+	// exchanges.
+	upstreamFails atomic.Uint32
+
 	// access drops unallowed clients.
 	access *accessManager
 
+	// listenerAccess are the access managers of the listener configurations
+	// with client lists.
+	listenerAccess map[*ListenerConfig]*accessManager
+
+	// geoIP is the database used to match the ASN and country access rules.
+	// It's nil if there are no databases configured.
+	geoIP *geoip.Database
+
+	// geoIPPaths are the paths of the files of geoIP.
+	geoIPPaths []string
+
+	// limiter limits the rates of the requests from the persistent clients
+	// and blocks the flooding clients.
+	limiter *clientLimiter
+
 	// localDomainSuffix is the suffix used to detect internal hosts.  It
 	// must be a valid domain name plus dots on each side.
 	localDomainSuffix string
@@ -157,6 +207,78 @@ type Server struct {
 	// We don't Start() it and so no listen port is required.
 	internalProxy *proxy.Proxy
 
+	// dnssec validates the DNSSEC signatures of the upstream responses.  It is
+	// nil if the local validation is disabled.
+	dnssec *dnssecValidator
+
+	// upstreamFailure handles the requests the upstreams have failed to
+	// resolve.  It is nil if the default behavior is used.
+	upstreamFailure *upstreamFailureHandler
+
+	// queryTypePolicies are the policies for the requests with particular query
+	// types.
+	queryTypePolicies []*queryTypePolicy
+
+	// dohRelays are the DoH relays forwarding the requests of other resolvers.
+	dohRelays []*dohRelay
+
+	// forwardingEndpoints are the upstream servers of the forwarding endpoints
+	// by their lowercased server names.
+	forwardingEndpoints map[string]upstream.Upstream
+
+	// answerOrder orders the records of the multi-record answers.  It is nil
+	// if the order of the records is preserved.
+	answerOrder *answerOrderer
+
+	// secondary serves the zones transferred from the primary servers.  It is
+	// nil if there are none.
+	secondary *secondaryZones
+
+	// dnsCrypt serves the additional DNSCrypt resolvers.  It is nil if there
+	// are none.
+	dnsCrypt *dnsCryptResolvers
+
+	// echConfigList is the serialized ECHConfigList of the ECH keys of the
+	// HTTPS server.  It is nil if there are none.
+	echConfigList []byte
+
+	// quicStats collects the statistics of the connections to the
+	// DNS-over-QUIC upstreams.
+	quicStats *quicStats
+
+	// pipelineStats collects the statistics of the pooled connections to the
+	// DNS-over-TCP and DNS-over-TLS upstreams.
+	pipelineStats *pipelineStats
+
+	// cache is the cache of the upstream responses.  It is nil if the cache
+	// is disabled.
+	cache *dnsCache
+
+	// raceStats are the estimated round-trip times of the upstreams raced in
+	// the race mode.
+	raceStats *raceStats
+
+	// raceWinners are the upstreams, which responses have been used in the
+	// race mode, by the requests being processed.
+	raceWinners *sync.Map
+
+	// clientSubnets are the subnets of the clients by the requests being
+	// resolved, which the upstreams with [UpstreamECSClient] mode send.  The
+	// keys are *dns.Msg and the values are netip.Prefix.
+	clientSubnets *sync.Map
+
+	// inflight coalesces the identical concurrent upstream requests, see
+	// [Server.resolve].
+	inflight inflightGroup
+
+	// cert is the current certificate of the encrypted DNS listeners.  It's
+	// nil if there are none.  See [Server.UpdateCertificate].
+	cert atomic.Pointer[tls.Certificate]
+
+	// acmeChallenges are the TXT records of the pending ACME DNS-01
+	// challenges.
+	acmeChallenges *acmeChallenges
+
 	// isRunning is true if the DNS server is running.
 	isRunning bool
 
@@ -169,6 +291,12 @@ type Server struct {
 
 	// serverLock protects Server.
 	serverLock sync.RWMutex
+
+	// requestLock is held for reading while a request is being processed, and
+	// for writing while the server is being reloaded, so that the requests
+	// aren't processed with a partially applied configuration.  It must be
+	// locked before serverLock.
+	requestLock sync.RWMutex
 }
 
 // defaultLocalDomainSuffix is the default suffix used to detect internal hosts
@@ -182,6 +310,10 @@ type DNSCreateParams struct {
 	DNSFilter   *filtering.DNSFilter
 	Stats       stats.Interface
 	QueryLog    querylog.QueryLog
+	BlockHook   blockhook.Interface
+	Metrics     *Metrics
+	Anomalies   *anomaly.Detector
+	Notifier    notify.Interface
 	DHCPServer  DHCP
 	PrivateNets netutil.SubnetSet
 	Anonymizer  *aghnet.IPMut
@@ -217,10 +349,23 @@ func NewServer(p DNSCreateParams) (s *Server, err error) {
 	if p.Anonymizer == nil {
 		p.Anonymizer = aghnet.NewIPMut(nil)
 	}
+
+	if p.BlockHook == nil {
+		p.BlockHook = blockhook.Empty{}
+	}
+
+	if p.Notifier == nil {
+		p.Notifier = notify.Empty{}
+	}
+
 	s = &Server{
 		dnsFilter:   p.DNSFilter,
 		stats:       p.Stats,
 		queryLog:    p.QueryLog,
+		blockHook:   p.BlockHook,
+		metrics:     p.Metrics,
+		anomalies:   p.Anomalies,
+		notifier:    p.Notifier,
 		privateNets: p.PrivateNets,
 		// TODO(e.burkov):  Use some case-insensitive string comparison.
 		localDomainSuffix: strings.ToLower(localDomainSuffix),
@@ -229,7 +374,17 @@ func NewServer(p DNSCreateParams) (s *Server, err error) {
 			EnableLRU: true,
 			MaxCount:  defaultClientIDCacheCount,
 		}),
-		anonymizer: p.Anonymizer,
+		seenClients: cache.New(cache.Config{
+			EnableLRU: true,
+			MaxCount:  seenClientsCount,
+		}),
+		anonymizer:     p.Anonymizer,
+		quicStats:      newQUICStats(),
+		pipelineStats:  newPipelineStats(),
+		raceStats:      newRaceStats(),
+		raceWinners:    &sync.Map{},
+		clientSubnets:  &sync.Map{},
+		acmeChallenges: newACMEChallenges(),
 	}
 
 	s.sysResolvers, err = sysresolv.NewSystemResolvers(nil, defaultPlainDNSPort)
@@ -261,6 +416,7 @@ func (s *Server) Close() {
 	s.stats = nil
 	s.queryLog = nil
 	s.dnsProxy = nil
+	s.listener = nil
 
 	if err := s.ipset.close(); err != nil {
 		log.Error("dnsforward: closing ipset: %s", err)
@@ -433,10 +589,26 @@ func (s *Server) Start() error {
 // startLocked starts the DNS server without locking. For internal use only.
 func (s *Server) startLocked() error {
 	err := s.dnsProxy.Start()
-	if err == nil {
-		s.isRunning = true
+	if err != nil {
+		return err
 	}
-	return err
+
+	err = s.dnsCrypt.start(s.conf.AdditionalResolvers)
+	if err != nil {
+		stopErr := s.dnsProxy.Stop()
+		if stopErr != nil {
+			log.Error("dnsforward: stopping primary resolvers: %s", stopErr)
+		}
+
+		return fmt.Errorf("starting additional dnscrypt resolvers: %w", err)
+	}
+
+	s.listener = s.dnsProxy
+	s.listenerConf = s.conf
+	s.isRunning = true
+	s.secondary.start()
+
+	return nil
 }
 
 // defaultLocalTimeout is the default timeout for resolving addresses from
@@ -518,6 +690,13 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 
 	s.initDefaultSettings()
 
+	err = validateListeners(s.conf.Listeners)
+	if err != nil {
+		return fmt.Errorf("preparing listeners: %w", err)
+	}
+
+	s.conf.addClientIDListenAddrs(s.conf.Listeners)
+
 	err = s.prepareIpsetListSettings()
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
@@ -543,15 +722,38 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 		return fmt.Errorf("preparing internal proxy: %w", err)
 	}
 
+	err = s.setupDNSSECValidator()
+	if err != nil {
+		return fmt.Errorf("preparing dnssec validator: %w", err)
+	}
+
+	err = s.prepareGeoIP()
+	if err != nil {
+		return fmt.Errorf("preparing geoip: %w", err)
+	}
+
 	s.access, err = newAccessCtx(
 		s.conf.AllowedClients,
 		s.conf.DisallowedClients,
 		s.conf.BlockedHosts,
+		s.geoIP,
 	)
 	if err != nil {
 		return fmt.Errorf("preparing access: %w", err)
 	}
 
+	s.listenerAccess, err = newListenerAccess(s.conf.Listeners, s.geoIP)
+	if err != nil {
+		return fmt.Errorf("preparing listeners access: %w", err)
+	}
+
+	err = validateFloodProtection(s.conf.FloodProtection)
+	if err != nil {
+		return fmt.Errorf("preparing limiter: %w", err)
+	}
+
+	s.limiter = newClientLimiter(s.conf.FloodProtection)
+
 	// Set the proxy here because [setupLocalResolvers] sets its values.
 	//
 	// TODO(e.burkov):  Remove once the local resolvers logic moved to dnsproxy.
@@ -567,6 +769,78 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 		return fmt.Errorf("setting up fallback dns servers: %w", err)
 	}
 
+	s.upstreamFailure, err = newUpstreamFailureHandler(s.conf.UpstreamFailure, &upstream.Options{
+		Bootstrap:    s.conf.BootstrapDNS,
+		Timeout:      s.conf.UpstreamTimeout,
+		HTTPVersions: UpstreamHTTPVersions(s.conf.UseHTTP3Upstreams),
+		PreferIPv6:   s.conf.BootstrapPreferIPv6,
+		RootCAs:      s.conf.TLSv12Roots,
+		CipherSuites: s.conf.TLSCiphers,
+	})
+	if err != nil {
+		return fmt.Errorf("setting up upstream failure handling: %w", err)
+	}
+
+	s.dohRelays, err = newDoHRelays(s.conf.DoHRelays, &upstream.Options{
+		Bootstrap:    s.conf.BootstrapDNS,
+		Timeout:      s.conf.UpstreamTimeout,
+		HTTPVersions: UpstreamHTTPVersions(s.conf.UseHTTP3Upstreams),
+		PreferIPv6:   s.conf.BootstrapPreferIPv6,
+		RootCAs:      s.conf.TLSv12Roots,
+		CipherSuites: s.conf.TLSCiphers,
+	})
+	if err != nil {
+		return fmt.Errorf("setting up doh relays: %w", err)
+	}
+
+	s.forwardingEndpoints, err = newForwardingEndpoints(s.conf.ForwardingEndpoints, &upstream.Options{
+		Bootstrap:    s.conf.BootstrapDNS,
+		Timeout:      s.conf.UpstreamTimeout,
+		HTTPVersions: UpstreamHTTPVersions(s.conf.UseHTTP3Upstreams),
+		PreferIPv6:   s.conf.BootstrapPreferIPv6,
+		RootCAs:      s.conf.TLSv12Roots,
+		CipherSuites: s.conf.TLSCiphers,
+	})
+	if err != nil {
+		return fmt.Errorf("setting up forwarding endpoints: %w", err)
+	}
+
+	s.queryTypePolicies, err = newQueryTypePolicies(s.conf.QueryTypePolicies)
+	if err != nil {
+		return fmt.Errorf("setting up query type policies: %w", err)
+	}
+
+	s.cache, err = newDNSCache(&s.conf)
+	if err != nil {
+		return fmt.Errorf("setting up cache: %w", err)
+	}
+
+	s.answerOrder, err = newAnswerOrderer(s.conf.AnswerOrder)
+	if err != nil {
+		return fmt.Errorf("setting up answer order: %w", err)
+	}
+
+	s.secondary, err = newSecondaryZones(s.conf.SecondaryZones)
+	if err != nil {
+		return fmt.Errorf("setting up secondary zones: %w", err)
+	}
+
+	s.dnsCrypt = nil
+	if c := s.conf.DNSCryptConfig; c.Enabled {
+		s.dnsCrypt, err = newDNSCryptResolvers(s, c.AdditionalResolvers)
+		if err != nil {
+			return fmt.Errorf("setting up dnscrypt resolvers: %w", err)
+		}
+	}
+
+	s.echConfigList = nil
+	if keys := s.conf.ECHKeys; len(keys) > 0 {
+		s.echConfigList, err = aghtls.ECHConfigList(keys)
+		if err != nil {
+			return fmt.Errorf("setting up ech: %w", err)
+		}
+	}
+
 	s.recDetector.clear()
 
 	s.setupAddrProc()
@@ -584,7 +858,7 @@ func (s *Server) setupFallbackDNS() (err error) {
 		return nil
 	}
 
-	uc, err := proxy.ParseUpstreamsConfig(fallbacks, &upstream.Options{
+	uc, err := ParseUpstreamsConfig(fallbacks, &upstream.Options{
 		// TODO(s.chzhen):  Investigate if other options are needed.
 		Timeout:    s.conf.UpstreamTimeout,
 		PreferIPv6: s.conf.BootstrapPreferIPv6,
@@ -605,6 +879,10 @@ func (s *Server) setupAddrProc() {
 	if s.conf.AddrProcConf == nil {
 		s.conf.AddrProcConf = &client.DefaultAddrProcConfig{}
 	}
+
+	// Don't report the well-known clients as new after a restart.
+	s.rememberClients(s.conf.AddrProcConf.InitialAddresses)
+
 	if s.conf.AddrProcConf.AddressUpdater == nil {
 		s.addrProc = client.EmptyAddrProc{}
 	} else {
@@ -634,11 +912,11 @@ func validateBlockingMode(
 		filtering.BlockingModeREFUSED,
 		filtering.BlockingModeNullIP:
 		return nil
-	case filtering.BlockingModeCustomIP:
+	case filtering.BlockingModeCustomIP, filtering.BlockingModeBlockPage:
 		if !blockingIPv4.Is4() {
-			return fmt.Errorf("blocking_ipv4 must be valid ipv4 on custom_ip blocking_mode")
+			return fmt.Errorf("blocking_ipv4 must be valid ipv4 on %s blocking_mode", mode)
 		} else if !blockingIPv6.Is6() {
-			return fmt.Errorf("blocking_ipv6 must be valid ipv6 on custom_ip blocking_mode")
+			return fmt.Errorf("blocking_ipv6 must be valid ipv6 on %s blocking_mode", mode)
 		}
 
 		return nil
@@ -690,30 +968,11 @@ func (s *Server) Stop() error {
 
 // stopLocked stops the DNS server without locking.  For internal use only.
 func (s *Server) stopLocked() (err error) {
-	// TODO(e.burkov, a.garipov):  Return critical errors, not just log them.
-	// This will require filtering all the non-critical errors in
-	// [upstream.Upstream] implementations.
-
-	if s.dnsProxy != nil {
-		err = s.dnsProxy.Stop()
-		if err != nil {
-			log.Error("dnsforward: closing primary resolvers: %s", err)
-		}
-	}
-
-	if upsConf := s.internalProxy.UpstreamConfig; upsConf != nil {
-		err = upsConf.Close()
-		if err != nil {
-			log.Error("dnsforward: closing internal resolvers: %s", err)
-		}
-	}
-
-	if upsConf := s.localResolvers.UpstreamConfig; upsConf != nil {
-		err = upsConf.Close()
-		if err != nil {
-			log.Error("dnsforward: closing local resolvers: %s", err)
-		}
-	}
+	// Get the resolvers before the listener is stopped, since it closes its
+	// own upstream configurations.
+	r := s.replaceableResolvers()
+	s.stopListener()
+	r.close()
 
 	s.isRunning = false
 
@@ -743,80 +1002,49 @@ func (s *Server) proxy() (p *proxy.Proxy) {
 	return s.dnsProxy
 }
 
-// Reconfigure applies the new configuration to the DNS server.
-func (s *Server) Reconfigure(conf *ServerConfig) error {
-	s.serverLock.Lock()
-	defer s.serverLock.Unlock()
+// Reconfigure applies the new configuration to the DNS server.  See
+// [Server.Reload].
+func (s *Server) Reconfigure(conf *ServerConfig) (err error) {
+	_, err = s.Reload(conf)
 
-	log.Info("dnsforward: starting reconfiguring server")
-	defer log.Info("dnsforward: finished reconfiguring server")
+	return err
+}
 
-	err := s.stopLocked()
-	if err != nil {
-		return fmt.Errorf("could not reconfigure the server: %w", err)
+// ServeHTTP is a HTTP handler method we use to provide DNS-over-HTTPS.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if prx := s.proxy(); prx != nil {
+		prx.ServeHTTP(w, r)
 	}
+}
 
-	// It seems that net.Listener.Close() doesn't close file descriptors right away.
-	// We wait for some time and hope that this fd will be closed.
-	time.Sleep(100 * time.Millisecond)
+// prepareGeoIP loads the GeoIP databases, unless the same files are already
+// loaded.
+func (s *Server) prepareGeoIP() (err error) {
+	paths := s.conf.GeoIPDatabases
+	if len(paths) == 0 {
+		s.geoIP, s.geoIPPaths = nil, nil
 
-	// TODO(a.garipov): This whole piece of API is weird and needs to be remade.
-	if conf == nil {
-		conf = &s.conf
-	} else {
-		closeErr := s.addrProc.Close()
-		if closeErr != nil {
-			log.Error("dnsforward: closing address processor: %s", closeErr)
-		}
+		return nil
+	} else if s.geoIP != nil && slices.Equal(paths, s.geoIPPaths) {
+		return nil
 	}
 
-	err = s.Prepare(conf)
+	db, err := geoip.New(paths, 0)
 	if err != nil {
-		return fmt.Errorf("could not reconfigure the server: %w", err)
+		// Don't wrap the error, because it's informative enough as is.
+		return err
 	}
 
-	err = s.startLocked()
-	if err != nil {
-		return fmt.Errorf("could not reconfigure the server: %w", err)
-	}
+	s.geoIP, s.geoIPPaths = db, slices.Clone(paths)
 
 	return nil
 }
 
-// ServeHTTP is a HTTP handler method we use to provide DNS-over-HTTPS.
-func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if prx := s.proxy(); prx != nil {
-		prx.ServeHTTP(w, r)
-	}
-}
-
 // IsBlockedClient returns true if the client is blocked by the current access
 // settings.
 func (s *Server) IsBlockedClient(ip netip.Addr, clientID string) (blocked bool, rule string) {
 	s.serverLock.RLock()
 	defer s.serverLock.RUnlock()
 
-	blockedByIP := false
-	if ip != (netip.Addr{}) {
-		blockedByIP, rule = s.access.isBlockedIP(ip)
-	}
-
-	allowlistMode := s.access.allowlistMode()
-	blockedByClientID := s.access.isBlockedClientID(clientID)
-
-	// Allow if at least one of the checks allows in allowlist mode, but block
-	// if at least one of the checks blocks in blocklist mode.
-	if allowlistMode && blockedByIP && blockedByClientID {
-		log.Debug("dnsforward: client %v (id %q) is not in access allowlist", ip, clientID)
-
-		// Return now without substituting the empty rule for the
-		// clientID because the rule can't be empty here.
-		return true, rule
-	} else if !allowlistMode && (blockedByIP || blockedByClientID) {
-		log.Debug("dnsforward: client %v (id %q) is in access blocklist", ip, clientID)
-
-		blocked = true
-	}
-
-	return blocked, aghalg.Coalesce(rule, clientID)
+	return s.access.isBlockedClient(ip, clientID)
 }
@@ -94,9 +94,9 @@ func createTestServer(
 	f.SetEnabled(true)
 
 	dhcp := &testDHCP{
-		OnEnabled:  func() (ok bool) { return false },
-		OnHostByIP: func(ip netip.Addr) (host string) { return "" },
-		OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+		OnEnabled:   func() (ok bool) { return false },
+		OnHostByIP:  func(ip netip.Addr) (host string) { return "" },
+		OnIPsByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 	}
 	s, err = NewServer(DNSCreateParams{
 		DHCPServer:  dhcp,
@@ -903,9 +903,9 @@ func TestBlockedCustomIP(t *testing.T) {
 	require.NoError(t, err)
 
 	dhcp := &testDHCP{
-		OnEnabled:  func() (ok bool) { return false },
-		OnHostByIP: func(_ netip.Addr) (host string) { panic("not implemented") },
-		OnIPByHost: func(_ string) (ip netip.Addr) { panic("not implemented") },
+		OnEnabled:   func() (ok bool) { return false },
+		OnHostByIP:  func(_ netip.Addr) (host string) { panic("not implemented") },
+		OnIPsByHost: func(_ string) (ips []netip.Addr) { panic("not implemented") },
 	}
 	s, err := NewServer(DNSCreateParams{
 		DHCPServer:  dhcp,
@@ -1054,6 +1054,18 @@ func TestRewrite(t *testing.T) {
 			Domain: "my.alias.example.org",
 			Answer: "example.org",
 			Type:   dns.TypeCNAME,
+		}, {
+			Domain:     "mail.test.com",
+			Answer:     "10 mx.test.com",
+			RecordType: "MX",
+		}, {
+			Domain:     "test.com",
+			Answer:     "v=spf1 mx, -all",
+			RecordType: "TXT",
+		}, {
+			Domain: "mail.alias.test.com",
+			Answer: "mail.test.com",
+			Type:   dns.TypeCNAME,
 		}},
 	}
 	f, err := filtering.New(c, nil)
@@ -1062,9 +1074,9 @@ func TestRewrite(t *testing.T) {
 	f.SetEnabled(true)
 
 	dhcp := &testDHCP{
-		OnEnabled:  func() (ok bool) { return false },
-		OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
-		OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+		OnEnabled:   func() (ok bool) { return false },
+		OnHostByIP:  func(ip netip.Addr) (host string) { panic("not implemented") },
+		OnIPsByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 	}
 	s, err := NewServer(DNSCreateParams{
 		DHCPServer:  dhcp,
@@ -1135,6 +1147,28 @@ func TestRewrite(t *testing.T) {
 
 		assert.Equal(t, "example.org.", reply.Answer[0].(*dns.CNAME).Target)
 		assert.Equal(t, dns.TypeA, reply.Answer[1].Header().Rrtype)
+
+		req = createTestMessageWithType("test.com.", dns.TypeTXT)
+		reply, eerr = dns.Exchange(req, addr.String())
+		require.NoError(t, eerr)
+
+		require.Len(t, reply.Answer, 1)
+
+		txt := testutil.RequireTypeAssert[*dns.TXT](t, reply.Answer[0])
+		assert.Equal(t, []string{"v=spf1 mx, -all"}, txt.Txt)
+
+		req = createTestMessageWithType("mail.alias.test.com.", dns.TypeMX)
+		reply, eerr = dns.Exchange(req, addr.String())
+		require.NoError(t, eerr)
+
+		require.Len(t, reply.Answer, 2)
+
+		assert.Equal(t, "mail.test.com.", reply.Answer[0].(*dns.CNAME).Target)
+
+		mx := testutil.RequireTypeAssert[*dns.MX](t, reply.Answer[1])
+		assert.Equal(t, "mail.test.com.", mx.Hdr.Name)
+		assert.Equal(t, "mx.test.com.", mx.Mx)
+		assert.Equal(t, uint16(10), mx.Preference)
 	}
 
 	for _, protect := range []bool{true, false} {
@@ -1162,9 +1196,9 @@ func publicKey(priv any) any {
 
 // testDHCP is a mock implementation of the [DHCP] interface.
 type testDHCP struct {
-	OnHostByIP func(ip netip.Addr) (host string)
-	OnIPByHost func(host string) (ip netip.Addr)
-	OnEnabled  func() (ok bool)
+	OnHostByIP  func(ip netip.Addr) (host string)
+	OnIPsByHost func(host string) (ips []netip.Addr)
+	OnEnabled   func() (ok bool)
 }
 
 // type check
@@ -1173,8 +1207,8 @@ var _ DHCP = (*testDHCP)(nil)
 // HostByIP implements the [DHCP] interface for *testDHCP.
 func (d *testDHCP) HostByIP(ip netip.Addr) (host string) { return d.OnHostByIP(ip) }
 
-// IPByHost implements the [DHCP] interface for *testDHCP.
-func (d *testDHCP) IPByHost(host string) (ip netip.Addr) { return d.OnIPByHost(host) }
+// IPsByHost implements the [DHCP] interface for *testDHCP.
+func (d *testDHCP) IPsByHost(host string) (ips []netip.Addr) { return d.OnIPsByHost(host) }
 
 // IsClientHost implements the [DHCP] interface for *testDHCP.
 func (d *testDHCP) Enabled() (ok bool) { return d.OnEnabled() }
@@ -1190,8 +1224,8 @@ func TestPTRResponseFromDHCPLeases(t *testing.T) {
 	s, err := NewServer(DNSCreateParams{
 		DNSFilter: flt,
 		DHCPServer: &testDHCP{
-			OnEnabled:  func() (ok bool) { return true },
-			OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+			OnEnabled:   func() (ok bool) { return true },
+			OnIPsByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 			OnHostByIP: func(ip netip.Addr) (host string) {
 				return "myhost"
 			},
@@ -1243,9 +1277,9 @@ func TestPTRResponseFromHosts(t *testing.T) {
 	}
 
 	dhcp := &testDHCP{
-		OnEnabled:  func() (ok bool) { return false },
-		OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
-		OnHostByIP: func(ip netip.Addr) (host string) { return "" },
+		OnEnabled:   func() (ok bool) { return false },
+		OnIPsByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
+		OnHostByIP:  func(ip netip.Addr) (host string) { return "" },
 	}
 
 	var eventsCalledCounter uint32
@@ -0,0 +1,702 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/miekg/dns"
+)
+
+// rootTrustAnchors are the DS records of the root zone key-signing keys.  See
+// https://data.iana.org/root-anchors/root-anchors.xml.
+var rootTrustAnchors = []string{
+	". 0 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 0 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+const (
+	// errDNSSECNoKey is returned when there is no DNSKEY matching the
+	// signature or the delegation signer.
+	errDNSSECNoKey errors.Error = "no matching dnskey"
+
+	// errDNSSECNoSig is returned when an RRset from a secure zone isn't signed
+	// by that zone.
+	errDNSSECNoSig errors.Error = "missing signature"
+
+	// errDNSSECNoProof is returned when there is no data in a secure zone and
+	// no valid NSEC or NSEC3 records proving that.
+	errDNSSECNoProof errors.Error = "no proof of nonexistence"
+)
+
+// maxDNSSECKeyTTL is the maximum duration for which the validated keys of a
+// zone are cached.
+const maxDNSSECKeyTTL = 1 * time.Hour
+
+// maxDNSSECCacheSize is the maximum number of the names, for which the
+// delegations are cached.  The cache is cleared when it's exceeded.
+const maxDNSSECCacheSize = 10_000
+
+// dnssecZoneKeys is a cached result of checking if a name is a delegation
+// point.  If keys is nil and insecure is false, the name isn't a zone cut and
+// belongs to the zone of its parent.
+type dnssecZoneKeys struct {
+	// expire is the time when the delegation should be checked again.
+	expire time.Time
+
+	// keys are the validated keys of the zone, if the name is a secure zone
+	// cut.
+	keys []*dns.DNSKEY
+
+	// insecure is true if the name is a delegation to an unsigned zone, which
+	// has been proven by the NSEC or NSEC3 records of the parent zone.
+	insecure bool
+}
+
+// dnssecValidator validates the DNSSEC signatures of the upstream responses
+// locally by building the chain of trust from the root trust anchors.
+//
+// TODO(a.garipov): Check the proof of nonexistence of the closer names for the
+// answers synthesized from wildcards.
+type dnssecValidator struct {
+	// exchange sends the requests for the DNSKEY and DS records.
+	exchange func(req *dns.Msg) (resp *dns.Msg, err error)
+
+	// now returns the current time.
+	now func() (now time.Time)
+
+	// keysMu protects keys.
+	keysMu *sync.Mutex
+
+	// keys are the cached delegations by the name in the canonical form.
+	keys map[string]*dnssecZoneKeys
+
+	// anchors are the trust anchors of the root zone.
+	anchors []*dns.DS
+}
+
+// newDNSSECValidator returns a new properly initialized *dnssecValidator.
+func newDNSSECValidator(
+	exchange func(req *dns.Msg) (resp *dns.Msg, err error),
+	anchors []string,
+) (v *dnssecValidator, err error) {
+	v = &dnssecValidator{
+		exchange: exchange,
+		now:      time.Now,
+		keysMu:   &sync.Mutex{},
+		keys:     map[string]*dnssecZoneKeys{},
+	}
+
+	for i, a := range anchors {
+		var rr dns.RR
+		rr, err = dns.NewRR(a)
+		if err != nil {
+			return nil, fmt.Errorf("trust anchor at index %d: %w", i, err)
+		}
+
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor at index %d: not a ds record", i)
+		}
+
+		v.anchors = append(v.anchors, ds)
+	}
+
+	return v, nil
+}
+
+// validate checks the signatures of the answer and authority sections of resp
+// and, if it doesn't contain the requested data, the proof of its
+// nonexistence.  secure is true if all of those have been validated.  err is
+// not nil if the response is bogus, that is, if it contains unsigned or badly
+// signed data from a secure zone.
+func (v *dnssecValidator) validate(resp *dns.Msg) (secure bool, err error) {
+	if len(resp.Question) == 0 {
+		return false, nil
+	}
+
+	q := resp.Question[0]
+
+	secure = true
+	for i, sect := range [][]dns.RR{resp.Answer, resp.Ns} {
+		isAuthority := i == 1
+		sets, sigs := splitRRSets(sect)
+		for _, set := range sets {
+			hdr := set[0].Header()
+			if skipRRSet(set, resp.Answer, isAuthority) {
+				continue
+			}
+
+			var setSecure bool
+			setSecure, err = v.verifyRRSet(set, sigs)
+			if err != nil {
+				return false, fmt.Errorf("%s %s: %w", hdr.Name, dns.Type(hdr.Rrtype), err)
+			}
+
+			secure = secure && setSecure
+		}
+	}
+
+	target, answered := answerTarget(resp, q)
+	if answered {
+		return secure, nil
+	}
+
+	denialSecure, err := v.verifyDenial(resp, target, q.Qtype)
+	if err != nil {
+		return false, fmt.Errorf("%s %s: %w", target, dns.Type(q.Qtype), err)
+	}
+
+	return secure && denialSecure, nil
+}
+
+// skipRRSet returns true if set shouldn't be validated by itself: the NSEC and
+// NSEC3 records are validated as the proof of nonexistence, the delegation NS
+// records in the authority section aren't signed, and the CNAME records
+// synthesized from a DNAME from answer aren't signed as well.
+func skipRRSet(set []dns.RR, answer []dns.RR, isAuthority bool) (ok bool) {
+	switch set[0].Header().Rrtype {
+	case dns.TypeNSEC, dns.TypeNSEC3:
+		return true
+	case dns.TypeNS:
+		return isAuthority
+	case dns.TypeCNAME:
+		return !isAuthority && isSynthesized(set[0].(*dns.CNAME), answer)
+	default:
+		return false
+	}
+}
+
+// isSynthesized returns true if c is synthesized from one of the DNAME records
+// from answer.  The DNAME record itself is validated separately.
+func isSynthesized(c *dns.CNAME, answer []dns.RR) (ok bool) {
+	owner := dns.CanonicalName(c.Hdr.Name)
+	for _, rr := range answer {
+		d, isDNAME := rr.(*dns.DNAME)
+		if !isDNAME {
+			continue
+		}
+
+		dOwner := dns.CanonicalName(d.Hdr.Name)
+		if owner == dOwner || !dns.IsSubDomain(dOwner, owner) {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(owner, dOwner)
+		if dns.CanonicalName(c.Target) == prefix+dns.CanonicalName(d.Target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// answerTarget follows the CNAME chain in the answer section of resp starting
+// at the name of q.  answered is true if the answer contains the data of the
+// requested type for the target name.
+func answerTarget(resp *dns.Msg, q dns.Question) (target string, answered bool) {
+	target = dns.CanonicalName(q.Name)
+	for range resp.Answer {
+		var next string
+		for _, rr := range resp.Answer {
+			hdr := rr.Header()
+			if dns.CanonicalName(hdr.Name) != target {
+				continue
+			}
+
+			if hdr.Rrtype == q.Qtype || q.Qtype == dns.TypeANY {
+				return target, true
+			} else if c, ok := rr.(*dns.CNAME); ok {
+				next = dns.CanonicalName(c.Target)
+			}
+		}
+
+		if next == "" {
+			break
+		}
+
+		target = next
+	}
+
+	return target, false
+}
+
+// verifyRRSet validates set using sigs.  secure is false if set belongs to an
+// insecure zone.
+func (v *dnssecValidator) verifyRRSet(set []dns.RR, sigs []*dns.RRSIG) (secure bool, err error) {
+	hdr := set[0].Header()
+
+	// DS records are served and signed by the parent zone.
+	name := dns.CanonicalName(hdr.Name)
+	if hdr.Rrtype == dns.TypeDS && name != "." {
+		name = parentName(name)
+	}
+
+	zone, keys, err := v.findZone(name)
+	if err != nil {
+		return false, err
+	} else if keys == nil {
+		return false, nil
+	}
+
+	err = v.verifyInZone(set, sigs, zone, keys)
+
+	return err == nil, err
+}
+
+// verifyInZone verifies set using one of sigs made by zone with one of keys.
+func (v *dnssecValidator) verifyInZone(
+	set []dns.RR,
+	sigs []*dns.RRSIG,
+	zone string,
+	keys []*dns.DNSKEY,
+) (err error) {
+	hdr := set[0].Header()
+
+	err = errDNSSECNoSig
+	for _, sig := range sigsFor(sigs, hdr.Name, hdr.Rrtype) {
+		if dns.CanonicalName(sig.SignerName) != zone {
+			continue
+		}
+
+		err = v.verifySig(sig, keys, set)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// verifySig verifies sig over set using the matching key from keys.
+func (v *dnssecValidator) verifySig(sig *dns.RRSIG, keys []*dns.DNSKEY, set []dns.RR) (err error) {
+	if !sig.ValidityPeriod(v.now()) {
+		return fmt.Errorf("signature by key %d: expired or not yet valid", sig.KeyTag)
+	}
+
+	err = errDNSSECNoKey
+	for _, k := range keys {
+		if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+			continue
+		}
+
+		err = sig.Verify(k, set)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signature by key %d: %w", sig.KeyTag, err)
+}
+
+// verifyDenial validates the proof of nonexistence of the data of type qt for
+// target in the authority section of resp.  secure is false if target belongs
+// to an insecure zone or the proof uses NSEC3 opt-out.
+func (v *dnssecValidator) verifyDenial(
+	resp *dns.Msg,
+	target string,
+	qt uint16,
+) (secure bool, err error) {
+	name := target
+	if qt == dns.TypeDS && name != "." {
+		name = parentName(name)
+	}
+
+	zone, keys, err := v.findZone(name)
+	if err != nil {
+		return false, err
+	} else if keys == nil {
+		return false, nil
+	}
+
+	d, err := v.denialRecords(resp.Ns, zone, keys)
+	if err != nil {
+		return false, err
+	}
+
+	if resp.Rcode == dns.RcodeNameError {
+		return d.proveNXDomain(target)
+	}
+
+	return d.proveNoData(target, qt)
+}
+
+// findZone returns the closest zone enclosing name and its validated keys by
+// following the chain of trust from the root zone.  keys are nil if the zone
+// is insecure.
+func (v *dnssecValidator) findZone(name string) (zone string, keys []*dns.DNSKEY, err error) {
+	zone = "."
+	keys, err = v.rootKeys()
+	if err != nil {
+		return "", nil, fmt.Errorf("dnskey of %q: %w", zone, err)
+	}
+
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		child := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		var d *dnssecZoneKeys
+		d, err = v.delegation(child, zone, keys)
+		if err != nil {
+			return "", nil, fmt.Errorf("delegation of %q: %w", child, err)
+		}
+
+		if d.insecure {
+			return child, nil, nil
+		} else if d.keys != nil {
+			zone, keys = child, d.keys
+		}
+	}
+
+	return zone, keys, nil
+}
+
+// rootKeys returns the validated keys of the root zone.
+func (v *dnssecValidator) rootKeys() (keys []*dns.DNSKEY, err error) {
+	if d := v.cached("."); d != nil {
+		return d.keys, nil
+	}
+
+	keys, ttl, err := v.fetchKeys(".", v.anchors)
+	if err != nil {
+		return nil, err
+	}
+
+	v.store(".", &dnssecZoneKeys{keys: keys}, ttl)
+
+	return keys, nil
+}
+
+// delegation checks if child is a zone cut within parent, which keys are
+// parentKeys, and returns the validated keys of child if it's a secure one.
+// A delegation is only considered insecure if the absence of the DS records is
+// proven by the NSEC or NSEC3 records of parent.
+func (v *dnssecValidator) delegation(
+	child string,
+	parent string,
+	parentKeys []*dns.DNSKEY,
+) (d *dnssecZoneKeys, err error) {
+	if d = v.cached(child); d != nil {
+		return d, nil
+	}
+
+	resp, err := v.query(child, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+
+	d = &dnssecZoneKeys{}
+	ttl := maxDNSSECKeyTTL
+
+	var dsSet []*dns.DS
+	isAlias := false
+	sets, sigs := splitRRSets(resp.Answer)
+	for _, set := range sets {
+		hdr := set[0].Header()
+		if dns.CanonicalName(hdr.Name) != child {
+			continue
+		}
+
+		switch hdr.Rrtype {
+		case dns.TypeDS:
+			// DS records must be signed by the parent zone.
+			err = v.verifyInZone(set, sigs, parent, parentKeys)
+			if err != nil {
+				return nil, fmt.Errorf("ds: %w", err)
+			}
+
+			for _, rr := range set {
+				dsSet = append(dsSet, rr.(*dns.DS))
+			}
+
+			ttl = mathutil.Min(ttl, time.Duration(hdr.Ttl)*time.Second)
+		case dns.TypeCNAME:
+			// An alias can't be a zone cut.
+			err = v.verifyInZone(set, sigs, parent, parentKeys)
+			if err != nil {
+				return nil, fmt.Errorf("cname: %w", err)
+			}
+
+			isAlias = true
+		}
+	}
+
+	switch {
+	case len(dsSet) > 0:
+		var keysTTL time.Duration
+		d.keys, keysTTL, err = v.fetchKeys(child, dsSet)
+		if err != nil {
+			return nil, fmt.Errorf("dnskey: %w", err)
+		}
+
+		ttl = mathutil.Min(ttl, keysTTL)
+	case !isAlias:
+		var dn *dnssecDenial
+		dn, err = v.denialRecords(resp.Ns, parent, parentKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		d.insecure, err = dn.proveNoDS(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	v.store(child, d, ttl)
+
+	return d, nil
+}
+
+// cached returns the cached delegation of name, if it hasn't expired yet.
+func (v *dnssecValidator) cached(name string) (d *dnssecZoneKeys) {
+	v.keysMu.Lock()
+	defer v.keysMu.Unlock()
+
+	d, ok := v.keys[name]
+	if !ok || !v.now().Before(d.expire) {
+		return nil
+	}
+
+	return d
+}
+
+// store caches the delegation d of name for ttl.
+func (v *dnssecValidator) store(name string, d *dnssecZoneKeys, ttl time.Duration) {
+	d.expire = v.now().Add(ttl)
+
+	v.keysMu.Lock()
+	defer v.keysMu.Unlock()
+
+	if len(v.keys) >= maxDNSSECCacheSize {
+		v.keys = map[string]*dnssecZoneKeys{}
+	}
+
+	v.keys[name] = d
+}
+
+// parentName returns the name of the parent of the non-root name in the
+// canonical form.
+func parentName(name string) (parent string) {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+
+	return name[off:]
+}
+
+// fetchKeys requests the DNSKEY records of zone and validates them using
+// dsSet.  ttl is the duration for which the keys may be cached.
+func (v *dnssecValidator) fetchKeys(
+	zone string,
+	dsSet []*dns.DS,
+) (keys []*dns.DNSKEY, ttl time.Duration, err error) {
+	resp, err := v.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var set []dns.RR
+	ttl = maxDNSSECKeyTTL
+	for _, rr := range resp.Answer {
+		k, ok := rr.(*dns.DNSKEY)
+		if !ok || dns.CanonicalName(k.Hdr.Name) != zone {
+			continue
+		}
+
+		keys = append(keys, k)
+		set = append(set, k)
+		if kttl := time.Duration(k.Hdr.Ttl) * time.Second; kttl < ttl {
+			ttl = kttl
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, 0, errDNSSECNoKey
+	}
+
+	var sepKeys []*dns.DNSKEY
+	for _, ds := range dsSet {
+		for _, k := range keys {
+			if k.KeyTag() != ds.KeyTag || k.Algorithm != ds.Algorithm {
+				continue
+			}
+
+			kds := k.ToDS(ds.DigestType)
+			if kds != nil && strings.EqualFold(kds.Digest, ds.Digest) {
+				sepKeys = append(sepKeys, k)
+			}
+		}
+	}
+
+	if len(sepKeys) == 0 {
+		return nil, 0, fmt.Errorf("no key matches ds: %w", errDNSSECNoKey)
+	}
+
+	_, sigs := splitRRSets(resp.Answer)
+	for _, sig := range sigsFor(sigs, zone, dns.TypeDNSKEY) {
+		err = v.verifySig(sig, sepKeys, set)
+		if err == nil {
+			return keys, ttl, nil
+		}
+	}
+
+	if err == nil {
+		err = errors.Error("no signatures")
+	}
+
+	return nil, 0, err
+}
+
+// query requests the records of type qt for name with the DO bit set.
+func (v *dnssecValidator) query(name string, qt uint16) (resp *dns.Msg, err error) {
+	req := (&dns.Msg{}).SetQuestion(name, qt)
+	req.SetEdns0(dns.DefaultMsgSize, true)
+
+	resp, err = v.exchange(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", dns.Type(qt), err)
+	} else if resp == nil {
+		return nil, fmt.Errorf("requesting %s: %w", dns.Type(qt), errors.Error("no response"))
+	} else if rc := resp.Rcode; rc != dns.RcodeSuccess && rc != dns.RcodeNameError {
+		return nil, fmt.Errorf("requesting %s: rcode %s", dns.Type(qt), dns.RcodeToString[rc])
+	}
+
+	return resp, nil
+}
+
+// splitRRSets groups rrs into the RRsets by their owner name and type and
+// separates the signatures.
+func splitRRSets(rrs []dns.RR) (sets [][]dns.RR, sigs []*dns.RRSIG) {
+	type setKey struct {
+		name  string
+		rtype uint16
+	}
+
+	idx := map[setKey]int{}
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+
+			continue
+		}
+
+		hdr := rr.Header()
+		k := setKey{name: dns.CanonicalName(hdr.Name), rtype: hdr.Rrtype}
+		i, ok := idx[k]
+		if !ok {
+			i = len(sets)
+			idx[k] = i
+			sets = append(sets, nil)
+		}
+
+		sets[i] = append(sets[i], rr)
+	}
+
+	return sets, sigs
+}
+
+// sigsFor returns the signatures covering the RRset with the owner name and
+// type.
+func sigsFor(sigs []*dns.RRSIG, name string, rtype uint16) (res []*dns.RRSIG) {
+	name = dns.CanonicalName(name)
+	for _, sig := range sigs {
+		if sig.TypeCovered == rtype && dns.CanonicalName(sig.Hdr.Name) == name {
+			res = append(res, sig)
+		}
+	}
+
+	return res
+}
+
+// setupDNSSECValidator initializes the local DNSSEC validator if it's enabled.
+// It must be called after [Server.prepareInternalProxy].
+func (s *Server) setupDNSSECValidator() (err error) {
+	if !s.conf.ValidateDNSSEC {
+		s.dnssec = nil
+
+		return nil
+	}
+
+	exchange := func(req *dns.Msg) (resp *dns.Msg, err error) {
+		dctx := &proxy.DNSContext{
+			Proto:     proxy.ProtoUDP,
+			Req:       req,
+			StartTime: time.Now(),
+		}
+
+		err = s.internalProxy.Resolve(dctx)
+
+		return dctx.Res, err
+	}
+
+	s.dnssec, err = newDNSSECValidator(exchange, rootTrustAnchors)
+
+	return err
+}
+
+// setReqDO sets the DO bit in req, so that the upstream returns the
+// signatures.  wasSet is true if req already had the DO bit.
+func setReqDO(req *dns.Msg) (wasSet bool) {
+	if hasDO(req) {
+		return true
+	}
+
+	if o := req.IsEdns0(); o != nil {
+		o.SetDo()
+	} else {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+
+	return false
+}
+
+// validateDNSSEC validates the upstream response of dctx and replaces it with
+// a SERVFAIL one if it's bogus.
+func (s *Server) validateDNSSEC(dctx *dnsContext, dnssecRequested bool) {
+	pctx := dctx.proxyCtx
+	if s.dnssec == nil || pctx.Req.CheckingDisabled {
+		return
+	}
+
+	secure, err := s.dnssec.validate(pctx.Res)
+	if err != nil {
+		log.Info("dnsforward: dnssec: bogus response for %q: %s", pctx.Req.Question[0].Name, err)
+
+		dctx.dnssecErr = err
+		dctx.origResp = pctx.Res
+		dctx.responseAD = false
+		pctx.Res = s.genServerFailure(pctx.Req)
+
+		return
+	}
+
+	dctx.responseAD = secure
+	pctx.Res.AuthenticatedData = secure
+
+	if !dnssecRequested {
+		pctx.Res.Answer = removeDNSSECRecords(pctx.Res.Answer)
+		pctx.Res.Ns = removeDNSSECRecords(pctx.Res.Ns)
+	}
+}
+
+// removeDNSSECRecords removes the DNSSEC-specific records from rrs.
+func removeDNSSECRecords(rrs []dns.RR) (res []dns.RR) {
+	res = rrs[:0]
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			// Go on.
+		default:
+			res = append(res, rr)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,332 @@
+package dnsforward
+
+import (
+	"crypto"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZoneSigner is a zone with a generated key used to sign test records.
+type testZoneSigner struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+// newTestZoneSigner generates a new key-signing key for zone.
+func newTestZoneSigner(t *testing.T, zone string) (z *testZoneSigner) {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	require.NoError(t, err)
+
+	return &testZoneSigner{
+		key:  key,
+		priv: priv.(crypto.Signer),
+	}
+}
+
+// sign returns the signature of set by z.
+func (z *testZoneSigner) sign(t *testing.T, set ...dns.RR) (sig *dns.RRSIG) {
+	t.Helper()
+
+	now := time.Now()
+	sig = &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   set[0].Header().Name,
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    set[0].Header().Ttl,
+		},
+		Algorithm:  z.key.Algorithm,
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.key.Hdr.Name,
+	}
+
+	err := sig.Sign(z.priv, set)
+	require.NoError(t, err)
+
+	return sig
+}
+
+// newTestNSEC returns a new NSEC record for name with next and types.
+func newTestNSEC(name, next string, types ...uint16) (n *dns.NSEC) {
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+// testDNSSECDenial is a negative response of the test authoritative server.
+type testDNSSECDenial struct {
+	ns    []dns.RR
+	rcode int
+}
+
+func TestDNSSECValidator_validate(t *testing.T) {
+	const (
+		zone         = "example."
+		host         = "www.example."
+		missing      = "nope.example."
+		insecureZone = "insecure."
+		insecureHost = "www.insecure."
+		noDSZone     = "nods."
+		noDSHost     = "www.nods."
+	)
+
+	root := newTestZoneSigner(t, ".")
+	child := newTestZoneSigner(t, zone)
+
+	childDS := child.key.ToDS(dns.SHA256)
+	childDS.Hdr.Ttl = 3600
+
+	records := map[uint16]map[string][]dns.RR{
+		dns.TypeDNSKEY: {
+			".":  {root.key, root.sign(t, root.key)},
+			zone: {child.key, child.sign(t, child.key)},
+		},
+		dns.TypeDS: {
+			zone: {childDS, root.sign(t, childDS)},
+		},
+	}
+
+	insecureNSEC := newTestNSEC(insecureZone, noDSZone, dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC)
+	hostNSEC := newTestNSEC(host, zone, dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC)
+	apexNSEC := newTestNSEC(
+		zone,
+		host,
+		dns.TypeNS,
+		dns.TypeSOA,
+		dns.TypeRRSIG,
+		dns.TypeNSEC,
+		dns.TypeDNSKEY,
+	)
+
+	nxdomain := []dns.RR{apexNSEC, child.sign(t, apexNSEC)}
+	nodata := []dns.RR{hostNSEC, child.sign(t, hostNSEC)}
+
+	denials := map[string]*testDNSSECDenial{
+		insecureZone: {
+			ns:    []dns.RR{insecureNSEC, root.sign(t, insecureNSEC)},
+			rcode: dns.RcodeSuccess,
+		},
+		noDSZone: {
+			rcode: dns.RcodeSuccess,
+		},
+		host: {
+			ns:    nodata,
+			rcode: dns.RcodeSuccess,
+		},
+		missing: {
+			ns:    nxdomain,
+			rcode: dns.RcodeNameError,
+		},
+	}
+
+	exchange := func(req *dns.Msg) (resp *dns.Msg, err error) {
+		q := req.Question[0]
+		resp = (&dns.Msg{}).SetReply(req)
+		resp.Answer = records[q.Qtype][q.Name]
+		if d := denials[q.Name]; resp.Answer == nil && d != nil {
+			resp.Ns, resp.Rcode = d.ns, d.rcode
+		}
+
+		return resp, nil
+	}
+
+	v, err := newDNSSECValidator(exchange, []string{root.key.ToDS(dns.SHA256).String()})
+	require.NoError(t, err)
+
+	newA := func(name string) (a *dns.A) {
+		return &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			A: net.IP{1, 2, 3, 4},
+		}
+	}
+
+	a := newA(host)
+	sig := child.sign(t, a)
+
+	forged := newA(host)
+	forged.A = net.IP{5, 6, 7, 8}
+
+	newResp := func(name string, qt uint16, rcode int, ans, ns []dns.RR) (resp *dns.Msg) {
+		resp = (&dns.Msg{}).SetQuestion(name, qt)
+		resp.Response, resp.Rcode = true, rcode
+		resp.Answer, resp.Ns = ans, ns
+
+		return resp
+	}
+
+	testCases := []struct {
+		resp       *dns.Msg
+		wantErr    error
+		name       string
+		wantSecure bool
+	}{{
+		resp:       newResp(host, dns.TypeA, dns.RcodeSuccess, []dns.RR{a, sig}, nil),
+		wantErr:    nil,
+		name:       "secure",
+		wantSecure: true,
+	}, {
+		resp:       newResp(host, dns.TypeA, dns.RcodeSuccess, []dns.RR{a}, nil),
+		wantErr:    errDNSSECNoSig,
+		name:       "unsigned",
+		wantSecure: false,
+	}, {
+		resp:       newResp(host, dns.TypeA, dns.RcodeSuccess, []dns.RR{forged, sig}, nil),
+		wantErr:    dns.ErrSig,
+		name:       "bogus",
+		wantSecure: false,
+	}, {
+		resp:       newResp(insecureHost, dns.TypeA, dns.RcodeSuccess, []dns.RR{newA(insecureHost)}, nil),
+		wantErr:    nil,
+		name:       "insecure",
+		wantSecure: false,
+	}, {
+		resp:       newResp(noDSHost, dns.TypeA, dns.RcodeSuccess, []dns.RR{newA(noDSHost)}, nil),
+		wantErr:    errDNSSECNoProof,
+		name:       "no_ds_proof",
+		wantSecure: false,
+	}, {
+		resp:       newResp(host, dns.TypeAAAA, dns.RcodeSuccess, nil, nodata),
+		wantErr:    nil,
+		name:       "nodata",
+		wantSecure: true,
+	}, {
+		resp:       newResp(host, dns.TypeA, dns.RcodeSuccess, nil, nodata),
+		wantErr:    errDNSSECNoProof,
+		name:       "nodata_type_exists",
+		wantSecure: false,
+	}, {
+		resp:       newResp(host, dns.TypeAAAA, dns.RcodeSuccess, nil, nil),
+		wantErr:    errDNSSECNoProof,
+		name:       "nodata_no_proof",
+		wantSecure: false,
+	}, {
+		resp:       newResp(missing, dns.TypeA, dns.RcodeNameError, nil, nxdomain),
+		wantErr:    nil,
+		name:       "nxdomain",
+		wantSecure: true,
+	}, {
+		resp:       newResp(missing, dns.TypeA, dns.RcodeNameError, nil, []dns.RR{apexNSEC}),
+		wantErr:    errDNSSECNoProof,
+		name:       "nxdomain_unsigned_proof",
+		wantSecure: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			secure, vErr := v.validate(tc.resp)
+			if tc.wantErr == nil {
+				require.NoError(t, vErr)
+			} else {
+				assert.ErrorIs(t, vErr, tc.wantErr)
+			}
+
+			assert.Equal(t, tc.wantSecure, secure)
+		})
+	}
+
+	t.Run("wrong_anchor", func(t *testing.T) {
+		other := newTestZoneSigner(t, ".")
+
+		var badV *dnssecValidator
+		badV, err = newDNSSECValidator(exchange, []string{other.key.ToDS(dns.SHA256).String()})
+		require.NoError(t, err)
+
+		resp := newResp(host, dns.TypeA, dns.RcodeSuccess, []dns.RR{a, sig}, nil)
+		_, vErr := badV.validate(resp)
+		assert.True(t, errors.Is(vErr, errDNSSECNoKey))
+	})
+}
+
+func TestDNSSECDenial_nsec3(t *testing.T) {
+	const zone = "example."
+
+	newNSEC3 := func(name, next string, flags uint8, types ...uint16) (n *dns.NSEC3) {
+		return &dns.NSEC3{
+			Hdr: dns.RR_Header{
+				Name:   dns.HashName(name, dns.SHA1, 0, "") + "." + zone,
+				Rrtype: dns.TypeNSEC3,
+				Class:  dns.ClassINET,
+				Ttl:    3600,
+			},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			NextDomain: dns.HashName(next, dns.SHA1, 0, ""),
+			TypeBitMap: types,
+		}
+	}
+
+	newDenial := func(optOut uint8) (d *dnssecDenial) {
+		return &dnssecDenial{
+			zone: zone,
+			nsec3: []*dns.NSEC3{
+				newNSEC3(zone, "www."+zone, optOut, dns.TypeNS, dns.TypeSOA),
+				newNSEC3("www."+zone, "sub."+zone, optOut, dns.TypeA),
+				newNSEC3("sub."+zone, zone, optOut, dns.TypeNS),
+			},
+		}
+	}
+
+	d := newDenial(0)
+
+	secure, err := d.proveNXDomain("nope." + zone)
+	require.NoError(t, err)
+
+	assert.True(t, secure)
+
+	secure, err = d.proveNoData("www."+zone, dns.TypeAAAA)
+	require.NoError(t, err)
+
+	assert.True(t, secure)
+
+	_, err = d.proveNoData("www."+zone, dns.TypeA)
+	assert.ErrorIs(t, err, errDNSSECNoProof)
+
+	insecure, err := d.proveNoDS("sub." + zone)
+	require.NoError(t, err)
+
+	assert.True(t, insecure)
+
+	insecure, err = d.proveNoDS("www." + zone)
+	require.NoError(t, err)
+
+	assert.False(t, insecure)
+
+	secure, err = newDenial(nsec3FlagOptOut).proveNXDomain("nope." + zone)
+	require.NoError(t, err)
+
+	assert.False(t, secure)
+}
@@ -0,0 +1,316 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// maxNSEC3Iterations is the maximum number of the additional iterations of the
+// NSEC3 hash.  The proofs using more iterations are considered insecure, see
+// RFC 9276.
+const maxNSEC3Iterations = 150
+
+// nsec3FlagOptOut is the NSEC3 flag meaning that the covered names may contain
+// unsigned delegations, see RFC 5155.
+const nsec3FlagOptOut = 1
+
+// dnssecDenial are the validated NSEC and NSEC3 records of a zone used to
+// prove the nonexistence of the data.
+type dnssecDenial struct {
+	// zone is the name of the zone, which has signed the records.
+	zone string
+
+	// nsec are the validated NSEC records.
+	nsec []*dns.NSEC
+
+	// nsec3 are the validated NSEC3 records.
+	nsec3 []*dns.NSEC3
+
+	// insecure is true if the NSEC3 records use the hash algorithm or the
+	// number of iterations, which aren't supported.
+	insecure bool
+}
+
+// denialRecords validates the NSEC and NSEC3 records of zone from rrs using
+// keys.  The records not signed by zone are ignored.
+func (v *dnssecValidator) denialRecords(
+	rrs []dns.RR,
+	zone string,
+	keys []*dns.DNSKEY,
+) (d *dnssecDenial, err error) {
+	d = &dnssecDenial{
+		zone: zone,
+	}
+
+	sets, sigs := splitRRSets(rrs)
+	for _, set := range sets {
+		hdr := set[0].Header()
+		owner := dns.CanonicalName(hdr.Name)
+		switch hdr.Rrtype {
+		case dns.TypeNSEC:
+			if !dns.IsSubDomain(zone, owner) {
+				continue
+			}
+		case dns.TypeNSEC3:
+			if owner == "." || parentName(owner) != zone {
+				continue
+			}
+		default:
+			continue
+		}
+
+		err = v.verifyInZone(set, sigs, zone, keys)
+		if errors.Is(err, errDNSSECNoSig) {
+			// The records may belong to another zone.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", hdr.Name, dns.Type(hdr.Rrtype), err)
+		}
+
+		d.add(set)
+	}
+
+	return d, nil
+}
+
+// add adds the records from the validated set to d.
+func (d *dnssecDenial) add(set []dns.RR) {
+	for _, rr := range set {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			d.nsec = append(d.nsec, rr)
+		case *dns.NSEC3:
+			d.nsec3 = append(d.nsec3, rr)
+			d.insecure = d.insecure || rr.Hash != dns.SHA1 || rr.Iterations > maxNSEC3Iterations
+		}
+	}
+}
+
+// proveNoDS checks the proof of nonexistence of the DS records for child.
+// insecure is true if child is a delegation to an unsigned zone or may be one
+// due to the NSEC3 opt-out.  Otherwise, child isn't a zone cut.
+func (d *dnssecDenial) proveNoDS(child string) (insecure bool, err error) {
+	if d.insecure {
+		return true, nil
+	}
+
+	for _, n := range d.nsec {
+		if dns.CanonicalName(n.Hdr.Name) == child {
+			return isUnsignedDelegation(n.TypeBitMap)
+		} else if d.nsecCovers(n, child) {
+			return false, nil
+		}
+	}
+
+	for _, n := range d.nsec3 {
+		if n.Match(child) {
+			return isUnsignedDelegation(n.TypeBitMap)
+		}
+	}
+
+	for _, n := range d.nsec3 {
+		if n.Cover(child) {
+			return n.Flags&nsec3FlagOptOut != 0, nil
+		}
+	}
+
+	return false, errDNSSECNoProof
+}
+
+// isUnsignedDelegation returns true if the NSEC or NSEC3 record with types at
+// a name without DS records proves an unsigned delegation.
+func isUnsignedDelegation(types []uint16) (ok bool, err error) {
+	if slices.Contains(types, dns.TypeDS) {
+		return false, fmt.Errorf("ds: %w", errDNSSECNoProof)
+	}
+
+	return slices.Contains(types, dns.TypeNS) && !slices.Contains(types, dns.TypeSOA), nil
+}
+
+// proveNoData checks the proof of nonexistence of the records of type qt for
+// the existing name.  secure is false if the proof relies on the NSEC3
+// opt-out or the unsupported NSEC3 parameters.
+func (d *dnssecDenial) proveNoData(name string, qt uint16) (secure bool, err error) {
+	if d.insecure {
+		return false, nil
+	}
+
+	for _, n := range d.nsec {
+		if dns.CanonicalName(n.Hdr.Name) == name {
+			if lacksType(n.TypeBitMap, qt) {
+				return true, nil
+			}
+
+			continue
+		} else if !d.nsecCovers(n, name) {
+			continue
+		}
+
+		next := dns.CanonicalName(n.NextDomain)
+		if next != name && dns.IsSubDomain(name, next) {
+			// The name is an empty non-terminal.
+			return true, nil
+		}
+
+		wildcard := wildcardName(d.nsecClosestEncloser(n, name))
+		if d.nsecMatches(wildcard, qt) {
+			return true, nil
+		}
+	}
+
+	for _, n := range d.nsec3 {
+		if n.Match(name) && lacksType(n.TypeBitMap, qt) {
+			return true, nil
+		}
+	}
+
+	ce, cover, ok := d.nsec3ClosestEncloser(name)
+	if !ok {
+		return false, errDNSSECNoProof
+	} else if qt == dns.TypeDS && cover.Flags&nsec3FlagOptOut != 0 {
+		return false, nil
+	}
+
+	wildcard := wildcardName(ce)
+	for _, n := range d.nsec3 {
+		if n.Match(wildcard) && lacksType(n.TypeBitMap, qt) {
+			return true, nil
+		}
+	}
+
+	return false, errDNSSECNoProof
+}
+
+// proveNXDomain checks the proof of nonexistence of name.  secure is false if
+// the proof relies on the NSEC3 opt-out or the unsupported NSEC3 parameters.
+func (d *dnssecDenial) proveNXDomain(name string) (secure bool, err error) {
+	if d.insecure {
+		return false, nil
+	}
+
+	for _, n := range d.nsec {
+		if !d.nsecCovers(n, name) {
+			continue
+		}
+
+		wildcard := wildcardName(d.nsecClosestEncloser(n, name))
+		for _, w := range d.nsec {
+			if d.nsecCovers(w, wildcard) {
+				return true, nil
+			}
+		}
+	}
+
+	ce, cover, ok := d.nsec3ClosestEncloser(name)
+	if !ok {
+		return false, errDNSSECNoProof
+	} else if cover.Flags&nsec3FlagOptOut != 0 {
+		return false, nil
+	}
+
+	wildcard := wildcardName(ce)
+	for _, n := range d.nsec3 {
+		if n.Cover(wildcard) {
+			return true, nil
+		}
+	}
+
+	return false, errDNSSECNoProof
+}
+
+// nsecMatches returns true if there is an NSEC record for name without the
+// records of type qt.
+func (d *dnssecDenial) nsecMatches(name string, qt uint16) (ok bool) {
+	for _, n := range d.nsec {
+		if dns.CanonicalName(n.Hdr.Name) == name && lacksType(n.TypeBitMap, qt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nsecCovers returns true if name is between the owner name and the next name
+// of n within d.zone in the canonical order.
+func (d *dnssecDenial) nsecCovers(n *dns.NSEC, name string) (ok bool) {
+	if !dns.IsSubDomain(d.zone, name) {
+		return false
+	}
+
+	owner, next := n.Hdr.Name, n.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+
+	// The last NSEC record of the zone points to its apex.
+	return canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0
+}
+
+// nsecClosestEncloser returns the closest existing ancestor of name, which is
+// covered by n.
+func (d *dnssecDenial) nsecClosestEncloser(n *dns.NSEC, name string) (ce string) {
+	owner, next := dns.CanonicalName(n.Hdr.Name), dns.CanonicalName(n.NextDomain)
+	for ce = name; ce != d.zone && ce != "."; {
+		ce = parentName(ce)
+		if dns.IsSubDomain(ce, owner) || dns.IsSubDomain(ce, next) {
+			break
+		}
+	}
+
+	return ce
+}
+
+// nsec3ClosestEncloser returns the closest existing ancestor of name proven by
+// a matching NSEC3 record and the NSEC3 record covering the next closer name.
+// ok is false if there is no such proof.
+func (d *dnssecDenial) nsec3ClosestEncloser(name string) (ce string, cover *dns.NSEC3, ok bool) {
+	for nc := name; nc != d.zone && nc != "."; nc = ce {
+		ce = parentName(nc)
+		if !slices.ContainsFunc(d.nsec3, func(n *dns.NSEC3) (m bool) { return n.Match(ce) }) {
+			continue
+		}
+
+		for _, n := range d.nsec3 {
+			if n.Cover(nc) {
+				return ce, n, true
+			}
+		}
+
+		return "", nil, false
+	}
+
+	return "", nil, false
+}
+
+// lacksType returns true if types contain neither qt nor CNAME.
+func lacksType(types []uint16, qt uint16) (ok bool) {
+	return !slices.Contains(types, qt) && !slices.Contains(types, dns.TypeCNAME)
+}
+
+// wildcardName returns the wildcard name with the closest encloser ce.
+func wildcardName(ce string) (name string) {
+	if ce == "." {
+		return "*."
+	}
+
+	return "*." + ce
+}
+
+// canonicalCompare compares the domain names a and b in the canonical DNS
+// order, see RFC 4034.  The result is negative if a goes before b, zero if they
+// are equal, and positive otherwise.
+func canonicalCompare(a, b string) (res int) {
+	la, lb := dns.SplitDomainName(a), dns.SplitDomainName(b)
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		res = strings.Compare(strings.ToLower(la[len(la)-i]), strings.ToLower(lb[len(lb)-i]))
+		if res != 0 {
+			return res
+		}
+	}
+
+	return len(la) - len(lb)
+}
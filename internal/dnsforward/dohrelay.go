@@ -0,0 +1,218 @@
+package dnsforward
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// dohRelayPath is the prefix of the paths of the DoH relays.  The full path is
+// dohRelayPath followed by the token of the relay.
+const dohRelayPath = "/dns-relay/"
+
+// dohRelayMinTokenLen is the minimum length of the token of a DoH relay.
+const dohRelayMinTokenLen = 16
+
+// dohContentType is the media type of the DNS messages in DoH, see RFC 8484,
+// Section 6.
+const dohContentType = "application/dns-message"
+
+// DoHRelayConfig is the configuration of a DoH relay, which forwards the DoH
+// requests of other resolvers to an upstream server without filtering them or
+// recording them in the query log and the statistics.
+type DoHRelayConfig struct {
+	// Token is the secret authenticating the requests to the relay.  The relay
+	// is served at the path "/dns-relay/" followed by the token.
+	Token string `yaml:"token"`
+
+	// Upstream is the address of the upstream server, to which the requests
+	// are forwarded.
+	Upstream string `yaml:"upstream"`
+}
+
+// dohRelay is a DoH relay prepared for use.
+type dohRelay struct {
+	// ups is the upstream server, to which the requests are forwarded.
+	ups upstream.Upstream
+
+	// token is the secret authenticating the requests.
+	token []byte
+}
+
+// newDoHRelays validates confs and returns the relays for them.
+func newDoHRelays(confs []*DoHRelayConfig, opts *upstream.Options) (relays []*dohRelay, err error) {
+	tokens := map[string]struct{}{}
+	for i, c := range confs {
+		var r *dohRelay
+		r, err = newDoHRelay(c, tokens, opts)
+		if err != nil {
+			return nil, errors.WithDeferred(
+				fmt.Errorf("doh relay at index %d: %w", i, err),
+				closeDoHRelays(relays),
+			)
+		}
+
+		relays = append(relays, r)
+	}
+
+	return relays, nil
+}
+
+// newDoHRelay validates conf and returns the relay for it.  tokens are the
+// tokens of the previously created relays.
+func newDoHRelay(
+	conf *DoHRelayConfig,
+	tokens map[string]struct{},
+	opts *upstream.Options,
+) (r *dohRelay, err error) {
+	switch {
+	case conf == nil:
+		return nil, errors.Error("no value")
+	case len(conf.Token) < dohRelayMinTokenLen:
+		return nil, fmt.Errorf("token must be at least %d characters long", dohRelayMinTokenLen)
+	case strings.Contains(conf.Token, "/"):
+		return nil, errors.Error("token must not contain slashes")
+	case conf.Upstream == "":
+		return nil, errors.Error("no upstream")
+	}
+
+	if _, ok := tokens[conf.Token]; ok {
+		return nil, errors.Error("duplicate token")
+	}
+
+	tokens[conf.Token] = struct{}{}
+
+	ups, err := addressToUpstream(conf.Upstream, opts)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", conf.Upstream, err)
+	}
+
+	return &dohRelay{
+		ups:   ups,
+		token: []byte(conf.Token),
+	}, nil
+}
+
+// closeDoHRelays closes the upstream servers of relays.
+func closeDoHRelays(relays []*dohRelay) (err error) {
+	var errs []error
+	for _, r := range relays {
+		errs = append(errs, r.ups.Close())
+	}
+
+	return errors.Annotate(errors.Join(errs...), "closing doh relays: %w")
+}
+
+// dohRelayByToken returns the relay with token.  r is nil if there is no such
+// relay.  All the tokens are compared in constant time to not leak them.
+func (s *Server) dohRelayByToken(token string) (r *dohRelay) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	for _, relay := range s.dohRelays {
+		if subtle.ConstantTimeCompare(relay.token, []byte(token)) == 1 {
+			r = relay
+		}
+	}
+
+	return r
+}
+
+// handleDoHRelay is the handler for the DoH relay paths.  It forwards the
+// request to the upstream server of the relay as is.
+func (s *Server) handleDoHRelay(w http.ResponseWriter, r *http.Request) {
+	if !s.conf.TLSAllowUnencryptedDoH && r.TLS == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "Not Found")
+
+		return
+	}
+
+	relay := s.dohRelayByToken(strings.TrimPrefix(r.URL.Path, dohRelayPath))
+	if relay == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "Not Found")
+
+		return
+	}
+
+	packed, status, err := readDoHRequest(r)
+	if err != nil {
+		aghhttp.Error(r, w, status, "reading request: %s", err)
+
+		return
+	}
+
+	req := &dns.Msg{}
+	err = req.Unpack(packed)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "unpacking request: %s", err)
+
+		return
+	} else if len(req.Question) != 1 {
+		aghhttp.Error(r, w, http.StatusBadRequest, "request must have exactly one question")
+
+		return
+	}
+
+	log.Debug("dnsforward: doh relay: forwarding to %s", relay.ups.Address())
+
+	resp, err := relay.ups.Exchange(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadGateway, "exchanging: %s", err)
+
+		return
+	}
+
+	resp.Id = req.Id
+	packed, err = resp.Pack()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "packing response: %s", err)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, dohContentType)
+	_, err = w.Write(packed)
+	if err != nil {
+		log.Debug("dnsforward: doh relay: writing response: %s", err)
+	}
+}
+
+// readDoHRequest returns the packed DNS message from the DoH request r, see RFC
+// 8484, Section 4.1.  status is the HTTP status code to respond with if err is
+// not nil.
+func readDoHRequest(r *http.Request) (packed []byte, status int, err error) {
+	switch r.Method {
+	case http.MethodGet:
+		packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("decoding dns parameter: %w", err)
+		}
+	case http.MethodPost:
+		if ct := r.Header.Get(httphdr.ContentType); ct != dohContentType {
+			return nil, http.StatusUnsupportedMediaType, fmt.Errorf("bad content type %q", ct)
+		}
+
+		packed, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("reading body: %w", err)
+		}
+	default:
+		return nil, http.StatusMethodNotAllowed, fmt.Errorf("bad method %q", r.Method)
+	}
+
+	if len(packed) == 0 {
+		return nil, http.StatusBadRequest, errors.Error("empty message")
+	}
+
+	return packed, http.StatusOK, nil
+}
@@ -0,0 +1,146 @@
+package dnsforward
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDoHRelays(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*DoHRelayConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs:      []*DoHRelayConfig{{Token: token, Upstream: "1.1.1.1"}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "doh relay at index 0: no value",
+		confs:      []*DoHRelayConfig{nil},
+	}, {
+		name:       "short_token",
+		wantErrMsg: "doh relay at index 0: token must be at least 16 characters long",
+		confs:      []*DoHRelayConfig{{Token: "short", Upstream: "1.1.1.1"}},
+	}, {
+		name:       "no_upstream",
+		wantErrMsg: "doh relay at index 0: no upstream",
+		confs:      []*DoHRelayConfig{{Token: token}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: "doh relay at index 1: duplicate token",
+		confs: []*DoHRelayConfig{
+			{Token: token, Upstream: "1.1.1.1"},
+			{Token: token, Upstream: "8.8.8.8"},
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			relays, err := newDoHRelays(tc.confs, &upstream.Options{})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			require.NoError(t, closeDoHRelays(relays))
+		})
+	}
+}
+
+func TestServer_handleDoHRelay(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	ups := &aghtest.UpstreamMock{
+		OnAddress: func() (addr string) { return "upstream.example" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			return newResp(dns.RcodeSuccess, req, []dns.RR{
+				newRR(t, req.Question[0].Name, dns.TypeA, 60, net.IP{192, 0, 2, 1}),
+			}), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	s := &Server{
+		dohRelays: []*dohRelay{{
+			ups:   ups,
+			token: []byte(token),
+		}},
+		conf: ServerConfig{
+			TLSAllowUnencryptedDoH: true,
+		},
+	}
+
+	req := createTestMessage(aghtest.ReqFQDN)
+	packed, err := req.Pack()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		newReq     func() (r *http.Request)
+		name       string
+		wantStatus int
+	}{{
+		newReq: func() (r *http.Request) {
+			r = httptest.NewRequest(http.MethodPost, dohRelayPath+token, bytes.NewReader(packed))
+			r.Header.Set(httphdr.ContentType, dohContentType)
+
+			return r
+		},
+		name:       "post",
+		wantStatus: http.StatusOK,
+	}, {
+		newReq: func() (r *http.Request) {
+			q := base64.RawURLEncoding.EncodeToString(packed)
+
+			return httptest.NewRequest(http.MethodGet, dohRelayPath+token+"?dns="+q, nil)
+		},
+		name:       "get",
+		wantStatus: http.StatusOK,
+	}, {
+		newReq: func() (r *http.Request) {
+			r = httptest.NewRequest(http.MethodPost, dohRelayPath+"bad", bytes.NewReader(packed))
+			r.Header.Set(httphdr.ContentType, dohContentType)
+
+			return r
+		},
+		name:       "bad_token",
+		wantStatus: http.StatusNotFound,
+	}, {
+		newReq: func() (r *http.Request) {
+			return httptest.NewRequest(http.MethodPost, dohRelayPath+token, bytes.NewReader(packed))
+		},
+		name:       "bad_content_type",
+		wantStatus: http.StatusUnsupportedMediaType,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handleDoHRelay(w, tc.newReq())
+
+			require.Equal(t, tc.wantStatus, w.Code)
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, dohContentType, w.Header().Get(httphdr.ContentType))
+
+			resp := &dns.Msg{}
+			require.NoError(t, resp.Unpack(w.Body.Bytes()))
+
+			assert.Equal(t, req.Id, resp.Id)
+			assert.Len(t, resp.Answer, 1)
+		})
+	}
+}
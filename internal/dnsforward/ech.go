@@ -0,0 +1,95 @@
+package dnsforward
+
+import (
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// defaultPortHTTPS is the default port of the HTTPS servers, which is omitted
+// from the HTTPS resource records.
+const defaultPortHTTPS = 443
+
+// processECHQuery responds to the HTTPS requests for the server name with the
+// records advertising the Encrypted Client Hello configurations of the HTTPS
+// server, so that the clients can hide the SNI of the DNS-over-HTTPS hostname.
+func (s *Server) processECHQuery(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing ech")
+	defer log.Debug("dnsforward: finished processing ech")
+
+	if s.echConfigList == nil || s.conf.ServerName == "" {
+		return resultCodeSuccess
+	}
+
+	pctx := dctx.proxyCtx
+	q := pctx.Req.Question[0]
+	if !strings.EqualFold(q.Name, dns.Fqdn(s.conf.ServerName)) {
+		return resultCodeSuccess
+	}
+
+	if q.Qtype != dns.TypeHTTPS {
+		return resultCodeSuccess
+	}
+
+	pctx.Res = s.makeECHResponse(pctx.Req)
+
+	return resultCodeFinish
+}
+
+// makeECHResponse returns the response with the HTTPS resource records for each
+// port of the HTTPS server.  The HTTP/3 ports, which differ from the HTTPS ones,
+// are advertised separately, like in the DDR responses.
+func (s *Server) makeECHResponse(req *dns.Msg) (resp *dns.Msg) {
+	resp = s.makeResponse(req)
+
+	h3Ports := map[int]bool{}
+	for _, addr := range s.conf.HTTP3ListenAddrs {
+		h3Ports[addr.Port] = true
+	}
+
+	httpsPorts := map[int]bool{}
+	for _, addr := range s.conf.HTTPSListenAddrs {
+		if httpsPorts[addr.Port] {
+			continue
+		}
+
+		httpsPorts[addr.Port] = true
+
+		alpn := []string{"h2"}
+		if h3Ports[addr.Port] {
+			alpn = append(alpn, "h3")
+		}
+
+		resp.Answer = append(resp.Answer, s.newECHHTTPS(req, alpn, addr.Port))
+	}
+
+	for _, addr := range s.conf.HTTP3ListenAddrs {
+		if !httpsPorts[addr.Port] {
+			httpsPorts[addr.Port] = true
+			resp.Answer = append(resp.Answer, s.newECHHTTPS(req, []string{"h3"}, addr.Port))
+		}
+	}
+
+	return resp
+}
+
+// newECHHTTPS returns a new HTTPS resource record with the ECH configurations
+// of the server for the ALPN identifiers alpn on port.
+func (s *Server) newECHHTTPS(req *dns.Msg, alpn []string, port int) (rr *dns.HTTPS) {
+	values := []dns.SVCBKeyValue{&dns.SVCBAlpn{Alpn: alpn}}
+	if port != defaultPortHTTPS {
+		values = append(values, &dns.SVCBPort{Port: uint16(port)})
+	}
+
+	values = append(values, &dns.SVCBECHConfig{ECH: s.echConfigList})
+
+	return &dns.HTTPS{
+		SVCB: dns.SVCB{
+			Hdr:      s.hdr(req, dns.TypeHTTPS),
+			Priority: 1,
+			Target:   ".",
+			Value:    values,
+		},
+	}
+}
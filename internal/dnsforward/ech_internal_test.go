@@ -0,0 +1,126 @@
+package dnsforward
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_processECHQuery(t *testing.T) {
+	echConfigList := []byte{0x00, 0x01, 0x02}
+
+	newHTTPS := func(alpn []string, port uint16) (rr *dns.HTTPS) {
+		values := []dns.SVCBKeyValue{&dns.SVCBAlpn{Alpn: alpn}}
+		if port != defaultPortHTTPS {
+			values = append(values, &dns.SVCBPort{Port: port})
+		}
+
+		return &dns.HTTPS{
+			SVCB: dns.SVCB{
+				Priority: 1,
+				Target:   ".",
+				Value:    append(values, &dns.SVCBECHConfig{ECH: echConfigList}),
+			},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		host     string
+		want     []*dns.HTTPS
+		wantRes  resultCode
+		qtype    uint16
+		noECH    bool
+		portDoH  int
+		portDoH3 int
+	}{{
+		name:    "default_port",
+		host:    ddrTestFQDN,
+		want:    []*dns.HTTPS{newHTTPS([]string{"h2"}, defaultPortHTTPS)},
+		wantRes: resultCodeFinish,
+		qtype:   dns.TypeHTTPS,
+		portDoH: defaultPortHTTPS,
+	}, {
+		name:     "h3_same_port",
+		host:     ddrTestFQDN,
+		want:     []*dns.HTTPS{newHTTPS([]string{"h2", "h3"}, 8443)},
+		wantRes:  resultCodeFinish,
+		qtype:    dns.TypeHTTPS,
+		portDoH:  8443,
+		portDoH3: 8443,
+	}, {
+		name: "h3_other_port",
+		host: ddrTestFQDN,
+		want: []*dns.HTTPS{
+			newHTTPS([]string{"h2"}, 8443),
+			newHTTPS([]string{"h3"}, 8444),
+		},
+		wantRes:  resultCodeFinish,
+		qtype:    dns.TypeHTTPS,
+		portDoH:  8443,
+		portDoH3: 8444,
+	}, {
+		name:    "case_insensitive",
+		host:    "DNS.Example.NET.",
+		want:    []*dns.HTTPS{newHTTPS([]string{"h2"}, defaultPortHTTPS)},
+		wantRes: resultCodeFinish,
+		qtype:   dns.TypeHTTPS,
+		portDoH: defaultPortHTTPS,
+	}, {
+		name:    "other_type",
+		host:    ddrTestFQDN,
+		wantRes: resultCodeSuccess,
+		qtype:   dns.TypeA,
+		portDoH: defaultPortHTTPS,
+	}, {
+		name:    "other_host",
+		host:    "other.example.net.",
+		wantRes: resultCodeSuccess,
+		qtype:   dns.TypeHTTPS,
+		portDoH: defaultPortHTTPS,
+	}, {
+		name:    "no_ech",
+		host:    ddrTestFQDN,
+		wantRes: resultCodeSuccess,
+		qtype:   dns.TypeHTTPS,
+		noECH:   true,
+		portDoH: defaultPortHTTPS,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := prepareTestServer(t, tc.portDoH, tc.portDoH3, 0, 0, false)
+			if !tc.noECH {
+				s.echConfigList = echConfigList
+			}
+
+			req := createTestMessageWithType(tc.host, tc.qtype)
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+				},
+			}
+
+			res := s.processECHQuery(dctx)
+			require.Equal(t, tc.wantRes, res)
+
+			if tc.wantRes != resultCodeFinish {
+				return
+			}
+
+			msg := dctx.proxyCtx.Res
+			require.NotNil(t, msg)
+
+			want := make([]dns.RR, 0, len(tc.want))
+			for _, rr := range tc.want {
+				rr.Hdr = s.hdr(req, dns.TypeHTTPS)
+				want = append(want, rr)
+			}
+
+			assert.Equal(t, want, msg.Answer)
+		})
+	}
+}
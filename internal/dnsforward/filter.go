@@ -4,13 +4,16 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"golang.org/x/exp/slices"
@@ -23,28 +26,62 @@ func (s *Server) beforeRequestHandler(
 	_ *proxy.Proxy,
 	pctx *proxy.DNSContext,
 ) (reply bool, err error) {
+	s.requestLock.RLock()
+	defer s.requestLock.RUnlock()
+
+	if ups := s.forwardingEndpoint(pctx); ups != nil {
+		// Check the access settings, but don't look for the ClientID, since
+		// the server name of the endpoint isn't one.
+		addr := netutil.NetAddrToAddrPort(pctx.Addr).Addr()
+		blocked, rule := s.IsBlockedClient(addr, "")
+		s.access.countClientHit(rule)
+		if blocked || s.isBlockedByListener(s.listenerConfig(pctx), pctx, addr, "") {
+			return s.preBlockedResponse(pctx)
+		}
+
+		s.forwardRequest(pctx, ups)
+
+		return true, nil
+	}
+
 	clientID, err := s.clientIDFromDNSContext(pctx)
 	if err != nil {
 		return false, fmt.Errorf("getting clientid: %w", err)
 	}
 
 	addrPort := netutil.NetAddrToAddrPort(pctx.Addr)
-	blocked, _ := s.IsBlockedClient(addrPort.Addr(), clientID)
+	blocked, rule := s.IsBlockedClient(addrPort.Addr(), clientID)
+	s.access.countClientHit(rule)
 	if blocked {
 		return s.preBlockedResponse(pctx)
 	}
 
+	lc := s.listenerConfig(pctx)
+	if s.isBlockedByListener(lc, pctx, addrPort.Addr(), clientID) {
+		return s.preBlockedResponse(pctx)
+	}
+
 	if len(pctx.Req.Question) == 1 {
 		q := pctx.Req.Question[0]
 		qt := q.Qtype
 		host := aghnet.NormalizeDomain(q.Name)
-		if s.access.isBlockedHost(host, qt) {
+		if blockedHost, hostRule := s.access.isBlockedHost(host, qt); blockedHost {
 			log.Debug("access: request %s %s is in access blocklist", dns.Type(qt), host)
+			s.access.countHostHit(hostRule)
 
 			return s.preBlockedResponse(pctx)
 		}
 	}
 
+	switch s.checkClientLimits(addrPort.Addr(), clientID, lc) {
+	case limitDrop:
+		return false, nil
+	case limitRefuse:
+		pctx.Res = s.makeResponseREFUSED(pctx.Req)
+
+		return true, nil
+	}
+
 	if clientID != "" {
 		key := [8]byte{}
 		binary.BigEndian.PutUint64(key[:], pctx.RequestID)
@@ -54,6 +91,41 @@ func (s *Server) beforeRequestHandler(
 	return true, nil
 }
 
+// checkClientLimits returns the decision about the request from the client
+// with ip and clientID according to its rate limit and the flood protection.
+// lc is the configuration of the listener, which has received the request, and
+// may be nil.
+func (s *Server) checkClientLimits(
+	ip netip.Addr,
+	clientID string,
+	lc *ListenerConfig,
+) (d limitDecision) {
+	if s.limiter == nil {
+		return limitAllow
+	}
+
+	id := stringutil.Coalesce(clientID, ip.String())
+
+	var lim *ClientRatelimit
+	if s.conf.GetClientRatelimit != nil {
+		lim = s.conf.GetClientRatelimit(id)
+	}
+
+	if (lim == nil || lim.RPS == 0) && lc != nil && lc.Ratelimit > 0 {
+		lim = &ClientRatelimit{
+			Name: id,
+			RPS:  lc.Ratelimit,
+		}
+	}
+
+	d = s.limiter.check(id, lim, time.Now())
+	if d != limitAllow {
+		log.Debug("dnsforward: request from %s is over the limit", id)
+	}
+
+	return d
+}
+
 // clientRequestFilteringSettings looks up client filtering settings using the
 // client's IP address and ID, if any, from dctx.
 func (s *Server) clientRequestFilteringSettings(dctx *dnsContext) (setts *filtering.Settings) {
@@ -62,6 +134,10 @@ func (s *Server) clientRequestFilteringSettings(dctx *dnsContext) (setts *filter
 	if s.conf.FilterHandler != nil {
 		addrPort := netutil.NetAddrToAddrPort(dctx.proxyCtx.Addr)
 		s.conf.FilterHandler(addrPort.Addr(), dctx.clientID, setts)
+
+		// The handler may disable the protection for the client, for example
+		// when it's paused.
+		dctx.protectionEnabled = setts.ProtectionEnabled
 	}
 
 	return setts
@@ -83,22 +159,21 @@ func (s *Server) filterDNSRequest(dctx *dnsContext) (res *filtering.Result, err
 	res = &resVal
 	switch {
 	case res.IsFiltered:
-		log.Debug(
-			"dnsforward: host %q is filtered, reason: %q; rule: %q",
-			host,
-			res.Reason,
-			res.Rules[0].Text,
-		)
+		log.Debug("dnsforward: host %q is filtered, reason: %q", host, res.Reason)
 		pctx.Res = s.genDNSFilterMessage(pctx, res)
 	case res.Reason.In(filtering.Rewritten, filtering.RewrittenRule) &&
 		res.CanonName != "" &&
-		len(res.IPList) == 0:
+		len(res.IPList) == 0 &&
+		res.DNSRewriteResult == nil:
 		// Resolve the new canonical name, not the original host name.  The
 		// original question is readded in processFilteringAfterResponse.
 		dctx.origQuestion = q
 		req.Question[0].Name = dns.Fqdn(res.CanonName)
 	case res.Reason == filtering.Rewritten:
-		pctx.Res = s.filterRewritten(req, host, res, q.Qtype)
+		pctx.Res, err = s.filterRewritten(req, host, res, q.Qtype)
+		if err != nil {
+			return nil, err
+		}
 	case res.Reason.In(filtering.RewrittenRule, filtering.RewrittenAutoHosts):
 		if err = s.filterDNSRewrite(req, res, pctx); err != nil {
 			return nil, err
@@ -115,7 +190,7 @@ func (s *Server) filterRewritten(
 	host string,
 	res *filtering.Result,
 	qt uint16,
-) (resp *dns.Msg) {
+) (resp *dns.Msg, err error) {
 	resp = s.makeResponse(req)
 	name := host
 	if len(res.CanonName) != 0 {
@@ -136,7 +211,24 @@ func (s *Server) filterRewritten(
 		}
 	}
 
-	return resp
+	if res.DNSRewriteResult == nil {
+		return resp, nil
+	}
+
+	for i, v := range res.DNSRewriteResult.Response[qt] {
+		var ans dns.RR
+		ans, err = s.filterDNSRewriteResponse(req, qt, v)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite response for %s[%d]: %w", dns.Type(qt), i, err)
+		} else if ans == nil {
+			continue
+		}
+
+		ans.Header().Name = dns.Fqdn(name)
+		resp.Answer = append(resp.Answer, ans)
+	}
+
+	return resp, nil
 }
 
 // checkHostRules checks the host against filters.  It is safe for concurrent
@@ -169,15 +261,38 @@ func (s *Server) filterDNSResponse(dctx *dnsContext) (err error) {
 
 	var res *filtering.Result
 	pctx := dctx.proxyCtx
+
+	chainFiltering := s.dnsFilter.CNAMEChainFiltering()
+	if chainFiltering {
+		res, err = s.filterCNAMEChain(pctx, setts)
+		if err != nil {
+			return fmt.Errorf("filtering cname chain: %w", err)
+		} else if res != nil {
+			dctx.result = res
+			dctx.origResp = pctx.Res
+			pctx.Res = s.genDNSFilterMessage(pctx, res)
+
+			return nil
+		}
+	}
+
 	for i, a := range pctx.Res.Answer {
 		host := ""
 		var rrtype rules.RRType
 		switch a := a.(type) {
 		case *dns.CNAME:
+			if chainFiltering {
+				// Already checked by filterCNAMEChain.
+				continue
+			}
+
 			host = strings.TrimSuffix(a.Target, ".")
 			rrtype = dns.TypeCNAME
 
 			res, err = s.checkHostRules(host, rrtype, setts)
+			if res != nil && res.IsFiltered {
+				res.CNAMEMatch = host
+			}
 		case *dns.A:
 			host = a.A.String()
 			rrtype = dns.TypeA
@@ -212,6 +327,72 @@ func (s *Server) filterDNSResponse(dctx *dnsContext) (err error) {
 	return nil
 }
 
+// filterCNAMEChain checks every name in the CNAME chain of the answer section
+// of pctx.Res, except for the question name, which has already been checked,
+// against all the host checkers.  res is nil if none of the names is filtered.
+func (s *Server) filterCNAMEChain(
+	pctx *proxy.DNSContext,
+	setts *filtering.Settings,
+) (res *filtering.Result, err error) {
+	if setts.AllowlistOnly || setts.InternetPaused {
+		// The names from the CNAME chains of the allowed hosts are considered
+		// allowed, since the clients can't know them in advance.
+		chainSetts := *setts
+		chainSetts.AllowlistOnly = false
+		chainSetts.InternetPaused = false
+		setts = &chainSetts
+	}
+
+	q := pctx.Req.Question[0]
+	checked := stringutil.NewSet(aghnet.NormalizeDomain(q.Name))
+	for _, rr := range pctx.Res.Answer {
+		cname, ok := rr.(*dns.CNAME)
+		if !ok {
+			continue
+		}
+
+		for _, name := range []string{cname.Hdr.Name, cname.Target} {
+			host := aghnet.NormalizeDomain(name)
+			if checked.Has(host) {
+				continue
+			}
+
+			checked.Add(host)
+
+			res, err = s.checkHost(host, q.Qtype, setts)
+			if err != nil {
+				return nil, fmt.Errorf("checking %q: %w", host, err)
+			} else if res.IsFiltered {
+				log.Debug("dnsforward: cname chain of %q matched by %q", q.Name, host)
+
+				res.CNAMEMatch = host
+
+				return res, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// checkHost checks the host against all the host checkers.  It is safe for
+// concurrent use.
+func (s *Server) checkHost(
+	host string,
+	qtype uint16,
+	setts *filtering.Settings,
+) (r *filtering.Result, err error) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	res, err := s.dnsFilter.CheckHost(host, qtype, setts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
 // removeIPv6Hints deletes IPv6 hints from RR values.
 func removeIPv6Hints(rr *dns.HTTPS) {
 	rr.Value = slices.DeleteFunc(rr.Value, func(kv dns.SVCBKeyValue) (del bool) {
@@ -49,9 +49,9 @@ func TestHandleDNSRequest_handleDNSRequest(t *testing.T) {
 
 	s, err := NewServer(DNSCreateParams{
 		DHCPServer: &testDHCP{
-			OnEnabled:  func() (ok bool) { return false },
-			OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
-			OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+			OnEnabled:   func() (ok bool) { return false },
+			OnHostByIP:  func(ip netip.Addr) (host string) { panic("not implemented") },
+			OnIPsByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 		},
 		DNSFilter:   f,
 		PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
@@ -374,3 +374,95 @@ func newSVCBHintsAnswer(target string, hints []dns.SVCBKeyValue) (rrs []dns.RR)
 		},
 	}}
 }
+
+func TestHandleDNSRequest_filterCNAMEChain(t *testing.T) {
+	const (
+		aliasFQDN   = "alias.example."
+		trackerFQDN = "cdn.tracker.example."
+	)
+
+	filters := []filtering.Filter{{
+		ID: 0, Data: []byte("||tracker.example^$dnstype=A\n"),
+	}}
+
+	req := createTestMessageWithType(aghtest.ReqFQDN, dns.TypeA)
+	respAns := []dns.RR{&dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   aghtest.ReqFQDN,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+		},
+		Target: aliasFQDN,
+	}, &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   aliasFQDN,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+		},
+		Target: trackerFQDN,
+	}, &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   trackerFQDN,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+		},
+		A: net.IP{1, 2, 3, 4},
+	}}
+
+	testCases := []struct {
+		want           *filtering.Result
+		name           string
+		chainFiltering bool
+	}{{
+		want:           nil,
+		name:           "targets_only",
+		chainFiltering: false,
+	}, {
+		want: &filtering.Result{
+			CNAMEMatch: "cdn.tracker.example",
+			Rules: []*filtering.ResultRule{{
+				Text: "||tracker.example^$dnstype=A",
+			}},
+			Reason:     filtering.FilteredBlockList,
+			IsFiltered: true,
+		},
+		name:           "chain",
+		chainFiltering: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := filtering.New(&filtering.Config{
+				CNAMEChainFiltering: tc.chainFiltering,
+			}, filters)
+			require.NoError(t, err)
+
+			f.SetEnabled(true)
+
+			s, err := NewServer(DNSCreateParams{
+				DHCPServer:  &testDHCP{},
+				DNSFilter:   f,
+				PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+			})
+			require.NoError(t, err)
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   req,
+					Res:   newResp(dns.RcodeSuccess, req, respAns),
+					Addr:  &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 1},
+				},
+				setts: &filtering.Settings{
+					ProtectionEnabled: true,
+					FilteringEnabled:  true,
+				},
+			}
+
+			err = s.filterDNSResponse(dctx)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, dctx.result)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// ForwardingEndpointConfig is the configuration of a forwarding endpoint.  The
+// DoT, DoQ, and DoH requests sent to its server name are relayed to the
+// upstream server as is, without filtering, caching, or recording them in the
+// query log and the statistics.  The TLS certificate must cover the server
+// name.
+type ForwardingEndpointConfig struct {
+	// ServerName is the hostname, by which the clients connect to the
+	// endpoint.  It's matched against the SNI of the DoT and DoQ connections
+	// and against the SNI or the Host header of the DoH requests.
+	ServerName string `yaml:"server_name"`
+
+	// Upstream is the address of the upstream server, to which the requests
+	// are forwarded.
+	Upstream string `yaml:"upstream"`
+}
+
+// newForwardingEndpoints validates confs and returns the upstream servers of
+// the forwarding endpoints by their lowercased server names.
+func newForwardingEndpoints(
+	confs []*ForwardingEndpointConfig,
+	opts *upstream.Options,
+) (endpoints map[string]upstream.Upstream, err error) {
+	if len(confs) == 0 {
+		return nil, nil
+	}
+
+	endpoints = make(map[string]upstream.Upstream, len(confs))
+	for i, c := range confs {
+		err = addForwardingEndpoint(endpoints, c, opts)
+		if err != nil {
+			return nil, errors.WithDeferred(
+				fmt.Errorf("forwarding endpoint at index %d: %w", i, err),
+				closeForwardingEndpoints(endpoints),
+			)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// addForwardingEndpoint validates conf and adds the upstream server for it to
+// endpoints.
+func addForwardingEndpoint(
+	endpoints map[string]upstream.Upstream,
+	conf *ForwardingEndpointConfig,
+	opts *upstream.Options,
+) (err error) {
+	switch {
+	case conf == nil:
+		return errors.Error("no value")
+	case conf.ServerName == "":
+		return errors.Error("no server_name")
+	case conf.Upstream == "":
+		return errors.Error("no upstream")
+	}
+
+	err = netutil.ValidateHostname(conf.ServerName)
+	if err != nil {
+		return fmt.Errorf("server_name: %w", err)
+	}
+
+	name := strings.ToLower(conf.ServerName)
+	if _, ok := endpoints[name]; ok {
+		return errors.Error("duplicate server_name")
+	}
+
+	ups, err := addressToUpstream(conf.Upstream, opts)
+	if err != nil {
+		return fmt.Errorf("upstream %q: %w", conf.Upstream, err)
+	}
+
+	endpoints[name] = ups
+
+	return nil
+}
+
+// closeForwardingEndpoints closes the upstream servers of endpoints.
+func closeForwardingEndpoints(endpoints map[string]upstream.Upstream) (err error) {
+	var errs []error
+	for _, ups := range endpoints {
+		errs = append(errs, ups.Close())
+	}
+
+	return errors.Annotate(errors.Join(errs...), "closing forwarding endpoints: %w")
+}
+
+// forwardingEndpoint returns the upstream server of the forwarding endpoint,
+// to which the DoT, DoQ, or DoH request of pctx has been sent.  ups is nil if
+// there is none.
+func (s *Server) forwardingEndpoint(pctx *proxy.DNSContext) (ups upstream.Upstream) {
+	if len(s.forwardingEndpoints) == 0 {
+		return nil
+	}
+
+	switch pctx.Proto {
+	case proxy.ProtoHTTPS, proxy.ProtoTLS, proxy.ProtoQUIC:
+		// Go on.
+	default:
+		return nil
+	}
+
+	srvName, err := clientServerName(pctx, pctx.Proto)
+	if err != nil {
+		log.Debug("dnsforward: forwarding endpoint: %s", err)
+
+		return nil
+	}
+
+	return s.forwardingEndpoints[strings.ToLower(srvName)]
+}
+
+// forwardRequest exchanges the request of pctx with ups and sets the response
+// to pctx.  If the exchange fails, the response is SERVFAIL.
+func (s *Server) forwardRequest(pctx *proxy.DNSContext, ups upstream.Upstream) {
+	log.Debug("dnsforward: forwarding endpoint: forwarding to %s", ups.Address())
+
+	resp, err := ups.Exchange(pctx.Req)
+	if err != nil {
+		log.Debug("dnsforward: forwarding endpoint: exchanging with %s: %s", ups.Address(), err)
+
+		pctx.Res = s.genServerFailure(pctx.Req)
+
+		return
+	}
+
+	resp.Id = pctx.Req.Id
+	pctx.Res = resp
+	pctx.Upstream = ups
+}
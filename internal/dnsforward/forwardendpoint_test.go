@@ -0,0 +1,167 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewForwardingEndpoints(t *testing.T) {
+	const srvName = "guest.dns.example"
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*ForwardingEndpointConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs:      []*ForwardingEndpointConfig{{ServerName: srvName, Upstream: "1.1.1.1"}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "forwarding endpoint at index 0: no value",
+		confs:      []*ForwardingEndpointConfig{nil},
+	}, {
+		name:       "no_server_name",
+		wantErrMsg: "forwarding endpoint at index 0: no server_name",
+		confs:      []*ForwardingEndpointConfig{{Upstream: "1.1.1.1"}},
+	}, {
+		name:       "no_upstream",
+		wantErrMsg: "forwarding endpoint at index 0: no upstream",
+		confs:      []*ForwardingEndpointConfig{{ServerName: srvName}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: "forwarding endpoint at index 1: duplicate server_name",
+		confs: []*ForwardingEndpointConfig{
+			{ServerName: srvName, Upstream: "1.1.1.1"},
+			{ServerName: "GUEST.dns.example", Upstream: "8.8.8.8"},
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoints, err := newForwardingEndpoints(tc.confs, &upstream.Options{})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			require.NoError(t, closeForwardingEndpoints(endpoints))
+		})
+	}
+}
+
+func TestServer_beforeRequestHandler_forwardingEndpoint(t *testing.T) {
+	const (
+		guestSrvName  = "guest.dns.example"
+		familySrvName = "family.dns.example"
+		failSrvName   = "fail.dns.example"
+	)
+
+	ups := &aghtest.UpstreamMock{
+		OnAddress: func() (addr string) { return "upstream.example" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			resp = newResp(dns.RcodeSuccess, req, []dns.RR{
+				newRR(t, req.Question[0].Name, dns.TypeA, 60, net.IP{192, 0, 2, 1}),
+			})
+			resp.Id = 0
+
+			return resp, nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	failUps := &aghtest.UpstreamMock{
+		OnAddress: func() (addr string) { return "fail.example" },
+		OnExchange: func(_ *dns.Msg) (resp *dns.Msg, err error) {
+			return nil, errors.Error("test error")
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	access, err := newAccessCtx(nil, []string{"192.0.2.2"}, nil, nil)
+	require.NoError(t, err)
+
+	s := &Server{
+		access: access,
+		forwardingEndpoints: map[string]upstream.Upstream{
+			guestSrvName: ups,
+			failSrvName:  failUps,
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		srvName   string
+		ip        net.IP
+		wantReply bool
+		wantRes   bool
+		wantRcode int
+	}{{
+		name:      "forwarded",
+		srvName:   guestSrvName,
+		ip:        net.IP{192, 0, 2, 1},
+		wantReply: true,
+		wantRes:   true,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "case_insensitive",
+		srvName:   "Guest.DNS.Example",
+		ip:        net.IP{192, 0, 2, 1},
+		wantReply: true,
+		wantRes:   true,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "upstream_failure",
+		srvName:   failSrvName,
+		ip:        net.IP{192, 0, 2, 1},
+		wantReply: true,
+		wantRes:   true,
+		wantRcode: dns.RcodeServerFailure,
+	}, {
+		name:      "blocked_client",
+		srvName:   guestSrvName,
+		ip:        net.IP{192, 0, 2, 2},
+		wantReply: true,
+		wantRes:   true,
+		wantRcode: dns.RcodeRefused,
+	}, {
+		name:      "other_server_name",
+		srvName:   familySrvName,
+		ip:        net.IP{192, 0, 2, 1},
+		wantReply: true,
+		wantRes:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := createTestMessage(aghtest.ReqFQDN)
+			pctx := &proxy.DNSContext{
+				Proto: proxy.ProtoTLS,
+				Conn:  testTLSConn{serverName: tc.srvName},
+				Addr:  &net.TCPAddr{IP: tc.ip, Port: 12345},
+				Req:   req,
+			}
+
+			reply, rErr := s.beforeRequestHandler(nil, pctx)
+			require.NoError(t, rErr)
+
+			assert.Equal(t, tc.wantReply, reply)
+			if !tc.wantRes {
+				assert.Nil(t, pctx.Res)
+
+				return
+			}
+
+			require.NotNil(t, pctx.Res)
+
+			assert.Equal(t, req.Id, pctx.Res.Id)
+			assert.Equal(t, tc.wantRcode, pctx.Res.Rcode)
+		})
+	}
+}
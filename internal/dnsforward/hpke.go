@@ -0,0 +1,189 @@
+package dnsforward
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// HPKE algorithm identifiers, see RFC 9180, Section 7.  Only the
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM suite is supported,
+// since it's the one every ODoH target is required to support.
+const (
+	hpkeKEMX25519HKDFSHA256 uint16 = 0x0020
+	hpkeKDFHKDFSHA256       uint16 = 0x0001
+	hpkeAEADAES128GCM       uint16 = 0x0001
+)
+
+// Sizes of the HPKE values of the supported suite in bytes.
+const (
+	// hpkeNSecret is the length of the KEM shared secret.
+	hpkeNSecret = 32
+
+	// hpkeNh is the output size of the KDF.
+	hpkeNh = sha256.Size
+
+	// hpkeNk is the length of the AEAD key.
+	hpkeNk = 16
+
+	// hpkeNn is the length of the AEAD nonce.
+	hpkeNn = 12
+)
+
+// hpkeVersionLabel is the version prefix of all HPKE labels.
+const hpkeVersionLabel = "HPKE-v1"
+
+// hpkeModeBase is the identifier of the base HPKE mode.
+const hpkeModeBase byte = 0x00
+
+var (
+	// hpkeKEMSuiteID is the suite identifier used within the KEM.
+	hpkeKEMSuiteID = binary.BigEndian.AppendUint16([]byte("KEM"), hpkeKEMX25519HKDFSHA256)
+
+	// hpkeSuiteID is the suite identifier used within the key schedule.
+	hpkeSuiteID = binary.BigEndian.AppendUint16(
+		binary.BigEndian.AppendUint16(
+			binary.BigEndian.AppendUint16([]byte("HPKE"), hpkeKEMX25519HKDFSHA256),
+			hpkeKDFHKDFSHA256,
+		),
+		hpkeAEADAES128GCM,
+	)
+)
+
+// hpkeContext is the single-shot encryption context of HPKE.  Since ODoH only
+// seals a single message per context, the sequence number is always zero.
+type hpkeContext struct {
+	aead           cipher.AEAD
+	baseNonce      []byte
+	exporterSecret []byte
+}
+
+// hpkeLabeledExtract implements the LabeledExtract function of RFC 9180.
+func hpkeLabeledExtract(suiteID, salt []byte, label string, ikm []byte) (prk []byte) {
+	labeled := append([]byte(hpkeVersionLabel), suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, ikm...)
+
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+// hpkeLabeledExpand implements the LabeledExpand function of RFC 9180.
+func hpkeLabeledExpand(
+	suiteID []byte,
+	prk []byte,
+	label string,
+	info []byte,
+	l uint16,
+) (out []byte, err error) {
+	labeled := binary.BigEndian.AppendUint16(nil, l)
+	labeled = append(labeled, hpkeVersionLabel...)
+	labeled = append(labeled, suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, info...)
+
+	out = make([]byte, l)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, labeled), out)
+	if err != nil {
+		return nil, fmt.Errorf("expanding %q: %w", label, err)
+	}
+
+	return out, nil
+}
+
+// hpkeSharedSecret implements the ExtractAndExpand function of the
+// DHKEM(X25519, HKDF-SHA256) KEM.
+func hpkeSharedSecret(dh, enc, pkR []byte) (secret []byte, err error) {
+	prk := hpkeLabeledExtract(hpkeKEMSuiteID, nil, "eae_prk", dh)
+	kemContext := append(append([]byte{}, enc...), pkR...)
+
+	return hpkeLabeledExpand(hpkeKEMSuiteID, prk, "shared_secret", kemContext, hpkeNSecret)
+}
+
+// hpkeSetupBaseS creates the sender's context for the recipient's public key
+// pkR.  enc is the encapsulated key to be sent to the recipient.
+func hpkeSetupBaseS(pkR *ecdh.PublicKey, info []byte) (enc []byte, c *hpkeContext, err error) {
+	skE, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating ephemeral key: %w", err)
+	}
+
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("computing shared key: %w", err)
+	}
+
+	enc = skE.PublicKey().Bytes()
+	secret, err := hpkeSharedSecret(dh, enc, pkR.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err = newHPKEContext(secret, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, c, nil
+}
+
+// newHPKEContext implements the KeySchedule function of RFC 9180 for the base
+// mode.
+func newHPKEContext(sharedSecret, info []byte) (c *hpkeContext, err error) {
+	pskIDHash := hpkeLabeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)
+	infoHash := hpkeLabeledExtract(hpkeSuiteID, nil, "info_hash", info)
+
+	ksContext := append([]byte{hpkeModeBase}, pskIDHash...)
+	ksContext = append(ksContext, infoHash...)
+
+	secret := hpkeLabeledExtract(hpkeSuiteID, sharedSecret, "secret", nil)
+
+	key, err := hpkeLabeledExpand(hpkeSuiteID, secret, "key", ksContext, hpkeNk)
+	if err != nil {
+		return nil, err
+	}
+
+	c = &hpkeContext{}
+	c.baseNonce, err = hpkeLabeledExpand(hpkeSuiteID, secret, "base_nonce", ksContext, hpkeNn)
+	if err != nil {
+		return nil, err
+	}
+
+	c.exporterSecret, err = hpkeLabeledExpand(hpkeSuiteID, secret, "exp", ksContext, hpkeNh)
+	if err != nil {
+		return nil, err
+	}
+
+	c.aead, err = newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// seal encrypts and authenticates pt with associated data aad.
+func (c *hpkeContext) seal(aad, pt []byte) (ct []byte) {
+	return c.aead.Seal(nil, c.baseNonce, pt, aad)
+}
+
+// export implements the Export function of RFC 9180.
+func (c *hpkeContext) export(exporterContext []byte, l uint16) (secret []byte, err error) {
+	return hpkeLabeledExpand(hpkeSuiteID, c.exporterSecret, "sec", exporterContext, l)
+}
+
+// newAESGCM returns an AES-GCM AEAD with key.
+func newAESGCM(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -140,6 +140,8 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 		upstreamMode = "fastest_addr"
 	} else if s.conf.AllServers {
 		upstreamMode = "parallel"
+	} else if s.conf.UpstreamRace {
+		upstreamMode = "race"
 	}
 
 	defPTRUps, err := s.defaultLocalPTRUpstreams()
@@ -209,7 +211,7 @@ func (req *jsonDNSConfig) checkBlockingMode() (err error) {
 }
 
 func (req *jsonDNSConfig) checkUpstreamsMode() bool {
-	valid := []string{"", "fastest_addr", "parallel"}
+	valid := []string{"", "fastest_addr", "parallel", "race"}
 
 	return req.UpstreamMode == nil || stringutil.InSlice(valid, *req.UpstreamMode)
 }
@@ -219,10 +221,16 @@ func (req *jsonDNSConfig) checkBootstrap() (err error) {
 		return nil
 	}
 
+	return ValidateBootstraps(*req.Bootstraps)
+}
+
+// ValidateBootstraps returns an error if any of the bootstrap DNS server
+// addresses is invalid.
+func ValidateBootstraps(bootstraps []string) (err error) {
 	var b string
 	defer func() { err = errors.Annotate(err, "checking bootstrap %s: invalid address: %w", b) }()
 
-	for _, b = range *req.Bootstraps {
+	for _, b = range bootstraps {
 		if b == "" {
 			return errors.Error("empty")
 		}
@@ -355,6 +363,7 @@ func (s *Server) setConfig(dc *jsonDNSConfig) (shouldRestart bool) {
 	if dc.UpstreamMode != nil {
 		s.conf.AllServers = *dc.UpstreamMode == "parallel"
 		s.conf.FastestAddr = *dc.UpstreamMode == "fastest_addr"
+		s.conf.UpstreamRace = *dc.UpstreamMode == "race"
 	}
 
 	if dc.EDNSCSUseCustom != nil && *dc.EDNSCSUseCustom {
@@ -459,7 +468,7 @@ func newUpstreamConfig(upstreams []string) (conf *proxy.UpstreamConfig, err erro
 		}
 	}
 
-	conf, err = proxy.ParseUpstreamsConfig(
+	conf, err = ParseUpstreamsConfig(
 		upstreams,
 		&upstream.Options{
 			Bootstrap: []string{},
@@ -526,6 +535,7 @@ func ValidateUpstreamsPrivate(upstreams []string, privateNets netutil.SubnetSet)
 var protocols = []string{
 	"h3://",
 	"https://",
+	"odoh://",
 	"quic://",
 	"sdns://",
 	"tcp://",
@@ -722,7 +732,7 @@ func (s *Server) parseUpstreamLine(
 		}
 		sortNetIPAddrs(opts.ServerIPAddrs, opts.PreferIPv6)
 	}
-	u, err = upstream.AddressToUpstream(upstreamAddr, opts)
+	u, err = addressToUpstream(upstreamAddr, opts)
 	if err != nil {
 		return nil, specific, fmt.Errorf("creating upstream for %q: %w", upstreamAddr, err)
 	}
@@ -761,8 +771,31 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	errs := s.TestUpstreams(req.Upstreams, req.FallbackDNS, req.PrivateUpstreams, req.BootstrapDNS)
+	result := make(map[string]string, len(errs))
+	for host, upsErr := range errs {
+		if upsErr != nil {
+			result[host] = upsErr.Error()
+		} else {
+			result[host] = "OK"
+		}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, result)
+}
+
+// TestUpstreams checks if the upstreams, the fallback upstreams, and the
+// private upstreams are usable with the bootstrap servers.  The default
+// bootstrap servers are used if bootstrap is empty.  errs contains the result
+// of the check, which is nil for the usable upstreams, for each of them.
+func (s *Server) TestUpstreams(
+	upstreams []string,
+	fallback []string,
+	private []string,
+	bootstrap []string,
+) (errs map[string]error) {
 	opts := &upstream.Options{
-		Bootstrap:  req.BootstrapDNS,
+		Bootstrap:  bootstrap,
 		Timeout:    s.conf.UpstreamTimeout,
 		PreferIPv6: s.conf.BootstrapPreferIPv6,
 	}
@@ -775,15 +808,15 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 		host string
 	}
 
-	req.Upstreams = stringutil.FilterOut(req.Upstreams, IsCommentOrEmpty)
-	req.FallbackDNS = stringutil.FilterOut(req.FallbackDNS, IsCommentOrEmpty)
-	req.PrivateUpstreams = stringutil.FilterOut(req.PrivateUpstreams, IsCommentOrEmpty)
+	upstreams = stringutil.FilterOut(upstreams, IsCommentOrEmpty)
+	fallback = stringutil.FilterOut(fallback, IsCommentOrEmpty)
+	private = stringutil.FilterOut(private, IsCommentOrEmpty)
 
-	upsNum := len(req.Upstreams) + len(req.FallbackDNS) + len(req.PrivateUpstreams)
-	result := make(map[string]string, upsNum)
+	upsNum := len(upstreams) + len(fallback) + len(private)
+	errs = make(map[string]error, upsNum)
 	resCh := make(chan upsCheckResult, upsNum)
 
-	for _, ups := range req.Upstreams {
+	for _, ups := range upstreams {
 		go func(ups string) {
 			resCh <- upsCheckResult{
 				host: ups,
@@ -791,7 +824,7 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 			}
 		}(ups)
 	}
-	for _, ups := range req.FallbackDNS {
+	for _, ups := range fallback {
 		go func(ups string) {
 			resCh <- upsCheckResult{
 				host: ups,
@@ -799,7 +832,7 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 			}
 		}(ups)
 	}
-	for _, ups := range req.PrivateUpstreams {
+	for _, ups := range private {
 		go func(ups string) {
 			resCh <- upsCheckResult{
 				host: ups,
@@ -812,19 +845,21 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 		// TODO(e.burkov):  The upstreams used for both common and private
 		// resolving should be reported separately.
 		pair := <-resCh
-		if pair.err != nil {
-			result[pair.host] = pair.err.Error()
-		} else {
-			result[pair.host] = "OK"
-		}
+		errs[pair.host] = pair.err
 	}
 
-	aghhttp.WriteJSONResponseOK(w, r, result)
+	return errs
 }
 
 // handleCacheClear is the handler for the POST /control/cache_clear HTTP API.
 func (s *Server) handleCacheClear(w http.ResponseWriter, _ *http.Request) {
-	s.dnsProxy.ClearCache()
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	if s.cache != nil {
+		s.cache.flush(&dnsCacheFilter{})
+	}
+
 	_, _ = io.WriteString(w, "OK")
 }
 
@@ -896,6 +931,10 @@ func (s *Server) handleDoH(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.checkClientCertHTTP(w, r) {
+		return
+	}
+
 	s.ServeHTTP(w, r)
 }
 
@@ -907,12 +946,22 @@ func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodGet, "/control/dns_info", s.handleGetConfig)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dns_config", s.handleSetConfig)
 	s.conf.HTTPRegister(http.MethodPost, "/control/test_upstream_dns", s.handleTestUpstreamDNS)
+	s.conf.HTTPRegister(http.MethodGet, "/control/upstreams/quic_stats", s.handleQUICStats)
+	s.conf.HTTPRegister(http.MethodGet, "/control/upstreams/pool_stats", s.handlePipelineStats)
 	s.conf.HTTPRegister(http.MethodPost, "/control/protection", s.handleSetProtection)
 
 	s.conf.HTTPRegister(http.MethodGet, "/control/access/list", s.handleAccessList)
 	s.conf.HTTPRegister(http.MethodPost, "/control/access/set", s.handleAccessSet)
+	s.conf.HTTPRegister(http.MethodGet, "/control/access/hits", s.handleAccessHits)
+
+	s.conf.HTTPRegister(http.MethodGet, "/control/listeners/list", s.handleListenersList)
+	s.conf.HTTPRegister(http.MethodPost, "/control/listeners/set", s.handleListenersSet)
 
 	s.conf.HTTPRegister(http.MethodPost, "/control/cache_clear", s.handleCacheClear)
+	s.conf.HTTPRegister(http.MethodGet, "/control/cache", s.handleCacheList)
+	s.conf.HTTPRegister(http.MethodPost, "/control/cache/flush", s.handleCacheFlush)
+
+	s.conf.HTTPRegister(http.MethodPost, queryTraceAPI, s.handleQueryTrace)
 
 	// Register both versions, with and without the trailing slash, to
 	// prevent a 301 Moved Permanently redirect when clients request the
@@ -923,6 +972,7 @@ func (s *Server) registerHandlers() {
 	// See also https://github.com/AdguardTeam/AdGuardHome/issues/2628.
 	s.conf.HTTPRegister("", "/dns-query", s.handleDoH)
 	s.conf.HTTPRegister("", "/dns-query/", s.handleDoH)
+	s.conf.HTTPRegister("", dohRelayPath, s.handleDoHRelay)
 
 	webRegistered = true
 }
@@ -200,6 +200,9 @@ func TestDNSForwardHTTP_handleSetConfig(t *testing.T) {
 	}, {
 		name:    "upstream_mode_fastest_addr",
 		wantSet: "",
+	}, {
+		name:    "upstream_mode_race",
+		wantSet: "",
 	}, {
 		name:    "upstream_dns_bad",
 		wantSet: `validating upstream servers: validating upstream "!!!": not an ip:port`,
@@ -324,6 +327,13 @@ func TestValidateUpstreams(t *testing.T) {
 			"[/host/]" + sdnsStamp,
 			"8.8.8.8",
 		},
+	}, {
+		name:    "odoh",
+		wantErr: ``,
+		set: []string{
+			"odoh://odoh.example/dns-query?relay=https://relay.example/proxy",
+			"[/host.com/]odoh://odoh.example/dns-query",
+		},
 	}, {
 		name:    "invalid",
 		wantErr: `validating upstream "dhcp://fake.dns": bad protocol "dhcp"`,
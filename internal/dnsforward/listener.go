@@ -0,0 +1,367 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/geoip"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"golang.org/x/exp/slices"
+)
+
+// Listener protocol names.
+const (
+	listenerProtoDNS      = "dns"
+	listenerProtoTLS      = "tls"
+	listenerProtoHTTPS    = "https"
+	listenerProtoQUIC     = "quic"
+	listenerProtoDNSCrypt = "dnscrypt"
+)
+
+// listenerProtos are the valid names of the protocols in the listener
+// configurations.
+var listenerProtos = []string{
+	listenerProtoDNS,
+	listenerProtoTLS,
+	listenerProtoHTTPS,
+	listenerProtoQUIC,
+	listenerProtoDNSCrypt,
+}
+
+// ListenerConfig is the configuration of the DNS listeners with a certain
+// local address.
+type ListenerConfig struct {
+	// Address is the local address of the listeners, as in the bind_hosts
+	// and the port settings.  The unspecified IP address matches the
+	// listeners with any address and zero port matches the listeners with any
+	// port.
+	Address netip.AddrPort `yaml:"address" json:"address"`
+
+	// ClientID, if not empty, is the ClientID attributed to the requests
+	// received by the listeners, which have no ClientID of their own.  It
+	// allows the per-client settings for the devices, which only support plain
+	// DNS and can't be distinguished by their IP addresses, and serves as the
+	// default client policy of the listeners.  If the address has a non-zero
+	// port and isn't listened to yet, the plain DNS listeners are added for
+	// it.
+	ClientID string `yaml:"client_id" json:"client_id"`
+
+	// Protocols, if not empty, are the protocols served by the listeners:
+	// "dns", "tls", "https", "quic", and "dnscrypt".  The requests received
+	// using the other protocols are dropped or refused as the ones from the
+	// disallowed clients.
+	Protocols []string `yaml:"protocols" json:"protocols"`
+
+	// AllowedClients, if not empty, are the only clients allowed to use the
+	// listeners, in addition to the global access settings.  The syntax is
+	// the same as in the global allowed clients.
+	AllowedClients []string `yaml:"allowed_clients" json:"allowed_clients"`
+
+	// DisallowedClients are the clients, which aren't allowed to use the
+	// listeners, in addition to the global access settings.  It's ignored if
+	// AllowedClients isn't empty.
+	DisallowedClients []string `yaml:"disallowed_clients" json:"disallowed_clients"`
+
+	// Ratelimit is the maximum number of requests per second from a client
+	// received by the listeners, which is used for the clients with no rate
+	// limit of their own.  Zero means no limit.
+	Ratelimit uint32 `yaml:"ratelimit" json:"ratelimit"`
+
+	// IgnoreQueryLog, if true, makes the requests received by the listeners
+	// not to be written to the query log.
+	IgnoreQueryLog bool `yaml:"ignore_querylog" json:"ignore_querylog"`
+
+	// IgnoreStatistics, if true, makes the requests received by the listeners
+	// not to be counted in the statistics.
+	IgnoreStatistics bool `yaml:"ignore_statistics" json:"ignore_statistics"`
+}
+
+// matches returns true if the listener with the local address laddr matches
+// c.
+func (c *ListenerConfig) matches(laddr netip.AddrPort) (ok bool) {
+	ip := c.Address.Addr()
+	if !ip.IsUnspecified() && ip != laddr.Addr() {
+		return false
+	}
+
+	port := c.Address.Port()
+
+	return port == 0 || port == laddr.Port()
+}
+
+// validateListeners returns an error if the listener configurations are
+// invalid.
+func validateListeners(confs []*ListenerConfig) (err error) {
+	for i, c := range confs {
+		if c == nil {
+			return fmt.Errorf("listener at index %d: %w", i, errors.Error("no value"))
+		}
+
+		err = c.validate()
+		if err != nil {
+			return fmt.Errorf("listener at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// validate returns an error if c is invalid.  c must not be nil.
+func (c *ListenerConfig) validate() (err error) {
+	if !c.Address.Addr().IsValid() {
+		return errors.Error("no address")
+	}
+
+	if c.ClientID != "" {
+		err = ValidateClientID(c.ClientID)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+	}
+
+	for _, p := range c.Protocols {
+		if !slices.Contains(listenerProtos, p) {
+			return fmt.Errorf("protocols: unknown protocol %q", p)
+		}
+	}
+
+	err = validateAccessSet(&accessListJSON{
+		AllowedClients:    c.AllowedClients,
+		DisallowedClients: c.DisallowedClients,
+	})
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return nil
+}
+
+// newListenerAccess returns the access managers of the listener configurations
+// with client lists.  confs must be valid.
+func newListenerAccess(
+	confs []*ListenerConfig,
+	geoIP *geoip.Database,
+) (access map[*ListenerConfig]*accessManager, err error) {
+	for i, c := range confs {
+		if len(c.AllowedClients) == 0 && len(c.DisallowedClients) == 0 {
+			continue
+		}
+
+		var a *accessManager
+		a, err = newAccessCtx(c.AllowedClients, c.DisallowedClients, nil, geoIP)
+		if err != nil {
+			return nil, fmt.Errorf("listener at index %d: %w", i, err)
+		}
+
+		if access == nil {
+			access = map[*ListenerConfig]*accessManager{}
+		}
+
+		access[c] = a
+	}
+
+	return access, nil
+}
+
+// listenerProto returns the name of the listener protocol for proto.
+func listenerProto(proto proxy.Proto) (name string) {
+	switch proto {
+	case proxy.ProtoUDP, proxy.ProtoTCP:
+		return listenerProtoDNS
+	case proxy.ProtoTLS:
+		return listenerProtoTLS
+	case proxy.ProtoHTTPS:
+		return listenerProtoHTTPS
+	case proxy.ProtoQUIC:
+		return listenerProtoQUIC
+	case proxy.ProtoDNSCrypt:
+		return listenerProtoDNSCrypt
+	default:
+		return string(proto)
+	}
+}
+
+// isBlockedByListener returns true if the request of pctx from the client with
+// ip and clientID isn't allowed by the configuration of the listener lc, which
+// has received it.  lc may be nil.
+func (s *Server) isBlockedByListener(
+	lc *ListenerConfig,
+	pctx *proxy.DNSContext,
+	ip netip.Addr,
+	clientID string,
+) (blocked bool) {
+	if lc == nil {
+		return false
+	}
+
+	if len(lc.Protocols) > 0 && !slices.Contains(lc.Protocols, listenerProto(pctx.Proto)) {
+		log.Debug("dnsforward: listener %s doesn't serve %s", lc.Address, pctx.Proto)
+
+		return true
+	}
+
+	s.serverLock.RLock()
+	a := s.listenerAccess[lc]
+	s.serverLock.RUnlock()
+
+	if a == nil {
+		return false
+	}
+
+	blocked, _ = a.isBlockedClient(ip, clientID)
+
+	return blocked
+}
+
+// addClientIDListenAddrs adds the addresses of the listeners with ClientIDs,
+// which aren't listened to yet, to the plain DNS listen addresses of conf.  The
+// ports already listened to on the unspecified addresses are skipped, since
+// they can't be bound again.  confs must be valid.
+func (conf *ServerConfig) addClientIDListenAddrs(confs []*ListenerConfig) {
+	addrs, unspecPorts := conf.collectDNSAddrs()
+
+	var udpAddrs []*net.UDPAddr
+	var tcpAddrs []*net.TCPAddr
+	for _, c := range confs {
+		if c.ClientID == "" || c.Address.Port() == 0 {
+			continue
+		}
+
+		if _, ok := addrs[c.Address]; ok {
+			continue
+		} else if _, ok = unspecPorts[c.Address.Port()]; ok {
+			continue
+		}
+
+		addrs[c.Address] = unit{}
+		udpAddrs = append(udpAddrs, net.UDPAddrFromAddrPort(c.Address))
+		tcpAddrs = append(tcpAddrs, net.TCPAddrFromAddrPort(c.Address))
+	}
+
+	if len(udpAddrs) == 0 {
+		return
+	}
+
+	// Don't modify the slices of the caller.
+	conf.UDPListenAddrs = append(slices.Clip(conf.UDPListenAddrs), udpAddrs...)
+	conf.TCPListenAddrs = append(slices.Clip(conf.TCPListenAddrs), tcpAddrs...)
+}
+
+// listenerConfig returns the configuration of the listener, which has received
+// the request of pctx.  lc is nil if there is none.
+func (s *Server) listenerConfig(pctx *proxy.DNSContext) (lc *ListenerConfig) {
+	if len(s.conf.Listeners) == 0 {
+		return nil
+	}
+
+	laddr := localAddr(pctx)
+	if !laddr.IsValid() {
+		return nil
+	}
+
+	for _, c := range s.conf.Listeners {
+		if c.matches(laddr) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// localAddr returns the local address of the listener, which has received the
+// request of pctx.  addr is invalid if it's unknown.
+func localAddr(pctx *proxy.DNSContext) (addr netip.AddrPort) {
+	var laddr net.Addr
+	switch {
+	case pctx.Conn != nil:
+		laddr = pctx.Conn.LocalAddr()
+	case pctx.QUICConnection != nil:
+		laddr = pctx.QUICConnection.LocalAddr()
+	case pctx.HTTPRequest != nil:
+		laddr, _ = pctx.HTTPRequest.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	case pctx.DNSCryptResponseWriter != nil:
+		laddr = pctx.DNSCryptResponseWriter.LocalAddr()
+	default:
+		// Go on.
+	}
+
+	if laddr == nil {
+		return netip.AddrPort{}
+	}
+
+	addr = netutil.NetAddrToAddrPort(laddr)
+
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
+
+// listenersJSON is the JSON structure of the listener configurations for the
+// HTTP API.
+type listenersJSON struct {
+	Listeners []*ListenerConfig `json:"listeners"`
+}
+
+// handleListenersList is the handler for the GET /control/listeners/list HTTP
+// API.
+func (s *Server) handleListenersList(w http.ResponseWriter, r *http.Request) {
+	s.serverLock.RLock()
+	resp := &listenersJSON{
+		Listeners: slices.Clone(s.conf.Listeners),
+	}
+	s.serverLock.RUnlock()
+
+	if resp.Listeners == nil {
+		resp.Listeners = []*ListenerConfig{}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleListenersSet is the handler for the POST /control/listeners/set HTTP
+// API.  The server is restarted to apply the new configurations.
+func (s *Server) handleListenersSet(w http.ResponseWriter, r *http.Request) {
+	req := &listenersJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = validateListeners(req.Listeners)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	s.serverLock.RLock()
+	geoIP := s.geoIP
+	s.serverLock.RUnlock()
+
+	_, err = newListenerAccess(req.Listeners, geoIP)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	s.serverLock.Lock()
+	s.conf.Listeners = req.Listeners
+	s.serverLock.Unlock()
+
+	s.conf.ConfigModified()
+
+	err = s.Reconfigure(nil)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+	}
+}
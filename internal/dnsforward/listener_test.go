@@ -0,0 +1,182 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateListeners(t *testing.T) {
+	addr := netip.MustParseAddrPort("192.0.2.1:853")
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*ListenerConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs: []*ListenerConfig{{
+			Address:        addr,
+			Protocols:      []string{listenerProtoTLS, listenerProtoQUIC},
+			AllowedClients: []string{"198.51.100.0/24"},
+			Ratelimit:      10,
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "listener at index 0: no value",
+		confs:      []*ListenerConfig{nil},
+	}, {
+		name:       "no_address",
+		wantErrMsg: "listener at index 0: no address",
+		confs:      []*ListenerConfig{{}},
+	}, {
+		name:       "bad_protocol",
+		wantErrMsg: `listener at index 0: protocols: unknown protocol "udp"`,
+		confs: []*ListenerConfig{{
+			Address:   addr,
+			Protocols: []string{"udp"},
+		}},
+	}, {
+		name: "intersecting_clients",
+		wantErrMsg: "listener at index 0: items in allowed and disallowed clients " +
+			`intersect: duplicated values: [198.51.100.1]`,
+		confs: []*ListenerConfig{{
+			Address:           addr,
+			AllowedClients:    []string{"198.51.100.1"},
+			DisallowedClients: []string{"198.51.100.1"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateListeners(tc.confs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestServer_beforeRequestHandler_listener(t *testing.T) {
+	lanAddr := &net.UDPAddr{IP: net.IP{192, 168, 0, 1}, Port: 53}
+	wanAddr := &net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 53}
+	limAddr := &net.UDPAddr{IP: net.IP{192, 0, 2, 2}, Port: 53}
+
+	listeners := []*ListenerConfig{{
+		Address:           netip.AddrPortFrom(wanAddr.AddrPort().Addr(), 0),
+		Protocols:         []string{listenerProtoTLS},
+		DisallowedClients: []string{"198.51.100.2"},
+	}, {
+		Address:   limAddr.AddrPort(),
+		Ratelimit: 1,
+	}}
+
+	access, err := newAccessCtx(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	listenerAccess, err := newListenerAccess(listeners, nil)
+	require.NoError(t, err)
+
+	s := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				Listeners: listeners,
+			},
+		},
+		access:         access,
+		listenerAccess: listenerAccess,
+		limiter:        newClientLimiter(nil),
+	}
+
+	cliAddr := &net.TCPAddr{IP: net.IP{198, 51, 100, 1}, Port: 12345}
+	blockedCliAddr := &net.TCPAddr{IP: net.IP{198, 51, 100, 2}, Port: 12345}
+
+	testCases := []struct {
+		conn      net.Conn
+		addr      net.Addr
+		name      string
+		proto     proxy.Proto
+		wantReply bool
+		wantRcode int
+	}{{
+		conn:      testUDPConn{laddr: lanAddr},
+		addr:      cliAddr,
+		name:      "lan_plain",
+		proto:     proxy.ProtoTCP,
+		wantReply: true,
+		wantRcode: -1,
+	}, {
+		conn:      testTLSConn{Conn: testUDPConn{laddr: wanAddr}},
+		addr:      cliAddr,
+		name:      "wan_tls",
+		proto:     proxy.ProtoTLS,
+		wantReply: true,
+		wantRcode: -1,
+	}, {
+		conn:      testUDPConn{laddr: wanAddr},
+		addr:      cliAddr,
+		name:      "wan_plain_tcp",
+		proto:     proxy.ProtoTCP,
+		wantReply: true,
+		wantRcode: dns.RcodeRefused,
+	}, {
+		conn:      testUDPConn{laddr: wanAddr},
+		addr:      cliAddr,
+		name:      "wan_plain_udp",
+		proto:     proxy.ProtoUDP,
+		wantReply: false,
+		wantRcode: -1,
+	}, {
+		conn:      testTLSConn{Conn: testUDPConn{laddr: wanAddr}},
+		addr:      blockedCliAddr,
+		name:      "wan_tls_disallowed",
+		proto:     proxy.ProtoTLS,
+		wantReply: true,
+		wantRcode: dns.RcodeRefused,
+	}, {
+		conn:      testUDPConn{laddr: limAddr},
+		addr:      cliAddr,
+		name:      "ratelimit_first",
+		proto:     proxy.ProtoTCP,
+		wantReply: true,
+		wantRcode: -1,
+	}, {
+		conn:      testUDPConn{laddr: limAddr},
+		addr:      cliAddr,
+		name:      "ratelimit_exceeded",
+		proto:     proxy.ProtoTCP,
+		wantReply: true,
+		wantRcode: dns.RcodeRefused,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pctx := &proxy.DNSContext{
+				Proto: tc.proto,
+				Conn:  tc.conn,
+				Addr:  tc.addr,
+				Req:   createTestMessage(aghtest.ReqFQDN),
+			}
+
+			reply, rErr := s.beforeRequestHandler(nil, pctx)
+			require.NoError(t, rErr)
+
+			assert.Equal(t, tc.wantReply, reply)
+			if tc.wantRcode < 0 {
+				assert.Nil(t, pctx.Res)
+
+				return
+			}
+
+			require.NotNil(t, pctx.Res)
+
+			assert.Equal(t, tc.wantRcode, pctx.Res.Rcode)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package dnsforward
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/metrics"
+)
+
+// upstreamDurationBuckets are the upper bounds of the buckets of the upstream
+// response duration histogram, in seconds.
+var upstreamDurationBuckets = []float64{
+	0.001,
+	0.005,
+	0.01,
+	0.025,
+	0.05,
+	0.1,
+	0.25,
+	0.5,
+	1,
+	2.5,
+	5,
+	10,
+}
+
+// Metrics are the metrics of the DNS server.  They are kept across the
+// reconfigurations of the server.
+type Metrics struct {
+	// queries is the number of the processed requests by the protocol.
+	queries *metrics.CounterVec
+
+	// blocked is the number of the filtered requests by the reason.
+	blocked *metrics.CounterVec
+
+	// cacheLookups is the number of the requests resolved either from the
+	// cache or by the upstreams.
+	cacheLookups *metrics.CounterVec
+
+	// cacheHits is the number of the requests resolved from the cache.
+	cacheHits *metrics.CounterVec
+
+	// upstreamDuration is the duration of processing the requests resolved by
+	// the upstreams by the upstream address.
+	upstreamDuration *metrics.HistogramVec
+}
+
+// NewMetrics registers the metrics of the DNS server in reg and returns them.
+// It must only be called once for reg.
+func NewMetrics(reg *metrics.Registry) (m *Metrics) {
+	return &Metrics{
+		queries: reg.NewCounterVec(
+			"adguardhome_dns_queries_total",
+			"Number of processed DNS queries by the protocol.",
+			"protocol",
+		),
+		blocked: reg.NewCounterVec(
+			"adguardhome_dns_blocked_total",
+			"Number of filtered DNS queries by the filtering reason.",
+			"reason",
+		),
+		cacheLookups: reg.NewCounterVec(
+			"adguardhome_dns_cache_lookups_total",
+			"Number of DNS queries resolved either from the cache or by the upstreams.",
+		),
+		cacheHits: reg.NewCounterVec(
+			"adguardhome_dns_cache_hits_total",
+			"Number of DNS queries resolved from the cache.",
+		),
+		upstreamDuration: reg.NewHistogramVec(
+			"adguardhome_dns_upstream_duration_seconds",
+			"Duration of processing the DNS queries resolved by the upstreams.",
+			upstreamDurationBuckets,
+			"upstream",
+		),
+	}
+}
+
+// update records the processed request into the metrics.
+func (m *Metrics) update(dctx *dnsContext, elapsed time.Duration) {
+	pctx := dctx.proxyCtx
+	m.queries.Inc(string(pctx.Proto))
+
+	if res := dctx.result; res != nil && res.IsFiltered {
+		m.blocked.Inc(res.Reason.String())
+	}
+
+	if pctx.Upstream != nil {
+		m.cacheLookups.Inc()
+		m.upstreamDuration.Observe(elapsed.Seconds(), pctx.Upstream.Address())
+	} else if pctx.CachedUpstreamAddr != "" {
+		m.cacheLookups.Inc()
+		m.cacheHits.Inc()
+	}
+}
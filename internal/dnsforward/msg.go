@@ -77,7 +77,7 @@ func (s *Server) genDNSFilterMessage(
 // blocking mode.
 func (s *Server) genForBlockingMode(req *dns.Msg, ips []netip.Addr) (resp *dns.Msg) {
 	switch mode, bIPv4, bIPv6 := s.dnsFilter.BlockingMode(); mode {
-	case filtering.BlockingModeCustomIP:
+	case filtering.BlockingModeCustomIP, filtering.BlockingModeBlockPage:
 		return s.makeResponseCustomIP(req, bIPv4, bIPv6)
 	case filtering.BlockingModeDefault:
 		if len(ips) > 0 {
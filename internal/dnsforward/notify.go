@@ -0,0 +1,81 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// seenClientsCount is the maximum number of the remembered IP addresses of the
+// clients used to detect the new ones.  The least recently seen clients are
+// forgotten first.
+const seenClientsCount = 10_000
+
+// seenClientVal is the value of the seen clients in the cache.  It's not empty,
+// since the cache returns nil for the missing keys.
+var seenClientVal = []byte{1}
+
+// upstreamOutageThreshold is the number of the consecutive failed upstream
+// exchanges, after which the upstreams are considered down.
+const upstreamOutageThreshold = 10
+
+// rememberClients marks the clients with ips as seen.
+func (s *Server) rememberClients(ips []netip.Addr) {
+	for _, ip := range ips {
+		s.seenClients.Set(ip.AsSlice(), seenClientVal)
+	}
+}
+
+// notifyNewClient sends a notification about the client with ip, if it hasn't
+// been seen before.
+func (s *Server) notifyNewClient(ip netip.Addr) {
+	key := ip.AsSlice()
+	if s.seenClients.Get(key) != nil {
+		return
+	}
+
+	s.seenClients.Set(key, seenClientVal)
+	s.notifier.Notify(&notify.Event{
+		Time:    time.Now(),
+		Type:    notify.EventNewClient,
+		Message: fmt.Sprintf("new client %s", ip),
+		Details: map[string]string{
+			"ip": ip.String(),
+		},
+	})
+}
+
+// countUpstreamFailure accounts the result of an upstream exchange and sends a
+// notification when the number of the consecutive failures reaches
+// [upstreamOutageThreshold].  err is the error of the exchange, if any.
+func (s *Server) countUpstreamFailure(err error) {
+	if err == nil {
+		s.upstreamFails.Store(0)
+
+		return
+	} else if errors.Is(err, upstream.ErrNoUpstreams) {
+		// Not an upstream failure.
+		return
+	}
+
+	if s.upstreamFails.Add(1) != upstreamOutageThreshold {
+		return
+	}
+
+	s.notifier.Notify(&notify.Event{
+		Time: time.Now(),
+		Type: notify.EventUpstreamOutage,
+		Message: fmt.Sprintf(
+			"%d consecutive upstream requests have failed, last error: %s",
+			upstreamOutageThreshold,
+			err,
+		),
+		Details: map[string]string{
+			"error": err.Error(),
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNotifier is a [notify.Interface] implementation for tests, which
+// collects the events.
+type testNotifier struct {
+	events []*notify.Event
+}
+
+// type check
+var _ notify.Interface = (*testNotifier)(nil)
+
+// Notify implements the [notify.Interface] interface for *testNotifier.
+func (n *testNotifier) Notify(e *notify.Event) {
+	n.events = append(n.events, e)
+}
+
+// newNotifyTestServer returns a new *Server with only the fields required for
+// the notifications.
+func newNotifyTestServer(n notify.Interface) (s *Server) {
+	return &Server{
+		notifier: n,
+		seenClients: cache.New(cache.Config{
+			EnableLRU: true,
+			MaxCount:  seenClientsCount,
+		}),
+	}
+}
+
+func TestServer_notifyNewClient(t *testing.T) {
+	n := &testNotifier{}
+	s := newNotifyTestServer(n)
+
+	known := netip.MustParseAddr("192.0.2.1")
+	s.rememberClients([]netip.Addr{known})
+
+	s.notifyNewClient(known)
+	assert.Empty(t, n.events)
+
+	ip := netip.MustParseAddr("192.0.2.2")
+	s.notifyNewClient(ip)
+	s.notifyNewClient(ip)
+
+	require.Len(t, n.events, 1)
+
+	assert.Equal(t, notify.EventNewClient, n.events[0].Type)
+	assert.Equal(t, map[string]string{"ip": "192.0.2.2"}, n.events[0].Details)
+}
+
+func TestServer_countUpstreamFailure(t *testing.T) {
+	const errTest errors.Error = "test error"
+
+	n := &testNotifier{}
+	s := newNotifyTestServer(n)
+
+	for i := 0; i < upstreamOutageThreshold-1; i++ {
+		s.countUpstreamFailure(errTest)
+	}
+
+	// A success resets the counter.
+	s.countUpstreamFailure(nil)
+
+	for i := 0; i < upstreamOutageThreshold-1; i++ {
+		s.countUpstreamFailure(errTest)
+	}
+
+	// Not an upstream failure.
+	s.countUpstreamFailure(upstream.ErrNoUpstreams)
+	assert.Empty(t, n.events)
+
+	// Only notify once per outage.
+	for i := 0; i < upstreamOutageThreshold; i++ {
+		s.countUpstreamFailure(errTest)
+	}
+
+	require.Len(t, n.events, 1)
+
+	assert.Equal(t, notify.EventUpstreamOutage, n.events[0].Type)
+	assert.Equal(t, map[string]string{"error": "test error"}, n.events[0].Details)
+}
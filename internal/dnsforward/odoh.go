@@ -0,0 +1,592 @@
+package dnsforward
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/hkdf"
+)
+
+// odohScheme is the prefix of the addresses of Oblivious DNS-over-HTTPS
+// upstreams.  The address has the following format:
+//
+//	odoh://target.example/dns-query?relay=https://relay.example/proxy
+//
+// The relay parameter is optional.  Without it, the encrypted queries are sent
+// to the target directly, which only hides their contents from the
+// intermediaries.
+const odohScheme = "odoh://"
+
+// odohContentType is the media type of the ODoH messages, see RFC 9230,
+// Section 4.1.
+const odohContentType = "application/oblivious-dns-message"
+
+// odohConfigsPath is the well-known path of the ODoH target configurations,
+// see RFC 9230, Section 6.3.
+const odohConfigsPath = "/.well-known/odohconfigs"
+
+// odohConfigVersion is the supported version of the ODoH configuration.
+const odohConfigVersion uint16 = 0x0001
+
+// ODoH message types, see RFC 9230, Section 6.1.
+const (
+	odohMsgTypeQuery    byte = 0x01
+	odohMsgTypeResponse byte = 0x02
+)
+
+// odohResponseNonceLen is the length of the response nonce, which is
+// max(Nn, Nk) per RFC 9230, Section 6.4.
+const odohResponseNonceLen = hpkeNk
+
+// odohPaddingBlock is the block size the plaintext queries are padded to, see
+// RFC 8467.
+const odohPaddingBlock = 128
+
+// odohConfigTTL is the time after which the target configuration is fetched
+// again.
+const odohConfigTTL = 1 * time.Hour
+
+// odohMaxMsgSize is the maximum size of the HTTP responses read from the
+// target and the relay.
+const odohMaxMsgSize = 64 * 1024
+
+// odohTargetConfig is the parsed ODoH configuration of the target.
+type odohTargetConfig struct {
+	// pk is the public key of the target.
+	pk *ecdh.PublicKey
+
+	// keyID is the identifier of the key, derived from the configuration
+	// contents.
+	keyID []byte
+
+	// expire is the time when the configuration should be fetched again.
+	expire time.Time
+}
+
+// odohUpstream is an [upstream.Upstream] that sends queries to an Oblivious
+// DNS-over-HTTPS target, optionally through a relay, see RFC 9230.
+type odohUpstream struct {
+	// client is used to fetch the target configuration and to send the
+	// queries.
+	client *http.Client
+
+	// target is the URL of the target's DNS endpoint.
+	target *url.URL
+
+	// relay is the URL of the relay.  It's nil if queries are sent to the
+	// target directly.
+	relay *url.URL
+
+	// confMu protects conf.
+	confMu *sync.Mutex
+
+	// conf is the cached configuration of the target.
+	conf *odohTargetConfig
+
+	// addr is the address the upstream has been created from.
+	addr string
+
+	// closers close the bootstrap resolvers.
+	closers []io.Closer
+}
+
+// type check
+var _ upstream.Upstream = (*odohUpstream)(nil)
+
+// newODoHUpstream creates a new ODoH upstream from addr, which must have the
+// [odohScheme] prefix.
+func newODoHUpstream(addr string, opts *upstream.Options) (u *odohUpstream, err error) {
+	target, relay, err := parseODoHAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &upstream.Options{}
+	}
+
+	u = &odohUpstream{
+		target: target,
+		relay:  relay,
+		confMu: &sync.Mutex{},
+		addr:   addr,
+	}
+
+	var resolvers []upstream.Resolver
+	resolvers, u.closers, err = newBootstrapResolvers(opts)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	u.client = newODoHClient(opts, resolvers)
+
+	return u, nil
+}
+
+// parseODoHAddr parses the ODoH upstream address into the target and the relay
+// URLs.
+func parseODoHAddr(addr string) (target, relay *url.URL, err error) {
+	defer func() { err = errors.Annotate(err, "parsing odoh address %q: %w", addr) }()
+
+	target, err = url.Parse("https://" + strings.TrimPrefix(addr, odohScheme))
+	if err != nil {
+		return nil, nil, err
+	} else if target.Host == "" {
+		return nil, nil, errors.Error("no target host")
+	}
+
+	q := target.Query()
+	relayAddr := q.Get("relay")
+	q.Del("relay")
+	target.RawQuery = q.Encode()
+
+	if target.Path == "" {
+		target.Path = "/dns-query"
+	}
+
+	if relayAddr == "" {
+		return target, nil, nil
+	}
+
+	relay, err = url.Parse(relayAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("relay: %w", err)
+	} else if relay.Scheme != "https" || relay.Host == "" {
+		return nil, nil, fmt.Errorf("relay: %w", errors.Error("must be an https url"))
+	}
+
+	return target, relay, nil
+}
+
+// newODoHClient returns an HTTP client for the ODoH upstream using the TLS
+// settings from opts and resolving the hostnames using resolvers.
+func newODoHClient(opts *upstream.Options, resolvers []upstream.Resolver) (c *http.Client) {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: newBootstrapDialFunc(opts, resolvers),
+			TLSClientConfig: &tls.Config{
+				RootCAs:               opts.RootCAs,
+				CipherSuites:          opts.CipherSuites,
+				VerifyPeerCertificate: opts.VerifyServerCertificate,
+				VerifyConnection:      opts.VerifyConnection,
+				InsecureSkipVerify:    opts.InsecureSkipVerify,
+				MinVersion:            tls.VersionTLS12,
+			},
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   5 * time.Minute,
+		},
+		Timeout: opts.Timeout,
+	}
+}
+
+// Address implements the [upstream.Upstream] interface for *odohUpstream.
+func (u *odohUpstream) Address() (addr string) {
+	return u.addr
+}
+
+// Exchange implements the [upstream.Upstream] interface for *odohUpstream.
+func (u *odohUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	defer func() { err = errors.Annotate(err, "odoh %s: %w", u.addr) }()
+
+	conf, err := u.targetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("getting target config: %w", err)
+	}
+
+	// Use zero ID as recommended for DNS-over-HTTPS by RFC 8484.
+	id := req.Id
+	req.Id = 0
+	packed, err := req.Pack()
+	req.Id = id
+	if err != nil {
+		return nil, fmt.Errorf("packing request: %w", err)
+	}
+
+	plainQuery := packODoHPlaintext(packed)
+	enc, hctx, err := hpkeSetupBaseS(conf.pk, []byte("odoh query"))
+	if err != nil {
+		return nil, fmt.Errorf("setting up encryption: %w", err)
+	}
+
+	aad := odohAAD(odohMsgTypeQuery, conf.keyID)
+	encrypted := append(enc, hctx.seal(aad, plainQuery)...)
+	msg := packODoHMessage(odohMsgTypeQuery, conf.keyID, encrypted)
+
+	respMsg, err := u.post(msg)
+	if err != nil {
+		// The target may have rotated its key, so fetch the configuration
+		// again next time.
+		u.resetTargetConfig()
+
+		return nil, err
+	}
+
+	packedResp, err := openODoHResponse(hctx, plainQuery, respMsg)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting response: %w", err)
+	}
+
+	resp = &dns.Msg{}
+	err = resp.Unpack(packedResp)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking response: %w", err)
+	}
+
+	resp.Id = id
+
+	return resp, nil
+}
+
+// Close implements the [upstream.Upstream] interface for *odohUpstream.
+func (u *odohUpstream) Close() (err error) {
+	if u.client != nil {
+		u.client.CloseIdleConnections()
+	}
+
+	var errs []error
+	for _, c := range u.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Annotate(errors.Join(errs...), "closing odoh bootstrap: %w")
+}
+
+// post sends the encrypted query msg either to the relay or to the target
+// and returns the encrypted response.
+func (u *odohUpstream) post(msg []byte) (respMsg []byte, err error) {
+	endpoint := *u.target
+	if u.relay != nil {
+		endpoint = *u.relay
+		q := endpoint.Query()
+		q.Set("targethost", u.target.Host)
+		q.Set("targetpath", u.target.Path)
+		endpoint.RawQuery = q.Encode()
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewReader(msg))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	httpReq.Header.Set(httphdr.ContentType, odohContentType)
+	httpReq.Header.Set(httphdr.Accept, odohContentType)
+
+	return u.do(httpReq)
+}
+
+// do performs httpReq and returns the body of a successful response.
+func (u *odohUpstream) do(httpReq *http.Request) (body []byte, err error) {
+	httpResp, err := u.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", httpReq.URL.Host, err)
+	}
+	defer func() { err = errors.WithDeferred(err, httpResp.Body.Close()) }()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"requesting %s: got status code %d, want %d",
+			httpReq.URL.Host,
+			httpResp.StatusCode,
+			http.StatusOK,
+		)
+	}
+
+	body, err = io.ReadAll(io.LimitReader(httpResp.Body, odohMaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	return body, nil
+}
+
+// targetConfig returns the cached configuration of the target or fetches it
+// if it's absent or expired.
+func (u *odohUpstream) targetConfig() (conf *odohTargetConfig, err error) {
+	u.confMu.Lock()
+	defer u.confMu.Unlock()
+
+	if u.conf != nil && time.Now().Before(u.conf.expire) {
+		return u.conf, nil
+	}
+
+	confURL := &url.URL{
+		Scheme: "https",
+		Host:   u.target.Host,
+		Path:   odohConfigsPath,
+	}
+
+	httpReq, err := http.NewRequest(http.MethodGet, confURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	body, err := u.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err = parseODoHConfigs(body)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.expire = time.Now().Add(odohConfigTTL)
+	u.conf = conf
+
+	return conf, nil
+}
+
+// resetTargetConfig removes the cached target configuration.
+func (u *odohUpstream) resetTargetConfig() {
+	u.confMu.Lock()
+	defer u.confMu.Unlock()
+
+	u.conf = nil
+}
+
+// errODoHNoConfig is returned when the target provides no configuration
+// supported by the upstream.
+const errODoHNoConfig errors.Error = "no supported odoh config"
+
+// parseODoHConfigs parses the ObliviousDoHConfigs structure and returns the
+// first supported configuration from it, see RFC 9230, Section 6.
+func parseODoHConfigs(data []byte) (conf *odohTargetConfig, err error) {
+	configs, _, err := readU16Prefixed(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading configs: %w", err)
+	}
+
+	for len(configs) > 0 {
+		if len(configs) < 2 {
+			return nil, errors.Error("truncated config")
+		}
+
+		version := binary.BigEndian.Uint16(configs)
+
+		var contents []byte
+		contents, configs, err = readU16Prefixed(configs[2:])
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+
+		if version != odohConfigVersion {
+			continue
+		}
+
+		conf, err = parseODoHConfigContents(contents)
+		if err != nil {
+			log.Debug("dnsforward: skipping odoh config: %s", err)
+
+			continue
+		}
+
+		return conf, nil
+	}
+
+	return nil, errODoHNoConfig
+}
+
+// parseODoHConfigContents parses the ObliviousDoHConfigContents structure.
+func parseODoHConfigContents(contents []byte) (conf *odohTargetConfig, err error) {
+	if len(contents) < 6 {
+		return nil, errors.Error("truncated config contents")
+	}
+
+	kem := binary.BigEndian.Uint16(contents)
+	kdf := binary.BigEndian.Uint16(contents[2:])
+	aead := binary.BigEndian.Uint16(contents[4:])
+	if kem != hpkeKEMX25519HKDFSHA256 || kdf != hpkeKDFHKDFSHA256 || aead != hpkeAEADAES128GCM {
+		return nil, fmt.Errorf("unsupported suite %#04x/%#04x/%#04x", kem, kdf, aead)
+	}
+
+	pkData, _, err := readU16Prefixed(contents[6:])
+	if err != nil {
+		return nil, fmt.Errorf("reading public key: %w", err)
+	}
+
+	pk, err := ecdh.X25519().NewPublicKey(pkData)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	keyID, err := odohKeyID(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &odohTargetConfig{
+		pk:    pk,
+		keyID: keyID,
+	}, nil
+}
+
+// odohKeyID returns the identifier of the key from the configuration contents,
+// see RFC 9230, Section 6.2.
+func odohKeyID(contents []byte) (keyID []byte, err error) {
+	prk := hkdf.Extract(sha256.New, contents, nil)
+	keyID = make([]byte, hpkeNh)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("odoh key id")), keyID)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key id: %w", err)
+	}
+
+	return keyID, nil
+}
+
+// readU16Prefixed reads a value prefixed with its two-byte length from data and
+// returns it with the rest of data.
+func readU16Prefixed(data []byte) (val, rest []byte, err error) {
+	if len(data) < 2 {
+		return nil, nil, errors.Error("no length")
+	}
+
+	l := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < l {
+		return nil, nil, fmt.Errorf("length %d is greater than %d bytes left", l, len(data))
+	}
+
+	return data[:l], data[l:], nil
+}
+
+// appendU16Prefixed appends val prefixed with its two-byte length to b.
+func appendU16Prefixed(b, val []byte) (res []byte) {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(val)))
+
+	return append(b, val...)
+}
+
+// packODoHPlaintext returns the ObliviousDoHMessagePlaintext structure with
+// the DNS message packed, padded to [odohPaddingBlock].
+func packODoHPlaintext(packed []byte) (plain []byte) {
+	plain = appendU16Prefixed(nil, packed)
+
+	padLen := 0
+	if rem := (len(plain) + 2) % odohPaddingBlock; rem != 0 {
+		padLen = odohPaddingBlock - rem
+	}
+
+	return appendU16Prefixed(plain, make([]byte, padLen))
+}
+
+// packODoHMessage returns the ObliviousDoHMessage structure.
+func packODoHMessage(msgType byte, keyID, encrypted []byte) (msg []byte) {
+	msg = appendU16Prefixed([]byte{msgType}, keyID)
+
+	return appendU16Prefixed(msg, encrypted)
+}
+
+// unpackODoHMessage parses the ObliviousDoHMessage structure.
+func unpackODoHMessage(msg []byte) (msgType byte, keyID, encrypted []byte, err error) {
+	if len(msg) < 1 {
+		return 0, nil, nil, errors.Error("empty message")
+	}
+
+	msgType = msg[0]
+	keyID, rest, err := readU16Prefixed(msg[1:])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("reading key id: %w", err)
+	}
+
+	encrypted, _, err = readU16Prefixed(rest)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("reading encrypted message: %w", err)
+	}
+
+	return msgType, keyID, encrypted, nil
+}
+
+// odohAAD returns the associated data for the message of msgType with keyID.
+func odohAAD(msgType byte, keyID []byte) (aad []byte) {
+	return appendU16Prefixed([]byte{msgType}, keyID)
+}
+
+// odohResponseAEAD derives the key and the nonce of the response encryption,
+// see RFC 9230, Section 6.4.
+func odohResponseAEAD(
+	hctx *hpkeContext,
+	plainQuery []byte,
+	respNonce []byte,
+) (aead cipher.AEAD, nonce []byte, err error) {
+	secret, err := hctx.export([]byte("odoh response"), hpkeNk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	salt := appendU16Prefixed(append([]byte{}, plainQuery...), respNonce)
+	prk := hkdf.Extract(sha256.New, secret, salt)
+
+	key := make([]byte, hpkeNk)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("odoh key")), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("deriving key: %w", err)
+	}
+
+	nonce = make([]byte, hpkeNn)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("odoh nonce")), nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("deriving nonce: %w", err)
+	}
+
+	aead, err = newAESGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, nonce, nil
+}
+
+// openODoHResponse decrypts the ODoH response message to the query plainQuery
+// encrypted within hctx and returns the packed DNS message.
+func openODoHResponse(hctx *hpkeContext, plainQuery, msg []byte) (packed []byte, err error) {
+	msgType, respNonce, encrypted, err := unpackODoHMessage(msg)
+	if err != nil {
+		return nil, err
+	} else if msgType != odohMsgTypeResponse {
+		return nil, fmt.Errorf("unexpected message type %#02x", msgType)
+	}
+
+	aead, nonce, err := odohResponseAEAD(hctx, plainQuery, respNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := aead.Open(nil, nonce, encrypted, odohAAD(odohMsgTypeResponse, respNonce))
+	if err != nil {
+		return nil, fmt.Errorf("opening: %w", err)
+	}
+
+	packed, _, err = readU16Prefixed(plain)
+	if err != nil {
+		return nil, fmt.Errorf("reading dns message: %w", err)
+	}
+
+	return packed, nil
+}
+
+// addressToUpstream creates an upstream from addr, which may also be an ODoH
+// address.
+func addressToUpstream(addr string, opts *upstream.Options) (u upstream.Upstream, err error) {
+	if strings.HasPrefix(addr, odohScheme) {
+		return newODoHUpstream(addr, opts)
+	}
+
+	return upstream.AddressToUpstream(addr, opts)
+}
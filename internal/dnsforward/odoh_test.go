@@ -0,0 +1,280 @@
+package dnsforward
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestODoHTarget starts a new ODoH target, which responds to A queries with
+// ip.
+func newTestODoHTarget(t *testing.T, ip net.IP) (srv *httptest.Server) {
+	t.Helper()
+
+	sk, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	contents := binary.BigEndian.AppendUint16(nil, hpkeKEMX25519HKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKDFHKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeAEADAES128GCM)
+	contents = appendU16Prefixed(contents, sk.PublicKey().Bytes())
+
+	conf := binary.BigEndian.AppendUint16(nil, odohConfigVersion)
+	conf = appendU16Prefixed(conf, contents)
+	configs := appendU16Prefixed(nil, conf)
+
+	keyID, err := odohKeyID(contents)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(odohConfigsPath, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(configs)
+	})
+	mux.HandleFunc("/dns-query", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(testutil.PanicT{}, odohContentType, r.Header.Get(httphdr.ContentType))
+
+		body, rErr := io.ReadAll(r.Body)
+		require.NoError(testutil.PanicT{}, rErr)
+
+		msgType, gotKeyID, encrypted, rErr := unpackODoHMessage(body)
+		require.NoError(testutil.PanicT{}, rErr)
+		require.Equal(testutil.PanicT{}, odohMsgTypeQuery, msgType)
+		require.Equal(testutil.PanicT{}, keyID, gotKeyID)
+
+		plainQuery, hctx := openTestODoHQuery(t, sk, keyID, encrypted)
+		packed, _, rErr := readU16Prefixed(plainQuery)
+		require.NoError(testutil.PanicT{}, rErr)
+
+		req := &dns.Msg{}
+		require.NoError(testutil.PanicT{}, req.Unpack(packed))
+
+		resp := aghtest.MatchedResponse(req, dns.TypeA, req.Question[0].Name, ip.String())
+		packed, rErr = resp.Pack()
+		require.NoError(testutil.PanicT{}, rErr)
+
+		respNonce := make([]byte, odohResponseNonceLen)
+		_, rErr = rand.Read(respNonce)
+		require.NoError(testutil.PanicT{}, rErr)
+
+		aead, nonce, rErr := odohResponseAEAD(hctx, plainQuery, respNonce)
+		require.NoError(testutil.PanicT{}, rErr)
+
+		aad := odohAAD(odohMsgTypeResponse, respNonce)
+		ct := aead.Seal(nil, nonce, appendU16Prefixed(nil, packed), aad)
+
+		w.Header().Set(httphdr.ContentType, odohContentType)
+		_, _ = w.Write(packODoHMessage(odohMsgTypeResponse, respNonce, ct))
+	})
+
+	srv = httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// openTestODoHQuery decrypts the encrypted query as the target with the private
+// key sk does.
+func openTestODoHQuery(
+	t *testing.T,
+	sk *ecdh.PrivateKey,
+	keyID []byte,
+	encrypted []byte,
+) (plainQuery []byte, hctx *hpkeContext) {
+	t.Helper()
+
+	enc, ct := encrypted[:hpkeNSecret], encrypted[hpkeNSecret:]
+
+	pkE, err := ecdh.X25519().NewPublicKey(enc)
+	require.NoError(testutil.PanicT{}, err)
+
+	dh, err := sk.ECDH(pkE)
+	require.NoError(testutil.PanicT{}, err)
+
+	secret, err := hpkeSharedSecret(dh, enc, sk.PublicKey().Bytes())
+	require.NoError(testutil.PanicT{}, err)
+
+	hctx, err = newHPKEContext(secret, []byte("odoh query"))
+	require.NoError(testutil.PanicT{}, err)
+
+	plainQuery, err = hctx.aead.Open(nil, hctx.baseNonce, ct, odohAAD(odohMsgTypeQuery, keyID))
+	require.NoError(testutil.PanicT{}, err)
+
+	return plainQuery, hctx
+}
+
+// newTestODoHRelay starts a new ODoH relay forwarding the queries to target.
+func newTestODoHRelay(t *testing.T, target *httptest.Server) (srv *httptest.Server, hits *atomic.Uint32) {
+	t.Helper()
+
+	hits = &atomic.Uint32{}
+	client := target.Client()
+
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+
+		q := r.URL.Query()
+		u := &url.URL{
+			Scheme: "https",
+			Host:   q.Get("targethost"),
+			Path:   q.Get("targetpath"),
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(testutil.PanicT{}, err)
+
+		resp, err := client.Post(u.String(), odohContentType, bytes.NewReader(body))
+		require.NoError(testutil.PanicT{}, err)
+		defer func() { _ = resp.Body.Close() }()
+
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, hits
+}
+
+func TestODoHUpstream_Exchange(t *testing.T) {
+	const host = "example.org."
+
+	ip := net.IP{1, 2, 3, 4}
+	target := newTestODoHTarget(t, ip)
+	relay, hits := newTestODoHRelay(t, target)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(target.Certificate())
+	roots.AddCert(relay.Certificate())
+
+	targetAddr := odohScheme + target.Listener.Addr().String() + "/dns-query"
+
+	testCases := []struct {
+		name     string
+		addr     string
+		wantHits uint32
+	}{{
+		name:     "direct",
+		addr:     targetAddr,
+		wantHits: 0,
+	}, {
+		name:     "relay",
+		addr:     targetAddr + "?relay=" + relay.URL + "/proxy",
+		wantHits: 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hits.Store(0)
+
+			u, err := newODoHUpstream(tc.addr, &upstream.Options{
+				RootCAs: roots,
+				Timeout: testTimeout,
+			})
+			require.NoError(t, err)
+			testutil.CleanupAndRequireSuccess(t, u.Close)
+
+			req := (&dns.Msg{}).SetQuestion(host, dns.TypeA)
+			resp, err := u.Exchange(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, req.Id, resp.Id)
+			require.Len(t, resp.Answer, 1)
+
+			a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+			assert.Equal(t, ip, a.A.To4())
+			assert.Equal(t, tc.wantHits, hits.Load())
+		})
+	}
+}
+
+func TestParseUpstreamsConfig_odoh(t *testing.T) {
+	const odohAddr = "odoh://odoh.example/dns-query?relay=https://relay.example/proxy"
+
+	uc, err := ParseUpstreamsConfig([]string{
+		"1.1.1.1",
+		odohAddr,
+		"[/example.org/]" + odohAddr,
+		"[/*.example.com/]" + odohAddr,
+	}, &upstream.Options{})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	require.Len(t, uc.Upstreams, 2)
+	assert.Equal(t, "1.1.1.1:53", uc.Upstreams[0].Address())
+	assert.Equal(t, odohAddr, uc.Upstreams[1].Address())
+
+	require.Len(t, uc.SpecifiedDomainUpstreams["example.org."], 1)
+	assert.Same(t, uc.Upstreams[1], uc.SpecifiedDomainUpstreams["example.org."][0])
+
+	require.Len(t, uc.DomainReservedUpstreams["example.com."], 1)
+	assert.True(t, uc.SubdomainExclusions.Has("example.com."))
+	assert.NotContains(t, uc.SpecifiedDomainUpstreams, "example.com.")
+}
+
+func TestParseODoHAddr(t *testing.T) {
+	testCases := []struct {
+		name       string
+		addr       string
+		wantTarget string
+		wantRelay  string
+		wantErrMsg string
+	}{{
+		name:       "relay",
+		addr:       "odoh://odoh.example/query?relay=https://relay.example/proxy",
+		wantTarget: "https://odoh.example/query",
+		wantRelay:  "https://relay.example/proxy",
+		wantErrMsg: "",
+	}, {
+		name:       "default_path",
+		addr:       "odoh://odoh.example",
+		wantTarget: "https://odoh.example/dns-query",
+		wantRelay:  "",
+		wantErrMsg: "",
+	}, {
+		name:       "bad_relay",
+		addr:       "odoh://odoh.example/dns-query?relay=http://relay.example",
+		wantTarget: "",
+		wantRelay:  "",
+		wantErrMsg: `parsing odoh address "odoh://odoh.example/dns-query?relay=http://relay.example": ` +
+			`relay: must be an https url`,
+	}, {
+		name:       "no_host",
+		addr:       "odoh:///dns-query",
+		wantTarget: "",
+		wantRelay:  "",
+		wantErrMsg: `parsing odoh address "odoh:///dns-query": no target host`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target, relay, err := parseODoHAddr(tc.addr)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			assert.Equal(t, tc.wantTarget, target.String())
+			if tc.wantRelay == "" {
+				assert.Nil(t, relay)
+			} else {
+				assert.Equal(t, tc.wantRelay, relay.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,625 @@
+package dnsforward
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/miekg/dns"
+)
+
+// Prefixes of the addresses of the upstreams, which use the pipelined
+// connections, if enabled.
+const (
+	pipelineTCPScheme = "tcp://"
+	pipelineTLSScheme = "tls://"
+)
+
+// pipelineMaxConns is the maximum number of the connections to a single
+// upstream.
+const pipelineMaxConns = 4
+
+// pipelineMaxInFlight is the number of the queries in flight on a single
+// connection, after which another connection is opened, unless there are
+// already [pipelineMaxConns] of them.
+const pipelineMaxInFlight = 100
+
+// pipelineIdleTimeout is the duration, after which a connection without any
+// responses is closed.  See RFC 7766, Section 6.2.1.
+const pipelineIdleTimeout = 30 * time.Second
+
+// defaultPipelineTimeout is the timeout of a single query, if the upstream
+// options don't specify one.
+const defaultPipelineTimeout = 10 * time.Second
+
+// errPipelineConnClosed is returned when the connection has been closed before
+// the response has been received.
+const errPipelineConnClosed errors.Error = "connection closed"
+
+// errPipelineClosed is returned when the upstream has already been closed.
+const errPipelineClosed errors.Error = "upstream closed"
+
+// isPipelineAddr returns true if the upstream with addr can use the pipelined
+// connections.
+func isPipelineAddr(addr string) (ok bool) {
+	return strings.HasPrefix(addr, pipelineTCPScheme) || strings.HasPrefix(addr, pipelineTLSScheme)
+}
+
+// pipelineUpstream is an [upstream.Upstream] that keeps a pool of the
+// persistent DNS-over-TCP or DNS-over-TLS connections and sends the queries
+// over them without waiting for the previous responses, processing the
+// responses out of order.  See RFC 7766, Section 6.2.1.1 and RFC 7858, Section
+// 3.3.
+type pipelineUpstream struct {
+	// stats collects the statistics of the connections.  It must not be nil.
+	stats *pipelineStats
+
+	// tlsConf is the TLS configuration of the connections.  It's nil for the
+	// plain TCP upstreams.
+	tlsConf *tls.Config
+
+	// dial dials the upstream resolving its hostname, if needed.
+	dial func(ctx context.Context, network, addr string) (conn net.Conn, err error)
+
+	// mu protects conns and closed.
+	mu *sync.Mutex
+
+	// dialMu makes the queries wait for the connection being dialed instead of
+	// dialing their own ones.
+	dialMu *sync.Mutex
+
+	// conns are the open connections.
+	conns []*pipelineConn
+
+	// closers close the bootstrap resolvers.
+	closers []io.Closer
+
+	// addr is the address the upstream has been created from.
+	addr string
+
+	// hostPort is the address to dial.
+	hostPort string
+
+	// timeout is the timeout of a single query.
+	timeout time.Duration
+
+	// closed is true if the upstream has been closed.
+	closed bool
+}
+
+// type check
+var _ upstream.Upstream = (*pipelineUpstream)(nil)
+
+// newPipelineUpstream creates a new pipelined upstream from addr, which must
+// have either the [pipelineTCPScheme] or the [pipelineTLSScheme] prefix.  ps
+// must not be nil.
+func newPipelineUpstream(
+	addr string,
+	opts *upstream.Options,
+	ps *pipelineStats,
+) (u *pipelineUpstream, err error) {
+	parsed, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing address %q: %w", addr, err)
+	}
+
+	host := parsed.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("parsing address %q: %w", addr, errors.Error("no host"))
+	}
+
+	if opts == nil {
+		opts = &upstream.Options{}
+	}
+
+	u = &pipelineUpstream{
+		stats:   ps,
+		mu:      &sync.Mutex{},
+		dialMu:  &sync.Mutex{},
+		addr:    addr,
+		timeout: opts.Timeout,
+	}
+
+	if u.timeout == 0 {
+		u.timeout = defaultPipelineTimeout
+	}
+
+	port := parsed.Port()
+	if parsed.Scheme == "tls" {
+		if port == "" {
+			port = "853"
+		}
+
+		u.tlsConf = &tls.Config{
+			ServerName:            host,
+			RootCAs:               opts.RootCAs,
+			CipherSuites:          opts.CipherSuites,
+			VerifyPeerCertificate: opts.VerifyServerCertificate,
+			VerifyConnection:      opts.VerifyConnection,
+			InsecureSkipVerify:    opts.InsecureSkipVerify,
+			ClientSessionCache:    tls.NewLRUClientSessionCache(pipelineMaxConns),
+			MinVersion:            tls.VersionTLS12,
+		}
+	} else if port == "" {
+		port = "53"
+	}
+
+	u.hostPort = net.JoinHostPort(host, port)
+
+	var resolvers []upstream.Resolver
+	if len(opts.ServerIPAddrs) > 0 {
+		resolvers = []upstream.Resolver{newStaticResolver(opts.ServerIPAddrs)}
+	} else {
+		resolvers, u.closers, err = newBootstrapResolvers(opts)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+	}
+
+	u.dial = newBootstrapDialFunc(opts, resolvers)
+
+	return u, nil
+}
+
+// Address implements the [upstream.Upstream] interface for *pipelineUpstream.
+func (u *pipelineUpstream) Address() (addr string) {
+	return u.addr
+}
+
+// Exchange implements the [upstream.Upstream] interface for *pipelineUpstream.
+// The query failed because the reused connection has been closed by the
+// upstream is retried once on another connection.
+func (u *pipelineUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	defer func() { err = errors.Annotate(err, "pipeline %s: %w", u.addr) }()
+
+	for retried := false; ; retried = true {
+		var c *pipelineConn
+		var reused bool
+		c, reused, err = u.conn()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = c.exchange(req, u.timeout)
+		if err == nil || !reused || retried || !errors.Is(err, errPipelineConnClosed) {
+			return resp, err
+		}
+
+		log.Debug("pipeline %s: retrying on another connection: %s", u.addr, err)
+	}
+}
+
+// conn returns the connection to send the next query over.  reused is true if
+// the connection has already been used.
+func (u *pipelineUpstream) conn() (c *pipelineConn, reused bool, err error) {
+	c, err = u.leastLoaded(false)
+	if c != nil || err != nil {
+		return c, c != nil, err
+	}
+
+	u.dialMu.Lock()
+	defer u.dialMu.Unlock()
+
+	// Another query could have dialed a connection while this one has been
+	// waiting.
+	c, err = u.leastLoaded(false)
+	if c != nil || err != nil {
+		return c, c != nil, err
+	}
+
+	c, err = u.dialConn()
+	if err == nil {
+		return c, false, nil
+	}
+
+	// Use any connection left, if the limit has been reached, or fail.
+	c, _ = u.leastLoaded(true)
+	if c != nil {
+		return c, true, nil
+	}
+
+	return nil, false, err
+}
+
+// leastLoaded returns the open connection with the least number of the queries
+// in flight.  c is nil, if there are no connections or if all of them are
+// loaded and a new one can be opened, unless force is true.
+func (u *pipelineUpstream) leastLoaded(force bool) (c *pipelineConn, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return nil, errPipelineClosed
+	}
+
+	minInFlight := -1
+	for _, conn := range u.conns {
+		n := conn.inFlight()
+		if minInFlight < 0 || n < minInFlight {
+			c, minInFlight = conn, n
+		}
+	}
+
+	if !force && minInFlight >= pipelineMaxInFlight && len(u.conns) < pipelineMaxConns {
+		return nil, nil
+	}
+
+	return c, nil
+}
+
+// dialConn opens a new connection to the upstream and adds it to the pool.
+func (u *pipelineUpstream) dialConn() (c *pipelineConn, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
+	defer cancel()
+
+	conn, err := u.dial(ctx, "tcp", u.hostPort)
+	if err != nil {
+		u.stats.update(u.addr, func(us *pipelineUpstreamStats) { us.DialErrors++ })
+
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+
+	if u.tlsConf != nil {
+		tlsConn := tls.Client(conn, u.tlsConf)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			u.stats.update(u.addr, func(us *pipelineUpstreamStats) { us.DialErrors++ })
+
+			return nil, errors.WithDeferred(fmt.Errorf("tls handshake: %w", err), conn.Close())
+		}
+
+		conn = tlsConn
+	}
+
+	c = &pipelineConn{
+		stats:   u.stats,
+		conn:    conn,
+		writeMu: &sync.Mutex{},
+		mu:      &sync.Mutex{},
+		pending: map[uint16]chan *pipelineResult{},
+		addr:    u.addr,
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return nil, errors.WithDeferred(errPipelineClosed, conn.Close())
+	}
+
+	u.conns = append(u.conns, c)
+	u.stats.update(u.addr, func(us *pipelineUpstreamStats) {
+		us.Connections++
+		us.Open++
+		us.RemoteAddr = conn.RemoteAddr().String()
+	})
+
+	go u.readLoop(c)
+
+	return c, nil
+}
+
+// readLoop reads the responses from c and delivers them to the waiting
+// queries until c is closed.
+func (u *pipelineUpstream) readLoop(c *pipelineConn) {
+	defer log.OnPanic("pipeline " + u.addr)
+
+	dc := &dns.Conn{Conn: c.conn}
+	for {
+		err := c.conn.SetReadDeadline(time.Now().Add(pipelineIdleTimeout))
+		if err != nil {
+			u.closeConn(c, err)
+
+			return
+		}
+
+		var resp *dns.Msg
+		resp, err = dc.ReadMsg()
+		if err != nil {
+			u.closeConn(c, err)
+
+			return
+		}
+
+		if !c.deliver(resp) {
+			log.Debug("pipeline %s: unexpected response with id %d", u.addr, resp.Id)
+		}
+	}
+}
+
+// closeConn removes c from the pool and fails the queries in flight on it with
+// cause.
+func (u *pipelineUpstream) closeConn(c *pipelineConn, cause error) {
+	isIdle := c.fail(cause)
+	if closeErr := c.conn.Close(); closeErr != nil {
+		log.Debug("pipeline %s: closing connection: %s", u.addr, closeErr)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for i, conn := range u.conns {
+		if conn == c {
+			u.conns = append(u.conns[:i], u.conns[i+1:]...)
+
+			break
+		}
+	}
+
+	u.stats.update(u.addr, func(us *pipelineUpstreamStats) {
+		us.Open--
+		if isIdle {
+			us.IdleClosed++
+		}
+	})
+}
+
+// Close implements the [upstream.Upstream] interface for *pipelineUpstream.
+func (u *pipelineUpstream) Close() (err error) {
+	u.mu.Lock()
+	u.closed = true
+	conns := u.conns
+	u.mu.Unlock()
+
+	var errs []error
+	for _, c := range conns {
+		// The read loops remove the connections from the pool.
+		errs = append(errs, c.conn.Close())
+	}
+
+	for _, c := range u.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Annotate(errors.Join(errs...), "closing pipeline %s: %w", u.addr)
+}
+
+// pipelineResult is the result of a single query sent over a pipelined
+// connection.
+type pipelineResult struct {
+	// resp is the response, if err is nil.
+	resp *dns.Msg
+
+	// err is the error of the connection.
+	err error
+}
+
+// pipelineConn is a single pipelined connection to the upstream.
+type pipelineConn struct {
+	// stats collects the statistics of the connections.
+	stats *pipelineStats
+
+	// conn is the underlying connection.
+	conn net.Conn
+
+	// writeMu serializes the writes to conn.
+	writeMu *sync.Mutex
+
+	// mu protects pending and err.
+	mu *sync.Mutex
+
+	// pending are the channels of the queries in flight by their IDs.
+	pending map[uint16]chan *pipelineResult
+
+	// err is the error, with which the connection has been closed.
+	err error
+
+	// addr is the address of the upstream.
+	addr string
+}
+
+// inFlight returns the number of the queries in flight on c.
+func (c *pipelineConn) inFlight() (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.pending)
+}
+
+// exchange sends req over c and waits for the response for the timeout.
+func (c *pipelineConn) exchange(req *dns.Msg, timeout time.Duration) (resp *dns.Msg, err error) {
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("packing request: %w", err)
+	}
+
+	ch := make(chan *pipelineResult, 1)
+	id, inFlight, err := c.register(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.unregister(id)
+
+	c.stats.update(c.addr, func(us *pipelineUpstreamStats) {
+		us.Queries++
+		if inFlight > 0 {
+			us.Pipelined++
+		}
+
+		us.MaxInFlight = mathutil.Max(us.MaxInFlight, uint64(inFlight+1))
+	})
+
+	// Use the connection-unique ID instead of the original one, since the
+	// responses are matched by it, and don't modify req, since it may be used
+	// by other upstreams concurrently.
+	binary.BigEndian.PutUint16(packed, id)
+
+	err = c.write(packed, timeout)
+	if err != nil {
+		// The message may have been written partially, so the connection
+		// can't be used anymore.  The read loop removes it from the pool.
+		if closeErr := c.conn.Close(); closeErr != nil {
+			log.Debug("pipeline %s: closing connection: %s", c.addr, closeErr)
+		}
+
+		return nil, fmt.Errorf("%w: writing: %w", errPipelineConnClosed, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var res *pipelineResult
+	select {
+	case res = <-ch:
+	case <-timer.C:
+		c.stats.update(c.addr, func(us *pipelineUpstreamStats) { us.Timeouts++ })
+
+		return nil, fmt.Errorf("waiting for response: %w", context.DeadlineExceeded)
+	}
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	resp = res.resp
+	resp.Id = req.Id
+	if !isSameQuestion(req, resp) {
+		return nil, fmt.Errorf("response: %w", dns.ErrId)
+	}
+
+	return resp, nil
+}
+
+// register adds ch to the pending queries and returns the ID for the query
+// and the number of the other queries in flight.
+func (c *pipelineConn) register(ch chan *pipelineResult) (id uint16, inFlight int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, 0, fmt.Errorf("%w: %w", errPipelineConnClosed, c.err)
+	}
+
+	for {
+		// #nosec G404 -- The IDs only need to be unique within the connection,
+		// which is protected by TLS, if needed.
+		id = uint16(rand.Uint32())
+		if _, ok := c.pending[id]; !ok {
+			break
+		}
+	}
+
+	inFlight = len(c.pending)
+	c.pending[id] = ch
+
+	return id, inFlight, nil
+}
+
+// unregister removes the query with id from the pending ones.
+func (c *pipelineConn) unregister(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// write writes the message msg prefixed with its length to c.
+func (c *pipelineConn) write(msg []byte, timeout time.Duration) (err error) {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	err = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(buf)
+
+	return err
+}
+
+// deliver sends resp to the query waiting for it and returns true, if there is
+// one.
+func (c *pipelineConn) deliver(resp *dns.Msg) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.pending[resp.Id]
+	if ok {
+		delete(c.pending, resp.Id)
+		ch <- &pipelineResult{resp: resp}
+	}
+
+	return ok
+}
+
+// fail marks c as closed with cause and fails the queries in flight.  isIdle
+// is true if there have been no queries in flight.
+func (c *pipelineConn) fail(cause error) (isIdle bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = cause
+	isIdle = len(c.pending) == 0
+
+	err := fmt.Errorf("%w: %w", errPipelineConnClosed, cause)
+	for id, ch := range c.pending {
+		delete(c.pending, id)
+		ch <- &pipelineResult{err: err}
+	}
+
+	return isIdle
+}
+
+// isSameQuestion returns true if resp answers the question of req.
+func isSameQuestion(req, resp *dns.Msg) (ok bool) {
+	if len(req.Question) != len(resp.Question) {
+		return false
+	}
+
+	for i, q := range req.Question {
+		rq := resp.Question[i]
+		if q.Qtype != rq.Qtype || q.Qclass != rq.Qclass || !strings.EqualFold(q.Name, rq.Name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// staticResolver is an [upstream.Resolver] returning the same addresses for
+// any host.
+type staticResolver []netip.Addr
+
+// newStaticResolver returns a resolver returning ips.
+func newStaticResolver(ips []net.IP) (r staticResolver) {
+	r = make(staticResolver, 0, len(ips))
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			r = append(r, addr.Unmap())
+		}
+	}
+
+	return r
+}
+
+// type check
+var _ upstream.Resolver = staticResolver(nil)
+
+// LookupNetIP implements the [upstream.Resolver] interface for staticResolver.
+func (r staticResolver) LookupNetIP(
+	_ context.Context,
+	_ string,
+	_ string,
+) (addrs []netip.Addr, err error) {
+	return r, nil
+}
@@ -0,0 +1,208 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPipelineServer starts a new TCP listener serving each accepted
+// connection with handle and returns its address.
+func newTestPipelineServer(t *testing.T, handle func(dc *dns.Conn)) (addr string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, l.Close)
+
+	go func() {
+		for {
+			conn, acceptErr := l.Accept()
+			if acceptErr != nil {
+				return
+			}
+
+			go func() {
+				defer func() { _ = conn.Close() }()
+
+				handle(&dns.Conn{Conn: conn})
+			}()
+		}
+	}()
+
+	return "tcp://" + l.Addr().String()
+}
+
+// testPipelineIPs are the addresses returned for the test hostnames.
+var testPipelineIPs = map[string]netip.Addr{
+	"first.example.":  netip.MustParseAddr("192.0.2.1"),
+	"second.example.": netip.MustParseAddr("192.0.2.2"),
+}
+
+// newTestPipelineResp returns the response to req with the A record from
+// [testPipelineIPs].
+func newTestPipelineResp(req *dns.Msg) (resp *dns.Msg) {
+	name := req.Question[0].Name
+
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		A: testPipelineIPs[name].AsSlice(),
+	})
+
+	return resp
+}
+
+// exchangeAsync sends the A query for name to u in a separate goroutine.
+func exchangeAsync(
+	wg *sync.WaitGroup,
+	u upstream.Upstream,
+	name string,
+) (resp **dns.Msg, err *error) {
+	resp, err = new(*dns.Msg), new(error)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		req := (&dns.Msg{}).SetQuestion(name, dns.TypeA)
+		*resp, *err = u.Exchange(req)
+	}()
+
+	return resp, err
+}
+
+func TestPipelineUpstream_Exchange(t *testing.T) {
+	opts := &upstream.Options{
+		Timeout: time.Second,
+	}
+
+	t.Run("out_of_order", func(t *testing.T) {
+		// Respond only after both queries have been received and in the
+		// reverse order.
+		addr := newTestPipelineServer(t, func(dc *dns.Conn) {
+			var reqs []*dns.Msg
+			for range testPipelineIPs {
+				req, err := dc.ReadMsg()
+				if err != nil {
+					return
+				}
+
+				reqs = append(reqs, req)
+			}
+
+			for i := len(reqs) - 1; i >= 0; i-- {
+				_ = dc.WriteMsg(newTestPipelineResp(reqs[i]))
+			}
+
+			_, _ = dc.ReadMsg()
+		})
+
+		ps := newPipelineStats()
+		u, err := newPipelineUpstream(addr, opts, ps)
+		require.NoError(t, err)
+		testutil.CleanupAndRequireSuccess(t, u.Close)
+
+		wg := &sync.WaitGroup{}
+		firstResp, firstErr := exchangeAsync(wg, u, "first.example.")
+		secondResp, secondErr := exchangeAsync(wg, u, "second.example.")
+		wg.Wait()
+
+		require.NoError(t, *firstErr)
+		require.NoError(t, *secondErr)
+
+		for name, resp := range map[string]*dns.Msg{
+			"first.example.":  *firstResp,
+			"second.example.": *secondResp,
+		} {
+			require.Len(t, resp.Answer, 1)
+
+			a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+			assert.Equal(t, testPipelineIPs[name].AsSlice(), []byte(a.A.To4()))
+		}
+
+		stats := ps.list()
+		require.Len(t, stats, 1)
+
+		assert.Equal(t, uint64(1), stats[0].Connections)
+		assert.Equal(t, uint64(1), stats[0].Open)
+		assert.Equal(t, uint64(2), stats[0].Queries)
+		assert.Equal(t, uint64(1), stats[0].Pipelined)
+		assert.Equal(t, uint64(2), stats[0].MaxInFlight)
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		// Close each connection after the first response.
+		addr := newTestPipelineServer(t, func(dc *dns.Conn) {
+			req, err := dc.ReadMsg()
+			if err != nil {
+				return
+			}
+
+			_ = dc.WriteMsg(newTestPipelineResp(req))
+		})
+
+		ps := newPipelineStats()
+		u, err := newPipelineUpstream(addr, opts, ps)
+		require.NoError(t, err)
+		testutil.CleanupAndRequireSuccess(t, u.Close)
+
+		for name := range testPipelineIPs {
+			var resp *dns.Msg
+			resp, err = u.Exchange((&dns.Msg{}).SetQuestion(name, dns.TypeA))
+			require.NoError(t, err)
+
+			assert.Len(t, resp.Answer, 1)
+		}
+
+		stats := ps.list()
+		require.Len(t, stats, 1)
+
+		assert.Equal(t, uint64(2), stats[0].Connections)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		u, err := newPipelineUpstream("tcp://127.0.0.1:53", opts, newPipelineStats())
+		require.NoError(t, err)
+		require.NoError(t, u.Close())
+
+		_, err = u.Exchange((&dns.Msg{}).SetQuestion("first.example.", dns.TypeA))
+		assert.True(t, errors.Is(err, errPipelineClosed))
+	})
+}
+
+func TestParseUpstreamsConfig_pipeline(t *testing.T) {
+	ps := newPipelineStats()
+	uc, err := parseUpstreamsConfig([]string{
+		"tls://dns.example",
+		"[/local.example/]tcp://192.0.2.1",
+		"udp://192.0.2.2",
+	}, &upstream.Options{}, nil, ps)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	require.Len(t, uc.Upstreams, 2)
+
+	var pipelined []string
+	for _, u := range append(uc.Upstreams, uc.DomainReservedUpstreams["local.example."]...) {
+		if _, ok := u.(*pipelineUpstream); ok {
+			pipelined = append(pipelined, u.Address())
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"tls://dns.example", "tcp://192.0.2.1"}, pipelined)
+}
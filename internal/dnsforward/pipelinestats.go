@@ -0,0 +1,112 @@
+package dnsforward
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// pipelineUpstreamStats are the statistics of the pooled connections to a
+// single DNS-over-TCP or DNS-over-TLS upstream.
+type pipelineUpstreamStats struct {
+	// Address is the address of the upstream.
+	Address string `json:"address"`
+
+	// RemoteAddr is the remote address of the latest connection.
+	RemoteAddr string `json:"remote_addr"`
+
+	// Connections is the number of connections opened.
+	Connections uint64 `json:"connections"`
+
+	// Open is the number of connections currently open.
+	Open uint64 `json:"open"`
+
+	// IdleClosed is the number of connections closed after the idle timeout.
+	IdleClosed uint64 `json:"idle_closed"`
+
+	// DialErrors is the number of failed attempts to open a connection.
+	DialErrors uint64 `json:"dial_errors"`
+
+	// Queries is the number of queries sent.
+	Queries uint64 `json:"queries"`
+
+	// Pipelined is the number of queries sent while other queries have been
+	// in flight on the same connection.
+	Pipelined uint64 `json:"pipelined"`
+
+	// MaxInFlight is the maximum number of queries in flight on a single
+	// connection.
+	MaxInFlight uint64 `json:"max_in_flight"`
+
+	// Timeouts is the number of queries left without a response.
+	Timeouts uint64 `json:"timeouts"`
+}
+
+// pipelineStats collects the statistics of the pooled connections to the
+// pipelined upstreams.  The statistics are kept across the reconfigurations
+// of the server.
+type pipelineStats struct {
+	// mu protects upstreams.
+	mu *sync.Mutex
+
+	// upstreams are the statistics by the upstream address.
+	upstreams map[string]*pipelineUpstreamStats
+}
+
+// newPipelineStats returns a new properly initialized *pipelineStats.
+func newPipelineStats() (s *pipelineStats) {
+	return &pipelineStats{
+		mu:        &sync.Mutex{},
+		upstreams: map[string]*pipelineUpstreamStats{},
+	}
+}
+
+// update calls f with the statistics of the upstream with addr locked.
+func (s *pipelineStats) update(addr string, f func(us *pipelineUpstreamStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	us, ok := s.upstreams[addr]
+	if !ok {
+		us = &pipelineUpstreamStats{
+			Address: addr,
+		}
+		s.upstreams[addr] = us
+	}
+
+	f(us)
+}
+
+// list returns the copies of the statistics sorted by the upstream address.
+func (s *pipelineStats) list() (stats []*pipelineUpstreamStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := maps.Keys(s.upstreams)
+	slices.Sort(addrs)
+
+	stats = make([]*pipelineUpstreamStats, 0, len(addrs))
+	for _, addr := range addrs {
+		us := *s.upstreams[addr]
+		stats = append(stats, &us)
+	}
+
+	return stats
+}
+
+// pipelineStatsJSON is the JSON representation of the statistics of the
+// pipelined upstreams.
+type pipelineStatsJSON struct {
+	Upstreams []*pipelineUpstreamStats `json:"upstreams"`
+}
+
+// handlePipelineStats is the handler for the GET
+// /control/upstreams/pool_stats HTTP API.
+func (s *Server) handlePipelineStats(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &pipelineStatsJSON{
+		Upstreams: s.pipelineStats.list(),
+	})
+}
@@ -34,6 +34,10 @@ type dnsContext struct {
 	// response is modified by filters.
 	origResp *dns.Msg
 
+	// trace is the trace of processing the request.  It's only set for the
+	// requests sent by the query trace HTTP API.
+	trace *queryTrace
+
 	// unreversedReqIP stores an IP address obtained from a PTR request if it
 	// was parsed successfully and belongs to one of the locally served IP
 	// ranges.  It is also filled with unmapped version of the address if it's
@@ -45,6 +49,10 @@ type dnsContext struct {
 	// err is the error returned from a processing function.
 	err error
 
+	// dnssecErr is the reason why the upstream response has been considered
+	// bogus by the local DNSSEC validation, if any.
+	dnssecErr error
+
 	// clientID is the ClientID from DoH, DoQ, or DoT, if provided.
 	clientID string
 
@@ -73,6 +81,10 @@ type dnsContext struct {
 	// isDHCPHost is true if the request for a local domain name and the DHCP is
 	// available for this request.
 	isDHCPHost bool
+
+	// strippedTypes are the types of the records removed from the upstream
+	// response by the query type policies.
+	strippedTypes []uint16
 }
 
 // resultCode is the result of a request processing function.
@@ -92,43 +104,93 @@ const (
 	resultCodeError
 )
 
+// String implements the [fmt.Stringer] interface for resultCode.
+func (rc resultCode) String() (str string) {
+	switch rc {
+	case resultCodeSuccess:
+		return "success"
+	case resultCodeFinish:
+		return "finish"
+	case resultCodeError:
+		return "error"
+	default:
+		return "resultCode(" + strconv.Itoa(int(rc)) + ")"
+	}
+}
+
 // ddrHostFQDN is the FQDN used in Discovery of Designated Resolvers (DDR) requests.
 // See https://www.ietf.org/archive/id/draft-ietf-add-ddr-06.html.
 const ddrHostFQDN = "_dns.resolver.arpa."
 
 // handleDNSRequest filters the incoming DNS requests and writes them to the query log
 func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error {
+	s.requestLock.RLock()
+	defer s.requestLock.RUnlock()
+
 	dctx := &dnsContext{
 		proxyCtx:  pctx,
 		result:    &filtering.Result{},
 		startTime: time.Now(),
 	}
 
-	type modProcessFunc func(ctx *dnsContext) (rc resultCode)
+	return s.processRequest(dctx)
+}
+
+// requestStage is a named stage of processing a request.
+type requestStage struct {
+	// process is the processing function of the stage.
+	process func(dctx *dnsContext) (rc resultCode)
+
+	// name is the name of the stage used in the query traces.
+	name string
+}
+
+// requestStages returns the stages of processing a request in the order, in
+// which they must be run.
+func (s *Server) requestStages() (stages []requestStage) {
+	return []requestStage{
+		{process: s.processNotify, name: "notify"},
+		{process: s.processRecursion, name: "recursion"},
+		{process: s.processInitial, name: "initial"},
+		{process: s.processACMEChallenge, name: "acme_challenge"},
+		{process: s.processQueryTypePolicy, name: "query_type_policy"},
+		{process: s.processDDRQuery, name: "ddr"},
+		{process: s.processECHQuery, name: "ech"},
+		{process: s.processDetermineLocal, name: "determine_local"},
+		{process: s.processDHCPHosts, name: "dhcp_hosts"},
+		{process: s.processSecondaryZones, name: "secondary_zones"},
+		{process: s.processRestrictLocal, name: "restrict_local"},
+		{process: s.processDHCPAddrs, name: "dhcp_addrs"},
+		{process: s.processFilteringBeforeRequest, name: "filtering_before_request"},
+		{process: s.processLocalPTR, name: "local_ptr"},
+		{process: s.processUpstream, name: "upstream"},
+		{process: s.processQueryTypeStrip, name: "query_type_strip"},
+		{process: s.processFilteringAfterResponse, name: "filtering_after_response"},
+		{process: s.processClientMinTTL, name: "client_min_ttl"},
+		{process: s.processAnswerOrder, name: "answer_order"},
+		{process: s.ipset.process, name: "ipset"},
+		{process: s.processQueryLogsAndStats, name: "querylog_and_stats"},
+	}
+}
 
+// processRequest runs the stages of processing the request in dctx.  If
+// dctx.trace isn't nil, the stages are recorded into it.  s.requestLock is
+// expected to be locked for reading.
+func (s *Server) processRequest(dctx *dnsContext) (err error) {
 	// Since (*dnsforward.Server).handleDNSRequest(...) is used as
 	// proxy.(Config).RequestHandler, there is no need for additional index
 	// out of range checking in any of the following functions, because the
 	// (*proxy.Proxy).handleDNSRequest method performs it before calling the
-	// appropriate handler.
-	mods := []modProcessFunc{
-		s.processRecursion,
-		s.processInitial,
-		s.processDDRQuery,
-		s.processDetermineLocal,
-		s.processDHCPHosts,
-		s.processRestrictLocal,
-		s.processDHCPAddrs,
-		s.processFilteringBeforeRequest,
-		s.processLocalPTR,
-		s.processUpstream,
-		s.processFilteringAfterResponse,
-		s.ipset.process,
-		s.processQueryLogsAndStats,
-	}
-	for _, process := range mods {
-		r := process(dctx)
-		switch r {
+	// appropriate handler.  The traced requests always have a single question.
+	for _, st := range s.requestStages() {
+		var rc resultCode
+		if dctx.trace == nil {
+			rc = st.process(dctx)
+		} else {
+			rc = dctx.trace.run(st, dctx)
+		}
+
+		switch rc {
 		case resultCodeSuccess:
 			// continue: call the next filter
 
@@ -140,6 +202,7 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error
 		}
 	}
 
+	pctx := dctx.proxyCtx
 	if pctx.Res != nil {
 		// Some devices require DNS message compression.
 		pctx.Res.Compress = true
@@ -192,6 +255,7 @@ func (s *Server) processInitial(dctx *dnsContext) (rc resultCode) {
 
 	pctx := dctx.proxyCtx
 	s.processClientIP(pctx.Addr)
+	s.processUserAgent(pctx)
 
 	q := pctx.Req.Question[0]
 	qt := q.Qtype
@@ -227,6 +291,24 @@ func (s *Server) processInitial(dctx *dnsContext) (rc resultCode) {
 	return resultCodeSuccess
 }
 
+// processUserAgent sends the User-Agent of the DoH request to
+// s.conf.UserAgentHandler, if needed.
+func (s *Server) processUserAgent(pctx *proxy.DNSContext) {
+	if s.conf.UserAgentHandler == nil || pctx.HTTPRequest == nil {
+		return
+	}
+
+	ua := pctx.HTTPRequest.UserAgent()
+	if ua == "" {
+		return
+	}
+
+	clientIP := netutil.NetAddrToAddrPort(pctx.Addr).Addr()
+	if clientIP.IsValid() {
+		s.conf.UserAgentHandler(clientIP.Unmap(), ua)
+	}
+}
+
 // processClientIP sends the client IP address to s.addrProc, if needed.
 func (s *Server) processClientIP(addr net.Addr) {
 	clientIP := netutil.NetAddrToAddrPort(addr).Addr()
@@ -242,6 +324,7 @@ func (s *Server) processClientIP(addr net.Addr) {
 	defer s.serverLock.RUnlock()
 
 	s.addrProc.Process(clientIP)
+	s.notifyNewClient(clientIP)
 }
 
 // processDDRQuery responds to Discovery of Designated Resolvers (DDR) SVCB
@@ -285,21 +368,28 @@ func (s *Server) makeDDRResponse(req *dns.Msg) (resp *dns.Msg) {
 	// name somewhere.
 	domainName := dns.Fqdn(s.conf.ServerName)
 
+	h3Ports := map[int]bool{}
+	for _, addr := range s.conf.HTTP3ListenAddrs {
+		h3Ports[addr.Port] = true
+	}
+
+	httpsPorts := map[int]bool{}
 	for _, addr := range s.conf.HTTPSListenAddrs {
-		values := []dns.SVCBKeyValue{
-			&dns.SVCBAlpn{Alpn: []string{"h2"}},
-			&dns.SVCBPort{Port: uint16(addr.Port)},
-			&dns.SVCBDoHPath{Template: "/dns-query{?dns}"},
-		}
+		httpsPorts[addr.Port] = true
 
-		ans := &dns.SVCB{
-			Hdr:      s.hdr(req, dns.TypeSVCB),
-			Priority: 1,
-			Target:   domainName,
-			Value:    values,
+		alpn := []string{"h2"}
+		if h3Ports[addr.Port] {
+			alpn = append(alpn, "h3")
 		}
 
-		resp.Answer = append(resp.Answer, ans)
+		resp.Answer = append(resp.Answer, s.newDoHSVCB(req, domainName, alpn, addr.Port))
+	}
+
+	// Advertise the HTTP/3 ports, which differ from the HTTPS ones, separately.
+	for _, addr := range s.conf.HTTP3ListenAddrs {
+		if !httpsPorts[addr.Port] {
+			resp.Answer = append(resp.Answer, s.newDoHSVCB(req, domainName, []string{"h3"}, addr.Port))
+		}
 	}
 
 	if s.conf.hasIPAddrs {
@@ -343,6 +433,27 @@ func (s *Server) makeDDRResponse(req *dns.Msg) (resp *dns.Msg) {
 	return resp
 }
 
+// newDoHSVCB returns a new DDR SVCB resource record for the DNS-over-HTTPS
+// resolver with the ALPN identifiers alpn on port.
+func (s *Server) newDoHSVCB(req *dns.Msg, target string, alpn []string, port int) (rr *dns.SVCB) {
+	values := []dns.SVCBKeyValue{
+		&dns.SVCBAlpn{Alpn: alpn},
+		&dns.SVCBPort{Port: uint16(port)},
+		&dns.SVCBDoHPath{Template: "/dns-query{?dns}"},
+	}
+
+	if s.echConfigList != nil {
+		values = append(values, &dns.SVCBECHConfig{ECH: s.echConfigList})
+	}
+
+	return &dns.SVCB{
+		Hdr:      s.hdr(req, dns.TypeSVCB),
+		Priority: 1,
+		Target:   target,
+		Value:    values,
+	}
+}
+
 // processDetermineLocal determines if the client's IP address is from locally
 // served network and saves the result into the context.
 func (s *Server) processDetermineLocal(dctx *dnsContext) (rc resultCode) {
@@ -361,11 +472,9 @@ func (s *Server) processDetermineLocal(dctx *dnsContext) (rc resultCode) {
 	return rc
 }
 
-// processDHCPHosts respond to A requests if the target hostname is known to
-// the server.  It responds with a mapped IP address if the DNS64 is enabled and
-// the request is for AAAA.
-//
-// TODO(a.garipov): Adapt to AAAA as well.
+// processDHCPHosts responds to A and AAAA requests if the target hostname is
+// known to the server.  It responds with a mapped IP address if the DNS64 is
+// enabled, the request is for AAAA, and the client has no IPv6 addresses.
 func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 	log.Debug("dnsforward: started processing dhcp hosts")
 	defer log.Debug("dnsforward: finished processing dhcp hosts")
@@ -387,8 +496,8 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 		return resultCodeFinish
 	}
 
-	ip := s.dhcpServer.IPByHost(dhcpHost)
-	if ip == (netip.Addr{}) {
+	ips := s.dhcpServer.IPsByHost(dhcpHost)
+	if len(ips) == 0 {
 		// Go on and process them with filters, including dnsrewrite ones, and
 		// possibly route them to a domain-specific upstream.
 		log.Debug("dnsforward: no dhcp record for %q", dhcpHost)
@@ -396,31 +505,72 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 		return resultCodeSuccess
 	}
 
-	log.Debug("dnsforward: dhcp record for %q is %s", dhcpHost, ip)
+	log.Debug("dnsforward: dhcp records for %q are %s", dhcpHost, ips)
 
 	resp := s.makeResponse(req)
-	switch q.Qtype {
-	case dns.TypeA:
-		a := &dns.A{
-			Hdr: s.hdr(req, dns.TypeA),
-			A:   ip.AsSlice(),
+	resp.Answer = s.dhcpHostAnswers(req, ips)
+
+	dctx.proxyCtx.Res = resp
+
+	return resultCodeSuccess
+}
+
+// dhcpHostAnswers returns the answers to the A or AAAA request req for a DHCP
+// client with ips.  If the request is for AAAA, the client has no IPv6
+// addresses, and DNS64 is enabled, its IPv4 addresses are mapped.
+func (s *Server) dhcpHostAnswers(req *dns.Msg, ips []netip.Addr) (ans []dns.RR) {
+	qt := req.Question[0].Qtype
+	for _, ip := range ips {
+		if qt == dns.TypeA && ip.Is4() {
+			ans = append(ans, s.genAnswerA(req, ip))
+		} else if qt == dns.TypeAAAA && ip.Is6() {
+			ans = append(ans, s.genAnswerAAAA(req, ip))
 		}
-		resp.Answer = append(resp.Answer, a)
-	case dns.TypeAAAA:
-		if s.dns64Pref != (netip.Prefix{}) {
-			// Respond with DNS64-mapped address for IPv4 host if DNS64 is
-			// enabled.
-			aaaa := &dns.AAAA{
+	}
+
+	if len(ans) > 0 || qt != dns.TypeAAAA || s.dns64Pref == (netip.Prefix{}) {
+		return ans
+	}
+
+	for _, ip := range ips {
+		if ip.Is4() {
+			ans = append(ans, &dns.AAAA{
 				Hdr:  s.hdr(req, dns.TypeAAAA),
 				AAAA: s.mapDNS64(ip),
-			}
-			resp.Answer = append(resp.Answer, aaaa)
+			})
 		}
-	default:
-		// Go on.
 	}
 
-	dctx.proxyCtx.Res = resp
+	return ans
+}
+
+// processNotify responds to the NOTIFY messages for the secondary zones.
+func (s *Server) processNotify(dctx *dnsContext) (rc resultCode) {
+	pctx := dctx.proxyCtx
+	if pctx.Req.Opcode != dns.OpcodeNotify {
+		return resultCodeSuccess
+	}
+
+	log.Debug("dnsforward: started processing notify")
+	defer log.Debug("dnsforward: finished processing notify")
+
+	clientIP := netutil.NetAddrToAddrPort(pctx.Addr).Addr()
+	pctx.Res = s.secondary.handleNotify(pctx.Req, clientIP)
+
+	// Do not even put into query log.
+	return resultCodeFinish
+}
+
+// processSecondaryZones responds to the requests for the names within the
+// secondary zones.
+func (s *Server) processSecondaryZones(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing secondary zones")
+	defer log.Debug("dnsforward: finished processing secondary zones")
+
+	pctx := dctx.proxyCtx
+	if resp := s.secondary.answer(pctx.Req); resp != nil {
+		pctx.Res = resp
+	}
 
 	return resultCodeSuccess
 }
@@ -730,6 +880,15 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 
 	reqWantsDNSSEC := s.setReqAD(req)
 
+	clientDO := true
+	if s.dnssec != nil {
+		clientDO = setReqDO(req)
+		if !clientDO {
+			// Restore the original request for the query log.
+			defer req.IsEdns0().SetDo(false)
+		}
+	}
+
 	// Process the request further since it wasn't filtered.
 	prx := s.proxy()
 	if prx == nil {
@@ -738,7 +897,16 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		return resultCodeError
 	}
 
-	if err := prx.Resolve(pctx); err != nil {
+	if len(s.conf.UpstreamECS) > 0 {
+		if subnet, ok := clientSubnet(pctx.Addr); ok {
+			s.clientSubnets.Store(req, subnet)
+			defer s.clientSubnets.Delete(req)
+		}
+	}
+
+	err := s.resolveCached(prx, pctx, dctx.trace)
+	s.countUpstreamFailure(err)
+	if err != nil {
 		if errors.Is(err, upstream.ErrNoUpstreams) {
 			// Do not even put into querylog.  Currently this happens either
 			// when the private resolvers enabled and the request is DNS64 PTR,
@@ -751,14 +919,33 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 			return resultCodeFinish
 		}
 
+		if resp := s.upstreamFailure.handle(s, req); resp != nil {
+			pctx.Res = resp
+
+			return resultCodeSuccess
+		}
+
 		dctx.err = err
 
 		return resultCodeError
 	}
 
+	if pctx.Res.Rcode == dns.RcodeServerFailure {
+		if resp := s.upstreamFailure.handle(s, req); resp != nil {
+			dctx.origResp = pctx.Res
+			pctx.Res = resp
+
+			return resultCodeSuccess
+		}
+	} else {
+		s.upstreamFailure.store(req, pctx.Res)
+	}
+
 	dctx.responseFromUpstream = true
 	dctx.responseAD = pctx.Res.AuthenticatedData
 
+	s.validateDNSSEC(dctx, clientDO)
+
 	s.setRespAD(pctx, reqWantsDNSSEC)
 
 	return resultCodeSuccess
@@ -814,8 +1001,8 @@ func (s *Server) dhcpHostFromRequest(q *dns.Question) (reqHost string) {
 		return ""
 	}
 
-	// Include AAAA here, because despite the fact that we don't support it yet,
-	// the expected behavior here is to respond with an empty answer and not
+	// Include AAAA here, because even if the client has no IPv6 addresses, the
+	// expected behavior here is to respond with an empty answer and not
 	// NXDOMAIN.
 	if qt := q.Qtype; qt != dns.TypeA && qt != dns.TypeAAAA {
 		return ""
@@ -852,6 +1039,34 @@ func (s *Server) setCustomUpstream(pctx *proxy.DNSContext, clientID string) {
 	pctx.CustomUpstreamConfig = upsConf
 }
 
+// processClientMinTTL raises the TTLs of the records in the response to the
+// minimum configured for the client, if any.
+func (s *Server) processClientMinTTL(dctx *dnsContext) (rc resultCode) {
+	pctx := dctx.proxyCtx
+	getMinTTL := s.conf.GetClientMinTTL
+	if pctx.Res == nil || pctx.Addr == nil || getMinTTL == nil {
+		return resultCodeSuccess
+	}
+
+	id := stringutil.Coalesce(dctx.clientID, ipStringFromAddr(pctx.Addr))
+	minTTL := getMinTTL(id)
+	if minTTL == 0 {
+		return resultCodeSuccess
+	}
+
+	log.Debug("dnsforward: applying minimum ttl %d for client %s", minTTL, id)
+
+	for _, rrs := range [][]dns.RR{pctx.Res.Answer, pctx.Res.Ns, pctx.Res.Extra} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT && hdr.Ttl < minTTL {
+				hdr.Ttl = minTTL
+			}
+		}
+	}
+
+	return resultCodeSuccess
+}
+
 // Apply filtering logic after we have received response from upstream servers
 func (s *Server) processFilteringAfterResponse(dctx *dnsContext) (rc resultCode) {
 	log.Debug("dnsforward: started processing filtering after resp")
@@ -901,5 +1116,13 @@ func (s *Server) filterAfterResponse(dctx *dnsContext) (res resultCode) {
 		return resultCodeError
 	}
 
+	if !dctx.result.IsFiltered {
+		s.filterResponseIP(dctx)
+	}
+
+	if !dctx.result.IsFiltered {
+		s.filterRebind(dctx)
+	}
+
 	return resultCodeSuccess
 }
@@ -2,7 +2,11 @@ package dnsforward
 
 import (
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
@@ -10,6 +14,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/AdguardTeam/urlfilter/rules"
@@ -221,6 +226,26 @@ func TestServer_ProcessDDRQuery(t *testing.T) {
 		},
 	}
 
+	doh3SVCB := &dns.SVCB{
+		Priority: 1,
+		Target:   ddrTestFQDN,
+		Value: []dns.SVCBKeyValue{
+			&dns.SVCBAlpn{Alpn: []string{"h3"}},
+			&dns.SVCBPort{Port: 8045},
+			&dns.SVCBDoHPath{Template: "/dns-query{?dns}"},
+		},
+	}
+
+	dohH3SVCB := &dns.SVCB{
+		Priority: 1,
+		Target:   ddrTestFQDN,
+		Value: []dns.SVCBKeyValue{
+			&dns.SVCBAlpn{Alpn: []string{"h2", "h3"}},
+			&dns.SVCBPort{Port: 8044},
+			&dns.SVCBDoHPath{Template: "/dns-query{?dns}"},
+		},
+	}
+
 	dotSVCB := &dns.SVCB{
 		Priority: 1,
 		Target:   ddrTestFQDN,
@@ -245,6 +270,7 @@ func TestServer_ProcessDDRQuery(t *testing.T) {
 		want       []*dns.SVCB
 		wantRes    resultCode
 		portDoH    int
+		portDoH3   int
 		portDoT    int
 		portDoQ    int
 		qtype      uint16
@@ -309,11 +335,29 @@ func TestServer_ProcessDDRQuery(t *testing.T) {
 		ddrEnabled: true,
 		portDoT:    8043,
 		portDoH:    8044,
+	}, {
+		name:       "doh_h3_same_port",
+		wantRes:    resultCodeFinish,
+		want:       []*dns.SVCB{dohH3SVCB},
+		host:       ddrHostFQDN,
+		qtype:      dns.TypeSVCB,
+		ddrEnabled: true,
+		portDoH:    8044,
+		portDoH3:   8044,
+	}, {
+		name:       "doh_h3_other_port",
+		wantRes:    resultCodeFinish,
+		want:       []*dns.SVCB{dohSVCB, doh3SVCB},
+		host:       ddrHostFQDN,
+		qtype:      dns.TypeSVCB,
+		ddrEnabled: true,
+		portDoH:    8044,
+		portDoH3:   8045,
 	}}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			s := prepareTestServer(t, tc.portDoH, tc.portDoT, tc.portDoQ, tc.ddrEnabled)
+			s := prepareTestServer(t, tc.portDoH, tc.portDoH3, tc.portDoT, tc.portDoQ, tc.ddrEnabled)
 
 			req := createTestMessageWithType(tc.host, tc.qtype)
 
@@ -354,7 +398,14 @@ func createTestDNSFilter(t *testing.T) (f *filtering.DNSFilter) {
 	return f
 }
 
-func prepareTestServer(t *testing.T, portDoH, portDoT, portDoQ int, ddrEnabled bool) (s *Server) {
+func prepareTestServer(
+	t *testing.T,
+	portDoH int,
+	portDoH3 int,
+	portDoT int,
+	portDoQ int,
+	ddrEnabled bool,
+) (s *Server) {
 	t.Helper()
 
 	s = &Server{
@@ -385,6 +436,10 @@ func prepareTestServer(t *testing.T, portDoH, portDoT, portDoQ int, ddrEnabled b
 		s.conf.HTTPSListenAddrs = []*net.TCPAddr{{Port: portDoH}}
 	}
 
+	if portDoH3 > 0 {
+		s.conf.HTTP3ListenAddrs = []*net.UDPAddr{{Port: portDoH3}}
+	}
+
 	return s
 }
 
@@ -444,12 +499,12 @@ func TestServer_ProcessDHCPHosts_localRestriction(t *testing.T) {
 	knownIP := netip.MustParseAddr("1.2.3.4")
 	dhcp := &testDHCP{
 		OnEnabled: func() (_ bool) { return true },
-		OnIPByHost: func(host string) (ip netip.Addr) {
+		OnIPsByHost: func(host string) (ips []netip.Addr) {
 			if host == dhcpClient {
-				ip = knownIP
+				ips = []netip.Addr{knownIP}
 			}
 
-			return ip
+			return ips
 		},
 	}
 
@@ -547,11 +602,13 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 		localTLD = "lan"
 
 		knownClient  = "example"
+		dualClient   = "dual"
 		externalHost = knownClient + ".com"
 		clientHost   = knownClient + "." + localTLD
 	)
 
 	knownIP := netip.MustParseAddr("1.2.3.4")
+	knownIP6 := netip.MustParseAddr("fd00::1234")
 
 	testCases := []struct {
 		wantIP  netip.Addr
@@ -595,6 +652,20 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 		suffix:  localTLD,
 		wantRes: resultCodeSuccess,
 		qtyp:    dns.TypeAAAA,
+	}, {
+		wantIP:  knownIP6,
+		name:    "internal_aaaa_dual",
+		host:    dualClient + "." + localTLD,
+		suffix:  localTLD,
+		wantRes: resultCodeSuccess,
+		qtyp:    dns.TypeAAAA,
+	}, {
+		wantIP:  knownIP,
+		name:    "internal_a_dual",
+		host:    dualClient + "." + localTLD,
+		suffix:  localTLD,
+		wantRes: resultCodeSuccess,
+		qtyp:    dns.TypeA,
 	}, {
 		wantIP:  knownIP,
 		name:    "custom_suffix",
@@ -607,12 +678,15 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 	for _, tc := range testCases {
 		testDHCP := &testDHCP{
 			OnEnabled: func() (_ bool) { return true },
-			OnIPByHost: func(host string) (ip netip.Addr) {
-				if host == knownClient {
-					ip = knownIP
+			OnIPsByHost: func(host string) (ips []netip.Addr) {
+				switch host {
+				case knownClient:
+					return []netip.Addr{knownIP}
+				case dualClient:
+					return []netip.Addr{knownIP, knownIP6}
+				default:
+					return nil
 				}
-
-				return ip
 			},
 			OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
 		}
@@ -647,28 +721,36 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 			assert.Equal(t, tc.wantRes, res)
 			require.NoError(t, dctx.err)
 
-			if tc.qtyp == dns.TypeAAAA {
-				// TODO(a.garipov): Remove this special handling
-				// when we fully support AAAA.
-				require.NotNil(t, pctx.Res)
+			if tc.wantIP == (netip.Addr{}) {
+				if tc.qtyp == dns.TypeAAAA {
+					// The known client without IPv6 addresses.
+					require.NotNil(t, pctx.Res)
 
-				ans := pctx.Res.Answer
-				require.Len(t, ans, 0)
-			} else if tc.wantIP == (netip.Addr{}) {
-				assert.Nil(t, pctx.Res)
-			} else {
-				require.NotNil(t, pctx.Res)
+					assert.Empty(t, pctx.Res.Answer)
+				} else {
+					assert.Nil(t, pctx.Res)
+				}
 
-				ans := pctx.Res.Answer
-				require.Len(t, ans, 1)
+				return
+			}
 
-				a := testutil.RequireTypeAssert[*dns.A](t, ans[0])
+			require.NotNil(t, pctx.Res)
 
-				ip, err := netutil.IPToAddr(a.A, netutil.AddrFamilyIPv4)
-				require.NoError(t, err)
+			ans := pctx.Res.Answer
+			require.Len(t, ans, 1)
 
-				assert.Equal(t, tc.wantIP, ip)
+			var ip netip.Addr
+			var err error
+			if tc.qtyp == dns.TypeAAAA {
+				aaaa := testutil.RequireTypeAssert[*dns.AAAA](t, ans[0])
+				ip, err = netutil.IPToAddr(aaaa.AAAA, netutil.AddrFamilyIPv6)
+			} else {
+				a := testutil.RequireTypeAssert[*dns.A](t, ans[0])
+				ip, err = netutil.IPToAddr(a.A, netutil.AddrFamilyIPv4)
 			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantIP, ip)
 		})
 	}
 }
@@ -958,3 +1040,312 @@ func TestExtractARPASubnet(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_ProcessFilteringAfterResponse_rebind(t *testing.T) {
+	t.Parallel()
+
+	const allowedFQDN = "allowed.example."
+
+	var (
+		privIPv4   net.IP = netip.MustParseAddr("192.168.1.1").AsSlice()
+		cgnatIPv4  net.IP = netip.MustParseAddr("100.64.0.1").AsSlice()
+		publicIPv4 net.IP = netip.MustParseAddr("1.2.3.4").AsSlice()
+	)
+
+	testCases := []struct {
+		name       string
+		host       string
+		ip         net.IP
+		wantReason filtering.Reason
+	}{{
+		name:       "public",
+		host:       aghtest.ReqFQDN,
+		ip:         publicIPv4,
+		wantReason: filtering.NotFilteredNotFound,
+	}, {
+		name:       "private",
+		host:       aghtest.ReqFQDN,
+		ip:         privIPv4,
+		wantReason: filtering.FilteredRebind,
+	}, {
+		name:       "additional",
+		host:       aghtest.ReqFQDN,
+		ip:         cgnatIPv4,
+		wantReason: filtering.FilteredRebind,
+	}, {
+		name:       "allowed_subdomain",
+		host:       "sub." + allowedFQDN,
+		ip:         privIPv4,
+		wantReason: filtering.NotFilteredNotFound,
+	}, {
+		name:       "local_domain",
+		host:       "host.lan.",
+		ip:         privIPv4,
+		wantReason: filtering.NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := ServerConfig{
+				Config: Config{
+					EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+					RebindProtection: &RebindProtectionConfig{
+						AllowedDomains: []string{allowedFQDN},
+						AdditionalNetworks: []netip.Prefix{
+							netip.MustParsePrefix("100.64.0.0/10"),
+						},
+						Enabled: true,
+					},
+				},
+			}
+
+			s := createTestServer(t, &filtering.Config{
+				BlockingMode: filtering.BlockingModeDefault,
+			}, c, nil)
+			s.privateNets = netutil.SubnetSetFunc(netutil.IsLocallyServed)
+
+			req := createTestMessageWithType(tc.host, dns.TypeA)
+			resp := newResp(dns.RcodeSuccess, req, []dns.RR{
+				newRR(t, tc.host, dns.TypeA, 3600, tc.ip),
+			})
+			dctx := &dnsContext{
+				setts: &filtering.Settings{
+					FilteringEnabled:  true,
+					ProtectionEnabled: true,
+				},
+				protectionEnabled:    true,
+				responseFromUpstream: true,
+				result:               &filtering.Result{},
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   req,
+					Res:   resp,
+					Addr:  testClientAddr,
+				},
+			}
+
+			gotRC := s.processFilteringAfterResponse(dctx)
+			require.Equal(t, resultCodeSuccess, gotRC)
+
+			assert.Equal(t, tc.wantReason, dctx.result.Reason)
+			if tc.wantReason == filtering.FilteredRebind {
+				assert.Same(t, resp, dctx.origResp)
+				assert.NotSame(t, resp, dctx.proxyCtx.Res)
+			}
+		})
+	}
+}
+
+func TestServer_ProcessFilteringAfterResponse_responseIP(t *testing.T) {
+	t.Parallel()
+
+	listPath := filepath.Join(t.TempDir(), "feed.txt")
+	err := os.WriteFile(listPath, []byte("198.51.100.0/24\n2001:db8::1\n"), 0o644)
+	require.NoError(t, err)
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+		IPBlocklists: []*filtering.IPBlocklist{{
+			Name:    "feed",
+			URL:     listPath,
+			Enabled: true,
+		}},
+	}, ServerConfig{
+		Config: Config{
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+	}, nil)
+
+	testCases := []struct {
+		name             string
+		ip               net.IP
+		qtype            uint16
+		filteringEnabled bool
+		wantReason       filtering.Reason
+	}{{
+		name:             "blocked_v4",
+		ip:               netip.MustParseAddr("198.51.100.1").AsSlice(),
+		qtype:            dns.TypeA,
+		filteringEnabled: true,
+		wantReason:       filtering.FilteredResponseIP,
+	}, {
+		name:             "blocked_v6",
+		ip:               netip.MustParseAddr("2001:db8::1").AsSlice(),
+		qtype:            dns.TypeAAAA,
+		filteringEnabled: true,
+		wantReason:       filtering.FilteredResponseIP,
+	}, {
+		name:             "not_blocked",
+		ip:               netip.MustParseAddr("192.0.2.1").AsSlice(),
+		qtype:            dns.TypeA,
+		filteringEnabled: true,
+		wantReason:       filtering.NotFilteredNotFound,
+	}, {
+		name:             "filtering_disabled",
+		ip:               netip.MustParseAddr("198.51.100.1").AsSlice(),
+		qtype:            dns.TypeA,
+		filteringEnabled: false,
+		wantReason:       filtering.NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := createTestMessageWithType(aghtest.ReqFQDN, tc.qtype)
+			resp := newResp(dns.RcodeSuccess, req, []dns.RR{
+				newRR(t, aghtest.ReqFQDN, tc.qtype, 3600, tc.ip),
+			})
+			dctx := &dnsContext{
+				setts: &filtering.Settings{
+					FilteringEnabled:  tc.filteringEnabled,
+					ProtectionEnabled: true,
+				},
+				protectionEnabled:    true,
+				responseFromUpstream: true,
+				result:               &filtering.Result{},
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   req,
+					Res:   resp,
+					Addr:  testClientAddr,
+				},
+			}
+
+			gotRC := s.processFilteringAfterResponse(dctx)
+			require.Equal(t, resultCodeSuccess, gotRC)
+
+			assert.Equal(t, tc.wantReason, dctx.result.Reason)
+			if tc.wantReason != filtering.FilteredResponseIP {
+				assert.Same(t, resp, dctx.proxyCtx.Res)
+
+				return
+			}
+
+			assert.Same(t, resp, dctx.origResp)
+			require.NotSame(t, resp, dctx.proxyCtx.Res)
+			require.Len(t, dctx.proxyCtx.Res.Answer, 1)
+
+			var gotIP net.IP
+			switch a := dctx.proxyCtx.Res.Answer[0].(type) {
+			case *dns.A:
+				gotIP = a.A
+			case *dns.AAAA:
+				gotIP = a.AAAA
+			}
+
+			assert.True(t, gotIP.IsUnspecified())
+		})
+	}
+}
+
+func TestServer_ProcessClientMinTTL(t *testing.T) {
+	t.Parallel()
+
+	const (
+		clientID = "phone"
+		minTTL   = 300
+	)
+
+	s := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				GetClientMinTTL: func(id string) (ttl uint32) {
+					if id == clientID {
+						return minTTL
+					}
+
+					return 0
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		clientID string
+		ttl      uint32
+		wantTTL  uint32
+	}{{
+		name:     "raised",
+		clientID: clientID,
+		ttl:      10,
+		wantTTL:  minTTL,
+	}, {
+		name:     "greater",
+		clientID: clientID,
+		ttl:      3600,
+		wantTTL:  3600,
+	}, {
+		name:     "other_client",
+		clientID: "",
+		ttl:      10,
+		wantTTL:  10,
+	}}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := (&dns.Msg{}).SetQuestion(aghtest.ReqFQDN, dns.TypeA)
+			resp := aghtest.MatchedResponse(req, dns.TypeA, aghtest.ReqFQDN, "1.2.3.4")
+			resp.Answer[0].Header().Ttl = tc.ttl
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req:  req,
+					Res:  resp,
+					Addr: testClientAddr,
+				},
+				clientID: tc.clientID,
+			}
+
+			rc := s.processClientMinTTL(dctx)
+			require.Equal(t, resultCodeSuccess, rc)
+
+			assert.Equal(t, tc.wantTTL, dctx.proxyCtx.Res.Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestServer_ProcessUserAgent(t *testing.T) {
+	t.Parallel()
+
+	const ua = "Mozilla/5.0 (PlayStation 5 3.11)"
+
+	var gotIP netip.Addr
+	var gotUA string
+	s := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				UserAgentHandler: func(ip netip.Addr, userAgent string) {
+					gotIP, gotUA = ip, userAgent
+				},
+			},
+		},
+	}
+
+	s.processUserAgent(&proxy.DNSContext{
+		Addr: testClientAddr,
+	})
+	assert.Empty(t, gotUA)
+
+	r := httptest.NewRequest(http.MethodGet, "/dns-query", nil)
+	r.Header.Set(httphdr.UserAgent, ua)
+
+	s.processUserAgent(&proxy.DNSContext{
+		Addr:        testClientAddr,
+		HTTPRequest: r,
+	})
+
+	assert.Equal(t, ua, gotUA)
+	assert.Equal(t, netutil.NetAddrToAddrPort(testClientAddr).Addr(), gotIP)
+}
@@ -0,0 +1,394 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// queryTraceAPI is the path of the HTTP API tracing the resolution of a query.
+// It's under the debug APIs, so that it's only available to the
+// administrators.
+const queryTraceAPI = "/control/debug/query_trace"
+
+// cacheStatus describes how the cache has been used for a request.
+type cacheStatus string
+
+// cacheStatus values.
+const (
+	// cacheStatusDisabled means that the cache is disabled.
+	cacheStatusDisabled cacheStatus = "disabled"
+
+	// cacheStatusNotCacheable means that the response to the request mustn't
+	// be cached, for example, because of a custom upstream configuration.
+	cacheStatusNotCacheable cacheStatus = "not_cacheable"
+
+	// cacheStatusMiss means that there has been no cached response, so the
+	// upstream has been used.
+	cacheStatusMiss cacheStatus = "miss"
+
+	// cacheStatusHit means that the cached response has been used.
+	cacheStatusHit cacheStatus = "hit"
+
+	// cacheStatusStale means that the expired cached response has been used
+	// in the optimistic mode and is being refreshed.
+	cacheStatusStale cacheStatus = "stale"
+)
+
+// queryTrace is the trace of processing a single request.  It's only
+// collected for the requests sent by the query trace HTTP API.  A nil
+// *queryTrace records nothing.
+type queryTrace struct {
+	// cache is the use of the cache, if the request has reached it.
+	cache *queryTraceCacheJSON
+
+	// stages are the stages of processing, which have been run, in the order
+	// of running.
+	stages []*queryTraceStageJSON
+}
+
+// run runs the processing stage st for dctx and records it into tr.
+func (tr *queryTrace) run(st requestStage, dctx *dnsContext) (rc resultCode) {
+	start := time.Now()
+	rc = st.process(dctx)
+
+	tr.stages = append(tr.stages, &queryTraceStageJSON{
+		Name:     st.name,
+		Result:   rc.String(),
+		Duration: durationMs(time.Since(start)),
+	})
+
+	return rc
+}
+
+// setCache records the use of the cache c for key into tr, if it isn't nil.
+// c may be nil.
+func (tr *queryTrace) setCache(c *dnsCache, key dnsCacheKey, status cacheStatus) {
+	if tr == nil {
+		return
+	}
+
+	if c == nil {
+		tr.cache = &queryTraceCacheJSON{
+			Status: cacheStatusDisabled,
+		}
+
+		return
+	}
+
+	tr.cache = &queryTraceCacheJSON{
+		Status: status,
+	}
+
+	if status != cacheStatusNotCacheable {
+		shard := c.shardIndex(key.name)
+		tr.cache.Shard = &shard
+	}
+}
+
+// durationMs returns d in milliseconds.
+func durationMs(d time.Duration) (ms float64) {
+	return float64(d.Microseconds()) / 1000
+}
+
+// queryTraceReq is the request to the POST /control/debug/query_trace HTTP
+// API.
+type queryTraceReq struct {
+	// Name is the name to resolve.
+	Name string `json:"name"`
+
+	// Type is the query type.  Empty type means A.
+	Type string `json:"type"`
+
+	// Client is the IP address of the client, on behalf of which the name is
+	// resolved.  Empty client means the IPv4 localhost.
+	Client string `json:"client"`
+}
+
+// queryTraceJSON is the response to the POST /control/debug/query_trace HTTP
+// API.
+type queryTraceJSON struct {
+	// Cache is the use of the cache.  It's nil if the request hasn't reached
+	// the cache.
+	Cache *queryTraceCacheJSON `json:"cache"`
+
+	// Filtering is the result of filtering the request.  It's nil if the
+	// request hasn't reached the filtering.
+	Filtering *queryTraceFilteringJSON `json:"filtering"`
+
+	// Stages are the stages of processing, which have been run, in the order
+	// of running.
+	Stages []*queryTraceStageJSON `json:"stages"`
+
+	// Name is the name of the question.
+	Name string `json:"name"`
+
+	// Type is the query type.
+	Type string `json:"type"`
+
+	// Client is the IP address of the client.
+	Client string `json:"client"`
+
+	// Upstream is the address of the upstream, which has resolved the
+	// response, including the one of a cached response.  It's empty if no
+	// upstream has been used.
+	Upstream string `json:"upstream"`
+
+	// Error is the error of processing the request, if any.
+	Error string `json:"error,omitempty"`
+
+	// Rcode is the response code.  It's empty if there is no response.
+	Rcode string `json:"rcode"`
+
+	// Response is the text representation of the response.  It's empty if
+	// there is no response.
+	Response string `json:"response"`
+
+	// ResponseWire is the response in the wire format.  It's nil if there is
+	// no response.
+	ResponseWire []byte `json:"response_wire"`
+
+	// Elapsed is the total time of processing the request in milliseconds.
+	Elapsed float64 `json:"elapsed_ms"`
+}
+
+// queryTraceStageJSON is the JSON representation of a processing stage.
+type queryTraceStageJSON struct {
+	// Name is the name of the stage.
+	Name string `json:"name"`
+
+	// Result is the result of the stage: "success" if the processing has
+	// continued, "finish" if it has been stopped, and "error" if it has
+	// failed.
+	Result string `json:"result"`
+
+	// Duration is the time of running the stage in milliseconds.
+	Duration float64 `json:"duration_ms"`
+}
+
+// queryTraceCacheJSON is the JSON representation of the use of the cache.
+type queryTraceCacheJSON struct {
+	// Shard is the index of the cache shard, which has been used.  It's nil if
+	// the response isn't cacheable.
+	Shard *int `json:"shard"`
+
+	// Status describes how the cache has been used.
+	Status cacheStatus `json:"status"`
+}
+
+// queryTraceFilteringJSON is the JSON representation of filtering a request.
+type queryTraceFilteringJSON struct {
+	// Rules are the matched rules.
+	Rules []*queryTraceRuleJSON `json:"rules"`
+
+	// DisabledFilterLists are the IDs of the filter lists, which haven't been
+	// applied for the client.
+	DisabledFilterLists []int64 `json:"disabled_filter_lists"`
+
+	// BlockedServices are the IDs of the services blocked for the client.
+	BlockedServices []string `json:"blocked_services"`
+
+	// BlockedCategories are the names of the domain categories blocked for the
+	// client.
+	BlockedCategories []string `json:"blocked_categories"`
+
+	// ClientName is the name of the persistent client, if any.
+	ClientName string `json:"client_name"`
+
+	// Reason is the reason of the filtering result.
+	Reason string `json:"reason"`
+
+	// ServiceName is the name of the blocked service, which has matched, if
+	// any.
+	ServiceName string `json:"service_name"`
+
+	// Filtered is true if the request or the response has been filtered.
+	Filtered bool `json:"filtered"`
+
+	// ProtectionEnabled is true if the protection has been enabled.
+	ProtectionEnabled bool `json:"protection_enabled"`
+
+	// FilteringEnabled is true if the filter lists have been applied.
+	FilteringEnabled bool `json:"filtering_enabled"`
+
+	// SafeBrowsingEnabled is true if the safe browsing has been checked.
+	SafeBrowsingEnabled bool `json:"safebrowsing_enabled"`
+
+	// ParentalEnabled is true if the parental control has been checked.
+	ParentalEnabled bool `json:"parental_enabled"`
+
+	// SafeSearchEnabled is true if the safe search has been applied.
+	SafeSearchEnabled bool `json:"safesearch_enabled"`
+}
+
+// queryTraceRuleJSON is the JSON representation of a matched rule.
+type queryTraceRuleJSON struct {
+	// Text is the text of the rule.
+	Text string `json:"text"`
+
+	// FilterListID is the ID of the filter list of the rule.
+	FilterListID int64 `json:"filter_list_id"`
+}
+
+// newQueryTraceContext returns a new context for resolving the request
+// described by req.
+func newQueryTraceContext(req *queryTraceReq) (pctx *proxy.DNSContext, err error) {
+	if req.Name == "" {
+		return nil, errors.Error("name: empty")
+	} else if _, ok := dns.IsDomainName(req.Name); !ok {
+		return nil, fmt.Errorf("name: bad domain name %q", req.Name)
+	}
+
+	qtypeStr := stringutil.Coalesce(req.Type, "A")
+	qtype, ok := dns.StringToType[strings.ToUpper(qtypeStr)]
+	if !ok {
+		return nil, fmt.Errorf("type: unknown type %q", qtypeStr)
+	}
+
+	ip := netutil.IPv4Localhost()
+	if req.Client != "" {
+		ip, err = netip.ParseAddr(req.Client)
+		if err != nil {
+			return nil, fmt.Errorf("client: %w", err)
+		}
+	}
+
+	return &proxy.DNSContext{
+		Proto: proxy.ProtoUDP,
+		Req:   (&dns.Msg{}).SetQuestion(dns.Fqdn(req.Name), qtype),
+		Addr:  net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, 0)),
+	}, nil
+}
+
+// handleQueryTrace is the handler for the POST /control/debug/query_trace HTTP
+// API.  It resolves the name through all the stages of processing a request
+// and returns the trace of the resolution.  The traced requests aren't written
+// to the query log and the statistics.
+func (s *Server) handleQueryTrace(w http.ResponseWriter, r *http.Request) {
+	req := &queryTraceReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	pctx, err := newQueryTraceContext(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	if !s.IsRunning() {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "dns server is not running")
+
+		return
+	}
+
+	dctx := &dnsContext{
+		proxyCtx:  pctx,
+		result:    &filtering.Result{},
+		startTime: time.Now(),
+		trace: &queryTrace{
+			stages: []*queryTraceStageJSON{},
+		},
+	}
+
+	func() {
+		s.requestLock.RLock()
+		defer s.requestLock.RUnlock()
+
+		err = s.processRequest(dctx)
+	}()
+
+	aghhttp.WriteJSONResponseOK(w, r, newQueryTraceJSON(dctx, err))
+}
+
+// newQueryTraceJSON returns the JSON representation of the trace of processing
+// the request in dctx, which has finished with err.
+func newQueryTraceJSON(dctx *dnsContext, err error) (resp *queryTraceJSON) {
+	pctx := dctx.proxyCtx
+	q := pctx.Req.Question[0]
+
+	resp = &queryTraceJSON{
+		Cache:     dctx.trace.cache,
+		Filtering: newQueryTraceFilteringJSON(dctx),
+		Stages:    dctx.trace.stages,
+		Name:      q.Name,
+		Type:      dns.Type(q.Qtype).String(),
+		Client:    netutil.NetAddrToAddrPort(pctx.Addr).Addr().String(),
+		Elapsed:   durationMs(time.Since(dctx.startTime)),
+	}
+
+	if pctx.Upstream != nil {
+		resp.Upstream = pctx.Upstream.Address()
+	} else {
+		resp.Upstream = pctx.CachedUpstreamAddr
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	if res := pctx.Res; res != nil {
+		resp.Rcode = dns.RcodeToString[res.Rcode]
+		resp.Response = res.String()
+
+		// Ignore the error, since the response has already been used.
+		resp.ResponseWire, _ = res.Pack()
+	}
+
+	return resp
+}
+
+// newQueryTraceFilteringJSON returns the JSON representation of filtering the
+// request in dctx.  resp is nil if the request hasn't reached the filtering.
+func newQueryTraceFilteringJSON(dctx *dnsContext) (resp *queryTraceFilteringJSON) {
+	setts := dctx.setts
+	if setts == nil {
+		return nil
+	}
+
+	res := dctx.result
+	resp = &queryTraceFilteringJSON{
+		Rules:               make([]*queryTraceRuleJSON, 0, len(res.Rules)),
+		DisabledFilterLists: append([]int64{}, setts.DisabledFilterLists...),
+		BlockedServices:     make([]string, 0, len(setts.ServicesRules)),
+		BlockedCategories:   append([]string{}, setts.BlockedCategories...),
+		ClientName:          setts.ClientName,
+		Reason:              res.Reason.String(),
+		ServiceName:         res.ServiceName,
+		Filtered:            res.IsFiltered,
+		ProtectionEnabled:   dctx.protectionEnabled,
+		FilteringEnabled:    setts.FilteringEnabled,
+		SafeBrowsingEnabled: setts.SafeBrowsingEnabled,
+		ParentalEnabled:     setts.ParentalEnabled,
+		SafeSearchEnabled:   setts.SafeSearchEnabled,
+	}
+
+	for _, rule := range res.Rules {
+		resp.Rules = append(resp.Rules, &queryTraceRuleJSON{
+			Text:         rule.Text,
+			FilterListID: rule.FilterListID,
+		})
+	}
+
+	for _, svc := range setts.ServicesRules {
+		resp.BlockedServices = append(resp.BlockedServices, svc.Name)
+	}
+
+	return resp
+}
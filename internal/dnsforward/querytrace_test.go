@@ -0,0 +1,152 @@
+package dnsforward
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_HandleQueryTrace(t *testing.T) {
+	h := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		resp := newTestCacheResp(m.Question[0].Name, 60)
+		resp.Id = m.Id
+
+		err := w.WriteMsg(resp)
+		require.NoError(testutil.PanicT{}, err)
+	})
+
+	ups := (&url.URL{
+		Scheme: "tcp",
+		Host:   newLocalUpstreamListener(t, 0, h).String(),
+	}).String()
+
+	s := createTestServer(t, &filtering.Config{
+		ProtectionEnabled: true,
+		BlockingMode:      filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		TCPListenAddrs: []*net.TCPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		Config: Config{
+			UpstreamDNS:      []string{ups},
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			CacheSize:        64 * 1024,
+		},
+	}, nil)
+	startDeferStop(t, s)
+
+	trace := func(t *testing.T, body string) (resp *queryTraceJSON) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, queryTraceAPI, bytes.NewBufferString(body))
+		s.handleQueryTrace(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp = &queryTraceJSON{}
+		err := json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	t.Run("upstream", func(t *testing.T) {
+		resp := trace(t, `{"name":"example.org","client":"192.168.1.1"}`)
+		assert.Equal(t, "example.org.", resp.Name)
+		assert.Equal(t, "A", resp.Type)
+		assert.Equal(t, "192.168.1.1", resp.Client)
+		assert.Equal(t, ups, resp.Upstream)
+		assert.Equal(t, "NOERROR", resp.Rcode)
+		assert.Empty(t, resp.Error)
+
+		msg := &dns.Msg{}
+		err := msg.Unpack(resp.ResponseWire)
+		require.NoError(t, err)
+		require.Len(t, msg.Answer, 1)
+
+		assert.Equal(t, msg.String(), resp.Response)
+
+		require.NotNil(t, resp.Cache)
+		require.NotNil(t, resp.Cache.Shard)
+
+		assert.Equal(t, cacheStatusMiss, resp.Cache.Status)
+		assert.Less(t, *resp.Cache.Shard, dnsCacheShards)
+
+		names := make([]string, 0, len(resp.Stages))
+		for _, st := range resp.Stages {
+			names = append(names, st.Name)
+			assert.Equal(t, resultCodeSuccess.String(), st.Result)
+		}
+
+		assert.Contains(t, names, "upstream")
+		assert.Equal(t, "querylog_and_stats", names[len(names)-1])
+
+		cached := trace(t, `{"name":"example.org","client":"192.168.1.1"}`)
+		require.NotNil(t, cached.Cache)
+
+		assert.Equal(t, cacheStatusHit, cached.Cache.Status)
+		assert.Equal(t, resp.Cache.Shard, cached.Cache.Shard)
+		assert.Equal(t, ups, cached.Upstream)
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		resp := trace(t, `{"name":"nxdomain.example.org","type":"aaaa"}`)
+		assert.Equal(t, "AAAA", resp.Type)
+		assert.Equal(t, "127.0.0.1", resp.Client)
+		assert.Empty(t, resp.Upstream)
+		assert.Nil(t, resp.Cache)
+
+		require.NotNil(t, resp.Filtering)
+
+		assert.True(t, resp.Filtering.Filtered)
+		assert.Equal(t, filtering.FilteredBlockList.String(), resp.Filtering.Reason)
+
+		require.Len(t, resp.Filtering.Rules, 1)
+
+		assert.Equal(t, "||nxdomain.example.org", resp.Filtering.Rules[0].Text)
+	})
+}
+
+func TestNewQueryTraceContext(t *testing.T) {
+	testCases := []struct {
+		req        *queryTraceReq
+		name       string
+		wantErrMsg string
+	}{{
+		req:        &queryTraceReq{Name: "example.org", Type: "TXT", Client: "::1"},
+		name:       "good",
+		wantErrMsg: "",
+	}, {
+		req:        &queryTraceReq{},
+		name:       "empty_name",
+		wantErrMsg: "name: empty",
+	}, {
+		req:        &queryTraceReq{Name: "example..org"},
+		name:       "bad_name",
+		wantErrMsg: `name: bad domain name "example..org"`,
+	}, {
+		req:        &queryTraceReq{Name: "example.org", Type: "BAD"},
+		name:       "bad_type",
+		wantErrMsg: `type: unknown type "BAD"`,
+	}, {
+		req:        &queryTraceReq{Name: "example.org", Client: "bad"},
+		name:       "bad_client",
+		wantErrMsg: `client: ParseAddr("bad"): unable to parse IP`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newQueryTraceContext(tc.req)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// QueryTypeAction is the action performed on the requests with the query types
+// matching a [QueryTypePolicyConfig].
+type QueryTypeAction string
+
+// Query type actions.
+const (
+	// QueryTypeActionRefuse responds to the matching requests with REFUSED.
+	QueryTypeActionRefuse QueryTypeAction = "refuse"
+
+	// QueryTypeActionNoData responds to the matching requests with NODATA.
+	QueryTypeActionNoData QueryTypeAction = "nodata"
+
+	// QueryTypeActionStrip responds to the matching requests with NODATA and
+	// also removes the records of the matching types from all the upstream
+	// responses, for example the HTTPS records from the additional section.
+	QueryTypeActionStrip QueryTypeAction = "strip"
+)
+
+// QueryTypePolicyConfig is the configuration of a policy for the requests with
+// particular query types.
+type QueryTypePolicyConfig struct {
+	// Action is the action performed on the matching requests.
+	Action QueryTypeAction `yaml:"action"`
+
+	// Types are the names of the query types, for example "ANY" or "HTTPS".
+	Types []string `yaml:"types"`
+
+	// Clients are the ClientIDs, IP addresses, CIDR subnets, and names of the
+	// persistent clients, to which the policy applies.  The policy applies to
+	// all clients if both Clients and Tags are empty.
+	Clients []string `yaml:"clients"`
+
+	// Tags are the tags of the persistent clients, to which the policy
+	// applies.
+	Tags []string `yaml:"tags"`
+}
+
+// queryTypePolicy is the query type policy prepared for use.
+type queryTypePolicy struct {
+	action QueryTypeAction
+
+	// types are the matching query types.
+	types []uint16
+
+	// ids are the ClientIDs and the names of the persistent clients.
+	ids *stringutil.Set
+
+	// tags are the tags of the persistent clients.
+	tags *stringutil.Set
+
+	// subnets are the IP addresses and the CIDR subnets.  The single IP
+	// addresses are kept as single-address prefixes.
+	subnets []netip.Prefix
+}
+
+// newQueryTypePolicies validates confs and returns the policies for them.
+func newQueryTypePolicies(confs []*QueryTypePolicyConfig) (ps []*queryTypePolicy, err error) {
+	for i, c := range confs {
+		var p *queryTypePolicy
+		p, err = newQueryTypePolicy(c)
+		if err != nil {
+			return nil, fmt.Errorf("query type policy at index %d: %w", i, err)
+		}
+
+		ps = append(ps, p)
+	}
+
+	return ps, nil
+}
+
+// newQueryTypePolicy validates conf and returns the policy for it.
+func newQueryTypePolicy(conf *QueryTypePolicyConfig) (p *queryTypePolicy, err error) {
+	if conf == nil {
+		return nil, errors.Error("no value")
+	}
+
+	switch conf.Action {
+	case QueryTypeActionRefuse, QueryTypeActionNoData, QueryTypeActionStrip:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("bad action %q", conf.Action)
+	}
+
+	if len(conf.Types) == 0 {
+		return nil, errors.Error("no types")
+	}
+
+	p = &queryTypePolicy{
+		action: conf.Action,
+		ids:    stringutil.NewSet(),
+		tags:   stringutil.NewSet(conf.Tags...),
+	}
+
+	for _, name := range conf.Types {
+		qt, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q", name)
+		}
+
+		p.types = append(p.types, qt)
+	}
+
+	for _, c := range conf.Clients {
+		var pref netip.Prefix
+		pref, err = parsePolicyClient(c)
+		if err != nil {
+			return nil, fmt.Errorf("client %q: %w", c, err)
+		} else if pref.IsValid() {
+			p.subnets = append(p.subnets, pref)
+		} else {
+			p.ids.Add(c)
+		}
+	}
+
+	return p, nil
+}
+
+// parsePolicyClient parses c as an IP address or a CIDR subnet.  pref is
+// invalid if c is neither, which means that c is a ClientID or a name.
+func parsePolicyClient(c string) (pref netip.Prefix, err error) {
+	if strings.Contains(c, "/") {
+		pref, err = netip.ParsePrefix(c)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return netip.Prefix{}, err
+		}
+
+		return pref.Masked(), nil
+	}
+
+	ip, err := netip.ParseAddr(c)
+	if err != nil {
+		return netip.Prefix{}, nil
+	}
+
+	return netip.PrefixFrom(ip.Unmap(), ip.BitLen()), nil
+}
+
+// matchesClient returns true if p applies to the client with the filtering
+// settings setts, clientID, and ip.
+func (p *queryTypePolicy) matchesClient(
+	setts *filtering.Settings,
+	clientID string,
+	ip netip.Addr,
+) (ok bool) {
+	if p.ids.Len() == 0 && p.tags.Len() == 0 && len(p.subnets) == 0 {
+		return true
+	}
+
+	if (clientID != "" && p.ids.Has(clientID)) ||
+		(setts.ClientName != "" && p.ids.Has(setts.ClientName)) {
+		return true
+	}
+
+	for _, t := range setts.ClientTags {
+		if p.tags.Has(t) {
+			return true
+		}
+	}
+
+	ip = ip.Unmap()
+	for _, pref := range p.subnets {
+		if pref.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processQueryTypePolicy applies the query type policies matching the client
+// to the request.  The stripped types are stored in dctx for
+// [Server.processQueryTypeStrip].
+func (s *Server) processQueryTypePolicy(dctx *dnsContext) (rc resultCode) {
+	if len(s.queryTypePolicies) == 0 {
+		return resultCodeSuccess
+	}
+
+	log.Debug("dnsforward: started processing query type policy")
+	defer log.Debug("dnsforward: finished processing query type policy")
+
+	pctx := dctx.proxyCtx
+	req := pctx.Req
+	qt := req.Question[0].Qtype
+	ip := netutil.NetAddrToAddrPort(pctx.Addr).Addr()
+
+	var action QueryTypeAction
+	for _, p := range s.queryTypePolicies {
+		if !p.matchesClient(dctx.setts, dctx.clientID, ip) {
+			continue
+		}
+
+		if p.action == QueryTypeActionStrip {
+			dctx.strippedTypes = append(dctx.strippedTypes, p.types...)
+		}
+
+		if action == "" && slices.Contains(p.types, qt) {
+			action = p.action
+		}
+	}
+
+	switch action {
+	case "":
+		return resultCodeSuccess
+	case QueryTypeActionRefuse:
+		pctx.Res = s.makeResponseREFUSED(req)
+	default:
+		pctx.Res = s.newMsgNODATA(req)
+	}
+
+	log.Debug("dnsforward: query type policy: %s for %s", action, dns.Type(qt))
+
+	dctx.result = &filtering.Result{
+		Reason:     filtering.FilteredQueryType,
+		IsFiltered: true,
+	}
+
+	return resultCodeSuccess
+}
+
+// processQueryTypeStrip removes the records of the stripped types from the
+// upstream response.
+func (s *Server) processQueryTypeStrip(dctx *dnsContext) (rc resultCode) {
+	pctx := dctx.proxyCtx
+	if len(dctx.strippedTypes) == 0 || !dctx.responseFromUpstream || pctx.Res == nil {
+		return resultCodeSuccess
+	}
+
+	strip := func(rrs []dns.RR) (res []dns.RR) {
+		return slices.DeleteFunc(rrs, func(rr dns.RR) (del bool) {
+			return slices.Contains(dctx.strippedTypes, rr.Header().Rrtype)
+		})
+	}
+
+	resp := pctx.Res
+	resp.Answer, resp.Ns, resp.Extra = strip(resp.Answer), strip(resp.Ns), strip(resp.Extra)
+
+	return resultCodeSuccess
+}
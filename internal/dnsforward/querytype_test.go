@@ -0,0 +1,196 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQueryTypePolicies(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*QueryTypePolicyConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs: []*QueryTypePolicyConfig{{
+			Action:  QueryTypeActionStrip,
+			Types:   []string{"https", "SVCB"},
+			Clients: []string{"192.0.2.0/24", "laptop"},
+			Tags:    []string{"device_tablet"},
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "query type policy at index 0: no value",
+		confs:      []*QueryTypePolicyConfig{nil},
+	}, {
+		name:       "bad_action",
+		wantErrMsg: `query type policy at index 0: bad action "drop"`,
+		confs: []*QueryTypePolicyConfig{{
+			Action: "drop",
+			Types:  []string{"ANY"},
+		}},
+	}, {
+		name:       "no_types",
+		wantErrMsg: "query type policy at index 0: no types",
+		confs: []*QueryTypePolicyConfig{{
+			Action: QueryTypeActionRefuse,
+		}},
+	}, {
+		name:       "bad_type",
+		wantErrMsg: `query type policy at index 0: unknown type "BAD"`,
+		confs: []*QueryTypePolicyConfig{{
+			Action: QueryTypeActionRefuse,
+			Types:  []string{"BAD"},
+		}},
+	}, {
+		name: "bad_subnet",
+		wantErrMsg: `query type policy at index 0: client "192.0.2.0/33": ` +
+			`netip.ParsePrefix("192.0.2.0/33"): prefix length out of range`,
+		confs: []*QueryTypePolicyConfig{{
+			Action:  QueryTypeActionRefuse,
+			Types:   []string{"ANY"},
+			Clients: []string{"192.0.2.0/33"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newQueryTypePolicies(tc.confs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestServer_ProcessQueryTypePolicy(t *testing.T) {
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		Config: Config{
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			QueryTypePolicies: []*QueryTypePolicyConfig{{
+				Action: QueryTypeActionRefuse,
+				Types:  []string{"ANY"},
+			}, {
+				Action: QueryTypeActionStrip,
+				Types:  []string{"HTTPS"},
+			}, {
+				Action:  QueryTypeActionNoData,
+				Types:   []string{"AAAA"},
+				Clients: []string{"1.2.3.0/24"},
+			}, {
+				Action: QueryTypeActionNoData,
+				Types:  []string{"MX"},
+				Tags:   []string{"device_tablet"},
+			}},
+		},
+	}, nil)
+
+	testCases := []struct {
+		setts      *filtering.Settings
+		name       string
+		qtype      uint16
+		wantRcode  int
+		wantReason filtering.Reason
+	}{{
+		setts:      &filtering.Settings{},
+		name:       "any",
+		qtype:      dns.TypeANY,
+		wantRcode:  dns.RcodeRefused,
+		wantReason: filtering.FilteredQueryType,
+	}, {
+		setts:      &filtering.Settings{},
+		name:       "strip",
+		qtype:      dns.TypeHTTPS,
+		wantRcode:  dns.RcodeSuccess,
+		wantReason: filtering.FilteredQueryType,
+	}, {
+		setts:      &filtering.Settings{},
+		name:       "subnet",
+		qtype:      dns.TypeAAAA,
+		wantRcode:  dns.RcodeSuccess,
+		wantReason: filtering.FilteredQueryType,
+	}, {
+		setts:      &filtering.Settings{},
+		name:       "other_tag",
+		qtype:      dns.TypeMX,
+		wantRcode:  -1,
+		wantReason: filtering.NotFilteredNotFound,
+	}, {
+		setts:      &filtering.Settings{ClientTags: []string{"device_tablet"}},
+		name:       "tag",
+		qtype:      dns.TypeMX,
+		wantRcode:  dns.RcodeSuccess,
+		wantReason: filtering.FilteredQueryType,
+	}, {
+		setts:      &filtering.Settings{},
+		name:       "not_matched",
+		qtype:      dns.TypeA,
+		wantRcode:  -1,
+		wantReason: filtering.NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dctx := &dnsContext{
+				setts:  tc.setts,
+				result: &filtering.Result{},
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   createTestMessageWithType(aghtest.ReqFQDN, tc.qtype),
+					Addr:  testClientAddr,
+				},
+			}
+
+			require.Equal(t, resultCodeSuccess, s.processQueryTypePolicy(dctx))
+
+			assert.Equal(t, tc.wantReason, dctx.result.Reason)
+			assert.Equal(t, []uint16{dns.TypeHTTPS}, dctx.strippedTypes)
+			if tc.wantRcode == -1 {
+				assert.Nil(t, dctx.proxyCtx.Res)
+			} else {
+				require.NotNil(t, dctx.proxyCtx.Res)
+
+				assert.Equal(t, tc.wantRcode, dctx.proxyCtx.Res.Rcode)
+				assert.Empty(t, dctx.proxyCtx.Res.Answer)
+			}
+		})
+	}
+
+	t.Run("strip_response", func(t *testing.T) {
+		req := createTestMessageWithType(aghtest.ReqFQDN, dns.TypeA)
+		resp := newResp(dns.RcodeSuccess, req, []dns.RR{
+			newRR(t, aghtest.ReqFQDN, dns.TypeA, 3600, net.IP{1, 2, 3, 4}),
+		})
+		resp.Extra = []dns.RR{&dns.HTTPS{SVCB: dns.SVCB{
+			Hdr: dns.RR_Header{
+				Name:   aghtest.ReqFQDN,
+				Rrtype: dns.TypeHTTPS,
+				Class:  dns.ClassINET,
+			},
+			Target: ".",
+		}}}
+
+		dctx := &dnsContext{
+			proxyCtx: &proxy.DNSContext{
+				Req: req,
+				Res: resp,
+			},
+			responseFromUpstream: true,
+			strippedTypes:        []uint16{dns.TypeHTTPS},
+		}
+
+		require.Equal(t, resultCodeSuccess, s.processQueryTypeStrip(dctx))
+
+		assert.Len(t, resp.Answer, 1)
+		assert.Empty(t, resp.Extra)
+	})
+}
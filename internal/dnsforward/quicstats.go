@@ -0,0 +1,261 @@
+package dnsforward
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// quicUpstreamStats are the statistics of the QUIC connections to a single
+// DNS-over-QUIC upstream.
+type quicUpstreamStats struct {
+	// Address is the address of the upstream.
+	Address string `json:"address"`
+
+	// RemoteAddr is the remote address of the latest connection.
+	RemoteAddr string `json:"remote_addr"`
+
+	// Connections is the number of connections started, that is the number of
+	// handshakes performed.
+	Connections uint64 `json:"connections"`
+
+	// Resumed is the number of connections that have tried to resume the
+	// previous session.
+	Resumed uint64 `json:"resumed"`
+
+	// ZeroRTT is the number of connections that have sent the queries as 0-RTT
+	// data.
+	ZeroRTT uint64 `json:"zero_rtt"`
+
+	// Closed is the number of connections closed.
+	Closed uint64 `json:"closed"`
+
+	// IdleTimeouts is the number of connections closed due to the idle
+	// timeout, which usually means that the network has changed.
+	IdleTimeouts uint64 `json:"idle_timeouts"`
+
+	// LostPackets is the number of packets considered lost.
+	LostPackets uint64 `json:"lost_packets"`
+
+	// BytesSent is the number of bytes sent in all the packets.
+	BytesSent uint64 `json:"bytes_sent"`
+
+	// BytesReceived is the number of bytes received in all the packets.
+	BytesReceived uint64 `json:"bytes_received"`
+
+	// SmoothedRTT is the latest smoothed round-trip time in milliseconds.
+	SmoothedRTT float64 `json:"smoothed_rtt_ms"`
+}
+
+// quicStats collects the statistics of the QUIC connections to the
+// DNS-over-QUIC upstreams.  The statistics are kept across the
+// reconfigurations of the server.  It only observes the connections, the
+// session resumption and 0-RTT are handled by the DNS-over-QUIC client itself.
+type quicStats struct {
+	// mu protects upstreams.
+	mu *sync.Mutex
+
+	// upstreams are the statistics by the upstream address.
+	upstreams map[string]*quicUpstreamStats
+}
+
+// newQUICStats returns a new properly initialized *quicStats.
+func newQUICStats() (s *quicStats) {
+	return &quicStats{
+		mu:        &sync.Mutex{},
+		upstreams: map[string]*quicUpstreamStats{},
+	}
+}
+
+// update calls f with the statistics of the upstream with addr locked.
+func (s *quicStats) update(addr string, f func(us *quicUpstreamStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	us, ok := s.upstreams[addr]
+	if !ok {
+		us = &quicUpstreamStats{
+			Address: addr,
+		}
+		s.upstreams[addr] = us
+	}
+
+	f(us)
+}
+
+// list returns the copies of the statistics sorted by the upstream address.
+func (s *quicStats) list() (stats []*quicUpstreamStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := maps.Keys(s.upstreams)
+	slices.Sort(addrs)
+
+	stats = make([]*quicUpstreamStats, 0, len(addrs))
+	for _, addr := range addrs {
+		us := *s.upstreams[addr]
+		stats = append(stats, &us)
+	}
+
+	return stats
+}
+
+// tracer returns the function tracing the connections to the upstream with
+// addr.
+func (s *quicStats) tracer(addr string) (f upstream.QUICTraceFunc) {
+	return func(
+		_ context.Context,
+		_ logging.Perspective,
+		_ quic.ConnectionID,
+	) (tracer logging.ConnectionTracer) {
+		return &quicConnTracer{
+			stats: s,
+			addr:  addr,
+		}
+	}
+}
+
+// quicConnTracer is a [logging.ConnectionTracer] collecting the statistics of
+// a single connection.
+type quicConnTracer struct {
+	logging.NullConnectionTracer
+
+	// stats is the collector of the statistics.
+	stats *quicStats
+
+	// addr is the address of the upstream.
+	addr string
+
+	// sentZeroRTT is true if the connection has already sent a 0-RTT packet.
+	// It's only accessed from the connection's goroutine.
+	sentZeroRTT bool
+}
+
+// type check
+var _ logging.ConnectionTracer = (*quicConnTracer)(nil)
+
+// StartedConnection implements the [logging.ConnectionTracer] interface for
+// *quicConnTracer.
+func (t *quicConnTracer) StartedConnection(_, remote net.Addr, _, _ logging.ConnectionID) {
+	t.stats.update(t.addr, func(us *quicUpstreamStats) {
+		us.Connections++
+		us.RemoteAddr = remote.String()
+	})
+}
+
+// RestoredTransportParameters implements the [logging.ConnectionTracer]
+// interface for *quicConnTracer.
+func (t *quicConnTracer) RestoredTransportParameters(_ *logging.TransportParameters) {
+	t.stats.update(t.addr, func(us *quicUpstreamStats) { us.Resumed++ })
+}
+
+// ClosedConnection implements the [logging.ConnectionTracer] interface for
+// *quicConnTracer.
+func (t *quicConnTracer) ClosedConnection(err error) {
+	var idleErr *quic.IdleTimeoutError
+	isIdle := errors.As(err, &idleErr)
+
+	t.stats.update(t.addr, func(us *quicUpstreamStats) {
+		us.Closed++
+		if isIdle {
+			us.IdleTimeouts++
+		}
+	})
+}
+
+// SentLongHeaderPacket implements the [logging.ConnectionTracer] interface for
+// *quicConnTracer.
+func (t *quicConnTracer) SentLongHeaderPacket(
+	hdr *logging.ExtendedHeader,
+	size logging.ByteCount,
+	_ *logging.AckFrame,
+	_ []logging.Frame,
+) {
+	isFirstZeroRTT := false
+	if !t.sentZeroRTT && logging.PacketTypeFromHeader(&hdr.Header) == logging.PacketType0RTT {
+		t.sentZeroRTT = true
+		isFirstZeroRTT = true
+	}
+
+	t.stats.update(t.addr, func(us *quicUpstreamStats) {
+		us.BytesSent += uint64(size)
+		if isFirstZeroRTT {
+			us.ZeroRTT++
+		}
+	})
+}
+
+// SentShortHeaderPacket implements the [logging.ConnectionTracer] interface
+// for *quicConnTracer.
+func (t *quicConnTracer) SentShortHeaderPacket(
+	_ *logging.ShortHeader,
+	size logging.ByteCount,
+	_ *logging.AckFrame,
+	_ []logging.Frame,
+) {
+	t.stats.update(t.addr, func(us *quicUpstreamStats) { us.BytesSent += uint64(size) })
+}
+
+// ReceivedLongHeaderPacket implements the [logging.ConnectionTracer] interface
+// for *quicConnTracer.
+func (t *quicConnTracer) ReceivedLongHeaderPacket(
+	_ *logging.ExtendedHeader,
+	size logging.ByteCount,
+	_ []logging.Frame,
+) {
+	t.stats.update(t.addr, func(us *quicUpstreamStats) { us.BytesReceived += uint64(size) })
+}
+
+// ReceivedShortHeaderPacket implements the [logging.ConnectionTracer]
+// interface for *quicConnTracer.
+func (t *quicConnTracer) ReceivedShortHeaderPacket(
+	_ *logging.ShortHeader,
+	size logging.ByteCount,
+	_ []logging.Frame,
+) {
+	t.stats.update(t.addr, func(us *quicUpstreamStats) { us.BytesReceived += uint64(size) })
+}
+
+// LostPacket implements the [logging.ConnectionTracer] interface for
+// *quicConnTracer.
+func (t *quicConnTracer) LostPacket(
+	_ logging.EncryptionLevel,
+	_ logging.PacketNumber,
+	_ logging.PacketLossReason,
+) {
+	t.stats.update(t.addr, func(us *quicUpstreamStats) { us.LostPackets++ })
+}
+
+// UpdatedMetrics implements the [logging.ConnectionTracer] interface for
+// *quicConnTracer.
+func (t *quicConnTracer) UpdatedMetrics(
+	rttStats *logging.RTTStats,
+	_ logging.ByteCount,
+	_ logging.ByteCount,
+	_ int,
+) {
+	rtt := float64(rttStats.SmoothedRTT().Microseconds()) / 1000
+	t.stats.update(t.addr, func(us *quicUpstreamStats) { us.SmoothedRTT = rtt })
+}
+
+// quicStatsJSON is the JSON representation of the QUIC statistics.
+type quicStatsJSON struct {
+	Upstreams []*quicUpstreamStats `json:"upstreams"`
+}
+
+// handleQUICStats is the handler for the GET /control/upstreams/quic_stats
+// HTTP API.
+func (s *Server) handleQUICStats(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &quicStatsJSON{
+		Upstreams: s.quicStats.list(),
+	})
+}
@@ -0,0 +1,43 @@
+package dnsforward
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICStats_tracer(t *testing.T) {
+	const addr = "quic://dns.example"
+
+	qs := newQUICStats()
+	remote := &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 853}
+
+	tracer := qs.tracer(addr)(context.Background(), logging.PerspectiveClient, quic.ConnectionID{})
+	tracer.StartedConnection(nil, remote, logging.ConnectionID{}, logging.ConnectionID{})
+	tracer.RestoredTransportParameters(&logging.TransportParameters{})
+
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{}, 100, nil, nil)
+	tracer.ReceivedShortHeaderPacket(&logging.ShortHeader{}, 50, nil)
+	tracer.LostPacket(logging.Encryption1RTT, 1, logging.PacketLossTimeThreshold)
+	tracer.ClosedConnection(&quic.IdleTimeoutError{})
+
+	stats := qs.list()
+	require.Len(t, stats, 1)
+
+	assert.Equal(t, &quicUpstreamStats{
+		Address:       addr,
+		RemoteAddr:    remote.String(),
+		Connections:   1,
+		Resumed:       1,
+		Closed:        1,
+		IdleTimeouts:  1,
+		LostPackets:   1,
+		BytesSent:     100,
+		BytesReceived: 50,
+	}, stats[0])
+}
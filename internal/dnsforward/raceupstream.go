@@ -0,0 +1,334 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// DefaultUpstreamHedgeDelay is the default delay, after which the query is
+// also sent to the second fastest upstream in the race mode.
+const DefaultUpstreamHedgeDelay = 50 * time.Millisecond
+
+// raceCandidates is the number of the fastest upstreams each query is raced
+// between.
+const raceCandidates = 2
+
+// raceEWMAWeight is the weight of the latest round-trip time in the
+// exponentially weighted moving average of the round-trip times.
+const raceEWMAWeight = 0.2
+
+// raceStaleAfter is the duration, after which the estimate of an upstream not
+// chosen for the race is refreshed by sending it a copy of a query.
+const raceStaleAfter = 1 * time.Minute
+
+// raceEstimate is the estimated round-trip time of a single upstream.
+type raceEstimate struct {
+	// updated is the time of the latest measurement.
+	updated time.Time
+
+	// ewma is the exponentially weighted moving average of the round-trip
+	// times.
+	ewma time.Duration
+}
+
+// raceStats keeps the estimated round-trip times of the upstreams by their
+// addresses.  The estimates are kept across the reconfigurations of the
+// server.
+type raceStats struct {
+	// mu protects estimates.
+	mu *sync.Mutex
+
+	// estimates are the estimates by the upstream address.
+	estimates map[string]*raceEstimate
+}
+
+// newRaceStats returns a new properly initialized *raceStats.
+func newRaceStats() (s *raceStats) {
+	return &raceStats{
+		mu:        &sync.Mutex{},
+		estimates: map[string]*raceEstimate{},
+	}
+}
+
+// record updates the estimate of the upstream with addr with rtt.
+func (s *raceStats) record(addr string, rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.estimates[addr]
+	if !ok {
+		s.estimates[addr] = &raceEstimate{
+			updated: time.Now(),
+			ewma:    rtt,
+		}
+
+		return
+	}
+
+	e.ewma = time.Duration(raceEWMAWeight*float64(rtt) + (1-raceEWMAWeight)*float64(e.ewma))
+	e.updated = time.Now()
+}
+
+// candidates returns the fastest upstreams from ups, starting from the fastest
+// one.  The upstreams without an estimate are considered the fastest, so that
+// they are measured.  probe is the upstream, which estimate should be
+// refreshed, if any.
+func (s *raceStats) candidates(
+	ups []upstream.Upstream,
+) (cands []upstream.Upstream, probe upstream.Upstream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := slices.Clone(ups)
+	slices.SortStableFunc(sorted, func(a, b upstream.Upstream) (res int) {
+		rttA, rttB := s.ewma(a.Address()), s.ewma(b.Address())
+		switch {
+		case rttA < rttB:
+			return -1
+		case rttA > rttB:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	n := mathutil.Min(raceCandidates, len(sorted))
+	now := time.Now()
+	for _, u := range sorted[n:] {
+		if e := s.estimates[u.Address()]; e != nil && now.Sub(e.updated) > raceStaleAfter {
+			// Prevent the other queries from probing it at the same time.
+			e.updated = now
+
+			return sorted[:n], u
+		}
+	}
+
+	return sorted[:n], nil
+}
+
+// ewma returns the estimated round-trip time of the upstream with addr.
+// s.mu is expected to be locked.
+func (s *raceStats) ewma(addr string) (rtt time.Duration) {
+	if e := s.estimates[addr]; e != nil {
+		return e.ewma
+	}
+
+	return 0
+}
+
+// raceUpstream is an [upstream.Upstream] that sends each query to the fastest
+// of its upstreams and, if it doesn't respond within the hedging delay, also to
+// the second fastest, using the first valid response.
+type raceUpstream struct {
+	// stats are the estimated round-trip times of the upstreams.
+	stats *raceStats
+
+	// winners are the upstreams, which responses have been used, by the
+	// requests.  The upstream is only stored if the request is already in the
+	// map.
+	winners *sync.Map
+
+	// subnets are the subnets of the clients by the requests, see
+	// [Server.clientSubnets].
+	subnets *sync.Map
+
+	// ups are the upstreams to race.
+	ups []upstream.Upstream
+
+	// addr is the address of the upstream used in the logs.
+	addr string
+
+	// hedgeDelay is the delay, after which the query is sent to the second
+	// fastest upstream.
+	hedgeDelay time.Duration
+
+	// failRTT is the round-trip time recorded for the failed exchanges.
+	failRTT time.Duration
+}
+
+// type check
+var _ upstream.Upstream = (*raceUpstream)(nil)
+
+// wrapRaceUpstreams returns the upstream racing ups, unless there is only one
+// of them.
+func (s *Server) wrapRaceUpstreams(ups []upstream.Upstream) (res []upstream.Upstream) {
+	if len(ups) < 2 {
+		return ups
+	}
+
+	addrs := make([]string, 0, len(ups))
+	for _, u := range ups {
+		addrs = append(addrs, u.Address())
+	}
+
+	hedgeDelay := s.conf.UpstreamHedgeDelay.Duration
+	if hedgeDelay <= 0 {
+		hedgeDelay = DefaultUpstreamHedgeDelay
+	}
+
+	return []upstream.Upstream{&raceUpstream{
+		stats:      s.raceStats,
+		winners:    s.raceWinners,
+		subnets:    s.clientSubnets,
+		ups:        ups,
+		addr:       "race:" + strings.Join(addrs, ","),
+		hedgeDelay: hedgeDelay,
+		failRTT:    s.conf.UpstreamTimeout,
+	}}
+}
+
+// setRaceWinner replaces the race upstream in pctx with the upstream, which
+// response has been used, if any.
+func (s *Server) setRaceWinner(pctx *proxy.DNSContext) {
+	v, ok := s.raceWinners.LoadAndDelete(pctx.Req)
+	if !ok {
+		return
+	}
+
+	if winner, isUps := v.(upstream.Upstream); isUps && winner != nil {
+		pctx.Upstream = winner
+	}
+}
+
+// Address implements the [upstream.Upstream] interface for *raceUpstream.
+func (u *raceUpstream) Address() (addr string) {
+	return u.addr
+}
+
+// raceResult is the result of the exchange with a single upstream.
+type raceResult struct {
+	// resp is the response, if err is nil.
+	resp *dns.Msg
+
+	// err is the error of the exchange.
+	err error
+
+	// ups is the upstream.
+	ups upstream.Upstream
+}
+
+// Exchange implements the [upstream.Upstream] interface for *raceUpstream.
+func (u *raceUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	cands, probe := u.stats.candidates(u.ups)
+	if probe != nil {
+		log.Debug("dnsforward: race: probing %s", probe.Address())
+
+		u.start(probe, req, nil)
+	}
+
+	// Use a buffered channel, so that the losing exchanges don't block after
+	// the response has been returned.
+	resCh := make(chan *raceResult, len(cands))
+	u.start(cands[0], req, resCh)
+
+	timer := time.NewTimer(u.hedgeDelay)
+	defer timer.Stop()
+
+	started, finished := 1, 0
+	var errs []error
+	for finished < started {
+		select {
+		case res := <-resCh:
+			finished++
+			if res.err == nil && isValidRaceResp(res.resp) {
+				u.setWinner(req, res.ups)
+
+				return res.resp, nil
+			}
+
+			if res.err == nil {
+				// Keep the invalid response in case all the others fail.
+				resp = res.resp
+				res.err = fmt.Errorf("%s: rcode %s", res.ups.Address(), dns.RcodeToString[res.resp.Rcode])
+			}
+
+			errs = append(errs, res.err)
+		case <-timer.C:
+		}
+
+		// Start the next candidate either after the hedging delay or right
+		// after the previous one has failed.
+		if started < len(cands) {
+			log.Debug("dnsforward: race: hedging to %s", cands[started].Address())
+
+			u.start(cands[started], req, resCh)
+			started++
+		}
+	}
+
+	if resp != nil {
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("race: %w", errors.Join(errs...))
+}
+
+// start starts sending a copy of req to ups.  The copy is used, since the
+// exchange may still be going on after the response to req has been returned,
+// and req is modified further.
+func (u *raceUpstream) start(ups upstream.Upstream, req *dns.Msg, resCh chan<- *raceResult) {
+	reqCopy := req.Copy()
+	if subnet, ok := u.subnets.Load(req); ok {
+		u.subnets.Store(reqCopy, subnet)
+	}
+
+	go u.exchange(ups, reqCopy, resCh)
+}
+
+// exchange sends req to ups, records the round-trip time, and sends the result
+// to resCh, if it's not nil.
+func (u *raceUpstream) exchange(ups upstream.Upstream, req *dns.Msg, resCh chan<- *raceResult) {
+	defer log.OnPanic("dnsforward: race upstream")
+	defer u.subnets.Delete(req)
+
+	start := time.Now()
+	resp, err := ups.Exchange(req)
+
+	rtt := time.Since(start)
+	if err != nil {
+		rtt = mathutil.Max(rtt, u.failRTT)
+	}
+
+	u.stats.record(ups.Address(), rtt)
+
+	if resCh != nil {
+		resCh <- &raceResult{
+			resp: resp,
+			err:  err,
+			ups:  ups,
+		}
+	}
+}
+
+// setWinner stores ups as the upstream, which response has been used for req,
+// if req is expected to be there.
+func (u *raceUpstream) setWinner(req *dns.Msg, ups upstream.Upstream) {
+	if _, ok := u.winners.Load(req); ok {
+		u.winners.Store(req, ups)
+	}
+}
+
+// isValidRaceResp returns true if resp can be used as the result of the race.
+func isValidRaceResp(resp *dns.Msg) (ok bool) {
+	return resp.Rcode != dns.RcodeServerFailure && resp.Rcode != dns.RcodeRefused
+}
+
+// Close implements the [upstream.Upstream] interface for *raceUpstream.
+func (u *raceUpstream) Close() (err error) {
+	var errs []error
+	for _, ups := range u.ups {
+		errs = append(errs, ups.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,192 @@
+package dnsforward
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRaceUpstream returns a new upstream mock with addr, which responds
+// with rcode after delay, or fails, if rcode is negative, and counts the
+// exchanges in n.
+func newTestRaceUpstream(
+	addr string,
+	rcode int,
+	delay time.Duration,
+	n *atomic.Int32,
+) (u *aghtest.UpstreamMock) {
+	return &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return addr },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			n.Add(1)
+			time.Sleep(delay)
+
+			if rcode < 0 {
+				return nil, errors.Error("test error")
+			}
+
+			return (&dns.Msg{}).SetRcode(req, rcode), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+}
+
+func TestRaceStats_candidates(t *testing.T) {
+	s := newRaceStats()
+	s.record("a", 30*time.Millisecond)
+	s.record("b", 10*time.Millisecond)
+	s.record("c", 20*time.Millisecond)
+
+	var n atomic.Int32
+	ups := []upstream.Upstream{
+		newTestRaceUpstream("a", dns.RcodeSuccess, 0, &n),
+		newTestRaceUpstream("b", dns.RcodeSuccess, 0, &n),
+		newTestRaceUpstream("c", dns.RcodeSuccess, 0, &n),
+	}
+
+	cands, probe := s.candidates(ups)
+	require.Len(t, cands, raceCandidates)
+
+	assert.Equal(t, "b", cands[0].Address())
+	assert.Equal(t, "c", cands[1].Address())
+	assert.Nil(t, probe)
+
+	s.estimates["a"].updated = time.Now().Add(-2 * raceStaleAfter)
+
+	_, probe = s.candidates(ups)
+	require.NotNil(t, probe)
+
+	assert.Equal(t, "a", probe.Address())
+
+	_, probe = s.candidates(ups)
+	assert.Nil(t, probe)
+
+	ups = append(ups, newTestRaceUpstream("d", dns.RcodeSuccess, 0, &n))
+	cands, _ = s.candidates(ups)
+	require.Len(t, cands, raceCandidates)
+
+	assert.Equal(t, "d", cands[0].Address())
+
+	s.record("b", 110*time.Millisecond)
+	assert.Equal(t, 30*time.Millisecond, s.ewma("b"))
+}
+
+func TestRaceUpstream_Exchange(t *testing.T) {
+	const (
+		short = 10 * time.Millisecond
+		long  = 1 * time.Hour
+	)
+
+	testCases := []struct {
+		name       string
+		wantWinner string
+		firstRcode int
+		firstDelay time.Duration
+		hedge      time.Duration
+		wantRcode  int
+		wantSecond int32
+	}{{
+		name:       "first_wins",
+		wantWinner: "first",
+		firstRcode: dns.RcodeSuccess,
+		firstDelay: 0,
+		hedge:      long,
+		wantRcode:  dns.RcodeSuccess,
+		wantSecond: 0,
+	}, {
+		name:       "hedged",
+		wantWinner: "second",
+		firstRcode: dns.RcodeSuccess,
+		firstDelay: 10 * short,
+		hedge:      short,
+		wantRcode:  dns.RcodeSuccess,
+		wantSecond: 1,
+	}, {
+		name:       "first_fails",
+		wantWinner: "second",
+		firstRcode: -1,
+		firstDelay: 0,
+		hedge:      long,
+		wantRcode:  dns.RcodeSuccess,
+		wantSecond: 1,
+	}, {
+		name:       "first_servfail",
+		wantWinner: "second",
+		firstRcode: dns.RcodeServerFailure,
+		firstDelay: 0,
+		hedge:      long,
+		wantRcode:  dns.RcodeSuccess,
+		wantSecond: 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var firstN, secondN atomic.Int32
+			stats := newRaceStats()
+			stats.record("first", short)
+			stats.record("second", 2*short)
+
+			u := &raceUpstream{
+				stats:   stats,
+				winners: &sync.Map{},
+				subnets: &sync.Map{},
+				ups: []upstream.Upstream{
+					newTestRaceUpstream("second", dns.RcodeSuccess, 0, &secondN),
+					newTestRaceUpstream("first", tc.firstRcode, tc.firstDelay, &firstN),
+				},
+				addr:       "race:first,second",
+				hedgeDelay: tc.hedge,
+				failRTT:    time.Second,
+			}
+
+			req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+			u.winners.Store(req, nil)
+
+			resp, err := u.Exchange(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			assert.Equal(t, int32(1), firstN.Load())
+			assert.Equal(t, tc.wantSecond, secondN.Load())
+
+			v, ok := u.winners.Load(req)
+			require.True(t, ok)
+
+			winner, ok := v.(upstream.Upstream)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.wantWinner, winner.Address())
+		})
+	}
+
+	t.Run("all_fail", func(t *testing.T) {
+		var n atomic.Int32
+		u := &raceUpstream{
+			stats:   newRaceStats(),
+			winners: &sync.Map{},
+			subnets: &sync.Map{},
+			ups: []upstream.Upstream{
+				newTestRaceUpstream("first", -1, 0, &n),
+				newTestRaceUpstream("second", dns.RcodeServerFailure, 0, &n),
+			},
+			addr:       "race:first,second",
+			hedgeDelay: long,
+			failRTT:    time.Second,
+		}
+
+		req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+		resp, err := u.Exchange(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+		assert.Equal(t, int32(2), n.Load())
+	})
+}
@@ -0,0 +1,112 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// RebindProtectionConfig is the configuration of the DNS rebinding protection,
+// which rejects the upstream answers containing private IP addresses.
+type RebindProtectionConfig struct {
+	// AllowedDomains are the domain names, answers for which, as well as for
+	// their subdomains, are never rejected.
+	AllowedDomains []string `yaml:"allowed_domains"`
+
+	// AdditionalNetworks are the networks considered private in addition to
+	// the configured private networks, for example CGNAT or ULA ranges.
+	AdditionalNetworks []netip.Prefix `yaml:"additional_networks"`
+
+	// Enabled defines if the DNS rebinding protection is enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// isAllowed returns true if answers for host must not be checked.  host must
+// be in lower case and have no trailing dot.
+func (c *RebindProtectionConfig) isAllowed(host string) (ok bool) {
+	for _, d := range c.AllowedDomains {
+		d = strings.ToLower(strings.TrimSuffix(d, "."))
+		if host == d || netutil.IsSubdomain(host, d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRebindAddr returns true if ip is an address that must not be returned from
+// upstreams according to the rebinding protection.
+func (s *Server) isRebindAddr(ip netip.Addr) (ok bool) {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsUnspecified() {
+		// Unspecified addresses are commonly used by the upstreams for blocking
+		// and are safe.
+		return false
+	}
+
+	if s.privateNets != nil && s.privateNets.Contains(ip.AsSlice()) {
+		return true
+	}
+
+	for _, n := range s.conf.RebindProtection.AdditionalNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterRebind rejects the upstream response of dctx if it contains private IP
+// addresses and the protection is enabled.
+func (s *Server) filterRebind(dctx *dnsContext) {
+	conf := s.conf.RebindProtection
+	if conf == nil || !conf.Enabled {
+		return
+	}
+
+	pctx := dctx.proxyCtx
+	host := strings.ToLower(strings.TrimSuffix(pctx.Req.Question[0].Name, "."))
+	if conf.isAllowed(host) || s.isLocalDomain(host) {
+		return
+	}
+
+	for _, a := range pctx.Res.Answer {
+		var ip netip.Addr
+		switch a := a.(type) {
+		case *dns.A:
+			ip, _ = netip.AddrFromSlice(a.A)
+		case *dns.AAAA:
+			ip, _ = netip.AddrFromSlice(a.AAAA)
+		default:
+			continue
+		}
+
+		if !s.isRebindAddr(ip) {
+			continue
+		}
+
+		log.Info("dnsforward: rebind protection: rejected answer %s for %q", ip, host)
+
+		res := &filtering.Result{
+			Reason:     filtering.FilteredRebind,
+			IsFiltered: true,
+		}
+
+		dctx.result = res
+		dctx.origResp = pctx.Res
+		pctx.Res = s.genDNSFilterMessage(pctx, res)
+
+		return
+	}
+}
+
+// isLocalDomain returns true if host is within the local domain of the DHCP
+// server.  Answers for such domains normally contain private addresses.
+func (s *Server) isLocalDomain(host string) (ok bool) {
+	return netutil.IsSubdomain(host, s.localDomainSuffix)
+}
@@ -0,0 +1,257 @@
+package dnsforward
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// Reload applies conf to the DNS server.  If conf is nil, the current
+// configuration is reapplied.
+//
+// If the server is running and the settings of its listeners haven't changed,
+// the settings are applied in place: the listeners keep serving, the requests
+// already being processed are finished before the settings are applied, and
+// the incoming ones wait for it.  Otherwise, the server is restarted, and
+// restarted is true.
+func (s *Server) Reload(conf *ServerConfig) (restarted bool, err error) {
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+
+	s.serverLock.Lock()
+	defer s.serverLock.Unlock()
+
+	if !s.isRunning {
+		return true, s.restartLocked(conf)
+	}
+
+	log.Info("dnsforward: starting reloading server")
+	defer log.Info("dnsforward: finished reloading server")
+
+	prev := s.replaceableResolvers()
+	prevUpstreamTimeout := s.conf.UpstreamTimeout
+
+	err = s.prepareLocked(conf)
+	if err != nil {
+		// Don't leave the server running half-configured.
+		_ = s.stopLocked()
+		prev.close()
+
+		return false, fmt.Errorf("could not reload the server: %w", err)
+	}
+
+	if !listenersEqual(&s.listenerConf, &s.conf) {
+		log.Info("dnsforward: listeners changed, restarting")
+
+		s.stopListener()
+		prev.close()
+
+		return true, s.startAfterStopLocked()
+	}
+
+	err = s.dnsProxy.Init()
+	if err != nil {
+		_ = s.stopLocked()
+		prev.close()
+
+		return false, fmt.Errorf("could not reload the server: initializing proxy: %w", err)
+	}
+
+	s.secondary.start()
+
+	// Let the background exchanges, such as the optimistic cache refreshes,
+	// which may still use the previous upstreams, finish.
+	time.AfterFunc(prevUpstreamTimeout, prev.close)
+
+	return false, nil
+}
+
+// restartLocked stops the server, applies conf to it, and starts it again.
+// s.serverLock is expected to be locked.
+func (s *Server) restartLocked(conf *ServerConfig) (err error) {
+	err = s.stopLocked()
+	if err != nil {
+		return fmt.Errorf("could not reconfigure the server: %w", err)
+	}
+
+	err = s.prepareLocked(conf)
+	if err != nil {
+		return fmt.Errorf("could not reconfigure the server: %w", err)
+	}
+
+	return s.startAfterStopLocked()
+}
+
+// prepareLocked applies conf to s, or reapplies the current configuration if
+// conf is nil.  s.serverLock is expected to be locked.
+//
+// TODO(a.garipov): This whole piece of API is weird and needs to be remade.
+func (s *Server) prepareLocked(conf *ServerConfig) (err error) {
+	if conf == nil {
+		conf = &s.conf
+	} else {
+		closeErr := s.addrProc.Close()
+		if closeErr != nil {
+			log.Error("dnsforward: closing address processor: %s", closeErr)
+		}
+	}
+
+	return s.Prepare(conf)
+}
+
+// startAfterStopLocked starts the server after its listeners have been
+// stopped.  s.serverLock is expected to be locked.
+func (s *Server) startAfterStopLocked() (err error) {
+	// It seems that net.Listener.Close() doesn't close file descriptors right
+	// away.  We wait for some time and hope that this fd will be closed.
+	time.Sleep(100 * time.Millisecond)
+
+	err = s.startLocked()
+	if err != nil {
+		return fmt.Errorf("could not reconfigure the server: %w", err)
+	}
+
+	return nil
+}
+
+// stopListener stops the proxy serving the listeners, if any.
+func (s *Server) stopListener() {
+	if s.listener == nil {
+		return
+	}
+
+	err := s.listener.Stop()
+	if err != nil {
+		log.Error("dnsforward: closing primary resolvers: %s", err)
+	}
+
+	s.listener = nil
+}
+
+// resolvers are the resolvers of the server, which are replaced on each
+// reconfiguration.
+type resolvers struct {
+	// upstreamConfs are the upstream configurations, which aren't closed by
+	// stopping the listener.
+	upstreamConfs []*proxy.UpstreamConfig
+
+	upstreamFailure     *upstreamFailureHandler
+	forwardingEndpoints map[string]upstream.Upstream
+	secondary           *secondaryZones
+	dnsCrypt            *dnsCryptResolvers
+	dohRelays           []*dohRelay
+}
+
+// replaceableResolvers returns the current resolvers of s.  The upstream
+// configurations of s.dnsProxy are only included if it isn't the listener,
+// which closes them itself when stopped.
+func (s *Server) replaceableResolvers() (r *resolvers) {
+	r = &resolvers{
+		upstreamFailure:     s.upstreamFailure,
+		forwardingEndpoints: s.forwardingEndpoints,
+		secondary:           s.secondary,
+		dnsCrypt:            s.dnsCrypt,
+		dohRelays:           s.dohRelays,
+	}
+
+	if prx := s.dnsProxy; prx != nil && prx != s.listener {
+		r.upstreamConfs = append(
+			r.upstreamConfs,
+			prx.UpstreamConfig,
+			prx.PrivateRDNSUpstreamConfig,
+			prx.Fallbacks,
+		)
+	}
+
+	if s.internalProxy != nil {
+		r.upstreamConfs = append(r.upstreamConfs, s.internalProxy.UpstreamConfig)
+	}
+
+	if s.localResolvers != nil {
+		r.upstreamConfs = append(r.upstreamConfs, s.localResolvers.UpstreamConfig)
+	}
+
+	return r
+}
+
+// close closes the resolvers and logs the errors.
+func (r *resolvers) close() {
+	// TODO(e.burkov, a.garipov):  Return critical errors, not just log them.
+	// This will require filtering all the non-critical errors in
+	// [upstream.Upstream] implementations.
+	for _, upsConf := range r.upstreamConfs {
+		if upsConf == nil {
+			continue
+		}
+
+		err := upsConf.Close()
+		if err != nil {
+			log.Error("dnsforward: closing resolvers: %s", err)
+		}
+	}
+
+	err := r.upstreamFailure.close()
+	if err != nil {
+		log.Error("dnsforward: %s", err)
+	}
+
+	err = closeDoHRelays(r.dohRelays)
+	if err != nil {
+		log.Error("dnsforward: %s", err)
+	}
+
+	err = closeForwardingEndpoints(r.forwardingEndpoints)
+	if err != nil {
+		log.Error("dnsforward: %s", err)
+	}
+
+	r.secondary.close()
+	r.dnsCrypt.close()
+}
+
+// listenersEqual returns true if a and b have the same settings used by the
+// listeners, so that the server can be reconfigured from a to b without
+// restarting them.  The DNSCrypt listeners are always restarted.
+func listenersEqual(a, b *ServerConfig) (ok bool) {
+	return !a.DNSCryptConfig.Enabled &&
+		!b.DNSCryptConfig.Enabled &&
+		reflect.DeepEqual(a.UDPListenAddrs, b.UDPListenAddrs) &&
+		reflect.DeepEqual(a.TCPListenAddrs, b.TCPListenAddrs) &&
+		reflect.DeepEqual(a.Listeners, b.Listeners) &&
+		tlsListenersEqual(&a.TLSConfig, &b.TLSConfig) &&
+		a.TLSAllowUnencryptedDoH == b.TLSAllowUnencryptedDoH &&
+		slices.Equal(a.TLSCiphers, b.TLSCiphers) &&
+		a.Ratelimit == b.Ratelimit &&
+		slices.Equal(a.RatelimitWhitelist, b.RatelimitWhitelist) &&
+		a.RefuseAny == b.RefuseAny &&
+		slices.Equal(a.TrustedProxies, b.TrustedProxies) &&
+		a.MaxGoroutines == b.MaxGoroutines &&
+		a.ServeHTTP3 == b.ServeHTTP3 &&
+		a.UseDNS64 == b.UseDNS64 &&
+		slices.Equal(a.DNS64Prefixes, b.DNS64Prefixes)
+}
+
+// tlsListenersEqual returns true if a and b have the same settings used by the
+// encrypted listeners.
+func tlsListenersEqual(a, b *TLSConfig) (ok bool) {
+	return reflect.DeepEqual(a.TLSListenAddrs, b.TLSListenAddrs) &&
+		reflect.DeepEqual(a.QUICListenAddrs, b.QUICListenAddrs) &&
+		reflect.DeepEqual(a.HTTPSListenAddrs, b.HTTPSListenAddrs) &&
+		bytes.Equal(a.CertificateChainData, b.CertificateChainData) &&
+		bytes.Equal(a.PrivateKeyData, b.PrivateKeyData) &&
+		reflect.DeepEqual(a.PrivateKeySigner, b.PrivateKeySigner) &&
+		reflect.DeepEqual(a.AdditionalCertificates, b.AdditionalCertificates) &&
+		a.ClientCAs.Equal(b.ClientCAs) &&
+		a.ClientCertField == b.ClientCertField &&
+		a.RequireClientCert == b.RequireClientCert &&
+		reflect.DeepEqual(a.ECHKeys, b.ECHKeys) &&
+		slices.Equal(a.OverrideTLSCiphers, b.OverrideTLSCiphers) &&
+		a.StrictSNICheck == b.StrictSNICheck &&
+		a.ServerName == b.ServerName
+}
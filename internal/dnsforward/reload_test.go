@@ -0,0 +1,136 @@
+package dnsforward
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenersEqual(t *testing.T) {
+	newConf := func() (c *ServerConfig) {
+		return &ServerConfig{
+			UDPListenAddrs: []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}, Port: 53}},
+			TCPListenAddrs: []*net.TCPAddr{{IP: net.IP{127, 0, 0, 1}, Port: 53}},
+			TLSConfig: TLSConfig{
+				CertificateChainData: []byte("cert"),
+				PrivateKeyData:       []byte("key"),
+			},
+			Config: Config{
+				Ratelimit:   20,
+				UpstreamDNS: []string{"1.1.1.1"},
+			},
+		}
+	}
+
+	testCases := []struct {
+		modify func(c *ServerConfig)
+		name   string
+		want   bool
+	}{{
+		modify: func(_ *ServerConfig) {},
+		name:   "same",
+		want:   true,
+	}, {
+		modify: func(c *ServerConfig) { c.UpstreamDNS = []string{"8.8.8.8"} },
+		name:   "upstreams",
+		want:   true,
+	}, {
+		modify: func(c *ServerConfig) { c.UDPListenAddrs[0].Port = 5353 },
+		name:   "udp_port",
+		want:   false,
+	}, {
+		modify: func(c *ServerConfig) { c.PrivateKeyData = []byte("other key") },
+		name:   "private_key",
+		want:   false,
+	}, {
+		modify: func(c *ServerConfig) { c.Ratelimit = 0 },
+		name:   "ratelimit",
+		want:   false,
+	}, {
+		modify: func(c *ServerConfig) { c.DNSCryptConfig.Enabled = true },
+		name:   "dnscrypt",
+		want:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newConf()
+			tc.modify(b)
+
+			assert.Equal(t, tc.want, listenersEqual(newConf(), b))
+		})
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	newUps := func(rcode int) (addr string) {
+		h := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+			err := w.WriteMsg((&dns.Msg{}).SetRcode(m, rcode))
+			require.NoError(testutil.PanicT{}, err)
+		})
+
+		u := &url.URL{
+			Scheme: "tcp",
+			Host:   newLocalUpstreamListener(t, 0, h).String(),
+		}
+
+		return u.String()
+	}
+
+	firstUps, secondUps := newUps(dns.RcodeSuccess), newUps(dns.RcodeNameError)
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		TCPListenAddrs: []*net.TCPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		Config: Config{
+			UpstreamDNS:      []string{firstUps},
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+	}, nil)
+	startDeferStop(t, s)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+	client := &dns.Client{}
+
+	resp, _, err := client.Exchange(createTestMessage("example.org."), addr)
+	require.NoError(t, err)
+
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+
+	conf := s.conf
+	conf.UpstreamDNS = []string{secondUps}
+
+	restarted, err := s.Reload(&conf)
+	require.NoError(t, err)
+
+	assert.False(t, restarted)
+	assert.NotSame(t, s.listener, s.dnsProxy)
+
+	resp, _, err = client.Exchange(createTestMessage("example.org."), addr)
+	require.NoError(t, err)
+
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+
+	conf.RefuseAny = !conf.RefuseAny
+
+	restarted, err = s.Reload(&conf)
+	require.NoError(t, err)
+
+	assert.True(t, restarted)
+	assert.Same(t, s.listener, s.dnsProxy)
+
+	addr = s.dnsProxy.Addr(proxy.ProtoUDP).String()
+	resp, _, err = client.Exchange(createTestMessage("example.org."), addr)
+	require.NoError(t, err)
+
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+}
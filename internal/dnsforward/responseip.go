@@ -0,0 +1,50 @@
+package dnsforward
+
+import (
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// filterResponseIP blocks the upstream response of dctx if it contains an IP
+// address from the IP blocklists of the filtering engine.
+func (s *Server) filterResponseIP(dctx *dnsContext) {
+	if !dctx.setts.FilteringEnabled {
+		return
+	}
+
+	pctx := dctx.proxyCtx
+	for _, a := range pctx.Res.Answer {
+		var ip netip.Addr
+		switch a := a.(type) {
+		case *dns.A:
+			ip, _ = netip.AddrFromSlice(a.A)
+		case *dns.AAAA:
+			ip, _ = netip.AddrFromSlice(a.AAAA)
+		default:
+			continue
+		}
+
+		if !ip.IsValid() {
+			continue
+		}
+
+		res := s.dnsFilter.CheckResponseIP(ip)
+		if !res.IsFiltered {
+			continue
+		}
+
+		log.Info(
+			"dnsforward: ip blocklist: blocked answer %s for %q",
+			ip,
+			pctx.Req.Question[0].Name,
+		)
+
+		dctx.result = &res
+		dctx.origResp = pctx.Res
+		pctx.Res = s.genDNSFilterMessage(pctx, &res)
+
+		return
+	}
+}
@@ -0,0 +1,470 @@
+package dnsforward
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/miekg/dns"
+)
+
+// SecondaryZoneConfig is the configuration of a zone, for which the server acts
+// as a secondary server.
+type SecondaryZoneConfig struct {
+	// Name is the name of the zone, for example "lan".
+	Name string `yaml:"name"`
+
+	// Primaries are the primary servers of the zone as IP addresses with
+	// optional ports.  Port 53 is used by default.  The NOTIFY messages are
+	// only accepted from these addresses.
+	Primaries []string `yaml:"primaries"`
+}
+
+// secondaryXFRTimeout is the timeout for the network operations of the SOA
+// queries and the zone transfers.
+const secondaryXFRTimeout = 30 * time.Second
+
+// secondaryDefaultRetry is the interval between the attempts to transfer the
+// zone, while there is no SOA record for it yet.
+const secondaryDefaultRetry = 1 * time.Minute
+
+// secondaryMinInterval is the minimum interval between the attempts to refresh
+// the zone regardless of the ones set by the SOA record.
+const secondaryMinInterval = 5 * time.Second
+
+// secondaryZone is a zone transferred from the primary servers.
+type secondaryZone struct {
+	// mu protects records, soa, and checked.
+	mu *sync.RWMutex
+
+	// records are the records of the zone by the lowercased FQDN of the owner.
+	// The empty non-terminals are present with no records.
+	records map[string][]dns.RR
+
+	// soa is the SOA record of the zone.  It's nil until the first successful
+	// transfer.
+	soa *dns.SOA
+
+	// checked is the time of the last successful check of the serial number
+	// with one of the primaries.
+	checked time.Time
+
+	// notify receives a value when a NOTIFY message for the zone is accepted.
+	notify chan struct{}
+
+	// name is the lowercased FQDN of the zone.
+	name string
+
+	// primaries are the addresses of the primary servers.
+	primaries []netip.AddrPort
+}
+
+// secondaryZones serves the zones, for which the server is a secondary one.
+type secondaryZones struct {
+	// zones are the zones by their lowercased FQDNs.
+	zones map[string]*secondaryZone
+
+	// cancel stops the refreshing of the zones.  It's nil unless the zones are
+	// being refreshed.
+	cancel context.CancelFunc
+}
+
+// newSecondaryZones returns the secondary zones for confs.  zs is nil if confs
+// are empty.
+func newSecondaryZones(confs []*SecondaryZoneConfig) (zs *secondaryZones, err error) {
+	if len(confs) == 0 {
+		return nil, nil
+	}
+
+	zs = &secondaryZones{
+		zones: make(map[string]*secondaryZone, len(confs)),
+	}
+
+	for i, c := range confs {
+		var z *secondaryZone
+		z, err = newSecondaryZone(c)
+		if err != nil {
+			return nil, fmt.Errorf("secondary zone at index %d: %w", i, err)
+		}
+
+		if _, ok := zs.zones[z.name]; ok {
+			return nil, fmt.Errorf("secondary zone at index %d: duplicate zone %q", i, z.name)
+		}
+
+		zs.zones[z.name] = z
+	}
+
+	return zs, nil
+}
+
+// newSecondaryZone validates conf and returns a new zone for it.
+func newSecondaryZone(conf *SecondaryZoneConfig) (z *secondaryZone, err error) {
+	if conf == nil {
+		return nil, errors.Error("no value")
+	}
+
+	name := strings.ToLower(dns.Fqdn(conf.Name))
+	if _, ok := dns.IsDomainName(name); !ok || conf.Name == "" {
+		return nil, fmt.Errorf("bad zone name %q", conf.Name)
+	} else if len(conf.Primaries) == 0 {
+		return nil, fmt.Errorf("zone %q: no primaries", name)
+	}
+
+	z = &secondaryZone{
+		mu:        &sync.RWMutex{},
+		records:   map[string][]dns.RR{},
+		notify:    make(chan struct{}, 1),
+		name:      name,
+		primaries: make([]netip.AddrPort, 0, len(conf.Primaries)),
+	}
+
+	for _, p := range conf.Primaries {
+		var addr netip.AddrPort
+		addr, err = parsePrimary(p)
+		if err != nil {
+			return nil, fmt.Errorf("zone %q: primary %q: %w", name, p, err)
+		}
+
+		z.primaries = append(z.primaries, addr)
+	}
+
+	return z, nil
+}
+
+// parsePrimary parses the address of a primary server, which is an IP address
+// with an optional port.
+func parsePrimary(s string) (addr netip.AddrPort, err error) {
+	ip, err := netip.ParseAddr(s)
+	if err == nil {
+		return netip.AddrPortFrom(ip.Unmap(), 53), nil
+	}
+
+	addr, err = netip.ParseAddrPort(s)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return netip.AddrPort{}, err
+	}
+
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()), nil
+}
+
+// start starts refreshing the zones.  zs may be nil.
+func (zs *secondaryZones) start() {
+	if zs == nil || zs.cancel != nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, zs.cancel = context.WithCancel(context.Background())
+	for _, z := range zs.zones {
+		go z.run(ctx)
+	}
+}
+
+// close stops refreshing the zones.  The transferred records are kept.  zs may
+// be nil.
+func (zs *secondaryZones) close() {
+	if zs == nil || zs.cancel == nil {
+		return
+	}
+
+	zs.cancel()
+	zs.cancel = nil
+}
+
+// zone returns the zone containing the lowercased FQDN name, if any.
+func (zs *secondaryZones) zone(name string) (z *secondaryZone, ok bool) {
+	for {
+		z, ok = zs.zones[name]
+		if ok || name == "." {
+			return z, ok
+		}
+
+		i := strings.IndexByte(name, '.')
+		if i == len(name)-1 {
+			name = "."
+		} else {
+			name = name[i+1:]
+		}
+	}
+}
+
+// answer returns the authoritative response to req, if the requested name
+// belongs to one of the zones.  zs may be nil.
+func (zs *secondaryZones) answer(req *dns.Msg) (resp *dns.Msg) {
+	if zs == nil {
+		return nil
+	}
+
+	z, ok := zs.zone(strings.ToLower(req.Question[0].Name))
+	if !ok {
+		return nil
+	}
+
+	return z.answer(req)
+}
+
+// handleNotify returns the response to the NOTIFY message req from the client
+// with addr and schedules the refresh of the zone, if the message is accepted.
+// zs may be nil.  See RFC 1996.
+func (zs *secondaryZones) handleNotify(req *dns.Msg, addr netip.Addr) (resp *dns.Msg) {
+	resp = &dns.Msg{}
+	if zs == nil {
+		return resp.SetRcode(req, dns.RcodeRefused)
+	}
+
+	name := strings.ToLower(req.Question[0].Name)
+	z, ok := zs.zones[name]
+	if !ok || !z.isPrimary(addr) {
+		log.Debug("dnsforward: refusing notify for %q from %s", name, addr)
+
+		return resp.SetRcode(req, dns.RcodeRefused)
+	}
+
+	log.Debug("dnsforward: accepted notify for %q from %s", name, addr)
+
+	select {
+	case z.notify <- struct{}{}:
+	default:
+		// Go on, the refresh is already scheduled.
+	}
+
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	return resp
+}
+
+// isPrimary returns true if addr is the address of one of the primaries of z.
+func (z *secondaryZone) isPrimary(addr netip.Addr) (ok bool) {
+	addr = addr.Unmap()
+	for _, p := range z.primaries {
+		if p.Addr() == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// run refreshes z until ctx is canceled.
+func (z *secondaryZone) run(ctx context.Context) {
+	defer log.OnPanic("dnsforward: refreshing secondary zone")
+
+	for {
+		timer := time.NewTimer(z.refresh())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-z.notify:
+			timer.Stop()
+		case <-timer.C:
+			// Go on.
+		}
+	}
+}
+
+// refresh checks the serial number of z with the primaries and transfers the
+// zone, if it has changed.  next is the interval until the next refresh.
+func (z *secondaryZone) refresh() (next time.Duration) {
+	z.mu.RLock()
+	soa := z.soa
+	z.mu.RUnlock()
+
+	var errs []error
+	for _, p := range z.primaries {
+		err := z.refreshFrom(p, soa)
+		if err == nil {
+			z.mu.RLock()
+			defer z.mu.RUnlock()
+
+			return mathutil.Max(time.Duration(z.soa.Refresh)*time.Second, secondaryMinInterval)
+		}
+
+		errs = append(errs, fmt.Errorf("primary %s: %w", p, err))
+	}
+
+	log.Info("dnsforward: refreshing secondary zone %q: %s", z.name, errors.Join(errs...))
+
+	if soa == nil {
+		return secondaryDefaultRetry
+	}
+
+	return mathutil.Max(time.Duration(soa.Retry)*time.Second, secondaryMinInterval)
+}
+
+// refreshFrom refreshes z using the primary at addr.  soa is the current SOA
+// record of z, if any.
+func (z *secondaryZone) refreshFrom(addr netip.AddrPort, soa *dns.SOA) (err error) {
+	if soa != nil {
+		var serial uint32
+		serial, err = z.querySerial(addr)
+		if err != nil {
+			return fmt.Errorf("querying soa: %w", err)
+		}
+
+		if !serialGreater(serial, soa.Serial) {
+			z.mu.Lock()
+			defer z.mu.Unlock()
+
+			z.checked = time.Now()
+
+			return nil
+		}
+	}
+
+	t := &dns.Transfer{
+		DialTimeout:  secondaryXFRTimeout,
+		ReadTimeout:  secondaryXFRTimeout,
+		WriteTimeout: secondaryXFRTimeout,
+	}
+
+	envs, err := t.In((&dns.Msg{}).SetAxfr(z.name), addr.String())
+	if err != nil {
+		return fmt.Errorf("transferring zone: %w", err)
+	}
+
+	var rrs []dns.RR
+	for env := range envs {
+		if env.Error != nil {
+			return fmt.Errorf("transferring zone: %w", env.Error)
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	return z.set(rrs)
+}
+
+// querySerial returns the serial number of the SOA record of z from the
+// primary at addr.
+func (z *secondaryZone) querySerial(addr netip.AddrPort) (serial uint32, err error) {
+	c := &dns.Client{
+		Timeout: secondaryXFRTimeout,
+	}
+
+	resp, _, err := c.Exchange((&dns.Msg{}).SetQuestion(z.name, dns.TypeSOA), addr.String())
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return 0, err
+	} else if resp.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("bad rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+
+	return 0, errors.Error("no soa in response")
+}
+
+// serialGreater returns true if the serial number a is greater than b.  See
+// RFC 1982, Section 3.2.
+func serialGreater(a, b uint32) (ok bool) {
+	return int32(a-b) > 0
+}
+
+// set replaces the records of z with the transferred ones.
+func (z *secondaryZone) set(rrs []dns.RR) (err error) {
+	if len(rrs) == 0 {
+		return errors.Error("empty transfer")
+	}
+
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return errors.Error("transfer doesn't start with soa")
+	}
+
+	// The transfer ends with the copy of the SOA record.
+	if len(rrs) > 1 && rrs[len(rrs)-1].Header().Rrtype == dns.TypeSOA {
+		rrs = rrs[:len(rrs)-1]
+	}
+
+	records := map[string][]dns.RR{}
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(z.name, name) {
+			log.Debug("dnsforward: secondary zone %q: skipping out-of-zone %q", z.name, name)
+
+			continue
+		}
+
+		records[name] = append(records[name], rr)
+
+		// Add the empty non-terminals.
+		for name != z.name {
+			i := strings.IndexByte(name, '.')
+			name = name[i+1:]
+			if _, ok = records[name]; !ok {
+				records[name] = nil
+			}
+		}
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.records, z.soa, z.checked = records, soa, time.Now()
+
+	log.Info("dnsforward: secondary zone %q: transferred serial %d", z.name, soa.Serial)
+
+	return nil
+}
+
+// answer returns the authoritative response to req for the name within z.  The
+// delegations within the zone aren't supported, so the records below the zone
+// cuts are served as if they were in the zone.
+func (z *secondaryZone) answer(req *dns.Msg) (resp *dns.Msg) {
+	resp = &dns.Msg{}
+
+	q := req.Question[0]
+	if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+		return resp.SetRcode(req, dns.RcodeRefused)
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if z.soa == nil || time.Since(z.checked) > time.Duration(z.soa.Expire)*time.Second {
+		// The zone isn't transferred yet or has expired.  See RFC 1035, Section
+		// 4.3.5.
+		resp.SetRcode(req, dns.RcodeServerFailure)
+		resp.RecursionAvailable = true
+
+		return resp
+	}
+
+	resp.SetReply(req)
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+
+	rrs, ok := z.records[strings.ToLower(q.Name)]
+	if !ok {
+		resp.Rcode = dns.RcodeNameError
+	}
+
+	for _, rr := range rrs {
+		if t := rr.Header().Rrtype; t == q.Qtype || t == dns.TypeCNAME || q.Qtype == dns.TypeANY {
+			resp.Answer = append(resp.Answer, dns.Copy(rr))
+		}
+	}
+
+	if len(resp.Answer) == 0 {
+		// See RFC 2308, Section 3.
+		soa := dns.Copy(z.soa).(*dns.SOA)
+		soa.Hdr.Ttl = mathutil.Min(soa.Hdr.Ttl, soa.Minttl)
+		resp.Ns = append(resp.Ns, soa)
+	}
+
+	return resp
+}
@@ -0,0 +1,258 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSecondaryZones(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*SecondaryZoneConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs: []*SecondaryZoneConfig{{
+			Name:      "lan",
+			Primaries: []string{"192.0.2.1", "[2001:db8::1]:5353"},
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "secondary zone at index 0: no value",
+		confs:      []*SecondaryZoneConfig{nil},
+	}, {
+		name:       "no_primaries",
+		wantErrMsg: `secondary zone at index 0: zone "lan.": no primaries`,
+		confs: []*SecondaryZoneConfig{{
+			Name: "lan",
+		}},
+	}, {
+		name:       "bad_primary",
+		wantErrMsg: `secondary zone at index 0: zone "lan.": primary "primary.lan": not an ip:port`,
+		confs: []*SecondaryZoneConfig{{
+			Name:      "lan",
+			Primaries: []string{"primary.lan"},
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `secondary zone at index 1: duplicate zone "lan."`,
+		confs: []*SecondaryZoneConfig{{
+			Name:      "lan",
+			Primaries: []string{"192.0.2.1"},
+		}, {
+			Name:      "LAN.",
+			Primaries: []string{"192.0.2.1"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newSecondaryZones(tc.confs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+// startPrimary starts a primary server for the zone "lan." on localhost, which
+// serves the records returned by rrs.  It returns the address of the server.
+func startPrimary(t testing.TB, rrs func() (zone []dns.RR)) (addr netip.AddrPort) {
+	t.Helper()
+
+	h := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		zone := rrs()
+		if req.Question[0].Qtype != dns.TypeAXFR {
+			resp := (&dns.Msg{}).SetReply(req)
+			resp.Answer = zone[:1]
+			_ = w.WriteMsg(resp)
+
+			return
+		}
+
+		ch := make(chan *dns.Envelope)
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_ = (&dns.Transfer{}).Out(w, req, ch)
+		}()
+
+		ch <- &dns.Envelope{RR: append(zone, zone[0])}
+		close(ch)
+		wg.Wait()
+		_ = w.Close()
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.NoError(t, err)
+
+	for _, srv := range []*dns.Server{{PacketConn: pc, Handler: h}, {Listener: l, Handler: h}} {
+		srv := srv
+		go func() { _ = srv.ActivateAndServe() }()
+		testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+	}
+
+	return netip.MustParseAddrPort(pc.LocalAddr().String())
+}
+
+func TestSecondaryZones(t *testing.T) {
+	soaHdr := dns.RR_Header{Name: "lan.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600}
+	newZone := func(serial uint32, ip net.IP) (zone []dns.RR) {
+		return []dns.RR{&dns.SOA{
+			Hdr:     soaHdr,
+			Ns:      "ns.lan.",
+			Mbox:    "admin.lan.",
+			Serial:  serial,
+			Refresh: 3600,
+			Retry:   3600,
+			Expire:  86400,
+			Minttl:  60,
+		}, &dns.A{
+			Hdr: dns.RR_Header{Name: "host.sub.lan.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   ip,
+		}}
+	}
+
+	zone := &atomic.Pointer[[]dns.RR]{}
+	initial := newZone(1, net.IP{192, 0, 2, 1})
+	zone.Store(&initial)
+
+	primary := startPrimary(t, func() (rrs []dns.RR) { return *zone.Load() })
+
+	zs, err := newSecondaryZones([]*SecondaryZoneConfig{{
+		Name:      "lan",
+		Primaries: []string{primary.String()},
+	}})
+	require.NoError(t, err)
+
+	req := (&dns.Msg{}).SetQuestion("HOST.sub.lan.", dns.TypeA)
+	resp := zs.answer(req)
+	require.NotNil(t, resp)
+
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+
+	zs.start()
+	t.Cleanup(zs.close)
+
+	waitForA := func(t *testing.T, want net.IP) {
+		t.Helper()
+
+		require.Eventually(t, func() (ok bool) {
+			resp = zs.answer(req)
+			if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+				return false
+			}
+
+			return resp.Answer[0].(*dns.A).A.Equal(want)
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	waitForA(t, net.IP{192, 0, 2, 1})
+
+	t.Run("answers", func(t *testing.T) {
+		testCases := []struct {
+			name      string
+			qname     string
+			qtype     uint16
+			wantRcode int
+			wantAns   int
+		}{{
+			name:      "nodata",
+			qname:     "host.sub.lan.",
+			qtype:     dns.TypeAAAA,
+			wantRcode: dns.RcodeSuccess,
+			wantAns:   0,
+		}, {
+			name:      "empty_non_terminal",
+			qname:     "sub.lan.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			wantAns:   0,
+		}, {
+			name:      "nxdomain",
+			qname:     "other.lan.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeNameError,
+			wantAns:   0,
+		}, {
+			name:      "soa",
+			qname:     "lan.",
+			qtype:     dns.TypeSOA,
+			wantRcode: dns.RcodeSuccess,
+			wantAns:   1,
+		}, {
+			name:      "axfr",
+			qname:     "lan.",
+			qtype:     dns.TypeAXFR,
+			wantRcode: dns.RcodeRefused,
+			wantAns:   0,
+		}}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				r := zs.answer((&dns.Msg{}).SetQuestion(tc.qname, tc.qtype))
+				require.NotNil(t, r)
+
+				assert.Equal(t, tc.wantRcode, r.Rcode)
+				assert.Len(t, r.Answer, tc.wantAns)
+				if tc.wantRcode != dns.RcodeRefused {
+					assert.True(t, r.Authoritative)
+				}
+
+				if tc.wantAns == 0 && tc.wantRcode != dns.RcodeRefused {
+					require.Len(t, r.Ns, 1)
+
+					assert.Equal(t, uint32(60), r.Ns[0].Header().Ttl)
+				}
+			})
+		}
+	})
+
+	t.Run("not_in_zone", func(t *testing.T) {
+		assert.Nil(t, zs.answer((&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)))
+	})
+
+	notify := &dns.Msg{}
+	notify.SetNotify("lan.")
+
+	t.Run("notify_refused", func(t *testing.T) {
+		r := zs.handleNotify(notify, netip.MustParseAddr("192.0.2.2"))
+		assert.Equal(t, dns.RcodeRefused, r.Rcode)
+
+		other := (&dns.Msg{}).SetNotify("other.")
+		r = zs.handleNotify(other, primary.Addr())
+		assert.Equal(t, dns.RcodeRefused, r.Rcode)
+	})
+
+	t.Run("notify", func(t *testing.T) {
+		updated := newZone(2, net.IP{192, 0, 2, 2})
+		zone.Store(&updated)
+
+		r := zs.handleNotify(notify, primary.Addr())
+		assert.Equal(t, dns.RcodeSuccess, r.Rcode)
+		assert.Equal(t, dns.OpcodeNotify, r.Opcode)
+		assert.True(t, r.Response)
+
+		waitForA(t, net.IP{192, 0, 2, 2})
+	})
+}
+
+func TestSerialGreater(t *testing.T) {
+	assert.True(t, serialGreater(2, 1))
+	assert.True(t, serialGreater(0, 0xFFFF_FFFF))
+	assert.False(t, serialGreater(1, 1))
+	assert.False(t, serialGreater(1, 2))
+}
@@ -2,10 +2,13 @@ package dnsforward
 
 import (
 	"net"
+	"net/netip"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/geoip"
 	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/AdGuardHome/internal/stats"
 	"github.com/AdguardTeam/dnsproxy/proxy"
@@ -20,6 +23,12 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 	log.Debug("dnsforward: started processing querylog and stats")
 	defer log.Debug("dnsforward: finished processing querylog and stats")
 
+	if dctx.trace != nil {
+		// Don't pollute the query log and the statistics with the traced
+		// requests.
+		return resultCodeSuccess
+	}
+
 	elapsed := time.Since(dctx.startTime)
 	pctx := dctx.proxyCtx
 
@@ -36,14 +45,25 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 	ids := []string{ipStr, dctx.clientID}
 	qt, cl := q.Qtype, q.Qclass
 
+	if s.metrics != nil {
+		s.metrics.update(dctx, elapsed)
+	}
+
+	ignoreLog, ignoreStats := false, false
+	if lc := s.listenerConfig(pctx); lc != nil {
+		ignoreLog, ignoreStats = lc.IgnoreQueryLog, lc.IgnoreStatistics
+	}
+
 	// Synchronize access to s.queryLog and s.stats so they won't be suddenly
 	// uninitialized while in use.  This can happen after proxy server has been
 	// stopped, but its workers haven't yet exited.
 	s.serverLock.RLock()
 	defer s.serverLock.RUnlock()
 
-	if s.shouldLog(host, qt, cl, ids) {
-		s.logQuery(dctx, pctx, elapsed, ip)
+	clientGeo, answerGeo := s.lookupGeo(pctx)
+
+	if !ignoreLog && s.shouldLog(host, qt, cl, ids) {
+		s.logQuery(dctx, pctx, elapsed, ip, clientGeo, answerGeo)
 	} else {
 		log.Debug(
 			"dnsforward: request %s %s %q from %s ignored; not adding to querylog",
@@ -54,8 +74,8 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 		)
 	}
 
-	if s.shouldCountStat(host, qt, cl, ids) {
-		s.updateStats(dctx, elapsed, *dctx.result, ipStr)
+	if !ignoreStats && s.shouldCountStat(host, qt, cl, ids) {
+		s.updateStats(dctx, elapsed, *dctx.result, ipStr, answerGeo)
 	} else {
 		log.Debug(
 			"dnsforward: request %s %s %q from %s ignored; not counting in stats",
@@ -66,9 +86,47 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 		)
 	}
 
+	if res := dctx.result; res != nil && res.IsFiltered && res.Reason != filtering.FilteredSafeSearch {
+		s.notifyBlockHook(dctx, host, qt, ip)
+	}
+
 	return resultCodeSuccess
 }
 
+// notifyBlockHook notifies the block hooks about the blocked request.
+func (s *Server) notifyBlockHook(dctx *dnsContext, host string, qt uint16, ip net.IP) {
+	clientIP, err := netutil.IPToAddrNoMapped(ip)
+	if err != nil {
+		// Go on and notify the hooks matching the ClientID or rules.
+		log.Debug("dnsforward: block hook: client ip: %s", err)
+	}
+
+	res := dctx.result
+	e := &blockhook.Event{
+		Time:        dctx.startTime,
+		Host:        host,
+		QType:       dns.Type(qt).String(),
+		ClientIP:    clientIP,
+		ClientID:    dctx.clientID,
+		Reason:      res.Reason.String(),
+		ServiceName: res.ServiceName,
+		Rules:       make([]*blockhook.Rule, 0, len(res.Rules)),
+	}
+
+	if res.Reason == filtering.FilteredCategory {
+		e.Categories = res.Categories
+	}
+
+	for _, r := range res.Rules {
+		e.Rules = append(e.Rules, &blockhook.Rule{
+			Text:         r.Text,
+			FilterListID: r.FilterListID,
+		})
+	}
+
+	s.blockHook.Notify(e)
+}
+
 // shouldLog returns true if the query with the given data should be logged in
 // the query log.  s.serverLock is expected to be locked.
 func (s *Server) shouldLog(host string, qt, cl uint16, ids []string) (ok bool) {
@@ -89,12 +147,83 @@ func (s *Server) shouldCountStat(host string, qt, cl uint16, ids []string) (ok b
 	return s.stats != nil && s.stats.ShouldCount(host, qt, cl, ids)
 }
 
+// maxAnswerGeo is the maximum number of the IP addresses from a response,
+// which geolocations are looked up.
+const maxAnswerGeo = 16
+
+// lookupGeo returns the geolocations of the client, unless its address is
+// private, and of the IP addresses in the response.  Both are nil if there are
+// no GeoIP databases.  s.serverLock is expected to be locked.
+func (s *Server) lookupGeo(
+	pctx *proxy.DNSContext,
+) (clientGeo *querylog.GeoInfo, answerGeo []*querylog.GeoInfo) {
+	if s.geoIP == nil {
+		return nil, nil
+	}
+
+	clientIP := netutil.NetAddrToAddrPort(pctx.Addr).Addr().Unmap()
+	if clientIP.IsValid() && !s.privateNets.Contains(clientIP.AsSlice()) {
+		clientGeo = s.lookupGeoIP(clientIP)
+		if clientGeo != nil {
+			// The address of the client is already known.
+			clientGeo.IP = netip.Addr{}
+		}
+	}
+
+	if pctx.Res == nil {
+		return clientGeo, nil
+	}
+
+	for _, rr := range pctx.Res.Answer {
+		if len(answerGeo) >= maxAnswerGeo {
+			break
+		}
+
+		var ip netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip, _ = netip.AddrFromSlice(rr.A.To4())
+		case *dns.AAAA:
+			ip, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			continue
+		}
+
+		if g := s.lookupGeoIP(ip); g != nil {
+			answerGeo = append(answerGeo, g)
+		}
+	}
+
+	return clientGeo, answerGeo
+}
+
+// lookupGeoIP returns the geolocation of ip or nil if it's unknown.
+// s.serverLock is expected to be locked.
+func (s *Server) lookupGeoIP(ip netip.Addr) (g *querylog.GeoInfo) {
+	info, err := s.geoIP.Lookup(ip)
+	if err != nil {
+		log.Debug("dnsforward: looking up geoip of %s: %s", ip, err)
+	}
+
+	if info == (geoip.Info{}) {
+		return nil
+	}
+
+	return &querylog.GeoInfo{
+		IP:      ip,
+		Country: info.Country,
+		ASN:     info.ASN,
+	}
+}
+
 // logQuery pushes the request details into the query log.
 func (s *Server) logQuery(
 	dctx *dnsContext,
 	pctx *proxy.DNSContext,
 	elapsed time.Duration,
 	ip net.IP,
+	clientGeo *querylog.GeoInfo,
+	answerGeo []*querylog.GeoInfo,
 ) {
 	p := &querylog.AddParams{
 		Question:          pctx.Req,
@@ -104,10 +233,16 @@ func (s *Server) logQuery(
 		Result:            dctx.result,
 		ClientID:          dctx.clientID,
 		ClientIP:          ip,
+		ClientGeo:         clientGeo,
+		AnswerGeo:         answerGeo,
 		Elapsed:           elapsed,
 		AuthenticatedData: dctx.responseAD,
 	}
 
+	if dctx.dnssecErr != nil {
+		p.DNSSECError = dctx.dnssecErr.Error()
+	}
+
 	switch pctx.Proto {
 	case proxy.ProtoHTTPS:
 		p.ClientProto = querylog.ClientProtoDoH
@@ -137,10 +272,13 @@ func (s *Server) updateStats(
 	elapsed time.Duration,
 	res filtering.Result,
 	clientIP string,
+	answerGeo []*querylog.GeoInfo,
 ) {
 	pctx := ctx.proxyCtx
+	q := pctx.Req.Question[0]
 	e := &stats.Entry{
-		Domain: aghnet.NormalizeDomain(pctx.Req.Question[0].Name),
+		Domain: aghnet.NormalizeDomain(q.Name),
+		QType:  dns.Type(q.Qtype).String(),
 		Result: stats.RNotFiltered,
 		Time:   elapsed,
 	}
@@ -149,6 +287,16 @@ func (s *Server) updateStats(
 		e.Upstream = pctx.Upstream.Address()
 	}
 
+	if pctx.Res != nil {
+		e.RCode = dns.RcodeToString[pctx.Res.Rcode]
+	}
+
+	for _, g := range answerGeo {
+		if g.Country != "" && !slices.Contains(e.AnswerCountries, g.Country) {
+			e.AnswerCountries = append(e.AnswerCountries, g.Country)
+		}
+	}
+
 	if clientID := ctx.clientID; clientID != "" {
 		e.Client = clientID
 	} else {
@@ -163,11 +311,21 @@ func (s *Server) updateStats(
 	case filtering.FilteredSafeSearch:
 		e.Result = stats.RSafeSearch
 	case
+		filtering.FilteredAllowlistOnly,
 		filtering.FilteredBlockList,
+		filtering.FilteredCategory,
 		filtering.FilteredInvalid,
 		filtering.FilteredBlockedService:
 		e.Result = stats.RFiltered
+		if len(res.Rules) > 0 {
+			e.Rule = res.Rules[0].Text
+			e.FilterListID = res.Rules[0].FilterListID
+		}
 	}
 
 	s.stats.Update(e)
+
+	if s.anomalies != nil {
+		s.anomalies.Observe(e)
+	}
 }
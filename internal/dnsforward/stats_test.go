@@ -2,15 +2,21 @@ package dnsforward
 
 import (
 	"net"
+	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/metrics"
 	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/AdGuardHome/internal/stats"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -233,6 +239,213 @@ func TestServer_ProcessQueryLogsAndStats(t *testing.T) {
 			assert.Equal(t, tc.wantLogProto, ql.lastParams.ClientProto)
 			assert.Equal(t, tc.wantStatClient, st.lastEntry.Client)
 			assert.Equal(t, tc.wantStatResult, st.lastEntry.Result)
+			assert.Equal(t, "NOERROR", st.lastEntry.RCode)
 		})
 	}
 }
+
+func TestServer_ProcessQueryLogsAndStats_listeners(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	laddr := netutil.NetAddrToAddrPort(conn.LocalAddr())
+
+	testCases := []struct {
+		name      string
+		listeners []*ListenerConfig
+		wantLog   bool
+		wantStats bool
+	}{{
+		name:      "no_listeners",
+		listeners: nil,
+		wantLog:   true,
+		wantStats: true,
+	}, {
+		name: "ignore_querylog",
+		listeners: []*ListenerConfig{{
+			Address:        laddr,
+			IgnoreQueryLog: true,
+		}},
+		wantLog:   false,
+		wantStats: true,
+	}, {
+		name: "ignore_statistics_any_ip",
+		listeners: []*ListenerConfig{{
+			Address:          netip.AddrPortFrom(netip.IPv4Unspecified(), laddr.Port()),
+			IgnoreStatistics: true,
+		}},
+		wantLog:   true,
+		wantStats: false,
+	}, {
+		name: "other_port",
+		listeners: []*ListenerConfig{{
+			Address:          netip.AddrPortFrom(laddr.Addr(), laddr.Port()+1),
+			IgnoreQueryLog:   true,
+			IgnoreStatistics: true,
+		}},
+		wantLog:   true,
+		wantStats: true,
+	}, {
+		name: "any_port",
+		listeners: []*ListenerConfig{{
+			Address:          netip.AddrPortFrom(laddr.Addr(), 0),
+			IgnoreQueryLog:   true,
+			IgnoreStatistics: true,
+		}},
+		wantLog:   false,
+		wantStats: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ql := &testQueryLog{}
+			st := &testStats{}
+			srv := &Server{
+				conf: ServerConfig{
+					Config: Config{
+						Listeners: tc.listeners,
+					},
+				},
+				queryLog:   ql,
+				stats:      st,
+				anonymizer: aghnet.NewIPMut(nil),
+			}
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA),
+					Res:   &dns.Msg{},
+					Addr:  &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234},
+					Conn:  conn,
+				},
+				startTime: time.Now(),
+				result:    &filtering.Result{},
+			}
+
+			code := srv.processQueryLogsAndStats(dctx)
+			assert.Equal(t, resultCodeSuccess, code)
+			assert.Equal(t, tc.wantLog, ql.lastParams != nil)
+			assert.Equal(t, tc.wantStats, st.lastEntry != nil)
+		})
+	}
+}
+
+func TestServer_ProcessQueryLogsAndStats_geo(t *testing.T) {
+	geoIP := newTestGeoIP(t)
+
+	answerIP := netip.MustParseAddr("5.6.7.8")
+	res := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+	res.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA},
+		A:   answerIP.AsSlice(),
+	}, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA},
+		A:   net.IP{198, 51, 100, 1},
+	}}
+
+	wantAnswerGeo := []*querylog.GeoInfo{{
+		IP:      answerIP,
+		Country: "DE",
+		ASN:     64496,
+	}}
+
+	testCases := []struct {
+		addr          net.Addr
+		wantClientGeo *querylog.GeoInfo
+		name          string
+	}{{
+		addr: &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234},
+		wantClientGeo: &querylog.GeoInfo{
+			Country: "DE",
+			ASN:     64496,
+		},
+		name: "public_client",
+	}, {
+		addr:          &net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: 1234},
+		wantClientGeo: nil,
+		name:          "private_client",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ql := &testQueryLog{}
+			st := &testStats{}
+			srv := &Server{
+				queryLog:    ql,
+				stats:       st,
+				anonymizer:  aghnet.NewIPMut(nil),
+				geoIP:       geoIP,
+				privateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+			}
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req:   (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA),
+					Res:   res,
+					Addr:  tc.addr,
+				},
+				startTime: time.Now(),
+				result:    &filtering.Result{},
+			}
+
+			code := srv.processQueryLogsAndStats(dctx)
+			require.Equal(t, resultCodeSuccess, code)
+
+			assert.Equal(t, tc.wantClientGeo, ql.lastParams.ClientGeo)
+			assert.Equal(t, wantAnswerGeo, ql.lastParams.AnswerGeo)
+			assert.Equal(t, []string{"DE"}, st.lastEntry.AnswerCountries)
+		})
+	}
+}
+
+func TestServer_ProcessQueryLogsAndStats_metrics(t *testing.T) {
+	ups, err := upstream.AddressToUpstream("1.1.1.1", nil)
+	require.NoError(t, err)
+
+	reg := metrics.NewRegistry()
+	srv := &Server{
+		queryLog:   &testQueryLog{},
+		stats:      &testStats{},
+		blockHook:  blockhook.Empty{},
+		anonymizer: aghnet.NewIPMut(nil),
+		metrics:    NewMetrics(reg),
+	}
+
+	process := func(proto proxy.Proto, u upstream.Upstream, cached string, res *filtering.Result) {
+		dctx := &dnsContext{
+			proxyCtx: &proxy.DNSContext{
+				Proto:              proto,
+				Req:                (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA),
+				Res:                &dns.Msg{},
+				Addr:               &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234},
+				Upstream:           u,
+				CachedUpstreamAddr: cached,
+			},
+			startTime: time.Now(),
+			result:    res,
+		}
+
+		require.Equal(t, resultCodeSuccess, srv.processQueryLogsAndStats(dctx))
+	}
+
+	process(proxy.ProtoUDP, ups, "", &filtering.Result{})
+	process(proxy.ProtoUDP, nil, ups.Address(), &filtering.Result{})
+	process(proxy.ProtoHTTPS, nil, "", &filtering.Result{
+		IsFiltered: true,
+		Reason:     filtering.FilteredBlockList,
+	})
+
+	b := &strings.Builder{}
+	require.NoError(t, reg.Write(b))
+
+	out := b.String()
+	assert.Contains(t, out, "\nadguardhome_dns_queries_total{protocol=\"https\"} 1\n")
+	assert.Contains(t, out, "\nadguardhome_dns_queries_total{protocol=\"udp\"} 2\n")
+	assert.Contains(t, out, "\nadguardhome_dns_blocked_total{reason=\"FilteredBlackList\"} 1\n")
+	assert.Contains(t, out, "\nadguardhome_dns_cache_lookups_total 2\n")
+	assert.Contains(t, out, "\nadguardhome_dns_cache_hits_total 1\n")
+	assert.Contains(t, out, "\nadguardhome_dns_upstream_duration_seconds_count{upstream=\"1.1.1.1:53\"} 1\n")
+}
@@ -0,0 +1,259 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// UpstreamECSMode is the mode of handling the EDNS Client Subnet option in the
+// requests sent to an upstream server.
+type UpstreamECSMode string
+
+// Upstream ECS modes.
+const (
+	// UpstreamECSClient sends the subnet of the client, truncated to /24 for
+	// IPv4 and to /56 for IPv6.  The subnets of the clients with
+	// special-purpose addresses are never sent.
+	UpstreamECSClient UpstreamECSMode = "client"
+
+	// UpstreamECSCustom sends the fixed subnet set in the configuration.
+	UpstreamECSCustom UpstreamECSMode = "custom"
+
+	// UpstreamECSStrip removes the EDNS Client Subnet option from the
+	// requests.
+	UpstreamECSStrip UpstreamECSMode = "strip"
+)
+
+// ECS subnet prefix lengths for the client mode.  See RFC 7871, Section 11.1.
+const (
+	ecsPrefixLenIPv4 = 24
+	ecsPrefixLenIPv6 = 56
+)
+
+// UpstreamECSConfig is the EDNS Client Subnet policy for a single upstream
+// server.
+type UpstreamECSConfig struct {
+	// Address is the address of the upstream server as it's written in the
+	// upstream configuration, for example "8.8.8.8" or
+	// "https://dns.google/dns-query".
+	Address string `yaml:"address"`
+
+	// Mode is the mode of handling ECS for the upstream.
+	Mode UpstreamECSMode `yaml:"mode"`
+
+	// CustomSubnet is the subnet sent with [UpstreamECSCustom] mode.
+	CustomSubnet netip.Prefix `yaml:"custom_subnet"`
+}
+
+// normalizeUpstreamAddr returns the address of the upstream in the form
+// returned by its [upstream.Upstream.Address] method.
+func normalizeUpstreamAddr(addr string) (norm string, err error) {
+	u, err := upstream.AddressToUpstream(addr, &upstream.Options{})
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return "", err
+	}
+
+	norm = u.Address()
+
+	return norm, u.Close()
+}
+
+// validateUpstreamECS returns an error if the upstream ECS policies are
+// invalid.
+func validateUpstreamECS(confs []*UpstreamECSConfig) (err error) {
+	for i, c := range confs {
+		if c == nil {
+			return fmt.Errorf("upstream ecs at index %d: %w", i, errors.Error("no value"))
+		}
+
+		switch c.Mode {
+		case UpstreamECSClient, UpstreamECSStrip:
+			// Go on.
+		case UpstreamECSCustom:
+			if !c.CustomSubnet.IsValid() {
+				return fmt.Errorf("upstream ecs at index %d: no custom_subnet", i)
+			}
+		default:
+			return fmt.Errorf("upstream ecs at index %d: bad mode %q", i, c.Mode)
+		}
+
+		if _, err = normalizeUpstreamAddr(c.Address); err != nil {
+			return fmt.Errorf("upstream ecs at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// wrapECSUpstreams wraps each upstream in ups that has the ECS policy in confs.
+// subnets are the subnets of the clients by the requests being resolved.
+func wrapECSUpstreams(ups []upstream.Upstream, confs []*UpstreamECSConfig, subnets *sync.Map) {
+	if len(confs) == 0 {
+		return
+	}
+
+	byAddr := make(map[string]*UpstreamECSConfig, len(confs))
+	for _, c := range confs {
+		if addr, err := normalizeUpstreamAddr(c.Address); err == nil {
+			byAddr[addr] = c
+		}
+	}
+
+	for i, u := range ups {
+		c, ok := byAddr[u.Address()]
+		if !ok {
+			continue
+		}
+
+		log.Debug("dnsforward: using ecs mode %q for upstream %s", c.Mode, u.Address())
+
+		ups[i] = &ecsUpstream{
+			Upstream: u,
+			subnets:  subnets,
+			mode:     c.Mode,
+			custom:   c.CustomSubnet.Masked(),
+		}
+	}
+}
+
+// clientSubnet returns the subnet of the client with addr to send to the
+// upstreams.  ok is false if the subnet of the client mustn't be sent.
+func clientSubnet(addr net.Addr) (subnet netip.Prefix, ok bool) {
+	ip := netutil.NetAddrToAddrPort(addr).Addr().Unmap()
+	if !ip.IsValid() || netutil.IsSpecialPurposeAddr(ip) {
+		return netip.Prefix{}, false
+	}
+
+	bits := ecsPrefixLenIPv4
+	if ip.Is6() {
+		bits = ecsPrefixLenIPv6
+	}
+
+	subnet, err := ip.Prefix(bits)
+
+	return subnet, err == nil
+}
+
+// ecsUpstream is an [upstream.Upstream] that modifies the EDNS Client Subnet
+// option of the requests according to its mode.
+type ecsUpstream struct {
+	upstream.Upstream
+
+	// subnets are the subnets of the clients by the requests being resolved.
+	// The keys are *dns.Msg and the values are netip.Prefix.
+	subnets *sync.Map
+
+	// mode is the ECS mode of the upstream.
+	mode UpstreamECSMode
+
+	// custom is the subnet used with [UpstreamECSCustom] mode.
+	custom netip.Prefix
+}
+
+// type check
+var _ upstream.Upstream = (*ecsUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *ecsUpstream.  req
+// isn't modified, since it may be sent to several upstreams simultaneously.
+func (u *ecsUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	hasECS := findECS(req) != nil
+
+	var subnet netip.Prefix
+	switch u.mode {
+	case UpstreamECSClient:
+		v, ok := u.subnets.Load(req)
+		if hasECS || !ok {
+			return u.Upstream.Exchange(req)
+		}
+
+		subnet = v.(netip.Prefix)
+	case UpstreamECSCustom:
+		subnet = u.custom
+	default:
+		if !hasECS {
+			return u.Upstream.Exchange(req)
+		}
+	}
+
+	modified := req.Copy()
+	removeECS(modified)
+	if subnet.IsValid() {
+		setECS(modified, subnet)
+	}
+
+	resp, err = u.Upstream.Exchange(modified)
+	if resp != nil && !hasECS {
+		// Don't reveal the subnet sent to the upstream to the client.
+		removeECS(resp)
+	}
+
+	return resp, err
+}
+
+// findECS returns the EDNS Client Subnet option of m, if any.
+func findECS(m *dns.Msg) (ecs *dns.EDNS0_SUBNET) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+
+	return nil
+}
+
+// removeECS removes the EDNS Client Subnet option from m, if any.
+func removeECS(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	opts := opt.Option[:0]
+	for _, o := range opt.Option {
+		if _, ok := o.(*dns.EDNS0_SUBNET); !ok {
+			opts = append(opts, o)
+		}
+	}
+
+	opt.Option = opts
+}
+
+// setECS sets the EDNS Client Subnet option with subnet into m, which must not
+// contain one.
+func setECS(m *dns.Msg, subnet netip.Prefix) {
+	ecs := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: uint8(subnet.Bits()),
+		// A stub resolver must set the scope prefix length to zero.  See RFC
+		// 7871, Section 6.
+		SourceScope: 0,
+		Address:     subnet.Addr().AsSlice(),
+	}
+
+	if subnet.Addr().Is6() {
+		ecs.Family = 2
+	}
+
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt = m.IsEdns0()
+	}
+
+	opt.Option = append(opt.Option, ecs)
+}
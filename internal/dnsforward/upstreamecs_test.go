@@ -0,0 +1,199 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestECSUpstream_Exchange(t *testing.T) {
+	const (
+		clientAddr = "1.1.1.1:53"
+		customAddr = "2.2.2.2:53"
+		stripAddr  = "3.3.3.3:53"
+		otherAddr  = "4.4.4.4:53"
+	)
+
+	// gotECS is the ECS option of the latest request received by any of the
+	// upstreams.
+	var gotECS *dns.EDNS0_SUBNET
+
+	newUps := func(addr string) (u upstream.Upstream) {
+		return &aghtest.UpstreamMock{
+			OnAddress: func() (a string) { return addr },
+			OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+				gotECS = findECS(req)
+
+				resp = (&dns.Msg{}).SetReply(req)
+				if gotECS != nil {
+					resp.SetEdns0(dns.DefaultMsgSize, false)
+					resp.IsEdns0().Option = append(resp.IsEdns0().Option, gotECS)
+				}
+
+				return resp, nil
+			},
+			OnClose: func() (err error) { return nil },
+		}
+	}
+
+	subnets := &sync.Map{}
+	ups := []upstream.Upstream{
+		newUps(clientAddr),
+		newUps(customAddr),
+		newUps(stripAddr),
+		newUps(otherAddr),
+	}
+	wrapECSUpstreams(ups, []*UpstreamECSConfig{{
+		Address: "1.1.1.1",
+		Mode:    UpstreamECSClient,
+	}, {
+		Address:      "udp://2.2.2.2",
+		Mode:         UpstreamECSCustom,
+		CustomSubnet: netip.MustParsePrefix("203.0.113.7/24"),
+	}, {
+		Address: "3.3.3.3:53",
+		Mode:    UpstreamECSStrip,
+	}}, subnets)
+
+	require.IsType(t, (*ecsUpstream)(nil), ups[0])
+	require.IsType(t, (*ecsUpstream)(nil), ups[1])
+	require.IsType(t, (*ecsUpstream)(nil), ups[2])
+	require.IsType(t, (*aghtest.UpstreamMock)(nil), ups[3])
+
+	clientSub, ok := clientSubnet(&net.UDPAddr{IP: net.IP{93, 184, 216, 34}, Port: 1234})
+	require.True(t, ok)
+	require.Equal(t, netip.MustParsePrefix("93.184.216.0/24"), clientSub)
+
+	passedSub := netip.MustParsePrefix("192.0.2.0/24")
+
+	testCases := []struct {
+		ups      upstream.Upstream
+		wantECS  netip.Prefix
+		reqECS   netip.Prefix
+		name     string
+		withAddr bool
+	}{{
+		ups:      ups[0],
+		wantECS:  clientSub,
+		reqECS:   netip.Prefix{},
+		name:     "client",
+		withAddr: true,
+	}, {
+		ups:      ups[0],
+		wantECS:  netip.Prefix{},
+		reqECS:   netip.Prefix{},
+		name:     "client_no_subnet",
+		withAddr: false,
+	}, {
+		ups:      ups[0],
+		wantECS:  passedSub,
+		reqECS:   passedSub,
+		name:     "client_pass_through",
+		withAddr: true,
+	}, {
+		ups:      ups[1],
+		wantECS:  netip.MustParsePrefix("203.0.113.0/24"),
+		reqECS:   passedSub,
+		name:     "custom",
+		withAddr: true,
+	}, {
+		ups:      ups[2],
+		wantECS:  netip.Prefix{},
+		reqECS:   passedSub,
+		name:     "strip",
+		withAddr: true,
+	}, {
+		ups:      ups[3],
+		wantECS:  passedSub,
+		reqECS:   passedSub,
+		name:     "other",
+		withAddr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+			if tc.reqECS.IsValid() {
+				setECS(req, tc.reqECS)
+			}
+
+			if tc.withAddr {
+				subnets.Store(req, clientSub)
+				t.Cleanup(func() { subnets.Delete(req) })
+			}
+
+			gotECS = nil
+			resp, err := tc.ups.Exchange(req)
+			require.NoError(t, err)
+			require.NotNil(t, resp)
+
+			if !tc.wantECS.IsValid() {
+				assert.Nil(t, gotECS)
+			} else {
+				require.NotNil(t, gotECS)
+
+				addr, _ := netip.AddrFromSlice(gotECS.Address)
+				assert.Equal(t, tc.wantECS, netip.PrefixFrom(addr, int(gotECS.SourceNetmask)))
+			}
+
+			// The original request must not be modified and the subnet must
+			// not be revealed to the client.
+			assert.Equal(t, tc.reqECS.IsValid(), findECS(req) != nil)
+			if !tc.reqECS.IsValid() {
+				assert.Nil(t, findECS(resp))
+			}
+		})
+	}
+}
+
+func TestValidateUpstreamECS(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*UpstreamECSConfig
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		confs: []*UpstreamECSConfig{{
+			Address: "https://dns.example/dns-query",
+			Mode:    UpstreamECSClient,
+		}, {
+			Address:      "8.8.8.8",
+			Mode:         UpstreamECSCustom,
+			CustomSubnet: netip.MustParsePrefix("203.0.113.0/24"),
+		}},
+	}, {
+		name:       "no_custom_subnet",
+		wantErrMsg: "upstream ecs at index 0: no custom_subnet",
+		confs: []*UpstreamECSConfig{{
+			Address: "8.8.8.8",
+			Mode:    UpstreamECSCustom,
+		}},
+	}, {
+		name:       "bad_mode",
+		wantErrMsg: `upstream ecs at index 0: bad mode "bad"`,
+		confs: []*UpstreamECSConfig{{
+			Address: "8.8.8.8",
+			Mode:    "bad",
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "upstream ecs at index 0: no value",
+		confs:      []*UpstreamECSConfig{nil},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateUpstreamECS(tc.confs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
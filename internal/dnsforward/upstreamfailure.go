@@ -0,0 +1,456 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/maps"
+)
+
+// UpstreamFailureAction is the action performed when the upstream servers
+// have failed to respond or have responded with SERVFAIL.
+type UpstreamFailureAction string
+
+// Upstream failure actions.
+const (
+	// UpstreamFailureServFail responds with SERVFAIL.  It's the default.
+	UpstreamFailureServFail UpstreamFailureAction = "servfail"
+
+	// UpstreamFailureRefused responds with REFUSED.
+	UpstreamFailureRefused UpstreamFailureAction = "refused"
+
+	// UpstreamFailureNXDomain responds with NXDOMAIN.
+	UpstreamFailureNXDomain UpstreamFailureAction = "nxdomain"
+
+	// UpstreamFailureStale responds with the last successful response for the
+	// same question, if there is one, and with SERVFAIL otherwise.
+	UpstreamFailureStale UpstreamFailureAction = "stale"
+
+	// UpstreamFailureRetry retries the request using a different group of
+	// upstream servers.
+	UpstreamFailureRetry UpstreamFailureAction = "retry"
+
+	// UpstreamFailureStatic responds with the matching records of the static
+	// zone, and with NXDOMAIN if there are no records for the requested name.
+	UpstreamFailureStatic UpstreamFailureAction = "static"
+
+	// UpstreamFailureRedirect responds to A and AAAA requests with the
+	// configured IP addresses, for example the ones of a local page telling
+	// that the DNS is down, and with NODATA to the other requests.
+	UpstreamFailureRedirect UpstreamFailureAction = "redirect"
+)
+
+// UpstreamFailureAnswers are the configuration of the answers for
+// [UpstreamFailureStatic] and [UpstreamFailureRedirect] actions.
+type UpstreamFailureAnswers struct {
+	// Records are the resource records of the static zone in the zone file
+	// format used by [UpstreamFailureStatic] action, for example:
+	//
+	//	example.com. 60 IN A 192.0.2.1
+	Records []string `yaml:"records"`
+
+	// RedirectIPv4 is the IPv4 address used by [UpstreamFailureRedirect]
+	// action.
+	RedirectIPv4 netip.Addr `yaml:"redirect_ipv4"`
+
+	// RedirectIPv6 is the IPv6 address used by [UpstreamFailureRedirect]
+	// action.
+	RedirectIPv6 netip.Addr `yaml:"redirect_ipv6"`
+}
+
+// UpstreamFailureConfig is the configuration of the behavior in case the
+// upstream servers fail.
+type UpstreamFailureConfig struct {
+	// Action is the action for the domains not matching any of Domains.
+	Action UpstreamFailureAction `yaml:"action"`
+
+	// Upstreams are the upstream servers used by [UpstreamFailureRetry]
+	// action.
+	Upstreams []string `yaml:"upstreams"`
+
+	// Domains are the per-domain overrides of the action.
+	Domains []*UpstreamFailureDomainConfig `yaml:"domains"`
+
+	// UpstreamFailureAnswers are the answers used by the corresponding
+	// actions.
+	UpstreamFailureAnswers `yaml:",inline"`
+}
+
+// UpstreamFailureDomainConfig is the override of the upstream failure behavior
+// for particular domains.
+type UpstreamFailureDomainConfig struct {
+	// Action is the action for domains.
+	Action UpstreamFailureAction `yaml:"action"`
+
+	// Domains are the domain names for which, as well as for their
+	// subdomains, the action is used.
+	Domains []string `yaml:"domains"`
+
+	// Upstreams are the upstream servers used by [UpstreamFailureRetry]
+	// action.
+	Upstreams []string `yaml:"upstreams"`
+
+	// UpstreamFailureAnswers are the answers used by the corresponding
+	// actions.
+	UpstreamFailureAnswers `yaml:",inline"`
+}
+
+// staleTTL is the TTL of the records of the stale responses in seconds.  See
+// RFC 8767, Section 4.
+const staleTTL = 30
+
+// maxStaleResponses is the maximum number of responses kept to be served
+// stale.
+const maxStaleResponses = 10_000
+
+// upstreamFailurePolicy is the action prepared for use.
+type upstreamFailurePolicy struct {
+	action    UpstreamFailureAction
+	upstreams []upstream.Upstream
+
+	// records are the records of the static zone by the lowercased FQDN.
+	records map[string][]dns.RR
+
+	// redirectIPv4 and redirectIPv6 are the addresses used by
+	// [UpstreamFailureRedirect].
+	redirectIPv4 netip.Addr
+	redirectIPv6 netip.Addr
+}
+
+// upstreamFailureHandler handles the failed upstream exchanges.
+type upstreamFailureHandler struct {
+	// def is the default policy.
+	def *upstreamFailurePolicy
+
+	// domains are the policies by domain name in lower case without the
+	// trailing dot.
+	domains map[string]*upstreamFailurePolicy
+
+	// stale contains the successful responses to be served stale.  It's nil
+	// if none of the policies use [UpstreamFailureStale].
+	stale cache.Cache
+}
+
+// newUpstreamFailureHandler returns a new handler for conf.  h is nil if conf
+// is nil.
+func newUpstreamFailureHandler(
+	conf *UpstreamFailureConfig,
+	opts *upstream.Options,
+) (h *upstreamFailureHandler, err error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	h = &upstreamFailureHandler{
+		domains: map[string]*upstreamFailurePolicy{},
+	}
+
+	defer func() {
+		if err != nil {
+			err = errors.WithDeferred(err, h.close())
+		}
+	}()
+
+	h.def, err = h.newPolicy(conf.Action, conf.Upstreams, &conf.UpstreamFailureAnswers, opts)
+	if err != nil {
+		return nil, fmt.Errorf("default action: %w", err)
+	}
+
+	for i, d := range conf.Domains {
+		if d == nil {
+			return nil, fmt.Errorf("domains at index %d: %w", i, errors.Error("no value"))
+		}
+
+		var p *upstreamFailurePolicy
+		p, err = h.newPolicy(d.Action, d.Upstreams, &d.UpstreamFailureAnswers, opts)
+		if err != nil {
+			return nil, fmt.Errorf("domains at index %d: %w", i, err)
+		}
+
+		for _, name := range d.Domains {
+			h.domains[strings.ToLower(strings.TrimSuffix(name, "."))] = p
+		}
+	}
+
+	return h, nil
+}
+
+// newPolicy validates the action and creates the upstreams or the answers for
+// it.
+func (h *upstreamFailureHandler) newPolicy(
+	action UpstreamFailureAction,
+	addrs []string,
+	answers *UpstreamFailureAnswers,
+	opts *upstream.Options,
+) (p *upstreamFailurePolicy, err error) {
+	p = &upstreamFailurePolicy{
+		action: action,
+	}
+
+	switch action {
+	case "":
+		p.action = UpstreamFailureServFail
+	case
+		UpstreamFailureServFail,
+		UpstreamFailureRefused,
+		UpstreamFailureNXDomain:
+		// Go on.
+	case UpstreamFailureStale:
+		if h.stale == nil {
+			h.stale = cache.New(cache.Config{
+				EnableLRU: true,
+				MaxCount:  maxStaleResponses,
+			})
+		}
+	case UpstreamFailureRetry:
+		addrs = stringutil.FilterOut(addrs, IsCommentOrEmpty)
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("action %q: no upstreams", action)
+		}
+
+		for _, addr := range addrs {
+			var u upstream.Upstream
+			u, err = addressToUpstream(addr, opts)
+			if err != nil {
+				return nil, fmt.Errorf("action %q: upstream %q: %w", action, addr, err)
+			}
+
+			p.upstreams = append(p.upstreams, u)
+		}
+	case UpstreamFailureStatic:
+		p.records, err = parseStaticZone(answers.Records)
+		if err != nil {
+			return nil, fmt.Errorf("action %q: %w", action, err)
+		}
+	case UpstreamFailureRedirect:
+		err = validateRedirectIPs(answers.RedirectIPv4, answers.RedirectIPv6)
+		if err != nil {
+			return nil, fmt.Errorf("action %q: %w", action, err)
+		}
+
+		p.redirectIPv4, p.redirectIPv6 = answers.RedirectIPv4, answers.RedirectIPv6
+	default:
+		return nil, fmt.Errorf("bad action %q", action)
+	}
+
+	return p, nil
+}
+
+// parseStaticZone parses the resource records of the static zone and groups
+// them by the lowercased names.
+func parseStaticZone(lines []string) (records map[string][]dns.RR, err error) {
+	lines = stringutil.FilterOut(lines, IsCommentOrEmpty)
+	if len(lines) == 0 {
+		return nil, errors.Error("no records")
+	}
+
+	records = make(map[string][]dns.RR, len(lines))
+	for i, l := range lines {
+		var rr dns.RR
+		rr, err = dns.NewRR(l)
+		if err != nil {
+			return nil, fmt.Errorf("record at index %d: %w", i, err)
+		} else if rr == nil {
+			return nil, fmt.Errorf("record at index %d: %w", i, errors.Error("no record"))
+		}
+
+		name := strings.ToLower(rr.Header().Name)
+		records[name] = append(records[name], rr)
+	}
+
+	return records, nil
+}
+
+// validateRedirectIPs returns an error if the redirect addresses are invalid.
+func validateRedirectIPs(ipv4, ipv6 netip.Addr) (err error) {
+	switch {
+	case !ipv4.IsValid() && !ipv6.IsValid():
+		return errors.Error("no redirect addresses")
+	case ipv4.IsValid() && !ipv4.Is4():
+		return fmt.Errorf("redirect_ipv4: %s is not an ipv4 address", ipv4)
+	case ipv6.IsValid() && !ipv6.Is6():
+		return fmt.Errorf("redirect_ipv6: %s is not an ipv6 address", ipv6)
+	default:
+		return nil
+	}
+}
+
+// policy returns the policy for host, which must be in lower case and without
+// the trailing dot.
+func (h *upstreamFailureHandler) policy(host string) (p *upstreamFailurePolicy) {
+	for {
+		if p = h.domains[host]; p != nil {
+			return p
+		}
+
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return h.def
+		}
+
+		host = host[i+1:]
+	}
+}
+
+// staleKey returns the key of the stale cache for the question of req.
+func staleKey(req *dns.Msg) (key []byte) {
+	q := req.Question[0]
+	key = binary.BigEndian.AppendUint16(nil, q.Qtype)
+
+	return append(key, strings.ToLower(q.Name)...)
+}
+
+// store saves the successful response resp to req to be served stale later.
+func (h *upstreamFailureHandler) store(req, resp *dns.Msg) {
+	if h == nil || h.stale == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return
+	}
+
+	packed, err := resp.Pack()
+	if err != nil {
+		log.Debug("dnsforward: packing stale response: %s", err)
+
+		return
+	}
+
+	h.stale.Set(staleKey(req), packed)
+}
+
+// handle returns the response to req, which the upstreams have failed to
+// resolve.  resp is nil if the default behavior should be preserved.
+func (h *upstreamFailureHandler) handle(s *Server, req *dns.Msg) (resp *dns.Msg) {
+	if h == nil {
+		return nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(req.Question[0].Name, "."))
+	p := h.policy(host)
+
+	log.Debug("dnsforward: upstreams failed for %q, using action %q", host, p.action)
+
+	switch p.action {
+	case UpstreamFailureRefused:
+		return s.makeResponseREFUSED(req)
+	case UpstreamFailureNXDomain:
+		return s.genNXDomain(req)
+	case UpstreamFailureStale:
+		return h.staleResponse(req)
+	case UpstreamFailureRetry:
+		var err error
+		resp, _, err = upstream.ExchangeParallel(p.upstreams, req)
+		if err != nil {
+			log.Debug("dnsforward: retrying %q: %s", host, err)
+
+			return nil
+		}
+
+		return resp
+	case UpstreamFailureStatic:
+		return s.staticResponse(req, p.records)
+	case UpstreamFailureRedirect:
+		return s.redirectResponse(req, p.redirectIPv4, p.redirectIPv6)
+	default:
+		return nil
+	}
+}
+
+// staticResponse returns the response to req with the records of the static
+// zone of the requested type as well as CNAME records.  It returns NXDOMAIN if
+// there are no records for the requested name at all.
+func (s *Server) staticResponse(req *dns.Msg, records map[string][]dns.RR) (resp *dns.Msg) {
+	q := req.Question[0]
+	rrs, ok := records[strings.ToLower(q.Name)]
+	if !ok {
+		return s.genNXDomain(req)
+	}
+
+	resp = s.makeResponse(req)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype != q.Qtype && hdr.Rrtype != dns.TypeCNAME {
+			continue
+		}
+
+		ans := dns.Copy(rr)
+		ans.Header().Name = q.Name
+		resp.Answer = append(resp.Answer, ans)
+	}
+
+	if len(resp.Answer) == 0 {
+		return s.newMsgNODATA(req)
+	}
+
+	return resp
+}
+
+// redirectResponse returns the response to req with ipv4 or ipv6 depending on
+// the requested type.  It returns NODATA if there is no address of the type.
+func (s *Server) redirectResponse(req *dns.Msg, ipv4, ipv6 netip.Addr) (resp *dns.Msg) {
+	switch qt := req.Question[0].Qtype; {
+	case qt == dns.TypeA && ipv4.IsValid():
+		return s.genARecord(req, ipv4)
+	case qt == dns.TypeAAAA && ipv6.IsValid():
+		return s.genAAAARecord(req, ipv6)
+	default:
+		return s.newMsgNODATA(req)
+	}
+}
+
+// staleResponse returns the stored response to req with the TTLs replaced by
+// [staleTTL].  resp is nil if there is no stored response.
+func (h *upstreamFailureHandler) staleResponse(req *dns.Msg) (resp *dns.Msg) {
+	packed := h.stale.Get(staleKey(req))
+	if packed == nil {
+		return nil
+	}
+
+	resp = &dns.Msg{}
+	err := resp.Unpack(packed)
+	if err != nil {
+		log.Debug("dnsforward: unpacking stale response: %s", err)
+
+		return nil
+	}
+
+	resp.Id = req.Id
+	resp.Question = req.Question
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+				hdr.Ttl = staleTTL
+			}
+		}
+	}
+
+	return resp
+}
+
+// close closes the upstreams of h.
+func (h *upstreamFailureHandler) close() (err error) {
+	if h == nil {
+		return nil
+	}
+
+	var errs []error
+	closed := map[*upstreamFailurePolicy]struct{}{}
+	for _, p := range append([]*upstreamFailurePolicy{h.def}, maps.Values(h.domains)...) {
+		if _, ok := closed[p]; ok || p == nil {
+			continue
+		}
+
+		closed[p] = struct{}{}
+		for _, u := range p.upstreams {
+			errs = append(errs, u.Close())
+		}
+	}
+
+	return errors.Annotate(errors.Join(errs...), "closing upstream failure upstreams: %w")
+}
@@ -0,0 +1,253 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUpstreamFailureHandler(t *testing.T) {
+	testCases := []struct {
+		conf       *UpstreamFailureConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       &UpstreamFailureConfig{},
+		name:       "empty",
+		wantErrMsg: "",
+	}, {
+		conf: &UpstreamFailureConfig{
+			Action: "bad",
+		},
+		name:       "bad_action",
+		wantErrMsg: `default action: bad action "bad"`,
+	}, {
+		conf: &UpstreamFailureConfig{
+			Action: UpstreamFailureRefused,
+			Domains: []*UpstreamFailureDomainConfig{{
+				Action:  UpstreamFailureRetry,
+				Domains: []string{"example.com"},
+			}},
+		},
+		name:       "retry_no_upstreams",
+		wantErrMsg: `domains at index 0: action "retry": no upstreams`,
+	}, {
+		conf: &UpstreamFailureConfig{
+			Action: UpstreamFailureStatic,
+			UpstreamFailureAnswers: UpstreamFailureAnswers{
+				Records: []string{"example.com. 60 IN BAD"},
+			},
+		},
+		name: "static_bad_record",
+		wantErrMsg: `default action: action "static": record at index 0: ` +
+			`dns: unknown RR type: "BAD" at line: 1:22`,
+	}, {
+		conf: &UpstreamFailureConfig{
+			Action: UpstreamFailureRedirect,
+			UpstreamFailureAnswers: UpstreamFailureAnswers{
+				RedirectIPv4: netip.MustParseAddr("::1"),
+			},
+		},
+		name: "redirect_bad_ipv4",
+		wantErrMsg: `default action: action "redirect": ` +
+			`redirect_ipv4: ::1 is not an ipv4 address`,
+	}, {
+		conf: &UpstreamFailureConfig{
+			Action: UpstreamFailureRedirect,
+		},
+		name:       "redirect_no_ips",
+		wantErrMsg: `default action: action "redirect": no redirect addresses`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := newUpstreamFailureHandler(tc.conf, &upstream.Options{})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if err == nil {
+				assert.NoError(t, h.close())
+			}
+		})
+	}
+}
+
+func TestUpstreamFailureHandler_handle(t *testing.T) {
+	const (
+		staleHost = "flaky.example."
+		retryHost = "retry.example."
+		otherHost = "other.example."
+	)
+
+	retryIP := net.IP{1, 2, 3, 4}
+	ups := &aghtest.UpstreamMock{
+		OnAddress: func() (addr string) { return "retry" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			return aghtest.MatchedResponse(req, dns.TypeA, retryHost, retryIP.String()), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	h, err := newUpstreamFailureHandler(&UpstreamFailureConfig{
+		Action: UpstreamFailureRefused,
+		Domains: []*UpstreamFailureDomainConfig{{
+			Action:  UpstreamFailureStale,
+			Domains: []string{"flaky.example"},
+		}},
+	}, &upstream.Options{})
+	require.NoError(t, err)
+
+	h.domains["retry.example"] = &upstreamFailurePolicy{
+		action:    UpstreamFailureRetry,
+		upstreams: []upstream.Upstream{ups},
+	}
+
+	s := &Server{}
+
+	t.Run("default", func(t *testing.T) {
+		req := (&dns.Msg{}).SetQuestion(otherHost, dns.TypeA)
+		resp := h.handle(s, req)
+		require.NotNil(t, resp)
+
+		assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		req := (&dns.Msg{}).SetQuestion("sub."+staleHost, dns.TypeA)
+		assert.Nil(t, h.handle(s, req))
+
+		good := aghtest.MatchedResponse(req, dns.TypeA, "sub."+staleHost, "5.6.7.8")
+		good.Answer[0].Header().Ttl = 3600
+		h.store(req, good)
+
+		req.Id = dns.Id()
+		resp := h.handle(s, req)
+		require.NotNil(t, resp)
+		require.Len(t, resp.Answer, 1)
+
+		assert.Equal(t, req.Id, resp.Id)
+		assert.Equal(t, uint32(staleTTL), resp.Answer[0].Header().Ttl)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		req := (&dns.Msg{}).SetQuestion(retryHost, dns.TypeA)
+		resp := h.handle(s, req)
+		require.NotNil(t, resp)
+		require.Len(t, resp.Answer, 1)
+
+		a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+		assert.Equal(t, retryIP.To16(), a.A.To16())
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var nilH *upstreamFailureHandler
+		req := (&dns.Msg{}).SetQuestion(otherHost, dns.TypeA)
+		assert.Nil(t, nilH.handle(s, req))
+	})
+}
+
+func TestUpstreamFailureHandler_handle_answers(t *testing.T) {
+	const (
+		staticHost   = "static.example."
+		redirectHost = "redirect.example."
+	)
+
+	redirectIP := netip.MustParseAddr("192.168.1.1")
+
+	h, err := newUpstreamFailureHandler(&UpstreamFailureConfig{
+		Action: UpstreamFailureRedirect,
+		UpstreamFailureAnswers: UpstreamFailureAnswers{
+			RedirectIPv4: redirectIP,
+		},
+		Domains: []*UpstreamFailureDomainConfig{{
+			Action:  UpstreamFailureStatic,
+			Domains: []string{"static.example"},
+			UpstreamFailureAnswers: UpstreamFailureAnswers{
+				Records: []string{
+					"Static.Example. 60 IN A 192.0.2.1",
+					"www.static.example. 60 IN CNAME static.example.",
+				},
+			},
+		}},
+	}, &upstream.Options{})
+	require.NoError(t, err)
+
+	s := &Server{
+		dnsFilter: createTestDNSFilter(t),
+	}
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantRRs   []uint16
+		qtype     uint16
+		wantRcode int
+	}{{
+		name:      "static_a",
+		host:      staticHost,
+		wantRRs:   []uint16{dns.TypeA},
+		qtype:     dns.TypeA,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "static_nodata",
+		host:      staticHost,
+		wantRRs:   nil,
+		qtype:     dns.TypeAAAA,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "static_cname",
+		host:      "www." + staticHost,
+		wantRRs:   []uint16{dns.TypeCNAME},
+		qtype:     dns.TypeA,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "static_nxdomain",
+		host:      "other." + staticHost,
+		wantRRs:   nil,
+		qtype:     dns.TypeA,
+		wantRcode: dns.RcodeNameError,
+	}, {
+		name:      "redirect_a",
+		host:      redirectHost,
+		wantRRs:   []uint16{dns.TypeA},
+		qtype:     dns.TypeA,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:      "redirect_aaaa_nodata",
+		host:      redirectHost,
+		wantRRs:   nil,
+		qtype:     dns.TypeAAAA,
+		wantRcode: dns.RcodeSuccess,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion(tc.host, tc.qtype)
+			resp := h.handle(s, req)
+			require.NotNil(t, resp)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			require.Len(t, resp.Answer, len(tc.wantRRs))
+
+			for i, rr := range resp.Answer {
+				assert.Equal(t, tc.wantRRs[i], rr.Header().Rrtype)
+				assert.Equal(t, tc.host, rr.Header().Name)
+			}
+		})
+	}
+
+	t.Run("redirect_ip", func(t *testing.T) {
+		req := (&dns.Msg{}).SetQuestion(redirectHost, dns.TypeA)
+		resp := h.handle(s, req)
+		require.NotNil(t, resp)
+		require.Len(t, resp.Answer, 1)
+
+		a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+		assert.Equal(t, redirectIP.AsSlice(), []byte(a.A.To4()))
+	})
+}
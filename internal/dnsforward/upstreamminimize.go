@@ -0,0 +1,456 @@
+package dnsforward
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// Limits of the minimized resolution.
+const (
+	// maxMinimiseCount is the maximum number of the minimized queries sent
+	// while resolving a single name, after which the full name is sent.  See
+	// RFC 9156, Section 2.3.
+	maxMinimiseCount = 10
+
+	// maxResolutionSteps is the maximum number of the queries, including the
+	// ones following the referrals, sent while resolving a single name.
+	maxResolutionSteps = 32
+
+	// maxNSResolutionDepth is the maximum depth of the nested resolutions of
+	// the names of the name servers, for which there is no glue.
+	maxNSResolutionDepth = 3
+
+	// maxMinimizedCNAMEs is the maximum number of the CNAME records followed
+	// while resolving a single name.
+	maxMinimizedCNAMEs = 8
+
+	// maxNameServersTried is the maximum number of the name servers of a zone
+	// tried before giving up.
+	maxNameServersTried = 4
+
+	// maxZoneCutCacheSize is the maximum number of the cached zone cuts.  The
+	// cache is cleared when it's exceeded.
+	maxZoneCutCacheSize = 10_000
+
+	// maxZoneCutTTL is the maximum duration, for which a zone cut is cached.
+	maxZoneCutTTL = 24 * time.Hour
+
+	// minimizedUDPSize is the EDNS UDP payload size of the queries sent during
+	// the minimized resolution.
+	minimizedUDPSize = 1232
+)
+
+// errTooManySteps is returned when the resolution of a name requires more
+// than [maxResolutionSteps] queries.
+const errTooManySteps errors.Error = "too many resolution steps"
+
+// errQuestionMismatch is returned when the question of the response received
+// during the minimized resolution doesn't match the one of the query.
+const errQuestionMismatch errors.Error = "response is for another question"
+
+// zoneCut is a delegation found during the minimized resolution.
+type zoneCut struct {
+	// expire is the time when the delegation should be requested again.
+	expire time.Time
+
+	// servers are the addresses of the name servers of the zone.
+	servers []netip.AddrPort
+}
+
+// minimize resolves the question of req iteratively starting from the
+// upstream.  Each name server only receives the name one label longer than its
+// own zone, until the zone of the requested name is found.  The CNAME records
+// are followed.  See RFC 7816.
+func (u *privacyUpstream) minimize(req *dns.Msg) (resp *dns.Msg, err error) {
+	q := req.Question[0]
+	name := dns.CanonicalName(q.Name)
+
+	var answer []dns.RR
+	var res *dns.Msg
+	for i := 0; ; i++ {
+		res, err = u.resolve(name, q.Qtype, req, 0)
+		if err != nil {
+			return nil, fmt.Errorf("minimized resolution of %q: %w", name, err)
+		}
+
+		answer = append(answer, res.Answer...)
+
+		var ok bool
+		name, ok = cnameTarget(res.Answer, name, q.Qtype)
+		if !ok || res.Rcode != dns.RcodeSuccess || i == maxMinimizedCNAMEs-1 {
+			break
+		}
+	}
+
+	resp = (&dns.Msg{}).SetRcode(req, res.Rcode)
+	resp.RecursionAvailable = true
+	resp.Answer = answer
+	resp.Ns = res.Ns
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+
+	return resp, nil
+}
+
+// resolve resolves name of type qt iteratively starting from the closest known
+// zone cut.  If req isn't nil, its DNSSEC flags are used for the query with the
+// full name.  depth is the depth of the nested resolutions of the names of the
+// name servers.
+func (u *privacyUpstream) resolve(
+	name string,
+	qt uint16,
+	req *dns.Msg,
+	depth int,
+) (resp *dns.Msg, err error) {
+	start := name
+	if qt == dns.TypeDS && name != "." {
+		// The DS records are served by the parent zone.
+		start = parentName(name)
+	}
+
+	zone, servers := u.closestCut(start)
+	labels := dns.CountLabel(name)
+	n, minimized := dns.CountLabel(zone), 0
+	for i := 0; i < maxResolutionSteps; i++ {
+		qname, qtype, finalReq := name, qt, req
+		if n++; n < labels && minimized < maxMinimiseCount {
+			qname, qtype, finalReq = lastLabels(name, n), dns.TypeNS, nil
+			minimized++
+		} else {
+			n = labels
+		}
+
+		resp, err = u.query(servers, qname, qtype, finalReq)
+		if err != nil {
+			return nil, fmt.Errorf("querying %q: %w", qname, err)
+		}
+
+		if resp.Rcode == dns.RcodeNameError {
+			// The descendants of a nonexistent name don't exist either.  See
+			// RFC 8020.
+			return resp, nil
+		}
+
+		cut, ok := referralZone(resp, zone, qname)
+		if ok && !(qt == dns.TypeDS && cut == name) {
+			servers, err = u.delegation(resp, zone, cut, depth)
+			if err != nil {
+				return nil, fmt.Errorf("delegation to %q: %w", cut, err)
+			}
+
+			log.Debug("dnsforward: minimization: %q is delegated to %v", cut, servers)
+
+			zone, n = cut, dns.CountLabel(cut)
+
+			continue
+		}
+
+		if qname == name {
+			return resp, nil
+		}
+	}
+
+	return nil, errTooManySteps
+}
+
+// query sends the non-recursive query for qname of type qtype to one of the
+// servers or, if there are none, to the upstream itself.  If req isn't nil, its
+// DNSSEC flags are copied into the query.
+func (u *privacyUpstream) query(
+	servers []netip.AddrPort,
+	qname string,
+	qtype uint16,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	m := (&dns.Msg{}).SetQuestion(qname, qtype)
+	m.RecursionDesired = false
+
+	do := false
+	if req != nil {
+		m.CheckingDisabled = req.CheckingDisabled
+		if opt := req.IsEdns0(); opt != nil {
+			do = opt.Do()
+		}
+	}
+
+	m.SetEdns0(minimizedUDPSize, do)
+
+	if len(servers) == 0 {
+		resp, err = u.exchange(m, u.Upstream.Exchange)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		}
+
+		return resp, checkMinimizedResponse(m, resp)
+	}
+
+	var errs []error
+	for _, addr := range servers[:mathutil.Min(len(servers), maxNameServersTried)] {
+		addr := addr
+		resp, err = u.exchange(m, func(r *dns.Msg) (res *dns.Msg, exchErr error) {
+			return u.exchangeNS(r, addr)
+		})
+		if err == nil {
+			err = checkMinimizedResponse(m, resp)
+		}
+
+		if err == nil {
+			return resp, nil
+		}
+
+		errs = append(errs, fmt.Errorf("name server %s: %w", addr, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// checkMinimizedResponse returns an error if resp isn't a valid response to
+// req sent during the minimized resolution.
+func checkMinimizedResponse(req, resp *dns.Msg) (err error) {
+	q := req.Question[0]
+	if len(resp.Question) != 1 ||
+		resp.Question[0].Qtype != q.Qtype ||
+		!strings.EqualFold(resp.Question[0].Name, q.Name) {
+		return errQuestionMismatch
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		return nil
+	default:
+		return fmt.Errorf("response code %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+// referralZone returns the zone, to which resp refers the resolver.  ok is
+// false if resp isn't a referral from zone to an ancestor of qname or qname
+// itself.
+func referralZone(resp *dns.Msg, zone, qname string) (cut string, ok bool) {
+	if resp.Rcode != dns.RcodeSuccess || resp.Authoritative || len(resp.Answer) > 0 {
+		return "", false
+	}
+
+	for _, rr := range resp.Ns {
+		ns, isNS := rr.(*dns.NS)
+		if !isNS {
+			continue
+		}
+
+		cut = dns.CanonicalName(ns.Hdr.Name)
+		if cut != zone && dns.IsSubDomain(zone, cut) && dns.IsSubDomain(cut, qname) {
+			return cut, true
+		}
+	}
+
+	return "", false
+}
+
+// delegation returns the addresses of the name servers of cut from the
+// referral resp sent by the name servers of zone and caches them.  The names of
+// the name servers are resolved, if there is no glue for them.
+func (u *privacyUpstream) delegation(
+	resp *dns.Msg,
+	zone string,
+	cut string,
+	depth int,
+) (servers []netip.AddrPort, err error) {
+	var names []string
+	ttl := uint32(math.MaxUint32)
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*dns.NS)
+		if ok && dns.CanonicalName(ns.Hdr.Name) == cut {
+			names = append(names, dns.CanonicalName(ns.Ns))
+			ttl = mathutil.Min(ttl, ns.Hdr.Ttl)
+		}
+	}
+
+	var servers6 []netip.AddrPort
+	for _, rr := range resp.Extra {
+		// Only accept the glue within the zone of the name servers sending it.
+		owner := dns.CanonicalName(rr.Header().Name)
+		if !slices.Contains(names, owner) || !dns.IsSubDomain(zone, owner) {
+			continue
+		}
+
+		switch rr := rr.(type) {
+		case *dns.A:
+			servers = appendNameServer(servers, rr.A)
+		case *dns.AAAA:
+			servers6 = appendNameServer(servers6, rr.AAAA)
+		}
+	}
+
+	// Prefer IPv4, since IPv6 connectivity is more often missing.
+	servers = append(servers, servers6...)
+	if len(servers) == 0 {
+		servers, err = u.resolveNameServers(names, depth)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		}
+	}
+
+	u.storeCut(cut, servers, time.Duration(ttl)*time.Second)
+
+	return servers, nil
+}
+
+// appendNameServer appends the address of the name server with ip to servers,
+// if ip is valid.
+func appendNameServer(servers []netip.AddrPort, ip []byte) (res []netip.AddrPort) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return servers
+	}
+
+	return append(servers, netip.AddrPortFrom(addr.Unmap(), 53))
+}
+
+// resolveNameServers returns the IPv4 addresses of the name servers with names.
+// depth is the depth of the resolution requiring them.
+func (u *privacyUpstream) resolveNameServers(
+	names []string,
+	depth int,
+) (servers []netip.AddrPort, err error) {
+	if depth >= maxNSResolutionDepth {
+		return nil, errors.Error("name servers without glue are nested too deep")
+	}
+
+	var errs []error
+	for _, name := range names[:mathutil.Min(len(names), maxNameServersTried)] {
+		var resp *dns.Msg
+		resp, err = u.resolve(name, dns.TypeA, nil, depth+1)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolving %q: %w", name, err))
+
+			continue
+		}
+
+		for _, rr := range resp.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				servers = appendNameServer(servers, a.A)
+			}
+		}
+
+		if len(servers) > 0 {
+			return servers, nil
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return nil, errors.Error("no addresses of the name servers")
+}
+
+// closestCut returns the closest cached ancestor zone of name, or name itself,
+// and the addresses of its name servers.  If there is none, it returns the root
+// zone, which is served by the upstream itself, and nil.
+func (u *privacyUpstream) closestCut(name string) (zone string, servers []netip.AddrPort) {
+	u.cutsMu.Lock()
+	defer u.cutsMu.Unlock()
+
+	now := u.now()
+	for zone = name; zone != "."; zone = parentName(zone) {
+		c, ok := u.cuts[zone]
+		if ok && now.Before(c.expire) {
+			return zone, c.servers
+		}
+	}
+
+	return ".", nil
+}
+
+// storeCut caches the addresses of the name servers of zone for ttl.
+func (u *privacyUpstream) storeCut(zone string, servers []netip.AddrPort, ttl time.Duration) {
+	c := &zoneCut{
+		expire:  u.now().Add(mathutil.Min(ttl, maxZoneCutTTL)),
+		servers: servers,
+	}
+
+	u.cutsMu.Lock()
+	defer u.cutsMu.Unlock()
+
+	if len(u.cuts) >= maxZoneCutCacheSize {
+		u.cuts = map[string]*zoneCut{}
+	}
+
+	u.cuts[zone] = c
+}
+
+// cnameTarget returns the name, which the CNAME chain in rrs starting at name
+// leads to, if rrs don't contain the records of type qt for it.  ok is false if
+// there is no such chain.
+func cnameTarget(rrs []dns.RR, name string, qt uint16) (target string, ok bool) {
+	if qt == dns.TypeCNAME || qt == dns.TypeANY {
+		return "", false
+	}
+
+	target = name
+
+	// The chain can't be longer than the answer.
+	for range rrs {
+		next := ""
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if dns.CanonicalName(hdr.Name) != target {
+				continue
+			} else if hdr.Rrtype == qt {
+				return "", false
+			}
+
+			if c, isCNAME := rr.(*dns.CNAME); isCNAME {
+				next = dns.CanonicalName(c.Target)
+			}
+		}
+
+		if next == "" {
+			break
+		}
+
+		target = next
+	}
+
+	return target, target != name
+}
+
+// lastLabels returns the name consisting of the last n labels of name.  n must
+// be positive and less than the number of the labels of name.
+func lastLabels(name string, n int) (res string) {
+	idx := dns.Split(name)
+
+	return name[idx[len(idx)-n]:]
+}
+
+// exchangeNameServer sends req to the name server at addr over UDP and, if the
+// response is truncated, over TCP.
+func exchangeNameServer(
+	req *dns.Msg,
+	addr netip.AddrPort,
+	timeout time.Duration,
+) (resp *dns.Msg, err error) {
+	c := &dns.Client{
+		Net:     "udp",
+		Timeout: timeout,
+	}
+
+	resp, _, err = c.Exchange(req, addr.String())
+	if err == nil && resp.Truncated {
+		c.Net = "tcp"
+		resp, _, err = c.Exchange(req, addr.String())
+	}
+
+	return resp, err
+}
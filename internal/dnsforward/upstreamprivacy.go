@@ -0,0 +1,228 @@
+package dnsforward
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// UpstreamPrivacyConfig is the set of privacy settings for a single plain-UDP
+// upstream server.
+type UpstreamPrivacyConfig struct {
+	// Address is the address of the plain-UDP upstream server, for example
+	// "8.8.8.8" or "udp://8.8.8.8:53".
+	Address string `yaml:"address"`
+
+	// QNAMEMinimization, if true, makes AdGuard Home resolve the names
+	// iteratively starting from the upstream, which must be a root server or
+	// a server serving the root zone, and send each name server only the
+	// labels of the requested name it needs to know, as described in RFC 7816.
+	QNAMEMinimization bool `yaml:"qname_minimization"`
+
+	// CaseRandomization, if true, randomizes the case of the letters of the
+	// requested name and verifies that the response contains the same name,
+	// also known as 0x20 encoding.
+	CaseRandomization bool `yaml:"case_randomization"`
+}
+
+// errCaseMismatch is returned when the question of the response received from
+// an upstream with case randomization enabled doesn't match the question of the
+// request exactly, which is probably a sign of spoofing.
+const errCaseMismatch errors.Error = "response question does not match the request"
+
+// normalizePlainAddr returns the address of the plain-UDP upstream in the form
+// returned by its [upstream.Upstream.Address] method.  ok is false if addr is
+// not a plain-UDP address.
+func normalizePlainAddr(addr string) (norm string, ok bool) {
+	addr = strings.TrimPrefix(addr, "udp://")
+	if strings.Contains(addr, "://") {
+		return "", false
+	}
+
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), "53"), true
+	}
+
+	return addr, true
+}
+
+// validateUpstreamPrivacy returns an error if the upstream privacy settings
+// are invalid.
+func validateUpstreamPrivacy(confs []*UpstreamPrivacyConfig) (err error) {
+	for i, c := range confs {
+		if c == nil {
+			return fmt.Errorf("upstream privacy at index %d: %w", i, errors.Error("no value"))
+		}
+
+		if _, ok := normalizePlainAddr(c.Address); !ok {
+			return fmt.Errorf(
+				"upstream privacy at index %d: %q is not a plain udp upstream",
+				i,
+				c.Address,
+			)
+		}
+	}
+
+	return nil
+}
+
+// wrapPrivacyUpstreams wraps each plain-UDP upstream in ups that has the
+// privacy settings in confs.  timeout is the timeout of the requests to the
+// name servers found during the minimized resolution.
+func wrapPrivacyUpstreams(
+	ups []upstream.Upstream,
+	confs []*UpstreamPrivacyConfig,
+	timeout time.Duration,
+) {
+	if len(confs) == 0 {
+		return
+	}
+
+	byAddr := make(map[string]*UpstreamPrivacyConfig, len(confs))
+	for _, c := range confs {
+		if addr, ok := normalizePlainAddr(c.Address); ok {
+			byAddr[addr] = c
+		}
+	}
+
+	for i, u := range ups {
+		c, ok := byAddr[u.Address()]
+		if !ok || (!c.QNAMEMinimization && !c.CaseRandomization) {
+			continue
+		}
+
+		log.Debug("dnsforward: using privacy settings for upstream %s", u.Address())
+
+		ups[i] = &privacyUpstream{
+			Upstream: u,
+			cutsMu:   &sync.Mutex{},
+			cuts:     map[string]*zoneCut{},
+			now:      time.Now,
+			exchangeNS: func(req *dns.Msg, addr netip.AddrPort) (resp *dns.Msg, err error) {
+				return exchangeNameServer(req, addr, timeout)
+			},
+			qnameMinimization: c.QNAMEMinimization,
+			caseRandomization: c.CaseRandomization,
+		}
+	}
+}
+
+// privacyUpstream is an [upstream.Upstream] that minimizes the query names
+// and randomizes their case.
+type privacyUpstream struct {
+	upstream.Upstream
+
+	// cutsMu protects cuts.
+	cutsMu *sync.Mutex
+
+	// cuts are the zone cuts found during the minimized resolution by the
+	// names of their zones.
+	cuts map[string]*zoneCut
+
+	// now returns the current time.
+	now func() time.Time
+
+	// exchangeNS sends req to the name server at addr.
+	exchangeNS func(req *dns.Msg, addr netip.AddrPort) (resp *dns.Msg, err error)
+
+	// qnameMinimization defines if the names are resolved iteratively
+	// starting from the upstream with the minimized query names.
+	qnameMinimization bool
+
+	// caseRandomization defines if 0x20 encoding is used.
+	caseRandomization bool
+}
+
+// type check
+var _ upstream.Upstream = (*privacyUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *privacyUpstream.
+func (u *privacyUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	if len(req.Question) != 1 {
+		return u.Upstream.Exchange(req)
+	}
+
+	if u.qnameMinimization {
+		return u.minimize(req)
+	}
+
+	return u.exchange(req, u.Upstream.Exchange)
+}
+
+// exchange sends req using send randomizing the case of the name if
+// necessary.
+func (u *privacyUpstream) exchange(
+	req *dns.Msg,
+	send func(req *dns.Msg) (resp *dns.Msg, err error),
+) (resp *dns.Msg, err error) {
+	if !u.caseRandomization {
+		return send(req)
+	}
+
+	origName := req.Question[0].Name
+	randName, err := randomizeCase(origName)
+	if err != nil {
+		return nil, fmt.Errorf("randomizing case: %w", err)
+	}
+
+	req.Question[0].Name = randName
+	defer func() { req.Question[0].Name = origName }()
+
+	resp, err = send(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	if len(resp.Question) != 1 || resp.Question[0].Name != randName {
+		return nil, errCaseMismatch
+	}
+
+	restoreCase(resp, randName, origName)
+
+	return resp, nil
+}
+
+// randomizeCase returns name with the case of each ASCII letter randomized.
+func randomizeCase(name string) (res string, err error) {
+	bits := make([]byte, len(name))
+	_, err = rand.Read(bits)
+	if err != nil {
+		return "", err
+	}
+
+	b := []byte(name)
+	for i, c := range b {
+		isLetter := ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+		if isLetter && bits[i]&1 == 1 {
+			b[i] = c ^ 0x20
+		}
+	}
+
+	return string(b), nil
+}
+
+// restoreCase replaces randName with origName in the question and in the
+// owner names of the resource records of resp.
+func restoreCase(resp *dns.Msg, randName, origName string) {
+	resp.Question[0].Name = origName
+
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if hdr.Name == randName {
+				hdr.Name = origName
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePlainAddr(t *testing.T) {
+	testCases := []struct {
+		name     string
+		addr     string
+		wantNorm string
+		wantOK   bool
+	}{{
+		name:     "ip",
+		addr:     "1.2.3.4",
+		wantNorm: "1.2.3.4:53",
+		wantOK:   true,
+	}, {
+		name:     "ip_port",
+		addr:     "1.2.3.4:5353",
+		wantNorm: "1.2.3.4:5353",
+		wantOK:   true,
+	}, {
+		name:     "udp_scheme",
+		addr:     "udp://[::1]",
+		wantNorm: "[::1]:53",
+		wantOK:   true,
+	}, {
+		name:     "tls",
+		addr:     "tls://1.2.3.4",
+		wantNorm: "",
+		wantOK:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			norm, ok := normalizePlainAddr(tc.addr)
+			assert.Equal(t, tc.wantNorm, norm)
+			assert.Equal(t, tc.wantOK, ok)
+		})
+	}
+}
+
+func TestPrivacyUpstream_Exchange(t *testing.T) {
+	const (
+		addr    = "1.2.3.4:53"
+		reqName = "www.example.com."
+	)
+
+	var ups upstream.Upstream = &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return addr },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			return (&dns.Msg{}).SetReply(req), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	upsList := []upstream.Upstream{ups}
+	wrapPrivacyUpstreams(upsList, []*UpstreamPrivacyConfig{{
+		Address:           "1.2.3.4",
+		CaseRandomization: true,
+	}}, time.Second)
+	require.IsType(t, (*privacyUpstream)(nil), upsList[0])
+
+	req := (&dns.Msg{}).SetQuestion(reqName, dns.TypeA)
+	resp, err := upsList[0].Exchange(req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Equal(t, reqName, resp.Question[0].Name)
+	assert.Equal(t, reqName, req.Question[0].Name)
+}
+
+func TestPrivacyUpstream_Exchange_caseMismatch(t *testing.T) {
+	ups := &privacyUpstream{
+		Upstream: &aghtest.UpstreamMock{
+			OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+				resp = (&dns.Msg{}).SetReply(req)
+				resp.Question[0].Name = "spoofed.example."
+
+				return resp, nil
+			},
+		},
+		caseRandomization: true,
+	}
+
+	req := (&dns.Msg{}).SetQuestion("www.example.com.", dns.TypeA)
+	_, err := ups.Exchange(req)
+	assert.ErrorIs(t, err, errCaseMismatch)
+}
+
+// newTestReferral returns a referral response to req delegating zone to the
+// name server with the address addr.
+func newTestReferral(req *dns.Msg, zone string, addr netip.Addr) (resp *dns.Msg) {
+	nsName := "ns." + zone
+
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.Ns = []dns.RR{&dns.NS{
+		Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 3600},
+		Ns:  nsName,
+	}}
+	resp.Extra = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: nsName, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+		A:   addr.AsSlice(),
+	}}
+
+	return resp
+}
+
+func TestPrivacyUpstream_Exchange_minimization(t *testing.T) {
+	var (
+		comAddr     = netip.MustParseAddr("192.0.2.1")
+		exampleAddr = netip.MustParseAddr("192.0.2.2")
+		answerIP    = net.IP{192, 0, 2, 3}
+	)
+
+	// seen are the names sent to each name server, the upstream is "root".
+	seenMu := &sync.Mutex{}
+	seen := map[string][]string{}
+	see := func(srv string, req *dns.Msg) (name string) {
+		name = dns.CanonicalName(req.Question[0].Name)
+
+		seenMu.Lock()
+		defer seenMu.Unlock()
+
+		seen[srv] = append(seen[srv], name)
+
+		return name
+	}
+
+	root := &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return "192.0.2.0:53" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			if see("root", req) != "com." {
+				return (&dns.Msg{}).SetRcode(req, dns.RcodeRefused), nil
+			}
+
+			return newTestReferral(req, "com.", comAddr), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	exchangeNS := func(req *dns.Msg, addr netip.AddrPort) (resp *dns.Msg, err error) {
+		require.Equal(t, uint16(53), addr.Port())
+		assert.False(t, req.RecursionDesired)
+
+		switch addr.Addr() {
+		case comAddr:
+			switch see("com", req) {
+			case "example.com.":
+				return newTestReferral(req, "example.com.", exampleAddr), nil
+			default:
+				return (&dns.Msg{}).SetRcode(req, dns.RcodeNameError), nil
+			}
+		case exampleAddr:
+			name := see("example", req)
+			resp = (&dns.Msg{}).SetReply(req)
+			resp.Authoritative = true
+			resp.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   req.Question[0].Name,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    60,
+				},
+				A: answerIP,
+			}}
+			require.Contains(t, []string{"www.example.com.", "mail.example.com."}, name)
+
+			return resp, nil
+		default:
+			t.Fatalf("unexpected name server %s", addr)
+
+			return nil, nil
+		}
+	}
+
+	ups := &privacyUpstream{
+		Upstream:          root,
+		cutsMu:            &sync.Mutex{},
+		cuts:              map[string]*zoneCut{},
+		now:               time.Now,
+		exchangeNS:        exchangeNS,
+		qnameMinimization: true,
+		caseRandomization: true,
+	}
+
+	t.Run("delegation", func(t *testing.T) {
+		const reqName = "www.example.com."
+
+		req := (&dns.Msg{}).SetQuestion(reqName, dns.TypeA)
+		resp, err := ups.Exchange(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Equal(t, req.Id, resp.Id)
+		assert.Equal(t, reqName, resp.Question[0].Name)
+
+		require.Len(t, resp.Answer, 1)
+		a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+		assert.Equal(t, reqName, a.Hdr.Name)
+		assert.Equal(t, answerIP, a.A)
+
+		assert.Equal(t, []string{"com."}, seen["root"])
+		assert.Equal(t, []string{"example.com."}, seen["com"])
+		assert.Equal(t, []string{"www.example.com."}, seen["example"])
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		req := (&dns.Msg{}).SetQuestion("mail.example.com.", dns.TypeA)
+		resp, err := ups.Exchange(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Len(t, resp.Answer, 1)
+
+		assert.Equal(t, []string{"com."}, seen["root"])
+		assert.Equal(t, []string{"example.com."}, seen["com"])
+		assert.Equal(t, []string{"www.example.com.", "mail.example.com."}, seen["example"])
+	})
+
+	t.Run("nxdomain", func(t *testing.T) {
+		req := (&dns.Msg{}).SetQuestion("a.b.nonexistent.com.", dns.TypeA)
+		resp, err := ups.Exchange(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+		assert.Empty(t, resp.Answer)
+
+		assert.Equal(t, []string{"example.com.", "nonexistent.com."}, seen["com"])
+	})
+}
+
+func TestCnameTarget(t *testing.T) {
+	newCNAME := func(name, target string) (rr dns.RR) {
+		return &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET},
+			Target: target,
+		}
+	}
+
+	newA := func(name string) (rr dns.RR) {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+			A:   net.IP{192, 0, 2, 1},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		rrs        []dns.RR
+		qt         uint16
+		wantTarget string
+		wantOK     bool
+	}{{
+		name:       "no_cname",
+		rrs:        []dns.RR{newA("www.example.com.")},
+		qt:         dns.TypeA,
+		wantTarget: "",
+		wantOK:     false,
+	}, {
+		name:       "resolved_chain",
+		rrs:        []dns.RR{newCNAME("www.example.com.", "cdn.example.net."), newA("cdn.example.net.")},
+		qt:         dns.TypeA,
+		wantTarget: "",
+		wantOK:     false,
+	}, {
+		name: "unresolved_chain",
+		rrs: []dns.RR{
+			newCNAME("www.example.com.", "a.example.net."),
+			newCNAME("A.example.net.", "b.example.org."),
+		},
+		qt:         dns.TypeA,
+		wantTarget: "b.example.org.",
+		wantOK:     true,
+	}, {
+		name:       "cname_requested",
+		rrs:        []dns.RR{newCNAME("www.example.com.", "cdn.example.net.")},
+		qt:         dns.TypeCNAME,
+		wantTarget: "",
+		wantOK:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target, ok := cnameTarget(tc.rrs, "www.example.com.", tc.qt)
+			assert.Equal(t, tc.wantTarget, target)
+			assert.Equal(t, tc.wantOK, ok)
+		})
+	}
+}
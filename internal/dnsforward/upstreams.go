@@ -11,6 +11,7 @@ import (
 
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
@@ -47,6 +48,24 @@ func (s *Server) prepareUpstreamSettings() (err error) {
 		return fmt.Errorf("loading upstreams: %w", err)
 	}
 
+	err = validateUpstreamPrivacy(s.conf.UpstreamPrivacy)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = validateUpstreamECS(s.conf.UpstreamECS)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = validateAdaptiveTimeout(s.conf.AdaptiveTimeout, s.conf.UpstreamTimeout)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
 	s.conf.UpstreamConfig, err = s.prepareUpstreamConfig(upstreams, defaultDNS, &upstream.Options{
 		Bootstrap:    s.conf.BootstrapDNS,
 		Timeout:      s.conf.UpstreamTimeout,
@@ -66,9 +85,77 @@ func (s *Server) prepareUpstreamSettings() (err error) {
 		return fmt.Errorf("preparing upstream config: %w", err)
 	}
 
+	s.wrapUpstreamConfig(s.conf.UpstreamConfig)
+
 	return nil
 }
 
+// NewClientUpstreamConfig returns the upstream configuration of a client
+// parsed from lines using opts.  Same as the global one, its upstreams use the
+// adaptive timeout, privacy, ECS, and race settings of s.  It's intended to be
+// called from [Config.GetCustomUpstreamByClient], so s.requestLock is expected
+// to be locked for reading.
+func (s *Server) NewClientUpstreamConfig(
+	lines []string,
+	opts *upstream.Options,
+) (uc *proxy.UpstreamConfig, err error) {
+	var ps *pipelineStats
+	if s.conf.UpstreamPipelining {
+		ps = s.pipelineStats
+	}
+
+	uc, err = parseUpstreamsConfig(lines, opts, s.quicStats, ps)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	s.wrapUpstreamConfig(uc)
+
+	return uc, nil
+}
+
+// wrapUpstreamConfig wraps the upstreams of uc according to the settings of s.
+func (s *Server) wrapUpstreamConfig(uc *proxy.UpstreamConfig) {
+	s.wrapUpstreams(uc.Upstreams)
+	for _, ups := range uc.DomainReservedUpstreams {
+		s.wrapUpstreams(ups)
+	}
+
+	for _, ups := range uc.SpecifiedDomainUpstreams {
+		s.wrapUpstreams(ups)
+	}
+
+	if !s.isRaceMode() {
+		return
+	}
+
+	uc.Upstreams = s.wrapRaceUpstreams(uc.Upstreams)
+	for host, ups := range uc.DomainReservedUpstreams {
+		uc.DomainReservedUpstreams[host] = s.wrapRaceUpstreams(ups)
+	}
+
+	for host, ups := range uc.SpecifiedDomainUpstreams {
+		uc.SpecifiedDomainUpstreams[host] = s.wrapRaceUpstreams(ups)
+	}
+}
+
+// isRaceMode returns true if the upstreams are raced.
+func (s *Server) isRaceMode() (ok bool) {
+	return s.conf.UpstreamRace && !s.conf.AllServers && !s.conf.FastestAddr
+}
+
+// wrapUpstreams wraps the upstreams in ups according to their adaptive timeout,
+// privacy, and ECS settings.
+func (s *Server) wrapUpstreams(ups []upstream.Upstream) {
+	// Wrap with the adaptive timeout first, so that it applies to each of the
+	// requests sent to the upstream itself during the minimized resolution.
+	// The requests to the name servers it refers to use the upstream timeout.
+	wrapAdaptiveUpstreams(ups, s.conf.AdaptiveTimeout, s.conf.UpstreamTimeout)
+	wrapPrivacyUpstreams(ups, s.conf.UpstreamPrivacy, s.conf.UpstreamTimeout)
+	wrapECSUpstreams(ups, s.conf.UpstreamECS, s.clientSubnets)
+}
+
 // prepareUpstreamConfig returns the upstream configuration based on upstreams
 // and configuration of s.
 func (s *Server) prepareUpstreamConfig(
@@ -76,7 +163,12 @@ func (s *Server) prepareUpstreamConfig(
 	defaultUpstreams []string,
 	opts *upstream.Options,
 ) (uc *proxy.UpstreamConfig, err error) {
-	uc, err = proxy.ParseUpstreamsConfig(upstreams, opts)
+	var ps *pipelineStats
+	if s.conf.UpstreamPipelining {
+		ps = s.pipelineStats
+	}
+
+	uc, err = parseUpstreamsConfig(upstreams, opts, s.quicStats, ps)
 	if err != nil {
 		return nil, fmt.Errorf("parsing upstream config: %w", err)
 	}
@@ -84,7 +176,7 @@ func (s *Server) prepareUpstreamConfig(
 	if len(uc.Upstreams) == 0 && defaultUpstreams != nil {
 		log.Info("dnsforward: warning: no default upstreams specified, using %v", defaultUpstreams)
 		var defaultUpstreamConfig *proxy.UpstreamConfig
-		defaultUpstreamConfig, err = proxy.ParseUpstreamsConfig(defaultUpstreams, opts)
+		defaultUpstreamConfig, err = parseUpstreamsConfig(defaultUpstreams, opts, s.quicStats, ps)
 		if err != nil {
 			return nil, fmt.Errorf("parsing default upstreams: %w", err)
 		}
@@ -103,6 +195,173 @@ func (s *Server) prepareUpstreamConfig(
 	return uc, nil
 }
 
+// ParseUpstreamsConfig is a wrapper around [proxy.ParseUpstreamsConfig] that
+// also supports the ODoH upstreams, which have the "odoh://" scheme.
+func ParseUpstreamsConfig(
+	lines []string,
+	opts *upstream.Options,
+) (uc *proxy.UpstreamConfig, err error) {
+	return parseUpstreamsConfig(lines, opts, nil, nil)
+}
+
+// parseUpstreamsConfig parses the upstream configuration from lines.  The
+// DNS-over-QUIC upstreams are traced by qs, if it's not nil.  The
+// DNS-over-TCP and DNS-over-TLS upstreams use the pipelined connections and
+// report their statistics to ps, if it's not nil.
+//
+// The lines are parsed by [proxy.ParseUpstreamsConfig], so that the order of
+// the upstreams and the handling of the domain specifications are the same for
+// all of them.  The addresses of the upstreams, which dnsproxy can't create,
+// are replaced with the placeholders, which are then replaced with the
+// upstreams created here.
+func parseUpstreamsConfig(
+	lines []string,
+	opts *upstream.Options,
+	qs *quicStats,
+	ps *pipelineStats,
+) (uc *proxy.UpstreamConfig, err error) {
+	if opts == nil {
+		opts = &upstream.Options{}
+	}
+
+	lines, custom := replaceCustomUpstreams(lines, qs, ps)
+
+	uc, err = proxy.ParseUpstreamsConfig(lines, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(custom) == 0 {
+		return uc, nil
+	}
+
+	defer func() {
+		if err != nil {
+			err = errors.WithDeferred(err, uc.Close())
+		}
+	}()
+
+	// The placeholders are plain-DNS upstreams, which have never been used, so
+	// there is nothing to close.
+	byPlaceholder := make(map[string]upstream.Upstream, len(custom))
+	for _, c := range custom {
+		o := opts.Clone()
+		if qs != nil {
+			o.QUICTracer = qs.tracer(c.addr)
+		}
+
+		var u upstream.Upstream
+		if ps != nil && isPipelineAddr(c.addr) {
+			u, err = newPipelineUpstream(c.addr, o, ps)
+		} else {
+			u, err = addressToUpstream(c.addr, o)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot prepare the upstream %s: %w", c.line, err)
+		}
+
+		byPlaceholder[c.placeholder] = u
+	}
+
+	replacePlaceholders(uc.Upstreams, byPlaceholder)
+	for _, ups := range uc.DomainReservedUpstreams {
+		replacePlaceholders(ups, byPlaceholder)
+	}
+
+	for _, ups := range uc.SpecifiedDomainUpstreams {
+		replacePlaceholders(ups, byPlaceholder)
+	}
+
+	return uc, nil
+}
+
+// customUpstream is an upstream, which is created by AdGuard Home instead of
+// dnsproxy.
+type customUpstream struct {
+	// addr is the address of the upstream.
+	addr string
+
+	// line is the first configuration line containing the upstream.
+	line string
+
+	// placeholder is the address of the plain-DNS upstream, which replaces the
+	// upstream in the lines passed to dnsproxy, in the form returned by its
+	// [upstream.Upstream.Address] method.
+	placeholder string
+}
+
+// replaceCustomUpstreams returns lines with the addresses of the upstreams,
+// which need to be created by AdGuard Home, replaced with the placeholders.
+// qs and ps are the same as in [parseUpstreamsConfig].  The placeholders are
+// the addresses from the discard-only prefix 100::/64, see RFC 6666, which
+// aren't used in lines.
+func replaceCustomUpstreams(
+	lines []string,
+	qs *quicStats,
+	ps *pipelineStats,
+) (res []string, custom []*customUpstream) {
+	isCustom := func(addr string) (ok bool) {
+		return (qs != nil && strings.HasPrefix(addr, "quic://")) ||
+			(ps != nil && isPipelineAddr(addr)) ||
+			strings.HasPrefix(addr, odohScheme)
+	}
+
+	used := map[string]struct{}{}
+	for _, l := range lines {
+		addr, _, sepErr := separateUpstream(l)
+		if norm, ok := normalizePlainAddr(addr); ok && sepErr == nil && !isCustom(addr) {
+			used[norm] = struct{}{}
+		}
+	}
+
+	res = make([]string, 0, len(lines))
+	byAddr := map[string]*customUpstream{}
+	n := 0
+	for _, l := range lines {
+		addr, _, sepErr := separateUpstream(l)
+		if sepErr != nil || !isCustom(addr) {
+			// Let dnsproxy report the errors.
+			res = append(res, l)
+
+			continue
+		}
+
+		c, ok := byAddr[addr]
+		if !ok {
+			c = &customUpstream{
+				addr: addr,
+				line: l,
+			}
+
+			for {
+				n++
+				c.placeholder = fmt.Sprintf("[100::%x]:53", n)
+				if _, ok = used[c.placeholder]; !ok {
+					break
+				}
+			}
+
+			byAddr[addr] = c
+			custom = append(custom, c)
+		}
+
+		res = append(res, l[:len(l)-len(addr)]+c.placeholder)
+	}
+
+	return res, custom
+}
+
+// replacePlaceholders replaces the placeholder upstreams in ups with the
+// corresponding upstreams from byPlaceholder.
+func replacePlaceholders(ups []upstream.Upstream, byPlaceholder map[string]upstream.Upstream) {
+	for i, u := range ups {
+		if c, ok := byPlaceholder[u.Address()]; ok {
+			ups[i] = c
+		}
+	}
+}
+
 // replaceUpstreamsWithHosts replaces unique upstreams with their resolved
 // versions based on the system hosts file.
 //
@@ -152,6 +411,11 @@ func (s *Server) resolveUpstreamsWithHosts(
 ) (err error) {
 	for i := range upstreams {
 		u := upstreams[i]
+		if _, ok := u.(*odohUpstream); ok {
+			// ODoH upstreams resolve the target and the relay on their own.
+			continue
+		}
+
 		addr := u.Address()
 		host := extractUpstreamHost(addr)
 
@@ -180,7 +444,12 @@ func (s *Server) resolveUpstreamsWithHosts(
 			return fmt.Errorf("closing upstream %s: %w", addr, err)
 		}
 
-		upstreams[i], err = upstream.AddressToUpstream(addr, withIPs)
+		if pu, isPipeline := u.(*pipelineUpstream); isPipeline {
+			upstreams[i], err = newPipelineUpstream(addr, withIPs, pu.stats)
+		} else {
+			upstreams[i], err = upstream.AddressToUpstream(addr, withIPs)
+		}
+
 		if err != nil {
 			return fmt.Errorf("replacing upstream %s with resolved %s: %w", addr, host, err)
 		}
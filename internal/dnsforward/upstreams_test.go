@@ -0,0 +1,88 @@
+package dnsforward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstreamsConfig_order(t *testing.T) {
+	const odohAddr = "odoh://odoh.example/dns-query"
+
+	uc, err := parseUpstreamsConfig([]string{
+		"tls://first.example",
+		"1.1.1.1",
+		odohAddr,
+		"[100::1]:53",
+		"[/example.org/]" + odohAddr,
+		"[/example.org/]8.8.8.8",
+	}, &upstream.Options{}, nil, newPipelineStats())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	addrs := make([]string, 0, len(uc.Upstreams))
+	for _, u := range uc.Upstreams {
+		addrs = append(addrs, u.Address())
+	}
+
+	assert.Equal(t, []string{
+		"tls://first.example",
+		"1.1.1.1:53",
+		odohAddr,
+		"[100::1]:53",
+	}, addrs)
+
+	assert.IsType(t, (*pipelineUpstream)(nil), uc.Upstreams[0])
+	assert.IsType(t, (*odohUpstream)(nil), uc.Upstreams[2])
+
+	ups := uc.SpecifiedDomainUpstreams["example.org."]
+	require.Len(t, ups, 2)
+
+	assert.Same(t, uc.Upstreams[2], ups[0])
+	assert.Equal(t, "8.8.8.8:53", ups[1].Address())
+}
+
+func TestParseUpstreamsConfig_badDomain(t *testing.T) {
+	_, err := parseUpstreamsConfig([]string{
+		"[/bad..example/]odoh://odoh.example/dns-query",
+	}, &upstream.Options{}, nil, nil)
+	testutil.AssertErrorMsg(
+		t,
+		`bad domain name "bad..example": bad domain name label "": domain name label is empty`,
+		err,
+	)
+}
+
+func TestServer_NewClientUpstreamConfig(t *testing.T) {
+	s := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				AdaptiveTimeout: &AdaptiveTimeoutConfig{
+					Min:        timeutil.Duration{Duration: time.Second},
+					Multiplier: 2,
+					Percentile: 99,
+					Enabled:    true,
+				},
+			},
+			UpstreamTimeout: 10 * time.Second,
+		},
+	}
+
+	uc, err := s.NewClientUpstreamConfig([]string{
+		"1.1.1.1",
+		"[/example.org/]odoh://odoh.example/dns-query",
+	}, &upstream.Options{})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	require.Len(t, uc.Upstreams, 1)
+	assert.IsType(t, (*adaptiveUpstream)(nil), uc.Upstreams[0])
+
+	require.Len(t, uc.SpecifiedDomainUpstreams["example.org."], 1)
+	assert.IsType(t, (*adaptiveUpstream)(nil), uc.SpecifiedDomainUpstreams["example.org."][0])
+}
@@ -0,0 +1,411 @@
+// Package dnsupdate contains the listener of the dynamic DNS UPDATE messages,
+// see RFC 2136, which adds and removes the legacy DNS rewrites within the
+// configured zones.
+package dnsupdate
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// Rewrites is the storage of the legacy DNS rewrites.  It's implemented by
+// [*filtering.DNSFilter].
+type Rewrites interface {
+	// ModifyRewrites atomically replaces the rewrites with the result of f.
+	// See [filtering.DNSFilter.ModifyRewrites].
+	ModifyRewrites(
+		f func(rws []*filtering.LegacyRewrite) (res []*filtering.LegacyRewrite, changed bool, err error),
+	) (err error)
+}
+
+// KeyConfig is the configuration of a TSIG key.
+type KeyConfig struct {
+	// Name is the name of the key, for example "dhcp-key".
+	Name string
+
+	// Algorithm is the algorithm of the key, for example "hmac-sha256".  If
+	// empty, "hmac-sha256" is used.
+	Algorithm string
+
+	// Secret is the base64-encoded secret of the key.
+	Secret string
+}
+
+// ZoneConfig is the configuration of a zone accepting the updates.
+type ZoneConfig struct {
+	// Name is the name of the zone, for example "lan".
+	Name string
+
+	// Keys are the names of the TSIG keys allowed to update the zone.  It
+	// must not be empty.
+	Keys []string
+}
+
+// Config is the configuration of the server.
+type Config struct {
+	// Rewrites is the storage of the records.  It must not be nil.
+	Rewrites Rewrites
+
+	// Addrs are the addresses to listen on for both UDP and TCP.  It must not
+	// be empty.
+	Addrs []netip.AddrPort
+
+	// Keys are the TSIG keys used to authenticate the updates.  Each item must
+	// not be nil.
+	Keys []*KeyConfig
+
+	// Zones are the zones accepting the updates.  Each item must not be nil.
+	Zones []*ZoneConfig
+}
+
+// ProvenanceSource is the source of the provenance of the rewrites added by the
+// updates.  See [filtering.RuleProvenance.Source].
+const ProvenanceSource = "dns_update"
+
+// tsigFudge is the permitted difference between the clocks of AdGuard Home and
+// the clients in seconds.
+const tsigFudge = 300
+
+// tsigAlgorithms are the supported TSIG algorithms by their names.
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// zone is a zone accepting the updates.
+type zone struct {
+	// keys are the FQDNs of the keys allowed to update the zone.
+	keys *stringutil.Set
+
+	// name is the lowercased FQDN of the zone.
+	name string
+}
+
+// Server accepts the DNS UPDATE messages signed with the configured TSIG keys
+// and applies them to the rewrites.
+type Server struct {
+	// rewrites is the storage of the records.
+	rewrites Rewrites
+
+	// zones are the zones accepting the updates by their lowercased FQDNs.
+	zones map[string]*zone
+
+	// secrets are the base64-encoded secrets of the keys by their lowercased
+	// FQDNs.
+	secrets map[string]string
+
+	// algorithms are the algorithms of the keys by their lowercased FQDNs.
+	algorithms map[string]string
+
+	// mu protects servers.
+	mu *sync.Mutex
+
+	// servers are the running DNS servers.
+	servers []*dns.Server
+
+	// addrs are the addresses to listen on.
+	addrs []netip.AddrPort
+}
+
+// Validate returns an error if conf is invalid.  Rewrites isn't checked, so
+// that the configuration could be validated before the storage is ready.
+func (conf *Config) Validate() (err error) {
+	_, err = newServer(conf)
+
+	return err
+}
+
+// New returns a new properly initialized *Server.  conf must not be nil.
+func New(conf *Config) (s *Server, err error) {
+	if conf.Rewrites == nil {
+		return nil, errors.Error("rewrites: no value")
+	}
+
+	return newServer(conf)
+}
+
+// newServer returns a new *Server for conf without checking conf.Rewrites.
+func newServer(conf *Config) (s *Server, err error) {
+	if len(conf.Addrs) == 0 {
+		return nil, errors.Error("addresses: no value")
+	} else if len(conf.Zones) == 0 {
+		return nil, errors.Error("zones: no value")
+	}
+
+	s = &Server{
+		rewrites:   conf.Rewrites,
+		zones:      make(map[string]*zone, len(conf.Zones)),
+		secrets:    make(map[string]string, len(conf.Keys)),
+		algorithms: make(map[string]string, len(conf.Keys)),
+		mu:         &sync.Mutex{},
+		addrs:      conf.Addrs,
+	}
+
+	for i, kc := range conf.Keys {
+		err = s.addKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("key at index %d: %w", i, err)
+		}
+	}
+
+	for i, zc := range conf.Zones {
+		err = s.addZone(zc)
+		if err != nil {
+			return nil, fmt.Errorf("zone at index %d: %w", i, err)
+		}
+	}
+
+	return s, nil
+}
+
+// addKey validates kc and adds the key to s.
+func (s *Server) addKey(kc *KeyConfig) (err error) {
+	if kc == nil {
+		return errors.Error("no value")
+	}
+
+	name := strings.ToLower(dns.Fqdn(kc.Name))
+	if _, ok := dns.IsDomainName(name); !ok || kc.Name == "" {
+		return fmt.Errorf("bad name %q", kc.Name)
+	} else if _, ok = s.secrets[name]; ok {
+		return fmt.Errorf("duplicate key %q", name)
+	}
+
+	alg := kc.Algorithm
+	if alg == "" {
+		alg = "hmac-sha256"
+	}
+
+	algFQDN, ok := tsigAlgorithms[strings.ToLower(strings.TrimSuffix(alg, "."))]
+	if !ok {
+		return fmt.Errorf("algorithm: bad value %q", alg)
+	}
+
+	if kc.Secret == "" {
+		return errors.Error("secret: no value")
+	}
+
+	_, err = base64.StdEncoding.DecodeString(kc.Secret)
+	if err != nil {
+		return fmt.Errorf("secret: %w", err)
+	}
+
+	s.secrets[name], s.algorithms[name] = kc.Secret, algFQDN
+
+	return nil
+}
+
+// addZone validates zc and adds the zone to s.  The keys must be added before.
+func (s *Server) addZone(zc *ZoneConfig) (err error) {
+	if zc == nil {
+		return errors.Error("no value")
+	}
+
+	name := strings.ToLower(dns.Fqdn(zc.Name))
+	if _, ok := dns.IsDomainName(name); !ok || zc.Name == "" || name == "." {
+		return fmt.Errorf("bad name %q", zc.Name)
+	} else if _, ok = s.zones[name]; ok {
+		return fmt.Errorf("duplicate zone %q", name)
+	} else if len(zc.Keys) == 0 {
+		return fmt.Errorf("zone %q: keys: no value", name)
+	}
+
+	z := &zone{
+		keys: stringutil.NewSet(),
+		name: name,
+	}
+
+	for _, k := range zc.Keys {
+		keyName := strings.ToLower(dns.Fqdn(k))
+		if _, ok := s.secrets[keyName]; !ok {
+			return fmt.Errorf("zone %q: unknown key %q", name, k)
+		}
+
+		z.keys.Add(keyName)
+	}
+
+	s.zones[name] = z
+
+	return nil
+}
+
+// Start starts listening on the configured addresses.  If any of the
+// listeners fails to start, the started ones are closed.
+func (s *Server) Start() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, addr := range s.addrs {
+		err = s.listen(addr)
+		if err != nil {
+			return errors.WithDeferred(err, s.closeLocked())
+		}
+	}
+
+	return nil
+}
+
+// listen starts the UDP and TCP servers on addr.  s.mu is expected to be
+// locked.
+func (s *Server) listen(addr netip.AddrPort) (err error) {
+	pc, err := net.ListenPacket("udp", addr.String())
+	if err != nil {
+		return fmt.Errorf("listening on udp %s: %w", addr, err)
+	}
+
+	s.serve(&dns.Server{PacketConn: pc})
+
+	l, err := net.Listen("tcp", addr.String())
+	if err != nil {
+		return fmt.Errorf("listening on tcp %s: %w", addr, err)
+	}
+
+	s.serve(&dns.Server{Listener: l})
+
+	log.Info("dnsupdate: listening on %s", addr)
+
+	return nil
+}
+
+// serve starts srv with the connection already set and waits for it to start.
+// s.mu is expected to be locked.
+func (s *Server) serve(srv *dns.Server) {
+	started := make(chan struct{})
+
+	srv.Handler = s
+	srv.TsigSecret = s.secrets
+	srv.MsgAcceptFunc = acceptUpdate
+	srv.NotifyStartedFunc = func() { close(started) }
+
+	go func() {
+		defer log.OnPanic("dnsupdate: serving")
+
+		err := srv.ActivateAndServe()
+		if err != nil {
+			log.Debug("dnsupdate: serving: %s", err)
+		}
+	}()
+
+	<-started
+
+	s.servers = append(s.servers, srv)
+}
+
+// Close stops all listeners.
+func (s *Server) Close() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeLocked()
+}
+
+// closeLocked stops all listeners.  s.mu is expected to be locked.
+func (s *Server) closeLocked() (err error) {
+	var errs []error
+	for _, srv := range s.servers {
+		err = srv.Shutdown()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.servers = nil
+
+	return errors.Join(errs...)
+}
+
+// acceptUpdate is the [dns.MsgAcceptFunc], which only accepts the UPDATE
+// messages.
+func acceptUpdate(dh dns.Header) (act dns.MsgAcceptAction) {
+	const (
+		qrBit       = 1 << 15
+		opcodeShift = 11
+		opcodeMask  = 0xf
+	)
+
+	if dh.Bits&qrBit != 0 {
+		return dns.MsgIgnore
+	} else if int(dh.Bits>>opcodeShift)&opcodeMask != dns.OpcodeUpdate {
+		return dns.MsgRejectNotImplemented
+	} else if dh.Qdcount != 1 {
+		return dns.MsgReject
+	}
+
+	return dns.MsgAccept
+}
+
+// type check
+var _ dns.Handler = (*Server)(nil)
+
+// ServeDNS implements the [dns.Handler] interface for *Server.
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	tsigStatus := w.TsigStatus()
+	resp := s.handle(req, tsigStatus)
+
+	// Sign the response with the key of the request, if it's valid.  See
+	// RFC 8945, Section 5.3.
+	if tsig := req.IsTsig(); tsig != nil && tsigStatus == nil {
+		resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsigFudge, time.Now().Unix())
+	}
+
+	err := w.WriteMsg(resp)
+	if err != nil {
+		log.Debug("dnsupdate: writing response: %s", err)
+	}
+}
+
+// handle returns the response to the UPDATE message req.  tsigStatus is the
+// result of the verification of the TSIG record of req, if any.
+func (s *Server) handle(req *dns.Msg, tsigStatus error) (resp *dns.Msg) {
+	resp = &dns.Msg{}
+	if len(req.Question) != 1 {
+		return resp.SetRcode(req, dns.RcodeFormatError)
+	}
+
+	q := req.Question[0]
+	if q.Qtype != dns.TypeSOA || q.Qclass != dns.ClassINET {
+		return resp.SetRcode(req, dns.RcodeFormatError)
+	}
+
+	name := strings.ToLower(q.Name)
+	z, ok := s.zones[name]
+	if !ok {
+		log.Debug("dnsupdate: refusing update for unknown zone %q", name)
+
+		return resp.SetRcode(req, dns.RcodeNotAuth)
+	}
+
+	tsig := req.IsTsig()
+	if tsig == nil {
+		log.Info("dnsupdate: refusing unsigned update for zone %q", name)
+
+		return resp.SetRcode(req, dns.RcodeRefused)
+	} else if tsigStatus != nil {
+		log.Info("dnsupdate: refusing update for zone %q: tsig: %s", name, tsigStatus)
+
+		return resp.SetRcode(req, dns.RcodeNotAuth)
+	}
+
+	keyName := strings.ToLower(tsig.Hdr.Name)
+	if !z.keys.Has(keyName) || !strings.EqualFold(tsig.Algorithm, s.algorithms[keyName]) {
+		log.Info("dnsupdate: refusing update for zone %q by key %q", name, keyName)
+
+		return resp.SetRcode(req, dns.RcodeRefused)
+	}
+
+	return resp.SetRcode(req, s.update(z, req, keyName))
+}
@@ -0,0 +1,403 @@
+package dnsupdate
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// Common test values.
+const (
+	testKey    = "dhcp-key."
+	testOther  = "other-key."
+	testSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+	testZone   = "lan."
+)
+
+// testRewrites is a [Rewrites] implementation for tests.
+type testRewrites struct {
+	// mu protects rws.
+	mu *sync.Mutex
+
+	// rws are the current rewrites.
+	rws []*filtering.LegacyRewrite
+}
+
+// type check
+var _ Rewrites = (*testRewrites)(nil)
+
+// ModifyRewrites implements the [Rewrites] interface for *testRewrites.
+func (r *testRewrites) ModifyRewrites(
+	f func(rws []*filtering.LegacyRewrite) (res []*filtering.LegacyRewrite, changed bool, err error),
+) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res, changed, err := f(append([]*filtering.LegacyRewrite{}, r.rws...))
+	if err == nil && changed {
+		r.rws = res
+	}
+
+	return err
+}
+
+// records returns the current rewrites as "domain type answer" strings.
+func (r *testRewrites) records() (recs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rw := range r.rws {
+		recs = append(recs, rw.Domain+" "+dns.Type(rw.Type).String()+" "+rw.Answer)
+	}
+
+	return recs
+}
+
+// newTestServer returns a new *Server for the zone "lan" with the keys
+// [testKey], which may update it, and [testOther], which may not.
+func newTestServer(t *testing.T, rws ...*filtering.LegacyRewrite) (s *Server, r *testRewrites) {
+	t.Helper()
+
+	r = &testRewrites{
+		mu:  &sync.Mutex{},
+		rws: rws,
+	}
+
+	s, err := New(&Config{
+		Rewrites: r,
+		Addrs:    []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:0")},
+		Keys: []*KeyConfig{{
+			Name:   testKey,
+			Secret: testSecret,
+		}, {
+			Name:   testOther,
+			Secret: testSecret,
+		}},
+		Zones: []*ZoneConfig{{
+			Name: "lan",
+			Keys: []string{"dhcp-key"},
+		}},
+	})
+	require.NoError(t, err)
+
+	return s, r
+}
+
+// newRewrite returns a new rewrite or fails the test.
+func newRewrite(t *testing.T, domain, typ, answer string) (rw *filtering.LegacyRewrite) {
+	t.Helper()
+
+	rw, err := filtering.NewLegacyRewrite(domain, typ, answer, nil)
+	require.NoError(t, err)
+
+	return rw
+}
+
+// newUpdate returns a new UPDATE message for zone with prereqs and updates
+// signed, but without the MAC, with key, if it's not empty.
+func newUpdate(zone, key string, prereqs []dns.RR, updates []string) (m *dns.Msg) {
+	m = &dns.Msg{}
+	m.SetUpdate(zone)
+	m.Answer = prereqs
+
+	for _, s := range updates {
+		m.Ns = append(m.Ns, mustRR(s))
+	}
+
+	if key != "" {
+		m.SetTsig(key, dns.HmacSHA256, tsigFudge, time.Now().Unix())
+	}
+
+	return m
+}
+
+// newPrereq returns a new prerequisite RR without data.
+func newPrereq(name string, class, qtype uint16) (rr dns.RR) {
+	return &dns.ANY{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: qtype,
+			Class:  class,
+		},
+	}
+}
+
+// mustRR parses s as a resource record or panics.
+func mustRR(s string) (rr dns.RR) {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return rr
+}
+
+func TestServer_handle(t *testing.T) {
+	testCases := []struct {
+		req      *dns.Msg
+		name     string
+		wantRecs []string
+		wantRC   int
+	}{{
+		req: newUpdate(testZone, testKey, nil, []string{
+			"host.lan. 300 IN A 192.0.2.2",
+			"_acme-challenge.host.lan. 60 IN TXT \"token\"",
+			"_http._tcp.lan. 300 IN SRV 0 5 80 host.lan.",
+		}),
+		name: "add",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+			"host.lan A 192.0.2.2",
+			"_acme-challenge.host.lan TXT token",
+			"_http._tcp.lan SRV 0 5 80 host.lan",
+		},
+		wantRC: dns.RcodeSuccess,
+	}, {
+		req: newUpdate(testZone, testKey, nil, []string{
+			"HOST.lan. 300 IN A 192.0.2.1",
+		}),
+		name: "add_duplicate",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeSuccess,
+	}, {
+		req: newUpdate(testZone, testKey, nil, []string{
+			"host.lan. 0 NONE A 192.0.2.1",
+		}),
+		name: "delete_record",
+		wantRecs: []string{
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeSuccess,
+	}, {
+		req: newUpdate(
+			testZone,
+			testKey,
+			[]dns.RR{newPrereq("new.lan.", dns.ClassNONE, dns.TypeANY)},
+			[]string{"new.lan. 300 IN A 192.0.2.3"},
+		),
+		name: "prereq_not_in_use",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+			"new.lan A 192.0.2.3",
+		},
+		wantRC: dns.RcodeSuccess,
+	}, {
+		req: newUpdate(
+			testZone,
+			testKey,
+			[]dns.RR{newPrereq("host.lan.", dns.ClassNONE, dns.TypeANY)},
+			[]string{"host.lan. 300 IN A 192.0.2.3"},
+		),
+		name: "prereq_in_use",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeYXDomain,
+	}, {
+		req: newUpdate(
+			testZone,
+			testKey,
+			[]dns.RR{newPrereq("host.lan.", dns.ClassANY, dns.TypeAAAA)},
+			nil,
+		),
+		name: "prereq_no_rrset",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeNXRrset,
+	}, {
+		req:  newUpdate(testZone, testKey, nil, []string{"host.example. 300 IN A 192.0.2.3"}),
+		name: "not_zone",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeNotZone,
+	}, {
+		req:  newUpdate(testZone, testKey, nil, []string{"host.lan. 300 IN HINFO \"cpu\" \"os\""}),
+		name: "unsupported_type",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeRefused,
+	}, {
+		req:  newUpdate("example.", testKey, nil, nil),
+		name: "unknown_zone",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeNotAuth,
+	}, {
+		req:  newUpdate(testZone, "", nil, []string{"new.lan. 300 IN A 192.0.2.3"}),
+		name: "unsigned",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeRefused,
+	}, {
+		req:  newUpdate(testZone, testOther, nil, []string{"new.lan. 300 IN A 192.0.2.3"}),
+		name: "other_key",
+		wantRecs: []string{
+			"host.lan A 192.0.2.1",
+			"printer.lan A 192.0.2.9",
+		},
+		wantRC: dns.RcodeRefused,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, r := newTestServer(
+				t,
+				newRewrite(t, "host.lan", "", "192.0.2.1"),
+				newRewrite(t, "printer.lan", "", "192.0.2.9"),
+			)
+
+			resp := s.handle(tc.req, nil)
+			assert.Equal(t, dns.RcodeToString[tc.wantRC], dns.RcodeToString[resp.Rcode])
+			assert.Equal(t, tc.wantRecs, r.records())
+		})
+	}
+
+	t.Run("delete_rrset", func(t *testing.T) {
+		s, r := newTestServer(
+			t,
+			newRewrite(t, "host.lan", "", "192.0.2.1"),
+			newRewrite(t, "host.lan", "", "2001:db8::1"),
+			newRewrite(t, "host.lan", "", "192.0.2.2"),
+		)
+
+		req := newUpdate(testZone, testKey, nil, nil)
+		req.RemoveRRset([]dns.RR{mustRR("host.lan. 0 IN A 192.0.2.1")})
+
+		resp := s.handle(req, nil)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Equal(t, []string{"host.lan AAAA 2001:db8::1"}, r.records())
+
+		req = newUpdate(testZone, testKey, nil, nil)
+		req.RemoveName([]dns.RR{mustRR("host.lan. 0 IN A 192.0.2.1")})
+
+		resp = s.handle(req, nil)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Empty(t, r.records())
+	})
+}
+
+func TestServer_Start(t *testing.T) {
+	s, r := newTestServer(t)
+
+	err := s.Start()
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	addr := s.servers[0].PacketConn.LocalAddr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+
+	c := &dns.Client{
+		TsigSecret: map[string]string{testKey: testSecret},
+	}
+
+	req := (&dns.Msg{}).SetUpdate(testZone)
+	req.Insert([]dns.RR{mustRR("host.lan. 300 IN A 192.0.2.1")})
+	req.SetTsig(testKey, dns.HmacSHA256, tsigFudge, time.Now().Unix())
+
+	resp, _, err := c.ExchangeContext(ctx, req, addr)
+	require.NoError(t, err)
+
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.NotNil(t, resp.IsTsig())
+	assert.Equal(t, []string{"host.lan A 192.0.2.1"}, r.records())
+
+	c.TsigSecret = map[string]string{testKey: "d3Jvbmc="}
+	req.SetTsig(testKey, dns.HmacSHA256, tsigFudge, time.Now().Unix())
+	req.Insert([]dns.RR{mustRR("host.lan. 300 IN A 192.0.2.2")})
+
+	resp, _, _ = c.ExchangeContext(ctx, req, addr)
+	require.NotNil(t, resp)
+
+	assert.Equal(t, dns.RcodeNotAuth, resp.Rcode)
+	assert.Equal(t, []string{"host.lan A 192.0.2.1"}, r.records())
+
+	query := (&dns.Msg{}).SetQuestion("host.lan.", dns.TypeA)
+	resp, _, err = (&dns.Client{}).ExchangeContext(ctx, query, addr)
+	require.NoError(t, err)
+
+	assert.Equal(t, dns.RcodeNotImplemented, resp.Rcode)
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		zone       *ZoneConfig
+		name       string
+		wantErrMsg string
+	}{{
+		zone:       &ZoneConfig{Name: "lan", Keys: []string{"key"}},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		zone:       nil,
+		name:       "nil_zone",
+		wantErrMsg: "zone at index 0: no value",
+	}, {
+		zone:       &ZoneConfig{Name: "lan"},
+		name:       "no_keys",
+		wantErrMsg: `zone at index 0: zone "lan.": keys: no value`,
+	}, {
+		zone:       &ZoneConfig{Name: "lan", Keys: []string{"unknown"}},
+		name:       "unknown_key",
+		wantErrMsg: `zone at index 0: zone "lan.": unknown key "unknown"`,
+	}, {
+		zone:       &ZoneConfig{Name: ".", Keys: []string{"key"}},
+		name:       "root",
+		wantErrMsg: `zone at index 0: bad name "."`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(&Config{
+				Rewrites: &testRewrites{mu: &sync.Mutex{}},
+				Addrs:    []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:53")},
+				Keys: []*KeyConfig{{
+					Name:   "key",
+					Secret: testSecret,
+				}},
+				Zones: []*ZoneConfig{tc.zone},
+			})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+
+	_, err := New(&Config{
+		Rewrites: &testRewrites{mu: &sync.Mutex{}},
+		Addrs:    []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:53")},
+		Keys: []*KeyConfig{{
+			Name:      "key",
+			Algorithm: "hmac-md4",
+			Secret:    testSecret,
+		}},
+		Zones: []*ZoneConfig{{Name: "lan", Keys: []string{"key"}}},
+	})
+	testutil.AssertErrorMsg(t, `key at index 0: algorithm: bad value "hmac-md4"`, err)
+}
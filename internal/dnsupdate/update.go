@@ -0,0 +1,289 @@
+package dnsupdate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// updateOp is a single operation of the update section.  See RFC 2136,
+// Section 2.5.
+type updateOp struct {
+	// rw is the rewrite to add, if class is IN, or to delete, if class is
+	// NONE.  It's nil if class is ANY.
+	rw *filtering.LegacyRewrite
+
+	// domain is the lowercased owner name without the trailing dot.
+	domain string
+
+	// qtype is the type of the records to delete, if class is ANY.
+	qtype uint16
+
+	// class is the class of the operation: IN to add a record, ANY to delete
+	// an RRset or all RRsets, and NONE to delete a record.
+	class uint16
+}
+
+// update applies the update section of req to the rewrites within z, if the
+// prerequisites of req are satisfied, and returns the response code.  See RFC
+// 2136, Section 3.
+func (s *Server) update(z *zone, req *dns.Msg, keyName string) (rc int) {
+	for _, rr := range req.Answer {
+		rc = z.prescanPrereq(rr)
+		if rc != dns.RcodeSuccess {
+			return rc
+		}
+	}
+
+	prov := &filtering.RuleProvenance{
+		Time:   time.Now().UTC(),
+		Source: ProvenanceSource,
+		Author: strings.TrimSuffix(keyName, "."),
+	}
+
+	ops := make([]*updateOp, 0, len(req.Ns))
+	for _, rr := range req.Ns {
+		var op *updateOp
+		op, rc = z.prescanUpdate(rr, prov)
+		if rc != dns.RcodeSuccess {
+			return rc
+		}
+
+		ops = append(ops, op)
+	}
+
+	err := s.rewrites.ModifyRewrites(func(
+		rws []*filtering.LegacyRewrite,
+	) (res []*filtering.LegacyRewrite, changed bool, err error) {
+		for _, rr := range req.Answer {
+			rc = checkPrereq(rws, rr)
+			if rc != dns.RcodeSuccess {
+				return nil, false, nil
+			}
+		}
+
+		for _, op := range ops {
+			var opChanged bool
+			rws, opChanged = op.apply(rws)
+			changed = changed || opChanged
+		}
+
+		return rws, changed, nil
+	})
+	if err != nil {
+		log.Error("dnsupdate: zone %q: applying update: %s", z.name, err)
+
+		return dns.RcodeServerFailure
+	}
+
+	if rc == dns.RcodeSuccess && len(ops) > 0 {
+		log.Info("dnsupdate: zone %q: applied %d changes by key %q", z.name, len(ops), keyName)
+	}
+
+	return rc
+}
+
+// prescanPrereq returns the response code for the malformed prerequisite rr.
+// See RFC 2136, Section 3.2.
+func (z *zone) prescanPrereq(rr dns.RR) (rc int) {
+	hdr := rr.Header()
+	if hdr.Ttl != 0 {
+		return dns.RcodeFormatError
+	} else if !dns.IsSubDomain(z.name, strings.ToLower(hdr.Name)) {
+		return dns.RcodeNotZone
+	}
+
+	switch hdr.Class {
+	case dns.ClassANY, dns.ClassNONE:
+		if hdr.Rdlength != 0 {
+			return dns.RcodeFormatError
+		}
+
+		return dns.RcodeSuccess
+	case dns.ClassINET:
+		// The value-dependent prerequisites aren't supported.
+		return dns.RcodeNotImplemented
+	default:
+		return dns.RcodeFormatError
+	}
+}
+
+// checkPrereq returns the response code for the prerequisite rr checked
+// against rws.  rr must be prescanned.  See RFC 2136, Section 3.2.
+func checkPrereq(rws []*filtering.LegacyRewrite, rr dns.RR) (rc int) {
+	hdr := rr.Header()
+	domain := toDomain(hdr.Name)
+
+	inUse, exists := false, false
+	for _, rw := range rws {
+		if rw.Domain != domain {
+			continue
+		}
+
+		inUse = true
+		if rw.Type == hdr.Rrtype {
+			exists = true
+		}
+	}
+
+	switch {
+	case hdr.Class == dns.ClassANY && hdr.Rrtype == dns.TypeANY && !inUse:
+		return dns.RcodeNameError
+	case hdr.Class == dns.ClassANY && hdr.Rrtype != dns.TypeANY && !exists:
+		return dns.RcodeNXRrset
+	case hdr.Class == dns.ClassNONE && hdr.Rrtype == dns.TypeANY && inUse:
+		return dns.RcodeYXDomain
+	case hdr.Class == dns.ClassNONE && hdr.Rrtype != dns.TypeANY && exists:
+		return dns.RcodeYXRrset
+	default:
+		return dns.RcodeSuccess
+	}
+}
+
+// prescanUpdate returns the operation for the update rr or the response code
+// if it's malformed or unsupported.  See RFC 2136, Section 3.4.1.
+func (z *zone) prescanUpdate(
+	rr dns.RR,
+	prov *filtering.RuleProvenance,
+) (op *updateOp, rc int) {
+	hdr := rr.Header()
+	if !dns.IsSubDomain(z.name, strings.ToLower(hdr.Name)) {
+		return nil, dns.RcodeNotZone
+	}
+
+	op = &updateOp{
+		domain: toDomain(hdr.Name),
+		qtype:  hdr.Rrtype,
+		class:  hdr.Class,
+	}
+
+	switch hdr.Class {
+	case dns.ClassINET:
+		if isMetaType(hdr.Rrtype) {
+			return nil, dns.RcodeFormatError
+		}
+	case dns.ClassANY:
+		if hdr.Ttl != 0 || hdr.Rdlength != 0 {
+			return nil, dns.RcodeFormatError
+		}
+
+		return op, dns.RcodeSuccess
+	case dns.ClassNONE:
+		if hdr.Ttl != 0 || hdr.Rrtype == dns.TypeANY {
+			return nil, dns.RcodeFormatError
+		}
+	default:
+		return nil, dns.RcodeFormatError
+	}
+
+	var err error
+	op.rw, err = toRewrite(rr, prov)
+	if err != nil {
+		log.Info("dnsupdate: zone %q: refusing update: %s", z.name, err)
+
+		return nil, dns.RcodeRefused
+	}
+
+	return op, dns.RcodeSuccess
+}
+
+// isMetaType returns true if t is a meta type or a query type, which can't be
+// the type of a record.  See RFC 6895, Section 3.1.
+func isMetaType(t uint16) (ok bool) {
+	return t == dns.TypeOPT || (t >= 128 && t <= dns.TypeANY)
+}
+
+// apply applies op to rws and returns the result.  changed is true if the
+// rewrites have been changed.
+func (op *updateOp) apply(
+	rws []*filtering.LegacyRewrite,
+) (res []*filtering.LegacyRewrite, changed bool) {
+	switch op.class {
+	case dns.ClassINET:
+		for _, rw := range rws {
+			if sameRecord(rw, op.rw) {
+				return rws, false
+			}
+		}
+
+		return append(rws, op.rw), true
+	default:
+		res = rws[:0]
+		for _, rw := range rws {
+			if op.deletes(rw) {
+				changed = true
+			} else {
+				res = append(res, rw)
+			}
+		}
+
+		return res, changed
+	}
+}
+
+// deletes returns true if the deleting operation op matches rw.
+func (op *updateOp) deletes(rw *filtering.LegacyRewrite) (ok bool) {
+	if op.class == dns.ClassNONE {
+		return sameRecord(rw, op.rw)
+	}
+
+	return rw.Domain == op.domain && (op.qtype == dns.TypeANY || rw.Type == op.qtype)
+}
+
+// sameRecord returns true if a and b describe the same record.
+func sameRecord(a, b *filtering.LegacyRewrite) (ok bool) {
+	if a.Domain != b.Domain || a.Type != b.Type {
+		return false
+	} else if a.IP.IsValid() || b.IP.IsValid() {
+		return a.IP == b.IP
+	} else if a.Type == dns.TypeTXT {
+		return a.Answer == b.Answer
+	}
+
+	return strings.EqualFold(a.Answer, b.Answer)
+}
+
+// errUnsupportedType is returned by [toRewrite] for the records of the types,
+// which the rewrites don't support.
+const errUnsupportedType errors.Error = "unsupported record type"
+
+// toRewrite returns the rewrite for the record rr with the provenance prov.
+func toRewrite(rr dns.RR, prov *filtering.RuleProvenance) (rw *filtering.LegacyRewrite, err error) {
+	var answer string
+	switch v := rr.(type) {
+	case *dns.A:
+		answer = v.A.String()
+	case *dns.AAAA:
+		answer = v.AAAA.String()
+	case *dns.CNAME:
+		answer = toDomain(v.Target)
+	case *dns.MX:
+		answer = fmt.Sprintf("%d %s", v.Preference, toDomain(v.Mx))
+	case *dns.PTR:
+		answer = v.Ptr
+	case *dns.SRV:
+		answer = fmt.Sprintf("%d %d %d %s", v.Priority, v.Weight, v.Port, toDomain(v.Target))
+	case *dns.TXT:
+		answer = strings.Join(v.Txt, "")
+	default:
+		return nil, fmt.Errorf("%s: %w", dns.Type(rr.Header().Rrtype), errUnsupportedType)
+	}
+
+	typ := dns.Type(rr.Header().Rrtype).String()
+	rw, err = filtering.NewLegacyRewrite(rr.Header().Name, typ, answer, prov)
+	if err != nil {
+		return nil, fmt.Errorf("%s record: %w", typ, err)
+	}
+
+	return rw, nil
+}
+
+// toDomain returns the lowercased name without the trailing dot.
+func toDomain(name string) (domain string) {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
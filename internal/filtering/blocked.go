@@ -72,7 +72,7 @@ func (s *BlockedServices) Clone() (c *BlockedServices) {
 // must not be nil.
 func (s *BlockedServices) Validate() (err error) {
 	for _, id := range s.IDs {
-		_, ok := serviceRules[id]
+		_, ok := lookupServiceRules(id)
 		if !ok {
 			return fmt.Errorf("unknown blocked-service %q", id)
 		}
@@ -99,7 +99,7 @@ func (d *DNSFilter) ApplyBlockedServices(setts *Settings) {
 // ApplyBlockedServicesList appends filtering rules to the settings.
 func (d *DNSFilter) ApplyBlockedServicesList(setts *Settings, list []string) {
 	for _, name := range list {
-		rules, ok := serviceRules[name]
+		rules, ok := lookupServiceRules(name)
 		if !ok {
 			log.Error("unknown service name: %s", name)
 
@@ -114,14 +114,32 @@ func (d *DNSFilter) ApplyBlockedServicesList(setts *Settings, list []string) {
 }
 
 func (d *DNSFilter) handleBlockedServicesIDs(w http.ResponseWriter, r *http.Request) {
-	aghhttp.WriteJSONResponseOK(w, r, serviceIDs)
+	ids := append(slices.Clone(serviceIDs), customServiceIDs()...)
+	slices.Sort(ids)
+
+	aghhttp.WriteJSONResponseOK(w, r, ids)
 }
 
 func (d *DNSFilter) handleBlockedServicesAll(w http.ResponseWriter, r *http.Request) {
+	svcs := slices.Clone(blockedServices)
+	func() {
+		customServices.mu.RLock()
+		defer customServices.mu.RUnlock()
+
+		for _, s := range customServices.svcs {
+			svcs = append(svcs, blockedService{
+				ID:      s.ID,
+				Name:    s.Name,
+				IconSVG: []byte{},
+				Rules:   s.Rules,
+			})
+		}
+	}()
+
 	aghhttp.WriteJSONResponseOK(w, r, struct {
 		BlockedServices []blockedService `json:"blocked_services"`
 	}{
-		BlockedServices: blockedServices,
+		BlockedServices: svcs,
 	})
 }
 
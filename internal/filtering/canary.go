@@ -0,0 +1,417 @@
+package filtering
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"golang.org/x/exp/slices"
+)
+
+// CanaryConfig is the configuration of the canary rollout of the filter list
+// updates.  When enabled, an update of a filter list is first applied only to
+// the canary clients.  The other clients keep using the previous version of the
+// list until the update is promoted.
+type CanaryConfig struct {
+	// Clients are the IP addresses, CIDR subnets, and names of the persistent
+	// clients, which receive the updates first.
+	Clients []string `yaml:"clients"`
+
+	// Tags are the tags of the clients, which receive the updates first.
+	Tags []string `yaml:"tags"`
+
+	// Duration is the duration of the canary rollout of an update, after which
+	// it's promoted to all clients.  If zero, the updates are only promoted
+	// manually.
+	Duration timeutil.Duration `yaml:"duration"`
+
+	// Enabled defines if the canary rollout is used.
+	Enabled bool `yaml:"enabled"`
+}
+
+// canaryMatcher matches the canary clients.
+type canaryMatcher struct {
+	// names are the names of the persistent canary clients.
+	names *stringutil.Set
+
+	// tags are the tags of the canary clients.
+	tags *stringutil.Set
+
+	// subnets are the subnets of the canary clients.  The single IP addresses
+	// are kept as single-address prefixes.
+	subnets []netip.Prefix
+
+	// duration is the duration of the canary rollout of an update.
+	duration time.Duration
+}
+
+// newCanaryMatcher returns a new canary matcher for conf.  m is nil if conf is
+// nil or disabled.
+func newCanaryMatcher(conf *CanaryConfig) (m *canaryMatcher, err error) {
+	if conf == nil || !conf.Enabled {
+		return nil, nil
+	}
+
+	if conf.Duration.Duration < 0 {
+		return nil, fmt.Errorf("duration: must not be negative, got %s", conf.Duration)
+	}
+
+	m = &canaryMatcher{
+		names:    stringutil.NewSet(),
+		tags:     stringutil.NewSet(conf.Tags...),
+		duration: conf.Duration.Duration,
+	}
+
+	for _, c := range conf.Clients {
+		var pref netip.Prefix
+		if strings.Contains(c, "/") {
+			pref, err = netip.ParsePrefix(c)
+			if err != nil {
+				return nil, fmt.Errorf("client %q: %w", c, err)
+			}
+
+			m.subnets = append(m.subnets, pref.Masked())
+
+			continue
+		}
+
+		ip, pErr := netip.ParseAddr(c)
+		if pErr != nil {
+			m.names.Add(c)
+
+			continue
+		}
+
+		m.subnets = append(m.subnets, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+
+	if m.names.Len() == 0 && m.tags.Len() == 0 && len(m.subnets) == 0 {
+		return nil, errors.Error("no clients or tags")
+	}
+
+	return m, nil
+}
+
+// match returns true if the client described by setts is a canary one.  m may
+// be nil.
+func (m *canaryMatcher) match(setts *Settings) (ok bool) {
+	if m == nil {
+		return false
+	}
+
+	if setts.ClientName != "" && m.names.Has(setts.ClientName) {
+		return true
+	}
+
+	for _, t := range setts.ClientTags {
+		if m.tags.Has(t) {
+			return true
+		}
+	}
+
+	ip := setts.ClientIP.Unmap()
+	for _, pref := range m.subnets {
+		if pref.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canaryStats are the counters of the requests matched against the filter
+// lists during the canary rollout.
+type canaryStats struct {
+	// since is the time of the latest reset, stored as Unix nanoseconds.
+	since atomic.Int64
+
+	canaryRequests atomic.Uint64
+	canaryBlocked  atomic.Uint64
+	otherRequests  atomic.Uint64
+	otherBlocked   atomic.Uint64
+}
+
+// count counts a request from a canary client, if isCanary is true, or from
+// another one otherwise.
+func (s *canaryStats) count(isCanary, blocked bool) {
+	reqs, blockedNum := &s.otherRequests, &s.otherBlocked
+	if isCanary {
+		reqs, blockedNum = &s.canaryRequests, &s.canaryBlocked
+	}
+
+	reqs.Add(1)
+	if blocked {
+		blockedNum.Add(1)
+	}
+}
+
+// reset resets the counters.
+func (s *canaryStats) reset() {
+	s.since.Store(time.Now().UnixNano())
+	s.canaryRequests.Store(0)
+	s.canaryBlocked.Store(0)
+	s.otherRequests.Store(0)
+	s.otherBlocked.Store(0)
+}
+
+// canaryEngines are the filtering engines built from the filter lists with the
+// updates in the canary rollout.
+type canaryEngines struct {
+	storage      *filterlist.RuleStorage
+	engine       *urlfilter.DNSEngine
+	storageAllow *filterlist.RuleStorage
+	engineAllow  *urlfilter.DNSEngine
+}
+
+// newCanaryEngines returns the engines for the canary filter lists from params.
+// ce is nil if there are none.
+func newCanaryEngines(params *filtersInitializerParams) (ce *canaryEngines, err error) {
+	if params.canaryBlockFilters == nil && params.canaryAllowFilters == nil {
+		return nil, nil
+	}
+
+	ce = &canaryEngines{}
+	ce.storage, err = newRuleStorage(params.canaryBlockFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	ce.storageAllow, err = newRuleStorage(params.canaryAllowFilters)
+	if err != nil {
+		return nil, errors.WithDeferred(err, ce.storage.Close())
+	}
+
+	ce.engine = urlfilter.NewDNSEngine(ce.storage)
+	ce.engineAllow = urlfilter.NewDNSEngine(ce.storageAllow)
+
+	return ce, nil
+}
+
+// setCanaryEngines sets the canary engines from ce, which may be nil.
+// d.engineLock is expected to be locked for writing and the previous canary
+// engines are expected to be reset.
+func (d *DNSFilter) setCanaryEngines(ce *canaryEngines) {
+	if ce == nil {
+		return
+	}
+
+	d.rulesStorageCanary = ce.storage
+	d.filteringEngineCanary = ce.engine
+	d.rulesStorageCanaryAllow = ce.storageAllow
+	d.filteringEngineCanaryAllow = ce.engineAllow
+}
+
+// resetCanary closes the canary rule storages, if any.  d.engineLock is
+// expected to be locked for writing.
+func (d *DNSFilter) resetCanary() {
+	for _, rs := range []*filterlist.RuleStorage{d.rulesStorageCanary, d.rulesStorageCanaryAllow} {
+		if rs == nil {
+			continue
+		}
+
+		if err := rs.Close(); err != nil {
+			log.Error("filtering: closing canary rule storage: %s", err)
+		}
+	}
+
+	d.rulesStorageCanary, d.filteringEngineCanary = nil, nil
+	d.rulesStorageCanaryAllow, d.filteringEngineCanaryAllow = nil, nil
+}
+
+// canaryPath returns the path to the file with the update of the filter list in
+// the canary rollout.
+func (filter *FilterYAML) canaryPath(dataDir string) (p string) {
+	return filepath.Join(dataDir, filterDir, strconv.FormatInt(filter.ID, 10)+".canary.txt")
+}
+
+// isCanary returns true if the update of flt should be rolled out to the canary
+// clients first.  flt should be a previously loaded filter list.  The updates
+// of the monitor-only lists are applied at once, since they don't affect the
+// responses.
+func (d *DNSFilter) isCanary(flt *FilterYAML) (ok bool) {
+	return d.canary != nil && flt.checksum != 0 && !flt.MonitorOnly
+}
+
+// loadCanary restores the state of the canary rollout of the update of flt, if
+// there is one, and returns the path to the file with the latest contents of
+// flt.  If the canary rollout has been disabled, the update is promoted.
+func (d *DNSFilter) loadCanary(flt *FilterYAML) (fileName string, err error) {
+	fileName = flt.Path(d.conf.DataDir)
+	canaryPath := flt.canaryPath(d.conf.DataDir)
+
+	st, err := os.Stat(canaryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fileName, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if d.canary == nil {
+		log.Info("filtering: canary rollout is disabled, promoting update of filter %d", flt.ID)
+
+		return fileName, os.Rename(canaryPath, fileName)
+	}
+
+	flt.canarySince = st.ModTime()
+
+	return canaryPath, nil
+}
+
+// canaryFilters returns the filter lists for the canary engines, replacing the
+// ones with the updates in the canary rollout.  ok is false if there are no
+// such updates.  d.conf.filtersMu is expected to be locked.
+func (d *DNSFilter) canaryFilters(filters []Filter, lists []FilterYAML) (res []Filter, ok bool) {
+	res = slices.Clone(filters)
+	for _, flt := range lists {
+		if !flt.Enabled || flt.canarySince.IsZero() {
+			continue
+		}
+
+		i := slices.IndexFunc(res, func(f Filter) (found bool) { return f.ID == flt.ID })
+		if i != -1 {
+			res[i].FilePath = flt.canaryPath(d.conf.DataDir)
+			ok = true
+		}
+	}
+
+	return res, ok
+}
+
+// canaryPromoteTime returns the time, when the update of flt in the canary
+// rollout should be promoted.  ok is false if the update shouldn't be promoted
+// automatically.
+func (d *DNSFilter) canaryPromoteTime(flt *FilterYAML) (t time.Time, ok bool) {
+	if d.canary == nil || d.canary.duration == 0 || flt.canarySince.IsZero() {
+		return time.Time{}, false
+	}
+
+	return flt.canarySince.Add(d.canary.duration), true
+}
+
+// canaryPromoteIvl returns the time until the next automatic promotion of an
+// update in the canary rollout.  ok is false if there are none.
+func (d *DNSFilter) canaryPromoteIvl() (ivl time.Duration, ok bool) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	now := time.Now()
+	for _, filters := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+		for i := range filters {
+			t, promote := d.canaryPromoteTime(&filters[i])
+			if !promote {
+				continue
+			}
+
+			fltIvl := t.Sub(now)
+			if !ok || fltIvl < ivl {
+				ivl, ok = fltIvl, true
+			}
+		}
+	}
+
+	return ivl, ok
+}
+
+// promoteExpiredCanaries promotes the updates in the canary rollout, which
+// have been rolled out for the configured duration.
+func (d *DNSFilter) promoteExpiredCanaries() {
+	var ids []int64
+	func() {
+		d.conf.filtersMu.RLock()
+		defer d.conf.filtersMu.RUnlock()
+
+		now := time.Now()
+		for _, filters := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+			for i := range filters {
+				flt := &filters[i]
+				if t, ok := d.canaryPromoteTime(flt); ok && !now.Before(t) {
+					ids = append(ids, flt.ID)
+				}
+			}
+		}
+	}()
+
+	for _, id := range ids {
+		err := d.finishCanary(id, true)
+		if err != nil {
+			log.Error("filtering: promoting canary update of filter %d: %s", id, err)
+		}
+	}
+}
+
+// errNoCanary is returned when there is no update in the canary rollout for a
+// filter list.
+const errNoCanary errors.Error = "no update in canary rollout"
+
+// finishCanary finishes the canary rollout of the update of the filter list
+// with id.  If promote is true, the update is applied to all clients, otherwise
+// it's discarded.  The same contents of the filter list aren't rolled out again
+// until the list changes.
+func (d *DNSFilter) finishCanary(id int64, promote bool) (err error) {
+	d.refreshLock.Lock()
+	defer d.refreshLock.Unlock()
+
+	err = func() (err error) {
+		d.conf.filtersMu.Lock()
+		defer d.conf.filtersMu.Unlock()
+
+		flt := d.filterByIDLocked(id)
+		if flt == nil {
+			return errFilterNotExist
+		} else if flt.canarySince.IsZero() {
+			return errNoCanary
+		}
+
+		canaryPath := flt.canaryPath(d.conf.DataDir)
+		if promote {
+			err = os.Rename(canaryPath, flt.Path(d.conf.DataDir))
+		} else {
+			err = os.Remove(canaryPath)
+		}
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		flt.canarySince = time.Time{}
+
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	if promote {
+		log.Info("filtering: promoted canary update of filter %d", id)
+	} else {
+		log.Info("filtering: rolled back canary update of filter %d", id)
+	}
+
+	d.EnableFilters(true)
+
+	return nil
+}
+
+// filterByIDLocked returns the filter list with id, if any.  d.conf.filtersMu
+// is expected to be locked.
+func (d *DNSFilter) filterByIDLocked(id int64) (flt *FilterYAML) {
+	for _, filters := range []*[]FilterYAML{&d.conf.Filters, &d.conf.WhitelistFilters} {
+		i := slices.IndexFunc(*filters, func(f FilterYAML) (ok bool) { return f.ID == id })
+		if i != -1 {
+			return &(*filters)[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCanaryMatcher(t *testing.T) {
+	m, err := newCanaryMatcher(&CanaryConfig{
+		Clients: []string{"192.0.2.1", "198.51.100.0/24", "kids-tablet"},
+		Tags:    []string{"user_child"},
+		Enabled: true,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		setts *Settings
+		name  string
+		want  assert.BoolAssertionFunc
+	}{{
+		setts: &Settings{ClientIP: netip.MustParseAddr("192.0.2.1")},
+		name:  "ip",
+		want:  assert.True,
+	}, {
+		setts: &Settings{ClientIP: netip.MustParseAddr("::ffff:198.51.100.7")},
+		name:  "subnet",
+		want:  assert.True,
+	}, {
+		setts: &Settings{ClientName: "kids-tablet"},
+		name:  "name",
+		want:  assert.True,
+	}, {
+		setts: &Settings{ClientTags: []string{"device_pc", "user_child"}},
+		name:  "tag",
+		want:  assert.True,
+	}, {
+		setts: &Settings{
+			ClientName: "laptop",
+			ClientIP:   netip.MustParseAddr("192.0.2.2"),
+			ClientTags: []string{"device_pc"},
+		},
+		name: "other",
+		want: assert.False,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.want(t, m.match(tc.setts))
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		m, err = newCanaryMatcher(&CanaryConfig{Clients: []string{"192.0.2.1"}})
+		require.NoError(t, err)
+
+		assert.Nil(t, m)
+		assert.False(t, m.match(&Settings{ClientIP: netip.MustParseAddr("192.0.2.1")}))
+	})
+
+	t.Run("no_clients", func(t *testing.T) {
+		_, err = newCanaryMatcher(&CanaryConfig{Enabled: true})
+		testutil.AssertErrorMsg(t, "no clients or tags", err)
+	})
+
+	t.Run("bad_duration", func(t *testing.T) {
+		_, err = newCanaryMatcher(&CanaryConfig{
+			Clients:  []string{"192.0.2.1"},
+			Duration: timeutil.Duration{Duration: -time.Hour},
+			Enabled:  true,
+		})
+		testutil.AssertErrorMsg(t, "duration: must not be negative, got -1h", err)
+	})
+}
+
+func TestDNSFilter_canary(t *testing.T) {
+	content := &atomic.Pointer[string]{}
+	content.Store(ptr("||old.example^\n"))
+
+	fltURL := serveHTTPLocally(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(*content.Load()))
+	}))
+
+	d, err := New(&Config{
+		DataDir: t.TempDir(),
+		HTTPClient: &http.Client{
+			Timeout: testTimeout,
+		},
+		Canary: &CanaryConfig{
+			Clients: []string{"192.0.2.1"},
+			Enabled: true,
+		},
+		Filters: []FilterYAML{{
+			Enabled: true,
+			URL:     fltURL,
+			Filter:  Filter{ID: 1},
+		}},
+	}, nil)
+	require.NoError(t, err)
+	t.Cleanup(d.Close)
+
+	// Don't start the initializer goroutine, apply the pending filters
+	// synchronously instead, see applyFilters.
+	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
+	applyFilters := func(t *testing.T) {
+		t.Helper()
+
+		params := <-d.filtersInitializerChan
+		require.NoError(t, d.initFiltering(&params))
+	}
+
+	flt := &d.conf.Filters[0]
+	setts := func(ip string) (s *Settings) {
+		return &Settings{
+			ClientIP:          netip.MustParseAddr(ip),
+			FilteringEnabled:  true,
+			ProtectionEnabled: true,
+		}
+	}
+
+	canarySetts, otherSetts := setts("192.0.2.1"), setts("192.0.2.2")
+
+	assertBlocked := func(t *testing.T, s *Settings, blocked, allowed string) {
+		t.Helper()
+
+		res, cErr := d.CheckHost(blocked, dns.TypeA, s)
+		require.NoError(t, cErr)
+		assert.True(t, res.IsFiltered)
+
+		res, cErr = d.CheckHost(allowed, dns.TypeA, s)
+		require.NoError(t, cErr)
+		assert.False(t, res.IsFiltered)
+	}
+
+	ok, err := d.update(flt)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	assert.True(t, flt.canarySince.IsZero())
+	assert.NoFileExists(t, flt.canaryPath(d.conf.DataDir))
+
+	content.Store(ptr("||new.example^\n"))
+	ok, err = d.update(flt)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.False(t, flt.canarySince.IsZero())
+	require.FileExists(t, flt.canaryPath(d.conf.DataDir))
+
+	d.EnableFilters(false)
+
+	assertBlocked(t, canarySetts, "new.example", "old.example")
+	assertBlocked(t, otherSetts, "old.example", "new.example")
+
+	t.Run("status", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		d.handleCanaryStatus(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &canaryStatusResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+
+		assert.True(t, resp.Enabled)
+		require.Len(t, resp.Lists, 1)
+
+		assert.Equal(t, int64(1), resp.Lists[0].ID)
+		assert.Empty(t, resp.Lists[0].PromoteAt)
+		assert.Equal(t, newCanaryGroupStatsJSON(2, 1), resp.Stats.Canary)
+		assert.Equal(t, newCanaryGroupStatsJSON(2, 1), resp.Stats.Others)
+	})
+
+	finish := func(t *testing.T, h http.HandlerFunc, id int64) (w *httptest.ResponseRecorder) {
+		t.Helper()
+
+		body, mErr := json.Marshal(&canaryFinishReq{ID: id})
+		require.NoError(t, mErr)
+
+		w = httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+		return w
+	}
+
+	t.Run("promote", func(t *testing.T) {
+		w := finish(t, d.handleCanaryPromote, flt.ID)
+		require.Equal(t, http.StatusOK, w.Code)
+		applyFilters(t)
+
+		assert.True(t, flt.canarySince.IsZero())
+		assert.NoFileExists(t, flt.canaryPath(d.conf.DataDir))
+
+		assertBlocked(t, otherSetts, "new.example", "old.example")
+
+		w = finish(t, d.handleCanaryPromote, flt.ID)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "filter 1: no update in canary rollout\n", w.Body.String())
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		content.Store(ptr("||bad.example^\n"))
+		ok, err = d.update(flt)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		w := finish(t, d.handleCanaryRollback, flt.ID)
+		require.Equal(t, http.StatusOK, w.Code)
+		applyFilters(t)
+
+		assert.NoFileExists(t, flt.canaryPath(d.conf.DataDir))
+		assertBlocked(t, canarySetts, "new.example", "bad.example")
+
+		// The rolled back contents aren't rolled out again.
+		ok, err = d.update(flt)
+		require.NoError(t, err)
+
+		assert.False(t, ok)
+		assert.True(t, flt.canarySince.IsZero())
+	})
+}
+
+// ptr returns a pointer to s.
+func ptr(s string) (p *string) {
+	return &s
+}
@@ -0,0 +1,177 @@
+package filtering
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// canaryListJSON is the JSON structure for a filter list with an update in the
+// canary rollout.
+type canaryListJSON struct {
+	// Since is the time, when the update has been rolled out to the canary
+	// clients, in the RFC 3339 format.
+	Since string `json:"since"`
+
+	// PromoteAt is the time, when the update is going to be promoted to all
+	// clients, in the RFC 3339 format.  It's empty if the update is only
+	// promoted manually.
+	PromoteAt string `json:"promote_at,omitempty"`
+
+	// Name is the name of the filter list.
+	Name string `json:"name"`
+
+	// URL is the URL or the file path of the filter list.
+	URL string `json:"url"`
+
+	// ID is the ID of the filter list.
+	ID int64 `json:"id"`
+
+	// Whitelist is true if the filter list is an allowlist.
+	Whitelist bool `json:"whitelist"`
+}
+
+// canaryGroupStatsJSON is the JSON structure for the block rate of a group of
+// clients.
+type canaryGroupStatsJSON struct {
+	// Requests is the number of requests matched against the filter lists.
+	Requests uint64 `json:"requests"`
+
+	// Blocked is the number of blocked requests.
+	Blocked uint64 `json:"blocked"`
+
+	// BlockRate is the ratio of Blocked to Requests.
+	BlockRate float64 `json:"block_rate"`
+}
+
+// newCanaryGroupStatsJSON returns the block rate statistics for the counters.
+func newCanaryGroupStatsJSON(requests, blocked uint64) (s *canaryGroupStatsJSON) {
+	s = &canaryGroupStatsJSON{
+		Requests: requests,
+		Blocked:  blocked,
+	}
+
+	if requests > 0 {
+		s.BlockRate = float64(blocked) / float64(requests)
+	}
+
+	return s
+}
+
+// canaryStatsJSON is the JSON structure for the comparative block rates of the
+// canary clients and the others.
+type canaryStatsJSON struct {
+	// Canary are the statistics of the canary clients.
+	Canary *canaryGroupStatsJSON `json:"canary"`
+
+	// Others are the statistics of the other clients.
+	Others *canaryGroupStatsJSON `json:"others"`
+
+	// Since is the time, since which the requests are counted, in the RFC 3339
+	// format.  It's empty if there have been no updates in the canary rollout.
+	Since string `json:"since,omitempty"`
+}
+
+// canaryStatusResp is the JSON structure for the status of the canary rollout.
+type canaryStatusResp struct {
+	// Stats are the comparative block rates.
+	Stats *canaryStatsJSON `json:"stats"`
+
+	// Lists are the filter lists with the updates in the canary rollout.
+	Lists []*canaryListJSON `json:"lists"`
+
+	// Enabled is true if the canary rollout is enabled.
+	Enabled bool `json:"enabled"`
+}
+
+// handleCanaryStatus is the handler for the GET /control/filtering/canary HTTP
+// API.
+func (d *DNSFilter) handleCanaryStatus(w http.ResponseWriter, r *http.Request) {
+	resp := &canaryStatusResp{
+		Lists:   []*canaryListJSON{},
+		Enabled: d.canary != nil,
+	}
+
+	func() {
+		d.conf.filtersMu.RLock()
+		defer d.conf.filtersMu.RUnlock()
+
+		for j, filters := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+			for i := range filters {
+				flt := &filters[i]
+				if flt.canarySince.IsZero() {
+					continue
+				}
+
+				l := &canaryListJSON{
+					Since:     flt.canarySince.Format(time.RFC3339),
+					Name:      flt.Name,
+					URL:       flt.URL,
+					ID:        flt.ID,
+					Whitelist: j == 1,
+				}
+
+				if t, ok := d.canaryPromoteTime(flt); ok {
+					l.PromoteAt = t.Format(time.RFC3339)
+				}
+
+				resp.Lists = append(resp.Lists, l)
+			}
+		}
+	}()
+
+	s := d.canaryStats
+	resp.Stats = &canaryStatsJSON{
+		Canary: newCanaryGroupStatsJSON(s.canaryRequests.Load(), s.canaryBlocked.Load()),
+		Others: newCanaryGroupStatsJSON(s.otherRequests.Load(), s.otherBlocked.Load()),
+	}
+
+	if since := s.since.Load(); since != 0 {
+		resp.Stats.Since = time.Unix(0, since).Format(time.RFC3339)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// canaryFinishReq is the JSON structure for the request to promote or to roll
+// back the update of a filter list in the canary rollout.
+type canaryFinishReq struct {
+	// ID is the ID of the filter list.
+	ID int64 `json:"id"`
+}
+
+// handleCanaryFinish handles the requests to promote, if promote is true, or
+// to roll back the update of a filter list in the canary rollout.
+func (d *DNSFilter) handleCanaryFinish(w http.ResponseWriter, r *http.Request, promote bool) {
+	req := &canaryFinishReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = d.finishCanary(req.ID, promote)
+	if errors.Is(err, errFilterNotExist) || errors.Is(err, errNoCanary) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "filter %d: %s", req.ID, err)
+
+		return
+	} else if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "filter %d: %s", req.ID, err)
+	}
+}
+
+// handleCanaryPromote is the handler for the POST
+// /control/filtering/canary/promote HTTP API.
+func (d *DNSFilter) handleCanaryPromote(w http.ResponseWriter, r *http.Request) {
+	d.handleCanaryFinish(w, r, true)
+}
+
+// handleCanaryRollback is the handler for the POST
+// /control/filtering/canary/rollback HTTP API.
+func (d *DNSFilter) handleCanaryRollback(w http.ResponseWriter, r *http.Request) {
+	d.handleCanaryFinish(w, r, false)
+}
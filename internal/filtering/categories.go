@@ -0,0 +1,390 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// CategoriesConfig is the configuration of the domain categorization
+// database.  The requests are tagged with the categories of the requested
+// hosts, and the hosts from the blocked categories are blocked.
+type CategoriesConfig struct {
+	// URL is either the HTTP(S) URL of the database or the absolute path to a
+	// local file.  Each line of the database contains a hostname followed by
+	// one or more of its categories, separated by spaces or commas.  The
+	// subdomains of the hostname have the same categories.  Lines starting
+	// with "#" are comments.  The remote database is updated with the same
+	// interval as the filter lists.
+	URL string `yaml:"url"`
+
+	// Blocked are the names of the categories, the hosts from which are
+	// blocked for the clients, which don't have their own blocked categories,
+	// for example "gambling" or "adult".
+	Blocked []string `yaml:"blocked"`
+
+	// Enabled defines if the database is used.
+	Enabled bool `yaml:"enabled"`
+}
+
+// isLocal returns true if the database is a local file.
+func (c *CategoriesConfig) isLocal() (ok bool) {
+	return filepath.IsAbs(c.URL)
+}
+
+// path returns the path to the file with the contents of the database.  For
+// remote databases, it's the file with the downloaded contents in dataDir.
+func (c *CategoriesConfig) path(dataDir string) (p string) {
+	if c.isLocal() {
+		return c.URL
+	}
+
+	name := fmt.Sprintf("categories_%08x.txt", crc32.ChecksumIEEE([]byte(c.URL)))
+
+	return filepath.Join(dataDir, filterDir, name)
+}
+
+// ValidateCategories returns an error if any of the category names is invalid
+// or duplicated.
+func ValidateCategories(cats []string) (err error) {
+	seen := make(map[string]struct{}, len(cats))
+	for i, cat := range cats {
+		err = validateCategory(cat)
+		if err != nil {
+			return fmt.Errorf("category at index %d: %w", i, err)
+		}
+
+		if _, ok := seen[cat]; ok {
+			return fmt.Errorf("category at index %d: duplicate category %q", i, cat)
+		}
+
+		seen[cat] = struct{}{}
+	}
+
+	return nil
+}
+
+// validateCategory returns an error if cat isn't a valid category name.  A
+// valid name is a non-empty string of lowercase ASCII letters, digits, '-',
+// and '_'.
+func validateCategory(cat string) (err error) {
+	if cat == "" {
+		return errors.Error("empty category")
+	}
+
+	for _, r := range cat {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return fmt.Errorf("bad category %q: bad rune %q", cat, r)
+		}
+	}
+
+	return nil
+}
+
+// maxCategoriesSize is the maximum size of a downloaded categorization
+// database.
+const maxCategoriesSize = 256 * 1024 * 1024
+
+// categoryMatcher matches the hosts against the loaded categorization
+// database.
+type categoryMatcher struct {
+	// hosts maps the hostnames to their sorted categories.
+	hosts map[string][]string
+
+	// counts maps the categories to the numbers of the hostnames in them.
+	counts map[string]int
+
+	// updated is the time of the last modification of the database.
+	updated time.Time
+}
+
+// newCategoryMatcher returns a new matcher for the database in b.  invalid is
+// the number of skipped lines.
+func newCategoryMatcher(b []byte) (m *categoryMatcher, invalid int) {
+	m = &categoryMatcher{
+		hosts:  map[string][]string{},
+		counts: map[string]int{},
+	}
+
+	// interned is used to share the category names and their sets between
+	// the hostnames.
+	interned := map[string][]string{}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		host, cats, err := parseCategoriesLine(line)
+		if err != nil {
+			invalid++
+
+			continue
+		}
+
+		cats = mergeCategories(m.hosts[host], cats)
+		key := strings.Join(cats, ",")
+		if shared, ok := interned[key]; ok {
+			cats = shared
+		} else {
+			interned[key] = cats
+		}
+
+		m.hosts[host] = cats
+	}
+
+	for _, cats := range m.hosts {
+		for _, cat := range cats {
+			m.counts[cat]++
+		}
+	}
+
+	return m, invalid
+}
+
+// parseCategoriesLine parses the hostname and its categories from a non-empty
+// line of the database.
+func parseCategoriesLine(line string) (host string, cats []string, err error) {
+	fields := strings.FieldsFunc(line, func(r rune) (ok bool) {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	if len(fields) < 2 {
+		return "", nil, errors.Error("no categories")
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(fields[0], "."))
+	err = netutil.ValidateHostname(host)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return "", nil, err
+	}
+
+	cats = fields[1:]
+	for i, cat := range cats {
+		cat = strings.ToLower(cat)
+		err = validateCategory(cat)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return "", nil, err
+		}
+
+		cats[i] = cat
+	}
+
+	slices.Sort(cats)
+
+	return host, slices.Compact(cats), nil
+}
+
+// mergeCategories returns the sorted union of the sorted categories a and b.
+// It doesn't modify a and b.
+func mergeCategories(a, b []string) (res []string) {
+	if len(a) == 0 {
+		return b
+	} else if len(b) == 0 {
+		return a
+	}
+
+	res = append(slices.Clone(a), b...)
+	slices.Sort(res)
+
+	return slices.Compact(res)
+}
+
+// match returns the sorted categories of host and its parent domains.  cats
+// must not be modified.
+func (m *categoryMatcher) match(host string) (cats []string) {
+	for h := host; h != ""; {
+		cats = mergeCategories(cats, m.hosts[h])
+
+		_, h, _ = strings.Cut(h, ".")
+	}
+
+	return cats
+}
+
+// Categories returns the categories of host, which must be normalized, from
+// the categorization database.  cats is nil if the database isn't loaded.  cats
+// must not be modified.
+func (d *DNSFilter) Categories(host string) (cats []string) {
+	m := d.categoryMatcher.Load()
+	if m == nil {
+		return nil
+	}
+
+	return m.match(host)
+}
+
+// checkCategories blocks host if it belongs to one of the blocked categories
+// from setts.  err is always nil.
+func (d *DNSFilter) checkCategories(
+	host string,
+	_ uint16,
+	setts *Settings,
+) (res Result, err error) {
+	if !setts.ProtectionEnabled || len(setts.BlockedCategories) == 0 {
+		return Result{}, nil
+	}
+
+	cats := d.Categories(host)
+	for _, cat := range cats {
+		if !slices.Contains(setts.BlockedCategories, cat) {
+			continue
+		}
+
+		log.Debug("filtering: host %q is in blocked category %q", host, cat)
+
+		return Result{
+			Rules: []*ResultRule{{
+				Text:         cat,
+				FilterListID: CategoriesListID,
+			}},
+			Categories: cats,
+			Reason:     FilteredCategory,
+			IsFiltered: true,
+		}, nil
+	}
+
+	return Result{}, nil
+}
+
+// loadCategories loads the categorization database from the filesystem and
+// replaces the current matcher.
+func (d *DNSFilter) loadCategories() {
+	d.confMu.RLock()
+	conf := d.conf.Categories
+	d.confMu.RUnlock()
+
+	if conf == nil || !conf.Enabled || conf.URL == "" {
+		d.categoryMatcher.Store(nil)
+
+		return
+	}
+
+	p := conf.path(d.conf.DataDir)
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		// The database hasn't been downloaded yet.
+		d.categoryMatcher.Store(nil)
+
+		return
+	} else if err != nil {
+		log.Error("filtering: categories: %s", err)
+
+		return
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		log.Error("filtering: categories: reading: %s", err)
+
+		return
+	}
+
+	m, invalid := newCategoryMatcher(b)
+	m.updated = fi.ModTime()
+
+	log.Debug("filtering: categories: %d hosts, %d invalid lines", len(m.hosts), invalid)
+
+	d.categoryMatcher.Store(m)
+}
+
+// refreshCategories downloads the remote categorization database, if it
+// either hasn't been downloaded yet or is due for an update, and reloads it if
+// it has been updated.  The local database is reloaded with the update
+// interval of the filter lists.
+func (d *DNSFilter) refreshCategories() {
+	d.categoriesMu.Lock()
+	defer d.categoriesMu.Unlock()
+
+	d.confMu.RLock()
+	var conf CategoriesConfig
+	if d.conf.Categories != nil {
+		conf = *d.conf.Categories
+	}
+	d.confMu.RUnlock()
+
+	if !conf.Enabled || conf.URL == "" {
+		return
+	}
+
+	ivl := time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour
+	updated := false
+	if !conf.isLocal() {
+		p := conf.path(d.conf.DataDir)
+		fi, err := os.Stat(p)
+		if err != nil || (ivl != 0 && time.Since(fi.ModTime()) >= ivl) {
+			err = d.downloadCategories(conf.URL, p)
+			if err != nil {
+				log.Error("filtering: categories: downloading: %s", err)
+			} else {
+				updated = true
+			}
+		}
+	}
+
+	if ivl != 0 && time.Since(d.categoriesUpdated) >= ivl {
+		updated = true
+	}
+
+	if updated || d.categoryMatcher.Load() == nil {
+		d.categoriesUpdated = time.Now()
+		d.loadCategories()
+	}
+}
+
+// downloadCategories downloads the categorization database from u into the
+// file at p.
+func (d *DNSFilter) downloadCategories(u, p string) (err error) {
+	log.Debug("filtering: downloading categories from %q", u)
+
+	r, err := d.readerFromURL(u)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, r.Close()) }()
+
+	f, err := aghrenameio.NewPendingFile(p, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	_, err = io.Copy(f, io.LimitReader(r, maxCategoriesSize))
+	if err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+
+	return nil
+}
+
+// categoryCounts returns the sorted names of the categories from the loaded
+// database with the numbers of their hostnames as well as the time of the last
+// update of the database.
+func (d *DNSFilter) categoryCounts() (names []string, counts map[string]int, updated time.Time) {
+	m := d.categoryMatcher.Load()
+	if m == nil {
+		return nil, nil, time.Time{}
+	}
+
+	names = maps.Keys(m.counts)
+	slices.Sort(names)
+
+	return names, m.counts, m.updated
+}
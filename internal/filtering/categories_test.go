@@ -0,0 +1,140 @@
+package filtering
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_checkCategories(t *testing.T) {
+	const data = `# Categories.
+casino.example gambling
+ads.example advertising,tracking
+sub.ads.example	Malware
+social.example social adult
+bad!host gambling
+nocategories.example
+`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	dataDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dataDir, filterDir), 0o755)
+	require.NoError(t, err)
+
+	d, setts := newForTest(t, &Config{
+		DataDir:    dataDir,
+		HTTPClient: srv.Client(),
+		Categories: &CategoriesConfig{
+			URL:     srv.URL,
+			Blocked: []string{"gambling", "malware"},
+			Enabled: true,
+		},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	// The database isn't downloaded yet.
+	assert.Nil(t, d.Categories("casino.example"))
+
+	d.refreshCategories()
+
+	setts.BlockedCategories = d.Settings().BlockedCategories
+
+	testCases := []struct {
+		name     string
+		host     string
+		wantRule string
+		wantCats []string
+	}{{
+		name:     "blocked",
+		host:     "casino.example",
+		wantRule: "gambling",
+		wantCats: []string{"gambling"},
+	}, {
+		name:     "blocked_subdomain",
+		host:     "www.sub.ads.example",
+		wantRule: "malware",
+		wantCats: []string{"advertising", "malware", "tracking"},
+	}, {
+		name:     "tagged",
+		host:     "ads.example",
+		wantRule: "",
+		wantCats: []string{"advertising", "tracking"},
+	}, {
+		name:     "not_found",
+		host:     "other.example",
+		wantRule: "",
+		wantCats: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cErr := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantCats, res.Categories)
+
+			if tc.wantRule == "" {
+				assert.False(t, res.IsFiltered)
+
+				return
+			}
+
+			assert.True(t, res.IsFiltered)
+			assert.Equal(t, FilteredCategory, res.Reason)
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+			assert.Equal(t, int64(CategoriesListID), res.Rules[0].FilterListID)
+		})
+	}
+
+	names, counts, _ := d.categoryCounts()
+	assert.Equal(t, []string{"adult", "advertising", "gambling", "malware", "social", "tracking"}, names)
+	assert.Equal(t, 1, counts["advertising"])
+}
+
+func TestValidateCategories(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		cats       []string
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		cats:       []string{"adult", "social-media", "ads_2"},
+	}, {
+		name:       "empty",
+		wantErrMsg: "category at index 1: empty category",
+		cats:       []string{"adult", ""},
+	}, {
+		name:       "uppercase",
+		wantErrMsg: `category at index 0: bad category "Adult": bad rune 'A'`,
+		cats:       []string{"Adult"},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `category at index 1: duplicate category "adult"`,
+		cats:       []string{"adult", "adult"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateCategories(tc.cats)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErrMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// categoriesConfigJSON is the JSON structure for the configuration of the
+// domain categorization database.
+type categoriesConfigJSON struct {
+	// URL is either the HTTP(S) URL of the database or the absolute path to a
+	// local file.
+	URL string `json:"url"`
+
+	// Blocked are the names of the globally blocked categories.
+	Blocked []string `json:"blocked"`
+
+	// Enabled defines if the database is used.
+	Enabled bool `json:"enabled"`
+}
+
+// categoryJSON is the JSON structure for a category from the loaded database.
+type categoryJSON struct {
+	// Name is the name of the category.
+	Name string `json:"name"`
+
+	// Hosts is the number of the hostnames in the category.
+	Hosts int `json:"hosts"`
+}
+
+// categoriesStatusResp is the JSON structure for the status of the domain
+// categorization.
+type categoriesStatusResp struct {
+	categoriesConfigJSON
+
+	// Updated is the time of the last update of the loaded database in the RFC
+	// 3339 format.  It's empty if the database isn't loaded.
+	Updated string `json:"updated,omitempty"`
+
+	// Categories are the categories from the loaded database sorted by name.
+	Categories []*categoryJSON `json:"categories"`
+}
+
+// handleCategoriesStatus is the handler for the GET
+// /control/filtering/categories HTTP API.
+func (d *DNSFilter) handleCategoriesStatus(w http.ResponseWriter, r *http.Request) {
+	resp := &categoriesStatusResp{
+		categoriesConfigJSON: categoriesConfigJSON{
+			Blocked: []string{},
+		},
+		Categories: []*categoryJSON{},
+	}
+
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		if c := d.conf.Categories; c != nil {
+			resp.URL = c.URL
+			resp.Enabled = c.Enabled
+			resp.Blocked = append(resp.Blocked, c.Blocked...)
+		}
+	}()
+
+	names, counts, updated := d.categoryCounts()
+	for _, name := range names {
+		resp.Categories = append(resp.Categories, &categoryJSON{
+			Name:  name,
+			Hosts: counts[name],
+		})
+	}
+
+	if !updated.IsZero() {
+		resp.Updated = updated.Format(time.RFC3339)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// validate returns an error if the configuration is invalid.
+func (j *categoriesConfigJSON) validate() (err error) {
+	if j.Enabled && j.URL == "" {
+		return errors.Error("url: must not be empty")
+	}
+
+	if j.URL != "" && !filepath.IsAbs(j.URL) {
+		var u *url.URL
+		u, err = url.ParseRequestURI(j.URL)
+		if err != nil {
+			return fmt.Errorf("url: %w", err)
+		}
+
+		if u.Scheme != aghhttp.SchemeHTTP && u.Scheme != aghhttp.SchemeHTTPS {
+			return fmt.Errorf("url: bad scheme %q", u.Scheme)
+		}
+	}
+
+	err = ValidateCategories(j.Blocked)
+	if err != nil {
+		return fmt.Errorf("blocked: %w", err)
+	}
+
+	return nil
+}
+
+// handleCategoriesConfig is the handler for the PUT
+// /control/filtering/categories/config HTTP API.
+func (d *DNSFilter) handleCategoriesConfig(w http.ResponseWriter, r *http.Request) {
+	req := &categoriesConfigJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = req.validate()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "validating: %s", err)
+
+		return
+	}
+
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		d.conf.Categories = &CategoriesConfig{
+			URL:     req.URL,
+			Blocked: slices.Clone(req.Blocked),
+			Enabled: req.Enabled,
+		}
+	}()
+
+	log.Debug("filtering: categories: set url %q, blocked %q", req.URL, req.Blocked)
+
+	d.conf.ConfigModified()
+
+	d.loadCategories()
+	go d.refreshCategories()
+}
@@ -0,0 +1,204 @@
+package filtering
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// compiledDir is the subdirectory of [filterDir] to store the compiled filter
+// lists, see [Config.LazyLoading].
+const compiledDir = "compiled"
+
+// compiledPath returns the path to the compiled form of the filter list with
+// id and the rules checksum.
+func compiledPath(dataDir string, id int64, checksum uint32) (p string) {
+	return filepath.Join(dataDir, filterDir, compiledDir, fmt.Sprintf("%d-%08x.txt", id, checksum))
+}
+
+// FiltersCompiled returns true if the compiled forms of all the enabled filter
+// lists are cached, so that the filter lists are loaded without parsing their
+// comments and the rules not used for DNS filtering.
+func (d *DNSFilter) FiltersCompiled() (ok bool) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	for _, lists := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+		for _, flt := range lists {
+			checksum := flt.compiledChecksum()
+			if !flt.Enabled || checksum == 0 {
+				continue
+			}
+
+			_, err := os.Stat(compiledPath(d.conf.DataDir, flt.ID, checksum))
+			if err != nil {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// compileFilters replaces the file paths of the filter lists in params with
+// the ones of their compiled forms, compiling the lists that aren't cached yet,
+// and removes the compiled forms of the lists that are no longer used.  The
+// lists that can't be compiled are used as is.
+func (d *DNSFilter) compileFilters(params *filtersInitializerParams) {
+	used := map[string]struct{}{}
+	for _, filters := range [][]Filter{
+		params.blockFilters,
+		params.allowFilters,
+		params.monitorFilters,
+		params.canaryBlockFilters,
+		params.canaryAllowFilters,
+	} {
+		for i := range filters {
+			f := &filters[i]
+			if f.FilePath == "" || f.rulesChecksum == 0 {
+				continue
+			}
+
+			p, err := d.compiledFilter(f)
+			if err != nil {
+				log.Error("filtering: compiling filter %d: %s", f.ID, err)
+
+				continue
+			}
+
+			used[p] = struct{}{}
+			f.FilePath = p
+		}
+	}
+
+	d.removeStaleCompiled(used)
+}
+
+// compiledFilter returns the path to the compiled form of f compiling it, if
+// it isn't cached yet.
+func (d *DNSFilter) compiledFilter(f *Filter) (p string, err error) {
+	p = compiledPath(d.conf.DataDir, f.ID, f.rulesChecksum)
+	_, err = os.Stat(p)
+	if err == nil {
+		return p, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("checking compiled file: %w", err)
+	}
+
+	log.Debug("filtering: compiling filter %d into %q", f.ID, p)
+
+	err = os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return "", fmt.Errorf("creating compiled filters dir: %w", err)
+	}
+
+	bufPtr := d.bufPool.Get()
+	defer d.bufPool.Put(bufPtr)
+
+	err = compileList(p, f.FilePath, f.ID, f.rulesChecksum, *bufPtr)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return "", err
+	}
+
+	return p, nil
+}
+
+// compileList writes the rules of the filter list with id from the file at
+// src, which are used for DNS filtering, into the file at dst.  checksum is the
+// expected checksum of the rules of the list, so that the list changed since it
+// was loaded isn't cached under the wrong checksum.
+func compileList(dst, src string, id int64, checksum uint32, buf []byte) (err error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening filter file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	f, err := aghrenameio.NewPendingFile(dst, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating compiled file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	w := bufio.NewWriter(f)
+	c := &ruleCompiler{w: w, id: int(id)}
+	res, err := rulelist.NewParser().Parse(c, bufio.NewReader(file), buf)
+	if err != nil {
+		return fmt.Errorf("parsing filter file: %w", err)
+	}
+
+	if res.Checksum != checksum {
+		return fmt.Errorf("checksum: got %08x, want %08x", res.Checksum, checksum)
+	}
+
+	return errors.Annotate(w.Flush(), "writing compiled file: %w")
+}
+
+// ruleCompiler is an [io.Writer] receiving the rules from [rulelist.Parser] one
+// at a time and writing only the valid rules used for DNS filtering.
+type ruleCompiler struct {
+	// w is the writer for the compiled rules.
+	w *bufio.Writer
+
+	// id is the ID of the filter list.
+	id int
+}
+
+// Write implements the [io.Writer] interface for *ruleCompiler.  line is a
+// single rule ending with a newline.
+func (c *ruleCompiler) Write(line []byte) (n int, err error) {
+	r, err := rules.NewRule(string(line), c.id)
+	if err != nil {
+		return len(line), nil
+	}
+
+	switch r := r.(type) {
+	case *rules.HostRule:
+		// Go on.
+	case *rules.NetworkRule:
+		if !r.IsHostLevelNetworkRule() {
+			return len(line), nil
+		}
+	default:
+		return len(line), nil
+	}
+
+	return c.w.Write(line)
+}
+
+// removeStaleCompiled removes the compiled filter lists, which paths aren't in
+// used.
+func (d *DNSFilter) removeStaleCompiled(used map[string]struct{}) {
+	dir := filepath.Join(d.conf.DataDir, filterDir, compiledDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Error("filtering: reading compiled filters: %s", err)
+		}
+
+		return
+	}
+
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if _, ok := used[p]; ok || e.IsDir() {
+			continue
+		}
+
+		log.Debug("filtering: removing stale compiled filter %q", p)
+
+		err = os.Remove(p)
+		if err != nil {
+			log.Error("filtering: removing stale compiled filter: %s", err)
+		}
+	}
+}
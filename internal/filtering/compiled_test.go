@@ -0,0 +1,118 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCompiledRules is the content of the filter list for compilation tests.
+// Only the rules used for DNS filtering are kept.
+const testCompiledRules = `! Title: Test
+! Comment
+||blocked.example^
+example.org##.banner
+||domain.example^$domain=example.org
+0.0.0.0 hosts.example
+@@||allowed.example^
+`
+
+// testChecksum returns the checksum of the rules in data.
+func testChecksum(t *testing.T, data string) (checksum uint32) {
+	t.Helper()
+
+	res, err := rulelist.NewParser().Parse(
+		&strings.Builder{},
+		strings.NewReader(data),
+		make([]byte, rulelist.DefaultRuleBufSize),
+	)
+	require.NoError(t, err)
+
+	return res.Checksum
+}
+
+func TestCompileList(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "1.txt")
+	err := os.WriteFile(src, []byte(testCompiledRules), 0o644)
+	require.NoError(t, err)
+
+	checksum := testChecksum(t, testCompiledRules)
+	buf := make([]byte, rulelist.DefaultRuleBufSize)
+
+	t.Run("success", func(t *testing.T) {
+		dst := filepath.Join(dir, "compiled.txt")
+		err = compileList(dst, src, 1, checksum, buf)
+		require.NoError(t, err)
+
+		var data []byte
+		data, err = os.ReadFile(dst)
+		require.NoError(t, err)
+
+		assert.Equal(t, "||blocked.example^\n0.0.0.0 hosts.example\n@@||allowed.example^\n", string(data))
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		dst := filepath.Join(dir, "changed.txt")
+		err = compileList(dst, src, 1, checksum+1, buf)
+		require.Error(t, err)
+
+		assert.NoFileExists(t, dst)
+	})
+}
+
+func TestDNSFilter_compiledFilters(t *testing.T) {
+	dataDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dataDir, filterDir), 0o755)
+	require.NoError(t, err)
+
+	flt := FilterYAML{
+		Enabled: true,
+		URL:     "https://filters.example/list.txt",
+		Filter:  Filter{ID: 1},
+	}
+
+	err = os.WriteFile(flt.Path(dataDir), []byte(testCompiledRules), 0o644)
+	require.NoError(t, err)
+
+	d, setts := newForTest(t, &Config{
+		DataDir:     dataDir,
+		LazyLoading: true,
+		Filters:     []FilterYAML{flt},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	assert.False(t, d.FiltersCompiled())
+
+	d.EnableFilters(false)
+
+	assert.True(t, d.FiltersCompiled())
+	assert.FileExists(t, compiledPath(dataDir, 1, testChecksum(t, testCompiledRules)))
+
+	for host, want := range map[string]bool{
+		"blocked.example": true,
+		"hosts.example":   true,
+		"allowed.example": false,
+		"domain.example":  false,
+	} {
+		res, cErr := d.CheckHost(host, dns.TypeA, setts)
+		require.NoError(t, cErr)
+
+		assert.Equalf(t, want, res.IsFiltered, "host %q", host)
+	}
+
+	// The compiled forms of the removed lists are removed on the next reload.
+	d.conf.Filters = nil
+	d.EnableFilters(false)
+
+	entries, err := os.ReadDir(filepath.Join(dataDir, filterDir, compiledDir))
+	require.NoError(t, err)
+
+	assert.Empty(t, entries)
+}
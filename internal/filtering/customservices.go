@@ -0,0 +1,253 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// CustomService is a service defined by the user, which is blocked the same
+// way as the built-in ones.
+type CustomService struct {
+	// ID is the unique identifier of the service.  It must not be the same as
+	// the ID of any built-in service.
+	ID string `json:"id" yaml:"id"`
+
+	// Name is the human-readable name of the service.
+	Name string `json:"name" yaml:"name"`
+
+	// Rules are the filtering rules blocking the service, for example
+	// "||example.com^".
+	Rules []string `json:"rules" yaml:"rules"`
+}
+
+// customServices are the rules of the custom services by their IDs.
+//
+// TODO(a.garipov): Move the blocked services data into a separate entity
+// instead of package-level variables.
+var customServices = &customServiceRegistry{
+	mu:    &sync.RWMutex{},
+	rules: map[string][]*rules.NetworkRule{},
+}
+
+// customServiceRegistry contains the rules of the custom services.
+type customServiceRegistry struct {
+	// mu protects svcs and rules.
+	mu *sync.RWMutex
+
+	// svcs are the custom services in the order of their definition.
+	svcs []*CustomService
+
+	// rules are the parsed rules of svcs by the IDs of the services.
+	rules map[string][]*rules.NetworkRule
+}
+
+// SetCustomServices validates svcs and makes them available for blocking.  It
+// must be called after [InitModule].
+func SetCustomServices(svcs []*CustomService) (err error) {
+	svcRules, err := parseCustomServices(svcs)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	customServices.mu.Lock()
+	defer customServices.mu.Unlock()
+
+	customServices.svcs = svcs
+	customServices.rules = svcRules
+
+	log.Debug("filtering: initialized %d custom services", len(svcs))
+
+	return nil
+}
+
+// parseCustomServices validates svcs and returns their parsed rules by the IDs
+// of the services.
+func parseCustomServices(svcs []*CustomService) (svcRules map[string][]*rules.NetworkRule, err error) {
+	svcRules = make(map[string][]*rules.NetworkRule, len(svcs))
+	for i, s := range svcs {
+		var netRules []*rules.NetworkRule
+		netRules, err = s.parse()
+		if err != nil {
+			return nil, fmt.Errorf("custom service at index %d: %w", i, err)
+		}
+
+		if _, ok := svcRules[s.ID]; ok {
+			return nil, fmt.Errorf("custom service at index %d: duplicate id %q", i, s.ID)
+		}
+
+		svcRules[s.ID] = netRules
+	}
+
+	return svcRules, nil
+}
+
+// parse validates s and returns its parsed rules.
+func (s *CustomService) parse() (netRules []*rules.NetworkRule, err error) {
+	switch {
+	case s == nil:
+		return nil, errors.Error("no value")
+	case s.ID == "":
+		return nil, errors.Error("empty id")
+	case s.Name == "":
+		return nil, errors.Error("empty name")
+	case len(s.Rules) == 0:
+		return nil, errors.Error("no rules")
+	}
+
+	if _, ok := serviceRules[s.ID]; ok {
+		return nil, fmt.Errorf("id %q is the id of a built-in service", s.ID)
+	}
+
+	netRules = make([]*rules.NetworkRule, 0, len(s.Rules))
+	for _, text := range s.Rules {
+		var rule *rules.NetworkRule
+		rule, err = rules.NewNetworkRule(text, BlockedSvcsListID)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", text, err)
+		}
+
+		netRules = append(netRules, rule)
+	}
+
+	return netRules, nil
+}
+
+// lookupServiceRules returns the rules of the built-in or the custom service
+// with id.
+func lookupServiceRules(id string) (netRules []*rules.NetworkRule, ok bool) {
+	netRules, ok = serviceRules[id]
+	if ok {
+		return netRules, true
+	}
+
+	customServices.mu.RLock()
+	defer customServices.mu.RUnlock()
+
+	netRules, ok = customServices.rules[id]
+
+	return netRules, ok
+}
+
+// customServiceIDs returns the sorted IDs of the custom services.
+func customServiceIDs() (ids []string) {
+	customServices.mu.RLock()
+	defer customServices.mu.RUnlock()
+
+	ids = maps.Keys(customServices.rules)
+	slices.Sort(ids)
+
+	return ids
+}
+
+// customServicesJSON is the JSON structure for the list of the custom
+// services.
+type customServicesJSON struct {
+	Services []*CustomService `json:"services"`
+}
+
+// handleCustomServicesGet is the handler for the GET
+// /control/blocked_services/custom HTTP API.
+func (d *DNSFilter) handleCustomServicesGet(w http.ResponseWriter, r *http.Request) {
+	resp := &customServicesJSON{}
+	func() {
+		customServices.mu.RLock()
+		defer customServices.mu.RUnlock()
+
+		resp.Services = slices.Clone(customServices.svcs)
+	}()
+
+	if resp.Services == nil {
+		resp.Services = []*CustomService{}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleCustomServicesUpdate is the handler for the PUT
+// /control/blocked_services/custom/update HTTP API.  It replaces all the custom
+// services.
+func (d *DNSFilter) handleCustomServicesUpdate(w http.ResponseWriter, r *http.Request) {
+	req := &customServicesJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	_, err = parseCustomServices(req.Services)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "validating: %s", err)
+
+		return
+	}
+
+	err = d.checkRemovedServices(req.Services)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "%s", err)
+
+		return
+	}
+
+	err = SetCustomServices(req.Services)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "setting custom services: %s", err)
+
+		return
+	}
+
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		d.conf.CustomServices = req.Services
+	}()
+
+	d.conf.ConfigModified()
+}
+
+// checkRemovedServices returns an error if any of the custom services absent
+// from svcs is still blocked globally or, according to d.conf.ServiceInUse,
+// anywhere else.
+func (d *DNSFilter) checkRemovedServices(svcs []*CustomService) (err error) {
+	ids := stringutil.NewSet()
+	for _, s := range svcs {
+		ids.Add(s.ID)
+	}
+
+	var blocked []string
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		blocked = slices.Clone(d.conf.BlockedServices.IDs)
+	}()
+
+	for _, id := range customServiceIDs() {
+		if ids.Has(id) {
+			continue
+		}
+
+		inUse := slices.Contains(blocked, id)
+		if !inUse && d.conf.ServiceInUse != nil {
+			inUse = d.conf.ServiceInUse(id)
+		}
+
+		if inUse {
+			return fmt.Errorf("custom service %q is in use", id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCustomServices(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, SetCustomServices(nil))
+	})
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		svcs       []*CustomService
+	}{{
+		name:       "nil",
+		wantErrMsg: "custom service at index 0: no value",
+		svcs:       []*CustomService{nil},
+	}, {
+		name:       "no_id",
+		wantErrMsg: "custom service at index 0: empty id",
+		svcs:       []*CustomService{{Name: "App", Rules: []string{"||app.example^"}}},
+	}, {
+		name:       "no_rules",
+		wantErrMsg: "custom service at index 0: no rules",
+		svcs:       []*CustomService{{ID: "app", Name: "App"}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `custom service at index 1: duplicate id "app"`,
+		svcs: []*CustomService{{
+			ID:    "app",
+			Name:  "App",
+			Rules: []string{"||app.example^"},
+		}, {
+			ID:    "app",
+			Name:  "Other app",
+			Rules: []string{"||other.example^"},
+		}},
+	}, {
+		name:       "valid",
+		wantErrMsg: "",
+		svcs: []*CustomService{{
+			ID:    "regional_app",
+			Name:  "Regional app",
+			Rules: []string{"||app.example^", "||cdn.app.example^"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := SetCustomServices(tc.svcs)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+
+	assert.Equal(t, []string{"regional_app"}, customServiceIDs())
+
+	err := (&BlockedServices{IDs: []string{"regional_app"}}).Validate()
+	require.NoError(t, err)
+
+	d, setts := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.ApplyBlockedServicesList(setts, []string{"regional_app"})
+	require.Len(t, setts.ServicesRules, 1)
+
+	res, err := d.CheckHost("www.app.example", dns.TypeA, setts)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, FilteredBlockedService, res.Reason)
+	assert.Equal(t, "regional_app", res.ServiceName)
+}
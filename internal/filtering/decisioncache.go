@@ -0,0 +1,129 @@
+package filtering
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// decisionCache is an LRU cache of the results of matching hosts against the
+// filtering rules.  It must be cleared each time the filtering engines change.
+type decisionCache struct {
+	// mu protects items and order.
+	mu *sync.Mutex
+
+	// items are the elements of order by the key.
+	items map[string]*list.Element
+
+	// order is the list of *decisionCacheItem from the most recently used to
+	// the least recently used.
+	order *list.List
+
+	// size is the maximum number of items.
+	size int
+}
+
+// decisionCacheItem is a single cached result.
+type decisionCacheItem struct {
+	key string
+	res Result
+}
+
+// newDecisionCache returns a new properly initialized *decisionCache with size
+// maximum number of items.  c is nil if size is zero.
+func newDecisionCache(size uint) (c *decisionCache) {
+	if size == 0 {
+		return nil
+	}
+
+	return &decisionCache{
+		mu:    &sync.Mutex{},
+		items: map[string]*list.Element{},
+		order: list.New(),
+		size:  int(size),
+	}
+}
+
+// decisionKey returns the cache key for the request for host of qtype by the
+// client described by setts.  It only includes the settings, which affect the
+// matching of the filtering rules.
+func decisionKey(host string, qtype uint16, setts *Settings) (key string) {
+	b := &strings.Builder{}
+
+	b.WriteString(strconv.FormatBool(setts.FilteringEnabled))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatBool(setts.ProtectionEnabled))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatUint(uint64(qtype), 10))
+	b.WriteByte(' ')
+	b.WriteString(host)
+	b.WriteByte(' ')
+	b.WriteString(setts.ClientIP.String())
+	b.WriteByte(' ')
+	b.WriteString(strings.Join(setts.ClientTags, ","))
+	b.WriteByte(' ')
+	b.WriteString(setts.ClientName)
+	for _, id := range setts.DisabledFilterLists {
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(id, 10))
+	}
+
+	return b.String()
+}
+
+// get returns the cached result for key, if any.
+func (c *decisionCache) get(key string) (res Result, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return Result{}, false
+	}
+
+	c.order.MoveToFront(e)
+
+	return e.Value.(*decisionCacheItem).res, true
+}
+
+// set caches res for key evicting the least recently used item, if necessary.
+func (c *decisionCache) set(key string, res Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*decisionCacheItem).res = res
+		c.order.MoveToFront(e)
+
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&decisionCacheItem{
+		key: key,
+		res: res,
+	})
+
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*decisionCacheItem).key)
+	}
+}
+
+// clear removes all the items from the cache.
+func (c *decisionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+}
+
+// len returns the number of the cached items.
+func (c *decisionCache) len() (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,79 @@
+package filtering
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionCache(t *testing.T) {
+	c := newDecisionCache(2)
+
+	c.set("a", Result{Reason: FilteredBlockList})
+	c.set("b", Result{Reason: NotFilteredAllowList})
+
+	res, ok := c.get("a")
+	require.True(t, ok)
+	assert.Equal(t, FilteredBlockList, res.Reason)
+
+	// "b" is the least recently used one now.
+	c.set("c", Result{})
+	assert.Equal(t, 2, c.len())
+
+	_, ok = c.get("b")
+	assert.False(t, ok)
+
+	_, ok = c.get("a")
+	assert.True(t, ok)
+
+	c.clear()
+	assert.Zero(t, c.len())
+
+	assert.Nil(t, newDecisionCache(0))
+}
+
+func TestDNSFilter_matchHost_decisionCache(t *testing.T) {
+	const host = "host.example"
+
+	filters := []Filter{{
+		ID: 0, Data: []byte("||host.example^$client=1.2.3.4\n"),
+	}}
+
+	f, setts := newForTest(t, &Config{DecisionCacheSize: 10}, filters)
+	t.Cleanup(f.Close)
+
+	setts.ClientIP = netip.MustParseAddr("1.2.3.4")
+
+	res, err := f.CheckHostRules(host, dns.TypeA, setts)
+	require.NoError(t, err)
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, 1, f.decisions.len())
+
+	res, err = f.CheckHostRules(host, dns.TypeA, setts)
+	require.NoError(t, err)
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, 1, f.decisions.len())
+
+	// Another client has its own decision.
+	otherSetts := *setts
+	otherSetts.ClientIP = netip.MustParseAddr("5.6.7.8")
+
+	res, err = f.CheckHostRules(host, dns.TypeA, &otherSetts)
+	require.NoError(t, err)
+	assert.False(t, res.IsFiltered)
+	assert.Equal(t, 2, f.decisions.len())
+
+	// Changing the rules invalidates the cache.
+	err = f.initFiltering(&filtersInitializerParams{
+		blockFilters: []Filter{{ID: 0, Data: []byte("@@||host.example^\n")}},
+	})
+	require.NoError(t, err)
+	assert.Zero(t, f.decisions.len())
+
+	res, err = f.CheckHostRules(host, dns.TypeA, setts)
+	require.NoError(t, err)
+	assert.False(t, res.IsFiltered)
+}
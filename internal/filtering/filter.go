@@ -1,9 +1,11 @@
 package filtering
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -14,6 +16,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"golang.org/x/exp/slices"
 )
@@ -39,6 +42,20 @@ type FilterYAML struct {
 	checksum    uint32    // checksum of the file data
 	white       bool
 
+	// MonitorOnly defines if the rules of the list are only matched and
+	// recorded into the query log with the NotFilteredMonitored reason, but
+	// aren't enforced.  Allowlists can't be monitor-only.
+	MonitorOnly bool `yaml:"monitor_only"`
+
+	// rpz is the information about the response policy zone, which the list
+	// has been translated from, if any.
+	rpz *rpzInfo
+
+	// canarySince is the time, when the update of the list has been rolled out
+	// to the canary clients.  It's zero if there is no update in the canary
+	// rollout, see [CanaryConfig].
+	canarySince time.Time
+
 	Filter `yaml:",inline"`
 }
 
@@ -46,6 +63,8 @@ type FilterYAML struct {
 func (filter *FilterYAML) unload() {
 	filter.RulesCount = 0
 	filter.checksum = 0
+	filter.rpz = nil
+	filter.canarySince = time.Time{}
 }
 
 // Path to the filter contents
@@ -53,6 +72,17 @@ func (filter *FilterYAML) Path(dataDir string) string {
 	return filepath.Join(dataDir, filterDir, strconv.FormatInt(filter.ID, 10)+".txt")
 }
 
+// compiledChecksum returns the checksum of the rules of the filter list, by
+// which its compiled form is cached, or zero if the list shouldn't be compiled,
+// since the checksum is the one of the update in the canary rollout.
+func (filter *FilterYAML) compiledChecksum() (checksum uint32) {
+	if !filter.canarySince.IsZero() {
+		return 0
+	}
+
+	return filter.checksum
+}
+
 // ensureName sets provided title or default name for the filter if it doesn't
 // have name already.
 func (filter *FilterYAML) ensureName(title string) {
@@ -81,6 +111,10 @@ const (
 	//
 	// TODO(e.burkov):  Use wherever the same error is needed.
 	errFilterExists errors.Error = "url already exists"
+
+	// errMonitorAllowlist is returned when an allowlist is set to be
+	// monitor-only.
+	errMonitorAllowlist errors.Error = "allowlists can't be monitor-only"
 )
 
 // filterSetProperties searches for the particular filter list by url and sets
@@ -91,6 +125,10 @@ func (d *DNSFilter) filterSetProperties(
 	newList FilterYAML,
 	isAllowlist bool,
 ) (shouldRestart bool, err error) {
+	if isAllowlist && newList.MonitorOnly {
+		return false, errMonitorAllowlist
+	}
+
 	d.conf.filtersMu.Lock()
 	defer d.conf.filtersMu.Unlock()
 
@@ -106,10 +144,11 @@ func (d *DNSFilter) filterSetProperties(
 
 	flt := &filters[i]
 	log.Debug(
-		"filtering: set name to %q, url to %s, enabled to %t for filter %s",
+		"filtering: set name to %q, url to %s, enabled to %t, monitor only to %t for filter %s",
 		newList.Name,
 		newList.URL,
 		newList.Enabled,
+		newList.MonitorOnly,
 		flt.URL,
 	)
 
@@ -156,6 +195,13 @@ func (d *DNSFilter) filterSetProperties(
 		flt.unload()
 	}
 
+	if err == nil && flt.MonitorOnly != newList.MonitorOnly {
+		// The contents of the list don't change, so the engines only need to be
+		// rebuilt.
+		flt.MonitorOnly = newList.MonitorOnly
+		shouldRestart = shouldRestart || flt.Enabled
+	}
+
 	return shouldRestart, err
 }
 
@@ -188,6 +234,53 @@ func (d *DNSFilter) filterExistsLocked(url string) (ok bool) {
 	return false
 }
 
+// FilterListSize is the number of rules in a filter list.
+type FilterListSize struct {
+	// Name is the name of the list.
+	Name string
+
+	// ID is the ID of the list.
+	ID int64
+
+	// RulesCount is the number of rules in the list.
+	RulesCount int
+
+	// Enabled defines if the list is enabled.
+	Enabled bool
+
+	// Allowlist is true if the list is an allowlist.
+	Allowlist bool
+}
+
+// FilterListSizes returns the numbers of rules in the configured filter lists.
+// It's safe for concurrent use.
+func (d *DNSFilter) FilterListSizes() (sizes []*FilterListSize) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	sizes = make([]*FilterListSize, 0, len(d.conf.Filters)+len(d.conf.WhitelistFilters))
+	for _, f := range d.conf.Filters {
+		sizes = append(sizes, newFilterListSize(&f, false))
+	}
+
+	for _, f := range d.conf.WhitelistFilters {
+		sizes = append(sizes, newFilterListSize(&f, true))
+	}
+
+	return sizes
+}
+
+// newFilterListSize returns the size of the filter list f.
+func newFilterListSize(f *FilterYAML, allowlist bool) (s *FilterListSize) {
+	return &FilterListSize{
+		Name:       f.Name,
+		ID:         f.ID,
+		RulesCount: f.RulesCount,
+		Enabled:    f.Enabled,
+		Allowlist:  allowlist,
+	}
+}
+
 // Add a filter
 // Return FALSE if a filter with this URL exists
 func (d *DNSFilter) filterAdd(flt FilterYAML) (err error) {
@@ -283,7 +376,20 @@ func (d *DNSFilter) periodicallyRefreshFilters() {
 			}
 		}
 
-		time.Sleep(time.Duration(ivl) * time.Second)
+		d.promoteExpiredCanaries()
+		d.refreshIPBlocklists()
+		d.refreshCategories()
+
+		sleep := time.Duration(ivl) * time.Second
+		if rpzIvl, hasRPZ := d.rpzRefreshIvl(); hasRPZ && rpzIvl < sleep {
+			sleep = rpzIvl
+		}
+
+		if canaryIvl, hasCanary := d.canaryPromoteIvl(); hasCanary && canaryIvl < sleep {
+			sleep = mathutil.Max(canaryIvl, time.Second)
+		}
+
+		time.Sleep(sleep)
 	}
 }
 
@@ -317,26 +423,59 @@ func (d *DNSFilter) listsToUpdate(filters *[]FilterYAML, force bool) (toUpd []Fi
 			continue
 		}
 
-		if !force {
-			exp := flt.LastUpdated.Add(time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour)
-			if now.Before(exp) {
-				continue
-			}
+		if !force && now.Before(flt.LastUpdated.Add(d.updateIvl(flt))) {
+			continue
 		}
 
 		toUpd = append(toUpd, FilterYAML{
 			Filter: Filter{
 				ID: flt.ID,
 			},
-			URL:      flt.URL,
-			Name:     flt.Name,
-			checksum: flt.checksum,
+			URL:         flt.URL,
+			Name:        flt.Name,
+			checksum:    flt.checksum,
+			rpz:         flt.rpz,
+			canarySince: flt.canarySince,
 		})
 	}
 
 	return toUpd
 }
 
+// updateIvl returns the interval between the updates of flt.  The response
+// policy zones are updated according to their SOA timers.
+func (d *DNSFilter) updateIvl(flt *FilterYAML) (ivl time.Duration) {
+	if flt.rpz != nil {
+		return flt.rpz.updateIvl()
+	}
+
+	return time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour
+}
+
+// rpzRefreshIvl returns the time until the next update of the enabled response
+// policy zones.  ok is false if there are none.
+func (d *DNSFilter) rpzRefreshIvl() (ivl time.Duration, ok bool) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	now := time.Now()
+	for _, filters := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+		for i := range filters {
+			flt := &filters[i]
+			if !flt.Enabled || flt.rpz == nil {
+				continue
+			}
+
+			fltIvl := flt.LastUpdated.Add(flt.rpz.updateIvl()).Sub(now)
+			if !ok || fltIvl < ivl {
+				ivl, ok = fltIvl, true
+			}
+		}
+	}
+
+	return mathutil.Max(ivl, minRPZRefreshIvl), ok
+}
+
 func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int, []FilterYAML, []bool, bool) {
 	var updateFlags []bool // 'true' if filter data has changed
 
@@ -353,6 +492,9 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 		if err != nil {
 			failNum++
 			log.Error("filtering: updating filter from url %q: %s\n", uf.URL, err)
+			if d.conf.FilterUpdateFailed != nil {
+				d.conf.FilterUpdateFailed(uf.Name, uf.URL, err)
+			}
 
 			continue
 		}
@@ -378,6 +520,8 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 			}
 
 			f.LastUpdated = uf.LastUpdated
+			f.rpz = uf.rpz
+			f.canarySince = uf.canarySince
 			if !updated {
 				continue
 			}
@@ -466,6 +610,9 @@ func (d *DNSFilter) refreshFiltersIntl(block, allow, force bool) (int, bool) {
 func (d *DNSFilter) update(filter *FilterYAML) (b bool, err error) {
 	b, err = d.updateIntl(filter)
 	filter.LastUpdated = time.Now()
+	if filter.rpz != nil {
+		filter.rpz = filter.rpz.withFailed(err != nil)
+	}
 	if !b {
 		chErr := os.Chtimes(
 			filter.Path(d.conf.DataDir),
@@ -486,19 +633,30 @@ func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
 	log.Debug("filtering: downloading update for filter %d from %q", flt.ID, flt.URL)
 
 	var res *rulelist.ParseResult
+	var info *rpzInfo
+
+	// Roll the updates of the previously loaded lists out to the canary
+	// clients first, if configured.
+	isCanary := d.isCanary(flt)
+	p := flt.Path(d.conf.DataDir)
+	if isCanary {
+		p = flt.canaryPath(d.conf.DataDir)
+	}
 
 	// Change the default 0o600 permission to something more acceptable by end
 	// users.
 	//
 	// See https://github.com/AdguardTeam/AdGuardHome/issues/3198.
-	tmpFile, err := aghrenameio.NewPendingFile(flt.Path(d.conf.DataDir), 0o644)
+	tmpFile, err := aghrenameio.NewPendingFile(p, 0o644)
 	if err != nil {
 		return false, err
 	}
 	defer func() { err = d.finalizeUpdate(tmpFile, flt, res, err, ok) }()
 
-	r, err := d.reader(flt.URL)
-	if err != nil {
+	r, err := d.reader(flt)
+	if errors.Is(err, errRPZNotChanged) {
+		return false, nil
+	} else if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return false, err
 	}
@@ -507,10 +665,24 @@ func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
 	bufPtr := d.bufPool.Get()
 	defer d.bufPool.Put(bufPtr)
 
-	p := rulelist.NewParser()
-	res, err = p.Parse(tmpFile, r, *bufPtr)
+	br := bufio.NewReader(r)
+	if isRPZ(br) {
+		res, info, err = parseRPZ(tmpFile, br, *bufPtr)
+	} else {
+		p := rulelist.NewParser()
+		res, err = p.Parse(tmpFile, br, *bufPtr)
+	}
+
+	ok = err == nil && (res.Checksum != flt.checksum || rpzChanged(flt.rpz, info))
+	if ok {
+		flt.rpz = info
+		if isCanary {
+			flt.canarySince = time.Now()
+			log.Info("filtering: rolling update of filter %d out to canary clients", flt.ID)
+		}
+	}
 
-	return res.Checksum != flt.checksum && err == nil, err
+	return ok, err
 }
 
 // finalizeUpdate closes and gets rid of temporary file f with filter's content
@@ -532,7 +704,12 @@ func (d *DNSFilter) finalizeUpdate(
 		return errors.WithDeferred(returned, file.Cleanup())
 	}
 
-	log.Info("filtering: saving contents of filter %d into %q", id, flt.Path(d.conf.DataDir))
+	p := flt.Path(d.conf.DataDir)
+	if !flt.canarySince.IsZero() {
+		p = flt.canaryPath(d.conf.DataDir)
+	}
+
+	log.Info("filtering: saving contents of filter %d into %q", id, p)
 
 	err = file.CloseReplace()
 	if err != nil {
@@ -550,8 +727,13 @@ func (d *DNSFilter) finalizeUpdate(
 }
 
 // reader returns an io.ReadCloser reading filtering-rule list data form either
-// a file on the filesystem or the filter's HTTP URL.
-func (d *DNSFilter) reader(fltURL string) (r io.ReadCloser, err error) {
+// a file on the filesystem, the filter's HTTP URL, or the zone transfer.
+func (d *DNSFilter) reader(flt *FilterYAML) (r io.ReadCloser, err error) {
+	fltURL := flt.URL
+	if u, pErr := url.Parse(fltURL); pErr == nil && isXFRScheme(u.Scheme) {
+		return readerFromXFR(u, flt.rpz)
+	}
+
 	if !filepath.IsAbs(fltURL) {
 		r, err = d.readerFromURL(fltURL)
 		if err != nil {
@@ -587,7 +769,10 @@ func (d *DNSFilter) readerFromURL(fltURL string) (r io.ReadCloser, err error) {
 
 // loads filter contents from the file in dataDir
 func (d *DNSFilter) load(flt *FilterYAML) (err error) {
-	fileName := flt.Path(d.conf.DataDir)
+	fileName, err := d.loadCanary(flt)
+	if err != nil {
+		return fmt.Errorf("loading canary update: %w", err)
+	}
 
 	log.Debug("filtering: loading filter %d from %q", flt.ID, fileName)
 
@@ -610,8 +795,11 @@ func (d *DNSFilter) load(flt *FilterYAML) (err error) {
 	bufPtr := d.bufPool.Get()
 	defer d.bufPool.Put(bufPtr)
 
+	br := bufio.NewReader(file)
+	flt.rpz = readRPZInfo(br)
+
 	p := rulelist.NewParser()
-	res, err := p.Parse(io.Discard, file, *bufPtr)
+	res, err := p.Parse(io.Discard, br, *bufPtr)
 	if err != nil {
 		return fmt.Errorf("parsing filter file: %w", err)
 	}
@@ -630,21 +818,36 @@ func (d *DNSFilter) EnableFilters(async bool) {
 }
 
 func (d *DNSFilter) enableFiltersLocked(async bool) {
-	filters := make([]Filter, 1, len(d.conf.Filters)+len(d.conf.WhitelistFilters)+1)
+	filters := make([]Filter, 1, len(d.conf.Filters)+len(d.conf.WhitelistFilters)+2)
 	filters[0] = Filter{
 		ID:   CustomListID,
 		Data: []byte(strings.Join(d.conf.UserRules, "\n")),
 	}
 
+	if len(d.conf.ManagedHosts) > 0 {
+		filters = append(filters, Filter{
+			ID:   ManagedHostsListID,
+			Data: []byte(strings.Join(d.conf.ManagedHosts, "\n")),
+		})
+	}
+
+	var monitorFilters []Filter
 	for _, filter := range d.conf.Filters {
 		if !filter.Enabled {
 			continue
 		}
 
-		filters = append(filters, Filter{
-			ID:       filter.ID,
-			FilePath: filter.Path(d.conf.DataDir),
-		})
+		f := Filter{
+			ID:            filter.ID,
+			FilePath:      filter.Path(d.conf.DataDir),
+			rulesChecksum: filter.compiledChecksum(),
+		}
+
+		if filter.MonitorOnly {
+			monitorFilters = append(monitorFilters, f)
+		} else {
+			filters = append(filters, f)
+		}
 	}
 
 	var allowFilters []Filter
@@ -654,12 +857,25 @@ func (d *DNSFilter) enableFiltersLocked(async bool) {
 		}
 
 		allowFilters = append(allowFilters, Filter{
-			ID:       filter.ID,
-			FilePath: filter.Path(d.conf.DataDir),
+			ID:            filter.ID,
+			FilePath:      filter.Path(d.conf.DataDir),
+			rulesChecksum: filter.compiledChecksum(),
 		})
 	}
 
-	err := d.setFilters(filters, allowFilters, async)
+	params := &filtersInitializerParams{
+		allowFilters:   allowFilters,
+		blockFilters:   filters,
+		monitorFilters: monitorFilters,
+	}
+
+	canaryBlock, hasBlock := d.canaryFilters(filters, d.conf.Filters)
+	canaryAllow, hasAllow := d.canaryFilters(allowFilters, d.conf.WhitelistFilters)
+	if d.canary != nil && (hasBlock || hasAllow) {
+		params.canaryBlockFilters, params.canaryAllowFilters = canaryBlock, canaryAllow
+	}
+
+	err := d.setFilters(params, async)
 	if err != nil {
 		log.Error("filtering: enabling filters: %s", err)
 	}
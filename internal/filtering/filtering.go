@@ -12,7 +12,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,6 +26,7 @@ import (
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/golibs/syncutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/AdguardTeam/urlfilter"
 	"github.com/AdguardTeam/urlfilter/filterlist"
 	"github.com/AdguardTeam/urlfilter/rules"
@@ -45,6 +45,9 @@ const (
 	ParentalListID
 	SafeBrowsingListID
 	SafeSearchListID
+	ManagedHostsListID
+	IPBlocklistsListID
+	CategoriesListID
 )
 
 // ServiceEntry - blocked service array element
@@ -69,6 +72,58 @@ type Settings struct {
 
 	// ClientSafeSearch is a client configured safe search.
 	ClientSafeSearch SafeSearch
+
+	// DisabledFilterLists are the sorted IDs of the filter lists, rules from
+	// which must not be applied to the request.
+	DisabledFilterLists []int64
+
+	// BlockedCategories are the names of the domain categories, the hosts from
+	// which must be blocked, see [CategoriesConfig].
+	BlockedCategories []string
+
+	// AllowlistOnly, if true, means that all hosts, which aren't explicitly
+	// allowed by the allowlists or the allowlist rules, must be blocked.  See
+	// [DNSFilter.CheckHost] for the interaction with the other features.
+	AllowlistOnly bool
+
+	// UnblockedHosts are the hosts, which are temporarily unblocked for the
+	// client, for example from the block page.  The subdomains of the hosts
+	// are unblocked as well.
+	UnblockedHosts []string
+
+	// InternetPauseAllowedHosts are the hosts, which are resolved while the
+	// internet access of the client is paused.  The subdomains of the hosts
+	// are allowed as well.
+	InternetPauseAllowedHosts []string
+
+	// InternetPaused, if true, means that the internet access of the client is
+	// paused, so all hosts except InternetPauseAllowedHosts must be blocked
+	// regardless of the other settings.
+	InternetPaused bool
+}
+
+// isUnblocked returns true if host, which must be normalized, is one of the
+// unblocked hosts of setts or their subdomain.
+func (setts *Settings) isUnblocked(host string) (ok bool) {
+	return hasHostOrParent(setts.UnblockedHosts, host)
+}
+
+// isPaused returns true if host, which must be normalized, must be blocked,
+// because the internet access of the client is paused.
+func (setts *Settings) isPaused(host string) (ok bool) {
+	return setts.InternetPaused && !hasHostOrParent(setts.InternetPauseAllowedHosts, host)
+}
+
+// hasHostOrParent returns true if host, which must be normalized, or any of its
+// parent domains is in hosts.
+func hasHostOrParent(hosts []string, host string) (ok bool) {
+	for _, h := range hosts {
+		if host == h || netutil.IsSubdomain(host, h) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Resolver is the interface for net.Resolver to simplify testing.
@@ -90,16 +145,56 @@ type Config struct {
 	// ParentControl is the parental control hash-prefix checker.
 	ParentalControlChecker Checker `yaml:"-"`
 
+	// SafeBrowsingProvider is the configuration of the safe browsing lookup
+	// provider, which is used to create SafeBrowsingChecker.  If nil, the
+	// default one is used.
+	SafeBrowsingProvider *ProviderConfig `yaml:"safebrowsing_provider"`
+
+	// ParentalProvider is the configuration of the parental control lookup
+	// provider, which is used to create ParentalControlChecker.  If nil, the
+	// default one is used.
+	ParentalProvider *ProviderConfig `yaml:"parental_provider"`
+
 	SafeSearch SafeSearch `yaml:"-"`
 
 	// BlockedServices is the configuration of blocked services.
 	// Per-client settings can override this configuration.
 	BlockedServices *BlockedServices `yaml:"blocked_services"`
 
+	// CustomServices are the services defined by the user, which can be
+	// blocked the same way as the built-in ones.
+	CustomServices []*CustomService `yaml:"custom_blocked_services"`
+
 	// EtcHosts is a container of IP-hostname pairs taken from the operating
 	// system configuration files (e.g. /etc/hosts).
 	EtcHosts *aghnet.HostsContainer `yaml:"-"`
 
+	// ServiceInUse, if not nil, returns true if the service with id is blocked
+	// by anything besides the global blocked services, so that it can't be
+	// removed.
+	ServiceInUse func(id string) (ok bool) `yaml:"-"`
+
+	// RequestUser, if not nil, returns the name of the authenticated user
+	// making the HTTP request r.
+	RequestUser func(r *http.Request) (name string) `yaml:"-"`
+
+	// ApplyClientSettings, if not nil, applies the settings of the client with
+	// clientIP and clientID to setts.  It's used to trace the filtering of the
+	// requests of particular clients.
+	ApplyClientSettings func(clientIP netip.Addr, clientID string, setts *Settings) `yaml:"-"`
+
+	// BlockedClients, if not nil, returns the list of the disallowed clients
+	// for the export of the user rules.
+	BlockedClients func() (clients []string) `yaml:"-"`
+
+	// AddBlockedClients, if not nil, adds the imported clients to the list of
+	// the disallowed clients.  added is the number of the new clients.
+	AddBlockedClients func(clients []string) (added int, err error) `yaml:"-"`
+
+	// FilterUpdateFailed, if not nil, is called when the filter list with name
+	// and url couldn't be updated.
+	FilterUpdateFailed func(name, url string, err error) `yaml:"-"`
+
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func() `yaml:"-"`
 
@@ -143,12 +238,38 @@ type Config struct {
 	// UserRules is the global list of custom rules.
 	UserRules []string `yaml:"-"`
 
+	// UserRulesProvenance are the records of the origins of the custom rules
+	// added using the HTTP API, keyed by the text of the rule.
+	UserRulesProvenance map[string]*RuleProvenance `yaml:"user_rules_provenance"`
+
+	// ManagedHosts are the normalized lines of the hosts-style block list
+	// edited using the HTTP API, see [DNSFilter.handleManagedHostsList].
+	ManagedHosts []string `yaml:"managed_hosts"`
+
+	// IPBlocklists are the lists of IP addresses and CIDR subnets, the
+	// upstream responses containing which are blocked.  The remote lists are
+	// updated with the same interval as the filter lists.
+	IPBlocklists []*IPBlocklist `yaml:"ip_blocklists"`
+
+	// Canary is the configuration of the canary rollout of the filter list
+	// updates.  If nil, the updates are applied to all clients at once.
+	Canary *CanaryConfig `yaml:"canary"`
+
+	// Categories is the configuration of the domain categorization database.
+	// If nil, the database isn't used.
+	Categories *CategoriesConfig `yaml:"categories"`
+
 	SafeBrowsingCacheSize uint `yaml:"safebrowsing_cache_size"` // (in bytes)
 	SafeSearchCacheSize   uint `yaml:"safesearch_cache_size"`   // (in bytes)
 	ParentalCacheSize     uint `yaml:"parental_cache_size"`     // (in bytes)
 	// TODO(a.garipov): Use timeutil.Duration
 	CacheTime uint `yaml:"cache_time"` // Element's TTL (in minutes)
 
+	// DecisionCacheSize is the maximum number of the cached results of
+	// matching the hosts against the filtering rules.  Zero disables the
+	// cache.
+	DecisionCacheSize uint `yaml:"decision_cache_size"`
+
 	// enabled is used to be returned within Settings.
 	//
 	// It is of type uint32 to be accessed by atomic.
@@ -167,6 +288,22 @@ type Config struct {
 	// FilteringEnabled indicates whether or not use filter lists.
 	FilteringEnabled bool `yaml:"filtering_enabled"`
 
+	// LazyLoading, if true, makes the filter lists be compiled into the files
+	// containing only the rules used for DNS filtering, which are cached by
+	// the checksums of the lists and loaded instead of the lists themselves.
+	// If not all of them are cached on startup, the filter lists are loaded in
+	// the background, so that the DNS server doesn't wait for them, and
+	// aren't applied until they're loaded.  The garbage is also collected more
+	// often during the reloads, while both the current and the new engines are
+	// in memory.
+	LazyLoading bool `yaml:"lazy_loading"`
+
+	// CNAMEChainFiltering, if true, makes the filtering engine check every
+	// name in the CNAME chains of the upstream responses against all the host
+	// checkers.  Otherwise, only the targets of the CNAME records are checked
+	// against the filtering rules.
+	CNAMEChainFiltering bool `yaml:"cname_chain_filtering"`
+
 	ParentalEnabled     bool `yaml:"parental_enabled"`
 	SafeBrowsingEnabled bool `yaml:"safebrowsing_enabled"`
 
@@ -179,6 +316,10 @@ type BlockingMode string
 
 // Allowed blocking modes.
 const (
+	// BlockingModeBlockPage means respond with the IP addresses of the block
+	// page server, which are set the same way as for BlockingModeCustomIP.
+	BlockingModeBlockPage BlockingMode = "block_page"
+
 	// BlockingModeCustomIP means respond with a custom IP address.
 	BlockingModeCustomIP BlockingMode = "custom_ip"
 
@@ -217,6 +358,16 @@ type Stats struct {
 type filtersInitializerParams struct {
 	allowFilters []Filter
 	blockFilters []Filter
+
+	// canaryAllowFilters and canaryBlockFilters are the filter lists with the
+	// updates in the canary rollout, see [CanaryConfig].  They're nil if there
+	// are no such updates.
+	canaryAllowFilters []Filter
+	canaryBlockFilters []Filter
+
+	// monitorFilters are the monitor-only filter lists, see
+	// [FilterYAML.MonitorOnly].
+	monitorFilters []Filter
 }
 
 type hostChecker struct {
@@ -225,11 +376,48 @@ type hostChecker struct {
 }
 
 // Checker is used for safe browsing or parental control hash-prefix filtering.
+// It's the interface of the lookup providers, see [ProviderConfig].
 type Checker interface {
 	// Check returns true if request for the host should be blocked.
 	Check(host string) (block bool, err error)
 }
 
+// ProviderConfig is the configuration of the lookup provider of safe browsing
+// or parental control.  The zero value means the default AdGuard DNS
+// hash-prefix server.
+type ProviderConfig struct {
+	// URL is the address of the DNS server, which serves the hash-prefix TXT
+	// records, in the same format as the upstream servers.  If empty, the
+	// default server is used.
+	URL string `yaml:"url"`
+
+	// TXTSuffix is the domain name suffix of the hash-prefix TXT requests.  If
+	// empty, the default suffix of the service is used.
+	TXTSuffix string `yaml:"txt_suffix"`
+
+	// OfflineDatabase is the path to the file with the blocked hostnames or
+	// their hexadecimal-encoded SHA256 hashes, one per line.  If set, the hosts
+	// are checked against it and no requests are sent to the server.
+	OfflineDatabase string `yaml:"offline_database"`
+
+	// RootCAPath is the path to the file with the PEM-encoded certificates of
+	// the authorities used to verify the certificate of the server instead of
+	// the system ones.
+	RootCAPath string `yaml:"root_ca_path"`
+
+	// BootstrapDNS are the plain DNS servers used to resolve the hostname of
+	// the server in URL.  If empty, the system resolver is used.
+	BootstrapDNS []string `yaml:"bootstrap_dns"`
+
+	// CacheTTL is the time for which the results are cached.  If zero,
+	// [Config.CacheTime] is used.
+	CacheTTL timeutil.Duration `yaml:"cache_ttl"`
+
+	// InsecureSkipVerify, if true, disables the verification of the
+	// certificate of the server.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
 // DNSFilter matches hostnames and DNS requests against filtering rules.
 type DNSFilter struct {
 	// bufPool is a pool of buffers used for filtering-rule list parsing.
@@ -241,6 +429,31 @@ type DNSFilter struct {
 	rulesStorageAllow    *filterlist.RuleStorage
 	filteringEngineAllow *urlfilter.DNSEngine
 
+	// rulesStorageCanary and filteringEngineCanary, as well as their allowlist
+	// counterparts, contain the filter list updates in the canary rollout.
+	// They're nil if there are no such updates.
+	rulesStorageCanary    *filterlist.RuleStorage
+	filteringEngineCanary *urlfilter.DNSEngine
+
+	rulesStorageCanaryAllow    *filterlist.RuleStorage
+	filteringEngineCanaryAllow *urlfilter.DNSEngine
+
+	// rulesStorageMonitor and filteringEngineMonitor contain the monitor-only
+	// filter lists, the rules of which are matched and logged but not
+	// enforced.  They're nil if there are no such lists.
+	rulesStorageMonitor    *filterlist.RuleStorage
+	filteringEngineMonitor *urlfilter.DNSEngine
+
+	// canary matches the clients receiving the filter list updates in the
+	// canary rollout first.  It's nil if the canary rollout is disabled.
+	canary *canaryMatcher
+
+	// canaryStats are the block rates of the canary clients and the others.
+	canaryStats *canaryStats
+
+	// listStats are the statistics of the matches of the filter lists.
+	listStats *listStats
+
 	safeSearch SafeSearch
 
 	// safeBrowsingChecker is the safe browsing hash-prefix checker.
@@ -251,6 +464,11 @@ type DNSFilter struct {
 
 	engineLock sync.RWMutex
 
+	// decisions caches the results of matching the hosts against the
+	// filtering rules.  It's protected by engineLock from being used with the
+	// results of the previous engines.  It's nil if the cache is disabled.
+	decisions *decisionCache
+
 	// confMu protects conf.
 	confMu *sync.RWMutex
 
@@ -261,8 +479,31 @@ type DNSFilter struct {
 	filtersInitializerChan chan filtersInitializerParams
 	filtersInitializerLock sync.Mutex
 
+	// initMu serializes the initializations of the filtering engines, so that
+	// the compiled filter lists used by one of them aren't removed by another.
+	initMu sync.Mutex
+
 	refreshLock *sync.Mutex
 
+	// ipMatcher matches the IP addresses from the upstream responses against
+	// the IP blocklists.  It's nil if there are no loaded lists.
+	ipMatcher atomic.Pointer[ipMatcher]
+
+	// ipBlocklistsUpdated is the time of the last update of the remote IP
+	// blocklists.
+	ipBlocklistsUpdated time.Time
+
+	// categoryMatcher matches the hosts against the domain categorization
+	// database.  It's nil if the database isn't loaded.
+	categoryMatcher atomic.Pointer[categoryMatcher]
+
+	// categoriesMu serializes the updates of the categorization database.
+	categoriesMu sync.Mutex
+
+	// categoriesUpdated is the time of the last reload of the categorization
+	// database.
+	categoriesUpdated time.Time
+
 	hostCheckers []hostChecker
 }
 
@@ -276,6 +517,11 @@ type Filter struct {
 
 	// ID is automatically assigned when filter is added using nextFilterID.
 	ID int64 `yaml:"id"`
+
+	// rulesChecksum is the checksum of the rules of the list at FilePath, see
+	// [rulelist.ParseResult].  It's zero if the list shouldn't be compiled,
+	// see [Config.LazyLoading].
+	rulesChecksum uint32
 }
 
 // Reason holds an enum detailing why it was filtered or not filtered
@@ -322,6 +568,31 @@ const (
 	//
 	// See https://github.com/AdguardTeam/AdGuardHome/issues/2499.
 	RewrittenRule
+
+	// FilteredRebind is returned when the upstream response contained a
+	// private IP address and was rejected by the DNS rebinding protection.
+	FilteredRebind
+
+	// FilteredQueryType is returned when the request was blocked by a query
+	// type policy.
+	FilteredQueryType
+
+	// FilteredResponseIP is returned when the upstream response contained an
+	// IP address from an IP blocklist.
+	FilteredResponseIP
+
+	// NotFilteredMonitored is returned when the host would have been blocked
+	// by a monitor-only filter list, which isn't enforced.
+	NotFilteredMonitored
+
+	// FilteredCategory is returned when the host belongs to a blocked domain
+	// category.
+	FilteredCategory
+
+	// FilteredAllowlistOnly is returned when the host is blocked, because the
+	// client is in the allowlist-only mode and the host isn't explicitly
+	// allowed.
+	FilteredAllowlistOnly
 )
 
 // TODO(a.garipov): Resync with actual code names or replace completely
@@ -341,6 +612,15 @@ var reasonNames = []string{
 	Rewritten:          "Rewrite",
 	RewrittenAutoHosts: "RewriteEtcHosts",
 	RewrittenRule:      "RewriteRule",
+
+	FilteredRebind:     "FilteredRebind",
+	FilteredQueryType:  "FilteredQueryType",
+	FilteredResponseIP: "FilteredResponseIP",
+
+	NotFilteredMonitored: "NotFilteredMonitored",
+
+	FilteredCategory:      "FilteredCategory",
+	FilteredAllowlistOnly: "FilteredAllowlistOnly",
 }
 
 func (r Reason) String() string {
@@ -364,12 +644,18 @@ func (d *DNSFilter) Settings() (s *Settings) {
 	d.confMu.RLock()
 	defer d.confMu.RUnlock()
 
-	return &Settings{
+	s = &Settings{
 		FilteringEnabled:    atomic.LoadUint32(&d.conf.enabled) != 0,
 		SafeSearchEnabled:   d.conf.SafeSearchConf.Enabled,
 		SafeBrowsingEnabled: d.conf.SafeBrowsingEnabled,
 		ParentalEnabled:     d.conf.ParentalEnabled,
 	}
+
+	if c := d.conf.Categories; c != nil && c.Enabled {
+		s.BlockedCategories = c.Blocked
+	}
+
+	return s
 }
 
 // WriteDiskConfig - write configuration
@@ -388,6 +674,7 @@ func (d *DNSFilter) WriteDiskConfig(c *Config) {
 	c.Filters = slices.Clone(d.conf.Filters)
 	c.WhitelistFilters = slices.Clone(d.conf.WhitelistFilters)
 	c.UserRules = slices.Clone(d.conf.UserRules)
+	c.ManagedHosts = slices.Clone(d.conf.ManagedHosts)
 }
 
 // setFilters sets new filters, synchronously or asynchronously.  When filters
@@ -395,13 +682,8 @@ func (d *DNSFilter) WriteDiskConfig(c *Config) {
 // filters are ready.
 //
 // In this case the caller must ensure that the old filter files are intact.
-func (d *DNSFilter) setFilters(blockFilters, allowFilters []Filter, async bool) error {
+func (d *DNSFilter) setFilters(params *filtersInitializerParams, async bool) error {
 	if async {
-		params := filtersInitializerParams{
-			allowFilters: allowFilters,
-			blockFilters: blockFilters,
-		}
-
 		d.filtersInitializerLock.Lock()
 		defer d.filtersInitializerLock.Unlock()
 
@@ -416,19 +698,19 @@ func (d *DNSFilter) setFilters(blockFilters, allowFilters []Filter, async bool)
 			}
 		}
 
-		d.filtersInitializerChan <- params
+		d.filtersInitializerChan <- *params
 
 		return nil
 	}
 
-	return d.initFiltering(allowFilters, blockFilters)
+	return d.initFiltering(params)
 }
 
 // Starts initializing new filters by signal from channel
 func (d *DNSFilter) filtersInitializer() {
 	for {
 		params := <-d.filtersInitializerChan
-		err := d.initFiltering(params.allowFilters, params.blockFilters)
+		err := d.initFiltering(&params)
 		if err != nil {
 			log.Error("filtering: initializing: %s", err)
 
@@ -457,6 +739,18 @@ func (d *DNSFilter) reset() {
 			log.Error("filtering: rulesStorageAllow.Close: %s", err)
 		}
 	}
+
+	if d.rulesStorageMonitor != nil {
+		if err := d.rulesStorageMonitor.Close(); err != nil {
+			log.Error("filtering: rulesStorageMonitor.Close: %s", err)
+		}
+	}
+
+	d.rulesStorage, d.filteringEngine = nil, nil
+	d.rulesStorageAllow, d.filteringEngineAllow = nil, nil
+	d.rulesStorageMonitor, d.filteringEngineMonitor = nil, nil
+
+	d.resetCanary()
 }
 
 // ProtectionStatus returns the status of protection and time until it's
@@ -486,6 +780,16 @@ func (d *DNSFilter) SetProtectionEnabled(status bool) {
 	d.conf.ProtectionEnabled = status
 }
 
+// SetSafeBrowsingEnabled updates the status of the safe browsing.
+func (d *DNSFilter) SetSafeBrowsingEnabled(enabled bool) {
+	setProtectedBool(d.confMu, &d.conf.SafeBrowsingEnabled, enabled)
+}
+
+// SetParentalEnabled updates the status of the parental control.
+func (d *DNSFilter) SetParentalEnabled(enabled bool) {
+	setProtectedBool(d.confMu, &d.conf.ParentalEnabled, enabled)
+}
+
 // EtcHostsRecords returns the hosts records for the hostname.
 func (d *DNSFilter) EtcHostsRecords(hostname string) (recs []*hostsfile.Record) {
 	if d.conf.EtcHosts != nil {
@@ -501,7 +805,7 @@ func (d *DNSFilter) SetBlockingMode(mode BlockingMode, bIPv4, bIPv6 netip.Addr)
 	defer d.confMu.Unlock()
 
 	d.conf.BlockingMode = mode
-	if mode == BlockingModeCustomIP {
+	if mode == BlockingModeCustomIP || mode == BlockingModeBlockPage {
 		d.conf.BlockingIPv4 = bIPv4
 		d.conf.BlockingIPv6 = bIPv6
 	}
@@ -531,6 +835,15 @@ func (d *DNSFilter) BlockedResponseTTL() (ttl uint32) {
 	return d.conf.BlockedResponseTTL
 }
 
+// CNAMEChainFiltering returns true if every name in the CNAME chains of the
+// upstream responses should be checked against all the host checkers.
+func (d *DNSFilter) CNAMEChainFiltering() (ok bool) {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	return d.conf.CNAMEChainFiltering
+}
+
 // SafeBrowsingBlockHost returns a host for safe browsing blocked responses.
 func (d *DNSFilter) SafeBrowsingBlockHost() (host string) {
 	return d.conf.SafeBrowsingBlockHost
@@ -571,6 +884,11 @@ type Result struct {
 	// Reason is set to FilteredBlockedService.
 	ServiceName string `json:",omitempty"`
 
+	// CNAMEMatch is the name from the CNAME chain of the upstream response,
+	// which has triggered the filtering.  It is empty unless the response has
+	// been filtered because of its CNAME records.
+	CNAMEMatch string `json:",omitempty"`
+
 	// IPList is the lookup rewrite result.  It is empty unless Reason is set to
 	// Rewritten.
 	IPList []netip.Addr `json:",omitempty"`
@@ -578,6 +896,10 @@ type Result struct {
 	// Rules are applied rules.  If Rules are not empty, each rule is not nil.
 	Rules []*ResultRule `json:",omitempty"`
 
+	// Categories are the sorted domain categories of the host, if the
+	// categorization database is used.
+	Categories []string `json:",omitempty"`
+
 	// Reason is the reason for blocking or unblocking the request.
 	Reason Reason `json:",omitempty"`
 
@@ -593,11 +915,26 @@ func (r Reason) Matched() bool {
 
 // CheckHostRules tries to match the host against filtering rules only.
 func (d *DNSFilter) CheckHostRules(host string, rrtype uint16, setts *Settings) (Result, error) {
-	return d.matchHost(strings.ToLower(host), rrtype, setts)
+	return d.matchHost(aghnet.NormalizeDomain(host), rrtype, setts)
 }
 
 // CheckHost tries to match the host against filtering rules, then safebrowsing
 // and parental control rules, if they are enabled.
+//
+// If setts.AllowlistOnly is true, the host is blocked with the
+// FilteredAllowlistOnly reason unless it's matched by anything else.  That is,
+// the legacy rewrites, the operating system's hosts files, and the $dnsrewrite
+// rules are applied as usual, the allowlist rules allow the host, and the
+// blocklists, the blocked services, and the other features block it as usual.
+// The safe search doesn't allow the hosts, which aren't allowed explicitly.
+// The canonical names of the legacy rewrites are considered allowed.  The
+// allowlist-only mode is only used when both the protection and the filtering
+// are enabled.
+//
+// If setts.InternetPaused is true, the hosts except the ones from
+// setts.InternetPauseAllowedHosts are blocked with the FilteredAllowlistOnly
+// reason before any other checks, even if the protection is disabled.  The hosts
+// in setts.UnblockedHosts are never filtered.
 func (d *DNSFilter) CheckHost(
 	host string,
 	qtype uint16,
@@ -609,27 +946,108 @@ func (d *DNSFilter) CheckHost(
 		return Result{}, nil
 	}
 
-	host = strings.ToLower(host)
+	host = aghnet.NormalizeDomain(host)
+
+	defer func() {
+		if err == nil && res.IsFiltered && setts.isUnblocked(host) {
+			log.Debug("filtering: host %q is temporarily unblocked", host)
+
+			res = Result{}
+		}
+
+		if err == nil && res.Categories == nil {
+			res.Categories = d.Categories(host)
+		}
+	}()
+
+	if setts.isPaused(host) {
+		log.Debug("filtering: host %q is blocked, because internet access is paused", host)
+
+		return Result{
+			Reason:     FilteredAllowlistOnly,
+			IsFiltered: true,
+		}, nil
+	}
 
 	if setts.FilteringEnabled {
 		res = d.processRewrites(host, qtype)
 		if res.Reason == Rewritten {
-			return res, nil
+			return d.checkRewriteTarget(res, qtype, setts)
 		}
 	}
 
+	return d.checkHostCheckers(host, qtype, setts)
+}
+
+// checkRewriteTarget checks the canonical name of the legacy rewrite result res,
+// which is going to be resolved using the upstream servers, the same way the
+// original host is checked.  If the canonical name is filtered, the result of
+// that check is returned, otherwise res is returned as is.
+func (d *DNSFilter) checkRewriteTarget(
+	res Result,
+	qtype uint16,
+	setts *Settings,
+) (targetRes Result, err error) {
+	if res.CanonName == "" || len(res.IPList) > 0 || res.DNSRewriteResult != nil {
+		return res, nil
+	}
+
+	if setts.AllowlistOnly {
+		targetSetts := *setts
+		targetSetts.AllowlistOnly = false
+		setts = &targetSetts
+	}
+
+	targetRes, err = d.checkHostCheckers(res.CanonName, qtype, setts)
+	if err != nil {
+		return Result{}, fmt.Errorf("checking cname %q: %w", res.CanonName, err)
+	}
+
+	if targetRes.IsFiltered {
+		log.Debug("filtering: cname %q is filtered, reason: %q", res.CanonName, targetRes.Reason)
+
+		return targetRes, nil
+	}
+
+	return res, nil
+}
+
+// checkHostCheckers tries to match the host, which must be normalized, against
+// the host checkers of d.
+func (d *DNSFilter) checkHostCheckers(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, err error) {
+	// monitored is the result of matching the monitor-only filter lists, which
+	// must not prevent the other checkers from blocking the host.
+	var monitored Result
 	for _, hc := range d.hostCheckers {
 		res, err = hc.check(host, qtype, setts)
 		if err != nil {
 			return Result{}, fmt.Errorf("%s: %w", hc.name, err)
 		}
 
-		if res.Reason.Matched() {
+		if res.Reason == NotFilteredMonitored {
+			monitored = res
+		} else if res.Reason == FilteredSafeSearch && setts.AllowlistOnly {
+			// Don't let the safe search allow the host.
+			continue
+		} else if res.Reason.Matched() {
 			return res, nil
 		}
 	}
 
-	return Result{}, nil
+	if setts.AllowlistOnly && setts.ProtectionEnabled && setts.FilteringEnabled {
+		log.Debug("filtering: host %q isn't allowed in allowlist-only mode", host)
+
+		return Result{
+			Reason:     FilteredAllowlistOnly,
+			IsFiltered: true,
+		}, nil
+	}
+
+	return monitored, nil
 }
 
 // matchSysHosts tries to match the host against the operating system's hosts
@@ -692,7 +1110,9 @@ func (d *DNSFilter) matchSysHosts(
 //
 // Firstly, it finds CNAME rewrites for host.  If the CNAME is the same as host,
 // this query isn't filtered.  If it's different, repeat the process for the new
-// CNAME, breaking loops in the process.
+// CNAME, breaking loops in the process.  If the new CNAME itself is an
+// exception, the chase stops and the CNAME is resolved using the upstream
+// servers.
 //
 // Secondly, it finds A or AAAA rewrites for host and, if found, sets res.IPList
 // accordingly.  If the found rewrite has a special value of "A" or "AAAA", the
@@ -718,6 +1138,11 @@ func (d *DNSFilter) processRewrites(host string, qtype uint16) (res Result) {
 		log.Debug("rewrite: cname for %s is %s", host, rwAns)
 
 		if origHost == rwAns || rwPat == rwAns {
+			if res.CanonName != "" {
+				// The CNAME target is an exception, so resolve it as is.
+				break
+			}
+
 			// Either a request for the hostname itself or a rewrite of
 			// a pattern onto itself, both of which are an exception rules.
 			// Return a not filtered result.
@@ -802,7 +1227,7 @@ func newRuleStorage(filters []Filter) (rs *filterlist.RuleStorage, err error) {
 		case len(f.Data) != 0:
 			lists = append(lists, &filterlist.StringRuleList{
 				ID:             id,
-				RulesText:      string(f.Data),
+				RulesText:      rulelist.NormalizeRules(string(f.Data)),
 				IgnoreCosmetic: true,
 			})
 		case f.FilePath == "":
@@ -844,30 +1269,73 @@ func newRuleStorage(filters []Filter) (rs *filterlist.RuleStorage, err error) {
 	return rs, nil
 }
 
+// lazyGCPercent is the garbage collection target percentage used while the
+// filtering engines are initialized with [Config.LazyLoading] enabled.
+const lazyGCPercent = 20
+
 // Initialize urlfilter objects.
-func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err error) {
-	rulesStorage, err := newRuleStorage(blockFilters)
+func (d *DNSFilter) initFiltering(params *filtersInitializerParams) (err error) {
+	d.initMu.Lock()
+	defer d.initMu.Unlock()
+
+	if d.conf.LazyLoading {
+		// Collect the garbage more often, since the current engines are kept
+		// in memory until the new ones are ready, so that the heap doesn't
+		// grow to twice the size of both.
+		defer debug.SetGCPercent(debug.SetGCPercent(lazyGCPercent))
+
+		d.compileFilters(params)
+	}
+
+	rulesStorage, err := newRuleStorage(params.blockFilters)
 	if err != nil {
 		return err
 	}
 
-	rulesStorageAllow, err := newRuleStorage(allowFilters)
+	rulesStorageAllow, err := newRuleStorage(params.allowFilters)
 	if err != nil {
 		return err
 	}
 
+	var rulesStorageMonitor *filterlist.RuleStorage
+	var filteringEngineMonitor *urlfilter.DNSEngine
+	if len(params.monitorFilters) > 0 {
+		rulesStorageMonitor, err = newRuleStorage(params.monitorFilters)
+		if err != nil {
+			return fmt.Errorf("monitor: %w", err)
+		}
+
+		filteringEngineMonitor = urlfilter.NewDNSEngine(rulesStorageMonitor)
+	}
+
 	filteringEngine := urlfilter.NewDNSEngine(rulesStorage)
 	filteringEngineAllow := urlfilter.NewDNSEngine(rulesStorageAllow)
 
+	ce, err := newCanaryEngines(params)
+	if err != nil {
+		return fmt.Errorf("canary: %w", err)
+	}
+
 	func() {
 		d.engineLock.Lock()
 		defer d.engineLock.Unlock()
 
+		if ce != nil && d.filteringEngineCanary == nil {
+			d.canaryStats.reset()
+		}
+
 		d.reset()
 		d.rulesStorage = rulesStorage
 		d.filteringEngine = filteringEngine
 		d.rulesStorageAllow = rulesStorageAllow
 		d.filteringEngineAllow = filteringEngineAllow
+		d.rulesStorageMonitor = rulesStorageMonitor
+		d.filteringEngineMonitor = filteringEngineMonitor
+		d.setCanaryEngines(ce)
+
+		if d.decisions != nil {
+			d.decisions.clear()
+		}
 	}()
 
 	// Make sure that the OS reclaims memory as soon as possible.
@@ -981,7 +1449,42 @@ func (d *DNSFilter) matchHost(
 		return Result{}, nil
 	}
 
-	ufReq := &urlfilter.DNSRequest{
+	d.engineLock.RLock()
+	// Keep in mind that this lock must be held no just when calling Match() but
+	// also while using the rules returned by it.
+	//
+	// TODO(e.burkov):  Inspect if the above is true.
+	defer d.engineLock.RUnlock()
+
+	inCanary := d.filteringEngineCanary != nil
+	isCanary := inCanary && d.canary.match(setts)
+	if inCanary {
+		defer func() { d.canaryStats.count(isCanary, res.IsFiltered) }()
+	}
+
+	defer func() { d.listStats.count(host, &res) }()
+
+	if d.decisions == nil {
+		return d.matchHostLocked(host, rrtype, setts, isCanary)
+	}
+
+	key := decisionKey(host, rrtype, setts)
+	if res, ok := d.decisions.get(key); ok {
+		return res, nil
+	}
+
+	res, err = d.matchHostLocked(host, rrtype, setts, isCanary)
+	if err == nil {
+		d.decisions.set(key, res)
+	}
+
+	return res, err
+}
+
+// newDNSRequest returns a new urlfilter request for host and rrtype with the
+// client information from setts.
+func newDNSRequest(host string, rrtype uint16, setts *Settings) (ufReq *urlfilter.DNSRequest) {
+	return &urlfilter.DNSRequest{
 		Hostname:         host,
 		SortedClientTags: setts.ClientTags,
 		// TODO(e.burkov): Wait for urlfilter update to pass net.IP.
@@ -989,26 +1492,97 @@ func (d *DNSFilter) matchHost(
 		ClientName: setts.ClientName,
 		DNSType:    rrtype,
 	}
+}
 
-	d.engineLock.RLock()
-	// Keep in mind that this lock must be held no just when calling Match() but
-	// also while using the rules returned by it.
-	//
-	// TODO(e.burkov):  Inspect if the above is true.
-	defer d.engineLock.RUnlock()
+// matchHostLocked matches host against the filtering engines and, if none of
+// them has matched, against the monitor-only filter lists.  If isCanary is
+// true, the engines with the filter list updates in the canary rollout are
+// used.  d.engineLock is expected to be locked for reading.
+func (d *DNSFilter) matchHostLocked(
+	host string,
+	rrtype uint16,
+	setts *Settings,
+	isCanary bool,
+) (res Result, err error) {
+	res, err = d.matchEnginesLocked(host, rrtype, setts, isCanary)
+	if err != nil || res.Reason.Matched() || !setts.ProtectionEnabled {
+		return res, err
+	}
+
+	return d.matchMonitorLocked(host, rrtype, setts), nil
+}
+
+// matchMonitorLocked matches host against the monitor-only filter lists.  The
+// result is never filtered, but contains the rules, which would have blocked
+// the request.  d.engineLock is expected to be locked for reading.
+func (d *DNSFilter) matchMonitorLocked(host string, rrtype uint16, setts *Settings) (res Result) {
+	if d.filteringEngineMonitor == nil {
+		return Result{}
+	}
+
+	dnsres, ok := d.filteringEngineMonitor.MatchRequest(newDNSRequest(host, rrtype, setts))
+	if ok && len(setts.DisabledFilterLists) > 0 {
+		dnsres, ok = withoutLists(dnsres, setts.DisabledFilterLists)
+	}
+
+	if !ok {
+		return Result{}
+	}
+
+	res = d.matchHostProcessDNSResult(rrtype, dnsres)
+	if !res.IsFiltered {
+		return Result{}
+	}
+
+	for _, r := range res.Rules {
+		log.Debug(
+			"filtering: monitor-only rule %q for host %q, filter list id: %d",
+			r.Text,
+			host,
+			r.FilterListID,
+		)
+	}
+
+	res.Reason, res.IsFiltered = NotFilteredMonitored, false
+
+	return res
+}
+
+// matchEnginesLocked matches host against the filtering engines.  If isCanary
+// is true, the engines with the filter list updates in the canary rollout are
+// used.  d.engineLock is expected to be locked for reading.
+func (d *DNSFilter) matchEnginesLocked(
+	host string,
+	rrtype uint16,
+	setts *Settings,
+	isCanary bool,
+) (res Result, err error) {
+	ufReq := newDNSRequest(host, rrtype, setts)
+
+	engine, engineAllow := d.filteringEngine, d.filteringEngineAllow
+	if isCanary {
+		engine, engineAllow = d.filteringEngineCanary, d.filteringEngineCanaryAllow
+	}
+
+	if setts.ProtectionEnabled && engineAllow != nil {
+		dnsres, ok := engineAllow.MatchRequest(ufReq)
+		if ok && len(setts.DisabledFilterLists) > 0 {
+			dnsres, ok = withoutLists(dnsres, setts.DisabledFilterLists)
+		}
 
-	if setts.ProtectionEnabled && d.filteringEngineAllow != nil {
-		dnsres, ok := d.filteringEngineAllow.MatchRequest(ufReq)
 		if ok {
 			return d.matchHostProcessAllowList(host, dnsres)
 		}
 	}
 
-	if d.filteringEngine == nil {
+	if engine == nil {
 		return Result{}, nil
 	}
 
-	dnsres, matchedEngine := d.filteringEngine.MatchRequest(ufReq)
+	dnsres, matchedEngine := engine.MatchRequest(ufReq)
+	if len(setts.DisabledFilterLists) > 0 {
+		dnsres, matchedEngine = withoutLists(dnsres, setts.DisabledFilterLists)
+	}
 
 	// Check DNS rewrites first, because the API there is a bit awkward.
 	dnsRWRes := d.processDNSResultRewrites(dnsres, host)
@@ -1036,6 +1610,49 @@ func (d *DNSFilter) matchHost(
 	return res, nil
 }
 
+// withoutLists returns the copy of dnsres without the rules from the filter
+// lists with ids, which must be sorted.  matched has the same meaning as in
+// [urlfilter.DNSEngine.MatchRequest].
+//
+// NOTE: The engine doesn't match the hosts-style rules if a network rule has
+// matched, so these aren't returned even if the network rule is removed.
+func withoutLists(
+	dnsres *urlfilter.DNSResult,
+	ids []int64,
+) (res *urlfilter.DNSResult, matched bool) {
+	isEnabled := func(r rules.Rule) (ok bool) {
+		_, found := slices.BinarySearch(ids, int64(r.GetFilterListID()))
+
+		return !found
+	}
+
+	res = &urlfilter.DNSResult{}
+	for _, r := range dnsres.NetworkRules {
+		if isEnabled(r) {
+			res.NetworkRules = append(res.NetworkRules, r)
+		}
+	}
+
+	res.NetworkRule = rules.NewMatchingResult(res.NetworkRules, nil).GetBasicResult()
+	if res.NetworkRule != nil {
+		return res, true
+	}
+
+	for _, r := range dnsres.HostRulesV4 {
+		if isEnabled(r) {
+			res.HostRulesV4 = append(res.HostRulesV4, r)
+		}
+	}
+
+	for _, r := range dnsres.HostRulesV6 {
+		if isEnabled(r) {
+			res.HostRulesV6 = append(res.HostRulesV6, r)
+		}
+	}
+
+	return res, len(res.HostRulesV4) > 0 || len(res.HostRulesV6) > 0
+}
+
 // makeResult returns a properly constructed Result.
 func makeResult(matchedRules []rules.Rule, reason Reason) (res Result) {
 	resRules := make([]*ResultRule, len(matchedRules))
@@ -1067,6 +1684,9 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 		safeBrowsingChecker:    c.SafeBrowsingChecker,
 		parentalControlChecker: c.ParentalControlChecker,
 		confMu:                 &sync.RWMutex{},
+		decisions:              newDecisionCache(c.DecisionCacheSize),
+		canaryStats:            &canaryStats{},
+		listStats:              newListStats(),
 	}
 
 	d.safeSearch = c.SafeSearch
@@ -1080,6 +1700,9 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 	}, {
 		check: matchBlockedServicesRules,
 		name:  "blocked services",
+	}, {
+		check: d.checkCategories,
+		name:  "categories",
 	}, {
 		check: d.checkSafeBrowsing,
 		name:  "safe browsing",
@@ -1108,8 +1731,20 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 		}
 	}
 
+	d.canary, err = newCanaryMatcher(d.conf.Canary)
+	if err != nil {
+		return nil, fmt.Errorf("canary: %w", err)
+	}
+
+	if c := d.conf.Categories; c != nil {
+		err = ValidateCategories(c.Blocked)
+		if err != nil {
+			return nil, fmt.Errorf("categories: blocked: %w", err)
+		}
+	}
+
 	if blockFilters != nil {
-		err = d.initFiltering(nil, blockFilters)
+		err = d.initFiltering(&filtersInitializerParams{blockFilters: blockFilters})
 		if err != nil {
 			d.Close()
 
@@ -1121,6 +1756,10 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 
 	d.loadFilters(d.conf.Filters)
 	d.loadFilters(d.conf.WhitelistFilters)
+	d.loadIPBlocklists()
+	d.ipBlocklistsUpdated = time.Now()
+	d.loadCategories()
+	d.categoriesUpdated = time.Now()
 
 	d.conf.Filters = deduplicateFilters(d.conf.Filters)
 	d.conf.WhitelistFilters = deduplicateFilters(d.conf.WhitelistFilters)
@@ -516,6 +516,20 @@ func TestMatching(t *testing.T) {
 		wantIsFiltered: false,
 		wantReason:     NotFilteredAllowList,
 		wantDNSType:    dns.TypeAAAA,
+	}, {
+		name:           "idna",
+		rules:          "||пример.рф^",
+		host:           "www.xn--e1afmkfd.xn--p1ai",
+		wantIsFiltered: true,
+		wantReason:     FilteredBlockList,
+		wantDNSType:    dns.TypeA,
+	}, {
+		name:           "idna",
+		rules:          "||xn--e1afmkfd.xn--p1ai^",
+		host:           "www.Пример.рф",
+		wantIsFiltered: true,
+		wantReason:     FilteredBlockList,
+		wantDNSType:    dns.TypeA,
 	}}
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("%s-%s", tc.name, tc.host), func(t *testing.T) {
@@ -548,7 +562,10 @@ func TestWhitelist(t *testing.T) {
 	}}
 	d, setts := newForTest(t, nil, filters)
 
-	err := d.setFilters(filters, whiteFilters, false)
+	err := d.setFilters(&filtersInitializerParams{
+		allowFilters: whiteFilters,
+		blockFilters: filters,
+	}, false)
 	require.NoError(t, err)
 
 	t.Cleanup(d.Close)
@@ -576,6 +593,397 @@ func TestWhitelist(t *testing.T) {
 	assert.Equal(t, "||host2^", res.Rules[0].Text)
 }
 
+func TestDNSFilter_lazyLoading(t *testing.T) {
+	d, setts := newForTest(t, &Config{
+		DecisionCacheSize: 100,
+		LazyLoading:       true,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
+	go d.filtersInitializer()
+
+	blockFilters := []Filter{{
+		ID: 1, Data: []byte("||blocked.example^\n"),
+	}}
+
+	err := d.setFilters(&filtersInitializerParams{blockFilters: blockFilters}, true)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() (ok bool) {
+		res, cErr := d.CheckHost("blocked.example", dns.TypeA, setts)
+		require.NoError(t, cErr)
+
+		return res.IsFiltered
+	}, testTimeout, testTimeout/100)
+
+	// The reloads keep the current engines until the new ones are ready.
+	reloadFilters := []Filter{{
+		ID: 1, Data: []byte("||blocked.example^\n||other.example^\n"),
+	}}
+
+	err = d.setFilters(&filtersInitializerParams{blockFilters: reloadFilters}, true)
+	require.NoError(t, err)
+
+	res, err := d.CheckHost("blocked.example", dns.TypeA, setts)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+
+	require.Eventually(t, func() (ok bool) {
+		res, cErr := d.CheckHost("other.example", dns.TypeA, setts)
+		require.NoError(t, cErr)
+
+		return res.IsFiltered
+	}, testTimeout, testTimeout/100)
+}
+
+func TestDNSFilter_CheckHost_monitorOnly(t *testing.T) {
+	const monitorListID = 2
+
+	d, setts := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.initFiltering(&filtersInitializerParams{
+		blockFilters: []Filter{{
+			ID: 1, Data: []byte("||blocked.example^\n@@||allowed.example^\n"),
+		}},
+		monitorFilters: []Filter{{
+			ID: monitorListID, Data: []byte(
+				"||monitored.example^\n||blocked.example^\n||allowed.example^\n",
+			),
+		}},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantReason Reason
+		wantRule   string
+		protection bool
+	}{{
+		name:       "monitored",
+		host:       "monitored.example",
+		wantReason: NotFilteredMonitored,
+		wantRule:   "||monitored.example^",
+		protection: true,
+	}, {
+		name:       "blocked",
+		host:       "blocked.example",
+		wantReason: FilteredBlockList,
+		wantRule:   "||blocked.example^",
+		protection: true,
+	}, {
+		name:       "allowed",
+		host:       "allowed.example",
+		wantReason: NotFilteredAllowList,
+		wantRule:   "@@||allowed.example^",
+		protection: true,
+	}, {
+		name:       "not_found",
+		host:       "other.example",
+		wantReason: NotFilteredNotFound,
+		wantRule:   "",
+		protection: true,
+	}, {
+		name:       "protection_disabled",
+		host:       "monitored.example",
+		wantReason: NotFilteredNotFound,
+		wantRule:   "",
+		protection: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := *setts
+			s.ProtectionEnabled = tc.protection
+
+			res, cErr := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantReason == FilteredBlockList, res.IsFiltered)
+
+			if tc.wantRule == "" {
+				assert.Empty(t, res.Rules)
+
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+		})
+	}
+
+	res, err := d.CheckHost("monitored.example", dns.TypeA, &Settings{
+		ProtectionEnabled:   true,
+		FilteringEnabled:    true,
+		DisabledFilterLists: []int64{monitorListID},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, NotFilteredNotFound, res.Reason)
+}
+
+func TestDNSFilter_CheckHost_disabledFilterLists(t *testing.T) {
+	d, setts := newForTest(t, &Config{DecisionCacheSize: 100}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.setFilters(&filtersInitializerParams{
+		allowFilters: []Filter{{
+			ID: 3, Data: []byte("||allowed.example^\n"),
+		}},
+		blockFilters: []Filter{{
+			ID: 1, Data: []byte("||blocked.example^\n||allowed.example^\n"),
+		}, {
+			ID: 2, Data: []byte("0.0.0.0 blocked.example\n"),
+		}},
+	}, false)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantReason Reason
+		disabled   []int64
+		wantListID int64
+	}{{
+		name:       "all_enabled",
+		host:       "blocked.example",
+		wantReason: FilteredBlockList,
+		disabled:   nil,
+		wantListID: 1,
+	}, {
+		name:       "network_rule_disabled",
+		host:       "blocked.example",
+		wantReason: NotFilteredNotFound,
+		disabled:   []int64{1},
+		wantListID: 0,
+	}, {
+		name:       "all_disabled",
+		host:       "blocked.example",
+		wantReason: NotFilteredNotFound,
+		disabled:   []int64{1, 2},
+		wantListID: 0,
+	}, {
+		name:       "allowlist_enabled",
+		host:       "allowed.example",
+		wantReason: NotFilteredAllowList,
+		disabled:   nil,
+		wantListID: 3,
+	}, {
+		name:       "allowlist_disabled",
+		host:       "allowed.example",
+		wantReason: FilteredBlockList,
+		disabled:   []int64{3},
+		wantListID: 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := *setts
+			s.DisabledFilterLists = tc.disabled
+
+			res, cErr := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantListID == 0 {
+				assert.Empty(t, res.Rules)
+
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantListID, res.Rules[0].FilterListID)
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_allowlistOnly(t *testing.T) {
+	d, setts := newForTest(t, &Config{
+		Rewrites: []*LegacyRewrite{{
+			Domain: "rewritten.example",
+			Answer: "192.0.2.1",
+		}, {
+			Domain: "cname.example",
+			Answer: "target.example",
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.setFilters(&filtersInitializerParams{
+		allowFilters: []Filter{{
+			ID: 2, Data: []byte("||allowed.example^\n"),
+		}},
+		blockFilters: []Filter{{
+			ID: 1, Data: []byte("||blocked.example^\n@@||exception.example^\n"),
+		}},
+	}, false)
+	require.NoError(t, err)
+
+	setts.AllowlistOnly = true
+	svcRule, err := rules.NewNetworkRule("||service.example^", BlockedSvcsListID)
+	require.NoError(t, err)
+
+	allowedSvcRule, err := rules.NewNetworkRule("||service.allowed.example^", BlockedSvcsListID)
+	require.NoError(t, err)
+
+	setts.ServicesRules = []ServiceEntry{{
+		Name:  "service",
+		Rules: []*rules.NetworkRule{svcRule, allowedSvcRule},
+	}}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantReason Reason
+	}{{
+		name:       "allowlist",
+		host:       "allowed.example",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "exception_rule",
+		host:       "exception.example",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "blocklist",
+		host:       "blocked.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "blocked_service",
+		host:       "service.example",
+		wantReason: FilteredBlockedService,
+	}, {
+		name:       "allowed_blocked_service",
+		host:       "service.allowed.example",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "rewrite",
+		host:       "rewritten.example",
+		wantReason: Rewritten,
+	}, {
+		name:       "rewrite_cname",
+		host:       "cname.example",
+		wantReason: Rewritten,
+	}, {
+		name:       "not_allowed",
+		host:       "other.example",
+		wantReason: FilteredAllowlistOnly,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cErr := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+		})
+	}
+
+	t.Run("filtering_disabled", func(t *testing.T) {
+		s := *setts
+		s.FilteringEnabled = false
+
+		res, cErr := d.CheckHost("other.example", dns.TypeA, &s)
+		require.NoError(t, cErr)
+
+		assert.False(t, res.IsFiltered)
+	})
+}
+
+func TestDNSFilter_CheckHost_unblockedHosts(t *testing.T) {
+	d, setts := newForTest(t, nil, []Filter{{
+		ID: 0, Data: []byte("||blocked.example^\n||other.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	setts.UnblockedHosts = []string{"blocked.example"}
+
+	testCases := []struct {
+		name        string
+		host        string
+		wantBlocked bool
+	}{{
+		name:        "unblocked",
+		host:        "blocked.example",
+		wantBlocked: false,
+	}, {
+		name:        "unblocked_subdomain",
+		host:        "www.Blocked.example",
+		wantBlocked: false,
+	}, {
+		name:        "blocked",
+		host:        "other.example",
+		wantBlocked: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantBlocked, res.IsFiltered)
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_internetPaused(t *testing.T) {
+	d, setts := newForTest(t, nil, []Filter{{
+		ID: 0, Data: []byte("||blocked.example^\n@@||allowed.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	setts.ProtectionEnabled = false
+	setts.InternetPaused = true
+	setts.InternetPauseAllowedHosts = []string{"school.example", "blocked.example"}
+
+	testCases := []struct {
+		name        string
+		host        string
+		wantReason  Reason
+		wantBlocked bool
+	}{{
+		name:        "paused",
+		host:        "other.example",
+		wantReason:  FilteredAllowlistOnly,
+		wantBlocked: true,
+	}, {
+		name:        "paused_allowlisted",
+		host:        "allowed.example",
+		wantReason:  FilteredAllowlistOnly,
+		wantBlocked: true,
+	}, {
+		name:        "allowed",
+		host:        "school.example",
+		wantReason:  NotFilteredNotFound,
+		wantBlocked: false,
+	}, {
+		name:        "allowed_subdomain",
+		host:        "www.School.example",
+		wantReason:  NotFilteredNotFound,
+		wantBlocked: false,
+	}, {
+		name:        "allowed_protection_disabled",
+		host:        "blocked.example",
+		wantReason:  NotFilteredNotFound,
+		wantBlocked: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantBlocked, res.IsFiltered)
+			assert.Equal(t, tc.wantReason, res.Reason)
+		})
+	}
+}
+
 // Client Settings.
 
 func applyClientSettings(setts *Settings) {
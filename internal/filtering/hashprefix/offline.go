@@ -0,0 +1,110 @@
+package hashprefix
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// OfflineChecker checks the hosts against a local database, which makes it
+// usable in the networks without access to the hash-prefix servers.
+type OfflineChecker struct {
+	// hashes are the hashes of the blocked hostnames.
+	hashes map[hostnameHash]struct{}
+
+	// svc is the name of the service.
+	svc string
+}
+
+// NewOffline returns a new OfflineChecker with the database read from the file
+// at path.  Each non-empty line of the file, which isn't a comment starting
+// with '#', must be either a hostname or a hexadecimal-encoded SHA256 hash of
+// one.  Just like with the hash-prefix servers, the subdomains of the blocked
+// hostnames are blocked as well.
+func NewOffline(svc, path string) (c *OfflineChecker, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	c = &OfflineChecker{
+		hashes: map[hostnameHash]struct{}{},
+		svc:    svc,
+	}
+
+	err = c.read(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", path, err)
+	}
+
+	log.Info("%s: loaded %d hashes from %q", svc, len(c.hashes), path)
+
+	return c, nil
+}
+
+// read reads the database from r.
+func (c *OfflineChecker) read(r io.Reader) (err error) {
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var hash hostnameHash
+		hash, err = parseDatabaseLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		c.hashes[hash] = struct{}{}
+	}
+
+	return s.Err()
+}
+
+// parseDatabaseLine returns the hash of the hostname from the line of the
+// offline database.
+func parseDatabaseLine(line string) (hash hostnameHash, err error) {
+	if len(line) == hexSize {
+		var buf []byte
+		buf, err = hex.DecodeString(line)
+		if err == nil {
+			copy(hash[:], buf)
+
+			return hash, nil
+		}
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(line, "."))
+	err = netutil.ValidateHostname(host)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return hash, err
+	}
+
+	return sha256.Sum256([]byte(host)), nil
+}
+
+// Check returns true if request for the host should be blocked.
+func (c *OfflineChecker) Check(host string) (ok bool, err error) {
+	for _, hash := range hostnameToHashes(host) {
+		if _, ok = c.hashes[hash]; ok {
+			log.Debug("%s: matched %s", c.svc, host)
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
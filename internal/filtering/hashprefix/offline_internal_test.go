@@ -0,0 +1,70 @@
+package hashprefix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineChecker_Check(t *testing.T) {
+	hash := sha256.Sum256([]byte("hashed.example"))
+	data := "# Comment.\n\nBlocked.Example.\n" + hex.EncodeToString(hash[:]) + "\n"
+
+	path := filepath.Join(t.TempDir(), "db.txt")
+	err := os.WriteFile(path, []byte(data), 0o600)
+	require.NoError(t, err)
+
+	c, err := NewOffline("test", path)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		host string
+		want bool
+	}{{
+		name: "host",
+		host: "blocked.example",
+		want: true,
+	}, {
+		name: "subdomain",
+		host: "sub.blocked.example",
+		want: true,
+	}, {
+		name: "hash",
+		host: "www.hashed.example",
+		want: true,
+	}, {
+		name: "other",
+		host: "other.example",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, cErr := c.Check(tc.host)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.want, ok)
+		})
+	}
+}
+
+func TestNewOffline_bad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.txt")
+	err := os.WriteFile(path, []byte("good.example\nbad host\n"), 0o600)
+	require.NoError(t, err)
+
+	_, err = NewOffline("test", path)
+	testutil.AssertErrorMsg(
+		t,
+		`reading "`+path+`": line 2: bad hostname "bad host": `+
+			`bad top-level domain name label "bad host": bad top-level domain name label rune ' '`,
+		err,
+	)
+}
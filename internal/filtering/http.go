@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -36,21 +37,40 @@ func validateFilterURL(urlStr string) (err error) {
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return err
-	} else if s := u.Scheme; s != aghhttp.SchemeHTTP && s != aghhttp.SchemeHTTPS {
+	}
+
+	switch s := u.Scheme; s {
+	case aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS:
+		return nil
+	case schemeAXFR, schemeIXFR:
+		if strings.Trim(u.Path, "/") == "" {
+			return &url.Error{
+				Op:  "Check zone",
+				URL: urlStr,
+				Err: errors.Error("no zone name in url path"),
+			}
+		}
+
+		return nil
+	default:
 		return &url.Error{
 			Op:  "Check scheme",
 			URL: urlStr,
-			Err: fmt.Errorf("only %v allowed", []string{aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS}),
+			Err: fmt.Errorf("only %v allowed", []string{
+				aghhttp.SchemeHTTP,
+				aghhttp.SchemeHTTPS,
+				schemeAXFR,
+				schemeIXFR,
+			}),
 		}
 	}
-
-	return nil
 }
 
 type filterAddJSON struct {
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	Whitelist bool   `json:"whitelist"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Whitelist   bool   `json:"whitelist"`
+	MonitorOnly bool   `json:"monitor_only"`
 }
 
 func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
@@ -69,6 +89,12 @@ func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if fj.Whitelist && fj.MonitorOnly {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", errMonitorAllowlist)
+
+		return
+	}
+
 	// Check for duplicates
 	if d.filterExists(fj.URL) {
 		err = errFilterExists
@@ -79,10 +105,11 @@ func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 
 	// Set necessary properties
 	filt := FilterYAML{
-		Enabled: true,
-		URL:     fj.URL,
-		Name:    fj.Name,
-		white:   fj.Whitelist,
+		Enabled:     true,
+		URL:         fj.URL,
+		Name:        fj.Name,
+		white:       fj.Whitelist,
+		MonitorOnly: fj.MonitorOnly,
 		Filter: Filter{
 			ID: assignUniqueFilterID(),
 		},
@@ -175,7 +202,14 @@ func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		cp := deleted.canaryPath(d.conf.DataDir)
+		err = os.Remove(cp)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("deleting filter %d: removing canary file %q: %s", deleted.ID, cp, err)
+		}
+
 		*filters = slices.Delete(*filters, delIdx, delIdx+1)
+		d.listStats.remove(deleted.ID)
 
 		log.Info("deleted filter %d", deleted.ID)
 	}()
@@ -196,9 +230,10 @@ func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 }
 
 type filterURLReqData struct {
-	Name    string `json:"name"`
-	URL     string `json:"url"`
-	Enabled bool   `json:"enabled"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Enabled     bool   `json:"enabled"`
+	MonitorOnly bool   `json:"monitor_only"`
 }
 
 type filterURLReq struct {
@@ -230,9 +265,10 @@ func (d *DNSFilter) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 	}
 
 	filt := FilterYAML{
-		Enabled: fj.Data.Enabled,
-		Name:    fj.Data.Name,
-		URL:     fj.Data.URL,
+		Enabled:     fj.Data.Enabled,
+		Name:        fj.Data.Name,
+		URL:         fj.Data.URL,
+		MonitorOnly: fj.Data.MonitorOnly,
 	}
 
 	restart, err := d.filterSetProperties(fj.URL, filt, fj.Whitelist)
@@ -250,6 +286,10 @@ func (d *DNSFilter) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 
 // filteringRulesReq is the JSON structure for settings custom filtering rules.
 type filteringRulesReq struct {
+	// Reason is the reason for adding the new rules, which is recorded into
+	// their provenance.
+	Reason string `json:"reason,omitempty"`
+
 	Rules []string `json:"rules"`
 }
 
@@ -266,6 +306,14 @@ func (d *DNSFilter) handleFilteringSetRules(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	p := d.newProvenance(r, req.Reason)
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		d.conf.UserRulesProvenance = updatedProvenance(d.conf.UserRulesProvenance, req.Rules, p)
+	}()
+
 	d.conf.UserRules = req.Rules
 	d.conf.ConfigModified()
 	d.EnableFilters(true)
@@ -311,6 +359,15 @@ type filterJSON struct {
 	ID          int64  `json:"id"`
 	RulesCount  uint32 `json:"rules_count"`
 	Enabled     bool   `json:"enabled"`
+	MonitorOnly bool   `json:"monitor_only"`
+
+	// LastMatches are the most recent matches of the rules from the list,
+	// the newest first.
+	LastMatches []*listMatchJSON `json:"last_matches,omitempty"`
+
+	// HitsCount is the number of queries, filtering results of which have
+	// been determined by the rules from the list, since the start.
+	HitsCount uint64 `json:"hits_count"`
 }
 
 type filteringConfig struct {
@@ -323,11 +380,12 @@ type filteringConfig struct {
 
 func filterToJSON(f FilterYAML) filterJSON {
 	fj := filterJSON{
-		ID:         f.ID,
-		Enabled:    f.Enabled,
-		URL:        f.URL,
-		Name:       f.Name,
-		RulesCount: uint32(f.RulesCount),
+		ID:          f.ID,
+		Enabled:     f.Enabled,
+		URL:         f.URL,
+		Name:        f.Name,
+		RulesCount:  uint32(f.RulesCount),
+		MonitorOnly: f.MonitorOnly,
 	}
 
 	if !f.LastUpdated.IsZero() {
@@ -345,10 +403,12 @@ func (d *DNSFilter) handleFilteringStatus(w http.ResponseWriter, r *http.Request
 	resp.Interval = d.conf.FiltersUpdateIntervalHours
 	for _, f := range d.conf.Filters {
 		fj := filterToJSON(f)
+		d.listStats.fillJSON(&fj)
 		resp.Filters = append(resp.Filters, fj)
 	}
 	for _, f := range d.conf.WhitelistFilters {
 		fj := filterToJSON(f)
+		d.listStats.fillJSON(&fj)
 		resp.WhitelistFilters = append(resp.WhitelistFilters, fj)
 	}
 	resp.UserRules = d.conf.UserRules
@@ -435,8 +495,12 @@ func (d *DNSFilter) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rulesLen := len(result.Rules)
-	resp := checkHostResp{
+	aghhttp.WriteJSONResponseOK(w, r, newCheckHostResp(result))
+}
+
+// newCheckHostResp returns the HTTP API representation of result.
+func newCheckHostResp(result Result) (resp *checkHostResp) {
+	resp = &checkHostResp{
 		Reason:    result.Reason.String(),
 		SvcName:   result.ServiceName,
 		CanonName: result.CanonName,
@@ -444,7 +508,7 @@ func (d *DNSFilter) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 		Rules:     make([]*checkHostRespRule, len(result.Rules)),
 	}
 
-	if rulesLen > 0 {
+	if len(result.Rules) > 0 {
 		resp.FilterID = result.Rules[0].FilterListID
 		resp.Rule = result.Rules[0].Text
 	}
@@ -456,6 +520,164 @@ func (d *DNSFilter) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	return resp
+}
+
+// maxCheckHostsRequests is the maximum number of the requests in a single
+// batch checked by the POST /control/filtering/check_hosts HTTP API.
+const maxCheckHostsRequests = 1_000
+
+// checkHostsReq is the request for the POST /control/filtering/check_hosts
+// HTTP API.
+type checkHostsReq struct {
+	Requests []*checkHostsReqItem `json:"requests"`
+}
+
+// checkHostsReqItem is a single request to check.
+type checkHostsReqItem struct {
+	// Name is the domain name to check.
+	Name string `json:"name"`
+
+	// Client is the IP address or the name of the client making the request.
+	// It's used to match the rules with the $client modifier.
+	Client string `json:"client"`
+
+	// QType is the type of the request, for example "AAAA".  The default is
+	// "A".
+	QType string `json:"qtype"`
+
+	// ClientTags are the tags of the client used to match the rules with the
+	// $ctag modifier.
+	ClientTags []string `json:"client_tags"`
+}
+
+// settings returns the filtering settings for the request based on the global
+// settings setts.
+func (item *checkHostsReqItem) settings(setts *Settings) (res *Settings) {
+	res = &Settings{}
+	*res = *setts
+
+	if ip, err := netip.ParseAddr(item.Client); err == nil {
+		res.ClientIP = ip
+	} else {
+		res.ClientName = item.Client
+	}
+
+	res.ClientTags = slices.Clone(item.ClientTags)
+	slices.Sort(res.ClientTags)
+
+	return res
+}
+
+// qtype returns the DNS type of the request.
+func (item *checkHostsReqItem) qtype() (qt uint16, err error) {
+	if item.QType == "" {
+		return dns.TypeA, nil
+	}
+
+	qt, ok := dns.StringToType[strings.ToUpper(item.QType)]
+	if !ok {
+		return 0, fmt.Errorf("bad qtype %q", item.QType)
+	}
+
+	return qt, nil
+}
+
+// checkHostsResp is the response for the POST /control/filtering/check_hosts
+// HTTP API.
+type checkHostsResp struct {
+	Results []*checkHostsRespItem `json:"results"`
+}
+
+// checkHostsRespItem is the result of checking a single request.
+type checkHostsRespItem struct {
+	// Result is the result of the check in the same format as in the GET
+	// /control/filtering/check_host HTTP API.
+	Result *checkHostResp `json:"result"`
+
+	// Name is the checked domain name.
+	Name string `json:"name"`
+
+	// Client is the client from the request.
+	Client string `json:"client"`
+
+	// QType is the type of the request.
+	QType string `json:"qtype"`
+}
+
+// handleCheckHosts is the handler for the POST /control/filtering/check_hosts
+// HTTP API.  It checks each of the requests against the current filtering
+// engines, so that the maintainers of the rules could test them.
+func (d *DNSFilter) handleCheckHosts(w http.ResponseWriter, r *http.Request) {
+	req := &checkHostsReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	if l := len(req.Requests); l > maxCheckHostsRequests {
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusBadRequest,
+			"too many requests: got %d, max %d",
+			l,
+			maxCheckHostsRequests,
+		)
+
+		return
+	}
+
+	setts := d.Settings()
+	setts.FilteringEnabled = true
+	setts.ProtectionEnabled = true
+
+	d.ApplyBlockedServices(setts)
+
+	resp := &checkHostsResp{
+		Results: make([]*checkHostsRespItem, 0, len(req.Requests)),
+	}
+
+	for i, item := range req.Requests {
+		if item == nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "request at index %d: no value", i)
+
+			return
+		}
+
+		var qt uint16
+		qt, err = item.qtype()
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "request at index %d: %s", i, err)
+
+			return
+		}
+
+		var result Result
+		result, err = d.CheckHost(item.Name, qt, item.settings(setts))
+		if err != nil {
+			aghhttp.Error(
+				r,
+				w,
+				http.StatusInternalServerError,
+				"couldn't apply filtering: %s: %s",
+				item.Name,
+				err,
+			)
+
+			return
+		}
+
+		resp.Results = append(resp.Results, &checkHostsRespItem{
+			Result: newCheckHostResp(result),
+			Name:   item.Name,
+			Client: item.Client,
+			QType:  dns.TypeToString[qt],
+		})
+	}
+
 	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
@@ -567,6 +789,12 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 
 	registerHTTP(http.MethodGet, "/control/blocked_services/get", d.handleBlockedServicesGet)
 	registerHTTP(http.MethodPut, "/control/blocked_services/update", d.handleBlockedServicesUpdate)
+	registerHTTP(http.MethodGet, "/control/blocked_services/custom", d.handleCustomServicesGet)
+	registerHTTP(
+		http.MethodPut,
+		"/control/blocked_services/custom/update",
+		d.handleCustomServicesUpdate,
+	)
 
 	registerHTTP(http.MethodGet, "/control/filtering/status", d.handleFilteringStatus)
 	registerHTTP(http.MethodPost, "/control/filtering/config", d.handleFilteringConfig)
@@ -575,7 +803,25 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPost, "/control/filtering/set_url", d.handleFilteringSetURL)
 	registerHTTP(http.MethodPost, "/control/filtering/refresh", d.handleFilteringRefresh)
 	registerHTTP(http.MethodPost, "/control/filtering/set_rules", d.handleFilteringSetRules)
+	registerHTTP(http.MethodGet, "/control/filtering/provenance", d.handleProvenance)
 	registerHTTP(http.MethodGet, "/control/filtering/check_host", d.handleCheckHost)
+	registerHTTP(http.MethodPost, "/control/filtering/check_hosts", d.handleCheckHosts)
+	registerHTTP(http.MethodGet, "/control/filtering/trace", d.handleTrace)
+	registerHTTP(http.MethodGet, "/control/filtering/export", d.handleExport)
+	registerHTTP(http.MethodPost, "/control/filtering/import", d.handleImport)
+
+	registerHTTP(http.MethodGet, "/control/filtering/hosts", d.handleManagedHostsList)
+	registerHTTP(http.MethodPost, "/control/filtering/hosts/add", d.handleManagedHostsAdd)
+	registerHTTP(http.MethodPost, "/control/filtering/hosts/remove", d.handleManagedHostsRemove)
+	registerHTTP(http.MethodPost, "/control/filtering/hosts/import", d.handleManagedHostsImport)
+	registerHTTP(http.MethodPost, "/control/filtering/hosts/validate", d.handleManagedHostsValidate)
+
+	registerHTTP(http.MethodGet, "/control/filtering/categories", d.handleCategoriesStatus)
+	registerHTTP(http.MethodPut, "/control/filtering/categories/config", d.handleCategoriesConfig)
+
+	registerHTTP(http.MethodGet, "/control/filtering/canary", d.handleCanaryStatus)
+	registerHTTP(http.MethodPost, "/control/filtering/canary/promote", d.handleCanaryPromote)
+	registerHTTP(http.MethodPost, "/control/filtering/canary/rollback", d.handleCanaryRollback)
 }
 
 // ValidateUpdateIvl returns false if i is not a valid filters update interval.
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -305,3 +306,80 @@ func TestDNSFilter_handleParentalStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestDNSFilter_handleCheckHosts(t *testing.T) {
+	filters := []Filter{{
+		ID: 0, Data: []byte("||blocked.example^\n" +
+			"||client.example^$client=1.2.3.4\n" +
+			"||aaaa.example^$dnstype=AAAA\n" +
+			"||tag.example^$ctag=device_pc\n"),
+	}}
+
+	d, _ := newForTest(t, &Config{
+		BlockedServices: &BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+		},
+		FilteringEnabled: true,
+	}, filters)
+	t.Cleanup(d.Close)
+
+	reqData, err := json.Marshal(&checkHostsReq{
+		Requests: []*checkHostsReqItem{{
+			Name: "blocked.example",
+		}, {
+			Name:   "client.example",
+			Client: "1.2.3.4",
+		}, {
+			Name:   "client.example",
+			Client: "5.6.7.8",
+		}, {
+			Name:  "aaaa.example",
+			QType: "aaaa",
+		}, {
+			Name: "aaaa.example",
+		}, {
+			Name:       "tag.example",
+			ClientTags: []string{"device_pc"},
+		}},
+	})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/control/filtering/check_hosts", bytes.NewReader(reqData))
+	w := httptest.NewRecorder()
+
+	d.handleCheckHosts(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &checkHostsResp{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	wantReasons := []string{
+		FilteredBlockList.String(),
+		FilteredBlockList.String(),
+		NotFilteredNotFound.String(),
+		FilteredBlockList.String(),
+		NotFilteredNotFound.String(),
+		FilteredBlockList.String(),
+	}
+
+	require.Len(t, resp.Results, len(wantReasons))
+	for i, res := range resp.Results {
+		assert.Equalf(t, wantReasons[i], res.Result.Reason, "result at index %d", i)
+	}
+
+	assert.Equal(t, "AAAA", resp.Results[3].QType)
+	assert.Equal(t, "1.2.3.4", resp.Results[1].Client)
+
+	t.Run("bad_qtype", func(t *testing.T) {
+		r = httptest.NewRequest(
+			http.MethodPost,
+			"/control/filtering/check_hosts",
+			bytes.NewReader([]byte(`{"requests":[{"name":"a.example","qtype":"BAD"}]}`)),
+		)
+		w = httptest.NewRecorder()
+
+		d.handleCheckHosts(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
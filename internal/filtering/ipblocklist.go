@@ -0,0 +1,305 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// IPBlocklist is a list of IP addresses and CIDR subnets, for example a threat
+// intelligence feed.  The upstream responses containing A or AAAA records with
+// these addresses are blocked.
+type IPBlocklist struct {
+	// Name is the human-readable name of the list.
+	Name string `yaml:"name"`
+
+	// URL is either the HTTP(S) URL of the list or the absolute path to a local
+	// file.  Each line of the list contains an IP address or a CIDR subnet,
+	// optionally followed by a comment starting with "#" or ";".
+	URL string `yaml:"url"`
+
+	// Enabled defines if the list is used.
+	Enabled bool `yaml:"enabled"`
+}
+
+// isLocal returns true if the list is a local file.
+func (l *IPBlocklist) isLocal() (ok bool) {
+	return filepath.IsAbs(l.URL)
+}
+
+// path returns the path to the file with the contents of the list.  For remote
+// lists, it's the file with the downloaded contents in dataDir.
+func (l *IPBlocklist) path(dataDir string) (p string) {
+	if l.isLocal() {
+		return l.URL
+	}
+
+	name := fmt.Sprintf("ip_%08x.txt", crc32.ChecksumIEEE([]byte(l.URL)))
+
+	return filepath.Join(dataDir, filterDir, name)
+}
+
+// maxIPBlocklistSize is the maximum size of a downloaded IP blocklist.
+const maxIPBlocklistSize = 64 * 1024 * 1024
+
+// ipMatcher matches IP addresses against the loaded IP blocklists.
+type ipMatcher struct {
+	// subnets maps the prefix lengths to the masked subnets with those lengths
+	// and the names of their lists.  Single addresses are stored as the
+	// subnets with the full lengths.
+	subnets map[int]map[netip.Prefix]string
+
+	// bits are the prefix lengths present in subnets, longest first.
+	bits []int
+}
+
+// newIPMatcher returns a new matcher for the lists in data, which maps the
+// names of the lists to their contents.
+func newIPMatcher(data map[string][]byte) (m *ipMatcher) {
+	m = &ipMatcher{
+		subnets: map[int]map[netip.Prefix]string{},
+	}
+
+	for name, b := range data {
+		n, invalid := m.add(name, b)
+		log.Debug("filtering: ip blocklist %q: %d entries, %d invalid lines", name, n, invalid)
+	}
+
+	for bits := range m.subnets {
+		m.bits = append(m.bits, bits)
+	}
+
+	slices.Sort(m.bits)
+	slices.Reverse(m.bits)
+
+	return m
+}
+
+// add adds the entries of the list with name from b to m.  n is the number of
+// added entries and invalid is the number of skipped lines.
+func (m *ipMatcher) add(name string, b []byte) (n, invalid int) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		pref, err := parseIPBlocklistEntry(line)
+		if err != nil {
+			invalid++
+
+			continue
+		}
+
+		bySubnet := m.subnets[pref.Bits()]
+		if bySubnet == nil {
+			bySubnet = map[netip.Prefix]string{}
+			m.subnets[pref.Bits()] = bySubnet
+		}
+
+		bySubnet[pref] = name
+		n++
+	}
+
+	return n, invalid
+}
+
+// parseIPBlocklistEntry parses an IP address or a CIDR subnet from the first
+// field of line.
+func parseIPBlocklistEntry(line string) (pref netip.Prefix, err error) {
+	field, _, _ := strings.Cut(line, " ")
+	field, _, _ = strings.Cut(field, "\t")
+
+	if strings.Contains(field, "/") {
+		pref, err = netip.ParsePrefix(field)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return netip.Prefix{}, err
+		}
+
+		if pref.Addr().Is4In6() && pref.Bits() >= 96 {
+			pref = netip.PrefixFrom(pref.Addr().Unmap(), pref.Bits()-96)
+		}
+
+		return pref.Masked(), nil
+	}
+
+	ip, err := netip.ParseAddr(field)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return netip.Prefix{}, err
+	}
+
+	ip = ip.Unmap()
+
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// match returns the most specific subnet containing ip and the name of its
+// list.  ok is false if ip isn't blocked.
+func (m *ipMatcher) match(ip netip.Addr) (pref netip.Prefix, list string, ok bool) {
+	ip = ip.Unmap()
+	for _, bits := range m.bits {
+		if bits > ip.BitLen() {
+			continue
+		}
+
+		pref = netip.PrefixFrom(ip, bits).Masked()
+		list, ok = m.subnets[bits][pref]
+		if ok {
+			return pref, list, true
+		}
+	}
+
+	return netip.Prefix{}, "", false
+}
+
+// CheckResponseIP checks the IP address from an A or AAAA record of an
+// upstream response against the IP blocklists.  res.IsFiltered is false if ip
+// isn't blocked.
+func (d *DNSFilter) CheckResponseIP(ip netip.Addr) (res Result) {
+	m := d.ipMatcher.Load()
+	if m == nil {
+		return Result{}
+	}
+
+	pref, list, ok := m.match(ip)
+	if !ok {
+		return Result{}
+	}
+
+	log.Debug("filtering: ip blocklist %q: matched %s for %s", list, pref, ip)
+
+	return Result{
+		Rules: []*ResultRule{{
+			Text:         pref.String(),
+			FilterListID: IPBlocklistsListID,
+		}},
+		Reason:     FilteredResponseIP,
+		IsFiltered: true,
+	}
+}
+
+// loadIPBlocklists loads the contents of the enabled IP blocklists from the
+// filesystem and replaces the current matcher.
+func (d *DNSFilter) loadIPBlocklists() {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	data := map[string][]byte{}
+	for _, l := range d.conf.IPBlocklists {
+		if !l.Enabled {
+			continue
+		}
+
+		b, err := os.ReadFile(l.path(d.conf.DataDir))
+		if errors.Is(err, os.ErrNotExist) {
+			// The list hasn't been downloaded yet.
+			continue
+		} else if err != nil {
+			log.Error("filtering: ip blocklist %q: reading: %s", l.Name, err)
+
+			continue
+		}
+
+		data[l.Name] = append(data[l.Name], b...)
+	}
+
+	if len(data) == 0 {
+		d.ipMatcher.Store(nil)
+
+		return
+	}
+
+	d.ipMatcher.Store(newIPMatcher(data))
+}
+
+// refreshIPBlocklists downloads the enabled remote IP blocklists, which either
+// haven't been downloaded yet or are due for an update, and reloads all the
+// lists if any of them has been updated.  The local lists are reloaded with the
+// update interval of the filter lists.  It must only be called from
+// [DNSFilter.periodicallyRefreshFilters].
+func (d *DNSFilter) refreshIPBlocklists() {
+	d.conf.filtersMu.RLock()
+	lists := slices.Clone(d.conf.IPBlocklists)
+	d.conf.filtersMu.RUnlock()
+
+	if len(lists) == 0 {
+		return
+	}
+
+	ivl := time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour
+	updated := false
+	for _, l := range lists {
+		if !l.Enabled || l.isLocal() {
+			continue
+		}
+
+		p := l.path(d.conf.DataDir)
+		fi, err := os.Stat(p)
+		if err == nil && (ivl == 0 || time.Since(fi.ModTime()) < ivl) {
+			continue
+		}
+
+		err = d.downloadIPBlocklist(l, p)
+		if err != nil {
+			log.Error("filtering: ip blocklist %q: downloading: %s", l.Name, err)
+
+			continue
+		}
+
+		updated = true
+	}
+
+	if ivl != 0 && time.Since(d.ipBlocklistsUpdated) >= ivl {
+		updated = true
+	}
+
+	if updated {
+		d.ipBlocklistsUpdated = time.Now()
+		d.loadIPBlocklists()
+	}
+}
+
+// downloadIPBlocklist downloads the contents of l into the file at p.
+func (d *DNSFilter) downloadIPBlocklist(l *IPBlocklist, p string) (err error) {
+	log.Debug("filtering: downloading ip blocklist %q from %q", l.Name, l.URL)
+
+	r, err := d.readerFromURL(l.URL)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, r.Close()) }()
+
+	f, err := aghrenameio.NewPendingFile(p, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	_, err = io.Copy(f, io.LimitReader(r, maxIPBlocklistSize))
+	if err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+
+	return nil
+}
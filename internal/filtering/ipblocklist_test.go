@@ -0,0 +1,116 @@
+package filtering
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckResponseIP(t *testing.T) {
+	const localData = `# Local feed.
+192.0.2.1
+198.51.100.0/24 ; SBL123
+2001:db8::/32	# documentation
+not an ip
+::ffff:203.0.113.0/120
+`
+
+	const remoteData = "192.0.2.200\n"
+
+	dataDir := t.TempDir()
+	localPath := filepath.Join(dataDir, "local.txt")
+	err := os.WriteFile(localPath, []byte(localData), 0o644)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(remoteData))
+	}))
+	t.Cleanup(srv.Close)
+
+	err = os.MkdirAll(filepath.Join(dataDir, filterDir), 0o755)
+	require.NoError(t, err)
+
+	d, _ := newForTest(t, &Config{
+		DataDir:    dataDir,
+		HTTPClient: srv.Client(),
+		IPBlocklists: []*IPBlocklist{{
+			Name:    "local",
+			URL:     localPath,
+			Enabled: true,
+		}, {
+			Name:    "remote",
+			URL:     srv.URL,
+			Enabled: true,
+		}, {
+			Name:    "disabled",
+			URL:     localPath + ".disabled",
+			Enabled: false,
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	// The remote list isn't downloaded yet.
+	res := d.CheckResponseIP(netip.MustParseAddr("192.0.2.200"))
+	assert.False(t, res.IsFiltered)
+
+	d.refreshIPBlocklists()
+
+	testCases := []struct {
+		name     string
+		ip       netip.Addr
+		wantRule string
+	}{{
+		name:     "single",
+		ip:       netip.MustParseAddr("192.0.2.1"),
+		wantRule: "192.0.2.1/32",
+	}, {
+		name:     "subnet",
+		ip:       netip.MustParseAddr("198.51.100.42"),
+		wantRule: "198.51.100.0/24",
+	}, {
+		name:     "ipv6",
+		ip:       netip.MustParseAddr("2001:db8::1"),
+		wantRule: "2001:db8::/32",
+	}, {
+		name:     "mapped_subnet",
+		ip:       netip.MustParseAddr("203.0.113.1"),
+		wantRule: "203.0.113.0/24",
+	}, {
+		name:     "mapped_ip",
+		ip:       netip.MustParseAddr("::ffff:192.0.2.1"),
+		wantRule: "192.0.2.1/32",
+	}, {
+		name:     "remote",
+		ip:       netip.MustParseAddr("192.0.2.200"),
+		wantRule: "192.0.2.200/32",
+	}, {
+		name:     "not_blocked",
+		ip:       netip.MustParseAddr("192.0.2.2"),
+		wantRule: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res = d.CheckResponseIP(tc.ip)
+			if tc.wantRule == "" {
+				assert.False(t, res.IsFiltered)
+
+				return
+			}
+
+			assert.True(t, res.IsFiltered)
+			assert.Equal(t, FilteredResponseIP, res.Reason)
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+			assert.Equal(t, int64(IPBlocklistsListID), res.Rules[0].FilterListID)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package filtering
+
+import (
+	"sync"
+	"time"
+)
+
+// listMatchesNum is the maximum number of the most recent matches kept for
+// each filter list.
+const listMatchesNum = 5
+
+// listMatch is a single match of a rule from a filter list.
+type listMatch struct {
+	// time is the time of the match.
+	time time.Time
+
+	// rule is the text of the matched rule.
+	rule string
+
+	// host is the matched hostname.
+	host string
+}
+
+// listStat are the statistics of a single filter list.
+type listStat struct {
+	// matches are the most recent matches, the newest first.
+	matches []*listMatch
+
+	// hits is the number of queries, filtering results of which have been
+	// determined by the rules of the list.
+	hits uint64
+}
+
+// listStats are the statistics of the filter lists since the start.
+type listStats struct {
+	// mu protects lists.
+	mu *sync.Mutex
+
+	// lists are the statistics of the filter lists by their IDs.
+	lists map[int64]*listStat
+}
+
+// newListStats returns new properly initialized filter list statistics.
+func newListStats() (s *listStats) {
+	return &listStats{
+		mu:    &sync.Mutex{},
+		lists: map[int64]*listStat{},
+	}
+}
+
+// count counts the filtering result res for host.  Each filter list is counted
+// once per result, even if several of its rules have matched.
+func (s *listStats) count(host string, res *Result) {
+	if len(res.Rules) == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range res.Rules {
+		if seenList(res.Rules[:i], r.FilterListID) {
+			continue
+		}
+
+		st := s.lists[r.FilterListID]
+		if st == nil {
+			st = &listStat{}
+			s.lists[r.FilterListID] = st
+		}
+
+		st.hits++
+
+		m := &listMatch{
+			time: now,
+			rule: r.Text,
+			host: host,
+		}
+
+		if len(st.matches) < listMatchesNum {
+			st.matches = append(st.matches, nil)
+		}
+
+		copy(st.matches[1:], st.matches)
+		st.matches[0] = m
+	}
+}
+
+// seenList returns true if any of rules is from the filter list with id.
+func seenList(rules []*ResultRule, id int64) (ok bool) {
+	for _, r := range rules {
+		if r.FilterListID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove removes the statistics of the filter list with id.
+func (s *listStats) remove(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.lists, id)
+}
+
+// fillJSON sets the statistics fields of fj.
+func (s *listStats) fillJSON(fj *filterJSON) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.lists[fj.ID]
+	if st == nil {
+		return
+	}
+
+	fj.HitsCount = st.hits
+	fj.LastMatches = make([]*listMatchJSON, 0, len(st.matches))
+	for _, m := range st.matches {
+		fj.LastMatches = append(fj.LastMatches, &listMatchJSON{
+			Time: m.time.Format(time.RFC3339),
+			Rule: m.rule,
+			Host: m.host,
+		})
+	}
+}
+
+// listMatchJSON is the JSON structure for a recent match of a rule from a
+// filter list.
+type listMatchJSON struct {
+	// Time is the time of the match in the RFC 3339 format.
+	Time string `json:"time"`
+
+	// Rule is the text of the matched rule.
+	Rule string `json:"rule"`
+
+	// Host is the matched hostname.
+	Host string `json:"host"`
+}
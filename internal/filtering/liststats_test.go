@@ -0,0 +1,72 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_handleFilteringStatus_listStats(t *testing.T) {
+	const (
+		usedID   = 1
+		unusedID = 2
+	)
+
+	conf := &Config{
+		Filters: []FilterYAML{{
+			Enabled: true,
+			URL:     "https://filters.example/used.txt",
+			Filter:  Filter{ID: usedID},
+		}, {
+			Enabled: true,
+			URL:     "https://filters.example/unused.txt",
+			Filter:  Filter{ID: unusedID},
+		}},
+	}
+
+	d, setts := newForTest(t, conf, []Filter{{
+		ID:   usedID,
+		Data: []byte("||blocked.example^\n||blocked.example^$dnstype=A\n"),
+	}, {
+		ID:   unusedID,
+		Data: []byte("||unused.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	for i := 0; i < listMatchesNum+1; i++ {
+		host := fmt.Sprintf("host%d.blocked.example", i)
+		res, err := d.CheckHost(host, dns.TypeA, setts)
+		require.NoError(t, err)
+		require.True(t, res.IsFiltered)
+	}
+
+	res, err := d.CheckHost("allowed.example", dns.TypeA, setts)
+	require.NoError(t, err)
+	require.False(t, res.IsFiltered)
+
+	w := httptest.NewRecorder()
+	d.handleFilteringStatus(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &filteringConfig{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+	require.Len(t, resp.Filters, 2)
+
+	used, unused := resp.Filters[0], resp.Filters[1]
+
+	// Each query is counted once, even though two rules match it.
+	assert.Equal(t, uint64(listMatchesNum+1), used.HitsCount)
+	require.Len(t, used.LastMatches, listMatchesNum)
+
+	assert.Equal(t, fmt.Sprintf("host%d.blocked.example", listMatchesNum), used.LastMatches[0].Host)
+	assert.Equal(t, "host1.blocked.example", used.LastMatches[listMatchesNum-1].Host)
+
+	assert.Zero(t, unused.HitsCount)
+	assert.Empty(t, unused.LastMatches)
+}
@@ -0,0 +1,158 @@
+package filtering
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// maxManagedHostsLines is the maximum number of lines in a single change of the
+// managed hosts list.
+const maxManagedHostsLines = 100_000
+
+// hostsLineError is an error in a single line of the hosts-style data.
+type hostsLineError struct {
+	// err is the underlying error.
+	err error
+
+	// line is the one-based number of the line.
+	line int
+}
+
+// type check
+var _ error = (*hostsLineError)(nil)
+
+// Error implements the error interface for *hostsLineError.
+func (e *hostsLineError) Error() (msg string) {
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+// type check
+var _ errors.Wrapper = (*hostsLineError)(nil)
+
+// Unwrap implements the [errors.Wrapper] interface for *hostsLineError.
+func (e *hostsLineError) Unwrap() (unwrapped error) {
+	return e.err
+}
+
+// parseHostsLine parses a line of the hosts-style data and returns its
+// normalized form, which contains the IP address followed by the lowercase
+// hostnames separated by single spaces.  The comments are removed.  entry is
+// empty if the line contains no entry.
+func parseHostsLine(line string) (entry string, err error) {
+	line, _, _ = strings.Cut(line, "#")
+	fields := strings.Fields(line)
+	switch len(fields) {
+	case 0:
+		return "", nil
+	case 1:
+		return "", errors.Error("no hostnames")
+	default:
+		// Go on.
+	}
+
+	ip, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return "", fmt.Errorf("bad ip: %w", err)
+	}
+
+	hosts := fields[1:]
+	for i, h := range hosts {
+		h = strings.ToLower(h)
+		err = netutil.ValidateHostname(h)
+		if err != nil {
+			return "", fmt.Errorf("hostname at index %d: %w", i, err)
+		}
+
+		hosts[i] = h
+	}
+
+	return ip.String() + " " + strings.Join(hosts, " "), nil
+}
+
+// parseHostsLines parses lines of the hosts-style data.  entries are the
+// normalized unique entries and errs are the errors of the invalid lines, if
+// any.
+func parseHostsLines(lines []string) (entries []string, errs []*hostsLineError) {
+	set := stringutil.NewSet()
+	for i, line := range lines {
+		entry, err := parseHostsLine(line)
+		if err != nil {
+			errs = append(errs, &hostsLineError{
+				err:  err,
+				line: i + 1,
+			})
+
+			continue
+		}
+
+		if entry != "" && !set.Has(entry) {
+			set.Add(entry)
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, errs
+}
+
+// ManagedHosts returns a copy of the managed hosts list.
+func (d *DNSFilter) ManagedHosts() (entries []string) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	return append([]string{}, d.conf.ManagedHosts...)
+}
+
+// changeManagedHosts applies the change to the managed hosts list.  The entries
+// to add and to remove must be normalized, see [parseHostsLine].  If replace is
+// true, the current entries are removed first.  It saves the configuration and
+// reloads the filtering engines if the list has changed.
+func (d *DNSFilter) changeManagedHosts(add, remove []string, replace bool) (changed bool) {
+	func() {
+		d.conf.filtersMu.Lock()
+		defer d.conf.filtersMu.Unlock()
+
+		cur := d.conf.ManagedHosts
+		if replace {
+			changed = len(cur) > 0
+			cur = nil
+		}
+
+		removeSet := stringutil.NewSet(remove...)
+		set := stringutil.NewSet()
+		upd := make([]string, 0, len(cur)+len(add))
+		for _, e := range cur {
+			if removeSet.Has(e) {
+				changed = true
+
+				continue
+			}
+
+			set.Add(e)
+			upd = append(upd, e)
+		}
+
+		for _, e := range add {
+			if !set.Has(e) {
+				changed = true
+				set.Add(e)
+				upd = append(upd, e)
+			}
+		}
+
+		if changed {
+			d.conf.ManagedHosts = upd
+		}
+	}()
+
+	if changed {
+		d.conf.ConfigModified()
+		d.EnableFilters(true)
+	}
+
+	return changed
+}
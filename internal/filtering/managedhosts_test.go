@@ -0,0 +1,200 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHostsLine(t *testing.T) {
+	testCases := []struct {
+		name       string
+		line       string
+		want       string
+		wantErrMsg string
+	}{{
+		name:       "valid",
+		line:       "0.0.0.0 Block.Example  ads.example # comment",
+		want:       "0.0.0.0 block.example ads.example",
+		wantErrMsg: "",
+	}, {
+		name:       "ipv6",
+		line:       "\t::0\tblock.example",
+		want:       ":: block.example",
+		wantErrMsg: "",
+	}, {
+		name:       "comment",
+		line:       "  # 0.0.0.0 block.example",
+		want:       "",
+		wantErrMsg: "",
+	}, {
+		name:       "empty",
+		line:       "",
+		want:       "",
+		wantErrMsg: "",
+	}, {
+		name:       "no_hosts",
+		line:       "0.0.0.0",
+		want:       "",
+		wantErrMsg: "no hostnames",
+	}, {
+		name: "bad_ip",
+		line: "0.0.0 block.example",
+		want: "",
+		wantErrMsg: `bad ip: ParseAddr("0.0.0"): ` +
+			`IPv4 address too short`,
+	}, {
+		name: "bad_host",
+		line: "0.0.0.0 block.example bad_host.example",
+		want: "",
+		wantErrMsg: `hostname at index 1: bad hostname "bad_host.example": ` +
+			`bad hostname label "bad_host": bad hostname label rune '_'`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := parseHostsLine(tc.line)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.want, entry)
+		})
+	}
+}
+
+func TestDNSFilter_handleManagedHosts(t *testing.T) {
+	confModified := 0
+	d, setts := newForTest(t, &Config{
+		ConfigModified: func() { confModified++ },
+	}, nil)
+	t.Cleanup(d.Close)
+
+	// Don't start the initializer goroutine, apply the pending filters
+	// synchronously instead, see applyFilters.
+	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
+	applyFilters := func(t *testing.T) {
+		t.Helper()
+
+		params := <-d.filtersInitializerChan
+		require.NoError(t, d.initFiltering(&params))
+	}
+
+	do := func(t *testing.T, h http.HandlerFunc, reqData any) (w *httptest.ResponseRecorder) {
+		t.Helper()
+
+		var body []byte
+		if reqData != nil {
+			var err error
+			body, err = json.Marshal(reqData)
+			require.NoError(t, err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		w = httptest.NewRecorder()
+		h(w, r)
+
+		return w
+	}
+
+	assertEntries := func(t *testing.T, w *httptest.ResponseRecorder, want []string) {
+		t.Helper()
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &managedHostsJSON{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+
+		assert.Equal(t, want, resp.Entries)
+	}
+
+	checkHost := func(t *testing.T, host string) (res Result) {
+		t.Helper()
+
+		res, err := d.CheckHost(host, dns.TypeA, setts)
+		require.NoError(t, err)
+
+		return res
+	}
+
+	t.Run("add", func(t *testing.T) {
+		w := do(t, d.handleManagedHostsAdd, &managedHostsJSON{
+			Entries: []string{"0.0.0.0 Block.Example", "0.0.0.0 block.example"},
+		})
+		assertEntries(t, w, []string{"0.0.0.0 block.example"})
+		assert.Equal(t, 1, confModified)
+		applyFilters(t)
+
+		res := checkHost(t, "block.example")
+		assert.Equal(t, FilteredBlockList, res.Reason)
+		require.Len(t, res.Rules, 1)
+
+		assert.Equal(t, int64(ManagedHostsListID), res.Rules[0].FilterListID)
+	})
+
+	t.Run("add_invalid", func(t *testing.T) {
+		w := do(t, d.handleManagedHostsAdd, &managedHostsJSON{
+			Entries: []string{"0.0.0.0 other.example", "0.0.0.0"},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "line 2: no hostnames\n", w.Body.String())
+		assert.Equal(t, []string{"0.0.0.0 block.example"}, d.ManagedHosts())
+	})
+
+	t.Run("import", func(t *testing.T) {
+		w := do(t, d.handleManagedHostsImport, &managedHostsImportReq{
+			Data: "# Imported\n0.0.0.0 ads.example\n127.0.0.1 block.example\n",
+		})
+		assertEntries(t, w, []string{
+			"0.0.0.0 block.example",
+			"0.0.0.0 ads.example",
+			"127.0.0.1 block.example",
+		})
+		assert.Equal(t, 2, confModified)
+		applyFilters(t)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		w := do(t, d.handleManagedHostsRemove, &managedHostsJSON{
+			Entries: []string{"0.0.0.0 block.example"},
+		})
+		assertEntries(t, w, []string{"0.0.0.0 ads.example", "127.0.0.1 block.example"})
+		assert.Equal(t, 3, confModified)
+		applyFilters(t)
+	})
+
+	t.Run("import_replace", func(t *testing.T) {
+		w := do(t, d.handleManagedHostsImport, &managedHostsImportReq{
+			Data:    "0.0.0.0 other.example",
+			Replace: true,
+		})
+		assertEntries(t, w, []string{"0.0.0.0 other.example"})
+		assert.Equal(t, 4, confModified)
+		applyFilters(t)
+
+		assert.Equal(t, NotFilteredNotFound, checkHost(t, "ads.example").Reason)
+		assert.Equal(t, FilteredBlockList, checkHost(t, "other.example").Reason)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		w := do(t, d.handleManagedHostsValidate, &managedHostsImportReq{
+			Data: "0.0.0.0 valid.example\nbad\n0.0.0.0 -bad.example",
+		})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &managedHostsValidateResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+
+		assert.Equal(t, []string{"0.0.0.0 valid.example"}, resp.Entries)
+		require.Len(t, resp.Errors, 2)
+
+		assert.Equal(t, 2, resp.Errors[0].Line)
+		assert.Equal(t, 3, resp.Errors[1].Line)
+		assert.Equal(t, 4, confModified)
+	})
+}
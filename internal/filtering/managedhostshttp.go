@@ -0,0 +1,187 @@
+package filtering
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// managedHostsJSON is the JSON structure for the managed hosts list.
+type managedHostsJSON struct {
+	// Entries are the normalized hosts-style entries.
+	Entries []string `json:"entries"`
+}
+
+// managedHostsImportReq is the JSON structure for the request to import the
+// hosts-style data into the managed hosts list.
+type managedHostsImportReq struct {
+	// Data is the text of the hosts-style data.
+	Data string `json:"data"`
+
+	// Replace, if true, tells to replace the current entries instead of adding
+	// the imported ones to them.
+	Replace bool `json:"replace"`
+}
+
+// managedHostsLineError is the JSON structure for an error in a line of the
+// hosts-style data.
+type managedHostsLineError struct {
+	// Error is the description of the error.
+	Error string `json:"error"`
+
+	// Line is the one-based number of the line.
+	Line int `json:"line"`
+}
+
+// managedHostsValidateResp is the JSON structure for the response to the
+// hosts-style data validation request.
+type managedHostsValidateResp struct {
+	// Entries are the normalized entries of the valid lines.
+	Entries []string `json:"entries"`
+
+	// Errors are the errors of the invalid lines.
+	Errors []*managedHostsLineError `json:"errors"`
+}
+
+// parseManagedHostsReq parses the lines of the request and writes an HTTP
+// error if there are too many lines or any of them are invalid.  ok is false if
+// an error has been written.
+func parseManagedHostsReq(
+	w http.ResponseWriter,
+	r *http.Request,
+	lines []string,
+) (entries []string, ok bool) {
+	if l := len(lines); l > maxManagedHostsLines {
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusBadRequest,
+			"too many lines: got %d, max %d",
+			l,
+			maxManagedHostsLines,
+		)
+
+		return nil, false
+	}
+
+	entries, errs := parseHostsLines(lines)
+	if len(errs) > 0 {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", errs[0])
+
+		return nil, false
+	}
+
+	return entries, true
+}
+
+// handleManagedHostsList is the handler for the GET /control/filtering/hosts
+// HTTP API.
+func (d *DNSFilter) handleManagedHostsList(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &managedHostsJSON{Entries: d.ManagedHosts()})
+}
+
+// handleManagedHostsChange handles the requests to add and remove entries of
+// the managed hosts list.
+func (d *DNSFilter) handleManagedHostsChange(w http.ResponseWriter, r *http.Request, isAdd bool) {
+	req := &managedHostsJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	entries, ok := parseManagedHostsReq(w, r, req.Entries)
+	if !ok {
+		return
+	}
+
+	if isAdd {
+		d.changeManagedHosts(entries, nil, false)
+	} else {
+		d.changeManagedHosts(nil, entries, false)
+	}
+
+	d.handleManagedHostsList(w, r)
+}
+
+// handleManagedHostsAdd is the handler for the POST
+// /control/filtering/hosts/add HTTP API.
+func (d *DNSFilter) handleManagedHostsAdd(w http.ResponseWriter, r *http.Request) {
+	d.handleManagedHostsChange(w, r, true)
+}
+
+// handleManagedHostsRemove is the handler for the POST
+// /control/filtering/hosts/remove HTTP API.
+func (d *DNSFilter) handleManagedHostsRemove(w http.ResponseWriter, r *http.Request) {
+	d.handleManagedHostsChange(w, r, false)
+}
+
+// handleManagedHostsImport is the handler for the POST
+// /control/filtering/hosts/import HTTP API.  The data is imported only if all
+// of its lines are valid.
+func (d *DNSFilter) handleManagedHostsImport(w http.ResponseWriter, r *http.Request) {
+	req := &managedHostsImportReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	entries, ok := parseManagedHostsReq(w, r, strings.Split(req.Data, "\n"))
+	if !ok {
+		return
+	}
+
+	d.changeManagedHosts(entries, nil, req.Replace)
+
+	d.handleManagedHostsList(w, r)
+}
+
+// handleManagedHostsValidate is the handler for the POST
+// /control/filtering/hosts/validate HTTP API.  It doesn't change the list.
+func (d *DNSFilter) handleManagedHostsValidate(w http.ResponseWriter, r *http.Request) {
+	req := &managedHostsImportReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	lines := strings.Split(req.Data, "\n")
+	if l := len(lines); l > maxManagedHostsLines {
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusBadRequest,
+			"too many lines: got %d, max %d",
+			l,
+			maxManagedHostsLines,
+		)
+
+		return
+	}
+
+	entries, errs := parseHostsLines(lines)
+	resp := &managedHostsValidateResp{
+		Entries: entries,
+		Errors:  make([]*managedHostsLineError, 0, len(errs)),
+	}
+
+	if resp.Entries == nil {
+		resp.Entries = []string{}
+	}
+
+	for _, e := range errs {
+		resp.Errors = append(resp.Errors, &managedHostsLineError{
+			Error: e.err.Error(),
+			Line:  e.line,
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
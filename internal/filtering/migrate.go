@@ -0,0 +1,282 @@
+package filtering
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// importFormat is the format of the data imported from other DNS filtering
+// software.
+type importFormat string
+
+// importFormat values.
+const (
+	// importFormatHosts is the hosts file format.  Lines containing a single
+	// hostname, as in the Pi-hole domain lists, are also accepted.
+	importFormatHosts importFormat = "hosts"
+
+	// importFormatDnsmasq is the dnsmasq configuration format.  Only the
+	// "address" options are translated.
+	importFormatDnsmasq importFormat = "dnsmasq"
+
+	// importFormatPiholeRegex is the format of the Pi-hole regular expression
+	// lists, one expression per line.
+	importFormatPiholeRegex importFormat = "pihole_regex"
+)
+
+// importedData is the result of translating the imported data.
+type importedData struct {
+	// rules are the translated filtering rules.
+	rules []string
+
+	// rewrites are the translated and normalized DNS rewrites.
+	rewrites []*LegacyRewrite
+
+	// skipped is the number of the lines, which contain no errors but have no
+	// equivalent in AdGuard Home, for example localhost entries of the hosts
+	// files.
+	skipped int
+}
+
+// translateFunc translates a single line of the imported data and adds the
+// result to data.  skipped is true if the line has no equivalent.
+type translateFunc func(line string, data *importedData) (skipped bool, err error)
+
+// translateImport translates the lines of the data in format f into the
+// filtering rules and DNS rewrites.  errs are the errors of the invalid lines,
+// if any.
+func translateImport(
+	f importFormat,
+	lines []string,
+) (data *importedData, errs []*hostsLineError, err error) {
+	var translate translateFunc
+	switch f {
+	case importFormatHosts:
+		translate = translateHostsLine
+	case importFormatDnsmasq:
+		translate = translateDnsmasqLine
+	case importFormatPiholeRegex:
+		translate = translatePiholeRegexLine
+	default:
+		return nil, nil, fmt.Errorf("format: unsupported value %q", f)
+	}
+
+	data = &importedData{}
+	for i, line := range lines {
+		skipped, lineErr := translate(strings.TrimSpace(line), data)
+		if lineErr != nil {
+			errs = append(errs, &hostsLineError{
+				err:  lineErr,
+				line: i + 1,
+			})
+		} else if skipped {
+			data.skipped++
+		}
+	}
+
+	return data, errs, nil
+}
+
+// localhostNames are the hostnames, which are conventionally present in the
+// hosts files and must not be blocked or rewritten.
+var localhostNames = stringutil.NewSet(
+	"broadcasthost",
+	"ip6-allhosts",
+	"ip6-allnodes",
+	"ip6-allrouters",
+	"ip6-localhost",
+	"ip6-localnet",
+	"ip6-loopback",
+	"ip6-mcastprefix",
+	"local",
+	"localhost",
+	"localhost.localdomain",
+)
+
+// blockingRule returns the rule blocking host and its subdomains.
+func blockingRule(host string) (rule string) {
+	return "||" + host + "^"
+}
+
+// isBlockingAddr returns true if ip is conventionally used for blocking in the
+// hosts files and the dnsmasq configuration.
+func isBlockingAddr(ip netip.Addr) (ok bool) {
+	return ip.IsUnspecified() || ip.IsLoopback()
+}
+
+// normalizeImportedHost returns the lowercase host without the trailing dot
+// and validates it.
+func normalizeImportedHost(host string) (norm string, err error) {
+	norm = strings.ToLower(strings.TrimSuffix(host, "."))
+	err = netutil.ValidateHostname(norm)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return "", err
+	}
+
+	return norm, nil
+}
+
+// translateHostsLine translates a line of a hosts file.  The hostnames with
+// unspecified or loopback addresses are blocked, and the ones with other
+// addresses are rewritten.  It is a [translateFunc].
+func translateHostsLine(line string, data *importedData) (skipped bool, err error) {
+	line, _, _ = strings.Cut(line, "#")
+	fields := strings.Fields(line)
+	switch len(fields) {
+	case 0:
+		return false, nil
+	case 1:
+		var host string
+		host, err = normalizeImportedHost(fields[0])
+		if err != nil {
+			// Don't wrap the error, since it's informative enough as is.
+			return false, err
+		}
+
+		data.rules = append(data.rules, blockingRule(host))
+
+		return false, nil
+	default:
+		// Go on.
+	}
+
+	ip, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return false, fmt.Errorf("bad ip: %w", err)
+	}
+
+	ip = ip.Unmap()
+	skipped = true
+	for i, h := range fields[1:] {
+		if localhostNames.Has(strings.ToLower(h)) {
+			continue
+		}
+
+		var host string
+		host, err = normalizeImportedHost(h)
+		if err != nil {
+			return false, fmt.Errorf("hostname at index %d: %w", i, err)
+		}
+
+		skipped = false
+		if isBlockingAddr(ip) {
+			data.rules = append(data.rules, blockingRule(host))
+
+			continue
+		}
+
+		rw := &LegacyRewrite{
+			Domain: host,
+			Answer: ip.String(),
+		}
+
+		err = rw.normalize()
+		if err != nil {
+			return false, fmt.Errorf("rewrite for hostname at index %d: %w", i, err)
+		}
+
+		data.rewrites = append(data.rewrites, rw)
+	}
+
+	return skipped, nil
+}
+
+// translateDnsmasqLine translates a line of the dnsmasq configuration.  The
+// "address=/domain/.../answer" options are translated into the rules, since,
+// just like them, the options also match the subdomains.  An empty answer,
+// "#", and unspecified or loopback addresses mean blocking.  The other options
+// are skipped.  It is a [translateFunc].
+func translateDnsmasqLine(line string, data *importedData) (skipped bool, err error) {
+	if line == "" || line[0] == '#' {
+		return false, nil
+	}
+
+	opt, val, _ := strings.Cut(line, "=")
+	if strings.TrimPrefix(strings.TrimSpace(opt), "--") != "address" {
+		return true, nil
+	}
+
+	val = strings.TrimSpace(val)
+	if !strings.HasPrefix(val, "/") {
+		return false, fmt.Errorf("address %q: no domains", val)
+	}
+
+	parts := strings.Split(val[1:], "/")
+	domains, answer := parts[:len(parts)-1], parts[len(parts)-1]
+	if len(domains) == 0 {
+		return false, fmt.Errorf("address %q: no domains", val)
+	}
+
+	modifier := ""
+	if answer != "" && answer != "#" {
+		var ip netip.Addr
+		ip, err = netip.ParseAddr(answer)
+		if err != nil {
+			return false, fmt.Errorf("bad ip: %w", err)
+		}
+
+		if ip = ip.Unmap(); !isBlockingAddr(ip) {
+			modifier = "$dnsrewrite=" + ip.String()
+		}
+	}
+
+	for i, d := range domains {
+		if d == "#" {
+			return false, fmt.Errorf("domain at index %d: wildcard is not supported", i)
+		}
+
+		var host string
+		host, err = normalizeImportedHost(d)
+		if err != nil {
+			return false, fmt.Errorf("domain at index %d: %w", i, err)
+		}
+
+		data.rules = append(data.rules, blockingRule(host)+modifier)
+	}
+
+	return false, nil
+}
+
+// piholeRegexExtensions are the prefixes of the Pi-hole specific extensions of
+// the regular expressions, which have no equivalent in AdGuard Home.
+var piholeRegexExtensions = []string{
+	";invert",
+	";querytype=",
+	";reply=",
+}
+
+// translatePiholeRegexLine translates a line of a Pi-hole regular expression
+// list into a regular expression rule.  It is a [translateFunc].
+func translatePiholeRegexLine(line string, data *importedData) (skipped bool, err error) {
+	if line == "" || line[0] == '#' {
+		return false, nil
+	}
+
+	for _, ext := range piholeRegexExtensions {
+		if strings.Contains(line, ext) {
+			return false, fmt.Errorf("extension %q is not supported", strings.TrimSuffix(ext, "="))
+		}
+	}
+
+	_, err = regexp.Compile(line)
+	if err != nil {
+		return false, fmt.Errorf("bad regexp: %w", err)
+	}
+
+	rule := "/" + line + "/"
+	_, err = rules.NewNetworkRule(rule, 0)
+	if err != nil {
+		return false, fmt.Errorf("bad rule: %w", err)
+	}
+
+	data.rules = append(data.rules, rule)
+
+	return false, nil
+}
@@ -0,0 +1,264 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateImport(t *testing.T) {
+	testCases := []struct {
+		name         string
+		format       importFormat
+		data         string
+		wantRules    []string
+		wantRewrites []string
+		wantErrs     []string
+		wantSkipped  int
+	}{{
+		name:   "hosts",
+		format: importFormatHosts,
+		data: "# Comment\n" +
+			"127.0.0.1 localhost\n" +
+			"0.0.0.0 Ads.Example tracker.example # Inline comment\n" +
+			":: ipv6.example\n" +
+			"192.168.1.2 nas.lan\n" +
+			"domain-only.example\n",
+		wantRules: []string{
+			"||ads.example^",
+			"||tracker.example^",
+			"||ipv6.example^",
+			"||domain-only.example^",
+		},
+		wantRewrites: []string{"nas.lan 192.168.1.2"},
+		wantErrs:     nil,
+		wantSkipped:  1,
+	}, {
+		name:         "hosts_errors",
+		format:       importFormatHosts,
+		data:         "0.0.0 bad.example\n0.0.0.0 bad_host.example",
+		wantRules:    nil,
+		wantRewrites: nil,
+		wantErrs: []string{
+			`line 1: bad ip: ParseAddr("0.0.0"): IPv4 address too short`,
+			`line 2: hostname at index 0: bad hostname "bad_host.example": ` +
+				`bad hostname label "bad_host": bad hostname label rune '_'`,
+		},
+		wantSkipped: 0,
+	}, {
+		name:   "dnsmasq",
+		format: importFormatDnsmasq,
+		data: "# Comment\n" +
+			"address=/ads.example/tracker.example/0.0.0.0\n" +
+			"address=/null.example/\n" +
+			"address=/hash.example/#\n" +
+			"--address=/nas.lan/192.168.1.2\n" +
+			"server=/corp.example/10.0.0.1\n",
+		wantRules: []string{
+			"||ads.example^",
+			"||tracker.example^",
+			"||null.example^",
+			"||hash.example^",
+			"||nas.lan^$dnsrewrite=192.168.1.2",
+		},
+		wantRewrites: nil,
+		wantErrs:     nil,
+		wantSkipped:  1,
+	}, {
+		name:         "dnsmasq_errors",
+		format:       importFormatDnsmasq,
+		data:         "address=/#/0.0.0.0\naddress=0.0.0.0\naddress=/a.example/bad",
+		wantRules:    nil,
+		wantRewrites: nil,
+		wantErrs: []string{
+			"line 1: domain at index 0: wildcard is not supported",
+			`line 2: address "0.0.0.0": no domains`,
+			`line 3: bad ip: ParseAddr("bad"): unable to parse IP`,
+		},
+		wantSkipped: 0,
+	}, {
+		name:         "pihole_regex",
+		format:       importFormatPiholeRegex,
+		data:         "# Comment\n(^|\\.)ads\\.example$\n^ad[0-9]+\\.\n",
+		wantRules:    []string{`/(^|\.)ads\.example$/`, `/^ad[0-9]+\./`},
+		wantRewrites: nil,
+		wantErrs:     nil,
+		wantSkipped:  0,
+	}, {
+		name:         "pihole_regex_errors",
+		format:       importFormatPiholeRegex,
+		data:         "^ads\\.;querytype=AAAA\n(unclosed",
+		wantRules:    nil,
+		wantRewrites: nil,
+		wantErrs: []string{
+			`line 1: extension ";querytype" is not supported`,
+			"line 2: bad regexp: error parsing regexp: missing closing ): `(unclosed`",
+		},
+		wantSkipped: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, errs, err := translateImport(tc.format, strings.Split(tc.data, "\n"))
+			require.NoError(t, err)
+
+			var gotRewrites []string
+			for _, rw := range data.rewrites {
+				gotRewrites = append(gotRewrites, rw.Domain+" "+rw.Answer)
+			}
+
+			var gotErrs []string
+			for _, e := range errs {
+				gotErrs = append(gotErrs, e.Error())
+			}
+
+			assert.Equal(t, tc.wantRules, data.rules)
+			assert.Equal(t, tc.wantRewrites, gotRewrites)
+			assert.Equal(t, tc.wantErrs, gotErrs)
+			assert.Equal(t, tc.wantSkipped, data.skipped)
+		})
+	}
+
+	t.Run("bad_format", func(t *testing.T) {
+		_, _, err := translateImport("bad", nil)
+		testutil.AssertErrorMsg(t, `format: unsupported value "bad"`, err)
+	})
+}
+
+func TestDNSFilter_handleImport(t *testing.T) {
+	confModified := 0
+	var blocked []string
+	d, setts := newForTest(t, &Config{
+		ConfigModified: func() { confModified++ },
+		BlockedClients: func() (clients []string) { return blocked },
+		AddBlockedClients: func(clients []string) (added int, err error) {
+			blocked = append(blocked, clients...)
+
+			return len(clients), nil
+		},
+		UserRules: []string{"||ads.example^"},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	// Don't start the initializer goroutine, apply the pending filters
+	// synchronously instead.
+	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
+
+	doImport := func(t *testing.T, req *importReq) (resp *importResp) {
+		t.Helper()
+
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/control/filtering/import", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		d.handleImport(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp = &importResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+
+		return resp
+	}
+
+	const hostsData = "0.0.0.0 ads.example tracker.example\n192.168.1.2 nas.lan\n"
+
+	t.Run("dry_run", func(t *testing.T) {
+		resp := doImport(t, &importReq{
+			Format: importFormatHosts,
+			Data:   hostsData,
+			DryRun: true,
+		})
+
+		assert.False(t, resp.Imported)
+		assert.Equal(t, []string{"||tracker.example^"}, resp.Rules)
+		require.Len(t, resp.Rewrites, 1)
+
+		assert.Equal(t, "nas.lan", resp.Rewrites[0].Domain)
+		assert.Equal(t, 0, confModified)
+		assert.Equal(t, []string{"||ads.example^"}, d.conf.UserRules)
+		assert.Empty(t, d.conf.Rewrites)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		resp := doImport(t, &importReq{
+			Format: importFormatHosts,
+			Data:   hostsData + "0.0.0 bad.example\n",
+		})
+
+		assert.False(t, resp.Imported)
+		require.Len(t, resp.Errors, 1)
+
+		assert.Equal(t, 3, resp.Errors[0].Line)
+		assert.Equal(t, 0, confModified)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		resp := doImport(t, &importReq{
+			Format: importFormatHosts,
+			Data:   hostsData,
+			Reason: "migration",
+		})
+
+		assert.True(t, resp.Imported)
+		assert.Equal(t, 1, confModified)
+		assert.Equal(t, []string{"||ads.example^", "||tracker.example^"}, d.conf.UserRules)
+		require.Len(t, d.conf.Rewrites, 1)
+		require.NotNil(t, d.conf.Rewrites[0].Provenance)
+
+		assert.Equal(t, "migration", d.conf.Rewrites[0].Provenance.Reason)
+		assert.Equal(t, "migration", d.conf.UserRulesProvenance["||tracker.example^"].Reason)
+
+		params := <-d.filtersInitializerChan
+		require.NoError(t, d.initFiltering(&params))
+
+		res, err := d.CheckHost("sub.tracker.example", dns.TypeA, setts)
+		require.NoError(t, err)
+
+		assert.Equal(t, FilteredBlockList, res.Reason)
+	})
+
+	t.Run("export_bundle", func(t *testing.T) {
+		blocked = []string{"192.0.2.1"}
+
+		r := httptest.NewRequest(http.MethodGet, "/control/filtering/export", nil)
+		w := httptest.NewRecorder()
+		d.handleExport(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		bundle := &rulesBundleJSON{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), bundle))
+
+		assert.Equal(t, rulesBundleVersion, bundle.Version)
+		assert.Equal(t, []string{"||ads.example^", "||tracker.example^"}, bundle.UserRules)
+		assert.Equal(t, []string{"192.0.2.1"}, bundle.BlockedClients)
+		require.Len(t, bundle.Rewrites, 1)
+
+		bundle.UserRules = append(bundle.UserRules, "||new.example^")
+		bundle.BlockedClients = append(bundle.BlockedClients, "192.0.2.2")
+		bundle.Rewrites[0].Provenance = nil
+
+		data, err := json.Marshal(bundle)
+		require.NoError(t, err)
+
+		resp := doImport(t, &importReq{
+			Format: importFormatBundle,
+			Data:   string(data),
+		})
+
+		assert.True(t, resp.Imported)
+		assert.Equal(t, []string{"||new.example^"}, resp.Rules)
+		assert.Empty(t, resp.Rewrites)
+		assert.Equal(t, []string{"192.0.2.2"}, resp.BlockedClients)
+		assert.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, blocked)
+		assert.Equal(t, 2, confModified)
+	})
+}
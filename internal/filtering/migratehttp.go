@@ -0,0 +1,310 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/exp/slices"
+)
+
+// importFormatBundle is the format of the bundles exported by AdGuard Home.
+const importFormatBundle importFormat = "bundle"
+
+// rulesBundleVersion is the current version of the exported bundle.
+const rulesBundleVersion = 1
+
+// rulesBundleJSON is the JSON structure for the portable bundle of the custom
+// filtering rules, DNS rewrites, and blocked clients.
+type rulesBundleJSON struct {
+	// UserRules are the custom filtering rules.
+	UserRules []string `json:"user_rules"`
+
+	// Rewrites are the DNS rewrites.
+	Rewrites []*rewriteEntryJSON `json:"rewrites"`
+
+	// BlockedClients are the disallowed clients.
+	BlockedClients []string `json:"blocked_clients"`
+
+	// Version is the version of the bundle format.
+	Version int `json:"version"`
+}
+
+// handleExport is the handler for the GET /control/filtering/export HTTP API.
+func (d *DNSFilter) handleExport(w http.ResponseWriter, r *http.Request) {
+	bundle := &rulesBundleJSON{
+		UserRules:      []string{},
+		Rewrites:       []*rewriteEntryJSON{},
+		BlockedClients: []string{},
+		Version:        rulesBundleVersion,
+	}
+
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		for _, rule := range d.conf.UserRules {
+			if strings.TrimSpace(rule) != "" {
+				bundle.UserRules = append(bundle.UserRules, rule)
+			}
+		}
+
+		for _, rw := range d.conf.Rewrites {
+			bundle.Rewrites = append(bundle.Rewrites, &rewriteEntryJSON{
+				Domain: rw.Domain,
+				Answer: rw.Answer,
+				Type:   rw.RecordType,
+			})
+		}
+	}()
+
+	if d.conf.BlockedClients != nil {
+		bundle.BlockedClients = append(bundle.BlockedClients, d.conf.BlockedClients()...)
+	}
+
+	w.Header().Set(httphdr.ContentDisposition, `attachment; filename="adguardhome_rules.json"`)
+
+	aghhttp.WriteJSONResponseOK(w, r, bundle)
+}
+
+// importReq is the JSON structure for the request to import the custom
+// filtering rules, DNS rewrites, and blocked clients.
+type importReq struct {
+	// Format is the format of Data.
+	Format importFormat `json:"format"`
+
+	// Data is the imported data.  For [importFormatBundle], it's the JSON text
+	// of the exported bundle.
+	Data string `json:"data"`
+
+	// Reason is the reason for the import, which is recorded into the
+	// provenance of the imported rules and rewrites.
+	Reason string `json:"reason"`
+
+	// DryRun, if true, tells to only translate and validate the data.
+	DryRun bool `json:"dry_run"`
+}
+
+// importResp is the JSON structure for the report on the import.
+type importResp struct {
+	// Rules are the new custom filtering rules.
+	Rules []string `json:"rules"`
+
+	// Rewrites are the new DNS rewrites.
+	Rewrites []*rewriteEntryJSON `json:"rewrites"`
+
+	// BlockedClients are the new blocked clients.
+	BlockedClients []string `json:"blocked_clients"`
+
+	// Errors are the errors of the invalid lines.  For bundles, Line is zero.
+	Errors []*managedHostsLineError `json:"errors"`
+
+	// Skipped is the number of the lines, which have no equivalent in AdGuard
+	// Home.
+	Skipped int `json:"skipped"`
+
+	// Imported is true if the data has been imported, that is, if the request
+	// wasn't a dry run and there were no errors.
+	Imported bool `json:"imported"`
+}
+
+// handleImport is the handler for the POST /control/filtering/import HTTP
+// API.  The data is translated into the custom filtering rules and DNS
+// rewrites, and the ones, which aren't present yet, are added.  Nothing is
+// imported if the request is a dry run or any of the lines is invalid.  The
+// response is the report in any case.
+func (d *DNSFilter) handleImport(w http.ResponseWriter, r *http.Request) {
+	req := &importReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	var data *importedData
+	var clients []string
+	var errs []*hostsLineError
+	if req.Format == importFormatBundle {
+		data, clients, errs, err = translateBundle(req.Data)
+	} else {
+		lines := strings.Split(req.Data, "\n")
+		if l := len(lines); l > maxManagedHostsLines {
+			err = fmt.Errorf("too many lines: got %d, max %d", l, maxManagedHostsLines)
+		} else {
+			data, errs, err = translateImport(req.Format, lines)
+		}
+	}
+
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "translating: %s", err)
+
+		return
+	}
+
+	resp, ok := d.importTranslated(w, r, req, data, clients, errs)
+	if ok {
+		aghhttp.WriteJSONResponseOK(w, r, resp)
+	}
+}
+
+// translateBundle parses the JSON text of the exported bundle.  errs are the
+// errors of the invalid rewrites, if any.
+func translateBundle(
+	text string,
+) (data *importedData, clients []string, errs []*hostsLineError, err error) {
+	bundle := &rulesBundleJSON{}
+	err = json.Unmarshal([]byte(text), bundle)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding bundle: %w", err)
+	} else if bundle.Version > rulesBundleVersion {
+		return nil, nil, nil, fmt.Errorf("bundle version %d is not supported", bundle.Version)
+	}
+
+	data = &importedData{}
+	for _, rule := range bundle.UserRules {
+		if strings.TrimSpace(rule) != "" {
+			data.rules = append(data.rules, rule)
+		}
+	}
+
+	for i, rwJSON := range bundle.Rewrites {
+		rw := rwJSON.toRewrite()
+		err = rw.normalize()
+		if err != nil {
+			errs = append(errs, &hostsLineError{
+				err: fmt.Errorf("rewrite at index %d: %w", i, err),
+			})
+
+			continue
+		}
+
+		data.rewrites = append(data.rewrites, rw)
+	}
+
+	return data, bundle.BlockedClients, errs, nil
+}
+
+// importTranslated adds the translated data and the blocked clients, which
+// aren't present yet, unless req is a dry run or there are errs.  ok is false
+// if an HTTP error has been written.
+func (d *DNSFilter) importTranslated(
+	w http.ResponseWriter,
+	r *http.Request,
+	req *importReq,
+	data *importedData,
+	clients []string,
+	errs []*hostsLineError,
+) (resp *importResp, ok bool) {
+	resp = &importResp{
+		Rules:          []string{},
+		Rewrites:       []*rewriteEntryJSON{},
+		BlockedClients: []string{},
+		Errors:         make([]*managedHostsLineError, 0, len(errs)),
+		Skipped:        data.skipped,
+	}
+
+	for _, e := range errs {
+		resp.Errors = append(resp.Errors, &managedHostsLineError{
+			Error: e.err.Error(),
+			Line:  e.line,
+		})
+	}
+
+	if d.conf.BlockedClients != nil {
+		cur := stringutil.NewSet(d.conf.BlockedClients()...)
+		for _, c := range clients {
+			if !cur.Has(c) {
+				cur.Add(c)
+				resp.BlockedClients = append(resp.BlockedClients, c)
+			}
+		}
+	}
+
+	apply := !req.DryRun && len(errs) == 0
+	if apply && len(resp.BlockedClients) > 0 && d.conf.AddBlockedClients != nil {
+		_, err := d.conf.AddBlockedClients(resp.BlockedClients)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "adding blocked clients: %s", err)
+
+			return nil, false
+		}
+	}
+
+	var p *RuleProvenance
+	if apply {
+		p = d.newProvenance(r, req.Reason)
+	}
+
+	rules, rws := d.addImported(data, p)
+	resp.Rules = append(resp.Rules, rules...)
+	for _, rw := range rws {
+		resp.Rewrites = append(resp.Rewrites, newRewriteEntryJSON(rw))
+	}
+
+	resp.Imported = apply
+	if apply && (len(rules) > 0 || len(rws) > 0 || len(resp.BlockedClients) > 0) {
+		log.Info(
+			"filtering: imported %d rules, %d rewrites, %d blocked clients",
+			len(rules),
+			len(rws),
+			len(resp.BlockedClients),
+		)
+
+		d.conf.ConfigModified()
+	}
+
+	if apply && len(rules) > 0 {
+		d.EnableFilters(true)
+	}
+
+	return resp, true
+}
+
+// addImported returns the rules and rewrites from data, which aren't present
+// yet.  If p isn't nil, it also adds them with the provenance p.
+func (d *DNSFilter) addImported(
+	data *importedData,
+	p *RuleProvenance,
+) (rules []string, rws []*LegacyRewrite) {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	seen := stringutil.NewSet(d.conf.UserRules...)
+	for _, rule := range data.rules {
+		if !seen.Has(rule) {
+			seen.Add(rule)
+			rules = append(rules, rule)
+		}
+	}
+
+	for _, rw := range data.rewrites {
+		has := func(other *LegacyRewrite) (ok bool) { return other.equal(rw) }
+		if !slices.ContainsFunc(d.conf.Rewrites, has) && !slices.ContainsFunc(rws, has) {
+			rws = append(rws, rw)
+		}
+	}
+
+	if p == nil {
+		return rules, rws
+	}
+
+	for _, rw := range rws {
+		rw.Provenance = p
+	}
+
+	d.conf.Rewrites = append(d.conf.Rewrites, rws...)
+
+	if len(rules) > 0 {
+		upd := append(slices.Clone(d.conf.UserRules), rules...)
+		d.conf.UserRulesProvenance = updatedProvenance(d.conf.UserRulesProvenance, upd, p)
+		d.conf.UserRules = upd
+	}
+
+	return rules, rws
+}
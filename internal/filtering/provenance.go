@@ -0,0 +1,199 @@
+package filtering
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"golang.org/x/exp/slices"
+)
+
+// RuleProvenance is the record of the origin of a custom filtering rule or a
+// DNS rewrite.
+type RuleProvenance struct {
+	// Time is the time when the rule has been added.
+	Time time.Time `yaml:"time" json:"time"`
+
+	// Source is the path of the HTTP API, through which the rule has been
+	// added, for example "/control/filtering/set_rules", or "dns_update" for
+	// the rewrites added by the DNS UPDATE messages.
+	Source string `yaml:"source" json:"source"`
+
+	// Author is the name of the user, who has added the rule.  It's empty if
+	// the authentication is disabled.
+	Author string `yaml:"author,omitempty" json:"author,omitempty"`
+
+	// Reason is the reason for adding the rule given by the author.
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// newProvenance returns the provenance of the rules added by r.
+func (d *DNSFilter) newProvenance(r *http.Request, reason string) (p *RuleProvenance) {
+	p = &RuleProvenance{
+		Time:   time.Now().UTC(),
+		Source: r.URL.Path,
+		Reason: reason,
+	}
+
+	if d.conf.RequestUser != nil {
+		p.Author = d.conf.RequestUser(r)
+	}
+
+	return p
+}
+
+// contains returns true if any of the fields of p contains the lowercased
+// substring s.  p may be nil.
+func (p *RuleProvenance) contains(s string) (ok bool) {
+	if p == nil {
+		return false
+	}
+
+	for _, f := range []string{p.Source, p.Author, p.Reason} {
+		if strings.Contains(strings.ToLower(f), s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updatedProvenance returns the provenance of rules, which keeps the records of
+// the rules present in prev and uses p for the new ones.
+func updatedProvenance(
+	prev map[string]*RuleProvenance,
+	rules []string,
+	p *RuleProvenance,
+) (res map[string]*RuleProvenance) {
+	res = make(map[string]*RuleProvenance, len(rules))
+	for _, rule := range rules {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		if prevProv, ok := prev[rule]; ok {
+			res[rule] = prevProv
+		} else {
+			res[rule] = p
+		}
+	}
+
+	return res
+}
+
+// userRuleJSON is a custom filtering rule with its provenance.  Provenance is
+// nil if the rule has been added before the provenance has been recorded or
+// by editing the configuration file.
+type userRuleJSON struct {
+	Provenance *RuleProvenance `json:"provenance"`
+	Rule       string          `json:"rule"`
+}
+
+// provenanceJSON is the response to the GET /control/filtering/provenance
+// HTTP API.
+type provenanceJSON struct {
+	UserRules []*userRuleJSON     `json:"user_rules"`
+	Rewrites  []*rewriteEntryJSON `json:"rewrites"`
+}
+
+// handleProvenance is the handler for the GET /control/filtering/provenance
+// HTTP API.  The optional search parameter limits the response to the custom
+// rules and the rewrites, the text or the provenance of which contain it.
+func (d *DNSFilter) handleProvenance(w http.ResponseWriter, r *http.Request) {
+	search := strings.ToLower(r.URL.Query().Get("search"))
+
+	resp := &provenanceJSON{
+		UserRules: []*userRuleJSON{},
+		Rewrites:  []*rewriteEntryJSON{},
+	}
+
+	d.conf.filtersMu.RLock()
+	userRules := slices.Clone(d.conf.UserRules)
+	d.conf.filtersMu.RUnlock()
+
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		for _, rule := range userRules {
+			if strings.TrimSpace(rule) == "" {
+				continue
+			}
+
+			p := d.conf.UserRulesProvenance[rule]
+			if strings.Contains(strings.ToLower(rule), search) || p.contains(search) {
+				resp.UserRules = append(resp.UserRules, &userRuleJSON{
+					Provenance: p,
+					Rule:       rule,
+				})
+			}
+		}
+
+		for _, rw := range d.conf.Rewrites {
+			text := strings.ToLower(rw.Domain + " " + rw.Answer)
+			if strings.Contains(text, search) || rw.Provenance.contains(search) {
+				resp.Rewrites = append(resp.Rewrites, newRewriteEntryJSON(rw))
+			}
+		}
+	}()
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// AddUserRule adds rule to the custom filtering rules with the provenance p,
+// unless it's already there, and applies the rules.
+func (d *DNSFilter) AddUserRule(rule string, p *RuleProvenance) (added bool) {
+	d.conf.filtersMu.Lock()
+	if slices.Contains(d.conf.UserRules, rule) {
+		d.conf.filtersMu.Unlock()
+
+		return false
+	}
+
+	d.conf.UserRules = append(slices.Clone(d.conf.UserRules), rule)
+	d.conf.filtersMu.Unlock()
+
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		if d.conf.UserRulesProvenance == nil {
+			d.conf.UserRulesProvenance = map[string]*RuleProvenance{}
+		}
+
+		d.conf.UserRulesProvenance[rule] = p
+	}()
+
+	d.conf.ConfigModified()
+	d.EnableFilters(true)
+
+	return true
+}
+
+// RemoveUserRule removes rule from the custom filtering rules and applies the
+// rules.
+func (d *DNSFilter) RemoveUserRule(rule string) (removed bool) {
+	d.conf.filtersMu.Lock()
+	i := slices.Index(d.conf.UserRules, rule)
+	if i < 0 {
+		d.conf.filtersMu.Unlock()
+
+		return false
+	}
+
+	d.conf.UserRules = slices.Delete(slices.Clone(d.conf.UserRules), i, i+1)
+	d.conf.filtersMu.Unlock()
+
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		delete(d.conf.UserRulesProvenance, rule)
+	}()
+
+	d.conf.ConfigModified()
+	d.EnableFilters(true)
+
+	return true
+}
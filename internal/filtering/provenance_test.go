@@ -0,0 +1,138 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_handleProvenance(t *testing.T) {
+	const (
+		setRulesPath   = "/control/filtering/set_rules"
+		rewriteAddPath = "/control/rewrite/add"
+		provenancePath = "/control/filtering/provenance"
+	)
+
+	user := "admin"
+	d, _ := newForTest(t, &Config{
+		ConfigModified: func() {},
+		RequestUser:    func(_ *http.Request) (name string) { return user },
+	}, nil)
+	t.Cleanup(d.Close)
+
+	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
+
+	post := func(t *testing.T, h http.HandlerFunc, path string, v any) {
+		t.Helper()
+
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	getProvenance := func(t *testing.T, search string) (resp *provenanceJSON) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, provenancePath+"?search="+search, nil)
+		w := httptest.NewRecorder()
+		d.handleProvenance(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp = &provenanceJSON{}
+		err := json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	post(t, d.handleFilteringSetRules, setRulesPath, &filteringRulesReq{
+		Rules:  []string{"||first.example^", ""},
+		Reason: "ads",
+	})
+
+	user = "other"
+	post(t, d.handleFilteringSetRules, setRulesPath, &filteringRulesReq{
+		Rules:  []string{"||first.example^", "@@||second.example^"},
+		Reason: "broken site",
+	})
+
+	post(t, d.handleRewriteAdd, rewriteAddPath, &rewriteEntryJSON{
+		Domain: "host.example",
+		Answer: "192.0.2.1",
+		Reason: "test server",
+	})
+
+	resp := getProvenance(t, "")
+	require.Len(t, resp.UserRules, 2)
+	require.Len(t, resp.Rewrites, 1)
+
+	first, second := resp.UserRules[0], resp.UserRules[1]
+	assert.Equal(t, "||first.example^", first.Rule)
+	require.NotNil(t, first.Provenance)
+
+	assert.Equal(t, "admin", first.Provenance.Author)
+	assert.Equal(t, "ads", first.Provenance.Reason)
+	assert.Equal(t, setRulesPath, first.Provenance.Source)
+
+	assert.Equal(t, "@@||second.example^", second.Rule)
+	require.NotNil(t, second.Provenance)
+
+	assert.Equal(t, "other", second.Provenance.Author)
+	assert.Equal(t, "broken site", second.Provenance.Reason)
+
+	rw := resp.Rewrites[0]
+	require.NotNil(t, rw.Provenance)
+
+	assert.Equal(t, "test server", rw.Provenance.Reason)
+	assert.Equal(t, rewriteAddPath, rw.Provenance.Source)
+
+	resp = getProvenance(t, "BROKEN")
+	require.Len(t, resp.UserRules, 1)
+
+	assert.Equal(t, "@@||second.example^", resp.UserRules[0].Rule)
+	assert.Empty(t, resp.Rewrites)
+
+	resp = getProvenance(t, "host.example")
+	assert.Empty(t, resp.UserRules)
+	assert.Len(t, resp.Rewrites, 1)
+}
+
+func TestDNSFilter_AddUserRule(t *testing.T) {
+	const rule = "@@||allowed.example^$client=192.0.2.1"
+
+	modified := 0
+	d, _ := newForTest(t, &Config{
+		ConfigModified: func() { modified++ },
+		UserRules:      []string{"||blocked.example^"},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
+
+	p := &RuleProvenance{Source: "/control/unblock_requests/review", Author: "admin"}
+	assert.True(t, d.AddUserRule(rule, p))
+	assert.False(t, d.AddUserRule(rule, p))
+
+	assert.Equal(t, []string{"||blocked.example^", rule}, d.conf.UserRules)
+	assert.Same(t, p, d.conf.UserRulesProvenance[rule])
+
+	assert.True(t, d.RemoveUserRule(rule))
+	assert.False(t, d.RemoveUserRule(rule))
+
+	assert.Equal(t, []string{"||blocked.example^"}, d.conf.UserRules)
+	assert.NotContains(t, d.conf.UserRulesProvenance, rule)
+
+	assert.Equal(t, 2, modified)
+}
@@ -3,8 +3,8 @@ package rewrite
 import (
 	"fmt"
 	"net/netip"
-	"strings"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/miekg/dns"
 )
 
@@ -35,7 +35,7 @@ func (rw *Item) toRule() (res string) {
 		return ""
 	}
 
-	domain := strings.ToLower(rw.Domain)
+	domain := aghnet.NormalizeDomain(rw.Domain)
 
 	dType, exception := rw.rewriteParams()
 	dTypeKey := dns.TypeToString[dType]
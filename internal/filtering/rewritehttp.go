@@ -13,6 +13,37 @@ import (
 type rewriteEntryJSON struct {
 	Domain string `json:"domain"`
 	Answer string `json:"answer"`
+
+	// Type is the explicit type of the record.  See
+	// [LegacyRewrite.RecordType].
+	Type string `json:"type,omitempty"`
+
+	// Reason is the reason for adding the rewrite, which is recorded into its
+	// provenance.  It's only used in requests.
+	Reason string `json:"reason,omitempty"`
+
+	// Provenance is the provenance of the rewrite.  It's only used in
+	// responses.
+	Provenance *RuleProvenance `json:"provenance,omitempty"`
+}
+
+// newRewriteEntryJSON returns the JSON representation of rw.
+func newRewriteEntryJSON(rw *LegacyRewrite) (j *rewriteEntryJSON) {
+	return &rewriteEntryJSON{
+		Domain:     rw.Domain,
+		Answer:     rw.Answer,
+		Type:       rw.RecordType,
+		Provenance: rw.Provenance,
+	}
+}
+
+// toRewrite returns a new legacy rewrite with the data from j.
+func (j *rewriteEntryJSON) toRewrite() (rw *LegacyRewrite) {
+	return &LegacyRewrite{
+		Domain:     j.Domain,
+		Answer:     j.Answer,
+		RecordType: j.Type,
+	}
 }
 
 // handleRewriteList is the handler for the GET /control/rewrite/list HTTP API.
@@ -24,11 +55,7 @@ func (d *DNSFilter) handleRewriteList(w http.ResponseWriter, r *http.Request) {
 		defer d.confMu.RUnlock()
 
 		for _, ent := range d.conf.Rewrites {
-			jsonEnt := rewriteEntryJSON{
-				Domain: ent.Domain,
-				Answer: ent.Answer,
-			}
-			arr = append(arr, &jsonEnt)
+			arr = append(arr, newRewriteEntryJSON(ent))
 		}
 	}()
 
@@ -45,20 +72,16 @@ func (d *DNSFilter) handleRewriteAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rw := &LegacyRewrite{
-		Domain: rwJSON.Domain,
-		Answer: rwJSON.Answer,
-	}
-
+	rw := rwJSON.toRewrite()
 	err = rw.normalize()
 	if err != nil {
-		// Shouldn't happen currently, since normalize only returns a non-nil
-		// error when a rewrite is nil, but be change-proof.
 		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
 
 		return
 	}
 
+	rw.Provenance = d.newProvenance(r, rwJSON.Reason)
+
 	func() {
 		d.confMu.Lock()
 		defer d.confMu.Unlock()
@@ -86,10 +109,7 @@ func (d *DNSFilter) handleRewriteDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	entDel := &LegacyRewrite{
-		Domain: jsent.Domain,
-		Answer: jsent.Answer,
-	}
+	entDel := jsent.toRewrite()
 	arr := []*LegacyRewrite{}
 
 	func() {
@@ -128,25 +148,17 @@ func (d *DNSFilter) handleRewriteUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	rwDel := &LegacyRewrite{
-		Domain: updateJSON.Target.Domain,
-		Answer: updateJSON.Target.Answer,
-	}
-
-	rwAdd := &LegacyRewrite{
-		Domain: updateJSON.Update.Domain,
-		Answer: updateJSON.Update.Answer,
-	}
-
+	rwDel := updateJSON.Target.toRewrite()
+	rwAdd := updateJSON.Update.toRewrite()
 	err = rwAdd.normalize()
 	if err != nil {
-		// Shouldn't happen currently, since normalize only returns a non-nil
-		// error when a rewrite is nil, but be change-proof.
 		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
 
 		return
 	}
 
+	rwAdd.Provenance = d.newProvenance(r, updateJSON.Update.Reason)
+
 	index := -1
 	defer func() {
 		if index >= 0 {
@@ -19,6 +19,7 @@ import (
 type rewriteJSON struct {
 	Domain string `json:"domain"`
 	Answer string `json:"answer"`
+	Type   string `json:"type,omitempty"`
 }
 
 type rewriteUpdateJSON struct {
@@ -80,6 +81,37 @@ func TestDNSFilter_handleRewriteHTTP(t *testing.T) {
 			testRewrites,
 			&rewriteJSON{Domain: "add.local", Answer: "add.rewrite"},
 		),
+	}, {
+		name:        "add_mx",
+		url:         addURL,
+		method:      http.MethodPost,
+		reqData:     rewriteJSON{Domain: "mx.local", Answer: "10 mail.local", Type: "MX"},
+		wantConfMod: true,
+		wantStatus:  http.StatusOK,
+		wantBody:    "",
+		wantList: append(
+			testRewrites,
+			&rewriteJSON{Domain: "mx.local", Answer: "10 mail.local", Type: "MX"},
+		),
+	}, {
+		name:        "add_error_type",
+		url:         addURL,
+		method:      http.MethodPost,
+		reqData:     rewriteJSON{Domain: "ns.local", Answer: "ns.rewrite", Type: "NS"},
+		wantConfMod: false,
+		wantStatus:  http.StatusBadRequest,
+		wantBody:    "normalizing: unsupported type \"NS\"\n",
+		wantList:    testRewrites,
+	}, {
+		name:        "add_error_value",
+		url:         addURL,
+		method:      http.MethodPost,
+		reqData:     rewriteJSON{Domain: "srv.local", Answer: "sip.local", Type: "SRV"},
+		wantConfMod: false,
+		wantStatus:  http.StatusBadRequest,
+		wantBody: "normalizing: bad SRV answer \"sip.local\": " +
+			`invalid srv "sip.local": need four fields` + "\n",
+		wantList: testRewrites,
 	}, {
 		name:        "add_error",
 		url:         addURL,
@@ -228,8 +260,9 @@ func assertRewritesList(t *testing.T, handler http.Handler, wantList []*rewriteJ
 func rewriteEntriesToLegacyRewrites(entries []*rewriteJSON) (rw []*filtering.LegacyRewrite) {
 	for _, entry := range entries {
 		rw = append(rw, &filtering.LegacyRewrite{
-			Domain: entry.Domain,
-			Answer: entry.Answer,
+			Domain:     entry.Domain,
+			Answer:     entry.Answer,
+			RecordType: entry.Type,
 		})
 	}
 
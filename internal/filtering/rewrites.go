@@ -5,9 +5,11 @@ import (
 	"net/netip"
 	"strings"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"golang.org/x/exp/slices"
 )
@@ -22,20 +24,48 @@ type LegacyRewrite struct {
 	Domain string `yaml:"domain"`
 
 	// Answer is the IP address, canonical name, or one of the special
-	// values: "A" or "AAAA".
+	// values: "A" or "AAAA".  If RecordType is set to one of the other
+	// supported types, Answer is the data of the record in the same format as
+	// in the $dnsrewrite rules, for example "10 mail.example.com" for MX.
 	Answer string `yaml:"answer"`
 
+	// RecordType is the explicit type of the record, see
+	// [explicitRewriteTypes].  If empty, the type is inferred from Answer.
+	RecordType string `yaml:"type,omitempty"`
+
+	// Provenance is the record of the origin of the rewrite.  It's nil if the
+	// rewrite has been added by editing the configuration file.
+	Provenance *RuleProvenance `yaml:"provenance,omitempty"`
+
 	// IP is the IP address that should be used in the response if Type is
 	// dns.TypeA or dns.TypeAAAA.
 	IP netip.Addr `yaml:"-"`
 
-	// Type is the DNS record type: A, AAAA, or CNAME.
+	// Value is the parsed record data if Type is one of
+	// [explicitRewriteTypes].  See [rules.RRValue].
+	Value rules.RRValue `yaml:"-"`
+
+	// Type is the DNS record type: A, AAAA, CNAME, or one of
+	// [explicitRewriteTypes].
 	Type uint16 `yaml:"-"`
 }
 
+// explicitRewriteTypes are the types of records, which can only be set by
+// specifying RecordType explicitly.
+var explicitRewriteTypes = map[string]uint16{
+	"HTTPS": dns.TypeHTTPS,
+	"MX":    dns.TypeMX,
+	"PTR":   dns.TypePTR,
+	"SRV":   dns.TypeSRV,
+	"SVCB":  dns.TypeSVCB,
+	"TXT":   dns.TypeTXT,
+}
+
 // equal returns true if the rw is equal to the other.
 func (rw *LegacyRewrite) equal(other *LegacyRewrite) (ok bool) {
-	return rw.Domain == other.Domain && rw.Answer == other.Answer
+	return rw.Domain == other.Domain &&
+		rw.Answer == other.Answer &&
+		strings.EqualFold(rw.RecordType, other.RecordType)
 }
 
 // matchesQType returns true if the entry matches the question type qt.
@@ -45,6 +75,10 @@ func (rw *LegacyRewrite) matchesQType(qt uint16) (ok bool) {
 		return true
 	}
 
+	if rw.Value != nil {
+		return rw.Type == qt
+	}
+
 	// Reject types other than A and AAAA.
 	if qt != dns.TypeA && qt != dns.TypeAAAA {
 		return false
@@ -58,7 +92,8 @@ func (rw *LegacyRewrite) matchesQType(qt uint16) (ok bool) {
 // normalize makes sure that the new or decoded entry is normalized with regards
 // to domain name case, IP length, and so on.
 //
-// If rw is nil, it returns an errors.
+// If rw is nil or its answer is invalid for its record type, it returns an
+// error.
 func (rw *LegacyRewrite) normalize() (err error) {
 	if rw == nil {
 		return errors.Error("nil rewrite entry")
@@ -67,19 +102,70 @@ func (rw *LegacyRewrite) normalize() (err error) {
 	// TODO(a.garipov): Write a case-agnostic version of strings.HasSuffix and
 	// use it in matchDomainWildcard instead of using strings.ToLower
 	// everywhere.
-	rw.Domain = strings.ToLower(rw.Domain)
+	rw.Domain = aghnet.NormalizeDomain(rw.Domain)
+	rw.RecordType = strings.ToUpper(rw.RecordType)
+	rw.Value = nil
+
+	if qt, ok := explicitRewriteTypes[rw.RecordType]; ok {
+		return rw.normalizeExplicit(qt)
+	}
+
+	switch rw.RecordType {
+	case "", "A", "AAAA", "CNAME":
+		rw.normalizeInferred()
+	default:
+		return fmt.Errorf("unsupported type %q", rw.RecordType)
+	}
+
+	if rw.RecordType != "" && rw.RecordType != dns.Type(rw.Type).String() {
+		return fmt.Errorf("answer %q doesn't match type %q", rw.Answer, rw.RecordType)
+	}
+
+	return nil
+}
+
+// rewriteValueEscaper escapes the characters having special meaning within the
+// filtering rule modifiers.
+var rewriteValueEscaper = strings.NewReplacer(",", `\,`, "$", `\$`)
+
+// normalizeExplicit parses the answer of rw with an explicitly set record type
+// qt.
+func (rw *LegacyRewrite) normalizeExplicit(qt uint16) (err error) {
+	// Use the $dnsrewrite rules parser, so that the legacy rewrites and the
+	// rules accept the same values.  The domain of the rule doesn't matter.
+	text := fmt.Sprintf(
+		"|rewrite.invalid^$dnsrewrite=NOERROR;%s;%s",
+		rw.RecordType,
+		rewriteValueEscaper.Replace(rw.Answer),
+	)
+
+	nr, err := rules.NewNetworkRule(text, 0)
+	if err != nil {
+		return fmt.Errorf("bad %s answer %q: %w", rw.RecordType, rw.Answer, err)
+	} else if nr.DNSRewrite == nil || nr.DNSRewrite.Value == nil {
+		return fmt.Errorf("bad %s answer %q", rw.RecordType, rw.Answer)
+	}
 
+	rw.IP = netip.Addr{}
+	rw.Type = qt
+	rw.Value = nr.DNSRewrite.Value
+
+	return nil
+}
+
+// normalizeInferred infers the record type of rw from its answer.
+func (rw *LegacyRewrite) normalizeInferred() {
 	switch rw.Answer {
 	case "AAAA":
 		rw.IP = netip.Addr{}
 		rw.Type = dns.TypeAAAA
 
-		return nil
+		return
 	case "A":
 		rw.IP = netip.Addr{}
 		rw.Type = dns.TypeA
 
-		return nil
+		return
 	default:
 		// Go on.
 	}
@@ -89,7 +175,7 @@ func (rw *LegacyRewrite) normalize() (err error) {
 		log.Debug("normalizing legacy rewrite: %s", err)
 		rw.Type = dns.TypeCNAME
 
-		return nil
+		return
 	}
 
 	rw.IP = ip
@@ -98,8 +184,6 @@ func (rw *LegacyRewrite) normalize() (err error) {
 	} else {
 		rw.Type = dns.TypeAAAA
 	}
-
-	return nil
 }
 
 // isWildcard returns true if pat is a wildcard domain pattern.
@@ -152,52 +236,89 @@ func (d *DNSFilter) prepareRewrites() (err error) {
 // empty, but matched is true, the domain is found among the rewrite rules but
 // not for this question type.
 //
-// The result priority is: CNAME, then A and AAAA; exact, then wildcard.  If the
-// host is matched exactly, wildcard entries aren't returned.  If the host
-// matched by wildcards, return the most specific for the question type.
+// The result priority is: exact, then wildcard; CNAME, then A and AAAA.  If the
+// host is matched exactly, wildcard entries aren't returned, regardless of their
+// type, so that an exact entry is an exception from a wildcard one.  If the host
+// is matched by wildcards, return the entries of the most specific wildcard for
+// the question type.
 func findRewrites(
 	entries []*LegacyRewrite,
 	host string,
 	qtype uint16,
 ) (rewrites []*LegacyRewrite, matched bool) {
+	var wildcards []*LegacyRewrite
 	for _, e := range entries {
-		if e.Domain != host && !matchDomainWildcard(host, e.Domain) {
+		isExact := e.Domain == host
+		if !isExact && !matchDomainWildcard(host, e.Domain) {
 			continue
 		}
 
 		matched = true
-		if e.matchesQType(qtype) {
+		if !e.matchesQType(qtype) {
+			continue
+		}
+
+		if isExact {
 			rewrites = append(rewrites, e)
+		} else {
+			wildcards = append(wildcards, e)
 		}
 	}
 
 	if len(rewrites) == 0 {
-		return nil, matched
+		if len(wildcards) == 0 {
+			return nil, matched
+		}
+
+		rewrites = mostSpecificWildcards(wildcards)
 	}
 
 	slices.SortFunc(rewrites, (*LegacyRewrite).Compare)
 
-	for i, r := range rewrites {
-		if isWildcard(r.Domain) {
-			// Don't use rewrites[:0], because we need to return at least one
-			// item here.
-			rewrites = rewrites[:mathutil.Max(1, i)]
+	return rewrites, matched
+}
+
+// mostSpecificWildcards returns the entries of wildcards having the longest,
+// that is the most specific, domain pattern.  wildcards must not be empty.
+func mostSpecificWildcards(wildcards []*LegacyRewrite) (rewrites []*LegacyRewrite) {
+	maxLen := 0
+	for _, w := range wildcards {
+		maxLen = mathutil.Max(maxLen, len(w.Domain))
+	}
 
-			break
+	for _, w := range wildcards {
+		if len(w.Domain) == maxLen {
+			rewrites = append(rewrites, w)
 		}
 	}
 
-	return rewrites, matched
+	return rewrites
 }
 
-// setRewriteResult sets the Reason or IPList of res if necessary.  res must not
-// be nil.
+// setRewriteResult sets the Reason, IPList, or DNSRewriteResult of res if
+// necessary.  res must not be nil.
 func setRewriteResult(res *Result, host string, rewrites []*LegacyRewrite, qtype uint16) {
 	for _, rw := range rewrites {
-		if rw.Type == qtype && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		if rw.Type == qtype && rw.Value != nil {
+			if res.DNSRewriteResult == nil {
+				res.DNSRewriteResult = &DNSRewriteResult{
+					Response: DNSRewriteResultResponse{},
+					RCode:    dns.RcodeSuccess,
+				}
+			}
+
+			res.DNSRewriteResult.Response[qtype] = append(res.DNSRewriteResult.Response[qtype], rw.Value)
+
+			log.Debug("rewrite: %s for %s is %q", dns.Type(qtype), host, rw.Answer)
+		} else if rw.Type == qtype && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
 			if rw.IP == (netip.Addr{}) {
-				// "A"/"AAAA" exception: allow getting from upstream.
-				res.Reason = NotFilteredNotFound
+				// "A"/"AAAA" exception: allow getting from upstream.  If host is
+				// a CNAME target, keep the result, so that the target is
+				// resolved instead of the original host.
+				res.IPList = nil
+				if res.CanonName == "" {
+					res.Reason = NotFilteredNotFound
+				}
 
 				return
 			}
@@ -214,10 +335,13 @@ func cloneRewrites(entries []*LegacyRewrite) (clone []*LegacyRewrite) {
 	clone = make([]*LegacyRewrite, len(entries))
 	for i, rw := range entries {
 		clone[i] = &LegacyRewrite{
-			Domain: rw.Domain,
-			Answer: rw.Answer,
-			IP:     rw.IP,
-			Type:   rw.Type,
+			Domain:     rw.Domain,
+			Answer:     rw.Answer,
+			RecordType: rw.RecordType,
+			Provenance: rw.Provenance,
+			IP:         rw.IP,
+			Value:      rw.Value,
+			Type:       rw.Type,
 		}
 	}
 
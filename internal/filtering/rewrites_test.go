@@ -5,6 +5,8 @@ import (
 	"net/netip"
 	"testing"
 
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -400,3 +402,223 @@ func TestRewritesExceptionIP(t *testing.T) {
 		})
 	}
 }
+
+func TestRewrites_wildcardExceptionsAndCNAME(t *testing.T) {
+	filters := []Filter{{
+		ID: 0, Data: []byte("||blocked.example^\n"),
+	}}
+	d, setts := newForTest(t, nil, filters)
+	t.Cleanup(d.Close)
+
+	var (
+		devAddr   = netip.AddrFrom4([4]byte{10, 0, 0, 5})
+		buildAddr = netip.AddrFrom4([4]byte{10, 0, 0, 6})
+	)
+
+	d.conf.Rewrites = []*LegacyRewrite{{
+		Domain: "*.dev.local",
+		Answer: devAddr.String(),
+	}, {
+		// An exception from the wildcard above.
+		Domain: "ci.dev.local",
+		Answer: "ci.dev.local",
+	}, {
+		Domain: "alias.example",
+		Answer: "ci.dev.local",
+	}, {
+		Domain: "*.build.local",
+		Answer: "main.build.local",
+	}, {
+		// An exact entry takes precedence over the wildcard CNAME.
+		Domain: "main.build.local",
+		Answer: buildAddr.String(),
+	}, {
+		Domain: "v6only.example",
+		Answer: "target.example",
+	}, {
+		Domain: "target.example",
+		Answer: "AAAA",
+	}, {
+		Domain: "to-blocked.example",
+		Answer: "blocked.example",
+	}}
+
+	require.NoError(t, d.prepareRewrites())
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantCName  string
+		wantIPs    []netip.Addr
+		wantReason Reason
+		qtype      uint16
+	}{{
+		name:       "wildcard",
+		host:       "www.dev.local",
+		wantCName:  "",
+		wantIPs:    []netip.Addr{devAddr},
+		wantReason: Rewritten,
+		qtype:      dns.TypeA,
+	}, {
+		name:       "wildcard_exception",
+		host:       "ci.dev.local",
+		wantCName:  "",
+		wantIPs:    nil,
+		wantReason: NotFilteredNotFound,
+		qtype:      dns.TypeA,
+	}, {
+		name:       "cname_to_exception",
+		host:       "alias.example",
+		wantCName:  "ci.dev.local",
+		wantIPs:    nil,
+		wantReason: Rewritten,
+		qtype:      dns.TypeA,
+	}, {
+		name:       "wildcard_cname_to_exact",
+		host:       "www.build.local",
+		wantCName:  "main.build.local",
+		wantIPs:    []netip.Addr{buildAddr},
+		wantReason: Rewritten,
+		qtype:      dns.TypeA,
+	}, {
+		name:       "exact_over_wildcard_cname",
+		host:       "main.build.local",
+		wantCName:  "",
+		wantIPs:    []netip.Addr{buildAddr},
+		wantReason: Rewritten,
+		qtype:      dns.TypeA,
+	}, {
+		name:       "cname_to_type_exception",
+		host:       "v6only.example",
+		wantCName:  "target.example",
+		wantIPs:    nil,
+		wantReason: Rewritten,
+		qtype:      dns.TypeAAAA,
+	}, {
+		name:       "cname_to_blocked",
+		host:       "to-blocked.example",
+		wantCName:  "",
+		wantIPs:    nil,
+		wantReason: FilteredBlockList,
+		qtype:      dns.TypeA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, tc.qtype, setts)
+			require.NoError(t, err)
+
+			assert.Equalf(t, tc.wantReason, res.Reason, "got %s", res.Reason)
+			assert.Equal(t, tc.wantCName, res.CanonName)
+			assert.Equal(t, tc.wantIPs, res.IPList)
+		})
+	}
+}
+
+func TestRewrites_recordTypes(t *testing.T) {
+	d, _ := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.conf.Rewrites = []*LegacyRewrite{{
+		Domain:     "_sip._udp.example.local",
+		Answer:     "10 5 5060 sip.example.local",
+		RecordType: "srv",
+	}, {
+		Domain:     "svc.example.local",
+		Answer:     "1 . alpn=h2,h3",
+		RecordType: "HTTPS",
+	}, {
+		Domain:     "5.0.0.10.in-addr.arpa",
+		Answer:     "host.example.local.",
+		RecordType: "PTR",
+	}, {
+		Domain:     "host.example.local",
+		Answer:     "10.0.0.5",
+		RecordType: "A",
+	}}
+
+	require.NoError(t, d.prepareRewrites())
+
+	testCases := []struct {
+		want  rules.RRValue
+		name  string
+		host  string
+		qtype uint16
+	}{{
+		want: &rules.DNSSRV{
+			Target:   "sip.example.local",
+			Priority: 10,
+			Weight:   5,
+			Port:     5060,
+		},
+		name:  "srv",
+		host:  "_sip._udp.example.local",
+		qtype: dns.TypeSRV,
+	}, {
+		want: &rules.DNSSVCB{
+			Params:   map[string]string{"alpn": "h2,h3"},
+			Target:   ".",
+			Priority: 1,
+		},
+		name:  "https",
+		host:  "svc.example.local",
+		qtype: dns.TypeHTTPS,
+	}, {
+		want:  "host.example.local.",
+		name:  "ptr",
+		host:  "5.0.0.10.in-addr.arpa",
+		qtype: dns.TypePTR,
+	}, {
+		want:  nil,
+		name:  "other_type",
+		host:  "svc.example.local",
+		qtype: dns.TypeA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := d.processRewrites(tc.host, tc.qtype)
+			require.Equal(t, Rewritten, res.Reason)
+
+			if tc.want == nil {
+				assert.Nil(t, res.DNSRewriteResult)
+
+				return
+			}
+
+			require.NotNil(t, res.DNSRewriteResult)
+
+			assert.Equal(t, []rules.RRValue{tc.want}, res.DNSRewriteResult.Response[tc.qtype])
+		})
+	}
+}
+
+func TestLegacyRewrite_normalize_errors(t *testing.T) {
+	testCases := []struct {
+		rw         *LegacyRewrite
+		name       string
+		wantErrMsg string
+	}{{
+		rw:         nil,
+		name:       "nil",
+		wantErrMsg: "nil rewrite entry",
+	}, {
+		rw:         &LegacyRewrite{Domain: "a.local", Answer: "b.local", RecordType: "NS"},
+		name:       "unsupported",
+		wantErrMsg: `unsupported type "NS"`,
+	}, {
+		rw:         &LegacyRewrite{Domain: "a.local", Answer: "b.local", RecordType: "A"},
+		name:       "mismatch",
+		wantErrMsg: `answer "b.local" doesn't match type "A"`,
+	}, {
+		rw:         &LegacyRewrite{Domain: "a.local", Answer: "mail.local", RecordType: "MX"},
+		name:       "bad_mx",
+		wantErrMsg: `bad MX answer "mail.local": invalid mx: "mail.local"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.rw.normalize())
+		})
+	}
+}
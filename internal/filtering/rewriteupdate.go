@@ -0,0 +1,65 @@
+package filtering
+
+import (
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// NewLegacyRewrite returns a new normalized legacy rewrite of domain to answer
+// with the explicit record type recordType, which may be empty.  See
+// [LegacyRewrite].
+func NewLegacyRewrite(
+	domain string,
+	recordType string,
+	answer string,
+	p *RuleProvenance,
+) (rw *LegacyRewrite, err error) {
+	rw = &LegacyRewrite{
+		Domain:     domain,
+		Answer:     answer,
+		RecordType: recordType,
+		Provenance: p,
+	}
+
+	err = rw.normalize()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return rw, nil
+}
+
+// ModifyRewrites calls f with a copy of the list of the legacy rewrites and
+// replaces the list with the result, if f reports a change.  f is called with
+// the configuration locked, so that the changes are atomic.  f must not modify
+// the items of the list.
+func (d *DNSFilter) ModifyRewrites(
+	f func(rws []*LegacyRewrite) (res []*LegacyRewrite, changed bool, err error),
+) (err error) {
+	changed := false
+	defer func() {
+		if changed {
+			d.conf.ConfigModified()
+		}
+	}()
+
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	res, changed, err := f(slices.Clone(d.conf.Rewrites))
+	if err != nil {
+		changed = false
+
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if !changed {
+		return nil
+	}
+
+	log.Debug("rewrite: modified: %d elements, was %d", len(res), len(d.conf.Rewrites))
+
+	d.conf.Rewrites = res
+
+	return nil
+}
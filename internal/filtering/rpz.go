@@ -0,0 +1,451 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// URL schemes of the response policy zones downloaded using the zone transfer.
+// The URLs look like "axfr://192.0.2.1:53/rpz.example", where the path is the
+// name of the zone.  The default port is 53.
+const (
+	schemeAXFR = "axfr"
+	schemeIXFR = "ixfr"
+)
+
+// xfrTimeout is the timeout for the network operations of a zone transfer.
+const xfrTimeout = 30 * time.Second
+
+// minRPZRefreshIvl is the minimum interval between the refreshes of a response
+// policy zone, regardless of its SOA timers.
+const minRPZRefreshIvl = 1 * time.Minute
+
+// errRPZNotChanged is returned from [readerFromXFR] when the zone
+// hasn't changed since the last transfer.
+const errRPZNotChanged errors.Error = "zone not changed"
+
+// rpzInfo is the information about a filter list translated from a response
+// policy zone (RPZ).  It must not be modified after creation.
+type rpzInfo struct {
+	// serial is the serial number of the zone.
+	serial uint32
+
+	// refresh is the interval between the refreshes of the zone.
+	refresh time.Duration
+
+	// retry is the interval between the refreshes of the zone after a failed
+	// one.
+	retry time.Duration
+
+	// failed is true if the last refresh of the zone has failed.
+	failed bool
+}
+
+// rpzInfoPrefix is the prefix of the comment line containing the rpzInfo in
+// the translated filter list.
+const rpzInfoPrefix = "! RPZ: "
+
+// newRPZInfo returns the information about the zone with the start of
+// authority record soa.
+func newRPZInfo(soa *dns.SOA) (info *rpzInfo) {
+	return &rpzInfo{
+		serial:  soa.Serial,
+		refresh: time.Duration(soa.Refresh) * time.Second,
+		retry:   time.Duration(soa.Retry) * time.Second,
+	}
+}
+
+// updateIvl returns the interval between the refreshes of the zone.
+func (info *rpzInfo) updateIvl() (ivl time.Duration) {
+	ivl = info.refresh
+	if info.failed {
+		ivl = info.retry
+	}
+
+	return mathutil.Max(ivl, minRPZRefreshIvl)
+}
+
+// withFailed returns a copy of info with the failed flag set to failed.
+func (info *rpzInfo) withFailed(failed bool) (cloned *rpzInfo) {
+	c := *info
+	c.failed = failed
+
+	return &c
+}
+
+// rpzSniffLen is the maximum number of the bytes at the start of a filter list
+// inspected to detect a response policy zone.
+const rpzSniffLen = 4096
+
+// isRPZ returns true if the data read from br looks like a zone file, that is
+// its first meaningful line is either a control entry or a start of authority
+// record.
+func isRPZ(br *bufio.Reader) (ok bool) {
+	// Ignore the error, since the data may well be shorter than rpzSniffLen.
+	data, _ := br.Peek(rpzSniffLen)
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == ';' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "$ORIGIN") || strings.HasPrefix(line, "$TTL") {
+			return true
+		}
+
+		fields := strings.Fields(line)
+
+		// The type of a record follows the owner name, the optional TTL, and
+		// the optional class.
+		for _, f := range fields[1:mathutil.Min(len(fields), 4)] {
+			if strings.EqualFold(f, "SOA") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// parseRPZ translates the response policy zone read from src into the
+// filtering rules and parses them into dst the way [rulelist.Parser.Parse]
+// does.  The rules are preceded by the info comment line, see [readRPZInfo].
+// buf is used for the parsing.
+func parseRPZ(
+	dst io.Writer,
+	src io.Reader,
+	buf []byte,
+) (res *rulelist.ParseResult, info *rpzInfo, err error) {
+	translated := &bytes.Buffer{}
+	info, err = translateRPZ(translated, src)
+	if err != nil {
+		return &rulelist.ParseResult{}, nil, fmt.Errorf("translating rpz: %w", err)
+	}
+
+	_, err = fmt.Fprintf(
+		dst,
+		"%sserial=%d refresh=%d retry=%d\n",
+		rpzInfoPrefix,
+		info.serial,
+		int(info.refresh.Seconds()),
+		int(info.retry.Seconds()),
+	)
+	if err != nil {
+		return &rulelist.ParseResult{}, nil, fmt.Errorf("writing rpz info: %w", err)
+	}
+
+	res, err = rulelist.NewParser().Parse(dst, translated, buf)
+
+	return res, info, err
+}
+
+// rpzChanged returns true if the serial numbers of the zones described by prev
+// and info differ.  Both may be nil.
+func rpzChanged(prev, info *rpzInfo) (ok bool) {
+	if prev == nil || info == nil {
+		return prev != info
+	}
+
+	return prev.serial != info.serial
+}
+
+// rpzTriggerLabels are the last labels of the triggers, which aren't based on
+// the requested domain name, and so aren't supported.
+var rpzTriggerLabels = []string{
+	"rpz-client-ip",
+	"rpz-ip",
+	"rpz-nsdname",
+	"rpz-nsip",
+}
+
+// translateRPZ reads the response policy zone in the zone file format from src
+// and writes the equivalent filtering rules into dst.  The first record must be
+// the start of authority one, which name is the origin of the zone.
+//
+// Only the triggers based on the requested domain name are supported.  The
+// NXDOMAIN, NODATA, and Local-Data actions are translated into the $dnsrewrite
+// rules and the PASSTHRU action is translated into the exception rules.  The
+// other triggers, actions, and types of Local-Data records are skipped.
+func translateRPZ(dst io.Writer, src io.Reader) (info *rpzInfo, err error) {
+	zp := dns.NewZoneParser(src, "", "")
+
+	var origin string
+	var skipped int
+	w := bufio.NewWriter(dst)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if info == nil {
+			soa, isSOA := rr.(*dns.SOA)
+			if !isSOA {
+				return nil, fmt.Errorf("first record is %s, not soa", dns.TypeToString[rr.Header().Rrtype])
+			}
+
+			info = newRPZInfo(soa)
+			origin = strings.ToLower(soa.Hdr.Name)
+
+			_, _ = fmt.Fprintf(w, "! Title: %s\n", strings.TrimSuffix(origin, "."))
+
+			continue
+		}
+
+		trigger, isTrigger := rpzTrigger(rr.Header().Name, origin)
+		if !isTrigger {
+			// Records of the zone apex, like NS, aren't policies.
+			continue
+		}
+
+		rulesText := rpzRules(trigger, rr)
+		if len(rulesText) == 0 {
+			skipped++
+
+			continue
+		}
+
+		for _, text := range rulesText {
+			_, _ = w.WriteString(text)
+			_ = w.WriteByte('\n')
+		}
+	}
+
+	err = zp.Err()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	} else if info == nil {
+		return nil, errors.Error("no soa record")
+	}
+
+	if skipped > 0 {
+		log.Debug("filtering: rpz %q: skipped %d unsupported records", origin, skipped)
+	}
+
+	return info, w.Flush()
+}
+
+// rpzTrigger returns the trigger, that is the owner name relative to the zone
+// origin, of the record with the owner name.  ok is false if the name isn't a
+// trigger.
+func rpzTrigger(name, origin string) (trigger string, ok bool) {
+	trigger, ok = strings.CutSuffix(strings.ToLower(name), "."+origin)
+	if !ok || trigger == "" {
+		return "", false
+	}
+
+	lastLabel := trigger[strings.LastIndexByte(trigger, '.')+1:]
+	for _, l := range rpzTriggerLabels {
+		if lastLabel == l {
+			return "", false
+		}
+	}
+
+	return trigger, true
+}
+
+// rpzRules returns the texts of the filtering rules equivalent to the policy
+// record rr of trigger.  rulesText is empty if the policy isn't supported.
+func rpzRules(trigger string, rr dns.RR) (rulesText []string) {
+	// An exact trigger only matches the domain name itself and a wildcard one
+	// only matches its subdomains.
+	pattern := "|" + trigger + "^"
+	if host, ok := strings.CutPrefix(trigger, "*."); ok {
+		pattern = "||*." + host + "^"
+	}
+
+	var dnsrewrite string
+	switch rr := rr.(type) {
+	case *dns.CNAME:
+		switch target := strings.ToLower(rr.Target); target {
+		case ".":
+			dnsrewrite = "NXDOMAIN"
+		case "*.":
+			// NODATA.
+			dnsrewrite = "NOERROR"
+		case "rpz-passthru.":
+			// Also cancel the rewrites of the wildcard triggers matching the
+			// domain name.
+			return validRules("@@"+pattern, "@@"+pattern+"$dnsrewrite")
+		default:
+			if strings.HasPrefix(target, "*.") || strings.HasPrefix(target, "rpz-") {
+				// The rewrites relative to the requested domain name and the
+				// actions like rpz-drop aren't supported.
+				return nil
+			}
+
+			dnsrewrite = "NOERROR;CNAME;" + strings.TrimSuffix(target, ".")
+		}
+	case *dns.A:
+		dnsrewrite = "NOERROR;A;" + rr.A.String()
+	case *dns.AAAA:
+		dnsrewrite = "NOERROR;AAAA;" + rr.AAAA.String()
+	case *dns.MX:
+		dnsrewrite = fmt.Sprintf("NOERROR;MX;%d %s", rr.Preference, strings.TrimSuffix(rr.Mx, "."))
+	case *dns.PTR:
+		dnsrewrite = "NOERROR;PTR;" + rr.Ptr
+	case *dns.SRV:
+		dnsrewrite = fmt.Sprintf(
+			"NOERROR;SRV;%d %d %d %s",
+			rr.Priority,
+			rr.Weight,
+			rr.Port,
+			strings.TrimSuffix(rr.Target, "."),
+		)
+	case *dns.TXT:
+		dnsrewrite = "NOERROR;TXT;" + rewriteValueEscaper.Replace(strings.Join(rr.Txt, ""))
+	default:
+		return nil
+	}
+
+	return validRules(pattern + "$dnsrewrite=" + dnsrewrite)
+}
+
+// validRules returns rulesText if all of them are valid filtering rules.
+func validRules(rulesText ...string) (valid []string) {
+	for _, text := range rulesText {
+		_, err := rules.NewNetworkRule(text, 0)
+		if err != nil {
+			log.Debug("filtering: rpz: bad rule %q: %s", text, err)
+
+			return nil
+		}
+	}
+
+	return rulesText
+}
+
+// readRPZInfo returns the information about the response policy zone, which
+// the filter list file read from br has been translated from.  info is nil if
+// it's not a translated response policy zone.
+func readRPZInfo(br *bufio.Reader) (info *rpzInfo) {
+	// Ignore the error, since the file may well be shorter than the limit.  The
+	// information is always in the first line of the file.
+	data, _ := br.Peek(128)
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+
+	infoStr, ok := strings.CutPrefix(string(line), rpzInfoPrefix)
+	if !ok {
+		return nil
+	}
+
+	var serial, refresh, retry uint32
+	_, err := fmt.Sscanf(infoStr, "serial=%d refresh=%d retry=%d", &serial, &refresh, &retry)
+	if err != nil {
+		log.Debug("filtering: bad rpz info %q: %s", infoStr, err)
+
+		return nil
+	}
+
+	return newRPZInfo(&dns.SOA{
+		Serial:  serial,
+		Refresh: refresh,
+		Retry:   retry,
+	})
+}
+
+// isXFRScheme returns true if scheme is the URL scheme of the response policy
+// zones downloaded using the zone transfer.
+func isXFRScheme(scheme string) (ok bool) {
+	return scheme == schemeAXFR || scheme == schemeIXFR
+}
+
+// readerFromXFR returns an io.ReadCloser reading the zone file of the response
+// policy zone transferred from the server at u.  prev is the information about
+// the previous version of the zone, if any.  If the scheme of u is schemeIXFR,
+// the server is asked for the changes since the previous version, and
+// [errRPZNotChanged] is returned if there are none.
+//
+// TODO(a.garipov): Apply the incremental changes instead of requesting the
+// whole zone.  This requires storing the zone itself as opposed to the
+// translated rules.
+func readerFromXFR(u *url.URL, prev *rpzInfo) (r io.ReadCloser, err error) {
+	zone := dns.Fqdn(strings.TrimPrefix(u.Path, "/"))
+	if zone == "." {
+		return nil, errors.Error("no zone name in url path")
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "53")
+	}
+
+	incremental := u.Scheme == schemeIXFR && prev != nil
+	m := &dns.Msg{}
+	if incremental {
+		m.SetIxfr(zone, prev.serial, ".", ".")
+	} else {
+		m.SetAxfr(zone)
+	}
+
+	rrs, err := transfer(m, addr)
+	if err != nil {
+		return nil, fmt.Errorf("transferring %q from %s: %w", zone, addr, err)
+	}
+
+	if incremental && len(rrs) == 1 {
+		// The single SOA record means that the zone is up to date.
+		return nil, errRPZNotChanged
+	} else if incremental && len(rrs) > 1 && rrs[1].Header().Rrtype == dns.TypeSOA {
+		log.Debug("filtering: rpz %q: got incremental changes, requesting whole zone", zone)
+
+		return readerFromXFR(&url.URL{Scheme: schemeAXFR, Host: u.Host, Path: u.Path}, prev)
+	}
+
+	// Drop the SOA record closing the whole zone.
+	if len(rrs) > 1 && rrs[len(rrs)-1].Header().Rrtype == dns.TypeSOA {
+		rrs = rrs[:len(rrs)-1]
+	}
+
+	b := &bytes.Buffer{}
+	for _, rr := range rrs {
+		_, _ = b.WriteString(rr.String())
+		_ = b.WriteByte('\n')
+	}
+
+	return io.NopCloser(b), nil
+}
+
+// transfer performs the zone transfer requested by m from the server at addr
+// and returns the received records.  The first of them is always the SOA one.
+func transfer(m *dns.Msg, addr string) (rrs []dns.RR, err error) {
+	t := &dns.Transfer{
+		DialTimeout:  xfrTimeout,
+		ReadTimeout:  xfrTimeout,
+		WriteTimeout: xfrTimeout,
+	}
+
+	envs, err := t.In(m, addr)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	for env := range envs {
+		if env.Error != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, env.Error
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	if len(rrs) == 0 || rrs[0].Header().Rrtype != dns.TypeSOA {
+		return nil, errors.Error("transfer doesn't start with soa")
+	}
+
+	return rrs, nil
+}
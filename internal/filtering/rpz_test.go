@@ -0,0 +1,258 @@
+package filtering
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRPZ is the common response policy zone for tests.
+const testRPZ = `$ORIGIN rpz.example.
+$TTL 300
+@                   SOA   ns.rpz.example. admin.rpz.example. 17 3600 600 86400 60
+@                   NS    ns.rpz.example.
+nxdomain.test       CNAME .
+*.nxdomain.test     CNAME .
+nodata.test         CNAME *.
+*.wild.test         CNAME .
+pass.wild.test      CNAME rpz-passthru.
+local.test          A     192.0.2.1
+local.test          AAAA  2001:db8::1
+local.test          TXT   "text, with $ chars"
+cname.test          CNAME target.example.
+mx.test             MX    10 mail.example.
+drop.test           CNAME rpz-drop.
+32.1.2.0.192.rpz-ip CNAME .
+`
+
+func TestTranslateRPZ(t *testing.T) {
+	b := &bytes.Buffer{}
+	info, err := translateRPZ(b, strings.NewReader(testRPZ))
+	require.NoError(t, err)
+
+	assert.Equal(t, &rpzInfo{
+		serial:  17,
+		refresh: time.Hour,
+		retry:   10 * time.Minute,
+	}, info)
+
+	want := strings.Join([]string{
+		"! Title: rpz.example",
+		"|nxdomain.test^$dnsrewrite=NXDOMAIN",
+		"||*.nxdomain.test^$dnsrewrite=NXDOMAIN",
+		"|nodata.test^$dnsrewrite=NOERROR",
+		"||*.wild.test^$dnsrewrite=NXDOMAIN",
+		"@@|pass.wild.test^",
+		"@@|pass.wild.test^$dnsrewrite",
+		"|local.test^$dnsrewrite=NOERROR;A;192.0.2.1",
+		"|local.test^$dnsrewrite=NOERROR;AAAA;2001:db8::1",
+		`|local.test^$dnsrewrite=NOERROR;TXT;text\, with \$ chars`,
+		"|cname.test^$dnsrewrite=NOERROR;CNAME;target.example",
+		"|mx.test^$dnsrewrite=NOERROR;MX;10 mail.example",
+	}, "\n") + "\n"
+	assert.Equal(t, want, b.String())
+
+	t.Run("no_soa", func(t *testing.T) {
+		_, err = translateRPZ(b, strings.NewReader("a.example. 300 IN A 192.0.2.1\n"))
+		testutil.AssertErrorMsg(t, "first record is A, not soa", err)
+	})
+}
+
+func TestDNSFilter_CheckHost_rpz(t *testing.T) {
+	b := &bytes.Buffer{}
+	_, err := translateRPZ(b, strings.NewReader(testRPZ))
+	require.NoError(t, err)
+
+	d, setts := newForTest(t, nil, []Filter{{ID: 1, Data: b.Bytes()}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		wantRes    *DNSRewriteResult
+		name       string
+		host       string
+		wantCNAME  string
+		qtype      uint16
+		wantReason Reason
+	}{{
+		wantRes:    &DNSRewriteResult{RCode: dns.RcodeNameError},
+		name:       "nxdomain",
+		host:       "nxdomain.test",
+		wantCNAME:  "",
+		qtype:      dns.TypeA,
+		wantReason: RewrittenRule,
+	}, {
+		wantRes:    &DNSRewriteResult{RCode: dns.RcodeNameError},
+		name:       "nxdomain_wildcard",
+		host:       "sub.nxdomain.test",
+		wantCNAME:  "",
+		qtype:      dns.TypeA,
+		wantReason: RewrittenRule,
+	}, {
+		wantRes:    nil,
+		name:       "nodata_subdomain",
+		host:       "sub.nodata.test",
+		wantCNAME:  "",
+		qtype:      dns.TypeA,
+		wantReason: NotFilteredNotFound,
+	}, {
+		wantRes:    nil,
+		name:       "wildcard_apex",
+		host:       "wild.test",
+		wantCNAME:  "",
+		qtype:      dns.TypeA,
+		wantReason: NotFilteredNotFound,
+	}, {
+		wantRes:    nil,
+		name:       "passthru",
+		host:       "pass.wild.test",
+		wantCNAME:  "",
+		qtype:      dns.TypeA,
+		wantReason: NotFilteredAllowList,
+	}, {
+		wantRes: &DNSRewriteResult{
+			RCode: dns.RcodeSuccess,
+			Response: DNSRewriteResultResponse{
+				dns.TypeA:    []rules.RRValue{netip.MustParseAddr("192.0.2.1")},
+				dns.TypeAAAA: []rules.RRValue{netip.MustParseAddr("2001:db8::1")},
+				dns.TypeTXT:  []rules.RRValue{"text, with $ chars"},
+			},
+		},
+		name:       "local_data",
+		host:       "local.test",
+		wantCNAME:  "",
+		qtype:      dns.TypeA,
+		wantReason: RewrittenRule,
+	}, {
+		wantRes:    nil,
+		name:       "local_cname",
+		host:       "cname.test",
+		wantCNAME:  "target.example",
+		qtype:      dns.TypeA,
+		wantReason: RewrittenRule,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cErr := d.CheckHost(tc.host, tc.qtype, setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantCNAME, res.CanonName)
+			assert.Equal(t, tc.wantRes, res.DNSRewriteResult)
+		})
+	}
+}
+
+func TestDNSFilter_Update_rpz(t *testing.T) {
+	d := newDNSFilter(t)
+
+	f := &FilterYAML{
+		URL: serveFiltersLocally(t, []byte(testRPZ)),
+	}
+
+	updateAndAssert(t, d, f, require.True, 11)
+	assert.Equal(t, "rpz.example", f.Name)
+
+	require.NotNil(t, f.rpz)
+
+	assert.Equal(t, uint32(17), f.rpz.serial)
+	assert.Equal(t, time.Hour, d.updateIvl(f))
+
+	f.rpz = f.rpz.withFailed(true)
+	assert.Equal(t, 10*time.Minute, d.updateIvl(f))
+
+	t.Run("refresh_idle", func(t *testing.T) {
+		updateAndAssert(t, d, f, require.False, 11)
+	})
+}
+
+// serveZoneLocally starts a DNS server serving the transfers of the zone
+// parsed from zoneData over TCP.  The server responds to the IXFR requests with
+// the current serial with the single SOA record.
+func serveZoneLocally(t *testing.T, zoneData string) (addr string) {
+	t.Helper()
+
+	var rrs []dns.RR
+	zp := dns.NewZoneParser(strings.NewReader(zoneData), "", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	require.NoError(t, zp.Err())
+
+	soa := testutil.RequireTypeAssert[*dns.SOA](t, rrs[0])
+
+	h := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		pt := testutil.PanicT{}
+
+		env := &dns.Envelope{RR: append(append([]dns.RR{}, rrs...), soa)}
+		if q := req.Question[0]; q.Qtype == dns.TypeIXFR {
+			reqSOA, ok := req.Ns[0].(*dns.SOA)
+			require.True(pt, ok)
+
+			if reqSOA.Serial == soa.Serial {
+				env.RR = []dns.RR{soa}
+			}
+		}
+
+		ch := make(chan *dns.Envelope, 1)
+		ch <- env
+		close(ch)
+
+		tr := &dns.Transfer{}
+		require.NoError(pt, tr.Out(w, req, ch))
+		require.NoError(pt, w.Close())
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &dns.Server{
+		Listener: l,
+		Handler:  h,
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	return l.Addr().String()
+}
+
+func TestReaderFromXFR(t *testing.T) {
+	addr := serveZoneLocally(t, testRPZ)
+
+	u := &url.URL{Scheme: schemeIXFR, Host: addr, Path: "/rpz.example"}
+
+	r, err := readerFromXFR(u, nil)
+	require.NoError(t, err)
+
+	b := &bytes.Buffer{}
+	info, err := translateRPZ(b, r)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(17), info.serial)
+	assert.Contains(t, b.String(), "|nxdomain.test^$dnsrewrite=NXDOMAIN\n")
+
+	t.Run("not_changed", func(t *testing.T) {
+		_, err = readerFromXFR(u, info)
+		assert.ErrorIs(t, err, errRPZNotChanged)
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		_, err = readerFromXFR(u, &rpzInfo{serial: 16})
+		assert.NoError(t, err)
+	})
+
+	t.Run("no_zone", func(t *testing.T) {
+		_, err = readerFromXFR(&url.URL{Scheme: schemeAXFR, Host: addr}, nil)
+		testutil.AssertErrorMsg(t, "no zone name in url path", err)
+	})
+}
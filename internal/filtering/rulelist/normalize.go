@@ -0,0 +1,93 @@
+package rulelist
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+)
+
+// NormalizeRules returns text with each of the rules normalized using
+// [NormalizeRule].
+func NormalizeRules(text string) (norm string) {
+	if isASCII([]byte(text)) {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = string(NormalizeRule([]byte(l)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// NormalizeRule converts the internationalized domain names within the pattern
+// of the rule into punycode using [aghnet.NormalizeDomain], so that the rules
+// written in unicode match the queries clients actually send.  Comments,
+// regular expressions, and the modifiers are left as is, since the latter may
+// contain unicode values, such as client names.  rule is returned as is if it
+// only contains ASCII characters.
+func NormalizeRule(rule []byte) (norm []byte) {
+	if isASCII(rule) {
+		return rule
+	}
+
+	trimmed := bytes.TrimSpace(rule)
+	if len(trimmed) == 0 || trimmed[0] == '!' || trimmed[0] == '#' || trimmed[0] == '/' {
+		return rule
+	}
+
+	pattern, modifiers := rule, []byte(nil)
+	if i := bytes.IndexByte(rule, '$'); i >= 0 {
+		pattern, modifiers = rule[:i], rule[i:]
+	}
+
+	norm = make([]byte, 0, len(rule))
+	for len(pattern) > 0 {
+		i := bytes.IndexFunc(pattern, isDomainRune)
+		if i < 0 {
+			norm = append(norm, pattern...)
+
+			break
+		}
+
+		norm = append(norm, pattern[:i]...)
+		pattern = pattern[i:]
+
+		j := bytes.IndexFunc(pattern, func(r rune) (ok bool) { return !isDomainRune(r) })
+		if j < 0 {
+			j = len(pattern)
+		}
+
+		name := pattern[:j]
+		pattern = pattern[j:]
+		if isASCII(name) {
+			norm = append(norm, name...)
+		} else {
+			norm = append(norm, aghnet.NormalizeDomain(string(name))...)
+		}
+	}
+
+	return append(norm, modifiers...)
+}
+
+// isDomainRune returns true if r may be a part of a domain name or a domain
+// name pattern.
+func isDomainRune(r rune) (ok bool) {
+	return r == '.' || r == '-' || r == '_' || r == '*' ||
+		unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// isASCII returns true if b only contains ASCII characters.
+func isASCII(b []byte) (ok bool) {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
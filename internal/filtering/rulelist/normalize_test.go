@@ -0,0 +1,55 @@
+package rulelist_test
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeRule(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		rule string
+		want string
+	}{{
+		name: "ascii",
+		rule: "||Example.ORG^",
+		want: "||Example.ORG^",
+	}, {
+		name: "adblock",
+		rule: "||Пример.РФ^",
+		want: "||xn--e1afmkfd.xn--p1ai^",
+	}, {
+		name: "hosts",
+		rule: "0.0.0.0 пример.рф www.пример.рф",
+		want: "0.0.0.0 xn--e1afmkfd.xn--p1ai www.xn--e1afmkfd.xn--p1ai",
+	}, {
+		name: "wildcard",
+		rule: "@@||*.пример.рф^",
+		want: "@@||*.xn--e1afmkfd.xn--p1ai^",
+	}, {
+		name: "modifiers",
+		rule: "||пример.рф^$client='Мой телефон'",
+		want: "||xn--e1afmkfd.xn--p1ai^$client='Мой телефон'",
+	}, {
+		name: "regexp",
+		rule: "/пример/",
+		want: "/пример/",
+	}, {
+		name: "comment",
+		rule: "! Пример",
+		want: "! Пример",
+	}}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, string(rulelist.NormalizeRule([]byte(tc.rule))))
+		})
+	}
+}
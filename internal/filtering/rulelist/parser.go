@@ -116,6 +116,8 @@ func (p *Parser) processLine(dst io.Writer, line []byte, lineNum int) (n int, er
 		return 0, nil
 	}
 
+	trimmed = NormalizeRule(trimmed)
+
 	p.rulesCount++
 	p.checksum = crc32.Update(p.checksum, crc32.IEEETable, trimmed)
 
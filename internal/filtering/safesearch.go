@@ -26,11 +26,26 @@ type SafeSearchConfig struct {
 	// enabled or disabled.
 
 	Bing       bool `yaml:"bing" json:"bing"`
+	Brave      bool `yaml:"brave" json:"brave"`
 	DuckDuckGo bool `yaml:"duckduckgo" json:"duckduckgo"`
 	Google     bool `yaml:"google" json:"google"`
 	Pixabay    bool `yaml:"pixabay" json:"pixabay"`
 	Yandex     bool `yaml:"yandex" json:"yandex"`
 	YouTube    bool `yaml:"youtube" json:"youtube"`
+
+	// Custom are the custom mappings of the hosts to their safe versions,
+	// which are applied in addition to the ones of the services.
+	Custom []*SafeSearchMapping `yaml:"custom,omitempty" json:"custom,omitempty"`
+}
+
+// SafeSearchMapping is a custom safe search mapping of a host to its safe
+// version, which the host is rewritten to using a CNAME record.
+type SafeSearchMapping struct {
+	// Host is the domain name of the search engine.
+	Host string `yaml:"host" json:"host"`
+
+	// SafeHost is the domain name of the safe version of the search engine.
+	SafeHost string `yaml:"safe_host" json:"safe_host"`
 }
 
 // checkSafeSearch checks host with safe search engine.  Matches
@@ -2,6 +2,9 @@ package safesearch
 
 import _ "embed"
 
+//go:embed rules/brave.txt
+var brave string
+
 //go:embed rules/bing.txt
 var bing string
 
@@ -26,6 +29,7 @@ var youtube string
 // https://adguardteam.github.io/HostlistsRegistry/assets/youtube_safe_search.txt.
 var safeSearchRules = map[Service]string{
 	Bing:       bing,
+	Brave:      brave,
 	DuckDuckGo: duckduckgo,
 	Google:     google,
 	Pixabay:    pixabay,
@@ -15,7 +15,9 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/urlfilter"
 	"github.com/AdguardTeam/urlfilter/filterlist"
 	"github.com/AdguardTeam/urlfilter/rules"
@@ -28,6 +30,7 @@ type Service string
 // Service enum members.
 const (
 	Bing       Service = "bing"
+	Brave      Service = "brave"
 	DuckDuckGo Service = "duckduckgo"
 	Google     Service = "google"
 	Pixabay    Service = "pixabay"
@@ -40,6 +43,8 @@ func isServiceProtected(s filtering.SafeSearchConfig, service Service) (ok bool)
 	switch service {
 	case Bing:
 		return s.Bing
+	case Brave:
+		return s.Brave
 	case DuckDuckGo:
 		return s.DuckDuckGo
 	case Google:
@@ -141,6 +146,12 @@ func (ss *Default) resetEngine(
 		}
 	}
 
+	err = writeCustomRules(&sb, conf.Custom)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	strList := &filterlist.StringRuleList{
 		ID:             listID,
 		RulesText:      sb.String(),
@@ -159,6 +170,42 @@ func (ss *Default) resetEngine(
 	return nil
 }
 
+// writeCustomRules validates the custom mappings and writes the rules for them
+// into sb.
+func writeCustomRules(sb *strings.Builder, mappings []*filtering.SafeSearchMapping) (err error) {
+	for i, m := range mappings {
+		err = validateMapping(m)
+		if err != nil {
+			return fmt.Errorf("custom mapping at index %d: %w", i, err)
+		}
+
+		// Use the same format as the rules of the built-in services.
+		_, _ = fmt.Fprintf(sb, "\n|%s^$dnsrewrite=NOERROR;CNAME;%s\n", m.Host, m.SafeHost)
+	}
+
+	return nil
+}
+
+// validateMapping returns an error if m isn't a valid custom safe search
+// mapping.
+func validateMapping(m *filtering.SafeSearchMapping) (err error) {
+	if m == nil {
+		return errors.Error("no value")
+	}
+
+	err = netutil.ValidateDomainName(m.Host)
+	if err != nil {
+		return fmt.Errorf("host: %w", err)
+	}
+
+	err = netutil.ValidateDomainName(m.SafeHost)
+	if err != nil {
+		return fmt.Errorf("safe host: %w", err)
+	}
+
+	return nil
+}
+
 // type check
 var _ filtering.SafeSearch = (*Default)(nil)
 
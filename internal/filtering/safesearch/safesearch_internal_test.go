@@ -27,6 +27,7 @@ const (
 var defaultSafeSearchConf = filtering.SafeSearchConfig{
 	Enabled:    true,
 	Bing:       true,
+	Brave:      true,
 	DuckDuckGo: true,
 	Google:     true,
 	Pixabay:    true,
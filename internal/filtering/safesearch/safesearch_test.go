@@ -35,6 +35,7 @@ var testConf = filtering.SafeSearchConfig{
 	Enabled: true,
 
 	Bing:       true,
+	Brave:      true,
 	DuckDuckGo: true,
 	Google:     true,
 	Pixabay:    true,
@@ -183,6 +184,63 @@ func TestDefault_CheckHost_duckduckgoAAAA(t *testing.T) {
 	assert.EqualValues(t, filtering.SafeSearchListID, res.Rules[0].FilterListID)
 }
 
+func TestDefault_CheckHost_custom(t *testing.T) {
+	const safeHost = "safe.search.example"
+
+	conf := testConf
+	conf.CustomResolver = &testResolver{
+		OnLookupIP: func(_ context.Context, _, host string) (ips []net.IP, err error) {
+			assert.Equal(t, safeHost, host)
+
+			ip4, _ := aghtest.HostToIPs(host)
+
+			return []net.IP{ip4.AsSlice()}, nil
+		},
+	}
+	conf.Custom = []*filtering.SafeSearchMapping{{
+		Host:     "search.example",
+		SafeHost: safeHost,
+	}}
+
+	ss, err := safesearch.NewDefault(conf, "", testCacheSize, testCacheTTL)
+	require.NoError(t, err)
+
+	wantIP, _ := aghtest.HostToIPs(safeHost)
+
+	res, err := ss.CheckHost("search.example", testQType)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+
+	require.Len(t, res.Rules, 1)
+
+	assert.Equal(t, wantIP, res.Rules[0].IP)
+	assert.EqualValues(t, filtering.SafeSearchListID, res.Rules[0].FilterListID)
+
+	res, err = ss.CheckHost("other.example", testQType)
+	require.NoError(t, err)
+
+	assert.False(t, res.IsFiltered)
+}
+
+func TestDefault_Update_badCustom(t *testing.T) {
+	ss, err := safesearch.NewDefault(testConf, "", testCacheSize, testCacheTTL)
+	require.NoError(t, err)
+
+	conf := testConf
+	conf.Custom = []*filtering.SafeSearchMapping{{
+		Host:     "search.example",
+		SafeHost: "",
+	}}
+
+	err = ss.Update(conf)
+	testutil.AssertErrorMsg(
+		t,
+		`custom mapping at index 0: safe host: bad domain name "": domain name is empty`,
+		err,
+	)
+}
+
 func TestDefault_Update(t *testing.T) {
 	conf := testConf
 	ss, err := safesearch.NewDefault(conf, "", testCacheSize, testCacheTTL)
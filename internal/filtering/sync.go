@@ -0,0 +1,278 @@
+package filtering
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// SyncedConfig is the part of the filtering configuration, which the replica
+// instances mirror from the primary one.
+type SyncedConfig struct {
+	// BlockedServices is the configuration of the globally blocked services.
+	BlockedServices *BlockedServices `yaml:"blocked_services"`
+
+	// Filters are the blocking filter lists.
+	Filters []*SyncedFilter `yaml:"filters"`
+
+	// WhitelistFilters are the allowing filter lists.
+	WhitelistFilters []*SyncedFilter `yaml:"whitelist_filters"`
+
+	// Rewrites are the legacy DNS rewrites.
+	Rewrites []*LegacyRewrite `yaml:"rewrites"`
+
+	// UserRules is the global list of custom rules.
+	UserRules []string `yaml:"user_rules"`
+}
+
+// SyncedFilter is a filter list in [SyncedConfig].  The lists are matched by
+// their URLs, since the IDs and the downloaded contents are specific to each
+// instance.
+type SyncedFilter struct {
+	// Name is the human-readable name of the list.
+	Name string `yaml:"name"`
+
+	// URL is the URL of the list.
+	URL string `yaml:"url"`
+
+	// Enabled defines if the list is enabled.
+	Enabled bool `yaml:"enabled"`
+
+	// MonitorOnly defines if the list is monitor-only, see
+	// [FilterYAML.MonitorOnly].
+	MonitorOnly bool `yaml:"monitor_only"`
+}
+
+// SyncedConfig returns the part of the configuration of d, which is mirrored to
+// the replicas.  The filter lists with local file paths aren't included, since
+// they are specific to the instance.
+func (d *DNSFilter) SyncedConfig() (c *SyncedConfig) {
+	c = &SyncedConfig{}
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		c.BlockedServices = d.conf.BlockedServices.Clone()
+		c.Rewrites = cloneRewrites(d.conf.Rewrites)
+	}()
+
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	c.Filters = toSyncedFilters(d.conf.Filters)
+	c.WhitelistFilters = toSyncedFilters(d.conf.WhitelistFilters)
+	c.UserRules = slices.Clone(d.conf.UserRules)
+
+	return c
+}
+
+// toSyncedFilters converts filters to their synced form skipping the lists
+// with local file paths.
+func toSyncedFilters(filters []FilterYAML) (synced []*SyncedFilter) {
+	synced = []*SyncedFilter{}
+	for _, flt := range filters {
+		if filepath.IsAbs(flt.URL) {
+			continue
+		}
+
+		synced = append(synced, &SyncedFilter{
+			Name:        flt.Name,
+			URL:         flt.URL,
+			Enabled:     flt.Enabled,
+			MonitorOnly: flt.MonitorOnly,
+		})
+	}
+
+	return synced
+}
+
+// ApplySyncedConfig replaces the corresponding part of the configuration of d
+// with c, which it takes ownership of.  The lists, which are new to d, are
+// downloaded asynchronously.  The lists of d with local file paths are kept.
+func (d *DNSFilter) ApplySyncedConfig(c *SyncedConfig) (err error) {
+	err = validateSyncedConfig(c)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if c.BlockedServices.Schedule == nil {
+		c.BlockedServices.Schedule = schedule.EmptyWeekly()
+	}
+
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		d.conf.BlockedServices = c.BlockedServices
+		d.conf.Rewrites = c.Rewrites
+	}()
+
+	var removed []FilterYAML
+	func() {
+		d.conf.filtersMu.Lock()
+		defer d.conf.filtersMu.Unlock()
+
+		var rmBlock, rmAllow []FilterYAML
+		d.conf.Filters, rmBlock = mergeSyncedFilters(d.conf.Filters, c.Filters, false)
+		d.conf.WhitelistFilters, rmAllow = mergeSyncedFilters(
+			d.conf.WhitelistFilters,
+			c.WhitelistFilters,
+			true,
+		)
+		d.conf.UserRules = c.UserRules
+
+		removed = append(rmBlock, rmAllow...)
+	}()
+
+	for i := range removed {
+		d.removeFilterFiles(&removed[i])
+	}
+
+	d.conf.ConfigModified()
+	d.EnableFilters(true)
+
+	go func() {
+		defer log.OnPanic("filtering: updating synced filters")
+
+		d.refreshLock.Lock()
+		defer d.refreshLock.Unlock()
+
+		// The new lists have never been updated, so they are updated here, and
+		// the engines are rebuilt if they are.
+		d.refreshFiltersIntl(true, true, false)
+	}()
+
+	return nil
+}
+
+// validateSyncedConfig returns an error if c is invalid.
+func validateSyncedConfig(c *SyncedConfig) (err error) {
+	if c.BlockedServices == nil {
+		return fmt.Errorf("blocked services: %w", errors.Error("no value"))
+	}
+
+	err = c.BlockedServices.Validate()
+	if err != nil {
+		return fmt.Errorf("blocked services: %w", err)
+	}
+
+	for i, rw := range c.Rewrites {
+		if rw == nil {
+			return fmt.Errorf("rewrite at index %d: %w", i, errors.Error("no value"))
+		}
+
+		err = rw.normalize()
+		if err != nil {
+			return fmt.Errorf("rewrite at index %d: %w", i, err)
+		}
+	}
+
+	for _, filters := range [][]*SyncedFilter{c.Filters, c.WhitelistFilters} {
+		for i, flt := range filters {
+			if flt == nil {
+				return fmt.Errorf("filter at index %d: %w", i, errors.Error("no value"))
+			} else if filepath.IsAbs(flt.URL) {
+				return fmt.Errorf("filter %q: local files can't be synced", flt.URL)
+			}
+
+			err = validateFilterURL(flt.URL)
+			if err != nil {
+				return fmt.Errorf("filter at index %d: %w", i, err)
+			}
+		}
+	}
+
+	for _, flt := range c.WhitelistFilters {
+		if flt.MonitorOnly {
+			return fmt.Errorf("filter %q: %w", flt.URL, errMonitorAllowlist)
+		}
+	}
+
+	return nil
+}
+
+// mergeSyncedFilters returns the filter lists with the properties from synced,
+// reusing the matching lists from cur, and the lists from cur, which have been
+// removed.  The lists from cur with local file paths are kept.
+func mergeSyncedFilters(
+	cur []FilterYAML,
+	synced []*SyncedFilter,
+	white bool,
+) (merged, removed []FilterYAML) {
+	merged = make([]FilterYAML, 0, len(synced))
+	used := make([]bool, len(cur))
+	for _, s := range synced {
+		i := slices.IndexFunc(cur, func(flt FilterYAML) (ok bool) { return flt.URL == s.URL })
+		if i == -1 {
+			merged = append(merged, FilterYAML{
+				Enabled:     s.Enabled,
+				URL:         s.URL,
+				Name:        s.Name,
+				white:       white,
+				MonitorOnly: s.MonitorOnly,
+				Filter: Filter{
+					ID: assignUniqueFilterID(),
+				},
+			})
+
+			continue
+		} else if used[i] {
+			// Skip the duplicates.
+			continue
+		}
+
+		used[i] = true
+
+		flt := cur[i]
+		flt.Name = s.Name
+		flt.MonitorOnly = s.MonitorOnly
+		if flt.Enabled != s.Enabled {
+			flt.Enabled = s.Enabled
+
+			// Make sure that the list is reloaded, when it's enabled again.
+			flt.unload()
+			flt.LastUpdated = time.Time{}
+		}
+
+		merged = append(merged, flt)
+	}
+
+	for i, flt := range cur {
+		if used[i] {
+			continue
+		} else if filepath.IsAbs(flt.URL) {
+			merged = append(merged, flt)
+		} else {
+			removed = append(removed, flt)
+		}
+	}
+
+	return merged, removed
+}
+
+// removeFilterFiles moves away the file of the removed filter list flt and
+// removes its canary file and statistics.
+func (d *DNSFilter) removeFilterFiles(flt *FilterYAML) {
+	p := flt.Path(d.conf.DataDir)
+	err := os.Rename(p, p+".old")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("filtering: deleting filter %d: renaming file %q: %s", flt.ID, p, err)
+	}
+
+	cp := flt.canaryPath(d.conf.DataDir)
+	err = os.Remove(cp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("filtering: deleting filter %d: removing canary file %q: %s", flt.ID, cp, err)
+	}
+
+	d.listStats.remove(flt.ID)
+
+	log.Info("filtering: deleted filter %d", flt.ID)
+}
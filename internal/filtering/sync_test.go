@@ -0,0 +1,76 @@
+package filtering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSyncedFilters(t *testing.T) {
+	const (
+		keptURL    = "https://example.com/kept.txt"
+		removedURL = "https://example.com/removed.txt"
+		newURL     = "https://example.com/new.txt"
+		localPath  = "/etc/adguard/local.txt"
+	)
+
+	cur := []FilterYAML{{
+		Enabled:     true,
+		URL:         keptURL,
+		Name:        "Kept",
+		RulesCount:  10,
+		LastUpdated: time.Now(),
+		Filter:      Filter{ID: 1},
+	}, {
+		Enabled: true,
+		URL:     removedURL,
+		Name:    "Removed",
+		Filter:  Filter{ID: 2},
+	}, {
+		Enabled: true,
+		URL:     localPath,
+		Name:    "Local",
+		Filter:  Filter{ID: 3},
+	}}
+
+	synced := []*SyncedFilter{{
+		Name:    "Renamed",
+		URL:     keptURL,
+		Enabled: true,
+	}, {
+		Name:    "New",
+		URL:     newURL,
+		Enabled: true,
+	}}
+
+	merged, removed := mergeSyncedFilters(cur, synced, false)
+	require.Len(t, merged, 3)
+	require.Len(t, removed, 1)
+
+	assert.Equal(t, removedURL, removed[0].URL)
+
+	kept := merged[0]
+	assert.Equal(t, "Renamed", kept.Name)
+	assert.Equal(t, int64(1), kept.ID)
+	assert.Equal(t, 10, kept.RulesCount)
+
+	added := merged[1]
+	assert.Equal(t, newURL, added.URL)
+	assert.NotContains(t, []int64{1, 2, 3}, added.ID)
+	assert.True(t, added.LastUpdated.IsZero())
+
+	assert.Equal(t, localPath, merged[2].URL)
+
+	t.Run("disable", func(t *testing.T) {
+		synced[0].Enabled = false
+
+		merged, _ = mergeSyncedFilters(cur, synced, false)
+		require.NotEmpty(t, merged)
+
+		assert.False(t, merged[0].Enabled)
+		assert.Zero(t, merged[0].RulesCount)
+		assert.True(t, merged[0].LastUpdated.IsZero())
+	})
+}
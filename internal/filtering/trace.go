@@ -0,0 +1,470 @@
+package filtering
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slices"
+)
+
+// traceDecision is the decision of a single stage of the filtering pipeline.
+type traceDecision string
+
+// traceDecision values.
+const (
+	// traceDecisionMatched means that the stage has matched the host.  It
+	// doesn't necessarily mean that the host is blocked, since allowlists
+	// and rewrites match too.
+	traceDecisionMatched traceDecision = "matched"
+
+	// traceDecisionNotMatched means that the stage has been checked, but
+	// hasn't matched the host.
+	traceDecisionNotMatched traceDecision = "not_matched"
+
+	// traceDecisionDisabled means that the stage hasn't been checked, since
+	// it's disabled by the settings.
+	traceDecisionDisabled traceDecision = "disabled"
+)
+
+// The names of the stages of the filtering pipeline in the order of checking.
+const (
+	traceStageRewrites        = "rewrites"
+	traceStageHostsFile       = "hosts_file"
+	traceStageAllowlists      = "allowlists"
+	traceStageBlocklist       = "blocklist"
+	traceStageMonitorOnly     = "monitor_only"
+	traceStageBlockedServices = "blocked_services"
+	traceStageCategories      = "categories"
+	traceStageSafeBrowsing    = "safe_browsing"
+	traceStageParental        = "parental"
+	traceStageSafeSearch      = "safe_search"
+	traceStageAllowlistOnly   = "allowlist_only"
+)
+
+// traceStage is the result of a single stage of the filtering pipeline.
+type traceStage struct {
+	// result is the result of the stage.
+	result Result
+
+	// name is the name of the stage.
+	name string
+
+	// decision is the decision of the stage.
+	decision traceDecision
+
+	// filterListID is the ID of the filter list for the [traceStageBlocklist]
+	// stages.  It's nil if no blocklist has matched.
+	filterListID *int64
+}
+
+// newTraceStage returns a new stage with the decision based on res.
+func newTraceStage(name string, res Result) (s *traceStage) {
+	s = &traceStage{
+		result:   res,
+		name:     name,
+		decision: traceDecisionNotMatched,
+	}
+
+	if res.Reason.Matched() {
+		s.decision = traceDecisionMatched
+	}
+
+	return s
+}
+
+// disabledTraceStage returns a new stage, which hasn't been checked.
+func disabledTraceStage(name string) (s *traceStage) {
+	return &traceStage{
+		name:     name,
+		decision: traceDecisionDisabled,
+	}
+}
+
+// trace checks the host against every stage of the filtering pipeline
+// independently and returns the result of each stage as well as the final
+// result, which is the same as the one returned by [DNSFilter.CheckHost].
+// Unlike the latter, it doesn't stop at the first match.  The engines with the
+// filter list updates in the canary rollout aren't traced.
+func (d *DNSFilter) trace(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (stages []*traceStage, res Result, err error) {
+	host = aghnet.NormalizeDomain(host)
+
+	if setts.FilteringEnabled {
+		stages = append(stages, newTraceStage(traceStageRewrites, d.processRewrites(host, qtype)))
+
+		res, err = d.matchSysHosts(host, qtype, setts)
+		if err != nil {
+			return nil, Result{}, fmt.Errorf("%s: %w", traceStageHostsFile, err)
+		}
+
+		stages = append(stages, newTraceStage(traceStageHostsFile, res))
+	} else {
+		stages = append(
+			stages,
+			disabledTraceStage(traceStageRewrites),
+			disabledTraceStage(traceStageHostsFile),
+		)
+	}
+
+	engineStages, err := d.traceEngines(host, qtype, setts)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, Result{}, err
+	}
+
+	stages = append(stages, engineStages...)
+
+	for _, c := range []struct {
+		check   func(host string, qtype uint16, setts *Settings) (res Result, err error)
+		name    string
+		enabled bool
+	}{{
+		check:   matchBlockedServicesRules,
+		name:    traceStageBlockedServices,
+		enabled: setts.ProtectionEnabled,
+	}, {
+		check:   d.checkCategories,
+		name:    traceStageCategories,
+		enabled: setts.ProtectionEnabled && len(setts.BlockedCategories) > 0,
+	}, {
+		check:   d.checkSafeBrowsing,
+		name:    traceStageSafeBrowsing,
+		enabled: setts.ProtectionEnabled && setts.SafeBrowsingEnabled,
+	}, {
+		check:   d.checkParental,
+		name:    traceStageParental,
+		enabled: setts.ProtectionEnabled && setts.ParentalEnabled,
+	}, {
+		check:   d.checkSafeSearch,
+		name:    traceStageSafeSearch,
+		enabled: setts.ProtectionEnabled && setts.SafeSearchEnabled,
+	}} {
+		if !c.enabled {
+			stages = append(stages, disabledTraceStage(c.name))
+
+			continue
+		}
+
+		res, err = c.check(host, qtype, setts)
+		if err != nil {
+			return nil, Result{}, fmt.Errorf("%s: %w", c.name, err)
+		}
+
+		stages = append(stages, newTraceStage(c.name, res))
+	}
+
+	res, err = d.CheckHost(host, qtype, setts)
+	if err != nil {
+		return nil, Result{}, fmt.Errorf("checking host: %w", err)
+	}
+
+	if !setts.AllowlistOnly || !setts.ProtectionEnabled || !setts.FilteringEnabled {
+		stages = append(stages, disabledTraceStage(traceStageAllowlistOnly))
+	} else if res.Reason == FilteredAllowlistOnly {
+		stages = append(stages, newTraceStage(traceStageAllowlistOnly, res))
+	} else {
+		stages = append(stages, newTraceStage(traceStageAllowlistOnly, Result{}))
+	}
+
+	return stages, res, nil
+}
+
+// traceEngines returns the results of matching host against the allowlists,
+// each of the blocklists, and the monitor-only lists.
+func (d *DNSFilter) traceEngines(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (stages []*traceStage, err error) {
+	if !setts.FilteringEnabled {
+		stages = append(
+			stages,
+			disabledTraceStage(traceStageAllowlists),
+			disabledTraceStage(traceStageBlocklist),
+		)
+
+		if d.filteringEngineMonitor != nil {
+			stages = append(stages, disabledTraceStage(traceStageMonitorOnly))
+		}
+
+		return stages, nil
+	}
+
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	ufReq := newDNSRequest(host, qtype, setts)
+
+	allowStage := disabledTraceStage(traceStageAllowlists)
+	if setts.ProtectionEnabled {
+		allowStage = newTraceStage(traceStageAllowlists, Result{})
+		if d.filteringEngineAllow != nil {
+			dnsres, ok := d.filteringEngineAllow.MatchRequest(ufReq)
+			if ok && len(setts.DisabledFilterLists) > 0 {
+				dnsres, ok = withoutLists(dnsres, setts.DisabledFilterLists)
+			}
+
+			if ok {
+				var res Result
+				res, err = d.matchHostProcessAllowList(host, dnsres)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", traceStageAllowlists, err)
+				}
+
+				allowStage = newTraceStage(traceStageAllowlists, res)
+			}
+		}
+	}
+
+	stages = append(stages, allowStage)
+	stages = append(stages, d.traceBlocklistsLocked(ufReq, host, qtype, setts)...)
+
+	if d.filteringEngineMonitor != nil {
+		monitorStage := disabledTraceStage(traceStageMonitorOnly)
+		if setts.ProtectionEnabled {
+			monitorStage = newTraceStage(
+				traceStageMonitorOnly,
+				d.matchMonitorLocked(host, qtype, setts),
+			)
+		}
+
+		stages = append(stages, monitorStage)
+	}
+
+	return stages, nil
+}
+
+// traceBlocklistsLocked returns a stage for each of the blocklists, the rules
+// of which match ufReq.  If none of them do, it returns a single stage without
+// a filter list ID.  d.engineLock is expected to be locked for reading.
+func (d *DNSFilter) traceBlocklistsLocked(
+	ufReq *urlfilter.DNSRequest,
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (stages []*traceStage) {
+	var dnsres *urlfilter.DNSResult
+	if d.filteringEngine != nil {
+		dnsres, _ = d.filteringEngine.MatchRequest(ufReq)
+	}
+
+	ids := matchedListIDs(dnsres)
+	if len(ids) == 0 {
+		return []*traceStage{newTraceStage(traceStageBlocklist, Result{})}
+	}
+
+	for _, id := range ids {
+		id := id
+
+		var s *traceStage
+		if _, disabled := slices.BinarySearch(setts.DisabledFilterLists, id); disabled {
+			s = disabledTraceStage(traceStageBlocklist)
+		} else {
+			s = newTraceStage(traceStageBlocklist, d.matchListResult(dnsres, id, host, qtype, setts))
+		}
+
+		s.filterListID = &id
+		stages = append(stages, s)
+	}
+
+	return stages
+}
+
+// matchListResult returns the result of matching host against the rules of
+// the filter list with id from dnsres only.
+func (d *DNSFilter) matchListResult(
+	dnsres *urlfilter.DNSResult,
+	id int64,
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result) {
+	listRes := onlyList(dnsres, id)
+
+	res = d.processDNSResultRewrites(listRes, host)
+	if res.Reason.Matched() || !setts.ProtectionEnabled {
+		return res
+	}
+
+	return d.matchHostProcessDNSResult(qtype, listRes)
+}
+
+// matchedListIDs returns the sorted IDs of the filter lists of the rules from
+// dnsres, which may be nil.
+func matchedListIDs(dnsres *urlfilter.DNSResult) (ids []int64) {
+	if dnsres == nil {
+		return nil
+	}
+
+	add := func(r rules.Rule) {
+		id := int64(r.GetFilterListID())
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, r := range dnsres.NetworkRules {
+		add(r)
+	}
+
+	for _, r := range dnsres.HostRulesV4 {
+		add(r)
+	}
+
+	for _, r := range dnsres.HostRulesV6 {
+		add(r)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// onlyList returns the copy of dnsres with only the rules from the filter list
+// with id.
+func onlyList(dnsres *urlfilter.DNSResult, id int64) (res *urlfilter.DNSResult) {
+	res = &urlfilter.DNSResult{}
+	for _, r := range dnsres.NetworkRules {
+		if int64(r.GetFilterListID()) == id {
+			res.NetworkRules = append(res.NetworkRules, r)
+		}
+	}
+
+	res.NetworkRule = rules.NewMatchingResult(res.NetworkRules, nil).GetBasicResult()
+
+	for _, r := range dnsres.HostRulesV4 {
+		if int64(r.GetFilterListID()) == id {
+			res.HostRulesV4 = append(res.HostRulesV4, r)
+		}
+	}
+
+	for _, r := range dnsres.HostRulesV6 {
+		if int64(r.GetFilterListID()) == id {
+			res.HostRulesV6 = append(res.HostRulesV6, r)
+		}
+	}
+
+	return res
+}
+
+// traceStageJSON is a single stage of the response of the GET
+// /control/filtering/trace HTTP API.
+type traceStageJSON struct {
+	// Result is the result of the stage in the same format as in the GET
+	// /control/filtering/check_host HTTP API.  It's nil if the stage is
+	// disabled.
+	Result *checkHostResp `json:"result,omitempty"`
+
+	// Stage is the name of the stage.
+	Stage string `json:"stage"`
+
+	// Decision is the decision of the stage.
+	Decision traceDecision `json:"decision"`
+
+	// FilterListID is the ID of the filter list for the "blocklist" stages.
+	// It's nil if no blocklist has matched.
+	FilterListID *int64 `json:"filter_list_id,omitempty"`
+}
+
+// traceResp is the response of the GET /control/filtering/trace HTTP API.
+type traceResp struct {
+	// Result is the final result of filtering.
+	Result *checkHostResp `json:"result"`
+
+	// Stages are the results of every stage of the filtering pipeline.
+	Stages []*traceStageJSON `json:"stages"`
+
+	// ClientName is the name of the persistent client, which settings have
+	// been applied, if any.
+	ClientName string `json:"client_name"`
+
+	// QType is the type of the request.
+	QType string `json:"qtype"`
+}
+
+// handleTrace is the handler for the GET /control/filtering/trace HTTP API.
+// It accepts the "name", "qtype", "client", and "client_id" query parameters,
+// the latter two being the IP address and the ClientID of the client, the
+// settings of which are applied.
+func (d *DNSFilter) handleTrace(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	host := q.Get("name")
+	if host == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no name")
+
+		return
+	}
+
+	qt := dns.TypeA
+	if qtStr := q.Get("qtype"); qtStr != "" {
+		var ok bool
+		qt, ok = dns.StringToType[strings.ToUpper(qtStr)]
+		if !ok {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad qtype %q", qtStr)
+
+			return
+		}
+	}
+
+	var clientIP netip.Addr
+	if ipStr := q.Get("client"); ipStr != "" {
+		var err error
+		clientIP, err = netip.ParseAddr(ipStr)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad client: %s", err)
+
+			return
+		}
+	}
+
+	setts := d.Settings()
+	setts.FilteringEnabled = true
+	setts.ProtectionEnabled = true
+
+	d.ApplyBlockedServices(setts)
+	if d.conf.ApplyClientSettings != nil {
+		d.conf.ApplyClientSettings(clientIP, q.Get("client_id"), setts)
+	} else {
+		setts.ClientIP = clientIP
+	}
+
+	stages, res, err := d.trace(host, qt, setts)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "tracing %q: %s", host, err)
+
+		return
+	}
+
+	resp := &traceResp{
+		Result:     newCheckHostResp(res),
+		Stages:     make([]*traceStageJSON, 0, len(stages)),
+		ClientName: setts.ClientName,
+		QType:      dns.TypeToString[qt],
+	}
+
+	for _, s := range stages {
+		sj := &traceStageJSON{
+			Stage:        s.name,
+			Decision:     s.decision,
+			FilterListID: s.filterListID,
+		}
+
+		if s.decision != traceDecisionDisabled {
+			sj.Result = newCheckHostResp(s.result)
+		}
+
+		resp.Stages = append(resp.Stages, sj)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
@@ -0,0 +1,120 @@
+package filtering
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_handleTrace(t *testing.T) {
+	var appliedIP netip.Addr
+	d, _ := newForTest(t, &Config{
+		ApplyClientSettings: func(clientIP netip.Addr, _ string, setts *Settings) {
+			appliedIP = clientIP
+			setts.ClientIP = clientIP
+			setts.ClientName = "client"
+		},
+		BlockedServices: &BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+		},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.initFiltering(&filtersInitializerParams{
+		allowFilters: []Filter{{
+			ID: 3, Data: []byte("@@||allowed.example^\n"),
+		}},
+		blockFilters: []Filter{{
+			ID: 1, Data: []byte("||both.example^\n||allowed.example^\n"),
+		}, {
+			ID: 2, Data: []byte("||both.example^$client=192.0.2.1\n"),
+		}},
+	})
+	require.NoError(t, err)
+
+	trace := func(t *testing.T, query string) (resp *traceResp) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/control/filtering/trace?"+query, nil)
+		d.handleTrace(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp = &traceResp{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+
+		return resp
+	}
+
+	decisions := func(resp *traceResp) (got []string) {
+		for _, s := range resp.Stages {
+			d := s.Stage + ":" + string(s.Decision)
+			if s.FilterListID != nil {
+				d += ":" + strconv.FormatInt(*s.FilterListID, 10)
+			}
+
+			got = append(got, d)
+		}
+
+		return got
+	}
+
+	t.Run("both_lists", func(t *testing.T) {
+		resp := trace(t, "name=both.example&client=192.0.2.1")
+
+		assert.Equal(t, netip.MustParseAddr("192.0.2.1"), appliedIP)
+		assert.Equal(t, "client", resp.ClientName)
+		assert.Equal(t, "A", resp.QType)
+		assert.Equal(t, "FilteredBlackList", resp.Result.Reason)
+		assert.Equal(t, []string{
+			"rewrites:not_matched",
+			"hosts_file:not_matched",
+			"allowlists:not_matched",
+			"blocklist:matched:1",
+			"blocklist:matched:2",
+			"blocked_services:not_matched",
+			"categories:disabled",
+			"safe_browsing:disabled",
+			"parental:disabled",
+			"safe_search:disabled",
+			"allowlist_only:disabled",
+		}, decisions(resp))
+
+		require.Len(t, resp.Stages[4].Result.Rules, 1)
+
+		assert.Equal(t, "||both.example^$client=192.0.2.1", resp.Stages[4].Result.Rules[0].Text)
+	})
+
+	t.Run("other_client", func(t *testing.T) {
+		resp := trace(t, "name=both.example&client=192.0.2.2&qtype=aaaa")
+
+		assert.Equal(t, "AAAA", resp.QType)
+		assert.Equal(t, "blocklist:matched:1", decisions(resp)[3])
+		assert.Len(t, resp.Stages, 10)
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		resp := trace(t, "name=allowed.example")
+
+		assert.Equal(t, "NotFilteredWhiteList", resp.Result.Reason)
+		assert.Equal(t, []string{
+			"allowlists:matched",
+			"blocklist:matched:1",
+		}, decisions(resp)[2:4])
+	})
+
+	t.Run("bad_qtype", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/control/filtering/trace?name=a&qtype=bad", nil)
+		d.handleTrace(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
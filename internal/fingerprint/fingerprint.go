@@ -0,0 +1,202 @@
+// Package fingerprint guesses the kinds of the devices by their DHCP
+// fingerprints and the User-Agent headers of their DNS-over-HTTPS requests.
+package fingerprint
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// Device is the guess about a device.
+type Device struct {
+	// Type is the kind of the device.
+	Type discovery.DeviceType `json:"device_type"`
+
+	// Vendor is the vendor of the device, if known.
+	Vendor string `json:"vendor,omitempty"`
+
+	// OS is the operating system of the device, if known.
+	OS string `json:"os,omitempty"`
+}
+
+// Rule is an entry of the fingerprint database.  A rule matches if all its
+// non-empty conditions match.
+type Rule struct {
+	Device
+
+	// DHCPParams is the DHCP parameter request list, option 55, as decimal
+	// option codes separated by commas, for example "1,3,6,15".  It matches
+	// the list exactly, including the order.
+	DHCPParams string `json:"dhcp_params,omitempty"`
+
+	// VendorClass is the prefix of the DHCP vendor class identifier, option
+	// 60.  It's matched case-insensitively.
+	VendorClass string `json:"vendor_class,omitempty"`
+
+	// UserAgent is the substring of the User-Agent header of the DoH requests.
+	// It's matched case-insensitively.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// params are the option codes parsed from DHCPParams.
+	params []byte
+}
+
+// init validates r and initializes its unexported fields.
+func (r *Rule) init() (err error) {
+	switch {
+	case r.Type == discovery.DeviceTypeNone:
+		return errors.Error("no device_type")
+	case r.UserAgent != "" && (r.DHCPParams != "" || r.VendorClass != ""):
+		return errors.Error("user_agent can't be combined with dhcp conditions")
+	case r.UserAgent == "" && r.DHCPParams == "" && r.VendorClass == "":
+		return errors.Error("no conditions")
+	}
+
+	r.params = nil
+	if r.DHCPParams == "" {
+		return nil
+	}
+
+	for _, s := range strings.Split(r.DHCPParams, ",") {
+		var code uint64
+		code, err = strconv.ParseUint(strings.TrimSpace(s), 10, 8)
+		if err != nil {
+			return fmt.Errorf("dhcp_params: %w", err)
+		}
+
+		r.params = append(r.params, byte(code))
+	}
+
+	return nil
+}
+
+// matchesDHCP returns the number of the conditions of r satisfied by params and
+// vendorClass, or zero if any of them isn't.
+func (r *Rule) matchesDHCP(params []byte, vendorClass string) (n int) {
+	if r.UserAgent != "" {
+		return 0
+	}
+
+	if r.params != nil {
+		if !bytes.Equal(r.params, params) {
+			return 0
+		}
+
+		n++
+	}
+
+	if r.VendorClass != "" {
+		if len(vendorClass) < len(r.VendorClass) ||
+			!strings.EqualFold(vendorClass[:len(r.VendorClass)], r.VendorClass) {
+			return 0
+		}
+
+		n++
+	}
+
+	return n
+}
+
+// rulesFile is the structure of a fingerprint database file.
+type rulesFile struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Parse parses the fingerprint database file data.
+func Parse(data []byte) (rules []*Rule, err error) {
+	f := &rulesFile{}
+	err = json.Unmarshal(data, f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	return f.Rules, nil
+}
+
+// defaultData is the built-in fingerprint database.
+//
+//go:embed default.json
+var defaultData []byte
+
+// DefaultRules returns the rules of the built-in fingerprint database.
+func DefaultRules() (rules []*Rule) {
+	rules, err := Parse(defaultData)
+	if err != nil {
+		panic(fmt.Errorf("fingerprint: built-in database: %w", err))
+	}
+
+	return rules
+}
+
+// Database matches the devices against the fingerprint rules.
+type Database struct {
+	// dhcp are the rules with the DHCP conditions.
+	dhcp []*Rule
+
+	// userAgent are the rules with the User-Agent conditions.
+	userAgent []*Rule
+}
+
+// New returns a new database with rules.  The earlier rules take precedence
+// over the later ones with as many matching conditions.
+func New(rules []*Rule) (db *Database, err error) {
+	db = &Database{}
+	for i, r := range rules {
+		if r == nil {
+			return nil, fmt.Errorf("rule at index %d: %w", i, errors.Error("no value"))
+		}
+
+		err = r.init()
+		if err != nil {
+			return nil, fmt.Errorf("rule at index %d: %w", i, err)
+		}
+
+		if r.UserAgent != "" {
+			db.userAgent = append(db.userAgent, r)
+		} else {
+			db.dhcp = append(db.dhcp, r)
+		}
+	}
+
+	return db, nil
+}
+
+// MatchDHCP returns the guess about the device with the DHCP parameter request
+// list params and the vendor class identifier vendorClass.  The rule with the
+// most matching conditions is used.  d is nil if no rule matches.  d must not
+// be modified.
+func (db *Database) MatchDHCP(params []byte, vendorClass string) (d *Device) {
+	best := 0
+	for _, r := range db.dhcp {
+		if n := r.matchesDHCP(params, vendorClass); n > best {
+			best, d = n, &r.Device
+		}
+	}
+
+	return d
+}
+
+// MatchUserAgent returns the guess about the device with the User-Agent ua.
+// The first matching rule is used.  d is nil if no rule matches.  d must not be
+// modified.
+func (db *Database) MatchUserAgent(ua string) (d *Device) {
+	if ua == "" {
+		return nil
+	}
+
+	ua = strings.ToLower(ua)
+	for _, r := range db.userAgent {
+		if strings.Contains(ua, strings.ToLower(r.UserAgent)) {
+			return &r.Device
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package fingerprint_test
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
+	"github.com/AdguardTeam/AdGuardHome/internal/fingerprint"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		rules      []*fingerprint.Rule
+	}{{
+		name:       "default",
+		wantErrMsg: "",
+		rules:      fingerprint.DefaultRules(),
+	}, {
+		name:       "nil",
+		wantErrMsg: "rule at index 0: no value",
+		rules:      []*fingerprint.Rule{nil},
+	}, {
+		name:       "no_type",
+		wantErrMsg: "rule at index 0: no device_type",
+		rules: []*fingerprint.Rule{{
+			UserAgent: "Test",
+		}},
+	}, {
+		name:       "no_conditions",
+		wantErrMsg: "rule at index 0: no conditions",
+		rules: []*fingerprint.Rule{{
+			Device: fingerprint.Device{Type: discovery.DeviceTypeIoT},
+		}},
+	}, {
+		name:       "mixed",
+		wantErrMsg: "rule at index 0: user_agent can't be combined with dhcp conditions",
+		rules: []*fingerprint.Rule{{
+			Device:      fingerprint.Device{Type: discovery.DeviceTypeIoT},
+			VendorClass: "test",
+			UserAgent:   "Test",
+		}},
+	}, {
+		name: "bad_params",
+		wantErrMsg: `rule at index 0: dhcp_params: strconv.ParseUint: ` +
+			`parsing "256": value out of range`,
+		rules: []*fingerprint.Rule{{
+			Device:     fingerprint.Device{Type: discovery.DeviceTypeIoT},
+			DHCPParams: "1,256",
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := fingerprint.New(tc.rules)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestDatabase_MatchDHCP(t *testing.T) {
+	rules := append([]*fingerprint.Rule{{
+		Device: fingerprint.Device{
+			Type:   discovery.DeviceTypeIoT,
+			Vendor: "Example",
+		},
+		DHCPParams:  "1,3,6,15,26,28,51,58,59,43",
+		VendorClass: "example-cam",
+	}}, fingerprint.DefaultRules()...)
+
+	db, err := fingerprint.New(rules)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		want        *fingerprint.Device
+		name        string
+		vendorClass string
+		params      []byte
+	}{{
+		want: &fingerprint.Device{
+			Type:   discovery.DeviceTypeComputer,
+			Vendor: "Microsoft",
+			OS:     "Windows",
+		},
+		name:        "windows",
+		vendorClass: "MSFT 5.0",
+		params:      []byte{1, 3, 6, 15, 31, 33, 43, 44, 46, 47, 119, 121, 249, 252},
+	}, {
+		want: &fingerprint.Device{
+			Type:   discovery.DeviceTypePhone,
+			Vendor: "Apple",
+			OS:     "iOS",
+		},
+		name:        "ios",
+		vendorClass: "",
+		params:      []byte{1, 121, 3, 6, 15, 119, 252},
+	}, {
+		want: &fingerprint.Device{
+			Type: discovery.DeviceTypePhone,
+			OS:   "Android",
+		},
+		name:        "android_vendor_class",
+		vendorClass: "android-dhcp-13",
+		params:      []byte{1, 3, 6},
+	}, {
+		want: &fingerprint.Device{
+			Type:   discovery.DeviceTypeIoT,
+			Vendor: "Example",
+		},
+		name:        "more_conditions",
+		vendorClass: "Example-Cam 2.0",
+		params:      []byte{1, 3, 6, 15, 26, 28, 51, 58, 59, 43},
+	}, {
+		want:        nil,
+		name:        "unknown",
+		vendorClass: "",
+		params:      []byte{1, 3},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, db.MatchDHCP(tc.params, tc.vendorClass))
+		})
+	}
+}
+
+func TestDatabase_MatchUserAgent(t *testing.T) {
+	db, err := fingerprint.New(fingerprint.DefaultRules())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		want *fingerprint.Device
+		name string
+		ua   string
+	}{{
+		want: &fingerprint.Device{
+			Type:   discovery.DeviceTypeTV,
+			Vendor: "Samsung",
+			OS:     "Tizen",
+		},
+		name: "samsung_tv",
+		ua: "Mozilla/5.0 (SMART-TV; LINUX; Tizen 6.0) AppleWebKit/537.36 " +
+			"(KHTML, like Gecko) SamsungBrowser/4.0 Chrome/76.0.3809.146 TV " +
+			"Safari/537.36",
+	}, {
+		want: &fingerprint.Device{
+			Type:   discovery.DeviceTypeConsole,
+			Vendor: "Sony",
+		},
+		name: "playstation",
+		ua:   "Mozilla/5.0 (PlayStation; PlayStation 5/2.26) AppleWebKit/605.1.15",
+	}, {
+		want: &fingerprint.Device{
+			Type: discovery.DeviceTypePhone,
+			OS:   "Android",
+		},
+		name: "android",
+		ua:   "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36",
+	}, {
+		want: nil,
+		name: "empty",
+		ua:   "",
+	}, {
+		want: nil,
+		name: "unknown",
+		ua:   "curl/8.0.1",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, db.MatchUserAgent(tc.ua))
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Package geoip contains the lookups of the countries and the autonomous
+// systems of IP addresses in the databases in the MaxMind DB format.
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// DefaultRefreshIvl is the default interval between the checks of the
+// database files for changes.
+const DefaultRefreshIvl = 1 * time.Minute
+
+// Info is the geolocation information about an IP address.
+type Info struct {
+	// Country is the uppercase ISO 3166-1 alpha-2 code of the country.  It's
+	// empty if unknown.
+	Country string
+
+	// ASN is the number of the autonomous system.  It's zero if unknown.
+	ASN uint32
+}
+
+// Database looks up the information about the IP addresses in one or more
+// database files in the MaxMind DB format, such as GeoLite2-Country and
+// GeoLite2-ASN.  The files are reloaded when they change.  A Database is safe
+// for concurrent use.
+type Database struct {
+	files []*dbFile
+
+	// refreshIvl is the interval between the checks of the files for changes.
+	refreshIvl time.Duration
+}
+
+// New returns a new database of the files with paths.  refreshIvl is the
+// interval between the checks of the files for changes, if it's zero,
+// [DefaultRefreshIvl] is used.
+func New(paths []string, refreshIvl time.Duration) (db *Database, err error) {
+	if len(paths) == 0 {
+		return nil, errors.Error("no database files")
+	}
+
+	if refreshIvl == 0 {
+		refreshIvl = DefaultRefreshIvl
+	}
+
+	db = &Database{
+		files:      make([]*dbFile, 0, len(paths)),
+		refreshIvl: refreshIvl,
+	}
+
+	now := time.Now()
+	for _, p := range paths {
+		f := &dbFile{
+			path: p,
+			mu:   &sync.Mutex{},
+		}
+
+		err = f.load(now)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+
+		db.files = append(db.files, f)
+	}
+
+	return db, nil
+}
+
+// Lookup returns the information about ip.  The information from the files
+// listed earlier takes precedence.
+func (db *Database) Lookup(ip netip.Addr) (info Info, err error) {
+	now := time.Now()
+
+	var errs []error
+	for _, f := range db.files {
+		f.refresh(now, db.refreshIvl)
+
+		var v any
+		v, err = f.reader.Load().lookup(ip)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("looking up in %q: %w", f.path, err))
+
+			continue
+		}
+
+		fillInfo(&info, v)
+		if info.Country != "" && info.ASN != 0 {
+			break
+		}
+	}
+
+	return info, errors.Join(errs...)
+}
+
+// dbFile is a single database file.
+type dbFile struct {
+	// mu prevents the concurrent reloads of the file.
+	mu *sync.Mutex
+
+	// reader is the reader of the current contents of the file.
+	reader atomic.Pointer[reader]
+
+	// lastCheck is the Unix time of the last check of the file for changes in
+	// nanoseconds.
+	lastCheck atomic.Int64
+
+	// modTime is the modification time of the currently loaded contents.  It's
+	// protected by mu.
+	modTime time.Time
+
+	// path is the path to the file.
+	path string
+}
+
+// load reads and parses the file.  It must only be called during the
+// initialization or with f.mu locked.
+func (f *dbFile) load(now time.Time) (err error) {
+	defer func() { err = errors.Annotate(err, "loading geoip database %q: %w", f.path) }()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	r, err := newReader(b)
+	if err != nil {
+		return err
+	}
+
+	f.reader.Store(r)
+	f.modTime = fi.ModTime()
+	f.lastCheck.Store(now.UnixNano())
+
+	return nil
+}
+
+// refresh reloads the file if ivl has passed since the last check and the file
+// has changed since then.  The previous contents are kept if the file can't be
+// reloaded.
+func (f *dbFile) refresh(now time.Time, ivl time.Duration) {
+	last := f.lastCheck.Load()
+	if now.UnixNano()-last < int64(ivl) || !f.lastCheck.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		log.Error("geoip: checking %q: %s", f.path, err)
+
+		return
+	} else if fi.ModTime().Equal(f.modTime) {
+		return
+	}
+
+	err = f.load(now)
+	if err != nil {
+		log.Error("geoip: %s; keeping previous data", err)
+
+		return
+	}
+
+	log.Info("geoip: reloaded %q", f.path)
+}
+
+// fillInfo sets the empty fields of info from the record v.  It supports the
+// layouts of the MaxMind, DB-IP, and IPinfo databases.
+func fillInfo(info *Info, v any) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return
+	}
+
+	if info.Country == "" {
+		info.Country = countryFromRecord(m)
+	}
+
+	if info.ASN == 0 {
+		info.ASN = asnFromRecord(m)
+	}
+}
+
+// countryFromRecord returns the country code from m, if any.
+func countryFromRecord(m map[string]any) (cc string) {
+	for _, key := range []string{"country", "registered_country"} {
+		switch c := m[key].(type) {
+		case map[string]any:
+			cc, _ = c["iso_code"].(string)
+		case string:
+			cc = c
+		}
+
+		if cc != "" {
+			return strings.ToUpper(cc)
+		}
+	}
+
+	return ""
+}
+
+// asnFromRecord returns the number of the autonomous system from m, if any.
+func asnFromRecord(m map[string]any) (asn uint32) {
+	if n, ok := m["autonomous_system_number"].(uint64); ok {
+		return uint32(n)
+	}
+
+	s, ok := m["asn"].(string)
+	if !ok {
+		return 0
+	}
+
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "AS"), 10, 32)
+	if err != nil {
+		return 0
+	}
+
+	return uint32(n)
+}
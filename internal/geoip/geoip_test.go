@@ -0,0 +1,286 @@
+package geoip_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/geoip"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testNode is a node of the search tree of a test database.
+type testNode struct {
+	children [2]*testNode
+	data     [2]int
+}
+
+// newTestNode returns a new node without data.
+func newTestNode() (n *testNode) {
+	return &testNode{data: [2]int{-1, -1}}
+}
+
+// encodeField appends the control byte for the field of type typ and size to
+// b.  size must be less than 29.
+func encodeField(b []byte, typ, size int) (res []byte) {
+	if typ > 7 {
+		return append(b, byte(size), byte(typ-7))
+	}
+
+	return append(b, byte(typ<<5|size))
+}
+
+// encode appends the encoded v to b.  v must be a string, a uint32, or a map
+// of those.
+func encode(b []byte, v any) (res []byte) {
+	switch v := v.(type) {
+	case string:
+		return append(encodeField(b, 2, len(v)), v...)
+	case uint32:
+		b = encodeField(b, 6, 4)
+
+		return binary.BigEndian.AppendUint32(b, v)
+	case map[string]any:
+		b = encodeField(b, 7, len(v))
+		keys := maps.Keys(v)
+		slices.Sort(keys)
+		for _, k := range keys {
+			b = encode(b, k)
+			b = encode(b, v[k])
+		}
+
+		return b
+	default:
+		panic("unsupported type")
+	}
+}
+
+// buildDB returns the contents of a database of the ipVersion with 24-bit
+// records, which maps the prefixes to records.  The first byte of the data
+// section is a shared string "shared", which may be referenced by a pointer
+// encoded as the []byte{0x20, 0x00}.
+func buildDB(t *testing.T, ipVersion int, records map[netip.Prefix]map[string]any) (b []byte) {
+	t.Helper()
+
+	data := encode(nil, "shared")
+	root := newTestNode()
+	for pref, rec := range records {
+		addr := pref.Addr().AsSlice()
+		n := root
+		for i := 0; i < pref.Bits(); i++ {
+			bit := addr[i/8] >> (7 - i%8) & 1
+			if i == pref.Bits()-1 {
+				n.data[bit] = len(data)
+
+				break
+			}
+
+			if n.children[bit] == nil {
+				n.children[bit] = newTestNode()
+			}
+
+			n = n.children[bit]
+		}
+
+		data = encode(data, rec)
+	}
+
+	var nodes []*testNode
+	for queue := []*testNode{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	idx := map[*testNode]int{}
+	for i, n := range nodes {
+		idx[n] = i
+	}
+
+	buf := &bytes.Buffer{}
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			rec := len(nodes)
+			if c := n.children[bit]; c != nil {
+				rec = idx[c]
+			} else if n.data[bit] >= 0 {
+				rec = len(nodes) + 16 + n.data[bit]
+			}
+
+			buf.Write([]byte{byte(rec >> 16), byte(rec >> 8), byte(rec)})
+		}
+	}
+
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	buf.Write(encode(nil, map[string]any{
+		"database_type": "Test",
+		"ip_version":    uint32(ipVersion),
+		"node_count":    uint32(len(nodes)),
+		"record_size":   uint32(24),
+	}))
+
+	return buf.Bytes()
+}
+
+// writeDB writes b into a new file and returns its path.
+func writeDB(t *testing.T, b []byte) (path string) {
+	t.Helper()
+
+	path = filepath.Join(t.TempDir(), "test.mmdb")
+	err := os.WriteFile(path, b, 0o644)
+	require.NoError(t, err)
+
+	return path
+}
+
+func TestDatabase_Lookup(t *testing.T) {
+	countryDB := buildDB(t, 4, map[netip.Prefix]map[string]any{
+		netip.MustParsePrefix("192.0.2.0/24"): {
+			"country": map[string]any{"iso_code": "de"},
+		},
+		netip.MustParsePrefix("198.51.100.0/24"): {
+			"registered_country": map[string]any{"iso_code": "FR"},
+		},
+	})
+
+	asnDB := buildDB(t, 6, map[netip.Prefix]map[string]any{
+		netip.MustParsePrefix("::c000:200/120"): {
+			"autonomous_system_number": uint32(64496),
+		},
+		netip.MustParsePrefix("2001:db8::/32"): {
+			"autonomous_system_number": uint32(64497),
+			"country":                  "NL",
+		},
+	})
+
+	db, err := geoip.New([]string{writeDB(t, countryDB), writeDB(t, asnDB)}, 0)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		ip   netip.Addr
+		want geoip.Info
+		name string
+	}{{
+		ip:   netip.MustParseAddr("192.0.2.1"),
+		want: geoip.Info{Country: "DE", ASN: 64496},
+		name: "both",
+	}, {
+		ip:   netip.MustParseAddr("::ffff:192.0.2.1"),
+		want: geoip.Info{Country: "DE", ASN: 64496},
+		name: "mapped",
+	}, {
+		ip:   netip.MustParseAddr("198.51.100.1"),
+		want: geoip.Info{Country: "FR"},
+		name: "registered_country",
+	}, {
+		ip:   netip.MustParseAddr("2001:db8::1"),
+		want: geoip.Info{Country: "NL", ASN: 64497},
+		name: "ipv6",
+	}, {
+		ip:   netip.MustParseAddr("203.0.113.1"),
+		want: geoip.Info{},
+		name: "not_found",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, lookupErr := db.Lookup(tc.ip)
+			require.NoError(t, lookupErr)
+
+			assert.Equal(t, tc.want, info)
+		})
+	}
+}
+
+func TestDatabase_Lookup_pointer(t *testing.T) {
+	b := buildDB(t, 4, map[netip.Prefix]map[string]any{
+		netip.MustParsePrefix("192.0.2.0/24"): {
+			"country": map[string]any{"iso_code": "XX"},
+		},
+	})
+
+	// Replace the value "XX" with the pointer to the "shared" string at the
+	// start of the data section and a padding.
+	i := bytes.Index(b, []byte("\x42XX"))
+	require.Positive(t, i)
+
+	copy(b[i:], []byte{0x20, 0x00, 0x00})
+
+	db, err := geoip.New([]string{writeDB(t, b)}, 0)
+	require.NoError(t, err)
+
+	info, err := db.Lookup(netip.MustParseAddr("192.0.2.1"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "SHARED", info.Country)
+}
+
+func TestDatabase_Lookup_reload(t *testing.T) {
+	path := writeDB(t, buildDB(t, 4, map[netip.Prefix]map[string]any{
+		netip.MustParsePrefix("192.0.2.0/24"): {"country": "DE"},
+	}))
+
+	db, err := geoip.New([]string{path}, time.Nanosecond)
+	require.NoError(t, err)
+
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	info, err := db.Lookup(ip)
+	require.NoError(t, err)
+
+	assert.Equal(t, "DE", info.Country)
+
+	err = os.WriteFile(path, []byte("broken"), 0o644)
+	require.NoError(t, err)
+
+	mtime := time.Now().Add(time.Hour)
+	err = os.Chtimes(path, mtime, mtime)
+	require.NoError(t, err)
+
+	// The previous data is kept.
+	info, err = db.Lookup(ip)
+	require.NoError(t, err)
+
+	assert.Equal(t, "DE", info.Country)
+
+	err = os.WriteFile(path, buildDB(t, 4, map[netip.Prefix]map[string]any{
+		netip.MustParsePrefix("192.0.2.0/24"): {"country": "FR"},
+	}), 0o644)
+	require.NoError(t, err)
+
+	mtime = mtime.Add(time.Hour)
+	err = os.Chtimes(path, mtime, mtime)
+	require.NoError(t, err)
+
+	info, err = db.Lookup(ip)
+	require.NoError(t, err)
+
+	assert.Equal(t, "FR", info.Country)
+}
+
+func TestNew_error(t *testing.T) {
+	_, err := geoip.New(nil, 0)
+	testutil.AssertErrorMsg(t, "no database files", err)
+
+	path := writeDB(t, []byte("broken"))
+	_, err = geoip.New([]string{path}, 0)
+	testutil.AssertErrorMsg(t, `loading geoip database "`+path+`": no metadata`, err)
+}
@@ -0,0 +1,389 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/mathutil"
+)
+
+// metadataMarker is the marker preceding the metadata section of a MaxMind DB
+// file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSep is the size of the separator between the search tree and the
+// data section.
+const dataSectionSep = 16
+
+// maxDecodeDepth is the maximum depth of the nested maps and arrays.
+const maxDecodeDepth = 32
+
+// Data field types.  See https://maxmind.github.io/MaxMind-DB/.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// reader reads the records from a database in the MaxMind DB format.
+type reader struct {
+	// tree is the binary search tree.
+	tree []byte
+
+	// data is the data section.
+	data []byte
+
+	// nodeCount is the number of the nodes in the search tree.
+	nodeCount uint
+
+	// recordSize is the size of a record in the search tree in bits.
+	recordSize uint
+
+	// ipv4Start is the node, at which the lookups of the IPv4 addresses start
+	// in an IPv6 tree.
+	ipv4Start uint
+
+	// ipVersion is the IP version of the search tree, 4 or 6.
+	ipVersion uint
+}
+
+// newReader returns a new reader of the database file contents b.
+func newReader(b []byte) (r *reader, err error) {
+	i := bytes.LastIndex(b, metadataMarker)
+	if i < 0 {
+		return nil, errors.Error("no metadata")
+	}
+
+	meta := b[i+len(metadataMarker):]
+	d := &decoder{data: meta}
+	v, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("metadata: want map, got %T", v)
+	}
+
+	r = &reader{
+		nodeCount:  toUint(m["node_count"]),
+		recordSize: toUint(m["record_size"]),
+		ipVersion:  toUint(m["ip_version"]),
+	}
+
+	switch {
+	case r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32:
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	case r.ipVersion != 4 && r.ipVersion != 6:
+		return nil, fmt.Errorf("unsupported ip version %d", r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSep > uint(i) {
+		return nil, fmt.Errorf("search tree size %d exceeds file size", treeSize)
+	}
+
+	r.tree = b[:treeSize]
+	r.data = b[treeSize+dataSectionSep : i]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < r.nodeCount; j++ {
+			node = r.record(node, 0)
+		}
+
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// record returns the left, if bit is 0, or the right record of node.
+func (r *reader) record(node, bit uint) (rec uint) {
+	off := node * r.recordSize / 4
+	b := r.tree[off : off+r.recordSize/4]
+
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the record for ip.  v is nil if there is none.
+func (r *reader) lookup(ip netip.Addr) (v any, err error) {
+	ip = ip.Unmap()
+
+	node, bits := uint(0), 128
+	if ip.Is4() {
+		bits = 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	addr := ip.AsSlice()
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	if node <= r.nodeCount {
+		// Either not found or the tree is malformed.
+		return nil, nil
+	}
+
+	off := node - r.nodeCount - dataSectionSep
+	if off >= uint(len(r.data)) {
+		return nil, fmt.Errorf("data offset %d out of range", off)
+	}
+
+	d := &decoder{data: r.data}
+	v, _, err = d.decode(off, 0)
+
+	return v, err
+}
+
+// decoder decodes the data section of a MaxMind DB file.
+type decoder struct {
+	data []byte
+}
+
+// decode decodes the field at off and returns its value and the offset of the
+// next field.
+func (d *decoder) decode(off uint, depth int) (v any, next uint, err error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.Error("too deep")
+	}
+
+	typ, size, off, err := d.ctrl(off)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		var ptr uint
+		ptr, next, err = d.pointer(size, off)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		v, _, err = d.decode(ptr, depth+1)
+
+		return v, next, err
+	}
+
+	return d.decodeValue(typ, size, off, depth)
+}
+
+// ctrl decodes the control byte and the size of the field at off.  For
+// pointers, size contains the raw size bits.
+func (d *decoder) ctrl(off uint) (typ, size, next uint, err error) {
+	if off >= uint(len(d.data)) {
+		return 0, 0, 0, errors.Error("unexpected end of data")
+	}
+
+	c := d.data[off]
+	off++
+
+	typ = uint(c >> 5)
+	if typ == typePointer {
+		return typ, uint(c & 0x1f), off, nil
+	} else if typ == typeExtended {
+		if off >= uint(len(d.data)) {
+			return 0, 0, 0, errors.Error("unexpected end of data")
+		}
+
+		typ = 7 + uint(d.data[off])
+		off++
+	}
+
+	size = uint(c & 0x1f)
+	if size < 29 {
+		return typ, size, off, nil
+	}
+
+	n := size - 28
+	if off+n > uint(len(d.data)) {
+		return 0, 0, 0, errors.Error("unexpected end of data")
+	}
+
+	ext := uintFromBytes(d.data[off : off+n])
+	switch size {
+	case 29:
+		size = 29 + ext
+	case 30:
+		size = 285 + ext
+	default:
+		size = 65821 + ext
+	}
+
+	return typ, size, off + n, nil
+}
+
+// pointer decodes the pointer with the size bits sizeBits, which value starts
+// at off.
+func (d *decoder) pointer(sizeBits, off uint) (ptr, next uint, err error) {
+	n := (sizeBits>>3)&0x3 + 1
+	if off+n > uint(len(d.data)) {
+		return 0, 0, errors.Error("unexpected end of data")
+	}
+
+	b := d.data[off : off+n]
+	switch n {
+	case 1:
+		ptr = (sizeBits&0x7)<<8 | uint(b[0])
+	case 2:
+		ptr = ((sizeBits&0x7)<<16 | uintFromBytes(b)) + 2048
+	case 3:
+		ptr = ((sizeBits&0x7)<<24 | uintFromBytes(b)) + 526336
+	default:
+		ptr = uintFromBytes(b)
+	}
+
+	return ptr, off + n, nil
+}
+
+// decodeValue decodes the value of a field of type typ and size, which starts
+// at off.
+func (d *decoder) decodeValue(typ, size, off uint, depth int) (v any, next uint, err error) {
+	switch typ {
+	case typeMap:
+		return d.decodeMap(size, off, depth)
+	case typeArray:
+		return d.decodeArray(size, off, depth)
+	case typeBool:
+		return size != 0, off, nil
+	case typeContainer, typeEndMarker:
+		return nil, off, nil
+	}
+
+	if off+size > uint(len(d.data)) {
+		return nil, 0, errors.Error("unexpected end of data")
+	}
+
+	b, next := d.data[off:off+size], off+size
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("bad double size %d", size)
+		}
+
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("bad float size %d", size)
+		}
+
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("bad uint size %d", size)
+		}
+
+		return uint64(uintFromBytes(b)), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("bad int32 size %d", size)
+		}
+
+		return int32(uintFromBytes(b)), next, nil
+	case typeUint128:
+		// Not used by the supported databases.
+		return bytes.Clone(b), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown type %d", typ)
+	}
+}
+
+// decodeMap decodes the map with size pairs starting at off.
+func (d *decoder) decodeMap(size, off uint, depth int) (v any, next uint, err error) {
+	m := make(map[string]any, mathutil.Min(size, 64))
+	for i := uint(0); i < size; i++ {
+		var k, val any
+		k, off, err = d.decode(off, depth+1)
+		if err != nil {
+			return nil, 0, fmt.Errorf("map key: %w", err)
+		}
+
+		key, ok := k.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("map key: want string, got %T", k)
+		}
+
+		val, off, err = d.decode(off, depth+1)
+		if err != nil {
+			return nil, 0, fmt.Errorf("map value %q: %w", key, err)
+		}
+
+		m[key] = val
+	}
+
+	return m, off, nil
+}
+
+// decodeArray decodes the array with size elements starting at off.
+func (d *decoder) decodeArray(size, off uint, depth int) (v any, next uint, err error) {
+	a := make([]any, 0, mathutil.Min(size, 64))
+	for i := uint(0); i < size; i++ {
+		var val any
+		val, off, err = d.decode(off, depth+1)
+		if err != nil {
+			return nil, 0, fmt.Errorf("array element at index %d: %w", i, err)
+		}
+
+		a = append(a, val)
+	}
+
+	return a, off, nil
+}
+
+// uintFromBytes returns the big-endian unsigned integer from b, which must not
+// be longer than 8 bytes.
+func uintFromBytes(b []byte) (n uint) {
+	for _, c := range b {
+		n = n<<8 | uint(c)
+	}
+
+	return n
+}
+
+// toUint returns v as an unsigned integer or zero if it isn't one.
+func toUint(v any) (n uint) {
+	u, _ := v.(uint64)
+
+	return uint(u)
+}
@@ -0,0 +1,105 @@
+package home
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// anomalyConfig is the configuration of the detection of the anomalies in the
+// DNS query patterns.  See [anomaly.Config].
+type anomalyConfig struct {
+	// Window is the duration of the window, within which the queries are
+	// counted.
+	Window timeutil.Duration `yaml:"window"`
+
+	// Cooldown is the minimum duration between two alerts of the same type
+	// about the same client or domain.
+	Cooldown timeutil.Duration `yaml:"cooldown"`
+
+	// MinQueries is the minimum number of queries within a window, for which
+	// the NXDOMAIN share and the query rate of a client are checked.
+	MinQueries uint64 `yaml:"min_queries"`
+
+	// RandomSubdomains is the minimum number of distinct random-looking
+	// subdomains of a single domain within a window considered an anomaly.
+	RandomSubdomains uint64 `yaml:"random_subdomains"`
+
+	// NXDomainRatio is the share of the NXDOMAIN responses within a window
+	// considered an anomaly.
+	NXDomainRatio float64 `yaml:"nxdomain_ratio"`
+
+	// MinEntropy is the minimum average entropy of the subdomain labels, in
+	// bits per character, considered random.
+	MinEntropy float64 `yaml:"min_entropy"`
+
+	// RateFactor is the minimum ratio of the number of the client's queries
+	// within a window to its usual number considered an anomaly.
+	RateFactor float64 `yaml:"rate_factor"`
+
+	// MaxAlerts is the maximum number of the kept recent alerts.
+	MaxAlerts int `yaml:"max_alerts"`
+
+	// Enabled defines if the anomaly detection is enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// defaultAnomalyConfig returns the default configuration of the anomaly
+// detection.
+func defaultAnomalyConfig() (c *anomalyConfig) {
+	return &anomalyConfig{
+		Window:           timeutil.Duration{Duration: time.Minute},
+		Cooldown:         timeutil.Duration{Duration: 10 * time.Minute},
+		MinQueries:       100,
+		RandomSubdomains: 100,
+		NXDomainRatio:    0.5,
+		MinEntropy:       3,
+		RateFactor:       10,
+		MaxAlerts:        100,
+		Enabled:          false,
+	}
+}
+
+// toInternal returns the configuration of the detector.  onAlert is called
+// with each detected anomaly, if not nil.
+func (c *anomalyConfig) toInternal(onAlert func(a *anomaly.Alert)) (conf *anomaly.Config) {
+	return &anomaly.Config{
+		OnAlert:          onAlert,
+		HTTPRegister:     httpRegister,
+		Window:           c.Window.Duration,
+		Cooldown:         c.Cooldown.Duration,
+		MinQueries:       c.MinQueries,
+		RandomSubdomains: c.RandomSubdomains,
+		NXDomainRatio:    c.NXDomainRatio,
+		MinEntropy:       c.MinEntropy,
+		RateFactor:       c.RateFactor,
+		MaxAlerts:        c.MaxAlerts,
+	}
+}
+
+// validate returns an error if the anomaly detection configuration is invalid.
+func (c *anomalyConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	return c.toInternal(nil).Validate()
+}
+
+// newAnomalyDetector returns a new anomaly detector for c, or nil if it's
+// disabled.
+func newAnomalyDetector(c *anomalyConfig) (d *anomaly.Detector, err error) {
+	if c == nil || !c.Enabled {
+		return nil, nil
+	}
+
+	conf := c.toInternal(onAnomaly)
+	err = conf.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("validating: %w", err)
+	}
+
+	return anomaly.New(conf), nil
+}
@@ -0,0 +1,267 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/audit"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// auditConfig is the configuration of the remote audit log.
+type auditConfig struct {
+	// URL is the address of the remote collector.  See [audit.Config.URL].
+	URL string `yaml:"url"`
+
+	// BufferSize is the maximum number of the undelivered events kept in
+	// memory and in the spool file.
+	BufferSize int `yaml:"buffer_size"`
+
+	// Enabled defines if the audit events are shipped to the collector.
+	Enabled bool `yaml:"enabled"`
+
+	// LocalEnabled defines if the audit events are kept in the local file,
+	// which can be reviewed using the HTTP API.
+	LocalEnabled bool `yaml:"local_enabled"`
+}
+
+// defaultAuditBufferSize is the default maximum number of the undelivered
+// audit events.
+const defaultAuditBufferSize = 1000
+
+// auditFileName is the name of the local audit log file in the data directory.
+const auditFileName = "audit.json"
+
+// auditFileMaxSize is the size of the local audit log file, after exceeding
+// which it's rotated.
+const auditFileMaxSize = 10 * 1024 * 1024
+
+// auditLogAPI is the path of the HTTP API returning the events of the local
+// audit log.
+const auditLogAPI = "/control/audit_log"
+
+// defaultAuditLogLimit is the default maximum number of the events returned
+// by the audit log HTTP API.
+const defaultAuditLogLimit = 100
+
+// validate returns an error if the audit configuration is invalid.
+func (c *auditConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("bad url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp", aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS:
+		// Go on.
+	default:
+		return fmt.Errorf("bad url scheme %q", u.Scheme)
+	}
+
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("buffer_size: must be positive, got %d", c.BufferSize)
+	}
+
+	return nil
+}
+
+// newAuditLogger returns a new audit logger for c, which must be enabled and
+// valid.
+func newAuditLogger(c *auditConfig) (l *audit.Logger, err error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("bad url: %w", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Debug("audit: getting hostname: %s", err)
+	}
+
+	return audit.New(&audit.Config{
+		HTTPClient: httpClient(),
+		URL:        u,
+		Hostname:   hostname,
+		SpoolFile:  filepath.Join(Context.getDataDir(), "audit.spool"),
+		BufferSize: c.BufferSize,
+	})
+}
+
+// auditEnabled returns true if either the remote or the local audit log is
+// enabled.
+func auditEnabled() (ok bool) {
+	return Context.audit != nil || Context.auditFile != nil
+}
+
+// recordAudit records the administrative action of r into the audit logs, if
+// they are enabled.
+func recordAudit(r *http.Request, action audit.Action, user string, status int) {
+	if !auditEnabled() {
+		return
+	}
+
+	// Use the remote address, as in the authentication, since the real IP
+	// headers could be spoofed.
+	remoteIP, err := netutil.SplitHost(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	e := &audit.Event{
+		Time:     time.Now(),
+		Action:   action,
+		User:     user,
+		RemoteIP: remoteIP,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Status:   status,
+	}
+
+	if Context.audit != nil {
+		Context.audit.Record(e)
+	}
+
+	if Context.auditFile != nil {
+		Context.auditFile.Record(e)
+	}
+}
+
+// auditAction returns the type of the audited action for the request changing
+// the data at path.
+func auditAction(path string) (action audit.Action) {
+	for _, prefix := range []string{
+		"/control/blocked_services/",
+		"/control/filtering/",
+		"/control/rewrite/",
+	} {
+		if strings.HasPrefix(path, prefix) {
+			return audit.ActionRuleChange
+		}
+	}
+
+	return audit.ActionConfigChange
+}
+
+// auditStatusWriter is an [http.ResponseWriter] remembering the status code of
+// the response.
+type auditStatusWriter struct {
+	http.ResponseWriter
+
+	status int
+}
+
+// WriteHeader implements the [http.ResponseWriter] interface for
+// *auditStatusWriter.
+func (w *auditStatusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the [http.ResponseWriter] interface for *auditStatusWriter.
+func (w *auditStatusWriter) Write(b []byte) (n int, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// auditHandler returns a handler recording the requests changing the data
+// into the audit log.
+func auditHandler(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auditEnabled() || !modifiesData(r.Method) {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		sw := &auditStatusWriter{
+			ResponseWriter: w,
+		}
+
+		h.ServeHTTP(sw, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		recordAudit(r, auditAction(r.URL.Path), Context.auth.getCurrentUser(r).Name, status)
+	})
+}
+
+// auditLogJSON is the JSON structure for the response of the audit log HTTP
+// API.
+type auditLogJSON struct {
+	Events []*audit.Event `json:"events"`
+}
+
+// handleAuditLog is the handler for the GET /control/audit_log HTTP API.
+func handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p := &audit.SearchParams{
+		Action: audit.Action(q.Get("action")),
+		User:   q.Get("user"),
+		Limit:  defaultAuditLogLimit,
+	}
+
+	var err error
+	if olderThan := q.Get("older_than"); olderThan != "" {
+		p.OlderThan, err = time.Parse(time.RFC3339Nano, olderThan)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "parsing older_than: %s", err)
+
+			return
+		}
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		p.Limit, err = strconv.Atoi(limit)
+		if err != nil || p.Limit <= 0 {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad limit %q", limit)
+
+			return
+		}
+	}
+
+	events, err := Context.auditFile.Events(p)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "reading audit log: %s", err)
+
+		return
+	}
+
+	if events == nil {
+		events = []*audit.Event{}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &auditLogJSON{
+		Events: events,
+	})
+}
+
+// registerAuditHandlers registers the HTTP handlers of the local audit log, if
+// it's enabled.
+func registerAuditHandlers() {
+	if Context.auditFile == nil {
+		return
+	}
+
+	httpRegister(http.MethodGet, auditLogAPI, handleAuditLog)
+}
@@ -0,0 +1,95 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/audit"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditConfig_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *auditConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf: &auditConfig{
+			URL:        "udp://192.0.2.1:514",
+			BufferSize: defaultAuditBufferSize,
+			Enabled:    true,
+		},
+		name:       "valid_syslog",
+		wantErrMsg: "",
+	}, {
+		conf: &auditConfig{
+			URL:        "https://collector.example/events",
+			BufferSize: defaultAuditBufferSize,
+			Enabled:    true,
+		},
+		name:       "valid_http",
+		wantErrMsg: "",
+	}, {
+		conf: &auditConfig{
+			URL:        "ftp://192.0.2.1",
+			BufferSize: defaultAuditBufferSize,
+			Enabled:    true,
+		},
+		name:       "bad_scheme",
+		wantErrMsg: `bad url scheme "ftp"`,
+	}, {
+		conf: &auditConfig{
+			URL:        "udp://192.0.2.1:514",
+			BufferSize: 0,
+			Enabled:    true,
+		},
+		name:       "bad_buffer_size",
+		wantErrMsg: "buffer_size: must be positive, got 0",
+	}, {
+		conf: &auditConfig{
+			URL:        "ftp://192.0.2.1",
+			BufferSize: 0,
+			Enabled:    false,
+		},
+		name:       "disabled",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
+
+func TestAuditAction(t *testing.T) {
+	testCases := []struct {
+		path string
+		want audit.Action
+	}{{
+		path: "/control/filtering/set_rules",
+		want: audit.ActionRuleChange,
+	}, {
+		path: "/control/rewrite/add",
+		want: audit.ActionRuleChange,
+	}, {
+		path: "/control/blocked_services/update",
+		want: audit.ActionRuleChange,
+	}, {
+		path: "/control/dns_config",
+		want: audit.ActionConfigChange,
+	}, {
+		path: "/control/clients/add",
+		want: audit.ActionConfigChange,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			assert.Equal(t, tc.want, auditAction(tc.path))
+		})
+	}
+}
@@ -14,7 +14,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/audit"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
@@ -22,6 +24,7 @@ import (
 	"github.com/AdguardTeam/golibs/timeutil"
 	"go.etcd.io/bbolt"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slices"
 )
 
 // cookieTTL is the time-to-live of the session cookie.
@@ -72,14 +75,39 @@ type Auth struct {
 	raleLimiter *authRateLimiter
 	sessions    map[string]*session
 	users       []webUser
-	lock        sync.Mutex
-	sessionTTL  uint32
+
+	// totpPending are the TOTP secrets of the unconfirmed enrollments by the
+	// user names.
+	totpPending map[string]string
+
+	// totpLastSteps are the time steps of the last used TOTP codes by the user
+	// names, which are kept to prevent reusing the codes.
+	totpLastSteps map[string]uint64
+
+	lock       sync.Mutex
+	sessionTTL uint32
 }
 
 // webUser represents a user of the Web UI.
 type webUser struct {
 	Name         string `yaml:"name"`
 	PasswordHash string `yaml:"password"`
+
+	// Role defines the HTTP APIs available to the user.  An empty role means
+	// [roleAdmin].
+	Role userRole `yaml:"role"`
+
+	// Clients are the names of the persistent clients, which the user with
+	// [roleParent] is allowed to manage.
+	Clients []string `yaml:"clients"`
+
+	// TOTPSecret is the base32-encoded TOTP secret of the user.  If not empty,
+	// the two-factor authentication is enabled for the user.
+	TOTPSecret string `yaml:"totp_secret"`
+
+	// RecoveryCodes are the hashes of the unused recovery codes, which can be
+	// used instead of the TOTP codes.
+	RecoveryCodes []string `yaml:"recovery_codes"`
 }
 
 // InitAuth - create a global object
@@ -87,10 +115,12 @@ func InitAuth(dbFilename string, users []webUser, sessionTTL uint32, rateLimiter
 	log.Info("Initializing auth module: %s", dbFilename)
 
 	a := &Auth{
-		sessionTTL:  sessionTTL,
-		raleLimiter: rateLimiter,
-		sessions:    make(map[string]*session),
-		users:       users,
+		sessionTTL:    sessionTTL,
+		raleLimiter:   rateLimiter,
+		sessions:      make(map[string]*session),
+		users:         users,
+		totpPending:   map[string]string{},
+		totpLastSteps: map[string]uint64{},
 	}
 	var err error
 	a.db, err = bbolt.Open(dbFilename, 0o644, nil)
@@ -313,6 +343,10 @@ func (a *Auth) RemoveSession(sess string) {
 type loginJSON struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
+
+	// TOTP is the TOTP or recovery code of the user with the two-factor
+	// authentication enabled.
+	TOTP string `json:"totp"`
 }
 
 // newSessionToken returns cryptographically secure randomly generated slice of
@@ -332,18 +366,10 @@ func newSessionToken() (data []byte, err error) {
 
 // newCookie creates a new authentication cookie.
 func (a *Auth) newCookie(req loginJSON, addr string) (c *http.Cookie, err error) {
-	rateLimiter := a.raleLimiter
-	u, ok := a.findUser(req.Name, req.Password)
-	if !ok {
-		if rateLimiter != nil {
-			rateLimiter.inc(addr)
-		}
-
-		return nil, errors.Error("invalid username or password")
-	}
-
-	if rateLimiter != nil {
-		rateLimiter.remove(addr)
+	u, err := a.authenticate(req, addr)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
 	}
 
 	sess, err := newSessionToken()
@@ -369,6 +395,41 @@ func (a *Auth) newCookie(req loginJSON, addr string) (c *http.Cookie, err error)
 	}, nil
 }
 
+// authenticate checks the credentials and the second factor of the user from
+// req, which are sent from the address addr, and returns the user.  The failed
+// attempts are counted by the rate limiter.
+func (a *Auth) authenticate(req loginJSON, addr string) (u webUser, err error) {
+	rateLimiter := a.raleLimiter
+	u, ok := a.findUser(req.Name, req.Password)
+	if !ok {
+		if rateLimiter != nil {
+			rateLimiter.inc(addr)
+		}
+
+		return webUser{}, errors.Error("invalid username or password")
+	}
+
+	a.lock.Lock()
+	modified, err := a.checkSecondFactor(u.Name, req.TOTP)
+	a.lock.Unlock()
+	if err != nil {
+		if rateLimiter != nil && !errors.Is(err, errTOTPRequired) {
+			rateLimiter.inc(addr)
+		}
+
+		// Don't wrap the error, because it's informative enough as is.
+		return webUser{}, err
+	} else if modified {
+		onConfigModified()
+	}
+
+	if rateLimiter != nil {
+		rateLimiter.remove(addr)
+	}
+
+	return u, nil
+}
+
 // realIP extracts the real IP address of the client from an HTTP request using
 // the known HTTP headers.
 //
@@ -458,6 +519,8 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	if rateLimiter := Context.auth.raleLimiter; rateLimiter != nil {
 		if left := rateLimiter.check(remoteIP); left > 0 {
+			recordAudit(r, audit.ActionLoginFailed, req.Name, http.StatusTooManyRequests)
+
 			w.Header().Set(httphdr.RetryAfter, strconv.Itoa(int(left.Seconds())))
 			writeErrorWithIP(
 				r,
@@ -474,7 +537,22 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	cookie, err := Context.auth.newCookie(req, remoteIP)
 	if err != nil {
-		writeErrorWithIP(r, w, http.StatusForbidden, remoteIP, "%s", err)
+		code := http.StatusForbidden
+		if errors.Is(err, errTOTPRequired) {
+			code = http.StatusUnauthorized
+		}
+
+		recordAudit(r, audit.ActionLoginFailed, req.Name, code)
+
+		if rateLimiter := Context.auth.raleLimiter; rateLimiter != nil {
+			if left := rateLimiter.blocked(remoteIP); left > 0 {
+				log.Info("auth: blocked ip %s from logging in for %s", remoteIP, left)
+
+				recordAudit(r, audit.ActionLoginBlocked, req.Name, code)
+			}
+		}
+
+		writeErrorWithIP(r, w, code, remoteIP, "%s", err)
 
 		return
 	}
@@ -487,6 +565,8 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	log.Info("auth: user %q successfully logged in from ip %v", req.Name, ip)
 
+	recordAudit(r, audit.ActionLogin, req.Name, http.StatusOK)
+
 	http.SetCookie(w, cookie)
 
 	h := w.Header()
@@ -509,6 +589,8 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAudit(r, audit.ActionLogout, Context.auth.getCurrentUser(r).Name, http.StatusFound)
+
 	Context.auth.RemoveSession(c.Value)
 
 	c = &http.Cookie{
@@ -530,6 +612,8 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 func RegisterAuthHandlers() {
 	Context.mux.Handle("/control/login", postInstallHandler(ensureHandler(http.MethodPost, handleLogin)))
 	httpRegister(http.MethodGet, "/control/logout", handleLogout)
+	Context.auth.registerUsersHandlers()
+	Context.auth.registerTOTPHandlers()
 }
 
 // optionalAuthThird return true if user should authenticate first.
@@ -548,9 +632,10 @@ func optionalAuthThird(w http.ResponseWriter, r *http.Request) (mustAuth bool) {
 		// Check Basic authentication.
 		user, pass, hasBasic := r.BasicAuth()
 		if hasBasic {
-			_, isAuthenticated = Context.auth.findUser(user, pass)
-			if !isAuthenticated {
-				log.Info("auth: invalid Basic Authorization value")
+			var blocked bool
+			isAuthenticated, blocked = Context.auth.checkBasicAuth(w, r, user, pass)
+			if blocked {
+				return true
 			}
 		}
 	} else {
@@ -581,6 +666,69 @@ func optionalAuthThird(w http.ResponseWriter, r *http.Request) (mustAuth bool) {
 	return true
 }
 
+// checkBasicAuth checks the Basic authentication credentials from r the same
+// way as [handleLogin] checks the login requests: the failed attempts are
+// counted by the rate limiter and recorded in the audit log.  blocked is true if
+// the address is blocked, in which case the response has already been written.
+func (a *Auth) checkBasicAuth(
+	w http.ResponseWriter,
+	r *http.Request,
+	user string,
+	pass string,
+) (ok, blocked bool) {
+	// Use the remote address, as in [handleLogin], since the real IP headers
+	// could be spoofed.
+	remoteIP, err := netutil.SplitHost(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	rateLimiter := a.raleLimiter
+	if rateLimiter != nil {
+		if left := rateLimiter.check(remoteIP); left > 0 {
+			recordAudit(r, audit.ActionLoginFailed, user, http.StatusTooManyRequests)
+
+			w.Header().Set(httphdr.RetryAfter, strconv.Itoa(int(left.Seconds())))
+			writeErrorWithIP(
+				r,
+				w,
+				http.StatusTooManyRequests,
+				remoteIP,
+				"auth: blocked for %s",
+				left,
+			)
+
+			return false, true
+		}
+	}
+
+	// The users with the two-factor authentication enabled can't use Basic
+	// authentication.
+	u, ok := a.findUser(user, pass)
+	if ok && !u.totpEnabled() {
+		if rateLimiter != nil {
+			rateLimiter.remove(remoteIP)
+		}
+
+		return true, false
+	}
+
+	log.Info("auth: invalid Basic Authorization value from ip %s", remoteIP)
+
+	recordAudit(r, audit.ActionLoginFailed, user, http.StatusForbidden)
+
+	if rateLimiter != nil {
+		rateLimiter.inc(remoteIP)
+		if left := rateLimiter.blocked(remoteIP); left > 0 {
+			log.Info("auth: blocked ip %s from logging in for %s", remoteIP, left)
+
+			recordAudit(r, audit.ActionLoginBlocked, user, http.StatusForbidden)
+		}
+	}
+
+	return false, false
+}
+
 // TODO(a.garipov): Use [http.Handler] consistently everywhere throughout the
 // project.
 func optionalAuth(
@@ -682,6 +830,16 @@ func (a *Auth) findUser(login, password string) (u webUser, ok bool) {
 	return webUser{}, false
 }
 
+// requestUser returns the name of the authenticated user making r.  name is
+// empty if the authentication is disabled.
+func requestUser(r *http.Request) (name string) {
+	if Context.auth == nil {
+		return ""
+	}
+
+	return Context.auth.getCurrentUser(r).Name
+}
+
 // getCurrentUser returns the current user.  It returns an empty User if the
 // user is not found.
 func (a *Auth) getCurrentUser(r *http.Request) (u webUser) {
@@ -691,6 +849,9 @@ func (a *Auth) getCurrentUser(r *http.Request) (u webUser) {
 		user, pass, ok := r.BasicAuth()
 		if ok {
 			u, _ = Context.auth.findUser(user, pass)
+			if u.totpEnabled() {
+				return webUser{}
+			}
 
 			return u
 		}
@@ -715,6 +876,112 @@ func (a *Auth) getCurrentUser(r *http.Request) (u webUser) {
 	return webUser{}
 }
 
+// addUser adds a new user with the given password.
+func (a *Auth) addUser(u *webUser, password string) (err error) {
+	if password == "" {
+		return errors.Error("empty password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("generating hash: %w", err)
+	}
+
+	u.PasswordHash = string(hash)
+
+	return a.modifyUsers(func(users []webUser) (res []webUser, err error) {
+		return append(users, *u), nil
+	})
+}
+
+// updateUser replaces the user with the given name with u.  If password is
+// empty, the password is kept unchanged.
+func (a *Auth) updateUser(name string, u *webUser, password string) (err error) {
+	var hash []byte
+	if password != "" {
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("generating hash: %w", err)
+		}
+	}
+
+	return a.modifyUsers(func(users []webUser) (res []webUser, err error) {
+		i := slices.IndexFunc(users, func(prev webUser) (ok bool) { return prev.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("user %q: not found", name)
+		}
+
+		u.PasswordHash = aghalg.Coalesce(string(hash), users[i].PasswordHash)
+		u.TOTPSecret = users[i].TOTPSecret
+		u.RecoveryCodes = users[i].RecoveryCodes
+		users[i] = *u
+
+		return users, nil
+	})
+}
+
+// removeUser removes the user with the given name.
+func (a *Auth) removeUser(name string) (err error) {
+	return a.modifyUsers(func(users []webUser) (res []webUser, err error) {
+		i := slices.IndexFunc(users, func(u webUser) (ok bool) { return u.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("user %q: not found", name)
+		}
+
+		return slices.Delete(users, i, i+1), nil
+	})
+}
+
+// modifyUsers replaces the users with the ones returned by modify, which
+// receives a copy of the current users.  The result must be valid and contain
+// at least one administrator.  The sessions of the removed and renamed users
+// as well as of the users with the changed passwords are removed.
+func (a *Auth) modifyUsers(modify func(users []webUser) (res []webUser, err error)) (err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	users, err := modify(slices.Clone(a.users))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = validateUsers(users)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if !slices.ContainsFunc(users, func(u webUser) (ok bool) { return u.role() == roleAdmin }) {
+		return errors.Error("at least one admin is required")
+	}
+
+	prevHashes := make(map[string]string, len(a.users))
+	for _, u := range a.users {
+		prevHashes[u.Name] = u.PasswordHash
+	}
+
+	hashes := make(map[string]string, len(users))
+	for _, u := range users {
+		hashes[u.Name] = u.PasswordHash
+	}
+
+	for sess, s := range a.sessions {
+		hash, ok := hashes[s.userName]
+		if ok && hash == prevHashes[s.userName] {
+			continue
+		}
+
+		delete(a.sessions, sess)
+		key, _ := hex.DecodeString(sess)
+		a.removeSession(key)
+	}
+
+	a.users = users
+
+	return nil
+}
+
 // GetUsers - get users
 func (a *Auth) GetUsers() []webUser {
 	a.lock.Lock()
@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/textproto"
 	"net/url"
 	"path/filepath"
@@ -197,6 +198,39 @@ func TestAuthHTTP(t *testing.T) {
 	Context.auth.Close()
 }
 
+func TestAuth_checkBasicAuth(t *testing.T) {
+	users := []webUser{
+		{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
+	}
+	a := InitAuth(filepath.Join(t.TempDir(), "sessions.db"), users, 60, newAuthRateLimiter(time.Minute, 2))
+	t.Cleanup(a.Close)
+
+	check := func(pass string) (ok, blocked bool, w *httptest.ResponseRecorder) {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/control/status", nil)
+
+		ok, blocked = a.checkBasicAuth(w, r, "name", pass)
+
+		return ok, blocked, w
+	}
+
+	ok, blocked, _ := check("password")
+	assert.True(t, ok)
+	assert.False(t, blocked)
+
+	ok, blocked, _ = check("bad")
+	assert.False(t, ok)
+	assert.False(t, blocked)
+
+	// The next attempt is delayed after a failed one.
+	ok, blocked, w := check("password")
+	assert.False(t, ok)
+	assert.True(t, blocked)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get(httphdr.RetryAfter))
+}
+
 func TestRealIP(t *testing.T) {
 	const remoteAddr = "1.2.3.4:5678"
 
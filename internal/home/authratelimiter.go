@@ -3,16 +3,42 @@ package home
 import (
 	"sync"
 	"time"
+
+	"github.com/AdguardTeam/golibs/mathutil"
 )
 
 // failedAuthTTL is the period of time for which the failed attempt will stay in
 // cache.
 const failedAuthTTL = 1 * time.Minute
 
+// authBackoffBase is the delay after the first failed attempt.  It's doubled
+// after each subsequent failed attempt until the attempter is blocked.
+const authBackoffBase = 1 * time.Second
+
+// maxAuthBlockDur is the maximum duration of the block of an attempter, which
+// is doubled after each subsequent block.
+const maxAuthBlockDur = 24 * time.Hour
+
 // failedAuth is an entry of authRateLimiter's cache.
 type failedAuth struct {
+	// until is the time until which the entry is kept and, if num has reached
+	// the maximum, the attempter is blocked.
 	until time.Time
-	num   uint
+
+	// next is the earliest time of the next attempt according to the
+	// exponential backoff.
+	next time.Time
+
+	// blocksUntil is the time until which the number of the previous blocks
+	// is kept.
+	blocksUntil time.Time
+
+	// num is the number of the failed attempts since the last block.
+	num uint
+
+	// blocks is the number of the previous blocks, each of which doubles the
+	// duration of the next one.
+	blocks uint
 }
 
 // authRateLimiter used to cache failed authentication attempts.
@@ -37,9 +63,23 @@ func newAuthRateLimiter(blockDur time.Duration, maxAttempts uint) (ab *authRateL
 // internal use only.
 func (ab *authRateLimiter) cleanupLocked(now time.Time) {
 	for k, v := range ab.failedAuths {
-		if now.After(v.until) {
-			delete(ab.failedAuths, k)
+		if !now.After(v.until) {
+			continue
+		}
+
+		if v.blocks > 0 && now.Before(v.blocksUntil) {
+			// Keep the number of the previous blocks to make the next one
+			// longer.
+			ab.failedAuths[k] = failedAuth{
+				until:       v.blocksUntil,
+				blocksUntil: v.blocksUntil,
+				blocks:      v.blocks,
+			}
+
+			continue
 		}
+
+		delete(ab.failedAuths, k)
 	}
 }
 
@@ -51,6 +91,17 @@ func (ab *authRateLimiter) checkLocked(usrID string, now time.Time) (left time.D
 	}
 
 	if a.num < ab.maxAttempts {
+		return a.next.Sub(now)
+	}
+
+	return a.until.Sub(now)
+}
+
+// blockedLocked returns the time left until unblocking the attempter, not
+// counting the backoff delay.  For internal use only.
+func (ab *authRateLimiter) blockedLocked(usrID string, now time.Time) (left time.Duration) {
+	a, ok := ab.failedAuths[usrID]
+	if !ok || a.num < ab.maxAttempts {
 		return 0
 	}
 
@@ -70,25 +121,58 @@ func (ab *authRateLimiter) check(usrID string) (left time.Duration) {
 	return ab.checkLocked(usrID, now)
 }
 
+// blocked returns the time left until unblocking the attempter after too many
+// failed attempts.  Unlike [authRateLimiter.check], it doesn't count the
+// backoff delay.  The nonpositive result should be interpreted as not blocked
+// attempter.
+func (ab *authRateLimiter) blocked(usrID string) (left time.Duration) {
+	now := time.Now()
+
+	ab.failedAuthsLock.Lock()
+	defer ab.failedAuthsLock.Unlock()
+
+	return ab.blockedLocked(usrID, now)
+}
+
 // incLocked increments the number of unsuccessful attempts for attempter with
 // usrID and updates it's blocking moment if needed.  For internal use only.
 func (ab *authRateLimiter) incLocked(usrID string, now time.Time) {
-	until := now.Add(failedAuthTTL)
-	var attNum uint = 1
-
 	a, ok := ab.failedAuths[usrID]
-	if ok {
-		until = a.until
-		attNum = a.num + 1
+	if !ok {
+		a.until = now.Add(failedAuthTTL)
+	}
+
+	a.num++
+	if a.num < ab.maxAttempts {
+		a.next = now.Add(mathutil.Min(authBackoffBase<<(a.num-1), ab.blockDur))
+		if a.until.Before(a.next) {
+			a.until = a.next
+		}
+
+		ab.failedAuths[usrID] = a
+
+		return
 	}
-	if attNum >= ab.maxAttempts {
-		until = now.Add(ab.blockDur)
+
+	if a.num == ab.maxAttempts {
+		a.blocks++
 	}
 
-	ab.failedAuths[usrID] = failedAuth{
-		num:   attNum,
-		until: until,
+	blockDur := ab.blockDurFor(a.blocks)
+	a.until = now.Add(blockDur)
+	a.blocksUntil = a.until.Add(blockDur)
+	ab.failedAuths[usrID] = a
+}
+
+// blockDurFor returns the duration of the block with the given number, which
+// doubles with each block.
+func (ab *authRateLimiter) blockDurFor(blocks uint) (dur time.Duration) {
+	dur = ab.blockDur
+	for i := uint(1); i < blocks && dur < maxAuthBlockDur; i++ {
+		dur *= 2
 	}
+
+	return mathutil.Max(mathutil.Min(dur, maxAuthBlockDur), ab.blockDur)
 }
 
 // inc updates the failed attempt in cache.
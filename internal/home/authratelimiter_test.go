@@ -205,3 +205,55 @@ func TestAuthRateLimiter_Remove(t *testing.T) {
 
 	assert.Empty(t, ab.failedAuths)
 }
+
+func TestAuthRateLimiter_backoff(t *testing.T) {
+	const (
+		key      = "some-key"
+		maxAtt   = 4
+		blockDur = 15 * time.Minute
+	)
+
+	ab := newAuthRateLimiter(blockDur, maxAtt)
+	now := time.Now()
+
+	for i, want := range []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second} {
+		ab.incLocked(key, now)
+
+		assert.Equal(t, want, ab.checkLocked(key, now), i)
+		assert.LessOrEqual(t, ab.blockedLocked(key, now), time.Duration(0), i)
+	}
+
+	ab.incLocked(key, now)
+
+	assert.Equal(t, blockDur, ab.checkLocked(key, now))
+	assert.Equal(t, blockDur, ab.blockedLocked(key, now))
+
+	// The number of the blocks is kept after the block.
+	now = now.Add(blockDur + time.Second)
+	ab.cleanupLocked(now)
+
+	require.Contains(t, ab.failedAuths, key)
+	assert.LessOrEqual(t, ab.checkLocked(key, now), time.Duration(0))
+
+	for i := 0; i < maxAtt; i++ {
+		ab.incLocked(key, now)
+	}
+
+	// The next block is twice as long.
+	assert.Equal(t, 2*blockDur, ab.blockedLocked(key, now))
+
+	// Everything is forgotten eventually.
+	now = now.Add(10 * blockDur)
+	ab.cleanupLocked(now)
+
+	assert.Empty(t, ab.failedAuths)
+}
+
+func TestAuthRateLimiter_blockDurFor(t *testing.T) {
+	ab := newAuthRateLimiter(15*time.Minute, 5)
+
+	assert.Equal(t, 15*time.Minute, ab.blockDurFor(0))
+	assert.Equal(t, 15*time.Minute, ab.blockDurFor(1))
+	assert.Equal(t, 30*time.Minute, ab.blockDurFor(2))
+	assert.Equal(t, maxAuthBlockDur, ab.blockDurFor(100))
+}
@@ -0,0 +1,283 @@
+package home
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/exp/slices"
+)
+
+// userRole is the role of a user of the web interface, which defines the HTTP
+// APIs available to them.
+type userRole string
+
+// userRole values.
+const (
+	// roleAdmin is allowed to use all HTTP APIs.  The users without a role,
+	// for example from the older configuration files, are administrators.
+	roleAdmin userRole = "admin"
+
+	// roleOperator is allowed to use all HTTP APIs except the ones changing the
+	// upstream, encryption, DHCP, access, and notification settings, managing
+	// the users, and updating AdGuard Home.
+	roleOperator userRole = "operator"
+
+	// roleViewer is only allowed to read the data.
+	roleViewer userRole = "viewer"
+
+	// roleParent is allowed to read the data as well as to change the settings
+	// of and to pause the protection for the persistent clients listed in
+	// [webUser.Clients].
+	roleParent userRole = "parent"
+)
+
+// validate returns an error if r is not a valid role.
+func (r userRole) validate() (err error) {
+	switch r {
+	case roleAdmin, roleOperator, roleViewer, roleParent:
+		return nil
+	default:
+		return fmt.Errorf("bad role %q", r)
+	}
+}
+
+// usersAPIPrefix is the prefix of the HTTP APIs managing the users.  They are
+// only available to the administrators.
+const usersAPIPrefix = "/control/users"
+
+// adminOnlyReadAPIPrefixes are the prefixes of the HTTP APIs, which are only
+// available to the administrators regardless of the method.
+var adminOnlyReadAPIPrefixes = []string{
+	auditLogAPI,
+	backupAPI,
+	configAPI,
+	configHistoryAPIPrefix,
+	debugAPIPrefix,
+	restoreAPI,
+	supportBundleAPI,
+	syncAPIPrefix,
+	usersAPIPrefix,
+}
+
+// adminOnlyAPIPrefixes are the prefixes of the HTTP APIs changing the data,
+// which are only available to the administrators.
+var adminOnlyAPIPrefixes = []string{
+	"/control/access/",
+	"/control/dhcp/",
+	"/control/dns_config",
+	"/control/listeners/",
+	"/control/notifications/",
+	"/control/test_upstream_dns",
+	"/control/tls/",
+	"/control/update",
+	reloadAPI,
+	usersAPIPrefix,
+}
+
+// readOnlyAPIs are the HTTP APIs, which are requested using the methods
+// changing the data, but are available to all users, since they either don't
+// change anything, only change the appearance of the web interface, or only
+// change the settings of the current user.
+var readOnlyAPIs = stringutil.NewSet(
+	"/control/filtering/check_hosts",
+	"/control/filtering/hosts/validate",
+	"/control/i18n/change_language",
+	"/control/profile/update",
+	"/control/totp/confirm",
+	"/control/totp/disable",
+	"/control/totp/enroll",
+)
+
+// parentAPIs are the HTTP APIs changing the data of a single persistent
+// client, which are available to the parents for their clients.
+var parentAPIs = stringutil.NewSet(
+	"/control/clients/internet_pause",
+	"/control/clients/internet_resume",
+	"/control/clients/pause",
+	"/control/clients/resume",
+	"/control/clients/update",
+)
+
+// parentReqJSON is the JSON structure containing the fields of the requests to
+// [parentAPIs] identifying the persistent client.
+type parentReqJSON struct {
+	Data *struct {
+		Name string `json:"name"`
+	} `json:"data"`
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// maxParentReqSize is the maximum size of the body of a request to
+// [parentAPIs] made by a parent.
+const maxParentReqSize = 64 * 1024
+
+// role returns the role of u.
+func (u *webUser) role() (r userRole) {
+	if u.Role == "" {
+		return roleAdmin
+	}
+
+	return u.Role
+}
+
+// canAccess returns true if u is allowed to make a request with method to
+// path.  body is the body of the request, which is only inspected for the
+// requests of parents to [parentAPIs].
+func (u *webUser) canAccess(method, path string, body []byte) (ok bool) {
+	r := u.role()
+	if r == roleAdmin {
+		return true
+	} else if hasAnyPrefix(path, adminOnlyReadAPIPrefixes) {
+		return false
+	} else if !modifiesData(method) || readOnlyAPIs.Has(path) {
+		return true
+	}
+
+	switch r {
+	case roleOperator:
+		return !hasAnyPrefix(path, adminOnlyAPIPrefixes)
+	case roleParent:
+		return parentAPIs.Has(path) && u.ownsClients(body)
+	default:
+		return false
+	}
+}
+
+// hasAnyPrefix returns true if path has any of the prefixes.
+func hasAnyPrefix(path string, prefixes []string) (ok bool) {
+	return slices.ContainsFunc(prefixes, func(p string) (found bool) {
+		return strings.HasPrefix(path, p)
+	})
+}
+
+// ownsClients returns true if all persistent clients referred in body of a
+// request to [parentAPIs] are listed in the clients of u.
+func (u *webUser) ownsClients(body []byte) (ok bool) {
+	req := &parentReqJSON{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		return false
+	}
+
+	ids := []string{req.Name, req.ID}
+	if req.Data != nil {
+		ids = append(ids, req.Data.Name)
+	}
+
+	owns := false
+	for _, id := range ids {
+		if id == "" {
+			continue
+		} else if !slices.Contains(u.Clients, id) {
+			return false
+		}
+
+		owns = true
+	}
+
+	return owns
+}
+
+// roleHandler returns a handler rejecting the requests, which the current user
+// isn't allowed to make according to their role.
+func roleHandler(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Context.auth == nil || !Context.auth.AuthRequired() {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		u := Context.auth.getCurrentUser(r)
+		if u.Name == "" {
+			// The authentication is either handled by the GL-Inet submodule,
+			// which only has the administrator, or the user has been removed.
+			if !GLMode {
+				aghhttp.Error(r, w, http.StatusForbidden, "unknown user")
+
+				return
+			}
+
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		var body []byte
+		if u.role() == roleParent && parentAPIs.Has(r.URL.Path) {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, maxParentReqSize))
+			if err != nil {
+				aghhttp.Error(r, w, http.StatusBadRequest, "reading body: %s", err)
+
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if !u.canAccess(r.Method, r.URL.Path, body) {
+			aghhttp.Error(
+				r,
+				w,
+				http.StatusForbidden,
+				"user %q with role %q is not allowed to %s %s",
+				u.Name,
+				u.role(),
+				r.Method,
+				r.URL.Path,
+			)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validateUsers returns an error if users aren't valid.  It also sets the role
+// of the users without one to [roleAdmin].
+func validateUsers(users []webUser) (err error) {
+	names := stringutil.NewSet()
+	for i := range users {
+		u := &users[i]
+		err = u.validate()
+		if err != nil {
+			return fmt.Errorf("user at index %d: %w", i, err)
+		} else if names.Has(u.Name) {
+			return fmt.Errorf("user at index %d: duplicate name %q", i, u.Name)
+		}
+
+		names.Add(u.Name)
+		u.Role = u.role()
+	}
+
+	return nil
+}
+
+// validate returns an error if u isn't valid.
+func (u *webUser) validate() (err error) {
+	if u.Name == "" {
+		return errors.Error("empty name")
+	}
+
+	err = u.role().validate()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if u.role() == roleParent && len(u.Clients) == 0 {
+		return errors.Error("clients: no value for parent")
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package home
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebUser_canAccess(t *testing.T) {
+	var (
+		admin    = &webUser{Name: "admin"}
+		operator = &webUser{Name: "operator", Role: roleOperator}
+		viewer   = &webUser{Name: "viewer", Role: roleViewer}
+		parent   = &webUser{Name: "parent", Role: roleParent, Clients: []string{"kid"}}
+	)
+
+	const (
+		get  = http.MethodGet
+		post = http.MethodPost
+	)
+
+	testCases := []struct {
+		user   *webUser
+		name   string
+		method string
+		path   string
+		body   string
+		want   assert.BoolAssertionFunc
+	}{{
+		user:   admin,
+		name:   "admin_upstreams",
+		method: post,
+		path:   "/control/dns_config",
+		want:   assert.True,
+	}, {
+		user:   operator,
+		name:   "operator_rules",
+		method: post,
+		path:   "/control/filtering/set_rules",
+		want:   assert.True,
+	}, {
+		user:   operator,
+		name:   "operator_upstreams",
+		method: post,
+		path:   "/control/dns_config",
+		want:   assert.False,
+	}, {
+		user:   operator,
+		name:   "operator_users",
+		method: get,
+		path:   "/control/users",
+		want:   assert.False,
+	}, {
+		user:   operator,
+		name:   "operator_audit_log",
+		method: get,
+		path:   "/control/audit_log",
+		want:   assert.False,
+	}, {
+		user:   operator,
+		name:   "operator_config_history",
+		method: get,
+		path:   "/control/config_history",
+		want:   assert.False,
+	}, {
+		user:   viewer,
+		name:   "viewer_querylog",
+		method: get,
+		path:   "/control/querylog",
+		want:   assert.True,
+	}, {
+		user:   viewer,
+		name:   "viewer_check_hosts",
+		method: post,
+		path:   "/control/filtering/check_hosts",
+		want:   assert.True,
+	}, {
+		user:   viewer,
+		name:   "viewer_protection",
+		method: post,
+		path:   "/control/protection",
+		want:   assert.False,
+	}, {
+		user:   parent,
+		name:   "parent_own_client",
+		method: post,
+		path:   "/control/clients/update",
+		body:   `{"name":"kid","data":{"name":"kid"}}`,
+		want:   assert.True,
+	}, {
+		user:   parent,
+		name:   "parent_rename_client",
+		method: post,
+		path:   "/control/clients/update",
+		body:   `{"name":"kid","data":{"name":"parent"}}`,
+		want:   assert.False,
+	}, {
+		user:   parent,
+		name:   "parent_pause_own",
+		method: post,
+		path:   "/control/clients/pause",
+		body:   `{"id":"kid","duration":60000}`,
+		want:   assert.True,
+	}, {
+		user:   parent,
+		name:   "parent_pause_other",
+		method: post,
+		path:   "/control/clients/pause",
+		body:   `{"id":"192.0.2.1","duration":60000}`,
+		want:   assert.False,
+	}, {
+		user:   parent,
+		name:   "parent_no_client",
+		method: post,
+		path:   "/control/clients/resume",
+		body:   `{}`,
+		want:   assert.False,
+	}, {
+		user:   parent,
+		name:   "parent_protection",
+		method: post,
+		path:   "/control/protection",
+		want:   assert.False,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.want(t, tc.user.canAccess(tc.method, tc.path, []byte(tc.body)))
+		})
+	}
+}
+
+func TestValidateUsers(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		users      []webUser
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		users: []webUser{{
+			Name: "admin",
+		}, {
+			Name:    "parent",
+			Role:    roleParent,
+			Clients: []string{"kid"},
+		}},
+	}, {
+		name:       "empty_name",
+		wantErrMsg: "user at index 0: empty name",
+		users:      []webUser{{}},
+	}, {
+		name:       "bad_role",
+		wantErrMsg: `user at index 0: bad role "root"`,
+		users:      []webUser{{Name: "admin", Role: "root"}},
+	}, {
+		name:       "parent_no_clients",
+		wantErrMsg: "user at index 0: clients: no value for parent",
+		users:      []webUser{{Name: "parent", Role: roleParent}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `user at index 1: duplicate name "admin"`,
+		users:      []webUser{{Name: "admin"}, {Name: "admin"}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, validateUsers(tc.users))
+		})
+	}
+}
+
+func TestAuth_modifyUsers(t *testing.T) {
+	// The hash of "password".
+	const hash = "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"
+
+	a := InitAuth(filepath.Join(t.TempDir(), "sessions.db"), []webUser{{
+		Name:         "admin",
+		PasswordHash: hash,
+		Role:         roleAdmin,
+	}}, 60, nil)
+	require.NotNil(t, a)
+	t.Cleanup(a.Close)
+
+	err := a.addUser(&webUser{Name: "viewer", Role: roleViewer}, "")
+	testutil.AssertErrorMsg(t, "empty password", err)
+
+	err = a.addUser(&webUser{Name: "viewer", Role: roleViewer}, "viewer")
+	require.NoError(t, err)
+
+	a.addSession([]byte{1}, &session{userName: "viewer", expire: 1 << 31})
+	a.addSession([]byte{2}, &session{userName: "admin", expire: 1 << 31})
+
+	// The password is kept, but the user is renamed.
+	err = a.updateUser("viewer", &webUser{Name: "operator", Role: roleOperator}, "")
+	require.NoError(t, err)
+
+	users := a.GetUsers()
+	require.Len(t, users, 2)
+
+	assert.Equal(t, roleOperator, users[1].Role)
+	assert.NotEmpty(t, users[1].PasswordHash)
+
+	_, ok := a.findUser("operator", "viewer")
+	assert.True(t, ok)
+
+	// The session of the renamed user is removed.
+	assert.Equal(t, checkSessionNotFound, a.checkSession("01"))
+	assert.Equal(t, checkSessionOK, a.checkSession("02"))
+
+	err = a.updateUser("admin", &webUser{Name: "admin", Role: roleViewer}, "")
+	testutil.AssertErrorMsg(t, "at least one admin is required", err)
+
+	err = a.removeUser("admin")
+	testutil.AssertErrorMsg(t, "at least one admin is required", err)
+
+	err = a.removeUser("viewer")
+	testutil.AssertErrorMsg(t, `user "viewer": not found`, err)
+
+	require.NoError(t, a.removeUser("operator"))
+	assert.Len(t, a.GetUsers(), 1)
+}
@@ -0,0 +1,312 @@
+package home
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// TOTP parameters, see RFC 6238.  These are the defaults supported by all
+// authenticator applications.
+const (
+	// totpSecretSize is the size of a TOTP secret in bytes.
+	totpSecretSize = 20
+
+	// totpDigits is the number of digits in a TOTP code.
+	totpDigits = 6
+
+	// totpPeriod is the duration of a single TOTP time step.
+	totpPeriod = 30 * time.Second
+
+	// totpSkew is the number of time steps before and after the current one,
+	// the codes of which are also accepted to compensate for clock drift.
+	totpSkew = 1
+)
+
+// totpIssuer is the issuer of the TOTP secrets shown by the authenticator
+// applications.
+const totpIssuer = "AdGuard Home"
+
+// Recovery code parameters.
+const (
+	// recoveryCodesNum is the number of the recovery codes generated when
+	// TOTP is enabled.
+	recoveryCodesNum = 10
+
+	// recoveryCodeSize is the size of the random part of a recovery code in
+	// bytes.
+	recoveryCodeSize = 5
+)
+
+// errTOTPRequired is returned when a user with TOTP enabled tries to log in
+// without a code.
+const errTOTPRequired errors.Error = "two-factor authentication code required"
+
+// errTOTPInvalid is returned when the TOTP or recovery code is invalid.
+const errTOTPInvalid errors.Error = "invalid two-factor authentication code"
+
+// totpEncoding is the encoding of TOTP secrets, as expected by authenticator
+// applications.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode returns the TOTP code for secret and the time step.
+func totpCode(secret []byte, step uint64) (code string) {
+	mac := hmac.New(sha1.New, secret)
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], step)
+	_, _ = mac.Write(msg[:])
+
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fff_ffff
+
+	return fmt.Sprintf("%0*d", totpDigits, bin%1_000_000)
+}
+
+// totpStep returns the TOTP time step for t.
+func totpStep(t time.Time) (step uint64) {
+	return uint64(t.Unix() / int64(totpPeriod/time.Second))
+}
+
+// matchTOTP returns the time step of the code matching code for secret at the
+// time now and true, if there is one.  Only the steps after lastStep are
+// accepted to prevent reusing the codes.
+func matchTOTP(secret []byte, code string, now time.Time, lastStep uint64) (step uint64, ok bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	cur := totpStep(now)
+	for s := cur - totpSkew; s <= cur+totpSkew; s++ {
+		if s <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+// newTOTPSecret returns a new random TOTP secret encoded with totpEncoding.
+func newTOTPSecret() (secret string, err error) {
+	data := make([]byte, totpSecretSize)
+	_, err = rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(data), nil
+}
+
+// totpURI returns the otpauth URI of the TOTP secret of the user with name,
+// which is used by the authenticator applications, usually as a QR code.
+func totpURI(name, secret string) (uri string) {
+	u := &url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + totpIssuer + ":" + name,
+		RawQuery: url.Values{
+			"secret": []string{secret},
+			"issuer": []string{totpIssuer},
+		}.Encode(),
+	}
+
+	return u.String()
+}
+
+// newRecoveryCodes returns the new random recovery codes and their hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodesNum; i++ {
+		data := make([]byte, recoveryCodeSize)
+		_, err = rand.Read(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(data))
+		code = code[:4] + "-" + code[4:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hash of the recovery code.  The recovery codes
+// are random, so a fast hash is enough.
+func hashRecoveryCode(code string) (hash string) {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// totpEnabled returns true if u has TOTP enabled.
+func (u *webUser) totpEnabled() (ok bool) {
+	return u.TOTPSecret != ""
+}
+
+// checkSecondFactor checks code, which is either a TOTP code or a recovery
+// code, for the user with name.  The used recovery code is removed, in which
+// case modified is true.  a.lock is expected to be locked.
+func (a *Auth) checkSecondFactor(name, code string) (modified bool, err error) {
+	i := slices.IndexFunc(a.users, func(u webUser) (ok bool) { return u.Name == name })
+	if i < 0 {
+		return false, errTOTPInvalid
+	}
+
+	u := &a.users[i]
+	if !u.totpEnabled() {
+		return false, nil
+	} else if code == "" {
+		return false, errTOTPRequired
+	}
+
+	secret, err := totpEncoding.DecodeString(u.TOTPSecret)
+	if err != nil {
+		return false, fmt.Errorf("decoding totp secret: %w", err)
+	}
+
+	step, ok := matchTOTP(secret, code, time.Now(), a.totpLastSteps[name])
+	if ok {
+		a.totpLastSteps[name] = step
+
+		return false, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	j := slices.Index(u.RecoveryCodes, hash)
+	if j < 0 {
+		return false, errTOTPInvalid
+	}
+
+	// Don't modify the users in place, since they may be in use by the
+	// configuration writer.
+	users := slices.Clone(a.users)
+	users[i].RecoveryCodes = slices.Delete(slices.Clone(u.RecoveryCodes), j, j+1)
+	a.users = users
+
+	log.Info("auth: user %q used a recovery code, %d left", name, len(users[i].RecoveryCodes))
+
+	return true, nil
+}
+
+// enrollTOTP generates a new TOTP secret for the user with name, which must be
+// confirmed using [Auth.confirmTOTP].
+func (a *Auth) enrollTOTP(name string) (secret string, err error) {
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", fmt.Errorf("generating secret: %w", err)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !slices.ContainsFunc(a.users, func(u webUser) (ok bool) { return u.Name == name }) {
+		return "", fmt.Errorf("user %q: not found", name)
+	}
+
+	a.totpPending[name] = secret
+
+	return secret, nil
+}
+
+// confirmTOTP enables TOTP for the user with name using the secret generated
+// by [Auth.enrollTOTP] if code matches it.  It returns the recovery codes.
+func (a *Auth) confirmTOTP(name, code string) (codes []string, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	secret, ok := a.totpPending[name]
+	if !ok {
+		return nil, errors.Error("no pending enrollment")
+	}
+
+	data, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("decoding totp secret: %w", err)
+	}
+
+	step, ok := matchTOTP(data, code, time.Now(), 0)
+	if !ok {
+		return nil, errTOTPInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("generating recovery codes: %w", err)
+	}
+
+	users := slices.Clone(a.users)
+	i := slices.IndexFunc(users, func(u webUser) (ok bool) { return u.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("user %q: not found", name)
+	}
+
+	users[i].TOTPSecret = secret
+	users[i].RecoveryCodes = hashes
+	a.users = users
+
+	delete(a.totpPending, name)
+	a.totpLastSteps[name] = step
+
+	log.Info("auth: enabled two-factor authentication for user %q", name)
+
+	return codes, nil
+}
+
+// disableTOTP disables TOTP for the user with name if code is a valid TOTP or
+// recovery code.  All sessions of the user are removed.
+func (a *Auth) disableTOTP(name, code string) (err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	_, err = a.checkSecondFactor(name, code)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	users := slices.Clone(a.users)
+	i := slices.IndexFunc(users, func(u webUser) (ok bool) { return u.Name == name })
+	if i < 0 || !users[i].totpEnabled() {
+		return errors.Error("two-factor authentication is not enabled")
+	}
+
+	users[i].TOTPSecret = ""
+	users[i].RecoveryCodes = nil
+	a.users = users
+
+	delete(a.totpLastSteps, name)
+
+	for sess, s := range a.sessions {
+		if s.userName != name {
+			continue
+		}
+
+		delete(a.sessions, sess)
+		key, _ := hex.DecodeString(sess)
+		a.removeSession(key)
+	}
+
+	log.Info("auth: disabled two-factor authentication for user %q", name)
+
+	return nil
+}
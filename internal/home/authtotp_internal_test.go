@@ -0,0 +1,129 @@
+package home
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTOTPSecret is the secret from the test vectors of RFC 6238.
+var testTOTPSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	testCases := []struct {
+		want string
+		unix int64
+	}{{
+		want: "287082",
+		unix: 59,
+	}, {
+		want: "081804",
+		unix: 1111111109,
+	}, {
+		want: "005924",
+		unix: 1234567890,
+	}, {
+		want: "279037",
+		unix: 2000000000,
+	}}
+
+	for _, tc := range testCases {
+		step := totpStep(time.Unix(tc.unix, 0))
+		assert.Equal(t, tc.want, totpCode(testTOTPSecret, step), tc.unix)
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	cur := totpStep(now)
+
+	step, ok := matchTOTP(testTOTPSecret, "005924", now, 0)
+	require.True(t, ok)
+
+	assert.Equal(t, cur, step)
+
+	// The previous code is accepted due to the clock drift.
+	_, ok = matchTOTP(testTOTPSecret, totpCode(testTOTPSecret, cur-1), now, 0)
+	assert.True(t, ok)
+
+	_, ok = matchTOTP(testTOTPSecret, totpCode(testTOTPSecret, cur-2), now, 0)
+	assert.False(t, ok)
+
+	// The used code is rejected.
+	_, ok = matchTOTP(testTOTPSecret, "005924", now, cur)
+	assert.False(t, ok)
+
+	_, ok = matchTOTP(testTOTPSecret, "5924", now, 0)
+	assert.False(t, ok)
+}
+
+func TestAuth_secondFactor(t *testing.T) {
+	const recoveryCode = "abcd-efgh"
+
+	a := InitAuth(filepath.Join(t.TempDir(), "sessions.db"), []webUser{{
+		Name:          "admin",
+		Role:          roleAdmin,
+		TOTPSecret:    totpEncoding.EncodeToString(testTOTPSecret),
+		RecoveryCodes: []string{hashRecoveryCode(recoveryCode)},
+	}, {
+		Name: "viewer",
+		Role: roleViewer,
+	}}, 60, nil)
+	require.NotNil(t, a)
+	t.Cleanup(a.Close)
+
+	check := func(name, code string) (modified bool, err error) {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+
+		return a.checkSecondFactor(name, code)
+	}
+
+	_, err := check("viewer", "")
+	require.NoError(t, err)
+
+	_, err = check("admin", "")
+	assert.ErrorIs(t, err, errTOTPRequired)
+
+	_, err = check("admin", "000000")
+	assert.ErrorIs(t, err, errTOTPInvalid)
+
+	code := totpCode(testTOTPSecret, totpStep(time.Now()))
+	modified, err := check("admin", code)
+	require.NoError(t, err)
+
+	assert.False(t, modified)
+
+	// The code can't be reused.
+	_, err = check("admin", code)
+	assert.ErrorIs(t, err, errTOTPInvalid)
+
+	modified, err = check("admin", " ABCD-EFGH ")
+	require.NoError(t, err)
+
+	assert.True(t, modified)
+	assert.Empty(t, a.GetUsers()[0].RecoveryCodes)
+
+	// The recovery code can't be reused either.
+	_, err = check("admin", recoveryCode)
+	assert.ErrorIs(t, err, errTOTPInvalid)
+
+	a.addSession([]byte{1}, &session{userName: "admin", expire: 1 << 31})
+	a.addSession([]byte{2}, &session{userName: "viewer", expire: 1 << 31})
+
+	err = a.disableTOTP("viewer", "")
+	testutil.AssertErrorMsg(t, "two-factor authentication is not enabled", err)
+
+	// Reset the last used time step instead of waiting for the next one.
+	a.totpLastSteps["admin"] = 0
+	err = a.disableTOTP("admin", totpCode(testTOTPSecret, totpStep(time.Now())))
+	require.NoError(t, err)
+
+	assert.False(t, a.GetUsers()[0].totpEnabled())
+	assert.Equal(t, checkSessionNotFound, a.checkSession("01"))
+	assert.Equal(t, checkSessionOK, a.checkSession("02"))
+}
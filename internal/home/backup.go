@@ -0,0 +1,446 @@
+package home
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/confmigrate"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// Backup HTTP APIs.  They are only available to the administrators, since the
+// bundles contain the whole configuration.
+const (
+	backupAPI  = "/control/backup"
+	restoreAPI = "/control/restore"
+)
+
+// hdrValApplicationGzip is the value of the Content-Type header for the backup
+// bundles.
+const hdrValApplicationGzip = "application/gzip"
+
+// maxRestoreSize is the maximum size of the backup bundle accepted by the
+// restore HTTP API.
+const maxRestoreSize = 1024 * 1024 * 1024
+
+// Backup bundle layout.  The configuration file is at the root of the bundle,
+// and the other files are within backupDataDir.
+const (
+	backupConfigName  = "AdGuardHome.yaml"
+	backupDataDir     = "data"
+	backupFiltersDir  = "filters"
+	backupLeasesFile  = "leases.json"
+	backupRestoreTemp = "restore-"
+)
+
+// backupStatsFiles are the files of the statistics within the data directory.
+var backupStatsFiles = []string{"stats.db"}
+
+// backupQueryLogFiles are the files of the query log within the data
+// directory.
+var backupQueryLogFiles = []string{"querylog.json", "querylog.json.1"}
+
+// handleBackup is the handler for the GET /control/backup HTTP API.  The
+// bundle is a gzipped tarball with the configuration file, the filter lists,
+// the DHCP leases, and, if the stats and querylog query parameters are true,
+// the statistics and the query log.
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	files := []string{backupLeasesFile}
+	for param, paramFiles := range map[string][]string{
+		"stats":    backupStatsFiles,
+		"querylog": backupQueryLogFiles,
+	} {
+		v := r.URL.Query().Get(param)
+		if v == "" {
+			continue
+		}
+
+		ok, err := strconv.ParseBool(v)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "parsing %s: %s", param, err)
+
+			return
+		} else if ok {
+			files = append(files, paramFiles...)
+		}
+	}
+
+	config.RLock()
+	confData, err := os.ReadFile(config.getConfigFilename())
+	config.RUnlock()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "reading config file: %s", err)
+
+		return
+	}
+
+	now := time.Now()
+	contDisp := fmt.Sprintf(
+		`attachment; filename="adguardhome-backup-%s.tar.gz"`,
+		now.Format("20060102-150405"),
+	)
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, hdrValApplicationGzip)
+	h.Set(httphdr.ContentDisposition, contDisp)
+
+	err = writeBackup(w, Context.getDataDir(), confData, files, now)
+	if err != nil {
+		// The headers have already been sent, so just log the error.
+		log.Error("backup: writing bundle: %s", err)
+	}
+}
+
+// writeBackup writes the bundle with confData and the files within the data
+// directory dataDir to w.  The missing files are skipped.
+func writeBackup(
+	w io.Writer,
+	dataDir string,
+	confData []byte,
+	files []string,
+	now time.Time,
+) (err error) {
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    backupConfigName,
+		Mode:    0o600,
+		Size:    int64(len(confData)),
+		ModTime: now,
+	})
+	if err == nil {
+		_, err = tw.Write(confData)
+	}
+
+	if err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+
+	filterFiles, err := os.ReadDir(filepath.Join(dataDir, backupFiltersDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading filters dir: %w", err)
+	}
+
+	for _, f := range filterFiles {
+		if f.Type().IsRegular() {
+			files = append(files, path.Join(backupFiltersDir, f.Name()))
+		}
+	}
+
+	for _, name := range files {
+		err = addBackupFile(tw, dataDir, name)
+		if err != nil {
+			return fmt.Errorf("adding %q: %w", name, err)
+		}
+	}
+
+	err = tw.Close()
+
+	return errors.WithDeferred(err, gzw.Close())
+}
+
+// addBackupFile adds the file with name within dataDir to tw, if it exists.
+func addBackupFile(tw *tar.Writer, dataDir, name string) (err error) {
+	f, err := os.Open(filepath.Join(dataDir, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    path.Join(backupDataDir, name),
+		Mode:    0o600,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	// Copy exactly the size from the header, since the file may grow.
+	_, err = io.CopyN(tw, f, fi.Size())
+
+	return err
+}
+
+// backupDataPath returns the path of the bundle entry with name relative to
+// the data directory and true, if the entry is a known data file.
+func backupDataPath(name string) (rel string, ok bool) {
+	rel, ok = strings.CutPrefix(name, backupDataDir+"/")
+	if !ok {
+		return "", false
+	}
+
+	if rel == backupLeasesFile ||
+		slices.Contains(backupStatsFiles, rel) ||
+		slices.Contains(backupQueryLogFiles, rel) {
+		return rel, true
+	}
+
+	dir, file := path.Split(rel)
+
+	return rel, dir == backupFiltersDir+"/" && file != ""
+}
+
+// readBackup extracts the data files of the bundle from r into dir and returns
+// the configuration file data.
+func readBackup(r io.Reader, dir string) (confData []byte, err error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing: %w", err)
+	}
+
+	tr := tar.NewReader(gzr)
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading bundle: %w", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		} else if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("entry %q: not a regular file", name)
+		}
+
+		if name == backupConfigName {
+			if hdr.Size > maxConfigApplyReqSize {
+				return nil, fmt.Errorf(
+					"config entry too large: %d bytes, max %d",
+					hdr.Size,
+					maxConfigApplyReqSize,
+				)
+			}
+
+			confData, err = io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("reading config: %w", err)
+			}
+
+			continue
+		}
+
+		err = extractBackupFile(tr, dir, name)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", name, err)
+		}
+	}
+
+	if confData == nil {
+		return nil, fmt.Errorf("no %s in bundle", backupConfigName)
+	}
+
+	return confData, nil
+}
+
+// extractBackupFile writes the data file with name from r into dir.
+func extractBackupFile(r io.Reader, dir, name string) (err error) {
+	rel, ok := backupDataPath(name)
+	if !ok {
+		return errors.Error("unexpected entry")
+	}
+
+	dst := filepath.Join(dir, filepath.FromSlash(rel))
+	err = os.MkdirAll(filepath.Dir(dst), 0o755)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+
+	return errors.WithDeferred(err, f.Close())
+}
+
+// handleRestore is the handler for the POST /control/restore HTTP API.  The
+// body is a bundle returned by the backup HTTP API.  The configuration is
+// migrated to the current schema version and validated.  Then, the
+// configuration file is replaced, and AdGuard Home is stopped, the data files
+// from the bundle are moved into the data directory, and AdGuard Home is
+// restarted.
+func (web *webAPI) handleRestore(w http.ResponseWriter, r *http.Request) {
+	execPath, err := os.Executable()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "getting path: %s", err)
+
+		return
+	}
+
+	dataDir := Context.getDataDir()
+	tmpDir, err := os.MkdirTemp(dataDir, backupRestoreTemp)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "creating temporary dir: %s", err)
+
+		return
+	}
+
+	confData, err := prepareRestore(io.LimitReader(r.Body, maxRestoreSize), tmpDir)
+	if err != nil {
+		removeRestoreTemp(tmpDir)
+		aghhttp.Error(r, w, http.StatusBadRequest, "restoring: %s", err)
+
+		return
+	}
+
+	err = config.replaceFile(confData)
+	if err != nil {
+		removeRestoreTemp(tmpDir)
+		aghhttp.Error(r, w, http.StatusInternalServerError, "restoring: %s", err)
+
+		return
+	}
+
+	user := Context.auth.getCurrentUser(r).Name
+	if hist := Context.configHistory; hist != nil {
+		err = hist.record(confData, user, 0)
+		if err != nil {
+			log.Error("config history: %s", err)
+		}
+	}
+
+	log.Info("restore: user %q restored a backup, restarting to apply it", user)
+
+	aghhttp.OK(w)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	// See the comment in [webAPI.handleUpdate].
+	go finishRestore(context.Background(), execPath, web.conf.runningAsService, tmpDir, dataDir)
+}
+
+// removeRestoreTemp removes the temporary directory of a failed restore.  The
+// errors are logged.
+func removeRestoreTemp(tmpDir string) {
+	err := os.RemoveAll(tmpDir)
+	if err != nil {
+		log.Error("restore: removing temporary dir: %s", err)
+	}
+}
+
+// prepareRestore extracts the bundle from r into tmpDir and returns the
+// migrated and validated configuration file data.  The commands run by the
+// configuration must be the same as in the current one, see [checkCommands].
+func prepareRestore(r io.Reader, tmpDir string) (confData []byte, err error) {
+	confData, err = readBackup(r, tmpDir)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	migrator := confmigrate.New(&confmigrate.Config{
+		WorkingDir: Context.workDir,
+	})
+
+	confData, _, err = migrator.Migrate(confData, confmigrate.LastSchemaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("migrating config: %w", err)
+	}
+
+	conf, err := parseConfigData(confData)
+	if err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
+	}
+
+	err = validateCommands(conf)
+	if err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
+	}
+
+	return confData, nil
+}
+
+// finishRestore stops AdGuard Home, moves the restored data files from tmpDir
+// into dataDir, and restarts AdGuard Home.
+func finishRestore(
+	ctx context.Context,
+	execPath string,
+	runningAsService bool,
+	tmpDir string,
+	dataDir string,
+) {
+	log.Info("restore: stopping all tasks")
+
+	cleanup(ctx)
+
+	err := moveRestoredFiles(tmpDir, dataDir)
+	if err != nil {
+		log.Error("restore: moving data files: %s", err)
+	}
+
+	cleanupAlways()
+	restartProcess(execPath, runningAsService)
+}
+
+// moveRestoredFiles replaces the files and directories within dataDir with the
+// ones from tmpDir and removes tmpDir.
+func moveRestoredFiles(tmpDir, dataDir string) (err error) {
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return fmt.Errorf("reading temporary dir: %w", err)
+	}
+
+	var errs []error
+	for _, e := range entries {
+		src := filepath.Join(tmpDir, e.Name())
+		dst := filepath.Join(dataDir, e.Name())
+		if e.IsDir() {
+			// Move the current directory out of the way, so that it's removed
+			// together with tmpDir.
+			err = os.Rename(dst, src+".old")
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+
+				continue
+			}
+		}
+
+		err = os.Rename(src, dst)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(append(errs, os.RemoveAll(tmpDir))...)
+}
+
+// registerBackupHandlers registers the HTTP handlers of the backups.
+func registerBackupHandlers(web *webAPI) {
+	httpRegister(http.MethodGet, backupAPI, handleBackup)
+	httpRegister(http.MethodPost, restoreAPI, web.handleRestore)
+}
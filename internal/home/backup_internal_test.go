@@ -0,0 +1,143 @@
+package home
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	dataDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, backupFiltersDir), 0o755))
+
+	files := map[string]string{
+		backupLeasesFile:                         "leases",
+		"stats.db":                               "stats",
+		filepath.Join(backupFiltersDir, "1.txt"): "||example.org^",
+	}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dataDir, name), []byte(data), 0o644))
+	}
+
+	const confData = "schema_version: 1\n"
+
+	buf := &bytes.Buffer{}
+	err := writeBackup(buf, dataDir, []byte(confData), []string{backupLeasesFile}, time.Now())
+	require.NoError(t, err)
+
+	restoreDir := t.TempDir()
+	gotConf, err := readBackup(buf, restoreDir)
+	require.NoError(t, err)
+
+	assert.Equal(t, confData, string(gotConf))
+
+	for name, data := range files {
+		var got []byte
+		got, err = os.ReadFile(filepath.Join(restoreDir, name))
+		if name == "stats.db" {
+			// The statistics weren't requested.
+			assert.ErrorIs(t, err, os.ErrNotExist)
+
+			continue
+		}
+
+		require.NoError(t, err)
+		assert.Equal(t, data, string(got))
+	}
+
+	t.Run("move", func(t *testing.T) {
+		require.NoError(t, moveRestoredFiles(restoreDir, dataDir))
+
+		assert.NoDirExists(t, restoreDir)
+		assert.FileExists(t, filepath.Join(dataDir, backupFiltersDir, "1.txt"))
+	})
+}
+
+func TestReadBackup_bad(t *testing.T) {
+	newBundle := func(t *testing.T, name string, size int) (b *bytes.Buffer) {
+		t.Helper()
+
+		b = &bytes.Buffer{}
+		gzw := gzip.NewWriter(b)
+		tw := tar.NewWriter(gzw)
+
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o600,
+			Size:     int64(size),
+			Typeflag: tar.TypeReg,
+		}))
+
+		_, err := tw.Write(bytes.Repeat([]byte("a"), size))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gzw.Close())
+
+		return b
+	}
+
+	testCases := []struct {
+		name       string
+		entry      string
+		wantErrMsg string
+		size       int
+	}{{
+		name:       "no_config",
+		entry:      "data/leases.json",
+		wantErrMsg: "no AdGuardHome.yaml in bundle",
+		size:       1,
+	}, {
+		name:       "traversal",
+		entry:      "data/filters/../../../etc/passwd",
+		wantErrMsg: `entry "../etc/passwd": unexpected entry`,
+		size:       1,
+	}, {
+		name:       "unknown",
+		entry:      "data/sessions.db",
+		wantErrMsg: `entry "data/sessions.db": unexpected entry`,
+		size:       1,
+	}, {
+		name:       "config_too_large",
+		entry:      backupConfigName,
+		wantErrMsg: "config entry too large: 16777217 bytes, max 16777216",
+		size:       maxConfigApplyReqSize + 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readBackup(newBundle(t, tc.entry, tc.size), t.TempDir())
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestPrepareRestore_commands(t *testing.T) {
+	conf := newDefaultConfig()
+	conf.BlockHooks = []*blockHookConfig{{
+		Name:    "hook",
+		Command: []string{"/bin/sh"},
+		Rules:   []string{"||example.org^"},
+	}}
+
+	confData, err := marshalConfig(conf)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = writeBackup(buf, t.TempDir(), confData, nil, time.Now())
+	require.NoError(t, err)
+
+	_, err = prepareRestore(buf, t.TempDir())
+	testutil.AssertErrorMsg(
+		t,
+		`validating config: block_hooks["hook"].command: can't be changed using the http api`,
+		err,
+	)
+}
@@ -0,0 +1,140 @@
+package home
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// blockHookConfig is the configuration of a hook run when requests are
+// blocked.
+type blockHookConfig struct {
+	// Name is the name of the hook used in logs.
+	Name string `yaml:"name"`
+
+	// URL is the address of the webhook.  See [blockhook.HookConfig.URL].
+	URL string `yaml:"url"`
+
+	// Command is the executable and its arguments.  See
+	// [blockhook.HookConfig.Command].
+	Command []string `yaml:"command"`
+
+	// Rules are the texts of the rules triggering the hook.
+	Rules []string `yaml:"rules"`
+
+	// Clients are the IP addresses, CIDR subnets, and ClientIDs of the clients
+	// triggering the hook.
+	Clients []string `yaml:"clients"`
+
+	// Services are the names of the blocked services triggering the hook.
+	Services []string `yaml:"services"`
+
+	// Categories are the domain categories of the blocked hosts triggering
+	// the hook.
+	Categories []string `yaml:"categories"`
+
+	// RateLimit is the minimum duration between two runs of the hook.
+	RateLimit timeutil.Duration `yaml:"rate_limit"`
+
+	// Timeout is the timeout for a single run of the hook.  If zero,
+	// [blockhook.DefaultTimeout] is used.
+	Timeout timeutil.Duration `yaml:"timeout"`
+
+	// Enabled defines if the hook is run.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validate returns an error if the hook configuration is invalid.
+func (c *blockHookConfig) validate() (err error) {
+	if c == nil {
+		return errors.Error("no value")
+	} else if !c.Enabled {
+		return nil
+	}
+
+	if (c.URL == "") == (len(c.Command) == 0) {
+		return errors.Error("exactly one of url and command must be set")
+	}
+
+	if c.URL != "" {
+		var u *url.URL
+		u, err = url.Parse(c.URL)
+		if err != nil {
+			return fmt.Errorf("bad url: %w", err)
+		}
+
+		switch u.Scheme {
+		case aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS:
+			// Go on.
+		default:
+			return fmt.Errorf("bad url scheme %q", u.Scheme)
+		}
+	}
+
+	if c.RateLimit.Duration < 0 {
+		return fmt.Errorf("rate_limit: must not be negative, got %s", c.RateLimit)
+	}
+
+	if c.Timeout.Duration < 0 {
+		return fmt.Errorf("timeout: must not be negative, got %s", c.Timeout)
+	}
+
+	return nil
+}
+
+// validateBlockHooks returns an error if any of the hook configurations are
+// invalid.
+func validateBlockHooks(hooks []*blockHookConfig) (err error) {
+	for i, h := range hooks {
+		err = h.validate()
+		if err != nil {
+			return fmt.Errorf("hook at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// newBlockHookNotifier returns a new notifier running the enabled hooks from
+// hooks, which must be valid.  n is nil if there are no enabled hooks.
+func newBlockHookNotifier(hooks []*blockHookConfig) (n *blockhook.Notifier, err error) {
+	conf := &blockhook.Config{
+		HTTPClient: httpClient(),
+	}
+
+	for _, h := range hooks {
+		if !h.Enabled {
+			continue
+		}
+
+		hc := &blockhook.HookConfig{
+			Name:       h.Name,
+			Command:    h.Command,
+			Rules:      h.Rules,
+			Clients:    h.Clients,
+			Services:   h.Services,
+			Categories: h.Categories,
+			RateLimit:  h.RateLimit.Duration,
+			Timeout:    h.Timeout.Duration,
+		}
+
+		if h.URL != "" {
+			hc.URL, err = url.Parse(h.URL)
+			if err != nil {
+				return nil, fmt.Errorf("hook %q: bad url: %w", h.Name, err)
+			}
+		}
+
+		conf.Hooks = append(conf.Hooks, hc)
+	}
+
+	if len(conf.Hooks) == 0 {
+		return nil, nil
+	}
+
+	return blockhook.New(conf)
+}
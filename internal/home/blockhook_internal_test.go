@@ -0,0 +1,75 @@
+package home
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+func TestValidateBlockHooks(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		hooks      []*blockHookConfig
+	}{{
+		name:       "empty",
+		wantErrMsg: "",
+		hooks:      nil,
+	}, {
+		name:       "valid",
+		wantErrMsg: "",
+		hooks: []*blockHookConfig{{
+			URL:     "https://hook.example/blocked",
+			Enabled: true,
+		}, {
+			Command:   []string{"/usr/local/bin/flash"},
+			Clients:   []string{"192.0.2.0/24"},
+			RateLimit: timeutil.Duration{Duration: time.Minute},
+			Enabled:   true,
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "hook at index 0: no value",
+		hooks:      []*blockHookConfig{nil},
+	}, {
+		name:       "no_action",
+		wantErrMsg: "hook at index 0: exactly one of url and command must be set",
+		hooks: []*blockHookConfig{{
+			Enabled: true,
+		}},
+	}, {
+		name:       "bad_scheme",
+		wantErrMsg: `hook at index 1: bad url scheme "ftp"`,
+		hooks: []*blockHookConfig{{
+			URL:     "ftp://hook.example",
+			Enabled: false,
+		}, {
+			URL:     "ftp://hook.example",
+			Enabled: true,
+		}},
+	}, {
+		name:       "bad_rate_limit",
+		wantErrMsg: "hook at index 0: rate_limit: must not be negative, got -1s",
+		hooks: []*blockHookConfig{{
+			Command:   []string{"/usr/local/bin/flash"},
+			RateLimit: timeutil.Duration{Duration: -time.Second},
+			Enabled:   true,
+		}},
+	}, {
+		name:       "bad_timeout",
+		wantErrMsg: "hook at index 0: timeout: must not be negative, got -1s",
+		hooks: []*blockHookConfig{{
+			Command: []string{"/usr/local/bin/flash"},
+			Timeout: timeutil.Duration{Duration: -time.Second},
+			Enabled: true,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, validateBlockHooks(tc.hooks))
+		})
+	}
+}
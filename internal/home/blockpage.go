@@ -0,0 +1,509 @@
+package home
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+)
+
+// blockPageConfig is the configuration of the block page server, which shows
+// the reason of the blocking to the clients, when the block_page blocking mode
+// is used.
+type blockPageConfig struct {
+	// Address is the address of the HTTP server of the block page.  The
+	// blocking IP addresses should point to it.
+	Address netip.AddrPort `yaml:"address"`
+
+	// AddressHTTPS, if valid, is the address of the HTTPS server of the block
+	// page, which uses the certificate of the web interface.  The browsers
+	// warn about it first, since it doesn't cover the blocked hosts.
+	AddressHTTPS netip.AddrPort `yaml:"address_https"`
+
+	// UnblockDuration is the duration, for which a host is unblocked for the
+	// client from the block page.
+	UnblockDuration timeutil.Duration `yaml:"unblock_duration"`
+
+	// Enabled defines if the block page server is enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validate returns an error if the block page configuration is invalid.
+func (c *blockPageConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	switch {
+	case c.Address.Port() == 0:
+		return errors.Error("address: no port")
+	case c.AddressHTTPS.IsValid() && c.AddressHTTPS.Port() == 0:
+		return errors.Error("address_https: no port")
+	case c.UnblockDuration.Duration <= 0:
+		return fmt.Errorf("unblock_duration: must be positive, got %s", c.UnblockDuration)
+	default:
+		return nil
+	}
+}
+
+// blockPage is the server of the block page.  It also keeps the hosts, which
+// are temporarily unblocked for the clients.
+type blockPage struct {
+	// mu protects unblocks.
+	mu *sync.Mutex
+
+	// unblocks are the expiration times of the unblocked hosts by the IP
+	// addresses of the clients.
+	unblocks map[netip.Addr]map[string]time.Time
+
+	// httpSrv is the HTTP server of the block page.
+	httpSrv *http.Server
+
+	// httpsSrv is the HTTPS server of the block page.  It's nil if disabled.
+	httpsSrv *http.Server
+
+	// httpsPort is the port of the HTTPS server of the block page.  It's zero
+	// if the HTTPS server is disabled.
+	httpsPort uint16
+
+	// unblockDur is the duration, for which a host is unblocked.
+	unblockDur time.Duration
+}
+
+// newBlockPage returns a new block page server.  conf must be valid.
+func newBlockPage(conf *blockPageConfig) (bp *blockPage) {
+	bp = &blockPage{
+		mu:         &sync.Mutex{},
+		unblocks:   map[netip.Addr]map[string]time.Time{},
+		unblockDur: conf.UnblockDuration.Duration,
+	}
+
+	bp.httpSrv = bp.newServer(conf.Address)
+	if conf.AddressHTTPS.IsValid() {
+		bp.httpsSrv = bp.newServer(conf.AddressHTTPS)
+		bp.httpsSrv.TLSConfig = &tls.Config{
+			GetCertificate: Context.web.certificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		bp.httpsPort = conf.AddressHTTPS.Port()
+	}
+
+	return bp
+}
+
+// newServer returns a new HTTP server of the block page on addr.
+func (bp *blockPage) newServer(addr netip.AddrPort) (srv *http.Server) {
+	return &http.Server{
+		ErrorLog:          log.StdLog("blockpage", log.DEBUG),
+		Addr:              addr.String(),
+		Handler:           limitRequestBody(bp),
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHdrTimeout,
+		WriteTimeout:      writeTimeout,
+	}
+}
+
+// start starts the servers of the block page in separate goroutines.  bp may
+// be nil.
+func (bp *blockPage) start() {
+	if bp == nil {
+		return
+	}
+
+	go func() {
+		defer log.OnPanic("blockpage: http")
+
+		log.Info("blockpage: listening on %s", bp.httpSrv.Addr)
+		err := bp.httpSrv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Error("blockpage: http: %s", err)
+		}
+	}()
+
+	if bp.httpsSrv == nil {
+		return
+	}
+
+	go func() {
+		defer log.OnPanic("blockpage: https")
+
+		log.Info("blockpage: listening on %s", bp.httpsSrv.Addr)
+		err := bp.httpsSrv.ListenAndServeTLS("", "")
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Error("blockpage: https: %s", err)
+		}
+	}()
+}
+
+// close shuts the servers of the block page down.  bp may be nil.
+func (bp *blockPage) close(ctx context.Context) {
+	if bp == nil {
+		return
+	}
+
+	shutdownSrv(ctx, bp.httpSrv)
+	shutdownSrv(ctx, bp.httpsSrv)
+}
+
+// applyUnblocks sets the hosts, which are unblocked for the client with ip at
+// now, to setts.  bp may be nil.
+func (bp *blockPage) applyUnblocks(setts *filtering.Settings, ip netip.Addr, now time.Time) {
+	if bp == nil {
+		return
+	}
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	hosts := bp.unblocks[ip]
+	for h, exp := range hosts {
+		if now.Before(exp) {
+			setts.UnblockedHosts = append(setts.UnblockedHosts, h)
+		} else {
+			delete(hosts, h)
+		}
+	}
+
+	if len(hosts) == 0 {
+		delete(bp.unblocks, ip)
+	}
+}
+
+// unblock unblocks host for the client with ip starting from now.
+func (bp *blockPage) unblock(ip netip.Addr, host string, now time.Time) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	hosts := bp.unblocks[ip]
+	if hosts == nil {
+		hosts = map[string]time.Time{}
+		bp.unblocks[ip] = hosts
+	}
+
+	hosts[host] = now.Add(bp.unblockDur)
+}
+
+// blockPageRule is a rule, which has blocked the host, for the block page
+// template.
+type blockPageRule struct {
+	// Text is the text of the rule.
+	Text string
+
+	// List is the name of the filter list of the rule.
+	List string
+}
+
+// blockPageData is the data of the block page template.
+type blockPageData struct {
+	// Host is the requested host.
+	Host string
+
+	// Reason is the reason of the filtering.
+	Reason string
+
+	// Service is the name of the blocked service, if any.
+	Service string
+
+	// Error is the error of the unblocking, if any.
+	Error string
+
+	// Duration is the duration of the unblocking.
+	Duration string
+
+	// Rules are the rules, which have blocked the host.
+	Rules []*blockPageRule
+
+	// Blocked is true if the host is currently blocked for the client.
+	Blocked bool
+
+	// HTTPSURL is the URL of the block page served over HTTPS, if the
+	// credentials can't be sent over the current connection and there is
+	// such a server.
+	HTTPSURL string
+
+	// AuthRequired is true if the credentials are required for the
+	// unblocking.
+	AuthRequired bool
+
+	// Insecure is true if the credentials are required but the request hasn't
+	// been sent over HTTPS, so the unblocking isn't allowed.
+	Insecure bool
+
+	// Unblocked is true if the host has just been unblocked.
+	Unblocked bool
+
+	// CanRequest is true if the access to the host can be requested from the
+	// administrators.
+	CanRequest bool
+
+	// Requested is true if the access to the host has just been requested.
+	Requested bool
+}
+
+// type check
+var _ http.Handler = (*blockPage)(nil)
+
+// ServeHTTP implements the [http.Handler] interface for *blockPage.  The GET
+// requests are responded with the block page for the requested host and the
+// POST requests unblock the host for the client.
+func (bp *blockPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		aghhttp.Error(r, w, http.StatusMethodNotAllowed, "bad method %q", r.Method)
+
+		return
+	}
+
+	host, err := netutil.SplitHost(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	host = aghnet.NormalizeDomain(host)
+
+	clientIP, err := remoteAddr(r)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "parsing remote address: %s", err)
+
+		return
+	}
+
+	data := &blockPageData{
+		Host:         host,
+		Duration:     bp.unblockDur.String(),
+		AuthRequired: Context.auth != nil && Context.auth.AuthRequired(),
+		CanRequest:   Context.unblockRequests != nil,
+	}
+
+	// Don't collect the credentials over plain HTTP.
+	data.Insecure = data.AuthRequired && r.TLS == nil
+	if data.Insecure && bp.httpsPort != 0 && host != "" {
+		data.HTTPSURL = (&url.URL{
+			Scheme: "https",
+			Host:   netutil.JoinHostPort(host, bp.httpsPort),
+			Path:   "/",
+		}).String()
+	}
+
+	if r.Method == http.MethodPost {
+		bp.handlePost(r, data, clientIP)
+	}
+
+	bp.fillBlockInfo(data, clientIP)
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, "text/html; charset=utf-8")
+	h.Set(httphdr.CacheControl, "no-store")
+
+	status := http.StatusOK
+	if data.Blocked {
+		status = http.StatusForbidden
+	}
+
+	w.WriteHeader(status)
+
+	err = blockPageTmpl.Execute(w, data)
+	if err != nil {
+		log.Debug("blockpage: writing response: %s", err)
+	}
+}
+
+// remoteAddr returns the IP address of the client, which has sent r.
+func remoteAddr(r *http.Request) (ip netip.Addr, err error) {
+	host, err := netutil.SplitHost(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	ip, err = netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return ip.Unmap(), nil
+}
+
+// handlePost handles the POST request r of the client with ip, which either
+// unblocks the host of data or requests the access to it, and sets the result
+// to data.
+func (bp *blockPage) handlePost(r *http.Request, data *blockPageData, ip netip.Addr) {
+	var err error
+	if r.PostFormValue("action") == "request" {
+		if !data.CanRequest {
+			err = errors.Error("requests are not accepted")
+		} else {
+			_, err = Context.unblockRequests.add(ip, data.Host, r.PostFormValue("comment"), time.Now())
+		}
+
+		if err != nil {
+			data.Error = fmt.Sprintf("Could not request access to %s: %s", data.Host, err)
+		} else {
+			data.Requested = true
+		}
+
+		return
+	}
+
+	err = bp.handleUnblock(r, ip, data.Host, data.Insecure)
+	if err != nil {
+		data.Error = fmt.Sprintf("Could not unblock %s: %s", data.Host, err)
+	} else {
+		data.Unblocked = true
+	}
+}
+
+// errUnblockInsecure is returned when the credentials for the unblocking are
+// sent over plain HTTP.
+const errUnblockInsecure errors.Error = "unblocking requires https"
+
+// handleUnblock checks the credentials from the form of r and unblocks host
+// for the client with ip.  insecure is true if the credentials are required but
+// r hasn't been sent over HTTPS.
+func (bp *blockPage) handleUnblock(
+	r *http.Request,
+	ip netip.Addr,
+	host string,
+	insecure bool,
+) (err error) {
+	if host == "" {
+		return errors.Error("no host")
+	} else if insecure {
+		return errUnblockInsecure
+	}
+
+	if Context.auth != nil && Context.auth.AuthRequired() {
+		addr := ip.String()
+		if rl := Context.auth.raleLimiter; rl != nil {
+			if left := rl.check(addr); left > 0 {
+				return fmt.Errorf("blocked for %s", left.Round(time.Second))
+			}
+		}
+
+		var u webUser
+		u, err = Context.auth.authenticate(loginJSON{
+			Name:     r.PostFormValue("name"),
+			Password: r.PostFormValue("password"),
+			TOTP:     r.PostFormValue("totp"),
+		}, addr)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		if role := u.role(); role != roleAdmin && role != roleOperator {
+			return fmt.Errorf("user %q with role %q is not allowed to unblock", u.Name, role)
+		}
+
+		log.Info("blockpage: user %q unblocked %q for %s", u.Name, host, ip)
+	} else {
+		log.Info("blockpage: unblocked %q for %s", host, ip)
+	}
+
+	bp.unblock(ip, host, time.Now())
+
+	return nil
+}
+
+// fillBlockInfo fills the filtering information about the host of data for the
+// client with ip.
+func (bp *blockPage) fillBlockInfo(data *blockPageData, ip netip.Addr) {
+	if data.Host == "" || Context.filters == nil {
+		return
+	}
+
+	setts := Context.filters.Settings()
+	setts.ProtectionEnabled, _ = Context.filters.ProtectionStatus()
+	applyAdditionalFiltering(ip, "", setts)
+
+	res, err := Context.filters.CheckHost(data.Host, dns.TypeA, setts)
+	if err != nil {
+		log.Debug("blockpage: checking %q: %s", data.Host, err)
+
+		return
+	}
+
+	data.Blocked = res.IsFiltered
+	data.Reason = res.Reason.String()
+	data.Service = res.ServiceName
+
+	names := map[int64]string{
+		filtering.CustomListID:      "Custom filtering rules",
+		filtering.SysHostsListID:    "System hosts file",
+		filtering.BlockedSvcsListID: "Blocked services",
+	}
+
+	for _, s := range Context.filters.FilterListSizes() {
+		names[s.ID] = s.Name
+	}
+
+	for _, rule := range res.Rules {
+		name, ok := names[rule.FilterListID]
+		if !ok {
+			name = "#" + strconv.FormatInt(rule.FilterListID, 10)
+		}
+
+		data.Rules = append(data.Rules, &blockPageRule{
+			Text: rule.Text,
+			List: name,
+		})
+	}
+}
+
+// blockPageTmpl is the template of the block page.
+var blockPageTmpl = template.Must(template.New("blockpage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Blocked}}Blocked: {{end}}{{.Host}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; color: #222; }
+code { word-break: break-all; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{if .Blocked}}Access to {{.Host}} is blocked{{else}}{{.Host}} is not blocked{{end}}</h1>
+{{if .Unblocked}}<p>{{.Host}} is unblocked for {{.Duration}}.  It may take some time until your device stops using the cached blocked response.</p>{{end}}
+{{if .Requested}}<p>Access to {{.Host}} has been requested.  Please wait for the administrators to review the request.</p>{{end}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Blocked}}
+<p>Reason: {{.Reason}}{{if .Service}}, service {{.Service}}{{end}}</p>
+{{if .Rules}}<ul>{{range .Rules}}<li><code>{{.Text}}</code> from {{.List}}</li>{{end}}</ul>{{end}}
+{{if .Insecure}}
+<p>Unblocking requires a secure connection.  {{if .HTTPSURL}}<a href="{{.HTTPSURL}}">Open this page over HTTPS</a> to unblock {{.Host}}.{{else}}The administrators can unblock {{.Host}} in the AdGuard Home web interface.{{end}}</p>
+{{else}}
+<form method="post">
+{{if .AuthRequired}}
+<p><label>Username <input name="name" autocomplete="username" required></label></p>
+<p><label>Password <input name="password" type="password" autocomplete="current-password" required></label></p>
+<p><label>Two-factor code <input name="totp" autocomplete="one-time-code"></label></p>
+{{end}}
+<p><button type="submit" name="action" value="unblock">Unblock for {{.Duration}}</button></p>
+</form>
+{{end}}
+{{if .CanRequest}}
+<form method="post">
+<p><label>Why do you need access to this site? <textarea name="comment" maxlength="1024"></textarea></label></p>
+<p><button type="submit" name="action" value="request">Request access</button></p>
+</form>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
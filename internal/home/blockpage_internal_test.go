@@ -0,0 +1,182 @@
+package home
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockPageConfig_validate(t *testing.T) {
+	addr := netip.MustParseAddrPort("0.0.0.0:80")
+	dur := timeutil.Duration{Duration: time.Hour}
+
+	testCases := []struct {
+		conf       *blockPageConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf:       &blockPageConfig{Enabled: false},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: &blockPageConfig{
+			Address:         addr,
+			AddressHTTPS:    netip.MustParseAddrPort("0.0.0.0:443"),
+			UnblockDuration: dur,
+			Enabled:         true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &blockPageConfig{
+			UnblockDuration: dur,
+			Enabled:         true,
+		},
+		name:       "no_port",
+		wantErrMsg: "address: no port",
+	}, {
+		conf: &blockPageConfig{
+			Address:         addr,
+			AddressHTTPS:    netip.MustParseAddrPort("0.0.0.0:0"),
+			UnblockDuration: dur,
+			Enabled:         true,
+		},
+		name:       "no_https_port",
+		wantErrMsg: "address_https: no port",
+	}, {
+		conf: &blockPageConfig{
+			Address: addr,
+			Enabled: true,
+		},
+		name:       "no_duration",
+		wantErrMsg: "unblock_duration: must be positive, got 0s",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
+
+func TestBlockPage_applyUnblocks(t *testing.T) {
+	const host = "blocked.example"
+
+	bp := newBlockPage(&blockPageConfig{
+		Address:         netip.MustParseAddrPort("127.0.0.1:8080"),
+		UnblockDuration: timeutil.Duration{Duration: time.Hour},
+		Enabled:         true,
+	})
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	otherIP := netip.MustParseAddr("192.0.2.2")
+	now := time.Now()
+
+	bp.unblock(ip, host, now)
+
+	setts := &filtering.Settings{}
+	bp.applyUnblocks(setts, ip, now.Add(time.Minute))
+	assert.Equal(t, []string{host}, setts.UnblockedHosts)
+
+	setts = &filtering.Settings{}
+	bp.applyUnblocks(setts, otherIP, now.Add(time.Minute))
+	assert.Empty(t, setts.UnblockedHosts)
+
+	setts = &filtering.Settings{}
+	bp.applyUnblocks(setts, ip, now.Add(2*time.Hour))
+	assert.Empty(t, setts.UnblockedHosts)
+	assert.NotContains(t, bp.unblocks, ip)
+
+	var nilBP *blockPage
+	assert.NotPanics(t, func() {
+		nilBP.applyUnblocks(setts, ip, now)
+	})
+}
+
+func TestBlockPage_ServeHTTP_unblock(t *testing.T) {
+	users := []webUser{
+		{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
+	}
+
+	prevAuth := Context.auth
+	Context.auth = InitAuth(filepath.Join(t.TempDir(), "sessions.db"), users, 60, nil)
+	t.Cleanup(func() {
+		Context.auth.Close()
+		Context.auth = prevAuth
+	})
+
+	bp := newBlockPage(&blockPageConfig{
+		Address:         netip.MustParseAddrPort("127.0.0.1:8080"),
+		AddressHTTPS:    netip.MustParseAddrPort("127.0.0.1:8443"),
+		UnblockDuration: timeutil.Duration{Duration: time.Hour},
+		Enabled:         true,
+	})
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	form := url.Values{
+		"action":   []string{"unblock"},
+		"name":     []string{"name"},
+		"password": []string{"password"},
+	}
+
+	newReq := func() (r *http.Request) {
+		r = httptest.NewRequest(
+			http.MethodPost,
+			"http://blocked.example/",
+			strings.NewReader(form.Encode()),
+		)
+		r.Header.Set(httphdr.ContentType, "application/x-www-form-urlencoded")
+
+		return r
+	}
+
+	t.Run("http", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		bp.ServeHTTP(w, newReq())
+
+		assert.Contains(t, w.Body.String(), errUnblockInsecure.Error())
+		assert.NotContains(t, bp.unblocks, ip)
+	})
+
+	t.Run("http_form", func(t *testing.T) {
+		b := &strings.Builder{}
+		err := blockPageTmpl.Execute(b, &blockPageData{
+			Host:         "blocked.example",
+			HTTPSURL:     "https://blocked.example:8443/",
+			Blocked:      true,
+			AuthRequired: true,
+			Insecure:     true,
+		})
+		require.NoError(t, err)
+
+		assert.Contains(t, b.String(), `href="https://blocked.example:8443/"`)
+		assert.NotContains(t, b.String(), `name="password"`)
+	})
+
+	t.Run("https", func(t *testing.T) {
+		r := newReq()
+		r.TLS = &tls.ConnectionState{}
+
+		w := httptest.NewRecorder()
+		bp.ServeHTTP(w, r)
+
+		assert.Contains(t, bp.unblocks[ip], "blocked.example")
+	})
+}
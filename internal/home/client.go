@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
@@ -32,6 +33,31 @@ type Client struct {
 	Tags      []string
 	Upstreams []string
 
+	// BootstrapDNS are the bootstrap DNS servers used to resolve the
+	// hostnames of the client's upstream servers.  If empty, the global
+	// bootstrap servers are used.
+	BootstrapDNS []string
+
+	// BlockedCategories are the names of the domain categories blocked for
+	// the client.  They are only used if UseOwnBlockedCategories is true.
+	BlockedCategories []string
+
+	// MinTTL is the minimum TTL of the records in the responses to the
+	// client.  Zero means that the TTLs aren't changed.
+	MinTTL uint32
+
+	// Ratelimit is the maximum number of requests per second from all the
+	// addresses of the client.  Zero means no limit.
+	Ratelimit uint32
+
+	// RatelimitBurst is the maximum number of requests, which the client may
+	// send at once.  Zero means that Ratelimit is used.
+	RatelimitBurst uint32
+
+	// RatelimitDrop is true if the requests above the limit are dropped
+	// instead of being responded with REFUSED.
+	RatelimitDrop bool
+
 	UseOwnSettings        bool
 	FilteringEnabled      bool
 	SafeBrowsingEnabled   bool
@@ -39,6 +65,14 @@ type Client struct {
 	UseOwnBlockedServices bool
 	IgnoreQueryLog        bool
 	IgnoreStatistics      bool
+
+	// UseOwnBlockedCategories is true if the client uses BlockedCategories
+	// instead of the globally blocked domain categories.
+	UseOwnBlockedCategories bool
+
+	// AllowlistOnly is true if all hosts, which aren't explicitly allowed, are
+	// blocked for the client.
+	AllowlistOnly bool
 }
 
 // ShallowClone returns a deep copy of the client, except upstreamConfig,
@@ -50,6 +84,8 @@ func (c *Client) ShallowClone() (sh *Client) {
 	clone.IDs = stringutil.CloneSlice(c.IDs)
 	clone.Tags = stringutil.CloneSlice(c.Tags)
 	clone.Upstreams = stringutil.CloneSlice(c.Upstreams)
+	clone.BootstrapDNS = stringutil.CloneSlice(c.BootstrapDNS)
+	clone.BlockedCategories = stringutil.CloneSlice(c.BlockedCategories)
 
 	return &clone
 }
@@ -92,6 +128,24 @@ type RuntimeClient struct {
 	// Host is the host name of a client.
 	Host string
 
+	// Model is the model of the device advertised by the client, if any.
+	Model string
+
+	// DeviceType is the kind of the device discovered on the local network,
+	// if known.
+	DeviceType discovery.DeviceType
+
+	// Vendor is the vendor of the device guessed by its fingerprint, if any.
+	Vendor string
+
+	// OS is the operating system of the device guessed by its fingerprint, if
+	// any.
+	OS string
+
+	// userAgent is the last User-Agent of the DoH requests of the client,
+	// which has been matched against the fingerprints.
+	userAgent string
+
 	// Source is the source from which the information about the client has
 	// been obtained.
 	Source client.Source
@@ -0,0 +1,446 @@
+package home
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/exp/slices"
+)
+
+// clientGroup is a set of settings inherited by its members, for example by
+// all the IoT devices on the network.  The members are identified by their
+// MAC addresses, IP addresses, CIDR subnets, ClientIDs, names of the persistent
+// clients, tags of the persistent clients, or the guessed device types of the
+// runtime clients.  The settings with nil values aren't inherited.
+type clientGroup struct {
+	// SafeSearch, if not nil, is the safe search configuration of the members.
+	SafeSearch *filtering.SafeSearchConfig `yaml:"safe_search,omitempty" json:"safe_search,omitempty"`
+
+	// BlockedServices, if not nil, are the services blocked for the members
+	// instead of the globally blocked ones, with their own schedule.
+	BlockedServices *filtering.BlockedServices `yaml:"blocked_services,omitempty" json:"blocked_services,omitempty"`
+
+	// FilteringEnabled, if not nil, overrides the filtering setting of the
+	// members.
+	FilteringEnabled *bool `yaml:"filtering_enabled,omitempty" json:"filtering_enabled,omitempty"`
+
+	// ParentalEnabled, if not nil, overrides the parental control setting of
+	// the members.
+	ParentalEnabled *bool `yaml:"parental_enabled,omitempty" json:"parental_enabled,omitempty"`
+
+	// SafeBrowsingEnabled, if not nil, overrides the safe browsing setting of
+	// the members.
+	SafeBrowsingEnabled *bool `yaml:"safebrowsing_enabled,omitempty" json:"safebrowsing_enabled,omitempty"`
+
+	// safeSearch is the safe search built from SafeSearch.  It's nil unless
+	// SafeSearch is enabled.
+	safeSearch filtering.SafeSearch
+
+	// upstreamConfig is the upstream config parsed from Upstreams.  If it's
+	// nil, it has not been initialized yet.
+	upstreamConfig *proxy.UpstreamConfig
+
+	// ids are the ClientIDs, the normalized MAC addresses, and the names of the
+	// persistent clients from Clients.
+	ids *stringutil.Set
+
+	// tags are the client tags from Tags.
+	tags *stringutil.Set
+
+	// Name is the unique name of the group.
+	Name string `yaml:"name" json:"name"`
+
+	// Clients are the MAC addresses, IP addresses, CIDR subnets, ClientIDs,
+	// and names of the persistent clients, which are the members of the group.
+	Clients []string `yaml:"clients" json:"clients"`
+
+	// Tags are the tags of the persistent clients, which are the members of
+	// the group.
+	Tags []string `yaml:"tags" json:"tags"`
+
+	// Upstreams are the upstream servers for the members, which don't have
+	// their own upstream servers.
+	Upstreams []string `yaml:"upstreams" json:"upstreams"`
+
+	// BootstrapDNS are the bootstrap DNS servers used to resolve the hostnames
+	// of Upstreams.
+	BootstrapDNS []string `yaml:"bootstrap_dns" json:"bootstrap_dns"`
+
+	// DeviceTypes are the device types of the runtime clients, which are the
+	// members of the group.  It allows the default policies for the kinds of
+	// devices, for example for all TVs.
+	DeviceTypes []discovery.DeviceType `yaml:"device_types" json:"device_types"`
+
+	// subnets are the IP addresses and CIDR subnets from Clients.  The single
+	// IP addresses are kept as single-address prefixes.
+	subnets []netip.Prefix
+
+	// hasMACs is true if Clients contain any MAC addresses.
+	hasMACs bool
+}
+
+// init validates g and initializes its unexported fields.  ssCacheSize and
+// ssCacheTTL are used for the safe search cache.
+func (g *clientGroup) init(ssCacheSize uint, ssCacheTTL time.Duration) (err error) {
+	switch {
+	case g.Name == "":
+		return errors.Error("empty name")
+	case len(g.Clients) == 0 && len(g.Tags) == 0 && len(g.DeviceTypes) == 0:
+		return errors.Error("no clients, tags, or device types")
+	}
+
+	g.ids, g.subnets, g.hasMACs = stringutil.NewSet(), nil, false
+	for _, c := range g.Clients {
+		if mac, macErr := net.ParseMAC(c); macErr == nil {
+			g.ids.Add(mac.String())
+			g.hasMACs = true
+
+			continue
+		}
+
+		var pref netip.Prefix
+		pref, err = parseScheduleClient(c)
+		if err != nil {
+			return fmt.Errorf("client %q: %w", c, err)
+		} else if pref.IsValid() {
+			g.subnets = append(g.subnets, pref)
+		} else {
+			g.ids.Add(c)
+		}
+	}
+
+	allTags := stringutil.NewSet(clientTags...)
+	for _, t := range g.Tags {
+		if !allTags.Has(t) {
+			return fmt.Errorf("unknown tag %q", t)
+		}
+	}
+
+	g.tags = stringutil.NewSet(g.Tags...)
+
+	if slices.Contains(g.DeviceTypes, discovery.DeviceTypeNone) {
+		return errors.Error("empty device type")
+	}
+
+	if g.BlockedServices != nil {
+		err = g.BlockedServices.Validate()
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		if g.BlockedServices.Schedule == nil {
+			g.BlockedServices.Schedule = schedule.EmptyWeekly()
+		}
+	}
+
+	err = dnsforward.ValidateUpstreams(g.Upstreams)
+	if err != nil {
+		return fmt.Errorf("invalid upstream servers: %w", err)
+	}
+
+	err = dnsforward.ValidateBootstraps(g.BootstrapDNS)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap servers: %w", err)
+	}
+
+	g.safeSearch = nil
+	if g.SafeSearch != nil && g.SafeSearch.Enabled {
+		conf := *g.SafeSearch
+		conf.CustomResolver = safeSearchResolver{}
+
+		g.safeSearch, err = safesearch.NewDefault(
+			conf,
+			fmt.Sprintf("group %q", g.Name),
+			ssCacheSize,
+			ssCacheTTL,
+		)
+		if err != nil {
+			return fmt.Errorf("safe search: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// contains returns true if the client with ip, clientID, mac, and the guessed
+// device type devType is a member of g.  c is the persistent client, if any.
+func (g *clientGroup) contains(
+	ip netip.Addr,
+	clientID string,
+	mac net.HardwareAddr,
+	devType discovery.DeviceType,
+	c *Client,
+) (ok bool) {
+	if (clientID != "" && g.ids.Has(clientID)) || (mac != nil && g.ids.Has(mac.String())) {
+		return true
+	}
+
+	if devType != discovery.DeviceTypeNone && slices.Contains(g.DeviceTypes, devType) {
+		return true
+	}
+
+	if c != nil {
+		if g.ids.Has(c.Name) {
+			return true
+		}
+
+		for _, t := range c.Tags {
+			if g.tags.Has(t) {
+				return true
+			}
+		}
+	}
+
+	ip = ip.Unmap()
+	for _, pref := range g.subnets {
+		if pref.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply applies the filtering settings of g to setts.
+func (g *clientGroup) apply(setts *filtering.Settings, now time.Time) {
+	if g.FilteringEnabled != nil {
+		setts.FilteringEnabled = *g.FilteringEnabled
+	}
+
+	if g.SafeBrowsingEnabled != nil {
+		setts.SafeBrowsingEnabled = *g.SafeBrowsingEnabled
+	}
+
+	if g.ParentalEnabled != nil {
+		setts.ParentalEnabled = *g.ParentalEnabled
+	}
+
+	if g.SafeSearch != nil {
+		setts.SafeSearchEnabled = g.SafeSearch.Enabled
+		setts.ClientSafeSearch = g.safeSearch
+	}
+
+	if g.BlockedServices != nil {
+		setts.ServicesRules = nil
+		if !g.BlockedServices.Schedule.Contains(now) {
+			Context.filters.ApplyBlockedServicesList(setts, g.BlockedServices.IDs)
+		}
+	}
+}
+
+// closeUpstreams closes the upstream config of g, if any.
+func (g *clientGroup) closeUpstreams() (err error) {
+	if g.upstreamConfig == nil {
+		return nil
+	}
+
+	err = g.upstreamConfig.Close()
+	if err != nil {
+		return fmt.Errorf("closing upstreams of group %q: %w", g.Name, err)
+	}
+
+	return nil
+}
+
+// addGroups validates and adds the client groups from the configuration file.
+func (clients *clientsContainer) addGroups(groups []*clientGroup) (err error) {
+	for i, g := range groups {
+		if g == nil {
+			return fmt.Errorf("clients: group at index %d: %w", i, errors.Error("no value"))
+		}
+
+		err = clients.addGroup(g)
+		if err != nil {
+			return fmt.Errorf("clients: group at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// groupIndexLocked returns the index of the group with name or -1 if there is
+// none.  clients.lock is expected to be locked.
+func (clients *clientsContainer) groupIndexLocked(name string) (i int) {
+	return slices.IndexFunc(clients.groups, func(g *clientGroup) (ok bool) {
+		return g.Name == name
+	})
+}
+
+// addGroup validates g and adds it with the lowest precedence.
+func (clients *clientsContainer) addGroup(g *clientGroup) (err error) {
+	err = g.init(clients.safeSearchCacheSize, clients.safeSearchCacheTTL)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if clients.groupIndexLocked(g.Name) != -1 {
+		return fmt.Errorf("group with name %q already exists", g.Name)
+	}
+
+	clients.groups = append(clients.groups, g)
+
+	return nil
+}
+
+// updateGroup validates g and replaces the group with name with it, keeping
+// its precedence.
+func (clients *clientsContainer) updateGroup(name string, g *clientGroup) (err error) {
+	err = g.init(clients.safeSearchCacheSize, clients.safeSearchCacheTTL)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	i := clients.groupIndexLocked(name)
+	if i == -1 {
+		return fmt.Errorf("group %q: %w", name, errNotFound)
+	}
+
+	if g.Name != name && clients.groupIndexLocked(g.Name) != -1 {
+		return fmt.Errorf("group with name %q already exists", g.Name)
+	}
+
+	if err = clients.groups[i].closeUpstreams(); err != nil {
+		log.Error("clients: updating group: %s", err)
+	}
+
+	clients.groups[i] = g
+
+	return nil
+}
+
+// removeGroup removes the group with name.
+func (clients *clientsContainer) removeGroup(name string) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	i := clients.groupIndexLocked(name)
+	if i == -1 {
+		return fmt.Errorf("group %q: %w", name, errNotFound)
+	}
+
+	if err = clients.groups[i].closeUpstreams(); err != nil {
+		log.Error("clients: removing group: %s", err)
+	}
+
+	clients.groups = slices.Delete(clients.groups, i, i+1)
+
+	return nil
+}
+
+// groupsForLocked returns the groups, which the client with ip and clientID is
+// a member of, in the order of decreasing precedence.  c is the persistent
+// client, if any.  clients.lock is expected to be locked.
+func (clients *clientsContainer) groupsForLocked(
+	ip netip.Addr,
+	clientID string,
+	c *Client,
+) (groups []*clientGroup) {
+	var mac net.HardwareAddr
+	if ip.IsValid() && slices.ContainsFunc(clients.groups, func(g *clientGroup) (ok bool) {
+		return g.hasMACs
+	}) {
+		mac = clients.dhcp.MACByIP(ip)
+	}
+
+	devType := discovery.DeviceTypeNone
+	if rc, ok := clients.ipToRC[ip]; ok {
+		devType = rc.DeviceType
+	}
+
+	for _, g := range clients.groups {
+		if g.contains(ip, clientID, mac, devType, c) {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// applyGroups applies the settings of the groups, which the client with ip and
+// clientID is a member of, to setts.  c is the persistent client, if any.  The
+// settings of the groups with higher precedence override the ones of the
+// groups with lower precedence.
+func (clients *clientsContainer) applyGroups(
+	setts *filtering.Settings,
+	ip netip.Addr,
+	clientID string,
+	c *Client,
+	now time.Time,
+) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	groups := clients.groupsForLocked(ip, clientID, c)
+	for i := len(groups) - 1; i >= 0; i-- {
+		groups[i].apply(setts, now)
+	}
+}
+
+// findGroupUpstreamsLocked returns the upstreams of the group with the highest
+// precedence among groups, which has any.  upsConf is nil if there are no such
+// groups.  clients.lock is expected to be locked.
+func (clients *clientsContainer) findGroupUpstreamsLocked(
+	groups []*clientGroup,
+) (upsConf *proxy.UpstreamConfig, err error) {
+	for _, g := range groups {
+		upstreams := stringutil.FilterOut(g.Upstreams, dnsforward.IsCommentOrEmpty)
+		if len(upstreams) == 0 {
+			continue
+		}
+
+		if g.upstreamConfig != nil {
+			return g.upstreamConfig, nil
+		}
+
+		upsConf, err = newClientUpstreamConfig(upstreams, g.BootstrapDNS)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %w", g.Name, err)
+		}
+
+		g.upstreamConfig = upsConf
+
+		return upsConf, nil
+	}
+
+	return nil, nil
+}
+
+// groupsBlockServiceLocked returns true if the service with id is blocked for
+// any of the groups.  clients.lock is expected to be locked.
+func (clients *clientsContainer) groupsBlockServiceLocked(id string) (ok bool) {
+	for _, g := range clients.groups {
+		if g.BlockedServices != nil && slices.Contains(g.BlockedServices.IDs, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupsForConfig returns the client groups for the configuration file.
+func (clients *clientsContainer) groupsForConfig() (groups []*clientGroup) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	return slices.Clone(clients.groups)
+}
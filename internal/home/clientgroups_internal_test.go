@@ -0,0 +1,288 @@
+package home
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/AdGuardHome/internal/whois"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsContainer_addGroups(t *testing.T) {
+	filtering.InitModule()
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		groups     []*clientGroup
+	}{{
+		name:       "empty",
+		wantErrMsg: "",
+		groups:     nil,
+	}, {
+		name:       "valid",
+		wantErrMsg: "",
+		groups: []*clientGroup{{
+			BlockedServices: &filtering.BlockedServices{IDs: []string{"youtube"}},
+			Name:            "iot",
+			Clients:         []string{"192.0.2.0/24", "aa:bb:cc:dd:ee:ff", "camera"},
+			Tags:            []string{"device_other"},
+			Upstreams:       []string{"1.1.1.1"},
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "clients: group at index 0: no value",
+		groups:     []*clientGroup{nil},
+	}, {
+		name:       "no_name",
+		wantErrMsg: "clients: group at index 0: empty name",
+		groups: []*clientGroup{{
+			Tags: []string{"device_other"},
+		}},
+	}, {
+		name:       "no_clients",
+		wantErrMsg: "clients: group at index 0: no clients, tags, or device types",
+		groups: []*clientGroup{{
+			Name: "test",
+		}},
+	}, {
+		name:       "bad_tag",
+		wantErrMsg: `clients: group at index 0: unknown tag "device_bad"`,
+		groups: []*clientGroup{{
+			Name: "test",
+			Tags: []string{"device_bad"},
+		}},
+	}, {
+		name:       "bad_service",
+		wantErrMsg: `clients: group at index 0: unknown blocked-service "bad_service"`,
+		groups: []*clientGroup{{
+			BlockedServices: &filtering.BlockedServices{IDs: []string{"bad_service"}},
+			Name:            "test",
+			Tags:            []string{"device_other"},
+		}},
+	}, {
+		name: "bad_bootstrap",
+		wantErrMsg: "clients: group at index 0: invalid bootstrap servers: " +
+			"checking bootstrap : invalid address: empty",
+		groups: []*clientGroup{{
+			Name:         "test",
+			Tags:         []string{"device_other"},
+			BootstrapDNS: []string{""},
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `clients: group at index 1: group with name "test" already exists`,
+		groups: []*clientGroup{{
+			Name: "test",
+			Tags: []string{"device_other"},
+		}, {
+			Name: "test",
+			Tags: []string{"device_other"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clients := newClientsContainer(t)
+
+			err := clients.addGroups(tc.groups)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestClientsContainer_applyGroups(t *testing.T) {
+	filtering.InitModule()
+
+	var err error
+	Context.filters, err = filtering.New(&filtering.Config{}, nil)
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	macIP := netip.MustParseAddr("192.168.1.10")
+
+	clients := newClientsContainer(t)
+	clients.dhcp = &testDHCP{
+		OnHostBy: func(ip netip.Addr) (host string) { return "" },
+		OnMACBy: func(ip netip.Addr) (m net.HardwareAddr) {
+			if ip == macIP {
+				return mac
+			}
+
+			return nil
+		},
+	}
+
+	enabled, disabled := true, false
+	err = clients.addGroups([]*clientGroup{{
+		ParentalEnabled: &enabled,
+		Name:            "kids",
+		Tags:            []string{"user_child"},
+	}, {
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+			IDs:      []string{"youtube"},
+		},
+		FilteringEnabled: &enabled,
+		ParentalEnabled:  &disabled,
+		Name:             "iot",
+		Clients:          []string{"192.168.1.0/24", mac.String()},
+	}, {
+		ParentalEnabled: &enabled,
+		Name:            "tvs",
+		DeviceTypes:     []discovery.DeviceType{discovery.DeviceTypeTV},
+	}})
+	require.NoError(t, err)
+
+	tvIP := netip.MustParseAddr("192.0.2.2")
+	clients.ipToRC[tvIP] = &RuntimeClient{
+		WHOIS:      &whois.Info{},
+		DeviceType: discovery.DeviceTypeTV,
+	}
+
+	child := &Client{
+		Name: "tablet",
+		Tags: []string{"user_child"},
+	}
+
+	now := time.Now()
+
+	testCases := []struct {
+		c             *Client
+		name          string
+		ip            netip.Addr
+		wantServices  int
+		wantFiltering bool
+		wantParental  bool
+	}{{
+		c:             nil,
+		name:          "none",
+		ip:            netip.MustParseAddr("192.0.2.1"),
+		wantServices:  0,
+		wantFiltering: false,
+		wantParental:  false,
+	}, {
+		c:             nil,
+		name:          "subnet",
+		ip:            netip.MustParseAddr("192.168.1.2"),
+		wantServices:  1,
+		wantFiltering: true,
+		wantParental:  false,
+	}, {
+		c:             child,
+		name:          "precedence",
+		ip:            netip.MustParseAddr("192.168.1.3"),
+		wantServices:  1,
+		wantFiltering: true,
+		wantParental:  true,
+	}, {
+		c:             nil,
+		name:          "mac",
+		ip:            macIP,
+		wantServices:  1,
+		wantFiltering: true,
+		wantParental:  false,
+	}, {
+		c:             nil,
+		name:          "device_type",
+		ip:            tvIP,
+		wantServices:  0,
+		wantFiltering: false,
+		wantParental:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setts := &filtering.Settings{}
+			clients.applyGroups(setts, tc.ip, "", tc.c, now)
+
+			assert.Len(t, setts.ServicesRules, tc.wantServices)
+			assert.Equal(t, tc.wantFiltering, setts.FilteringEnabled)
+			assert.Equal(t, tc.wantParental, setts.ParentalEnabled)
+		})
+	}
+}
+
+func TestClientsContainer_findUpstreams_groups(t *testing.T) {
+	clients := newClientsContainer(t)
+
+	err := clients.addGroups([]*clientGroup{{
+		Name:      "iot",
+		Clients:   []string{"192.168.1.0/24", "sensor"},
+		Upstreams: []string{"tls://dns.iot.example"},
+	}})
+	require.NoError(t, err)
+
+	err = clients.addTagUpstreams([]*tagUpstreamsObject{{
+		Tag:       "device_other",
+		Upstreams: []string{"tls://dns.tag.example"},
+	}})
+	require.NoError(t, err)
+
+	ok, err := clients.Add(&Client{
+		IDs:  []string{"192.168.1.2"},
+		Name: "camera",
+		Tags: []string{"device_other"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = clients.Add(&Client{
+		IDs:       []string{"192.168.1.3"},
+		Name:      "own",
+		Upstreams: []string{"tls://dns.own.example"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	testCases := []struct {
+		name string
+		id   string
+		want string
+	}{{
+		name: "runtime",
+		id:   "192.168.1.4",
+		want: "tls://dns.iot.example:853",
+	}, {
+		name: "client_id",
+		id:   "sensor",
+		want: "tls://dns.iot.example:853",
+	}, {
+		name: "group_over_tag",
+		id:   "192.168.1.2",
+		want: "tls://dns.iot.example:853",
+	}, {
+		name: "own",
+		id:   "192.168.1.3",
+		want: "tls://dns.own.example:853",
+	}, {
+		name: "none",
+		id:   "192.0.2.1",
+		want: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf, confErr := clients.findUpstreams(tc.id)
+			require.NoError(t, confErr)
+
+			if tc.want == "" {
+				assert.Nil(t, conf)
+
+				return
+			}
+
+			require.NotNil(t, conf)
+			require.Len(t, conf.Upstreams, 1)
+
+			assert.Equal(t, tc.want, conf.Upstreams[0].Address())
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// clientGroupsListJSON is the JSON structure for the list of client groups.
+type clientGroupsListJSON struct {
+	Groups []*clientGroup `json:"groups"`
+}
+
+// handleGetGroups is the handler for the GET /control/clients/groups HTTP API.
+func (clients *clientsContainer) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	resp := &clientGroupsListJSON{
+		Groups: clients.groupsForConfig(),
+	}
+
+	if resp.Groups == nil {
+		resp.Groups = []*clientGroup{}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleAddGroup is the handler for the POST /control/clients/groups/add HTTP
+// API.
+func (clients *clientsContainer) handleAddGroup(w http.ResponseWriter, r *http.Request) {
+	g := &clientGroup{}
+	err := json.NewDecoder(r.Body).Decode(g)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = clients.addGroup(g)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "adding group: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// clientGroupUpdateJSON is the JSON structure for the request to update a
+// client group.
+type clientGroupUpdateJSON struct {
+	Data *clientGroup `json:"data"`
+	Name string       `json:"name"`
+}
+
+// handleUpdateGroup is the handler for the POST /control/clients/groups/update
+// HTTP API.
+func (clients *clientsContainer) handleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	req := &clientGroupUpdateJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	} else if req.Data == nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no data")
+
+		return
+	}
+
+	err = clients.updateGroup(req.Name, req.Data)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "updating group: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// clientGroupDeleteJSON is the JSON structure for the request to delete a
+// client group.
+type clientGroupDeleteJSON struct {
+	Name string `json:"name"`
+}
+
+// handleDeleteGroup is the handler for the POST /control/clients/groups/delete
+// HTTP API.
+func (clients *clientsContainer) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	req := &clientGroupDeleteJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = clients.removeGroup(req.Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "deleting group: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
@@ -0,0 +1,214 @@
+package home
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"golang.org/x/exp/slices"
+)
+
+// internetPause is a pause of the internet access of a client or of the members
+// of a client group, during which only the allowed hosts are resolved for
+// them.
+type internetPause struct {
+	// Until is the time, when the internet access is automatically resumed.
+	Until time.Time
+
+	// ID is the key of the paused client, see [clientsContainer.pauseKey].
+	// It's empty if a group is paused.
+	ID string
+
+	// Group is the name of the paused client group.  It's empty if a client is
+	// paused.
+	Group string
+
+	// AllowedHosts are the normalized hosts, which are still resolved during
+	// the pause.
+	AllowedHosts []string
+}
+
+// internetPauseIndexLocked returns the index of the internet pause for the
+// client with key or for group, or -1 if there is none.  clients.lock is
+// expected to be locked.
+func (clients *clientsContainer) internetPauseIndexLocked(key, group string) (i int) {
+	return slices.IndexFunc(clients.internetPauses, func(p *internetPause) (ok bool) {
+		return p.ID == key && p.Group == group
+	})
+}
+
+// internetPauseTargetLocked validates the pause target and returns the key of
+// the client identified by id, see [clientsContainer.pauseKey].  Exactly one of
+// id and group must be set.  clients.lock is expected to be locked.
+func (clients *clientsContainer) internetPauseTargetLocked(id, group string) (key string, err error) {
+	switch {
+	case id == "" && group == "":
+		return "", errors.Error("no id or group")
+	case id != "" && group != "":
+		return "", errors.Error("both id and group are set")
+	case group != "":
+		if clients.groupIndexLocked(group) < 0 {
+			return "", fmt.Errorf("group %q: %w", group, errNotFound)
+		}
+
+		return "", nil
+	default:
+		// Don't wrap the error, because it's informative enough as is.
+		return clients.pauseKey(id)
+	}
+}
+
+// pauseInternet pauses the internet access of the client identified by id or
+// of the members of group until the until time.  allowed are the hosts still
+// resolved during the pause.  If there already is a pause for the same target,
+// it's replaced.
+func (clients *clientsContainer) pauseInternet(
+	id string,
+	group string,
+	until time.Time,
+	allowed []string,
+) (err error) {
+	hosts := make([]string, 0, len(allowed))
+	for _, h := range allowed {
+		h = aghnet.NormalizeDomain(h)
+		err = netutil.ValidateDomainName(h)
+		if err != nil {
+			return fmt.Errorf("allowed host %q: %w", h, err)
+		}
+
+		hosts = append(hosts, h)
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	key, err := clients.internetPauseTargetLocked(id, group)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	p := &internetPause{
+		Until:        until,
+		ID:           key,
+		Group:        group,
+		AllowedHosts: hosts,
+	}
+
+	if i := clients.internetPauseIndexLocked(key, group); i >= 0 {
+		clients.internetPauses[i] = p
+	} else {
+		clients.internetPauses = append(clients.internetPauses, p)
+	}
+
+	log.Info(
+		"clients: internet access for %s is paused until %s",
+		p.target(),
+		until.Format(time.RFC3339),
+	)
+
+	return nil
+}
+
+// resumeInternet resumes the internet access of the client identified by id or
+// of the members of group before the end of the pause.
+func (clients *clientsContainer) resumeInternet(id, group string) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	key, err := clients.internetPauseTargetLocked(id, group)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	i := clients.internetPauseIndexLocked(key, group)
+	if i < 0 {
+		return errors.Error("internet access isn't paused")
+	}
+
+	p := clients.internetPauses[i]
+	clients.internetPauses = slices.Delete(clients.internetPauses, i, i+1)
+
+	log.Info("clients: internet access for %s is resumed", p.target())
+
+	return nil
+}
+
+// target returns the description of the target of p for logging.
+func (p *internetPause) target() (s string) {
+	if p.Group != "" {
+		return fmt.Sprintf("group %q", p.Group)
+	}
+
+	return fmt.Sprintf("%q", p.ID)
+}
+
+// internetPausesLocked returns the current internet pauses and removes the
+// expired ones.  clients.lock is expected to be locked.
+func (clients *clientsContainer) internetPausesLocked(now time.Time) (pauses []*internetPause) {
+	expired := func(p *internetPause) (del bool) {
+		if del = !now.Before(p.Until); del {
+			log.Info("clients: internet access for %s is resumed after pause", p.target())
+		}
+
+		return del
+	}
+
+	clients.internetPauses = slices.DeleteFunc(clients.internetPauses, expired)
+
+	return clients.internetPauses
+}
+
+// applyInternetPause pauses the internet access in setts, if it's paused for
+// the client with setts and clientID or for any of its groups at now.  The
+// allowed hosts of all matching pauses are combined.
+func (clients *clientsContainer) applyInternetPause(
+	setts *filtering.Settings,
+	clientID string,
+	now time.Time,
+) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	pauses := clients.internetPausesLocked(now)
+	if len(pauses) == 0 {
+		return
+	}
+
+	keys := []string{setts.ClientName, clientID}
+	if setts.ClientIP.IsValid() {
+		keys = append(keys, setts.ClientIP.Unmap().String())
+	}
+
+	var groups []*clientGroup
+	if slices.ContainsFunc(pauses, func(p *internetPause) (ok bool) { return p.Group != "" }) {
+		groups = clients.groupsForLocked(setts.ClientIP, clientID, clients.list[setts.ClientName])
+	}
+
+	for _, p := range pauses {
+		if p.matches(keys, groups) {
+			setts.InternetPaused = true
+			setts.InternetPauseAllowedHosts = append(
+				setts.InternetPauseAllowedHosts,
+				p.AllowedHosts...,
+			)
+		}
+	}
+}
+
+// matches returns true if p applies to the client with any of keys or a member
+// of any of groups.
+func (p *internetPause) matches(keys []string, groups []*clientGroup) (ok bool) {
+	if p.Group != "" {
+		return slices.ContainsFunc(groups, func(g *clientGroup) (found bool) {
+			return g.Name == p.Group
+		})
+	}
+
+	return slices.Contains(keys, p.ID)
+}
@@ -0,0 +1,101 @@
+package home
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsContainer_pauseInternet(t *testing.T) {
+	clients := newClientsContainer(t)
+
+	ok, err := clients.Add(&Client{
+		Name: "tablet",
+		IDs:  []string{"192.0.2.1"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	err = clients.addGroup(&clientGroup{
+		Name:    "kids",
+		Clients: []string{"198.51.100.0/24"},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	err = clients.pauseInternet("tablet", "", now.Add(time.Hour), []string{"School.example"})
+	require.NoError(t, err)
+
+	err = clients.pauseInternet("", "kids", now.Add(time.Minute), nil)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		setts       *filtering.Settings
+		name        string
+		wantAllowed []string
+		now         time.Time
+		wantPaused  bool
+	}{{
+		setts:       &filtering.Settings{ClientName: "tablet"},
+		name:        "client",
+		wantAllowed: []string{"school.example"},
+		now:         now,
+		wantPaused:  true,
+	}, {
+		setts:       &filtering.Settings{ClientIP: netip.MustParseAddr("198.51.100.1")},
+		name:        "group",
+		wantAllowed: nil,
+		now:         now,
+		wantPaused:  true,
+	}, {
+		setts:       &filtering.Settings{ClientIP: netip.MustParseAddr("192.0.2.3")},
+		name:        "other",
+		wantAllowed: nil,
+		now:         now,
+		wantPaused:  false,
+	}, {
+		setts:       &filtering.Settings{ClientIP: netip.MustParseAddr("198.51.100.1")},
+		name:        "expired",
+		wantAllowed: nil,
+		now:         now.Add(2 * time.Minute),
+		wantPaused:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clients.applyInternetPause(tc.setts, "", tc.now)
+
+			assert.Equal(t, tc.wantPaused, tc.setts.InternetPaused)
+			assert.Equal(t, tc.wantAllowed, tc.setts.InternetPauseAllowedHosts)
+		})
+	}
+
+	pauses := clients.internetPausesJSON(now)
+	require.Len(t, pauses, 1)
+
+	assert.Equal(t, "tablet", pauses[0].ID)
+	assert.Equal(t, time.Hour.Milliseconds(), pauses[0].Remaining)
+
+	err = clients.resumeInternet("tablet", "")
+	require.NoError(t, err)
+
+	err = clients.resumeInternet("tablet", "")
+	testutil.AssertErrorMsg(t, "internet access isn't paused", err)
+
+	err = clients.pauseInternet("tablet", "kids", now.Add(time.Hour), nil)
+	testutil.AssertErrorMsg(t, "both id and group are set", err)
+
+	err = clients.pauseInternet("", "", now.Add(time.Hour), nil)
+	testutil.AssertErrorMsg(t, "no id or group", err)
+
+	err = clients.pauseInternet("", "adults", now.Add(time.Hour), nil)
+	testutil.AssertErrorMsg(t, `group "adults": not found`, err)
+
+	err = clients.pauseInternet("tablet", "", now.Add(time.Hour), []string{"bad host"})
+	require.Error(t, err)
+}
@@ -0,0 +1,160 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"golang.org/x/exp/slices"
+)
+
+// internetPauseJSON is the JSON structure for a pause of the internet access.
+type internetPauseJSON struct {
+	// Until is the time, when the internet access is automatically resumed.
+	Until time.Time `json:"until"`
+
+	// ID is the name of the persistent client, the IP address, or the
+	// ClientID of the paused client.
+	ID string `json:"id,omitempty"`
+
+	// Group is the name of the paused client group.
+	Group string `json:"group,omitempty"`
+
+	// AllowedHosts are the hosts still resolved during the pause.
+	AllowedHosts []string `json:"allowed_hosts"`
+
+	// Remaining is the time left until the end of the pause in milliseconds.
+	Remaining int64 `json:"remaining"`
+}
+
+// internetPausesJSON returns the current internet pauses at now sorted by the
+// time of their end.
+func (clients *clientsContainer) internetPausesJSON(now time.Time) (pauses []*internetPauseJSON) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	pauses = []*internetPauseJSON{}
+	for _, p := range clients.internetPausesLocked(now) {
+		pauses = append(pauses, &internetPauseJSON{
+			Until:        p.Until,
+			ID:           p.ID,
+			Group:        p.Group,
+			AllowedHosts: slices.Clone(p.AllowedHosts),
+			Remaining:    p.Until.Sub(now).Milliseconds(),
+		})
+	}
+
+	slices.SortFunc(pauses, func(a, b *internetPauseJSON) (res int) {
+		return a.Until.Compare(b.Until)
+	})
+
+	return pauses
+}
+
+// internetPausesResp is the JSON structure for the list of the pauses of the
+// internet access.
+type internetPausesResp struct {
+	Pauses []*internetPauseJSON `json:"pauses"`
+}
+
+// handleGetInternetPauses is the handler for the GET
+// /control/clients/internet_pauses HTTP API.
+func (clients *clientsContainer) handleGetInternetPauses(w http.ResponseWriter, r *http.Request) {
+	resp := &internetPausesResp{
+		Pauses: clients.internetPausesJSON(time.Now()),
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// internetPauseReqJSON is the JSON structure for the request to pause the
+// internet access of a client or a group.
+type internetPauseReqJSON struct {
+	// Until, if set, is the time, when the internet access is resumed.
+	Until *time.Time `json:"until,omitempty"`
+
+	// ID is the name of the persistent client, the IP address, or the
+	// ClientID of the client.
+	ID string `json:"id"`
+
+	// Group is the name of the client group.
+	Group string `json:"group"`
+
+	// AllowedHosts are the hosts still resolved during the pause.
+	AllowedHosts []string `json:"allowed_hosts"`
+
+	// Duration, if set, is the duration of the pause in milliseconds.
+	Duration uint `json:"duration"`
+}
+
+// handleInternetPause is the handler for the POST
+// /control/clients/internet_pause HTTP API.
+func (clients *clientsContainer) handleInternetPause(w http.ResponseWriter, r *http.Request) {
+	req := &internetPauseReqJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	now := time.Now()
+
+	var until time.Time
+	switch {
+	case req.Duration > 0 && req.Until != nil:
+		aghhttp.Error(r, w, http.StatusBadRequest, "both duration and until are set")
+
+		return
+	case req.Duration > 0:
+		until = now.Add(time.Duration(req.Duration) * time.Millisecond)
+	case req.Until != nil && req.Until.After(now):
+		until = *req.Until
+	default:
+		aghhttp.Error(r, w, http.StatusBadRequest, "duration or future until must be set")
+
+		return
+	}
+
+	err = clients.pauseInternet(req.ID, req.Group, until, req.AllowedHosts)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "pausing internet access: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// internetResumeReqJSON is the JSON structure for the request to resume the
+// internet access of a client or a group.
+type internetResumeReqJSON struct {
+	// ID is the name of the persistent client, the IP address, or the
+	// ClientID of the client.
+	ID string `json:"id"`
+
+	// Group is the name of the client group.
+	Group string `json:"group"`
+}
+
+// handleInternetResume is the handler for the POST
+// /control/clients/internet_resume HTTP API.
+func (clients *clientsContainer) handleInternetResume(w http.ResponseWriter, r *http.Request) {
+	req := &internetResumeReqJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = clients.resumeInternet(req.ID, req.Group)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "resuming internet access: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
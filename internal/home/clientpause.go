@@ -0,0 +1,116 @@
+package home
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/maps"
+)
+
+// pauseKey returns the key for the pause of the protection for the client
+// identified by id, which is either a name of a persistent client, an IP
+// address, or a ClientID.  clients.lock is expected to be locked.
+func (clients *clientsContainer) pauseKey(id string) (key string, err error) {
+	if _, ok := clients.list[id]; ok {
+		return id, nil
+	}
+
+	if ip, parseErr := netip.ParseAddr(id); parseErr == nil {
+		return ip.Unmap().String(), nil
+	}
+
+	if dnsforward.ValidateClientID(id) == nil {
+		return id, nil
+	}
+
+	return "", fmt.Errorf("client %q: %w", id, errNotFound)
+}
+
+// pause disables the protection for the client identified by id until the
+// until time.  See [clientsContainer.pauseKey] for id.
+func (clients *clientsContainer) pause(id string, until time.Time) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	key, err := clients.pauseKey(id)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	clients.pausedUntil[key] = until
+
+	log.Info("clients: protection for %q is paused until %s", key, until.Format(time.RFC3339))
+
+	return nil
+}
+
+// resume enables the protection for the client identified by id before the end
+// of the pause.  See [clientsContainer.pauseKey] for id.
+func (clients *clientsContainer) resume(id string) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	key, err := clients.pauseKey(id)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if _, ok := clients.pausedUntil[key]; !ok {
+		return fmt.Errorf("client %q: protection isn't paused", id)
+	}
+
+	delete(clients.pausedUntil, key)
+
+	log.Info("clients: protection for %q is resumed", key)
+
+	return nil
+}
+
+// pausesLocked returns the current pauses of the protection by the clients'
+// keys and removes the expired ones.  clients.lock is expected to be locked.
+func (clients *clientsContainer) pausesLocked(now time.Time) (pauses map[string]time.Time) {
+	maps.DeleteFunc(clients.pausedUntil, func(key string, until time.Time) (del bool) {
+		if del = !now.Before(until); del {
+			log.Info("clients: protection for %q is resumed after pause", key)
+		}
+
+		return del
+	})
+
+	return clients.pausedUntil
+}
+
+// applyPause disables the protection in setts, if it's paused for the client
+// with setts and clientID at now.
+func (clients *clientsContainer) applyPause(
+	setts *filtering.Settings,
+	clientID string,
+	now time.Time,
+) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	pauses := clients.pausesLocked(now)
+	if len(pauses) == 0 {
+		return
+	}
+
+	keys := []string{setts.ClientName, clientID}
+	if setts.ClientIP.IsValid() {
+		keys = append(keys, setts.ClientIP.Unmap().String())
+	}
+
+	for _, k := range keys {
+		if _, ok := pauses[k]; ok && k != "" {
+			setts.ProtectionEnabled = false
+
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package home
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsContainer_pause(t *testing.T) {
+	clients := newClientsContainer(t)
+
+	ok, err := clients.Add(&Client{
+		Name: "laptop",
+		IDs:  []string{"192.0.2.1"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	now := time.Now()
+	require.NoError(t, clients.pause("laptop", now.Add(time.Hour)))
+	require.NoError(t, clients.pause("::ffff:192.0.2.2", now.Add(time.Minute)))
+	require.NoError(t, clients.pause("cid", now.Add(time.Minute)))
+
+	testCases := []struct {
+		setts    *filtering.Settings
+		want     assert.BoolAssertionFunc
+		name     string
+		clientID string
+		now      time.Time
+	}{{
+		setts:    &filtering.Settings{ClientName: "laptop"},
+		want:     assert.False,
+		name:     "name",
+		clientID: "",
+		now:      now,
+	}, {
+		setts:    &filtering.Settings{ClientIP: netip.MustParseAddr("192.0.2.2")},
+		want:     assert.False,
+		name:     "ip",
+		clientID: "",
+		now:      now,
+	}, {
+		setts:    &filtering.Settings{},
+		want:     assert.False,
+		name:     "client_id",
+		clientID: "cid",
+		now:      now,
+	}, {
+		setts:    &filtering.Settings{ClientIP: netip.MustParseAddr("192.0.2.3")},
+		want:     assert.True,
+		name:     "other",
+		clientID: "",
+		now:      now,
+	}, {
+		setts:    &filtering.Settings{ClientIP: netip.MustParseAddr("192.0.2.2")},
+		want:     assert.True,
+		name:     "expired",
+		clientID: "",
+		now:      now.Add(2 * time.Minute),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.setts.ProtectionEnabled = true
+			clients.applyPause(tc.setts, tc.clientID, tc.now)
+
+			tc.want(t, tc.setts.ProtectionEnabled)
+		})
+	}
+
+	pauses := clients.pauses(now)
+	require.Len(t, pauses, 1)
+
+	assert.Equal(t, "laptop", pauses[0].ID)
+
+	require.NoError(t, clients.resume("laptop"))
+	assert.Empty(t, clients.pauses(now))
+
+	testutil.AssertErrorMsg(t, `client "laptop": protection isn't paused`, clients.resume("laptop"))
+	testutil.AssertErrorMsg(
+		t,
+		`client "bad id!": not found`,
+		clients.pause("bad id!", now.Add(time.Minute)),
+	)
+}
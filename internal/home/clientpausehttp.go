@@ -0,0 +1,121 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"golang.org/x/exp/slices"
+)
+
+// clientPauseJSON is the JSON structure for the pause of the protection for a
+// client.
+type clientPauseJSON struct {
+	// Until is the time, when the protection is automatically resumed.
+	Until time.Time `json:"until"`
+
+	// ID is the name of the persistent client, the IP address, or the
+	// ClientID of the client.
+	ID string `json:"id"`
+}
+
+// clientPausesJSON is the JSON structure for the list of the pauses of the
+// protection.
+type clientPausesJSON struct {
+	Pauses []*clientPauseJSON `json:"pauses"`
+}
+
+// handleGetPauses is the handler for the GET /control/clients/pauses HTTP API.
+func (clients *clientsContainer) handleGetPauses(w http.ResponseWriter, r *http.Request) {
+	resp := &clientPausesJSON{
+		Pauses: clients.pauses(time.Now()),
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// pauses returns the current pauses of the protection sorted by the time of
+// their end.
+func (clients *clientsContainer) pauses(now time.Time) (pauses []*clientPauseJSON) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	pauses = []*clientPauseJSON{}
+	for id, until := range clients.pausesLocked(now) {
+		pauses = append(pauses, &clientPauseJSON{
+			Until: until,
+			ID:    id,
+		})
+	}
+
+	slices.SortFunc(pauses, func(a, b *clientPauseJSON) (res int) {
+		return a.Until.Compare(b.Until)
+	})
+
+	return pauses
+}
+
+// clientPauseReqJSON is the JSON structure for the request to pause the
+// protection for a client.
+type clientPauseReqJSON struct {
+	// ID is the name of the persistent client, the IP address, or the
+	// ClientID of the client.
+	ID string `json:"id"`
+
+	// Duration is the duration of the pause in milliseconds.
+	Duration uint `json:"duration"`
+}
+
+// handlePause is the handler for the POST /control/clients/pause HTTP API.
+func (clients *clientsContainer) handlePause(w http.ResponseWriter, r *http.Request) {
+	req := &clientPauseReqJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	} else if req.Duration == 0 {
+		aghhttp.Error(r, w, http.StatusBadRequest, "duration must be positive")
+
+		return
+	}
+
+	until := time.Now().Add(time.Duration(req.Duration) * time.Millisecond)
+	err = clients.pause(req.ID, until)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "pausing protection: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// clientResumeReqJSON is the JSON structure for the request to resume the
+// protection for a client.
+type clientResumeReqJSON struct {
+	// ID is the name of the persistent client, the IP address, or the
+	// ClientID of the client.
+	ID string `json:"id"`
+}
+
+// handleResume is the handler for the POST /control/clients/resume HTTP API.
+func (clients *clientsContainer) handleResume(w http.ResponseWriter, r *http.Request) {
+	req := &clientResumeReqJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = clients.resume(req.ID)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "resuming protection: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
@@ -0,0 +1,210 @@
+package home
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// maxPendingClients is the maximum number of the quarantined clients waiting
+// for the approval.  The clients seen after that are quarantined without being
+// listed.
+const maxPendingClients = 1000
+
+// quarantineConfig is the configuration of the quarantine of the previously
+// unseen clients.
+type quarantineConfig struct {
+	// Group is the name of the client group, which settings are applied to the
+	// quarantined clients.  If empty, only AllowlistOnly is applied.
+	Group string `yaml:"group"`
+
+	// Approved are the MAC and IP addresses of the approved clients.
+	Approved []string `yaml:"approved"`
+
+	// Enabled, if true, enables the quarantine.
+	Enabled bool `yaml:"enabled"`
+
+	// AllowlistOnly, if true, means that only the hosts explicitly allowed by
+	// the allowlists or the allowlist rules are resolved for the quarantined
+	// clients.
+	AllowlistOnly bool `yaml:"allowlist_only"`
+}
+
+// pendingClient is a quarantined client waiting for the approval.
+type pendingClient struct {
+	// FirstSeen is the time of the first request of the client.
+	FirstSeen time.Time `json:"first_seen"`
+
+	// ID is the MAC address of the client, if known, or its IP address.  It's
+	// used for the approval.
+	ID string `json:"id"`
+
+	// MAC is the MAC address of the client, if known.
+	MAC string `json:"mac,omitempty"`
+
+	// Name is the runtime name of the client, if known.
+	Name string `json:"name,omitempty"`
+
+	// IP is the IP address of the client.
+	IP netip.Addr `json:"ip"`
+}
+
+// setQuarantine validates and sets the quarantine configuration.  conf must
+// not be nil.
+func (clients *clientsContainer) setQuarantine(conf *quarantineConfig) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if conf.Group != "" && clients.groupIndexLocked(conf.Group) < 0 {
+		return fmt.Errorf("quarantine: no group %q", conf.Group)
+	}
+
+	c := *conf
+	c.Approved = slices.Clone(conf.Approved)
+
+	clients.quarantine = &c
+	clients.approved = stringutil.NewSet(c.Approved...)
+	clients.pending = map[string]*pendingClient{}
+
+	return nil
+}
+
+// applyQuarantine quarantines the client with ip, which isn't a persistent one,
+// unless it's been approved, and applies the quarantine settings to setts.  A
+// notification is sent about each newly quarantined client.
+func (clients *clientsContainer) applyQuarantine(
+	setts *filtering.Settings,
+	ip netip.Addr,
+	now time.Time,
+) (quarantined bool) {
+	var pc *pendingClient
+	defer func() {
+		if pc != nil {
+			notifyQuarantined(pc)
+		}
+	}()
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if clients.quarantine == nil || !clients.quarantine.Enabled {
+		return false
+	}
+
+	id, mac := ip.String(), ""
+	if hwAddr := clients.dhcp.MACByIP(ip); hwAddr != nil {
+		mac = hwAddr.String()
+		id = mac
+	}
+
+	if clients.approved.Has(id) || clients.approved.Has(ip.String()) {
+		return false
+	}
+
+	if _, ok := clients.pending[id]; !ok && len(clients.pending) < maxPendingClients {
+		pc = &pendingClient{
+			FirstSeen: now,
+			ID:        id,
+			MAC:       mac,
+			IP:        ip,
+		}
+
+		if rc, rcOK := clients.ipToRC[ip]; rcOK {
+			pc.Name = rc.Host
+		}
+
+		clients.pending[id] = pc
+	}
+
+	if i := clients.groupIndexLocked(clients.quarantine.Group); i >= 0 {
+		clients.groups[i].apply(setts, now)
+	}
+
+	if clients.quarantine.AllowlistOnly {
+		setts.AllowlistOnly = true
+	}
+
+	return true
+}
+
+// notifyQuarantined sends the notification about the newly quarantined client
+// pc.
+func notifyQuarantined(pc *pendingClient) {
+	notifier().Notify(&notify.Event{
+		Time:    pc.FirstSeen,
+		Type:    notify.EventClientQuarantined,
+		Message: fmt.Sprintf("client %s quarantined until approved", pc.ID),
+		Details: map[string]string{
+			"id":  pc.ID,
+			"ip":  pc.IP.String(),
+			"mac": pc.MAC,
+		},
+	})
+}
+
+// pendingClients returns the quarantined clients waiting for the approval
+// sorted by the time they were first seen.
+func (clients *clientsContainer) pendingClients() (pcs []*pendingClient) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	pcs = maps.Values(clients.pending)
+	slices.SortFunc(pcs, func(a, b *pendingClient) (res int) {
+		return a.FirstSeen.Compare(b.FirstSeen)
+	})
+
+	return pcs
+}
+
+// approve approves the quarantined clients with ids.  It returns an error if
+// any of them isn't pending.
+func (clients *clientsContainer) approve(ids []string) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if clients.quarantine == nil {
+		return errors.Error("quarantine is not configured")
+	}
+
+	for _, id := range ids {
+		if _, ok := clients.pending[id]; !ok {
+			return fmt.Errorf("client %q is not pending", id)
+		}
+	}
+
+	for _, id := range ids {
+		delete(clients.pending, id)
+		if !clients.approved.Has(id) {
+			clients.approved.Add(id)
+			clients.quarantine.Approved = append(clients.quarantine.Approved, id)
+		}
+
+		log.Info("clients: approved quarantined client %q", id)
+	}
+
+	return nil
+}
+
+// quarantineForConfig returns a copy of the quarantine configuration to be
+// saved in the configuration file.
+func (clients *clientsContainer) quarantineForConfig() (conf *quarantineConfig) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if clients.quarantine == nil {
+		return nil
+	}
+
+	c := *clients.quarantine
+	c.Approved = slices.Clone(clients.quarantine.Approved)
+
+	return &c
+}
@@ -0,0 +1,93 @@
+package home
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsContainer_applyQuarantine(t *testing.T) {
+	mac := net.HardwareAddr{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	macIP := netip.MustParseAddr("192.168.1.10")
+	ip := netip.MustParseAddr("192.168.1.2")
+	approvedIP := netip.MustParseAddr("192.168.1.3")
+
+	clients := newClientsContainer(t)
+	clients.dhcp = &testDHCP{
+		OnHostBy: func(ip netip.Addr) (host string) { return "" },
+		OnMACBy: func(ip netip.Addr) (m net.HardwareAddr) {
+			if ip == macIP {
+				return mac
+			}
+
+			return nil
+		},
+	}
+
+	enabled := true
+	err := clients.addGroups([]*clientGroup{{
+		ParentalEnabled: &enabled,
+		Name:            "quarantine",
+		Clients:         []string{"192.0.2.0/24"},
+	}})
+	require.NoError(t, err)
+
+	err = clients.setQuarantine(&quarantineConfig{
+		Group: "unknown",
+	})
+	testutil.AssertErrorMsg(t, `quarantine: no group "unknown"`, err)
+
+	err = clients.setQuarantine(&quarantineConfig{
+		Group:         "quarantine",
+		Approved:      []string{approvedIP.String()},
+		Enabled:       true,
+		AllowlistOnly: true,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	t.Run("approved", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		assert.False(t, clients.applyQuarantine(setts, approvedIP, now))
+		assert.False(t, setts.AllowlistOnly)
+	})
+
+	t.Run("quarantined", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		assert.True(t, clients.applyQuarantine(setts, ip, now))
+		assert.True(t, setts.AllowlistOnly)
+		assert.True(t, setts.ParentalEnabled)
+
+		setts = &filtering.Settings{}
+		assert.True(t, clients.applyQuarantine(setts, macIP, now.Add(time.Second)))
+
+		pcs := clients.pendingClients()
+		require.Len(t, pcs, 2)
+
+		assert.Equal(t, ip.String(), pcs[0].ID)
+		assert.Equal(t, mac.String(), pcs[1].ID)
+		assert.Equal(t, macIP, pcs[1].IP)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		err = clients.approve([]string{"192.0.2.1"})
+		testutil.AssertErrorMsg(t, `client "192.0.2.1" is not pending`, err)
+
+		err = clients.approve([]string{mac.String()})
+		require.NoError(t, err)
+
+		setts := &filtering.Settings{}
+		assert.False(t, clients.applyQuarantine(setts, macIP, now))
+		assert.Len(t, clients.pendingClients(), 1)
+
+		conf := clients.quarantineForConfig()
+		assert.Equal(t, []string{approvedIP.String(), mac.String()}, conf.Approved)
+	})
+}
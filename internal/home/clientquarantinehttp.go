@@ -0,0 +1,51 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// pendingClientsJSON is the JSON structure for the list of the quarantined
+// clients waiting for the approval.
+type pendingClientsJSON struct {
+	Clients []*pendingClient `json:"clients"`
+}
+
+// handleGetPending is the handler for the GET /control/clients/pending HTTP
+// API.
+func (clients *clientsContainer) handleGetPending(w http.ResponseWriter, r *http.Request) {
+	resp := &pendingClientsJSON{
+		Clients: clients.pendingClients(),
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// approvePendingJSON is the JSON structure for the request to approve the
+// quarantined clients.
+type approvePendingJSON struct {
+	IDs []string `json:"ids"`
+}
+
+// handleApprovePending is the handler for the POST
+// /control/clients/pending/approve HTTP API.
+func (clients *clientsContainer) handleApprovePending(w http.ResponseWriter, r *http.Request) {
+	req := &approvePendingJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = clients.approve(req.IDs)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "approving clients: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
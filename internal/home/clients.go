@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -12,9 +13,12 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/fingerprint"
 	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
 	"github.com/AdguardTeam/dnsproxy/proxy"
@@ -55,6 +59,34 @@ type clientsContainer struct {
 
 	allTags *stringutil.Set
 
+	// tagUpstreams are the upstream servers for the clients with the tags by
+	// the tag.
+	tagUpstreams map[string]*tagUpstreams
+
+	// groups are the client groups in the order of decreasing precedence.
+	groups []*clientGroup
+
+	// quarantine is the configuration of the quarantine of the previously
+	// unseen clients.  It's nil if the quarantine isn't configured.
+	quarantine *quarantineConfig
+
+	// approved are the IDs of the clients approved to leave the quarantine.
+	approved *stringutil.Set
+
+	// pending are the quarantined clients waiting for the approval by their
+	// IDs.
+	pending map[string]*pendingClient
+
+	// pausedUntil are the ends of the temporary pauses of the protection by the
+	// names of the persistent clients, the IP addresses, and the ClientIDs.
+	// The pauses aren't stored in the configuration file.
+	pausedUntil map[string]time.Time
+
+	// internetPauses are the temporary pauses of the internet access of the
+	// clients and the groups.  The pauses aren't stored in the configuration
+	// file.
+	internetPauses []*internetPause
+
 	// dhcp is the DHCP service implementation.
 	dhcp DHCP
 
@@ -68,6 +100,15 @@ type clientsContainer struct {
 	// arpDB stores the neighbors retrieved from ARP.
 	arpDB arpdb.Interface
 
+	// mdns listens to the mDNS announcements of the devices on the local
+	// network.  It is nil if the mDNS source is disabled or the listener
+	// couldn't be started.
+	mdns *discovery.Listener
+
+	// fingerprints are used to guess the device types of the clients.  It is
+	// nil if the fingerprint source is disabled.
+	fingerprints *fingerprint.Database
+
 	// lock protects all fields.
 	//
 	// TODO(a.garipov): Use a pointer and describe which fields are protected in
@@ -93,6 +134,8 @@ type clientsContainer struct {
 // Note: this function must be called only once
 func (clients *clientsContainer) Init(
 	objects []*clientObject,
+	tagObjects []*tagUpstreamsObject,
+	groups []*clientGroup,
 	dhcpServer DHCP,
 	etcHosts *aghnet.HostsContainer,
 	arpDB arpdb.Interface,
@@ -105,9 +148,16 @@ func (clients *clientsContainer) Init(
 	clients.list = make(map[string]*Client)
 	clients.idIndex = make(map[string]*Client)
 	clients.ipToRC = map[netip.Addr]*RuntimeClient{}
+	clients.pausedUntil = map[string]time.Time{}
 
 	clients.allTags = stringutil.NewSet(clientTags...)
 
+	err = clients.addTagUpstreams(tagObjects)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	// TODO(e.burkov):  Use [dhcpsvc] implementation when it's ready.
 	clients.dhcp = dhcpServer
 
@@ -122,6 +172,12 @@ func (clients *clientsContainer) Init(
 	clients.safeSearchCacheSize = filteringConf.SafeSearchCacheSize
 	clients.safeSearchCacheTTL = time.Minute * time.Duration(filteringConf.CacheTime)
 
+	err = clients.addGroups(groups)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	if clients.testing {
 		return nil
 	}
@@ -157,6 +213,27 @@ func (clients *clientsContainer) Start() {
 	}
 
 	go clients.periodicUpdate()
+
+	if config.Clients.Sources.MDNS && clients.mdns == nil {
+		clients.startMDNS()
+	}
+}
+
+// startMDNS starts listening to the mDNS announcements.  The errors are only
+// logged, since the port may be occupied by the system mDNS responder.
+func (clients *clientsContainer) startMDNS() {
+	l, err := discovery.NewListener(&discovery.ListenerConfig{
+		Updater:  clients,
+		QueryIvl: discovery.DefaultQueryIvl,
+	})
+	if err != nil {
+		log.Error("clients: starting mdns listener: %s", err)
+
+		return
+	}
+
+	clients.mdns = l
+	l.Start()
 }
 
 // reloadARP reloads runtime clients from ARP, if configured.
@@ -179,6 +256,30 @@ type clientObject struct {
 	Tags      []string `yaml:"tags"`
 	Upstreams []string `yaml:"upstreams"`
 
+	// BootstrapDNS are the bootstrap DNS servers used to resolve the hostnames
+	// of the client's upstream servers.
+	BootstrapDNS []string `yaml:"bootstrap_dns"`
+
+	// MinTTL is the minimum TTL of the records in the responses to the
+	// client, in seconds.
+	MinTTL uint32 `yaml:"min_ttl"`
+
+	// Ratelimit is the maximum number of requests per second from the client.
+	// Zero means no limit.
+	Ratelimit uint32 `yaml:"ratelimit"`
+
+	// RatelimitBurst is the maximum number of requests, which the client may
+	// send at once.  Zero means that Ratelimit is used.
+	RatelimitBurst uint32 `yaml:"ratelimit_burst"`
+
+	// RatelimitDrop is true if the requests above the limit are dropped
+	// instead of being responded with REFUSED.
+	RatelimitDrop bool `yaml:"ratelimit_drop"`
+
+	// BlockedCategories are the names of the domain categories blocked for
+	// the client, if UseOwnBlockedCategories is true.
+	BlockedCategories []string `yaml:"blocked_categories"`
+
 	UseGlobalSettings        bool `yaml:"use_global_settings"`
 	FilteringEnabled         bool `yaml:"filtering_enabled"`
 	ParentalEnabled          bool `yaml:"parental_enabled"`
@@ -187,6 +288,14 @@ type clientObject struct {
 
 	IgnoreQueryLog   bool `yaml:"ignore_querylog"`
 	IgnoreStatistics bool `yaml:"ignore_statistics"`
+
+	// UseOwnBlockedCategories is true if the client uses BlockedCategories
+	// instead of the globally blocked domain categories.
+	UseOwnBlockedCategories bool `yaml:"use_own_blocked_categories"`
+
+	// AllowlistOnly is true if all hosts, which aren't explicitly allowed, are
+	// blocked for the client.
+	AllowlistOnly bool `yaml:"allowlist_only"`
 }
 
 // addFromConfig initializes the clients container with objects from the
@@ -199,8 +308,14 @@ func (clients *clientsContainer) addFromConfig(
 		cli := &Client{
 			Name: o.Name,
 
-			IDs:       o.IDs,
-			Upstreams: o.Upstreams,
+			IDs:          o.IDs,
+			Upstreams:    o.Upstreams,
+			BootstrapDNS: o.BootstrapDNS,
+			MinTTL:       o.MinTTL,
+
+			Ratelimit:      o.Ratelimit,
+			RatelimitBurst: o.RatelimitBurst,
+			RatelimitDrop:  o.RatelimitDrop,
 
 			UseOwnSettings:        !o.UseGlobalSettings,
 			FilteringEnabled:      o.FilteringEnabled,
@@ -210,6 +325,10 @@ func (clients *clientsContainer) addFromConfig(
 			UseOwnBlockedServices: !o.UseGlobalBlockedServices,
 			IgnoreQueryLog:        o.IgnoreQueryLog,
 			IgnoreStatistics:      o.IgnoreStatistics,
+
+			BlockedCategories:       o.BlockedCategories,
+			UseOwnBlockedCategories: o.UseOwnBlockedCategories,
+			AllowlistOnly:           o.AllowlistOnly,
 		}
 
 		if o.SafeSearchConf.Enabled {
@@ -232,6 +351,11 @@ func (clients *clientsContainer) addFromConfig(
 			return fmt.Errorf("clients: init client blocked services %q: %w", cli.Name, err)
 		}
 
+		err = filtering.ValidateCategories(o.BlockedCategories)
+		if err != nil {
+			return fmt.Errorf("clients: init client blocked categories %q: %w", cli.Name, err)
+		}
+
 		cli.BlockedServices = o.BlockedServices.Clone()
 
 		for _, t := range o.Tags {
@@ -253,6 +377,71 @@ func (clients *clientsContainer) addFromConfig(
 	return nil
 }
 
+// tagUpstreamsObject is the YAML representation of the upstream servers for
+// the persistent clients with a tag.
+type tagUpstreamsObject struct {
+	// Tag is the client tag.
+	Tag string `yaml:"tag"`
+
+	// Upstreams are the upstream servers for the clients with the tag.
+	Upstreams []string `yaml:"upstreams"`
+
+	// BootstrapDNS are the bootstrap DNS servers used to resolve the hostnames
+	// of Upstreams.
+	BootstrapDNS []string `yaml:"bootstrap_dns"`
+}
+
+// tagUpstreams are the upstream servers for the persistent clients with a tag.
+type tagUpstreams struct {
+	// upstreamConfig is the upstream config parsed from upstreams.  If it's
+	// nil, it has not been initialized yet.
+	upstreamConfig *proxy.UpstreamConfig
+
+	// upstreams are the upstream servers without comments and empty lines.
+	upstreams []string
+
+	// bootstrapDNS are the bootstrap DNS servers for upstreams.
+	bootstrapDNS []string
+}
+
+// addTagUpstreams initializes the upstream servers of the tags with objects
+// from the configuration file.  The upstreams for the tags are used in the
+// order of the tags of a client, which is alphabetical.
+func (clients *clientsContainer) addTagUpstreams(objects []*tagUpstreamsObject) (err error) {
+	clients.tagUpstreams = make(map[string]*tagUpstreams, len(objects))
+	for i, o := range objects {
+		if !clients.allTags.Has(o.Tag) {
+			return fmt.Errorf("clients: tag upstreams at index %d: invalid tag: %q", i, o.Tag)
+		}
+
+		if _, ok := clients.tagUpstreams[o.Tag]; ok {
+			return fmt.Errorf("clients: tag upstreams at index %d: duplicate tag: %q", i, o.Tag)
+		}
+
+		err = dnsforward.ValidateUpstreams(o.Upstreams)
+		if err != nil {
+			return fmt.Errorf("clients: tag upstreams for %q: invalid upstream servers: %w", o.Tag, err)
+		}
+
+		err = dnsforward.ValidateBootstraps(o.BootstrapDNS)
+		if err != nil {
+			return fmt.Errorf("clients: tag upstreams for %q: invalid bootstrap servers: %w", o.Tag, err)
+		}
+
+		upstreams := stringutil.FilterOut(o.Upstreams, dnsforward.IsCommentOrEmpty)
+		if len(upstreams) == 0 {
+			continue
+		}
+
+		clients.tagUpstreams[o.Tag] = &tagUpstreams{
+			upstreams:    upstreams,
+			bootstrapDNS: o.BootstrapDNS,
+		}
+	}
+
+	return nil
+}
+
 // forConfig returns all currently known persistent clients as objects for the
 // configuration file.
 func (clients *clientsContainer) forConfig() (objs []*clientObject) {
@@ -266,9 +455,15 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 
 			BlockedServices: cli.BlockedServices.Clone(),
 
-			IDs:       stringutil.CloneSlice(cli.IDs),
-			Tags:      stringutil.CloneSlice(cli.Tags),
-			Upstreams: stringutil.CloneSlice(cli.Upstreams),
+			IDs:          stringutil.CloneSlice(cli.IDs),
+			Tags:         stringutil.CloneSlice(cli.Tags),
+			Upstreams:    stringutil.CloneSlice(cli.Upstreams),
+			BootstrapDNS: stringutil.CloneSlice(cli.BootstrapDNS),
+			MinTTL:       cli.MinTTL,
+
+			Ratelimit:      cli.Ratelimit,
+			RatelimitBurst: cli.RatelimitBurst,
+			RatelimitDrop:  cli.RatelimitDrop,
 
 			UseGlobalSettings:        !cli.UseOwnSettings,
 			FilteringEnabled:         cli.FilteringEnabled,
@@ -278,6 +473,10 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
 			IgnoreQueryLog:           cli.IgnoreQueryLog,
 			IgnoreStatistics:         cli.IgnoreStatistics,
+
+			BlockedCategories:       stringutil.CloneSlice(cli.BlockedCategories),
+			UseOwnBlockedCategories: cli.UseOwnBlockedCategories,
+			AllowlistOnly:           cli.AllowlistOnly,
 		}
 
 		objs = append(objs, o)
@@ -379,8 +578,9 @@ func (clients *clientsContainer) clientOrArtificial(
 	rc, ok = clients.findRuntimeClient(ip)
 	if ok {
 		return &querylog.Client{
-			Name:  rc.Host,
-			WHOIS: rc.WHOIS,
+			Name:       rc.Host,
+			WHOIS:      rc.WHOIS,
+			DeviceType: string(rc.DeviceType),
 		}, false
 	}
 
@@ -419,23 +619,87 @@ func (clients *clientsContainer) shouldCountClient(ids []string) (y bool) {
 	return true
 }
 
+// blocksService returns true if the service with id is blocked for any of the
+// persistent clients or the client groups.
+func (clients *clientsContainer) blocksService(id string) (ok bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	for _, c := range clients.list {
+		if c.BlockedServices != nil && slices.Contains(c.BlockedServices.IDs, id) {
+			return true
+		}
+	}
+
+	return clients.groupsBlockServiceLocked(id)
+}
+
+// findMinTTL returns the minimum TTL of the responses configured for the
+// client, identified either by its IP address or its ClientID.  ttl is zero if
+// the client isn't found or if it has no minimum TTL.
+func (clients *clientsContainer) findMinTTL(id string) (ttl uint32) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.findLocked(id)
+	if !ok {
+		return 0
+	}
+
+	return c.MinTTL
+}
+
+// findRatelimit returns the rate limit of the persistent client, identified
+// either by its IP address or its ClientID.  lim is nil if the client isn't
+// found or if it has no rate limit.
+func (clients *clientsContainer) findRatelimit(id string) (lim *dnsforward.ClientRatelimit) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.findLocked(id)
+	if !ok || c.Ratelimit == 0 {
+		return nil
+	}
+
+	return &dnsforward.ClientRatelimit{
+		Name:  c.Name,
+		RPS:   c.Ratelimit,
+		Burst: c.RatelimitBurst,
+		Drop:  c.RatelimitDrop,
+	}
+}
+
 // findUpstreams returns upstreams configured for the client, identified either
-// by its IP address or its ClientID.  upsConf is nil if the client isn't found
-// or if the client has no custom upstreams.
+// by its IP address or its ClientID.  The upstreams of the persistent client
+// take precedence over the ones of its groups, which in turn take precedence
+// over the ones of its tags.  upsConf is nil if the client has no custom
+// upstreams.
 func (clients *clientsContainer) findUpstreams(
 	id string,
 ) (upsConf *proxy.UpstreamConfig, err error) {
 	clients.lock.Lock()
 	defer clients.lock.Unlock()
 
+	ip, _ := netip.ParseAddr(id)
+	clientID := ""
+	if !ip.IsValid() {
+		clientID = id
+	}
+
 	c, ok := clients.findLocked(id)
 	if !ok {
-		return nil, nil
+		return clients.findGroupUpstreamsLocked(clients.groupsForLocked(ip, clientID, nil))
 	}
 
 	upstreams := stringutil.FilterOut(c.Upstreams, dnsforward.IsCommentOrEmpty)
 	if len(upstreams) == 0 {
-		return nil, nil
+		groups := clients.groupsForLocked(ip, clientID, c)
+		upsConf, err = clients.findGroupUpstreamsLocked(groups)
+		if upsConf != nil || err != nil {
+			return upsConf, err
+		}
+
+		return clients.findTagUpstreamsLocked(c.Tags)
 	}
 
 	if c.upstreamConfig != nil {
@@ -443,15 +707,7 @@ func (clients *clientsContainer) findUpstreams(
 	}
 
 	var conf *proxy.UpstreamConfig
-	conf, err = proxy.ParseUpstreamsConfig(
-		upstreams,
-		&upstream.Options{
-			Bootstrap:    config.DNS.BootstrapDNS,
-			Timeout:      config.DNS.UpstreamTimeout.Duration,
-			HTTPVersions: dnsforward.UpstreamHTTPVersions(config.DNS.UseHTTP3Upstreams),
-			PreferIPv6:   config.DNS.BootstrapPreferIPv6,
-		},
-	)
+	conf, err = newClientUpstreamConfig(upstreams, c.BootstrapDNS)
 	if err != nil {
 		return nil, err
 	}
@@ -461,6 +717,61 @@ func (clients *clientsContainer) findUpstreams(
 	return conf, nil
 }
 
+// findTagUpstreamsLocked returns the upstreams configured for the first of the
+// tags, which has any.  upsConf is nil if there are no such tags.
+// clients.lock is expected to be locked.
+func (clients *clientsContainer) findTagUpstreamsLocked(
+	tags []string,
+) (upsConf *proxy.UpstreamConfig, err error) {
+	for _, t := range tags {
+		tu, ok := clients.tagUpstreams[t]
+		if !ok {
+			continue
+		}
+
+		if tu.upstreamConfig != nil {
+			return tu.upstreamConfig, nil
+		}
+
+		upsConf, err = newClientUpstreamConfig(tu.upstreams, tu.bootstrapDNS)
+		if err != nil {
+			return nil, fmt.Errorf("tag %q: %w", t, err)
+		}
+
+		tu.upstreamConfig = upsConf
+
+		return upsConf, nil
+	}
+
+	return nil, nil
+}
+
+// newClientUpstreamConfig returns the upstream configuration for upstreams
+// using the bootstrap servers or the global ones, if bootstraps is empty.  The
+// upstreams use the global upstream settings of the DNS server, if there is
+// one.
+func newClientUpstreamConfig(
+	upstreams []string,
+	bootstraps []string,
+) (conf *proxy.UpstreamConfig, err error) {
+	if len(bootstraps) == 0 {
+		bootstraps = config.DNS.BootstrapDNS
+	}
+
+	opts := &upstream.Options{
+		Bootstrap:    bootstraps,
+		Timeout:      config.DNS.UpstreamTimeout.Duration,
+		HTTPVersions: dnsforward.UpstreamHTTPVersions(config.DNS.UseHTTP3Upstreams),
+		PreferIPv6:   config.DNS.BootstrapPreferIPv6,
+	}
+
+	if Context.dnsServer == nil {
+		return dnsforward.ParseUpstreamsConfig(upstreams, opts)
+	}
+
+	return Context.dnsServer.NewClientUpstreamConfig(upstreams, opts)
+}
+
 // findLocked searches for a client by its ID.  clients.lock is expected to be
 // locked.
 func (clients *clientsContainer) findLocked(id string) (c *Client, ok bool) {
@@ -542,11 +853,19 @@ func (clients *clientsContainer) findRuntimeClient(ip netip.Addr) (rc *RuntimeCl
 		return rc, ok
 	}
 
-	return &RuntimeClient{
+	dhcpRC := &RuntimeClient{
 		Host:   host,
 		Source: client.SourceDHCP,
 		WHOIS:  &whois.Info{},
-	}, true
+	}
+
+	if rc != nil {
+		// Keep the hints discovered on the local network.
+		dhcpRC.Model, dhcpRC.DeviceType = rc.Model, rc.DeviceType
+		dhcpRC.Vendor, dhcpRC.OS = rc.Vendor, rc.OS
+	}
+
+	return dhcpRC, true
 }
 
 // check validates the client.
@@ -585,6 +904,11 @@ func (clients *clientsContainer) check(c *Client) (err error) {
 		return fmt.Errorf("invalid upstream servers: %w", err)
 	}
 
+	err = dnsforward.ValidateBootstraps(c.BootstrapDNS)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap servers: %w", err)
+	}
+
 	return nil
 }
 
@@ -677,6 +1001,10 @@ func (clients *clientsContainer) Del(name string) (ok bool) {
 	}
 
 	clients.del(c)
+	delete(clients.pausedUntil, name)
+	clients.internetPauses = slices.DeleteFunc(clients.internetPauses, func(p *internetPause) (ok bool) {
+		return p.ID == name
+	})
 
 	return true
 }
@@ -797,6 +1125,165 @@ func (clients *clientsContainer) UpdateAddress(ip netip.Addr, host string, info
 	}
 }
 
+// discoverySources are the client sources by the discovery methods.
+var discoverySources = map[discovery.Method]client.Source{
+	discovery.MethodNetBIOS: client.SourceNetBIOS,
+	discovery.MethodLLMNR:   client.SourceLLMNR,
+	discovery.MethodMDNS:    client.SourceMDNS,
+}
+
+// type check
+var _ discovery.Updater = (*clientsContainer)(nil)
+
+// UpdateDiscovered implements the [discovery.Updater] and
+// [client.AddressUpdater] interfaces for *clientsContainer.  The device type
+// and the model are kept even if the name from a source with higher priority
+// is already set.
+func (clients *clientsContainer) UpdateDiscovered(ip netip.Addr, info *discovery.Info) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if _, ok := clients.findLocked(ip.String()); ok {
+		return
+	}
+
+	if info.Name != "" {
+		ok := clients.addHostLocked(ip, info.Name, discoverySources[info.Method])
+		if !ok {
+			log.Debug("clients: host for client %q already set with higher priority source", ip)
+		}
+	}
+
+	if info.Model == "" && info.DeviceType == discovery.DeviceTypeNone {
+		return
+	}
+
+	rc, ok := clients.ipToRC[ip]
+	if !ok {
+		// Create a RuntimeClient without a name so that the hints are shown
+		// for the DHCP clients as well.
+		rc = &RuntimeClient{
+			WHOIS:  &whois.Info{},
+			Source: client.SourceNone,
+		}
+		clients.ipToRC[ip] = rc
+	}
+
+	if info.Model != "" {
+		rc.Model = info.Model
+	}
+
+	if info.DeviceType != discovery.DeviceTypeNone {
+		rc.DeviceType = info.DeviceType
+	}
+}
+
+// onDHCPFingerprint guesses the device type of the DHCP client by its
+// fingerprint.
+func (clients *clientsContainer) onDHCPFingerprint(fp *dhcpd.Fingerprint) {
+	if clients.fingerprints == nil || !fp.IP.IsValid() {
+		return
+	}
+
+	d := clients.fingerprints.MatchDHCP(fp.Params, fp.VendorClass)
+	if d == nil {
+		log.Debug("clients: no fingerprint match for %s (%s)", fp.IP, fp.HWAddr)
+
+		return
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	clients.updateFingerprintLocked(fp.IP, d, "")
+}
+
+// onUserAgent guesses the device type of the client with ip by the User-Agent
+// ua of its DoH request.
+func (clients *clientsContainer) onUserAgent(ip netip.Addr, ua string) {
+	if clients.fingerprints == nil {
+		return
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	if rc, ok := clients.ipToRC[ip]; ok && rc.userAgent == ua {
+		return
+	}
+
+	d := clients.fingerprints.MatchUserAgent(ua)
+	if d == nil {
+		return
+	}
+
+	clients.updateFingerprintLocked(ip, d, ua)
+}
+
+// updateFingerprintLocked updates the runtime client with ip with the guess d
+// made by the User-Agent ua, if any.  The device type discovered on the local
+// network isn't overridden, since it's more reliable.  clients.lock is expected
+// to be locked.
+func (clients *clientsContainer) updateFingerprintLocked(
+	ip netip.Addr,
+	d *fingerprint.Device,
+	ua string,
+) {
+	if _, ok := clients.findLocked(ip.String()); ok {
+		return
+	}
+
+	rc, ok := clients.ipToRC[ip]
+	if !ok {
+		rc = &RuntimeClient{
+			WHOIS:  &whois.Info{},
+			Source: client.SourceNone,
+		}
+		clients.ipToRC[ip] = rc
+	}
+
+	if rc.DeviceType == discovery.DeviceTypeNone {
+		rc.DeviceType = d.Type
+	}
+
+	if d.Vendor != "" {
+		rc.Vendor = d.Vendor
+	}
+
+	if d.OS != "" {
+		rc.OS = d.OS
+	}
+
+	if ua != "" {
+		rc.userAgent = ua
+	}
+}
+
+// newFingerprintDB returns the fingerprint database with the rules from the
+// file at path, if any, taking precedence over the built-in ones.
+func newFingerprintDB(path string) (db *fingerprint.Database, err error) {
+	var rules []*fingerprint.Rule
+	if path != "" {
+		var data []byte
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading fingerprints: %w", err)
+		}
+
+		rules, err = fingerprint.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing fingerprints: %w", err)
+		}
+	}
+
+	db, err = fingerprint.New(append(rules, fingerprint.DefaultRules()...))
+	if err != nil {
+		return nil, fmt.Errorf("fingerprints: %w", err)
+	}
+
+	return db, nil
+}
+
 // addHostLocked adds a new IP-hostname pairing.  clients.lock is expected to be
 // locked.
 func (clients *clientsContainer) addHostLocked(
@@ -905,11 +1392,39 @@ func (clients *clientsContainer) close() (err error) {
 
 	var errs []error
 
+	if clients.mdns != nil {
+		if err = clients.mdns.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing mdns listener: %w", err))
+		}
+
+		clients.mdns = nil
+	}
+
 	for _, cli := range persistent {
 		if err = cli.closeUpstreams(); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
+	for _, g := range clients.groups {
+		if err = g.closeUpstreams(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	tags := maps.Keys(clients.tagUpstreams)
+	slices.Sort(tags)
+
+	for _, t := range tags {
+		tu := clients.tagUpstreams[t]
+		if tu.upstreamConfig == nil {
+			continue
+		}
+
+		if err = tu.upstreamConfig.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing upstreams of tag %q: %w", t, err))
+		}
+	}
+
 	return errors.Join(errs...)
 }
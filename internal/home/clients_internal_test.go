@@ -10,7 +10,9 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/fingerprint"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -46,7 +48,7 @@ func newClientsContainer(t *testing.T) (c *clientsContainer) {
 		OnMACBy:  func(ip netip.Addr) (mac net.HardwareAddr) { return nil },
 	}
 
-	require.NoError(t, c.Init(nil, dhcp, nil, nil, &filtering.Config{}))
+	require.NoError(t, c.Init(nil, nil, nil, dhcp, nil, nil, &filtering.Config{}))
 
 	return c
 }
@@ -268,6 +270,145 @@ func TestClientsWHOIS(t *testing.T) {
 	})
 }
 
+func TestClientsContainer_UpdateDiscovered(t *testing.T) {
+	clients := newClientsContainer(t)
+
+	t.Run("new_client", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.2")
+		clients.UpdateDiscovered(ip, &discovery.Info{
+			Name:       "johns-iphone",
+			Model:      "iPhone14,2",
+			DeviceType: discovery.DeviceTypePhone,
+			Method:     discovery.MethodMDNS,
+		})
+
+		rc, ok := clients.findRuntimeClient(ip)
+		require.True(t, ok)
+
+		assert.Equal(t, "johns-iphone", rc.Host)
+		assert.Equal(t, client.SourceMDNS, rc.Source)
+		assert.Equal(t, "iPhone14,2", rc.Model)
+		assert.Equal(t, discovery.DeviceTypePhone, rc.DeviceType)
+	})
+
+	t.Run("keeps_higher_priority_name", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.3")
+		ok := clients.addHost(ip, "from_hosts", client.SourceHostsFile)
+		require.True(t, ok)
+
+		clients.UpdateDiscovered(ip, &discovery.Info{
+			Name:       "printer",
+			DeviceType: discovery.DeviceTypePrinter,
+			Method:     discovery.MethodMDNS,
+		})
+
+		rc, ok := clients.findRuntimeClient(ip)
+		require.True(t, ok)
+
+		assert.Equal(t, "from_hosts", rc.Host)
+		assert.Equal(t, client.SourceHostsFile, rc.Source)
+		assert.Equal(t, discovery.DeviceTypePrinter, rc.DeviceType)
+	})
+
+	t.Run("replaces_lower_priority_name", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.4")
+		ok := clients.addHost(ip, "from_arp", client.SourceARP)
+		require.True(t, ok)
+
+		clients.UpdateDiscovered(ip, &discovery.Info{
+			Name:   "desktop",
+			Method: discovery.MethodNetBIOS,
+		})
+
+		assert.Equal(t, client.SourceNetBIOS, clients.clientSource(ip))
+	})
+
+	t.Run("persistent", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.5")
+		ok, err := clients.Add(&Client{
+			IDs:  []string{ip.String()},
+			Name: "client1",
+		})
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		clients.UpdateDiscovered(ip, &discovery.Info{
+			Name:   "laptop",
+			Method: discovery.MethodLLMNR,
+		})
+
+		assert.Nil(t, clients.ipToRC[ip])
+	})
+}
+
+func TestClientsContainer_fingerprints(t *testing.T) {
+	clients := newClientsContainer(t)
+
+	var err error
+	clients.fingerprints, err = fingerprint.New([]*fingerprint.Rule{{
+		Device: fingerprint.Device{
+			Type:   discovery.DeviceTypeTV,
+			Vendor: "Samsung",
+		},
+		VendorClass: "samsung",
+	}, {
+		Device: fingerprint.Device{
+			Type: discovery.DeviceTypeConsole,
+			OS:   "PlayStation",
+		},
+		UserAgent: "PlayStation",
+	}})
+	require.NoError(t, err)
+
+	t.Run("dhcp", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.2")
+		clients.onDHCPFingerprint(&dhcpd.Fingerprint{
+			IP:          ip,
+			VendorClass: "Samsung Smart TV",
+		})
+
+		rc, ok := clients.findRuntimeClient(ip)
+		require.True(t, ok)
+
+		assert.Equal(t, discovery.DeviceTypeTV, rc.DeviceType)
+		assert.Equal(t, "Samsung", rc.Vendor)
+	})
+
+	t.Run("user_agent", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.3")
+		clients.onUserAgent(ip, "Mozilla/5.0 (PlayStation 5 3.11)")
+
+		rc, ok := clients.findRuntimeClient(ip)
+		require.True(t, ok)
+
+		assert.Equal(t, discovery.DeviceTypeConsole, rc.DeviceType)
+		assert.Equal(t, "PlayStation", rc.OS)
+	})
+
+	t.Run("keeps_discovered", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.4")
+		clients.UpdateDiscovered(ip, &discovery.Info{
+			DeviceType: discovery.DeviceTypeMedia,
+			Method:     discovery.MethodMDNS,
+		})
+		clients.onUserAgent(ip, "PlayStation")
+
+		rc, ok := clients.findRuntimeClient(ip)
+		require.True(t, ok)
+
+		assert.Equal(t, discovery.DeviceTypeMedia, rc.DeviceType)
+		assert.Equal(t, "PlayStation", rc.OS)
+	})
+
+	t.Run("no_match", func(t *testing.T) {
+		ip := netip.MustParseAddr("192.168.1.5")
+		clients.onUserAgent(ip, "curl/8.0.0")
+
+		_, ok := clients.findRuntimeClient(ip)
+		assert.False(t, ok)
+	})
+}
+
 func TestClientsAddExisting(t *testing.T) {
 	clients := newClientsContainer(t)
 
@@ -365,3 +506,58 @@ func TestClientsCustomUpstream(t *testing.T) {
 	assert.Len(t, config.Upstreams, 1)
 	assert.Len(t, config.DomainReservedUpstreams, 1)
 }
+
+func TestClientsTagUpstream(t *testing.T) {
+	clients := newClientsContainer(t)
+
+	err := clients.addTagUpstreams([]*tagUpstreamsObject{{
+		Tag:          "device_laptop",
+		Upstreams:    []string{"tls://dns.corp.example"},
+		BootstrapDNS: []string{"9.9.9.9"},
+	}})
+	require.NoError(t, err)
+
+	ok, err := clients.Add(&Client{
+		IDs:  []string{"1.1.1.1"},
+		Name: "laptop",
+		Tags: []string{"device_laptop"},
+	})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = clients.Add(&Client{
+		IDs:          []string{"2.2.2.2"},
+		Name:         "own",
+		Tags:         []string{"device_laptop"},
+		Upstreams:    []string{"1.1.1.1", "8.8.8.8"},
+		BootstrapDNS: []string{"9.9.9.9"},
+	})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	conf, err := clients.findUpstreams("1.1.1.1")
+	require.NoError(t, err)
+	require.NotNil(t, conf)
+	require.Len(t, conf.Upstreams, 1)
+
+	assert.Equal(t, "tls://dns.corp.example:853", conf.Upstreams[0].Address())
+
+	conf, err = clients.findUpstreams("2.2.2.2")
+	require.NoError(t, err)
+	require.NotNil(t, conf)
+
+	assert.Len(t, conf.Upstreams, 2)
+
+	err = clients.addTagUpstreams([]*tagUpstreamsObject{{
+		Tag:       "unknown_tag",
+		Upstreams: []string{"1.1.1.1"},
+	}})
+	assert.EqualError(t, err, `clients: tag upstreams at index 0: invalid tag: "unknown_tag"`)
+
+	_, err = clients.Add(&Client{
+		IDs:          []string{"3.3.3.3"},
+		Name:         "bad_bootstrap",
+		BootstrapDNS: []string{""},
+	})
+	assert.EqualError(t, err, "invalid bootstrap servers: checking bootstrap : invalid address: empty")
+}
@@ -0,0 +1,242 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/errors"
+	"golang.org/x/exp/slices"
+)
+
+// blockedServicesDocVersion is the current version of the format of the
+// blocked services document.
+const blockedServicesDocVersion uint = 1
+
+// blockedServicesDocJSON is the portable JSON document with the blocked
+// services settings of persistent clients.
+type blockedServicesDocJSON struct {
+	// Clients are the blocked services settings of the clients.
+	Clients []*blockedServicesDocClientJSON `json:"clients"`
+
+	// Version is the version of the format of the document.
+	Version uint `json:"version"`
+}
+
+// blockedServicesDocClientJSON is the blocked services settings of a single
+// client in the portable JSON document.
+type blockedServicesDocClientJSON struct {
+	// Schedule is the schedule, during which the services aren't blocked.
+	Schedule *schedule.Weekly `json:"schedule"`
+
+	// Name is the name of the client.
+	Name string `json:"name"`
+
+	// IDs are the IDs of the blocked services.
+	IDs []string `json:"ids"`
+
+	// UseGlobalBlockedServices is true if the client uses the global blocked
+	// services settings instead of the ones above.
+	UseGlobalBlockedServices bool `json:"use_global_blocked_services"`
+}
+
+// validate returns an error if the document is invalid.
+func (doc *blockedServicesDocJSON) validate() (err error) {
+	if doc.Version != blockedServicesDocVersion {
+		return fmt.Errorf("unsupported version %d, want %d", doc.Version, blockedServicesDocVersion)
+	}
+
+	names := map[string]struct{}{}
+	for i, c := range doc.Clients {
+		if c == nil {
+			return fmt.Errorf("client at index %d: %w", i, errors.Error("no value"))
+		}
+
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("client at index %d: duplicate name %q", i, c.Name)
+		}
+
+		names[c.Name] = struct{}{}
+
+		if c.Schedule == nil {
+			return fmt.Errorf("client %q: no schedule", c.Name)
+		}
+
+		err = (&filtering.BlockedServices{IDs: c.IDs}).Validate()
+		if err != nil {
+			return fmt.Errorf("client %q: %w", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// handleExportBlockedServices is the handler for the GET
+// /control/clients/blocked_services/export HTTP API.  The "name" query
+// parameters, if any, select the exported clients.  All persistent clients are
+// exported otherwise.
+func (clients *clientsContainer) handleExportBlockedServices(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+
+	doc := &blockedServicesDocJSON{
+		Clients: []*blockedServicesDocClientJSON{},
+		Version: blockedServicesDocVersion,
+	}
+
+	err := func() (err error) {
+		clients.lock.Lock()
+		defer clients.lock.Unlock()
+
+		if len(names) == 0 {
+			for name := range clients.list {
+				names = append(names, name)
+			}
+
+			slices.Sort(names)
+		}
+
+		for _, name := range names {
+			c, ok := clients.list[name]
+			if !ok {
+				return fmt.Errorf("client %q: %w", name, errNotFound)
+			}
+
+			doc.Clients = append(doc.Clients, blockedServicesToDoc(c))
+		}
+
+		return nil
+	}()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "exporting blocked services: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, doc)
+}
+
+// blockedServicesToDoc returns the blocked services settings of c for the
+// portable JSON document.
+func blockedServicesToDoc(c *Client) (dc *blockedServicesDocClientJSON) {
+	dc = &blockedServicesDocClientJSON{
+		Schedule:                 schedule.EmptyWeekly(),
+		Name:                     c.Name,
+		IDs:                      []string{},
+		UseGlobalBlockedServices: !c.UseOwnBlockedServices,
+	}
+
+	if bs := c.BlockedServices; bs != nil {
+		if bs.Schedule != nil {
+			dc.Schedule = bs.Schedule.Clone()
+		}
+
+		dc.IDs = append(dc.IDs, bs.IDs...)
+	}
+
+	return dc
+}
+
+// blockedServicesImportJSON is the JSON structure for the request to import
+// the portable blocked services document.
+type blockedServicesImportJSON struct {
+	// Document is the document to import.
+	Document *blockedServicesDocJSON `json:"document"`
+
+	// Targets, if not empty, are the names of the clients, to which the only
+	// client settings from the document are copied.  Otherwise, the settings
+	// are imported into the clients with the same names.
+	Targets []string `json:"targets"`
+}
+
+// handleImportBlockedServices is the handler for the POST
+// /control/clients/blocked_services/import HTTP API.  Nothing is imported if
+// the document or any of the targets are invalid.
+func (clients *clientsContainer) handleImportBlockedServices(w http.ResponseWriter, r *http.Request) {
+	req := &blockedServicesImportJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	} else if req.Document == nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no document")
+
+		return
+	}
+
+	err = req.Document.validate()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "validating document: %s", err)
+
+		return
+	}
+
+	err = clients.importBlockedServices(req.Document, req.Targets)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "importing blocked services: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// importBlockedServices applies the blocked services settings from doc, which
+// must be valid, to the persistent clients.  See
+// [blockedServicesImportJSON.Targets] for targets.
+func (clients *clientsContainer) importBlockedServices(
+	doc *blockedServicesDocJSON,
+	targets []string,
+) (err error) {
+	settings := map[string]*blockedServicesDocClientJSON{}
+	if len(targets) > 0 {
+		if len(doc.Clients) != 1 {
+			return fmt.Errorf(
+				"copying to targets requires exactly one client in document, got %d",
+				len(doc.Clients),
+			)
+		}
+
+		for _, name := range targets {
+			settings[name] = doc.Clients[0]
+		}
+	} else {
+		for _, dc := range doc.Clients {
+			settings[dc.Name] = dc
+		}
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	var notFound []string
+	for name := range settings {
+		if _, ok := clients.list[name]; !ok {
+			notFound = append(notFound, name)
+		}
+	}
+
+	if len(notFound) > 0 {
+		slices.Sort(notFound)
+
+		return fmt.Errorf("clients %s: %w", strings.Join(notFound, ", "), errNotFound)
+	}
+
+	for name, dc := range settings {
+		c := clients.list[name]
+
+		// Replace the settings instead of modifying them, since the shallow
+		// clones of the client share them.
+		c.BlockedServices = &filtering.BlockedServices{
+			Schedule: dc.Schedule.Clone(),
+			IDs:      slices.Clone(dc.IDs),
+		}
+		c.UseOwnBlockedServices = !dc.UseGlobalBlockedServices
+	}
+
+	return nil
+}
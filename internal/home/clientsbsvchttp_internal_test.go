@@ -0,0 +1,137 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsContainer_blockedServicesDoc(t *testing.T) {
+	filtering.InitModule()
+
+	clients := newClientsContainer(t)
+
+	weekly := &schedule.Weekly{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"time_zone": "Europe/Berlin",
+		"mon": {"start": 75600000, "end": 86400000}
+	}`), weekly))
+
+	for _, c := range []*Client{{
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: weekly,
+			IDs:      []string{"youtube"},
+		},
+		Name:                  "source",
+		IDs:                   []string{"192.0.2.1"},
+		UseOwnBlockedServices: true,
+	}, {
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+		},
+		Name: "target",
+		IDs:  []string{"192.0.2.2"},
+	}} {
+		ok, err := clients.Add(c)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/?name=source", nil)
+	clients.handleExportBlockedServices(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	doc := &blockedServicesDocJSON{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(doc))
+	require.NoError(t, doc.validate())
+	require.Len(t, doc.Clients, 1)
+
+	assert.Equal(t, "source", doc.Clients[0].Name)
+
+	t.Run("copy", func(t *testing.T) {
+		require.NoError(t, clients.importBlockedServices(doc, []string{"target"}))
+
+		c, ok := clients.Find("192.0.2.2")
+		require.True(t, ok)
+
+		assert.True(t, c.UseOwnBlockedServices)
+		assert.Equal(t, []string{"youtube"}, c.BlockedServices.IDs)
+		assert.Equal(t, weekly, c.BlockedServices.Schedule)
+	})
+
+	t.Run("unknown_client", func(t *testing.T) {
+		err := clients.importBlockedServices(doc, []string{"target", "unknown"})
+		testutil.AssertErrorMsg(t, "clients unknown: not found", err)
+	})
+
+	t.Run("export_unknown_client", func(t *testing.T) {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/?name=unknown", nil)
+		clients.handleExportBlockedServices(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestBlockedServicesDocJSON_validate(t *testing.T) {
+	filtering.InitModule()
+
+	testCases := []struct {
+		doc        *blockedServicesDocJSON
+		name       string
+		wantErrMsg string
+	}{{
+		doc: &blockedServicesDocJSON{
+			Version: 2,
+		},
+		name:       "bad_version",
+		wantErrMsg: "unsupported version 2, want 1",
+	}, {
+		doc: &blockedServicesDocJSON{
+			Clients: []*blockedServicesDocClientJSON{{
+				Name: "client",
+			}},
+			Version: blockedServicesDocVersion,
+		},
+		name:       "no_schedule",
+		wantErrMsg: `client "client": no schedule`,
+	}, {
+		doc: &blockedServicesDocJSON{
+			Clients: []*blockedServicesDocClientJSON{{
+				Schedule: schedule.EmptyWeekly(),
+				Name:     "client",
+				IDs:      []string{"bad_service"},
+			}},
+			Version: blockedServicesDocVersion,
+		},
+		name:       "bad_service",
+		wantErrMsg: `client "client": unknown blocked-service "bad_service"`,
+	}, {
+		doc: &blockedServicesDocJSON{
+			Clients: []*blockedServicesDocClientJSON{{
+				Schedule: schedule.EmptyWeekly(),
+				Name:     "client",
+			}, {
+				Schedule: schedule.EmptyWeekly(),
+				Name:     "client",
+			}},
+			Version: blockedServicesDocVersion,
+		},
+		name:       "duplicate",
+		wantErrMsg: `client at index 1: duplicate name "client"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.doc.validate())
+		})
+	}
+}
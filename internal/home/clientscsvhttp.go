@@ -0,0 +1,431 @@
+package home
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/slices"
+)
+
+// The names of the columns of the persistent clients CSV table.
+const (
+	csvColName                     = "name"
+	csvColIDs                      = "ids"
+	csvColTags                     = "tags"
+	csvColUpstreams                = "upstreams"
+	csvColUseGlobalSettings        = "use_global_settings"
+	csvColFilteringEnabled         = "filtering_enabled"
+	csvColSafeBrowsingEnabled      = "safebrowsing_enabled"
+	csvColParentalEnabled          = "parental_enabled"
+	csvColSafeSearchEnabled        = "safesearch_enabled"
+	csvColUseGlobalBlockedServices = "use_global_blocked_services"
+	csvColBlockedServices          = "blocked_services"
+)
+
+// csvColumns are the columns of the exported persistent clients CSV table in
+// the order of their appearance.
+var csvColumns = []string{
+	csvColName,
+	csvColIDs,
+	csvColTags,
+	csvColUpstreams,
+	csvColUseGlobalSettings,
+	csvColFilteringEnabled,
+	csvColSafeBrowsingEnabled,
+	csvColParentalEnabled,
+	csvColSafeSearchEnabled,
+	csvColUseGlobalBlockedServices,
+	csvColBlockedServices,
+}
+
+// csvListSep separates the values of the list columns, such as identifiers
+// and upstreams, within a single CSV field.  Spaces can't be used, since a
+// single upstream line may contain several space-separated addresses.
+const csvListSep = ";"
+
+// hdrValTextCSV is the value of the Content-Type header for CSV documents.
+const hdrValTextCSV = "text/csv; charset=utf-8"
+
+// handleExportCSV is the handler for the GET /control/clients/export_csv HTTP
+// API.  It writes all persistent clients as a CSV table sorted by name.
+func (clients *clientsContainer) handleExportCSV(w http.ResponseWriter, r *http.Request) {
+	records := [][]string{csvColumns}
+	func() {
+		clients.lock.Lock()
+		defer clients.lock.Unlock()
+
+		names := make([]string, 0, len(clients.list))
+		for name := range clients.list {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		for _, name := range names {
+			records = append(records, clientToCSV(clients.list[name]))
+		}
+	}()
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, hdrValTextCSV)
+	h.Set(httphdr.ContentDisposition, `attachment; filename="clients.csv"`)
+
+	cw := csv.NewWriter(w)
+	err := cw.WriteAll(records)
+	if err != nil {
+		log.Error("clients: writing csv: %s", err)
+	}
+}
+
+// clientToCSV returns the CSV record of c in the order of [csvColumns].
+func clientToCSV(c *Client) (rec []string) {
+	var blocked []string
+	if c.BlockedServices != nil {
+		blocked = c.BlockedServices.IDs
+	}
+
+	return []string{
+		c.Name,
+		strings.Join(c.IDs, csvListSep),
+		strings.Join(c.Tags, csvListSep),
+		strings.Join(c.Upstreams, csvListSep),
+		strconv.FormatBool(!c.UseOwnSettings),
+		strconv.FormatBool(c.FilteringEnabled),
+		strconv.FormatBool(c.SafeBrowsingEnabled),
+		strconv.FormatBool(c.ParentalEnabled),
+		strconv.FormatBool(c.safeSearchConf.Enabled),
+		strconv.FormatBool(!c.UseOwnBlockedServices),
+		strings.Join(blocked, csvListSep),
+	}
+}
+
+// csvImportAction is the action that importing a CSV record performs.
+type csvImportAction string
+
+// csvImportAction values.
+const (
+	csvImportActionAdd    csvImportAction = "add"
+	csvImportActionUpdate csvImportAction = "update"
+)
+
+// csvImportResultJSON is the result of validating a single record of the
+// imported CSV table.
+type csvImportResultJSON struct {
+	// Action is the action performed, or to be performed, with the client.
+	Action csvImportAction `json:"action,omitempty"`
+
+	// Name is the name of the client.
+	Name string `json:"name"`
+
+	// Error is the validation error, if any.
+	Error string `json:"error,omitempty"`
+
+	// Line is the number of the line of the record in the table.
+	Line int `json:"line"`
+}
+
+// csvImportReportJSON is the JSON structure for the response to the request
+// to import persistent clients from a CSV table.
+type csvImportReportJSON struct {
+	// Results are the results of validating each record of the table.
+	Results []*csvImportResultJSON `json:"results"`
+
+	// Imported is true if the clients have been imported, that is, if the
+	// request wasn't a dry run and all the records are valid.
+	Imported bool `json:"imported"`
+}
+
+// handleImportCSV is the handler for the POST /control/clients/import_csv HTTP
+// API.  The request body is a CSV table with a header, which must contain the
+// "name" column.  The other columns, as well as empty boolean fields, are
+// optional and are set to their defaults for the new clients and left
+// unchanged for the existing ones.  If the "dry_run" query parameter is true,
+// or if any record is invalid, nothing is imported.  The response is the
+// validation report in any case.
+func (clients *clientsContainer) handleImportCSV(w http.ResponseWriter, r *http.Request) {
+	var dryRun bool
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "parsing dry_run: %s", err)
+
+			return
+		}
+	}
+
+	report, err := clients.importCSV(r.Body, dryRun)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "importing clients: %s", err)
+
+		return
+	}
+
+	if report.Imported {
+		onConfigModified()
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, report)
+}
+
+// csvImportClient is a validated client from an imported CSV record.
+type csvImportClient struct {
+	prev *Client
+	c    *Client
+}
+
+// importCSV reads the CSV table from src, validates its records, and, unless
+// dryRun is true or any of the records is invalid, adds or updates the
+// persistent clients.  err is only returned if the table itself is malformed.
+func (clients *clientsContainer) importCSV(
+	src io.Reader,
+	dryRun bool,
+) (report *csvImportReportJSON, err error) {
+	cr := csv.NewReader(src)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	cols, err := csvHeaderColumns(header)
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	report = &csvImportReportJSON{
+		Results: []*csvImportResultJSON{},
+	}
+
+	var imported []*csvImportClient
+	names := map[string]int{}
+	ids := map[string]string{}
+	hasErrs := false
+	for {
+		var rec []string
+		rec, err = cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading record: %w", err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		res := &csvImportResultJSON{
+			Line: line,
+			Name: rec[cols[csvColName]],
+		}
+		report.Results = append(report.Results, res)
+
+		var ic *csvImportClient
+		ic, err = clients.csvToClientLocked(rec, cols, names, ids)
+		if err != nil {
+			res.Error, hasErrs = err.Error(), true
+
+			continue
+		}
+
+		res.Action = csvImportActionAdd
+		if ic.prev != nil {
+			res.Action = csvImportActionUpdate
+		}
+
+		names[res.Name] = line
+		imported = append(imported, ic)
+	}
+
+	if dryRun || hasErrs {
+		return report, nil
+	}
+
+	for _, ic := range imported {
+		if ic.prev != nil {
+			clients.del(ic.prev)
+		}
+
+		clients.add(ic.c)
+	}
+
+	report.Imported = true
+
+	return report, nil
+}
+
+// csvHeaderColumns returns the indexes of the known columns in the header.
+func csvHeaderColumns(header []string) (cols map[string]int, err error) {
+	cols = make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		if !slices.Contains(csvColumns, h) {
+			return nil, fmt.Errorf("unknown column %q", h)
+		} else if _, ok := cols[h]; ok {
+			return nil, fmt.Errorf("duplicate column %q", h)
+		}
+
+		cols[h] = i
+	}
+
+	if _, ok := cols[csvColName]; !ok {
+		return nil, fmt.Errorf("column %q: %w", csvColName, errors.Error("required"))
+	}
+
+	return cols, nil
+}
+
+// csvToClientLocked validates the CSV record and returns the client to import.
+// names and ids contain the names and identifiers of the clients from the
+// previous records, the latter mapped to the client names, and are updated
+// with the ones from rec.  clients.lock is expected to be locked.
+func (clients *clientsContainer) csvToClientLocked(
+	rec []string,
+	cols map[string]int,
+	names map[string]int,
+	ids map[string]string,
+) (ic *csvImportClient, err error) {
+	name := rec[cols[csvColName]]
+	if line, ok := names[name]; ok {
+		return nil, fmt.Errorf("duplicate name, see line %d", line)
+	}
+
+	ic = &csvImportClient{}
+	cj := &clientJSON{
+		UseGlobalSettings:        true,
+		UseGlobalBlockedServices: true,
+	}
+
+	if prev, ok := clients.list[name]; ok {
+		ic.prev = prev
+		cj = clientToJSON(prev)
+
+		// Don't modify the settings of the existing client in place.
+		ssConf := *cj.SafeSearchConf
+		cj.SafeSearchConf = &ssConf
+	}
+
+	err = setCSVFields(cj, rec, cols)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, err
+	}
+
+	ic.c, err = clients.jsonToClient(*cj, ic.prev)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, err
+	}
+
+	err = clients.check(ic.c)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, err
+	}
+
+	for _, id := range ic.c.IDs {
+		if other, ok := ids[id]; ok {
+			return nil, fmt.Errorf("id %q is used by client %q from the same table", id, other)
+		}
+
+		existing, ok := clients.idIndex[id]
+		if ok && existing != ic.prev {
+			return nil, fmt.Errorf("id %q is used by client %q", id, existing.Name)
+		}
+	}
+
+	for _, id := range ic.c.IDs {
+		ids[id] = name
+	}
+
+	return ic, nil
+}
+
+// setCSVFields sets the fields of cj from the fields of rec present in cols.
+func setCSVFields(cj *clientJSON, rec []string, cols map[string]int) (err error) {
+	for col, i := range cols {
+		val := strings.TrimSpace(rec[i])
+		switch col {
+		case csvColName:
+			cj.Name = val
+		case csvColIDs:
+			cj.IDs = splitCSVList(val)
+		case csvColTags:
+			cj.Tags = splitCSVList(val)
+		case csvColUpstreams:
+			cj.Upstreams = splitCSVList(val)
+		case csvColBlockedServices:
+			cj.BlockedServices = splitCSVList(val)
+		default:
+			err = setCSVBool(cj, col, val)
+		}
+
+		if err != nil {
+			return fmt.Errorf("column %q: %w", col, err)
+		}
+	}
+
+	return nil
+}
+
+// setCSVBool sets the boolean field of cj corresponding to col.  An empty val
+// leaves the field unchanged.
+func setCSVBool(cj *clientJSON, col, val string) (err error) {
+	if val == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	switch col {
+	case csvColUseGlobalSettings:
+		cj.UseGlobalSettings = b
+	case csvColFilteringEnabled:
+		cj.FilteringEnabled = b
+	case csvColSafeBrowsingEnabled:
+		cj.SafeBrowsingEnabled = b
+	case csvColParentalEnabled:
+		cj.ParentalEnabled = b
+	case csvColSafeSearchEnabled:
+		cj.SafeSearchEnabled = b
+		if cj.SafeSearchConf != nil {
+			cj.SafeSearchConf.Enabled = b
+		}
+	case csvColUseGlobalBlockedServices:
+		cj.UseGlobalBlockedServices = b
+	default:
+		panic(fmt.Errorf("unexpected column %q", col))
+	}
+
+	return nil
+}
+
+// splitCSVList splits the list field of a CSV record.  It returns nil if s is
+// empty.
+func splitCSVList(s string) (vals []string) {
+	if s == "" {
+		return nil
+	}
+
+	for _, v := range strings.Split(s, csvListSep) {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			vals = append(vals, v)
+		}
+	}
+
+	return vals
+}
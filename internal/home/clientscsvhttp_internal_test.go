@@ -0,0 +1,133 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsContainer_importCSV(t *testing.T) {
+	filtering.InitModule()
+
+	clients := newClientsContainer(t)
+
+	ok, err := clients.Add(&Client{
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+		},
+		Name:             "existing",
+		IDs:              []string{"192.0.2.1"},
+		UseOwnSettings:   true,
+		FilteringEnabled: true,
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	const validTable = "name,ids,tags,blocked_services,use_global_blocked_services\n" +
+		"existing,192.0.2.1;192.0.2.10,device_phone,youtube,false\n" +
+		"new,192.0.2.2,,,\n"
+
+	t.Run("dry_run", func(t *testing.T) {
+		report, iErr := clients.importCSV(strings.NewReader(validTable), true)
+		require.NoError(t, iErr)
+
+		assert.False(t, report.Imported)
+		assert.Equal(t, []*csvImportResultJSON{{
+			Action: csvImportActionUpdate,
+			Name:   "existing",
+			Line:   2,
+		}, {
+			Action: csvImportActionAdd,
+			Name:   "new",
+			Line:   3,
+		}}, report.Results)
+
+		_, ok = clients.Find("192.0.2.2")
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		const table = "name,ids\n" +
+			"new,192.0.2.2\n" +
+			"bad,192.0.2.2\n" +
+			"new,192.0.2.3\n" +
+			"bad_id,not an id\n"
+
+		report, iErr := clients.importCSV(strings.NewReader(table), false)
+		require.NoError(t, iErr)
+
+		assert.False(t, report.Imported)
+		require.Len(t, report.Results, 4)
+
+		assert.Empty(t, report.Results[0].Error)
+		assert.Equal(
+			t,
+			`id "192.0.2.2" is used by client "new" from the same table`,
+			report.Results[1].Error,
+		)
+		assert.Equal(t, "duplicate name, see line 2", report.Results[2].Error)
+		assert.Equal(
+			t,
+			`client at index 0: bad client identifier "not an id"`,
+			report.Results[3].Error,
+		)
+
+		_, ok = clients.Find("192.0.2.2")
+		assert.False(t, ok)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		report, iErr := clients.importCSV(strings.NewReader(validTable), false)
+		require.NoError(t, iErr)
+
+		assert.True(t, report.Imported)
+
+		c, found := clients.Find("192.0.2.10")
+		require.True(t, found)
+
+		assert.Equal(t, "existing", c.Name)
+		assert.Equal(t, []string{"device_phone"}, c.Tags)
+		assert.Equal(t, []string{"youtube"}, c.BlockedServices.IDs)
+		assert.True(t, c.UseOwnBlockedServices)
+		assert.True(t, c.UseOwnSettings)
+		assert.True(t, c.FilteringEnabled)
+
+		c, found = clients.Find("192.0.2.2")
+		require.True(t, found)
+
+		assert.Equal(t, "new", c.Name)
+		assert.False(t, c.UseOwnSettings)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/control/clients/export_csv", nil)
+		clients.handleExportCSV(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, ""+
+			"name,ids,tags,upstreams,use_global_settings,filtering_enabled,"+
+			"safebrowsing_enabled,parental_enabled,safesearch_enabled,"+
+			"use_global_blocked_services,blocked_services\n"+
+			"existing,192.0.2.1;192.0.2.10,device_phone,,false,true,false,false,"+
+			"false,false,youtube\n"+
+			"new,192.0.2.2,,,true,false,false,false,false,true,\n",
+			w.Body.String(),
+		)
+	})
+
+	t.Run("bad_header", func(t *testing.T) {
+		_, iErr := clients.importCSV(strings.NewReader("ids,unknown\n"), false)
+		testutil.AssertErrorMsg(t, `header: unknown column "unknown"`, iErr)
+
+		_, iErr = clients.importCSV(strings.NewReader("ids\n"), false)
+		testutil.AssertErrorMsg(t, `header: column "name": required`, iErr)
+	})
+}
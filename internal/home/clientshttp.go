@@ -9,9 +9,11 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/discovery"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
+	"github.com/AdguardTeam/golibs/stringutil"
 )
 
 // clientJSON is a common structure used by several handlers to deal with
@@ -40,12 +42,52 @@ type clientJSON struct {
 
 	Name string `json:"name"`
 
+	// Model is the model of the device advertised by a runtime client.  It's
+	// only set in the responses.
+	Model string `json:"model,omitempty"`
+
+	// DeviceType is the kind of the device of a runtime client.  It's only set
+	// in the responses.
+	DeviceType discovery.DeviceType `json:"device_type,omitempty"`
+
+	// Vendor is the vendor of the device of a runtime client guessed by its
+	// fingerprint.  It's only set in the responses.
+	Vendor string `json:"vendor,omitempty"`
+
+	// OS is the operating system of the device of a runtime client guessed by
+	// its fingerprint.  It's only set in the responses.
+	OS string `json:"os,omitempty"`
+
 	// BlockedServices is the names of blocked services.
 	BlockedServices []string `json:"blocked_services"`
 	IDs             []string `json:"ids"`
 	Tags            []string `json:"tags"`
 	Upstreams       []string `json:"upstreams"`
 
+	// BootstrapDNS are the bootstrap DNS servers used to resolve the hostnames
+	// of the client's upstream servers.
+	BootstrapDNS []string `json:"bootstrap_dns"`
+
+	// BlockedCategories are the names of the domain categories blocked for
+	// the client.  If nil, the previous value is kept.
+	BlockedCategories []string `json:"blocked_categories"`
+
+	// MinTTL is the minimum TTL of the records in the responses to the
+	// client, in seconds.
+	MinTTL uint32 `json:"min_ttl"`
+
+	// Ratelimit is the maximum number of requests per second from the client.
+	// Zero means no limit.
+	Ratelimit uint32 `json:"ratelimit"`
+
+	// RatelimitBurst is the maximum number of requests, which the client may
+	// send at once.  Zero means that Ratelimit is used.
+	RatelimitBurst uint32 `json:"ratelimit_burst"`
+
+	// RatelimitDrop is true if the requests above the limit are dropped
+	// instead of being responded with REFUSED.
+	RatelimitDrop bool `json:"ratelimit_drop"`
+
 	FilteringEnabled    bool `json:"filtering_enabled"`
 	ParentalEnabled     bool `json:"parental_enabled"`
 	SafeBrowsingEnabled bool `json:"safebrowsing_enabled"`
@@ -56,6 +98,15 @@ type clientJSON struct {
 
 	IgnoreQueryLog   aghalg.NullBool `json:"ignore_querylog"`
 	IgnoreStatistics aghalg.NullBool `json:"ignore_statistics"`
+
+	// UseOwnBlockedCategories is true if the client uses BlockedCategories
+	// instead of the globally blocked domain categories.  If null, the
+	// previous value is kept.
+	UseOwnBlockedCategories aghalg.NullBool `json:"use_own_blocked_categories"`
+
+	// AllowlistOnly is true if all hosts, which aren't explicitly allowed, are
+	// blocked for the client.  If null, the previous value is kept.
+	AllowlistOnly aghalg.NullBool `json:"allowlist_only"`
 }
 
 // copySettings returns a copy of specific settings from JSON or a previous
@@ -86,12 +137,54 @@ func (j *clientJSON) copySettings(
 	return weekly, ignoreQueryLog, ignoreStatistics
 }
 
+// copyCategories returns a copy of the domain category settings from JSON or a
+// previous client.
+func (j *clientJSON) copyCategories(prev *Client) (blocked []string, useOwn bool) {
+	if j.BlockedCategories != nil {
+		blocked = stringutil.CloneSlice(j.BlockedCategories)
+	} else if prev != nil {
+		blocked = stringutil.CloneSlice(prev.BlockedCategories)
+	}
+
+	if j.UseOwnBlockedCategories != aghalg.NBNull {
+		useOwn = j.UseOwnBlockedCategories == aghalg.NBTrue
+	} else if prev != nil {
+		useOwn = prev.UseOwnBlockedCategories
+	}
+
+	return blocked, useOwn
+}
+
+// allowlistOnly returns the allowlist-only mode from JSON or a previous client.
+func (j *clientJSON) allowlistOnly(prev *Client) (ok bool) {
+	if j.AllowlistOnly != aghalg.NBNull {
+		return j.AllowlistOnly == aghalg.NBTrue
+	} else if prev != nil {
+		return prev.AllowlistOnly
+	}
+
+	return false
+}
+
 type runtimeClientJSON struct {
 	WHOIS *whois.Info `json:"whois_info"`
 
 	IP     netip.Addr    `json:"ip"`
 	Name   string        `json:"name"`
 	Source client.Source `json:"source"`
+
+	// Model is the model of the device advertised by the client, if any.
+	Model string `json:"model,omitempty"`
+
+	// DeviceType is the kind of the device, if known.
+	DeviceType discovery.DeviceType `json:"device_type,omitempty"`
+
+	// Vendor is the vendor of the device guessed by its fingerprint, if any.
+	Vendor string `json:"vendor,omitempty"`
+
+	// OS is the operating system of the device guessed by its fingerprint, if
+	// any.
+	OS string `json:"os,omitempty"`
 }
 
 type clientListJSON struct {
@@ -116,9 +209,13 @@ func (clients *clientsContainer) handleGetClients(w http.ResponseWriter, r *http
 		cj := runtimeClientJSON{
 			WHOIS: rc.WHOIS,
 
-			Name:   rc.Host,
-			Source: rc.Source,
-			IP:     ip,
+			Name:       rc.Host,
+			Source:     rc.Source,
+			IP:         ip,
+			Model:      rc.Model,
+			DeviceType: rc.DeviceType,
+			Vendor:     rc.Vendor,
+			OS:         rc.OS,
 		}
 
 		data.RuntimeClients = append(data.RuntimeClients, cj)
@@ -155,6 +252,7 @@ func (clients *clientsContainer) jsonToClient(cj clientJSON, prev *Client) (c *C
 		// Set default service flags for enabled safesearch.
 		if safeSearchConf.Enabled {
 			safeSearchConf.Bing = true
+			safeSearchConf.Brave = true
 			safeSearchConf.DuckDuckGo = true
 			safeSearchConf.Google = true
 			safeSearchConf.Pixabay = true
@@ -174,6 +272,12 @@ func (clients *clientsContainer) jsonToClient(cj clientJSON, prev *Client) (c *C
 		return nil, fmt.Errorf("validating blocked services: %w", err)
 	}
 
+	blockedCats, useOwnCats := cj.copyCategories(prev)
+	err = filtering.ValidateCategories(blockedCats)
+	if err != nil {
+		return nil, fmt.Errorf("validating blocked categories: %w", err)
+	}
+
 	c = &Client{
 		safeSearchConf: safeSearchConf,
 
@@ -181,9 +285,15 @@ func (clients *clientsContainer) jsonToClient(cj clientJSON, prev *Client) (c *C
 
 		BlockedServices: bs,
 
-		IDs:       cj.IDs,
-		Tags:      cj.Tags,
-		Upstreams: cj.Upstreams,
+		IDs:          cj.IDs,
+		Tags:         cj.Tags,
+		Upstreams:    cj.Upstreams,
+		BootstrapDNS: cj.BootstrapDNS,
+		MinTTL:       cj.MinTTL,
+
+		Ratelimit:      cj.Ratelimit,
+		RatelimitBurst: cj.RatelimitBurst,
+		RatelimitDrop:  cj.RatelimitDrop,
 
 		UseOwnSettings:        !cj.UseGlobalSettings,
 		FilteringEnabled:      cj.FilteringEnabled,
@@ -192,6 +302,10 @@ func (clients *clientsContainer) jsonToClient(cj clientJSON, prev *Client) (c *C
 		UseOwnBlockedServices: !cj.UseGlobalBlockedServices,
 		IgnoreQueryLog:        ignoreQueryLog,
 		IgnoreStatistics:      ignoreStatistics,
+
+		BlockedCategories:       blockedCats,
+		UseOwnBlockedCategories: useOwnCats,
+		AllowlistOnly:           cj.allowlistOnly(prev),
 	}
 
 	if safeSearchConf.Enabled {
@@ -231,10 +345,20 @@ func clientToJSON(c *Client) (cj *clientJSON) {
 		Schedule:        c.BlockedServices.Schedule,
 		BlockedServices: c.BlockedServices.IDs,
 
-		Upstreams: c.Upstreams,
+		Upstreams:    c.Upstreams,
+		BootstrapDNS: c.BootstrapDNS,
+		MinTTL:       c.MinTTL,
+
+		Ratelimit:      c.Ratelimit,
+		RatelimitBurst: c.RatelimitBurst,
+		RatelimitDrop:  c.RatelimitDrop,
 
 		IgnoreQueryLog:   aghalg.BoolToNullBool(c.IgnoreQueryLog),
 		IgnoreStatistics: aghalg.BoolToNullBool(c.IgnoreStatistics),
+
+		BlockedCategories:       stringutil.CloneSlice(c.BlockedCategories),
+		UseOwnBlockedCategories: aghalg.BoolToNullBool(c.UseOwnBlockedCategories),
+		AllowlistOnly:           aghalg.BoolToNullBool(c.AllowlistOnly),
 	}
 }
 
@@ -402,9 +526,13 @@ func (clients *clientsContainer) findRuntime(ip netip.Addr, idStr string) (cj *c
 	}
 
 	cj = &clientJSON{
-		Name:  rc.Host,
-		IDs:   []string{idStr},
-		WHOIS: rc.WHOIS,
+		Name:       rc.Host,
+		IDs:        []string{idStr},
+		WHOIS:      rc.WHOIS,
+		Model:      rc.Model,
+		DeviceType: rc.DeviceType,
+		Vendor:     rc.Vendor,
+		OS:         rc.OS,
 	}
 
 	disallowed, rule := clients.dnsServer.IsBlockedClient(ip, idStr)
@@ -420,4 +548,33 @@ func (clients *clientsContainer) registerWebHandlers() {
 	httpRegister(http.MethodPost, "/control/clients/delete", clients.handleDelClient)
 	httpRegister(http.MethodPost, "/control/clients/update", clients.handleUpdateClient)
 	httpRegister(http.MethodGet, "/control/clients/find", clients.handleFindClient)
+	httpRegister(
+		http.MethodGet,
+		"/control/clients/blocked_services/export",
+		clients.handleExportBlockedServices,
+	)
+	httpRegister(
+		http.MethodPost,
+		"/control/clients/blocked_services/import",
+		clients.handleImportBlockedServices,
+	)
+	httpRegister(http.MethodGet, "/control/clients/export_csv", clients.handleExportCSV)
+	httpRegister(http.MethodPost, "/control/clients/import_csv", clients.handleImportCSV)
+	httpRegister(http.MethodGet, "/control/clients/pauses", clients.handleGetPauses)
+	httpRegister(http.MethodPost, "/control/clients/pause", clients.handlePause)
+	httpRegister(http.MethodPost, "/control/clients/resume", clients.handleResume)
+	httpRegister(
+		http.MethodGet,
+		"/control/clients/internet_pauses",
+		clients.handleGetInternetPauses,
+	)
+	httpRegister(http.MethodPost, "/control/clients/internet_pause", clients.handleInternetPause)
+	httpRegister(http.MethodPost, "/control/clients/internet_resume", clients.handleInternetResume)
+	httpRegister(http.MethodGet, "/control/clients/groups", clients.handleGetGroups)
+	httpRegister(http.MethodPost, "/control/clients/groups/add", clients.handleAddGroup)
+	httpRegister(http.MethodPost, "/control/clients/groups/update", clients.handleUpdateGroup)
+	httpRegister(http.MethodPost, "/control/clients/groups/delete", clients.handleDeleteGroup)
+
+	httpRegister(http.MethodGet, "/control/clients/pending", clients.handleGetPending)
+	httpRegister(http.MethodPost, "/control/clients/pending/approve", clients.handleApprovePending)
 }
@@ -1,12 +1,13 @@
 package home
 
 import (
-	"bytes"
 	"fmt"
 	"net/netip"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
@@ -22,7 +23,6 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/google/renameio/v2/maybe"
-	yaml "gopkg.in/yaml.v3"
 )
 
 // dataDir is the name of a directory under the working one to store some
@@ -77,6 +77,18 @@ type clientsConfig struct {
 	Sources *clientSourcesConfig `yaml:"runtime_sources"`
 	// Persistent are the configured clients.
 	Persistent []*clientObject `yaml:"persistent"`
+	// TagUpstreams are the upstream servers for the persistent clients with
+	// the tags, which don't have their own upstream servers.
+	TagUpstreams []*tagUpstreamsObject `yaml:"tag_upstreams"`
+	// Groups are the client groups in the order of decreasing precedence.
+	Groups []*clientGroup `yaml:"groups"`
+	// FingerprintsFile is the path to the file with the custom fingerprint
+	// rules, which take precedence over the built-in ones.  If empty, only the
+	// built-in rules are used.
+	FingerprintsFile string `yaml:"fingerprints_file"`
+	// Quarantine is the configuration of the quarantine of the previously
+	// unseen clients.
+	Quarantine *quarantineConfig `yaml:"quarantine"`
 }
 
 // clientSourceConfig is used to configure where the runtime clients will be
@@ -87,6 +99,23 @@ type clientSourcesConfig struct {
 	RDNS      bool `yaml:"rdns"`
 	DHCP      bool `yaml:"dhcp"`
 	HostsFile bool `yaml:"hosts"`
+
+	// MDNS, if true, enables listening to the mDNS announcements of the
+	// devices on the local network.
+	MDNS bool `yaml:"mdns"`
+
+	// NetBIOS, if true, enables the NetBIOS node status queries to the clients
+	// with private IPv4 addresses.
+	NetBIOS bool `yaml:"netbios"`
+
+	// LLMNR, if true, enables the LLMNR reverse queries to the clients with
+	// private IP addresses.
+	LLMNR bool `yaml:"llmnr"`
+
+	// Fingerprint, if true, enables guessing the device types of the clients
+	// by their DHCP fingerprints and the User-Agent headers of their DoH
+	// requests.
+	Fingerprint bool `yaml:"fingerprint"`
 }
 
 // configuration is loaded from YAML.
@@ -139,6 +168,52 @@ type configuration struct {
 	// Keep this field sorted to ensure consistent ordering.
 	Clients *clientsConfig `yaml:"clients"`
 
+	// Federation is the configuration of the aggregated view over several
+	// AdGuard Home instances.
+	Federation *federationConfig `yaml:"federation"`
+
+	// Audit is the configuration of the audit log of the administrative
+	// actions shipped to a remote collector.
+	Audit *auditConfig `yaml:"audit"`
+
+	// BlockHooks are the configurations of the external commands and webhooks
+	// run when requests are blocked.
+	BlockHooks []*blockHookConfig `yaml:"block_hooks"`
+
+	// Anomalies is the configuration of the detection of the anomalies in
+	// the DNS query patterns.
+	Anomalies *anomalyConfig `yaml:"anomaly_detection"`
+
+	// Schedules are the filtering settings applied to the matching clients
+	// during the scheduled time.
+	Schedules []*filteringSchedule `yaml:"schedules"`
+
+	// Notifications is the configuration of the notifications about the
+	// notable events, such as the failed filter list updates.
+	Notifications *notificationsConfig `yaml:"notifications"`
+
+	// DDNS is the configuration of the dynamic DNS updater keeping the
+	// records of the hostnames pointed at the public IP addresses.
+	DDNS *ddnsConfig `yaml:"ddns"`
+
+	// DNSUpdate is the configuration of the listener of the dynamic DNS
+	// UPDATE messages adding the records to the rewrites.
+	DNSUpdate *dnsUpdateConfig `yaml:"dns_update"`
+
+	// ConfigHistory is the configuration of the history of the changes of the
+	// configuration file.
+	ConfigHistory *configHistoryConfig `yaml:"config_history"`
+
+	// ConfigSync is the configuration of mirroring the settings from the
+	// primary instance.
+	ConfigSync *configSyncConfig `yaml:"config_sync"`
+
+	// SecretsKeyFile is the path to the file with the master key used to
+	// encrypt the secrets in the configuration file.  If empty and the key
+	// isn't set in the environment, the secrets aren't encrypted.  See
+	// [secretsKeyEnv].
+	SecretsKeyFile string `yaml:"secrets_key_file"`
+
 	// Log is a block with log configuration settings.
 	Log logSettings `yaml:"log"`
 
@@ -146,6 +221,11 @@ type configuration struct {
 
 	sync.RWMutex `yaml:"-"`
 
+	// replaced is true if the configuration file has been replaced, for
+	// example by a rollback, and AdGuard Home is waiting for the restart to
+	// apply it.  The file mustn't be overwritten in that case.
+	replaced atomic.Bool
+
 	// SchemaVersion is the version of the configuration schema.  See
 	// [confmigrate.LastSchemaVersion].
 	SchemaVersion uint `yaml:"schema_version"`
@@ -165,6 +245,13 @@ type httpConfig struct {
 	// SessionTTL for a web session.
 	// An active session is automatically refreshed once a day.
 	SessionTTL timeutil.Duration `yaml:"session_ttl"`
+
+	// Metrics defines the Prometheus metrics HTTP handler.
+	Metrics *httpMetricsConfig `yaml:"metrics"`
+
+	// BlockPage defines the server of the block page for the block_page
+	// blocking mode.
+	BlockPage *blockPageConfig `yaml:"block_page"`
 }
 
 // httpPprofConfig is the block with pprof HTTP configuration.
@@ -236,6 +323,33 @@ type tlsConfigSettings struct {
 	PortDNSOverTLS  uint16 `yaml:"port_dns_over_tls" json:"port_dns_over_tls,omitempty"`   // DNS-over-TLS port. If 0, DoT will be disabled
 	PortDNSOverQUIC uint16 `yaml:"port_dns_over_quic" json:"port_dns_over_quic,omitempty"` // DNS-over-QUIC port. If 0, DoQ will be disabled
 
+	// PortHTTP3 is the UDP port for the HTTP/3 requests to the web interface
+	// and DNS-over-HTTPS, which are only served if [dnsConfig.ServeHTTP3] is
+	// true.  If it's zero, PortHTTPS is used.  It can only be set in the
+	// configuration file.
+	PortHTTP3 uint16 `yaml:"port_http3" json:"-"`
+
+	// ACME is the configuration of the automatic management of the
+	// certificate.  If enabled, the certificate and the private key are
+	// obtained from the ACME certificate authority instead of the ones set
+	// by the user.  It can only be set in the configuration file.
+	ACME *acmeConfig `yaml:"acme,omitempty" json:"-"`
+
+	// SNICertificates are the additional certificates for the HTTPS,
+	// DNS-over-HTTPS, DNS-over-TLS, and DNS-over-QUIC servers, which are
+	// selected by the SNI of the clients.  It can only be set in the
+	// configuration file.
+	SNICertificates []*tlsSNICertificate `yaml:"sni_certificates,omitempty" json:"-"`
+
+	// ClientAuth is the configuration of the authentication of the clients of
+	// the encrypted DNS servers with the TLS client certificates.  It can only
+	// be set in the configuration file.
+	ClientAuth *tlsClientAuthConfig `yaml:"client_auth,omitempty" json:"-"`
+
+	// ECH is the configuration of the Encrypted Client Hello on the HTTPS
+	// server.  It can only be set in the configuration file.
+	ECH *tlsECHConfig `yaml:"ech,omitempty" json:"-"`
+
 	// PortDNSCrypt is the port for DNSCrypt requests.  If it's zero,
 	// DNSCrypt is disabled.
 	PortDNSCrypt uint16 `yaml:"port_dnscrypt" json:"port_dnscrypt"`
@@ -246,12 +360,37 @@ type tlsConfigSettings struct {
 	// https://github.com/ameshkov/dnscrypt.
 	DNSCryptConfigFile string `yaml:"dnscrypt_config_file" json:"dnscrypt_config_file"`
 
+	// DNSCryptResolvers are the additional DNSCrypt resolvers with their own
+	// provider names and keys.  They are only served if PortDNSCrypt is not
+	// zero.  It can only be set in the configuration file.
+	DNSCryptResolvers []*dnsCryptResolverConfig `yaml:"dnscrypt_resolvers,omitempty" json:"-"`
+
+	// DNSCryptRotationInterval is the interval, after which the certificates
+	// of the DNSCrypt resolvers are recreated with new short-term keys.  If
+	// it's zero, the certificates aren't rotated.  It can only be set in the
+	// configuration file.
+	DNSCryptRotationInterval timeutil.Duration `yaml:"dnscrypt_rotation_interval,omitempty" json:"-"`
+
 	// Allow DoH queries via unencrypted HTTP (e.g. for reverse proxying)
 	AllowUnencryptedDoH bool `yaml:"allow_unencrypted_doh" json:"allow_unencrypted_doh"`
 
 	dnsforward.TLSConfig `yaml:",inline" json:",inline"`
 }
 
+// http3Port returns the UDP port for the HTTP/3 requests, if serveHTTP3 is true
+// and HTTPS is enabled.  Otherwise, it returns zero.
+func (c *tlsConfigSettings) http3Port(serveHTTP3 bool) (port uint16) {
+	if !serveHTTP3 || !c.Enabled || c.PortHTTPS == 0 {
+		return 0
+	}
+
+	if c.PortHTTP3 != 0 {
+		return c.PortHTTP3
+	}
+
+	return c.PortHTTPS
+}
+
 type queryLogConfig struct {
 	// Ignored is the list of host names, which should not be written to log.
 	// "." is considered to be the root domain.
@@ -269,6 +408,14 @@ type queryLogConfig struct {
 
 	// FileEnabled defines, if the query log is written to the file.
 	FileEnabled bool `yaml:"file_enabled"`
+
+	// EncryptionKeyFile is the path to the file with the key used to encrypt
+	// the query log files.  If empty, the files aren't encrypted.
+	EncryptionKeyFile string `yaml:"encryption_key_file"`
+
+	// Ship is the configuration of shipping the entries to a remote
+	// collector.
+	Ship *queryLogShipConfig `yaml:"ship"`
 }
 
 type statsConfig struct {
@@ -278,8 +425,17 @@ type statsConfig struct {
 	// Interval is the retention interval for statistics.
 	Interval timeutil.Duration `yaml:"interval"`
 
+	// DailyInterval is the retention interval for the daily statistics, into
+	// which the hourly statistics are downsampled.  If zero, the daily
+	// statistics aren't kept.
+	DailyInterval timeutil.Duration `yaml:"daily_interval"`
+
 	// Enabled defines if the statistics are enabled.
 	Enabled bool `yaml:"enabled"`
+
+	// EncryptionKeyFile is the path to the file with the key used to encrypt
+	// the statistics database.  If empty, the database isn't encrypted.
+	EncryptionKeyFile string `yaml:"encryption_key_file"`
 }
 
 // Default block host constants.
@@ -291,143 +447,253 @@ const (
 // config is the global configuration structure.
 //
 // TODO(a.garipov, e.burkov): This global is awful and must be removed.
-var config = &configuration{
-	AuthAttempts: 5,
-	AuthBlockMin: 15,
-	HTTPConfig: httpConfig{
-		Address:    netip.AddrPortFrom(netip.IPv4Unspecified(), 3000),
-		SessionTTL: timeutil.Duration{Duration: 30 * timeutil.Day},
-		Pprof: &httpPprofConfig{
-			Enabled: false,
-			Port:    6060,
+var config = newDefaultConfig()
+
+// newDefaultConfig returns a new configuration with the default values.
+func newDefaultConfig() (c *configuration) {
+	return &configuration{
+		AuthAttempts: 5,
+		AuthBlockMin: 15,
+		HTTPConfig: httpConfig{
+			Address:    netip.AddrPortFrom(netip.IPv4Unspecified(), 3000),
+			SessionTTL: timeutil.Duration{Duration: 30 * timeutil.Day},
+			Pprof: &httpPprofConfig{
+				Enabled: false,
+				Port:    6060,
+			},
+			Metrics: &httpMetricsConfig{
+				Token:   "",
+				Enabled: false,
+			},
+			BlockPage: &blockPageConfig{
+				Address:         netip.AddrPortFrom(netip.IPv4Unspecified(), 80),
+				UnblockDuration: timeutil.Duration{Duration: time.Hour},
+				Enabled:         false,
+			},
+		},
+		DNS: dnsConfig{
+			BindHosts: []netip.Addr{netip.IPv4Unspecified()},
+			Port:      defaultPortDNS,
+			Config: dnsforward.Config{
+				Ratelimit:  20,
+				RefuseAny:  true,
+				AllServers: false,
+				HandleDDR:  true,
+
+				FloodProtection: &dnsforward.FloodProtectionConfig{
+					BlockDuration: timeutil.Duration{Duration: 1 * time.Minute},
+					Threshold:     1000,
+					Enabled:       false,
+				},
+
+				DeduplicateQueries: true,
+				FastestTimeout: timeutil.Duration{
+					Duration: fastip.DefaultPingWaitTimeout,
+				},
+				UpstreamHedgeDelay: timeutil.Duration{
+					Duration: dnsforward.DefaultUpstreamHedgeDelay,
+				},
+
+				TrustedProxies: []string{"127.0.0.0/8", "::1/128"},
+				CacheSize:      4 * 1024 * 1024,
+
+				EDNSClientSubnet: &dnsforward.EDNSClientSubnet{
+					CustomIP:  netip.Addr{},
+					Enabled:   false,
+					UseCustom: false,
+				},
+				RebindProtection: &dnsforward.RebindProtectionConfig{
+					AllowedDomains: []string{},
+					AdditionalNetworks: []netip.Prefix{
+						netip.MustParsePrefix("100.64.0.0/10"),
+						netip.MustParsePrefix("fc00::/7"),
+					},
+					Enabled: false,
+				},
+
+				// set default maximum concurrent queries to 300
+				// we introduced a default limit due to this:
+				// https://github.com/AdguardTeam/AdGuardHome/issues/2015#issuecomment-674041912
+				// was later increased to 300 due to https://github.com/AdguardTeam/AdGuardHome/issues/2257
+				MaxGoroutines: 300,
+			},
+			UpstreamTimeout: timeutil.Duration{Duration: dnsforward.DefaultTimeout},
+			UsePrivateRDNS:  true,
+		},
+		TLS: tlsConfigSettings{
+			PortHTTPS:       defaultPortHTTPS,
+			PortDNSOverTLS:  defaultPortTLS, // needs to be passed through to dnsproxy
+			PortDNSOverQUIC: defaultPortQUIC,
+		},
+		QueryLog: queryLogConfig{
+			Enabled:     true,
+			FileEnabled: true,
+			Interval:    timeutil.Duration{Duration: 90 * timeutil.Day},
+			MemSize:     1000,
+			Ignored:     []string{},
+			Ship: &queryLogShipConfig{
+				Format:     querylog.ShipFormatSyslog,
+				BufferSize: defaultQueryLogShipBufferSize,
+			},
+		},
+		Stats: statsConfig{
+			Enabled:       true,
+			Interval:      timeutil.Duration{Duration: 1 * timeutil.Day},
+			DailyInterval: timeutil.Duration{Duration: 365 * timeutil.Day},
+			Ignored:       []string{},
 		},
-	},
-	DNS: dnsConfig{
-		BindHosts: []netip.Addr{netip.IPv4Unspecified()},
-		Port:      defaultPortDNS,
-		Config: dnsforward.Config{
-			Ratelimit:  20,
-			RefuseAny:  true,
-			AllServers: false,
-			HandleDDR:  true,
-			FastestTimeout: timeutil.Duration{
-				Duration: fastip.DefaultPingWaitTimeout,
+		// NOTE: Keep these parameters in sync with the one put into
+		// client/src/helpers/filters/filters.js by scripts/vetted-filters.
+		//
+		// TODO(a.garipov): Think of a way to make scripts/vetted-filters update
+		// these as well if necessary.
+		Filters: []filtering.FilterYAML{{
+			Filter:  filtering.Filter{ID: 1},
+			Enabled: true,
+			URL:     "https://adguardteam.github.io/HostlistsRegistry/assets/filter_1.txt",
+			Name:    "AdGuard DNS filter",
+		}, {
+			Filter:  filtering.Filter{ID: 2},
+			Enabled: false,
+			URL:     "https://adguardteam.github.io/HostlistsRegistry/assets/filter_2.txt",
+			Name:    "AdAway Default Blocklist",
+		}},
+		Filtering: &filtering.Config{
+			ProtectionEnabled:  true,
+			BlockingMode:       filtering.BlockingModeDefault,
+			BlockedResponseTTL: 10, // in seconds
+
+			FilteringEnabled:           true,
+			FiltersUpdateIntervalHours: 24,
+
+			ParentalEnabled:     false,
+			SafeBrowsingEnabled: false,
+
+			SafeBrowsingCacheSize: 1 * 1024 * 1024,
+			SafeSearchCacheSize:   1 * 1024 * 1024,
+			ParentalCacheSize:     1 * 1024 * 1024,
+			CacheTime:             30,
+			DecisionCacheSize:     10_000,
+
+			SafeSearchConf: filtering.SafeSearchConfig{
+				Enabled:    false,
+				Bing:       true,
+				Brave:      true,
+				DuckDuckGo: true,
+				Google:     true,
+				Pixabay:    true,
+				Yandex:     true,
+				YouTube:    true,
+			},
+
+			BlockedServices: &filtering.BlockedServices{
+				Schedule: schedule.EmptyWeekly(),
+				IDs:      []string{},
 			},
 
-			TrustedProxies: []string{"127.0.0.0/8", "::1/128"},
-			CacheSize:      4 * 1024 * 1024,
+			Canary: &filtering.CanaryConfig{
+				Clients:  []string{},
+				Tags:     []string{},
+				Duration: timeutil.Duration{Duration: timeutil.Day},
+				Enabled:  false,
+			},
 
-			EDNSClientSubnet: &dnsforward.EDNSClientSubnet{
-				CustomIP:  netip.Addr{},
-				Enabled:   false,
-				UseCustom: false,
+			Categories: &filtering.CategoriesConfig{
+				Blocked: []string{},
 			},
 
-			// set default maximum concurrent queries to 300
-			// we introduced a default limit due to this:
-			// https://github.com/AdguardTeam/AdGuardHome/issues/2015#issuecomment-674041912
-			// was later increased to 300 due to https://github.com/AdguardTeam/AdGuardHome/issues/2257
-			MaxGoroutines: 300,
+			SafeBrowsingProvider: &filtering.ProviderConfig{
+				BootstrapDNS: []string{},
+			},
+			ParentalProvider: &filtering.ProviderConfig{
+				BootstrapDNS: []string{},
+			},
+
+			ParentalBlockHost:     defaultParentalBlockHost,
+			SafeBrowsingBlockHost: defaultSafeBrowsingBlockHost,
 		},
-		UpstreamTimeout: timeutil.Duration{Duration: dnsforward.DefaultTimeout},
-		UsePrivateRDNS:  true,
-	},
-	TLS: tlsConfigSettings{
-		PortHTTPS:       defaultPortHTTPS,
-		PortDNSOverTLS:  defaultPortTLS, // needs to be passed through to dnsproxy
-		PortDNSOverQUIC: defaultPortQUIC,
-	},
-	QueryLog: queryLogConfig{
-		Enabled:     true,
-		FileEnabled: true,
-		Interval:    timeutil.Duration{Duration: 90 * timeutil.Day},
-		MemSize:     1000,
-		Ignored:     []string{},
-	},
-	Stats: statsConfig{
-		Enabled:  true,
-		Interval: timeutil.Duration{Duration: 1 * timeutil.Day},
-		Ignored:  []string{},
-	},
-	// NOTE: Keep these parameters in sync with the one put into
-	// client/src/helpers/filters/filters.js by scripts/vetted-filters.
-	//
-	// TODO(a.garipov): Think of a way to make scripts/vetted-filters update
-	// these as well if necessary.
-	Filters: []filtering.FilterYAML{{
-		Filter:  filtering.Filter{ID: 1},
-		Enabled: true,
-		URL:     "https://adguardteam.github.io/HostlistsRegistry/assets/filter_1.txt",
-		Name:    "AdGuard DNS filter",
-	}, {
-		Filter:  filtering.Filter{ID: 2},
-		Enabled: false,
-		URL:     "https://adguardteam.github.io/HostlistsRegistry/assets/filter_2.txt",
-		Name:    "AdAway Default Blocklist",
-	}},
-	Filtering: &filtering.Config{
-		ProtectionEnabled:  true,
-		BlockingMode:       filtering.BlockingModeDefault,
-		BlockedResponseTTL: 10, // in seconds
-
-		FilteringEnabled:           true,
-		FiltersUpdateIntervalHours: 24,
-
-		ParentalEnabled:     false,
-		SafeBrowsingEnabled: false,
-
-		SafeBrowsingCacheSize: 1 * 1024 * 1024,
-		SafeSearchCacheSize:   1 * 1024 * 1024,
-		ParentalCacheSize:     1 * 1024 * 1024,
-		CacheTime:             30,
-
-		SafeSearchConf: filtering.SafeSearchConfig{
-			Enabled:    false,
-			Bing:       true,
-			DuckDuckGo: true,
-			Google:     true,
-			Pixabay:    true,
-			Yandex:     true,
-			YouTube:    true,
+		DHCP: &dhcpd.ServerConfig{
+			LocalDomainName:  "lan",
+			HostnameConflict: dhcpd.HostnameConflictKeep,
+			Conf4: dhcpd.V4ServerConf{
+				LeaseDuration: dhcpd.DefaultDHCPLeaseTTL,
+				ICMPTimeout:   dhcpd.DefaultDHCPTimeoutICMP,
+			},
+			Conf6: dhcpd.V6ServerConf{
+				LeaseDuration: dhcpd.DefaultDHCPLeaseTTL,
+			},
+			LeaseSync: &dhcpd.LeaseSyncConfig{
+				Interval: timeutil.Duration{Duration: dhcpd.DefaultLeaseSyncInterval},
+			},
 		},
-
-		BlockedServices: &filtering.BlockedServices{
-			Schedule: schedule.EmptyWeekly(),
-			IDs:      []string{},
+		Clients: &clientsConfig{
+			Sources: &clientSourcesConfig{
+				WHOIS:     true,
+				ARP:       true,
+				RDNS:      true,
+				DHCP:      true,
+				HostsFile: true,
+				MDNS:      true,
+				NetBIOS:   false,
+				LLMNR:     false,
+
+				Fingerprint: true,
+			},
+			Quarantine: &quarantineConfig{
+				Group:         "",
+				Approved:      []string{},
+				Enabled:       false,
+				AllowlistOnly: true,
+			},
 		},
-
-		ParentalBlockHost:     defaultParentalBlockHost,
-		SafeBrowsingBlockHost: defaultSafeBrowsingBlockHost,
-	},
-	DHCP: &dhcpd.ServerConfig{
-		LocalDomainName: "lan",
-		Conf4: dhcpd.V4ServerConf{
-			LeaseDuration: dhcpd.DefaultDHCPLeaseTTL,
-			ICMPTimeout:   dhcpd.DefaultDHCPTimeoutICMP,
+		Federation: &federationConfig{
+			Peers:   []*federationPeer{},
+			Timeout: timeutil.Duration{Duration: defaultFederationTimeout},
+			Enabled: false,
+		},
+		Audit: &auditConfig{
+			URL:          "",
+			BufferSize:   defaultAuditBufferSize,
+			Enabled:      false,
+			LocalEnabled: true,
+		},
+		BlockHooks: []*blockHookConfig{},
+		Anomalies:  defaultAnomalyConfig(),
+		Schedules:  []*filteringSchedule{},
+		Notifications: &notificationsConfig{
+			Channels:          []*notificationChannel{},
+			CertificateExpiry: timeutil.Duration{Duration: defaultCertificateExpiry},
+		},
+		DDNS: &ddnsConfig{
+			IPv4URL:   defaultDDNSIPv4URL,
+			Providers: []*ddnsProviderConfig{},
+			Interval:  timeutil.Duration{Duration: defaultDDNSInterval},
+			Enabled:   false,
+		},
+		DNSUpdate: &dnsUpdateConfig{
+			Addresses: []netip.AddrPort{},
+			Keys:      []*dnsUpdateKeyConfig{},
+			Zones:     []*dnsUpdateZoneConfig{},
+			Enabled:   false,
+		},
+		ConfigHistory: &configHistoryConfig{
+			MaxVersions: defaultConfigHistoryMaxVersions,
+			Enabled:     true,
 		},
-		Conf6: dhcpd.V6ServerConf{
-			LeaseDuration: dhcpd.DefaultDHCPLeaseTTL,
+		ConfigSync: &configSyncConfig{
+			Interval: timeutil.Duration{Duration: defaultConfigSyncInterval},
 		},
-	},
-	Clients: &clientsConfig{
-		Sources: &clientSourcesConfig{
-			WHOIS:     true,
-			ARP:       true,
-			RDNS:      true,
-			DHCP:      true,
-			HostsFile: true,
+		Log: logSettings{
+			Compress:   false,
+			LocalTime:  false,
+			MaxBackups: 0,
+			MaxSize:    100,
+			MaxAge:     3,
 		},
-	},
-	Log: logSettings{
-		Compress:   false,
-		LocalTime:  false,
-		MaxBackups: 0,
-		MaxSize:    100,
-		MaxAge:     3,
-	},
-	OSConfig:      &osConfig{},
-	SchemaVersion: confmigrate.LastSchemaVersion,
-	Theme:         ThemeAuto,
+		OSConfig:      &osConfig{},
+		SchemaVersion: confmigrate.LastSchemaVersion,
+		Theme:         ThemeAuto,
+	}
 }
 
 // getConfigFilename returns path to the current config file
@@ -490,13 +756,24 @@ func parseConfig() (err error) {
 		}
 	}
 
-	err = yaml.Unmarshal(config.fileData, &config)
+	keyFile, err := secretsKeyFile(config.fileData)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	Context.secrets, err = newSecretsCipher(keyFile)
+	if err != nil {
+		return fmt.Errorf("secrets key: %w", err)
+	}
+
+	err = decodeConfig(config.fileData, config, Context.secrets)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return err
 	}
 
-	err = validateConfig()
+	err = validateConfig(config)
 	if err != nil {
 		return err
 	}
@@ -513,31 +790,47 @@ func parseConfig() (err error) {
 	return nil
 }
 
-// validateConfig returns error if the configuration is invalid.
-func validateConfig() (err error) {
-	err = validateBindHosts(config)
+// validateConfig returns error if conf is invalid.  It also sets the defaults
+// for some of the invalid values.
+func validateConfig(conf *configuration) (err error) {
+	err = validateBindHosts(conf)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return err
 	}
 
 	tcpPorts := aghalg.UniqChecker[tcpPort]{}
-	addPorts(tcpPorts, tcpPort(config.HTTPConfig.Address.Port()))
+	addPorts(tcpPorts, tcpPort(conf.HTTPConfig.Address.Port()))
+
+	if bp := conf.HTTPConfig.BlockPage; bp != nil && bp.Enabled {
+		addPorts(tcpPorts, tcpPort(bp.Address.Port()), tcpPort(bp.AddressHTTPS.Port()))
+	}
 
 	udpPorts := aghalg.UniqChecker[udpPort]{}
-	addPorts(udpPorts, udpPort(config.DNS.Port))
+	addPorts(udpPorts, udpPort(conf.DNS.Port))
 
-	if config.TLS.Enabled {
+	if conf.TLS.Enabled {
 		addPorts(
 			tcpPorts,
-			tcpPort(config.TLS.PortHTTPS),
-			tcpPort(config.TLS.PortDNSOverTLS),
-			tcpPort(config.TLS.PortDNSCrypt),
+			tcpPort(conf.TLS.PortHTTPS),
+			tcpPort(conf.TLS.PortDNSOverTLS),
+			tcpPort(conf.TLS.PortDNSCrypt),
 		)
 
-		// TODO(e.burkov):  Consider adding a udpPort with the same value when
-		// we add support for HTTP/3 for web admin interface.
-		addPorts(udpPorts, udpPort(config.TLS.PortDNSOverQUIC))
+		addPorts(
+			udpPorts,
+			udpPort(conf.TLS.PortDNSOverQUIC),
+			udpPort(conf.TLS.http3Port(conf.DNS.ServeHTTP3)),
+		)
+
+		if conf.TLS.PortDNSCrypt != 0 {
+			for _, c := range conf.TLS.DNSCryptResolvers {
+				if c != nil {
+					addPorts(tcpPorts, tcpPort(c.Port))
+					addPorts(udpPorts, udpPort(c.Port))
+				}
+			}
+		}
 	}
 
 	if err = tcpPorts.Validate(); err != nil {
@@ -546,8 +839,141 @@ func validateConfig() (err error) {
 		return fmt.Errorf("validating udp ports: %w", err)
 	}
 
-	if !filtering.ValidateUpdateIvl(config.Filtering.FiltersUpdateIntervalHours) {
-		config.Filtering.FiltersUpdateIntervalHours = 24
+	err = conf.HTTPConfig.BlockPage.validate()
+	if err != nil {
+		return fmt.Errorf("validating http block_page: %w", err)
+	}
+
+	err = validateTLSACME(&conf.TLS)
+	if err != nil {
+		return fmt.Errorf("validating tls acme: %w", err)
+	}
+
+	err = validateSNICertificates(conf.TLS.SNICertificates)
+	if err != nil {
+		return fmt.Errorf("validating tls sni_certificates: %w", err)
+	}
+
+	err = conf.TLS.ClientAuth.validate()
+	if err != nil {
+		return fmt.Errorf("validating tls client_auth: %w", err)
+	}
+
+	err = conf.TLS.ECH.validate()
+	if err != nil {
+		return fmt.Errorf("validating tls ech: %w", err)
+	}
+
+	err = validateDNSCrypt(&conf.TLS)
+	if err != nil {
+		return fmt.Errorf("validating tls dnscrypt: %w", err)
+	}
+
+	err = conf.Federation.validate()
+	if err != nil {
+		return fmt.Errorf("validating federation: %w", err)
+	}
+
+	err = validateUsers(conf.Users)
+	if err != nil {
+		return fmt.Errorf("validating users: %w", err)
+	}
+
+	err = conf.QueryLog.Ship.validate()
+	if err != nil {
+		return fmt.Errorf("validating querylog ship: %w", err)
+	}
+
+	err = conf.Audit.validate()
+	if err != nil {
+		return fmt.Errorf("validating audit: %w", err)
+	}
+
+	err = validateBlockHooks(conf.BlockHooks)
+	if err != nil {
+		return fmt.Errorf("validating block_hooks: %w", err)
+	}
+
+	err = conf.Anomalies.validate()
+	if err != nil {
+		return fmt.Errorf("validating anomaly_detection: %w", err)
+	}
+
+	err = conf.Notifications.validate()
+	if err != nil {
+		return fmt.Errorf("validating notifications: %w", err)
+	}
+
+	err = conf.DDNS.validate()
+	if err != nil {
+		return fmt.Errorf("validating ddns: %w", err)
+	}
+
+	err = conf.DNSUpdate.validate()
+	if err != nil {
+		return fmt.Errorf("validating dns_update: %w", err)
+	}
+
+	err = conf.ConfigHistory.validate()
+	if err != nil {
+		return fmt.Errorf("validating config_history: %w", err)
+	}
+
+	err = conf.ConfigSync.validate()
+	if err != nil {
+		return fmt.Errorf("validating config_sync: %w", err)
+	}
+
+	if !filtering.ValidateUpdateIvl(conf.Filtering.FiltersUpdateIntervalHours) {
+		conf.Filtering.FiltersUpdateIntervalHours = 24
+	}
+
+	return nil
+}
+
+// parseConfigData returns the configuration from the configuration file data,
+// which must be of the latest schema version, with the default values for the
+// missing properties.
+func parseConfigData(data []byte) (conf *configuration, err error) {
+	conf = newDefaultConfig()
+	err = decodeConfig(data, conf, Context.secrets)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	if conf.SchemaVersion != confmigrate.LastSchemaVersion {
+		return nil, fmt.Errorf(
+			"schema version %d: expected %d",
+			conf.SchemaVersion,
+			confmigrate.LastSchemaVersion,
+		)
+	}
+
+	err = validateConfig(conf)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// replaceFile replaces the configuration file with data.  After that, the
+// file isn't written anymore, so AdGuard Home must be restarted to apply the
+// configuration.
+func (c *configuration) replaceFile(data []byte) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.replaced.CompareAndSwap(false, true) {
+		return errors.Error("configuration file has already been replaced")
+	}
+
+	err = maybe.WriteFile(c.getConfigFilename(), data, 0o644)
+	if err != nil {
+		c.replaced.Store(false)
+
+		return fmt.Errorf("writing config file: %w", err)
 	}
 
 	return nil
@@ -586,6 +1012,10 @@ func (c *configuration) write() (err error) {
 	c.Lock()
 	defer c.Unlock()
 
+	if c.replaced.Load() {
+		return errors.Error("configuration file has been replaced, waiting for restart")
+	}
+
 	if Context.auth != nil {
 		config.Users = Context.auth.GetUsers()
 	}
@@ -600,6 +1030,7 @@ func (c *configuration) write() (err error) {
 		statsConf := stats.Config{}
 		Context.stats.WriteDiskConfig(&statsConf)
 		config.Stats.Interval = timeutil.Duration{Duration: statsConf.Limit}
+		config.Stats.DailyInterval = timeutil.Duration{Duration: statsConf.DailyLimit}
 		config.Stats.Enabled = statsConf.Enabled
 		config.Stats.Ignored = statsConf.Ignored.Values()
 	}
@@ -641,24 +1072,37 @@ func (c *configuration) write() (err error) {
 	}
 
 	config.Clients.Persistent = Context.clients.forConfig()
+	config.Clients.Groups = Context.clients.groupsForConfig()
+	config.Clients.Quarantine = Context.clients.quarantineForConfig()
+
+	if Context.schedules != nil {
+		config.Schedules = Context.schedules.forConfig()
+	}
+
+	if Context.notifications != nil {
+		config.Notifications = Context.notifications.forConfig()
+	}
 
 	configFile := config.getConfigFilename()
 	log.Debug("writing config file %q", configFile)
 
-	buf := &bytes.Buffer{}
-	enc := yaml.NewEncoder(buf)
-	enc.SetIndent(2)
-
-	err = enc.Encode(config)
+	data, err := marshalConfig(config)
 	if err != nil {
 		return fmt.Errorf("generating config file: %w", err)
 	}
 
-	err = maybe.WriteFile(configFile, buf.Bytes(), 0o644)
+	err = maybe.WriteFile(configFile, data, 0o644)
 	if err != nil {
 		return fmt.Errorf("writing config file: %w", err)
 	}
 
+	if hist := Context.configHistory; hist != nil {
+		err = hist.record(data, "", 0)
+		if err != nil {
+			log.Error("config history: %s", err)
+		}
+	}
+
 	return nil
 }
 
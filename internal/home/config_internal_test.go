@@ -0,0 +1,111 @@
+package home
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/confmigrate"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfigData(t *testing.T) {
+	schema := fmt.Sprintf("schema_version: %d\n", confmigrate.LastSchemaVersion)
+
+	testCases := []struct {
+		name       string
+		data       string
+		wantErrMsg string
+	}{{
+		name:       "defaults",
+		data:       schema,
+		wantErrMsg: "",
+	}, {
+		name: "json",
+		data: fmt.Sprintf(
+			`{"http":{"address":"127.0.0.1:3000"},"schema_version":%d}`,
+			confmigrate.LastSchemaVersion,
+		),
+		wantErrMsg: "",
+	}, {
+		name:       "bad_yaml",
+		data:       "schema_version: [\n",
+		wantErrMsg: "decoding: yaml: line 1: did not find expected node content",
+	}, {
+		name: "old_schema",
+		data: "schema_version: 1\n",
+		wantErrMsg: fmt.Sprintf(
+			"schema version 1: expected %d",
+			confmigrate.LastSchemaVersion,
+		),
+	}, {
+		name:       "bad_user",
+		data:       schema + "users:\n- name: a\n  role: bad\n",
+		wantErrMsg: `validating users: user at index 0: bad role "bad"`,
+	}, {
+		name:       "same_ports",
+		data:       schema + "tls:\n  enabled: true\n  port_https: 3000\n",
+		wantErrMsg: "validating tcp ports: duplicated values: [3000]",
+	}, {
+		name: "same_http3_port",
+		data: schema + "dns:\n  serve_http3: true\n" +
+			"tls:\n  enabled: true\n  port_dns_over_quic: 8853\n  port_http3: 8853\n",
+		wantErrMsg: "validating udp ports: duplicated values: [8853]",
+	}, {
+		name: "http3_port",
+		data: schema + "dns:\n  serve_http3: true\n" +
+			"tls:\n  enabled: true\n  port_dns_over_quic: 8853\n  port_http3: 8443\n",
+		wantErrMsg: "",
+	}, {
+		name: "acme_wildcard_http01",
+		data: schema + "tls:\n  acme:\n    enabled: true\n    challenge: http-01\n" +
+			"    domains:\n    - '*.example.com'\n",
+		wantErrMsg: "validating tls acme: validating config: domain at index 0: " +
+			"wildcards require dns-01 challenge",
+	}, {
+		name: "acme_bad_provider",
+		data: schema + "tls:\n  acme:\n    enabled: true\n    challenge: dns-01\n" +
+			"    domains:\n    - '*.example.com'\n    dns_provider:\n      type: exec\n",
+		wantErrMsg: "validating tls acme: dns_provider: exec provider requires a command",
+	}, {
+		name: "acme_local",
+		data: schema + "tls:\n  acme:\n    enabled: true\n    challenge: dns-01\n" +
+			"    domains:\n    - '*.example.com'\n    dns_provider:\n      type: local\n",
+		wantErrMsg: "",
+	}, {
+		name: "sni_certificate_no_key",
+		data: schema + "tls:\n  sni_certificates:\n" +
+			"  - server_name: dns.example.org\n    certificate_path: /cert.pem\n",
+		wantErrMsg: "validating tls sni_certificates: certificate at index 0: " +
+			"no private_key_path",
+	}, {
+		name: "client_auth_bad_field",
+		data: schema + "tls:\n  client_auth:\n    enabled: true\n" +
+			"    ca_path: /ca.pem\n    clientid_field: email\n",
+		wantErrMsg: `validating tls client_auth: clientid_field: bad value "email"`,
+	}, {
+		name: "dnscrypt_resolver_no_port",
+		data: schema + "tls:\n  dnscrypt_resolvers:\n" +
+			"  - config_file: /dnscrypt.yaml\n",
+		wantErrMsg: "validating tls dnscrypt: resolver at index 0: no port",
+	}, {
+		name: "dnscrypt_resolver_port_conflict",
+		data: schema + "tls:\n  enabled: true\n  port_dnscrypt: 5443\n" +
+			"  dnscrypt_resolvers:\n  - config_file: /dnscrypt.yaml\n    port: 5443\n",
+		wantErrMsg: "validating tcp ports: duplicated values: [5443]",
+	}, {
+		name:       "ech_no_public_name",
+		data:       schema + "tls:\n  ech:\n    enabled: true\n",
+		wantErrMsg: "validating tls ech: no public_name",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf, err := parseConfigData([]byte(tc.data))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg == "" {
+				assert.NotNil(t, conf)
+			}
+		})
+	}
+}
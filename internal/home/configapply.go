@@ -0,0 +1,313 @@
+package home
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// configAPI is the path of the HTTP API applying the whole configuration.  It's
+// only available to the administrators, since the configuration contains the
+// users and the other secrets.
+const configAPI = "/control/config"
+
+// maxConfigApplyReqSize is the maximum size of the body of a request to apply
+// the configuration.
+const maxConfigApplyReqSize = 16 * 1024 * 1024
+
+// configApplyJSON is the JSON structure for the response of the configuration
+// apply HTTP API.
+type configApplyJSON struct {
+	// Errors are the problems found in the configuration.  It's empty if the
+	// configuration is valid.
+	Errors []string `json:"errors"`
+
+	// Applied is true if the configuration has been applied.
+	Applied bool `json:"applied"`
+}
+
+// handlePutConfig is the handler for the PUT /control/config HTTP API.  The
+// body is the complete configuration file as a JSON object with the same
+// properties.  If the dry_run query parameter is true, the configuration is
+// only checked.  Otherwise, the configuration file is replaced and AdGuard
+// Home is restarted to apply it.
+func (web *webAPI) handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := strconv.ParseBool(stringutil.Coalesce(r.URL.Query().Get("dry_run"), "false"))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "parsing dry_run: %s", err)
+
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigApplyReqSize+1))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading body: %s", err)
+
+		return
+	} else if len(body) > maxConfigApplyReqSize {
+		aghhttp.Error(r, w, http.StatusRequestEntityTooLarge, "body is too large")
+
+		return
+	}
+
+	// JSON is a subset of YAML, so the body can be decoded the same way as the
+	// configuration file.
+	conf, err := parseConfigData(body)
+	if err != nil {
+		writeConfigApplyErrors(w, r, dryRun, []string{err.Error()})
+
+		return
+	}
+
+	if errs := checkConfig(conf); len(errs) > 0 {
+		writeConfigApplyErrors(w, r, dryRun, errs)
+
+		return
+	} else if dryRun {
+		aghhttp.WriteJSONResponseOK(w, r, &configApplyJSON{
+			Errors: []string{},
+		})
+
+		return
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "getting path: %s", err)
+
+		return
+	}
+
+	data, err := marshalConfig(conf)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding config: %s", err)
+
+		return
+	}
+
+	err = config.replaceFile(data)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "applying config: %s", err)
+
+		return
+	}
+
+	user := Context.auth.getCurrentUser(r).Name
+	if hist := Context.configHistory; hist != nil {
+		err = hist.record(data, user, 0)
+		if err != nil {
+			log.Error("config history: %s", err)
+		}
+	}
+
+	log.Info("config: user %q replaced the configuration, restarting to apply it", user)
+
+	aghhttp.WriteJSONResponseOK(w, r, &configApplyJSON{
+		Errors:  []string{},
+		Applied: true,
+	})
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	// See the comment in [webAPI.handleUpdate].
+	go finishUpdate(context.Background(), execPath, web.conf.runningAsService)
+}
+
+// writeConfigApplyErrors writes the response with the problems found in the
+// configuration.  The status code is OK for the dry runs.
+func writeConfigApplyErrors(w http.ResponseWriter, r *http.Request, dryRun bool, errs []string) {
+	code := http.StatusBadRequest
+	if dryRun {
+		code = http.StatusOK
+	}
+
+	aghhttp.WriteJSONResponse(w, r, code, &configApplyJSON{
+		Errors: errs,
+	})
+}
+
+// marshalConfig returns the configuration file data for conf.  The secrets are
+// encrypted, if the master key is set.
+func marshalConfig(conf *configuration) (data []byte, err error) {
+	doc := &yaml.Node{}
+	err = doc.Encode(conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	if Context.secrets != nil {
+		encryptSecrets(doc, Context.secrets)
+	}
+
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+
+	err = enc.Encode(doc)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// checkConfig returns the problems in the valid configuration conf, which
+// can't be applied: the changed master key file and commands, the unusable
+// upstream servers, and the invalid TLS certificates.
+func checkConfig(conf *configuration) (errs []string) {
+	// The secrets are encrypted with the current master key, so the key file
+	// can only be changed manually.
+	if conf.SecretsKeyFile != config.SecretsKeyFile {
+		errs = append(errs, "secrets_key_file: can't be changed using the http api")
+	}
+
+	config.RLock()
+	errs = append(errs, checkCommands(config, conf)...)
+	config.RUnlock()
+
+	if conf.TLS.Enabled {
+		err := loadTLSConf(&conf.TLS, &tlsConfigStatus{})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("tls: %s", err))
+		}
+	}
+
+	if Context.dnsServer == nil {
+		return errs
+	}
+
+	upsErrs, err := testUpstreams(&conf.DNS)
+	if err != nil {
+		return append(errs, err.Error())
+	}
+
+	hosts := maps.Keys(upsErrs)
+	slices.Sort(hosts)
+	for _, host := range hosts {
+		if upsErr := upsErrs[host]; upsErr != nil {
+			errs = append(errs, fmt.Sprintf("upstream %q: %s", host, upsErr))
+		}
+	}
+
+	return errs
+}
+
+// checkCommands returns the problems for each field of conf running an external
+// command, which differs from the one in prev.  Such fields can only be changed
+// in the configuration file, since otherwise the HTTP API would allow running
+// arbitrary commands.
+func checkCommands(prev, conf *configuration) (errs []string) {
+	prevCmds, cmds := configCommands(prev), configCommands(conf)
+
+	fields := maps.Keys(prevCmds)
+	for f := range cmds {
+		if _, ok := prevCmds[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+
+	slices.Sort(fields)
+	for _, f := range fields {
+		if !slices.EqualFunc(prevCmds[f], cmds[f], slices.Equal[[]string]) {
+			errs = append(errs, fmt.Sprintf("%s: can't be changed using the http api", f))
+		}
+	}
+
+	return errs
+}
+
+// validateCommands returns an error if the commands run by conf differ from the
+// ones in the current configuration.  See [checkCommands].
+func validateCommands(conf *configuration) (err error) {
+	config.RLock()
+	defer config.RUnlock()
+
+	if errs := checkCommands(config, conf); len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// configCommands returns the commands run by conf by the names of the fields
+// containing them.  There may be several commands for a field, since the names
+// of the block hooks and the notification channels aren't necessarily unique.
+func configCommands(conf *configuration) (cmds map[string][][]string) {
+	cmds = map[string][][]string{}
+	add := func(field string, cmd []string) {
+		if len(cmd) > 0 {
+			cmds[field] = append(cmds[field], cmd)
+		}
+	}
+
+	for _, h := range conf.BlockHooks {
+		add(fmt.Sprintf("block_hooks[%q].command", h.Name), h.Command)
+	}
+
+	if n := conf.Notifications; n != nil {
+		for _, ch := range n.Channels {
+			add(fmt.Sprintf("notifications.channels[%q].command", ch.Name), ch.Command)
+		}
+	}
+
+	if conf.DHCP != nil {
+		add("dhcp.lease_hook", conf.DHCP.LeaseHook)
+	}
+
+	if acme := conf.TLS.ACME; acme != nil && acme.DNSProvider != nil {
+		add("tls.acme.dns_provider.command", acme.DNSProvider.Command)
+	}
+
+	return cmds
+}
+
+// testUpstreams checks the upstream servers from dns and returns the errors
+// by the upstream addresses.  Context.dnsServer must not be nil.
+func testUpstreams(dns *dnsConfig) (upsErrs map[string]error, err error) {
+	upstreams := dns.UpstreamDNS
+	if dns.UpstreamDNSFileName != "" {
+		var data []byte
+		data, err = os.ReadFile(dns.UpstreamDNSFileName)
+		if err != nil {
+			return nil, fmt.Errorf("reading upstream dns file: %w", err)
+		}
+
+		upstreams = stringutil.SplitTrimmed(string(data), "\n")
+	}
+
+	var private []string
+	if dns.UsePrivateRDNS {
+		private = dns.LocalPTRResolvers
+	}
+
+	upsErrs = Context.dnsServer.TestUpstreams(
+		upstreams,
+		dns.FallbackDNS,
+		private,
+		dns.BootstrapDNS,
+	)
+
+	return upsErrs, nil
+}
+
+// registerConfigHandlers registers the HTTP handler applying the whole
+// configuration.
+func registerConfigHandlers(web *webAPI) {
+	httpRegister(http.MethodPut, configAPI, web.handlePutConfig)
+}
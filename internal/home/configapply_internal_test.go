@@ -0,0 +1,83 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCommands(t *testing.T) {
+	newConf := func(hook, channel, lease, acme []string) (conf *configuration) {
+		return &configuration{
+			BlockHooks: []*blockHookConfig{{
+				Name:    "hook",
+				Command: hook,
+			}},
+			Notifications: &notificationsConfig{
+				Channels: []*notificationChannel{{
+					Name:    "channel",
+					Command: channel,
+				}},
+			},
+			DHCP: &dhcpd.ServerConfig{
+				LeaseHook: lease,
+			},
+			TLS: tlsConfigSettings{
+				ACME: &acmeConfig{
+					DNSProvider: &acmeDNSProviderConfig{
+						Command: acme,
+					},
+				},
+			},
+		}
+	}
+
+	cmd := []string{"/bin/true"}
+	prev := newConf(cmd, cmd, cmd, cmd)
+
+	testCases := []struct {
+		conf *configuration
+		name string
+		want []string
+	}{{
+		conf: newConf(cmd, cmd, cmd, cmd),
+		name: "same",
+		want: nil,
+	}, {
+		conf: newConf([]string{"/bin/true", "arg"}, cmd, cmd, cmd),
+		name: "hook",
+		want: []string{`block_hooks["hook"].command: can't be changed using the http api`},
+	}, {
+		conf: newConf(cmd, nil, cmd, cmd),
+		name: "channel",
+		want: []string{
+			`notifications.channels["channel"].command: can't be changed using the http api`,
+		},
+	}, {
+		conf: newConf(cmd, cmd, []string{"/bin/sh"}, []string{"/bin/sh"}),
+		name: "lease_and_acme",
+		want: []string{
+			"dhcp.lease_hook: can't be changed using the http api",
+			"tls.acme.dns_provider.command: can't be changed using the http api",
+		},
+	}, {
+		conf: func() (conf *configuration) {
+			conf = newConf(cmd, cmd, cmd, cmd)
+			conf.BlockHooks = append(conf.BlockHooks, &blockHookConfig{
+				Name:    "hook",
+				Command: []string{"/bin/sh"},
+			})
+
+			return conf
+		}(),
+		name: "duplicate_name",
+		want: []string{`block_hooks["hook"].command: can't be changed using the http api`},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, checkCommands(prev, tc.conf))
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package home
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/v2/maybe"
+	"golang.org/x/exp/slices"
+)
+
+// configHistoryConfig is the configuration of the history of the changes of
+// the configuration file.
+type configHistoryConfig struct {
+	// MaxVersions is the maximum number of the kept versions of the
+	// configuration file.
+	MaxVersions int `yaml:"max_versions"`
+
+	// Enabled defines if the versions of the configuration file are kept.
+	Enabled bool `yaml:"enabled"`
+}
+
+// defaultConfigHistoryMaxVersions is the default maximum number of the kept
+// versions of the configuration file.
+const defaultConfigHistoryMaxVersions = 50
+
+// validate returns an error if the configuration history configuration is
+// invalid.
+func (c *configHistoryConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	if c.MaxVersions <= 0 {
+		return fmt.Errorf("max_versions: must be positive, got %d", c.MaxVersions)
+	}
+
+	return nil
+}
+
+// Configuration history file names.
+const (
+	// configHistoryDir is the name of the directory within the data directory
+	// containing the versions of the configuration file.
+	configHistoryDir = "config_history"
+
+	// configHistoryIndex is the name of the file within configHistoryDir
+	// containing the descriptions of the versions.
+	configHistoryIndex = "versions.json"
+)
+
+// configVersion is the description of a version of the configuration file.
+type configVersion struct {
+	// Time is the time of writing the version.
+	Time time.Time `json:"time"`
+
+	// User is the name of the user, who has made the change.  It's empty if
+	// the change wasn't made using the HTTP API, for example if the file was
+	// edited manually.
+	User string `json:"user,omitempty"`
+
+	// Diff is the unified diff from the previous version.  It's empty for the
+	// first kept version.
+	Diff string `json:"diff"`
+
+	// ID is the identifier of the version, which increases with every new
+	// version.
+	ID uint64 `json:"id"`
+
+	// RollbackOf is the identifier of the version, to which the configuration
+	// was rolled back, if the version is the result of a rollback.
+	RollbackOf uint64 `json:"rollback_of,omitempty"`
+}
+
+// configHistory keeps the versions of the configuration file.
+type configHistory struct {
+	// mu protects versions, last, and the files.
+	mu *sync.Mutex
+
+	// dir is the directory containing the versions.
+	dir string
+
+	// versions are the descriptions of the kept versions, the oldest first.
+	versions []*configVersion
+
+	// last is the data of the latest version.
+	last []byte
+
+	// maxVersions is the maximum number of the kept versions.
+	maxVersions int
+}
+
+// newConfigHistory returns a new properly initialized *configHistory keeping
+// the versions in dir.  maxVersions must be positive.
+func newConfigHistory(dir string, maxVersions int) (h *configHistory, err error) {
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("creating dir: %w", err)
+	}
+
+	h = &configHistory{
+		mu:          &sync.Mutex{},
+		dir:         dir,
+		maxVersions: maxVersions,
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, configHistoryIndex))
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+
+	err = json.Unmarshal(data, &h.versions)
+	if err != nil {
+		return nil, fmt.Errorf("decoding index: %w", err)
+	}
+
+	if len(h.versions) > 0 {
+		h.last, err = os.ReadFile(h.versionPath(h.versions[len(h.versions)-1].ID))
+		if err != nil {
+			log.Error("config history: reading latest version: %s", err)
+		}
+	}
+
+	return h, nil
+}
+
+// versionPath returns the path to the file of the version with id.
+func (h *configHistory) versionPath(id uint64) (p string) {
+	return filepath.Join(h.dir, strconv.FormatUint(id, 10)+".yaml")
+}
+
+// record keeps data as a new version made by the user, if it differs from the
+// latest one.  rollbackOf is the identifier of the version, to which the
+// configuration was rolled back, if any.
+func (h *configHistory) record(data []byte, user string, rollbackOf uint64) (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if bytes.Equal(data, h.last) {
+		return nil
+	}
+
+	v := &configVersion{
+		Time:       time.Now(),
+		User:       user,
+		ID:         1,
+		RollbackOf: rollbackOf,
+	}
+
+	if l := len(h.versions); l > 0 {
+		prev := h.versions[l-1].ID
+		v.ID = prev + 1
+		v.Diff = unifiedDiff(
+			string(h.last),
+			string(data),
+			fmt.Sprintf("version %d", prev),
+			fmt.Sprintf("version %d", v.ID),
+		)
+	}
+
+	err = maybe.WriteFile(h.versionPath(v.ID), data, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing version: %w", err)
+	}
+
+	h.versions = append(h.versions, v)
+	h.last = slices.Clone(data)
+
+	for len(h.versions) > h.maxVersions {
+		err = os.Remove(h.versionPath(h.versions[0].ID))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("config history: removing version %d: %s", h.versions[0].ID, err)
+		}
+
+		h.versions = h.versions[1:]
+	}
+
+	return h.writeIndex()
+}
+
+// writeIndex writes the descriptions of the versions.  h.mu must be locked.
+func (h *configHistory) writeIndex() (err error) {
+	data, err := json.Marshal(h.versions)
+	if err != nil {
+		return fmt.Errorf("encoding index: %w", err)
+	}
+
+	err = maybe.WriteFile(filepath.Join(h.dir, configHistoryIndex), data, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+
+	return nil
+}
+
+// lastID returns the identifier of the latest version or zero, if there are
+// none.
+func (h *configHistory) lastID() (id uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if l := len(h.versions); l > 0 {
+		return h.versions[l-1].ID
+	}
+
+	return 0
+}
+
+// attribute sets the user of the versions made after the version with id,
+// which have no user yet.  It's used to attribute the versions written during
+// a request to the user, who has made it.
+func (h *configHistory) attribute(id uint64, user string) (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	changed := false
+	for _, v := range h.versions {
+		if v.ID > id && v.User == "" && v.RollbackOf == 0 {
+			v.User = user
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return h.writeIndex()
+}
+
+// list returns the descriptions of the kept versions, the most recent first.
+func (h *configHistory) list() (versions []*configVersion) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions = make([]*configVersion, 0, len(h.versions))
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		v := *h.versions[i]
+		versions = append(versions, &v)
+	}
+
+	return versions
+}
+
+// versionData returns the data of the version with id.
+func (h *configHistory) versionData(id uint64) (data []byte, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !slices.ContainsFunc(h.versions, func(v *configVersion) (ok bool) { return v.ID == id }) {
+		return nil, fmt.Errorf("version %d: not found", id)
+	}
+
+	return os.ReadFile(h.versionPath(id))
+}
+
+// rollback replaces the configuration file with the version with id on behalf
+// of the user.  AdGuard Home must be restarted after a successful rollback to
+// apply it, see [configuration.replaceFile].
+func (h *configHistory) rollback(id uint64, user string) (err error) {
+	data, err := h.versionData(id)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	conf, err := parseConfigData(data)
+	if err != nil {
+		return fmt.Errorf("version %d: %w", id, err)
+	}
+
+	err = validateCommands(conf)
+	if err != nil {
+		return fmt.Errorf("version %d: %w", id, err)
+	}
+
+	err = config.replaceFile(data)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	log.Info("config history: user %q rolled back to version %d", user, id)
+
+	return h.record(data, user, id)
+}
@@ -0,0 +1,133 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigHistory(t *testing.T) {
+	dir := t.TempDir()
+
+	h, err := newConfigHistory(dir, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, h.record([]byte("a: 1\n"), "", 0))
+	require.NoError(t, h.record([]byte("a: 1\n"), "", 0))
+	assert.Equal(t, uint64(1), h.lastID())
+
+	require.NoError(t, h.record([]byte("a: 2\n"), "", 0))
+	require.NoError(t, h.attribute(1, "admin"))
+
+	versions := h.list()
+	require.Len(t, versions, 2)
+
+	assert.Equal(t, uint64(2), versions[0].ID)
+	assert.Equal(t, "admin", versions[0].User)
+	assert.Contains(t, versions[0].Diff, "-a: 1\n+a: 2\n")
+
+	assert.Equal(t, uint64(1), versions[1].ID)
+	assert.Empty(t, versions[1].User)
+	assert.Empty(t, versions[1].Diff)
+
+	t.Run("trim", func(t *testing.T) {
+		require.NoError(t, h.record([]byte("a: 3\n"), "", 0))
+
+		versions = h.list()
+		require.Len(t, versions, 2)
+
+		assert.Equal(t, uint64(3), versions[0].ID)
+		assert.Equal(t, uint64(2), versions[1].ID)
+
+		_, err = h.versionData(1)
+		testutil.AssertErrorMsg(t, "version 1: not found", err)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		var reloaded *configHistory
+		reloaded, err = newConfigHistory(dir, 2)
+		require.NoError(t, err)
+
+		got := reloaded.list()
+		require.Len(t, got, 2)
+
+		assert.Equal(t, versions[0].ID, got[0].ID)
+		assert.Equal(t, versions[1].User, got[1].User)
+		assert.Equal(t, versions[1].Diff, got[1].Diff)
+
+		var data []byte
+		data, err = reloaded.versionData(2)
+		require.NoError(t, err)
+
+		assert.Equal(t, "a: 2\n", string(data))
+
+		require.NoError(t, reloaded.record([]byte("a: 3\n"), "", 0))
+		assert.Equal(t, uint64(3), reloaded.lastID())
+	})
+}
+
+func TestConfigHistory_rollback(t *testing.T) {
+	h, err := newConfigHistory(t.TempDir(), 2)
+	require.NoError(t, err)
+
+	conf := newDefaultConfig()
+	conf.DHCP.LeaseHook = []string{"/bin/sh"}
+
+	data, err := marshalConfig(conf)
+	require.NoError(t, err)
+	require.NoError(t, h.record(data, "", 0))
+
+	err = h.rollback(h.lastID(), "admin")
+	testutil.AssertErrorMsg(t, "version 1: dhcp.lease_hook: can't be changed using the http api", err)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	const hdr = "--- a\n+++ b\n"
+
+	testCases := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{{
+		name: "equal",
+		a:    "a\nb\n",
+		b:    "a\nb\n",
+		want: "",
+	}, {
+		name: "change",
+		a:    "a\nb\nc\n",
+		b:    "a\nB\nc\n",
+		want: hdr + "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+	}, {
+		name: "from_empty",
+		a:    "",
+		b:    "a\n",
+		want: hdr + "@@ -0,0 +1 @@\n+a\n",
+	}, {
+		name: "no_newline",
+		a:    "a\nb",
+		b:    "a\nc",
+		want: hdr + "@@ -1,2 +1,2 @@\n a\n-b\n+c\n",
+	}, {
+		name: "two_hunks",
+		a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+		b:    "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+		want: hdr +
+			"@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n" +
+			"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n",
+	}, {
+		name: "one_hunk",
+		a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+		b:    "x\n2\n3\n4\n5\n6\n7\ny\n",
+		want: hdr + "@@ -1,8 +1,8 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, unifiedDiff(tc.a, tc.b, "a", "b"))
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package home
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/mathutil"
+)
+
+// diffContext is the number of the unchanged lines shown around the changes in
+// a unified diff.
+const diffContext = 3
+
+// maxDiffCells is the maximum number of the cells of the table used to find the
+// longest common subsequence of the changed lines.  Larger changes are shown as
+// a replacement of all the changed lines.
+const maxDiffCells = 1 << 22
+
+// diffLine is a single line of a unified diff.
+type diffLine struct {
+	// text is the line including the trailing newline.
+	text string
+
+	// op is either ' ', '-', or '+'.
+	op byte
+}
+
+// unifiedDiff returns the unified diff between a and b, which are named from
+// and to in the header.  It returns an empty string if a and b are equal.
+func unifiedDiff(a, b, from, to string) (diff string) {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	sb := &strings.Builder{}
+	for start := 0; start < len(lines); {
+		first, last, ok := nextHunk(lines, start)
+		if !ok {
+			break
+		}
+
+		if sb.Len() == 0 {
+			_, _ = fmt.Fprintf(sb, "--- %s\n+++ %s\n", from, to)
+		}
+
+		writeHunk(sb, lines, first, last)
+		start = last
+	}
+
+	return sb.String()
+}
+
+// nextHunk returns the bounds of the next hunk of lines starting the search at
+// start.  ok is false if there are no more changes.
+func nextHunk(lines []diffLine, start int) (first, last int, ok bool) {
+	first = start
+	for first < len(lines) && lines[first].op == ' ' {
+		first++
+	}
+
+	if first == len(lines) {
+		return 0, 0, false
+	}
+
+	last = first
+	for i := first; i < len(lines); i++ {
+		if lines[i].op == ' ' {
+			if i-last >= 2*diffContext {
+				break
+			}
+
+			continue
+		}
+
+		last = i + 1
+	}
+
+	return mathutil.Max(first-diffContext, start), mathutil.Min(last+diffContext, len(lines)), true
+}
+
+// writeHunk writes the hunk of lines from first to last to sb.
+func writeHunk(sb *strings.Builder, lines []diffLine, first, last int) {
+	var aStart, bStart int
+	for _, l := range lines[:first] {
+		if l.op != '+' {
+			aStart++
+		}
+
+		if l.op != '-' {
+			bStart++
+		}
+	}
+
+	var aLen, bLen int
+	for _, l := range lines[first:last] {
+		if l.op != '+' {
+			aLen++
+		}
+
+		if l.op != '-' {
+			bLen++
+		}
+	}
+
+	_, _ = fmt.Fprintf(sb, "@@ -%s +%s @@\n", diffRange(aStart, aLen), diffRange(bStart, bLen))
+	for _, l := range lines[first:last] {
+		sb.WriteByte(l.op)
+		sb.WriteString(l.text)
+	}
+}
+
+// diffRange formats the range of lines of a hunk, where start is the number of
+// the lines preceding it.
+func diffRange(start, n int) (s string) {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+}
+
+// splitLines splits s into lines keeping the newlines.  The last line gets a
+// newline added, if it doesn't have one.
+func splitLines(s string) (lines []string) {
+	if s == "" {
+		return nil
+	}
+
+	lines = strings.SplitAfter(s, "\n")
+	if l := len(lines) - 1; lines[l] == "" {
+		lines = lines[:l]
+	} else {
+		lines[l] += "\n"
+	}
+
+	return lines
+}
+
+// diffLines returns the edit script turning a into b.
+func diffLines(a, b []string) (lines []diffLine) {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	lines = make([]diffLine, 0, len(a)+len(b)-pre-suf)
+	for _, l := range a[:pre] {
+		lines = append(lines, diffLine{text: l, op: ' '})
+	}
+
+	lines = appendChanges(lines, a[pre:len(a)-suf], b[pre:len(b)-suf])
+
+	for _, l := range a[len(a)-suf:] {
+		lines = append(lines, diffLine{text: l, op: ' '})
+	}
+
+	return lines
+}
+
+// appendChanges appends the edit script turning a into b to lines using the
+// longest common subsequence of a and b.
+func appendChanges(lines []diffLine, a, b []string) (res []diffLine) {
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			lines = append(lines, diffLine{text: l, op: '-'})
+		}
+
+		for _, l := range b {
+			lines = append(lines, diffLine{text: l, op: '+'})
+		}
+
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = mathutil.Max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{text: a[i], op: ' '})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{text: a[i], op: '-'})
+			i++
+		default:
+			lines = append(lines, diffLine{text: b[j], op: '+'})
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{text: a[i], op: '-'})
+	}
+
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{text: b[j], op: '+'})
+	}
+
+	return lines
+}
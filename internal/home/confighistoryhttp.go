@@ -0,0 +1,112 @@
+package home
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// configHistoryAPIPrefix is the prefix of the HTTP APIs of the configuration
+// history.  They are only available to the administrators, since the versions
+// contain the secrets.
+const configHistoryAPIPrefix = "/control/config_history"
+
+// configHistoryJSON is the JSON structure for the response of the
+// configuration history HTTP API.
+type configHistoryJSON struct {
+	Versions []*configVersion `json:"versions"`
+}
+
+// handleConfigHistory is the handler for the GET /control/config_history HTTP
+// API.
+func handleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &configHistoryJSON{
+		Versions: Context.configHistory.list(),
+	})
+}
+
+// configRollbackJSON is the JSON structure for the request to roll back the
+// configuration.
+type configRollbackJSON struct {
+	ID uint64 `json:"id"`
+}
+
+// handleConfigRollback is the handler for the POST
+// /control/config_history/rollback HTTP API.  AdGuard Home is restarted after
+// a successful rollback to apply the configuration.
+func (web *webAPI) handleConfigRollback(w http.ResponseWriter, r *http.Request) {
+	req := &configRollbackJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "getting path: %s", err)
+
+		return
+	}
+
+	err = Context.configHistory.rollback(req.ID, Context.auth.getCurrentUser(r).Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "rolling back: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	log.Info("config history: restarting to apply version %d", req.ID)
+
+	// See the comment in [webAPI.handleUpdate].
+	go finishUpdate(context.Background(), execPath, web.conf.runningAsService)
+}
+
+// configHistoryHandler returns a handler attributing the versions of the
+// configuration file written during the requests changing the data to the
+// current user.
+func configHistoryHandler(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hist := Context.configHistory
+		if hist == nil || Context.auth == nil || !modifiesData(r.Method) {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		id := hist.lastID()
+
+		h.ServeHTTP(w, r)
+
+		user := Context.auth.getCurrentUser(r).Name
+		if user == "" {
+			return
+		}
+
+		err := hist.attribute(id, user)
+		if err != nil {
+			log.Error("config history: attributing versions: %s", err)
+		}
+	})
+}
+
+// registerConfigHistoryHandlers registers the HTTP handlers of the
+// configuration history, if it's enabled.
+func registerConfigHistoryHandlers(web *webAPI) {
+	if Context.configHistory == nil {
+		return
+	}
+
+	httpRegister(http.MethodGet, configHistoryAPIPrefix, handleConfigHistory)
+	httpRegister(http.MethodPost, configHistoryAPIPrefix+"/rollback", web.handleConfigRollback)
+}
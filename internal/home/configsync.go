@@ -0,0 +1,392 @@
+package home
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// configSyncConfig is the configuration of the replica mode, in which this
+// instance mirrors the filtering settings, the rewrites, the blocked services,
+// and the persistent clients from the primary instance.  The statistics and the
+// query log are kept separately.
+type configSyncConfig struct {
+	// PrimaryURL is the base URL of the primary instance's web interface, for
+	// example "https://192.168.1.2:3000".
+	PrimaryURL string `yaml:"primary_url"`
+
+	// Username is the name of the administrator to authenticate with on the
+	// primary instance.
+	Username string `yaml:"username"`
+
+	// Password is the password of the administrator to authenticate with on
+	// the primary instance.
+	Password string `yaml:"password"`
+
+	// Interval is the interval between the requests to the primary instance.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// Enabled defines if this instance is a replica.
+	Enabled bool `yaml:"enabled"`
+}
+
+// defaultConfigSyncInterval is the default interval between the requests to
+// the primary instance.
+const defaultConfigSyncInterval = 5 * time.Minute
+
+// validate returns an error if the sync configuration is invalid.
+func (c *configSyncConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	u, err := url.Parse(c.PrimaryURL)
+	if err != nil {
+		return fmt.Errorf("bad primary_url: %w", err)
+	} else if u.Scheme != aghhttp.SchemeHTTP && u.Scheme != aghhttp.SchemeHTTPS {
+		return fmt.Errorf("bad primary_url scheme %q", u.Scheme)
+	}
+
+	if c.Interval.Duration <= 0 {
+		return fmt.Errorf("interval: %w", errors.Error("must be positive"))
+	}
+
+	return nil
+}
+
+// syncAPIPrefix is the prefix of the HTTP APIs of the config sync, which are
+// only available to the administrators.
+const syncAPIPrefix = "/control/sync"
+
+// syncSnapshotAPI is the path of the HTTP API returning the settings mirrored
+// by the replicas.
+const syncSnapshotAPI = syncAPIPrefix + "/snapshot"
+
+// hdrValApplicationYAML is the content type of the sync snapshots.
+const hdrValApplicationYAML = "application/yaml"
+
+// maxSyncSnapshotSize is the maximum size of the snapshot from the primary
+// instance.
+const maxSyncSnapshotSize = 64 * 1024 * 1024
+
+// syncSnapshot are the settings mirrored by the replicas.
+type syncSnapshot struct {
+	// Filtering are the filtering settings, including the rewrites and the
+	// blocked services.
+	Filtering *filtering.SyncedConfig `yaml:"filtering"`
+
+	// Clients are the persistent clients.
+	Clients []*clientObject `yaml:"clients"`
+}
+
+// newSyncSnapshot returns the snapshot of the current settings.
+func newSyncSnapshot() (snap *syncSnapshot) {
+	return &syncSnapshot{
+		Filtering: Context.filters.SyncedConfig(),
+		Clients:   Context.clients.forConfig(),
+	}
+}
+
+// handleSyncSnapshot is the handler for the GET /control/sync/snapshot HTTP
+// API.
+func handleSyncSnapshot(w http.ResponseWriter, r *http.Request) {
+	data, err := yaml.Marshal(newSyncSnapshot())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding snapshot: %s", err)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, hdrValApplicationYAML)
+
+	_, err = w.Write(data)
+	if err != nil {
+		log.Debug("sync: writing snapshot: %s", err)
+	}
+}
+
+// configSync periodically mirrors the settings from the primary instance.
+type configSync struct {
+	// done is closed when the sync is closing.
+	done chan struct{}
+
+	// client is the HTTP client used to query the primary instance.
+	client *http.Client
+
+	// conf is the sync configuration.  It must not be modified after the sync
+	// is created.
+	conf *configSyncConfig
+
+	// mu protects the fields below.
+	mu *sync.Mutex
+
+	// lastSync is the time of the last successful sync.
+	lastSync time.Time
+
+	// lastChange is the time of the last sync, which changed the settings.
+	lastChange time.Time
+
+	// lastErr is the error of the last sync, if any.
+	lastErr error
+}
+
+// newConfigSync returns a new properly initialized *configSync.  conf must be
+// valid.
+func newConfigSync(conf *configSyncConfig, cli *http.Client) (s *configSync) {
+	return &configSync{
+		done:   make(chan struct{}),
+		client: cli,
+		conf:   conf,
+		mu:     &sync.Mutex{},
+	}
+}
+
+// start starts the periodic sync.
+func (s *configSync) start() {
+	go s.syncLoop()
+}
+
+// syncLoop periodically mirrors the settings from the primary instance until s
+// is closed.  It's intended to be used as a goroutine.
+func (s *configSync) syncLoop() {
+	defer log.OnPanic("sync: syncing")
+
+	ticker := time.NewTicker(s.conf.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		changed, err := s.sync()
+		s.setResult(changed, err, time.Now())
+		if err != nil {
+			log.Error("sync: %s", err)
+		} else if changed {
+			log.Info("sync: applied settings from %s", s.conf.PrimaryURL)
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			// Go on.
+		}
+	}
+}
+
+// setResult records the result of the sync at now.
+func (s *configSync) setResult(changed bool, err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = err
+	if err != nil {
+		return
+	}
+
+	s.lastSync = now
+	if changed {
+		s.lastChange = now
+	}
+}
+
+// sync fetches the snapshot from the primary instance and applies it, if it
+// differs from the current settings.
+func (s *configSync) sync() (changed bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.Interval.Duration)
+	defer cancel()
+
+	data, err := s.fetch(ctx)
+	if err != nil {
+		return false, fmt.Errorf("fetching snapshot: %w", err)
+	}
+
+	snap := &syncSnapshot{}
+	err = yaml.Unmarshal(data, snap)
+	if err != nil {
+		return false, fmt.Errorf("decoding snapshot: %w", err)
+	} else if snap.Filtering == nil {
+		return false, fmt.Errorf("snapshot: filtering: %w", errors.Error("no value"))
+	}
+
+	// Compare the normalized encodings so that the settings are only applied,
+	// if they have been changed on the primary instance or here.
+	remote, err := yaml.Marshal(snap)
+	if err != nil {
+		return false, fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	local, err := yaml.Marshal(newSyncSnapshot())
+	if err != nil {
+		return false, fmt.Errorf("encoding local settings: %w", err)
+	}
+
+	if bytes.Equal(remote, local) {
+		return false, nil
+	}
+
+	err = snap.apply()
+	if err != nil {
+		return false, fmt.Errorf("applying snapshot: %w", err)
+	}
+
+	return true, nil
+}
+
+// fetch requests the snapshot from the primary instance.
+func (s *configSync) fetch(ctx context.Context) (data []byte, err error) {
+	u, err := url.Parse(s.conf.PrimaryURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing url: %w", err)
+	}
+
+	u = u.JoinPath(syncSnapshotAPI)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	if s.conf.Username != "" {
+		req.SetBasicAuth(s.conf.Username, s.conf.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxSyncSnapshotSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+
+	return data, nil
+}
+
+// apply replaces the current settings with the ones from snap and writes the
+// configuration file.
+func (snap *syncSnapshot) apply() (err error) {
+	err = validateClientObjects(snap.Clients)
+	if err != nil {
+		return fmt.Errorf("clients: %w", err)
+	}
+
+	err = Context.filters.ApplySyncedConfig(snap.Filtering)
+	if err != nil {
+		return fmt.Errorf("filtering: %w", err)
+	}
+
+	for _, o := range Context.clients.forConfig() {
+		Context.clients.Del(o.Name)
+	}
+
+	err = Context.clients.addFromConfig(snap.Clients, config.Filtering)
+	if err != nil {
+		// Shouldn't happen, since the clients have already been validated.
+		return fmt.Errorf("clients: %w", err)
+	}
+
+	onConfigModified()
+
+	return nil
+}
+
+// validateClientObjects returns an error if any of objs can't be added to the
+// clients container.
+func validateClientObjects(objs []*clientObject) (err error) {
+	for i, o := range objs {
+		if o == nil {
+			return fmt.Errorf("client at index %d: %w", i, errors.Error("no value"))
+		} else if o.BlockedServices == nil {
+			return fmt.Errorf("client %q: blocked services: %w", o.Name, errors.Error("no value"))
+		}
+
+		err = o.BlockedServices.Validate()
+		if err != nil {
+			return fmt.Errorf("client %q: blocked services: %w", o.Name, err)
+		}
+
+		err = filtering.ValidateCategories(o.BlockedCategories)
+		if err != nil {
+			return fmt.Errorf("client %q: blocked categories: %w", o.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// close stops the periodic sync.
+func (s *configSync) close() {
+	close(s.done)
+}
+
+// configSyncStatusJSON is the response to the GET /control/sync/status HTTP
+// API.
+type configSyncStatusJSON struct {
+	// LastSync is the time of the last successful sync, if any.
+	LastSync *time.Time `json:"last_sync,omitempty"`
+
+	// LastChange is the time of the last sync, which changed the settings, if
+	// any.
+	LastChange *time.Time `json:"last_change,omitempty"`
+
+	// PrimaryURL is the URL of the primary instance.
+	PrimaryURL string `json:"primary_url,omitempty"`
+
+	// Error is the error of the last sync, if any.
+	Error string `json:"error,omitempty"`
+
+	// Replica is true if this instance is a replica.
+	Replica bool `json:"replica"`
+}
+
+// handleSyncStatus is the handler for the GET /control/sync/status HTTP API.
+func handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	resp := &configSyncStatusJSON{}
+
+	if s := Context.configSync; s != nil {
+		resp.Replica = true
+		resp.PrimaryURL = s.conf.PrimaryURL
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if !s.lastSync.IsZero() {
+			lastSync := s.lastSync
+			resp.LastSync = &lastSync
+		}
+
+		if !s.lastChange.IsZero() {
+			lastChange := s.lastChange
+			resp.LastChange = &lastChange
+		}
+
+		if s.lastErr != nil {
+			resp.Error = s.lastErr.Error()
+		}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// registerSyncHandlers registers HTTP handlers for the config sync API.
+func registerSyncHandlers() {
+	httpRegister(http.MethodGet, syncSnapshotAPI, handleSyncSnapshot)
+	httpRegister(http.MethodGet, syncAPIPrefix+"/status", handleSyncStatus)
+}
@@ -0,0 +1,107 @@
+package home
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSyncConfig_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *configSyncConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf:       &configSyncConfig{PrimaryURL: "ftp://1.2.3.4"},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: &configSyncConfig{
+			PrimaryURL: "https://1.2.3.4:3000",
+			Interval:   timeutil.Duration{Duration: time.Minute},
+			Enabled:    true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &configSyncConfig{
+			PrimaryURL: "ftp://1.2.3.4",
+			Interval:   timeutil.Duration{Duration: time.Minute},
+			Enabled:    true,
+		},
+		name:       "bad_scheme",
+		wantErrMsg: `bad primary_url scheme "ftp"`,
+	}, {
+		conf: &configSyncConfig{
+			PrimaryURL: "http://1.2.3.4",
+			Enabled:    true,
+		},
+		name:       "no_interval",
+		wantErrMsg: "interval: must be positive",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
+
+func TestConfigSync_fetch(t *testing.T) {
+	const (
+		user = "admin"
+		pass = "pass"
+		data = "filtering: {}\n"
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != user || p != pass {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		} else if r.URL.Path != syncSnapshotAPI {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	conf := &configSyncConfig{
+		PrimaryURL: srv.URL,
+		Username:   user,
+		Password:   pass,
+		Interval:   timeutil.Duration{Duration: time.Minute},
+		Enabled:    true,
+	}
+
+	ctx := context.Background()
+
+	s := newConfigSync(conf, srv.Client())
+	got, err := s.fetch(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, data, string(got))
+
+	t.Run("unauthorized", func(t *testing.T) {
+		badConf := *conf
+		badConf.Password = "bad"
+
+		_, err = newConfigSync(&badConf, srv.Client()).fetch(ctx)
+		testutil.AssertErrorMsg(t, "status code 401", err)
+	})
+}
@@ -2,6 +2,7 @@ package home
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
 	"net/netip"
 	"net/url"
@@ -191,6 +192,16 @@ func registerControlHandlers(web *webAPI) {
 	Context.mux.HandleFunc("/apple/doh.mobileconfig", postInstall(handleMobileConfigDoH))
 	Context.mux.HandleFunc("/apple/dot.mobileconfig", postInstall(handleMobileConfigDoT))
 	RegisterAuthHandlers()
+	registerAuditHandlers()
+	registerConfigHandlers(web)
+	registerBackupHandlers(web)
+	registerSyncHandlers()
+	registerConfigHistoryHandlers(web)
+	registerReloadHandlers()
+
+	logs := newLogControl()
+	registerDebugHandlers(logs)
+	registerSupportHandlers(logs)
 }
 
 func httpRegister(method, url string, handler http.HandlerFunc) {
@@ -200,7 +211,7 @@ func httpRegister(method, url string, handler http.HandlerFunc) {
 		return
 	}
 
-	Context.mux.Handle(url, postInstallHandler(optionalAuthHandler(gziphandler.GzipHandler(ensureHandler(method, handler)))))
+	Context.mux.Handle(url, postInstallHandler(optionalAuthHandler(auditHandler(configHistoryHandler(roleHandler(gziphandler.GzipHandler(ensureHandler(method, handler))))))))
 }
 
 // ensure returns a wrapped handler that makes sure that the request has the
@@ -239,6 +250,14 @@ func modifiesData(m string) (ok bool) {
 	return m == http.MethodPost || m == http.MethodPut || m == http.MethodDelete
 }
 
+// rawBodyAPIs are the HTTP APIs accepting bodies of media types other than
+// JSON mapped to those media types.  Like JSON, they can't be sent by HTML
+// forms.
+var rawBodyAPIs = map[string]string{
+	"/control/clients/import_csv": "text/csv",
+	restoreAPI:                    hdrValApplicationGzip,
+}
+
 // ensureContentType makes sure that the content type of a data-modifying
 // request is set correctly.  If it is not, ensureContentType writes a response
 // to w, and ok is false.
@@ -260,7 +279,12 @@ func ensureContentType(w http.ResponseWriter, r *http.Request) (ok bool) {
 
 	}
 
-	const wantCType = aghhttp.HdrValApplicationJSON
+	wantCType := aghhttp.HdrValApplicationJSON
+	if rawType, ok := rawBodyAPIs[r.URL.Path]; ok {
+		wantCType = rawType
+		cType, _, _ = mime.ParseMediaType(cType)
+	}
+
 	if cType == wantCType {
 		return true
 	}
@@ -337,14 +361,15 @@ func handleHTTPSRedirect(w http.ResponseWriter, r *http.Request) (proceed bool)
 
 	var (
 		forceHTTPS bool
-		serveHTTP3 bool
 		portHTTPS  uint16
+		portHTTP3  uint16
 	)
 	func() {
 		config.RLock()
 		defer config.RUnlock()
 
-		serveHTTP3, portHTTPS = config.DNS.ServeHTTP3, config.TLS.PortHTTPS
+		portHTTPS = config.TLS.PortHTTPS
+		portHTTP3 = config.TLS.http3Port(config.DNS.ServeHTTP3)
 		forceHTTPS = config.TLS.ForceHTTPS && config.TLS.Enabled && config.TLS.PortHTTPS != 0
 	}()
 
@@ -356,8 +381,8 @@ func handleHTTPSRedirect(w http.ResponseWriter, r *http.Request) (proceed bool)
 	//
 	// TODO(a.garipov): Consider adding a configurable max-age.  Currently, the
 	// default is 24 hours.
-	if serveHTTP3 {
-		altSvc := fmt.Sprintf(`h3=":%d"`, portHTTPS)
+	if portHTTP3 != 0 {
+		altSvc := fmt.Sprintf(`h3=":%d"`, portHTTP3)
 		respHdr.Set(httphdr.AltSvc, altSvc)
 	}
 
@@ -419,6 +419,7 @@ func (web *webAPI) handleInstallConfigure(w http.ResponseWriter, r *http.Request
 
 	u := &webUser{
 		Name: req.Username,
+		Role: roleAdmin,
 	}
 	err = Context.auth.Add(u, req.Password)
 	if err != nil {
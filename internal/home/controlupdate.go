@@ -181,13 +181,17 @@ func tlsConfUsesPrivilegedPorts(c *tlsConfigSettings) (ok bool) {
 
 // finishUpdate completes an update procedure.
 func finishUpdate(ctx context.Context, execPath string, runningAsService bool) {
-	var err error
-
 	log.Info("stopping all tasks")
 
 	cleanup(ctx)
 	cleanupAlways()
+	restartProcess(execPath, runningAsService)
+}
 
+// restartProcess starts a new instance of AdGuard Home from execPath and exits
+// the current one.  All modules must be stopped.
+func restartProcess(execPath string, runningAsService bool) {
+	var err error
 	if runtime.GOOS == "windows" {
 		if runningAsService {
 			// NOTE: We can't restart the service via "kardianos/service"
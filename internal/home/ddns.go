@@ -0,0 +1,183 @@
+package home
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/ddns"
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// ddnsConfig is the configuration of the dynamic DNS updater.  See
+// [ddns.Config].
+type ddnsConfig struct {
+	// IPv4URL is the address of the service returning the public IPv4
+	// address.  If empty, the A records aren't updated.
+	IPv4URL string `yaml:"ipv4_url"`
+
+	// IPv6URL is the address of the service returning the public IPv6
+	// address.  If empty, the AAAA records aren't updated.
+	IPv6URL string `yaml:"ipv6_url"`
+
+	// Providers are the configurations of the DDNS providers.
+	Providers []*ddnsProviderConfig `yaml:"providers"`
+
+	// Interval is the interval between the checks of the public addresses.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// Enabled defines if the records are updated.
+	Enabled bool `yaml:"enabled"`
+}
+
+// ddnsProviderConfig is the configuration of a single DDNS provider.  See
+// [ddns.ProviderConfig].
+type ddnsProviderConfig struct {
+	// Name is the name of the provider used in logs and in the status.
+	Name string `yaml:"name"`
+
+	// Type is the type of the provider.
+	Type ddns.ProviderType `yaml:"type"`
+
+	// Hostname is the hostname, which records are updated.
+	Hostname string `yaml:"hostname"`
+
+	// Token is the API token for Cloudflare or the account token for DuckDNS.
+	Token string `yaml:"token,omitempty"`
+
+	// ZoneID is the ID of the Cloudflare zone.
+	ZoneID string `yaml:"zone_id,omitempty"`
+
+	// Server is the address of the DNS server accepting the RFC 2136 updates.
+	Server string `yaml:"server,omitempty"`
+
+	// Zone is the zone containing Hostname for RFC 2136.
+	Zone string `yaml:"zone,omitempty"`
+
+	// TSIGKeyName is the name of the TSIG key for RFC 2136.
+	TSIGKeyName string `yaml:"tsig_key_name,omitempty"`
+
+	// TSIGAlgorithm is the algorithm of the TSIG key.
+	TSIGAlgorithm string `yaml:"tsig_algorithm,omitempty"`
+
+	// TSIGSecret is the base64-encoded secret of the TSIG key.
+	TSIGSecret string `yaml:"tsig_secret,omitempty"`
+
+	// TTL is the TTL of the records.
+	TTL timeutil.Duration `yaml:"ttl,omitempty"`
+}
+
+// defaultDDNSInterval is the default interval between the checks of the public
+// addresses.
+const defaultDDNSInterval = 5 * time.Minute
+
+// defaultDDNSIPv4URL is the default address of the service returning the
+// public IPv4 address.
+const defaultDDNSIPv4URL = "https://api.ipify.org"
+
+// toInternal returns the configuration of the updater.  onError is called with
+// each failed update, if not nil.
+func (c *ddnsConfig) toInternal(
+	onError func(name string, err error),
+) (conf *ddns.Config, err error) {
+	conf = &ddns.Config{
+		HTTPRegister: httpRegister,
+		OnError:      onError,
+		Providers:    make([]*ddns.ProviderConfig, 0, len(c.Providers)),
+		Interval:     c.Interval.Duration,
+	}
+
+	conf.IPv4URL, err = parseOptionalURL(c.IPv4URL)
+	if err != nil {
+		return nil, fmt.Errorf("ipv4_url: %w", err)
+	}
+
+	conf.IPv6URL, err = parseOptionalURL(c.IPv6URL)
+	if err != nil {
+		return nil, fmt.Errorf("ipv6_url: %w", err)
+	}
+
+	for i, p := range c.Providers {
+		if p == nil {
+			return nil, fmt.Errorf("provider at index %d: %w", i, errors.Error("no value"))
+		}
+
+		conf.Providers = append(conf.Providers, &ddns.ProviderConfig{
+			Name:          p.Name,
+			Type:          p.Type,
+			Hostname:      p.Hostname,
+			Token:         p.Token,
+			ZoneID:        p.ZoneID,
+			Server:        p.Server,
+			Zone:          p.Zone,
+			TSIGKeyName:   p.TSIGKeyName,
+			TSIGAlgorithm: p.TSIGAlgorithm,
+			TSIGSecret:    p.TSIGSecret,
+			TTL:           p.TTL.Duration,
+		})
+	}
+
+	return conf, nil
+}
+
+// parseOptionalURL parses s, unless it's empty, in which case u is nil.
+func parseOptionalURL(s string) (u *url.URL, err error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	return url.Parse(s)
+}
+
+// validate returns an error if the dynamic DNS configuration is invalid.
+func (c *ddnsConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	conf, err := c.toInternal(nil)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return conf.Validate()
+}
+
+// newDDNSUpdater returns a new dynamic DNS updater for c, or nil if it's
+// disabled.
+func newDDNSUpdater(c *ddnsConfig) (u *ddns.Updater, err error) {
+	if c == nil || !c.Enabled {
+		return nil, nil
+	}
+
+	conf, err := c.toInternal(onDDNSError)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	conf.HTTPClient = httpClient()
+
+	return ddns.New(conf)
+}
+
+// onDDNSError sends the notification about the failed update of the records of
+// the DDNS provider with the name.
+func onDDNSError(name string, err error) {
+	if Context.notifications == nil {
+		return
+	}
+
+	Context.notifications.notifyOnce(&notify.Event{
+		Time:    time.Now(),
+		Type:    notify.EventDDNSUpdateFailed,
+		Message: fmt.Sprintf("updating dynamic dns records of %q: %s", name, err),
+		Details: map[string]string{
+			"name":  name,
+			"error": err.Error(),
+		},
+	})
+}
@@ -0,0 +1,79 @@
+package home
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/ddns"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+func TestDDNSConfig_validate(t *testing.T) {
+	duck := &ddnsProviderConfig{
+		Name:     "duck",
+		Type:     ddns.ProviderDuckDNS,
+		Hostname: "example",
+		Token:    "token",
+	}
+
+	interval := timeutil.Duration{Duration: time.Minute}
+
+	testCases := []struct {
+		conf       *ddnsConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf: &ddnsConfig{
+			Enabled: false,
+		},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: &ddnsConfig{
+			IPv4URL:   defaultDDNSIPv4URL,
+			Providers: []*ddnsProviderConfig{duck},
+			Interval:  interval,
+			Enabled:   true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &ddnsConfig{
+			IPv4URL:   "ftp://ip.example",
+			Providers: []*ddnsProviderConfig{duck},
+			Interval:  interval,
+			Enabled:   true,
+		},
+		name:       "bad_url",
+		wantErrMsg: `address detection url: bad scheme "ftp"`,
+	}, {
+		conf: &ddnsConfig{
+			IPv4URL:   defaultDDNSIPv4URL,
+			Providers: []*ddnsProviderConfig{nil},
+			Interval:  interval,
+			Enabled:   true,
+		},
+		name:       "nil_provider",
+		wantErrMsg: "provider at index 0: no value",
+	}, {
+		conf: &ddnsConfig{
+			IPv4URL:   defaultDDNSIPv4URL,
+			Providers: []*ddnsProviderConfig{},
+			Interval:  interval,
+			Enabled:   true,
+		},
+		name:       "no_providers",
+		wantErrMsg: "no providers",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package home
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+const (
+	// defaultDebugProfileDuration is the default duration of capturing the
+	// profiles, which are collected over time.
+	defaultDebugProfileDuration = 10 * time.Second
+
+	// maxDebugProfileDuration is the maximum duration of capturing the
+	// profiles, which are collected over time.  It must be less than
+	// writeTimeout.
+	maxDebugProfileDuration = 30 * time.Second
+)
+
+// errProfileInProgress is returned when another profile, which is collected
+// over time, is being captured.
+const errProfileInProgress errors.Error = "another profile is being captured"
+
+// profileCapturing is true while a profile, which is collected over time, is
+// being captured.
+var profileCapturing atomic.Bool
+
+// timedProfiles are the names of the profiles, which are collected over time.
+var timedProfiles = stringutil.NewSet("block", "cpu", "mutex", "trace")
+
+// snapshotProfiles are the names of the profiles, which are written right away.
+var snapshotProfiles = stringutil.NewSet("allocs", "goroutine", "heap", "threadcreate")
+
+// handleDebugProfile is the handler for the GET /control/debug/profile HTTP
+// API.  The profile parameter is the name of the profile.  The profiles, which
+// are collected over time, are captured for the number of seconds in the
+// seconds parameter.  The debug parameter is the same as in
+// [pprof.Profile.WriteTo], so that debug=2 returns the goroutine dump.
+func handleDebugProfile(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	name := q.Get("profile")
+	timed := timedProfiles.Has(name)
+	if !timed && !snapshotProfiles.Has(name) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "unknown profile %q", name)
+
+		return
+	}
+
+	dur, err := parseProfileDuration(q.Get("seconds"))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "seconds: %s", err)
+
+		return
+	}
+
+	debug, err := strconv.Atoi(stringutil.Coalesce(q.Get("debug"), "0"))
+	if err != nil || debug < 0 || debug > 2 {
+		aghhttp.Error(r, w, http.StatusBadRequest, "debug: bad value %q", q.Get("debug"))
+
+		return
+	}
+
+	h := w.Header()
+	if debug > 0 && name != "cpu" && name != "trace" {
+		h.Set(httphdr.ContentType, aghhttp.HdrValTextPlain)
+	} else {
+		h.Set(httphdr.ContentType, "application/octet-stream")
+		h.Set(httphdr.ContentDisposition, fmt.Sprintf(`attachment; filename="%s.pprof"`, name))
+	}
+
+	if !timed {
+		err = pprof.Lookup(name).WriteTo(w, debug)
+		if err != nil {
+			// The headers have already been sent, so just log the error.
+			log.Error("debug: writing %s profile: %s", name, err)
+		}
+
+		return
+	}
+
+	if !profileCapturing.CompareAndSwap(false, true) {
+		h.Del(httphdr.ContentDisposition)
+		aghhttp.Error(r, w, http.StatusConflict, "%s", errProfileInProgress)
+
+		return
+	}
+	defer profileCapturing.Store(false)
+
+	log.Info("debug: capturing %s profile for %s", name, dur)
+
+	err = captureProfile(r.Context(), w, name, dur, debug)
+	if err != nil {
+		h.Del(httphdr.ContentDisposition)
+		aghhttp.Error(r, w, http.StatusInternalServerError, "capturing %s profile: %s", name, err)
+	}
+}
+
+// parseProfileDuration parses the duration of capturing a profile in seconds
+// from s.  If s is empty, defaultDebugProfileDuration is returned.
+func parseProfileDuration(s string) (dur time.Duration, err error) {
+	if s == "" {
+		return defaultDebugProfileDuration, nil
+	}
+
+	secs, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return 0, err
+	}
+
+	dur = time.Duration(secs) * time.Second
+	if dur <= 0 || dur > maxDebugProfileDuration {
+		return 0, fmt.Errorf("must be from 1 to %d", maxDebugProfileDuration/time.Second)
+	}
+
+	return dur, nil
+}
+
+// captureProfile captures the profile with name, which must be one of
+// timedProfiles, for dur and writes it to w.  The error is only returned if
+// nothing has been written to w yet.
+func captureProfile(
+	ctx context.Context,
+	w io.Writer,
+	name string,
+	dur time.Duration,
+	debug int,
+) (err error) {
+	switch name {
+	case "cpu":
+		err = pprof.StartCPUProfile(w)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		defer pprof.StopCPUProfile()
+	case "trace":
+		err = trace.Start(w)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		defer trace.Stop()
+	case "block":
+		runtime.SetBlockProfileRate(1)
+		defer runtime.SetBlockProfileRate(0)
+	case "mutex":
+		prev := runtime.SetMutexProfileFraction(1)
+		defer runtime.SetMutexProfileFraction(prev)
+	}
+
+	sleepContext(ctx, dur)
+
+	if name == "block" || name == "mutex" {
+		err = pprof.Lookup(name).WriteTo(w, debug)
+		if err != nil {
+			log.Error("debug: writing %s profile: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// sleepContext waits for dur or until ctx is canceled.
+func sleepContext(ctx context.Context, dur time.Duration) {
+	timer := time.NewTimer(dur)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
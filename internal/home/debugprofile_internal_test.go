@@ -0,0 +1,51 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProfileDuration(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         string
+		wantErrMsg string
+		want       int
+	}{{
+		name:       "default",
+		in:         "",
+		wantErrMsg: "",
+		want:       10,
+	}, {
+		name:       "valid",
+		in:         "5",
+		wantErrMsg: "",
+		want:       5,
+	}, {
+		name:       "zero",
+		in:         "0",
+		wantErrMsg: "must be from 1 to 30",
+		want:       0,
+	}, {
+		name:       "too_long",
+		in:         "31",
+		wantErrMsg: "must be from 1 to 30",
+		want:       0,
+	}, {
+		name:       "bad",
+		in:         "1s",
+		wantErrMsg: `strconv.ParseUint: parsing "1s": invalid syntax`,
+		want:       0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dur, err := parseProfileDuration(tc.in)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.want, int(dur.Seconds()))
+		})
+	}
+}
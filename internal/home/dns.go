@@ -6,13 +6,14 @@ import (
 	"net"
 	"net/netip"
 	"net/url"
-	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
@@ -21,8 +22,6 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
-	"github.com/ameshkov/dnscrypt/v2"
-	yaml "gopkg.in/yaml.v3"
 )
 
 // Default listening ports.
@@ -50,9 +49,16 @@ func initDNS() (err error) {
 
 	anonymizer := config.anonymizer()
 
+	statsCipher, err := newAtRestCipher(config.Stats.EncryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("statistics: %w", err)
+	}
+
 	statsConf := stats.Config{
+		Cipher:            statsCipher,
 		Filename:          filepath.Join(baseDir, "stats.db"),
 		Limit:             config.Stats.Interval.Duration,
+		DailyLimit:        config.Stats.DailyInterval.Duration,
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 		Enabled:           config.Stats.Enabled,
@@ -70,7 +76,19 @@ func initDNS() (err error) {
 		return fmt.Errorf("init stats: %w", err)
 	}
 
+	queryLogCipher, err := newAtRestCipher(config.QueryLog.EncryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("querylog: %w", err)
+	}
+
+	shipConf, err := config.QueryLog.Ship.toInternal()
+	if err != nil {
+		return fmt.Errorf("querylog: ship: %w", err)
+	}
+
 	conf := querylog.Config{
+		Cipher:            queryLogCipher,
+		Ship:              shipConf,
 		Anonymizer:        anonymizer,
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
@@ -100,6 +118,25 @@ func initDNS() (err error) {
 		return err
 	}
 
+	Context.blockHook, err = newBlockHookNotifier(config.BlockHooks)
+	if err != nil {
+		return fmt.Errorf("init block hooks: %w", err)
+	}
+
+	Context.anomalies, err = newAnomalyDetector(config.Anomalies)
+	if err != nil {
+		return fmt.Errorf("init anomaly detection: %w", err)
+	}
+
+	hooks := blockhook.Multi{}
+	if Context.blockHook != nil {
+		hooks = append(hooks, Context.blockHook)
+	}
+
+	if Context.integration != nil {
+		hooks = append(hooks, Context.integration.blocked)
+	}
+
 	tlsConf := &tlsConfigSettings{}
 	Context.tls.WriteDiskConfig(tlsConf)
 
@@ -107,6 +144,7 @@ func initDNS() (err error) {
 		Context.filters,
 		Context.stats,
 		Context.queryLog,
+		hooks,
 		Context.dhcpServer,
 		anonymizer,
 		httpRegister,
@@ -114,6 +152,21 @@ func initDNS() (err error) {
 	)
 }
 
+// newAtRestCipher returns the cipher for encrypting the data stored on disk
+// with the key from keyFile.  c is nil if keyFile is empty.
+func newAtRestCipher(keyFile string) (c *aghcrypto.Cipher, err error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+
+	c, err = aghcrypto.NewCipherFromFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+
+	return c, nil
+}
+
 // initDNSServer initializes the [context.dnsServer].  To only use the internal
 // proxy, none of the arguments are required, but tlsConf still must not be nil,
 // in other cases all the arguments also must not be nil.  It also must not be
@@ -122,6 +175,7 @@ func initDNSServer(
 	filters *filtering.DNSFilter,
 	sts stats.Interface,
 	qlog querylog.QueryLog,
+	hook blockhook.Interface,
 	dhcpSrv dnsforward.DHCP,
 	anonymizer *aghnet.IPMut,
 	httpReg aghhttp.RegisterFunc,
@@ -132,10 +186,19 @@ func initDNSServer(
 		return fmt.Errorf("preparing set of private subnets: %w", err)
 	}
 
+	var dnsMetrics *dnsforward.Metrics
+	if Context.metrics != nil {
+		dnsMetrics = Context.metrics.dns
+	}
+
 	Context.dnsServer, err = dnsforward.NewServer(dnsforward.DNSCreateParams{
 		DNSFilter:   filters,
 		Stats:       sts,
 		QueryLog:    qlog,
+		BlockHook:   hook,
+		Metrics:     dnsMetrics,
+		Anomalies:   Context.anomalies,
+		Notifier:    notifier(),
 		PrivateNets: privateNets,
 		Anonymizer:  anonymizer,
 		LocalDomain: config.DHCP.LocalDomainName,
@@ -256,6 +319,8 @@ func newServerConfig(
 		CatchPanics:      true,
 		UseRDNS:          config.Clients.Sources.RDNS,
 		UseWHOIS:         config.Clients.Sources.WHOIS,
+		UseLLMNR:         config.Clients.Sources.LLMNR,
+		UseNetBIOS:       config.Clients.Sources.NetBIOS,
 	}
 
 	if tlsConf.Enabled {
@@ -266,6 +331,10 @@ func newServerConfig(
 			newConf.HTTPSListenAddrs = ipsToTCPAddrs(hosts, tlsConf.PortHTTPS)
 		}
 
+		if p := tlsConf.http3Port(dnsConf.ServeHTTP3); p != 0 {
+			newConf.HTTP3ListenAddrs = ipsToUDPAddrs(hosts, p)
+		}
+
 		if tlsConf.PortDNSOverTLS != 0 {
 			newConf.TLSListenAddrs = ipsToTCPAddrs(hosts, tlsConf.PortDNSOverTLS)
 		}
@@ -289,6 +358,9 @@ func newServerConfig(
 
 	newConf.FilterHandler = applyAdditionalFiltering
 	newConf.GetCustomUpstreamByClient = Context.clients.findUpstreams
+	newConf.GetClientMinTTL = Context.clients.findMinTTL
+	newConf.GetClientRatelimit = Context.clients.findRatelimit
+	newConf.UserAgentHandler = Context.clients.onUserAgent
 
 	newConf.LocalPTRResolvers = dnsConf.LocalPTRResolvers
 	newConf.UpstreamTimeout = dnsConf.UpstreamTimeout.Duration
@@ -305,29 +377,35 @@ func newDNSCrypt(hosts []netip.Addr, tlsConf tlsConfigSettings) (dnscc dnsforwar
 		return dnscc, errors.Error("no dnscrypt_config_file")
 	}
 
-	f, err := os.Open(tlsConf.DNSCryptConfigFile)
+	rotation := tlsConf.DNSCryptRotationInterval.Duration
+	cert, providerName, err := newDNSCryptCert(tlsConf.DNSCryptConfigFile, rotation)
 	if err != nil {
-		return dnscc, fmt.Errorf("opening dnscrypt config: %w", err)
+		// Don't wrap the error, because it's informative enough as is.
+		return dnscc, err
 	}
-	defer func() { err = errors.WithDeferred(err, f.Close()) }()
 
-	rc := &dnscrypt.ResolverConfig{}
-	err = yaml.NewDecoder(f).Decode(rc)
-	if err != nil {
-		return dnscc, fmt.Errorf("decoding dnscrypt config: %w", err)
-	}
+	var resolvers []*dnsforward.DNSCryptResolver
+	for i, c := range tlsConf.DNSCryptResolvers {
+		r := &dnsforward.DNSCryptResolver{
+			UDPListenAddrs: ipsToUDPAddrs(hosts, c.Port),
+			TCPListenAddrs: ipsToTCPAddrs(hosts, c.Port),
+		}
 
-	cert, err := rc.CreateCert()
-	if err != nil {
-		return dnscc, fmt.Errorf("creating dnscrypt cert: %w", err)
+		r.ResolverCert, r.ProviderName, err = newDNSCryptCert(c.ConfigFile, rotation)
+		if err != nil {
+			return dnscc, fmt.Errorf("dnscrypt resolver at index %d: %w", i, err)
+		}
+
+		resolvers = append(resolvers, r)
 	}
 
 	return dnsforward.DNSCryptConfig{
-		ResolverCert:   cert,
-		ProviderName:   rc.ProviderName,
-		UDPListenAddrs: ipsToUDPAddrs(hosts, tlsConf.PortDNSCrypt),
-		TCPListenAddrs: ipsToTCPAddrs(hosts, tlsConf.PortDNSCrypt),
-		Enabled:        true,
+		ResolverCert:        cert,
+		ProviderName:        providerName,
+		UDPListenAddrs:      ipsToUDPAddrs(hosts, tlsConf.PortDNSCrypt),
+		TCPListenAddrs:      ipsToTCPAddrs(hosts, tlsConf.PortDNSCrypt),
+		AdditionalResolvers: resolvers,
+		Enabled:             true,
 	}, nil
 }
 
@@ -375,9 +453,41 @@ func getDNSEncryption() (de dnsEncryption) {
 	return de
 }
 
+// blockedClients returns the disallowed clients of the DNS server.
+func blockedClients() (clients []string) {
+	if Context.dnsServer == nil {
+		return nil
+	}
+
+	return Context.dnsServer.DisallowedClients()
+}
+
+// addBlockedClients adds clients to the disallowed clients of the DNS server.
+func addBlockedClients(clients []string) (added int, err error) {
+	if Context.dnsServer == nil {
+		return 0, errors.Error("dns server is not initialized")
+	}
+
+	return Context.dnsServer.AddDisallowedClients(clients)
+}
+
 // applyAdditionalFiltering adds additional client information and settings if
-// the client has them.
+// the client has them, then applies the active filtering schedules, the pause
+// of the protection, and the pause of the internet access for the client, if
+// any.
 func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filtering.Settings) {
+	applyClientFiltering(clientIP, clientID, setts)
+
+	now := time.Now()
+	Context.schedules.apply(setts, clientID, now)
+	Context.clients.applyPause(setts, clientID, now)
+	Context.clients.applyInternetPause(setts, clientID, now)
+	Context.blockPage.applyUnblocks(setts, clientIP, now)
+}
+
+// applyClientFiltering adds additional client information and settings if the
+// client has them.
+func applyClientFiltering(clientIP netip.Addr, clientID string, setts *filtering.Settings) {
 	// pref is a prefix for logging messages around the scope.
 	const pref = "applying filters"
 
@@ -394,13 +504,24 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 	c, ok := Context.clients.Find(clientID)
 	if !ok {
 		c, ok = Context.clients.Find(clientIP.String())
-		if !ok {
-			log.Debug("%s: no clients with ip %s and clientid %q", pref, clientIP, clientID)
+	}
+
+	if !ok {
+		now := time.Now()
+		Context.clients.applyGroups(setts, clientIP, clientID, nil, now)
+		if Context.clients.applyQuarantine(setts, clientIP, now) {
+			log.Debug("%s: client with ip %s is quarantined", pref, clientIP)
 
 			return
 		}
+
+		log.Debug("%s: no clients with ip %s and clientid %q", pref, clientIP, clientID)
+
+		return
 	}
 
+	Context.clients.applyGroups(setts, clientIP, clientID, c, time.Now())
+
 	log.Debug("%s: using settings for client %q (%s; %q)", pref, c.Name, clientIP, clientID)
 
 	if c.UseOwnBlockedServices {
@@ -413,6 +534,12 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 		}
 	}
 
+	if c.UseOwnBlockedCategories {
+		setts.BlockedCategories = c.BlockedCategories
+	}
+
+	setts.AllowlistOnly = c.AllowlistOnly
+
 	setts.ClientName = c.Name
 	setts.ClientTags = c.Tags
 	if !c.UseOwnSettings {
@@ -434,7 +561,13 @@ func startDNSServer() error {
 		return fmt.Errorf("unable to start forwarding DNS server: Already running")
 	}
 
-	Context.filters.EnableFilters(false)
+	// Compile the filter lists in the background after the filtering module
+	// has been started, if configured and not all of them are compiled yet,
+	// since that is what starts the goroutine compiling them.
+	lazy := config.Filtering.LazyLoading && !Context.filters.FiltersCompiled()
+	if !lazy {
+		Context.filters.EnableFilters(false)
+	}
 
 	Context.clients.Start()
 
@@ -444,27 +577,45 @@ func startDNSServer() error {
 	}
 
 	Context.filters.Start()
+	if lazy {
+		Context.filters.EnableFilters(true)
+	}
 	Context.stats.Start()
 	Context.queryLog.Start()
 
+	if Context.blockHook != nil {
+		Context.blockHook.Start()
+	}
+
 	return nil
 }
 
+// reconfigureDNSServer applies the current configuration to the DNS server.
+// See [reloadDNSServer].
 func reconfigureDNSServer() (err error) {
+	_, err = reloadDNSServer()
+
+	return err
+}
+
+// reloadDNSServer applies the current configuration to the DNS server.  The
+// listeners are only restarted, and restarted is true, if their settings have
+// changed.
+func reloadDNSServer() (restarted bool, err error) {
 	tlsConf := &tlsConfigSettings{}
 	Context.tls.WriteDiskConfig(tlsConf)
 
 	newConf, err := newServerConfig(tlsConf, httpRegister)
 	if err != nil {
-		return fmt.Errorf("generating forwarding dns server config: %w", err)
+		return false, fmt.Errorf("generating forwarding dns server config: %w", err)
 	}
 
-	err = Context.dnsServer.Reconfigure(newConf)
+	restarted, err = Context.dnsServer.Reload(newConf)
 	if err != nil {
-		return fmt.Errorf("starting forwarding dns server: %w", err)
+		return false, fmt.Errorf("reloading forwarding dns server: %w", err)
 	}
 
-	return nil
+	return restarted, nil
 }
 
 func stopDNSServer() (err error) {
@@ -509,6 +660,13 @@ func closeDNSServer() {
 		Context.queryLog.Close()
 	}
 
+	if Context.blockHook != nil {
+		Context.blockHook.Close()
+		Context.blockHook = nil
+	}
+
+	Context.anomalies = nil
+
 	log.Debug("all dns modules are closed")
 }
 
@@ -0,0 +1,241 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/ameshkov/dnscrypt/v2"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// dnsCryptResolverConfig is the configuration of an additional DNSCrypt
+// resolver with its own provider name and keys.
+type dnsCryptResolverConfig struct {
+	// ConfigFile is the path to the DNSCrypt configuration file of the
+	// resolver, in the same format as DNSCryptConfigFile.
+	ConfigFile string `yaml:"config_file"`
+
+	// Port is the port for the DNSCrypt requests to the resolver.  It must
+	// differ from the ports of the other resolvers.
+	Port uint16 `yaml:"port"`
+}
+
+// validateDNSCrypt returns an error if the additional DNSCrypt resolvers or the
+// rotation interval of conf are invalid.
+func validateDNSCrypt(conf *tlsConfigSettings) (err error) {
+	if conf.DNSCryptRotationInterval.Duration < 0 {
+		return fmt.Errorf(
+			"dnscrypt_rotation_interval: negative value %s",
+			conf.DNSCryptRotationInterval,
+		)
+	}
+
+	for i, c := range conf.DNSCryptResolvers {
+		switch {
+		case c == nil:
+			return fmt.Errorf("resolver at index %d: %w", i, errors.Error("no value"))
+		case c.ConfigFile == "":
+			return fmt.Errorf("resolver at index %d: no config_file", i)
+		case c.Port == 0:
+			return fmt.Errorf("resolver at index %d: no port", i)
+		}
+	}
+
+	return nil
+}
+
+// readDNSCryptConfig reads the DNSCrypt resolver configuration from the file
+// at path.
+func readDNSCryptConfig(path string) (rc *dnscrypt.ResolverConfig, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening dnscrypt config: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	rc = &dnscrypt.ResolverConfig{}
+	err = yaml.NewDecoder(f).Decode(rc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding dnscrypt config: %w", err)
+	}
+
+	return rc, nil
+}
+
+// newDNSCryptCert reads the DNSCrypt resolver configuration from the file at
+// path and creates the certificate of the resolver.  If rotation is positive,
+// the short-term keys from the file are ignored and the certificate is only
+// valid for two rotation intervals.
+func newDNSCryptCert(
+	path string,
+	rotation time.Duration,
+) (cert *dnscrypt.Cert, providerName string, err error) {
+	rc, err := readDNSCryptConfig(path)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, "", err
+	}
+
+	if rotation > 0 {
+		// Generate new short-term keys for each certificate, so that the keys
+		// of the previous ones become useless after they expire.
+		rc.ResolverSk, rc.ResolverPk = "", ""
+		rc.CertificateTTL = 2 * rotation
+	}
+
+	cert, err = rc.CreateCert()
+	if err != nil {
+		return nil, "", fmt.Errorf("creating dnscrypt cert: %w", err)
+	}
+
+	return cert, rc.ProviderName, nil
+}
+
+// startDNSCryptRotation starts rotating the certificates of the DNSCrypt
+// resolvers, if it's configured.
+func (m *tlsManager) startDNSCryptRotation() {
+	m.confLock.Lock()
+	defer m.confLock.Unlock()
+
+	ivl := m.conf.DNSCryptRotationInterval.Duration
+	if ivl <= 0 || m.conf.PortDNSCrypt == 0 || m.dnsCryptDone != nil {
+		return
+	}
+
+	m.dnsCryptDone = make(chan struct{})
+	go rotateDNSCrypt(ivl, m.dnsCryptDone)
+}
+
+// stopDNSCryptRotation stops rotating the certificates of the DNSCrypt
+// resolvers, if it's started.
+func (m *tlsManager) stopDNSCryptRotation() {
+	m.confLock.Lock()
+	defer m.confLock.Unlock()
+
+	if m.dnsCryptDone != nil {
+		close(m.dnsCryptDone)
+		m.dnsCryptDone = nil
+	}
+}
+
+// rotateDNSCrypt recreates the certificates of the DNSCrypt resolvers every ivl
+// until done is closed.  It is intended to be used as a goroutine.
+func rotateDNSCrypt(ivl time.Duration, done <-chan struct{}) {
+	defer log.OnPanic("dnscrypt rotation")
+
+	ticker := time.NewTicker(ivl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !isRunning() {
+				continue
+			}
+
+			log.Info("dnscrypt: rotating certificates")
+
+			err := reconfigureDNSServer()
+			if err != nil {
+				log.Error("dnscrypt: rotating certificates: %s", err)
+			}
+		}
+	}
+}
+
+// dnsCryptStamp is a DNS stamp of a DNSCrypt resolver.
+type dnsCryptStamp struct {
+	// ProviderName is the DNSCrypt provider name of the resolver.
+	ProviderName string `json:"provider_name"`
+
+	// Stamp is the sdns:// stamp of the resolver.
+	Stamp string `json:"stamp"`
+}
+
+// dnsCryptStampsResp is the response to the GET /control/dnscrypt/stamps HTTP
+// API.
+type dnsCryptStampsResp struct {
+	Stamps []*dnsCryptStamp `json:"stamps"`
+}
+
+// dnsCryptStamps returns the stamps of the DNSCrypt resolvers of conf, which
+// are reachable at addr.
+func dnsCryptStamps(conf *tlsConfigSettings, addr netip.Addr) (stamps []*dnsCryptStamp, err error) {
+	type resolver struct {
+		path string
+		port uint16
+	}
+
+	resolvers := []resolver{{path: conf.DNSCryptConfigFile, port: conf.PortDNSCrypt}}
+	for _, c := range conf.DNSCryptResolvers {
+		resolvers = append(resolvers, resolver{path: c.ConfigFile, port: c.Port})
+	}
+
+	for _, r := range resolvers {
+		var rc *dnscrypt.ResolverConfig
+		rc, err = readDNSCryptConfig(r.path)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return nil, err
+		}
+
+		stamp, stampErr := rc.CreateStamp(netutil.JoinHostPort(addr.String(), r.port))
+		if stampErr != nil {
+			return nil, fmt.Errorf("creating stamp for %q: %w", rc.ProviderName, stampErr)
+		}
+
+		stamps = append(stamps, &dnsCryptStamp{
+			ProviderName: rc.ProviderName,
+			Stamp:        stamp.String(),
+		})
+	}
+
+	return stamps, nil
+}
+
+// handleDNSCryptStamps is the handler for the GET /control/dnscrypt/stamps HTTP
+// API.  The address of the stamps is taken from the "addr" query parameter or,
+// if there is none, from the host of the request.
+func (m *tlsManager) handleDNSCryptStamps(w http.ResponseWriter, r *http.Request) {
+	tlsConf := &tlsConfigSettings{}
+	m.WriteDiskConfig(tlsConf)
+
+	if !tlsConf.Enabled || tlsConf.PortDNSCrypt == 0 {
+		aghhttp.Error(r, w, http.StatusBadRequest, "dnscrypt is disabled")
+
+		return
+	}
+
+	addrStr := r.URL.Query().Get("addr")
+	if addrStr == "" {
+		addrStr = r.Host
+		if host, err := netutil.SplitHost(r.Host); err == nil {
+			addrStr = host
+		}
+	}
+
+	addr, err := netip.ParseAddr(addrStr)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "bad addr: %s", err)
+
+		return
+	}
+
+	stamps, err := dnsCryptStamps(tlsConf, addr.Unmap())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &dnsCryptStampsResp{Stamps: stamps})
+}
@@ -0,0 +1,135 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// newTestDNSCryptConfig writes a new DNSCrypt resolver configuration with
+// providerName to a temporary file and returns its path.
+func newTestDNSCryptConfig(t *testing.T, providerName string) (rc dnscrypt.ResolverConfig, path string) {
+	t.Helper()
+
+	rc, err := dnscrypt.GenerateResolverConfig(providerName, nil)
+	require.NoError(t, err)
+
+	b, err := yaml.Marshal(rc)
+	require.NoError(t, err)
+
+	path = filepath.Join(t.TempDir(), "dnscrypt.yaml")
+	err = os.WriteFile(path, b, 0o600)
+	require.NoError(t, err)
+
+	return rc, path
+}
+
+func TestNewDNSCryptCert(t *testing.T) {
+	rc, path := newTestDNSCryptConfig(t, "example.org")
+
+	t.Run("no_rotation", func(t *testing.T) {
+		cert, providerName, err := newDNSCryptCert(path, 0)
+		require.NoError(t, err)
+
+		assert.Equal(t, rc.ProviderName, providerName)
+
+		pk, err := dnscrypt.HexDecodeKey(rc.ResolverPk)
+		require.NoError(t, err)
+
+		assert.Equal(t, pk, cert.ResolverPk[:])
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		const ivl = time.Hour
+
+		first, _, err := newDNSCryptCert(path, ivl)
+		require.NoError(t, err)
+
+		second, _, err := newDNSCryptCert(path, ivl)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, first.ResolverPk, second.ResolverPk)
+		assert.Equal(t, uint32(2*ivl/time.Second), first.NotAfter-first.NotBefore)
+	})
+}
+
+func TestDNSCryptStamps(t *testing.T) {
+	mainRC, mainPath := newTestDNSCryptConfig(t, "main.example")
+	extraRC, extraPath := newTestDNSCryptConfig(t, "extra.example")
+
+	conf := &tlsConfigSettings{
+		Enabled:            true,
+		PortDNSCrypt:       5443,
+		DNSCryptConfigFile: mainPath,
+		DNSCryptResolvers: []*dnsCryptResolverConfig{{
+			ConfigFile: extraPath,
+			Port:       5444,
+		}},
+		DNSCryptRotationInterval: timeutil.Duration{Duration: time.Hour},
+	}
+
+	stamps, err := dnsCryptStamps(conf, netip.MustParseAddr("192.0.2.1"))
+	require.NoError(t, err)
+	require.Len(t, stamps, 2)
+
+	assert.Equal(t, mainRC.ProviderName, stamps[0].ProviderName)
+	assert.Equal(t, extraRC.ProviderName, stamps[1].ProviderName)
+
+	for _, s := range stamps {
+		assert.True(t, strings.HasPrefix(s.Stamp, "sdns://"))
+	}
+
+	assert.NotEqual(t, stamps[0].Stamp, stamps[1].Stamp)
+
+	t.Run("handler", func(t *testing.T) {
+		m := &tlsManager{conf: *conf}
+
+		testCases := []struct {
+			name     string
+			target   string
+			host     string
+			wantCode int
+		}{{
+			name:     "addr",
+			target:   "/control/dnscrypt/stamps?addr=192.0.2.1",
+			host:     "dns.example",
+			wantCode: http.StatusOK,
+		}, {
+			name:     "host",
+			target:   "/control/dnscrypt/stamps",
+			host:     "192.0.2.1:3000",
+			wantCode: http.StatusOK,
+		}, {
+			name:     "no_addr",
+			target:   "/control/dnscrypt/stamps",
+			host:     "dns.example",
+			wantCode: http.StatusBadRequest,
+		}}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+				r.Host = tc.host
+				w := httptest.NewRecorder()
+
+				m.handleDNSCryptStamps(w, r)
+				assert.Equal(t, tc.wantCode, w.Code)
+
+				if tc.wantCode == http.StatusOK {
+					assert.Contains(t, w.Body.String(), stamps[0].Stamp)
+				}
+			})
+		}
+	})
+}
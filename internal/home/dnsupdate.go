@@ -0,0 +1,118 @@
+package home
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsupdate"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// dnsUpdateConfig is the configuration of the listener of the dynamic DNS
+// UPDATE messages.  See [dnsupdate.Config].
+type dnsUpdateConfig struct {
+	// Addresses are the addresses to listen on for both UDP and TCP.
+	Addresses []netip.AddrPort `yaml:"addresses"`
+
+	// Keys are the TSIG keys used to authenticate the updates.
+	Keys []*dnsUpdateKeyConfig `yaml:"keys"`
+
+	// Zones are the zones accepting the updates.
+	Zones []*dnsUpdateZoneConfig `yaml:"zones"`
+
+	// Enabled defines if the updates are accepted.
+	Enabled bool `yaml:"enabled"`
+}
+
+// dnsUpdateKeyConfig is the configuration of a TSIG key.  See
+// [dnsupdate.KeyConfig].
+type dnsUpdateKeyConfig struct {
+	// Name is the name of the key.
+	Name string `yaml:"name"`
+
+	// Algorithm is the algorithm of the key.  If empty, "hmac-sha256" is used.
+	Algorithm string `yaml:"algorithm,omitempty"`
+
+	// Secret is the base64-encoded secret of the key.
+	Secret string `yaml:"secret"`
+}
+
+// dnsUpdateZoneConfig is the configuration of a zone accepting the updates.
+// See [dnsupdate.ZoneConfig].
+type dnsUpdateZoneConfig struct {
+	// Name is the name of the zone.
+	Name string `yaml:"name"`
+
+	// Keys are the names of the keys allowed to update the zone.
+	Keys []string `yaml:"keys"`
+}
+
+// toInternal returns the configuration of the listener applying the updates
+// to rws.
+func (c *dnsUpdateConfig) toInternal(rws dnsupdate.Rewrites) (conf *dnsupdate.Config, err error) {
+	conf = &dnsupdate.Config{
+		Rewrites: rws,
+		Addrs:    c.Addresses,
+		Keys:     make([]*dnsupdate.KeyConfig, 0, len(c.Keys)),
+		Zones:    make([]*dnsupdate.ZoneConfig, 0, len(c.Zones)),
+	}
+
+	for i, k := range c.Keys {
+		if k == nil {
+			return nil, fmt.Errorf("key at index %d: %w", i, errors.Error("no value"))
+		}
+
+		conf.Keys = append(conf.Keys, &dnsupdate.KeyConfig{
+			Name:      k.Name,
+			Algorithm: k.Algorithm,
+			Secret:    k.Secret,
+		})
+	}
+
+	for i, z := range c.Zones {
+		if z == nil {
+			return nil, fmt.Errorf("zone at index %d: %w", i, errors.Error("no value"))
+		}
+
+		conf.Zones = append(conf.Zones, &dnsupdate.ZoneConfig{
+			Name: z.Name,
+			Keys: z.Keys,
+		})
+	}
+
+	return conf, nil
+}
+
+// validate returns an error if the dynamic update configuration is invalid.
+func (c *dnsUpdateConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	conf, err := c.toInternal(nil)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return conf.Validate()
+}
+
+// newDNSUpdateServer returns a new listener of the dynamic updates applying
+// them to rws, or nil if it's disabled.
+func newDNSUpdateServer(
+	c *dnsUpdateConfig,
+	rws dnsupdate.Rewrites,
+) (s *dnsupdate.Server, err error) {
+	if c == nil || !c.Enabled {
+		return nil, nil
+	}
+
+	conf, err := c.toInternal(rws)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return dnsupdate.New(conf)
+}
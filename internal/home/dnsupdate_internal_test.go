@@ -0,0 +1,82 @@
+package home
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+)
+
+func TestDNSUpdateConfig_validate(t *testing.T) {
+	addrs := []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:5353")}
+	keys := []*dnsUpdateKeyConfig{{
+		Name:   "dhcp-key",
+		Secret: "c2VjcmV0",
+	}}
+
+	testCases := []struct {
+		conf       *dnsUpdateConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf: &dnsUpdateConfig{
+			Enabled: false,
+		},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: &dnsUpdateConfig{
+			Addresses: addrs,
+			Keys:      keys,
+			Zones: []*dnsUpdateZoneConfig{{
+				Name: "lan",
+				Keys: []string{"dhcp-key"},
+			}},
+			Enabled: true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &dnsUpdateConfig{
+			Keys: keys,
+			Zones: []*dnsUpdateZoneConfig{{
+				Name: "lan",
+				Keys: []string{"dhcp-key"},
+			}},
+			Enabled: true,
+		},
+		name:       "no_addresses",
+		wantErrMsg: "addresses: no value",
+	}, {
+		conf: &dnsUpdateConfig{
+			Addresses: addrs,
+			Keys:      keys,
+			Zones:     []*dnsUpdateZoneConfig{nil},
+			Enabled:   true,
+		},
+		name:       "nil_zone",
+		wantErrMsg: "zone at index 0: no value",
+	}, {
+		conf: &dnsUpdateConfig{
+			Addresses: addrs,
+			Keys:      keys,
+			Zones: []*dnsUpdateZoneConfig{{
+				Name: "lan",
+				Keys: []string{"other-key"},
+			}},
+			Enabled: true,
+		},
+		name:       "unknown_key",
+		wantErrMsg: `zone at index 0: zone "lan.": unknown key "other-key"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
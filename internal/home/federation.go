@@ -0,0 +1,633 @@
+package home
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"golang.org/x/exp/slices"
+)
+
+// federationConfig is the configuration of the federation mode, in which this
+// instance queries a set of peer instances and serves the combined data.
+type federationConfig struct {
+	// Peers are the AdGuard Home instances to aggregate the data from.
+	Peers []*federationPeer `yaml:"peers"`
+
+	// Timeout is the timeout for a single request to a peer.
+	Timeout timeutil.Duration `yaml:"timeout"`
+
+	// Enabled defines if the federation API is enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// federationPeer is a single peer instance in the federation.
+type federationPeer struct {
+	// Name is the human-readable name of the peer.  It must be unique.
+	Name string `yaml:"name"`
+
+	// URL is the base URL of the peer's web interface, for example
+	// "http://192.168.1.2:3000".
+	URL string `yaml:"url"`
+
+	// Username is the name of the user to authenticate with on the peer.
+	Username string `yaml:"username"`
+
+	// Password is the password of the user to authenticate with on the peer.
+	Password string `yaml:"password"`
+}
+
+// defaultFederationTimeout is the default timeout for the requests to the
+// federation peers.
+const defaultFederationTimeout = 10 * time.Second
+
+// localInstanceName is the name of this instance in the aggregated responses.
+const localInstanceName = "local"
+
+// validate returns an error if the federation configuration is invalid.
+func (c *federationConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	names := map[string]struct{}{localInstanceName: {}}
+	for i, p := range c.Peers {
+		if p == nil {
+			return fmt.Errorf("peer at index %d: %w", i, errors.Error("no value"))
+		}
+
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("peer at index %d: duplicate or reserved name %q", i, p.Name)
+		}
+
+		names[p.Name] = struct{}{}
+
+		var u *url.URL
+		u, err = url.Parse(p.URL)
+		if err != nil {
+			return fmt.Errorf("peer %q: bad url: %w", p.Name, err)
+		} else if u.Scheme != aghhttp.SchemeHTTP && u.Scheme != aghhttp.SchemeHTTPS {
+			return fmt.Errorf("peer %q: bad url scheme %q", p.Name, u.Scheme)
+		}
+	}
+
+	return nil
+}
+
+// federation aggregates the data from the local instance and its peers.
+type federation struct {
+	// local is the handler serving the local instance's API.
+	local http.Handler
+
+	// client is the HTTP client used to query the peers.
+	client *http.Client
+
+	// conf is the federation configuration.  It must not be modified after
+	// the federation is created.
+	conf *federationConfig
+}
+
+// newFederation returns a new properly initialized federation.  conf must be
+// valid.
+func newFederation(conf *federationConfig, local http.Handler, cli *http.Client) (f *federation) {
+	if conf.Timeout.Duration == 0 {
+		conf.Timeout.Duration = defaultFederationTimeout
+	}
+
+	return &federation{
+		local:  local,
+		client: cli,
+		conf:   conf,
+	}
+}
+
+// registerWebHandlers registers HTTP handlers for the federation API.
+func (f *federation) registerWebHandlers() {
+	httpRegister(http.MethodGet, "/control/federation/stats", f.handleStats)
+	httpRegister(http.MethodGet, "/control/federation/querylog", f.handleQueryLog)
+	httpRegister(http.MethodGet, "/control/federation/clients", f.handleClients)
+}
+
+// federationInstanceJSON is the status of a single instance in the aggregated
+// response.
+type federationInstanceJSON struct {
+	// Name is the name of the instance.
+	Name string `json:"name"`
+
+	// Error is the error message, if the data couldn't be fetched from the
+	// instance.
+	Error string `json:"error,omitempty"`
+}
+
+// federationResult is the result of a request to a single instance.
+type federationResult struct {
+	err  error
+	name string
+	body []byte
+}
+
+// fetch requests the API at path, with the query of r, from all instances
+// concurrently.  The results are in the same order as the instances in the
+// configuration, with the local instance first.
+func (f *federation) fetch(r *http.Request, path string) (results []*federationResult) {
+	results = make([]*federationResult, len(f.conf.Peers)+1)
+
+	results[0] = &federationResult{name: localInstanceName}
+	results[0].body, results[0].err = f.fetchLocal(r, path)
+
+	wg := &sync.WaitGroup{}
+	for i, p := range f.conf.Peers {
+		res := &federationResult{name: p.Name}
+		results[i+1] = res
+
+		wg.Add(1)
+		go func(p *federationPeer) {
+			defer log.OnPanic("federation: fetching from peer")
+			defer wg.Done()
+
+			res.body, res.err = f.fetchPeer(r.Context(), p, path, r.URL.RawQuery)
+		}(p)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// fetchLocal serves the request for path with the local handler and returns
+// the response body.
+func (f *federation) fetchLocal(r *http.Request, path string) (body []byte, err error) {
+	req := r.Clone(r.Context())
+	req.URL.Path = path
+	req.RequestURI = ""
+
+	// Make sure that the response isn't compressed.
+	req.Header.Del(httphdr.AcceptEncoding)
+
+	rw := &bufferedResponseWriter{header: http.Header{}}
+	f.local.ServeHTTP(rw, req)
+
+	if rw.code != 0 && rw.code != http.StatusOK {
+		return nil, fmt.Errorf("local: status code %d", rw.code)
+	}
+
+	return rw.body.Bytes(), nil
+}
+
+// maxFederationRespSize is the maximum size of a peer's response body.
+const maxFederationRespSize = 64 * 1024 * 1024
+
+// fetchPeer requests path with rawQuery from the peer p and returns the
+// response body.
+func (f *federation) fetchPeer(
+	ctx context.Context,
+	p *federationPeer,
+	path string,
+	rawQuery string,
+) (body []byte, err error) {
+	ctx, cancel := context.WithTimeout(ctx, f.conf.Timeout.Duration)
+	defer cancel()
+
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing url: %w", err)
+	}
+
+	u = u.JoinPath(path)
+	u.RawQuery = rawQuery
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxFederationRespSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+
+	return body, nil
+}
+
+// instancesJSON returns the statuses of the instances from results.
+func instancesJSON(results []*federationResult) (insts []*federationInstanceJSON) {
+	insts = make([]*federationInstanceJSON, 0, len(results))
+	for _, res := range results {
+		inst := &federationInstanceJSON{Name: res.name}
+		if res.err != nil {
+			inst.Error = res.err.Error()
+
+			log.Debug("federation: instance %q: %s", res.name, res.err)
+		}
+
+		insts = append(insts, inst)
+	}
+
+	return insts
+}
+
+// federationStatsJSON is the response to the GET /control/federation/stats
+// HTTP API.
+type federationStatsJSON struct {
+	// Stats are the merged statistics of all the instances.
+	Stats *stats.StatsResp `json:"stats"`
+
+	// Instances are the statuses of the instances.
+	Instances []*federationInstanceJSON `json:"instances"`
+}
+
+// handleStats is the handler for the GET /control/federation/stats HTTP API.
+func (f *federation) handleStats(w http.ResponseWriter, r *http.Request) {
+	results := f.fetch(r, "/control/stats")
+
+	var parts []*stats.StatsResp
+	for _, res := range results {
+		if res.err != nil {
+			continue
+		}
+
+		part := &stats.StatsResp{}
+		err := json.Unmarshal(res.body, part)
+		if err != nil {
+			res.err = fmt.Errorf("decoding stats: %w", err)
+
+			continue
+		}
+
+		parts = append(parts, part)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &federationStatsJSON{
+		Stats:     mergeStats(parts),
+		Instances: instancesJSON(results),
+	})
+}
+
+// maxFederationTop is the maximum number of items in each of the merged top
+// lists.
+const maxFederationTop = 100
+
+// mergeStats returns the sum of the statistics in parts.  The per-unit series
+// are summed element-wise starting from the most recent unit.
+func mergeStats(parts []*stats.StatsResp) (merged *stats.StatsResp) {
+	merged = &stats.StatsResp{
+		TimeUnits:             "hours",
+		TopQueried:            []map[string]uint64{},
+		TopClients:            []map[string]uint64{},
+		TopBlocked:            []map[string]uint64{},
+		TopUpstreamsResponses: []map[string]uint64{},
+		TopUpstreamsAvgTime:   []map[string]float64{},
+		TopUpstreamsShare:     []map[string]float64{},
+		TopBlockingRules:      []map[string]uint64{},
+		TopFilterLists:        []map[string]uint64{},
+		TopQueryTypes:         []map[string]uint64{},
+		TopResponseCodes:      []map[string]uint64{},
+		DNSQueries:            []uint64{},
+		BlockedFiltering:      []uint64{},
+		ReplacedSafebrowsing:  []uint64{},
+		ReplacedParental:      []uint64{},
+	}
+
+	if len(parts) == 0 {
+		return merged
+	}
+
+	merged.TimeUnits = parts[0].TimeUnits
+
+	var totalTime float64
+	queried, clients, blocked := map[string]uint64{}, map[string]uint64{}, map[string]uint64{}
+	upsResps, upsTime := map[string]uint64{}, map[string]float64{}
+	rules, lists := map[string]uint64{}, map[string]uint64{}
+	qTypes, rCodes := map[string]uint64{}, map[string]uint64{}
+	for _, p := range parts {
+		merged.NumDNSQueries += p.NumDNSQueries
+		merged.NumBlockedFiltering += p.NumBlockedFiltering
+		merged.NumReplacedSafebrowsing += p.NumReplacedSafebrowsing
+		merged.NumReplacedSafesearch += p.NumReplacedSafesearch
+		merged.NumReplacedParental += p.NumReplacedParental
+
+		totalTime += p.AvgProcessingTime * float64(p.NumDNSQueries)
+
+		merged.DNSQueries = sumSeries(merged.DNSQueries, p.DNSQueries)
+		merged.BlockedFiltering = sumSeries(merged.BlockedFiltering, p.BlockedFiltering)
+		merged.ReplacedSafebrowsing = sumSeries(merged.ReplacedSafebrowsing, p.ReplacedSafebrowsing)
+		merged.ReplacedParental = sumSeries(merged.ReplacedParental, p.ReplacedParental)
+
+		sumTops(queried, p.TopQueried)
+		sumTops(clients, p.TopClients)
+		sumTops(blocked, p.TopBlocked)
+		sumTops(rules, p.TopBlockingRules)
+		sumTops(lists, p.TopFilterLists)
+		sumTops(qTypes, p.TopQueryTypes)
+		sumTops(rCodes, p.TopResponseCodes)
+
+		resps := map[string]uint64{}
+		sumTops(resps, p.TopUpstreamsResponses)
+		for _, top := range p.TopUpstreamsAvgTime {
+			for ups, avg := range top {
+				upsTime[ups] += avg * float64(resps[ups])
+			}
+		}
+
+		for ups, n := range resps {
+			upsResps[ups] += n
+		}
+	}
+
+	if merged.NumDNSQueries > 0 {
+		merged.AvgProcessingTime = totalTime / float64(merged.NumDNSQueries)
+	}
+
+	merged.TopQueried = topsToJSON(queried)
+	merged.TopClients = topsToJSON(clients)
+	merged.TopBlocked = topsToJSON(blocked)
+	merged.TopUpstreamsResponses = topsToJSON(upsResps)
+	merged.TopUpstreamsShare = stats.UpstreamsShare(merged.TopUpstreamsResponses, merged.NumDNSQueries)
+	merged.TopBlockingRules = topsToJSON(rules)
+	merged.TopFilterLists = topsToJSON(lists)
+	merged.TopQueryTypes = topsToJSON(qTypes)
+	merged.TopResponseCodes = topsToJSON(rCodes)
+
+	for _, top := range merged.TopUpstreamsResponses {
+		for ups, n := range top {
+			avg := 0.0
+			if n > 0 {
+				avg = upsTime[ups] / float64(n)
+			}
+
+			merged.TopUpstreamsAvgTime = append(merged.TopUpstreamsAvgTime, map[string]float64{
+				ups: avg,
+			})
+		}
+	}
+
+	return merged
+}
+
+// sumSeries adds the values of b to the values of a aligning both by their
+// ends, since the last element of a statistics series is the most recent one.
+func sumSeries(a, b []uint64) (res []uint64) {
+	if len(b) > len(a) {
+		a, b = b, a
+	}
+
+	res = slices.Clone(a)
+	off := len(a) - len(b)
+	for i, v := range b {
+		res[off+i] += v
+	}
+
+	return res
+}
+
+// sumTops adds the counts from tops to acc.
+func sumTops(acc map[string]uint64, tops []map[string]uint64) {
+	for _, top := range tops {
+		for k, v := range top {
+			acc[k] += v
+		}
+	}
+}
+
+// topsToJSON converts acc into a top list sorted by the count in descending
+// order and the key in ascending order.
+func topsToJSON(acc map[string]uint64) (tops []map[string]uint64) {
+	type pair struct {
+		key   string
+		count uint64
+	}
+
+	pairs := make([]pair, 0, len(acc))
+	for k, v := range acc {
+		pairs = append(pairs, pair{key: k, count: v})
+	}
+
+	slices.SortFunc(pairs, func(a, b pair) (res int) {
+		switch {
+		case a.count > b.count:
+			return -1
+		case a.count < b.count:
+			return 1
+		default:
+			return strings.Compare(a.key, b.key)
+		}
+	})
+
+	if len(pairs) > maxFederationTop {
+		pairs = pairs[:maxFederationTop]
+	}
+
+	tops = make([]map[string]uint64, 0, len(pairs))
+	for _, p := range pairs {
+		tops = append(tops, map[string]uint64{p.key: p.count})
+	}
+
+	return tops
+}
+
+// federationQueryLogJSON is the response to the GET
+// /control/federation/querylog HTTP API.
+type federationQueryLogJSON struct {
+	// Data are the query log entries of all the instances, most recent first.
+	// Each entry has an additional "instance" property.
+	Data []map[string]any `json:"data"`
+
+	// Instances are the statuses of the instances.
+	Instances []*federationInstanceJSON `json:"instances"`
+}
+
+// defaultFederationQueryLogLimit is the default number of query log entries in
+// the aggregated response.
+const defaultFederationQueryLogLimit = 500
+
+// handleQueryLog is the handler for the GET /control/federation/querylog HTTP
+// API.  It accepts the same parameters as GET /control/querylog.
+func (f *federation) handleQueryLog(w http.ResponseWriter, r *http.Request) {
+	limit := defaultFederationQueryLogLimit
+	if limStr := r.URL.Query().Get("limit"); limStr != "" {
+		_, err := fmt.Sscanf(limStr, "%d", &limit)
+		if err != nil || limit <= 0 {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad limit %q", limStr)
+
+			return
+		}
+	}
+
+	results := f.fetch(r, "/control/querylog")
+
+	data := []map[string]any{}
+	for _, res := range results {
+		if res.err != nil {
+			continue
+		}
+
+		part := &struct {
+			Data []map[string]any `json:"data"`
+		}{}
+		err := json.Unmarshal(res.body, part)
+		if err != nil {
+			res.err = fmt.Errorf("decoding query log: %w", err)
+
+			continue
+		}
+
+		for _, e := range part.Data {
+			e["instance"] = res.name
+		}
+
+		data = append(data, part.Data...)
+	}
+
+	slices.SortStableFunc(data, func(a, b map[string]any) (res int) {
+		// The times are in RFC 3339 format with the same precision, so the
+		// string comparison is enough.
+		ta, _ := a["time"].(string)
+		tb, _ := b["time"].(string)
+
+		return -compareRFC3339(ta, tb)
+	})
+
+	if len(data) > limit {
+		data = data[:limit]
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &federationQueryLogJSON{
+		Data:      data,
+		Instances: instancesJSON(results),
+	})
+}
+
+// compareRFC3339 compares two RFC 3339 timestamps.  Unparseable timestamps are
+// considered to be the oldest.
+func compareRFC3339(a, b string) (res int) {
+	ta, errA := time.Parse(time.RFC3339Nano, a)
+	tb, errB := time.Parse(time.RFC3339Nano, b)
+	switch {
+	case errA != nil && errB != nil:
+		return 0
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	default:
+		return ta.Compare(tb)
+	}
+}
+
+// federationClientsJSON is the response to the GET /control/federation/clients
+// HTTP API.
+type federationClientsJSON struct {
+	// Clients are the persistent clients of all the instances.  Each client
+	// has an additional "instance" property.
+	Clients []map[string]any `json:"clients"`
+
+	// RuntimeClients are the runtime clients of all the instances.  Each
+	// client has an additional "instance" property.
+	RuntimeClients []map[string]any `json:"auto_clients"`
+
+	// Instances are the statuses of the instances.
+	Instances []*federationInstanceJSON `json:"instances"`
+}
+
+// handleClients is the handler for the GET /control/federation/clients HTTP
+// API.
+func (f *federation) handleClients(w http.ResponseWriter, r *http.Request) {
+	results := f.fetch(r, "/control/clients")
+
+	resp := &federationClientsJSON{
+		Clients:        []map[string]any{},
+		RuntimeClients: []map[string]any{},
+	}
+
+	for _, res := range results {
+		if res.err != nil {
+			continue
+		}
+
+		part := &struct {
+			Clients        []map[string]any `json:"clients"`
+			RuntimeClients []map[string]any `json:"auto_clients"`
+		}{}
+		err := json.Unmarshal(res.body, part)
+		if err != nil {
+			res.err = fmt.Errorf("decoding clients: %w", err)
+
+			continue
+		}
+
+		for _, c := range part.Clients {
+			c["instance"] = res.name
+		}
+
+		for _, c := range part.RuntimeClients {
+			c["instance"] = res.name
+		}
+
+		resp.Clients = append(resp.Clients, part.Clients...)
+		resp.RuntimeClients = append(resp.RuntimeClients, part.RuntimeClients...)
+	}
+
+	resp.Instances = instancesJSON(results)
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// bufferedResponseWriter is an [http.ResponseWriter] that keeps the response
+// in memory.
+type bufferedResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+// type check
+var _ http.ResponseWriter = (*bufferedResponseWriter)(nil)
+
+// Header implements the [http.ResponseWriter] interface for
+// *bufferedResponseWriter.
+func (w *bufferedResponseWriter) Header() (h http.Header) { return w.header }
+
+// Write implements the [http.ResponseWriter] interface for
+// *bufferedResponseWriter.
+func (w *bufferedResponseWriter) Write(b []byte) (n int, err error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	return w.body.Write(b)
+}
+
+// WriteHeader implements the [http.ResponseWriter] interface for
+// *bufferedResponseWriter.
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
@@ -0,0 +1,168 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederationConfig_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *federationConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf: &federationConfig{
+			Peers:   []*federationPeer{{Name: "a", URL: "http://1.2.3.4:3000"}},
+			Enabled: true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &federationConfig{
+			Peers: []*federationPeer{
+				{Name: "a", URL: "http://1.2.3.4:3000"},
+				{Name: "a", URL: "http://1.2.3.5:3000"},
+			},
+			Enabled: true,
+		},
+		name:       "duplicate",
+		wantErrMsg: `peer at index 1: duplicate or reserved name "a"`,
+	}, {
+		conf: &federationConfig{
+			Peers:   []*federationPeer{{Name: localInstanceName, URL: "http://1.2.3.4"}},
+			Enabled: true,
+		},
+		name:       "reserved",
+		wantErrMsg: `peer at index 0: duplicate or reserved name "local"`,
+	}, {
+		conf: &federationConfig{
+			Peers:   []*federationPeer{{Name: "a", URL: "ftp://1.2.3.4"}},
+			Enabled: true,
+		},
+		name:       "bad_scheme",
+		wantErrMsg: `peer "a": bad url scheme "ftp"`,
+	}, {
+		conf: &federationConfig{
+			Peers:   []*federationPeer{{Name: "a", URL: "ftp://1.2.3.4"}},
+			Enabled: false,
+		},
+		name:       "disabled",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
+
+func TestMergeStats(t *testing.T) {
+	a := &stats.StatsResp{
+		TimeUnits:             "hours",
+		TopQueried:            []map[string]uint64{{"example.com": 3}, {"example.org": 1}},
+		TopUpstreamsResponses: []map[string]uint64{{"1.1.1.1:53": 2}},
+		TopUpstreamsAvgTime:   []map[string]float64{{"1.1.1.1:53": 0.1}},
+		DNSQueries:            []uint64{1, 2, 3},
+		NumDNSQueries:         6,
+		AvgProcessingTime:     0.1,
+	}
+	b := &stats.StatsResp{
+		TimeUnits:             "hours",
+		TopQueried:            []map[string]uint64{{"example.org": 5}},
+		TopUpstreamsResponses: []map[string]uint64{{"1.1.1.1:53": 2}},
+		TopUpstreamsAvgTime:   []map[string]float64{{"1.1.1.1:53": 0.3}},
+		DNSQueries:            []uint64{4, 5},
+		NumDNSQueries:         2,
+		AvgProcessingTime:     0.5,
+	}
+
+	merged := mergeStats([]*stats.StatsResp{a, b})
+
+	assert.Equal(t, []uint64{1, 6, 8}, merged.DNSQueries)
+	assert.Equal(t, uint64(8), merged.NumDNSQueries)
+	assert.InDelta(t, 0.2, merged.AvgProcessingTime, 1e-9)
+	assert.Equal(t, []map[string]uint64{
+		{"example.org": 6},
+		{"example.com": 3},
+	}, merged.TopQueried)
+	assert.Equal(t, []map[string]uint64{{"1.1.1.1:53": 4}}, merged.TopUpstreamsResponses)
+
+	require.Len(t, merged.TopUpstreamsAvgTime, 1)
+	assert.InDelta(t, 0.2, merged.TopUpstreamsAvgTime[0]["1.1.1.1:53"], 1e-9)
+
+	t.Run("empty", func(t *testing.T) {
+		empty := mergeStats(nil)
+		assert.NotNil(t, empty.TopQueried)
+		assert.NotNil(t, empty.DNSQueries)
+	})
+}
+
+func TestFederation_handleQueryLog(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newHandler := func(times ...time.Time) (h http.Handler) {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/control/querylog", r.URL.Path)
+
+			data := []map[string]any{}
+			for _, tm := range times {
+				data = append(data, map[string]any{"time": tm.Format(time.RFC3339Nano)})
+			}
+
+			aghhttp.WriteJSONResponseOK(w, r, map[string]any{"data": data})
+		})
+	}
+
+	peer := httptest.NewServer(newHandler(now.Add(2*time.Second), now))
+	t.Cleanup(peer.Close)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(failing.Close)
+
+	f := newFederation(&federationConfig{
+		Peers: []*federationPeer{{
+			Name: "peer",
+			URL:  peer.URL,
+		}, {
+			Name: "failing",
+			URL:  failing.URL,
+		}},
+		Timeout: timeutil.Duration{Duration: time.Second},
+		Enabled: true,
+	}, newHandler(now.Add(time.Second)), http.DefaultClient)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/control/federation/querylog?limit=2", nil)
+	f.handleQueryLog(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &federationQueryLogJSON{}
+	err := json.Unmarshal(w.Body.Bytes(), resp)
+	require.NoError(t, err)
+
+	require.Len(t, resp.Data, 2)
+	assert.Equal(t, "peer", resp.Data[0]["instance"])
+	assert.Equal(t, localInstanceName, resp.Data[1]["instance"])
+
+	require.Len(t, resp.Instances, 3)
+	assert.Empty(t, resp.Instances[0].Error)
+	assert.Empty(t, resp.Instances[1].Error)
+	assert.Equal(t, "status code 403", resp.Instances[2].Error)
+}
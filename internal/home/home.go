@@ -24,9 +24,14 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
 	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
+	"github.com/AdguardTeam/AdGuardHome/internal/audit"
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
+	"github.com/AdguardTeam/AdGuardHome/internal/ddns"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsupdate"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/hashprefix"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
@@ -39,6 +44,7 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
 	"golang.org/x/exp/slices"
 )
 
@@ -56,6 +62,46 @@ type homeContext struct {
 	filters    *filtering.DNSFilter // DNS filtering module
 	web        *webAPI              // Web (HTTP, HTTPS) module
 	tls        *tlsManager          // TLS module
+	federation *federation          // Federation module
+	metrics    *metricsExporter     // Metrics module, nil if disabled
+	audit      *audit.Logger        // Audit log module, nil if disabled
+	auditFile  *audit.File          // Local audit log, nil if disabled
+	blockHook  *blockhook.Notifier  // Block hooks module, nil if disabled
+	anomalies  *anomaly.Detector    // Anomaly detection module, nil if disabled
+	schedules  *schedulesContainer  // Filtering schedules module
+	blockPage  *blockPage           // Block page module, nil if disabled
+
+	// notifications sends the notifications about the notable events.  It's
+	// nil before the initialization and during the first run.
+	notifications *notificationsContainer
+
+	// configHistory keeps the versions of the configuration file.  It's nil
+	// if disabled and during the first run.
+	configHistory *configHistory
+
+	// configSync mirrors the settings from the primary instance.  It's nil if
+	// this instance isn't a replica.
+	configSync *configSync
+
+	// unblockRequests keeps the requests of the clients for the access to the
+	// blocked domains.  It's nil during the first run.
+	unblockRequests *unblockRequests
+
+	// ddns keeps the records of the dynamic DNS providers up to date.  It's
+	// nil if disabled.
+	ddns *ddns.Updater
+
+	// dnsUpdate applies the dynamic DNS UPDATE messages to the rewrites.  It's
+	// nil if disabled.
+	dnsUpdate *dnsupdate.Server
+
+	// integration streams the events to the home automation platforms.  It's
+	// nil during the first run.
+	integration *integration
+
+	// secrets encrypts and decrypts the secrets in the configuration file.
+	// It's nil if the master key isn't set.
+	secrets *secretsCipher
 
 	// etcHosts contains IP-hostname mappings taken from the OS-specific hosts
 	// configuration files, for example /etc/hosts.
@@ -278,7 +324,11 @@ func initContextClients() (err error) {
 	config.DHCP.WorkDir = Context.workDir
 	config.DHCP.DataDir = Context.getDataDir()
 	config.DHCP.HTTPRegister = httpRegister
+	config.DHCP.HTTPClient = httpClient()
 	config.DHCP.ConfigModified = onConfigModified
+	config.DHCP.PoolExhausted = onDHCPPoolExhausted
+	config.DHCP.LeaseEvent = onDHCPLeaseEvent
+	config.DHCP.Fingerprint = Context.clients.onDHCPFingerprint
 
 	Context.dhcpServer, err = dhcpd.Create(config.DHCP)
 	if Context.dhcpServer == nil || err != nil {
@@ -296,6 +346,8 @@ func initContextClients() (err error) {
 
 	err = Context.clients.Init(
 		config.Clients.Persistent,
+		config.Clients.TagUpstreams,
+		config.Clients.Groups,
 		Context.dhcpServer,
 		Context.etcHosts,
 		arpDB,
@@ -306,6 +358,25 @@ func initContextClients() (err error) {
 		return err
 	}
 
+	if config.Clients.Sources.Fingerprint {
+		Context.clients.fingerprints, err = newFingerprintDB(config.Clients.FingerprintsFile)
+		if err != nil {
+			return fmt.Errorf("initializing clients: %w", err)
+		}
+	}
+
+	if config.Clients.Quarantine != nil {
+		err = Context.clients.setQuarantine(config.Clients.Quarantine)
+		if err != nil {
+			return fmt.Errorf("initializing clients: %w", err)
+		}
+	}
+
+	Context.schedules, err = newSchedulesContainer(config.Schedules, config.Filtering)
+	if err != nil {
+		return fmt.Errorf("initializing schedules: %w", err)
+	}
+
 	return nil
 }
 
@@ -352,8 +423,6 @@ func setupBindOpts(opts options) (err error) {
 // setupDNSFilteringConf sets up DNS filtering configuration settings.
 func setupDNSFilteringConf(conf *filtering.Config) (err error) {
 	const (
-		dnsTimeout = 3 * time.Second
-
 		sbService                 = "safe browsing"
 		defaultSafeBrowsingServer = `https://family.adguard-dns.com/dns-query`
 		sbTXTSuffix               = `sb.dns.adguard.com.`
@@ -366,6 +435,12 @@ func setupDNSFilteringConf(conf *filtering.Config) (err error) {
 	conf.EtcHosts = Context.etcHosts
 	conf.ConfigModified = onConfigModified
 	conf.HTTPRegister = httpRegister
+	conf.ServiceInUse = serviceInUse
+	conf.RequestUser = requestUser
+	conf.ApplyClientSettings = applyAdditionalFiltering
+	conf.BlockedClients = blockedClients
+	conf.AddBlockedClients = addBlockedClients
+	conf.FilterUpdateFailed = onFilterUpdateFailed
 	conf.DataDir = Context.getDataDir()
 	conf.Filters = slices.Clone(config.Filters)
 	conf.WhitelistFilters = slices.Clone(config.WhitelistFilters)
@@ -374,28 +449,15 @@ func setupDNSFilteringConf(conf *filtering.Config) (err error) {
 
 	cacheTime := time.Duration(conf.CacheTime) * time.Minute
 
-	upsOpts := &upstream.Options{
-		Timeout: dnsTimeout,
-		ServerIPAddrs: []net.IP{
-			{94, 140, 14, 15},
-			{94, 140, 15, 16},
-			net.ParseIP("2a10:50c0::bad1:ff"),
-			net.ParseIP("2a10:50c0::bad2:ff"),
-		},
-	}
-
-	sbUps, err := upstream.AddressToUpstream(defaultSafeBrowsingServer, upsOpts)
-	if err != nil {
-		return fmt.Errorf("converting safe browsing server: %w", err)
-	}
-
-	conf.SafeBrowsingChecker = hashprefix.New(&hashprefix.Config{
-		Upstream:    sbUps,
+	conf.SafeBrowsingChecker, err = newLookupProvider(conf.SafeBrowsingProvider, &hashprefix.Config{
 		ServiceName: sbService,
 		TXTSuffix:   sbTXTSuffix,
 		CacheTime:   cacheTime,
 		CacheSize:   conf.SafeBrowsingCacheSize,
-	})
+	}, defaultSafeBrowsingServer)
+	if err != nil {
+		return fmt.Errorf("initializing %s: %w", sbService, err)
+	}
 
 	// Protect against invalid configuration, see #6181.
 	//
@@ -408,18 +470,15 @@ func setupDNSFilteringConf(conf *filtering.Config) (err error) {
 		conf.SafeBrowsingBlockHost = host
 	}
 
-	parUps, err := upstream.AddressToUpstream(defaultParentalServer, upsOpts)
-	if err != nil {
-		return fmt.Errorf("converting parental server: %w", err)
-	}
-
-	conf.ParentalControlChecker = hashprefix.New(&hashprefix.Config{
-		Upstream:    parUps,
+	conf.ParentalControlChecker, err = newLookupProvider(conf.ParentalProvider, &hashprefix.Config{
 		ServiceName: pcService,
 		TXTSuffix:   pcTXTSuffix,
 		CacheTime:   cacheTime,
 		CacheSize:   conf.ParentalCacheSize,
-	})
+	}, defaultParentalServer)
+	if err != nil {
+		return fmt.Errorf("initializing %s: %w", pcService, err)
+	}
 
 	// Protect against invalid configuration, see #6181.
 	//
@@ -446,6 +505,83 @@ func setupDNSFilteringConf(conf *filtering.Config) (err error) {
 	return nil
 }
 
+// newLookupProvider returns a new safe browsing or parental control lookup
+// provider for pconf, which may be nil.  hpConf is the default configuration of
+// the hash-prefix checker, defaultServer is the address of the default
+// hash-prefix server.
+func newLookupProvider(
+	pconf *filtering.ProviderConfig,
+	hpConf *hashprefix.Config,
+	defaultServer string,
+) (c filtering.Checker, err error) {
+	const dnsTimeout = 3 * time.Second
+
+	if pconf == nil {
+		pconf = &filtering.ProviderConfig{}
+	}
+
+	if pconf.OfflineDatabase != "" {
+		return hashprefix.NewOffline(hpConf.ServiceName, pconf.OfflineDatabase)
+	}
+
+	upsOpts := &upstream.Options{
+		Bootstrap:          pconf.BootstrapDNS,
+		Timeout:            dnsTimeout,
+		InsecureSkipVerify: pconf.InsecureSkipVerify,
+	}
+
+	addr := pconf.URL
+	if addr == "" {
+		addr = defaultServer
+		upsOpts.ServerIPAddrs = []net.IP{
+			{94, 140, 14, 15},
+			{94, 140, 15, 16},
+			net.ParseIP("2a10:50c0::bad1:ff"),
+			net.ParseIP("2a10:50c0::bad2:ff"),
+		}
+	}
+
+	if pconf.RootCAPath != "" {
+		upsOpts.RootCAs, err = loadRootCAs(pconf.RootCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading root certificates: %w", err)
+		}
+	}
+
+	hpConf.Upstream, err = upstream.AddressToUpstream(addr, upsOpts)
+	if err != nil {
+		return nil, fmt.Errorf("converting server: %w", err)
+	}
+
+	if pconf.TXTSuffix != "" {
+		hpConf.TXTSuffix = dns.Fqdn(pconf.TXTSuffix)
+	}
+
+	if pconf.CacheTTL.Duration > 0 {
+		hpConf.CacheTime = pconf.CacheTTL.Duration
+	}
+
+	return hashprefix.New(hpConf), nil
+}
+
+// loadRootCAs returns the pool of the PEM-encoded certificates from the file at
+// path.
+func loadRootCAs(path string) (pool *x509.CertPool, err error) {
+	// #nosec G304 -- Trust the path explicitly given by the user.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, err
+	}
+
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %q", path)
+	}
+
+	return pool, nil
+}
+
 // checkPorts is a helper for ports validation in config.
 func checkPorts() (err error) {
 	tcpPorts := aghalg.UniqChecker[tcpPort]{}
@@ -462,7 +598,11 @@ func checkPorts() (err error) {
 			tcpPort(config.TLS.PortDNSCrypt),
 		)
 
-		addPorts(udpPorts, udpPort(config.TLS.PortDNSOverQUIC))
+		addPorts(
+			udpPorts,
+			udpPort(config.TLS.PortDNSOverQUIC),
+			udpPort(config.TLS.http3Port(config.DNS.ServeHTTP3)),
+		)
 	}
 
 	if err = tcpPorts.Validate(); err != nil {
@@ -554,6 +694,9 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 	// data first, but also to avoid relying on automatic Go init() function.
 	filtering.InitModule()
 
+	err = filtering.SetCustomServices(config.Filtering.CustomServices)
+	fatalOnError(errors.Annotate(err, "custom blocked services: %w"))
+
 	err = initContextClients()
 	fatalOnError(err)
 
@@ -608,6 +751,40 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 	Context.auth, err = initUsers()
 	fatalOnError(err)
 
+	if !Context.firstRun && config.Audit.Enabled {
+		Context.audit, err = newAuditLogger(config.Audit)
+		fatalOnError(errors.Annotate(err, "initializing audit log: %w"))
+
+		Context.audit.Start()
+	}
+
+	if !Context.firstRun && config.Audit.LocalEnabled {
+		Context.auditFile = audit.NewFile(filepath.Join(dir, auditFileName), auditFileMaxSize)
+	}
+
+	if !Context.firstRun && config.ConfigHistory.Enabled {
+		Context.configHistory, err = newConfigHistory(
+			filepath.Join(dir, configHistoryDir),
+			config.ConfigHistory.MaxVersions,
+		)
+		fatalOnError(errors.Annotate(err, "initializing config history: %w"))
+
+		// Keep the changes made to the file while AdGuard Home wasn't running.
+		err = Context.configHistory.record(config.fileData, "", 0)
+		if err != nil {
+			log.Error("config history: %s", err)
+		}
+	}
+
+	if !Context.firstRun {
+		Context.integration = newIntegration()
+
+		Context.notifications, err = newNotificationsContainer(config.Notifications)
+		fatalOnError(errors.Annotate(err, "initializing notifications: %w"))
+
+		Context.notifications.stream = Context.integration
+	}
+
 	Context.tls, err = newTLSManager(config.TLS)
 	if err != nil {
 		log.Error("initializing tls: %s", err)
@@ -618,11 +795,64 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 	fatalOnError(err)
 
 	if !Context.firstRun {
+		if m := config.HTTPConfig.Metrics; m != nil && m.Enabled {
+			Context.metrics = newMetricsExporter(m)
+			Context.metrics.registerWebHandlers()
+		}
+
+		if bp := config.HTTPConfig.BlockPage; bp != nil && bp.Enabled {
+			Context.blockPage = newBlockPage(bp)
+			Context.blockPage.start()
+		}
+
 		err = initDNS()
 		fatalOnError(err)
 
 		Context.tls.start()
 
+		if config.Federation.Enabled {
+			Context.federation = newFederation(config.Federation, Context.mux, httpClient())
+			Context.federation.registerWebHandlers()
+		}
+
+		Context.schedules.registerWebHandlers()
+
+		Context.unblockRequests, err = newUnblockRequests(
+			filepath.Join(Context.getDataDir(), unblockRequestsFilename),
+			Context.filters,
+		)
+		fatalOnError(errors.Annotate(err, "initializing unblock requests: %w"))
+
+		Context.unblockRequests.registerWebHandlers()
+		Context.unblockRequests.start()
+
+		Context.notifications.registerWebHandlers()
+		Context.notifications.start()
+
+		Context.integration.registerWebHandlers()
+		Context.integration.start()
+
+		Context.ddns, err = newDDNSUpdater(config.DDNS)
+		fatalOnError(errors.Annotate(err, "initializing ddns: %w"))
+
+		if Context.ddns != nil {
+			Context.ddns.Start()
+		}
+
+		Context.dnsUpdate, err = newDNSUpdateServer(config.DNSUpdate, Context.filters)
+		fatalOnError(errors.Annotate(err, "initializing dns_update: %w"))
+
+		if Context.dnsUpdate != nil {
+			err = Context.dnsUpdate.Start()
+			if err != nil {
+				log.Error("starting dns_update: %s", err)
+			}
+		}
+
+		if config.ConfigSync.Enabled {
+			Context.configSync = newConfigSync(config.ConfigSync, httpClient())
+		}
+
 		go func() {
 			startErr := startDNSServer()
 			if startErr != nil {
@@ -637,6 +867,15 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 				log.Error("starting dhcp server: %s", err)
 			}
 		}
+
+		if Context.secrets != nil {
+			// Encrypt the secrets, which may still be kept in plaintext.
+			onConfigModified()
+		}
+
+		if Context.configSync != nil {
+			Context.configSync.start()
+		}
 	}
 
 	Context.web.start()
@@ -781,10 +1020,22 @@ func cleanup(ctx context.Context) {
 		Context.web.close(ctx)
 		Context.web = nil
 	}
+	if Context.blockPage != nil {
+		Context.blockPage.close(ctx)
+		Context.blockPage = nil
+	}
 	if Context.auth != nil {
 		Context.auth.Close()
 		Context.auth = nil
 	}
+	if Context.audit != nil {
+		err := Context.audit.Close()
+		if err != nil {
+			log.Error("closing audit log: %s", err)
+		}
+
+		Context.audit = nil
+	}
 
 	err := stopDNSServer()
 	if err != nil {
@@ -804,7 +1055,42 @@ func cleanup(ctx context.Context) {
 		}
 	}
 
+	if Context.notifications != nil {
+		Context.notifications.close()
+		Context.notifications = nil
+	}
+
+	if Context.configSync != nil {
+		Context.configSync.close()
+		Context.configSync = nil
+	}
+
+	if Context.unblockRequests != nil {
+		Context.unblockRequests.close()
+		Context.unblockRequests = nil
+	}
+
+	if Context.integration != nil {
+		Context.integration.close()
+		Context.integration = nil
+	}
+
+	if Context.ddns != nil {
+		Context.ddns.Close()
+		Context.ddns = nil
+	}
+
+	if Context.dnsUpdate != nil {
+		err := Context.dnsUpdate.Close()
+		if err != nil {
+			log.Error("closing dns_update: %s", err)
+		}
+
+		Context.dnsUpdate = nil
+	}
+
 	if Context.tls != nil {
+		Context.tls.close()
 		Context.tls = nil
 	}
 }
@@ -933,7 +1219,7 @@ func cmdlineUpdate(opts options, upd *updater.Updater) {
 	//
 	// TODO(e.burkov):  We could probably initialize the internal resolver
 	// separately.
-	err := initDNSServer(nil, nil, nil, nil, nil, nil, &tlsConfigSettings{})
+	err := initDNSServer(nil, nil, nil, nil, nil, nil, nil, &tlsConfigSettings{})
 	fatalOnError(err)
 
 	log.Info("cmdline update: performing update")
@@ -0,0 +1,355 @@
+package home
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// integrationEventType is the type of an event of the integration event
+// stream.
+type integrationEventType string
+
+// integrationEventType values.
+const (
+	// integrationEventState is the first event of each stream containing the
+	// current state, see [integrationStateJSON].
+	integrationEventState integrationEventType = "state"
+
+	// integrationEventToggles is sent when the protection, the safe browsing,
+	// or the parental control is turned on or off, see [integrationToggles].
+	integrationEventToggles integrationEventType = "toggles"
+
+	// integrationEventClientBlocked is sent when the number of the blocked
+	// requests of a client changes, see [integrationClientJSON].
+	integrationEventClientBlocked integrationEventType = "client_blocked"
+
+	// integrationEventNotification is sent for each notable event, for example
+	// a new DHCP lease or an anomaly, see [notify.Event].
+	integrationEventNotification integrationEventType = "notification"
+)
+
+// integrationEvent is a single event of the integration event stream.
+type integrationEvent struct {
+	// Data is the data of the event, which is encoded into JSON.
+	Data any
+
+	// Type is the type of the event.
+	Type integrationEventType
+}
+
+// integrationToggles are the settings, which the integrations may turn on and
+// off.
+type integrationToggles struct {
+	// ProtectionDisabledUntil is the time until which the protection is
+	// paused, if any.
+	ProtectionDisabledUntil *time.Time `json:"protection_disabled_until,omitempty"`
+
+	// Protection is true if the protection is enabled.
+	Protection bool `json:"protection"`
+
+	// SafeBrowsing is true if the safe browsing is enabled.
+	SafeBrowsing bool `json:"safebrowsing"`
+
+	// Parental is true if the parental control is enabled.
+	Parental bool `json:"parental"`
+}
+
+// equal returns true if t and other are equal.  Both must not be nil.
+func (t *integrationToggles) equal(other *integrationToggles) (ok bool) {
+	until, otherUntil := t.ProtectionDisabledUntil, other.ProtectionDisabledUntil
+	if (until == nil) != (otherUntil == nil) || (until != nil && !until.Equal(*otherUntil)) {
+		return false
+	}
+
+	return t.Protection == other.Protection &&
+		t.SafeBrowsing == other.SafeBrowsing &&
+		t.Parental == other.Parental
+}
+
+// currentToggles returns the current state of the toggles from [Context].
+func currentToggles() (t *integrationToggles) {
+	t = &integrationToggles{}
+	if Context.dnsServer != nil {
+		t.Protection, t.ProtectionDisabledUntil = Context.dnsServer.UpdatedProtectionStatus()
+	}
+
+	if Context.filters != nil {
+		s := Context.filters.Settings()
+		t.SafeBrowsing, t.Parental = s.SafeBrowsingEnabled, s.ParentalEnabled
+	}
+
+	return t
+}
+
+// persistentClientName returns the name of the persistent client identified
+// by id, if any.
+func persistentClientName(id string) (name string) {
+	if c, ok := Context.clients.Find(id); ok {
+		return c.Name
+	}
+
+	return ""
+}
+
+// maxBlockedClients is the maximum number of the clients, for which the
+// blocked requests are counted.
+const maxBlockedClients = 1000
+
+// blockedCounter is a [blockhook.Interface] implementation, which counts the
+// blocked requests of each client.
+type blockedCounter struct {
+	// mu protects counts.
+	mu *sync.Mutex
+
+	// counts are the numbers of the blocked requests since the start by the
+	// ClientID or the IP address of the client.
+	counts map[string]uint64
+}
+
+// type check
+var _ blockhook.Interface = (*blockedCounter)(nil)
+
+// Notify implements the [blockhook.Interface] interface for *blockedCounter.
+// The requests of the new clients above [maxBlockedClients] aren't counted.
+func (c *blockedCounter) Notify(e *blockhook.Event) {
+	key := e.ClientID
+	if key == "" {
+		if !e.ClientIP.IsValid() {
+			return
+		}
+
+		key = e.ClientIP.String()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.counts[key]; ok || len(c.counts) < maxBlockedClients {
+		c.counts[key]++
+	}
+}
+
+// snapshot returns a copy of the current counts.
+func (c *blockedCounter) snapshot() (counts map[string]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return maps.Clone(c.counts)
+}
+
+// integrationCheckIvl is the interval between the checks of the state for the
+// changes, which are then sent through the event stream.
+const integrationCheckIvl = 5 * time.Second
+
+// maxIntegrationSubscribers is the maximum number of the simultaneous event
+// streams.
+const maxIntegrationSubscribers = 16
+
+// subscriberBufSize is the maximum number of the events waiting to be sent to
+// a single subscriber.  When it's exceeded, the new events are dropped.
+const subscriberBufSize = 64
+
+// integration is the machine-friendly API for the home automation platforms.
+// It streams the changes of the state and the notable events to the
+// subscribers.  It also implements [notify.Interface] to receive the notable
+// events.
+type integration struct {
+	// blocked counts the blocked requests of each client.
+	blocked *blockedCounter
+
+	// toggleState returns the current state of the toggles.  It's
+	// [currentToggles] except in tests.
+	toggleState func() (t *integrationToggles)
+
+	// clientName returns the name of the persistent client by its ClientID or
+	// IP address or an empty string.  It's [persistentClientName] except in
+	// tests.
+	clientName func(id string) (name string)
+
+	// done is closed when the integration is closed.
+	done chan struct{}
+
+	// mu protects subs, toggles, and reported.
+	mu *sync.Mutex
+
+	// subs are the channels of the subscribers.
+	subs map[chan *integrationEvent]struct{}
+
+	// toggles is the state of the toggles as of the last check.  It's nil
+	// before the first check.
+	toggles *integrationToggles
+
+	// reported are the numbers of the blocked requests as of the last check.
+	reported map[string]uint64
+}
+
+// type check
+var _ notify.Interface = (*integration)(nil)
+
+// newIntegration returns a new properly initialized *integration.
+func newIntegration() (i *integration) {
+	return &integration{
+		blocked: &blockedCounter{
+			mu:     &sync.Mutex{},
+			counts: map[string]uint64{},
+		},
+		toggleState: currentToggles,
+		clientName:  persistentClientName,
+		done:        make(chan struct{}),
+		mu:          &sync.Mutex{},
+		subs:        map[chan *integrationEvent]struct{}{},
+		reported:    map[string]uint64{},
+	}
+}
+
+// start starts checking the state for the changes.
+func (i *integration) start() {
+	go i.checkLoop()
+}
+
+// close stops the checks and ends the event streams.
+func (i *integration) close() {
+	close(i.done)
+}
+
+// checkLoop checks the state for the changes until i is closed.  It's intended
+// to be used as a goroutine.
+func (i *integration) checkLoop() {
+	defer log.OnPanic("integration: checking state")
+
+	ticker := time.NewTicker(integrationCheckIvl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.done:
+			return
+		case <-ticker.C:
+			i.check()
+		}
+	}
+}
+
+// check sends the events about the changes of the toggles and of the numbers of
+// the blocked requests since the last check.
+func (i *integration) check() {
+	t := i.toggleState()
+	counts := i.blocked.snapshot()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.toggles == nil || !i.toggles.equal(t) {
+		i.toggles = t
+		i.publishLocked(&integrationEvent{
+			Data: t,
+			Type: integrationEventToggles,
+		})
+	}
+
+	keys := maps.Keys(counts)
+	slices.Sort(keys)
+	for _, k := range keys {
+		n := counts[k]
+		prev := i.reported[k]
+		if n == prev {
+			continue
+		}
+
+		i.reported[k] = n
+		i.publishLocked(&integrationEvent{
+			Data: &integrationClientJSON{
+				Client:  k,
+				Name:    i.clientName(k),
+				Blocked: n,
+				Delta:   n - prev,
+			},
+			Type: integrationEventClientBlocked,
+		})
+	}
+}
+
+// Notify implements the [notify.Interface] interface for *integration.
+func (i *integration) Notify(e *notify.Event) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.publishLocked(&integrationEvent{
+		Data: e,
+		Type: integrationEventNotification,
+	})
+}
+
+// publishLocked sends e to all subscribers without blocking.  i.mu is expected
+// to be locked.
+func (i *integration) publishLocked(e *integrationEvent) {
+	for ch := range i.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Debug("integration: subscriber is too slow; dropping %s event", e.Type)
+		}
+	}
+}
+
+// subscribe adds a new subscriber and returns the channel of its events.  ok
+// is false if there are too many subscribers already.  unsubscribe must be
+// called when the subscriber is done.
+func (i *integration) subscribe() (ch chan *integrationEvent, unsubscribe func(), ok bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(i.subs) >= maxIntegrationSubscribers {
+		return nil, nil, false
+	}
+
+	ch = make(chan *integrationEvent, subscriberBufSize)
+	i.subs[ch] = struct{}{}
+
+	unsubscribe = func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		delete(i.subs, ch)
+	}
+
+	return ch, unsubscribe, true
+}
+
+// state returns the current state.
+func (i *integration) state() (s *integrationStateJSON) {
+	counts := i.blocked.snapshot()
+
+	s = &integrationStateJSON{
+		Toggles: i.toggleState(),
+		Clients: make([]*integrationClientJSON, 0, len(counts)),
+	}
+
+	for k, n := range counts {
+		s.Clients = append(s.Clients, &integrationClientJSON{
+			Client:  k,
+			Name:    i.clientName(k),
+			Blocked: n,
+		})
+	}
+
+	slices.SortFunc(s.Clients, func(a, b *integrationClientJSON) (res int) {
+		switch {
+		case a.Blocked > b.Blocked:
+			return -1
+		case a.Blocked < b.Blocked:
+			return 1
+		default:
+			return strings.Compare(a.Client, b.Client)
+		}
+	})
+
+	return s
+}
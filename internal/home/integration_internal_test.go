@@ -0,0 +1,167 @@
+package home
+
+import (
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/blockhook"
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestIntegration returns a new *integration with toggles and the client
+// named "tablet" with the IP address 192.0.2.1.
+func newTestIntegration(t *testing.T, toggles *integrationToggles) (i *integration) {
+	t.Helper()
+
+	i = newIntegration()
+	i.toggleState = func() (res *integrationToggles) {
+		c := *toggles
+
+		return &c
+	}
+	i.clientName = func(id string) (name string) {
+		if id == "192.0.2.1" {
+			return "tablet"
+		}
+
+		return ""
+	}
+
+	return i
+}
+
+// receiveEvent returns the next event from ch or fails the test.
+func receiveEvent(t *testing.T, ch chan *integrationEvent) (e *integrationEvent) {
+	t.Helper()
+
+	select {
+	case e = <-ch:
+		return e
+	default:
+		require.FailNow(t, "no event")
+
+		return nil
+	}
+}
+
+func TestIntegration_check(t *testing.T) {
+	toggles := &integrationToggles{
+		Protection: true,
+	}
+
+	i := newTestIntegration(t, toggles)
+
+	ch, unsubscribe, ok := i.subscribe()
+	require.True(t, ok)
+	t.Cleanup(unsubscribe)
+
+	i.check()
+
+	e := receiveEvent(t, ch)
+	assert.Equal(t, integrationEventToggles, e.Type)
+	assert.Equal(t, toggles, e.Data)
+
+	i.check()
+	assert.Empty(t, ch)
+
+	blocked := &blockhook.Event{
+		ClientIP: netip.MustParseAddr("192.0.2.1"),
+	}
+	i.blocked.Notify(blocked)
+	i.blocked.Notify(blocked)
+	i.blocked.Notify(&blockhook.Event{ClientID: "laptop"})
+
+	until := time.Now().Add(time.Hour)
+	toggles.Protection, toggles.ProtectionDisabledUntil = false, &until
+
+	i.check()
+
+	e = receiveEvent(t, ch)
+	assert.Equal(t, integrationEventToggles, e.Type)
+	assert.Equal(t, toggles, e.Data)
+
+	e = receiveEvent(t, ch)
+	assert.Equal(t, integrationEventClientBlocked, e.Type)
+	assert.Equal(t, &integrationClientJSON{
+		Client:  "192.0.2.1",
+		Name:    "tablet",
+		Blocked: 2,
+		Delta:   2,
+	}, e.Data)
+
+	e = receiveEvent(t, ch)
+	assert.Equal(t, integrationEventClientBlocked, e.Type)
+	assert.Equal(t, &integrationClientJSON{
+		Client:  "laptop",
+		Blocked: 1,
+		Delta:   1,
+	}, e.Data)
+
+	i.blocked.Notify(&blockhook.Event{ClientID: "laptop"})
+	i.check()
+
+	e = receiveEvent(t, ch)
+	assert.Equal(t, &integrationClientJSON{
+		Client:  "laptop",
+		Blocked: 2,
+		Delta:   1,
+	}, e.Data)
+	assert.Empty(t, ch)
+
+	n := &notify.Event{Type: notify.EventDHCPLeaseCreated}
+	i.Notify(n)
+
+	e = receiveEvent(t, ch)
+	assert.Equal(t, integrationEventNotification, e.Type)
+	assert.Same(t, n, e.Data)
+
+	s := i.state()
+	assert.Equal(t, toggles, s.Toggles)
+	assert.Equal(t, []*integrationClientJSON{{
+		Client:  "192.0.2.1",
+		Name:    "tablet",
+		Blocked: 2,
+	}, {
+		Client:  "laptop",
+		Blocked: 2,
+	}}, s.Clients)
+}
+
+func TestIntegration_subscribe(t *testing.T) {
+	i := newTestIntegration(t, &integrationToggles{})
+
+	for n := 0; n < maxIntegrationSubscribers; n++ {
+		_, unsubscribe, ok := i.subscribe()
+		require.True(t, ok)
+
+		t.Cleanup(unsubscribe)
+	}
+
+	_, _, ok := i.subscribe()
+	require.False(t, ok)
+
+	// The events for the slow subscribers are dropped.
+	for n := 0; n < subscriberBufSize+1; n++ {
+		i.Notify(&notify.Event{Type: notify.EventAnomaly})
+	}
+}
+
+func TestBlockedCounter_Notify(t *testing.T) {
+	i := newTestIntegration(t, &integrationToggles{})
+
+	for n := 0; n < maxBlockedClients+1; n++ {
+		i.blocked.Notify(&blockhook.Event{ClientID: "client" + strconv.Itoa(n)})
+	}
+
+	i.blocked.Notify(&blockhook.Event{})
+	i.blocked.Notify(&blockhook.Event{ClientID: "client0"})
+
+	counts := i.blocked.snapshot()
+	assert.Len(t, counts, maxBlockedClients)
+	assert.Equal(t, uint64(2), counts["client0"])
+	assert.NotContains(t, counts, "client"+strconv.Itoa(maxBlockedClients))
+}
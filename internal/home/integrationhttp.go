@@ -0,0 +1,187 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// integrationClientJSON is the JSON structure for the number of the blocked
+// requests of a client.
+type integrationClientJSON struct {
+	// Client is the ClientID or the IP address of the client.
+	Client string `json:"client"`
+
+	// Name is the name of the persistent client, if any.
+	Name string `json:"name,omitempty"`
+
+	// Blocked is the number of the blocked requests since the start.
+	Blocked uint64 `json:"blocked"`
+
+	// Delta is the number of the blocked requests since the previous event.
+	// It's only set in the events.
+	Delta uint64 `json:"delta,omitempty"`
+}
+
+// integrationStateJSON is the JSON structure for the state of AdGuard Home
+// for the integrations.
+type integrationStateJSON struct {
+	// Toggles is the current state of the toggles.
+	Toggles *integrationToggles `json:"toggles"`
+
+	// Clients are the numbers of the blocked requests of the clients since the
+	// start, sorted by the number in descending order.
+	Clients []*integrationClientJSON `json:"clients"`
+}
+
+// handleState is the handler for the GET /control/integration/state HTTP API.
+func (i *integration) handleState(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, i.state())
+}
+
+// integrationTogglesReq is the JSON structure for the request to set the
+// toggles.  The absent fields aren't changed.
+type integrationTogglesReq struct {
+	Protection   *bool `json:"protection"`
+	SafeBrowsing *bool `json:"safebrowsing"`
+	Parental     *bool `json:"parental"`
+}
+
+// handleToggles is the handler for the PUT /control/integration/toggles HTTP
+// API.  Unlike the toggle-like HTTP APIs, it sets the state, so repeating the
+// request doesn't change anything.  Enabling the protection also ends its
+// pause.
+func (i *integration) handleToggles(w http.ResponseWriter, r *http.Request) {
+	req := &integrationTogglesReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	if req.Protection != nil {
+		Context.filters.SetProtectionStatus(*req.Protection, nil)
+	}
+
+	if req.SafeBrowsing != nil {
+		Context.filters.SetSafeBrowsingEnabled(*req.SafeBrowsing)
+	}
+
+	if req.Parental != nil {
+		Context.filters.SetParentalEnabled(*req.Parental)
+	}
+
+	onConfigModified()
+
+	// Send the changes to the subscribers right away.
+	i.check()
+
+	aghhttp.WriteJSONResponseOK(w, r, i.toggleState())
+}
+
+// keepaliveIvl is the interval between the comments sent through the idle
+// event streams to keep the connections open.
+const keepaliveIvl = 30 * time.Second
+
+// handleEvents is the handler for the GET /control/integration/events HTTP
+// API.  It streams the events in the server-sent events format until the
+// client disconnects.  The first event is the current state.
+func (i *integration) handleEvents(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "streaming is not supported")
+
+		return
+	}
+
+	ch, unsubscribe, ok := i.subscribe()
+	if !ok {
+		aghhttp.Error(r, w, http.StatusServiceUnavailable, "too many event streams")
+
+		return
+	}
+	defer unsubscribe()
+
+	// The stream is longer than the write timeout of the server.
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		log.Debug("integration: removing write deadline: %s", err)
+	}
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, "text/event-stream")
+	h.Set(httphdr.CacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(keepaliveIvl)
+	defer ticker.Stop()
+
+	e := &integrationEvent{
+		Data: i.state(),
+		Type: integrationEventState,
+	}
+
+	for {
+		if e != nil {
+			err = writeEvent(w, e)
+		} else {
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+
+		if err != nil {
+			log.Debug("integration: writing event: %s", err)
+
+			return
+		}
+
+		f.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-i.done:
+			return
+		case e = <-ch:
+		case <-ticker.C:
+			e = nil
+		}
+	}
+}
+
+// writeEvent writes e to w in the server-sent events format.
+func writeEvent(w http.ResponseWriter, e *integrationEvent) (err error) {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return fmt.Errorf("encoding %s event: %w", e.Type, err)
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+
+	// Don't wrap the error, because it's informative enough as is.
+	return err
+}
+
+// integrationEventsPath is the path of the integration event stream HTTP API.
+const integrationEventsPath = "/control/integration/events"
+
+// registerWebHandlers registers the HTTP handlers of the integration API.
+func (i *integration) registerWebHandlers() {
+	httpRegister(http.MethodGet, "/control/integration/state", i.handleState)
+	httpRegister(http.MethodPut, "/control/integration/toggles", i.handleToggles)
+
+	// Don't compress the event stream, since the compression buffers the
+	// events.
+	Context.mux.Handle(
+		integrationEventsPath,
+		postInstallHandler(optionalAuthHandler(roleHandler(ensureHandler(
+			http.MethodGet,
+			i.handleEvents,
+		)))),
+	)
+}
@@ -0,0 +1,286 @@
+package home
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// debugAPIPrefix is the prefix of the HTTP APIs controlling the logging and
+// the profiling at runtime.  They are only available to the administrators.
+const debugAPIPrefix = "/control/debug"
+
+// maxRecentLogLines is the maximum number of the recent log lines kept for the
+// diagnostics bundle.
+const maxRecentLogLines = 1000
+
+// debugLogModules are the prefixes of the log messages of the modules, for
+// which the debug logging can be enabled separately, by the module name.
+var debugLogModules = map[string][]string{
+	"dhcpd":      {"dhcp:", "dhcpd:", "dhcpv4:", "dhcpv6:", "dhcpv6 ra:"},
+	"dnsforward": {"access:", "dns:", "dnsforward:", "ipset:"},
+	"filtering":  {"blocked services:", "filtering:", "rewrite:", "safesearch:"},
+}
+
+// logFilter defines which log messages are written.
+type logFilter struct {
+	// prefixes are the prefixes of the messages, which are written regardless
+	// of their level.
+	prefixes []string
+
+	// level is the maximum level of the other messages, which are written.
+	level log.Level
+}
+
+// logControl is an [io.Writer], which changes the logging settings at runtime.
+// When the debug logging is only enabled for some modules, it drops the
+// messages of the other modules with the levels above the configured one.  It
+// also keeps the recent written lines.
+type logControl struct {
+	// mu protects level and modules.
+	mu *sync.Mutex
+
+	// recentMu protects recent and next.
+	recentMu *sync.Mutex
+
+	// out is the actual output of the logs.
+	out io.Writer
+
+	// filter is the current filter of the messages.  If it's nil, all the
+	// messages are written.
+	filter atomic.Pointer[logFilter]
+
+	// modules are the sorted names of the modules, for which the debug logging
+	// is enabled.
+	modules []string
+
+	// recent is the ring buffer of the recent written lines.
+	recent [][]byte
+
+	// next is the index of the next line in recent.
+	next int
+
+	// level is the logging level of the modules, for which the debug logging
+	// isn't enabled.
+	level log.Level
+}
+
+// type check
+var _ io.Writer = (*logControl)(nil)
+
+// newLogControl returns a new *logControl, which replaces the current output of
+// the logs.
+func newLogControl() (c *logControl) {
+	c = &logControl{
+		mu:       &sync.Mutex{},
+		recentMu: &sync.Mutex{},
+		out:      log.Writer(),
+		modules:  []string{},
+		level:    log.GetLevel(),
+	}
+
+	log.SetOutput(c)
+
+	return c
+}
+
+// Write implements the [io.Writer] interface for *logControl.
+func (c *logControl) Write(b []byte) (n int, err error) {
+	f := c.filter.Load()
+	if f == nil {
+		return c.write(b)
+	}
+
+	lvl, msg, ok := parseLogLine(b)
+	if !ok || lvl <= f.level {
+		return c.write(b)
+	}
+
+	for _, p := range f.prefixes {
+		if bytes.HasPrefix(msg, []byte(p)) {
+			return c.write(b)
+		}
+	}
+
+	// Pretend that the message has been written, since it's dropped on
+	// purpose.
+	return len(b), nil
+}
+
+// write keeps b as a recent line and writes it to the output.
+func (c *logControl) write(b []byte) (n int, err error) {
+	func() {
+		c.recentMu.Lock()
+		defer c.recentMu.Unlock()
+
+		line := slices.Clone(b)
+		if len(c.recent) < maxRecentLogLines {
+			c.recent = append(c.recent, line)
+		} else {
+			c.recent[c.next] = line
+		}
+
+		c.next = (c.next + 1) % maxRecentLogLines
+	}()
+
+	return c.out.Write(b)
+}
+
+// recentLogs returns the recent written lines, oldest first.
+func (c *logControl) recentLogs() (data []byte) {
+	c.recentMu.Lock()
+	defer c.recentMu.Unlock()
+
+	for i := range c.recent {
+		data = append(data, c.recent[(c.next+i)%len(c.recent)]...)
+	}
+
+	return data
+}
+
+// parseLogLine returns the level and the message of the log line b written by
+// [log].  ok is false if b isn't a message with a level.
+func parseLogLine(b []byte) (lvl log.Level, msg []byte, ok bool) {
+	// The timestamp and the PID never contain brackets, so the first ones
+	// contain the level.
+	start := bytes.IndexByte(b, '[')
+	if start < 0 {
+		return 0, nil, false
+	}
+
+	end := bytes.Index(b[start:], []byte("] "))
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	lvl, err := parseLogLevel(string(b[start+1 : start+end]))
+	if err != nil {
+		return 0, nil, false
+	}
+
+	return lvl, b[start+end+2:], true
+}
+
+// parseLogLevel returns the log level with the name s.
+func parseLogLevel(s string) (lvl log.Level, err error) {
+	for _, l := range []log.Level{log.ERROR, log.INFO, log.DEBUG} {
+		if l.String() == s {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// set sets the logging level of all the modules to level and enables the debug
+// logging for modules.  modules must be valid and sorted.
+func (c *logControl) set(level log.Level, modules []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.level, c.modules = level, modules
+	if level == log.DEBUG || len(modules) == 0 {
+		c.filter.Store(nil)
+		log.SetLevel(level)
+
+		return
+	}
+
+	f := &logFilter{
+		level: level,
+	}
+	for _, m := range modules {
+		f.prefixes = append(f.prefixes, debugLogModules[m]...)
+	}
+
+	c.filter.Store(f)
+	log.SetLevel(log.DEBUG)
+}
+
+// logSettingsJSON is the JSON structure for the runtime logging settings.
+type logSettingsJSON struct {
+	// Level is the logging level of the modules, for which the debug logging
+	// isn't enabled.
+	Level string `json:"level"`
+
+	// Modules are the names of the modules, for which the debug logging is
+	// enabled.
+	Modules []string `json:"modules"`
+}
+
+// handleGetLog is the handler for the GET /control/debug/log HTTP API.
+func (c *logControl) handleGetLog(w http.ResponseWriter, r *http.Request) {
+	resp := &logSettingsJSON{}
+	func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		resp.Level = c.level.String()
+		resp.Modules = slices.Clone(c.modules)
+	}()
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handlePutLog is the handler for the PUT /control/debug/log/update HTTP API.
+// The settings are only kept until AdGuard Home is restarted.
+func (c *logControl) handlePutLog(w http.ResponseWriter, r *http.Request) {
+	req := &logSettingsJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	level, err := parseLogLevel(req.Level)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "level: %s", err)
+
+		return
+	}
+
+	modules := slices.Clone(req.Modules)
+	slices.Sort(modules)
+	modules = slices.Compact(modules)
+	for _, m := range modules {
+		if _, ok := debugLogModules[m]; !ok {
+			known := maps.Keys(debugLogModules)
+			slices.Sort(known)
+
+			aghhttp.Error(
+				r,
+				w,
+				http.StatusBadRequest,
+				"unknown module %q, expected one of %q",
+				m,
+				known,
+			)
+
+			return
+		}
+	}
+
+	c.set(level, modules)
+
+	log.Info("home: log level is set to %s, debug modules: %q", level, modules)
+
+	c.handleGetLog(w, r)
+}
+
+// registerDebugHandlers registers the HTTP handlers controlling the logging
+// using c and the profiling at runtime.
+func registerDebugHandlers(c *logControl) {
+	httpRegister(http.MethodGet, debugAPIPrefix+"/log", c.handleGetLog)
+	httpRegister(http.MethodPut, debugAPIPrefix+"/log/update", c.handlePutLog)
+	httpRegister(http.MethodGet, debugAPIPrefix+"/profile", handleDebugProfile)
+}
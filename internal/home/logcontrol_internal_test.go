@@ -0,0 +1,109 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogControl_Write(t *testing.T) {
+	aghtest.ReplaceLogLevel(t, log.INFO)
+
+	const (
+		dnsDebug    = "2023/10/15 10:00:00.000000 1#2 [debug] dnsforward: dns debug\n"
+		dhcpDebug   = "2023/10/15 10:00:00.000000 1#2 [debug] dhcpv4: dhcp debug\n"
+		filterInfo  = "2023/10/15 10:00:00.000000 1#2 [info] filtering: filtering info\n"
+		filterError = "2023/10/15 10:00:00.000000 1#2 [error] filtering: filtering error\n"
+		noLevel     = "2023/10/15 10:00:00.000000 no level\n"
+	)
+
+	lines := []string{dnsDebug, dhcpDebug, filterInfo, filterError, noLevel}
+
+	testCases := []struct {
+		name    string
+		modules []string
+		want    []string
+		level   log.Level
+	}{{
+		name:    "info",
+		modules: []string{},
+		want:    lines,
+		level:   log.INFO,
+	}, {
+		name:    "info_dnsforward",
+		modules: []string{"dnsforward"},
+		want:    []string{dnsDebug, filterInfo, filterError, noLevel},
+		level:   log.INFO,
+	}, {
+		name:    "error_dhcpd",
+		modules: []string{"dhcpd"},
+		want:    []string{dhcpDebug, filterError, noLevel},
+		level:   log.ERROR,
+	}, {
+		name:    "error_filtering",
+		modules: []string{"filtering"},
+		want:    []string{filterInfo, filterError, noLevel},
+		level:   log.ERROR,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			c := &logControl{
+				mu:       &sync.Mutex{},
+				recentMu: &sync.Mutex{},
+				out:      out,
+				modules:  []string{},
+				level:    log.INFO,
+			}
+
+			c.set(tc.level, tc.modules)
+			for _, l := range lines {
+				n, err := c.Write([]byte(l))
+				require.NoError(t, err)
+
+				assert.Equal(t, len(l), n)
+			}
+
+			want := &bytes.Buffer{}
+			for _, l := range tc.want {
+				want.WriteString(l)
+			}
+
+			assert.Equal(t, want.String(), out.String())
+		})
+	}
+}
+
+func TestLogControl_recentLogs(t *testing.T) {
+	c := &logControl{
+		mu:       &sync.Mutex{},
+		recentMu: &sync.Mutex{},
+		out:      &bytes.Buffer{},
+		modules:  []string{},
+		level:    log.INFO,
+	}
+
+	_, err := c.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "first\n", string(c.recentLogs()))
+
+	for i := 0; i < maxRecentLogLines; i++ {
+		_, err = c.Write([]byte(fmt.Sprintf("line %d\n", i)))
+		require.NoError(t, err)
+	}
+
+	logs := strings.Split(string(c.recentLogs()), "\n")
+	require.Len(t, logs, maxRecentLogLines+1)
+
+	assert.Equal(t, "line 0", logs[0])
+	assert.Equal(t, fmt.Sprintf("line %d", maxRecentLogLines-1), logs[maxRecentLogLines-1])
+}
@@ -0,0 +1,157 @@
+package home
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/AdGuardHome/internal/metrics"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/NYTimes/gziphandler"
+)
+
+// httpMetricsConfig is the block with the configuration of the metrics HTTP
+// handler.
+type httpMetricsConfig struct {
+	// Token is the bearer token, which grants access to the metrics in
+	// addition to the regular authentication.  If empty, only the regular
+	// authentication is used.
+	Token string `yaml:"token"`
+
+	// Enabled defines if the metrics HTTP handler is enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// metricsPath is the path of the metrics HTTP handler.
+const metricsPath = "/metrics"
+
+// metricsExporter serves the metrics of AdGuard Home in the Prometheus
+// text-based exposition format.
+type metricsExporter struct {
+	// reg contains all the exposed metrics.
+	reg *metrics.Registry
+
+	// dns are the metrics of the DNS server.
+	dns *dnsforward.Metrics
+
+	// token is the bearer token, which grants access to the metrics.  It's
+	// empty if only the regular authentication is used.
+	token string
+}
+
+// newMetricsExporter returns a new properly initialized *metricsExporter.
+// The metrics of the DHCP server and filter lists are collected from
+// [Context] on each scrape.
+func newMetricsExporter(conf *httpMetricsConfig) (m *metricsExporter) {
+	reg := metrics.NewRegistry()
+	reg.RegisterRuntime()
+
+	reg.NewGaugeFunc(
+		"adguardhome_dhcp_leases",
+		"Number of DHCP leases by the address family and the lease type.",
+		[]string{"family", "type"},
+		collectDHCPLeases,
+	)
+
+	reg.NewGaugeFunc(
+		"adguardhome_filter_list_rules",
+		"Number of rules in the filter lists.",
+		[]string{"id", "name", "enabled", "allowlist"},
+		collectFilterListSizes,
+	)
+
+	return &metricsExporter{
+		reg:   reg,
+		dns:   dnsforward.NewMetrics(reg),
+		token: conf.Token,
+	}
+}
+
+// collectDHCPLeases collects the numbers of the DHCP leases.  It is a
+// [metrics.CollectFunc].
+func collectDHCPLeases(add func(v float64, vals ...string)) {
+	var counts [2][2]int
+	if Context.dhcpServer != nil {
+		for _, l := range Context.dhcpServer.Leases() {
+			var fam, typ int
+			if l.IP.Is6() {
+				fam = 1
+			}
+
+			if l.IsStatic {
+				typ = 1
+			}
+
+			counts[fam][typ]++
+		}
+	}
+
+	for fam, famName := range []string{"ipv4", "ipv6"} {
+		for typ, typName := range []string{"dynamic", "static"} {
+			add(float64(counts[fam][typ]), famName, typName)
+		}
+	}
+}
+
+// collectFilterListSizes collects the numbers of rules in the filter lists.
+// It is a [metrics.CollectFunc].
+func collectFilterListSizes(add func(v float64, vals ...string)) {
+	if Context.filters == nil {
+		return
+	}
+
+	for _, s := range Context.filters.FilterListSizes() {
+		add(
+			float64(s.RulesCount),
+			strconv.FormatInt(s.ID, 10),
+			s.Name,
+			strconv.FormatBool(s.Enabled),
+			strconv.FormatBool(s.Allowlist),
+		)
+	}
+}
+
+// registerWebHandlers registers the HTTP handler for the metrics.
+func (m *metricsExporter) registerWebHandlers() {
+	Context.mux.Handle(metricsPath, postInstallHandler(gziphandler.GzipHandler(m)))
+}
+
+// type check
+var _ http.Handler = (*metricsExporter)(nil)
+
+// ServeHTTP implements the [http.Handler] interface for *metricsExporter.  The
+// requests with the valid bearer token are served without the regular
+// authentication.
+func (m *metricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		aghhttp.Error(r, w, http.StatusMethodNotAllowed, "only method %s is allowed", http.MethodGet)
+
+		return
+	}
+
+	if m.hasValidToken(r) {
+		m.reg.ServeHTTP(w, r)
+
+		return
+	}
+
+	optionalAuth(m.reg.ServeHTTP)(w, r)
+}
+
+// hasValidToken returns true if the token is configured and r contains it as
+// the bearer token.
+func (m *metricsExporter) hasValidToken(r *http.Request) (ok bool) {
+	if m.token == "" {
+		return false
+	}
+
+	tok, ok := strings.CutPrefix(r.Header.Get(httphdr.Authorization), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(tok), []byte(m.token)) == 1
+}
@@ -0,0 +1,91 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsExporter_ServeHTTP(t *testing.T) {
+	const token = "secret-token"
+
+	users := []webUser{{
+		Name:         "name",
+		PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2",
+	}}
+
+	Context.auth = InitAuth(filepath.Join(t.TempDir(), "sessions.db"), users, 60, nil)
+	t.Cleanup(func() {
+		Context.auth.Close()
+		Context.auth = nil
+	})
+
+	testCases := []struct {
+		setReq   func(r *http.Request)
+		name     string
+		method   string
+		token    string
+		wantCode int
+	}{{
+		setReq:   func(r *http.Request) { r.Header.Set(httphdr.Authorization, "Bearer "+token) },
+		name:     "token",
+		method:   http.MethodGet,
+		token:    token,
+		wantCode: http.StatusOK,
+	}, {
+		setReq:   func(r *http.Request) { r.Header.Set(httphdr.Authorization, "Bearer bad") },
+		name:     "bad_token",
+		method:   http.MethodGet,
+		token:    token,
+		wantCode: http.StatusForbidden,
+	}, {
+		setReq:   func(r *http.Request) { r.Header.Set(httphdr.Authorization, "Bearer ") },
+		name:     "no_token_configured",
+		method:   http.MethodGet,
+		token:    "",
+		wantCode: http.StatusForbidden,
+	}, {
+		setReq:   func(r *http.Request) { r.SetBasicAuth("name", "password") },
+		name:     "basic_auth",
+		method:   http.MethodGet,
+		token:    token,
+		wantCode: http.StatusOK,
+	}, {
+		setReq:   func(r *http.Request) {},
+		name:     "no_auth",
+		method:   http.MethodGet,
+		token:    token,
+		wantCode: http.StatusForbidden,
+	}, {
+		setReq:   func(r *http.Request) { r.Header.Set(httphdr.Authorization, "Bearer "+token) },
+		name:     "bad_method",
+		method:   http.MethodPost,
+		token:    token,
+		wantCode: http.StatusMethodNotAllowed,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMetricsExporter(&httpMetricsConfig{
+				Token:   tc.token,
+				Enabled: true,
+			})
+
+			r := httptest.NewRequest(tc.method, metricsPath, nil)
+			tc.setReq(r)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.wantCode, w.Code)
+			if tc.wantCode == http.StatusOK {
+				assert.Contains(t, w.Body.String(), "\nadguardhome_dhcp_leases{family=\"ipv4\",type=\"static\"} 0\n")
+				assert.Contains(t, w.Body.String(), "\n# TYPE adguardhome_dns_queries_total counter\n")
+			}
+		})
+	}
+}
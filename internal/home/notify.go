@@ -0,0 +1,601 @@
+package home
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/AdguardTeam/AdGuardHome/internal/anomaly"
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"golang.org/x/exp/slices"
+)
+
+// notificationsConfig is the configuration of the notifications about the
+// notable events.
+type notificationsConfig struct {
+	// Channels are the channels, through which the notifications are sent.
+	Channels []*notificationChannel `yaml:"channels"`
+
+	// CertificateExpiry is the duration before the expiration of the TLS
+	// certificate, within which the notifications about it are sent.
+	CertificateExpiry timeutil.Duration `yaml:"certificate_expiry"`
+}
+
+// defaultCertificateExpiry is the default duration before the expiration of
+// the TLS certificate, within which the notifications about it are sent.
+const defaultCertificateExpiry = 7 * timeutil.Day
+
+// validate returns an error if the notifications configuration is invalid.
+func (c *notificationsConfig) validate() (err error) {
+	if c == nil {
+		return nil
+	}
+
+	if c.CertificateExpiry.Duration < 0 {
+		return fmt.Errorf(
+			"certificate_expiry: must not be negative, got %s",
+			c.CertificateExpiry,
+		)
+	}
+
+	for i, ch := range c.Channels {
+		err = ch.validate()
+		if err != nil {
+			return fmt.Errorf("channel at index %d: %w", i, err)
+		}
+
+		if slices.IndexFunc(c.Channels[:i], func(o *notificationChannel) (ok bool) {
+			return o.Name == ch.Name
+		}) != -1 {
+			return fmt.Errorf("channel at index %d: duplicate name %q", i, ch.Name)
+		}
+	}
+
+	return nil
+}
+
+// notificationChannel is the configuration of a single notification channel.
+// See [notify.ChannelConfig].
+type notificationChannel struct {
+	// Name is the unique name of the channel.
+	Name string `yaml:"name" json:"name"`
+
+	// Type is the type of the channel.
+	Type notify.ChannelType `yaml:"type" json:"type"`
+
+	// URL is the address of the webhook, the Telegram Bot API server, or the
+	// syslog server.  See [notify.ChannelConfig.URL].
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+
+	// Template is the template of the messages.  See
+	// [notify.ChannelConfig.Template].
+	Template string `yaml:"template,omitempty" json:"template,omitempty"`
+
+	// BotToken is the token of the Telegram bot.
+	BotToken string `yaml:"bot_token,omitempty" json:"bot_token,omitempty"`
+
+	// ChatID is the ID of the Telegram chat.
+	ChatID string `yaml:"chat_id,omitempty" json:"chat_id,omitempty"`
+
+	// SMTPAddr is the address of the SMTP server in the host:port form.
+	SMTPAddr string `yaml:"smtp_addr,omitempty" json:"smtp_addr,omitempty"`
+
+	// SMTPUsername is the name of the user on the SMTP server.
+	SMTPUsername string `yaml:"smtp_username,omitempty" json:"smtp_username,omitempty"`
+
+	// SMTPPassword is the password of the user on the SMTP server.
+	SMTPPassword string `yaml:"smtp_password,omitempty" json:"smtp_password,omitempty"`
+
+	// From is the address of the sender of the email messages.
+	From string `yaml:"from,omitempty" json:"from,omitempty"`
+
+	// To are the addresses of the recipients of the email messages.
+	To []string `yaml:"to,omitempty" json:"to,omitempty"`
+
+	// Command is the executable and its arguments run for each message.  It
+	// can only be set in the configuration file, so it's not exposed through
+	// the HTTP API.
+	Command []string `yaml:"command,omitempty" json:"-"`
+
+	// Events are the types of the events sent through the channel.  If empty,
+	// all events except the DHCP lease ones are sent.
+	Events []notify.EventType `yaml:"events" json:"events"`
+
+	// RateLimit is the minimum duration between two messages sent through the
+	// channel.
+	RateLimit timeutil.Duration `yaml:"rate_limit,omitempty" json:"rate_limit"`
+
+	// Enabled defines if the notifications are sent through the channel.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+// validate returns an error if the channel configuration is invalid.
+func (c *notificationChannel) validate() (err error) {
+	if c == nil {
+		return errors.Error("no value")
+	} else if c.Name == "" {
+		return errors.Error("empty name")
+	}
+
+	conf, err := c.toInternal()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	// Creating the notifier doesn't connect anywhere, so it's used to validate
+	// the configuration.
+	n, err := notify.New(&notify.Config{
+		Channels: []*notify.ChannelConfig{conf},
+	})
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	n.Close()
+
+	return nil
+}
+
+// toInternal returns the configuration of the channel for the notifier.
+func (c *notificationChannel) toInternal() (conf *notify.ChannelConfig, err error) {
+	conf = &notify.ChannelConfig{
+		Name:      c.Name,
+		Type:      c.Type,
+		Template:  c.Template,
+		BotToken:  c.BotToken,
+		ChatID:    c.ChatID,
+		Command:   c.Command,
+		Events:    c.Events,
+		RateLimit: c.RateLimit.Duration,
+	}
+
+	if c.URL != "" {
+		conf.URL, err = url.Parse(c.URL)
+		if err != nil {
+			return nil, fmt.Errorf("bad url: %w", err)
+		}
+	}
+
+	if c.Type == notify.ChannelEmail {
+		conf.Email = &notify.EmailConfig{
+			Addr:     c.SMTPAddr,
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+			From:     c.From,
+			To:       c.To,
+		}
+	}
+
+	return conf, nil
+}
+
+// withoutSecrets returns a copy of c without the bot token and the SMTP
+// password.
+func (c *notificationChannel) withoutSecrets() (res *notificationChannel) {
+	res = &notificationChannel{}
+	*res = *c
+	res.BotToken, res.SMTPPassword = "", ""
+
+	return res
+}
+
+// certCheckIvl is the interval between the checks of the expiration of the TLS
+// certificate.
+const certCheckIvl = 1 * time.Hour
+
+// repeatIvl is the minimum interval between the repeated notifications about
+// the same ongoing condition, such as the exhausted DHCP pool or the expiring
+// certificate.
+const repeatIvl = 24 * time.Hour
+
+// notificationsContainer contains the notification channels and sends the
+// notifications through them.
+type notificationsContainer struct {
+	// done is closed when the container is closing.
+	done chan struct{}
+
+	// hostname is the name of the host used in the messages.
+	hostname string
+
+	// certExpiry is the duration before the expiration of the TLS
+	// certificate, within which the notifications about it are sent.
+	certExpiry time.Duration
+
+	// mu protects all the fields below.
+	mu *sync.RWMutex
+
+	// notifier sends the notifications.  It's nil if there are no enabled
+	// channels.
+	notifier *notify.Notifier
+
+	// stream receives all the events regardless of the channels, for example
+	// to send them through the integration event stream.  It must not be nil
+	// and must only be set before the events are sent.
+	stream notify.Interface
+
+	// lastSent are the times of the last notifications about the ongoing
+	// conditions by the event type.
+	lastSent map[notify.EventType]time.Time
+
+	// channels are the configured channels.
+	channels []*notificationChannel
+}
+
+// type check
+var _ notify.Interface = (*notificationsContainer)(nil)
+
+// newNotificationsContainer returns a new notifications container for conf,
+// which must be valid.
+func newNotificationsContainer(conf *notificationsConfig) (c *notificationsContainer, err error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Debug("notify: getting hostname: %s", err)
+	}
+
+	c = &notificationsContainer{
+		done:       make(chan struct{}),
+		hostname:   hostname,
+		certExpiry: conf.CertificateExpiry.Duration,
+		mu:         &sync.RWMutex{},
+		lastSent:   map[notify.EventType]time.Time{},
+		stream:     notify.Empty{},
+		channels:   slices.Clone(conf.Channels),
+	}
+
+	c.notifier, err = c.newNotifier(c.channels)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newNotifier returns a new started notifier for the enabled channels, which
+// must be valid.  n is nil if there are none.
+func (c *notificationsContainer) newNotifier(
+	channels []*notificationChannel,
+) (n *notify.Notifier, err error) {
+	conf := &notify.Config{
+		HTTPClient: httpClient(),
+		Hostname:   c.hostname,
+	}
+
+	for _, ch := range channels {
+		if !ch.Enabled {
+			continue
+		}
+
+		var cc *notify.ChannelConfig
+		cc, err = ch.toInternal()
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %w", ch.Name, err)
+		}
+
+		conf.Channels = append(conf.Channels, cc)
+	}
+
+	if len(conf.Channels) == 0 {
+		return nil, nil
+	}
+
+	n, err = notify.New(conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	n.Start()
+
+	return n, nil
+}
+
+// Notify implements the [notify.Interface] interface for
+// *notificationsContainer.
+func (c *notificationsContainer) Notify(e *notify.Event) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.stream.Notify(e)
+
+	if c.notifier != nil {
+		c.notifier.Notify(e)
+	}
+}
+
+// notifyOnce sends the notification about the ongoing condition, unless a
+// notification of the same type has been sent within [repeatIvl].
+func (c *notificationsContainer) notifyOnce(e *notify.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if last, ok := c.lastSent[e.Type]; ok && e.Time.Sub(last) < repeatIvl {
+		return
+	}
+
+	c.lastSent[e.Type] = e.Time
+	c.stream.Notify(e)
+
+	if c.notifier != nil {
+		c.notifier.Notify(e)
+	}
+}
+
+// modify replaces the channels of c with the result of f called with a copy of
+// the current ones, validates them, and restarts the notifier.
+func (c *notificationsContainer) modify(
+	f func(channels []*notificationChannel) (res []*notificationChannel, err error),
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	channels, err := f(slices.Clone(c.channels))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = (&notificationsConfig{Channels: channels}).validate()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	n, err := c.newNotifier(channels)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if c.notifier != nil {
+		c.notifier.Close()
+	}
+
+	c.notifier, c.channels = n, channels
+
+	return nil
+}
+
+// indexOf returns the index of the channel with name in channels or -1 if
+// there is none.
+func indexOf(channels []*notificationChannel, name string) (i int) {
+	return slices.IndexFunc(channels, func(ch *notificationChannel) (ok bool) {
+		return ch.Name == name
+	})
+}
+
+// errCommandChannel is returned when a command channel is added or changed
+// through the HTTP API.
+const errCommandChannel errors.Error = "command channels can only be set in the configuration file"
+
+// add validates and adds ch to c.  ch must not be a command channel.
+func (c *notificationsContainer) add(ch *notificationChannel) (err error) {
+	if ch.Type == notify.ChannelCommand {
+		return errCommandChannel
+	}
+
+	return c.modify(func(channels []*notificationChannel) (res []*notificationChannel, err error) {
+		return append(channels, ch), nil
+	})
+}
+
+// update validates ch and replaces the channel with name with it.  The empty
+// secrets of ch are taken from the replaced channel, if it has the same type.
+// Neither of the channels must be a command channel.
+func (c *notificationsContainer) update(name string, ch *notificationChannel) (err error) {
+	if ch.Type == notify.ChannelCommand {
+		return errCommandChannel
+	}
+
+	return c.modify(func(channels []*notificationChannel) (res []*notificationChannel, err error) {
+		i := indexOf(channels, name)
+		if i == -1 {
+			return nil, fmt.Errorf("channel %q: %w", name, errNotFound)
+		} else if channels[i].Type == notify.ChannelCommand {
+			return nil, errCommandChannel
+		}
+
+		if prev := channels[i]; prev.Type == ch.Type {
+			ch.BotToken = aghalg.Coalesce(ch.BotToken, prev.BotToken)
+			ch.SMTPPassword = aghalg.Coalesce(ch.SMTPPassword, prev.SMTPPassword)
+		}
+
+		channels[i] = ch
+
+		return channels, nil
+	})
+}
+
+// remove removes the channel with name from c.
+func (c *notificationsContainer) remove(name string) (err error) {
+	return c.modify(func(channels []*notificationChannel) (res []*notificationChannel, err error) {
+		i := indexOf(channels, name)
+		if i == -1 {
+			return nil, fmt.Errorf("channel %q: %w", name, errNotFound)
+		}
+
+		return slices.Delete(channels, i, i+1), nil
+	})
+}
+
+// list returns the configured channels.
+func (c *notificationsContainer) list() (channels []*notificationChannel) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Clone(c.channels)
+}
+
+// forConfig returns the notifications configuration for the configuration
+// file.
+func (c *notificationsContainer) forConfig() (conf *notificationsConfig) {
+	return &notificationsConfig{
+		Channels:          c.list(),
+		CertificateExpiry: timeutil.Duration{Duration: c.certExpiry},
+	}
+}
+
+// start starts checking the expiration of the TLS certificate.
+func (c *notificationsContainer) start() {
+	go c.checkCertificate()
+}
+
+// checkCertificate periodically checks the expiration of the TLS certificate
+// until c is closed.  It's intended to be used as a goroutine.
+func (c *notificationsContainer) checkCertificate() {
+	defer log.OnPanic("notify: checking certificate")
+
+	ticker := time.NewTicker(certCheckIvl)
+	defer ticker.Stop()
+
+	for {
+		if tlsMgr := Context.tls; tlsMgr != nil {
+			c.checkNotAfter(tlsMgr.certNotAfter(), time.Now())
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			// Go on.
+		}
+	}
+}
+
+// checkNotAfter sends the notification about the certificate, which expires
+// at notAfter, if it's expiring at now.  notAfter is zero if there is no
+// certificate.
+func (c *notificationsContainer) checkNotAfter(notAfter, now time.Time) {
+	if notAfter.IsZero() {
+		return
+	}
+
+	left := notAfter.Sub(now)
+	if left > c.certExpiry {
+		return
+	}
+
+	msg := fmt.Sprintf("tls certificate expires on %s", notAfter.Format(time.RFC3339))
+	if left <= 0 {
+		msg = fmt.Sprintf("tls certificate expired on %s", notAfter.Format(time.RFC3339))
+	}
+
+	c.notifyOnce(&notify.Event{
+		Time:    now,
+		Type:    notify.EventCertificateExpiry,
+		Message: msg,
+		Details: map[string]string{
+			"not_after": notAfter.Format(time.RFC3339),
+		},
+	})
+}
+
+// close stops the checks and the notifier.
+func (c *notificationsContainer) close() {
+	close(c.done)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.notifier != nil {
+		c.notifier.Close()
+		c.notifier = nil
+	}
+}
+
+// notifier returns the notifier for the modules, which is never nil.
+func notifier() (n notify.Interface) {
+	if Context.notifications == nil {
+		return notify.Empty{}
+	}
+
+	return Context.notifications
+}
+
+// onFilterUpdateFailed sends the notification about the filter list with name
+// and u, which couldn't be updated.
+func onFilterUpdateFailed(name, u string, err error) {
+	notifier().Notify(&notify.Event{
+		Time:    time.Now(),
+		Type:    notify.EventFilterUpdateFailed,
+		Message: fmt.Sprintf("updating filter %q: %s", name, err),
+		Details: map[string]string{
+			"name":  name,
+			"url":   u,
+			"error": err.Error(),
+		},
+	})
+}
+
+// onDHCPPoolExhausted sends the notification about the exhausted pool of the
+// DHCP server.
+func onDHCPPoolExhausted() {
+	if Context.notifications == nil {
+		return
+	}
+
+	Context.notifications.notifyOnce(&notify.Event{
+		Time:    time.Now(),
+		Type:    notify.EventDHCPPoolExhausted,
+		Message: "dhcp server has no ip addresses left to lease",
+	})
+}
+
+// leaseEventTypes maps the types of the DHCP lease events to the types of the
+// notifications.
+var leaseEventTypes = map[dhcpd.LeaseEventType]notify.EventType{
+	dhcpd.LeaseEventCreated: notify.EventDHCPLeaseCreated,
+	dhcpd.LeaseEventRenewed: notify.EventDHCPLeaseRenewed,
+	dhcpd.LeaseEventExpired: notify.EventDHCPLeaseExpired,
+}
+
+// onDHCPLeaseEvent sends the notification about the change of a DHCP lease.
+func onDHCPLeaseEvent(e *dhcpd.LeaseEvent) {
+	typ, ok := leaseEventTypes[e.Type]
+	if !ok {
+		return
+	}
+
+	notifier().Notify(&notify.Event{
+		Time:    e.Time,
+		Type:    typ,
+		Message: fmt.Sprintf("dhcp lease %s: %s (%s) %s", e.Type, e.IP, e.HWAddr, e.Hostname),
+		Details: map[string]string{
+			"ip":       e.IP.String(),
+			"mac":      e.HWAddr,
+			"hostname": e.Hostname,
+			"expires":  e.Expiry.Format(time.RFC3339),
+		},
+	})
+}
+
+// onAnomaly sends the notification about the detected anomaly a.
+func onAnomaly(a *anomaly.Alert) {
+	details := map[string]string{
+		"anomaly": string(a.Type),
+	}
+
+	if a.Client != "" {
+		details["client"] = a.Client
+	}
+
+	if a.Domain != "" {
+		details["domain"] = a.Domain
+	}
+
+	notifier().Notify(&notify.Event{
+		Time:    a.Time,
+		Type:    notify.EventAnomaly,
+		Message: a.Message,
+		Details: details,
+	})
+}
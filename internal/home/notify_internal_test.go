@@ -0,0 +1,198 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestNotificationsContainer returns a new notifications container with the
+// channels for tests and registers its closing in t's cleanup.
+func newTestNotificationsContainer(
+	t *testing.T,
+	channels ...*notificationChannel,
+) (c *notificationsContainer) {
+	t.Helper()
+
+	conf := &notificationsConfig{
+		Channels:          channels,
+		CertificateExpiry: timeutil.Duration{Duration: defaultCertificateExpiry},
+	}
+	require.NoError(t, conf.validate())
+
+	c, err := newNotificationsContainer(conf)
+	require.NoError(t, err)
+	t.Cleanup(c.close)
+
+	return c
+}
+
+func TestNotificationsConfig_validate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		channels   []*notificationChannel
+	}{{
+		name:       "nil",
+		wantErrMsg: "channel at index 0: no value",
+		channels:   []*notificationChannel{nil},
+	}, {
+		name:       "no_name",
+		wantErrMsg: "channel at index 0: empty name",
+		channels: []*notificationChannel{{
+			Type: notify.ChannelSyslog,
+			URL:  "udp://192.0.2.1:514",
+		}},
+	}, {
+		name:       "bad_channel",
+		wantErrMsg: "channel at index 0: channel at index 0: email: to: no value",
+		channels: []*notificationChannel{{
+			Name:     "mail",
+			Type:     notify.ChannelEmail,
+			SMTPAddr: "smtp.example:587",
+			From:     "agh@example.org",
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `channel at index 1: duplicate name "log"`,
+		channels: []*notificationChannel{{
+			Name: "log",
+			Type: notify.ChannelSyslog,
+			URL:  "udp://192.0.2.1:514",
+		}, {
+			Name: "log",
+			Type: notify.ChannelSyslog,
+			URL:  "tcp://192.0.2.1:514",
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := (&notificationsConfig{Channels: tc.channels}).validate()
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestNotificationsContainer_crud(t *testing.T) {
+	c := newTestNotificationsContainer(t, &notificationChannel{
+		Name:     "bot",
+		Type:     notify.ChannelTelegram,
+		BotToken: "123:abc",
+		ChatID:   "-100",
+		Enabled:  true,
+	})
+
+	err := c.add(&notificationChannel{
+		Name:    "log",
+		Type:    notify.ChannelSyslog,
+		URL:     "udp://192.0.2.1:514",
+		Events:  []notify.EventType{notify.EventAnomaly},
+		Enabled: true,
+	})
+	require.NoError(t, err)
+
+	err = c.add(&notificationChannel{Name: "log", Type: notify.ChannelSyslog, URL: "udp://x:1"})
+	testutil.AssertErrorMsg(t, `channel at index 2: duplicate name "log"`, err)
+
+	// The empty token is kept.
+	err = c.update("bot", &notificationChannel{
+		Name:   "telegram",
+		Type:   notify.ChannelTelegram,
+		ChatID: "-200",
+	})
+	require.NoError(t, err)
+
+	err = c.update("bot", &notificationChannel{})
+	testutil.AssertErrorMsg(t, `channel "bot": not found`, err)
+
+	channels := c.list()
+	require.Len(t, channels, 2)
+
+	assert.Equal(t, "telegram", channels[0].Name)
+	assert.Equal(t, "123:abc", channels[0].BotToken)
+	assert.Equal(t, "-200", channels[0].ChatID)
+
+	t.Run("get", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/control/notifications", nil)
+		c.handleGetNotifications(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &notificationsListJSON{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+		require.Len(t, resp.Channels, 2)
+
+		assert.Empty(t, resp.Channels[0].BotToken)
+		assert.Equal(t, "-200", resp.Channels[0].ChatID)
+	})
+
+	require.NoError(t, c.remove("telegram"))
+	testutil.AssertErrorMsg(t, `channel "telegram": not found`, c.remove("telegram"))
+
+	conf := c.forConfig()
+	require.Len(t, conf.Channels, 1)
+
+	assert.Equal(t, "log", conf.Channels[0].Name)
+}
+
+func TestNotificationsContainer_checkNotAfter(t *testing.T) {
+	c := newTestNotificationsContainer(t, &notificationChannel{
+		Name:    "log",
+		Type:    notify.ChannelSyslog,
+		URL:     "udp://192.0.2.1:514",
+		Events:  []notify.EventType{notify.EventDHCPPoolExhausted},
+		Enabled: true,
+	})
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// No certificate.
+	c.checkNotAfter(time.Time{}, now)
+	assert.Empty(t, c.lastSent)
+
+	// Not expiring yet.
+	c.checkNotAfter(now.Add(30*timeutil.Day), now)
+	assert.Empty(t, c.lastSent)
+
+	c.checkNotAfter(now.Add(timeutil.Day), now)
+	assert.Equal(t, now, c.lastSent[notify.EventCertificateExpiry])
+
+	// Not repeated within a day.
+	later := now.Add(time.Hour)
+	c.checkNotAfter(now.Add(timeutil.Day), later)
+	assert.Equal(t, now, c.lastSent[notify.EventCertificateExpiry])
+}
+
+func TestNotificationsContainer_commandChannel(t *testing.T) {
+	c := newTestNotificationsContainer(t, &notificationChannel{
+		Name:    "script",
+		Type:    notify.ChannelCommand,
+		Command: []string{"/usr/local/bin/on-event"},
+		Enabled: true,
+	})
+
+	err := c.add(&notificationChannel{
+		Name:    "other",
+		Type:    notify.ChannelCommand,
+		Command: []string{"/bin/sh"},
+	})
+	assert.ErrorIs(t, err, errCommandChannel)
+
+	err = c.update("script", &notificationChannel{
+		Name: "script",
+		Type: notify.ChannelSyslog,
+		URL:  "udp://192.0.2.1:514",
+	})
+	assert.ErrorIs(t, err, errCommandChannel)
+
+	require.NoError(t, c.remove("script"))
+}
@@ -0,0 +1,117 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// notificationsListJSON is the JSON structure for the list of notification
+// channels.
+type notificationsListJSON struct {
+	Channels []*notificationChannel `json:"channels"`
+}
+
+// handleGetNotifications is the handler for the GET /control/notifications
+// HTTP API.  The secrets of the channels aren't exposed.
+func (c *notificationsContainer) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	resp := &notificationsListJSON{
+		Channels: []*notificationChannel{},
+	}
+
+	for _, ch := range c.list() {
+		resp.Channels = append(resp.Channels, ch.withoutSecrets())
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleAddNotification is the handler for the POST /control/notifications/add
+// HTTP API.
+func (c *notificationsContainer) handleAddNotification(w http.ResponseWriter, r *http.Request) {
+	ch := &notificationChannel{}
+	err := json.NewDecoder(r.Body).Decode(ch)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = c.add(ch)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "adding channel: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// notificationUpdateJSON is the JSON structure for the request to update a
+// notification channel.
+type notificationUpdateJSON struct {
+	Data *notificationChannel `json:"data"`
+	Name string               `json:"name"`
+}
+
+// handleUpdateNotification is the handler for the POST
+// /control/notifications/update HTTP API.  The empty secrets are kept
+// unchanged.
+func (c *notificationsContainer) handleUpdateNotification(w http.ResponseWriter, r *http.Request) {
+	req := &notificationUpdateJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	} else if req.Data == nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no data")
+
+		return
+	}
+
+	err = c.update(req.Name, req.Data)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "updating channel: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// notificationDeleteJSON is the JSON structure for the request to delete a
+// notification channel.
+type notificationDeleteJSON struct {
+	Name string `json:"name"`
+}
+
+// handleDeleteNotification is the handler for the POST
+// /control/notifications/delete HTTP API.
+func (c *notificationsContainer) handleDeleteNotification(w http.ResponseWriter, r *http.Request) {
+	req := &notificationDeleteJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = c.remove(req.Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "deleting channel: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// registerWebHandlers registers the HTTP handlers of the notifications.
+func (c *notificationsContainer) registerWebHandlers() {
+	httpRegister(http.MethodGet, "/control/notifications", c.handleGetNotifications)
+	httpRegister(http.MethodPost, "/control/notifications/add", c.handleAddNotification)
+	httpRegister(http.MethodPost, "/control/notifications/update", c.handleUpdateNotification)
+	httpRegister(http.MethodPost, "/control/notifications/delete", c.handleDeleteNotification)
+}
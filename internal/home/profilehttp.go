@@ -40,9 +40,10 @@ func (t *Theme) UnmarshalText(b []byte) (err error) {
 // profileJSON is an object for /control/profile and /control/profile/update
 // endpoints.
 type profileJSON struct {
-	Name     string `json:"name"`
-	Language string `json:"language"`
-	Theme    Theme  `json:"theme"`
+	Name     string   `json:"name"`
+	Role     userRole `json:"role,omitempty"`
+	Language string   `json:"language"`
+	Theme    Theme    `json:"theme"`
 }
 
 // handleGetProfile is the handler for GET /control/profile endpoint.
@@ -56,6 +57,7 @@ func handleGetProfile(w http.ResponseWriter, r *http.Request) {
 
 		resp = profileJSON{
 			Name:     u.Name,
+			Role:     u.role(),
 			Language: config.Language,
 			Theme:    config.Theme,
 		}
@@ -0,0 +1,72 @@
+package home
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// queryLogShipConfig is the configuration of shipping the query log entries to
+// a remote collector, such as a SIEM.
+type queryLogShipConfig struct {
+	// URL is the address of the remote collector.  See
+	// [querylog.ShipConfig.URL].
+	URL string `yaml:"url"`
+
+	// Format is the format of the shipped entries.
+	Format querylog.ShipFormat `yaml:"format"`
+
+	// BufferSize is the maximum number of the entries waiting to be shipped.
+	BufferSize int `yaml:"buffer_size"`
+
+	// Enabled defines if the query log entries are shipped to the collector.
+	Enabled bool `yaml:"enabled"`
+}
+
+// defaultQueryLogShipBufferSize is the default maximum number of the query log
+// entries waiting to be shipped.
+const defaultQueryLogShipBufferSize = 10_000
+
+// validate returns an error if the shipping configuration is invalid.
+func (c *queryLogShipConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	conf, err := c.toInternal()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	return conf.Validate()
+}
+
+// toInternal returns the shipping configuration for the query log.  It returns
+// nil if c is nil or disabled.
+func (c *queryLogShipConfig) toInternal() (conf *querylog.ShipConfig, err error) {
+	if c == nil || !c.Enabled {
+		return nil, nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("bad url: %w", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Debug("querylog: getting hostname: %s", err)
+	}
+
+	return &querylog.ShipConfig{
+		URL:        u,
+		RootCAs:    Context.tlsRoots,
+		Hostname:   hostname,
+		Format:     c.Format,
+		BufferSize: c.BufferSize,
+	}, nil
+}
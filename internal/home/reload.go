@@ -0,0 +1,108 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// reloadAPI is the path of the HTTP API reloading the DNS and filtering
+// settings from the configuration file.
+const reloadAPI = "/control/reload"
+
+// reloadJSON is the JSON structure for the response of the reload HTTP API.
+type reloadJSON struct {
+	// Restarted is true if the DNS listeners have been restarted, since their
+	// settings have changed.
+	Restarted bool `json:"restarted"`
+}
+
+// handleReload is the handler for the POST /control/reload HTTP API.  It
+// rereads the configuration file and applies its DNS and filtering settings
+// without restarting the DNS listeners, unless their settings have changed.
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	restarted, err := reloadConfigFile()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "reloading: %s", err)
+
+		return
+	}
+
+	log.Info("config: user %q reloaded the configuration", Context.auth.getCurrentUser(r).Name)
+
+	aghhttp.WriteJSONResponseOK(w, r, &reloadJSON{
+		Restarted: restarted,
+	})
+}
+
+// reloadConfigFile rereads the configuration file and applies its DNS and
+// filtering settings.  The filter lists with local file paths aren't reloaded,
+// see [filtering.DNSFilter.ApplySyncedConfig].
+func reloadConfigFile() (restarted bool, err error) {
+	if config.replaced.Load() {
+		return false, errors.Error("configuration file has been replaced, waiting for restart")
+	}
+
+	data, err := os.ReadFile(config.getConfigFilename())
+	if err != nil {
+		return false, fmt.Errorf("reading config file: %w", err)
+	}
+
+	conf, err := parseConfigData(data)
+	if err != nil {
+		return false, fmt.Errorf("parsing config file: %w", err)
+	}
+
+	func() {
+		config.Lock()
+		defer config.Unlock()
+
+		config.DNS = conf.DNS
+	}()
+
+	err = Context.filters.ApplySyncedConfig(&filtering.SyncedConfig{
+		BlockedServices:  conf.Filtering.BlockedServices,
+		Filters:          toSyncedFilters(conf.Filters),
+		WhitelistFilters: toSyncedFilters(conf.WhitelistFilters),
+		Rewrites:         conf.Filtering.Rewrites,
+		UserRules:        conf.UserRules,
+	})
+	if err != nil {
+		return false, fmt.Errorf("filtering: %w", err)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return reloadDNSServer()
+}
+
+// toSyncedFilters converts filters to their synced form skipping the lists
+// with local file paths.
+func toSyncedFilters(filters []filtering.FilterYAML) (synced []*filtering.SyncedFilter) {
+	synced = []*filtering.SyncedFilter{}
+	for _, flt := range filters {
+		if filepath.IsAbs(flt.URL) {
+			continue
+		}
+
+		synced = append(synced, &filtering.SyncedFilter{
+			Name:        flt.Name,
+			URL:         flt.URL,
+			Enabled:     flt.Enabled,
+			MonitorOnly: flt.MonitorOnly,
+		})
+	}
+
+	return synced
+}
+
+// registerReloadHandlers registers the HTTP handler reloading the
+// configuration.
+func registerReloadHandlers() {
+	httpRegister(http.MethodPost, reloadAPI, handleReload)
+}
@@ -0,0 +1,38 @@
+package home
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToSyncedFilters(t *testing.T) {
+	filters := []filtering.FilterYAML{{
+		Enabled: true,
+		URL:     "https://example.com/list.txt",
+		Name:    "Remote",
+	}, {
+		Enabled: true,
+		URL:     filepath.Join(t.TempDir(), "local.txt"),
+		Name:    "Local",
+	}, {
+		URL:         "https://example.org/list.txt",
+		Name:        "Monitored",
+		MonitorOnly: true,
+	}}
+
+	want := []*filtering.SyncedFilter{{
+		Name:    "Remote",
+		URL:     "https://example.com/list.txt",
+		Enabled: true,
+	}, {
+		Name:        "Monitored",
+		URL:         "https://example.org/list.txt",
+		MonitorOnly: true,
+	}}
+
+	assert.Equal(t, want, toSyncedFilters(filters))
+	assert.Empty(t, toSyncedFilters(nil))
+}
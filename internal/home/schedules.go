@@ -0,0 +1,363 @@
+package home
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"golang.org/x/exp/slices"
+)
+
+// serviceInUse returns true if the blocked service with id is blocked for any of
+// the persistent clients or by any of the filtering schedules.
+func serviceInUse(id string) (ok bool) {
+	return Context.clients.blocksService(id) || Context.schedules.blocksService(id)
+}
+
+// filteringSchedule is a set of filtering settings applied to the matching
+// clients during the scheduled time, for example blocking a service for the
+// children's devices on school nights.
+type filteringSchedule struct {
+	// Schedule is the weekly schedule, during which the settings are applied.
+	Schedule *schedule.Weekly `yaml:"schedule" json:"schedule"`
+
+	// SafeSearch, if not nil, is the safe search configuration used instead of
+	// the client's one during the schedule.
+	SafeSearch *filtering.SafeSearchConfig `yaml:"safe_search,omitempty" json:"safe_search,omitempty"`
+
+	// ParentalEnabled, if not nil, overrides the client's parental control
+	// setting during the schedule.
+	ParentalEnabled *bool `yaml:"parental_enabled,omitempty" json:"parental_enabled,omitempty"`
+
+	// safeSearch is the safe search built from SafeSearch.  It's nil unless
+	// SafeSearch is enabled.
+	safeSearch filtering.SafeSearch
+
+	// ids are the ClientIDs and the names of the persistent clients from
+	// Clients.
+	ids *stringutil.Set
+
+	// tags are the client tags from Tags.
+	tags *stringutil.Set
+
+	// Name is the unique name of the schedule.
+	Name string `yaml:"name" json:"name"`
+
+	// Clients are the ClientIDs, IP addresses, CIDR subnets, and names of the
+	// persistent clients, to which the schedule applies.
+	Clients []string `yaml:"clients" json:"clients"`
+
+	// Tags are the tags of the persistent clients, to which the schedule
+	// applies.
+	Tags []string `yaml:"tags" json:"tags"`
+
+	// BlockedServices are the IDs of the services additionally blocked during
+	// the schedule.
+	BlockedServices []string `yaml:"blocked_services" json:"blocked_services"`
+
+	// DisabledFilters are the IDs of the filter lists, which aren't applied
+	// during the schedule.
+	DisabledFilters []int64 `yaml:"disabled_filters" json:"disabled_filters"`
+
+	// subnets are the IP addresses and CIDR subnets from Clients.  The single
+	// IP addresses are kept as single-address prefixes.
+	subnets []netip.Prefix
+
+	// Enabled defines if the schedule is used.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+// init validates s and initializes its unexported fields.  ssCacheSize and
+// ssCacheTTL are used for the safe search cache.
+func (s *filteringSchedule) init(ssCacheSize uint, ssCacheTTL time.Duration) (err error) {
+	switch {
+	case s.Name == "":
+		return errors.Error("empty name")
+	case s.Schedule == nil:
+		return errors.Error("no schedule")
+	case len(s.Clients) == 0 && len(s.Tags) == 0:
+		return errors.Error("no clients or tags")
+	}
+
+	s.ids, s.subnets = stringutil.NewSet(), nil
+	for _, c := range s.Clients {
+		var pref netip.Prefix
+		pref, err = parseScheduleClient(c)
+		if err != nil {
+			return fmt.Errorf("client %q: %w", c, err)
+		} else if pref.IsValid() {
+			s.subnets = append(s.subnets, pref)
+		} else {
+			s.ids.Add(c)
+		}
+	}
+
+	allTags := stringutil.NewSet(clientTags...)
+	for _, t := range s.Tags {
+		if !allTags.Has(t) {
+			return fmt.Errorf("unknown tag %q", t)
+		}
+	}
+
+	s.tags = stringutil.NewSet(s.Tags...)
+
+	err = (&filtering.BlockedServices{IDs: s.BlockedServices}).Validate()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	slices.Sort(s.DisabledFilters)
+	s.DisabledFilters = slices.Compact(s.DisabledFilters)
+
+	s.safeSearch = nil
+	if s.SafeSearch != nil && s.SafeSearch.Enabled {
+		conf := *s.SafeSearch
+		conf.CustomResolver = safeSearchResolver{}
+
+		s.safeSearch, err = safesearch.NewDefault(
+			conf,
+			fmt.Sprintf("schedule %q", s.Name),
+			ssCacheSize,
+			ssCacheTTL,
+		)
+		if err != nil {
+			return fmt.Errorf("safe search: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseScheduleClient parses c as an IP address or a CIDR subnet.  pref is
+// invalid if c is neither, which means that c is a ClientID or a name.
+func parseScheduleClient(c string) (pref netip.Prefix, err error) {
+	if strings.Contains(c, "/") {
+		pref, err = netip.ParsePrefix(c)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return netip.Prefix{}, err
+		}
+
+		return pref.Masked(), nil
+	}
+
+	ip, err := netip.ParseAddr(c)
+	if err != nil {
+		return netip.Prefix{}, nil
+	}
+
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// matches returns true if s applies to the client with the settings setts and
+// clientID at now.
+func (s *filteringSchedule) matches(setts *filtering.Settings, clientID string, now time.Time) (ok bool) {
+	if !s.Enabled || !s.Schedule.Contains(now) {
+		return false
+	}
+
+	if (clientID != "" && s.ids.Has(clientID)) ||
+		(setts.ClientName != "" && s.ids.Has(setts.ClientName)) {
+		return true
+	}
+
+	for _, t := range setts.ClientTags {
+		if s.tags.Has(t) {
+			return true
+		}
+	}
+
+	ip := setts.ClientIP.Unmap()
+	for _, pref := range s.subnets {
+		if pref.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply applies the filtering settings of s to setts.
+func (s *filteringSchedule) apply(setts *filtering.Settings) {
+	if s.ParentalEnabled != nil {
+		setts.ParentalEnabled = *s.ParentalEnabled
+	}
+
+	if s.SafeSearch != nil {
+		setts.SafeSearchEnabled = s.SafeSearch.Enabled
+		setts.ClientSafeSearch = s.safeSearch
+	}
+
+	if len(s.BlockedServices) > 0 {
+		Context.filters.ApplyBlockedServicesList(setts, s.BlockedServices)
+	}
+
+	if len(s.DisabledFilters) > 0 {
+		setts.DisabledFilterLists = append(setts.DisabledFilterLists, s.DisabledFilters...)
+		slices.Sort(setts.DisabledFilterLists)
+		setts.DisabledFilterLists = slices.Compact(setts.DisabledFilterLists)
+	}
+}
+
+// schedulesContainer contains the filtering schedules.
+type schedulesContainer struct {
+	// mu protects list.
+	mu *sync.RWMutex
+
+	// list are the filtering schedules in the order of their application.
+	list []*filteringSchedule
+
+	// safeSearchCacheSize is the size of the safe search cache of each
+	// schedule.
+	safeSearchCacheSize uint
+
+	// safeSearchCacheTTL is the TTL of the safe search cache entries.
+	safeSearchCacheTTL time.Duration
+}
+
+// newSchedulesContainer returns a new schedules container with the schedules
+// from the configuration file.
+func newSchedulesContainer(
+	confs []*filteringSchedule,
+	filteringConf *filtering.Config,
+) (c *schedulesContainer, err error) {
+	c = &schedulesContainer{
+		mu:                  &sync.RWMutex{},
+		safeSearchCacheSize: filteringConf.SafeSearchCacheSize,
+		safeSearchCacheTTL:  time.Minute * time.Duration(filteringConf.CacheTime),
+	}
+
+	for i, s := range confs {
+		if s == nil {
+			return nil, fmt.Errorf("schedule at index %d: %w", i, errors.Error("no value"))
+		}
+
+		err = c.add(s)
+		if err != nil {
+			return nil, fmt.Errorf("schedule at index %d: %w", i, err)
+		}
+	}
+
+	return c, nil
+}
+
+// apply applies the settings of the active schedules, which match the client
+// with clientID, to setts.  The schedules are applied in their order, so the
+// later ones override the earlier ones.  c may be nil.
+func (c *schedulesContainer) apply(setts *filtering.Settings, clientID string, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, s := range c.list {
+		if s.matches(setts, clientID, now) {
+			s.apply(setts)
+		}
+	}
+}
+
+// indexLocked returns the index of the schedule with name or -1 if there is
+// none.  c.mu is expected to be locked.
+func (c *schedulesContainer) indexLocked(name string) (i int) {
+	return slices.IndexFunc(c.list, func(s *filteringSchedule) (ok bool) {
+		return s.Name == name
+	})
+}
+
+// add validates and adds s to c.
+func (c *schedulesContainer) add(s *filteringSchedule) (err error) {
+	err = s.init(c.safeSearchCacheSize, c.safeSearchCacheTTL)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.indexLocked(s.Name) != -1 {
+		return fmt.Errorf("schedule with name %q already exists", s.Name)
+	}
+
+	c.list = append(c.list, s)
+
+	return nil
+}
+
+// update validates s and replaces the schedule with name with it.
+func (c *schedulesContainer) update(name string, s *filteringSchedule) (err error) {
+	err = s.init(c.safeSearchCacheSize, c.safeSearchCacheTTL)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := c.indexLocked(name)
+	if i == -1 {
+		return fmt.Errorf("schedule %q: %w", name, errNotFound)
+	}
+
+	if s.Name != name && c.indexLocked(s.Name) != -1 {
+		return fmt.Errorf("schedule with name %q already exists", s.Name)
+	}
+
+	c.list[i] = s
+
+	return nil
+}
+
+// errNotFound is returned when there is no entity, such as a schedule or a
+// client, with the requested name.
+const errNotFound errors.Error = "not found"
+
+// remove removes the schedule with name from c.
+func (c *schedulesContainer) remove(name string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := c.indexLocked(name)
+	if i == -1 {
+		return fmt.Errorf("schedule %q: %w", name, errNotFound)
+	}
+
+	c.list = slices.Delete(c.list, i, i+1)
+
+	return nil
+}
+
+// blocksService returns true if the service with id is blocked by any of the
+// schedules.
+func (c *schedulesContainer) blocksService(id string) (ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, s := range c.list {
+		if slices.Contains(s.BlockedServices, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forConfig returns the schedules for the configuration file.
+func (c *schedulesContainer) forConfig() (confs []*filteringSchedule) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Clone(c.list)
+}
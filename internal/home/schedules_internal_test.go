@@ -0,0 +1,223 @@
+package home
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSchedulesContainer(t *testing.T) {
+	filtering.InitModule()
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		schedules  []*filteringSchedule
+	}{{
+		name:       "empty",
+		wantErrMsg: "",
+		schedules:  nil,
+	}, {
+		name:       "valid",
+		wantErrMsg: "",
+		schedules: []*filteringSchedule{{
+			Schedule:        schedule.FullWeekly(),
+			Name:            "school_nights",
+			Clients:         []string{"192.0.2.0/24", "kids-tablet"},
+			Tags:            []string{"user_child"},
+			BlockedServices: []string{"youtube"},
+			Enabled:         true,
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "schedule at index 0: no value",
+		schedules:  []*filteringSchedule{nil},
+	}, {
+		name:       "no_clients",
+		wantErrMsg: "schedule at index 0: no clients or tags",
+		schedules: []*filteringSchedule{{
+			Schedule: schedule.FullWeekly(),
+			Name:     "test",
+		}},
+	}, {
+		name: "bad_subnet",
+		wantErrMsg: `schedule at index 0: client "192.0.2.0/33": ` +
+			`netip.ParsePrefix("192.0.2.0/33"): prefix length out of range`,
+		schedules: []*filteringSchedule{{
+			Schedule: schedule.FullWeekly(),
+			Name:     "test",
+			Clients:  []string{"192.0.2.0/33"},
+		}},
+	}, {
+		name:       "bad_tag",
+		wantErrMsg: `schedule at index 0: unknown tag "user_bad"`,
+		schedules: []*filteringSchedule{{
+			Schedule: schedule.FullWeekly(),
+			Name:     "test",
+			Tags:     []string{"user_bad"},
+		}},
+	}, {
+		name:       "bad_service",
+		wantErrMsg: `schedule at index 0: unknown blocked-service "bad_service"`,
+		schedules: []*filteringSchedule{{
+			Schedule:        schedule.FullWeekly(),
+			Name:            "test",
+			Tags:            []string{"user_child"},
+			BlockedServices: []string{"bad_service"},
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `schedule at index 1: schedule with name "test" already exists`,
+		schedules: []*filteringSchedule{{
+			Schedule: schedule.FullWeekly(),
+			Name:     "test",
+			Tags:     []string{"user_child"},
+		}, {
+			Schedule: schedule.FullWeekly(),
+			Name:     "test",
+			Tags:     []string{"user_child"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newSchedulesContainer(tc.schedules, &filtering.Config{})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestApplyAdditionalFiltering_schedules(t *testing.T) {
+	filtering.InitModule()
+
+	var err error
+	Context.filters, err = filtering.New(&filtering.Config{
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+			IDs:      []string{"vk"},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	Context.clients.idIndex = map[string]*Client{
+		"kids-tablet": {
+			Name:            "kids-tablet",
+			Tags:            []string{"user_child"},
+			UseOwnSettings:  true,
+			ParentalEnabled: false,
+		},
+		"laptop": {
+			Name: "laptop",
+		},
+	}
+
+	parentalEnabled := true
+	Context.schedules, err = newSchedulesContainer([]*filteringSchedule{{
+		Schedule:        schedule.FullWeekly(),
+		ParentalEnabled: &parentalEnabled,
+		Name:            "always",
+		Tags:            []string{"user_child"},
+		BlockedServices: []string{"ok"},
+		DisabledFilters: []int64{3, 1, 3},
+		Enabled:         true,
+	}, {
+		Schedule:        schedule.EmptyWeekly(),
+		Name:            "never",
+		Clients:         []string{"laptop", testIPv4.String()},
+		BlockedServices: []string{"mail_ru"},
+		Enabled:         true,
+	}, {
+		Schedule:        schedule.FullWeekly(),
+		Name:            "disabled",
+		Clients:         []string{"laptop"},
+		BlockedServices: []string{"mail_ru"},
+		Enabled:         false,
+	}}, &filtering.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { Context.schedules = nil })
+
+	t.Run("active", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		applyAdditionalFiltering(testIPv4, "kids-tablet", setts)
+
+		assert.True(t, setts.ParentalEnabled)
+		assert.Equal(t, []int64{1, 3}, setts.DisabledFilterLists)
+
+		require.Len(t, setts.ServicesRules, 2)
+
+		assert.Equal(t, "vk", setts.ServicesRules[0].Name)
+		assert.Equal(t, "ok", setts.ServicesRules[1].Name)
+	})
+
+	t.Run("inactive", func(t *testing.T) {
+		setts := &filtering.Settings{}
+		applyAdditionalFiltering(testIPv4, "laptop", setts)
+
+		assert.Empty(t, setts.DisabledFilterLists)
+
+		require.Len(t, setts.ServicesRules, 1)
+
+		assert.Equal(t, "vk", setts.ServicesRules[0].Name)
+	})
+}
+
+func TestFilteringSchedule_matches(t *testing.T) {
+	s := &filteringSchedule{
+		Schedule: schedule.FullWeekly(),
+		Name:     "test",
+		Clients:  []string{"192.0.2.0/24", "2001:db8::1", "kids-tablet", "cid"},
+		Tags:     []string{"user_child"},
+		Enabled:  true,
+	}
+	require.NoError(t, s.init(0, 0))
+
+	now := time.Now()
+	testCases := []struct {
+		setts    *filtering.Settings
+		want     assert.BoolAssertionFunc
+		name     string
+		clientID string
+	}{{
+		setts:    &filtering.Settings{ClientIP: testIPv4},
+		want:     assert.False,
+		name:     "other",
+		clientID: "",
+	}, {
+		setts:    &filtering.Settings{ClientIP: netip.MustParseAddr("::ffff:192.0.2.42")},
+		want:     assert.True,
+		name:     "subnet",
+		clientID: "",
+	}, {
+		setts:    &filtering.Settings{ClientIP: netip.MustParseAddr("2001:db8::1")},
+		want:     assert.True,
+		name:     "ip",
+		clientID: "",
+	}, {
+		setts:    &filtering.Settings{ClientName: "kids-tablet"},
+		want:     assert.True,
+		name:     "name",
+		clientID: "",
+	}, {
+		setts:    &filtering.Settings{},
+		want:     assert.True,
+		name:     "client_id",
+		clientID: "cid",
+	}, {
+		setts:    &filtering.Settings{ClientTags: []string{"device_tablet", "user_child"}},
+		want:     assert.True,
+		name:     "tag",
+		clientID: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.want(t, s.matches(tc.setts, tc.clientID, now))
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// scheduleJSON is the JSON structure for a filtering schedule in the list of
+// schedules.
+type scheduleJSON struct {
+	*filteringSchedule
+
+	// Active is true if the schedule is enabled and its time has come.
+	Active bool `json:"active"`
+}
+
+// schedulesListJSON is the JSON structure for the list of filtering schedules.
+type schedulesListJSON struct {
+	Schedules []*scheduleJSON `json:"schedules"`
+}
+
+// handleGetSchedules is the handler for the GET /control/schedules HTTP API.
+func (c *schedulesContainer) handleGetSchedules(w http.ResponseWriter, r *http.Request) {
+	resp := &schedulesListJSON{
+		Schedules: []*scheduleJSON{},
+	}
+
+	func() {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+
+		now := time.Now()
+		for _, s := range c.list {
+			resp.Schedules = append(resp.Schedules, &scheduleJSON{
+				filteringSchedule: s,
+				Active:            s.Enabled && s.Schedule.Contains(now),
+			})
+		}
+	}()
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleAddSchedule is the handler for the POST /control/schedules/add HTTP
+// API.
+func (c *schedulesContainer) handleAddSchedule(w http.ResponseWriter, r *http.Request) {
+	s := &filteringSchedule{}
+	err := json.NewDecoder(r.Body).Decode(s)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = c.add(s)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "adding schedule: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// scheduleUpdateJSON is the JSON structure for the request to update a
+// filtering schedule.
+type scheduleUpdateJSON struct {
+	Data *filteringSchedule `json:"data"`
+	Name string             `json:"name"`
+}
+
+// handleUpdateSchedule is the handler for the POST /control/schedules/update
+// HTTP API.
+func (c *schedulesContainer) handleUpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	req := &scheduleUpdateJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	} else if req.Data == nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no data")
+
+		return
+	}
+
+	err = c.update(req.Name, req.Data)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "updating schedule: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// scheduleDeleteJSON is the JSON structure for the request to delete a
+// filtering schedule.
+type scheduleDeleteJSON struct {
+	Name string `json:"name"`
+}
+
+// handleDeleteSchedule is the handler for the POST /control/schedules/delete
+// HTTP API.
+func (c *schedulesContainer) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	req := &scheduleDeleteJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = c.remove(req.Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "deleting schedule: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// registerWebHandlers registers the HTTP handlers of the filtering schedules.
+func (c *schedulesContainer) registerWebHandlers() {
+	httpRegister(http.MethodGet, "/control/schedules", c.handleGetSchedules)
+	httpRegister(http.MethodPost, "/control/schedules/add", c.handleAddSchedule)
+	httpRegister(http.MethodPost, "/control/schedules/update", c.handleUpdateSchedule)
+	httpRegister(http.MethodPost, "/control/schedules/delete", c.handleDeleteSchedule)
+}
@@ -0,0 +1,442 @@
+package home
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/version"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// supportBundleAPI is the path of the HTTP API returning the diagnostics
+// bundle.  It's only available to the administrators, since the bundle contains
+// the logs and the settings.
+const supportBundleAPI = "/control/support/bundle"
+
+// supportBundleDir is the directory within the diagnostics bundle containing
+// all the files.
+const supportBundleDir = "adguardhome-support"
+
+// redactedValue is the value replacing the secrets in the diagnostics bundle.
+const redactedValue = "REDACTED"
+
+// supportSecretPaths are the paths to the values in the configuration file,
+// which are redacted in the diagnostics bundle in addition to [secretPaths].
+var supportSecretPaths = []secretPath{
+	{"users", "*", "password"},
+}
+
+// logCredentialsRe matches the credentials within the URLs in the log lines.
+var logCredentialsRe = regexp.MustCompile(`([a-z][a-z0-9+.-]*://)[^\s/@]+@`)
+
+// supportHandler is the handler of the diagnostics bundle HTTP API.
+type supportHandler struct {
+	// logs contains the recent log lines.
+	logs *logControl
+}
+
+// supportFile is a single file of the diagnostics bundle.
+type supportFile struct {
+	// collect returns the data of the file.
+	collect func() (data []byte, err error)
+
+	// name is the name of the file within the bundle.
+	name string
+}
+
+// handleBundle is the handler for the GET /control/support/bundle HTTP API.  It
+// returns the diagnostics bundle to attach to the bug reports.  If a file of
+// the bundle can't be collected, it contains the error instead.
+func (h *supportHandler) handleBundle(w http.ResponseWriter, r *http.Request) {
+	files := []*supportFile{{
+		collect: redactedConfig,
+		name:    "AdGuardHome.yaml",
+	}, {
+		collect: func() (data []byte, err error) { return redactLogs(h.logs.recentLogs()), nil },
+		name:    "logs.txt",
+	}, {
+		collect: supportRuntime,
+		name:    "runtime.json",
+	}, {
+		collect: supportUpstreams,
+		name:    "upstreams.json",
+	}, {
+		collect: supportNetwork,
+		name:    "network.json",
+	}, {
+		collect: supportFilters,
+		name:    "filters.json",
+	}}
+
+	now := time.Now()
+	contents := make([][]byte, len(files))
+	for i, f := range files {
+		data, err := f.collect()
+		if err != nil {
+			log.Debug("support: collecting %s: %s", f.name, err)
+
+			data = []byte(fmt.Sprintf("error: %s\n", err))
+		}
+
+		contents[i] = data
+	}
+
+	contDisp := fmt.Sprintf(
+		`attachment; filename="adguardhome-support-%s.tar.gz"`,
+		now.Format("20060102-150405"),
+	)
+
+	hdr := w.Header()
+	hdr.Set(httphdr.ContentType, hdrValApplicationGzip)
+	hdr.Set(httphdr.ContentDisposition, contDisp)
+
+	err := writeSupportBundle(w, files, contents, now)
+	if err != nil {
+		// The headers have already been sent, so just log the error.
+		log.Error("support: writing bundle: %s", err)
+	}
+}
+
+// writeSupportBundle writes the gzipped tar archive with files and their
+// contents to w.
+func writeSupportBundle(
+	w io.Writer,
+	files []*supportFile,
+	contents [][]byte,
+	now time.Time,
+) (err error) {
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+
+	for i, f := range files {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    path.Join(supportBundleDir, f.name),
+			Mode:    0o600,
+			Size:    int64(len(contents[i])),
+			ModTime: now,
+		})
+		if err == nil {
+			_, err = tw.Write(contents[i])
+		}
+
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", f.name, err)
+		}
+	}
+
+	err = tw.Close()
+
+	return errors.WithDeferred(err, gzw.Close())
+}
+
+// redactedConfig returns the configuration file with the secrets redacted.
+func redactedConfig() (data []byte, err error) {
+	config.RLock()
+	data, err = os.ReadFile(config.getConfigFilename())
+	config.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return redactConfigData(data)
+}
+
+// redactConfigData returns the configuration file data with the values at
+// [secretPaths] and [supportSecretPaths] and the credentials of the upstreams
+// redacted.
+func redactConfigData(data []byte) (redacted []byte, err error) {
+	doc := &yaml.Node{}
+	err = yaml.Unmarshal(data, doc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding config file: %w", err)
+	}
+
+	n := doc
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	redact := func(_ string) (res string, ok bool) { return redactedValue, true }
+	for _, p := range append(slices.Clone(secretPaths), supportSecretPaths...) {
+		replaceAt(n, p, redact)
+	}
+
+	for _, p := range upstreamSecretPaths {
+		replaceAt(n, p, redactUpstream)
+	}
+
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+
+	err = enc.Encode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encoding config file: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// redactUpstream returns the upstream server line with the credentials
+// redacted.  ok is false if there are no credentials in line.
+func redactUpstream(line string) (res string, ok bool) {
+	var domains string
+	if strings.HasPrefix(line, "[/") {
+		if i := strings.Index(line, "/]"); i >= 0 {
+			domains, line = line[:i+2], line[i+2:]
+		}
+	}
+
+	u, err := url.Parse(line)
+	if err != nil || u.User == nil {
+		return "", false
+	}
+
+	u.User = url.User(redactedValue)
+
+	return domains + u.String(), true
+}
+
+// redactLogs returns the log lines with the credentials within the URLs
+// redacted.
+func redactLogs(data []byte) (redacted []byte) {
+	return logCredentialsRe.ReplaceAll(data, []byte("${1}"+redactedValue+"@"))
+}
+
+// supportRuntimeJSON is the JSON structure for the runtime information within
+// the diagnostics bundle.
+type supportRuntimeJSON struct {
+	Version      string `json:"version"`
+	GoVersion    string `json:"go_version"`
+	OS           string `json:"os"`
+	Arch         string `json:"arch"`
+	GCPauseTotal string `json:"gc_pause_total"`
+	NumCPU       int    `json:"num_cpu"`
+	NumGoroutine int    `json:"num_goroutine"`
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"num_gc"`
+	DNSRunning   bool   `json:"dns_running"`
+}
+
+// supportRuntime returns the runtime information for the diagnostics bundle.
+func supportRuntime() (data []byte, err error) {
+	ms := &runtime.MemStats{}
+	runtime.ReadMemStats(ms)
+
+	// Don't wrap the error, because it's informative enough as is.
+	return json.MarshalIndent(&supportRuntimeJSON{
+		Version:      version.Full(),
+		GoVersion:    runtime.Version(),
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		GCPauseTotal: time.Duration(ms.PauseTotalNs).String(),
+		NumCPU:       runtime.NumCPU(),
+		NumGoroutine: runtime.NumGoroutine(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		Sys:          ms.Sys,
+		NumGC:        ms.NumGC,
+		DNSRunning:   isRunning(),
+	}, "", "  ")
+}
+
+// supportUpstreamJSON is the JSON structure for the result of checking a
+// single upstream server within the diagnostics bundle.
+type supportUpstreamJSON struct {
+	// Error is the error of the check, if any.
+	Error string `json:"error,omitempty"`
+
+	// Upstream is the address of the upstream with the credentials redacted.
+	Upstream string `json:"upstream"`
+
+	// OK is true if the upstream is usable.
+	OK bool `json:"ok"`
+}
+
+// supportUpstreams returns the results of checking the configured upstream
+// servers for the diagnostics bundle.
+func supportUpstreams() (data []byte, err error) {
+	if Context.dnsServer == nil {
+		return nil, errors.Error("dns server is not configured")
+	}
+
+	config.RLock()
+	dns := config.DNS
+	config.RUnlock()
+
+	upsErrs, err := testUpstreams(&dns)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	hosts := maps.Keys(upsErrs)
+	slices.Sort(hosts)
+
+	res := make([]*supportUpstreamJSON, 0, len(hosts))
+	for _, host := range hosts {
+		u := &supportUpstreamJSON{
+			Upstream: host,
+			OK:       upsErrs[host] == nil,
+		}
+
+		if redacted, ok := redactUpstream(host); ok {
+			u.Upstream = redacted
+		}
+
+		if !u.OK {
+			u.Error = upsErrs[host].Error()
+		}
+
+		res = append(res, u)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return json.MarshalIndent(res, "", "  ")
+}
+
+// supportNetworkJSON is the JSON structure for the network information within
+// the diagnostics bundle.
+type supportNetworkJSON struct {
+	// Gateways are the default gateways by the interface names.
+	Gateways map[string]string `json:"gateways"`
+
+	// Interfaces are the network interfaces.
+	Interfaces []*aghnet.NetInterface `json:"interfaces"`
+}
+
+// supportNetwork returns the network information for the diagnostics bundle.
+func supportNetwork() (data []byte, err error) {
+	ifaces, err := aghnet.GetValidNetInterfacesForWeb()
+	if err != nil {
+		return nil, fmt.Errorf("getting interfaces: %w", err)
+	}
+
+	gateways := map[string]string{}
+	for _, iface := range ifaces {
+		if gw := aghnet.GatewayIP(iface.Name); gw.IsValid() {
+			gateways[iface.Name] = gw.String()
+		}
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return json.MarshalIndent(&supportNetworkJSON{
+		Gateways:   gateways,
+		Interfaces: ifaces,
+	}, "", "  ")
+}
+
+// supportFilterJSON is the JSON structure for the status of a filter list
+// within the diagnostics bundle.
+type supportFilterJSON struct {
+	// LastUpdated is the time of the last update of the list, if any.
+	LastUpdated *time.Time `json:"last_updated,omitempty"`
+
+	// Name is the name of the list.
+	Name string `json:"name"`
+
+	// URL is the URL or the path of the list with the credentials and the
+	// query redacted.
+	URL string `json:"url"`
+
+	// ID is the ID of the list.
+	ID int64 `json:"id"`
+
+	// RulesCount is the number of the loaded rules.
+	RulesCount int `json:"rules_count"`
+
+	// Enabled defines if the list is enabled.
+	Enabled bool `json:"enabled"`
+
+	// Allowlist is true if the list is an allowlist.
+	Allowlist bool `json:"allowlist"`
+
+	// MonitorOnly defines if the list is monitor-only.
+	MonitorOnly bool `json:"monitor_only"`
+}
+
+// supportFilters returns the status of the filter lists for the diagnostics
+// bundle.
+func supportFilters() (data []byte, err error) {
+	if Context.filters == nil {
+		return nil, errors.Error("filtering is not configured")
+	}
+
+	conf := &filtering.Config{}
+	Context.filters.WriteDiskConfig(conf)
+
+	res := []*supportFilterJSON{}
+	for i, filters := range [][]filtering.FilterYAML{conf.Filters, conf.WhitelistFilters} {
+		for _, flt := range filters {
+			f := &supportFilterJSON{
+				Name:        flt.Name,
+				URL:         redactFilterURL(flt.URL),
+				ID:          flt.ID,
+				RulesCount:  flt.RulesCount,
+				Enabled:     flt.Enabled,
+				Allowlist:   i == 1,
+				MonitorOnly: flt.MonitorOnly,
+			}
+
+			if !flt.LastUpdated.IsZero() {
+				f.LastUpdated = &flt.LastUpdated
+			}
+
+			res = append(res, f)
+		}
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return json.MarshalIndent(res, "", "  ")
+}
+
+// redactFilterURL returns the URL of the filter list with the credentials and
+// the query, which may contain the access tokens, redacted.
+func redactFilterURL(s string) (redacted string) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+
+	if u.User != nil {
+		u.User = url.User(redactedValue)
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery = redactedValue
+	}
+
+	return u.String()
+}
+
+// registerSupportHandlers registers the HTTP handler of the diagnostics bundle
+// with the recent log lines from logs.
+func registerSupportHandlers(logs *logControl) {
+	h := &supportHandler{
+		logs: logs,
+	}
+
+	httpRegister(http.MethodGet, supportBundleAPI, h.handleBundle)
+}
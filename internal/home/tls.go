@@ -18,6 +18,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/acme"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
@@ -36,6 +37,14 @@ type tlsManager struct {
 	// certLastMod is the last modification time of the certificate file.
 	certLastMod time.Time
 
+	// acme manages the certificate automatically.  It's nil if the ACME is
+	// disabled.
+	acme *acme.Manager
+
+	// dnsCryptDone stops the rotation of the DNSCrypt certificates.  It's nil
+	// if the rotation isn't started.
+	dnsCryptDone chan struct{}
+
 	confLock sync.Mutex
 	conf     tlsConfigSettings
 }
@@ -49,7 +58,20 @@ func newTLSManager(conf tlsConfigSettings) (m *tlsManager, err error) {
 		conf:   conf,
 	}
 
+	err = m.initACME()
+	if err != nil {
+		return m, err
+	}
+
 	if m.conf.Enabled {
+		if m.acme != nil && !m.acmeCertificateExists() {
+			// Don't disable the encryption, since the certificate is going to
+			// be obtained after the start.
+			m.status.WarningValidation = "waiting for the acme certificate"
+
+			return m, nil
+		}
+
 		err = m.load()
 		if err != nil {
 			m.conf.Enabled = false
@@ -80,21 +102,17 @@ func (m *tlsManager) WriteDiskConfig(conf *tlsConfigSettings) {
 	m.confLock.Unlock()
 }
 
-// setCertFileTime sets t.certLastMod from the certificate.  If there are
+// setCertFileTime sets t.certLastMod from the certificate files.  If there are
 // errors, setCertFileTime logs them.
 func (m *tlsManager) setCertFileTime() {
-	if len(m.conf.CertificatePath) == 0 {
-		return
-	}
-
-	fi, err := os.Stat(m.conf.CertificatePath)
+	modTime, err := certFilesModTime(&m.conf)
 	if err != nil {
-		log.Error("tls: looking up certificate path: %s", err)
+		log.Error("tls: %s", err)
 
 		return
 	}
 
-	m.certLastMod = fi.ModTime().UTC()
+	m.certLastMod = modTime
 }
 
 // start updates the configuration of t and starts it.
@@ -109,6 +127,39 @@ func (m *tlsManager) start() {
 	// with timeout on its own and shuts down the server, which handles current
 	// request.
 	Context.web.tlsConfigChanged(context.Background(), tlsConf)
+
+	if m.acme != nil {
+		// The HTTP-01 challenge requests are sent before the authentication
+		// and the HTTPS redirect, since the certificate authority can't do
+		// either.
+		Context.mux.Handle(acme.HTTP01PathPrefix, m.acme)
+		m.acme.Start()
+	}
+
+	m.startDNSCryptRotation()
+}
+
+// close stops the automatic management of the certificate, if any, and the
+// rotation of the DNSCrypt certificates.
+func (m *tlsManager) close() {
+	if m.acme != nil {
+		m.acme.Close()
+	}
+
+	m.stopDNSCryptRotation()
+}
+
+// certNotAfter returns the expiration time of the current certificate.  It's
+// zero if the encryption is disabled.
+func (m *tlsManager) certNotAfter() (notAfter time.Time) {
+	m.confLock.Lock()
+	defer m.confLock.Unlock()
+
+	if !m.conf.Enabled {
+		return time.Time{}
+	}
+
+	return m.status.NotAfter
 }
 
 // reload updates the configuration and restarts t.
@@ -117,18 +168,18 @@ func (m *tlsManager) reload() {
 	tlsConf := m.conf
 	m.confLock.Unlock()
 
-	if !tlsConf.Enabled || len(tlsConf.CertificatePath) == 0 {
+	if !tlsConf.Enabled || (len(tlsConf.CertificatePath) == 0 && len(tlsConf.SNICertificates) == 0) {
 		return
 	}
 
-	fi, err := os.Stat(tlsConf.CertificatePath)
+	modTime, err := certFilesModTime(&tlsConf)
 	if err != nil {
 		log.Error("tls: %s", err)
 
 		return
 	}
 
-	if fi.ModTime().UTC().Equal(m.certLastMod) {
+	if modTime.Equal(m.certLastMod) {
 		log.Debug("tls: certificate file isn't modified")
 
 		return
@@ -145,14 +196,37 @@ func (m *tlsManager) reload() {
 		return
 	}
 
-	m.certLastMod = fi.ModTime().UTC()
-
-	_ = reconfigureDNSServer()
+	m.certLastMod = modTime
 
 	m.confLock.Lock()
 	tlsConf = m.conf
 	m.confLock.Unlock()
 
+	m.applyCertificate(tlsConf)
+}
+
+// applyCertificate makes the DNS and the web servers use the certificate from
+// tlsConf.  If only the main certificate has changed, the DNS server keeps the
+// established encrypted connections.
+func (m *tlsManager) applyCertificate(tlsConf tlsConfigSettings) {
+	var err error = errors.Error("dns server is not initialized")
+	if len(tlsConf.AdditionalCertificates) > 0 {
+		// The DNS server only updates the main certificate in place, so
+		// reconfigure it to update the additional ones as well.
+		err = errors.Error("sni certificates are used")
+	} else if Context.dnsServer != nil {
+		err = Context.dnsServer.UpdateCertificate(
+			tlsConf.CertificateChainData,
+			tlsConf.PrivateKeyData,
+		)
+	}
+
+	if err != nil {
+		log.Debug("tls: %s; reconfiguring dns server", err)
+
+		_ = reconfigureDNSServer()
+	}
+
 	// The background context is used because the TLSConfigChanged wraps context
 	// with timeout on its own and shuts down the server, which handles current
 	// request.
@@ -162,6 +236,32 @@ func (m *tlsManager) reload() {
 // loadTLSConf loads and validates the TLS configuration.  The returned error is
 // also set in status.WarningValidation.
 func loadTLSConf(tlsConf *tlsConfigSettings, status *tlsConfigStatus) (err error) {
+	err = loadMainCertificate(tlsConf, status)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = loadSNICertificates(tlsConf, status)
+	if err == nil {
+		err = loadClientAuth(tlsConf)
+	}
+
+	if err == nil {
+		err = loadECH(tlsConf)
+	}
+
+	if err != nil {
+		status.WarningValidation = err.Error()
+	}
+
+	return err
+}
+
+// loadMainCertificate loads and validates the main certificate and private key
+// of the TLS configuration.  The returned error is also set in
+// status.WarningValidation.
+func loadMainCertificate(tlsConf *tlsConfigSettings, status *tlsConfigStatus) (err error) {
 	defer func() {
 		if err != nil {
 			status.WarningValidation = err.Error()
@@ -190,10 +290,44 @@ func loadTLSConf(tlsConf *tlsConfigSettings, status *tlsConfigStatus) (err error
 		tlsConf.PrivateKeyData,
 		tlsConf.ServerName,
 	)
+	if signer := tlsConf.PrivateKeySigner; signer != nil && status.ValidCert {
+		err = errors.Join(err, validateSigner(status, tlsConf.CertificateChainData, signer))
+	}
 
 	return errors.Annotate(err, "validating certificate pair: %w")
 }
 
+// validateSigner checks that the private key kept by the external signer
+// matches the certificate by signing a test message.
+func validateSigner(
+	status *tlsConfigStatus,
+	certChain []byte,
+	signer *aghtls.ExternalSignerConfig,
+) (err error) {
+	cert, err := aghtls.KeyPair(certChain, nil, signer)
+	if err != nil {
+		return fmt.Errorf("external signer: %w", err)
+	}
+
+	err = aghtls.VerifySigner(&cert)
+	if err != nil {
+		return fmt.Errorf("external signer: %w", err)
+	}
+
+	switch cert.Leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		status.KeyType = keyTypeRSA
+	case *ecdsa.PublicKey:
+		status.KeyType = keyTypeECDSA
+	case ed25519.PublicKey:
+		status.KeyType = keyTypeED25519
+	}
+
+	status.ValidKey, status.ValidPair = true, true
+
+	return nil
+}
+
 // loadCertificateChainData loads PEM-encoded certificates chain data to the
 // TLS configuration.
 func loadCertificateChainData(tlsConf *tlsConfigSettings, status *tlsConfigStatus) (err error) {
@@ -219,6 +353,10 @@ func loadCertificateChainData(tlsConf *tlsConfigSettings, status *tlsConfigStatu
 // loadPrivateKeyData loads PEM-encoded private key data to the TLS
 // configuration.
 func loadPrivateKeyData(tlsConf *tlsConfigSettings, status *tlsConfigStatus) (err error) {
+	if tlsConf.PrivateKeySigner != nil && (tlsConf.PrivateKey != "" || tlsConf.PrivateKeyPath != "") {
+		return errors.Error("private key and external signer can't be set together")
+	}
+
 	tlsConf.PrivateKeyData = []byte(tlsConf.PrivateKey)
 	if tlsConf.PrivateKeyPath != "" {
 		if tlsConf.PrivateKey != "" {
@@ -275,6 +413,10 @@ type tlsConfigStatus struct {
 	// ValidPair is true if both certificate and private key are correct for
 	// each other.
 	ValidPair bool `json:"valid_pair"`
+
+	// SNICertificates are the statuses of the additional certificates, which
+	// are selected by the SNI of the clients.
+	SNICertificates []*tlsSNICertificateStatus `json:"sni_certificates,omitempty"`
 }
 
 // tlsConfig is the TLS configuration and status response.
@@ -318,6 +460,17 @@ func (m *tlsManager) handleTLSValidate(w http.ResponseWriter, r *http.Request) {
 		setts.PrivateKey = m.conf.PrivateKey
 	}
 
+	// The external signer, the HTTP/3 port, the ACME, the SNI certificates,
+	// the client authentication, and the ECH can only be set in the
+	// configuration file.
+	setts.PrivateKeySigner = m.conf.PrivateKeySigner
+	setts.PortHTTP3 = m.conf.PortHTTP3
+	setts.ACME = m.conf.ACME
+	setts.SNICertificates = m.conf.SNICertificates
+	setts.ClientAuth = m.conf.ClientAuth
+	setts.ECH = m.conf.ECH
+	m.useACMECertificate(&setts.tlsConfigSettings)
+
 	if setts.Enabled {
 		err = validatePorts(
 			tcpPort(config.HTTPConfig.Address.Port()),
@@ -326,6 +479,7 @@ func (m *tlsManager) handleTLSValidate(w http.ResponseWriter, r *http.Request) {
 			tcpPort(setts.PortDNSCrypt),
 			udpPort(config.DNS.Port),
 			udpPort(setts.PortDNSOverQUIC),
+			udpPort(setts.http3Port(config.DNS.ServeHTTP3)),
 		)
 		if err != nil {
 			aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
@@ -368,7 +522,14 @@ func (m *tlsManager) setConfig(newConf tlsConfigSettings, status *tlsConfigStatu
 	// TODO(a.garipov): Define a custom comparer for dnsforward.TLSConfig.
 	newConf.DNSCryptConfigFile = m.conf.DNSCryptConfigFile
 	newConf.PortDNSCrypt = m.conf.PortDNSCrypt
-	if !cmp.Equal(m.conf, newConf, cmp.AllowUnexported(dnsforward.TLSConfig{})) {
+	newConf.DNSCryptResolvers = m.conf.DNSCryptResolvers
+	newConf.DNSCryptRotationInterval = m.conf.DNSCryptRotationInterval
+	if !cmp.Equal(
+		m.conf,
+		newConf,
+		cmp.AllowUnexported(dnsforward.TLSConfig{}),
+		cmp.Comparer((*x509.CertPool).Equal),
+	) {
 		log.Info("tls config has changed, restarting https server")
 		restartHTTPS = true
 	} else {
@@ -382,12 +543,18 @@ func (m *tlsManager) setConfig(newConf tlsConfigSettings, status *tlsConfigStatu
 	m.conf.PortHTTPS = newConf.PortHTTPS
 	m.conf.PortDNSOverTLS = newConf.PortDNSOverTLS
 	m.conf.PortDNSOverQUIC = newConf.PortDNSOverQUIC
+	m.conf.PortHTTP3 = newConf.PortHTTP3
 	m.conf.CertificateChain = newConf.CertificateChain
 	m.conf.CertificatePath = newConf.CertificatePath
 	m.conf.CertificateChainData = newConf.CertificateChainData
 	m.conf.PrivateKey = newConf.PrivateKey
 	m.conf.PrivateKeyPath = newConf.PrivateKeyPath
 	m.conf.PrivateKeyData = newConf.PrivateKeyData
+	m.conf.AdditionalCertificates = newConf.AdditionalCertificates
+	m.conf.ClientCAs = newConf.ClientCAs
+	m.conf.ClientCertField = newConf.ClientCertField
+	m.conf.RequireClientCert = newConf.RequireClientCert
+	m.conf.ECHKeys = newConf.ECHKeys
 	m.status = status
 
 	return restartHTTPS
@@ -405,6 +572,17 @@ func (m *tlsManager) handleTLSConfigure(w http.ResponseWriter, r *http.Request)
 		req.PrivateKey = m.conf.PrivateKey
 	}
 
+	// The external signer, the HTTP/3 port, the ACME, the SNI certificates,
+	// the client authentication, and the ECH can only be set in the
+	// configuration file.
+	req.PrivateKeySigner = m.conf.PrivateKeySigner
+	req.PortHTTP3 = m.conf.PortHTTP3
+	req.ACME = m.conf.ACME
+	req.SNICertificates = m.conf.SNICertificates
+	req.ClientAuth = m.conf.ClientAuth
+	req.ECH = m.conf.ECH
+	m.useACMECertificate(&req.tlsConfigSettings)
+
 	if req.Enabled {
 		err = validatePorts(
 			tcpPort(config.HTTPConfig.Address.Port()),
@@ -413,6 +591,7 @@ func (m *tlsManager) handleTLSConfigure(w http.ResponseWriter, r *http.Request)
 			tcpPort(req.PortDNSCrypt),
 			udpPort(config.DNS.Port),
 			udpPort(req.PortDNSOverQUIC),
+			udpPort(req.http3Port(config.DNS.ServeHTTP3)),
 		)
 		if err != nil {
 			aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
@@ -483,7 +662,7 @@ func (m *tlsManager) handleTLSConfigure(w http.ResponseWriter, r *http.Request)
 // DNS protocols.
 func validatePorts(
 	bindPort, dohPort, dotPort, dnscryptTCPPort tcpPort,
-	dnsPort, doqPort udpPort,
+	dnsPort, doqPort, doh3Port udpPort,
 ) (err error) {
 	tcpPorts := aghalg.UniqChecker[tcpPort]{}
 	addPorts(
@@ -500,7 +679,7 @@ func validatePorts(
 	}
 
 	udpPorts := aghalg.UniqChecker[udpPort]{}
-	addPorts(udpPorts, udpPort(dnsPort), udpPort(doqPort))
+	addPorts(udpPorts, udpPort(dnsPort), udpPort(doqPort), udpPort(doh3Port))
 
 	err = udpPorts.Validate()
 	if err != nil {
@@ -778,4 +957,5 @@ func (m *tlsManager) registerWebHandlers() {
 	httpRegister(http.MethodGet, "/control/tls/status", m.handleTLSStatus)
 	httpRegister(http.MethodPost, "/control/tls/configure", m.handleTLSConfigure)
 	httpRegister(http.MethodPost, "/control/tls/validate", m.handleTLSValidate)
+	httpRegister(http.MethodGet, "/control/dnscrypt/stamps", m.handleDNSCryptStamps)
 }
@@ -1,11 +1,14 @@
 package home
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testCertChainData = []byte(`-----BEGIN CERTIFICATE-----
@@ -75,3 +78,104 @@ func TestValidateCertificates(t *testing.T) {
 		assert.True(t, status.ValidPair)
 	})
 }
+
+func TestLoadSNICertificates(t *testing.T) {
+	dir := t.TempDir()
+
+	certPath := filepath.Join(dir, "cert.pem")
+	err := os.WriteFile(certPath, testCertChainData, 0o600)
+	require.NoError(t, err)
+
+	keyPath := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(keyPath, testPrivateKeyData, 0o600)
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		tlsConf := &tlsConfigSettings{
+			SNICertificates: []*tlsSNICertificate{{
+				ServerName:      "dns.other.example",
+				CertificatePath: certPath,
+				PrivateKeyPath:  keyPath,
+			}},
+		}
+
+		status := &tlsConfigStatus{}
+		err = loadSNICertificates(tlsConf, status)
+		require.NoError(t, err)
+
+		require.Len(t, tlsConf.AdditionalCertificates, 1)
+
+		cert := tlsConf.AdditionalCertificates[0]
+		assert.Equal(t, "dns.other.example", cert.ServerName)
+		assert.Equal(t, testCertChainData, cert.CertificateChainData)
+		assert.Equal(t, testPrivateKeyData, cert.PrivateKeyData)
+
+		require.Len(t, status.SNICertificates, 1)
+
+		certStatus := status.SNICertificates[0]
+		assert.Equal(t, "dns.other.example", certStatus.ServerName)
+		assert.True(t, certStatus.ValidPair)
+		assert.False(t, certStatus.ValidChain)
+		assert.NotEmpty(t, certStatus.WarningValidation)
+		assert.Equal(t, time.Date(2046, 7, 14, 9, 24, 23, 0, time.UTC), certStatus.NotAfter)
+	})
+
+	t.Run("bad_key", func(t *testing.T) {
+		tlsConf := &tlsConfigSettings{
+			SNICertificates: []*tlsSNICertificate{{
+				CertificatePath: certPath,
+				PrivateKeyPath:  certPath,
+			}},
+		}
+
+		status := &tlsConfigStatus{}
+		err = loadSNICertificates(tlsConf, status)
+		testutil.AssertErrorMsg(
+			t,
+			"sni certificate at index 0: validating certificate pair: no valid keys were found",
+			err,
+		)
+
+		require.Len(t, status.SNICertificates, 1)
+
+		assert.Equal(
+			t,
+			"validating certificate pair: no valid keys were found",
+			status.SNICertificates[0].WarningValidation,
+		)
+	})
+}
+
+func TestReadOrCreateECHKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", echKeyFilename)
+
+	created, err := readOrCreateECHKey(path, "public.example")
+	require.NoError(t, err)
+
+	assert.Equal(t, "public.example", created.PublicName())
+	assert.FileExists(t, path)
+
+	t.Run("existing", func(t *testing.T) {
+		key, rErr := readOrCreateECHKey(path, "public.example")
+		require.NoError(t, rErr)
+
+		assert.Equal(t, created, key)
+	})
+
+	t.Run("other_public_name", func(t *testing.T) {
+		key, rErr := readOrCreateECHKey(path, "other.example")
+		require.NoError(t, rErr)
+
+		assert.Equal(t, "other.example", key.PublicName())
+		assert.NotEqual(t, created.PrivateKey, key.PrivateKey)
+	})
+
+	t.Run("bad_file", func(t *testing.T) {
+		badPath := filepath.Join(t.TempDir(), echKeyFilename)
+		err = os.WriteFile(badPath, []byte("bad"), 0o600)
+		require.NoError(t, err)
+
+		_, err = readOrCreateECHKey(badPath, "public.example")
+		testutil.AssertErrorMsg(t, `parsing "`+badPath+`": no private key`, err)
+	})
+}
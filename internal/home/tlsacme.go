@@ -0,0 +1,244 @@
+package home
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/acme"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// acmeConfig is the configuration of the automatic management of the TLS
+// certificate using an ACME certificate authority, such as Let's Encrypt.
+type acmeConfig struct {
+	// DNSProvider is the provider of the TXT records for the DNS-01
+	// challenges.  It must not be nil if Challenge is [acme.ChallengeDNS01].
+	DNSProvider *acmeDNSProviderConfig `yaml:"dns_provider"`
+
+	// DirectoryURL is the URL of the directory of the certificate authority.
+	// If empty, Let's Encrypt is used.
+	DirectoryURL string `yaml:"directory_url"`
+
+	// Email is the contact address of the ACME account.
+	Email string `yaml:"email"`
+
+	// Challenge is the type of the challenge, either "http-01" or "dns-01".
+	// The HTTP-01 challenge requires the web interface to be reachable on
+	// port 80.
+	Challenge acme.Challenge `yaml:"challenge"`
+
+	// Domains are the domain names of the certificate.
+	Domains []string `yaml:"domains"`
+
+	// RenewBefore is the duration before the expiration of the certificate,
+	// within which it's renewed.
+	RenewBefore timeutil.Duration `yaml:"renew_before"`
+
+	// PropagationDelay is the duration to wait after provisioning the TXT
+	// records for the DNS-01 challenges.
+	PropagationDelay timeutil.Duration `yaml:"propagation_delay"`
+
+	// Enabled defines if the certificate is managed automatically.
+	Enabled bool `yaml:"enabled"`
+}
+
+// Types of the DNS-01 challenge providers.
+const (
+	// acmeDNSProviderLocal means that the TXT records are served by the DNS
+	// server of AdGuard Home itself, to which the zone of the domains is
+	// delegated.
+	acmeDNSProviderLocal = "local"
+
+	// acmeDNSProviderExec means that the TXT records are managed by an
+	// external command.  See [acme.ExecProvider].
+	acmeDNSProviderExec = "exec"
+)
+
+// acmeDNSProviderConfig is the configuration of the provider of the TXT records
+// for the DNS-01 challenges.
+type acmeDNSProviderConfig struct {
+	// Type is the type of the provider, either "local" or "exec".
+	Type string `yaml:"type"`
+
+	// Command is the command to run for the "exec" provider.
+	Command []string `yaml:"command"`
+}
+
+// validate returns an error if the ACME configuration is invalid.
+func (c *acmeConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	err = c.DNSProvider.validate()
+	if err != nil {
+		return fmt.Errorf("dns_provider: %w", err)
+	}
+
+	// The directory and the callback are only checked for presence, so use
+	// placeholders to validate the rest of the configuration.
+	_, err = acme.New(c.toInternal(os.TempDir(), func(_, _ []byte) {}))
+
+	// Don't wrap the error, because it's informative enough as is.
+	return err
+}
+
+// validateTLSACME returns an error if the ACME configuration of conf is invalid
+// or conflicts with the rest of it.
+func validateTLSACME(conf *tlsConfigSettings) (err error) {
+	c := conf.ACME
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	if conf.PrivateKeySigner != nil {
+		return errors.Error("private_key_signer can't be used with acme")
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return c.validate()
+}
+
+// toInternal returns the configuration of the ACME manager, which keeps its
+// files in dir and calls onCert with each new certificate.  c must be valid.
+func (c *acmeConfig) toInternal(dir string, onCert func(certChain, privateKey []byte)) (conf *acme.Config) {
+	conf = &acme.Config{
+		HTTPClient:       httpClient(),
+		OnCertificate:    onCert,
+		DirectoryURL:     c.DirectoryURL,
+		Email:            c.Email,
+		Dir:              dir,
+		Challenge:        c.Challenge,
+		Domains:          c.Domains,
+		RenewBefore:      c.RenewBefore.Duration,
+		PropagationDelay: c.PropagationDelay.Duration,
+	}
+
+	if p := c.DNSProvider; p != nil {
+		switch p.Type {
+		case acmeDNSProviderLocal:
+			conf.DNSProvider = acmeLocalProvider{}
+		case acmeDNSProviderExec:
+			conf.DNSProvider = acme.NewExecProvider(p.Command)
+		default:
+			// Go on.
+		}
+	}
+
+	return conf
+}
+
+// validate returns an error if the DNS provider configuration is invalid.
+func (c *acmeDNSProviderConfig) validate() (err error) {
+	if c == nil {
+		return nil
+	}
+
+	switch c.Type {
+	case acmeDNSProviderLocal:
+		return nil
+	case acmeDNSProviderExec:
+		if len(c.Command) == 0 {
+			return errors.Error("exec provider requires a command")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("bad type %q", c.Type)
+	}
+}
+
+// acmeLocalProvider is an [acme.DNSProvider], which makes the DNS server of
+// AdGuard Home answer the DNS-01 challenges.
+type acmeLocalProvider struct{}
+
+// type check
+var _ acme.DNSProvider = acmeLocalProvider{}
+
+// Present implements the [acme.DNSProvider] interface for acmeLocalProvider.
+func (acmeLocalProvider) Present(_ context.Context, fqdn, value string) (err error) {
+	if Context.dnsServer == nil {
+		return errors.Error("dns server is not initialized")
+	}
+
+	Context.dnsServer.AddACMEChallenge(fqdn, value)
+
+	return nil
+}
+
+// CleanUp implements the [acme.DNSProvider] interface for acmeLocalProvider.
+func (acmeLocalProvider) CleanUp(_ context.Context, fqdn, value string) (err error) {
+	if Context.dnsServer == nil {
+		return errors.Error("dns server is not initialized")
+	}
+
+	Context.dnsServer.RemoveACMEChallenge(fqdn, value)
+
+	return nil
+}
+
+// initACME creates the ACME manager, if it's enabled, and makes the TLS
+// configuration use the certificate it manages.
+func (m *tlsManager) initACME() (err error) {
+	c := m.conf.ACME
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	dir := filepath.Join(Context.getDataDir(), "acme")
+	m.acme, err = acme.New(c.toInternal(dir, m.onACMECertificate))
+	if err != nil {
+		return fmt.Errorf("initializing acme: %w", err)
+	}
+
+	m.useACMECertificate(&m.conf)
+
+	return nil
+}
+
+// useACMECertificate sets the certificate and the private key of conf to the
+// ones managed by the ACME manager, if there is one.
+func (m *tlsManager) useACMECertificate(conf *tlsConfigSettings) {
+	if m.acme == nil {
+		return
+	}
+
+	conf.CertificatePath, conf.PrivateKeyPath = m.acme.CertificatePaths()
+	conf.CertificateChain = ""
+	conf.CertificateChainData = nil
+	conf.PrivateKey = ""
+	conf.PrivateKeyData = nil
+}
+
+// acmeCertificateExists returns true if the ACME manager has already obtained
+// a certificate.
+func (m *tlsManager) acmeCertificateExists() (ok bool) {
+	certPath, _ := m.acme.CertificatePaths()
+	_, err := os.Stat(certPath)
+
+	return err == nil
+}
+
+// onACMECertificate reloads the TLS configuration after the ACME manager has
+// obtained a new certificate.
+func (m *tlsManager) onACMECertificate(_, _ []byte) {
+	m.confLock.Lock()
+	err := m.load()
+	if err == nil {
+		m.setCertFileTime()
+	}
+	tlsConf := m.conf
+	m.confLock.Unlock()
+
+	if err != nil {
+		log.Error("tls: acme: %s", err)
+
+		return
+	}
+
+	m.applyCertificate(tlsConf)
+}
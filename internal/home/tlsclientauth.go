@@ -0,0 +1,81 @@
+package home
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// tlsClientAuthConfig is the configuration of the authentication of the
+// clients of the encrypted DNS servers with the TLS client certificates.
+type tlsClientAuthConfig struct {
+	// CAPath is the path to the file with the PEM-encoded certificates of the
+	// authorities, which issue the client certificates.
+	CAPath string `yaml:"ca_path"`
+
+	// ClientIDField is the field of the client certificate, which is used as
+	// the ClientID, either "cn" or "san".  If empty, the certificates aren't
+	// used for ClientIDs.
+	ClientIDField dnsforward.ClientCertField `yaml:"clientid_field"`
+
+	// Required makes the DNS-over-TLS, DNS-over-QUIC, and DNS-over-HTTPS
+	// servers reject the clients without a valid certificate.
+	Required bool `yaml:"required"`
+
+	// Enabled defines if the client certificates are verified.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validate returns an error if the client authentication configuration is
+// invalid.
+func (c *tlsClientAuthConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	if c.CAPath == "" {
+		return errors.Error("no ca_path")
+	}
+
+	switch c.ClientIDField {
+	case
+		dnsforward.ClientCertFieldNone,
+		dnsforward.ClientCertFieldCN,
+		dnsforward.ClientCertFieldSAN:
+		return nil
+	default:
+		return fmt.Errorf("clientid_field: bad value %q", c.ClientIDField)
+	}
+}
+
+// loadClientAuth loads the certificate authorities for the client
+// authentication into tlsConf.
+func loadClientAuth(tlsConf *tlsConfigSettings) (err error) {
+	tlsConf.ClientCAs = nil
+	tlsConf.ClientCertField = dnsforward.ClientCertFieldNone
+	tlsConf.RequireClientCert = false
+
+	c := tlsConf.ClientAuth
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	b, err := os.ReadFile(c.CAPath)
+	if err != nil {
+		return fmt.Errorf("reading client ca file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("no certificates in client ca file %q", c.CAPath)
+	}
+
+	tlsConf.ClientCAs = pool
+	tlsConf.ClientCertField = c.ClientIDField
+	tlsConf.RequireClientCert = c.Required
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package home
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// echKeyFilename is the name of the file with the Encrypted Client Hello key
+// within the data directory.
+const echKeyFilename = "ech.pem"
+
+// tlsECHConfig is the configuration of the Encrypted Client Hello on the HTTPS
+// server, which hides the SNI of the DNS-over-HTTPS hostname.
+type tlsECHConfig struct {
+	// PublicName is the name, which the clients put into the unencrypted SNI
+	// instead of the server name.  The certificate should cover it.
+	PublicName string `yaml:"public_name"`
+
+	// Enabled defines if the ECH is accepted and advertised.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validate returns an error if the ECH configuration is invalid or if it's
+// enabled, but not supported by the build.
+func (c *tlsECHConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	if !aghtls.ECHSupported {
+		return aghtls.ErrECHUnsupported
+	} else if c.PublicName == "" {
+		return errors.Error("no public_name")
+	}
+
+	err = netutil.ValidateHostname(c.PublicName)
+	if err != nil {
+		return fmt.Errorf("public_name: %w", err)
+	}
+
+	return nil
+}
+
+// loadECH loads the ECH key into tlsConf, creating it in the data directory if
+// necessary.
+func loadECH(tlsConf *tlsConfigSettings) (err error) {
+	tlsConf.ECHKeys = nil
+
+	c := tlsConf.ECH
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	key, err := readOrCreateECHKey(filepath.Join(Context.getDataDir(), echKeyFilename), c.PublicName)
+	if err != nil {
+		return fmt.Errorf("ech key: %w", err)
+	}
+
+	tlsConf.ECHKeys = []*aghtls.ECHKey{key}
+
+	return nil
+}
+
+// readOrCreateECHKey reads the ECH key from the file at path.  If there is no
+// such file or the key is for another public name, it creates a new one and
+// writes it to the file.
+func readOrCreateECHKey(path, publicName string) (key *aghtls.ECHKey, err error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err = aghtls.ParseECHKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q: %w", path, err)
+		}
+
+		if key.PublicName() == publicName {
+			return key, nil
+		}
+
+		log.Info("tls: ech public name changed, creating new key")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading: %w", err)
+	}
+
+	key, err = aghtls.NewECHKey(0, publicName)
+	if err != nil {
+		return nil, fmt.Errorf("creating: %w", err)
+	}
+
+	data, err = aghtls.MarshalECHKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling: %w", err)
+	}
+
+	err = writeECHKey(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("writing: %w", err)
+	}
+
+	return key, nil
+}
+
+// writeECHKey atomically writes the PEM-encoded ECH key data to the file at
+// path.
+func writeECHKey(path string, data []byte) (err error) {
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	f, err := aghrenameio.NewPendingFile(path, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening pending file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing pending file: %w", err)
+	}
+
+	return nil
+}
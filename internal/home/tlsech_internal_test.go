@@ -0,0 +1,39 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
+	"github.com/AdguardTeam/golibs/testutil"
+)
+
+func TestTLSECHConfig_validate(t *testing.T) {
+	enabledErrMsg := ""
+	if !aghtls.ECHSupported {
+		enabledErrMsg = aghtls.ErrECHUnsupported.Error()
+	}
+
+	testCases := []struct {
+		conf       *tlsECHConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		conf:       &tlsECHConfig{PublicName: "", Enabled: false},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf:       &tlsECHConfig{PublicName: "public.example", Enabled: true},
+		name:       "enabled",
+		wantErrMsg: enabledErrMsg,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package home
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// tlsSNICertificate is the configuration of an additional certificate, which
+// is used instead of the main one for the clients, which SNI it matches.
+type tlsSNICertificate struct {
+	// ServerName is the hostname of the server, for which the certificate is
+	// issued.  It's used for ClientID checking and may be empty.
+	ServerName string `yaml:"server_name"`
+
+	// CertificatePath is the path to the file with the PEM-encoded certificate
+	// chain.
+	CertificatePath string `yaml:"certificate_path"`
+
+	// PrivateKeyPath is the path to the file with the PEM-encoded private key.
+	PrivateKeyPath string `yaml:"private_key_path"`
+}
+
+// validateSNICertificates returns an error if any of certs is invalid.
+func validateSNICertificates(certs []*tlsSNICertificate) (err error) {
+	for i, c := range certs {
+		switch {
+		case c == nil:
+			return fmt.Errorf("certificate at index %d: %w", i, errors.Error("no value"))
+		case c.CertificatePath == "":
+			return fmt.Errorf("certificate at index %d: no certificate_path", i)
+		case c.PrivateKeyPath == "":
+			return fmt.Errorf("certificate at index %d: no private_key_path", i)
+		}
+	}
+
+	return nil
+}
+
+// tlsSNICertificateStatus is the status of an additional certificate.
+type tlsSNICertificateStatus struct {
+	*tlsConfigStatus `json:",inline"`
+
+	// ServerName is the server name of the certificate from the configuration.
+	ServerName string `json:"server_name"`
+
+	// CertificatePath is the path to the certificate file.
+	CertificatePath string `json:"certificate_path"`
+}
+
+// loadSNICertificates loads and validates the additional certificates of
+// tlsConf, and sets their statuses in status.  Unlike the main certificate, an
+// additional certificate with an invalid key pair is an error.
+func loadSNICertificates(tlsConf *tlsConfigSettings, status *tlsConfigStatus) (err error) {
+	tlsConf.AdditionalCertificates = nil
+	status.SNICertificates = nil
+
+	for i, c := range tlsConf.SNICertificates {
+		certStatus := &tlsConfigStatus{}
+		status.SNICertificates = append(status.SNICertificates, &tlsSNICertificateStatus{
+			tlsConfigStatus: certStatus,
+			ServerName:      c.ServerName,
+			CertificatePath: c.CertificatePath,
+		})
+
+		var cert dnsforward.TLSCertificate
+		cert, err = loadSNICertificate(c, certStatus)
+		if err != nil {
+			return fmt.Errorf("sni certificate at index %d: %w", i, err)
+		}
+
+		tlsConf.AdditionalCertificates = append(tlsConf.AdditionalCertificates, cert)
+	}
+
+	return nil
+}
+
+// loadSNICertificate loads and validates the additional certificate c.  The
+// returned error, as well as the non-critical validation warnings, are also set
+// in status.WarningValidation.
+func loadSNICertificate(
+	c *tlsSNICertificate,
+	status *tlsConfigStatus,
+) (cert dnsforward.TLSCertificate, err error) {
+	defer func() {
+		if err != nil {
+			status.WarningValidation = err.Error()
+		}
+	}()
+
+	cert.ServerName = c.ServerName
+	cert.CertificateChainData, err = os.ReadFile(c.CertificatePath)
+	if err != nil {
+		return cert, fmt.Errorf("reading cert file: %w", err)
+	}
+
+	cert.PrivateKeyData, err = os.ReadFile(c.PrivateKeyPath)
+	if err != nil {
+		return cert, fmt.Errorf("reading key file: %w", err)
+	}
+
+	err = validateCertificates(
+		status,
+		cert.CertificateChainData,
+		cert.PrivateKeyData,
+		c.ServerName,
+	)
+	if err != nil {
+		if !status.ValidCert || !status.ValidKey || !status.ValidPair {
+			return cert, fmt.Errorf("validating certificate pair: %w", err)
+		}
+
+		// Do not return warnings since those aren't critical.
+		status.WarningValidation = err.Error()
+	}
+
+	return cert, nil
+}
+
+// certFilesModTime returns the latest modification time of the certificate
+// files of conf.
+func certFilesModTime(conf *tlsConfigSettings) (modTime time.Time, err error) {
+	paths := []string{conf.CertificatePath}
+	for _, c := range conf.SNICertificates {
+		paths = append(paths, c.CertificatePath)
+	}
+
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+
+		var fi os.FileInfo
+		fi, err = os.Stat(p)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("looking up certificate path: %w", err)
+		}
+
+		if t := fi.ModTime().UTC(); t.After(modTime) {
+			modTime = t
+		}
+	}
+
+	return modTime, nil
+}
@@ -0,0 +1,143 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// totpStatusJSON is the JSON structure for the status of the two-factor
+// authentication of the current user.
+type totpStatusJSON struct {
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	Enabled           bool `json:"enabled"`
+}
+
+// currentUserName returns the name of the user making r.  If there is none,
+// for example because the authentication is disabled, it responds with an
+// error and returns an empty name.
+func (a *Auth) currentUserName(w http.ResponseWriter, r *http.Request) (name string) {
+	name = a.getCurrentUser(r).Name
+	if name == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no current user")
+	}
+
+	return name
+}
+
+// handleTOTPStatus is the handler for the GET /control/totp/status HTTP API.
+func (a *Auth) handleTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	u := a.getCurrentUser(r)
+
+	aghhttp.WriteJSONResponseOK(w, r, &totpStatusJSON{
+		RecoveryCodesLeft: len(u.RecoveryCodes),
+		Enabled:           u.totpEnabled(),
+	})
+}
+
+// totpEnrollJSON is the JSON structure for the response to the request to
+// enroll the current user.
+type totpEnrollJSON struct {
+	// Secret is the base32-encoded TOTP secret.
+	Secret string `json:"secret"`
+
+	// URI is the otpauth URI of the secret, which can be shown as a QR code.
+	URI string `json:"uri"`
+}
+
+// handleTOTPEnroll is the handler for the POST /control/totp/enroll HTTP API.
+func (a *Auth) handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	name := a.currentUserName(w, r)
+	if name == "" {
+		return
+	}
+
+	secret, err := a.enrollTOTP(name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "enrolling: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &totpEnrollJSON{
+		Secret: secret,
+		URI:    totpURI(name, secret),
+	})
+}
+
+// totpCodeJSON is the JSON structure for the requests containing a TOTP or
+// recovery code.
+type totpCodeJSON struct {
+	Code string `json:"code"`
+}
+
+// totpRecoveryCodesJSON is the JSON structure for the response containing the
+// recovery codes.
+type totpRecoveryCodesJSON struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// handleTOTPConfirm is the handler for the POST /control/totp/confirm HTTP API.
+func (a *Auth) handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	name := a.currentUserName(w, r)
+	if name == "" {
+		return
+	}
+
+	req := &totpCodeJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	codes, err := a.confirmTOTP(name, req.Code)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "confirming: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+
+	aghhttp.WriteJSONResponseOK(w, r, &totpRecoveryCodesJSON{
+		RecoveryCodes: codes,
+	})
+}
+
+// handleTOTPDisable is the handler for the POST /control/totp/disable HTTP API.
+// All sessions of the current user are ended.
+func (a *Auth) handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	name := a.currentUserName(w, r)
+	if name == "" {
+		return
+	}
+
+	req := &totpCodeJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = a.disableTOTP(name, req.Code)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "disabling: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// registerTOTPHandlers registers the HTTP handlers managing the two-factor
+// authentication of the current user.
+func (a *Auth) registerTOTPHandlers() {
+	httpRegister(http.MethodGet, "/control/totp/status", a.handleTOTPStatus)
+	httpRegister(http.MethodPost, "/control/totp/enroll", a.handleTOTPEnroll)
+	httpRegister(http.MethodPost, "/control/totp/confirm", a.handleTOTPConfirm)
+	httpRegister(http.MethodPost, "/control/totp/disable", a.handleTOTPDisable)
+}
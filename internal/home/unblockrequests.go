@@ -0,0 +1,414 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/google/renameio/v2/maybe"
+	"golang.org/x/exp/slices"
+)
+
+// unblockRequestsFilename is the name of the file within the data directory
+// containing the unblock requests.
+const unblockRequestsFilename = "unblock_requests.json"
+
+// Limits of the unblock requests.
+const (
+	// maxUnblockRequests is the maximum number of the kept unblock requests.
+	// The oldest reviewed ones are removed first.
+	maxUnblockRequests = 1000
+
+	// maxClientUnblockRequests is the maximum number of the pending unblock
+	// requests of a single client.
+	maxClientUnblockRequests = 10
+
+	// maxUnblockRequestComment is the maximum length of the comment of an
+	// unblock request.
+	maxUnblockRequestComment = 1024
+)
+
+// unblockRequestsExpireIvl is the interval of the checks of the expiration of
+// the approved unblock requests.
+const unblockRequestsExpireIvl = 1 * time.Minute
+
+// unblockRequestStatus is the status of an unblock request.
+type unblockRequestStatus string
+
+// unblockRequestStatus values.
+const (
+	// unblockRequestPending means that the request waits for the review.
+	unblockRequestPending unblockRequestStatus = "pending"
+
+	// unblockRequestApproved means that the request has been approved and the
+	// allowlist rule has been added.
+	unblockRequestApproved unblockRequestStatus = "approved"
+
+	// unblockRequestDenied means that the request has been denied.
+	unblockRequestDenied unblockRequestStatus = "denied"
+
+	// unblockRequestExpired means that the request has been approved for a
+	// limited time, which has passed, and the allowlist rule has been removed.
+	unblockRequestExpired unblockRequestStatus = "expired"
+)
+
+// unblockRequest is a request of a client for the access to a blocked domain.
+type unblockRequest struct {
+	// Time is the time of the request.
+	Time time.Time `json:"time"`
+
+	// Reviewed is the time of the review, if reviewed.
+	Reviewed *time.Time `json:"reviewed,omitempty"`
+
+	// Expires is the time, when the allowlist rule of the approved request is
+	// removed.  It's nil if the rule is permanent.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Host is the requested domain.
+	Host string `json:"host"`
+
+	// Comment is the explanation of the request given by the client.
+	Comment string `json:"comment,omitempty"`
+
+	// Status is the status of the request.
+	Status unblockRequestStatus `json:"status"`
+
+	// Reviewer is the name of the user, who has reviewed the request.  It's
+	// empty if the authentication is disabled.
+	Reviewer string `json:"reviewer,omitempty"`
+
+	// Rule is the allowlist rule added for the approved request.
+	Rule string `json:"rule,omitempty"`
+
+	// Client is the IP address of the client.
+	Client netip.Addr `json:"client"`
+
+	// ID is the identifier of the request.
+	ID uint64 `json:"id"`
+}
+
+// unblockRule returns the allowlist rule unblocking host for the client with
+// ip.
+func unblockRule(host string, ip netip.Addr) (rule string) {
+	return fmt.Sprintf("@@||%s^$client=%s", host, ip)
+}
+
+// userRulesEditor edits the custom filtering rules.  It's implemented by
+// [*filtering.DNSFilter].
+type userRulesEditor interface {
+	// AddUserRule adds rule with the provenance p to the custom filtering
+	// rules.
+	AddUserRule(rule string, p *filtering.RuleProvenance) (added bool)
+
+	// RemoveUserRule removes rule from the custom filtering rules.
+	RemoveUserRule(rule string) (removed bool)
+}
+
+// type check
+var _ userRulesEditor = (*filtering.DNSFilter)(nil)
+
+// unblockRequests keeps the unblock requests of the clients and applies the
+// approved ones.
+type unblockRequests struct {
+	// done is closed when the requests are closing.
+	done chan struct{}
+
+	// rules edits the custom filtering rules for the approved requests.
+	rules userRulesEditor
+
+	// mu protects reqs, lastID, and the file.
+	mu *sync.Mutex
+
+	// path is the path to the file containing the requests.
+	path string
+
+	// reqs are the requests, the oldest first.
+	reqs []*unblockRequest
+
+	// lastID is the identifier of the latest request.
+	lastID uint64
+}
+
+// newUnblockRequests returns a new properly initialized *unblockRequests
+// keeping the requests in the file at path.
+func newUnblockRequests(path string, rules userRulesEditor) (u *unblockRequests, err error) {
+	u = &unblockRequests{
+		done:  make(chan struct{}),
+		rules: rules,
+		mu:    &sync.Mutex{},
+		path:  path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading: %w", err)
+	}
+
+	err = json.Unmarshal(data, &u.reqs)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	for _, req := range u.reqs {
+		u.lastID = mathutil.Max(u.lastID, req.ID)
+	}
+
+	return u, nil
+}
+
+// start starts the periodic removal of the expired allowlist rules.
+func (u *unblockRequests) start() {
+	go u.expireLoop()
+}
+
+// close stops the periodic removal of the expired allowlist rules.
+func (u *unblockRequests) close() {
+	close(u.done)
+}
+
+// expireLoop periodically removes the expired allowlist rules until u is
+// closed.  It's intended to be used as a goroutine.
+func (u *unblockRequests) expireLoop() {
+	defer log.OnPanic("unblock requests: expiring")
+
+	ticker := time.NewTicker(unblockRequestsExpireIvl)
+	defer ticker.Stop()
+
+	for {
+		u.expire(time.Now())
+
+		select {
+		case <-u.done:
+			return
+		case <-ticker.C:
+			// Go on.
+		}
+	}
+}
+
+// add adds the request of the client with ip for the access to host and
+// returns its copy.  If the same request is already pending, its copy is
+// returned instead.
+func (u *unblockRequests) add(
+	ip netip.Addr,
+	host string,
+	comment string,
+	now time.Time,
+) (req *unblockRequest, err error) {
+	err = netutil.ValidateDomainName(host)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	if len(comment) > maxUnblockRequestComment {
+		return nil, fmt.Errorf("comment is too long: %d bytes", len(comment))
+	}
+
+	added := false
+	defer func() {
+		if added {
+			notifyUnblockRequested(req)
+		}
+	}()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	clientPending := 0
+	for _, r := range u.reqs {
+		if r.Status != unblockRequestPending || r.Client != ip {
+			continue
+		} else if r.Host == host {
+			c := *r
+
+			return &c, nil
+		}
+
+		clientPending++
+	}
+
+	if clientPending >= maxClientUnblockRequests {
+		return nil, errors.Error("too many pending requests")
+	}
+
+	if !u.trimLocked() {
+		return nil, errors.Error("too many pending requests")
+	}
+
+	u.lastID++
+	req = &unblockRequest{
+		Time:    now,
+		Host:    host,
+		Comment: comment,
+		Status:  unblockRequestPending,
+		Client:  ip,
+		ID:      u.lastID,
+	}
+
+	c := *req
+	u.reqs = append(u.reqs, &c)
+	added = true
+
+	return req, u.writeLocked()
+}
+
+// trimLocked removes the oldest reviewed requests, if necessary, to make room
+// for a new one.  ok is false if there is no room.  u.mu must be locked.
+func (u *unblockRequests) trimLocked() (ok bool) {
+	for len(u.reqs) >= maxUnblockRequests {
+		i := slices.IndexFunc(u.reqs, func(r *unblockRequest) (found bool) {
+			return r.Status == unblockRequestDenied || r.Status == unblockRequestExpired
+		})
+		if i < 0 {
+			return false
+		}
+
+		u.reqs = slices.Delete(u.reqs, i, i+1)
+	}
+
+	return true
+}
+
+// list returns the copies of the requests, the oldest first.
+func (u *unblockRequests) list() (reqs []*unblockRequest) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	reqs = make([]*unblockRequest, 0, len(u.reqs))
+	for _, r := range u.reqs {
+		c := *r
+		reqs = append(reqs, &c)
+	}
+
+	return reqs
+}
+
+// review approves or denies the pending request with id.  If dur is positive,
+// the approval expires after it.
+func (u *unblockRequests) review(
+	id uint64,
+	approve bool,
+	dur time.Duration,
+	p *filtering.RuleProvenance,
+) (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	i := slices.IndexFunc(u.reqs, func(r *unblockRequest) (found bool) { return r.ID == id })
+	if i < 0 {
+		return fmt.Errorf("no request with id %d", id)
+	}
+
+	req := u.reqs[i]
+	if req.Status != unblockRequestPending {
+		return fmt.Errorf("request %d is %s", id, req.Status)
+	}
+
+	now := p.Time
+	req.Reviewed = &now
+	req.Reviewer = p.Author
+
+	if !approve {
+		req.Status = unblockRequestDenied
+		log.Info("unblock requests: denied %q for %s", req.Host, req.Client)
+
+		return u.writeLocked()
+	}
+
+	req.Status = unblockRequestApproved
+	req.Rule = unblockRule(req.Host, req.Client)
+	if dur > 0 {
+		exp := now.Add(dur)
+		req.Expires = &exp
+	}
+
+	u.rules.AddUserRule(req.Rule, p)
+	log.Info("unblock requests: approved %q for %s", req.Host, req.Client)
+
+	return u.writeLocked()
+}
+
+// expire removes the allowlist rules of the approved requests, which have
+// expired by now.
+func (u *unblockRequests) expire(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	expired := false
+	for _, req := range u.reqs {
+		if req.Status != unblockRequestApproved || req.Expires == nil || now.Before(*req.Expires) {
+			continue
+		}
+
+		if !u.hasOtherRuleLocked(req) {
+			u.rules.RemoveUserRule(req.Rule)
+		}
+
+		req.Status = unblockRequestExpired
+		expired = true
+
+		log.Info("unblock requests: access to %q for %s expired", req.Host, req.Client)
+	}
+
+	if !expired {
+		return
+	}
+
+	err := u.writeLocked()
+	if err != nil {
+		log.Error("unblock requests: %s", err)
+	}
+}
+
+// hasOtherRuleLocked returns true if another approved request, which hasn't
+// expired, has the same rule as req.  u.mu must be locked.
+func (u *unblockRequests) hasOtherRuleLocked(req *unblockRequest) (ok bool) {
+	return slices.ContainsFunc(u.reqs, func(r *unblockRequest) (found bool) {
+		return r != req &&
+			r.Status == unblockRequestApproved &&
+			r.Rule == req.Rule &&
+			(r.Expires == nil || r.Expires.After(*req.Expires))
+	})
+}
+
+// writeLocked writes the requests to the file.  u.mu must be locked.
+func (u *unblockRequests) writeLocked() (err error) {
+	data, err := json.Marshal(u.reqs)
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	err = maybe.WriteFile(u.path, data, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+
+	return nil
+}
+
+// notifyUnblockRequested sends the notification about the new unblock request
+// req.
+func notifyUnblockRequested(req *unblockRequest) {
+	notifier().Notify(&notify.Event{
+		Time:    req.Time,
+		Type:    notify.EventUnblockRequested,
+		Message: fmt.Sprintf("client %s requested access to %s", req.Client, req.Host),
+		Details: map[string]string{
+			"host":    req.Host,
+			"client":  req.Client.String(),
+			"comment": req.Comment,
+		},
+	})
+}
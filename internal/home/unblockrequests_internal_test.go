@@ -0,0 +1,133 @@
+package home
+
+import (
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+// testRulesEditor is a userRulesEditor for tests.
+type testRulesEditor struct {
+	rules []string
+}
+
+// type check
+var _ userRulesEditor = (*testRulesEditor)(nil)
+
+// AddUserRule implements the [userRulesEditor] interface for
+// *testRulesEditor.
+func (e *testRulesEditor) AddUserRule(rule string, _ *filtering.RuleProvenance) (added bool) {
+	if slices.Contains(e.rules, rule) {
+		return false
+	}
+
+	e.rules = append(e.rules, rule)
+
+	return true
+}
+
+// RemoveUserRule implements the [userRulesEditor] interface for
+// *testRulesEditor.
+func (e *testRulesEditor) RemoveUserRule(rule string) (removed bool) {
+	i := slices.Index(e.rules, rule)
+	if i < 0 {
+		return false
+	}
+
+	e.rules = slices.Delete(e.rules, i, i+1)
+
+	return true
+}
+
+func TestUnblockRequests(t *testing.T) {
+	const host = "blocked.example"
+
+	path := filepath.Join(t.TempDir(), unblockRequestsFilename)
+	editor := &testRulesEditor{}
+
+	u, err := newUnblockRequests(path, editor)
+	require.NoError(t, err)
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	now := time.Now()
+
+	first, err := u.add(ip, host, "homework", now)
+	require.NoError(t, err)
+
+	dup, err := u.add(ip, host, "again", now.Add(time.Minute))
+	require.NoError(t, err)
+
+	assert.Equal(t, first.ID, dup.ID)
+	assert.Equal(t, "homework", dup.Comment)
+
+	_, err = u.add(ip, "bad host", "", now)
+	require.Error(t, err)
+
+	second, err := u.add(ip, "other.example", "", now)
+	require.NoError(t, err)
+
+	p := &filtering.RuleProvenance{Time: now, Author: "admin"}
+	err = u.review(first.ID, true, time.Hour, p)
+	require.NoError(t, err)
+
+	err = u.review(first.ID, false, 0, p)
+	testutil.AssertErrorMsg(t, "request 1 is approved", err)
+
+	err = u.review(second.ID, false, 0, p)
+	require.NoError(t, err)
+
+	err = u.review(100, true, 0, p)
+	testutil.AssertErrorMsg(t, "no request with id 100", err)
+
+	rule := unblockRule(host, ip)
+	assert.Equal(t, "@@||blocked.example^$client=192.0.2.1", rule)
+	assert.Equal(t, []string{rule}, editor.rules)
+
+	u.expire(now.Add(time.Minute))
+	assert.Equal(t, []string{rule}, editor.rules)
+
+	u.expire(now.Add(2 * time.Hour))
+	assert.Empty(t, editor.rules)
+
+	reloaded, err := newUnblockRequests(path, editor)
+	require.NoError(t, err)
+
+	reqs := reloaded.list()
+	require.Len(t, reqs, 2)
+
+	assert.Equal(t, unblockRequestExpired, reqs[0].Status)
+	assert.Equal(t, "admin", reqs[0].Reviewer)
+	assert.Equal(t, unblockRequestDenied, reqs[1].Status)
+
+	third, err := reloaded.add(ip, host, "", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, second.ID+1, third.ID)
+}
+
+func TestUnblockRequests_add_limit(t *testing.T) {
+	u, err := newUnblockRequests(filepath.Join(t.TempDir(), unblockRequestsFilename), nil)
+	require.NoError(t, err)
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	now := time.Now()
+
+	for i := 0; i < maxClientUnblockRequests; i++ {
+		_, err = u.add(ip, "host"+strconv.Itoa(i)+".example", "", now)
+		require.NoError(t, err)
+	}
+
+	_, err = u.add(ip, "extra.example", "", now)
+	testutil.AssertErrorMsg(t, "too many pending requests", err)
+
+	_, err = u.add(netip.MustParseAddr("192.0.2.2"), "extra.example", "", now)
+	require.NoError(t, err)
+}
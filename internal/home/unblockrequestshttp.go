@@ -0,0 +1,84 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+)
+
+// unblockRequestsJSON is the JSON structure for the list of the unblock
+// requests.
+type unblockRequestsJSON struct {
+	Requests []*unblockRequest `json:"requests"`
+}
+
+// handleList is the handler for the GET /control/unblock_requests/list HTTP
+// API.
+func (u *unblockRequests) handleList(w http.ResponseWriter, r *http.Request) {
+	resp := &unblockRequestsJSON{
+		Requests: u.list(),
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// unblockReviewJSON is the JSON structure for the request to review an
+// unblock request.
+type unblockReviewJSON struct {
+	// Reason is the reason of the approval, which is recorded into the
+	// provenance of the allowlist rule.
+	Reason string `json:"reason,omitempty"`
+
+	// ID is the identifier of the unblock request.
+	ID uint64 `json:"id"`
+
+	// Duration is the duration of the approval in milliseconds.  If zero, the
+	// allowlist rule is permanent.
+	Duration uint `json:"duration"`
+
+	// Approve is true if the request is approved and false if it's denied.
+	Approve bool `json:"approve"`
+}
+
+// handleReview is the handler for the POST /control/unblock_requests/review
+// HTTP API.
+func (u *unblockRequests) handleReview(w http.ResponseWriter, r *http.Request) {
+	req := &unblockReviewJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("unblock request %d", req.ID)
+	}
+
+	p := &filtering.RuleProvenance{
+		Time:   time.Now().UTC(),
+		Source: r.URL.Path,
+		Author: requestUser(r),
+		Reason: reason,
+	}
+
+	err = u.review(req.ID, req.Approve, time.Duration(req.Duration)*time.Millisecond, p)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reviewing request: %s", err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// registerWebHandlers registers the HTTP handlers of the unblock requests.
+func (u *unblockRequests) registerWebHandlers() {
+	httpRegister(http.MethodGet, "/control/unblock_requests/list", u.handleList)
+	httpRegister(http.MethodPost, "/control/unblock_requests/review", u.handleReview)
+}
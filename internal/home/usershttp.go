@@ -0,0 +1,142 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+)
+
+// userJSON is the JSON structure for a user of the web interface.
+type userJSON struct {
+	// Password is the password of the user.  It's never sent in responses.
+	Password string   `json:"password,omitempty"`
+	Name     string   `json:"name"`
+	Role     userRole `json:"role"`
+	Clients  []string `json:"clients"`
+
+	// TOTPEnabled is true if the user has the two-factor authentication
+	// enabled.  It's ignored in requests.
+	TOTPEnabled bool `json:"totp_enabled"`
+}
+
+// toInternal returns the user defined by j.  The password hash is not set.
+func (j *userJSON) toInternal() (u *webUser) {
+	return &webUser{
+		Name:    j.Name,
+		Role:    j.Role,
+		Clients: j.Clients,
+	}
+}
+
+// usersListJSON is the JSON structure for the list of users.
+type usersListJSON struct {
+	Users []*userJSON `json:"users"`
+}
+
+// handleGetUsers is the handler for the GET /control/users HTTP API.
+func (a *Auth) handleGetUsers(w http.ResponseWriter, r *http.Request) {
+	resp := &usersListJSON{
+		Users: []*userJSON{},
+	}
+
+	for _, u := range a.GetUsers() {
+		clients := u.Clients
+		if clients == nil {
+			clients = []string{}
+		}
+
+		resp.Users = append(resp.Users, &userJSON{
+			Name:        u.Name,
+			Role:        u.role(),
+			Clients:     clients,
+			TOTPEnabled: u.totpEnabled(),
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleAddUser is the handler for the POST /control/users/add HTTP API.
+func (a *Auth) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	req := &userJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = a.addUser(req.toInternal(), req.Password)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "adding user: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// userUpdateJSON is the JSON structure for the request to update a user.
+type userUpdateJSON struct {
+	Data *userJSON `json:"data"`
+	Name string    `json:"name"`
+}
+
+// handleUpdateUser is the handler for the POST /control/users/update HTTP API.
+// The empty password is kept unchanged.
+func (a *Auth) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	req := &userUpdateJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	} else if req.Data == nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no data")
+
+		return
+	}
+
+	err = a.updateUser(req.Name, req.Data.toInternal(), req.Data.Password)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "updating user: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// userDeleteJSON is the JSON structure for the request to delete a user.
+type userDeleteJSON struct {
+	Name string `json:"name"`
+}
+
+// handleDeleteUser is the handler for the POST /control/users/delete HTTP API.
+func (a *Auth) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	req := &userDeleteJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	err = a.removeUser(req.Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "deleting user: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+}
+
+// registerUsersHandlers registers the HTTP handlers managing the users.
+func (a *Auth) registerUsersHandlers() {
+	httpRegister(http.MethodGet, "/control/users", a.handleGetUsers)
+	httpRegister(http.MethodPost, "/control/users/add", a.handleAddUser)
+	httpRegister(http.MethodPost, "/control/users/update", a.handleUpdateUser)
+	httpRegister(http.MethodPost, "/control/users/delete", a.handleDeleteUser)
+}
@@ -3,6 +3,7 @@ package home
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io/fs"
 	"net/http"
 	"net/netip"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
 	"github.com/AdguardTeam/AdGuardHome/internal/updater"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
@@ -74,10 +76,21 @@ type httpsServer struct {
 	// [httpsServer.server] must also be non-nil.
 	server3 *http3.Server
 
+	// certs are the certificates of the servers.  The first one is the main
+	// certificate, and the rest are selected by the SNI of the clients.
+	certs []tls.Certificate
+
+	// clientCAs, if not nil, are the certificate authorities, with which the
+	// client certificates are verified.  The clients without certificates
+	// aren't rejected here, since the web interface is served as well.
+	clientCAs *x509.CertPool
+
+	// echKeys are the Encrypted Client Hello keys of the servers.
+	echKeys []*aghtls.ECHKey
+
 	// TODO(a.garipov): Why is there a *sync.Cond here?  Remove.
 	cond       *sync.Cond
 	condLock   sync.Mutex
-	cert       tls.Certificate
 	inShutdown bool
 	enabled    bool
 }
@@ -142,15 +155,28 @@ func (web *webAPI) tlsConfigChanged(ctx context.Context, tlsConf tlsConfigSettin
 
 	enabled := tlsConf.Enabled &&
 		tlsConf.PortHTTPS != 0 &&
-		len(tlsConf.PrivateKeyData) != 0 &&
+		tlsConf.HasPrivateKey() &&
 		len(tlsConf.CertificateChainData) != 0
-	var cert tls.Certificate
-	var err error
+	var certs []tls.Certificate
 	if enabled {
-		cert, err = tls.X509KeyPair(tlsConf.CertificateChainData, tlsConf.PrivateKeyData)
+		cert, err := aghtls.KeyPair(
+			tlsConf.CertificateChainData,
+			tlsConf.PrivateKeyData,
+			tlsConf.PrivateKeySigner,
+		)
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		certs = append(certs, cert)
+		for _, c := range tlsConf.AdditionalCertificates {
+			cert, err = tls.X509KeyPair(c.CertificateChainData, c.PrivateKeyData)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			certs = append(certs, cert)
+		}
 	}
 
 	web.httpsServer.cond.L.Lock()
@@ -164,7 +190,9 @@ func (web *webAPI) tlsConfigChanged(ctx context.Context, tlsConf tlsConfigSettin
 	}
 
 	web.httpsServer.enabled = enabled
-	web.httpsServer.cert = cert
+	web.httpsServer.certs = certs
+	web.httpsServer.clientCAs = tlsConf.ClientCAs
+	web.httpsServer.echKeys = tlsConf.ECHKeys
 	web.httpsServer.cond.Broadcast()
 	web.httpsServer.cond.L.Unlock()
 }
@@ -229,6 +257,20 @@ func (web *webAPI) close(ctx context.Context) {
 	log.Info("stopped http server")
 }
 
+// certificate returns the main certificate of the HTTPS server.  It's used as
+// the [tls.Config.GetCertificate] of the other servers, which reuse the
+// certificate of the web interface.
+func (web *webAPI) certificate(_ *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
+	web.httpsServer.cond.L.Lock()
+	defer web.httpsServer.cond.L.Unlock()
+
+	if len(web.httpsServer.certs) == 0 {
+		return nil, errors.Error("no certificate")
+	}
+
+	return &web.httpsServer.certs[0], nil
+}
+
 func (web *webAPI) tlsServerLoop() {
 	for {
 		web.httpsServer.cond.L.Lock()
@@ -248,24 +290,20 @@ func (web *webAPI) tlsServerLoop() {
 
 		web.httpsServer.cond.L.Unlock()
 
-		var portHTTPS uint16
+		var portHTTPS, portHTTP3 uint16
 		func() {
 			config.RLock()
 			defer config.RUnlock()
 
 			portHTTPS = config.TLS.PortHTTPS
+			portHTTP3 = config.TLS.http3Port(web.conf.serveHTTP3)
 		}()
 
 		addr := netip.AddrPortFrom(web.conf.BindAddr.Addr(), portHTTPS).String()
 		web.httpsServer.server = &http.Server{
-			ErrorLog: log.StdLog("web: https", log.DEBUG),
-			Addr:     addr,
-			TLSConfig: &tls.Config{
-				Certificates: []tls.Certificate{web.httpsServer.cert},
-				RootCAs:      Context.tlsRoots,
-				CipherSuites: Context.tlsCipherIDs,
-				MinVersion:   tls.VersionTLS12,
-			},
+			ErrorLog:          log.StdLog("web: https", log.DEBUG),
+			Addr:              addr,
+			TLSConfig:         web.newTLSConfig(),
 			Handler:           withMiddlewares(Context.mux, limitRequestBody),
 			ReadTimeout:       web.conf.ReadTimeout,
 			ReadHeaderTimeout: web.conf.ReadHeaderTimeout,
@@ -274,8 +312,8 @@ func (web *webAPI) tlsServerLoop() {
 
 		printHTTPAddresses(aghhttp.SchemeHTTPS)
 
-		if web.conf.serveHTTP3 {
-			go web.mustStartHTTP3(addr)
+		if portHTTP3 != 0 {
+			go web.mustStartHTTP3(netip.AddrPortFrom(web.conf.BindAddr.Addr(), portHTTP3).String())
 		}
 
 		log.Debug("web: starting https server")
@@ -287,20 +325,47 @@ func (web *webAPI) tlsServerLoop() {
 	}
 }
 
+// newTLSConfig returns a new TLS configuration for the HTTPS servers.
+func (web *webAPI) newTLSConfig() (conf *tls.Config) {
+	conf = &tls.Config{
+		Certificates: web.httpsServer.certs,
+		ClientCAs:    web.httpsServer.clientCAs,
+		ClientAuth:   web.clientAuthType(),
+		RootCAs:      Context.tlsRoots,
+		CipherSuites: Context.tlsCipherIDs,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	err := aghtls.SetECHKeys(conf, web.httpsServer.echKeys)
+	if err != nil {
+		log.Error("web: setting ech keys: %s", err)
+	}
+
+	return conf
+}
+
+// clientAuthType returns the policy of the TLS client authentication for the
+// HTTPS servers.
+func (web *webAPI) clientAuthType() (typ tls.ClientAuthType) {
+	if web.httpsServer.clientCAs == nil {
+		return tls.NoClientCert
+	}
+
+	return tls.VerifyClientCertIfGiven
+}
+
+// mustStartHTTP3 starts the HTTP/3 server for the web interface and
+// DNS-over-HTTPS on the UDP address.  It shares the TLS configuration and the
+// handlers, including the ClientID ones, with the HTTPS server.
 func (web *webAPI) mustStartHTTP3(address string) {
 	defer log.OnPanic("web: http3")
 
 	web.httpsServer.server3 = &http3.Server{
 		// TODO(a.garipov): See if there is a way to use the error log as
 		// well as timeouts here.
-		Addr: address,
-		TLSConfig: &tls.Config{
-			Certificates: []tls.Certificate{web.httpsServer.cert},
-			RootCAs:      Context.tlsRoots,
-			CipherSuites: Context.tlsCipherIDs,
-			MinVersion:   tls.VersionTLS12,
-		},
-		Handler: withMiddlewares(Context.mux, limitRequestBody),
+		Addr:      address,
+		TLSConfig: web.newTLSConfig(),
+		Handler:   withMiddlewares(Context.mux, limitRequestBody),
 	}
 
 	log.Debug("web: starting http/3 server")
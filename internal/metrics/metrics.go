@@ -0,0 +1,242 @@
+// Package metrics contains the metrics of AdGuard Home, which are exposed in
+// the Prometheus text-based exposition format.
+//
+// See https://prometheus.io/docs/instrumenting/exposition_formats.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// ContentType is the media type of the text-based exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Type is the type of a metric family.
+type Type string
+
+// Type values.
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+)
+
+// family is a metric family, which writes its samples in the text-based
+// exposition format.
+type family interface {
+	// write writes the samples of the family to w.  The header is written by
+	// the registry.
+	write(w *bufio.Writer)
+}
+
+// desc is the description of a metric family.
+type desc struct {
+	name   string
+	help   string
+	typ    Type
+	labels []string
+}
+
+// newDesc returns a new description of a metric family.  It panics if any of
+// the names is invalid, since that is a programmer error.
+func newDesc(name, help string, typ Type, labels []string) (d *desc) {
+	if !isValidName(name) {
+		panic(fmt.Errorf("metrics: bad metric name %q", name))
+	}
+
+	for _, l := range labels {
+		if !isValidName(l) || strings.ContainsRune(l, ':') || strings.HasPrefix(l, "__") {
+			panic(fmt.Errorf("metrics: metric %q: bad label name %q", name, l))
+		}
+	}
+
+	return &desc{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: slices.Clone(labels),
+	}
+}
+
+// isValidName returns true if name is a valid metric name.
+func isValidName(name string) (ok bool) {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			// Go on.
+		case r >= '0' && r <= '9' && i > 0:
+			// Go on.
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkLabelValues panics if the number of label values doesn't match the
+// number of labels, since that is a programmer error.
+func (d *desc) checkLabelValues(vals []string) {
+	if len(vals) != len(d.labels) {
+		panic(fmt.Errorf(
+			"metrics: metric %q: got %d label values, want %d",
+			d.name,
+			len(vals),
+			len(d.labels),
+		))
+	}
+}
+
+// Registry is a set of metric families.
+type Registry struct {
+	// mu protects families.
+	mu *sync.Mutex
+
+	// families are the registered metric families by their descriptions.
+	families map[*desc]family
+}
+
+// NewRegistry returns a new empty registry.
+func NewRegistry() (r *Registry) {
+	return &Registry{
+		mu:       &sync.Mutex{},
+		families: map[*desc]family{},
+	}
+}
+
+// register adds f described by d to r.  It panics if a family with the same
+// name is already registered, since that is a programmer error.
+func (r *Registry) register(d *desc, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for other := range r.families {
+		if other.name == d.name {
+			panic(fmt.Errorf("metrics: metric %q is already registered", d.name))
+		}
+	}
+
+	r.families[d] = f
+}
+
+// Write writes all the metric families in r to w sorted by name.
+func (r *Registry) Write(w io.Writer) (err error) {
+	r.mu.Lock()
+	descs := maps.Keys(r.families)
+	fams := maps.Clone(r.families)
+	r.mu.Unlock()
+
+	slices.SortFunc(descs, func(a, b *desc) (res int) { return strings.Compare(a.name, b.name) })
+
+	bw := bufio.NewWriter(w)
+	for _, d := range descs {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+		_, _ = fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.typ)
+
+		fams[d].write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// type check
+var _ http.Handler = (*Registry)(nil)
+
+// ServeHTTP implements the [http.Handler] interface for *Registry.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set(httphdr.ContentType, ContentType)
+
+	err := r.Write(w)
+	if err != nil {
+		log.Debug("metrics: writing response: %s", err)
+	}
+}
+
+// helpEscaper escapes the help texts.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// labelValueEscaper escapes the label values.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// writeSample writes a single sample with the given name suffix.  extra, if
+// not empty, is the additional label and its value, such as the "le" label of
+// the histogram buckets.
+func writeSample(
+	w *bufio.Writer,
+	d *desc,
+	suffix string,
+	vals []string,
+	extra [2]string,
+	val string,
+) {
+	_, _ = w.WriteString(d.name)
+	_, _ = w.WriteString(suffix)
+
+	if len(vals) > 0 || extra[0] != "" {
+		_ = w.WriteByte('{')
+		for i, l := range d.labels {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+
+			writeLabel(w, l, vals[i])
+		}
+
+		if extra[0] != "" {
+			if len(vals) > 0 {
+				_ = w.WriteByte(',')
+			}
+
+			writeLabel(w, extra[0], extra[1])
+		}
+
+		_ = w.WriteByte('}')
+	}
+
+	_ = w.WriteByte(' ')
+	_, _ = w.WriteString(val)
+	_ = w.WriteByte('\n')
+}
+
+// writeLabel writes a single label pair.
+func writeLabel(w *bufio.Writer, name, val string) {
+	_, _ = w.WriteString(name)
+	_, _ = w.WriteString(`="`)
+	_, _ = labelValueEscaper.WriteString(w, val)
+	_ = w.WriteByte('"')
+}
+
+// formatFloat formats v as a sample value.
+func formatFloat(v float64) (s string) {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// seriesKey returns the key of the series with the label values vals.
+func seriesKey(vals []string) (key string) {
+	return strings.Join(vals, "\xff")
+}
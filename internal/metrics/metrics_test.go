@@ -0,0 +1,90 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/metrics"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	queries := reg.NewCounterVec("test_queries_total", "Number of queries.", "proto")
+	queries.Inc("udp")
+	queries.Inc("tcp")
+	queries.Add(2, "udp")
+
+	_ = reg.NewCounterVec("test_empty_total", "Help with \\ and\nnewline.")
+
+	dur := reg.NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.1, 1}, "upstream")
+	dur.Observe(0.05, `"quoted"`)
+	dur.Observe(0.5, `"quoted"`)
+	dur.Observe(2, `"quoted"`)
+
+	reg.NewGaugeFunc(
+		"test_rules",
+		"Number of rules.",
+		[]string{"id", "name"},
+		func(add func(v float64, vals ...string)) {
+			add(20, "2", "Second")
+			add(10, "1", "First")
+		},
+	)
+
+	b := &strings.Builder{}
+	require.NoError(t, reg.Write(b))
+
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{upstream="\"quoted\"",le="0.1"} 1
+test_duration_seconds_bucket{upstream="\"quoted\"",le="1"} 2
+test_duration_seconds_bucket{upstream="\"quoted\"",le="+Inf"} 3
+test_duration_seconds_sum{upstream="\"quoted\""} 2.55
+test_duration_seconds_count{upstream="\"quoted\""} 3
+# HELP test_empty_total Help with \\ and\nnewline.
+# TYPE test_empty_total counter
+test_empty_total 0
+# HELP test_queries_total Number of queries.
+# TYPE test_queries_total counter
+test_queries_total{proto="tcp"} 1
+test_queries_total{proto="udp"} 3
+# HELP test_rules Number of rules.
+# TYPE test_rules gauge
+test_rules{id="1",name="First"} 10
+test_rules{id="2",name="Second"} 20
+`
+	assert.Equal(t, want, b.String())
+}
+
+func TestRegistry_panics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c := reg.NewCounterVec("test_total", "Test.", "label")
+
+	assert.Panics(t, func() { reg.NewCounterVec("test_total", "Duplicate.") })
+	assert.Panics(t, func() { reg.NewCounterVec("bad-name", "Bad name.") })
+	assert.Panics(t, func() { reg.NewCounterVec("test_other_total", "Bad label.", "__reserved") })
+	assert.Panics(t, func() { reg.NewHistogramVec("test_seconds", "Bad buckets.", []float64{1, 1}) })
+	assert.Panics(t, func() { c.Inc() })
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.RegisterRuntime()
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, metrics.ContentType, w.Header().Get(httphdr.ContentType))
+
+	body := w.Body.String()
+	assert.Contains(t, body, "# TYPE go_goroutines gauge\ngo_goroutines ")
+	assert.Contains(t, body, "# TYPE go_gc_cycles_total counter\ngo_gc_cycles_total ")
+	assert.Contains(t, body, "\ngo_memstats_heap_alloc_bytes ")
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"runtime"
+	rtmetrics "runtime/metrics"
+)
+
+// runtimeMetric is a Go runtime metric exposed as a metric family.
+type runtimeMetric struct {
+	// name is the name of the metric family.
+	name string
+
+	// help is the help text of the metric family.
+	help string
+
+	// sample is the name of the runtime metric, see [rtmetrics.All].
+	sample string
+
+	// typ is the type of the metric family.
+	typ Type
+}
+
+// runtimeMetrics are the exposed Go runtime metrics.
+var runtimeMetrics = []*runtimeMetric{{
+	name:   "go_memstats_heap_alloc_bytes",
+	help:   "Number of bytes occupied by the live and not yet freed heap objects.",
+	sample: "/memory/classes/heap/objects:bytes",
+	typ:    TypeGauge,
+}, {
+	name:   "go_memstats_sys_bytes",
+	help:   "Number of bytes of memory obtained from the OS.",
+	sample: "/memory/classes/total:bytes",
+	typ:    TypeGauge,
+}, {
+	name:   "go_gc_cycles_total",
+	help:   "Number of completed GC cycles.",
+	sample: "/gc/cycles/total:gc-cycles",
+	typ:    TypeCounter,
+}}
+
+// RegisterRuntime registers the metrics of the Go runtime, such as the number
+// of goroutines and the memory usage, in r.
+func (r *Registry) RegisterRuntime() {
+	r.NewGaugeFunc(
+		"go_goroutines",
+		"Number of goroutines that currently exist.",
+		nil,
+		func(add func(v float64, vals ...string)) { add(float64(runtime.NumGoroutine())) },
+	)
+
+	for _, m := range runtimeMetrics {
+		m := m
+		collect := func(add func(v float64, vals ...string)) {
+			samples := []rtmetrics.Sample{{Name: m.sample}}
+			rtmetrics.Read(samples)
+			if v := samples[0].Value; v.Kind() == rtmetrics.KindUint64 {
+				add(float64(v.Uint64()))
+			}
+		}
+
+		if m.typ == TypeCounter {
+			r.NewCounterFunc(m.name, m.help, nil, collect)
+		} else {
+			r.NewGaugeFunc(m.name, m.help, nil, collect)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// CounterVec is a counter partitioned by the label values.
+type CounterVec struct {
+	// desc is the description of the family.
+	desc *desc
+
+	// mu protects series.
+	mu *sync.Mutex
+
+	// series are the counters by the joined label values.
+	series map[string]*counterSeries
+}
+
+// counterSeries is a single counter.
+type counterSeries struct {
+	vals []string
+	n    uint64
+}
+
+// NewCounterVec registers a new counter family in r and returns it.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) (c *CounterVec) {
+	c = &CounterVec{
+		desc:   newDesc(name, help, TypeCounter, labels),
+		mu:     &sync.Mutex{},
+		series: map[string]*counterSeries{},
+	}
+
+	r.register(c.desc, c)
+
+	return c
+}
+
+// Inc increments the counter with the label values vals.
+func (c *CounterVec) Inc(vals ...string) {
+	c.Add(1, vals...)
+}
+
+// Add adds n to the counter with the label values vals.
+func (c *CounterVec) Add(n uint64, vals ...string) {
+	c.desc.checkLabelValues(vals)
+
+	key := seriesKey(vals)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{vals: slices.Clone(vals)}
+		c.series[key] = s
+	}
+
+	s.n += n
+}
+
+// type check
+var _ family = (*CounterVec)(nil)
+
+// write implements the [family] interface for *CounterVec.
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	series := make([]counterSeries, 0, len(c.series))
+	for _, s := range c.series {
+		series = append(series, *s)
+	}
+	c.mu.Unlock()
+
+	if len(series) == 0 && len(c.desc.labels) == 0 {
+		series = append(series, counterSeries{})
+	}
+
+	slices.SortFunc(series, func(a, b counterSeries) (res int) {
+		return slices.Compare(a.vals, b.vals)
+	})
+
+	for _, s := range series {
+		writeSample(w, c.desc, "", s.vals, [2]string{}, strconv.FormatUint(s.n, 10))
+	}
+}
+
+// HistogramVec is a histogram partitioned by the label values.
+type HistogramVec struct {
+	// desc is the description of the family.
+	desc *desc
+
+	// mu protects series.
+	mu *sync.Mutex
+
+	// series are the histograms by the joined label values.
+	series map[string]*histogramSeries
+
+	// buckets are the sorted upper bounds of the buckets without +Inf.
+	buckets []float64
+}
+
+// histogramSeries is a single histogram.
+type histogramSeries struct {
+	vals []string
+
+	// counts are the numbers of observations within each bucket, not
+	// cumulative.
+	counts []uint64
+
+	sum   float64
+	count uint64
+}
+
+// NewHistogramVec registers a new histogram family in r and returns it.
+// buckets are the upper bounds of the buckets, the +Inf one is added
+// automatically.  It panics if buckets aren't strictly increasing, since that
+// is a programmer error.
+func (r *Registry) NewHistogramVec(
+	name string,
+	help string,
+	buckets []float64,
+	labels ...string,
+) (h *HistogramVec) {
+	buckets = slices.Clone(buckets)
+	if l := len(buckets); l > 0 && math.IsInf(buckets[l-1], 1) {
+		buckets = buckets[:l-1]
+	}
+
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic(fmt.Errorf("metrics: histogram %q: buckets are not strictly increasing", name))
+		}
+	}
+
+	for _, l := range labels {
+		if l == "le" {
+			panic(fmt.Errorf("metrics: histogram %q: label %q is reserved", name, l))
+		}
+	}
+
+	h = &HistogramVec{
+		desc:    newDesc(name, help, TypeHistogram, labels),
+		mu:      &sync.Mutex{},
+		series:  map[string]*histogramSeries{},
+		buckets: buckets,
+	}
+
+	r.register(h.desc, h)
+
+	return h
+}
+
+// Observe adds v to the histogram with the label values vals.
+func (h *HistogramVec) Observe(v float64, vals ...string) {
+	h.desc.checkLabelValues(vals)
+
+	key := seriesKey(vals)
+	i, _ := slices.BinarySearch(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			vals:   slices.Clone(vals),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+
+	s.sum += v
+	s.count++
+}
+
+// type check
+var _ family = (*HistogramVec)(nil)
+
+// write implements the [family] interface for *HistogramVec.
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	series := make([]histogramSeries, 0, len(h.series))
+	for _, s := range h.series {
+		cp := *s
+		cp.counts = slices.Clone(s.counts)
+		series = append(series, cp)
+	}
+	h.mu.Unlock()
+
+	slices.SortFunc(series, func(a, b histogramSeries) (res int) {
+		return slices.Compare(a.vals, b.vals)
+	})
+
+	for _, s := range series {
+		var cum uint64
+		for i, ub := range h.buckets {
+			cum += s.counts[i]
+			le := [2]string{"le", formatFloat(ub)}
+			writeSample(w, h.desc, "_bucket", s.vals, le, strconv.FormatUint(cum, 10))
+		}
+
+		inf := [2]string{"le", "+Inf"}
+		writeSample(w, h.desc, "_bucket", s.vals, inf, strconv.FormatUint(s.count, 10))
+		writeSample(w, h.desc, "_sum", s.vals, [2]string{}, formatFloat(s.sum))
+		writeSample(w, h.desc, "_count", s.vals, [2]string{}, strconv.FormatUint(s.count, 10))
+	}
+}
+
+// CollectFunc is called on each scrape to collect the current samples of a
+// metric family.  It must call add once for each sample.
+type CollectFunc func(add func(v float64, vals ...string))
+
+// funcFamily is a metric family, the samples of which are collected on each
+// scrape.
+type funcFamily struct {
+	desc    *desc
+	collect CollectFunc
+}
+
+// funcSample is a single collected sample.
+type funcSample struct {
+	vals []string
+	v    float64
+}
+
+// NewGaugeFunc registers a new gauge family in r, the samples of which are
+// collected by f on each scrape.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, f CollectFunc) {
+	ff := &funcFamily{
+		desc:    newDesc(name, help, TypeGauge, labels),
+		collect: f,
+	}
+
+	r.register(ff.desc, ff)
+}
+
+// NewCounterFunc registers a new counter family in r, the samples of which are
+// collected by f on each scrape.  f must only report the values, which never
+// decrease.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, f CollectFunc) {
+	ff := &funcFamily{
+		desc:    newDesc(name, help, TypeCounter, labels),
+		collect: f,
+	}
+
+	r.register(ff.desc, ff)
+}
+
+// type check
+var _ family = (*funcFamily)(nil)
+
+// write implements the [family] interface for *funcFamily.
+func (f *funcFamily) write(w *bufio.Writer) {
+	var samples []funcSample
+	f.collect(func(v float64, vals ...string) {
+		f.desc.checkLabelValues(vals)
+		samples = append(samples, funcSample{vals: slices.Clone(vals), v: v})
+	})
+
+	slices.SortStableFunc(samples, func(a, b funcSample) (res int) {
+		return slices.Compare(a.vals, b.vals)
+	})
+
+	for _, s := range samples {
+		writeSample(w, f.desc, "", s.vals, [2]string{}, formatFloat(s.v))
+	}
+}
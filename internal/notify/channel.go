@@ -0,0 +1,335 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"golang.org/x/exp/slices"
+)
+
+// ChannelType is the type of a notification channel.
+type ChannelType string
+
+// Channel types.
+const (
+	// ChannelWebhook sends the events in the bodies of the POST requests.
+	ChannelWebhook ChannelType = "webhook"
+
+	// ChannelTelegram sends the events as the messages of a Telegram bot.
+	ChannelTelegram ChannelType = "telegram"
+
+	// ChannelEmail sends the events as the email messages through an SMTP
+	// server.
+	ChannelEmail ChannelType = "email"
+
+	// ChannelSyslog sends the events to a remote syslog server.
+	ChannelSyslog ChannelType = "syslog"
+
+	// ChannelCommand runs a command with the message on its standard input.
+	ChannelCommand ChannelType = "command"
+)
+
+// ChannelConfig is the configuration of a single channel.
+type ChannelConfig struct {
+	// URL is the address used by the channel.  For [ChannelWebhook], it's the
+	// address of the webhook with either "http" or "https" scheme.  For
+	// [ChannelTelegram], it's the address of the Bot API server; if nil,
+	// [DefaultTelegramURL] is used.  For [ChannelSyslog], it's the address of
+	// the server with either "udp" or "tcp" scheme, for example
+	// "udp://192.0.2.1:514".  It's not used by [ChannelEmail] and
+	// [ChannelCommand].
+	URL *url.URL
+
+	// Email is the configuration of the SMTP server and the message
+	// addresses.  It must not be nil for [ChannelEmail].
+	Email *EmailConfig
+
+	// Name is the name of the channel used in logs.
+	Name string
+
+	// Type is the type of the channel.
+	Type ChannelType
+
+	// Template, if not empty, is the text/template template of the message
+	// executed with the *[Event] as data.  The "json" function encodes its
+	// argument as JSON.  If empty, [DefaultTemplate] is used for all types
+	// except [ChannelWebhook] and [ChannelCommand], which send the event as a
+	// JSON object.
+	Template string
+
+	// BotToken is the token of the Telegram bot.  It must not be empty for
+	// [ChannelTelegram].
+	BotToken string
+
+	// ChatID is the ID of the Telegram chat, to which the messages are sent.
+	// It must not be empty for [ChannelTelegram].
+	ChatID string
+
+	// Command is the executable and its arguments, which is run with the
+	// message on its standard input.  It must not be empty for
+	// [ChannelCommand].  See [aghos.RunHook].
+	Command []string
+
+	// Events, if not empty, are the types of the events sent through the
+	// channel.  If empty, all events except the DHCP lease ones are sent.
+	Events []EventType
+
+	// RateLimit is the minimum duration between two messages sent through the
+	// channel.  The events matched within it are dropped.  If zero, all
+	// matched events are sent.
+	RateLimit time.Duration
+}
+
+// EmailConfig is the configuration of sending the events by email.
+type EmailConfig struct {
+	// Addr is the address of the SMTP server in the host:port form.  STARTTLS
+	// is used if the server supports it.
+	Addr string
+
+	// Username is the name of the user for the authentication on the SMTP
+	// server.  If empty, no authentication is performed.
+	Username string
+
+	// Password is the password of the user.
+	Password string
+
+	// From is the address of the sender.
+	From string
+
+	// To are the addresses of the recipients.  It must not be empty.
+	To []string
+}
+
+// DefaultTemplate is the template of the messages used, when a channel has no
+// template.
+const DefaultTemplate = `AdGuard Home: {{.Type}}: {{.Message}}`
+
+// DefaultTelegramURL is the address of the Telegram Bot API server used by
+// default.
+const DefaultTelegramURL = "https://api.telegram.org"
+
+// templateFuncs are the functions available in the templates.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (s string, err error) {
+		b, err := json.Marshal(v)
+
+		return string(b), err
+	},
+}
+
+// channel is a single configured channel.
+type channel struct {
+	// sender sends the messages through the channel.
+	sender sender
+
+	// tmpl is the template of the message.  It's nil if the event is sent as
+	// is.
+	tmpl *template.Template
+
+	// queue are the events waiting to be sent.
+	queue chan *Event
+
+	// name is the name of the channel used in logs.
+	name string
+
+	// events are the types of the events sent through the channel.
+	events []EventType
+
+	// mu protects lastSent.
+	mu *sync.Mutex
+
+	// lastSent is the time, when the last event has been accepted for
+	// sending.
+	lastSent time.Time
+
+	// rateLimit is the minimum duration between two messages.
+	rateLimit time.Duration
+}
+
+// newChannel returns a new properly initialized *channel.  conf must not be
+// nil.  cli and hostname are used by the senders.
+func newChannel(conf *ChannelConfig, cli *http.Client, hostname string) (c *channel, err error) {
+	for _, t := range conf.Events {
+		err = t.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("events: %w", err)
+		}
+	}
+
+	if conf.RateLimit < 0 {
+		return nil, fmt.Errorf("rate limit: must not be negative, got %s", conf.RateLimit)
+	}
+
+	c = &channel{
+		queue:     make(chan *Event, queueSize),
+		name:      conf.Name,
+		events:    conf.Events,
+		mu:        &sync.Mutex{},
+		rateLimit: conf.RateLimit,
+	}
+
+	if c.name == "" {
+		c.name = "unnamed"
+	}
+
+	c.sender, err = newSender(conf, cli, hostname)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	text := conf.Template
+	if text == "" {
+		if conf.Type == ChannelWebhook || conf.Type == ChannelCommand {
+			return c, nil
+		}
+
+		text = DefaultTemplate
+	}
+
+	c.tmpl, err = template.New(c.name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+
+	return c, nil
+}
+
+// match returns true if e must be sent through c.
+func (c *channel) match(e *Event) (ok bool) {
+	if len(c.events) == 0 {
+		return !e.Type.isOptIn()
+	}
+
+	return slices.Contains(c.events, e.Type)
+}
+
+// allow returns true if the rate limit of c allows sending a message at now.
+func (c *channel) allow(now time.Time) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.lastSent.IsZero() && now.Sub(c.lastSent) < c.rateLimit {
+		return false
+	}
+
+	c.lastSent = now
+
+	return true
+}
+
+// send renders the message for e and sends it.
+func (c *channel) send(ctx context.Context, e *Event) (err error) {
+	msg, err := c.render(e)
+	if err != nil {
+		return fmt.Errorf("rendering message: %w", err)
+	}
+
+	return c.sender.send(ctx, e, msg)
+}
+
+// render returns the message for e.  If c has no template, the message is e
+// encoded as JSON.
+func (c *channel) render(e *Event) (msg []byte, err error) {
+	if c.tmpl == nil {
+		return json.Marshal(e)
+	}
+
+	b := &bytes.Buffer{}
+	err = c.tmpl.Execute(b, e)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// newSender returns a new sender for the channel described by conf.
+func newSender(conf *ChannelConfig, cli *http.Client, hostname string) (s sender, err error) {
+	switch conf.Type {
+	case ChannelWebhook:
+		err = validateHTTPURL(conf.URL)
+		if err != nil {
+			return nil, fmt.Errorf("url: %w", err)
+		}
+
+		return &webhookSender{client: cli, url: conf.URL}, nil
+	case ChannelTelegram:
+		return newTelegramSender(conf, cli)
+	case ChannelEmail:
+		return newEmailSender(conf.Email, hostname)
+	case ChannelSyslog:
+		return newSyslogSender(conf.URL, hostname)
+	case ChannelCommand:
+		if len(conf.Command) == 0 {
+			return nil, errors.Error("command: no value")
+		}
+
+		return &commandSender{argv: conf.Command}, nil
+	default:
+		return nil, fmt.Errorf("bad type %q", conf.Type)
+	}
+}
+
+// validateHTTPURL returns an error if u is not a valid HTTP or HTTPS URL.
+func validateHTTPURL(u *url.URL) (err error) {
+	if u == nil {
+		return errors.Error("no value")
+	}
+
+	switch u.Scheme {
+	case aghhttp.SchemeHTTP, aghhttp.SchemeHTTPS:
+		// Go on.
+	default:
+		return fmt.Errorf("bad scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return errors.Error("no host")
+	}
+
+	return nil
+}
+
+// newTelegramSender returns a new Telegram sender for conf.
+func newTelegramSender(conf *ChannelConfig, cli *http.Client) (s *telegramSender, err error) {
+	switch {
+	case conf.BotToken == "":
+		return nil, errors.Error("bot_token: no value")
+	case strings.ContainsAny(conf.BotToken, "/?#"):
+		return nil, errors.Error("bot_token: bad value")
+	case conf.ChatID == "":
+		return nil, errors.Error("chat_id: no value")
+	}
+
+	u := conf.URL
+	if u == nil {
+		u, err = url.Parse(DefaultTelegramURL)
+		if err != nil {
+			// Shouldn't happen, since DefaultTelegramURL is valid.
+			panic(err)
+		}
+	}
+
+	err = validateHTTPURL(u)
+	if err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	}
+
+	return &telegramSender{
+		client: cli,
+		url:    u.JoinPath("bot"+conf.BotToken, "sendMessage"),
+		chatID: conf.ChatID,
+	}, nil
+}
@@ -0,0 +1,261 @@
+// Package notify contains the notifier, which sends the messages about the
+// notable events, such as the failures of the filter list updates or the
+// upstream outages, through the configured channels: webhooks, Telegram, email,
+// and syslog.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// EventType is the type of a notable event.
+type EventType string
+
+// Event types.
+const (
+	// EventFilterUpdateFailed means that a filter list couldn't be updated.
+	EventFilterUpdateFailed EventType = "filter_update_failed"
+
+	// EventUpstreamOutage means that the upstream servers have failed to
+	// respond to a number of consecutive requests.
+	EventUpstreamOutage EventType = "upstream_outage"
+
+	// EventNewClient means that a client with a previously unseen IP address
+	// has sent a request.
+	EventNewClient EventType = "new_client"
+
+	// EventDHCPPoolExhausted means that the DHCP server has no IP addresses
+	// left to lease.
+	EventDHCPPoolExhausted EventType = "dhcp_pool_exhausted"
+
+	// EventCertificateExpiry means that the TLS certificate expires soon or
+	// has expired.
+	EventCertificateExpiry EventType = "certificate_expiry"
+
+	// EventAnomaly means that an anomaly in the DNS query patterns has been
+	// detected.
+	EventAnomaly EventType = "anomaly"
+
+	// EventDHCPLeaseCreated means that the DHCP server has leased an IP
+	// address to a client.  It's only sent through the channels listing it
+	// explicitly.
+	EventDHCPLeaseCreated EventType = "dhcp_lease_created"
+
+	// EventDHCPLeaseRenewed means that a client has renewed its DHCP lease.
+	// It's only sent through the channels listing it explicitly.
+	EventDHCPLeaseRenewed EventType = "dhcp_lease_renewed"
+
+	// EventDHCPLeaseExpired means that a DHCP lease hasn't been renewed in
+	// time.  It's only sent through the channels listing it explicitly.
+	EventDHCPLeaseExpired EventType = "dhcp_lease_expired"
+
+	// EventClientQuarantined means that a previously unseen client has been
+	// placed into quarantine until it's approved.
+	EventClientQuarantined EventType = "client_quarantined"
+
+	// EventUnblockRequested means that a client has requested the access to a
+	// blocked domain from the block page.
+	EventUnblockRequested EventType = "unblock_requested"
+
+	// EventDDNSUpdateFailed means that the records of a dynamic DNS provider
+	// couldn't be updated with the public IP addresses.
+	EventDDNSUpdateFailed EventType = "ddns_update_failed"
+)
+
+// Validate returns an error if t is not a known event type.
+func (t EventType) Validate() (err error) {
+	switch t {
+	case
+		EventFilterUpdateFailed,
+		EventUpstreamOutage,
+		EventNewClient,
+		EventDHCPPoolExhausted,
+		EventCertificateExpiry,
+		EventAnomaly,
+		EventDHCPLeaseCreated,
+		EventDHCPLeaseRenewed,
+		EventDHCPLeaseExpired,
+		EventClientQuarantined,
+		EventUnblockRequested,
+		EventDDNSUpdateFailed:
+		return nil
+	default:
+		return fmt.Errorf("bad event type %q", t)
+	}
+}
+
+// isOptIn returns true if the events of type t are too frequent to be sent
+// through the channels, which don't list t explicitly.
+func (t EventType) isOptIn() (ok bool) {
+	switch t {
+	case
+		EventDHCPLeaseCreated,
+		EventDHCPLeaseRenewed,
+		EventDHCPLeaseExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// Event is a single notable event.
+type Event struct {
+	// Time is the time of the event.
+	Time time.Time `json:"time"`
+
+	// Details are the additional properties of the event depending on its
+	// type, for example the URL of the filter list.
+	Details map[string]string `json:"details,omitempty"`
+
+	// Type is the type of the event.
+	Type EventType `json:"type"`
+
+	// Message is the human-readable description of the event.
+	Message string `json:"message"`
+}
+
+// Interface is the notifier of the events.
+type Interface interface {
+	// Notify sends e through the matching channels.  It must not block.  e
+	// must not be nil and must not be modified after the call.
+	Notify(e *Event)
+}
+
+// Empty is an [Interface] implementation that does nothing.
+type Empty struct{}
+
+// type check
+var _ Interface = Empty{}
+
+// Notify implements the [Interface] interface for Empty.
+func (Empty) Notify(_ *Event) {}
+
+// Config is the configuration of the notifier.
+type Config struct {
+	// HTTPClient is the client used to call the webhooks and the Telegram Bot
+	// API.  If nil, [http.DefaultClient] is used.
+	HTTPClient *http.Client
+
+	// Hostname is the HOSTNAME field of the syslog messages and the name of
+	// the host in the email messages.  If empty, the nil value is used for
+	// syslog.
+	Hostname string
+
+	// Channels are the configurations of the channels.  Each item must not be
+	// nil.
+	Channels []*ChannelConfig
+}
+
+// queueSize is the maximum number of the events waiting to be sent through a
+// channel.  When it's exceeded, the new events are dropped.
+const queueSize = 16
+
+// sendTimeout is the timeout for sending a single message.
+const sendTimeout = 10 * time.Second
+
+// Notifier is an [Interface] implementation, which sends the events through
+// each matching channel asynchronously.
+type Notifier struct {
+	// ctx is canceled when the notifier is closing.
+	ctx context.Context
+
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+
+	// wg waits for the channels goroutines to exit.
+	wg *sync.WaitGroup
+
+	// channels are the configured channels.
+	channels []*channel
+}
+
+// type check
+var _ Interface = (*Notifier)(nil)
+
+// New returns a new properly initialized *Notifier.  conf must not be nil.
+func New(conf *Config) (n *Notifier, err error) {
+	cli := conf.HTTPClient
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n = &Notifier{
+		ctx:      ctx,
+		cancel:   cancel,
+		wg:       &sync.WaitGroup{},
+		channels: make([]*channel, 0, len(conf.Channels)),
+	}
+
+	for i, cc := range conf.Channels {
+		var c *channel
+		c, err = newChannel(cc, cli, conf.Hostname)
+		if err != nil {
+			cancel()
+
+			return nil, fmt.Errorf("channel at index %d: %w", i, err)
+		}
+
+		n.channels = append(n.channels, c)
+	}
+
+	return n, nil
+}
+
+// Start starts sending the events.  It must only be called once.
+func (n *Notifier) Start() {
+	for _, c := range n.channels {
+		n.wg.Add(1)
+		go n.handle(c)
+	}
+}
+
+// Notify implements the [Interface] interface for *Notifier.
+func (n *Notifier) Notify(e *Event) {
+	now := time.Now()
+	for _, c := range n.channels {
+		if !c.match(e) || !c.allow(now) {
+			continue
+		}
+
+		select {
+		case c.queue <- e:
+		default:
+			log.Debug("notify: %s: queue is full, dropping event", c.name)
+		}
+	}
+}
+
+// handle sends each of the events queued for c until n is closed.  It's
+// intended to be used as a goroutine.
+func (n *Notifier) handle(c *channel) {
+	defer n.wg.Done()
+	defer log.OnPanic("notify: " + c.name)
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case e := <-c.queue:
+			ctx, cancel := context.WithTimeout(n.ctx, sendTimeout)
+			err := c.send(ctx, e)
+			cancel()
+			if err != nil {
+				log.Error("notify: %s: %s", c.name, err)
+			}
+		}
+	}
+}
+
+// Close stops sending the events, cancels the current sending, and waits for
+// the channels goroutines to exit.
+func (n *Notifier) Close() {
+	n.cancel()
+	n.wg.Wait()
+}
@@ -0,0 +1,417 @@
+package notify_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/notify"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// request is a request received by the test server.
+type request struct {
+	path        string
+	contentType string
+	body        []byte
+}
+
+// newTestServer returns a new test HTTP server, which sends the received
+// requests to the returned channel, and the URL of the server.
+func newTestServer(t *testing.T) (reqCh chan *request, u *url.URL) {
+	t.Helper()
+
+	reqCh = make(chan *request, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pt := testutil.PanicT{}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(pt, err)
+
+		reqCh <- &request{
+			path:        r.URL.Path,
+			contentType: r.Header.Get(httphdr.ContentType),
+			body:        body,
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	return reqCh, u
+}
+
+// newTestNotifier returns a new started notifier for the channels and
+// registers its closing in t's cleanup.
+func newTestNotifier(t *testing.T, channels ...*notify.ChannelConfig) (n *notify.Notifier) {
+	t.Helper()
+
+	n, err := notify.New(&notify.Config{
+		Hostname: "test-host",
+		Channels: channels,
+	})
+	require.NoError(t, err)
+
+	n.Start()
+	t.Cleanup(n.Close)
+
+	return n
+}
+
+// newTestEvent returns a new event of type typ.
+func newTestEvent(typ notify.EventType) (e *notify.Event) {
+	return &notify.Event{
+		Time:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Type:    typ,
+		Message: `filter "test" failed`,
+		Details: map[string]string{"url": "https://filter.example"},
+	}
+}
+
+func TestNotifier_webhook(t *testing.T) {
+	reqCh, u := newTestServer(t)
+
+	n := newTestNotifier(t, &notify.ChannelConfig{
+		URL:    u,
+		Name:   "json",
+		Type:   notify.ChannelWebhook,
+		Events: []notify.EventType{notify.EventFilterUpdateFailed},
+	}, &notify.ChannelConfig{
+		URL:      u.JoinPath("templated"),
+		Name:     "templated",
+		Type:     notify.ChannelWebhook,
+		Template: `{"text":{{json .Message}},"url":{{json (index .Details "url")}}}`,
+		Events:   []notify.EventType{notify.EventFilterUpdateFailed},
+	})
+
+	// Not matched by the events.
+	n.Notify(newTestEvent(notify.EventNewClient))
+
+	e := newTestEvent(notify.EventFilterUpdateFailed)
+	n.Notify(e)
+
+	got := map[string]*request{}
+	for i := 0; i < 2; i++ {
+		req, ok := testutil.RequireReceive(t, reqCh, testTimeout)
+		require.True(t, ok)
+
+		got[req.path] = req
+	}
+
+	require.Contains(t, got, "/")
+	require.Contains(t, got, "/templated")
+
+	gotEvent := &notify.Event{}
+	require.NoError(t, json.Unmarshal(got["/"].body, gotEvent))
+	assert.Equal(t, e, gotEvent)
+
+	assert.Equal(t, "application/json", got["/templated"].contentType)
+	assert.JSONEq(
+		t,
+		`{"text":"filter \"test\" failed","url":"https://filter.example"}`,
+		string(got["/templated"].body),
+	)
+
+	assert.Empty(t, reqCh)
+}
+
+func TestNotifier_optInEvents(t *testing.T) {
+	reqCh, u := newTestServer(t)
+
+	n := newTestNotifier(t, &notify.ChannelConfig{
+		URL:  u.JoinPath("all"),
+		Name: "all",
+		Type: notify.ChannelWebhook,
+	}, &notify.ChannelConfig{
+		URL:    u.JoinPath("leases"),
+		Name:   "leases",
+		Type:   notify.ChannelWebhook,
+		Events: []notify.EventType{notify.EventDHCPLeaseCreated},
+	})
+
+	n.Notify(newTestEvent(notify.EventDHCPLeaseCreated))
+
+	req, ok := testutil.RequireReceive(t, reqCh, testTimeout)
+	require.True(t, ok)
+
+	assert.Equal(t, "/leases", req.path)
+
+	n.Notify(newTestEvent(notify.EventNewClient))
+
+	req, ok = testutil.RequireReceive(t, reqCh, testTimeout)
+	require.True(t, ok)
+
+	assert.Equal(t, "/all", req.path)
+	assert.Empty(t, reqCh)
+}
+
+func TestNotifier_telegram(t *testing.T) {
+	reqCh, u := newTestServer(t)
+
+	n := newTestNotifier(t, &notify.ChannelConfig{
+		URL:      u,
+		Name:     "telegram",
+		Type:     notify.ChannelTelegram,
+		BotToken: "123:abc",
+		ChatID:   "-100",
+	})
+
+	n.Notify(newTestEvent(notify.EventUpstreamOutage))
+
+	req, ok := testutil.RequireReceive(t, reqCh, testTimeout)
+	require.True(t, ok)
+
+	assert.Equal(t, "/bot123:abc/sendMessage", req.path)
+	assert.JSONEq(
+		t,
+		`{"chat_id":"-100","text":"AdGuard Home: upstream_outage: filter \"test\" failed"}`,
+		string(req.body),
+	)
+}
+
+func TestNotifier_syslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	n := newTestNotifier(t, &notify.ChannelConfig{
+		URL:      &url.URL{Scheme: "udp", Host: conn.LocalAddr().String()},
+		Name:     "syslog",
+		Type:     notify.ChannelSyslog,
+		Template: "{{.Message}}",
+	})
+
+	n.Notify(newTestEvent(notify.EventCertificateExpiry))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+	buf := make([]byte, 1024)
+	l, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		`<28>1 2023-01-01T00:00:00.000000Z test-host AdGuardHome - certificate_expiry - `+
+			`filter "test" failed`,
+		string(buf[:l]),
+	)
+}
+
+func TestNotifier_command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test that requires a posix shell")
+	}
+
+	outFile := filepath.Join(t.TempDir(), "event.json")
+	n := newTestNotifier(t, &notify.ChannelConfig{
+		Name:      "command",
+		Type:      notify.ChannelCommand,
+		Command:   []string{"sh", "-c", `cat >> "$0"`, outFile},
+		RateLimit: time.Hour,
+	})
+
+	e := newTestEvent(notify.EventUpstreamOutage)
+	n.Notify(e)
+
+	// Dropped due to the rate limit.
+	n.Notify(newTestEvent(notify.EventFilterUpdateFailed))
+
+	var data []byte
+	require.Eventually(t, func() (ok bool) {
+		var err error
+		data, err = os.ReadFile(outFile)
+
+		return err == nil && len(data) > 0
+	}, testTimeout, testTimeout/10)
+
+	got := &notify.Event{}
+	require.NoError(t, json.Unmarshal(data, got))
+
+	assert.Equal(t, e, got)
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		channel    *notify.ChannelConfig
+		name       string
+		wantErrMsg string
+	}{{
+		channel:    &notify.ChannelConfig{Type: "pager"},
+		name:       "bad_type",
+		wantErrMsg: `channel at index 0: bad type "pager"`,
+	}, {
+		channel: &notify.ChannelConfig{
+			URL:  &url.URL{Scheme: "ftp", Host: "hook.example"},
+			Type: notify.ChannelWebhook,
+		},
+		name:       "bad_webhook_scheme",
+		wantErrMsg: `channel at index 0: url: bad scheme "ftp"`,
+	}, {
+		channel: &notify.ChannelConfig{
+			Type:   notify.ChannelTelegram,
+			ChatID: "1",
+		},
+		name:       "no_bot_token",
+		wantErrMsg: "channel at index 0: bot_token: no value",
+	}, {
+		channel: &notify.ChannelConfig{
+			Email: &notify.EmailConfig{
+				Addr: "smtp.example:587",
+				From: "agh@example.org",
+			},
+			Type: notify.ChannelEmail,
+		},
+		name:       "no_recipients",
+		wantErrMsg: "channel at index 0: email: to: no value",
+	}, {
+		channel: &notify.ChannelConfig{
+			URL:  &url.URL{Scheme: "http", Host: "192.0.2.1:514"},
+			Type: notify.ChannelSyslog,
+		},
+		name:       "bad_syslog_scheme",
+		wantErrMsg: `channel at index 0: url: bad scheme "http"`,
+	}, {
+		channel: &notify.ChannelConfig{
+			URL:    &url.URL{Scheme: "udp", Host: "192.0.2.1:514"},
+			Type:   notify.ChannelSyslog,
+			Events: []notify.EventType{"reboot"},
+		},
+		name:       "bad_event",
+		wantErrMsg: `channel at index 0: events: bad event type "reboot"`,
+	}, {
+		channel: &notify.ChannelConfig{
+			URL:      &url.URL{Scheme: "udp", Host: "192.0.2.1:514"},
+			Type:     notify.ChannelSyslog,
+			Template: "{{.Message",
+		},
+		name:       "bad_template",
+		wantErrMsg: "channel at index 0: template: template: unnamed:1: unclosed action",
+	}, {
+		channel:    &notify.ChannelConfig{Type: notify.ChannelCommand},
+		name:       "no_command",
+		wantErrMsg: "channel at index 0: command: no value",
+	}, {
+		channel: &notify.ChannelConfig{
+			Type:      notify.ChannelCommand,
+			Command:   []string{"true"},
+			RateLimit: -time.Second,
+		},
+		name:       "bad_rate_limit",
+		wantErrMsg: "channel at index 0: rate limit: must not be negative, got -1s",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := notify.New(&notify.Config{
+				Channels: []*notify.ChannelConfig{tc.channel},
+			})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+// serveSMTP accepts a single connection on l and handles it as a minimal
+// SMTP server without any extensions.  The received message data is sent to
+// dataCh.
+func serveSMTP(l net.Listener, dataCh chan<- string) {
+	pt := testutil.PanicT{}
+
+	conn, err := l.Accept()
+	require.NoError(pt, err)
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) {
+		_, wErr := io.WriteString(conn, s+"\r\n")
+		require.NoError(pt, wErr)
+	}
+
+	reply("220 smtp.example ESMTP")
+	for {
+		line, rErr := r.ReadString('\n')
+		if rErr != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 smtp.example")
+		case cmd == "DATA":
+			reply("354 go ahead")
+
+			data := &strings.Builder{}
+			for {
+				line, rErr = r.ReadString('\n')
+				require.NoError(pt, rErr)
+
+				if line == ".\r\n" {
+					break
+				}
+
+				data.WriteString(line)
+			}
+
+			dataCh <- data.String()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestNotifier_email(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, l.Close)
+
+	dataCh := make(chan string, 1)
+	go serveSMTP(l, dataCh)
+
+	n := newTestNotifier(t, &notify.ChannelConfig{
+		Email: &notify.EmailConfig{
+			Addr: l.Addr().String(),
+			From: "agh@example.org",
+			To:   []string{"admin@example.org", "ops@example.org"},
+		},
+		Name:     "email",
+		Type:     notify.ChannelEmail,
+		Template: "{{.Message}}\nurl: {{index .Details \"url\"}}",
+	})
+
+	n.Notify(newTestEvent(notify.EventFilterUpdateFailed))
+
+	data, ok := testutil.RequireReceive(t, dataCh, testTimeout)
+	require.True(t, ok)
+
+	assert.Contains(t, data, "To: admin@example.org, ops@example.org\r\n")
+	assert.Contains(t, data, "Subject: AdGuard Home: filter_update_failed\r\n")
+	assert.True(t, strings.HasSuffix(
+		data,
+		"\r\n\r\nfilter \"test\" failed\r\nurl: https://filter.example\r\n",
+	))
+}
@@ -0,0 +1,373 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+)
+
+// sender sends the messages through a channel.
+type sender interface {
+	// send sends msg rendered for e.  It returns an error if the message
+	// hasn't been delivered.
+	send(ctx context.Context, e *Event, msg []byte) (err error)
+}
+
+// webhookSender sends the messages in the bodies of the POST requests.
+type webhookSender struct {
+	// client is used to send the requests.
+	client *http.Client
+
+	// url is the address of the webhook.
+	url *url.URL
+}
+
+// type check
+var _ sender = (*webhookSender)(nil)
+
+// send implements the [sender] interface for *webhookSender.  The content type
+// is JSON if msg is a valid JSON document and plain text otherwise.
+func (s *webhookSender) send(ctx context.Context, _ *Event, msg []byte) (err error) {
+	ct := aghhttp.HdrValTextPlain
+	if json.Valid(msg) {
+		ct = aghhttp.HdrValApplicationJSON
+	}
+
+	return post(ctx, s.client, s.url, ct, msg)
+}
+
+// commandSender sends the messages by running a command with the message on
+// its standard input.
+type commandSender struct {
+	// argv is the executable and its arguments.
+	argv []string
+}
+
+// type check
+var _ sender = (*commandSender)(nil)
+
+// send implements the [sender] interface for *commandSender.
+func (s *commandSender) send(ctx context.Context, _ *Event, msg []byte) (err error) {
+	// Don't wrap the error, because it's informative enough as is.
+	return aghos.RunHook(ctx, s.argv, msg)
+}
+
+// telegramSender sends the messages through the Telegram Bot API.
+type telegramSender struct {
+	// client is used to send the requests.
+	client *http.Client
+
+	// url is the address of the sendMessage method of the bot.  It contains
+	// the token, so it must not be logged.
+	url *url.URL
+
+	// chatID is the ID of the chat, to which the messages are sent.
+	chatID string
+}
+
+// type check
+var _ sender = (*telegramSender)(nil)
+
+// telegramMessage is the request to the sendMessage method of the Telegram Bot
+// API.
+type telegramMessage struct {
+	ChatID string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// send implements the [sender] interface for *telegramSender.
+func (s *telegramSender) send(ctx context.Context, _ *Event, msg []byte) (err error) {
+	data, err := json.Marshal(&telegramMessage{
+		ChatID: s.chatID,
+		Text:   string(msg),
+	})
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+
+	err = post(ctx, s.client, s.url, aghhttp.HdrValApplicationJSON, data)
+
+	// Don't expose the token from the URL.
+	urlErr := &url.Error{}
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("sending request: %w", urlErr.Err)
+	}
+
+	return err
+}
+
+// post sends data to u in the body of a POST request with the content type
+// ct.
+func post(
+	ctx context.Context,
+	cli *http.Client,
+	u *url.URL,
+	ct string,
+	data []byte,
+) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, ct)
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		// Don't wrap the error, because the callers may need to inspect it.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	// Drain the body so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// emailSender sends the messages by email through an SMTP server.
+type emailSender struct {
+	// conf is the configuration of the server and the addresses.
+	conf *EmailConfig
+
+	// host is the hostname of the SMTP server.
+	host string
+
+	// hostname is the name of the local host sent in the HELO command.
+	hostname string
+}
+
+// type check
+var _ sender = (*emailSender)(nil)
+
+// newEmailSender returns a new email sender for conf.
+func newEmailSender(conf *EmailConfig, hostname string) (s *emailSender, err error) {
+	if conf == nil {
+		return nil, errors.Error("email: no value")
+	}
+
+	host, _, err := net.SplitHostPort(conf.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("email: addr: %w", err)
+	}
+
+	switch {
+	case conf.From == "":
+		return nil, errors.Error("email: from: no value")
+	case len(conf.To) == 0:
+		return nil, errors.Error("email: to: no value")
+	}
+
+	return &emailSender{
+		conf:     conf,
+		host:     host,
+		hostname: hostname,
+	}, nil
+}
+
+// send implements the [sender] interface for *emailSender.
+func (s *emailSender) send(ctx context.Context, e *Event, msg []byte) (err error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.conf.Addr)
+	if err != nil {
+		return fmt.Errorf("dialing: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return errors.WithDeferred(fmt.Errorf("setting deadline: %w", err), conn.Close())
+		}
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return errors.WithDeferred(fmt.Errorf("connecting: %w", err), conn.Close())
+	}
+	defer func() { err = errors.WithDeferred(err, c.Close()) }()
+
+	err = s.hello(c)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = c.Mail(s.conf.From)
+	if err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+
+	for _, to := range s.conf.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return fmt.Errorf("recipient %q: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("starting data: %w", err)
+	}
+
+	_, err = w.Write(s.message(e, msg))
+	if err != nil {
+		return errors.WithDeferred(fmt.Errorf("writing data: %w", err), w.Close())
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("finishing data: %w", err)
+	}
+
+	return c.Quit()
+}
+
+// hello greets the server, starts TLS if the server supports it, and
+// authenticates, if configured.
+func (s *emailSender) hello(c *smtp.Client) (err error) {
+	if s.hostname != "" {
+		err = c.Hello(s.hostname)
+		if err != nil {
+			return fmt.Errorf("hello: %w", err)
+		}
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: s.host})
+		if err != nil {
+			return fmt.Errorf("starting tls: %w", err)
+		}
+	}
+
+	if s.conf.Username == "" {
+		return nil
+	}
+
+	err = c.Auth(smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.host))
+	if err != nil {
+		return fmt.Errorf("authenticating: %w", err)
+	}
+
+	return nil
+}
+
+// message returns the email message with the body msg for e.
+func (s *emailSender) message(e *Event, msg []byte) (data []byte) {
+	b := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(b, "From: %s\r\n", s.conf.From)
+	_, _ = fmt.Fprintf(b, "To: %s\r\n", strings.Join(s.conf.To, ", "))
+	_, _ = fmt.Fprintf(b, "Subject: AdGuard Home: %s\r\n", e.Type)
+	_, _ = fmt.Fprintf(b, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	_, _ = b.WriteString("MIME-Version: 1.0\r\n")
+	_, _ = b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	_, _ = b.Write(bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n")))
+	_, _ = b.WriteString("\r\n")
+
+	return b.Bytes()
+}
+
+// syslogPriority is the priority of the syslog messages: the daemon facility
+// with the warning severity, see RFC 5424.
+const syslogPriority = 3*8 + 4
+
+// syslogSender sends the messages to a remote syslog server.  The messages are
+// formatted according to RFC 5424 and framed using the octet counting for TCP,
+// see RFC 6587.
+type syslogSender struct {
+	// network is either "udp" or "tcp".
+	network string
+
+	// addr is the address of the syslog server.
+	addr string
+
+	// hostname is the HOSTNAME field of the messages.
+	hostname string
+}
+
+// type check
+var _ sender = (*syslogSender)(nil)
+
+// newSyslogSender returns a new syslog sender for the server at u.
+func newSyslogSender(u *url.URL, hostname string) (s *syslogSender, err error) {
+	if u == nil {
+		return nil, errors.Error("url: no value")
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		// Go on.
+	default:
+		return nil, fmt.Errorf("url: bad scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, errors.Error("url: no host")
+	}
+
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogSender{
+		network:  u.Scheme,
+		addr:     u.Host,
+		hostname: hostname,
+	}, nil
+}
+
+// send implements the [sender] interface for *syslogSender.
+func (s *syslogSender) send(ctx context.Context, e *Event, msg []byte) (err error) {
+	b := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(
+		b,
+		"<%d>1 %s %s AdGuardHome - %s - ",
+		syslogPriority,
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname,
+		e.Type,
+	)
+	_, _ = b.Write(msg)
+
+	data := b.Bytes()
+	if s.network == "tcp" {
+		data = append([]byte(strconv.Itoa(len(data))+" "), data...)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("dialing: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return fmt.Errorf("setting deadline: %w", err)
+		}
+	}
+
+	_, err = conn.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import "github.com/AdguardTeam/AdGuardHome/internal/whois"
 type Client struct {
 	WHOIS          *whois.Info `json:"whois,omitempty"`
 	Name           string      `json:"name"`
+	DeviceType     string      `json:"device_type,omitempty"`
 	DisallowedRule string      `json:"disallowed_rule"`
 	Disallowed     bool        `json:"disallowed"`
 	IgnoreQueryLog bool        `json:"-"`
@@ -145,6 +145,16 @@ var logEntryHandlers = map[string]logEntryHandler{
 
 		return nil
 	},
+	"DNSSECErr": func(t json.Token, ent *logEntry) error {
+		v, ok := t.(string)
+		if !ok {
+			return nil
+		}
+
+		ent.DNSSECError = v
+
+		return nil
+	},
 	"Upstream": func(t json.Token, ent *logEntry) error {
 		v, ok := t.(string)
 		if !ok {
@@ -172,6 +182,23 @@ var logEntryHandlers = map[string]logEntryHandler{
 	},
 }
 
+// logEntryDecHandlers is the map of log entry decode handlers for the keys with
+// composite values.
+var logEntryDecHandlers = map[string]func(dec *json.Decoder, ent *logEntry){
+	"CGeo": func(dec *json.Decoder, ent *logEntry) {
+		err := dec.Decode(&ent.ClientGeo)
+		if err != nil {
+			log.Debug("decodeLogEntry: decoding client geo: %s", err)
+		}
+	},
+	"AGeo": func(dec *json.Decoder, ent *logEntry) {
+		err := dec.Decode(&ent.AnswerGeo)
+		if err != nil {
+			log.Debug("decodeLogEntry: decoding answer geo: %s", err)
+		}
+	},
+}
+
 // decodeResultRuleKey decodes the token of "Rules" type to logEntry struct.
 func decodeResultRuleKey(key string, i int, dec *json.Decoder, ent *logEntry) {
 	var vToken json.Token
@@ -378,6 +405,38 @@ func decodeResultIPList(dec *json.Decoder, ent *logEntry) {
 	}
 }
 
+// decodeResultCategories parses the dec's tokens into the domain categories of
+// ent.
+func decodeResultCategories(dec *json.Decoder, ent *logEntry) {
+	for {
+		itemToken, err := dec.Token()
+		if err != nil {
+			if err != io.EOF {
+				log.Debug("decodeResultCategories err: %s", err)
+			}
+
+			return
+		}
+
+		switch v := itemToken.(type) {
+		case json.Delim:
+			if v == '[' {
+				continue
+			} else if v == ']' {
+				return
+			}
+
+			log.Debug("decodeResultCategories: unexpected delim %q", v)
+
+			return
+		case string:
+			ent.Result.Categories = append(ent.Result.Categories, v)
+		default:
+			continue
+		}
+	}
+}
+
 // decodeResultDNSRewriteResultKey decodes the token of "DNSRewriteResult" type
 // to the logEntry struct.
 func decodeResultDNSRewriteResultKey(key string, dec *json.Decoder, ent *logEntry) {
@@ -640,6 +699,7 @@ var resultDecHandlers = map[string]func(dec *json.Decoder, ent *logEntry){
 	"IPList":           decodeResultIPList,
 	"Rules":            decodeResultRules,
 	"DNSRewriteResult": decodeResultDNSRewriteResult,
+	"Categories":       decodeResultCategories,
 }
 
 // decodeLogEntry decodes string str to logEntry ent.
@@ -671,6 +731,10 @@ func decodeLogEntry(ent *logEntry, str string) {
 		if key == "Result" {
 			decodeResult(dec, ent)
 
+			continue
+		} else if decHandler, ok := logEntryDecHandlers[key]; ok {
+			decHandler(dec, ent)
+
 			continue
 		}
 
@@ -38,6 +38,8 @@ func TestDecodeLogEntry(t *testing.T) {
 			`"Answer":"` + ansStr + `",` +
 			`"Cached":true,` +
 			`"AD":true,` +
+			`"CGeo":{"Addr":"","C":"DE","ASN":64496},` +
+			`"AGeo":[{"Addr":"127.0.0.2","C":"NL"}],` +
 			`"Result":{` +
 			`"IsFiltered":true,` +
 			`"Reason":3,` +
@@ -64,6 +66,14 @@ func TestDecodeLogEntry(t *testing.T) {
 			ReqECS:      "1.2.3.0/24",
 			Answer:      ans,
 			Cached:      true,
+			ClientGeo: &GeoInfo{
+				Country: "DE",
+				ASN:     64496,
+			},
+			AnswerGeo: []*GeoInfo{{
+				IP:      netip.AddrFrom4([4]byte{127, 0, 0, 2}),
+				Country: "NL",
+			}},
 			Result: filtering.Result{
 				DNSRewriteResult: &filtering.DNSRewriteResult{
 					RCode: dns.RcodeSuccess,
@@ -170,6 +180,10 @@ func TestDecodeLogEntry(t *testing.T) {
 		name: "bad_reverse_hosts",
 		log:  `{"IP":"127.0.0.1","T":"2020-11-25T18:55:56.519796+03:00","QH":"an.yandex.ru","QT":"A","QC":"IN","CP":"","Answer":"Qz+BgAABAAEAAAAAAmFuBnlhbmRleAJydQAAAQABwAwAAQABAAAACgAEAAAAAA==","Result":{"IsFiltered":true,"Reason":3,"ReverseHosts":[{}]},"Elapsed":837429}`,
 		want: "decodeResultReverseHosts: unexpected delim \"{\"\n",
+	}, {
+		name: "bad_geo",
+		log:  `{"IP":"127.0.0.1","T":"2020-11-25T18:55:56.519796+03:00","QH":"an.yandex.ru","QT":"A","QC":"IN","CP":"","CGeo":{"Addr":"bad"},"Elapsed":837429}`,
+		want: "decodeLogEntry: decoding client geo: ParseAddr(\"bad\"): unable to parse IP\n",
 	}, {
 		name: "bad_ip_list",
 		log:  `{"IP":"127.0.0.1","T":"2020-11-25T18:55:56.519796+03:00","QH":"an.yandex.ru","QT":"A","QC":"IN","CP":"","Answer":"Qz+BgAABAAEAAAAAAmFuBnlhbmRleAJydQAAAQABwAwAAQABAAAACgAEAAAAAA==","Result":{"IsFiltered":true,"Reason":3,"ReverseHosts":["example.net"],"IPList":[{}]},"Elapsed":837429}`,
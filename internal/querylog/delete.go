@@ -0,0 +1,191 @@
+package querylog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// deleteParams are the parameters of the scoped deletion of the log entries.
+// An entry is deleted if it matches all the set parameters.
+type deleteParams struct {
+	// from, if not zero, is the earliest time of the entries to delete,
+	// inclusive.
+	from time.Time
+
+	// to, if not zero, is the latest time of the entries to delete,
+	// exclusive.
+	to time.Time
+
+	// client, if not empty, is the IP address or the ClientID of the client,
+	// which entries to delete.
+	client string
+
+	// domain, if not empty, is the domain name, which entries to delete
+	// together with the ones of its subdomains.  It must be lowercased.
+	domain string
+}
+
+// validate returns an error if p doesn't limit the deletion in any way or if
+// the time range is invalid.
+func (p *deleteParams) validate() (err error) {
+	if p.from.IsZero() && p.to.IsZero() && p.client == "" && p.domain == "" {
+		return errors.Error("no criteria specified")
+	}
+
+	if !p.from.IsZero() && !p.to.IsZero() && !p.from.Before(p.to) {
+		return fmt.Errorf("from %s is not before to %s", p.from, p.to)
+	}
+
+	return nil
+}
+
+// match returns true if e matches all the set parameters.
+func (p *deleteParams) match(e *logEntry) (ok bool) {
+	if !p.from.IsZero() && e.Time.Before(p.from) {
+		return false
+	}
+
+	if !p.to.IsZero() && !e.Time.Before(p.to) {
+		return false
+	}
+
+	if p.client != "" && p.client != e.ClientID && p.client != e.IP.String() {
+		return false
+	}
+
+	if p.domain != "" {
+		host := strings.ToLower(strings.TrimSuffix(e.QHost, "."))
+		if host != p.domain && !netutil.IsSubdomain(host, p.domain) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// deleteEntries deletes the entries matching p from the memory buffer and the
+// log files.  deleted is the number of the deleted entries.
+func (l *queryLog) deleteEntries(p *deleteParams) (deleted int, err error) {
+	l.fileFlushLock.Lock()
+	defer l.fileFlushLock.Unlock()
+
+	deleted = l.deleteFromBuffer(p)
+
+	l.fileWriteLock.Lock()
+	defer l.fileWriteLock.Unlock()
+
+	for _, fileName := range []string{l.logFile + ".1", l.logFile} {
+		var n int
+		n, err = l.deleteFromFile(fileName, p)
+		if err != nil {
+			return deleted, fmt.Errorf("deleting from %q: %w", fileName, err)
+		}
+
+		deleted += n
+	}
+
+	log.Debug("querylog: deleted %d entries", deleted)
+
+	return deleted, nil
+}
+
+// deleteFromBuffer deletes the entries matching p from the memory buffer and
+// returns the number of the deleted ones.
+func (l *queryLog) deleteFromBuffer(p *deleteParams) (deleted int) {
+	l.bufferLock.Lock()
+	defer l.bufferLock.Unlock()
+
+	kept := make([]*logEntry, 0, l.buffer.Len())
+	l.buffer.Range(func(e *logEntry) (cont bool) {
+		if !p.match(e) {
+			kept = append(kept, e)
+		}
+
+		return true
+	})
+
+	deleted = l.buffer.Len() - len(kept)
+	if deleted == 0 {
+		return 0
+	}
+
+	l.buffer.Clear()
+	for _, e := range kept {
+		l.buffer.Append(e)
+	}
+
+	return deleted
+}
+
+// deleteFromFile rewrites the log file without the entries matching p and
+// returns the number of the deleted ones.  The lines which can't be decrypted
+// are kept as is.  A missing file is not an error.
+func (l *queryLog) deleteFromFile(fileName string, p *deleteParams) (deleted int, err error) {
+	src, err := os.Open(fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		// Don't wrap the error since it's informative enough as is.
+		return 0, err
+	}
+	defer func() { err = errors.WithDeferred(err, src.Close()) }()
+
+	dst, err := aghrenameio.NewPendingFile(fileName, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, dst) }()
+
+	r := bufio.NewReader(src)
+	w := bufio.NewWriter(dst)
+	for {
+		var line string
+		line, err = r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("reading: %w", err)
+		}
+
+		if trimmed := strings.TrimSuffix(line, "\n"); trimmed != "" {
+			if l.isDeletedLine(trimmed, p) {
+				deleted++
+			} else if _, err = w.WriteString(trimmed + "\n"); err != nil {
+				return 0, fmt.Errorf("writing: %w", err)
+			}
+		}
+
+		if line == "" || line[len(line)-1] != '\n' {
+			break
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return 0, fmt.Errorf("flushing: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// isDeletedLine returns true if line contains the log entry matching p.
+func (l *queryLog) isDeletedLine(line string, p *deleteParams) (ok bool) {
+	decrypted := decryptLine(l.cipher, line)
+	if decrypted == "" {
+		return false
+	}
+
+	e := &logEntry{}
+	decodeLogEntry(e, decrypted)
+
+	return p.match(e)
+}
@@ -2,8 +2,12 @@ package querylog
 
 import (
 	"net"
+	"net/netip"
 	"time"
 
+	"strconv"
+	"strings"
+
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
@@ -33,14 +37,72 @@ type logEntry struct {
 
 	IP net.IP `json:"IP"`
 
+	// ClientGeo is the geolocation of the client, if known.
+	ClientGeo *GeoInfo `json:"CGeo,omitempty"`
+
+	// AnswerGeo are the geolocations of the IP addresses in the answer, if
+	// known.
+	AnswerGeo []*GeoInfo `json:"AGeo,omitempty"`
+
 	Result filtering.Result
 
 	Elapsed time.Duration
 
+	DNSSECError string `json:"DNSSECErr,omitempty"`
+
 	Cached            bool `json:",omitempty"`
 	AuthenticatedData bool `json:"AD,omitempty"`
 }
 
+// GeoInfo is the geolocation of an IP address.
+type GeoInfo struct {
+	// IP is the IP address.  It's not set for the geolocation of the client,
+	// since it's already known.
+	IP netip.Addr `json:"Addr"`
+
+	// Country is the uppercase ISO 3166-1 alpha-2 code of the country, if
+	// known.
+	Country string `json:"C,omitempty"`
+
+	// ASN is the number of the autonomous system, if known.
+	ASN uint32 `json:"ASN,omitempty"`
+}
+
+// matchGeo returns true if g matches the geolocation search term, which is
+// either a lowercased "asn:" followed by the number or "country:" followed by
+// the uppercase country code.
+func (g *GeoInfo) matchGeo(term string) (ok bool) {
+	if g == nil {
+		return false
+	}
+
+	if cc, isCountry := strings.CutPrefix(term, geoTermCountry); isCountry {
+		return g.Country != "" && g.Country == cc
+	}
+
+	asn, isASN := strings.CutPrefix(term, geoTermASN)
+
+	return isASN && g.ASN != 0 && strconv.FormatUint(uint64(g.ASN), 10) == asn
+}
+
+// toJSON returns the JSON API representation of g.
+func (g *GeoInfo) toJSON() (res jobject) {
+	res = jobject{}
+	if g.IP.IsValid() {
+		res["ip"] = g.IP
+	}
+
+	if g.Country != "" {
+		res["country"] = g.Country
+	}
+
+	if g.ASN != 0 {
+		res["asn"] = g.ASN
+	}
+
+	return res
+}
+
 // shallowClone returns a shallow clone of e.
 func (e *logEntry) shallowClone() (clone *logEntry) {
 	cloneVal := *e
@@ -14,10 +14,8 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
-	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/golibs/timeutil"
-	"golang.org/x/net/idna"
 )
 
 // configJSON is the JSON structure for the querylog configuration.
@@ -56,10 +54,36 @@ type getConfigResp struct {
 	AnonymizeClientIP aghalg.NullBool `json:"anonymize_client_ip"`
 }
 
+// deleteReq is the JSON structure of the request to delete the log entries.
+type deleteReq struct {
+	// From, if not zero, is the earliest time of the entries to delete,
+	// inclusive.
+	From time.Time `json:"from"`
+
+	// To, if not zero, is the latest time of the entries to delete, exclusive.
+	To time.Time `json:"to"`
+
+	// Client, if not empty, is the IP address or the ClientID of the client,
+	// which entries to delete.
+	Client string `json:"client"`
+
+	// Domain, if not empty, is the domain name, which entries to delete
+	// together with the ones of its subdomains.
+	Domain string `json:"domain"`
+}
+
+// deleteResp is the JSON structure of the response to the request to delete
+// the log entries.
+type deleteResp struct {
+	// Deleted is the number of the deleted entries.
+	Deleted int `json:"deleted"`
+}
+
 // Register web handlers
 func (l *queryLog) initWeb() {
 	l.conf.HTTPRegister(http.MethodGet, "/control/querylog", l.handleQueryLog)
 	l.conf.HTTPRegister(http.MethodPost, "/control/querylog_clear", l.handleQueryLogClear)
+	l.conf.HTTPRegister(http.MethodPost, "/control/querylog/delete", l.handleQueryLogDelete)
 	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/config", l.handleGetQueryLogConfig)
 	l.conf.HTTPRegister(
 		http.MethodPut,
@@ -101,6 +125,42 @@ func (l *queryLog) handleQueryLogClear(_ http.ResponseWriter, _ *http.Request) {
 	l.clear()
 }
 
+// handleQueryLogDelete is the handler for the POST /control/querylog/delete
+// HTTP API.  It deletes only the entries matching all the criteria from the
+// request.
+func (l *queryLog) handleQueryLogDelete(w http.ResponseWriter, r *http.Request) {
+	req := &deleteReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	p := &deleteParams{
+		from:   req.From,
+		to:     req.To,
+		client: strings.TrimSpace(req.Client),
+		domain: strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Domain), ".")),
+	}
+
+	err = p.validate()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "%s", err)
+
+		return
+	}
+
+	deleted, err := l.deleteEntries(p)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "deleting entries: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &deleteResp{Deleted: deleted})
+}
+
 // handleQueryLogInfo is the handler for the GET /control/querylog_info HTTP
 // API.
 //
@@ -292,17 +352,17 @@ func parseSearchCriterion(q url.Values, name string, ct criterionType) (
 
 	strict := getDoubleQuotesEnclosedValue(&val)
 
-	var asciiVal string
+	var asciiVal, geoTerm string
 	switch ct {
 	case ctTerm:
-		// Decode lowercased value from punycode to make EqualFold and
+		geoTerm = normalizeGeoTerm(val)
+
+		// Encode lowercased value into punycode to make EqualFold and
 		// friends work properly with IDNAs.
 		//
-		// TODO(e.burkov):  Make it work with parts of IDNAs somehow.
+		// TODO(e.burkov):  Make it work with parts of IDNA labels somehow.
 		loweredVal := strings.ToLower(val)
-		if asciiVal, err = idna.ToASCII(loweredVal); err != nil {
-			log.Debug("can't convert %q to ascii: %s", val, err)
-		} else if asciiVal == loweredVal {
+		if asciiVal = aghnet.NormalizeDomain(loweredVal); asciiVal == loweredVal {
 			// Purge asciiVal to prevent checking the same value
 			// twice.
 			asciiVal = ""
@@ -323,6 +383,7 @@ func parseSearchCriterion(q url.Values, name string, ct criterionType) (
 		criterionType: ct,
 		value:         val,
 		asciiVal:      asciiVal,
+		geoTerm:       geoTerm,
 		strict:        strict,
 	}
 
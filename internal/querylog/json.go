@@ -85,6 +85,23 @@ func entryToJSON(entry *logEntry, anonFunc aghnet.IPMutFunc) (jsonEntry jobject)
 		jsonEntry["ecs"] = entry.ReqECS
 	}
 
+	if entry.DNSSECError != "" {
+		jsonEntry["dnssec_error"] = entry.DNSSECError
+	}
+
+	if entry.ClientGeo != nil {
+		jsonEntry["client_geo"] = entry.ClientGeo.toJSON()
+	}
+
+	if len(entry.AnswerGeo) > 0 {
+		answerGeo := make([]jobject, 0, len(entry.AnswerGeo))
+		for _, g := range entry.AnswerGeo {
+			answerGeo = append(answerGeo, g.toJSON())
+		}
+
+		jsonEntry["answer_geo"] = answerGeo
+	}
+
 	if len(entry.Result.Rules) > 0 {
 		if r := entry.Result.Rules[0]; len(r.Text) > 0 {
 			jsonEntry["rule"] = r.Text
@@ -96,6 +113,14 @@ func entryToJSON(entry *logEntry, anonFunc aghnet.IPMutFunc) (jsonEntry jobject)
 		jsonEntry["service_name"] = entry.Result.ServiceName
 	}
 
+	if entry.Result.CNAMEMatch != "" {
+		jsonEntry["cname_match"] = entry.Result.CNAMEMatch
+	}
+
+	if len(entry.Result.Categories) > 0 {
+		jsonEntry["categories"] = entry.Result.Categories
+	}
+
 	setMsgData(entry, jsonEntry)
 	setOrigAns(entry, jsonEntry)
 
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/errors"
@@ -37,6 +38,12 @@ type queryLog struct {
 	// logFile is the path to the log file.
 	logFile string
 
+	// cipher, if not nil, encrypts the entries written to the log files.
+	cipher *aghcrypto.Cipher
+
+	// shipper, if not nil, ships the entries to a remote collector.
+	shipper *shipper
+
 	// bufferLock protects buffer.
 	bufferLock sync.RWMutex
 
@@ -82,9 +89,17 @@ func (l *queryLog) Start() {
 	}
 
 	go l.periodicRotate()
+
+	if l.shipper != nil {
+		l.shipper.start()
+	}
 }
 
 func (l *queryLog) Close() {
+	if l.shipper != nil {
+		l.shipper.close()
+	}
+
 	l.confMu.RLock()
 	defer l.confMu.RUnlock()
 
@@ -177,8 +192,13 @@ func newLogEntry(params *AddParams) (entry *logEntry) {
 
 		IP: params.ClientIP,
 
+		ClientGeo: params.ClientGeo,
+		AnswerGeo: params.AnswerGeo,
+
 		Elapsed: params.Elapsed,
 
+		DNSSECError: params.DNSSECError,
+
 		Cached:            params.Cached,
 		AuthenticatedData: params.AuthenticatedData,
 	}
@@ -205,7 +225,7 @@ func (l *queryLog) Add(params *AddParams) {
 		memSize = l.conf.MemSize
 	}()
 
-	if !isEnabled || memSize == 0 {
+	if !isEnabled || (memSize == 0 && l.shipper == nil) {
 		return
 	}
 
@@ -222,6 +242,14 @@ func (l *queryLog) Add(params *AddParams) {
 
 	entry := newLogEntry(params)
 
+	if l.shipper != nil {
+		l.shipper.ship(entry)
+	}
+
+	if memSize == 0 {
+		return
+	}
+
 	l.bufferLock.Lock()
 	defer l.bufferLock.Unlock()
 
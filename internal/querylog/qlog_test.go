@@ -3,8 +3,11 @@ package querylog
 import (
 	"fmt"
 	"net"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/testutil"
@@ -232,6 +235,126 @@ func TestQueryLogMaxFileScanEntries(t *testing.T) {
 	}
 }
 
+func TestQueryLog_encrypted(t *testing.T) {
+	c, err := aghcrypto.NewCipher(make([]byte, aghcrypto.KeySize))
+	require.NoError(t, err)
+
+	l, err := newQueryLog(Config{
+		Cipher:      c,
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	// Write a plain entry first to make sure those are still readable.
+	l.cipher = nil
+	addEntry(l, "plain.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	require.NoError(t, l.flushLogBuffer())
+
+	l.cipher = c
+	addEntry(l, "secret.example", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	require.NoError(t, l.flushLogBuffer())
+
+	data, err := os.ReadFile(l.logFile)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(data), "plain.example")
+	assert.NotContains(t, string(data), "secret.example")
+
+	_, err = l.readFileFirstTimeValue()
+	require.NoError(t, err)
+
+	entries, _ := l.search(newSearchParams())
+	require.Len(t, entries, 2)
+
+	assertLogEntry(t, entries[0], "secret.example", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	assertLogEntry(t, entries[1], "plain.example", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+}
+
+func TestQueryLog_deleteEntries(t *testing.T) {
+	c, err := aghcrypto.NewCipher(make([]byte, aghcrypto.KeySize))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		params    *deleteParams
+		name      string
+		wantHosts []string
+		want      int
+	}{{
+		params:    &deleteParams{client: "2.2.2.3"},
+		name:      "client",
+		wantHosts: []string{"example.org", "example.org", "test.example.org", "example.org"},
+		want:      1,
+	}, {
+		params:    &deleteParams{domain: "example.org"},
+		name:      "domain",
+		wantHosts: []string{"example.com"},
+		want:      4,
+	}, {
+		params:    &deleteParams{domain: "example.org", client: "2.2.2.2"},
+		name:      "domain_and_client",
+		wantHosts: []string{"example.org", "example.com", "example.org"},
+		want:      2,
+	}, {
+		params: &deleteParams{to: time.Now().Add(-time.Hour)},
+		name:   "old",
+		wantHosts: []string{
+			"example.org",
+			"example.com",
+			"example.org",
+			"test.example.org",
+			"example.org",
+		},
+		want: 0,
+	}, {
+		params:    &deleteParams{from: time.Now().Add(-time.Hour)},
+		name:      "new",
+		wantHosts: []string{},
+		want:      5,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, lErr := newQueryLog(Config{
+				Cipher:      c,
+				Enabled:     true,
+				FileEnabled: true,
+				RotationIvl: timeutil.Day,
+				MemSize:     100,
+				BaseDir:     t.TempDir(),
+			})
+			require.NoError(t, lErr)
+
+			addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+			require.NoError(t, l.flushLogBuffer())
+			require.NoError(t, l.rotate())
+
+			addEntry(l, "test.example.org", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+			addEntry(l, "example.org", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+			require.NoError(t, l.flushLogBuffer())
+
+			addEntry(l, "example.com", net.IPv4(1, 1, 1, 3), net.IPv4(2, 2, 2, 3))
+			addEntry(l, "example.org", net.IPv4(1, 1, 1, 4), net.IPv4(2, 2, 2, 4))
+
+			deleted, dErr := l.deleteEntries(tc.params)
+			require.NoError(t, dErr)
+
+			assert.Equal(t, tc.want, deleted)
+
+			entries, _ := l.search(newSearchParams())
+			hosts := make([]string, 0, len(entries))
+			for _, e := range entries {
+				hosts = append(hosts, e.QHost)
+			}
+
+			assert.Equal(t, tc.wantHosts, hosts)
+		})
+	}
+}
+
 func TestQueryLogFileDisabled(t *testing.T) {
 	l, err := newQueryLog(Config{
 		Enabled:     true,
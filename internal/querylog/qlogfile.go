@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 )
@@ -52,16 +53,39 @@ type qLogFile struct {
 
 	// bufferLen is the length of the buffer.
 	bufferLen int
+
+	// cipher, if not nil, decrypts the encrypted lines.
+	cipher *aghcrypto.Cipher
 }
 
-// newQLogFile initializes a new instance of the qLogFile.
-func newQLogFile(path string) (qf *qLogFile, err error) {
+// newQLogFile initializes a new instance of the qLogFile.  c, if not nil, is
+// used to decrypt the encrypted lines.
+func newQLogFile(path string, c *aghcrypto.Cipher) (qf *qLogFile, err error) {
 	f, err := os.OpenFile(path, os.O_RDONLY, 0o644)
 	if err != nil {
 		return nil, err
 	}
 
-	return &qLogFile{file: f}, nil
+	return &qLogFile{file: f, cipher: c}, nil
+}
+
+// decryptLine returns line decrypted using c, if it's encrypted and c is not
+// nil.  Plain JSON lines, for example the ones written before the encryption
+// has been enabled, are returned as is.  decrypted is empty if line can't be
+// decrypted.
+func decryptLine(c *aghcrypto.Cipher, line string) (decrypted string) {
+	if c == nil || line == "" || line[0] == '{' {
+		return line
+	}
+
+	b, err := c.OpenLine([]byte(line))
+	if err != nil {
+		log.Debug("querylog: decrypting line: %s", err)
+
+		return ""
+	}
+
+	return string(b)
 }
 
 // validateQLogLineIdx returns error if the line index is not valid to continue
@@ -247,7 +271,8 @@ func (q *qLogFile) ReadNext() (string, error) {
 		// more char left from the line "\nline".
 		q.position = lineIdx - 1
 	}
-	return line, err
+
+	return decryptLine(q.cipher, line), err
 }
 
 // Close frees the underlying resources.
@@ -361,7 +386,9 @@ func (q *qLogFile) readProbeLine(position int64) (string, int64, int64, error) {
 
 	// Finally we can return the string we were looking for.
 	lineIdx := startLine + seekPosition
-	return string(buffer[startLine:endLine]), lineIdx, lineEndIdx, nil
+	line := decryptLine(q.cipher, string(buffer[startLine:endLine]))
+
+	return line, lineIdx, lineEndIdx, nil
 }
 
 // readJSONValue reads a JSON string in form of '"key":"value"'.  prefix must
@@ -80,7 +80,7 @@ func newTestQLogFile(t *testing.T, linesNum int) (file *qLogFile) {
 	testFile := prepareTestFiles(t, 1, linesNum)[0]
 
 	// Create the new qLogFile instance.
-	file, err := newQLogFile(testFile)
+	file, err := newQLogFile(testFile, nil)
 	require.NoError(t, err)
 
 	assert.NotNil(t, file)
@@ -293,7 +293,7 @@ func newTestQLogFileData(t *testing.T, data string) (file *qLogFile) {
 	_, err = f.WriteString(data)
 	require.NoError(t, err)
 
-	file, err = newQLogFile(f.Name())
+	file, err = newQLogFile(f.Name(), nil)
 	require.NoError(t, err)
 	testutil.CleanupAndRequireSuccess(t, file.Close)
 
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 )
@@ -24,12 +25,13 @@ type qLogReader struct {
 	currentFile int
 }
 
-// newQLogReader initializes a qLogReader instance with the specified files.
-func newQLogReader(files []string) (*qLogReader, error) {
+// newQLogReader initializes a qLogReader instance with the specified files.  c,
+// if not nil, is used to decrypt the encrypted lines.
+func newQLogReader(files []string, c *aghcrypto.Cipher) (*qLogReader, error) {
 	qFiles := make([]*qLogFile, 0)
 
 	for _, f := range files {
-		q, err := newQLogFile(f)
+		q, err := newQLogFile(f, c)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				continue
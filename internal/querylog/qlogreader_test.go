@@ -18,7 +18,7 @@ func newTestQLogReader(t *testing.T, filesNum, linesNum int) (reader *qLogReader
 	testFiles := prepareTestFiles(t, filesNum, linesNum)
 
 	// Create the new qLogReader instance.
-	reader, err := newQLogReader(testFiles)
+	reader, err := newQLogReader(testFiles, nil)
 	require.NoError(t, err)
 
 	assert.NotNil(t, reader)
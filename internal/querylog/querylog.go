@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
@@ -53,6 +54,14 @@ type Config struct {
 	// FindClient returns client information by their IDs.
 	FindClient func(ids []string) (c *Client, err error)
 
+	// Cipher, if not nil, is used to encrypt the log entries written to the
+	// files and to decrypt them when reading.
+	Cipher *aghcrypto.Cipher
+
+	// Ship, if not nil, is the configuration of shipping the entries to a
+	// remote collector as they are added.
+	Ship *ShipConfig
+
 	// BaseDir is the base directory for log files.
 	BaseDir string
 
@@ -103,12 +112,22 @@ type AddParams struct {
 
 	ClientIP net.IP
 
+	// ClientGeo is the geolocation of the client, if known.
+	ClientGeo *GeoInfo
+
+	// AnswerGeo are the geolocations of the IP addresses in Answer, if known.
+	AnswerGeo []*GeoInfo
+
 	// Elapsed is the time spent for processing the request.
 	Elapsed time.Duration
 
 	// Cached indicates if the response is served from cache.
 	Cached bool
 
+	// DNSSECError is the reason why the response has been considered bogus by
+	// the local DNSSEC validation, if any.
+	DNSSECError string
+
 	// AuthenticatedData shows if the response had the AD bit set.
 	AuthenticatedData bool
 }
@@ -157,6 +176,7 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		logFile: filepath.Join(conf.BaseDir, queryLogFileName),
 
 		anonymizer: conf.Anonymizer,
+		cipher:     conf.Cipher,
 	}
 
 	*l.conf = conf
@@ -166,5 +186,12 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		return nil, fmt.Errorf("unsupported interval: %w", err)
 	}
 
+	if conf.Ship != nil {
+		l.shipper, err = newShipper(conf.Ship, conf.Anonymizer, findClient)
+		if err != nil {
+			return nil, fmt.Errorf("shipping: %w", err)
+		}
+	}
+
 	return l, nil
 }
@@ -44,9 +44,22 @@ func (l *queryLog) encodeEntries() (b *bytes.Buffer, err error) {
 	e := json.NewEncoder(b)
 
 	l.buffer.Range(func(entry *logEntry) (cont bool) {
-		err = e.Encode(entry)
+		if l.cipher == nil {
+			err = e.Encode(entry)
 
-		return err == nil
+			return err == nil
+		}
+
+		var data []byte
+		data, err = json.Marshal(entry)
+		if err != nil {
+			return false
+		}
+
+		b.Write(l.cipher.SealLine(data))
+		b.WriteByte('\n')
+
+		return true
 	})
 
 	if err != nil {
@@ -88,6 +101,9 @@ func (l *queryLog) flushToFile(b *bytes.Buffer) (err error) {
 }
 
 func (l *queryLog) rotate() error {
+	l.fileWriteLock.Lock()
+	defer l.fileWriteLock.Unlock()
+
 	from := l.logFile
 	to := l.logFile + ".1"
 
@@ -116,14 +132,19 @@ func (l *queryLog) readFileFirstTimeValue() (first time.Time, err error) {
 
 	defer func() { err = errors.WithDeferred(err, f.Close()) }()
 
-	buf := make([]byte, 512)
+	buf := make([]byte, maxEntrySize)
 	var r int
 	r, err = f.Read(buf)
 	if err != nil {
 		return time.Time{}, err
 	}
 
-	val := readJSONValue(string(buf[:r]), `"T":"`)
+	line := buf[:r]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	val := readJSONValue(decryptLine(l.cipher, string(line)), `"T":"`)
 	t, err := time.Parse(time.RFC3339Nano, val)
 	if err != nil {
 		return time.Time{}, err
@@ -162,7 +162,7 @@ func (l *queryLog) setQLogReader(olderThan time.Time) (qr *qLogReader, err error
 		l.logFile,
 	}
 
-	r, err := newQLogReader(files)
+	r, err := newQLogReader(files, l.cipher)
 	if err != nil {
 		return nil, fmt.Errorf("opening qlog reader: %s", err)
 	}
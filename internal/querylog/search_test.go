@@ -2,6 +2,7 @@ package querylog
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -93,3 +94,88 @@ func TestQueryLog_Search_findClient(t *testing.T) {
 
 	assert.Equal(t, knownClientName, gotClient.Name)
 }
+
+func TestQueryLog_Search_geo(t *testing.T) {
+	l, err := newQueryLog(Config{
+		BaseDir:     t.TempDir(),
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		Enabled:     true,
+		FileEnabled: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(l.Close)
+
+	q := &dns.Msg{
+		Question: []dns.Question{{
+			Name: "example.com",
+		}},
+	}
+
+	l.Add(&AddParams{
+		Question:  q,
+		ClientIP:  net.IP{1, 2, 3, 4},
+		ClientGeo: &GeoInfo{Country: "DE", ASN: 64496},
+	})
+
+	l.Add(&AddParams{
+		Question: q,
+		ClientIP: net.IP{192, 168, 1, 2},
+		AnswerGeo: []*GeoInfo{{
+			IP:      netip.MustParseAddr("192.0.2.1"),
+			Country: "NL",
+			ASN:     64497,
+		}},
+	})
+
+	l.Add(&AddParams{
+		Question: q,
+		ClientIP: net.IP{192, 168, 1, 3},
+	})
+
+	// Flush the entries to test both the quick matching of the lines and the
+	// matching of the decoded entries.
+	err = l.flushLogBuffer()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		term    string
+		wantIPs []string
+	}{{
+		name:    "client_country",
+		term:    "country:de",
+		wantIPs: []string{"1.2.3.4"},
+	}, {
+		name:    "answer_asn",
+		term:    "ASN:AS64497",
+		wantIPs: []string{"192.168.1.2"},
+	}, {
+		name:    "no_match",
+		term:    "country:FR",
+		wantIPs: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sp := &searchParams{
+				olderThan: time.Now().Add(10 * time.Second),
+				limit:     10,
+				searchCriteria: []searchCriterion{{
+					value:         tc.term,
+					geoTerm:       normalizeGeoTerm(tc.term),
+					criterionType: ctTerm,
+				}},
+			}
+
+			entries, _ := l.search(sp)
+
+			var gotIPs []string
+			for _, e := range entries {
+				gotIPs = append(gotIPs, e.IP.String())
+			}
+
+			assert.Equal(t, tc.wantIPs, gotIPs)
+		})
+	}
+}
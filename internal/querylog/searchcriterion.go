@@ -29,6 +29,12 @@ const (
 	filteringStatusBlockedService      = "blocked_services"     // blocked
 	filteringStatusBlockedSafebrowsing = "blocked_safebrowsing" // blocked by safebrowsing
 	filteringStatusBlockedParental     = "blocked_parental"     // blocked by parental control
+	filteringStatusBlockedRebind       = "blocked_rebind"       // rejected by rebind protection
+	filteringStatusBlockedQueryType    = "blocked_query_type"   // blocked by query type policy
+	filteringStatusBlockedResponseIP   = "blocked_response_ip"  // blocked by ip blocklist
+	filteringStatusBlockedCategory     = "blocked_category"     // blocked by domain category
+	filteringStatusBlockedNotAllowed   = "blocked_not_allowed"  // blocked in allowlist-only mode
+	filteringStatusWouldBlock          = "would_block"          // matched by monitor-only filter list
 	filteringStatusWhitelisted         = "whitelisted"          // whitelisted
 	filteringStatusRewritten           = "rewritten"            // all kinds of rewrites
 	filteringStatusSafeSearch          = "safe_search"          // enforced safe search
@@ -39,14 +45,42 @@ const (
 var filteringStatusValues = []string{
 	filteringStatusAll, filteringStatusFiltered, filteringStatusBlocked,
 	filteringStatusBlockedService, filteringStatusBlockedSafebrowsing, filteringStatusBlockedParental,
+	filteringStatusBlockedRebind, filteringStatusBlockedQueryType, filteringStatusBlockedResponseIP,
 	filteringStatusWhitelisted, filteringStatusRewritten, filteringStatusSafeSearch,
-	filteringStatusProcessed,
+	filteringStatusProcessed, filteringStatusWouldBlock, filteringStatusBlockedCategory,
+	filteringStatusBlockedNotAllowed,
+}
+
+// Prefixes of the search terms matching the geolocations of the clients and
+// the answers.
+const (
+	geoTermASN     = "asn:"
+	geoTermCountry = "country:"
+)
+
+// normalizeGeoTerm returns the normalized form of the geolocation search term
+// val, which is either "asn:" followed by the number, or "country:" followed by
+// the uppercase country code.  term is empty if val isn't a geolocation term.
+func normalizeGeoTerm(val string) (term string) {
+	lower := strings.ToLower(val)
+	if cc, ok := strings.CutPrefix(lower, geoTermCountry); ok && cc != "" {
+		return geoTermCountry + strings.ToUpper(cc)
+	} else if asn, ok := strings.CutPrefix(lower, geoTermASN); ok && asn != "" {
+		return geoTermASN + strings.TrimPrefix(asn, "as")
+	}
+
+	return ""
 }
 
 // searchCriterion is a search criterion that is used to match a record.
 type searchCriterion struct {
-	value         string
-	asciiVal      string
+	value    string
+	asciiVal string
+
+	// geoTerm is the normalized geolocation search term, if the value is one.
+	// See [normalizeGeoTerm].
+	geoTerm string
+
 	criterionType criterionType
 	// strict, if true, means that the criterion must be applied to the
 	// whole value rather than the part of it.  That is, equality and not
@@ -90,6 +124,10 @@ func ctDomainOrClientCaseNonStrict(
 func (c *searchCriterion) quickMatch(line string, findClient quickMatchClientFunc) (ok bool) {
 	switch c.criterionType {
 	case ctTerm:
+		if c.geoTerm != "" {
+			return quickMatchGeo(line, c.geoTerm)
+		}
+
 		host := readJSONValue(line, `"QH":"`)
 		ip := readJSONValue(line, `"IP":"`)
 		clientID := readJSONValue(line, `"CID":"`)
@@ -113,6 +151,16 @@ func (c *searchCriterion) quickMatch(line string, findClient quickMatchClientFun
 	}
 }
 
+// quickMatchGeo quickly checks if the line may match the geolocation search
+// term.
+func quickMatchGeo(line, term string) (ok bool) {
+	if cc, isCountry := strings.CutPrefix(term, geoTermCountry); isCountry {
+		return strings.Contains(line, `"C":"`+cc+`"`)
+	}
+
+	return strings.Contains(line, `"ASN":`+strings.TrimPrefix(term, geoTermASN))
+}
+
 // match checks if the log entry matches this search criterion.
 func (c *searchCriterion) match(entry *logEntry) bool {
 	switch c.criterionType {
@@ -126,6 +174,10 @@ func (c *searchCriterion) match(entry *logEntry) bool {
 }
 
 func (c *searchCriterion) ctDomainOrClientCase(e *logEntry) bool {
+	if c.geoTerm != "" {
+		return matchGeo(e, c.geoTerm)
+	}
+
 	clientID := e.ClientID
 	host := e.QHost
 
@@ -142,6 +194,22 @@ func (c *searchCriterion) ctDomainOrClientCase(e *logEntry) bool {
 	return ctDomainOrClientCaseNonStrict(c.value, c.asciiVal, clientID, name, host, ip)
 }
 
+// matchGeo returns true if the geolocation of the client or of any of the
+// answers of e matches the normalized geolocation search term.
+func matchGeo(e *logEntry, term string) (ok bool) {
+	if e.ClientGeo.matchGeo(term) {
+		return true
+	}
+
+	for _, g := range e.AnswerGeo {
+		if g.matchGeo(term) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // ctFilteringStatusCase returns true if the result matches the value.
 func (c *searchCriterion) ctFilteringStatusCase(
 	reason filtering.Reason,
@@ -159,13 +227,20 @@ func (c *searchCriterion) ctFilteringStatusCase(
 		)
 	case
 		filteringStatusBlocked,
+		filteringStatusBlockedCategory,
+		filteringStatusBlockedNotAllowed,
 		filteringStatusBlockedParental,
+		filteringStatusBlockedQueryType,
+		filteringStatusBlockedRebind,
+		filteringStatusBlockedResponseIP,
 		filteringStatusBlockedSafebrowsing,
 		filteringStatusBlockedService,
 		filteringStatusSafeSearch:
 		return isFiltered && c.isFilteredWithReason(reason)
 	case filteringStatusWhitelisted:
 		return reason == filtering.NotFilteredAllowList
+	case filteringStatusWouldBlock:
+		return reason == filtering.NotFilteredMonitored
 	case filteringStatusRewritten:
 		return reason.In(
 			filtering.Rewritten,
@@ -187,7 +262,12 @@ func (c *searchCriterion) ctFilteringStatusCase(
 // c.value must be one of:
 //
 //   - filteringStatusBlocked
+//   - filteringStatusBlockedCategory
+//   - filteringStatusBlockedNotAllowed
 //   - filteringStatusBlockedParental
+//   - filteringStatusBlockedQueryType
+//   - filteringStatusBlockedRebind
+//   - filteringStatusBlockedResponseIP
 //   - filteringStatusBlockedSafebrowsing
 //   - filteringStatusBlockedService
 //   - filteringStatusSafeSearch
@@ -195,8 +275,18 @@ func (c *searchCriterion) isFilteredWithReason(reason filtering.Reason) (matched
 	switch c.value {
 	case filteringStatusBlocked:
 		return reason.In(filtering.FilteredBlockList, filtering.FilteredBlockedService)
+	case filteringStatusBlockedCategory:
+		return reason == filtering.FilteredCategory
+	case filteringStatusBlockedNotAllowed:
+		return reason == filtering.FilteredAllowlistOnly
 	case filteringStatusBlockedParental:
 		return reason == filtering.FilteredParental
+	case filteringStatusBlockedQueryType:
+		return reason == filtering.FilteredQueryType
+	case filteringStatusBlockedRebind:
+		return reason == filtering.FilteredRebind
+	case filteringStatusBlockedResponseIP:
+		return reason == filtering.FilteredResponseIP
 	case filteringStatusBlockedSafebrowsing:
 		return reason == filtering.FilteredSafeBrowsing
 	case filteringStatusBlockedService:
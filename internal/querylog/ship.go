@@ -0,0 +1,401 @@
+package querylog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+)
+
+// ShipFormat is the format of the entries shipped to a remote collector.
+type ShipFormat string
+
+// ShipFormat values.
+const (
+	// ShipFormatSyslog means the syslog messages formatted according to RFC
+	// 5424 with the entries as JSON objects in the message part.  For the
+	// stream transports, the messages are framed using the octet counting,
+	// see RFC 6587.
+	ShipFormatSyslog ShipFormat = "syslog"
+
+	// ShipFormatJSON means the JSON objects separated by newlines.  It's only
+	// supported by the stream transports.
+	ShipFormatJSON ShipFormat = "json"
+)
+
+// ShipConfig is the configuration of shipping the query log entries to a
+// remote collector.
+type ShipConfig struct {
+	// URL is the address of the collector.  The scheme is the transport,
+	// which is "udp", "tcp", or "tls", for example "tls://192.0.2.1:6514".
+	// It must not be nil.
+	URL *url.URL
+
+	// RootCAs are the root certificates used to verify the certificate of the
+	// collector for the "tls" transport.  If nil, the system ones are used.
+	RootCAs *x509.CertPool
+
+	// Hostname is the HOSTNAME field of the syslog messages.
+	Hostname string
+
+	// Format is the format of the shipped entries.
+	Format ShipFormat
+
+	// BufferSize is the maximum number of the entries waiting to be shipped.
+	// When it's exceeded, for example while the collector is unavailable, the
+	// new entries are dropped.  It must be positive.
+	BufferSize int
+}
+
+// Ship transports.
+const (
+	shipTransportUDP = "udp"
+	shipTransportTCP = "tcp"
+	shipTransportTLS = "tls"
+)
+
+// Shipping timeouts and limits.
+const (
+	// shipTimeout is the timeout for connecting and for writing a batch.
+	shipTimeout = 10 * time.Second
+
+	// minShipRetryIvl and maxShipRetryIvl are the bounds of the interval
+	// between the attempts to ship a batch after a failure.
+	minShipRetryIvl = 1 * time.Second
+	maxShipRetryIvl = 1 * time.Minute
+
+	// maxShipBatch is the maximum number of the entries shipped at once.
+	maxShipBatch = 100
+)
+
+// shipSyslogPriority is the priority of the syslog messages: the local0
+// facility with the informational severity, see RFC 5424.
+const shipSyslogPriority = 16*8 + 6
+
+// shipper ships the query log entries to a remote collector in the order they
+// were added.
+type shipper struct {
+	// conn is the current connection, if any.  It's only accessed from the
+	// shipping goroutine.
+	conn net.Conn
+
+	// tlsConf is the TLS configuration for the "tls" transport.
+	tlsConf *tls.Config
+
+	// anonymizer anonymizes the client IP addresses, if needed.
+	anonymizer *aghnet.IPMut
+
+	// findClient returns the information about the client.
+	findClient func(ids []string) (c *Client, err error)
+
+	// queue are the entries waiting to be shipped.
+	queue chan *logEntry
+
+	// done is closed when the shipper is closing.
+	done chan struct{}
+
+	// stopped is closed when the shipping goroutine exits.
+	stopped chan struct{}
+
+	// closeOnce makes sure that done is only closed once.
+	closeOnce *sync.Once
+
+	// dropped is the number of the entries dropped since the last report.
+	dropped *atomic.Uint64
+
+	// transport is the transport of the collector.
+	transport string
+
+	// addr is the address of the collector.
+	addr string
+
+	// hostname is the HOSTNAME field of the syslog messages.
+	hostname string
+
+	// format is the format of the shipped entries.
+	format ShipFormat
+}
+
+// newShipper returns a new properly initialized *shipper.  conf must not be
+// nil.
+func newShipper(
+	conf *ShipConfig,
+	anonymizer *aghnet.IPMut,
+	findClient func(ids []string) (c *Client, err error),
+) (s *shipper, err error) {
+	err = conf.Validate()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	if anonymizer == nil {
+		anonymizer = aghnet.NewIPMut(nil)
+	}
+
+	s = &shipper{
+		anonymizer: anonymizer,
+		findClient: findClient,
+		queue:      make(chan *logEntry, conf.BufferSize),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		closeOnce:  &sync.Once{},
+		dropped:    &atomic.Uint64{},
+		transport:  conf.URL.Scheme,
+		addr:       conf.URL.Host,
+		hostname:   conf.Hostname,
+		format:     conf.Format,
+	}
+
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+
+	if s.transport == shipTransportTLS {
+		host, _, _ := net.SplitHostPort(s.addr)
+		s.tlsConf = &tls.Config{
+			ServerName: host,
+			RootCAs:    conf.RootCAs,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return s, nil
+}
+
+// Validate returns an error if c is not valid.
+func (c *ShipConfig) Validate() (err error) {
+	if c.URL == nil {
+		return errors.Error("url: no value")
+	}
+
+	switch c.URL.Scheme {
+	case shipTransportUDP:
+		if c.Format == ShipFormatJSON {
+			return fmt.Errorf("format %q: not supported over udp", c.Format)
+		}
+	case shipTransportTCP, shipTransportTLS:
+		// Go on.
+	default:
+		return fmt.Errorf("url: bad scheme %q", c.URL.Scheme)
+	}
+
+	_, _, err = net.SplitHostPort(c.URL.Host)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+
+	switch c.Format {
+	case ShipFormatSyslog, ShipFormatJSON:
+		// Go on.
+	default:
+		return fmt.Errorf("bad format %q", c.Format)
+	}
+
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("buffer size: must be positive, got %d", c.BufferSize)
+	}
+
+	return nil
+}
+
+// start starts shipping the entries.  It must only be called once.
+func (s *shipper) start() {
+	go s.run()
+}
+
+// ship queues e for shipping.  It doesn't block, the entry is dropped if the
+// queue is full.  e must not be modified after the call.
+func (s *shipper) ship(e *logEntry) {
+	select {
+	case s.queue <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// run ships the queued entries until s is closed.  It's intended to be used
+// as a goroutine.
+func (s *shipper) run() {
+	defer log.OnPanic("querylog: shipping")
+	defer close(s.stopped)
+
+	retryIvl := minShipRetryIvl
+
+	var batch [][]byte
+	for {
+		if len(batch) == 0 {
+			select {
+			case e := <-s.queue:
+				batch = s.appendMsg(batch, e)
+			case <-s.done:
+				return
+			}
+		}
+
+		batch = s.drain(batch)
+
+		err := s.write(batch)
+		if err == nil {
+			batch = batch[:0]
+			retryIvl = minShipRetryIvl
+			s.reportDropped()
+
+			continue
+		}
+
+		log.Debug("querylog: shipping entries, retrying in %s: %s", retryIvl, err)
+
+		select {
+		case <-time.After(retryIvl):
+			retryIvl = mathutil.Min(retryIvl*2, maxShipRetryIvl)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// drain appends the messages for the already queued entries to batch without
+// waiting for new ones.
+func (s *shipper) drain(batch [][]byte) (res [][]byte) {
+	for len(batch) < maxShipBatch {
+		select {
+		case e := <-s.queue:
+			batch = s.appendMsg(batch, e)
+		default:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+// reportDropped logs the number of the dropped entries, if any.
+func (s *shipper) reportDropped() {
+	if n := s.dropped.Swap(0); n > 0 {
+		log.Info("querylog: shipping: buffer was full, dropped %d entries", n)
+	}
+}
+
+// appendMsg appends the message for e to batch.  The errors are logged.
+func (s *shipper) appendMsg(batch [][]byte, e *logEntry) (res [][]byte) {
+	msg, err := s.message(e)
+	if err != nil {
+		log.Error("querylog: shipping: formatting entry: %s", err)
+
+		return batch
+	}
+
+	return append(batch, msg)
+}
+
+// message returns the framed message for e.
+func (s *shipper) message(e *logEntry) (msg []byte, err error) {
+	e = e.shallowClone()
+	e.client, err = s.findClient([]string{e.ClientID, e.IP.String()})
+	if err != nil {
+		log.Debug("querylog: shipping: finding client: %s", err)
+	}
+
+	data, err := json.Marshal(entryToJSON(e, s.anonymizer.Load()))
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	if s.format == ShipFormatJSON {
+		return append(data, '\n'), nil
+	}
+
+	b := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(
+		b,
+		"<%d>1 %s %s AdGuardHome - query - ",
+		shipSyslogPriority,
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname,
+	)
+	_, _ = b.Write(data)
+
+	if s.transport == shipTransportUDP {
+		return b.Bytes(), nil
+	}
+
+	return append([]byte(strconv.Itoa(b.Len())+" "), b.Bytes()...), nil
+}
+
+// write writes batch into the current connection, connecting if necessary.
+// The connection is closed on errors, so that the next attempt reconnects.
+func (s *shipper) write(batch [][]byte) (err error) {
+	if s.conn == nil {
+		s.conn, err = s.dial()
+		if err != nil {
+			return fmt.Errorf("dialing: %w", err)
+		}
+	}
+
+	err = s.conn.SetWriteDeadline(time.Now().Add(shipTimeout))
+	if err == nil {
+		if s.transport == shipTransportUDP {
+			for _, msg := range batch {
+				if _, err = s.conn.Write(msg); err != nil {
+					break
+				}
+			}
+		} else {
+			bufs := net.Buffers(append([][]byte(nil), batch...))
+			_, err = bufs.WriteTo(s.conn)
+		}
+	}
+
+	if err != nil {
+		err = errors.WithDeferred(err, s.conn.Close())
+		s.conn = nil
+
+		return fmt.Errorf("writing: %w", err)
+	}
+
+	return nil
+}
+
+// dial connects to the collector.
+func (s *shipper) dial() (conn net.Conn, err error) {
+	d := &net.Dialer{Timeout: shipTimeout}
+	if s.transport == shipTransportTLS {
+		return tls.DialWithDialer(d, "tcp", s.addr, s.tlsConf)
+	}
+
+	return d.Dial(s.transport, s.addr)
+}
+
+// close stops shipping the entries and closes the connection.  The queued
+// entries are dropped.  It's safe for concurrent use and may be called
+// several times.
+func (s *shipper) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+
+	<-s.stopped
+
+	if s.conn != nil {
+		err := s.conn.Close()
+		if err != nil {
+			log.Debug("querylog: shipping: closing connection: %s", err)
+		}
+
+		s.conn = nil
+	}
+}
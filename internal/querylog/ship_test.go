@@ -0,0 +1,189 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testShipTimeout is the timeout for receiving the shipped entries in tests.
+const testShipTimeout = 5 * time.Second
+
+// newShipTestQueryLog returns a new enabled *queryLog without the memory buffer
+// shipping the entries according to conf.
+func newShipTestQueryLog(t *testing.T, conf *ShipConfig) (l *queryLog) {
+	t.Helper()
+
+	l, err := newQueryLog(Config{
+		Ship: conf,
+		FindClient: func(_ []string) (c *Client, err error) {
+			return &Client{Name: "laptop"}, nil
+		},
+		Enabled:     true,
+		RotationIvl: timeutil.Day,
+		BaseDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	l.shipper.start()
+	t.Cleanup(l.Close)
+
+	return l
+}
+
+func TestQueryLog_ship_syslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	l := newShipTestQueryLog(t, &ShipConfig{
+		URL:        &url.URL{Scheme: "udp", Host: conn.LocalAddr().String()},
+		Hostname:   "agh",
+		Format:     ShipFormatSyslog,
+		BufferSize: 10,
+	})
+
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+
+	// The memory buffer is disabled.
+	assert.Zero(t, l.buffer.Len())
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testShipTimeout)))
+
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<134>1 "), msg)
+
+	const hdrSuffix = " agh AdGuardHome - query - "
+	_, data, ok := strings.Cut(msg, hdrSuffix)
+	require.True(t, ok, msg)
+
+	entry := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(data), &entry))
+
+	assert.Equal(t, "2.2.2.1", entry["client"])
+	assert.Equal(t, "laptop", entry["client_info"].(map[string]any)["name"])
+}
+
+func TestQueryLog_ship_jsonTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, ln.Close)
+
+	l := newShipTestQueryLog(t, &ShipConfig{
+		URL:        &url.URL{Scheme: "tcp", Host: ln.Addr().String()},
+		Format:     ShipFormatJSON,
+		BufferSize: 10,
+	})
+
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(l, "example.com", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testShipTimeout)))
+
+	sc := bufio.NewScanner(conn)
+	for _, want := range []string{"example.org", "example.com"} {
+		require.True(t, sc.Scan(), sc.Err())
+
+		entry := map[string]any{}
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &entry))
+
+		assert.Equal(t, want, entry["question"].(map[string]any)["name"])
+	}
+}
+
+func TestShipper_ship_drop(t *testing.T) {
+	s, err := newShipper(&ShipConfig{
+		URL:        &url.URL{Scheme: "tcp", Host: "127.0.0.1:1"},
+		Format:     ShipFormatJSON,
+		BufferSize: 1,
+	}, nil, nil)
+	require.NoError(t, err)
+
+	s.ship(&logEntry{})
+	s.ship(&logEntry{})
+	s.ship(&logEntry{})
+
+	assert.Equal(t, uint64(2), s.dropped.Load())
+}
+
+func TestShipConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		conf       *ShipConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf: &ShipConfig{
+			URL:        &url.URL{Scheme: "tls", Host: "192.0.2.1:6514"},
+			Format:     ShipFormatJSON,
+			BufferSize: 1,
+		},
+		name:       "good",
+		wantErrMsg: "",
+	}, {
+		conf:       &ShipConfig{},
+		name:       "no_url",
+		wantErrMsg: "url: no value",
+	}, {
+		conf: &ShipConfig{
+			URL:        &url.URL{Scheme: "http", Host: "192.0.2.1:80"},
+			Format:     ShipFormatSyslog,
+			BufferSize: 1,
+		},
+		name:       "bad_scheme",
+		wantErrMsg: `url: bad scheme "http"`,
+	}, {
+		conf: &ShipConfig{
+			URL:        &url.URL{Scheme: "udp", Host: "192.0.2.1:514"},
+			Format:     ShipFormatJSON,
+			BufferSize: 1,
+		},
+		name:       "json_udp",
+		wantErrMsg: `format "json": not supported over udp`,
+	}, {
+		conf: &ShipConfig{
+			URL:        &url.URL{Scheme: "tcp", Host: "192.0.2.1"},
+			Format:     ShipFormatJSON,
+			BufferSize: 1,
+		},
+		name:       "no_port",
+		wantErrMsg: "url: address 192.0.2.1: missing port in address",
+	}, {
+		conf: &ShipConfig{
+			URL:        &url.URL{Scheme: "tcp", Host: "192.0.2.1:514"},
+			Format:     "xml",
+			BufferSize: 1,
+		},
+		name:       "bad_format",
+		wantErrMsg: `bad format "xml"`,
+	}, {
+		conf: &ShipConfig{
+			URL:    &url.URL{Scheme: "tcp", Host: "192.0.2.1:514"},
+			Format: ShipFormatSyslog,
+		},
+		name:       "bad_buffer_size",
+		wantErrMsg: "buffer size: must be positive, got 0",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.Validate())
+		})
+	}
+}
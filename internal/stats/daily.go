@@ -0,0 +1,253 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/mathutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"go.etcd.io/bbolt"
+)
+
+// maxDailyIvl is the maximum retention interval of the daily units.
+const maxDailyIvl = 5 * 365 * timeutil.Day
+
+// validateDailyIvl returns an error if ivl is neither zero nor between a day
+// and five years.
+func validateDailyIvl(ivl time.Duration) (err error) {
+	if ivl == 0 {
+		return nil
+	}
+
+	if ivl < timeutil.Day {
+		return errors.Error("less than a day")
+	}
+
+	if ivl > maxDailyIvl {
+		return errors.Error("more than five years")
+	}
+
+	return nil
+}
+
+// dailyBucketName is the name of the bucket containing the daily units.  Each
+// of them is stored in a nested bucket with the name made from the number of
+// the day since the beginning of UNIX time the same way as the names of the
+// hourly units.
+var dailyBucketName = []byte("daily")
+
+// lastMergedKey is the key of the ID of the last hourly unit merged into the
+// daily units within the daily bucket.
+var lastMergedKey = []byte("last_merged")
+
+// hoursInDay is the number of the hourly units in a daily one.
+const hoursInDay = 24
+
+// dayOf returns the ID of the daily unit containing the hourly unit with id.
+func dayOf(id uint32) (day uint32) {
+	return id / hoursInDay
+}
+
+// downsample merges the finished hourly units in tx, which haven't been
+// merged yet, into the daily units and deletes the daily units older than
+// dailyDays.  curID is the ID of the current hourly unit and hourLimit is the
+// number of the retained hourly units.  If dailyDays is zero, the daily units
+// are deleted.  tx must be writable.
+func (s *StatsCtx) downsample(tx *bbolt.Tx, curID, hourLimit, dailyDays uint32) (err error) {
+	if dailyDays == 0 {
+		err = tx.DeleteBucket(dailyBucketName)
+		if errors.Is(err, bbolt.ErrBucketNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	bkt, err := tx.CreateBucketIfNotExists(dailyBucketName)
+	if err != nil {
+		return fmt.Errorf("creating daily bucket: %w", err)
+	}
+
+	first := subClamped(curID, mathutil.Max(hourLimit, dailyDays*hoursInDay))
+	if v := bkt.Get(lastMergedKey); len(v) == 4 {
+		first = mathutil.Max(first, binary.BigEndian.Uint32(v)+1)
+	}
+
+	err = s.mergeHourly(tx, bkt, first, curID)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	if curID > 0 {
+		err = bkt.Put(lastMergedKey, binary.BigEndian.AppendUint32(nil, curID-1))
+		if err != nil {
+			return fmt.Errorf("putting last merged unit: %w", err)
+		}
+	}
+
+	return deleteOldDailyUnits(bkt, subClamped(dayOf(curID)+1, dailyDays))
+}
+
+// subClamped returns a - b or zero, if b is greater than a.
+func subClamped(a, b uint32) (res uint32) {
+	if b > a {
+		return 0
+	}
+
+	return a - b
+}
+
+// mergeHourly merges the hourly units from tx with the IDs from first up to,
+// but not including, last into the daily units in bkt.
+func (s *StatsCtx) mergeHourly(tx *bbolt.Tx, bkt *bbolt.Bucket, first, last uint32) (err error) {
+	var du *unit
+	flush := func() (ferr error) {
+		if du == nil {
+			return nil
+		}
+
+		ferr = du.serialize().flushUnitToDB(bkt, du.id, s.cipher)
+		if ferr != nil {
+			return fmt.Errorf("flushing daily unit %d: %w", du.id, ferr)
+		}
+
+		return nil
+	}
+
+	for id := first; id < last; id++ {
+		hu := loadUnitFromDB(tx, id, s.cipher)
+		if hu == nil {
+			continue
+		}
+
+		if day := dayOf(id); du == nil || du.id != day {
+			err = flush()
+			if err != nil {
+				return err
+			}
+
+			du = newUnit(day)
+			du.deserialize(loadUnitFromDB(bkt, day, s.cipher))
+		}
+
+		du.merge(hu)
+	}
+
+	return flush()
+}
+
+// deleteOldDailyUnits deletes the daily units from bkt with the IDs less than
+// firstDay.
+func deleteOldDailyUnits(bkt *bbolt.Bucket, firstDay uint32) (err error) {
+	var stale [][]byte
+	err = bkt.ForEachBucket(func(name []byte) (ferr error) {
+		if day, ok := unitNameToID(name); ok && day < firstDay {
+			stale = append(stale, bytes.Clone(name))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking daily units: %w", err)
+	}
+
+	for _, name := range stale {
+		err = bkt.DeleteBucket(name)
+		if err != nil {
+			return fmt.Errorf("deleting daily unit: %w", err)
+		}
+	}
+
+	if len(stale) > 0 {
+		log.Debug("stats: deleted %d daily units", len(stale))
+	}
+
+	return nil
+}
+
+// getDailyData returns the statistics data for the last days with the per day
+// counters.  The current day contains the data of the current hourly unit.
+func (s *StatsCtx) getDailyData(days uint32) (resp *StatsResp, ok bool) {
+	if days == 0 {
+		return s.getData(0)
+	}
+
+	units, ok := s.loadDailyUnits(days)
+	if !ok {
+		return &StatsResp{}, false
+	}
+
+	resp = s.summaryFromUnits(units)
+	resp.TimeUnits = timeUnitsDays
+	fillCollectedStatsPerUnit(resp, units)
+
+	return resp, true
+}
+
+// loadDailyUnits returns the daily units for the last days from the database,
+// the oldest first.  The missing units are empty.
+func (s *StatsCtx) loadDailyUnits(days uint32) (units []*unitDB, ok bool) {
+	db := s.db.Load()
+	if db == nil {
+		return nil, false
+	}
+
+	// Use writable transaction to ensure any ongoing writable transaction is
+	// taken into account.
+	tx, err := db.Begin(true)
+	if err != nil {
+		log.Error("stats: opening transaction: %s", err)
+
+		return nil, false
+	}
+	defer func() {
+		err = finishTxn(tx, false)
+		if err != nil {
+			log.Error("stats: %s", err)
+		}
+	}()
+
+	s.currMu.RLock()
+	defer s.currMu.RUnlock()
+
+	cur := s.curr
+
+	var curID uint32
+	if cur != nil {
+		curID = cur.id
+	} else {
+		curID = s.unitIDGen()
+	}
+
+	curDay := dayOf(curID)
+	days = mathutil.Min(days, curDay+1)
+
+	bkt := tx.Bucket(dailyBucketName)
+	units = make([]*unitDB, 0, days)
+	for day := curDay + 1 - days; day <= curDay; day++ {
+		var u *unitDB
+		if bkt != nil {
+			u = loadUnitFromDB(bkt, day, s.cipher)
+		}
+
+		if u == nil {
+			u = &unitDB{NResult: make([]uint64, resultLast)}
+		}
+
+		units = append(units, u)
+	}
+
+	if cur != nil {
+		today := newUnit(curDay)
+		today.deserialize(units[len(units)-1])
+		today.merge(cur.serialize())
+		units[len(units)-1] = today.serialize()
+	}
+
+	return units, true
+}
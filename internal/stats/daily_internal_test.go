@@ -0,0 +1,149 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCtx_downsample(t *testing.T) {
+	const dailyDays = 3
+
+	var curHour uint32
+	conf := Config{
+		ShouldCountClient: func([]string) bool { return true },
+		UnitID:            func() (id uint32) { return curHour },
+		Filename:          filepath.Join(t.TempDir(), "stats.db"),
+		Limit:             timeutil.Day,
+		DailyLimit:        dailyDays * timeutil.Day,
+		Enabled:           true,
+	}
+
+	newStats := func(t *testing.T) (s *StatsCtx) {
+		t.Helper()
+
+		s, err := New(conf)
+		require.NoError(t, err)
+
+		return s
+	}
+
+	// addHour adds n requests to the current hourly unit and flushes it by
+	// moving to the next hour.
+	addHour := func(s *StatsCtx, n int) {
+		for i := 0; i < n; i++ {
+			s.Update(&Entry{
+				Domain: "example.org",
+				Client: "127.0.0.1",
+				Result: RNotFiltered,
+			})
+		}
+
+		curHour++
+		cont, _ := s.flush()
+		require.True(t, cont)
+	}
+
+	dailyQueries := func(t *testing.T, s *StatsCtx) (queries []uint64) {
+		t.Helper()
+
+		resp, ok := s.getDailyData(dailyDays)
+		require.True(t, ok)
+
+		assert.Equal(t, timeUnitsDays, resp.TimeUnits)
+
+		return resp.DNSQueries
+	}
+
+	curHour = 22
+	s := newStats(t)
+
+	// Day 0, hours 22 and 23.
+	addHour(s, 1)
+	addHour(s, 2)
+
+	// Day 1, hour 24, and the current hour 25.
+	addHour(s, 4)
+	s.Update(&Entry{Domain: "example.org", Client: "127.0.0.1", Result: RFiltered})
+
+	assert.Equal(t, []uint64{3, 5}, dailyQueries(t, s))
+
+	t.Run("restart", func(t *testing.T) {
+		require.NoError(t, s.Close())
+
+		// The current hourly unit is loaded again and mustn't be merged twice.
+		s = newStats(t)
+		assert.Equal(t, []uint64{3, 5}, dailyQueries(t, s))
+
+		addHour(s, 0)
+		assert.Equal(t, []uint64{3, 5}, dailyQueries(t, s))
+
+		require.NoError(t, s.Close())
+
+		// The instance has been stopped for a few hours.
+		curHour += 5
+		s = newStats(t)
+		assert.Equal(t, []uint64{3, 5}, dailyQueries(t, s))
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		curHour = 4 * 24
+		cont, _ := s.flush()
+		require.True(t, cont)
+
+		addHour(s, 1)
+
+		// The days 0 and 1 have become stale, and the days 2 and 3 have no
+		// requests.
+		assert.Equal(t, []uint64{0, 0, 1}, dailyQueries(t, s))
+
+		db := s.db.Load()
+		require.NotNil(t, db)
+
+		tx, err := db.Begin(false)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, tx.Rollback()) })
+
+		bkt := tx.Bucket(dailyBucketName)
+		require.NotNil(t, bkt)
+
+		assert.Nil(t, bkt.Bucket(idToUnitName(1)))
+		assert.NotNil(t, bkt.Bucket(idToUnitName(4)))
+	})
+
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+}
+
+func TestValidateDailyIvl(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		ivl        timeutil.Duration
+	}{{
+		name:       "zero",
+		wantErrMsg: "",
+		ivl:        timeutil.Duration{},
+	}, {
+		name:       "day",
+		wantErrMsg: "",
+		ivl:        timeutil.Duration{Duration: timeutil.Day},
+	}, {
+		name:       "hour",
+		wantErrMsg: "less than a day",
+		ivl:        timeutil.Duration{Duration: timeutil.Day / 24},
+	}, {
+		name:       "too_big",
+		wantErrMsg: "more than five years",
+		ivl:        timeutil.Duration{Duration: maxDailyIvl + timeutil.Day},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, validateDailyIvl(tc.ivl.Duration))
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"go.etcd.io/bbolt"
+)
+
+// deleteParams are the parameters of the scoped deletion of the statistics.
+// Since the units don't contain the relations between the clients and the
+// domains, the client and the domain can't be set together.  Only the units
+// entirely within the time range are affected.
+type deleteParams struct {
+	// from, if not zero, is the beginning of the time range, inclusive.
+	from time.Time
+
+	// to, if not zero, is the end of the time range, exclusive.
+	to time.Time
+
+	// client, if not empty, is the IP address or the ClientID of the client,
+	// which is removed from the top clients.
+	client string
+
+	// domain, if not empty, is the domain name, which is removed from the top
+	// domains together with its subdomains.  It must be lowercased.
+	domain string
+}
+
+// validate returns an error if p doesn't limit the deletion in any way or if
+// its parameters can't be used together.
+func (p *deleteParams) validate() (err error) {
+	if p.from.IsZero() && p.to.IsZero() && p.client == "" && p.domain == "" {
+		return errors.Error("no criteria specified")
+	}
+
+	if p.client != "" && p.domain != "" {
+		return errors.Error("client and domain can't be specified together")
+	}
+
+	if !p.from.IsZero() && !p.to.IsZero() && !p.from.Before(p.to) {
+		return fmt.Errorf("from %s is not before to %s", p.from, p.to)
+	}
+
+	return nil
+}
+
+// covers returns true if the time range of p entirely contains the unit of
+// the specified duration with id.
+func (p *deleteParams) covers(id uint32, dur time.Duration) (ok bool) {
+	start := time.Unix(int64(id)*int64(dur/time.Second), 0)
+	if !p.from.IsZero() && start.Before(p.from) {
+		return false
+	}
+
+	return p.to.IsZero() || !start.Add(dur).After(p.to)
+}
+
+// isWholeUnit returns true if p deletes the whole units and not only the
+// particular clients or domains.
+func (p *deleteParams) isWholeUnit() (ok bool) {
+	return p.client == "" && p.domain == ""
+}
+
+// apply removes the client or the domain of p from u.
+func (p *deleteParams) apply(u *unit) {
+	if p.client != "" {
+		delete(u.clients, p.client)
+	}
+
+	if p.domain != "" {
+		deleteDomain(u.domains, p.domain)
+		deleteDomain(u.blockedDomains, p.domain)
+	}
+}
+
+// deleteDomain deletes domain and its subdomains from m.
+func deleteDomain(m map[string]uint64, domain string) {
+	for host := range m {
+		h := strings.ToLower(strings.TrimSuffix(host, "."))
+		if h == domain || netutil.IsSubdomain(h, domain) {
+			delete(m, host)
+		}
+	}
+}
+
+// deleteData deletes the statistics data matching p from the hourly and the
+// daily units.  The aggregate counters of the units are only changed when the
+// whole units are deleted.
+func (s *StatsCtx) deleteData(p *deleteParams) (err error) {
+	defer func() { err = errors.Annotate(err, "deleting data: %w") }()
+
+	db := s.db.Load()
+	if db == nil {
+		return errors.Error("database is closed")
+	}
+
+	s.currMu.Lock()
+	defer s.currMu.Unlock()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return fmt.Errorf("opening transaction: %w", err)
+	}
+
+	err = s.deleteFromUnits(tx, p)
+	if err != nil {
+		return errors.WithDeferred(err, finishTxn(tx, false))
+	}
+
+	err = finishTxn(tx, true)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	if cur := s.curr; cur != nil && p.covers(cur.id, time.Hour) {
+		if p.isWholeUnit() {
+			s.curr = newUnit(cur.id)
+		} else {
+			p.apply(cur)
+		}
+	}
+
+	log.Debug("stats: deleted data")
+
+	return nil
+}
+
+// deleteFromUnits deletes the data matching p from the hourly and the daily
+// units stored in tx.  tx must be writable.
+func (s *StatsCtx) deleteFromUnits(tx *bbolt.Tx, p *deleteParams) (err error) {
+	var ids []uint32
+	err = tx.ForEach(func(name []byte, _ *bbolt.Bucket) (ferr error) {
+		if bytes.Equal(name, dailyBucketName) {
+			return nil
+		}
+
+		if id, ok := unitNameToID(name); ok && p.covers(id, time.Hour) {
+			ids = append(ids, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking hourly units: %w", err)
+	}
+
+	err = s.deleteFromStore(tx, ids, p)
+	if err != nil {
+		return fmt.Errorf("hourly units: %w", err)
+	}
+
+	bkt := tx.Bucket(dailyBucketName)
+	if bkt == nil {
+		return nil
+	}
+
+	ids = ids[:0]
+	err = bkt.ForEachBucket(func(name []byte) (ferr error) {
+		if day, ok := unitNameToID(name); ok && p.covers(day, hoursInDay*time.Hour) {
+			ids = append(ids, day)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking daily units: %w", err)
+	}
+
+	err = s.deleteFromStore(bkt, ids, p)
+	if err != nil {
+		return fmt.Errorf("daily units: %w", err)
+	}
+
+	return nil
+}
+
+// bucketsStore is the common interface of the writable database transaction
+// and the bucket containing the units.
+type bucketsStore interface {
+	unitsStore
+
+	DeleteBucket(name []byte) (err error)
+}
+
+// type check
+var _ bucketsStore = (*bbolt.Tx)(nil)
+
+// type check
+var _ bucketsStore = (*bbolt.Bucket)(nil)
+
+// deleteFromStore deletes the data matching p from the units with ids stored
+// in st.  The units, which can't be loaded, are kept as is.
+func (s *StatsCtx) deleteFromStore(st bucketsStore, ids []uint32, p *deleteParams) (err error) {
+	for _, id := range ids {
+		if p.isWholeUnit() {
+			err = st.DeleteBucket(idToUnitName(id))
+			if err != nil {
+				return fmt.Errorf("deleting unit %d: %w", id, err)
+			}
+
+			continue
+		}
+
+		udb := loadUnitFromDB(st, id, s.cipher)
+		if udb == nil {
+			continue
+		}
+
+		u := newUnit(id)
+		u.deserialize(udb)
+		p.apply(u)
+
+		err = u.serialize().flushUnitToDB(st, id, s.cipher)
+		if err != nil {
+			return fmt.Errorf("flushing unit %d: %w", id, err)
+		}
+	}
+
+	return nil
+}
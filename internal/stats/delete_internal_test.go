@@ -0,0 +1,145 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCtx_deleteData(t *testing.T) {
+	const firstHour = 1000
+
+	hourStart := func(id uint32) (start time.Time) {
+		return time.Unix(int64(id)*int64(time.Hour/time.Second), 0)
+	}
+
+	testCases := []struct {
+		params      *deleteParams
+		wantClients []topAddrs
+		wantDomains []topAddrs
+		name        string
+		wantQueries uint64
+	}{{
+		params:      &deleteParams{client: "1.1.1.1"},
+		wantClients: []topAddrs{{"2.2.2.2": 2}},
+		wantDomains: []topAddrs{{"example.org": 4}, {"example.com": 2}, {"test.example.org": 1}},
+		name:        "client",
+		wantQueries: 7,
+	}, {
+		params:      &deleteParams{domain: "example.org"},
+		wantClients: []topAddrs{{"1.1.1.1": 5}, {"2.2.2.2": 2}},
+		wantDomains: []topAddrs{{"example.com": 2}},
+		name:        "domain",
+		wantQueries: 7,
+	}, {
+		params: &deleteParams{
+			domain: "example.org",
+			from:   hourStart(firstHour + 1),
+		},
+		wantClients: []topAddrs{{"1.1.1.1": 5}, {"2.2.2.2": 2}},
+		wantDomains: []topAddrs{{"example.org": 3}, {"example.com": 2}, {"test.example.org": 1}},
+		name:        "domain_in_range",
+		wantQueries: 7,
+	}, {
+		params: &deleteParams{
+			from: hourStart(firstHour + 1),
+			to:   hourStart(firstHour + 2),
+		},
+		wantClients: []topAddrs{{"1.1.1.1": 3}, {"2.2.2.2": 2}},
+		wantDomains: []topAddrs{{"example.org": 4}, {"test.example.org": 1}},
+		name:        "range",
+		wantQueries: 5,
+	}, {
+		params: &deleteParams{
+			from: hourStart(firstHour + 1),
+			to:   hourStart(firstHour + 2).Add(-time.Minute),
+		},
+		wantClients: []topAddrs{{"1.1.1.1": 5}, {"2.2.2.2": 2}},
+		wantDomains: []topAddrs{{"example.org": 4}, {"example.com": 2}, {"test.example.org": 1}},
+		name:        "partial_range",
+		wantQueries: 7,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			curHour := uint32(firstHour)
+			s, err := New(Config{
+				ShouldCountClient: func([]string) bool { return true },
+				UnitID:            func() (id uint32) { return curHour },
+				Filename:          filepath.Join(t.TempDir(), "stats.db"),
+				Limit:             timeutil.Day,
+				Enabled:           true,
+			})
+			require.NoError(t, err)
+			testutil.CleanupAndRequireSuccess(t, s.Close)
+
+			add := func(domain, client string) {
+				s.Update(&Entry{Domain: domain, Client: client, Result: RNotFiltered})
+			}
+
+			nextHour := func() {
+				curHour++
+				cont, _ := s.flush()
+				require.True(t, cont)
+			}
+
+			add("example.org", "1.1.1.1")
+			add("example.org", "1.1.1.1")
+			add("example.org", "1.1.1.1")
+			add("test.example.org", "2.2.2.2")
+			nextHour()
+
+			add("example.com", "1.1.1.1")
+			add("example.com", "1.1.1.1")
+			nextHour()
+
+			add("example.org", "2.2.2.2")
+
+			require.NoError(t, s.deleteData(tc.params))
+
+			resp, ok := s.getData(24)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.wantQueries, resp.NumDNSQueries)
+			assert.Equal(t, tc.wantClients, resp.TopClients)
+			assert.Equal(t, tc.wantDomains, resp.TopQueried)
+		})
+	}
+}
+
+func TestDeleteParams_validate(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		params     *deleteParams
+		name       string
+		wantErrMsg string
+	}{{
+		params:     &deleteParams{},
+		name:       "empty",
+		wantErrMsg: "no criteria specified",
+	}, {
+		params:     &deleteParams{client: "1.1.1.1", domain: "example.org"},
+		name:       "client_and_domain",
+		wantErrMsg: "client and domain can't be specified together",
+	}, {
+		params:     &deleteParams{from: now, to: now},
+		name:       "bad_range",
+		wantErrMsg: "from " + now.String() + " is not before to " + now.String(),
+	}, {
+		params:     &deleteParams{from: now, client: "1.1.1.1"},
+		name:       "good",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.params.validate())
+		})
+	}
+}
@@ -4,7 +4,10 @@ package stats
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
@@ -32,6 +35,27 @@ type StatsResp struct {
 	TopUpstreamsResponses []topAddrs      `json:"top_upstreams_responses"`
 	TopUpstreamsAvgTime   []topAddrsFloat `json:"top_upstreams_avg_time"`
 
+	// TopUpstreamsShare are the shares of the answers of each upstream among
+	// all the requests, from 0 to 1.
+	TopUpstreamsShare []topAddrsFloat `json:"top_upstreams_share"`
+
+	// TopBlockingRules are the rules, which have blocked the most requests.
+	TopBlockingRules []topAddrs `json:"top_blocking_rules"`
+
+	// TopFilterLists are the IDs of the filter lists, the rules of which have
+	// blocked the most requests.
+	TopFilterLists []topAddrs `json:"top_filter_lists"`
+
+	// TopQueryTypes are the numbers of requests of each type.
+	TopQueryTypes []topAddrs `json:"top_query_types"`
+
+	// TopResponseCodes are the numbers of responses with each response code.
+	TopResponseCodes []topAddrs `json:"top_response_codes"`
+
+	// TopAnswerCountries are the numbers of responses with IP addresses from
+	// each country.
+	TopAnswerCountries []topAddrs `json:"top_answer_countries"`
+
 	DNSQueries []uint64 `json:"dns_queries"`
 
 	BlockedFiltering     []uint64 `json:"blocked_filtering"`
@@ -88,6 +112,10 @@ type getConfigResp struct {
 	// Interval is the statistics rotation interval in milliseconds.
 	Interval float64 `json:"interval"`
 
+	// DailyInterval is the retention interval of the daily statistics in
+	// milliseconds.  If nil in a request, the current value is kept.
+	DailyInterval *float64 `json:"daily_interval,omitempty"`
+
 	// Enabled shows if statistics are enabled.  It is an aghalg.NullBool to be
 	// able to tell when it's set without using pointers.
 	Enabled aghalg.NullBool `json:"enabled"`
@@ -132,10 +160,12 @@ func (s *StatsCtx) handleGetStatsConfig(w http.ResponseWriter, r *http.Request)
 		s.confMu.RLock()
 		defer s.confMu.RUnlock()
 
+		dailyIvl := float64(s.dailyLimit.Milliseconds())
 		resp = &getConfigResp{
-			Ignored:  s.ignored.Values(),
-			Interval: float64(s.limit.Milliseconds()),
-			Enabled:  aghalg.BoolToNullBool(s.enabled),
+			Ignored:       s.ignored.Values(),
+			Interval:      float64(s.limit.Milliseconds()),
+			DailyInterval: &dailyIvl,
+			Enabled:       aghalg.BoolToNullBool(s.enabled),
 		}
 	}()
 
@@ -196,6 +226,17 @@ func (s *StatsCtx) handlePutStatsConfig(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	var dailyIvl time.Duration
+	if reqData.DailyInterval != nil {
+		dailyIvl = time.Duration(*reqData.DailyInterval) * time.Millisecond
+		err = validateDailyIvl(dailyIvl)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusUnprocessableEntity, "unsupported daily interval: %s", err)
+
+			return
+		}
+	}
+
 	if reqData.Enabled == aghalg.NBNull {
 		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "enabled is null")
 
@@ -210,6 +251,59 @@ func (s *StatsCtx) handlePutStatsConfig(w http.ResponseWriter, r *http.Request)
 	s.ignored = engine
 	s.limit = ivl
 	s.enabled = reqData.Enabled == aghalg.NBTrue
+	if reqData.DailyInterval != nil {
+		s.dailyLimit = dailyIvl
+	}
+}
+
+// handleStatsDaily is the handler for the GET /control/stats/daily HTTP API.
+// The optional "days" query parameter sets the number of the last days, which
+// must not exceed the retention interval of the daily statistics.  All the
+// retained days are returned by default.
+func (s *StatsCtx) handleStatsDaily(w http.ResponseWriter, r *http.Request) {
+	var days uint64
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		days, err = strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "parsing days: %s", err)
+
+			return
+		}
+	}
+
+	var (
+		resp *StatsResp
+		ok   bool
+	)
+	err := func() (err error) {
+		s.confMu.RLock()
+		defer s.confMu.RUnlock()
+
+		maxDays := uint64(s.dailyDays())
+		if days == 0 {
+			days = maxDays
+		} else if days > maxDays {
+			return fmt.Errorf("days: got %d, max %d", days, maxDays)
+		}
+
+		resp, ok = s.getDailyData(uint32(days))
+
+		return nil
+	}()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	if !ok {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "getting daily statistics")
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
 // handleStatsReset is the handler for the POST /control/stats_reset HTTP API.
@@ -220,6 +314,55 @@ func (s *StatsCtx) handleStatsReset(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// deleteReq is the request to the POST /control/stats/delete HTTP API.
+type deleteReq struct {
+	// From, if not zero, is the beginning of the time range, inclusive.
+	From time.Time `json:"from"`
+
+	// To, if not zero, is the end of the time range, exclusive.
+	To time.Time `json:"to"`
+
+	// Client, if not empty, is the IP address or the ClientID of the client to
+	// remove from the statistics.
+	Client string `json:"client"`
+
+	// Domain, if not empty, is the domain name to remove from the statistics
+	// together with its subdomains.
+	Domain string `json:"domain"`
+}
+
+// handleStatsDelete is the handler for the POST /control/stats/delete HTTP
+// API.  Unlike the POST /control/stats_reset HTTP API, it only deletes the data
+// matching the criteria from the request.
+func (s *StatsCtx) handleStatsDelete(w http.ResponseWriter, r *http.Request) {
+	req := &deleteReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	p := &deleteParams{
+		from:   req.From,
+		to:     req.To,
+		client: strings.TrimSpace(req.Client),
+		domain: strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Domain), ".")),
+	}
+
+	err = p.validate()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "%s", err)
+
+		return
+	}
+
+	err = s.deleteData(p)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "stats: %s", err)
+	}
+}
+
 // initWeb registers the handlers for web endpoints of statistics module.
 func (s *StatsCtx) initWeb() {
 	if s.httpRegister == nil {
@@ -227,7 +370,9 @@ func (s *StatsCtx) initWeb() {
 	}
 
 	s.httpRegister(http.MethodGet, "/control/stats", s.handleStats)
+	s.httpRegister(http.MethodGet, "/control/stats/daily", s.handleStatsDaily)
 	s.httpRegister(http.MethodPost, "/control/stats_reset", s.handleStatsReset)
+	s.httpRegister(http.MethodPost, "/control/stats/delete", s.handleStatsDelete)
 	s.httpRegister(http.MethodGet, "/control/stats/config", s.handleGetStatsConfig)
 	s.httpRegister(http.MethodPut, "/control/stats/config/update", s.handlePutStatsConfig)
 
@@ -23,6 +23,10 @@ func TestHandleStatsConfig(t *testing.T) {
 		maxIvl   = 365 * timeutil.Day
 	)
 
+	noDailyIvl := float64(0)
+	dailyIvl := float64(maxDailyIvl.Milliseconds())
+	bigDailyIvl := float64((maxDailyIvl + timeutil.Day).Milliseconds())
+
 	conf := Config{
 		UnitID:            func() (id uint32) { return 0 },
 		ConfigModified:    func() {},
@@ -40,9 +44,10 @@ func TestHandleStatsConfig(t *testing.T) {
 	}{{
 		name: "set_ivl_1_minIvl",
 		body: getConfigResp{
-			Enabled:  aghalg.NBTrue,
-			Interval: float64(minIvl.Milliseconds()),
-			Ignored:  []string{},
+			Enabled:       aghalg.NBTrue,
+			Interval:      float64(minIvl.Milliseconds()),
+			DailyInterval: &noDailyIvl,
+			Ignored:       []string{},
 		},
 		wantCode: http.StatusOK,
 		wantErr:  "",
@@ -67,14 +72,35 @@ func TestHandleStatsConfig(t *testing.T) {
 	}, {
 		name: "set_ignored_ivl_1_maxIvl",
 		body: getConfigResp{
-			Enabled:  aghalg.NBTrue,
-			Interval: float64(maxIvl.Milliseconds()),
+			Enabled:       aghalg.NBTrue,
+			Interval:      float64(maxIvl.Milliseconds()),
+			DailyInterval: &noDailyIvl,
 			Ignored: []string{
 				"ignor.ed",
 			},
 		},
 		wantCode: http.StatusOK,
 		wantErr:  "",
+	}, {
+		name: "set_daily_ivl",
+		body: getConfigResp{
+			Enabled:       aghalg.NBTrue,
+			Interval:      float64(minIvl.Milliseconds()),
+			DailyInterval: &dailyIvl,
+			Ignored:       []string{},
+		},
+		wantCode: http.StatusOK,
+		wantErr:  "",
+	}, {
+		name: "big_daily_interval",
+		body: getConfigResp{
+			Enabled:       aghalg.NBTrue,
+			Interval:      float64(minIvl.Milliseconds()),
+			DailyInterval: &bigDailyIvl,
+			Ignored:       []string{},
+		},
+		wantCode: http.StatusUnprocessableEntity,
+		wantErr:  "unsupported daily interval: more than five years\n",
 	}, {
 		name: "enabled_is_null",
 		body: getConfigResp{
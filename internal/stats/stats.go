@@ -3,6 +3,7 @@
 package stats
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/netip"
@@ -11,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/golibs/errors"
@@ -62,12 +64,20 @@ type Config struct {
 	// and matches them.
 	Ignored *aghnet.IgnoreEngine
 
+	// Cipher, if not nil, is used to encrypt the statistics units stored in
+	// the database and to decrypt them when reading.
+	Cipher *aghcrypto.Cipher
+
 	// Filename is the name of the database file.
 	Filename string
 
 	// Limit is an upper limit for collecting statistics.
 	Limit time.Duration
 
+	// DailyLimit is the retention interval of the daily units, into which the
+	// hourly units are downsampled.  If zero, the daily units aren't kept.
+	DailyLimit time.Duration
+
 	// Enabled tells if the statistics are enabled.
 	Enabled bool
 }
@@ -115,7 +125,7 @@ type StatsCtx struct {
 	// interface.
 	configModified func()
 
-	// confMu protects ignored, limit, and enabled.
+	// confMu protects ignored, limit, dailyLimit, and enabled.
 	confMu *sync.RWMutex
 
 	// ignored contains the list of host names, which should not be counted,
@@ -125,12 +135,18 @@ type StatsCtx struct {
 	// shouldCountClient returns client's ignore setting.
 	shouldCountClient func([]string) bool
 
+	// cipher, if not nil, encrypts the units stored in the database.
+	cipher *aghcrypto.Cipher
+
 	// filename is the name of database file.
 	filename string
 
 	// limit is an upper limit for collecting statistics.
 	limit time.Duration
 
+	// dailyLimit is the retention interval of the daily units.
+	dailyLimit time.Duration
+
 	// enabled tells if the statistics are enabled.
 	enabled bool
 }
@@ -145,6 +161,11 @@ func New(conf Config) (s *StatsCtx, err error) {
 		return nil, fmt.Errorf("unsupported interval: %w", err)
 	}
 
+	err = validateDailyIvl(conf.DailyLimit)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported daily interval: %w", err)
+	}
+
 	if conf.ShouldCountClient == nil {
 		return nil, errors.Error("should count client is unspecified")
 	}
@@ -154,11 +175,13 @@ func New(conf Config) (s *StatsCtx, err error) {
 		httpRegister:   conf.HTTPRegister,
 		configModified: conf.ConfigModified,
 		filename:       conf.Filename,
+		cipher:         conf.Cipher,
 
 		confMu:            &sync.RWMutex{},
 		ignored:           conf.Ignored,
 		shouldCountClient: conf.ShouldCountClient,
 		limit:             conf.Limit,
+		dailyLimit:        conf.DailyLimit,
 		enabled:           conf.Enabled,
 	}
 
@@ -181,10 +204,17 @@ func New(conf Config) (s *StatsCtx, err error) {
 		return nil, fmt.Errorf("stats: opening a transaction: %w", err)
 	}
 
-	deleted := deleteOldUnits(tx, id-uint32(s.limit.Hours())-1)
-	udb = loadUnitFromDB(tx, id)
+	// Downsample the units before deleting the old ones, since the instance
+	// may have been stopped for a while.
+	dsErr := s.downsample(tx, id, uint32(s.limit.Hours()), s.dailyDays())
+	if dsErr != nil {
+		log.Error("stats: downsampling: %s", dsErr)
+	}
+
+	_ = deleteOldUnits(tx, id-uint32(s.limit.Hours())-1)
+	udb = loadUnitFromDB(tx, id, s.cipher)
 
-	err = finishTxn(tx, deleted > 0)
+	err = finishTxn(tx, dsErr == nil)
 	if err != nil {
 		log.Error("stats: %s", err)
 	}
@@ -254,7 +284,7 @@ func (s *StatsCtx) Close() (err error) {
 
 	udb := s.curr.serialize()
 
-	return udb.flushUnitToDB(tx, s.curr.id)
+	return udb.flushUnitToDB(tx, s.curr.id, s.cipher)
 }
 
 // Update implements the [Interface] interface for *StatsCtx.  e must not be
@@ -293,6 +323,7 @@ func (s *StatsCtx) WriteDiskConfig(dc *Config) {
 
 	dc.Ignored = s.ignored
 	dc.Limit = s.limit
+	dc.DailyLimit = s.dailyLimit
 	dc.Enabled = s.enabled
 }
 
@@ -341,6 +372,10 @@ func deleteOldUnits(tx *bbolt.Tx, firstID uint32) (deleted int) {
 	const errStop errors.Error = "stop iteration"
 
 	walk := func(name []byte, _ *bbolt.Bucket) (err error) {
+		if bytes.Equal(name, dailyBucketName) {
+			return nil
+		}
+
 		nameID, ok := unitNameToID(name)
 		if ok && nameID >= firstID {
 			return errStop
@@ -436,12 +471,18 @@ func (s *StatsCtx) flushDB(id, limit uint32, ptr *unit) (cont bool, sleepFor tim
 
 	s.curr = newUnit(id)
 
-	flushErr := ptr.serialize().flushUnitToDB(tx, ptr.id)
+	flushErr := ptr.serialize().flushUnitToDB(tx, ptr.id, s.cipher)
 	if flushErr != nil {
 		log.Error("stats: flushing unit: %s", flushErr)
 		isCommitable = false
 	}
 
+	dsErr := s.downsample(tx, id, limit, s.dailyDays())
+	if dsErr != nil {
+		log.Error("stats: downsampling: %s", dsErr)
+		isCommitable = false
+	}
+
 	delErr := tx.DeleteBucket(idToUnitName(id - limit))
 	if delErr != nil {
 		// TODO(e.burkov):  Improve the algorithm of deleting the oldest bucket
@@ -568,7 +609,7 @@ func (s *StatsCtx) loadUnits(limit uint32) (units []*unitDB, curID uint32) {
 	units = make([]*unitDB, 0, limit)
 	firstID := curID - limit + 1
 	for i := firstID; i != curID; i++ {
-		u := loadUnitFromDB(tx, i)
+		u := loadUnitFromDB(tx, i, s.cipher)
 		if u == nil {
 			u = &unitDB{NResult: make([]uint64, resultLast)}
 		}
@@ -591,6 +632,12 @@ func (s *StatsCtx) loadUnits(limit uint32) (units []*unitDB, curID uint32) {
 	return units, curID
 }
 
+// dailyDays returns the number of the retained daily units.  s.confMu is
+// expected to be locked.
+func (s *StatsCtx) dailyDays() (days uint32) {
+	return uint32(s.dailyLimit / timeutil.Day)
+}
+
 // ShouldCount returns true if request for the host should be counted.
 func (s *StatsCtx) ShouldCount(host string, _, _ uint16, ids []string) bool {
 	s.confMu.RLock()
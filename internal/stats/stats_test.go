@@ -75,30 +75,53 @@ func TestStats(t *testing.T) {
 		const reqDomain = "domain"
 		const respUpstream = "upstream"
 
+		const blockingRule = "||domain^"
+
 		entries := []*stats.Entry{{
-			Domain:   reqDomain,
-			Client:   cliIPStr,
-			Result:   stats.RFiltered,
-			Time:     time.Microsecond * 123456,
-			Upstream: respUpstream,
+			Domain:       reqDomain,
+			Client:       cliIPStr,
+			QType:        "A",
+			RCode:        "NXDOMAIN",
+			Rule:         blockingRule,
+			FilterListID: 1,
+			Result:       stats.RFiltered,
+			Time:         time.Microsecond * 123456,
+			Upstream:     respUpstream,
+		}, {
+			Domain:          reqDomain,
+			Client:          cliIPStr,
+			QType:           "AAAA",
+			RCode:           "NOERROR",
+			Result:          stats.RNotFiltered,
+			Time:            time.Microsecond * 123456,
+			Upstream:        respUpstream,
+			AnswerCountries: []string{"DE", "NL"},
 		}, {
-			Domain:   reqDomain,
-			Client:   cliIPStr,
-			Result:   stats.RNotFiltered,
-			Time:     time.Microsecond * 123456,
-			Upstream: respUpstream,
+			Domain:          reqDomain,
+			Client:          cliIPStr,
+			QType:           "A",
+			RCode:           "NOERROR",
+			Result:          stats.RNotFiltered,
+			Time:            time.Microsecond * 123456,
+			AnswerCountries: []string{"DE"},
 		}}
 
 		wantData := &stats.StatsResp{
 			TimeUnits:             "hours",
-			TopQueried:            []map[string]uint64{0: {reqDomain: 1}},
-			TopClients:            []map[string]uint64{0: {cliIPStr: 2}},
+			TopQueried:            []map[string]uint64{0: {reqDomain: 2}},
+			TopClients:            []map[string]uint64{0: {cliIPStr: 3}},
 			TopBlocked:            []map[string]uint64{0: {reqDomain: 1}},
 			TopUpstreamsResponses: []map[string]uint64{0: {respUpstream: 2}},
 			TopUpstreamsAvgTime:   []map[string]float64{0: {respUpstream: 0.123456}},
+			TopUpstreamsShare:     []map[string]float64{0: {respUpstream: 2.0 / 3}},
+			TopBlockingRules:      []map[string]uint64{0: {blockingRule: 1}},
+			TopFilterLists:        []map[string]uint64{0: {"1": 1}},
+			TopQueryTypes:         []map[string]uint64{0: {"A": 2}, 1: {"AAAA": 1}},
+			TopResponseCodes:      []map[string]uint64{0: {"NOERROR": 2}, 1: {"NXDOMAIN": 1}},
+			TopAnswerCountries:    []map[string]uint64{0: {"DE": 2}, 1: {"NL": 1}},
 			DNSQueries: []uint64{
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3,
 			},
 			BlockedFiltering: []uint64{
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
@@ -112,7 +135,7 @@ func TestStats(t *testing.T) {
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 			},
-			NumDNSQueries:           2,
+			NumDNSQueries:           3,
 			NumBlockedFiltering:     1,
 			NumReplacedSafebrowsing: 0,
 			NumReplacedSafesearch:   0,
@@ -150,6 +173,12 @@ func TestStats(t *testing.T) {
 			TopBlocked:            []map[string]uint64{},
 			TopUpstreamsResponses: []map[string]uint64{},
 			TopUpstreamsAvgTime:   []map[string]float64{},
+			TopUpstreamsShare:     []map[string]float64{},
+			TopBlockingRules:      []map[string]uint64{},
+			TopFilterLists:        []map[string]uint64{},
+			TopQueryTypes:         []map[string]uint64{},
+			TopResponseCodes:      []map[string]uint64{},
+			TopAnswerCountries:    []map[string]uint64{},
 			DNSQueries:            _24zeroes[:],
 			BlockedFiltering:      _24zeroes[:],
 			ReplacedSafebrowsing:  _24zeroes[:],
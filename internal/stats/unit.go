@@ -5,8 +5,10 @@ import (
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
@@ -24,6 +26,21 @@ const (
 
 	// maxUpstreams is the max number of top upstreams to return.
 	maxUpstreams = 100
+
+	// maxRules is the max number of top blocking rules to return.
+	maxRules = 100
+
+	// maxFilterLists is the max number of top filter lists to return.
+	maxFilterLists = 100
+
+	// maxQTypes is the max number of top query types to return.
+	maxQTypes = 100
+
+	// maxRCodes is the max number of top response codes to return.
+	maxRCodes = 100
+
+	// maxCountries is the max number of top answer countries to return.
+	maxCountries = 100
 )
 
 // UnitIDGenFunc is the signature of a function that generates a unique ID for
@@ -65,6 +82,24 @@ type Entry struct {
 	// Upstream is the upstream DNS server.
 	Upstream string
 
+	// QType is the type of the request, for example "A".
+	QType string
+
+	// RCode is the response code, for example "NOERROR".  It's empty if there
+	// is no response.
+	RCode string
+
+	// Rule is the text of the rule, which has blocked the request, if any.
+	Rule string
+
+	// FilterListID is the ID of the filter list containing Rule.  It's only
+	// meaningful if Rule isn't empty.
+	FilterListID int64
+
+	// AnswerCountries are the unique uppercase country codes of the IP
+	// addresses in the response, if known.
+	AnswerCountries []string
+
 	// Result is the result of processing the request.
 	Result Result
 
@@ -107,6 +142,23 @@ type unit struct {
 	// responses from each upstream.
 	upstreamsTimeSum map[string]uint64
 
+	// blockingRules stores the number of requests blocked by each rule.
+	blockingRules map[string]uint64
+
+	// filterLists stores the number of requests blocked by the rules of each
+	// filter list by the list's ID.
+	filterLists map[string]uint64
+
+	// qTypes stores the number of requests of each type.
+	qTypes map[string]uint64
+
+	// rCodes stores the number of responses with each response code.
+	rCodes map[string]uint64
+
+	// answerCountries stores the number of responses with IP addresses from
+	// each country.
+	answerCountries map[string]uint64
+
 	// nResult stores the number of requests grouped by it's result.
 	nResult []uint64
 
@@ -133,6 +185,11 @@ func newUnit(id uint32) (u *unit) {
 		clients:            map[string]uint64{},
 		upstreamsResponses: map[string]uint64{},
 		upstreamsTimeSum:   map[string]uint64{},
+		blockingRules:      map[string]uint64{},
+		filterLists:        map[string]uint64{},
+		qTypes:             map[string]uint64{},
+		rCodes:             map[string]uint64{},
+		answerCountries:    map[string]uint64{},
 		nResult:            make([]uint64, resultLast),
 		id:                 id,
 	}
@@ -169,6 +226,23 @@ type unitDB struct {
 	// responses from each upstream.
 	UpstreamsTimeSum []countPair
 
+	// BlockingRules is the number of requests blocked by each rule.
+	BlockingRules []countPair
+
+	// FilterLists is the number of requests blocked by the rules of each
+	// filter list by the list's ID.
+	FilterLists []countPair
+
+	// QTypes is the number of requests of each type.
+	QTypes []countPair
+
+	// RCodes is the number of responses with each response code.
+	RCodes []countPair
+
+	// AnswerCountries is the number of responses with IP addresses from each
+	// country.
+	AnswerCountries []countPair
+
 	// NTotal is the total number of requests.
 	NTotal uint64
 
@@ -269,23 +343,68 @@ func (u *unit) serialize() (udb *unitDB) {
 		Clients:            convertMapToSlice(u.clients, maxClients),
 		UpstreamsResponses: convertMapToSlice(u.upstreamsResponses, maxUpstreams),
 		UpstreamsTimeSum:   convertMapToSlice(u.upstreamsTimeSum, maxUpstreams),
+		BlockingRules:      convertMapToSlice(u.blockingRules, maxRules),
+		FilterLists:        convertMapToSlice(u.filterLists, maxFilterLists),
+		QTypes:             convertMapToSlice(u.qTypes, maxQTypes),
+		RCodes:             convertMapToSlice(u.rCodes, maxRCodes),
+		AnswerCountries:    convertMapToSlice(u.answerCountries, maxCountries),
 		TimeAvg:            timeAvg,
 	}
 }
 
-func loadUnitFromDB(tx *bbolt.Tx, id uint32) (udb *unitDB) {
-	bkt := tx.Bucket(idToUnitName(id))
+// Keys of the unit data within the unit bucket.
+var (
+	// unitKeyPlain is the key of the plain gob-encoded unit data.
+	unitKeyPlain = []byte{0}
+
+	// unitKeyEncrypted is the key of the encrypted gob-encoded unit data.
+	unitKeyEncrypted = []byte{1}
+)
+
+// unitsStore is the common interface of the database transaction, which
+// stores the hourly units, and of the bucket, which stores the daily ones.
+type unitsStore interface {
+	Bucket(name []byte) (b *bbolt.Bucket)
+	CreateBucketIfNotExists(name []byte) (b *bbolt.Bucket, err error)
+}
+
+// type check
+var _ unitsStore = (*bbolt.Tx)(nil)
+
+// type check
+var _ unitsStore = (*bbolt.Bucket)(nil)
+
+// loadUnitFromDB returns the unit stored in st at id.  c, if not nil, is used
+// to decrypt the unit, if it's encrypted.  udb is nil if there is no such unit
+// or it can't be decoded.
+func loadUnitFromDB(st unitsStore, id uint32, c *aghcrypto.Cipher) (udb *unitDB) {
+	bkt := st.Bucket(idToUnitName(id))
 	if bkt == nil {
 		return nil
 	}
 
 	log.Tracef("Loading unit %d", id)
 
-	var buf bytes.Buffer
-	buf.Write(bkt.Get([]byte{0}))
+	data := bkt.Get(unitKeyPlain)
+	if enc := bkt.Get(unitKeyEncrypted); enc != nil {
+		if c == nil {
+			log.Error("stats: unit %d is encrypted, but no key is set", id)
+
+			return nil
+		}
+
+		var err error
+		data, err = c.Open(enc)
+		if err != nil {
+			log.Error("stats: decrypting unit %d: %s", id, err)
+
+			return nil
+		}
+	}
+
 	udb = &unitDB{}
 
-	err := gob.NewDecoder(&buf).Decode(udb)
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(udb)
 	if err != nil {
 		log.Error("gob Decode: %s", err)
 
@@ -310,9 +429,47 @@ func (u *unit) deserialize(udb *unitDB) {
 	u.clients = convertSliceToMap(udb.Clients)
 	u.upstreamsResponses = convertSliceToMap(udb.UpstreamsResponses)
 	u.upstreamsTimeSum = convertSliceToMap(udb.UpstreamsTimeSum)
+	u.blockingRules = convertSliceToMap(udb.BlockingRules)
+	u.filterLists = convertSliceToMap(udb.FilterLists)
+	u.qTypes = convertSliceToMap(udb.QTypes)
+	u.rCodes = convertSliceToMap(udb.RCodes)
+	u.answerCountries = convertSliceToMap(udb.AnswerCountries)
 	u.timeSum = uint64(udb.TimeAvg) * udb.NTotal
 }
 
+// merge adds the data from udb to u.  u must not be nil.
+func (u *unit) merge(udb *unitDB) {
+	if udb == nil {
+		return
+	}
+
+	u.timeSum += uint64(udb.TimeAvg) * udb.NTotal
+	u.nTotal += udb.NTotal
+	for i, n := range udb.NResult {
+		if i < len(u.nResult) {
+			u.nResult[i] += n
+		}
+	}
+
+	addPairs(u.domains, udb.Domains)
+	addPairs(u.blockedDomains, udb.BlockedDomains)
+	addPairs(u.clients, udb.Clients)
+	addPairs(u.upstreamsResponses, udb.UpstreamsResponses)
+	addPairs(u.upstreamsTimeSum, udb.UpstreamsTimeSum)
+	addPairs(u.blockingRules, udb.BlockingRules)
+	addPairs(u.filterLists, udb.FilterLists)
+	addPairs(u.qTypes, udb.QTypes)
+	addPairs(u.rCodes, udb.RCodes)
+	addPairs(u.answerCountries, udb.AnswerCountries)
+}
+
+// addPairs adds the counts from pairs to m.
+func addPairs(m map[string]uint64, pairs []countPair) {
+	for _, p := range pairs {
+		m[p.Name] += p.Count
+	}
+}
+
 // add adds new data to u.  It's safe for concurrent use.
 func (u *unit) add(e *Entry) {
 	u.nResult[e.Result]++
@@ -331,13 +488,31 @@ func (u *unit) add(e *Entry) {
 		u.upstreamsResponses[e.Upstream]++
 		u.upstreamsTimeSum[e.Upstream] += t
 	}
+
+	if e.Rule != "" && e.Result != RNotFiltered {
+		u.blockingRules[e.Rule]++
+		u.filterLists[strconv.FormatInt(e.FilterListID, 10)]++
+	}
+
+	if e.QType != "" {
+		u.qTypes[e.QType]++
+	}
+
+	if e.RCode != "" {
+		u.rCodes[e.RCode]++
+	}
+
+	for _, cc := range e.AnswerCountries {
+		u.answerCountries[cc]++
+	}
 }
 
-// flushUnitToDB puts udb to the database at id.
-func (udb *unitDB) flushUnitToDB(tx *bbolt.Tx, id uint32) (err error) {
+// flushUnitToDB puts udb to st at id.  c, if not nil, is used to encrypt the
+// unit.
+func (udb *unitDB) flushUnitToDB(st unitsStore, id uint32, c *aghcrypto.Cipher) (err error) {
 	log.Debug("stats: flushing unit with id %d and total of %d", id, udb.NTotal)
 
-	bkt, err := tx.CreateBucketIfNotExists(idToUnitName(id))
+	bkt, err := st.CreateBucketIfNotExists(idToUnitName(id))
 	if err != nil {
 		return fmt.Errorf("creating bucket: %w", err)
 	}
@@ -348,11 +523,23 @@ func (udb *unitDB) flushUnitToDB(tx *bbolt.Tx, id uint32) (err error) {
 		return fmt.Errorf("encoding unit: %w", err)
 	}
 
-	err = bkt.Put([]byte{0}, buf.Bytes())
+	key, data, staleKey := unitKeyPlain, buf.Bytes(), unitKeyEncrypted
+	if c != nil {
+		key, data, staleKey = unitKeyEncrypted, c.Seal(data), unitKeyPlain
+	}
+
+	err = bkt.Put(key, data)
 	if err != nil {
 		return fmt.Errorf("putting unit to database: %w", err)
 	}
 
+	// Remove the data stored before the encryption has been enabled or
+	// disabled.
+	err = bkt.Delete(staleKey)
+	if err != nil {
+		return fmt.Errorf("deleting stale unit data: %w", err)
+	}
+
 	return nil
 }
 
@@ -413,6 +600,12 @@ func (s *StatsCtx) getData(limit uint32) (resp *StatsResp, ok bool) {
 			TopQueried:            []topAddrs{},
 			TopUpstreamsResponses: []topAddrs{},
 			TopUpstreamsAvgTime:   []topAddrsFloat{},
+			TopUpstreamsShare:     []topAddrsFloat{},
+			TopBlockingRules:      []topAddrs{},
+			TopFilterLists:        []topAddrs{},
+			TopQueryTypes:         []topAddrs{},
+			TopResponseCodes:      []topAddrs{},
+			TopAnswerCountries:    []topAddrs{},
 
 			BlockedFiltering:     []uint64{},
 			DNSQueries:           []uint64{},
@@ -431,6 +624,15 @@ func (s *StatsCtx) getData(limit uint32) (resp *StatsResp, ok bool) {
 
 // dataFromUnits collects and returns the statistics data.
 func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp) {
+	resp = s.summaryFromUnits(units)
+	s.fillCollectedStats(resp, units, curID)
+
+	return resp
+}
+
+// summaryFromUnits returns the statistics data with the top and the total
+// counters collected from units.  The per time unit counters aren't filled.
+func (s *StatsCtx) summaryFromUnits(units []*unitDB) (resp *StatsResp) {
 	topUpstreamsResponses, topUpstreamsAvgTime := topUpstreamsPairs(units)
 
 	resp = &StatsResp{
@@ -439,10 +641,13 @@ func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp
 		TopUpstreamsResponses: topUpstreamsResponses,
 		TopUpstreamsAvgTime:   topUpstreamsAvgTime,
 		TopClients:            topsCollector(units, maxClients, nil, topClientPairs(s)),
+		TopBlockingRules:      topsCollector(units, maxRules, nil, func(u *unitDB) (pairs []countPair) { return u.BlockingRules }),
+		TopFilterLists:        topsCollector(units, maxFilterLists, nil, func(u *unitDB) (pairs []countPair) { return u.FilterLists }),
+		TopQueryTypes:         topsCollector(units, maxQTypes, nil, func(u *unitDB) (pairs []countPair) { return u.QTypes }),
+		TopResponseCodes:      topsCollector(units, maxRCodes, nil, func(u *unitDB) (pairs []countPair) { return u.RCodes }),
+		TopAnswerCountries:    topsCollector(units, maxCountries, nil, func(u *unitDB) (pairs []countPair) { return u.AnswerCountries }),
 	}
 
-	s.fillCollectedStats(resp, units, curID)
-
 	// Total counters:
 	sum := unitDB{
 		NResult: make([]uint64, resultLast),
@@ -470,9 +675,30 @@ func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp
 		resp.AvgProcessingTime = microsecondsToSeconds(float64(sum.TimeAvg / timeN))
 	}
 
+	resp.TopUpstreamsShare = UpstreamsShare(resp.TopUpstreamsResponses, resp.NumDNSQueries)
+
 	return resp
 }
 
+// UpstreamsShare returns the shares of the answers of each upstream among
+// total requests in the same order as topResps, which are the numbers of
+// responses of each upstream.
+func UpstreamsShare(topResps []map[string]uint64, total uint64) (shares []map[string]float64) {
+	shares = make([]map[string]float64, 0, len(topResps))
+	for _, top := range topResps {
+		for ups, n := range top {
+			share := 0.0
+			if total > 0 {
+				share = float64(n) / float64(total)
+			}
+
+			shares = append(shares, map[string]float64{ups: share})
+		}
+	}
+
+	return shares
+}
+
 // fillCollectedStats fills data with collected statistics.
 func (s *StatsCtx) fillCollectedStats(data *StatsResp, units []*unitDB, curID uint32) {
 	size := len(units)
@@ -484,17 +710,30 @@ func (s *StatsCtx) fillCollectedStats(data *StatsResp, units []*unitDB, curID ui
 		data.TimeUnits = timeUnitsDays
 	}
 
-	data.DNSQueries = make([]uint64, size)
-	data.BlockedFiltering = make([]uint64, size)
-	data.ReplacedSafebrowsing = make([]uint64, size)
-	data.ReplacedParental = make([]uint64, size)
-
 	if data.TimeUnits == timeUnitsDays {
+		makeCollectedStats(data, size)
 		s.fillCollectedStatsDaily(data, units, curID, size)
 
 		return
 	}
 
+	fillCollectedStatsPerUnit(data, units)
+}
+
+// makeCollectedStats allocates the per time unit counters of data for size
+// time units.
+func makeCollectedStats(data *StatsResp, size int) {
+	data.DNSQueries = make([]uint64, size)
+	data.BlockedFiltering = make([]uint64, size)
+	data.ReplacedSafebrowsing = make([]uint64, size)
+	data.ReplacedParental = make([]uint64, size)
+}
+
+// fillCollectedStatsPerUnit fills the per time unit counters of data with the
+// data from units, one time unit per unit.
+func fillCollectedStatsPerUnit(data *StatsResp, units []*unitDB) {
+	makeCollectedStats(data, len(units))
+
 	for i, u := range units {
 		data.DNSQueries[i] += u.NTotal
 		data.BlockedFiltering[i] += u.NResult[RFiltered]
@@ -1,10 +1,14 @@
 package stats
 
 import (
+	"path/filepath"
 	"testing"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghcrypto"
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
 )
 
 func TestUnit_Deserialize(t *testing.T) {
@@ -24,6 +28,11 @@ func TestUnit_Deserialize(t *testing.T) {
 			timeSum:            0,
 			upstreamsResponses: map[string]uint64{},
 			upstreamsTimeSum:   map[string]uint64{},
+			blockingRules:      map[string]uint64{},
+			filterLists:        map[string]uint64{},
+			qTypes:             map[string]uint64{},
+			rCodes:             map[string]uint64{},
+			answerCountries:    map[string]uint64{},
 		},
 		db: &unitDB{
 			NResult:            []uint64{0, 0, 0, 0, 0, 0},
@@ -57,6 +66,22 @@ func TestUnit_Deserialize(t *testing.T) {
 			upstreamsTimeSum: map[string]uint64{
 				"1.2.3.4": 246912,
 			},
+			blockingRules: map[string]uint64{
+				"||example.net^": 1,
+			},
+			filterLists: map[string]uint64{
+				"1": 1,
+			},
+			qTypes: map[string]uint64{
+				"A": 2,
+			},
+			rCodes: map[string]uint64{
+				"NOERROR":  1,
+				"NXDOMAIN": 1,
+			},
+			answerCountries: map[string]uint64{
+				"DE": 1,
+			},
 		},
 		db: &unitDB{
 			NResult: []uint64{0, 1, 1, 0, 0, 0},
@@ -77,6 +102,23 @@ func TestUnit_Deserialize(t *testing.T) {
 			UpstreamsTimeSum: []countPair{{
 				"1.2.3.4", 246912,
 			}},
+			BlockingRules: []countPair{{
+				"||example.net^", 1,
+			}},
+			FilterLists: []countPair{{
+				"1", 1,
+			}},
+			QTypes: []countPair{{
+				"A", 2,
+			}},
+			RCodes: []countPair{{
+				"NOERROR", 1,
+			}, {
+				"NXDOMAIN", 1,
+			}},
+			AnswerCountries: []countPair{{
+				"DE", 1,
+			}},
 		},
 	}}
 
@@ -175,3 +217,54 @@ func TestTopUpstreamsPairs(t *testing.T) {
 		})
 	}
 }
+
+func TestUnitDB_flushUnitToDB_encrypted(t *testing.T) {
+	c, err := aghcrypto.NewCipher(make([]byte, aghcrypto.KeySize))
+	require.NoError(t, err)
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "stats.db"), 0o644, nil)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, db.Close)
+
+	const id = 1
+
+	udb := &unitDB{
+		NResult: []uint64{1, 0, 0, 0, 0, 0},
+		Domains: []countPair{{Name: "secret.example", Count: 1}},
+		Clients: []countPair{{Name: "1.2.3.4", Count: 1}},
+		NTotal:  1,
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		require.NoError(t, db.Update(func(tx *bbolt.Tx) (err error) {
+			return udb.flushUnitToDB(tx, id, nil)
+		}))
+
+		require.NoError(t, db.View(func(tx *bbolt.Tx) (err error) {
+			// Plain units are readable with and without a cipher.
+			assert.Equal(t, udb, loadUnitFromDB(tx, id, nil))
+			assert.Equal(t, udb, loadUnitFromDB(tx, id, c))
+
+			return nil
+		}))
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		require.NoError(t, db.Update(func(tx *bbolt.Tx) (err error) {
+			return udb.flushUnitToDB(tx, id, c)
+		}))
+
+		require.NoError(t, db.View(func(tx *bbolt.Tx) (err error) {
+			bkt := tx.Bucket(idToUnitName(id))
+			require.NotNil(t, bkt)
+
+			assert.Nil(t, bkt.Get(unitKeyPlain))
+			assert.NotContains(t, string(bkt.Get(unitKeyEncrypted)), "secret.example")
+
+			assert.Equal(t, udb, loadUnitFromDB(tx, id, c))
+			assert.Nil(t, loadUnitFromDB(tx, id, nil))
+
+			return nil
+		}))
+	})
+}
@@ -203,6 +203,7 @@ run_linter gocognit --over='12'\
 	;
 
 run_linter gocognit --over='10'\
+	./embedded/\
 	./internal/aghalg/\
 	./internal/aghchan/\
 	./internal/aghhttp/\
@@ -239,6 +240,7 @@ run_linter nilness ./...
 
 # TODO(a.garipov): Enable for all.
 run_linter fieldalignment \
+	./embedded/\
 	./internal/aghalg/\
 	./internal/aghchan/\
 	./internal/aghhttp/\
@@ -267,6 +269,7 @@ run_linter -e shadow --strict ./...
 
 # TODO(a.garipov): Enable for all.
 run_linter gosec --quiet\
+	./embedded/\
 	./internal/aghalg/\
 	./internal/aghchan/\
 	./internal/aghhttp/\